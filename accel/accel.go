@@ -0,0 +1,137 @@
+// Package accel holds the acceleration structures that find the primitives
+// lightrays hit without testing every one of them.
+//
+// Built with the float32 tag, the nodes of the BVHs hold their bounds in
+// float32 and their indices in int32, which makes them half as large, 36
+// bytes instead of 72, so the hierarchies of huge scenes fit in memory and
+// more of their nodes in the caches. The bounds are rounded outwards, so
+// no lightray misses a node that holds what it hits, and lightrays are
+// still tested against them and the primitives in float64, so the renders
+// are the same but for the lightrays that graze the nodes, which are
+// tested against a few more primitives. The trade-off is that boxes far
+// from the origin grow: float32 has 24 bits of precision, so a box 10 km
+// away is rounded to about a millimetre, and one 10,000 km away to about a
+// metre, which makes the BVHs of detailed geometry far from the origin
+// slower. Such scenes should be moved near the origin, or use the default
+// float64 build, which is the one meant for numerical robustness.
+package accel
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// selfHitDistance is the distance below which a lightray hitting the
+// primitive it leaves is taken as hitting the point it left from. It
+// grows by math3d.RelativeEpsilon times the magnitude of the coordinates
+// of the source, as the rounding errors of the point do.
+const selfHitDistance = 1e-4
+
+// Primitive defines the geometry that can be stored in an acceleration
+// structure.
+type Primitive interface {
+	Intersect(lr *math3d.LightRay) float64
+	Bounds() *math3d.AABB
+}
+
+// Cutout defines the primitives with holes in them. Opaque returns
+// whether the primitive is there where the lightray hits it at distance,
+// rather than a hole.
+type Cutout interface {
+	Opaque(lr *math3d.LightRay, distance float64) bool
+}
+
+// Filter decides whether the hit of the lightray on the primitive with the
+// index, at distance, counts. The structures call it during the queries
+// for every hit they find, after the holes of cutout primitives, and
+// look further along the lightray past the hits it rejects, so it can
+// prune or cut away geometry by logic of its own. It may be called by
+// many goroutines at once.
+type Filter func(index int, lr *math3d.LightRay, distance float64) bool
+
+// Accelerator is an acceleration structure over a slice of primitives,
+// which it refers to by their index
+type Accelerator interface {
+	// Intersect returns the distance to the nearest primitive the lightray
+	// intersects and its index. If it doesn't intersect any, it returns
+	// math.MaxFloat64 and -1.
+	Intersect(lr *math3d.LightRay) (float64, int)
+	// Occluded returns true if the lightray intersects any primitive at a
+	// distance that is smaller than distance
+	Occluded(lr *math3d.LightRay, distance float64) bool
+	// Refit updates the structure after the primitives moved
+	Refit()
+	// Size returns the number of primitives in the structure
+	Size() int
+	// Bounds returns the bounding box of all the primitives
+	Bounds() *math3d.AABB
+	// Count makes the queries add the work they do to c, or stop counting
+	// it if c is nil. It must not be called while the structure is queried.
+	Count(c *Counters)
+	// Filter makes the queries call f for every hit, or stop filtering
+	// them if f is nil. It must not be called while the structure is
+	// queried.
+	Filter(f Filter)
+}
+
+// Counters counts the work done by the queries to an acceleration
+// structure. Its fields are added to atomically, so goroutines querying at
+// once may share it.
+type Counters struct {
+	// Nodes is the number of nodes visited
+	Nodes uint64
+	// Tests is the number of intersection tests against primitives
+	Tests uint64
+}
+
+// add adds the nodes and tests of a query to the counters, unless they
+// are nil
+func (c *Counters) add(nodes, tests uint64) {
+	if c != nil {
+		atomic.AddUint64(&c.Nodes, nodes)
+		atomic.AddUint64(&c.Tests, tests)
+	}
+}
+
+// SelfHitDistance returns the distance below which the lightray hitting
+// the primitive it leaves is taken as hitting the point it left from
+func SelfHitDistance(lr *math3d.LightRay) float64 {
+	return selfHitDistance + math3d.RelativeEpsilon*lr.Source.MaxAbs()
+}
+
+// maxHoles is the number of holes of a cutout primitive that a lightray
+// goes through before it's taken as missing it
+const maxHoles = 8
+
+// hitDistance returns the distance at which the lightray intersects the
+// primitive with the index, ignoring the lightray hitting the primitive it
+// leaves right at its source and going through the holes of cutout
+// primitives and the hits that the filter, if any, rejects
+func hitDistance(p Primitive, index int, lr *math3d.LightRay, filter Filter) float64 {
+	d := p.Intersect(lr)
+	if lr.Origin != nil && lr.Origin == p && d < SelfHitDistance(lr) {
+		return math.MaxFloat64
+	}
+	cutout, ok := p.(Cutout)
+	if !ok && filter == nil {
+		return d
+	}
+	for holes := 0; d != math.MaxFloat64 && !((!ok || cutout.Opaque(lr, d)) && (filter == nil || filter(index, lr, d))); holes++ {
+		if holes == maxHoles {
+			return math.MaxFloat64
+		}
+		// Looking for the primitive again past the hole, such as the far
+		// side of a sphere
+		beyond := *lr
+		beyond.Source = lr.Source.AddV(lr.Direction.MultiplyV(d + selfHitDistance))
+		beyond.Origin = nil
+		next := p.Intersect(&beyond)
+		if next == math.MaxFloat64 {
+			return next
+		}
+		d += selfHitDistance + next
+	}
+	return d
+}
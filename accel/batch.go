@@ -0,0 +1,34 @@
+package accel
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// BatchIntersector is an acceleration structure that traces batches of
+// lightrays at once, such as the ones of every pixel of an image, which
+// pays off for the structures that trace them on other devices.
+type BatchIntersector interface {
+	// IntersectRays sets the distances and indices to what Intersect
+	// returns for every lightray. The lightrays that are nil get
+	// math.MaxFloat64 and -1.
+	IntersectRays(lrs []*math3d.LightRay, distances []float64, indices []int)
+}
+
+// IntersectRays sets the distances and indices to what the structure's
+// Intersect returns for every lightray, tracing them at once if it's a
+// BatchIntersector and one after the other otherwise. The lightrays that
+// are nil get math.MaxFloat64 and -1.
+func IntersectRays(a Accelerator, lrs []*math3d.LightRay, distances []float64, indices []int) {
+	if b, ok := a.(BatchIntersector); ok {
+		b.IntersectRays(lrs, distances, indices)
+		return
+	}
+	for i, lr := range lrs {
+		distances[i], indices[i] = math.MaxFloat64, -1
+		if lr != nil {
+			distances[i], indices[i] = a.Intersect(lr)
+		}
+	}
+}
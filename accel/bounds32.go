@@ -0,0 +1,38 @@
+//go:build float32
+
+package accel
+
+import (
+	"github.com/ProjectMOA/goraytrace/internal/simd"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Float32 is whether the nodes of the BVHs hold their bounds and indices
+// in 32 bits, as the builds with the float32 tag do
+const Float32 = true
+
+// nodeBounds is the bounding box of a BVH node in float32, which lightrays
+// are still tested against in float64
+type nodeBounds math3d.AABB32
+
+// nodeIndex is the type of the indices that BVH nodes hold, which limits
+// the BVHs to 2^31 primitives
+type nodeIndex = int32
+
+func packBounds(b *math3d.AABB) nodeBounds {
+	return nodeBounds(math3d.Bounds32(b))
+}
+
+func (b *nodeBounds) aabb() math3d.AABB {
+	return (*math3d.AABB32)(b).Float64()
+}
+
+func (b *nodeBounds) intersect(lr *math3d.LightRay) float64 {
+	box := b.aabb()
+	return box.Intersect(lr)
+}
+
+func (b *nodeBounds) intersectPacket(p *simd.RayPacket4, maxDistance *[PacketSize]float64) uint8 {
+	box := b.aabb()
+	return p.IntersectAABB(&box, maxDistance)
+}
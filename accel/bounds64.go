@@ -0,0 +1,34 @@
+//go:build !float32
+
+package accel
+
+import (
+	"github.com/ProjectMOA/goraytrace/internal/simd"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Float32 is whether the nodes of the BVHs hold their bounds and indices
+// in 32 bits, as the builds with the float32 tag do
+const Float32 = false
+
+// nodeBounds is the bounding box of a BVH node
+type nodeBounds math3d.AABB
+
+// nodeIndex is the type of the indices that BVH nodes hold
+type nodeIndex = int
+
+func packBounds(b *math3d.AABB) nodeBounds {
+	return nodeBounds(*b)
+}
+
+func (b *nodeBounds) aabb() math3d.AABB {
+	return math3d.AABB(*b)
+}
+
+func (b *nodeBounds) intersect(lr *math3d.LightRay) float64 {
+	return (*math3d.AABB)(b).Intersect(lr)
+}
+
+func (b *nodeBounds) intersectPacket(p *simd.RayPacket4, maxDistance *[PacketSize]float64) uint8 {
+	return p.IntersectAABB((*math3d.AABB)(b), maxDistance)
+}
@@ -0,0 +1,264 @@
+package accel
+
+import (
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// maxLeafSize is the number of primitives below which a node isn't split
+const maxLeafSize = 2
+
+// BVH defines a bounding volume hierarchy over a slice of primitives.
+type BVH struct {
+	primitives []Primitive
+	// indices holds the primitive indices in the order the leaves use them
+	indices []int
+	nodes   []node
+	// counters counts the work of the queries if it isn't nil
+	counters *Counters
+	// filter decides which hits count if it isn't nil
+	filter Filter
+	// spatial is whether NewSBVH built the hierarchy, whose leaves may
+	// share primitives
+	spatial bool
+}
+
+// node is either an inner node with two children or a leaf with a range
+// of indices. The left child of an inner node is always the next node.
+type node struct {
+	bounds       nodeBounds
+	right        nodeIndex
+	first, count nodeIndex
+}
+
+func (n *node) isLeaf() bool {
+	return n.count > 0
+}
+
+// NewBVH builds a bounding volume hierarchy over the primitives. The
+// primitives must not change their bounds afterwards unless Refit is
+// called.
+func NewBVH(primitives []Primitive) *BVH {
+	if Float32 && len(primitives) > math.MaxInt32 {
+		panic("A BVH of the float32 build can't hold more than 2^31 primitives")
+	}
+	bvh := &BVH{primitives: primitives, indices: make([]int, len(primitives))}
+	for i := range bvh.indices {
+		bvh.indices[i] = i
+	}
+	if len(primitives) > 0 {
+		bvh.nodes = make([]node, 0, 2*len(primitives))
+		bvh.build(0, len(primitives))
+	}
+	return bvh
+}
+
+// build creates the subtree for indices[first:end] and returns the index
+// of its root node
+func (bvh *BVH) build(first, end int) int {
+	current := len(bvh.nodes)
+	bvh.nodes = append(bvh.nodes, node{bounds: packBounds(bvh.rangeBounds(first, end))})
+	if end-first <= maxLeafSize {
+		bvh.nodes[current].first, bvh.nodes[current].count = nodeIndex(first), nodeIndex(end-first)
+		return current
+	}
+
+	// Split by the median centroid along the axis where centroids spread most
+	centroids := math3d.EmptyAABB()
+	for _, i := range bvh.indices[first:end] {
+		centroids = centroids.Expand(bvh.primitives[i].Bounds().Centroid())
+	}
+	axis := longestAxis(centroids)
+	slice := bvh.indices[first:end]
+	sort.Slice(slice, func(a, b int) bool {
+		return component(bvh.primitives[slice[a]].Bounds().Centroid(), axis) <
+			component(bvh.primitives[slice[b]].Bounds().Centroid(), axis)
+	})
+	middle := first + (end-first)/2
+	bvh.build(first, middle)
+	right := bvh.build(middle, end)
+	bvh.nodes[current].right = nodeIndex(right)
+	return current
+}
+
+func (bvh *BVH) rangeBounds(first, end int) *math3d.AABB {
+	bounds := math3d.EmptyAABB()
+	for _, i := range bvh.indices[first:end] {
+		bounds = bounds.Union(bvh.primitives[i].Bounds())
+	}
+	return bounds
+}
+
+// Refit recomputes the bounds of every node from the current bounds of
+// the primitives, keeping the tree topology. It's much cheaper than
+// building a new hierarchy when primitives have moved a little.
+func (bvh *BVH) Refit() {
+	// Children are always stored after their parent, so going backwards
+	// visits them first.
+	for i := len(bvh.nodes) - 1; i >= 0; i-- {
+		n := &bvh.nodes[i]
+		if n.isLeaf() {
+			n.bounds = packBounds(bvh.rangeBounds(int(n.first), int(n.first+n.count)))
+		} else {
+			left, right := bvh.nodes[i+1].bounds.aabb(), bvh.nodes[n.right].bounds.aabb()
+			n.bounds = packBounds(left.Union(&right))
+		}
+	}
+}
+
+// Size returns the number of primitives in the hierarchy
+func (bvh *BVH) Size() int {
+	return len(bvh.primitives)
+}
+
+// Memory returns the number of bytes that the nodes of the hierarchy and
+// its references to the primitives take, without the primitives
+func (bvh *BVH) Memory() uint64 {
+	return uint64(len(bvh.nodes))*uint64(unsafe.Sizeof(node{})) +
+		uint64(len(bvh.indices))*uint64(unsafe.Sizeof(0)) +
+		uint64(len(bvh.primitives))*uint64(unsafe.Sizeof(Primitive(nil)))
+}
+
+// Bounds returns the bounding box of all the primitives
+func (bvh *BVH) Bounds() *math3d.AABB {
+	if len(bvh.nodes) == 0 {
+		return math3d.EmptyAABB()
+	}
+	b := bvh.nodes[0].bounds.aabb()
+	return &b
+}
+
+// Count makes the queries to the BVH add the work they do to c, or stop
+// counting it if c is nil. It must not be called while the BVH is queried.
+func (bvh *BVH) Count(c *Counters) {
+	bvh.counters = c
+}
+
+// Filter makes the queries to the BVH call f for every hit, or stop
+// filtering them if f is nil. It must not be called while the BVH is
+// queried.
+func (bvh *BVH) Filter(f Filter) {
+	bvh.filter = f
+}
+
+// Intersect returns the distance to the nearest primitive the lightray
+// intersects and its index. If it doesn't intersect any, it returns
+// math.MaxFloat64 and -1.
+func (bvh *BVH) Intersect(lr *math3d.LightRay) (float64, int) {
+	nearestDistance, nearest := math.MaxFloat64, -1
+	bvh.traverse(lr, func(i int) bool {
+		if d := bvh.intersect(i, lr); d < nearestDistance {
+			nearestDistance, nearest = d, i
+		}
+		return false
+	}, func() float64 { return nearestDistance })
+	return nearestDistance, nearest
+}
+
+// Occluded returns true if the lightray intersects any primitive at a
+// distance that is smaller than distance
+func (bvh *BVH) Occluded(lr *math3d.LightRay, distance float64) bool {
+	occluded := false
+	bvh.traverse(lr, func(i int) bool {
+		occluded = bvh.intersect(i, lr) < distance
+		return occluded
+	}, func() float64 { return distance })
+	return occluded
+}
+
+// intersect returns the distance at which the lightray intersects the
+// primitive with the index
+func (bvh *BVH) intersect(i int, lr *math3d.LightRay) float64 {
+	return hitDistance(bvh.primitives[i], i, lr, bvh.filter)
+}
+
+// traverse calls visit with every primitive in a node that the lightray
+// enters closer than maxDistance. It stops as soon as visit returns true.
+func (bvh *BVH) traverse(lr *math3d.LightRay, visit func(int) bool, maxDistance func() float64) {
+	if len(bvh.nodes) == 0 {
+		return
+	}
+	// The work is counted locally and added once, so queries don't contend
+	// for the counters at every node
+	var nodes, tests uint64
+	stack := make([]nodeIndex, 1, 64)
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n := &bvh.nodes[current]
+		nodes++
+		if n.bounds.intersect(lr) >= maxDistance() {
+			continue
+		}
+		if !n.isLeaf() {
+			stack = append(stack, n.right, current+1)
+			continue
+		}
+		for _, i := range bvh.indices[n.first : n.first+n.count] {
+			tests++
+			if visit(i) {
+				bvh.counters.add(nodes, tests)
+				return
+			}
+		}
+	}
+	bvh.counters.add(nodes, tests)
+}
+
+func longestAxis(b *math3d.AABB) int {
+	d := b.Max.Subtract(&b.Min)
+	if d.X >= d.Y && d.X >= d.Z {
+		return 0
+	} else if d.Y >= d.Z {
+		return 1
+	}
+	return 2
+}
+
+func component(v *math3d.Vector3, axis int) float64 {
+	switch axis {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
+
+// FlatNode is a node of a BVH as Flatten lays it out, for the code that
+// traverses the hierarchy elsewhere, such as on a GPU
+type FlatNode struct {
+	Bounds math3d.AABB
+	// Right is the index of the right child of an inner node, whose left
+	// child is the next node
+	Right int
+	// First and Count are the range of the indices of Flatten that a leaf
+	// holds. Count is 0 for inner nodes.
+	First, Count int
+}
+
+// Flatten returns the nodes of the hierarchy, the root first, and the
+// indices of the primitives in the order the leaves refer to them. The
+// nodes are traversed as Intersect traverses them, the left child first.
+func (bvh *BVH) Flatten() ([]FlatNode, []int) {
+	nodes := make([]FlatNode, len(bvh.nodes))
+	for i := range bvh.nodes {
+		n := &bvh.nodes[i]
+		nodes[i] = FlatNode{Bounds: n.bounds.aabb(), First: int(n.first), Count: int(n.count)}
+		if !n.isLeaf() {
+			nodes[i].Right = int(n.right)
+		}
+	}
+	return nodes, append([]int(nil), bvh.indices...)
+}
+
+// HitDistance returns the distance at which the queries take the lightray
+// to hit the primitive with the index, or math.MaxFloat64 if they take it
+// to miss it, such as when it leaves the primitive or the filter rejects
+// the hit
+func (bvh *BVH) HitDistance(i int, lr *math3d.LightRay) float64 {
+	return bvh.intersect(i, lr)
+}
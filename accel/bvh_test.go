@@ -0,0 +1,169 @@
+package accel
+
+import (
+	stdimg "image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func randomSpheres(n int) []Primitive {
+	r := rand.New(rand.NewSource(7))
+	primitives := make([]Primitive, 0, n)
+	for i := 0; i < n; i++ {
+		primitives = append(primitives, &shape.Sphere{
+			Position: math3d.Vector3{X: r.Float64()*10 - 5, Y: r.Float64()*10 - 5, Z: r.Float64() * 10},
+			Radius:   r.Float64() * 0.5})
+	}
+	return primitives
+}
+
+func bruteForce(primitives []Primitive, lr *math3d.LightRay) (float64, int) {
+	nearestDistance, nearest := math.MaxFloat64, -1
+	for i, p := range primitives {
+		if d := p.Intersect(lr); d < nearestDistance {
+			nearestDistance, nearest = d, i
+		}
+	}
+	return nearestDistance, nearest
+}
+
+func TestBVHMatchesBruteForce(t *testing.T) {
+	primitives := randomSpheres(200)
+	bvh := NewBVH(primitives)
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 1000; i++ {
+		lr := math3d.LightRay{
+			Source:    math3d.Vector3{X: 0, Y: 0, Z: -10},
+			Direction: *(&math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: 1}).Normalized()}
+		expectedDistance, expected := bruteForce(primitives, &lr)
+		distance, index := bvh.Intersect(&lr)
+		if index != expected || distance != expectedDistance {
+			t.Fatalf("BVH found %d at %.3f but the nearest is %d at %.3f", index, distance, expected, expectedDistance)
+		}
+		if bvh.Occluded(&lr, math.MaxFloat64) != (expected >= 0) {
+			t.Fatal("Occlusion doesn't match the nearest intersection")
+		}
+	}
+}
+
+func TestBVHRefit(t *testing.T) {
+	primitives := randomSpheres(50)
+	bvh := NewBVH(primitives)
+	moved := primitives[10].(*shape.Sphere)
+	moved.Translate(&math3d.Vector3{X: 100})
+	bvh.Refit()
+	lr := math3d.LightRay{Source: math3d.Vector3{X: moved.Position.X, Y: moved.Position.Y, Z: -10}, Direction: math3d.UnitZ}
+	if _, index := bvh.Intersect(&lr); index != 10 {
+		t.Errorf("The refitted BVH should find the moved sphere, found %d", index)
+	}
+}
+
+func TestBVHIgnoresSelfHits(t *testing.T) {
+	left := &shape.Sphere{Radius: 1}
+	primitives := []Primitive{left, &shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 0.5}}
+	bvh := NewBVH(primitives)
+	// Rounding left the source of the ray just behind the surface it
+	// leaves
+	lr := math3d.LightRay{Source: math3d.Vector3{Z: 1 - 5e-5}, Direction: math3d.UnitZ}
+	if _, index := bvh.Intersect(&lr); index != 0 {
+		t.Fatalf("Without an origin the ray should hit the sphere it leaves, it hit %d", index)
+	}
+	lr.Origin = left
+	if distance, index := bvh.Intersect(&lr); index != 1 || math.Abs(distance-1.5) > 1e-3 {
+		t.Errorf("The ray should skip the sphere it leaves and hit the next one, it hit %d at %f", index, distance)
+	}
+	if bvh.Occluded(&lr, 1) {
+		t.Error("The sphere the ray leaves shouldn't occlude it")
+	}
+}
+
+func TestAcceleratorsGoThroughCutouts(t *testing.T) {
+	// A sphere whose front half is cut away, in front of another sphere
+	cut := stdimg.NewGray(stdimg.Rect(0, 0, 4, 1))
+	for x := 0; x < 4; x++ {
+		if x < 2 {
+			cut.SetGray(x, 0, color.Gray{Y: 255})
+		}
+	}
+	front := &shape.Sphere{Radius: 1, Opacity: shape.NewOpacity(cut, shape.DefaultCutoff)}
+	primitives := []Primitive{front, &shape.Sphere{Position: math3d.Vector3{Z: 4}, Radius: 0.5}}
+	for _, a := range []Accelerator{NewBVH(primitives), NewKDTree(primitives)} {
+		// The front faces Z-, where u is about 0.75, and the back Z+ where
+		// it's about 0.25
+		lr := math3d.LightRay{Source: math3d.Vector3{X: 0.1, Z: -3}, Direction: math3d.UnitZ}
+		if d, index := a.Intersect(&lr); index != 0 || math.Abs(d-4) > 1e-2 {
+			t.Errorf("%T: the ray should go through the hole and hit the back of the sphere, it hit %d at %f", a, index, d)
+		}
+		front.Opacity = nil
+		if d, _ := a.Intersect(&lr); math.Abs(d-2) > 1e-2 {
+			t.Errorf("%T: without holes the ray should hit the front of the sphere, it hit at %f", a, d)
+		}
+		front.Opacity = shape.NewOpacity(stdimg.NewGray(stdimg.Rect(0, 0, 1, 1)), shape.DefaultCutoff)
+		if d, index := a.Intersect(&lr); index != 1 || a.Occluded(&lr, 3) {
+			t.Errorf("%T: the ray should go through the invisible sphere, it hit %d at %f", a, index, d)
+		}
+		front.Opacity = shape.NewOpacity(cut, shape.DefaultCutoff)
+	}
+}
+
+func TestAcceleratorsFilterHits(t *testing.T) {
+	// A sphere in front of another one, and the same two placed by an
+	// instance that turns them around and moves them to Z 10
+	primitives := []Primitive{&shape.Sphere{Radius: 1}, &shape.Sphere{Position: math3d.Vector3{Z: 4}, Radius: 0.5}}
+	turned := []Primitive{&shape.Sphere{Radius: 1}, &shape.Sphere{Position: math3d.Vector3{Z: -4}, Radius: 0.5}}
+	twoLevel := NewTwoLevel([]Instance{{Primitives: turned, Position: math3d.Vector3{Z: 10},
+		Rotation: math3d.AxisAngle(math3d.UnitY, math.Pi), Indices: []int{7, 8}}})
+	for _, test := range []struct {
+		a      Accelerator
+		front  int
+		center float64
+	}{
+		{NewBVH(primitives), 0, 0},
+		{NewKDTree(primitives), 0, 0},
+		{twoLevel, 7, 10},
+	} {
+		a := test.a
+		lr := math3d.LightRay{Source: math3d.Vector3{Z: test.center - 3}, Direction: math3d.UnitZ}
+		// A cutaway of the front half of the front sphere, in the world
+		a.Filter(func(index int, lr *math3d.LightRay, distance float64) bool {
+			return index != test.front || lr.Source.Z+lr.Direction.Z*distance >= test.center
+		})
+		if d, index := a.Intersect(&lr); index != test.front || math.Abs(d-4) > 1e-6 {
+			t.Errorf("%T: the ray should go past the cut half and hit the back of the sphere, it hit %d at %f", a, index, d)
+		}
+		if a.Occluded(&lr, 3) {
+			t.Errorf("%T: the cut half of the sphere shouldn't occlude the ray", a)
+		}
+		a.Filter(func(index int, lr *math3d.LightRay, distance float64) bool { return index != test.front })
+		if d, index := a.Intersect(&lr); index != test.front+1 || math.Abs(d-6.5) > 1e-6 {
+			t.Errorf("%T: the ray should go through the filtered sphere, it hit %d at %f", a, index, d)
+		}
+		a.Filter(nil)
+		if d, _ := a.Intersect(&lr); math.Abs(d-2) > 1e-6 {
+			t.Errorf("%T: without the filter the ray should hit the front of the sphere, it hit at %f", a, d)
+		}
+	}
+}
+
+func TestBVHCountsItsWork(t *testing.T) {
+	primitives := randomSpheres(200)
+	bvh := NewBVH(primitives)
+	var counters Counters
+	bvh.Count(&counters)
+	lr := math3d.LightRay{Source: math3d.Vector3{Z: -10}, Direction: math3d.UnitZ}
+	bvh.Intersect(&lr)
+	if counters.Nodes == 0 || counters.Tests == 0 || counters.Tests >= uint64(len(primitives)) {
+		t.Errorf("The ray should visit some nodes and test a few of the 200 spheres, but counted %+v", counters)
+	}
+	bvh.Count(nil)
+	counted := counters
+	bvh.Occluded(&lr, math.MaxFloat64)
+	if counters != counted {
+		t.Errorf("The BVH kept counting after it was told to stop: %+v", counters)
+	}
+}
@@ -0,0 +1,104 @@
+package accel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// encodedNodeSize is the number of bytes of an encoded BVH node: its
+// bounds as 6 little endian 64 bit floats, and the index of its right
+// child, its first index and its number of indices as little endian 32
+// bit integers
+const encodedNodeSize = 6*8 + 3*4
+
+// MarshalBinary encodes the hierarchy, without its primitives, so that
+// LoadBVH can load it over the same primitives instead of building it
+// again. It holds the number of primitives, the number of nodes, the
+// nodes and the indices, all little endian. The bounds are encoded in
+// float64 in either build, so the builds with the float32 tag and without
+// it load the hierarchies the other one encodes. The hierarchies NewSBVH
+// builds can't be encoded.
+func (bvh *BVH) MarshalBinary() ([]byte, error) {
+	if bvh.spatial {
+		return nil, fmt.Errorf("a BVH with spatial splits can't be encoded")
+	}
+	if uint64(len(bvh.primitives)) > math.MaxUint32 {
+		return nil, fmt.Errorf("a BVH of more than 2^32 primitives can't be encoded")
+	}
+	data := make([]byte, 8, 8+encodedNodeSize*len(bvh.nodes)+4*len(bvh.indices))
+	binary.LittleEndian.PutUint32(data, uint32(len(bvh.primitives)))
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(bvh.nodes)))
+	var b [8]byte
+	for i := range bvh.nodes {
+		n := &bvh.nodes[i]
+		bounds := n.bounds.aabb()
+		for _, v := range [...]float64{bounds.Min.X, bounds.Min.Y, bounds.Min.Z, bounds.Max.X, bounds.Max.Y, bounds.Max.Z} {
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+			data = append(data, b[:]...)
+		}
+		for _, v := range [...]nodeIndex{n.right, n.first, n.count} {
+			binary.LittleEndian.PutUint32(b[:], uint32(v))
+			data = append(data, b[:4]...)
+		}
+	}
+	for _, i := range bvh.indices {
+		binary.LittleEndian.PutUint32(b[:], uint32(i))
+		data = append(data, b[:4]...)
+	}
+	return data, nil
+}
+
+// LoadBVH returns the hierarchy that MarshalBinary encoded in data over
+// the primitives, which must be the ones it was built over, in the same
+// order and with the same bounds. Loading it only takes a pass over data,
+// which is much faster than building it for large meshes. It fails if
+// data is corrupt or was encoded over a different number of primitives,
+// but a hierarchy of the same number of different primitives loads and
+// gives wrong answers.
+func LoadBVH(primitives []Primitive, data []byte) (*BVH, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("the BVH is truncated")
+	}
+	size, count := int(binary.LittleEndian.Uint32(data)), int(binary.LittleEndian.Uint32(data[4:]))
+	if size != len(primitives) {
+		return nil, fmt.Errorf("the BVH is over %d primitives, not %d", size, len(primitives))
+	}
+	if Float32 && size > math.MaxInt32 {
+		return nil, fmt.Errorf("a BVH of the float32 build can't hold more than 2^31 primitives")
+	}
+	if len(data) != 8+encodedNodeSize*count+4*size || (size > 0) != (count > 0) {
+		return nil, fmt.Errorf("the BVH is truncated or corrupt")
+	}
+	bvh := &BVH{primitives: primitives, indices: make([]int, size), nodes: make([]node, count)}
+	data = data[8:]
+	float := func(i int) float64 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+	}
+	for i := range bvh.nodes {
+		n := &bvh.nodes[i]
+		n.bounds = packBounds(&math3d.AABB{
+			Min: math3d.Vector3{X: float(0), Y: float(1), Z: float(2)},
+			Max: math3d.Vector3{X: float(3), Y: float(4), Z: float(5)}})
+		right := int(binary.LittleEndian.Uint32(data[48:]))
+		first := int(binary.LittleEndian.Uint32(data[52:]))
+		length := int(binary.LittleEndian.Uint32(data[56:]))
+		// The traversal trusts the nodes, so they must be a tree whose
+		// children come after their parents and whose leaves hold indices
+		if length > 0 && (first > size || length > size-first) || length == 0 && (right <= i+1 || right >= count || i+1 >= count) {
+			return nil, fmt.Errorf("the node %d of the BVH is corrupt", i)
+		}
+		n.right, n.first, n.count = nodeIndex(right), nodeIndex(first), nodeIndex(length)
+		data = data[encodedNodeSize:]
+	}
+	for i := range bvh.indices {
+		index := int(binary.LittleEndian.Uint32(data[4*i:]))
+		if index >= size {
+			return nil, fmt.Errorf("the index %d of the BVH is of no primitive", i)
+		}
+		bvh.indices[i] = index
+	}
+	return bvh, nil
+}
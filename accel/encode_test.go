@@ -0,0 +1,51 @@
+package accel
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestLoadBVHGivesTheSameHierarchy(t *testing.T) {
+	primitives := randomSpheres(300)
+	built := NewBVH(primitives)
+	data, err := built.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBVH(primitives, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded.Bounds() != *built.Bounds() {
+		t.Errorf("The loaded BVH should have the bounds %v, not %v", built.Bounds(), loaded.Bounds())
+	}
+	r := rand.New(rand.NewSource(5))
+	for i := 0; i < 500; i++ {
+		lr := math3d.LightRay{
+			Source:    math3d.Vector3{Z: -10},
+			Direction: *(&math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: 1}).Normalized()}
+		wantDistance, want := built.Intersect(&lr)
+		if distance, index := loaded.Intersect(&lr); index != want || distance != wantDistance {
+			t.Fatalf("The loaded BVH found %d at %v but the built one %d at %v", index, distance, want, wantDistance)
+		}
+	}
+
+	if _, err := LoadBVH(primitives[1:], data); err == nil {
+		t.Error("A BVH shouldn't load over a different number of primitives")
+	}
+	if _, err := LoadBVH(primitives, data[:len(data)-1]); err == nil {
+		t.Error("A truncated BVH shouldn't load")
+	}
+	corrupt := append([]byte(nil), data...)
+	// The right child of the root pointing back at it
+	corrupt[8+48] = 0
+	if _, err := LoadBVH(primitives, corrupt); err == nil {
+		t.Error("A BVH whose nodes aren't a tree shouldn't load")
+	}
+	empty, _ := NewBVH(nil).MarshalBinary()
+	if bvh, err := LoadBVH(nil, empty); err != nil || bvh.Size() != 0 {
+		t.Errorf("An empty BVH should load, not fail with %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !gpu || !cgo
+
+package gpu
+
+import "errors"
+
+// device stands for the GPU in the builds without one, which have none
+type device struct{}
+
+// newDevice returns why there's no GPU in the builds without the gpu tag
+// or cgo
+func newDevice(nodes, triangles []float32) (*device, error) {
+	return nil, errors.New("gpu: built without the gpu tag or cgo")
+}
+
+func (d *device) update(nodes, triangles []float32) error {
+	return nil
+}
+
+func (d *device) trace(rays, hits []float32) error {
+	return nil
+}
+
+func (d *device) close() {}
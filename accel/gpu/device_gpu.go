@@ -0,0 +1,223 @@
+//go:build gpu && cgo
+
+package gpu
+
+// #cgo LDFLAGS: -lEGL -lGLESv2
+// #include <stdlib.h>
+// #include "gpu.h"
+import "C"
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// kernel traverses the hierarchy and tests the triangles for a lightray
+// on every thread, as BVH.Intersect does on the CPU: the left child of a
+// node first, and the right one from a stack. The triangles are tested
+// with the algorithm of Möller and Trumbore, whose barycentric coordinates
+// are let past the edges by margin, so that rounding doesn't lose hits,
+// and the bounds of the nodes are let past by farScale.
+const kernel = `#version 310 es
+precision highp float;
+precision highp int;
+layout(local_size_x = 64) in;
+
+struct Node { vec4 lo; vec4 hi; };
+layout(std430, binding = 0) readonly buffer Nodes { Node nodes[]; };
+layout(std430, binding = 1) readonly buffer Triangles { vec4 vertices[]; };
+layout(std430, binding = 2) readonly buffer Rays { vec4 rays[]; };
+layout(std430, binding = 3) writeonly buffer Hits { vec2 hits[]; };
+uniform uint count;
+
+const float margin = 1e-5;
+const float farScale = 1.0 + 1e-5;
+
+void main() {
+	uint ray = gl_GlobalInvocationID.x;
+	if (ray >= count) {
+		return;
+	}
+	vec4 s = rays[2u * ray], d = rays[2u * ray + 1u];
+	vec3 source = s.xyz, direction = d.xyz;
+	int skip = floatBitsToInt(s.w);
+	float nearest = 3.402823e38;
+	int hit = -1;
+	if (direction != vec3(0.0)) {
+		vec3 inverse = 1.0 / mix(direction, vec3(1e-30), equal(direction, vec3(0.0)));
+		int stack[64];
+		int top = 1;
+		stack[0] = 0;
+		while (top > 0) {
+			int current = stack[--top];
+			Node n = nodes[current];
+			vec3 t0 = (n.lo.xyz - source) * inverse, t1 = (n.hi.xyz - source) * inverse;
+			vec3 near = min(t0, t1), far = max(t0, t1);
+			float enter = max(max(near.x, near.y), max(near.z, 0.0));
+			float exit = min(min(far.x, far.y), far.z) * farScale;
+			if (enter > exit || enter >= nearest) {
+				continue;
+			}
+			int link = floatBitsToInt(n.lo.w), triangles = floatBitsToInt(n.hi.w);
+			if (triangles == 0) {
+				stack[top++] = link;
+				stack[top++] = current + 1;
+				continue;
+			}
+			for (int k = link; k < link + triangles; k++) {
+				vec4 a = vertices[3 * k];
+				vec3 e1 = vertices[3 * k + 1].xyz - a.xyz, e2 = vertices[3 * k + 2].xyz - a.xyz;
+				vec3 p = cross(direction, e2);
+				float determinant = dot(e1, p);
+				// The back faces are the ones the lightrays hit with a
+				// negative determinant, and the fourth of a culls them
+				if (determinant == 0.0 || a.w != 0.0 && determinant < 0.0) {
+					continue;
+				}
+				float inverseDeterminant = 1.0 / determinant;
+				vec3 o = source - a.xyz;
+				float u = dot(o, p) * inverseDeterminant;
+				if (u < -margin || u > 1.0 + margin) {
+					continue;
+				}
+				vec3 q = cross(o, e1);
+				float v = dot(direction, q) * inverseDeterminant;
+				if (v < -margin || u + v > 1.0 + margin) {
+					continue;
+				}
+				float t = dot(e2, q) * inverseDeterminant;
+				if (t > 0.0 && t < nearest && (k != skip || t >= d.w)) {
+					nearest = t;
+					hit = k;
+				}
+			}
+		}
+	}
+	hits[ray] = vec2(nearest, intBitsToFloat(hit));
+}
+`
+
+var (
+	// mu guards the context, which the thread that holds it makes current
+	mu      sync.Mutex
+	open    sync.Once
+	openErr error
+)
+
+// device holds a hierarchy in the buffers of the GPU
+type device struct {
+	// buffers are the nodes, triangles, rays and hits, as the kernel binds
+	// them, and capacity the number of lightrays the last two hold
+	buffers  [4]C.uint
+	capacity int
+	// err is why the device failed, after which it isn't used
+	err error
+}
+
+// newDevice uploads the nodes and triangles to the GPU
+func newDevice(nodes, triangles []float32) (*device, error) {
+	open.Do(func() {
+		source := C.CString(kernel)
+		defer C.free(unsafe.Pointer(source))
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		mu.Lock()
+		defer mu.Unlock()
+		openErr = failure(C.gpu_open(source))
+	})
+	if openErr != nil {
+		return nil, openErr
+	}
+	d := &device{}
+	err := bound(func() error {
+		for i, data := range [][]float32{nodes, triangles, make([]float32, raySize), make([]float32, hitSize)} {
+			if err := failure(C.gpu_buffer(&d.buffers[i], unsafe.Pointer(&data[0]), bytes(data))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		d.close()
+		return nil, err
+	}
+	d.capacity = 1
+	return d, nil
+}
+
+// update uploads the nodes and triangles again
+func (d *device) update(nodes, triangles []float32) error {
+	return bound(func() error {
+		if err := failure(C.gpu_update(d.buffers[0], unsafe.Pointer(&nodes[0]), bytes(nodes))); err != nil {
+			return err
+		}
+		return failure(C.gpu_update(d.buffers[1], unsafe.Pointer(&triangles[0]), bytes(triangles)))
+	})
+}
+
+// trace traces the rays on the GPU and sets their hits
+func (d *device) trace(rays, hits []float32) error {
+	count := len(rays) / raySize
+	if count == 0 {
+		return nil
+	}
+	return bound(func() error {
+		if d.err != nil {
+			return d.err
+		}
+		if count > d.capacity {
+			// The hits are written by the kernel, so they needn't be
+			// uploaded but only allocated
+			d.capacity = count
+			if d.err = failure(C.gpu_update(d.buffers[3], nil, C.size_t(4*hitSize*count))); d.err != nil {
+				return d.err
+			}
+		}
+		if d.err = failure(C.gpu_update(d.buffers[2], unsafe.Pointer(&rays[0]), bytes(rays))); d.err != nil {
+			return d.err
+		}
+		d.err = failure(C.gpu_trace(&d.buffers[0], C.int(count), unsafe.Pointer(&hits[0]), bytes(hits)))
+		return d.err
+	})
+}
+
+// close frees the buffers of the device
+func (d *device) close() {
+	bound(func() error {
+		for _, b := range d.buffers {
+			if b != 0 {
+				C.gpu_free(b)
+			}
+		}
+		return nil
+	})
+}
+
+// bound calls f with the context current on the thread
+func bound(f func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	mu.Lock()
+	defer mu.Unlock()
+	if err := failure(C.gpu_bind()); err != nil {
+		return err
+	}
+	defer C.gpu_release()
+	return f()
+}
+
+// failure returns the error of what a device function returns, nil if
+// it's NULL
+func failure(what *C.char) error {
+	if what == nil {
+		return nil
+	}
+	return errors.New("gpu: " + C.GoString(what))
+}
+
+// bytes returns the size of the data in bytes
+func bytes(data []float32) C.size_t {
+	return C.size_t(4 * len(data))
+}
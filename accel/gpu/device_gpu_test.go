@@ -0,0 +1,13 @@
+//go:build gpu && cgo
+
+package gpu
+
+import "testing"
+
+func TestBuildsWithTheTagTraceOnTheDevice(t *testing.T) {
+	g := New(terrain(8))
+	defer g.Close()
+	if g.Err != nil {
+		t.Fatal("The hierarchy should be on the device: ", g.Err)
+	}
+}
@@ -0,0 +1,123 @@
+//go:build gpu && cgo
+
+#include <EGL/egl.h>
+#include <EGL/eglext.h>
+#include <GLES3/gl31.h>
+#include <string.h>
+
+#include "gpu.h"
+
+// The display, context and kernel are shared by every hierarchy, and only
+// used by the thread that holds the lock of device_gpu.go
+static EGLDisplay display = EGL_NO_DISPLAY;
+static EGLContext context = EGL_NO_CONTEXT;
+static GLuint program;
+static GLint countLocation;
+
+// The work groups of the kernel, which must match its local_size_x
+#define GROUP 64
+
+const char *gpu_open(const char *kernel) {
+	PFNEGLGETPLATFORMDISPLAYEXTPROC getPlatformDisplay =
+		(PFNEGLGETPLATFORMDISPLAYEXTPROC)eglGetProcAddress("eglGetPlatformDisplayEXT");
+	// Surfaceless displays need no window system, as on render servers
+	if (getPlatformDisplay != NULL) {
+		display = getPlatformDisplay(EGL_PLATFORM_SURFACELESS_MESA, EGL_DEFAULT_DISPLAY, NULL);
+	}
+	if (display == EGL_NO_DISPLAY) {
+		display = eglGetDisplay(EGL_DEFAULT_DISPLAY);
+	}
+	if (display == EGL_NO_DISPLAY || !eglInitialize(display, NULL, NULL)) {
+		return "there's no EGL display";
+	}
+	if (!eglBindAPI(EGL_OPENGL_ES_API)) {
+		return "EGL doesn't support OpenGL ES";
+	}
+	EGLint configAttributes[] = {EGL_RENDERABLE_TYPE, EGL_OPENGL_ES3_BIT, EGL_NONE};
+	EGLConfig config = NULL;
+	EGLint configs = 0;
+	eglChooseConfig(display, configAttributes, &config, 1, &configs);
+	EGLint contextAttributes[] = {EGL_CONTEXT_MAJOR_VERSION, 3, EGL_CONTEXT_MINOR_VERSION, 1, EGL_NONE};
+	context = eglCreateContext(display, configs > 0 ? config : EGL_NO_CONFIG_KHR, EGL_NO_CONTEXT, contextAttributes);
+	if (context == EGL_NO_CONTEXT) {
+		return "there's no OpenGL ES 3.1 device";
+	}
+	const char *err = gpu_bind();
+	if (err != NULL) {
+		return err;
+	}
+	GLuint shader = glCreateShader(GL_COMPUTE_SHADER);
+	glShaderSource(shader, 1, &kernel, NULL);
+	glCompileShader(shader);
+	GLint ok = GL_FALSE;
+	glGetShaderiv(shader, GL_COMPILE_STATUS, &ok);
+	if (!ok) {
+		gpu_release();
+		return "the kernel doesn't compile";
+	}
+	program = glCreateProgram();
+	glAttachShader(program, shader);
+	glLinkProgram(program);
+	glDeleteShader(shader);
+	glGetProgramiv(program, GL_LINK_STATUS, &ok);
+	if (!ok) {
+		gpu_release();
+		return "the kernel doesn't link";
+	}
+	countLocation = glGetUniformLocation(program, "count");
+	gpu_release();
+	return NULL;
+}
+
+const char *gpu_bind(void) {
+	if (!eglMakeCurrent(display, EGL_NO_SURFACE, EGL_NO_SURFACE, context)) {
+		return "the context can't be made current";
+	}
+	return NULL;
+}
+
+void gpu_release(void) {
+	eglMakeCurrent(display, EGL_NO_SURFACE, EGL_NO_SURFACE, EGL_NO_CONTEXT);
+}
+
+const char *gpu_buffer(unsigned *buffer, const void *data, size_t size) {
+	GLuint b;
+	glGenBuffers(1, &b);
+	*buffer = b;
+	return gpu_update(b, data, size);
+}
+
+const char *gpu_update(unsigned buffer, const void *data, size_t size) {
+	glBindBuffer(GL_SHADER_STORAGE_BUFFER, buffer);
+	glBufferData(GL_SHADER_STORAGE_BUFFER, size, data, GL_DYNAMIC_DRAW);
+	if (glGetError() != GL_NO_ERROR) {
+		return "the buffer can't be uploaded";
+	}
+	return NULL;
+}
+
+const char *gpu_trace(const unsigned buffers[4], int count, void *hits, size_t size) {
+	glUseProgram(program);
+	for (int i = 0; i < 4; i++) {
+		glBindBufferBase(GL_SHADER_STORAGE_BUFFER, i, buffers[i]);
+	}
+	glUniform1ui(countLocation, (GLuint)count);
+	glDispatchCompute((count + GROUP - 1) / GROUP, 1, 1);
+	glMemoryBarrier(GL_BUFFER_UPDATE_BARRIER_BIT);
+	glBindBuffer(GL_SHADER_STORAGE_BUFFER, buffers[3]);
+	const void *mapped = glMapBufferRange(GL_SHADER_STORAGE_BUFFER, 0, size, GL_MAP_READ_BIT);
+	if (mapped == NULL) {
+		return "the hits can't be read back";
+	}
+	memcpy(hits, mapped, size);
+	glUnmapBuffer(GL_SHADER_STORAGE_BUFFER);
+	if (glGetError() != GL_NO_ERROR) {
+		return "the kernel failed";
+	}
+	return NULL;
+}
+
+void gpu_free(unsigned buffer) {
+	GLuint b = buffer;
+	glDeleteBuffers(1, &b);
+}
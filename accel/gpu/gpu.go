@@ -0,0 +1,290 @@
+// Package gpu traces batches of lightrays through a BVH over triangles on
+// a GPU, wavefront style: the lightrays of a batch are uploaded together,
+// in waves of up to maxWave, and a compute kernel traverses the hierarchy
+// and tests the triangles for each of them on a thread of its own, so the
+// device keeps thousands of lightrays in flight instead of one.
+//
+// The device is reached through EGL and the compute shaders of OpenGL ES
+// 3.1, which the drivers of most GPUs on Linux provide, only in the builds
+// with the gpu tag and cgo, which link libEGL and libGLESv2. Without them,
+// or when there's no device, or the primitives aren't all triangles it can
+// test, the batches are traced through the same BVH on the CPU.
+//
+// The device tests in float32, so the hit it finds for a lightray is tested
+// again in float64 on the CPU, which gives the distance that the CPU finds,
+// and the lightrays whose hits don't pass are traced on the CPU. Where two
+// triangles are hit at almost the same distance, the device may take the
+// one the CPU would take second.
+package gpu
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// maxWave is the number of lightrays uploaded to the device at once
+const maxWave = 1 << 16
+
+// maxDepth is the depth of the deepest hierarchy the kernel traverses,
+// whose stack holds maxDepth+1 nodes
+const maxDepth = 63
+
+// Node and lightray layouts, in float32, that the kernel reads
+const (
+	nodeSize     = 8
+	triangleSize = 12
+	raySize      = 8
+	hitSize      = 2
+)
+
+// BVH is a bounding volume hierarchy over triangles that traces batches
+// of lightrays on the GPU. It's an accel.BVH, whose queries of single
+// lightrays and packets it answers on the CPU.
+type BVH struct {
+	*accel.BVH
+	// Err is why the batches are traced on the CPU, or nil if they're
+	// traced on the GPU
+	Err error
+	// device holds the hierarchy on the GPU, nil if it's traced on the CPU
+	device *device
+	// triangles are the primitives in the order of the leaves, slots
+	// their positions in it and order their indices
+	triangles []accel.Primitive
+	slots     map[interface{}]int
+	order     []int
+	// counting and filtering are whether the queries are counted or
+	// filtered, which the device can't do
+	counting, filtering bool
+}
+
+// New builds a hierarchy over the primitives and uploads it to the GPU,
+// or leaves it on the CPU, with the reason in Err, if it can't
+func New(primitives []accel.Primitive) *BVH {
+	b := &BVH{BVH: accel.NewBVH(primitives)}
+	b.Err = b.upload(primitives)
+	if b.device != nil {
+		runtime.SetFinalizer(b, (*BVH).Close)
+	}
+	return b
+}
+
+// upload uploads the hierarchy over the primitives to the device
+func (b *BVH) upload(primitives []accel.Primitive) error {
+	nodes, order := b.BVH.Flatten()
+	if len(nodes) == 0 {
+		return errors.New("gpu: there are no primitives")
+	}
+	if depth(nodes, 0) > maxDepth {
+		return fmt.Errorf("gpu: the hierarchy is deeper than %d nodes", maxDepth)
+	}
+	b.order = order
+	b.triangles = make([]accel.Primitive, len(order))
+	b.slots = make(map[interface{}]int, len(order))
+	for slot, i := range order {
+		p := primitives[i]
+		if t := triangle(p); t == nil {
+			return fmt.Errorf("gpu: the primitive %d is a %T, not a triangle", i, p)
+		} else if t.Opacity != nil || t.Velocities != [3]math3d.Vector3{} {
+			return fmt.Errorf("gpu: the triangle %d has holes or moves", i)
+		}
+		b.triangles[slot], b.slots[p] = p, slot
+	}
+	d, err := newDevice(packNodes(nodes), packTriangles(b.triangles))
+	if err != nil {
+		return err
+	}
+	b.device = d
+	return nil
+}
+
+// triangle returns the primitive as a Triangle, or nil if it's no triangle
+func triangle(p accel.Primitive) *shape.Triangle {
+	switch p := p.(type) {
+	case *shape.Triangle:
+		return p
+	case *shape.MeshTriangle:
+		return p.Triangle()
+	}
+	return nil
+}
+
+// depth returns the depth of the subtree of the node
+func depth(nodes []accel.FlatNode, i int) int {
+	if nodes[i].Count > 0 {
+		return 1
+	}
+	left, right := depth(nodes, i+1), depth(nodes, nodes[i].Right)
+	if right > left {
+		left = right
+	}
+	return left + 1
+}
+
+// Refit updates the hierarchy after the triangles moved, on the CPU and
+// on the device
+func (b *BVH) Refit() {
+	b.BVH.Refit()
+	if b.device == nil {
+		return
+	}
+	nodes, _ := b.BVH.Flatten()
+	if err := b.device.update(packNodes(nodes), packTriangles(b.triangles)); err != nil {
+		b.Close()
+		b.Err = err
+	}
+}
+
+// Count makes the queries add the work they do to c, or stop counting it
+// if c is nil. The batches are traced on the CPU while they're counted,
+// as the device doesn't count its work.
+func (b *BVH) Count(c *accel.Counters) {
+	b.BVH.Count(c)
+	b.counting = c != nil
+}
+
+// Filter makes the queries call f for every hit, or stop filtering them if
+// f is nil. The batches are traced on the CPU while they're filtered, as
+// the device can't call f.
+func (b *BVH) Filter(f accel.Filter) {
+	b.BVH.Filter(f)
+	b.filtering = f != nil
+}
+
+// Close frees the hierarchy on the device. The batches are traced on the
+// CPU afterwards.
+func (b *BVH) Close() {
+	if b.device != nil {
+		b.device.close()
+		b.device = nil
+		b.Err = errors.New("gpu: the hierarchy was closed")
+	}
+}
+
+// IntersectRays sets the distances and indices to what Intersect returns
+// for every lightray, tracing them on the device if the hierarchy is
+// there. If the device fails, they're traced on the CPU, as are those of
+// the batches after. The lightrays that are nil get math.MaxFloat64 and
+// -1.
+func (b *BVH) IntersectRays(lrs []*math3d.LightRay, distances []float64, indices []int) {
+	if b.device == nil || b.counting || b.filtering {
+		accel.IntersectRays(b.BVH, lrs, distances, indices)
+		return
+	}
+	for start := 0; start < len(lrs); start += maxWave {
+		end := start + maxWave
+		if end > len(lrs) {
+			end = len(lrs)
+		}
+		if err := b.intersectWave(lrs[start:end], distances[start:end], indices[start:end]); err != nil {
+			accel.IntersectRays(b.BVH, lrs[start:], distances[start:], indices[start:])
+			return
+		}
+	}
+}
+
+// intersectWave traces a wave of lightrays on the device
+func (b *BVH) intersectWave(lrs []*math3d.LightRay, distances []float64, indices []int) error {
+	rays := make([]float32, 0, raySize*len(lrs))
+	for _, lr := range lrs {
+		rays = b.appendRay(rays, lr)
+	}
+	hits := make([]float32, hitSize*len(lrs))
+	if err := b.device.trace(rays, hits); err != nil {
+		return err
+	}
+	for j, lr := range lrs {
+		distances[j], indices[j] = math.MaxFloat64, -1
+		slot := int(int32(math.Float32bits(hits[hitSize*j+1])))
+		if lr == nil || slot < 0 {
+			continue
+		}
+		i := b.order[slot]
+		if d := b.BVH.HitDistance(i, lr); d != math.MaxFloat64 {
+			distances[j], indices[j] = d, i
+		} else {
+			distances[j], indices[j] = b.BVH.Intersect(lr)
+		}
+	}
+	return nil
+}
+
+// appendRay appends the lightray to the rays as the kernel reads them: the
+// source, the slot of the triangle it leaves, the direction and the
+// distance below which it doesn't hit that triangle. The lightrays that
+// are nil go nowhere.
+func (b *BVH) appendRay(rays []float32, lr *math3d.LightRay) []float32 {
+	if lr == nil {
+		// The kernel takes the lightrays without a direction as missing
+		return append(rays, 0, 0, 0, 0, 0, 0, 0, 0)
+	}
+	skip, skipDistance := -1, 0.0
+	if lr.Origin != nil {
+		if slot, ok := b.slots[lr.Origin]; ok {
+			// The device ignores a bit less than the CPU does, as the hits
+			// it finds are tested again on the CPU, but those it misses
+			// aren't
+			skip, skipDistance = slot, (1-1e-3)*accel.SelfHitDistance(lr)
+		}
+	}
+	return append(rays, float32(lr.Source.X), float32(lr.Source.Y), float32(lr.Source.Z), math.Float32frombits(uint32(int32(skip))),
+		float32(lr.Direction.X), float32(lr.Direction.Y), float32(lr.Direction.Z), float32(skipDistance))
+}
+
+// packNodes returns the nodes as the kernel reads them: the minimum of the
+// bounds, the right child of an inner node or the first triangle of a
+// leaf, the maximum of the bounds and the number of triangles. The bounds
+// are rounded outwards, so no lightray misses a node that holds what it
+// hits.
+func packNodes(nodes []accel.FlatNode) []float32 {
+	packed := make([]float32, 0, nodeSize*len(nodes))
+	for _, n := range nodes {
+		link := n.Right
+		if n.Count > 0 {
+			link = n.First
+		}
+		packed = append(packed, down(n.Bounds.Min.X), down(n.Bounds.Min.Y), down(n.Bounds.Min.Z), math.Float32frombits(uint32(int32(link))),
+			up(n.Bounds.Max.X), up(n.Bounds.Max.Y), up(n.Bounds.Max.Z), math.Float32frombits(uint32(int32(n.Count))))
+	}
+	return packed
+}
+
+// packTriangles returns the vertices of the triangles as the kernel reads
+// them, each followed by whether the back face of the triangle is culled
+func packTriangles(triangles []accel.Primitive) []float32 {
+	packed := make([]float32, 0, triangleSize*len(triangles))
+	for _, p := range triangles {
+		t := triangle(p)
+		cull := float32(0)
+		if t.CullBackfaces {
+			cull = 1
+		}
+		for _, v := range t.Vertices {
+			packed = append(packed, float32(v.X), float32(v.Y), float32(v.Z), cull)
+		}
+	}
+	return packed
+}
+
+// down returns the largest float32 that isn't greater than x
+func down(x float64) float32 {
+	f := float32(x)
+	if float64(f) > x {
+		f = math.Nextafter32(f, float32(math.Inf(-1)))
+	}
+	return f
+}
+
+// up returns the smallest float32 that isn't smaller than x
+func up(x float64) float32 {
+	f := float32(x)
+	if float64(f) < x {
+		f = math.Nextafter32(f, float32(math.Inf(1)))
+	}
+	return f
+}
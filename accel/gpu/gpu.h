@@ -0,0 +1,10 @@
+// The device functions of egl.c, which return NULL or what failed
+#include <stddef.h>
+
+const char *gpu_open(const char *kernel);
+const char *gpu_bind(void);
+void gpu_release(void);
+const char *gpu_buffer(unsigned *buffer, const void *data, size_t size);
+const char *gpu_update(unsigned buffer, const void *data, size_t size);
+const char *gpu_trace(const unsigned buffers[4], int count, void *hits, size_t size);
+void gpu_free(unsigned buffer);
@@ -0,0 +1,175 @@
+package gpu
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// terrain returns the triangles of a size x size grid of random heights,
+// which share their edges, and of a few triangles floating above it, the
+// odd ones with their back faces culled
+func terrain(size int) []accel.Primitive {
+	r := rand.New(rand.NewSource(5))
+	heights := make([]float64, (size+1)*(size+1))
+	for i := range heights {
+		heights[i] = r.Float64() * 0.3
+	}
+	vertex := func(x, z int) math3d.Vector3 {
+		return math3d.Vector3{X: float64(x)/float64(size)*10 - 5, Y: heights[z*(size+1)+x], Z: float64(z)/float64(size)*10 - 5}
+	}
+	primitives := make([]accel.Primitive, 0, 2*size*size+size)
+	for z := 0; z < size; z++ {
+		for x := 0; x < size; x++ {
+			a, b, c, d := vertex(x, z), vertex(x+1, z), vertex(x+1, z+1), vertex(x, z+1)
+			primitives = append(primitives, &shape.Triangle{Vertices: [3]math3d.Vector3{a, c, b}},
+				&shape.Triangle{Vertices: [3]math3d.Vector3{a, d, c}})
+		}
+	}
+	for i := 0; i < size; i++ {
+		center := math3d.Vector3{X: r.Float64()*8 - 4, Y: 1 + r.Float64(), Z: r.Float64()*8 - 4}
+		t := &shape.Triangle{CullBackfaces: i%2 == 1}
+		for v := range t.Vertices {
+			t.Vertices[v] = center.AddV(math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: r.Float64() - 0.5})
+		}
+		primitives = append(primitives, t)
+	}
+	return primitives
+}
+
+// cameraRays returns the lightrays through the pixels of a size x size
+// image of a camera looking down at the terrain
+func cameraRays(size int) []*math3d.LightRay {
+	lrs := make([]*math3d.LightRay, 0, size*size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			u, v := (float64(x)+0.5)/float64(size)-0.5, (float64(y)+0.5)/float64(size)-0.5
+			lrs = append(lrs, &math3d.LightRay{Source: math3d.Vector3{Y: 6, Z: -6},
+				Direction: *(&math3d.Vector3{X: u, Y: v - 0.7, Z: 0.7}).Normalized()})
+		}
+	}
+	return lrs
+}
+
+// matches fails the test unless the hits of the lightrays are the ones
+// of the BVH, or ones at the same distance
+func matches(t *testing.T, bvh *accel.BVH, lrs []*math3d.LightRay, distances []float64, indices []int) {
+	t.Helper()
+	for j, lr := range lrs {
+		expectedDistance, expected := math.MaxFloat64, -1
+		if lr != nil {
+			expectedDistance, expected = bvh.Intersect(lr)
+		}
+		if indices[j] == expected && distances[j] == expectedDistance {
+			continue
+		}
+		if indices[j] < 0 || expected < 0 || math.Abs(distances[j]-expectedDistance) > 1e-9*expectedDistance {
+			t.Fatalf("The lightray %d hit %d at %v, not %d at %v", j, indices[j], distances[j], expected, expectedDistance)
+		}
+	}
+}
+
+func TestIntersectRaysMatchesTheBVH(t *testing.T) {
+	primitives := terrain(64)
+	g := New(primitives)
+	defer g.Close()
+	if g.Err != nil {
+		t.Log("Traced on the CPU: ", g.Err)
+	}
+	bvh := accel.NewBVH(primitives)
+
+	lrs := cameraRays(128)
+	lrs[7] = nil
+	distances, indices := make([]float64, len(lrs)), make([]int, len(lrs))
+	g.IntersectRays(lrs, distances, indices)
+	matches(t, bvh, lrs, distances, indices)
+
+	// The lightrays that leave the terrain where the camera's hit it don't
+	// hit the triangles they leave
+	r := rand.New(rand.NewSource(9))
+	var bounces []*math3d.LightRay
+	for j, lr := range lrs {
+		if lr == nil || indices[j] < 0 {
+			continue
+		}
+		bounces = append(bounces, &math3d.LightRay{Source: lr.Source.AddV(lr.Direction.MultiplyV(distances[j])),
+			Direction: *(&math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64(), Z: r.Float64() - 0.5}).Normalized(),
+			Origin:    primitives[indices[j]]})
+	}
+	distances, indices = make([]float64, len(bounces)), make([]int, len(bounces))
+	g.IntersectRays(bounces, distances, indices)
+	matches(t, bvh, bounces, distances, indices)
+
+	// The moved triangles are found where they moved to
+	for _, p := range primitives[len(primitives)-64:] {
+		tr := p.(*shape.Triangle)
+		for v := range tr.Vertices {
+			tr.Vertices[v].Y -= 0.5
+		}
+	}
+	g.Refit()
+	bvh.Refit()
+	distances, indices = make([]float64, len(lrs)), make([]int, len(lrs))
+	g.IntersectRays(lrs, distances, indices)
+	matches(t, bvh, lrs, distances, indices)
+}
+
+func TestIntersectRaysOfPackedMeshes(t *testing.T) {
+	triangles := make([]*shape.Triangle, 0, 2*32*32)
+	for _, p := range terrain(32)[:2*32*32] {
+		triangles = append(triangles, p.(*shape.Triangle))
+	}
+	var primitives []accel.Primitive
+	for _, sh := range shape.PackTriangles(triangles).Triangles() {
+		primitives = append(primitives, sh)
+	}
+	g := New(primitives)
+	defer g.Close()
+	lrs := cameraRays(64)
+	distances, indices := make([]float64, len(lrs)), make([]int, len(lrs))
+	g.IntersectRays(lrs, distances, indices)
+	matches(t, accel.NewBVH(primitives), lrs, distances, indices)
+}
+
+func TestBatchesOfOtherPrimitivesAreTracedOnTheCPU(t *testing.T) {
+	primitives := append(terrain(8), &shape.Sphere{Position: math3d.Vector3{Y: 2}, Radius: 1})
+	g := New(primitives)
+	if g.Err == nil {
+		t.Fatal("A hierarchy with a sphere shouldn't be uploaded")
+	}
+	lrs := cameraRays(32)
+	distances, indices := make([]float64, len(lrs)), make([]int, len(lrs))
+	accel.IntersectRays(g, lrs, distances, indices)
+	for j, lr := range lrs {
+		if d, i := g.Intersect(lr); distances[j] != d || indices[j] != i {
+			t.Fatalf("The lightray %d hit %d at %v, not %d at %v", j, indices[j], distances[j], i, d)
+		}
+	}
+}
+
+func BenchmarkIntersectRays(b *testing.B) {
+	primitives := terrain(256)
+	g := New(primitives)
+	defer g.Close()
+	if g.Err != nil {
+		b.Log("Traced on the CPU: ", g.Err)
+	}
+	lrs := cameraRays(256)
+	distances, indices := make([]float64, len(lrs)), make([]int, len(lrs))
+	b.Run("gpu", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			g.IntersectRays(lrs, distances, indices)
+		}
+	})
+	b.Run("bvh", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for j, lr := range lrs {
+				distances[j], indices[j] = g.BVH.Intersect(lr)
+			}
+		}
+	})
+}
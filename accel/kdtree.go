@@ -0,0 +1,303 @@
+package accel
+
+import (
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Costs of the surface area heuristic that builds kd-trees, relative to
+// each other, as in PBRT
+const (
+	kdTraversalCost    = 1
+	kdIntersectionCost = 80
+	// kdEmptyBonus lowers the cost of the splits that leave a side empty,
+	// which rays cross without testing anything
+	kdEmptyBonus = 0.5
+	// kdMaxBadRefines is the number of splits costlier than a leaf that a
+	// branch may have before it's made a leaf
+	kdMaxBadRefines = 3
+	// kdLeaf is the axis of the leaves
+	kdLeaf = 3
+)
+
+// KDTree is a kd-tree over a slice of primitives, built with the surface
+// area heuristic. It splits space rather than the primitives, so the
+// primitives across a split are on both sides, and rays visit the regions
+// they cross front to back, stopping at the first one with a hit. It
+// beats a BVH on static scenes whose primitives overlap a lot, but it
+// can't be refitted when they move.
+type KDTree struct {
+	primitives []Primitive
+	// indices holds the primitive indices of the leaves, one range after
+	// another
+	indices []int
+	nodes   []kdNode
+	bounds  math3d.AABB
+	// counters counts the work of the queries if it isn't nil
+	counters *Counters
+	// filter decides which hits count if it isn't nil
+	filter Filter
+}
+
+// kdNode is either an inner node that splits space at split along axis,
+// whose side below split is the next node and the side above it is
+// above, or a leaf with a range of indices
+type kdNode struct {
+	split        float64
+	axis         int
+	above        int
+	first, count int
+}
+
+// kdEdge is where the bounds of a primitive start or end along an axis
+type kdEdge struct {
+	t     float64
+	start bool
+}
+
+// NewKDTree builds a kd-tree over the primitives
+func NewKDTree(primitives []Primitive) *KDTree {
+	t := &KDTree{primitives: primitives, bounds: *math3d.EmptyAABB()}
+	if len(primitives) == 0 {
+		return t
+	}
+	bounds := make([]math3d.AABB, len(primitives))
+	all := make([]int, len(primitives))
+	for i, p := range primitives {
+		bounds[i] = *p.Bounds()
+		t.bounds = *t.bounds.Union(&bounds[i])
+		all[i] = i
+	}
+	maxDepth := int(math.Round(8 + 1.3*math.Log2(float64(len(primitives)))))
+	t.build(bounds, t.bounds, all, maxDepth, 0)
+	return t
+}
+
+// build creates the subtree for the primitives within region and returns
+// the index of its root node
+func (t *KDTree) build(bounds []math3d.AABB, region math3d.AABB, primitives []int, depth, badRefines int) int {
+	current := len(t.nodes)
+	t.nodes = append(t.nodes, kdNode{axis: kdLeaf})
+	area := region.SurfaceArea()
+	if len(primitives) <= 1 || depth == 0 || !(area > 0) {
+		t.leaf(current, primitives)
+		return current
+	}
+
+	// Find the split with the lowest cost among the bounds of the
+	// primitives along every axis
+	leafCost := kdIntersectionCost * float64(len(primitives))
+	bestCost, bestAxis, bestSplit := math.Inf(1), -1, 0.0
+	extent := region.Max.SubtractV(region.Min)
+	edges := make([]kdEdge, 0, 2*len(primitives))
+	for axis := 0; axis < 3; axis++ {
+		edges = edges[:0]
+		for _, i := range primitives {
+			edges = append(edges, kdEdge{component(&bounds[i].Min, axis), true}, kdEdge{component(&bounds[i].Max, axis), false})
+		}
+		sort.Slice(edges, func(a, b int) bool {
+			if edges[a].t != edges[b].t {
+				return edges[a].t < edges[b].t
+			}
+			return edges[a].start && !edges[b].start
+		})
+		low, high := component(&region.Min, axis), component(&region.Max, axis)
+		across := component(&extent, (axis+1)%3) * component(&extent, (axis+2)%3)
+		around := component(&extent, (axis+1)%3) + component(&extent, (axis+2)%3)
+		below, above := 0, len(primitives)
+		for _, e := range edges {
+			if !e.start {
+				above--
+			}
+			if e.t > low && e.t < high {
+				belowArea := 2 * (across + (e.t-low)*around)
+				aboveArea := 2 * (across + (high-e.t)*around)
+				bonus := 0.0
+				if below == 0 || above == 0 {
+					bonus = kdEmptyBonus
+				}
+				cost := kdTraversalCost + kdIntersectionCost*(1-bonus)*
+					(belowArea/area*float64(below)+aboveArea/area*float64(above))
+				if cost < bestCost {
+					bestCost, bestAxis, bestSplit = cost, axis, e.t
+				}
+			}
+			if e.start {
+				below++
+			}
+		}
+	}
+	if bestCost > leafCost {
+		badRefines++
+	}
+	if bestAxis < 0 || badRefines == kdMaxBadRefines || (bestCost > 4*leafCost && len(primitives) < 16) {
+		t.leaf(current, primitives)
+		return current
+	}
+
+	var below, above []int
+	for _, i := range primitives {
+		low, high := component(&bounds[i].Min, bestAxis), component(&bounds[i].Max, bestAxis)
+		if low < bestSplit || (low == bestSplit && high == bestSplit) {
+			below = append(below, i)
+		}
+		if high > bestSplit || (low == bestSplit && high == bestSplit) {
+			above = append(above, i)
+		}
+	}
+	belowRegion, aboveRegion := region, region
+	setComponent(&belowRegion.Max, bestAxis, bestSplit)
+	setComponent(&aboveRegion.Min, bestAxis, bestSplit)
+	t.build(bounds, belowRegion, below, depth-1, badRefines)
+	aboveNode := t.build(bounds, aboveRegion, above, depth-1, badRefines)
+	t.nodes[current] = kdNode{split: bestSplit, axis: bestAxis, above: aboveNode}
+	return current
+}
+
+// leaf makes the node a leaf with the primitives
+func (t *KDTree) leaf(node int, primitives []int) {
+	t.nodes[node] = kdNode{axis: kdLeaf, first: len(t.indices), count: len(primitives)}
+	t.indices = append(t.indices, primitives...)
+}
+
+// Refit builds the tree again, as kd-trees can't be refitted
+func (t *KDTree) Refit() {
+	counters, filter := t.counters, t.filter
+	*t = *NewKDTree(t.primitives)
+	t.counters, t.filter = counters, filter
+}
+
+// Size returns the number of primitives in the tree
+func (t *KDTree) Size() int {
+	return len(t.primitives)
+}
+
+// Memory returns the number of bytes that the nodes of the tree and its
+// references to the primitives take, without the primitives
+func (t *KDTree) Memory() uint64 {
+	return uint64(len(t.nodes))*uint64(unsafe.Sizeof(kdNode{})) +
+		uint64(len(t.indices))*uint64(unsafe.Sizeof(0)) +
+		uint64(len(t.primitives))*uint64(unsafe.Sizeof(Primitive(nil)))
+}
+
+// Bounds returns the bounding box of all the primitives
+func (t *KDTree) Bounds() *math3d.AABB {
+	b := t.bounds
+	return &b
+}
+
+// Count makes the queries to the tree add the work they do to c, or stop
+// counting it if c is nil. It must not be called while the tree is queried.
+func (t *KDTree) Count(c *Counters) {
+	t.counters = c
+}
+
+// Filter makes the queries to the tree call f for every hit, or stop
+// filtering them if f is nil. It must not be called while the tree is
+// queried.
+func (t *KDTree) Filter(f Filter) {
+	t.filter = f
+}
+
+// Intersect returns the distance to the nearest primitive the lightray
+// intersects and its index. If it doesn't intersect any, it returns
+// math.MaxFloat64 and -1.
+func (t *KDTree) Intersect(lr *math3d.LightRay) (float64, int) {
+	nearestDistance, nearest := math.MaxFloat64, -1
+	t.traverse(lr, func(i int) bool {
+		if d := hitDistance(t.primitives[i], i, lr, t.filter); d < nearestDistance {
+			nearestDistance, nearest = d, i
+		}
+		return false
+	}, func() float64 { return nearestDistance })
+	return nearestDistance, nearest
+}
+
+// Occluded returns true if the lightray intersects any primitive at a
+// distance that is smaller than distance
+func (t *KDTree) Occluded(lr *math3d.LightRay, distance float64) bool {
+	occluded := false
+	t.traverse(lr, func(i int) bool {
+		occluded = hitDistance(t.primitives[i], i, lr, t.filter) < distance
+		return occluded
+	}, func() float64 { return distance })
+	return occluded
+}
+
+// kdTodo is a node that a lightray crosses from tMin to tMax, left to
+// visit after the nearer ones
+type kdTodo struct {
+	node       int
+	tMin, tMax float64
+}
+
+// traverse calls visit with every primitive in the leaves that the
+// lightray crosses, front to back, until visit returns true or the next
+// leaf is farther than maxDistance
+func (t *KDTree) traverse(lr *math3d.LightRay, visit func(int) bool, maxDistance func() float64) {
+	if len(t.nodes) == 0 {
+		return
+	}
+	tMin, tMax := t.bounds.IntersectRange(lr)
+	if tMin == math.MaxFloat64 {
+		return
+	}
+	// The work is counted locally and added once, so queries don't contend
+	// for the counters at every node
+	var nodes, tests uint64
+	defer func() { t.counters.add(nodes, tests) }()
+	stack := make([]kdTodo, 0, 64)
+	current := 0
+	for tMin <= maxDistance() {
+		n := &t.nodes[current]
+		nodes++
+		if n.axis != kdLeaf {
+			source, direction := component(&lr.Source, n.axis), component(&lr.Direction, n.axis)
+			tPlane := (n.split - source) / direction
+			first, second := current+1, n.above
+			if source > n.split || (source == n.split && direction > 0) {
+				first, second = second, first
+			}
+			switch {
+			case math.IsNaN(tPlane):
+				// The lightray runs along the plane, so it may touch both sides
+				stack = append(stack, kdTodo{second, tMin, tMax})
+				current = first
+			case tPlane > tMax || tPlane <= 0:
+				current = first
+			case tPlane < tMin:
+				current = second
+			default:
+				stack = append(stack, kdTodo{second, tPlane, tMax})
+				current, tMax = first, tPlane
+			}
+			continue
+		}
+		for _, i := range t.indices[n.first : n.first+n.count] {
+			tests++
+			if visit(i) {
+				return
+			}
+		}
+		if len(stack) == 0 {
+			return
+		}
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		current, tMin, tMax = next.node, next.tMin, next.tMax
+	}
+}
+
+func setComponent(v *math3d.Vector3, axis int, value float64) {
+	switch axis {
+	case 0:
+		v.X = value
+	case 1:
+		v.Y = value
+	default:
+		v.Z = value
+	}
+}
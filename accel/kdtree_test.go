@@ -0,0 +1,69 @@
+package accel
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestKDTreeMatchesBruteForce(t *testing.T) {
+	primitives := randomSpheres(300)
+	// Large spheres overlap many others and end up on both sides of splits
+	for i := 0; i < 5; i++ {
+		primitives = append(primitives, &shape.Sphere{Position: math3d.Vector3{X: float64(i) - 2, Z: 5}, Radius: 2})
+	}
+	tree := NewKDTree(primitives)
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 2000; i++ {
+		lr := math3d.LightRay{
+			Source:    math3d.Vector3{X: r.Float64()*20 - 10, Y: r.Float64()*20 - 10, Z: r.Float64()*20 - 5},
+			Direction: *(&math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: r.Float64() - 0.5}).Normalized()}
+		if i%4 == 0 {
+			// Rays parallel to the axes run along the splitting planes
+			lr.Direction = math3d.UnitZ
+		}
+		expectedDistance, expected := bruteForce(primitives, &lr)
+		distance, index := tree.Intersect(&lr)
+		if index != expected || distance != expectedDistance {
+			t.Fatalf("Ray %d: the kd-tree found %d at %.3f but the nearest is %d at %.3f", i, index, distance, expected, expectedDistance)
+		}
+		if tree.Occluded(&lr, math.MaxFloat64) != (expected >= 0) {
+			t.Fatal("Occlusion doesn't match the nearest intersection")
+		}
+		if expected >= 0 && tree.Occluded(&lr, expectedDistance*0.999) {
+			t.Fatal("Nothing should occlude the ray before the nearest intersection")
+		}
+	}
+}
+
+func TestKDTreeIsAnAccelerator(t *testing.T) {
+	primitives := randomSpheres(50)
+	for _, acc := range []Accelerator{NewBVH(primitives), NewKDTree(primitives), NewTwoLevel([]Instance{{Primitives: primitives}})} {
+		if acc.Size() != 50 {
+			t.Errorf("%T should hold the 50 spheres, it holds %d", acc, acc.Size())
+		}
+		var counters Counters
+		acc.Count(&counters)
+		moved := primitives[10].(*shape.Sphere)
+		moved.Translate(&math3d.Vector3{X: 100})
+		acc.Refit()
+		lr := math3d.LightRay{Source: math3d.Vector3{X: moved.Position.X, Y: moved.Position.Y, Z: -10}, Direction: math3d.UnitZ}
+		if _, index := acc.Intersect(&lr); index != 10 {
+			t.Errorf("%T should find the moved sphere after refitting, found %d", acc, index)
+		}
+		if counters.Nodes == 0 || counters.Tests == 0 {
+			t.Errorf("%T should keep counting after refitting, counted %+v", acc, counters)
+		}
+		if b := acc.Bounds(); !b.Contains(&moved.Position) {
+			t.Errorf("The bounds of %T should hold the moved sphere", acc)
+		}
+		moved.Translate(&math3d.Vector3{X: -100})
+	}
+	empty := NewKDTree(nil)
+	if _, index := empty.Intersect(&math3d.LightRay{Direction: math3d.UnitZ}); index != -1 || empty.Size() != 0 {
+		t.Error("An empty kd-tree shouldn't find anything")
+	}
+}
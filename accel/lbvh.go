@@ -0,0 +1,157 @@
+package accel
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// mortonBits is the number of bits of the Morton codes along every axis,
+// which interleave them in 30 bits
+const mortonBits = 10
+
+// NewLBVH builds a bounding volume hierarchy over the primitives the fast
+// way of Lauterbach et al.'s "Fast BVH Construction on GPUs": it sorts them
+// by the Morton codes of their centroids, which follow a Z-order curve
+// through their bounds, and splits every node where the highest bit of the
+// codes in it changes. It builds in a few passes over the primitives,
+// much faster than NewBVH and NewSBVH, but its nodes overlap more and
+// lightrays visit more of them, so it suits the geometry that's built
+// again every frame, such as deforming meshes, rather than the static
+// one. Like NewBVH's, the hierarchy can be refitted and encoded.
+func NewLBVH(primitives []Primitive) *BVH {
+	if Float32 && len(primitives) > math.MaxInt32 {
+		panic("A BVH of the float32 build can't hold more than 2^31 primitives")
+	}
+	bvh := &BVH{primitives: primitives}
+	bvh.buildMorton()
+	return bvh
+}
+
+// buildMorton builds the hierarchy again over its primitives as NewLBVH
+// does, reusing its memory
+func (bvh *BVH) buildMorton() {
+	codes := mortonCodes(bvh.primitives)
+	if cap(bvh.indices) < len(bvh.primitives) {
+		bvh.indices = make([]int, len(bvh.primitives))
+	}
+	bvh.indices = bvh.indices[:len(bvh.primitives)]
+	for i := range bvh.indices {
+		bvh.indices[i] = i
+	}
+	radixSort(codes, bvh.indices)
+	if cap(bvh.nodes) < 2*len(bvh.primitives) {
+		bvh.nodes = make([]node, 0, 2*len(bvh.primitives))
+	}
+	bvh.nodes = bvh.nodes[:0]
+	if len(bvh.primitives) > 0 {
+		bvh.buildMortonRange(codes, 0, len(bvh.primitives))
+	}
+}
+
+// buildMortonRange creates the subtree for indices[first:end], whose codes
+// are codes[first:end], and returns the index of its root node and its
+// bounds
+func (bvh *BVH) buildMortonRange(codes []uint32, first, end int) (int, *math3d.AABB) {
+	current := len(bvh.nodes)
+	bvh.nodes = append(bvh.nodes, node{})
+	if end-first <= maxLeafSize {
+		bounds := bvh.rangeBounds(first, end)
+		bvh.nodes[current] = node{bounds: packBounds(bounds), first: nodeIndex(first), count: nodeIndex(end - first)}
+		return current, bounds
+	}
+	middle := mortonSplit(codes, first, end)
+	_, left := bvh.buildMortonRange(codes, first, middle)
+	right, rightBounds := bvh.buildMortonRange(codes, middle, end)
+	bounds := left.Union(rightBounds)
+	bvh.nodes[current].bounds, bvh.nodes[current].right = packBounds(bounds), nodeIndex(right)
+	return current, bounds
+}
+
+// mortonSplit returns where the highest bit that differs between the
+// sorted codes[first:end] changes, or the middle of the range if they're
+// all the same
+func mortonSplit(codes []uint32, first, end int) int {
+	low := codes[first]
+	highest := bits.LeadingZeros32(low ^ codes[end-1])
+	if highest == 32 {
+		return first + (end-first)/2
+	}
+	// The codes are sorted, so the ones with the bit set come after the
+	// ones without it
+	return first + sort.Search(end-first, func(i int) bool {
+		return bits.LeadingZeros32(low^codes[first+i]) <= highest
+	})
+}
+
+// MortonOrder returns the indices of the primitives in the order of the
+// Morton codes of their centroids, so that the primitives near each other
+// are mostly near each other in the order too, such as to lay them out in
+// memory for lightrays that hit nearby primitives one after another
+func MortonOrder(primitives []Primitive) []int {
+	indices := make([]int, len(primitives))
+	for i := range indices {
+		indices[i] = i
+	}
+	radixSort(mortonCodes(primitives), indices)
+	return indices
+}
+
+// mortonCodes returns the Morton codes of the centroids of the primitives
+// within the bounds of the centroids
+func mortonCodes(primitives []Primitive) []uint32 {
+	centroids := make([]math3d.Vector3, len(primitives))
+	bounds := math3d.EmptyAABB()
+	for i, p := range primitives {
+		centroids[i] = *p.Bounds().Centroid()
+		bounds = bounds.Expand(&centroids[i])
+	}
+	extent := bounds.Max.Subtract(&bounds.Min)
+	quantize := func(value, low, extent float64) uint32 {
+		if !(extent > 0) {
+			return 0
+		}
+		return uint32(math.Min(math.Max((value-low)/extent*(1<<mortonBits), 0), 1<<mortonBits-1))
+	}
+	codes := make([]uint32, len(primitives))
+	for i, c := range centroids {
+		x := quantize(c.X, bounds.Min.X, extent.X)
+		y := quantize(c.Y, bounds.Min.Y, extent.Y)
+		z := quantize(c.Z, bounds.Min.Z, extent.Z)
+		codes[i] = spreadBits(x)<<2 | spreadBits(y)<<1 | spreadBits(z)
+	}
+	return codes
+}
+
+// spreadBits spreads the 10 low bits of v out to every third bit
+func spreadBits(v uint32) uint32 {
+	v = (v | v<<16) & 0x030000ff
+	v = (v | v<<8) & 0x0300f00f
+	v = (v | v<<4) & 0x030c30c3
+	return (v | v<<2) & 0x09249249
+}
+
+// radixSort sorts the codes and the indices along with them by the codes,
+// a byte at a time from the lowest one, keeping the order of the equal
+// codes
+func radixSort(codes []uint32, indices []int) {
+	sortedCodes, sortedIndices := make([]uint32, len(codes)), make([]int, len(indices))
+	for shift := uint(0); shift < 3*mortonBits; shift += 8 {
+		var offsets [257]int
+		for _, c := range codes {
+			offsets[(c>>shift)&0xff+1]++
+		}
+		for i := 1; i < len(offsets); i++ {
+			offsets[i] += offsets[i-1]
+		}
+		for i, c := range codes {
+			digit := (c >> shift) & 0xff
+			sortedCodes[offsets[digit]], sortedIndices[offsets[digit]] = c, indices[i]
+			offsets[digit]++
+		}
+		copy(codes, sortedCodes)
+		copy(indices, sortedIndices)
+	}
+}
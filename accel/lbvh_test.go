@@ -0,0 +1,53 @@
+package accel
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestLBVHMatchesBruteForce(t *testing.T) {
+	primitives := randomSpheres(300)
+	lbvh := NewLBVH(primitives)
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 1000; i++ {
+		lr := math3d.LightRay{
+			Source:    math3d.Vector3{X: 0, Y: 0, Z: -10},
+			Direction: *(&math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: 1}).Normalized()}
+		expectedDistance, expected := bruteForce(primitives, &lr)
+		distance, index := lbvh.Intersect(&lr)
+		if index != expected || distance != expectedDistance {
+			t.Fatalf("The LBVH found %d at %.3f but the nearest is %d at %.3f", index, distance, expected, expectedDistance)
+		}
+		if lbvh.Occluded(&lr, math.MaxFloat64) != (expected >= 0) {
+			t.Fatal("Occlusion doesn't match the nearest intersection")
+		}
+	}
+	// The spheres at the same place are split in halves
+	same := make([]Primitive, 9)
+	for i := range same {
+		same[i] = &shape.Sphere{Radius: 1}
+	}
+	if _, index := NewLBVH(same).Intersect(&math3d.LightRay{Source: math3d.Vector3{Z: -5}, Direction: math3d.UnitZ}); index < 0 {
+		t.Error("The LBVH of spheres at the same place should find one of them")
+	}
+}
+
+func TestMortonOrderFollowsAZCurve(t *testing.T) {
+	// The corners of a square in the XY plane, whose Z-order goes up the
+	// left side and then up the right one, X being the highest bit
+	corners := []math3d.Vector3{{X: 1, Y: 1}, {X: 0, Y: 0}, {X: 0, Y: 1}, {X: 1, Y: 0}}
+	primitives := make([]Primitive, len(corners))
+	for i, c := range corners {
+		primitives[i] = &shape.Sphere{Position: c, Radius: 0.1}
+	}
+	order := MortonOrder(primitives)
+	for i, expected := range []int{1, 2, 3, 0} {
+		if order[i] != expected {
+			t.Fatalf("The corners should be in the order 1, 2, 3, 0, not %v", order)
+		}
+	}
+}
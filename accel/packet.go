@@ -0,0 +1,93 @@
+package accel
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/internal/simd"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// PacketSize is the number of lightrays in the packets that are traced
+// together
+const PacketSize = simd.Lanes
+
+// PacketIntersector is an acceleration structure that traces packets of
+// lightrays together. The lightrays of a packet should be coherent, such
+// as the ones through neighbouring pixels, so they visit the same nodes.
+type PacketIntersector interface {
+	// IntersectPacket returns what Intersect returns for every lightray
+	// of the packet. The lanes whose lightrays are nil get
+	// math.MaxFloat64 and -1.
+	IntersectPacket(lrs *[PacketSize]*math3d.LightRay) ([PacketSize]float64, [PacketSize]int)
+}
+
+// IntersectPacket returns what the structure's Intersect returns for every
+// lightray of the packet, tracing them together if it's a
+// PacketIntersector and one after the other otherwise. The lanes whose
+// lightrays are nil get math.MaxFloat64 and -1.
+func IntersectPacket(a Accelerator, lrs *[PacketSize]*math3d.LightRay) ([PacketSize]float64, [PacketSize]int) {
+	if p, ok := a.(PacketIntersector); ok {
+		return p.IntersectPacket(lrs)
+	}
+	var distances [PacketSize]float64
+	var indices [PacketSize]int
+	for i, lr := range lrs {
+		distances[i], indices[i] = math.MaxFloat64, -1
+		if lr != nil {
+			distances[i], indices[i] = a.Intersect(lr)
+		}
+	}
+	return distances, indices
+}
+
+// IntersectPacket returns what Intersect returns for every lightray of the
+// packet. The hierarchy is traversed once for the packet: every node is
+// tested against all the lightrays at once, and the primitives of a leaf
+// only against the ones that enter it closer than their nearest hit so
+// far. Each lightray still visits the nodes in the order Intersect does,
+// so the hits are the same.
+func (bvh *BVH) IntersectPacket(lrs *[PacketSize]*math3d.LightRay) ([PacketSize]float64, [PacketSize]int) {
+	var distances [PacketSize]float64
+	var indices [PacketSize]int
+	var packet simd.RayPacket4
+	active := uint8(0)
+	for i, lr := range lrs {
+		distances[i], indices[i] = math.MaxFloat64, -1
+		if lr != nil {
+			packet.SetRay(i, lr)
+			active |= 1 << uint(i)
+		}
+	}
+	if len(bvh.nodes) == 0 || active == 0 {
+		return distances, indices
+	}
+	var nodes, tests uint64
+	stack := make([]nodeIndex, 1, 64)
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n := &bvh.nodes[current]
+		nodes++
+		lanes := n.bounds.intersectPacket(&packet, &distances) & active
+		if lanes == 0 {
+			continue
+		}
+		if !n.isLeaf() {
+			stack = append(stack, n.right, current+1)
+			continue
+		}
+		for lane, lr := range lrs {
+			if lanes&(1<<uint(lane)) == 0 {
+				continue
+			}
+			for _, i := range bvh.indices[n.first : n.first+n.count] {
+				tests++
+				if d := bvh.intersect(i, lr); d < distances[lane] {
+					distances[lane], indices[lane] = d, i
+				}
+			}
+		}
+	}
+	bvh.counters.add(nodes, tests)
+	return distances, indices
+}
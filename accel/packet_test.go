@@ -0,0 +1,68 @@
+package accel
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// cameraPacket returns the lightrays through the 2 x 2 pixels at x, y of a
+// size x size image of a camera looking at the spheres of randomSpheres
+func cameraPacket(x, y, size int) [PacketSize]*math3d.LightRay {
+	var lrs [PacketSize]*math3d.LightRay
+	for i := range lrs {
+		u := (float64(x+i%2)+0.5)/float64(size) - 0.5
+		v := (float64(y+i/2)+0.5)/float64(size) - 0.5
+		lrs[i] = &math3d.LightRay{Source: math3d.Vector3{Z: -10}, Direction: *(&math3d.Vector3{X: u, Y: v, Z: 1}).Normalized()}
+	}
+	return lrs
+}
+
+func TestIntersectPacketMatchesIntersect(t *testing.T) {
+	bvh := NewBVH(randomSpheres(500))
+	kd := NewKDTree(randomSpheres(500))
+	for y := 0; y < 32; y += 2 {
+		for x := 0; x < 32; x += 2 {
+			lrs := cameraPacket(x, y, 32)
+			// Packets may be partly empty
+			if x == y {
+				lrs[1] = nil
+			}
+			for _, a := range []Accelerator{bvh, kd} {
+				distances, indices := IntersectPacket(a, &lrs)
+				for i, lr := range lrs {
+					expectedDistance, expected := math.MaxFloat64, -1
+					if lr != nil {
+						expectedDistance, expected = a.Intersect(lr)
+					}
+					if distances[i] != expectedDistance || indices[i] != expected {
+						t.Fatalf("The lane %d of the packet at %d, %d hit %d at %v, not %d at %v", i, x, y, indices[i], distances[i], expected, expectedDistance)
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkIntersectPacket(b *testing.B) {
+	bvh := NewBVH(randomSpheres(10000))
+	packets := make([][PacketSize]*math3d.LightRay, 0, 32*32)
+	for y := 0; y < 64; y += 2 {
+		for x := 0; x < 64; x += 2 {
+			packets = append(packets, cameraPacket(x, y, 64))
+		}
+	}
+	b.Run("packet", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			bvh.IntersectPacket(&packets[n%len(packets)])
+		}
+	})
+	b.Run("rays", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, lr := range packets[n%len(packets)] {
+				bvh.Intersect(lr)
+			}
+		}
+	})
+}
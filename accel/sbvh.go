@@ -0,0 +1,344 @@
+package accel
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Costs of the surface area heuristic that builds the BVHs with spatial
+// splits, relative to each other
+const (
+	sbvhTraversalCost    = 1
+	sbvhIntersectionCost = 2
+	// sbvhBins is the number of bins the candidate splits of a node are
+	// taken between, along every axis
+	sbvhBins = 16
+	// sbvhMaxLeafSize is the number of references above which a node is
+	// split even if the heuristic finds no split cheaper than a leaf
+	sbvhMaxLeafSize = 8
+	// sbvhMaxDepth is the depth below which nodes aren't split
+	sbvhMaxDepth = 64
+	// sbvhMinOverlap is the area, relative to the one of the root, that
+	// the children of the best object split of a node must overlap by for
+	// spatial splits of it to be tried, which keeps them to where they pay
+	sbvhMinOverlap = 1e-5
+)
+
+// Clipped defines the primitives whose bounds within a box can be much
+// smaller than the box and their bounds, such as long thin triangles
+// crossing it diagonally. The spatial splits of NewSBVH clip them to the
+// sides of the splits, where the other primitives are bounded by their
+// bounds within the sides.
+type Clipped interface {
+	// ClippedBounds returns the bounds of the part of the primitive inside
+	// the box, which are empty if the primitive misses it
+	ClippedBounds(box *math3d.AABB) *math3d.AABB
+}
+
+// sbvhReference is a primitive in a node of a BVH with spatial splits,
+// bounded by the part of it inside the node
+type sbvhReference struct {
+	index  int
+	bounds math3d.AABB
+}
+
+// sbvhSplit is a candidate split of a node, along axis at the boundary
+// below bin of the bins spread over extent from low. Object splits split
+// the references by their centroids, and spatial splits split space,
+// clipping the references across it to both sides.
+type sbvhSplit struct {
+	cost        float64
+	axis, bin   int
+	low, extent float64
+	spatial     bool
+	left, right math3d.AABB
+}
+
+// sbvhBin is a bin of the candidate splits of a node: the bounds of the
+// references in it, and the number of them that start and end in it
+type sbvhBin struct {
+	bounds         math3d.AABB
+	entries, exits int
+}
+
+// sbvhBuilder builds a BVH with spatial splits
+type sbvhBuilder struct {
+	bvh *BVH
+	// rootArea is the surface area of the bounds of all the primitives
+	rootArea float64
+	// spare is how many more references spatial splits may add
+	spare int
+}
+
+// NewSBVH builds a bounding volume hierarchy over the primitives with the
+// surface area heuristic, splitting space as well as the primitives, as in
+// Stich, Friedrich and Dietrich's "Spatial Splits in Bounding Volume
+// Hierarchies". Where the children of a node would overlap a lot, as they
+// do over the large, poorly shaped triangles of architectural scenes, a
+// spatial split puts the primitives across it in both children, bounded by
+// their parts on either side, which the primitives that implement Clipped
+// keep tight. budget is how many more references than primitives the
+// splits may add, relative to their number: 0 makes a BVH without spatial
+// splits, and 0.3 lets them add up to about 30% more. The hierarchy
+// builds much slower than NewBVH's, and a primitive may be tested against
+// a lightray twice, but lightrays visit far fewer nodes and primitives.
+// Refit bounds the leaves by the whole primitives in them, which is right
+// but looser than the clipped bounds, and the hierarchy can't be encoded.
+func NewSBVH(primitives []Primitive, budget float64) *BVH {
+	if Float32 && float64(len(primitives))*(1+budget) > math.MaxInt32 {
+		panic("A BVH of the float32 build can't hold more than 2^31 references")
+	}
+	bvh := &BVH{primitives: primitives, spatial: true}
+	if len(primitives) == 0 {
+		return bvh
+	}
+	references := make([]sbvhReference, len(primitives))
+	bounds := math3d.EmptyAABB()
+	for i, p := range primitives {
+		references[i] = sbvhReference{index: i, bounds: *p.Bounds()}
+		bounds = bounds.Union(&references[i].bounds)
+	}
+	b := &sbvhBuilder{bvh: bvh, rootArea: bounds.SurfaceArea(), spare: int(budget * float64(len(primitives)))}
+	bvh.indices = make([]int, 0, len(primitives)+b.spare)
+	bvh.nodes = make([]node, 0, 2*len(primitives))
+	b.build(references, 0)
+	return bvh
+}
+
+// Spatial returns whether the hierarchy was built by NewSBVH
+func (bvh *BVH) Spatial() bool {
+	return bvh.spatial
+}
+
+// build creates the subtree for the references and returns the index of
+// its root node
+func (b *sbvhBuilder) build(references []sbvhReference, depth int) int {
+	bounds := math3d.EmptyAABB()
+	for i := range references {
+		bounds = bounds.Union(&references[i].bounds)
+	}
+	current := len(b.bvh.nodes)
+	b.bvh.nodes = append(b.bvh.nodes, node{bounds: packBounds(bounds)})
+	if len(references) == 1 || depth == sbvhMaxDepth {
+		return b.leaf(current, references)
+	}
+
+	split := b.objectSplit(references, bounds)
+	if !math.IsInf(split.cost, 1) && b.spare > 0 {
+		overlap := intersection(&split.left, &split.right)
+		if overlap.SurfaceArea() > sbvhMinOverlap*b.rootArea {
+			if spatial := b.spatialSplit(references, bounds); spatial.cost < split.cost {
+				split = spatial
+			}
+		}
+	}
+	if split.cost >= sbvhIntersectionCost*float64(len(references)) && len(references) <= sbvhMaxLeafSize {
+		return b.leaf(current, references)
+	}
+	var left, right []sbvhReference
+	switch {
+	case math.IsInf(split.cost, 1):
+	case split.spatial:
+		left, right = b.splitSpace(references, &split)
+	default:
+		left, right = splitObjects(references, &split)
+	}
+	if len(left) == 0 || len(right) == 0 {
+		// The centroids are all at the same place, so the references are
+		// split in halves
+		left, right = references[:len(references)/2], references[len(references)/2:]
+	}
+	b.build(left, depth+1)
+	rightNode := b.build(right, depth+1)
+	b.bvh.nodes[current].right = nodeIndex(rightNode)
+	return current
+}
+
+// leaf makes the node a leaf of the references and returns its index
+func (b *sbvhBuilder) leaf(current int, references []sbvhReference) int {
+	n := &b.bvh.nodes[current]
+	n.first, n.count = nodeIndex(len(b.bvh.indices)), nodeIndex(len(references))
+	for i := range references {
+		b.bvh.indices = append(b.bvh.indices, references[i].index)
+	}
+	return current
+}
+
+// objectSplit returns the cheapest split of the references by their
+// centroids between bins spread over the bounds of the centroids. Its cost
+// is infinite if the centroids are all at the same place.
+func (b *sbvhBuilder) objectSplit(references []sbvhReference, bounds *math3d.AABB) sbvhSplit {
+	centroids := math3d.EmptyAABB()
+	for i := range references {
+		centroids = centroids.Expand(references[i].bounds.Centroid())
+	}
+	best := sbvhSplit{cost: math.Inf(1)}
+	for axis := 0; axis < 3; axis++ {
+		low, extent := component(&centroids.Min, axis), component(&centroids.Max, axis)-component(&centroids.Min, axis)
+		if !(extent > 0) {
+			continue
+		}
+		var bins [sbvhBins]sbvhBin
+		for i := range bins {
+			bins[i].bounds = *math3d.EmptyAABB()
+		}
+		for i := range references {
+			r := &references[i]
+			bin := &bins[binOf(component(r.bounds.Centroid(), axis), low, extent)]
+			bin.bounds = *bin.bounds.Union(&r.bounds)
+			bin.entries++
+			bin.exits++
+		}
+		sweep(&bins, bounds, axis, low, extent, false, &best)
+	}
+	return best
+}
+
+// spatialSplit returns the cheapest split of space between bins spread
+// over the bounds, with the references across the boundary clipped to
+// both sides
+func (b *sbvhBuilder) spatialSplit(references []sbvhReference, bounds *math3d.AABB) sbvhSplit {
+	best := sbvhSplit{cost: math.Inf(1), spatial: true}
+	for axis := 0; axis < 3; axis++ {
+		low, extent := component(&bounds.Min, axis), component(&bounds.Max, axis)-component(&bounds.Min, axis)
+		if !(extent > 0) {
+			continue
+		}
+		var bins [sbvhBins]sbvhBin
+		for i := range bins {
+			bins[i].bounds = *math3d.EmptyAABB()
+		}
+		for i := range references {
+			r := &references[i]
+			first := binOf(component(&r.bounds.Min, axis), low, extent)
+			last := binOf(component(&r.bounds.Max, axis), low, extent)
+			for bin := first; bin <= last; bin++ {
+				slab := r.bounds
+				setComponent(&slab.Min, axis, math.Max(component(&slab.Min, axis), low+extent*float64(bin)/sbvhBins))
+				setComponent(&slab.Max, axis, math.Min(component(&slab.Max, axis), low+extent*float64(bin+1)/sbvhBins))
+				bins[bin].bounds = *bins[bin].bounds.Union(b.clip(r, &slab))
+			}
+			bins[first].entries++
+			bins[last].exits++
+		}
+		sweep(&bins, bounds, axis, low, extent, true, &best)
+	}
+	return best
+}
+
+// sweep sets best to the cheapest split between the bins along the axis,
+// if it's cheaper. The references enter the bins they start in and end in
+// the bins they end in, which are the same ones for object splits.
+func sweep(bins *[sbvhBins]sbvhBin, bounds *math3d.AABB, axis int, low, extent float64, spatial bool, best *sbvhSplit) {
+	// rightBounds[i] and rightCounts[i] are the bounds and the number of
+	// the references right of the boundary below the bin i
+	var rightBounds [sbvhBins]math3d.AABB
+	var rightCounts [sbvhBins]int
+	union, count := math3d.EmptyAABB(), 0
+	for i := len(bins) - 1; i > 0; i-- {
+		union = union.Union(&bins[i].bounds)
+		count += bins[i].exits
+		rightBounds[i], rightCounts[i] = *union, count
+	}
+	area := bounds.SurfaceArea()
+	union, count = math3d.EmptyAABB(), 0
+	for i := 1; i < len(bins); i++ {
+		union = union.Union(&bins[i-1].bounds)
+		count += bins[i-1].entries
+		if count == 0 || rightCounts[i] == 0 {
+			continue
+		}
+		cost := sbvhTraversalCost + sbvhIntersectionCost*(union.SurfaceArea()*float64(count)+rightBounds[i].SurfaceArea()*float64(rightCounts[i]))/area
+		if cost < best.cost {
+			*best = sbvhSplit{cost: cost, axis: axis, bin: i, low: low, extent: extent, spatial: spatial, left: *union, right: rightBounds[i]}
+		}
+	}
+}
+
+// splitObjects splits the references in place by whether their centroids
+// are in the bins of the split below its boundary or in the others
+func splitObjects(references []sbvhReference, split *sbvhSplit) ([]sbvhReference, []sbvhReference) {
+	middle := 0
+	for i := range references {
+		if binOf(component(references[i].bounds.Centroid(), split.axis), split.low, split.extent) < split.bin {
+			references[i], references[middle] = references[middle], references[i]
+			middle++
+		}
+	}
+	return references[:middle], references[middle:]
+}
+
+// splitSpace splits space at the boundary of the split, putting the
+// references across it on both sides, clipped to them, and spending the
+// spare references that adds
+func (b *sbvhBuilder) splitSpace(references []sbvhReference, split *sbvhSplit) ([]sbvhReference, []sbvhReference) {
+	axis := split.axis
+	plane := split.low + split.extent*float64(split.bin)/sbvhBins
+	var left, right []sbvhReference
+	for i := range references {
+		r := &references[i]
+		switch {
+		case component(&r.bounds.Max, axis) <= plane:
+			left = append(left, *r)
+		case component(&r.bounds.Min, axis) >= plane:
+			right = append(right, *r)
+		default:
+			below, above := r.bounds, r.bounds
+			setComponent(&below.Max, axis, plane)
+			setComponent(&above.Min, axis, plane)
+			below, above = *b.clip(r, &below), *b.clip(r, &above)
+			// The parts that clipping leaves empty are left out, since the
+			// primitive doesn't reach that side
+			sides := 0
+			if below.Min.LesserOrEqual(&below.Max) {
+				left = append(left, sbvhReference{index: r.index, bounds: below})
+				sides++
+			}
+			if above.Min.LesserOrEqual(&above.Max) {
+				right = append(right, sbvhReference{index: r.index, bounds: above})
+				sides++
+			}
+			if sides == 0 {
+				// Rounding clipped away all of the primitive, which must
+				// still be somewhere
+				left = append(left, *r)
+			}
+			b.spare -= sides - 1
+		}
+	}
+	return left, right
+}
+
+// clip returns the bounds of the part of the primitive of the reference
+// inside the box, which is within its bounds
+func (b *sbvhBuilder) clip(r *sbvhReference, box *math3d.AABB) *math3d.AABB {
+	if c, ok := b.bvh.primitives[r.index].(Clipped); ok {
+		return intersection(c.ClippedBounds(box), box)
+	}
+	return intersection(&r.bounds, box)
+}
+
+// binOf returns the bin of the value among sbvhBins spread evenly over
+// extent from low
+func binOf(value, low, extent float64) int {
+	bin := int(sbvhBins * (value - low) / extent)
+	if bin < 0 {
+		return 0
+	} else if bin >= sbvhBins {
+		return sbvhBins - 1
+	}
+	return bin
+}
+
+// intersection returns the box that both boxes hold, which is empty if
+// they don't overlap
+func intersection(a, b *math3d.AABB) *math3d.AABB {
+	box := &math3d.AABB{
+		Min: math3d.Vector3{X: math.Max(a.Min.X, b.Min.X), Y: math.Max(a.Min.Y, b.Min.Y), Z: math.Max(a.Min.Z, b.Min.Z)},
+		Max: math3d.Vector3{X: math.Min(a.Max.X, b.Max.X), Y: math.Min(a.Max.Y, b.Max.Y), Z: math.Min(a.Max.Z, b.Max.Z)}}
+	if !box.Min.LesserOrEqual(&box.Max) {
+		return math3d.EmptyAABB()
+	}
+	return box
+}
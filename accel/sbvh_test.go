@@ -0,0 +1,76 @@
+package accel
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// randomSlivers returns long thin triangles across the box from -5 to 5,
+// as the walls and floors of architectural scenes are, whose bounds are
+// mostly empty
+func randomSlivers(n int) []Primitive {
+	r := rand.New(rand.NewSource(5))
+	point := func() math3d.Vector3 {
+		return math3d.Vector3{X: r.Float64()*10 - 5, Y: r.Float64()*10 - 5, Z: r.Float64()*10 - 5}
+	}
+	primitives := make([]Primitive, 0, n)
+	for i := 0; i < n; i++ {
+		a, b := point(), point()
+		c := b.AddV(math3d.Vector3{X: r.Float64() * 0.1, Y: r.Float64() * 0.1, Z: r.Float64() * 0.1})
+		primitives = append(primitives, &shape.Triangle{Vertices: [3]math3d.Vector3{a, b, c}})
+	}
+	return primitives
+}
+
+func TestSBVHMatchesBruteForce(t *testing.T) {
+	primitives := randomSlivers(300)
+	sbvh := NewSBVH(primitives, 0.5)
+	if len(sbvh.indices) <= len(primitives) || len(sbvh.indices) > len(primitives)*3/2+1 {
+		t.Errorf("The spatial splits should add references within the budget, there are %d for %d primitives", len(sbvh.indices), len(primitives))
+	}
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 2000; i++ {
+		lr := math3d.LightRay{
+			Source:    math3d.Vector3{X: 0, Y: 0, Z: -10},
+			Direction: *(&math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: 1}).Normalized()}
+		expectedDistance, expected := bruteForce(primitives, &lr)
+		distance, index := sbvh.Intersect(&lr)
+		if index != expected || distance != expectedDistance {
+			t.Fatalf("The SBVH found %d at %.3f but the nearest is %d at %.3f", index, distance, expected, expectedDistance)
+		}
+		if sbvh.Occluded(&lr, math.MaxFloat64) != (expected >= 0) {
+			t.Fatal("Occlusion doesn't match the nearest intersection")
+		}
+	}
+	if _, err := sbvh.MarshalBinary(); err == nil {
+		t.Error("A BVH with spatial splits shouldn't be encoded")
+	}
+	if unsplit := NewSBVH(primitives, 0); len(unsplit.indices) != len(primitives) {
+		t.Errorf("Without a budget no primitive should be referenced twice, there are %d references", len(unsplit.indices))
+	}
+}
+
+func TestSpatialSplitsTestFewerPrimitives(t *testing.T) {
+	primitives := randomSlivers(2000)
+	tests := func(a Accelerator) uint64 {
+		var c Counters
+		a.Count(&c)
+		r := rand.New(rand.NewSource(9))
+		for i := 0; i < 1000; i++ {
+			lr := math3d.LightRay{
+				Source:    math3d.Vector3{X: 0, Y: 0, Z: -10},
+				Direction: *(&math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: 1}).Normalized()}
+			a.Intersect(&lr)
+		}
+		return c.Tests
+	}
+	bvh, unsplit, split := tests(NewBVH(primitives)), tests(NewSBVH(primitives, 0)), tests(NewSBVH(primitives, 1))
+	if unsplit >= bvh || split >= unsplit*3/4 {
+		t.Errorf("Over thin triangles the SAH should test fewer primitives than the median split, and spatial splits far fewer, "+
+			"but the BVH tested %d, the SBVH without spatial splits %d and the one with them %d", bvh, unsplit, split)
+	}
+}
@@ -0,0 +1,261 @@
+package accel
+
+import (
+	"math"
+	"unsafe"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Instance is a group of primitives placed in the world by a rigid
+// transform: the primitives are rotated by Rotation around the origin of
+// their own space and then moved by Position. A zero Rotation doesn't
+// rotate.
+type Instance struct {
+	Primitives []Primitive
+	Position   math3d.Vector3
+	Rotation   math3d.Quaternion
+	// Indices are the indices the queries return for the primitives. If
+	// it's nil, the primitives of all the instances are numbered one after
+	// the other in the order of the instances.
+	Indices []int
+	// Fast builds the BVH of the instance with NewLBVH, and builds it
+	// again rather than refitting it when its primitives move, which suits
+	// the instances whose primitives change every frame
+	Fast bool
+}
+
+// TwoLevel is a BVH over instances, each with a BVH of its own over its
+// primitives in its own space. Changing the transform of an instance or
+// moving the primitives of a single one only rebuilds the top level,
+// which is small when the instances are few, so scenes where a few
+// instances move every frame and the rest of the geometry stays still are
+// updated quickly.
+type TwoLevel struct {
+	instances []*instance
+	top       *BVH
+	// owners holds the instance of every primitive, by its index
+	owners   map[int]int
+	size     int
+	counters *Counters
+}
+
+// instance is an instance of a two level structure, which is the
+// primitive of its top level
+type instance struct {
+	bvh     *BVH
+	indices []int
+	// fast is whether the BVH is built with NewLBVH
+	fast bool
+	// position and rotation place the instance in the world, and inverse
+	// undoes the rotation
+	position          math3d.Vector3
+	rotation, inverse math3d.Quaternion
+	// bounds are the bounds of the primitives in the world
+	bounds math3d.AABB
+}
+
+// NewTwoLevel builds a two level acceleration structure over the
+// instances. Refit must be called if their primitives change their bounds
+// afterwards, or Moved if only the ones of an instance do.
+func NewTwoLevel(instances []Instance) *TwoLevel {
+	t := &TwoLevel{owners: make(map[int]int)}
+	for i := range instances {
+		in := &instances[i]
+		indices := in.Indices
+		if indices == nil {
+			indices = make([]int, len(in.Primitives))
+			for j := range indices {
+				indices[j] = t.size + j
+			}
+		}
+		for _, index := range indices {
+			t.owners[index] = i
+		}
+		t.size += len(in.Primitives)
+		built := &instance{indices: indices, fast: in.Fast}
+		if in.Fast {
+			built.bvh = NewLBVH(in.Primitives)
+		} else {
+			built.bvh = NewBVH(in.Primitives)
+		}
+		built.place(in.Position, in.Rotation)
+		t.instances = append(t.instances, built)
+	}
+	t.buildTop()
+	return t
+}
+
+// place sets the transform of the instance and its bounds in the world
+func (in *instance) place(position math3d.Vector3, rotation math3d.Quaternion) {
+	if rotation == (math3d.Quaternion{}) {
+		rotation = math3d.IdentityQuaternion
+	}
+	in.position, in.rotation, in.inverse = position, rotation, rotation.Conjugate()
+	in.bounds = *math3d.EmptyAABB()
+	if in.bvh.Size() == 0 {
+		return
+	}
+	// The world bounds hold the 8 corners of the local bounds, moved
+	local := in.bvh.Bounds()
+	for _, x := range []float64{local.Min.X, local.Max.X} {
+		for _, y := range []float64{local.Min.Y, local.Max.Y} {
+			for _, z := range []float64{local.Min.Z, local.Max.Z} {
+				corner := in.toWorld(math3d.Vector3{X: x, Y: y, Z: z})
+				in.bounds = *in.bounds.Expand(&corner)
+			}
+		}
+	}
+}
+
+func (in *instance) toWorld(point math3d.Vector3) math3d.Vector3 {
+	return in.rotation.Rotate(point).AddV(in.position)
+}
+
+// toLocal returns the lightray in the space of the primitives of the
+// instance. Rigid transforms keep lengths, so distances along it are the
+// same as along lr.
+func (in *instance) toLocal(lr *math3d.LightRay) *math3d.LightRay {
+	return &math3d.LightRay{
+		Source:    in.inverse.Rotate(lr.Source.SubtractV(in.position)),
+		Direction: in.inverse.Rotate(lr.Direction),
+		Origin:    lr.Origin}
+}
+
+// Intersect returns the distance to the nearest primitive of the instance
+// the lightray intersects
+func (in *instance) Intersect(lr *math3d.LightRay) float64 {
+	d, _ := in.bvh.Intersect(in.toLocal(lr))
+	return d
+}
+
+// update updates the BVH of the instance and its bounds after its
+// primitives moved, building the BVH again if the instance is fast
+func (in *instance) update() {
+	if in.fast {
+		in.bvh.buildMorton()
+	} else {
+		in.bvh.Refit()
+	}
+	in.place(in.position, in.rotation)
+}
+
+// Bounds returns the bounds of the instance in the world
+func (in *instance) Bounds() *math3d.AABB {
+	return &in.bounds
+}
+
+// buildTop builds the top level over the current bounds of the instances
+func (t *TwoLevel) buildTop() {
+	primitives := make([]Primitive, len(t.instances))
+	for i, in := range t.instances {
+		primitives[i] = in
+	}
+	t.top = NewBVH(primitives)
+	t.top.Count(t.counters)
+}
+
+// Instances returns the number of instances
+func (t *TwoLevel) Instances() int {
+	return len(t.instances)
+}
+
+// Place changes the transform of the instance at index
+func (t *TwoLevel) Place(index int, position math3d.Vector3, rotation math3d.Quaternion) {
+	t.instances[index].place(position, rotation)
+	t.buildTop()
+}
+
+// Moved updates the structure after the primitive with the index changed
+// its bounds, refitting the BVH of its instance alone, or building it
+// again if the instance is fast
+func (t *TwoLevel) Moved(primitive int) {
+	t.instances[t.owners[primitive]].update()
+	t.buildTop()
+}
+
+// Refit updates the structure after the primitives of any instance moved
+func (t *TwoLevel) Refit() {
+	for _, in := range t.instances {
+		in.update()
+	}
+	t.buildTop()
+}
+
+// Size returns the number of primitives in all the instances
+func (t *TwoLevel) Size() int {
+	return t.size
+}
+
+// Memory returns the number of bytes that the hierarchies of both levels
+// and the indices of the primitives take, without the primitives. The
+// owners of the primitives are counted as two words each, which is about
+// what a map takes for an entry.
+func (t *TwoLevel) Memory() uint64 {
+	bytes := t.top.Memory() + uint64(len(t.owners))*2*uint64(unsafe.Sizeof(0))
+	for _, in := range t.instances {
+		bytes += in.bvh.Memory() + uint64(len(in.indices))*uint64(unsafe.Sizeof(0)) + uint64(unsafe.Sizeof(*in))
+	}
+	return bytes
+}
+
+// Bounds returns the bounding box of all the instances in the world
+func (t *TwoLevel) Bounds() *math3d.AABB {
+	return t.top.Bounds()
+}
+
+// Count makes the queries add the work they do at both levels to c, or
+// stop counting it if c is nil. The instances count as primitives of the
+// top level.
+func (t *TwoLevel) Count(c *Counters) {
+	t.counters = c
+	t.top.Count(c)
+	for _, in := range t.instances {
+		in.bvh.Count(c)
+	}
+}
+
+// Filter makes the queries call f for every hit, with the indices of the
+// primitives and the lightrays in the world, or stop filtering them if f
+// is nil. It must not be called while the structure is queried.
+func (t *TwoLevel) Filter(f Filter) {
+	for _, in := range t.instances {
+		if f == nil {
+			in.bvh.Filter(nil)
+			continue
+		}
+		in := in
+		in.bvh.Filter(func(index int, lr *math3d.LightRay, distance float64) bool {
+			world := *lr
+			world.Source, world.Direction = in.toWorld(lr.Source), in.rotation.Rotate(lr.Direction)
+			return f(in.indices[index], &world, distance)
+		})
+	}
+}
+
+// Intersect returns the distance to the nearest primitive the lightray
+// intersects and its index. If it doesn't intersect any, it returns
+// math.MaxFloat64 and -1.
+func (t *TwoLevel) Intersect(lr *math3d.LightRay) (float64, int) {
+	nearestDistance, nearest := math.MaxFloat64, -1
+	t.top.traverse(lr, func(i int) bool {
+		in := t.instances[i]
+		if d, j := in.bvh.Intersect(in.toLocal(lr)); d < nearestDistance {
+			nearestDistance, nearest = d, in.indices[j]
+		}
+		return false
+	}, func() float64 { return nearestDistance })
+	return nearestDistance, nearest
+}
+
+// Occluded returns true if the lightray intersects any primitive at a
+// distance that is smaller than distance
+func (t *TwoLevel) Occluded(lr *math3d.LightRay, distance float64) bool {
+	occluded := false
+	t.top.traverse(lr, func(i int) bool {
+		in := t.instances[i]
+		occluded = in.bvh.Occluded(in.toLocal(lr), distance)
+		return occluded
+	}, func() float64 { return distance })
+	return occluded
+}
@@ -0,0 +1,120 @@
+package accel
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// placed returns the spheres of the instance where its transform puts
+// them in the world
+func placed(in *Instance) []Primitive {
+	world := make([]Primitive, 0, len(in.Primitives))
+	for _, p := range in.Primitives {
+		s := p.(*shape.Sphere)
+		world = append(world, &shape.Sphere{Position: in.Rotation.Rotate(s.Position).AddV(in.Position), Radius: s.Radius})
+	}
+	return world
+}
+
+func TestTwoLevelMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	instances := make([]Instance, 0, 4)
+	var world []Primitive
+	for i := 0; i < 4; i++ {
+		in := Instance{
+			Primitives: randomSpheres(50),
+			Position:   math3d.Vector3{X: float64(i)*6 - 9, Z: 5},
+			Rotation:   math3d.AxisAngle(math3d.Vector3{X: r.Float64(), Y: r.Float64(), Z: r.Float64()}, r.Float64()*math.Pi)}
+		instances = append(instances, in)
+		world = append(world, placed(&in)...)
+	}
+	structure := NewTwoLevel(instances)
+	if structure.Size() != 200 || structure.Instances() != 4 {
+		t.Fatalf("The structure should hold 200 spheres in 4 instances, it holds %d in %d", structure.Size(), structure.Instances())
+	}
+	for _, p := range world {
+		if !structure.Bounds().Contains(p.Bounds().Centroid()) {
+			t.Fatal("The world bounds should hold every rotated and moved sphere")
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		lr := math3d.LightRay{
+			Source:    math3d.Vector3{Z: -20},
+			Direction: *(&math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: 1}).Normalized()}
+		expectedDistance, expected := bruteForce(world, &lr)
+		distance, index := structure.Intersect(&lr)
+		if index != expected || math.Abs(distance-expectedDistance) > 1e-9 {
+			t.Fatalf("Ray %d: the two level structure found %d at %.3f but the nearest is %d at %.3f", i, index, distance, expected, expectedDistance)
+		}
+		if structure.Occluded(&lr, math.MaxFloat64) != (expected >= 0) {
+			t.Fatal("Occlusion doesn't match the nearest intersection")
+		}
+	}
+}
+
+func TestTwoLevelPlaceAndMove(t *testing.T) {
+	still := Instance{Primitives: randomSpheres(100)}
+	ball := &shape.Sphere{Radius: 1}
+	moving := Instance{Primitives: []Primitive{ball}, Indices: []int{7}}
+	structure := NewTwoLevel([]Instance{still, moving})
+	down := func(x float64) int {
+		_, index := structure.Intersect(&math3d.LightRay{Source: math3d.Vector3{X: x, Y: 50}, Direction: math3d.Vector3{Y: -1}})
+		return index
+	}
+
+	structure.Place(1, math3d.Vector3{X: 40}, math3d.IdentityQuaternion)
+	if index := down(40); index != 7 {
+		t.Errorf("The placed instance should be found with its own index 7, found %d", index)
+	}
+	ball.Translate(&math3d.Vector3{X: 10})
+	structure.Moved(7)
+	if index := down(50); index != 7 {
+		t.Errorf("The structure should find the ball where it moved within its instance, found %d", index)
+	}
+	if index := down(40); index != -1 {
+		t.Errorf("Nothing should be left where the ball was, found %d", index)
+	}
+}
+
+func TestFastInstancesAreBuiltAgainWhenMoved(t *testing.T) {
+	spheres := randomSpheres(100)
+	fast := NewTwoLevel([]Instance{{Primitives: spheres, Fast: true}})
+	refitted := NewTwoLevel([]Instance{{Primitives: spheres}})
+	var hits uint64
+	fast.Filter(func(int, *math3d.LightRay, float64) bool {
+		atomic.AddUint64(&hits, 1)
+		return true
+	})
+	// Half the spheres swap places with the other half, which a refit
+	// would bound with nodes spanning the whole instance
+	for i := 0; i < 50; i++ {
+		a, b := spheres[i].(*shape.Sphere), spheres[i+50].(*shape.Sphere)
+		a.Position, b.Position = b.Position, a.Position
+	}
+	fast.Moved(0)
+	refitted.Moved(0)
+	var fastWork, refittedWork Counters
+	fast.Count(&fastWork)
+	refitted.Count(&refittedWork)
+	for i, p := range spheres {
+		s := p.(*shape.Sphere)
+		lr := math3d.LightRay{Source: math3d.Vector3{X: s.Position.X, Y: s.Position.Y, Z: -20}, Direction: math3d.UnitZ}
+		if _, expected := bruteForce(spheres, &lr); expected >= 0 {
+			if _, index := fast.Intersect(&lr); index != expected {
+				t.Fatalf("The sphere %d: the fast instance should find %d where it moved, found %d", i, expected, index)
+			}
+			refitted.Intersect(&lr)
+		}
+	}
+	if 2*fastWork.Tests >= refittedWork.Tests {
+		t.Errorf("The fast instance built again should test far fewer spheres than the refitted one, it tested %d and the refitted one %d", fastWork.Tests, refittedWork.Tests)
+	}
+	if hits == 0 {
+		t.Error("The filter should still be called once the instance is built again")
+	}
+}
@@ -0,0 +1,293 @@
+// Package animation keyframes the camera, the shapes and the nodes of the
+// scene graph of a scene, to render the scene as a sequence of frames.
+package animation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+const (
+	// Linear interpolation goes straight from a keyframe to the next one
+	Linear = "linear"
+	// Cubic interpolation goes through the keyframes along a smooth curve,
+	// a Catmull-Rom spline for the positions and Squad for the rotations
+	Cubic = "cubic"
+)
+
+// Keyframe is the pose at a time, in seconds. The rotation is Angle
+// radians around Axis.
+type Keyframe struct {
+	Time     float64        `json:"time"`
+	Position math3d.Vector3 `json:"position"`
+	Axis     math3d.Vector3 `json:"axis"`
+	Angle    float64        `json:"angle"`
+}
+
+// Rotation returns the rotation of the keyframe
+func (k *Keyframe) Rotation() math3d.Quaternion {
+	return math3d.AxisAngle(k.Axis, k.Angle)
+}
+
+// Track is the keyframes of something that moves, sorted by time
+type Track struct {
+	// Interpolation is how the poses between keyframes are found, linear
+	// or cubic. It's linear if empty.
+	Interpolation string     `json:"interpolation,omitempty"`
+	Keys          []Keyframe `json:"keys"`
+}
+
+// At returns the pose of the track at time. It's the first keyframe
+// before it and the last one after it.
+func (t *Track) At(time float64) (math3d.Vector3, math3d.Quaternion) {
+	last := len(t.Keys) - 1
+	next := sort.Search(len(t.Keys), func(i int) bool { return t.Keys[i].Time > time })
+	if next == 0 {
+		return t.Keys[0].Position, t.Keys[0].Rotation()
+	}
+	if next > last {
+		return t.Keys[last].Position, t.Keys[last].Rotation()
+	}
+	k1, k2 := &t.Keys[next-1], &t.Keys[next]
+	u := (time - k1.Time) / (k2.Time - k1.Time)
+	if t.Interpolation != Cubic {
+		return math3d.Lerp(k1.Position, k2.Position, u), math3d.SlerpQuaternion(k1.Rotation(), k2.Rotation(), u)
+	}
+	// The keyframes past the ends are taken as the ends themselves
+	k0, k3 := &t.Keys[maxInt(next-2, 0)], &t.Keys[minInt(next+1, last)]
+	position := catmullRom(k0.Position, k1.Position, k2.Position, k3.Position, u)
+	return position, math3d.Squad(k0.Rotation(), k1.Rotation(), k2.Rotation(), k3.Rotation(), u)
+}
+
+// validate returns an error if the track has no keyframes, they aren't
+// sorted or the interpolation is unknown
+func (t *Track) validate() error {
+	if len(t.Keys) == 0 {
+		return fmt.Errorf("it has no keyframes")
+	}
+	for i := 1; i < len(t.Keys); i++ {
+		if t.Keys[i].Time <= t.Keys[i-1].Time {
+			return fmt.Errorf("the keyframes must be sorted by time")
+		}
+	}
+	if t.Interpolation != "" && t.Interpolation != Linear && t.Interpolation != Cubic {
+		return fmt.Errorf("the interpolation must be linear or cubic")
+	}
+	return nil
+}
+
+// catmullRom returns the point at u of the uniform Catmull-Rom spline
+// from p1 to p2, whose tangents come from p0 and p3
+func catmullRom(p0, p1, p2, p3 math3d.Vector3, u float64) math3d.Vector3 {
+	u2, u3 := u*u, u*u*u
+	return p0.MultiplyV(-0.5*u3 + u2 - 0.5*u).
+		AddV(p1.MultiplyV(1.5*u3 - 2.5*u2 + 1)).
+		AddV(p2.MultiplyV(-1.5*u3 + 2*u2 + 0.5*u)).
+		AddV(p3.MultiplyV(0.5*u3 - 0.5*u2))
+}
+
+// Animation holds the tracks of the camera, the shapes and the nodes of a
+// scene. The poses are relative to the ones in the scene file: the camera
+// is turned in place by the rotation of its track and moved by its
+// position, and the shapes are moved by the position of theirs. Shapes can
+// only move, so their rotations are ignored. Nodes are turned around their
+// origin, relative to their parent, and moved by the position, carrying
+// their shapes and their children along, as the joints of the hierarchies
+// of glTF files are.
+type Animation struct {
+	// FPS is the number of frames per second
+	FPS float64 `json:"fps"`
+	// Frames is the number of frames, starting at time 0
+	Frames int    `json:"frames"`
+	Camera *Track `json:"camera,omitempty"`
+	// Shapes holds the tracks of the shapes, keyed by their names
+	Shapes map[string]*Track `json:"shapes,omitempty"`
+	// Nodes holds the tracks of the nodes, keyed by their paths
+	Nodes map[string]*Track `json:"nodes,omitempty"`
+	// StaticNoise renders every frame with the seed of the settings. The
+	// frames are rendered with seeds of their own otherwise, so that the
+	// noise isn't a still pattern the denoisers of video can't tell from
+	// the scene.
+	StaticNoise bool `json:"staticnoise,omitempty"`
+}
+
+// Load returns the animation in the JSON file at path
+func Load(path string) (*Animation, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &Animation{}
+	if err := json.Unmarshal(bytes, a); err != nil {
+		return nil, err
+	}
+	return a, a.Validate()
+}
+
+// Validate returns an error if the animation can't be played
+func (a *Animation) Validate() error {
+	if a.FPS <= 0 {
+		return fmt.Errorf("the frames per second must be positive")
+	}
+	if a.Frames < 1 {
+		return fmt.Errorf("there must be at least 1 frame")
+	}
+	if a.Camera != nil {
+		if err := a.Camera.validate(); err != nil {
+			return fmt.Errorf("camera: %v", err)
+		}
+	}
+	for name, track := range a.Shapes {
+		if err := track.validate(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	for path, track := range a.Nodes {
+		if err := track.validate(); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Time returns the time of the frame in seconds
+func (a *Animation) Time(frame int) float64 {
+	return float64(frame) / a.FPS
+}
+
+// Player poses a scene as the animation is at any time
+type Player struct {
+	animation *Animation
+	scene     *scene.Scene
+	// camera is the camera of the scene before it was animated
+	camera camera.PinHole
+	// seed is the seed of the settings of the scene before it was animated
+	seed uint64
+	// shapes holds the indices of the animated shapes, keyed by their names
+	shapes map[string]int
+	// offsets holds how far every animated shape was moved
+	offsets map[string]math3d.Vector3
+	// nodes holds the animated nodes, keyed by their paths, and locals
+	// their transforms before they were animated
+	nodes  map[string]*scene.Node
+	locals map[string]math3d.Matrix
+}
+
+// NewPlayer returns a player of the animation on the scene as it is now.
+// Every animated shape must be in the scene and be movable, and every
+// animated node must be in the scene graph, with shapes that can be
+// rotated if its track turns it.
+func NewPlayer(a *Animation, s *scene.Scene) (*Player, error) {
+	p := &Player{animation: a, scene: s, camera: s.Camera, seed: s.Settings.Seed,
+		shapes: make(map[string]int), offsets: make(map[string]math3d.Vector3),
+		nodes: make(map[string]*scene.Node), locals: make(map[string]math3d.Matrix)}
+	for i, sh := range s.Shapes {
+		name := shape.NameOf(sh, i)
+		if _, animated := a.Shapes[name]; !animated {
+			continue
+		}
+		if _, ok := sh.(shape.Movable); !ok {
+			return nil, fmt.Errorf("%s can't be moved", name)
+		}
+		p.shapes[name] = i
+	}
+	for name := range a.Shapes {
+		if _, ok := p.shapes[name]; !ok {
+			return nil, fmt.Errorf("there is no shape named %s", name)
+		}
+	}
+	for path, track := range a.Nodes {
+		n, ok := s.Node(path)
+		if !ok {
+			return nil, fmt.Errorf("there is no node at %s", path)
+		}
+		if turns(track) {
+			var fixed shape.Shape
+			n.Walk(func(n *scene.Node) {
+				for _, sh := range n.Shapes() {
+					if _, ok := sh.(shape.Transformable); !ok && fixed == nil {
+						fixed = sh
+					}
+				}
+			})
+			if fixed != nil {
+				return nil, fmt.Errorf("%s turns shapes of type %T, which can't be rotated", path, fixed)
+			}
+		}
+		p.nodes[path], p.locals[path] = n, n.Local()
+	}
+	return p, nil
+}
+
+// turns returns whether any keyframe of the track rotates
+func turns(t *Track) bool {
+	for _, k := range t.Keys {
+		if k.Angle != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Seek poses the scene as the animation is at time
+func (p *Player) Seek(time float64) {
+	if track := p.animation.Camera; track != nil {
+		position, rotation := track.At(time)
+		c := p.camera
+		c.FocalPoint = c.FocalPoint.AddV(position)
+		c.Up, c.Right, c.Towards = rotation.Rotate(c.Up), rotation.Rotate(c.Right), rotation.Rotate(c.Towards)
+		p.scene.Camera = c
+	}
+	for name, track := range p.animation.Shapes {
+		offset, _ := track.At(time)
+		move := offset.SubtractV(p.offsets[name])
+		if move != (math3d.Vector3{}) {
+			p.scene.MoveShape(p.shapes[name], &move)
+			p.offsets[name] = offset
+		}
+	}
+	for path, track := range p.animation.Nodes {
+		position, rotation := track.At(time)
+		local, turn, move := p.locals[path], math3d.RotationMatrix(rotation), math3d.TranslationMatrix(position)
+		posed := *move.ComposeMatrix(local.ComposeMatrix(&turn))
+		if posed != p.nodes[path].Local() {
+			// NewPlayer made sure the shapes can take the pose
+			if err := p.scene.SetNodeTransform(p.nodes[path], posed); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+// SeekFrame poses the scene as the animation is at the frame, and seeds
+// its settings with the seed of the frame, or the one they had before they
+// were animated if the noise is static
+func (p *Player) SeekFrame(frame int) {
+	p.Seek(p.animation.Time(frame))
+	p.scene.Settings.Seed = p.seed
+	if !p.animation.StaticNoise {
+		p.scene.Settings.Seed = sampling.FrameSeed(p.seed, frame)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,192 @@
+package animation
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func testTrack(interpolation string) *Track {
+	return &Track{Interpolation: interpolation, Keys: []Keyframe{
+		{Time: 0, Position: math3d.Vector3{}},
+		{Time: 1, Position: math3d.Vector3{X: 1}, Axis: math3d.UnitY, Angle: 1},
+		{Time: 3, Position: math3d.Vector3{X: 1, Y: 2}, Axis: math3d.UnitY, Angle: 2},
+		{Time: 4, Position: math3d.Vector3{X: 3, Y: 2}, Axis: math3d.UnitY, Angle: 3},
+	}}
+}
+
+func TestTrackInterpolation(t *testing.T) {
+	tests := []struct {
+		name          string
+		interpolation string
+		time          float64
+		position      math3d.Vector3
+		angle         float64
+	}{
+		{"before the first key", Linear, -1, math3d.Vector3{}, 0},
+		{"after the last key", Cubic, 9, math3d.Vector3{X: 3, Y: 2}, 3},
+		{"at a key", Linear, 1, math3d.Vector3{X: 1}, 1},
+		{"at a key", Cubic, 3, math3d.Vector3{X: 1, Y: 2}, 2},
+		{"between keys", Linear, 2, math3d.Vector3{X: 1, Y: 1}, 1.5},
+		{"empty is linear", "", 0.5, math3d.Vector3{X: 0.5}, 0.5},
+		// The tangents at the keys bend the curve away from the segment
+		{"between keys", Cubic, 2, math3d.Vector3{X: 0.9375, Y: 1}, 1.5},
+	}
+	for _, test := range tests {
+		position, rotation := testTrack(test.interpolation).At(test.time)
+		if !position.Equal(&test.position) {
+			t.Errorf("%s %s: the position should be %s, not %s", test.interpolation, test.name, test.position.String(), position.String())
+		}
+		if want := math3d.AxisAngle(math3d.UnitY, test.angle); !rotation.Equal(want) {
+			t.Errorf("%s %s: the rotation should be %s, not %s", test.interpolation, test.name, want.String(), rotation.String())
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		json  string
+		valid bool
+	}{
+		{`{"fps": 24, "frames": 10, "camera": {"keys": [{"time": 0}]}}`, true},
+		{`{"fps": 0, "frames": 10}`, false},
+		{`{"fps": 24, "frames": 0}`, false},
+		{`{"fps": 24, "frames": 10, "camera": {"keys": []}}`, false},
+		{`{"fps": 24, "frames": 10, "camera": {"keys": [{"time": 1}, {"time": 1}]}}`, false},
+		{`{"fps": 24, "frames": 10, "shapes": {"ball": {"interpolation": "bezier", "keys": [{"time": 0}]}}}`, false},
+	}
+	for _, test := range tests {
+		a := &Animation{}
+		if err := json.Unmarshal([]byte(test.json), a); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: valid should be %v, the error is %v", test.json, test.valid, err)
+		}
+	}
+}
+
+func TestPlayerPosesTheScene(t *testing.T) {
+	s := scene.New()
+	ball := &shape.Sphere{Name: "ball", Position: math3d.Vector3{Y: 1}, Radius: 1}
+	s.AddShape(ball)
+	s.AddShape(&shape.Sphere{Radius: 2})
+	a := &Animation{FPS: 2, Frames: 5,
+		Camera: &Track{Keys: []Keyframe{{Time: 0}, {Time: 2, Position: math3d.Vector3{Z: -2}, Axis: math3d.UnitY, Angle: math.Pi / 2}}},
+		Shapes: map[string]*Track{"ball": {Keys: []Keyframe{{Time: 0}, {Time: 1, Position: math3d.Vector3{X: 4}}}}}}
+	focalPoint := s.Camera.FocalPoint
+	p, err := NewPlayer(a, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.SeekFrame(1)
+	if want := (math3d.Vector3{X: 2, Y: 1}); !ball.Position.Equal(&want) {
+		t.Error("The ball should be halfway, not at " + ball.Position.String())
+	}
+	p.SeekFrame(4)
+	if want := (math3d.Vector3{X: 4, Y: 1}); !ball.Position.Equal(&want) {
+		t.Error("The ball should stay at the last key, not at " + ball.Position.String())
+	}
+	// The camera turns in place to look along X
+	if want := focalPoint.AddV(math3d.Vector3{Z: -2}); !s.Camera.FocalPoint.Equal(&want) {
+		t.Error("The camera should have moved to " + want.String() + ", not " + s.Camera.FocalPoint.String())
+	}
+	if !s.Camera.Towards.Equal(&math3d.UnitX) || !s.Camera.Up.Equal(&math3d.UnitY) {
+		t.Error("The camera should look along X, not " + s.Camera.Towards.String())
+	}
+	p.SeekFrame(0)
+	if want := (math3d.Vector3{Y: 1}); !ball.Position.Equal(&want) {
+		t.Error("The ball should be back where it started, not at " + ball.Position.String())
+	}
+
+	a.Shapes["missing"] = &Track{Keys: []Keyframe{{Time: 0}}}
+	if _, err := NewPlayer(a, s); err == nil {
+		t.Error("Animating a shape that isn't in the scene should fail")
+	}
+}
+
+func TestFramesHaveSeedsOfTheirOwn(t *testing.T) {
+	s := scene.New()
+	s.Settings.Seed = 7
+	a := &Animation{FPS: 24, Frames: 3}
+	p, err := NewPlayer(a, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds := make([]uint64, a.Frames)
+	for f := range seeds {
+		p.SeekFrame(f)
+		seeds[f] = s.Settings.Seed
+	}
+	if seeds[0] == seeds[1] || seeds[1] == seeds[2] || seeds[0] == seeds[2] {
+		t.Errorf("Every frame should have a seed of its own, not %v", seeds)
+	}
+	p.SeekFrame(1)
+	if s.Settings.Seed != seeds[1] {
+		t.Errorf("Seeking a frame again should seed it as before, with %v, not %v", seeds[1], s.Settings.Seed)
+	}
+
+	a.StaticNoise = true
+	p.SeekFrame(2)
+	if s.Settings.Seed != 7 {
+		t.Errorf("With static noise, the frames should keep the seed 7, not %v", s.Settings.Seed)
+	}
+}
+
+func TestPlayerTurnsNodes(t *testing.T) {
+	s := scene.New()
+	arm := scene.NewNode("arm", math3d.TranslationMatrix(math3d.Vector3{Y: 1}))
+	hand := scene.NewNode("hand", math3d.TranslationMatrix(math3d.Vector3{X: 1}))
+	finger := &shape.Triangle{Vertices: [3]math3d.Vector3{{}, {X: 0.1}, {Y: 0.1}}}
+	if err := s.AddNode(nil, arm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddNode(arm, hand, finger); err != nil {
+		t.Fatal(err)
+	}
+	a := &Animation{FPS: 1, Frames: 2,
+		Nodes: map[string]*Track{"arm": {Keys: []Keyframe{{Time: 0}, {Time: 1, Axis: math3d.UnitZ, Angle: math.Pi / 2}}}}}
+	p, err := NewPlayer(a, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The arm turns around its origin, raising the hand above it
+	p.SeekFrame(1)
+	if want := (math3d.Vector3{Y: 2}); !finger.Vertices[0].Equal(&want) {
+		t.Error("The hand should be raised to " + want.String() + ", not " + finger.Vertices[0].String())
+	}
+	p.SeekFrame(0)
+	if want := (math3d.Vector3{X: 1, Y: 1}); !finger.Vertices[0].Equal(&want) {
+		t.Error("The hand should be back where it started, not at " + finger.Vertices[0].String())
+	}
+
+	if err := s.AddNode(hand, scene.NewNode("ball", math3d.IdentityMatrix()), &shape.Sphere{Radius: 0.1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPlayer(a, s); err == nil {
+		t.Error("Turning a node with a sphere should fail")
+	}
+	a.Nodes = map[string]*Track{"arm/missing": {Keys: []Keyframe{{Time: 0}}}}
+	if _, err := NewPlayer(a, s); err == nil {
+		t.Error("Animating a node that isn't in the scene should fail")
+	}
+}
+
+func TestExampleAnimation(t *testing.T) {
+	a, err := Load("../scene-examples/simple1.animation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _, err := scene.ParseSceneFile("../scene-examples/simple1.json", scene.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPlayer(a, s); err != nil {
+		t.Error(err)
+	}
+}
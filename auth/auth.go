@@ -0,0 +1,114 @@
+// Package auth secures the HTTP services of goraytrace, such as the page
+// that watches progressive renders and the coordinator of network
+// renders, so they can be reached from beyond localhost. Services require
+// a shared token from the clients, and serve TLS with a certificate, which
+// clients such as workers trust even if it's self-signed.
+package auth
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TokenCookie is the cookie that browsers keep the token in once they
+// open a page with the token in its query
+const TokenCookie = "goraytrace-token"
+
+// Require returns a handler that serves the requests with the token
+// through handler, and answers the rest with 401 Unauthorized. Requests
+// carry the token as a bearer token in their Authorization header, or in
+// the token parameter of their query, such as the one of the page a
+// browser opens first, since browsers can't send headers with the images
+// of a page. The token of the query is kept in a cookie for the requests
+// of the page. An empty token lets every request through.
+func Require(token string, handler http.Handler) http.Handler {
+	if token == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if query := r.URL.Query().Get("token"); query != "" && matches(query, token) {
+			http.SetCookie(w, &http.Cookie{Name: TokenCookie, Value: query, Path: "/", HttpOnly: true,
+				Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") && matches(bearer[len("Bearer "):], token) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if cookie, err := r.Cookie(TokenCookie); err == nil && matches(cookie.Value, token) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="goraytrace"`)
+		http.Error(w, "a valid token is required", http.StatusUnauthorized)
+	})
+}
+
+// matches returns whether the token given is the token, taking as long
+// whichever of their bytes differ, so timing doesn't give it away
+func matches(given, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Listen listens on the TCP address, and serves TLS with the certificate
+// in certFile and its key in keyFile, both in PEM, unless they're both
+// empty
+func Listen(addr, certFile, keyFile string) (net.Listener, error) {
+	if certFile == "" && keyFile == "" {
+		return net.Listen("tcp", addr)
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS needs both a certificate and its key")
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12})
+}
+
+// Client returns a client that sends the token as a bearer token with
+// every request, unless it's empty, and that trusts the certificates in
+// caFile in PEM besides the ones of the system, unless it's empty, such
+// as the self-signed certificate of a service
+func Client(token, caFile string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("there are no certificates in " + caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	if token == "" {
+		return &http.Client{Transport: transport}, nil
+	}
+	return &http.Client{Transport: &bearer{token: token, next: transport}}, nil
+}
+
+// bearer is a transport that adds the token to the requests it sends
+type bearer struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (b *bearer) RoundTrip(r *http.Request) (*http.Response, error) {
+	// Round trippers mustn't change the requests they're given
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+b.token)
+	return b.next.RoundTrip(r)
+}
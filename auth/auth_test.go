@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireTakesTheTokenInEveryWay(t *testing.T) {
+	handler := Require("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(httptest.NewRequest(http.MethodGet, "/status", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("A request without the token should be unauthorized, not %d", w.Code)
+	}
+	wrong := httptest.NewRequest(http.MethodGet, "/status?token=guess", nil)
+	wrong.Header.Set("Authorization", "Bearer guess")
+	if w := serve(wrong); w.Code != http.StatusUnauthorized {
+		t.Errorf("A request with another token should be unauthorized, not %d", w.Code)
+	}
+	withHeader := httptest.NewRequest(http.MethodPost, "/work", nil)
+	withHeader.Header.Set("Authorization", "Bearer secret")
+	if w := serve(withHeader); w.Code != http.StatusNoContent {
+		t.Errorf("A request with the bearer token should be served, not answered %d", w.Code)
+	}
+
+	// The page opened with the token in the query keeps it for the
+	// requests of its images
+	w := serve(httptest.NewRequest(http.MethodGet, "/?token=secret", nil))
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusNoContent || len(cookies) != 1 || cookies[0].Name != TokenCookie || !cookies[0].HttpOnly {
+		t.Fatalf("The page should be served and set the cookie, not answered %d with %v", w.Code, cookies)
+	}
+	stream := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	stream.AddCookie(cookies[0])
+	if w := serve(stream); w.Code != http.StatusNoContent {
+		t.Errorf("A request with the cookie should be served, not answered %d", w.Code)
+	}
+
+	open := httptest.NewRecorder()
+	Require("", handler).ServeHTTP(open, httptest.NewRequest(http.MethodGet, "/", nil))
+	if open.Code != http.StatusUnauthorized {
+		t.Errorf("Without a token of its own the handler should answer as the one it wraps, not %d", open.Code)
+	}
+}
+
+func TestClientSendsTheTokenOverTLS(t *testing.T) {
+	server := httptest.NewTLSServer(Require("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, certificate, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := Client("secret", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		t.Errorf("The client should be let in, not answered %s", response.Status)
+	}
+	untrusting, err := Client("secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := untrusting.Get(server.URL); err == nil {
+		t.Error("A client shouldn't trust a self-signed certificate it wasn't given")
+	}
+	if _, err := Client("", filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("A missing certificate should be an error")
+	}
+	if _, err := Listen("127.0.0.1:0", caFile, ""); err == nil {
+		t.Error("TLS without a key should be an error")
+	}
+}
@@ -0,0 +1,127 @@
+package bench
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/render"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// benchSize is the width and height of the renders of the benchmarks
+const benchSize = 64
+
+func TestStandardScenesShowSomething(t *testing.T) {
+	for _, standard := range Scenes() {
+		s := standard.New()
+		data, err := s.Marshal()
+		if err != nil {
+			t.Fatalf("%s: %v", standard.Name, err)
+		}
+		if _, _, err := scene.ParseScene(data, scene.Strict); err != nil {
+			t.Errorf("%s isn't a valid scene file: %v", standard.Name, err)
+		}
+		rendered, err := render.Scene(context.Background(), s, 16, 16, render.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lit := 0
+		for i := 0; i < len(rendered.Pix); i += 4 {
+			if rendered.Pix[i]+rendered.Pix[i+1]+rendered.Pix[i+2] > 0 {
+				lit++
+			}
+		}
+		if lit < 16 {
+			t.Errorf("%s should show something lit, but only %d of the 256 pixels are", standard.Name, lit)
+		}
+	}
+}
+
+// primitives returns the shapes of the scene as primitives of a BVH
+func primitives(s *scene.Scene) []accel.Primitive {
+	primitives := make([]accel.Primitive, 0, len(s.Shapes))
+	for _, sh := range s.Shapes {
+		primitives = append(primitives, sh)
+	}
+	return primitives
+}
+
+// cameraRays returns the rays through the centers of the pixels of a
+// width x height render of the scene
+func cameraRays(s *scene.Scene, width, height int) []math3d.LightRay {
+	it := s.Camera.GetIterator(width, height)
+	rays := make([]math3d.LightRay, 0, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := it.PointAt(x, y)
+			rays = append(rays, math3d.LightRay{Direction: p.SubtractV(s.Camera.FocalPoint).NormalizedV(), Source: *p})
+		}
+	}
+	return rays
+}
+
+// accelerators are the acceleration structures the benchmarks compare
+var accelerators = []struct {
+	name  string
+	build func([]accel.Primitive) accel.Accelerator
+}{
+	{scene.BVH, func(p []accel.Primitive) accel.Accelerator { return accel.NewBVH(p) }},
+	{scene.KDTree, func(p []accel.Primitive) accel.Accelerator { return accel.NewKDTree(p) }},
+	{scene.SBVH, func(p []accel.Primitive) accel.Accelerator { return accel.NewSBVH(p, scene.DefaultSplitBudget) }},
+	// The fast build of the instances of the two level structure
+	{"lbvh", func(p []accel.Primitive) accel.Accelerator { return accel.NewLBVH(p) }},
+}
+
+// BenchmarkBuild measures the time to build the acceleration structures
+// over the shapes. The quadtree of the terrain is built with the
+// heightfield, not measured here.
+func BenchmarkBuild(b *testing.B) {
+	for _, standard := range Scenes() {
+		prims := primitives(standard.New())
+		for _, a := range accelerators {
+			b.Run(standard.Name+"/"+a.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					a.build(prims)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkIntersect measures the time to find the nearest shape a camera
+// ray hits, per ray
+func BenchmarkIntersect(b *testing.B) {
+	for _, standard := range Scenes() {
+		s := standard.New()
+		prims := primitives(s)
+		rays := cameraRays(s, benchSize, benchSize)
+		for _, a := range accelerators {
+			structure := a.build(prims)
+			b.Run(standard.Name+"/"+a.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					structure.Intersect(&rays[i%len(rays)])
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRender measures the time to render a whole frame, and reports
+// the rays traced per frame
+func BenchmarkRender(b *testing.B) {
+	for _, standard := range Scenes() {
+		s := standard.New()
+		s.Prepare()
+		b.Run(standard.Name, func(b *testing.B) {
+			before := s.Statistics()
+			for i := 0; i < b.N; i++ {
+				render.Scene(context.Background(), s, benchSize, benchSize, render.Options{})
+			}
+			rays := s.Statistics().Since(before).Rays
+			b.ReportMetric(float64(rays)/float64(b.N), "rays/op")
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Package bench holds the standard scenes the performance of the
+// renderer is measured with, and benchmarks of intersecting, building
+// and rendering them, so that regressions show up across releases:
+//
+//	go test ./bench -run XXX -bench . -count 5 > new.txt
+//
+// The benchmarks work with the profilers of go test, such as
+//
+//	go test ./bench -run XXX -bench Render/cornell -cpuprofile cpu.out
+//	go tool pprof cpu.out
+//
+// The triangle heavy scene is a terrain, as there are no triangle meshes
+// to load a teapot into. Heightfields are made of triangles too.
+package bench
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/generate"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Standard is a standard scene, which New creates anew every time it's
+// called, always the same
+type Standard struct {
+	Name string
+	New  func() *scene.Scene
+}
+
+// Scenes returns the standard scenes, from the fewest shapes to the most
+func Scenes() []Standard {
+	return []Standard{
+		{"cornell", CornellBox},
+		{"spheres", func() *scene.Scene { return SphereField(10000) }},
+		{"terrain", func() *scene.Scene { return Terrain(512) }},
+	}
+}
+
+// wallRadius is the radius of the spheres that make the walls of the
+// Cornell box, large enough for them to look flat
+const wallRadius = 1000
+
+// CornellBox returns a Cornell box, a room 2 units wide, tall and deep
+// with a red wall on the left, a green one on the right and the camera
+// where the fourth wall would be. A glossy and a lambertian sphere sit on
+// the floor, lit by a sphere light under the ceiling. As in smallpt, the
+// walls are huge spheres.
+func CornellBox() *scene.Scene {
+	s := scene.New()
+	s.Camera.FocalPoint = math3d.Vector3{Z: -4.5}
+	white := &material.Lambertian{Albedo: image.Color{R: 0.75, G: 0.75, B: 0.75}}
+	walls := []struct {
+		name     string
+		normal   math3d.Vector3
+		distance float64
+		material material.Material
+	}{
+		{"left", math3d.Vector3{X: 1}, 1, &material.Lambertian{Albedo: image.Color{R: 0.75, G: 0.25, B: 0.25}}},
+		{"right", math3d.Vector3{X: -1}, 1, &material.Lambertian{Albedo: image.Color{R: 0.25, G: 0.75, B: 0.25}}},
+		{"floor", math3d.Vector3{Y: 1}, 1, white},
+		{"ceiling", math3d.Vector3{Y: -1}, 1, white},
+		{"back", math3d.Vector3{Z: -1}, 2, white},
+	}
+	for _, w := range walls {
+		// The wall faces the inside of the box along its normal
+		center := w.normal.MultiplyV(-w.distance - wallRadius)
+		s.AddShape(&shape.Sphere{Position: center, Radius: wallRadius, Name: w.name, Material: w.material})
+	}
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: -0.45, Y: -0.6, Z: 1.3}, Radius: 0.4, Name: "glossy",
+		Material: &material.Glossy{Albedo: image.Color{R: 0.9, G: 0.9, B: 0.9}, Exponent: 200}})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 0.45, Y: -0.65, Z: 0.8}, Radius: 0.35, Name: "matte", Material: white})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{Y: 0.75, Z: 1}, Radius: 0.15, Radiance: image.Color{R: 30, G: 30, B: 30}})
+	return s
+}
+
+// SphereField returns a field of randomly placed spheres of random
+// materials, lit by a few lights, always the same for the same number
+func SphereField(spheres int) *scene.Scene {
+	p := generate.DefaultParams()
+	p.Spheres = spheres
+	return generate.Scene(p)
+}
+
+// Terrain returns a rolling terrain of samples x samples heights, made
+// of two triangles per cell, seen from above one of its sides and lit by
+// a low sun and a sphere light
+func Terrain(samples int) *scene.Scene {
+	s := scene.New()
+	// The camera looks 30 degrees down
+	pitch := 30 * math.Pi / 180
+	eye := math3d.Vector3{Y: 1.5, Z: -2.6}
+	target := eye.AddV(math3d.Vector3{Y: -math.Sin(pitch), Z: math.Cos(pitch)})
+	s.Camera = camera.NewLookAt(eye, target, math3d.UnitY, 40, 0)
+	terrain := shape.NewHeightfield(math3d.Vector3{X: -1, Z: -1}, math3d.Vector3{X: 2, Y: 0.4, Z: 2}, samples, samples,
+		func(x, z float64) float64 {
+			return 0.5 + 0.3*math.Sin(7*x)*math.Cos(5*z) + 0.2*math.Sin(23*x+11*z)*math.Cos(17*z)
+		})
+	terrain.Name = "terrain"
+	s.AddShape(terrain)
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{X: -4, Y: 3, Z: -2}, Intensity: image.Color{R: 5, G: 4.8, B: 4.4}})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 1.5, Y: 1.5, Z: 1.5}, Radius: 0.2, Radiance: image.Color{R: 2, G: 2.2, B: 2.5}})
+	return s
+}
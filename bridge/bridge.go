@@ -0,0 +1,210 @@
+package bridge
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	stdimg "image"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/render"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// Message kinds
+const (
+	KindToken      byte = 'A'
+	KindScene      byte = 'S'
+	KindSceneProto byte = 'P'
+	KindRender     byte = 'R'
+	KindQuit       byte = 'Q'
+	KindOK         byte = 'O'
+	KindError      byte = 'E'
+	KindTile       byte = 'T'
+	KindDone       byte = 'D'
+)
+
+// TileSize is the side in pixels of the tiles streamed while rendering
+const TileSize = 32
+
+// maxPayload limits the size of the frames clients can send
+const maxPayload = 64 << 20
+
+// ListenAndServe listens on the TCP address and serves the bridge
+// protocol to every client that connects.
+func ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return Serve(l)
+}
+
+// Serve serves the bridge protocol to the clients accepted by l, each
+// one in its own goroutine, with no limits.
+func Serve(l net.Listener) error {
+	return (&Server{}).Serve(l)
+}
+
+// ServeConn serves a single session on rw with no limits until the client
+// quits or the connection fails.
+func ServeConn(rw io.ReadWriter) error {
+	return (&Server{}).ServeConn(rw, "")
+}
+
+// errToken is the error of the sessions that don't start with the token
+// of the server
+var errToken = errors.New("the client didn't send the token of the server")
+
+// ServeConn serves a single session of the client on rw until the client
+// quits or the connection fails. The messages past the limits of the
+// server are answered with errors, and the session stays usable. If the
+// server has a token, the session must start with it, and it ends with
+// an error otherwise.
+func (s *Server) ServeConn(rw io.ReadWriter, client string) error {
+	var current *scene.Scene
+	var memory uint64
+	authenticated := s.Token == ""
+	for {
+		kind, payload, err := ReadFrame(rw)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if kind == KindToken || kind == KindScene || kind == KindSceneProto || kind == KindRender {
+			if limited := s.allow(client); limited != nil {
+				if err = WriteFrame(rw, KindError, []byte(limited.Error())); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !authenticated && (kind != KindToken || subtle.ConstantTimeCompare(payload, []byte(s.Token)) != 1) {
+			WriteFrame(rw, KindError, []byte("a valid token is required"))
+			return errToken
+		}
+		switch kind {
+		case KindToken:
+			authenticated = true
+			err = WriteFrame(rw, KindOK, nil)
+		case KindScene, KindSceneProto:
+			load := scene.LoadScene
+			if kind == KindSceneProto {
+				load = scene.LoadSceneProto
+			}
+			loaded, loadedMemory, loadErr := s.loadScene(payload, load)
+			if loadErr != nil {
+				err = WriteFrame(rw, KindError, []byte(loadErr.Error()))
+			} else {
+				current, memory = loaded, loadedMemory
+				err = WriteFrame(rw, KindOK, nil)
+			}
+		case KindRender:
+			err = s.renderFrame(rw, current, memory, payload)
+		case KindQuit:
+			return nil
+		default:
+			err = WriteFrame(rw, KindError, []byte(fmt.Sprintf("unknown message kind %q", kind)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// renderFrame traces the scene, which takes memory bytes, tile by tile,
+// sending each tile as soon as it's done.
+func (srv *Server) renderFrame(w io.Writer, s *scene.Scene, memory uint64, payload []byte) error {
+	if s == nil {
+		return WriteFrame(w, KindError, []byte("no scene was sent before rendering"))
+	}
+	if len(payload) != 8 {
+		return WriteFrame(w, KindError, []byte("the render payload must be the width and height"))
+	}
+	// The product of two 32 bit sizes can't overflow 64 bits, unlike an int
+	// on 32 bit platforms
+	width64, height64 := uint64(binary.BigEndian.Uint32(payload[0:4])), uint64(binary.BigEndian.Uint32(payload[4:8]))
+	if width64 == 0 || height64 == 0 || width64*height64 > maxPayload/4 {
+		return WriteFrame(w, KindError, []byte(fmt.Sprintf("invalid frame size %dx%d", width64, height64)))
+	}
+	width, height := int(width64), int(height64)
+	if err := srv.checkFrame(width, height, memory); err != nil {
+		return WriteFrame(w, KindError, []byte(err.Error()))
+	}
+
+	start := time.Now()
+	var frame *image.Image
+	if err := protect(func() { frame = image.New(width, height) }); err != nil {
+		return WriteFrame(w, KindError, []byte(err.Error()))
+	}
+	for _, tile := range render.Tiles(width, height, TileSize) {
+		if srv.Limits.MaxTime > 0 && time.Since(start) > srv.Limits.MaxTime {
+			return WriteFrame(w, KindError, []byte(fmt.Sprintf("the render took longer than the %v the server allows", srv.Limits.MaxTime)))
+		}
+		if err := protect(func() { s.TraceRegion(frame, tile) }); err != nil {
+			return WriteFrame(w, KindError, []byte(err.Error()))
+		}
+		if err := WriteFrame(w, KindTile, tilePayload(frame, tile)); err != nil {
+			return err
+		}
+	}
+	return WriteFrame(w, KindDone, nil)
+}
+
+func tilePayload(frame *image.Image, tile stdimg.Rectangle) []byte {
+	payload := make([]byte, 16, 16+4*tile.Dx()*tile.Dy())
+	binary.BigEndian.PutUint32(payload[0:], uint32(tile.Min.X))
+	binary.BigEndian.PutUint32(payload[4:], uint32(tile.Min.Y))
+	binary.BigEndian.PutUint32(payload[8:], uint32(tile.Dx()))
+	binary.BigEndian.PutUint32(payload[12:], uint32(tile.Dy()))
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		start := frame.PixOffset(tile.Min.X, y)
+		payload = append(payload, frame.Pix[start:start+4*tile.Dx()]...)
+	}
+	return payload
+}
+
+// protect runs f turning its panics into errors, since malformed scenes
+// from a client mustn't bring the server down.
+func protect(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
+// ReadFrame reads a frame of the protocol from r
+func ReadFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxPayload {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too big", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// WriteFrame writes a frame of the protocol to w
+func WriteFrame(w io.Writer, kind byte, payload []byte) error {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
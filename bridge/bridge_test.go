@@ -0,0 +1,283 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/proto/scenepb"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+func TestRenderSession(t *testing.T) {
+	sceneJSON, err := ioutil.ReadFile("../scene-examples/simple1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	go ServeConn(server)
+	defer client.Close()
+
+	go WriteFrame(client, KindRender, make([]byte, 8))
+	if kind, _, _ := ReadFrame(client); kind != KindError {
+		t.Errorf("Rendering without a scene should fail, got %q", kind)
+	}
+
+	go WriteFrame(client, KindScene, sceneJSON)
+	if kind, msg, _ := ReadFrame(client); kind != KindOK {
+		t.Fatalf("Loading the scene failed: %s", msg)
+	}
+
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint32(size[0:], 0xFFFFFFFF)
+	binary.BigEndian.PutUint32(size[4:], 0xFFFFFFFF)
+	go WriteFrame(client, KindRender, size)
+	if kind, _, err := ReadFrame(client); err != nil || kind != KindError {
+		t.Fatalf("A frame too large to allocate should be turned down, got %q (%v)", kind, err)
+	}
+
+	binary.BigEndian.PutUint32(size[0:], 40)
+	binary.BigEndian.PutUint32(size[4:], 20)
+	go WriteFrame(client, KindRender, size)
+	pixels := 0
+	for {
+		kind, payload, err := ReadFrame(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind == KindDone {
+			break
+		}
+		if kind != KindTile {
+			t.Fatalf("Expected a tile, got %q: %s", kind, payload)
+		}
+		w, h := binary.BigEndian.Uint32(payload[8:]), binary.BigEndian.Uint32(payload[12:])
+		if len(payload) != 16+int(4*w*h) {
+			t.Fatalf("Tile of %dx%d with %d bytes", w, h, len(payload))
+		}
+		pixels += int(w * h)
+	}
+	if pixels != 40*20 {
+		t.Errorf("The tiles should cover the frame, but cover %d pixels", pixels)
+	}
+
+	go WriteFrame(client, KindScene, []byte("{not json"))
+	if kind, _, _ := ReadFrame(client); kind != KindError {
+		t.Errorf("A malformed scene should be reported, got %q", kind)
+	}
+}
+
+func TestProtobufScenes(t *testing.T) {
+	message := &scenepb.Scene{
+		Camera: &scenepb.Camera{Up: &scenepb.Vector3{Y: 1}, Right: &scenepb.Vector3{X: 1}, Towards: &scenepb.Vector3{Z: 1},
+			FocalPoint: &scenepb.Vector3{Z: -1}, FieldOfView: 1.5, ViewPlaneDistance: 1},
+		Lights: []*scenepb.Light{{Light: &scenepb.Light_Point{Point: &scenepb.PointLight{
+			Position: &scenepb.Vector3{Y: 2}, Intensity: &scenepb.Color{R: 1, G: 1, B: 1}}}}},
+		Shapes: []*scenepb.Shape{{Shape: &scenepb.Shape_Sphere{Sphere: &scenepb.Sphere{Position: &scenepb.Vector3{Z: 3}, Radius: 1}}}},
+	}
+	client, server := net.Pipe()
+	go ServeConn(server)
+	defer client.Close()
+	go WriteFrame(client, KindSceneProto, message.Marshal())
+	if kind, msg, _ := ReadFrame(client); kind != KindOK {
+		t.Fatalf("Loading the scene failed: %s", msg)
+	}
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint32(size[0:], 8)
+	binary.BigEndian.PutUint32(size[4:], 8)
+	go WriteFrame(client, KindRender, size)
+	kind, payload, _ := ReadFrame(client)
+	if kind != KindTile {
+		t.Fatalf("Expected a tile, got %q: %s", kind, payload)
+	}
+	// The sphere fills the middle of the frame
+	if middle := payload[16+4*(4*8+4):]; middle[0] == 0 && middle[1] == 0 && middle[2] == 0 {
+		t.Error("The sphere of the scene should be rendered")
+	}
+	if kind, _, _ := ReadFrame(client); kind != KindDone {
+		t.Fatalf("Expected the end of the frame, got %q", kind)
+	}
+	message.Camera = nil
+	go WriteFrame(client, KindSceneProto, message.Marshal())
+	if kind, _, _ := ReadFrame(client); kind != KindError {
+		t.Errorf("A scene without a camera should be reported, got %q", kind)
+	}
+	go WriteFrame(client, KindSceneProto, []byte("{not protobuf"))
+	if kind, _, _ := ReadFrame(client); kind != KindError {
+		t.Errorf("A malformed scene should be reported, got %q", kind)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	sceneJSON, err := ioutil.ReadFile("../scene-examples/simple1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{Limits: Limits{Rate: 0.001, Burst: 4, MaxPixels: 100, MaxMemory: 1 << 40}}
+	client, conn := net.Pipe()
+	go server.ServeConn(conn, "client")
+	defer client.Close()
+
+	go WriteFrame(client, KindScene, sceneJSON)
+	if kind, msg, _ := ReadFrame(client); kind != KindOK {
+		t.Fatalf("Loading the scene failed: %s", msg)
+	}
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint32(size[0:], 0xFFFFFFFF)
+	binary.BigEndian.PutUint32(size[4:], 0xFFFFFFFF)
+	go WriteFrame(client, KindRender, size)
+	if kind, msg, err := ReadFrame(client); err != nil || kind != KindError {
+		t.Fatalf("A frame whose pixels overflow an int should be turned down, got %q: %s (%v)", kind, msg, err)
+	}
+	binary.BigEndian.PutUint32(size[0:], 40)
+	binary.BigEndian.PutUint32(size[4:], 20)
+	go WriteFrame(client, KindRender, size)
+	if kind, msg, _ := ReadFrame(client); kind != KindError || !strings.Contains(string(msg), "pixels") {
+		t.Errorf("A frame with too many pixels should be turned down, got %q: %s", kind, msg)
+	}
+	binary.BigEndian.PutUint32(size[0:], 10)
+	binary.BigEndian.PutUint32(size[4:], 10)
+	go WriteFrame(client, KindRender, size)
+	for {
+		kind, msg, err := ReadFrame(client)
+		if err != nil || kind == KindError {
+			t.Fatalf("A frame within the limits should render: %v %s", err, msg)
+		}
+		if kind == KindDone {
+			break
+		}
+	}
+	go WriteFrame(client, KindRender, size)
+	if kind, msg, _ := ReadFrame(client); kind != KindError || !strings.Contains(string(msg), "try again") {
+		t.Errorf("The messages past the burst should be turned down, got %q: %s", kind, msg)
+	}
+
+	// Other clients have buckets of their own
+	other, conn := net.Pipe()
+	go server.ServeConn(conn, "other")
+	defer other.Close()
+	server.Limits.MaxSamples = 0
+	go WriteFrame(other, KindScene, []byte(`{"render": {"samples": 64}}`))
+	if kind, msg, _ := ReadFrame(other); kind == KindError && strings.Contains(string(msg), "try again") {
+		t.Errorf("Every client should have its own bucket: %s", msg)
+	}
+}
+
+func TestToken(t *testing.T) {
+	sceneJSON, err := ioutil.ReadFile("../scene-examples/simple1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{Token: "secret"}
+	session := func(kind byte, payload []byte) net.Conn {
+		client, conn := net.Pipe()
+		go func() {
+			server.ServeConn(conn, "client")
+			conn.Close()
+		}()
+		go WriteFrame(client, kind, payload)
+		return client
+	}
+	for _, first := range []struct {
+		kind    byte
+		payload string
+	}{{KindScene, string(sceneJSON)}, {KindToken, "secreT"}, {KindToken, "secret!"}} {
+		client := session(first.kind, []byte(first.payload))
+		if kind, _, _ := ReadFrame(client); kind != KindError {
+			t.Errorf("A session starting with %q %q should be turned down, got %q", first.kind, first.payload, kind)
+		}
+		if _, _, err := ReadFrame(client); err == nil {
+			t.Errorf("A session starting with %q %q should be closed", first.kind, first.payload)
+		}
+		client.Close()
+	}
+	client := session(KindToken, []byte("secret"))
+	defer client.Close()
+	if kind, msg, _ := ReadFrame(client); kind != KindOK {
+		t.Fatalf("The token should be accepted, got %q: %s", kind, msg)
+	}
+	go WriteFrame(client, KindScene, sceneJSON)
+	if kind, msg, _ := ReadFrame(client); kind != KindOK {
+		t.Errorf("The scene should load once the token is accepted, got %q: %s", kind, msg)
+	}
+}
+
+func TestFullBucketsAreDropped(t *testing.T) {
+	server := &Server{Limits: Limits{Rate: 1000, Burst: 2}}
+	for i := 0; i < 100; i++ {
+		server.allow(fmt.Sprint("client", i))
+	}
+	// The buckets fill up in 2 milliseconds
+	time.Sleep(10 * time.Millisecond)
+	if err := server.allow("client0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.buckets) != 1 {
+		t.Errorf("Only the bucket of the client that sent a message should be kept, not %d", len(server.buckets))
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	sceneJSON, err := ioutil.ReadFile("../scene-examples/simple1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spheres strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&spheres, `{"type": "sphere", "position": {"x": %d, "y": 0, "z": 5}, "radius": 0.5}, `, i)
+	}
+	sceneJSON = []byte(strings.Replace(string(sceneJSON), `"shapes": [`, `"shapes": [`+spheres.String(), 1))
+	server := &Server{Limits: Limits{MaxMemory: 1 << 10}}
+	if _, _, err := server.loadScene(sceneJSON, scene.LoadScene); err == nil || !strings.Contains(err.Error(), "memory") {
+		t.Errorf("A scene larger than the memory allowed should be turned down, not %v", err)
+	}
+	server.Limits.MaxMemory = 1 << 30
+	loaded, memory, err := server.loadScene(sceneJSON, scene.LoadScene)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memory != loaded.Memory() || memory <= 1<<10 {
+		t.Errorf("The memory of the scene should be the one of its geometry, not %d bytes", memory)
+	}
+}
+
+func TestSampleAndTimeLimits(t *testing.T) {
+	sceneJSON, err := ioutil.ReadFile("../scene-examples/simple1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{Limits: Limits{MaxSamples: 1, MaxTime: time.Nanosecond}}
+	client, conn := net.Pipe()
+	go server.ServeConn(conn, "client")
+	defer client.Close()
+
+	many := strings.Replace(string(sceneJSON), "{", `{"render": {"samples": 4},`, 1)
+	go WriteFrame(client, KindScene, []byte(many))
+	if kind, msg, _ := ReadFrame(client); kind != KindError || !strings.Contains(string(msg), "samples") {
+		t.Errorf("A scene with too many samples should be turned down, got %q: %s", kind, msg)
+	}
+	go WriteFrame(client, KindScene, sceneJSON)
+	if kind, msg, _ := ReadFrame(client); kind != KindOK {
+		t.Fatalf("Loading the scene failed: %s", msg)
+	}
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint32(size[0:], 200)
+	binary.BigEndian.PutUint32(size[4:], 200)
+	go WriteFrame(client, KindRender, size)
+	for {
+		kind, msg, err := ReadFrame(client)
+		if err != nil || kind == KindDone {
+			t.Fatalf("A render past the time limit should fail: %v", err)
+		}
+		if kind == KindError {
+			if !strings.Contains(string(msg), "longer") {
+				t.Errorf("Unexpected error: %s", msg)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Package bridge implements the server side of a small socket protocol that
+lets an external application, such as a Blender custom RenderEngine
+add-on, use goraytrace to render its viewport and final frames.
+
+The client sends the scene and asks for renders, and the server streams
+the frame back tile by tile as they are traced, so the host can show
+progress. A session is a single TCP connection and holds one scene.
+
+Every message in either direction is a frame made of a one byte kind,
+the length of the payload as a big endian uint32 and the payload:
+
+	+------+----------------+-----------------+
+	| kind | length (4 B)   | payload         |
+	+------+----------------+-----------------+
+
+The client sends:
+
+	'A' token   payload is the token of the server. A server with a token
+	            requires it first in every session, and answers the other
+	            messages, or a wrong token, with 'E' and closes the
+	            connection. Answered with 'O' or 'E'.
+	'S' scene   payload is a scene in the JSON scene file format. It
+	            replaces the scene of the session. Answered with 'O' or 'E'.
+	'P' scene   payload is a scene as the Scene message of
+	            proto/scene.proto, in the protobuf wire format, for the
+	            clients that build their scenes with protobuf. It's
+	            handled as 'S' is.
+	'R' render  payload is the width and height of the frame as two big
+	            endian uint32. Answered with 'T' frames followed by 'D',
+	            or with 'E'.
+	'Q' quit    empty payload. The server closes the connection.
+
+The server sends:
+
+	'O' ok      empty payload.
+	'E' error   payload is a UTF-8 message. The session stays usable.
+	'T' tile    payload is the x, y, width and height of the tile as four
+	            big endian uint32 followed by width*height pixels in 8 bit
+	            RGBA. Rows go from the bottom of the frame to the top,
+	            which is also the order Blender expects in a RenderResult,
+	            so tiles can be copied into its pass buffers as they come
+	            after dividing by 255.
+	'D' done    empty payload. The frame is complete.
+
+A Server shared by many clients can be given Limits: how many token,
+scene and render messages every client may send a second, and the most
+pixels, samples per pixel, time and memory a render may take. The
+messages past them are answered with 'E', and a render that runs out of
+time sends the tiles traced by then followed by 'E'. It can also be given
+a token, which sessions must start with; the connection should then be
+TLS, so the token isn't sent in the clear.
+*/
+package bridge
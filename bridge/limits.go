@@ -0,0 +1,160 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// Limits are the most a client of a shared server may ask of it, so no
+// client can keep it to itself. The zero value of every limit means no
+// limit.
+type Limits struct {
+	// Rate is the number of token, scene and render messages a second
+	// every client may send on average, over all of its sessions, and
+	// Burst the number it may send at once, so tokens can't be guessed
+	// quickly either. Clients are told apart by their hosts.
+	Rate  float64
+	Burst int
+	// MaxPixels is the most pixels a frame may have
+	MaxPixels int
+	// MaxSamples is the most samples per pixel a scene may take
+	MaxSamples int
+	// MaxTime is the longest a render may take. The tiles traced by then
+	// are sent, and then an error.
+	MaxTime time.Duration
+	// MaxMemory is the most bytes of memory a scene and a frame of it may
+	// take together. The memory of a scene is what scene.Memory estimates
+	// its geometry and its structures take once they're built.
+	MaxMemory uint64
+}
+
+// Server serves the bridge protocol within its limits
+type Server struct {
+	// Token is the token the sessions must start with, if it isn't empty
+	Token  string
+	Limits Limits
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// swept is when the full buckets were last dropped
+	swept time.Time
+}
+
+// bucket is a token bucket, which holds the messages a client may send
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Serve serves the bridge protocol to the clients accepted by l, each
+// one in its own goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		client, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			client = conn.RemoteAddr().String()
+		}
+		go func() {
+			defer conn.Close()
+			s.ServeConn(conn, client)
+		}()
+	}
+}
+
+// allow takes a message from the bucket of the client, returning an error
+// that says when to try again if it's empty
+func (s *Server) allow(client string) error {
+	if s.Limits.Rate <= 0 {
+		return nil
+	}
+	burst := float64(s.Limits.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets == nil {
+		s.buckets = make(map[string]*bucket)
+	}
+	now := time.Now()
+	// A full bucket is as good as none, so the buckets of the clients that
+	// stopped sending are dropped every time they could have filled up
+	if refill := time.Duration(burst / s.Limits.Rate * float64(time.Second)); now.Sub(s.swept) >= refill {
+		for c, b := range s.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*s.Limits.Rate >= burst {
+				delete(s.buckets, c)
+			}
+		}
+		s.swept = now
+	}
+	b, ok := s.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		s.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * s.Limits.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / s.Limits.Rate * float64(time.Second))
+		return fmt.Errorf("too many messages, try again in %v", wait.Round(time.Millisecond))
+	}
+	b.tokens--
+	return nil
+}
+
+// loadScene loads the scene in the payload with load, which panics if the
+// scene can't be used, returning the bytes of memory it takes if the
+// server limits it
+func (s *Server) loadScene(payload []byte, load func([]byte) *scene.Scene) (*scene.Scene, uint64, error) {
+	var loaded *scene.Scene
+	if s.Limits.MaxMemory == 0 {
+		err := protect(func() { loaded = load(payload) })
+		return loaded, 0, s.checkSamples(loaded, err)
+	}
+	err := protect(func() {
+		loaded = load(payload)
+		loaded.Prepare()
+	})
+	if err = s.checkSamples(loaded, err); err != nil {
+		return nil, 0, err
+	}
+	memory := loaded.Memory()
+	if memory > s.Limits.MaxMemory {
+		return nil, 0, fmt.Errorf("the scene takes %d MiB of memory and the server allows %d MiB", memory>>20, s.Limits.MaxMemory>>20)
+	}
+	return loaded, memory, nil
+}
+
+// checkSamples returns err, or an error if there's none and the loaded
+// scene takes more samples than the server allows
+func (s *Server) checkSamples(loaded *scene.Scene, err error) error {
+	if err != nil || s.Limits.MaxSamples <= 0 || loaded.Settings.Samples <= s.Limits.MaxSamples {
+		return err
+	}
+	return fmt.Errorf("the scene takes %d samples per pixel and the server allows %d", loaded.Settings.Samples, s.Limits.MaxSamples)
+}
+
+// checkFrame returns why a width x height frame of a scene that takes
+// memory bytes can't be rendered, or nil if it can
+func (s *Server) checkFrame(width, height int, memory uint64) error {
+	// The sizes are below 2^32, so their product fits in 64 bits
+	pixels := uint64(width) * uint64(height)
+	if s.Limits.MaxPixels > 0 && pixels > uint64(s.Limits.MaxPixels) {
+		return fmt.Errorf("the frame has %d pixels and the server allows %d", pixels, s.Limits.MaxPixels)
+	}
+	if total := memory + 4*pixels; s.Limits.MaxMemory > 0 && total > s.Limits.MaxMemory {
+		return fmt.Errorf("the scene and the frame take %d MiB of memory and the server allows %d MiB", total>>20, s.Limits.MaxMemory>>20)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+// Package bundle packs a scene file and the files its shapes and lights
+// refer to, such as meshes, images and profiles, into a single scene
+// bundle, optionally encrypted and signed, so that studios can send their
+// scenes to render farms without handing them the assets in the clear.
+//
+// A bundle is a zip archive of the scene file and the assets by their
+// paths relative to it, encrypted with AES-256 in GCM if it's given an
+// encryption key, which also makes it tamper evident. A bundle signed with
+// an Ed25519 key can be checked to come from whoever holds it with its
+// verifying key. The keys are given when the bundle is rendered, and its
+// files are decrypted into memory only, never to the disk. Note that the
+// process rendering it holds them in the clear all the same, so the farm
+// is trusted with them while it renders; what the bundle protects them
+// from is being stored and passed around in the clear.
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// SceneName is the name of the scene file in a bundle
+const SceneName = "scene.json"
+
+// KeySize is the size of the keys, in bytes: the encryption keys, the
+// seeds of the signing keys and the verifying keys
+const KeySize = 32
+
+// magic starts every bundle, followed by its version and its flags
+const magic = "GRTBUNDL"
+
+// version is the version of the bundles Write writes
+const version = 1
+
+// The flags of a bundle
+const (
+	encrypted = 1 << iota
+	signed
+)
+
+// headerSize is the size of the magic, the version and the flags
+const headerSize = len(magic) + 2
+
+// Keys are the keys a bundle is written or read with. Any of them may be
+// nil.
+type Keys struct {
+	// Encryption is the AES-256 key the bundle is encrypted with
+	Encryption []byte
+	// Signing is the seed of the Ed25519 key Write signs the bundle with
+	Signing []byte
+	// Verifying is the Ed25519 key Read requires the bundle to be signed
+	// with, the one VerifyingKey returns for its signing key
+	Verifying []byte
+}
+
+// validate returns an error if any of the keys has the wrong size
+func (k Keys) validate() error {
+	for _, key := range []struct {
+		name  string
+		value []byte
+	}{{"encryption", k.Encryption}, {"signing", k.Signing}, {"verifying", k.Verifying}} {
+		if key.value != nil && len(key.value) != KeySize {
+			return fmt.Errorf("the %s key must be %d bytes long, not %d", key.name, KeySize, len(key.value))
+		}
+	}
+	return nil
+}
+
+// VerifyingKey returns the key that verifies the bundles signed with the
+// signing key
+func VerifyingKey(signing []byte) []byte {
+	return ed25519.NewKeyFromSeed(signing).Public().(ed25519.PublicKey)
+}
+
+// NewKey returns a random key, for encrypting bundles or signing them
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadKey reads a key from a file that holds it in hexadecimal, such as
+// the ones that `openssl rand -hex 32` prints
+func LoadKey(path string) ([]byte, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("the key in %s must be %d bytes in hexadecimal", path, KeySize)
+	}
+	return key, nil
+}
+
+// Write writes the bundle of the scene file and the files it refers to,
+// which must be inside its directory, encrypted and signed with the keys
+// that aren't nil
+func Write(w io.Writer, scenePath string, keys Keys) error {
+	if err := keys.validate(); err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(scenePath)
+	if err != nil {
+		return err
+	}
+	referenced, err := scene.ReferencedFiles(contents)
+	if err != nil {
+		return fmt.Errorf("can't read the scene file: %v", err)
+	}
+	var archive bytes.Buffer
+	zipped := zip.NewWriter(&archive)
+	add := func(name string, contents []byte) error {
+		f, err := zipped.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(contents)
+		return err
+	}
+	if err := add(SceneName, contents); err != nil {
+		return err
+	}
+	dir := filepath.Dir(scenePath)
+	for _, p := range referenced {
+		name := filepath.ToSlash(filepath.Clean(p))
+		if filepath.IsAbs(p) || !isLocal(name) {
+			return fmt.Errorf("can't bundle %s, which isn't inside the directory of the scene file", p)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, p))
+		if err != nil {
+			return err
+		}
+		if err := add(name, contents); err != nil {
+			return err
+		}
+	}
+	if err := zipped.Close(); err != nil {
+		return err
+	}
+	bundle, err := seal(archive.Bytes(), keys)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bundle)
+	return err
+}
+
+// seal returns the bundle of the archive, encrypted and signed with the
+// keys that aren't nil
+func seal(archive []byte, keys Keys) ([]byte, error) {
+	var flags byte
+	if keys.Encryption != nil {
+		flags |= encrypted
+	}
+	if keys.Signing != nil {
+		flags |= signed
+	}
+	header := append([]byte(magic), version, flags)
+	bundle := append([]byte{}, header...)
+	if keys.Encryption != nil {
+		aead, err := newAEAD(keys.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		// The header is authenticated too, so the flags can't be changed
+		bundle = aead.Seal(append(bundle, nonce...), nonce, archive, header)
+	} else {
+		bundle = append(bundle, archive...)
+	}
+	if keys.Signing != nil {
+		bundle = append(bundle, ed25519.Sign(ed25519.NewKeyFromSeed(keys.Signing), bundle)...)
+	}
+	return bundle, nil
+}
+
+// Read returns the contents of the files of the bundle by their slash
+// separated paths, the scene file as SceneName. It returns an error if
+// the bundle is encrypted and the encryption key is nil or isn't the one
+// it was encrypted with, or if the verifying key isn't nil and the bundle
+// isn't signed with its signing key.
+func Read(bundle []byte, keys Keys) (map[string][]byte, error) {
+	if err := keys.validate(); err != nil {
+		return nil, err
+	}
+	if !isBundle(bundle) || len(bundle) < headerSize {
+		return nil, errors.New("not a scene bundle")
+	}
+	if bundle[len(magic)] != version {
+		return nil, fmt.Errorf("unknown version %d of scene bundles", bundle[len(magic)])
+	}
+	flags := bundle[len(magic)+1]
+	payload := bundle[headerSize:]
+	if flags&signed != 0 {
+		if len(payload) < ed25519.SignatureSize {
+			return nil, errors.New("the signature of the bundle is cut short")
+		}
+		signature := bundle[len(bundle)-ed25519.SignatureSize:]
+		bundle = bundle[:len(bundle)-ed25519.SignatureSize]
+		payload = bundle[headerSize:]
+		if keys.Verifying != nil && !ed25519.Verify(keys.Verifying, bundle, signature) {
+			return nil, errors.New("the bundle isn't signed with the key the verifying key verifies, or it was changed since")
+		}
+	} else if keys.Verifying != nil {
+		return nil, errors.New("the bundle isn't signed")
+	}
+	archive := payload
+	if flags&encrypted != 0 {
+		if keys.Encryption == nil {
+			return nil, errors.New("the bundle is encrypted, and needs its key")
+		}
+		aead, err := newAEAD(keys.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) < aead.NonceSize() {
+			return nil, errors.New("the bundle is cut short")
+		}
+		archive, err = aead.Open(nil, payload[:aead.NonceSize()], payload[aead.NonceSize():], bundle[:headerSize])
+		if err != nil {
+			return nil, errors.New("can't decrypt the bundle, the key is wrong or the bundle was changed")
+		}
+	}
+	zipped, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte, len(zipped.File))
+	for _, f := range zipped.File {
+		if !isLocal(f.Name) {
+			return nil, fmt.Errorf("the bundle holds %s, which isn't inside its directory", f.Name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		contents, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s: %v", f.Name, err)
+		}
+		files[path.Clean(f.Name)] = contents
+	}
+	if _, ok := files[SceneName]; !ok {
+		return nil, errors.New("the bundle holds no scene file")
+	}
+	return files, nil
+}
+
+// newAEAD returns AES-256 in GCM with the key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isLocal returns whether the slash separated path is relative and stays
+// inside its directory
+func isLocal(name string) bool {
+	name = path.Clean(name)
+	return name != "." && name != ".." && !path.IsAbs(name) && !strings.HasPrefix(name, "../") && !strings.Contains(name, `\`) && !strings.Contains(name, ":")
+}
+
+// isBundle returns whether the data starts like a bundle
+func isBundle(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// IsBundle returns whether the file at path is a bundle rather than a
+// scene file or a scene cache
+func IsBundle(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	start := make([]byte, len(magic))
+	n, _ := io.ReadFull(file, start)
+	return isBundle(start[:n]), nil
+}
+
+// Open reads the bundle at path with the keys, as Read does, and parses
+// its scene with scene.ParseSceneFile. Its files are mounted in memory as
+// if the bundle were their directory, so that the scene refers to them by
+// the same paths it did before it was bundled. They stay mounted for as
+// long as the process runs, since scenes may read some of them only when
+// they first render them.
+func Open(path string, mode scene.ParseMode, keys Keys) (*scene.Scene, []string, error) {
+	bundle, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := Read(bundle, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	assets.Mount(path, files)
+	return scene.ParseSceneFile(filepath.Join(path, SceneName), mode)
+}
@@ -0,0 +1,215 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// meshScene writes the mesh example scene to a directory of its own, with
+// its mesh in a subdirectory, and returns the path of the scene file,
+// whose directory the caller removes
+func meshScene(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("../scene-examples/mesh.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	m["shapes"].([]interface{})[0].(map[string]interface{})["file"] = "meshes/icosphere.obj"
+	data, _ = json.Marshal(m)
+	mesh, err := ioutil.ReadFile("../scene-examples/icosphere.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(dir, "meshes"), 0755)
+	if err := ioutil.WriteFile(filepath.Join(dir, "meshes", "icosphere.obj"), mesh, 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "mesh.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newKeys returns random encryption and signing keys
+func newKeys(t *testing.T) Keys {
+	encryption, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Keys{Encryption: encryption, Signing: signing, Verifying: VerifyingKey(signing)}
+}
+
+func TestBundlesRenderWithoutTheirFiles(t *testing.T) {
+	path := meshScene(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	original, _, err := scene.ParseSceneFile(path, scene.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newKeys(t)
+	var written bytes.Buffer
+	if err := Write(&written, path, keys); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(written.Bytes(), []byte("icosphere")) {
+		t.Error("the encrypted bundle shouldn't show the names of its files")
+	}
+	// The bundle takes the place of the scene and its mesh
+	os.RemoveAll(filepath.Dir(path))
+	os.Mkdir(filepath.Dir(path), 0755)
+	bundlePath := filepath.Join(filepath.Dir(path), "mesh.bundle")
+	if err := ioutil.WriteFile(bundlePath, written.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := IsBundle(bundlePath); !ok || err != nil {
+		t.Fatalf("the bundle should be told apart from scene files (%v)", err)
+	}
+	s, _, err := Open(bundlePath, scene.Strict, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Shapes) != len(original.Shapes) {
+		t.Errorf("the bundled scene should have the %d shapes of the original, not %d", len(original.Shapes), len(s.Shapes))
+	}
+	if entries, _ := ioutil.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("the files of the bundle shouldn't be written to the disk, but there are %d files", len(entries))
+	}
+}
+
+func TestBundlesHoldTheTexturesOfTheirMaterials(t *testing.T) {
+	path := meshScene(t)
+	dir := filepath.Dir(path)
+	defer os.RemoveAll(dir)
+	data, _ := ioutil.ReadFile(path)
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	m["shapes"].([]interface{})[0].(map[string]interface{})["material"] = map[string]interface{}{
+		"type": "procedural", "color": map[string]interface{}{"node": "image", "file": "textures/wood.png"}}
+	data, _ = json.Marshal(m)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wood := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	wood.Set(1, 1, color.RGBA{R: 120, G: 80, B: 40, A: 255})
+	var texture bytes.Buffer
+	png.Encode(&texture, wood)
+	os.Mkdir(filepath.Join(dir, "textures"), 0755)
+	if err := ioutil.WriteFile(filepath.Join(dir, "textures", "wood.png"), texture.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys := newKeys(t)
+	var written bytes.Buffer
+	if err := Write(&written, path, keys); err != nil {
+		t.Fatal(err)
+	}
+	files, err := Read(written.Bytes(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(files["textures/wood.png"], texture.Bytes()) {
+		t.Error("the bundle should hold the texture of the material of the mesh")
+	}
+	os.RemoveAll(dir)
+	os.Mkdir(dir, 0755)
+	bundlePath := filepath.Join(dir, "textured.bundle")
+	if err := ioutil.WriteFile(bundlePath, written.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Open(bundlePath, scene.Strict, keys); err != nil {
+		t.Errorf("the textured scene should load from its bundle alone: %v", err)
+	}
+}
+
+func TestBundlesRequireTheirKeys(t *testing.T) {
+	path := meshScene(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	keys := newKeys(t)
+	var written bytes.Buffer
+	if err := Write(&written, path, keys); err != nil {
+		t.Fatal(err)
+	}
+	bundle := written.Bytes()
+	if _, err := Read(bundle, keys); err != nil {
+		t.Fatal(err)
+	}
+	other := newKeys(t)
+	if _, err := Read(bundle, Keys{Verifying: keys.Verifying}); err == nil {
+		t.Error("an encrypted bundle shouldn't be read without its key")
+	}
+	if _, err := Read(bundle, Keys{Encryption: other.Encryption}); err == nil {
+		t.Error("an encrypted bundle shouldn't be read with another key")
+	}
+	if _, err := Read(bundle, Keys{Encryption: keys.Encryption, Verifying: other.Verifying}); err == nil {
+		t.Error("a bundle signed with another key shouldn't be verified")
+	}
+	if _, err := Read(bundle, Keys{Encryption: keys.Encryption}); err != nil {
+		t.Errorf("the signature of a bundle should only be checked with a verifying key (%v)", err)
+	}
+	tampered := append([]byte{}, bundle...)
+	tampered[headerSize+20] ^= 1
+	if _, err := Read(tampered, keys); err == nil {
+		t.Error("a changed bundle shouldn't be verified")
+	}
+	if _, err := Read(tampered, Keys{Encryption: keys.Encryption}); err == nil {
+		t.Error("a changed encrypted bundle shouldn't be decrypted, even if its signature isn't checked")
+	}
+}
+
+func TestUnencryptedBundles(t *testing.T) {
+	path := meshScene(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	var written bytes.Buffer
+	if err := Write(&written, path, Keys{}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := Read(written.Bytes(), Keys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh, _ := ioutil.ReadFile("../scene-examples/icosphere.obj")
+	if len(files) != 2 || !bytes.Equal(files["meshes/icosphere.obj"], mesh) {
+		t.Errorf("the bundle should hold the scene file and its mesh, not %d files", len(files))
+	}
+	if _, err := Read(written.Bytes(), Keys{Verifying: VerifyingKey(make([]byte, KeySize))}); err == nil {
+		t.Error("an unsigned bundle shouldn't be verified")
+	}
+}
+
+func TestFilesOutsideTheSceneDirectoryArentBundled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scene.json")
+	contents := `{"camera": {}, "shapes": [{"type": "mesh", "file": "../secrets.obj"}]}`
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(ioutil.Discard, path, Keys{}); err == nil {
+		t.Error("files outside the directory of the scene file shouldn't be bundled")
+	}
+}
@@ -0,0 +1,89 @@
+package camera
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// The projections of cameras
+const (
+	// Perspective sees through the view plane, as pinhole cameras do
+	Perspective = "perspective"
+	// Equirectangular sees all around the camera, with the longitude
+	// growing along the width of the image from behind it on the left to
+	// behind it on the right, and the latitude along the height from below
+	// it to above it, as the up vector does in perspective renders
+	Equirectangular = "equirectangular"
+)
+
+// The layouts of the eyes of stereo renders
+const (
+	// TopBottom puts the left eye in the first half of the rows and the
+	// right eye in the second half
+	TopBottom = "topbottom"
+	// SideBySide puts the left eye in the first half of the columns and the
+	// right eye in the second half
+	SideBySide = "sidebyside"
+)
+
+// validateProjection returns an error if the camera can't render with its
+// projection
+func (ph *PinHole) validateProjection() error {
+	switch {
+	case ph.Projection != "" && ph.Projection != Perspective && ph.Projection != Equirectangular:
+		return fmt.Errorf("the projection must be %s or %s", Perspective, Equirectangular)
+	case ph.Stereo != "" && ph.Stereo != TopBottom && ph.Stereo != SideBySide:
+		return fmt.Errorf("the stereo layout must be %s or %s", TopBottom, SideBySide)
+	case ph.Stereo != "" && ph.Projection != Equirectangular:
+		return fmt.Errorf("only the %s projection renders in stereo", Equirectangular)
+	case !(ph.IPD >= 0) || math.IsInf(ph.IPD, 1):
+		return fmt.Errorf("the IPD must be finite and can't be negative")
+	case !(ph.Convergence >= 0) || math.IsInf(ph.Convergence, 1):
+		return fmt.Errorf("the convergence must be finite and can't be negative")
+	}
+	return nil
+}
+
+// panoramicRay returns the lightray of the equirectangular projection
+// through the point x, y of an image of width x height, in pixels.
+//
+// Stereo renders are omnidirectional: rather than two cameras IPD apart,
+// every direction is seen from the eyes of a viewer turned towards it, on
+// a circle of diameter IPD around the focal point. The eyes look along
+// the tangents of the circle, or towards the point Convergence away along
+// the direction if it's not 0.
+func (ph *PinHole) panoramicRay(x, y float64, width, height int) math3d.LightRay {
+	w, h := float64(width), float64(height)
+	eye := 0.0
+	switch ph.Stereo {
+	case TopBottom:
+		h /= 2
+		eye = -1
+		if y >= h {
+			eye, y = 1, y-h
+		}
+	case SideBySide:
+		w /= 2
+		eye = -1
+		if x >= w {
+			eye, x = 1, x-w
+		}
+	}
+	right, up, towards := ph.Right.NormalizedV(), ph.Up.NormalizedV(), ph.Towards.NormalizedV()
+	longitude := (x/w - 0.5) * 2 * math.Pi
+	latitude := (y/h - 0.5) * math.Pi
+	horizontal := right.MultiplyV(math.Sin(longitude)).AddV(towards.MultiplyV(math.Cos(longitude)))
+	direction := horizontal.MultiplyV(math.Cos(latitude)).AddV(up.MultiplyV(math.Sin(latitude)))
+	source := ph.FocalPoint
+	if eye != 0 {
+		// The right eye of a viewer looking along the horizontal direction
+		side := right.MultiplyV(math.Cos(longitude)).SubtractV(towards.MultiplyV(math.Sin(longitude)))
+		source = source.AddV(side.MultiplyV(eye * ph.IPD / 2))
+		if ph.Convergence > 0 {
+			direction = ph.FocalPoint.AddV(direction.MultiplyV(ph.Convergence)).SubtractV(source)
+		}
+	}
+	return math3d.LightRay{Source: source, Direction: direction.NormalizedV()}
+}
@@ -0,0 +1,70 @@
+package camera
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestEquirectangularSeesAllAround(t *testing.T) {
+	ph := DefaultPinHole()
+	ph.Projection = Equirectangular
+	for _, c := range []struct {
+		x, y     float64
+		expected math3d.Vector3
+	}{
+		{4, 2, math3d.UnitZ},
+		{6, 2, math3d.UnitX},
+		{2, 2, math3d.Vector3{X: -1}},
+		{0, 2, math3d.Vector3{Z: -1}},
+		{4, 4, math3d.UnitY},
+		{4, 0, math3d.Vector3{Y: -1}},
+	} {
+		lr := ph.panoramicRay(c.x, c.y, 8, 4)
+		if math3d.Distance(&lr.Direction, &c.expected) > 1e-12 || !lr.Source.Equal(&ph.FocalPoint) {
+			t.Errorf("The point %.0f, %.0f should look along %s from the focal point, not along %s", c.x, c.y, c.expected.String(), lr.Direction.String())
+		}
+	}
+	if _, _, ok := ph.Project(&math3d.UnitZ, 8, 4); ok {
+		t.Error("Points can't be projected onto equirectangular renders")
+	}
+}
+
+func TestStereoEyesAreIPDApart(t *testing.T) {
+	ph := DefaultPinHole()
+	ph.Projection, ph.Stereo, ph.IPD = Equirectangular, TopBottom, 0.064
+	it := ph.GetIterator(8, 8)
+	// The left eye is in the first rows and the right eye in the others
+	left, right := it.Ray(4, 2, 0, 0), it.Ray(4, 6, 0, 0)
+	if separation := right.Source.SubtractV(left.Source); math3d.Distance(&separation, &math3d.Vector3{X: 0.064}) > 1e-12 {
+		t.Errorf("The right eye should be 0.064 to the right of the left one, not %s away", separation.String())
+	}
+	if !left.Direction.Equal(&right.Direction) {
+		t.Error("Without convergence, the eyes should look parallel")
+	}
+	// Looking back, the viewer's right is the camera's left
+	back := it.Ray(0, 6, 0, 0)
+	if !(back.Source.X < ph.FocalPoint.X) {
+		t.Errorf("Looking back, the right eye should be on the left of the camera, it's at %s", back.Source.String())
+	}
+
+	ph.Stereo, ph.Convergence = SideBySide, 2
+	it = ph.GetIterator(16, 4)
+	left, right = it.Ray(4, 2, 0, 0), it.Ray(12, 2, 0, 0)
+	meeting := ph.FocalPoint.AddV(math3d.Vector3{Z: 2})
+	for _, lr := range []math3d.LightRay{left, right} {
+		toMeeting := meeting.SubtractV(lr.Source).NormalizedV()
+		if math3d.Distance(&lr.Direction, &toMeeting) > 1e-12 {
+			t.Errorf("The eyes should converge 2 ahead, the one at %s looks along %s", lr.Source.String(), lr.Direction.String())
+		}
+	}
+	if size := ph.PixelSize(16, 4); math.Abs(size-math.Pi/4) > 1e-12 {
+		t.Errorf("The pixels should cover 45 degrees, not %.3f radians", size)
+	}
+
+	ph.Projection = Perspective
+	if ph.Validate() == nil {
+		t.Error("Only equirectangular cameras render in stereo")
+	}
+}
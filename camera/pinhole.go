@@ -1,12 +1,17 @@
 package camera
 
 import (
+	"encoding/json"
+	"fmt"
 	"math"
 
+	"github.com/ProjectMOA/goraytrace/jsonutil"
 	"github.com/ProjectMOA/goraytrace/math3d"
 )
 
-// PinHole defines a pinhole camera in 3D space.
+// PinHole defines a pinhole camera in 3D space. It sees through a view
+// plane in front of it, or all around it with the equirectangular
+// projection.
 type PinHole struct {
 	Up                math3d.Vector3 `json:"up"`
 	Right             math3d.Vector3 `json:"right"`
@@ -14,6 +19,110 @@ type PinHole struct {
 	FocalPoint        math3d.Vector3 `json:"focalpoint"`
 	FoV               float64        `json:"fieldofview"`
 	ViewPlaneDistance float64        `json:"viewplanedistance"`
+	// Aspect is the width over the height of the images the field of view
+	// is for. Images of other shapes keep the horizontal field of view
+	// rather than the vertical one. If it's zero, the field of view is
+	// always vertical.
+	Aspect float64 `json:"aspect,omitempty"`
+	// Projection is how the camera maps directions to pixels, Perspective
+	// if it's empty
+	Projection string `json:"projection,omitempty"`
+	// Stereo is how the images of the left and right eyes are laid out in
+	// equirectangular renders, which are mono if it's empty
+	Stereo string `json:"stereo,omitempty"`
+	// IPD is the distance between the eyes of stereo renders
+	IPD float64 `json:"ipd,omitempty"`
+	// Convergence is the distance at which the eyes of stereo renders see
+	// the same point, so things there are at the depth of the screen. The
+	// eyes look parallel, converging far away, if it's 0.
+	Convergence float64 `json:"convergence,omitempty"`
+}
+
+// NewLookAt returns a camera at eye that looks at target, turned so that
+// its up vector is as close to up as it can be, with a vertical field of
+// view of vfovDegrees for images aspect times as wide as they are high
+func NewLookAt(eye, target, up math3d.Vector3, vfovDegrees, aspect float64) PinHole {
+	ph := PinHole{ViewPlaneDistance: 1}
+	ph.LookAt(eye, target, up)
+	ph.SetFieldOfView(vfovDegrees)
+	ph.SetAspect(aspect)
+	return ph
+}
+
+// LookAt moves the camera to eye and turns it to look at target, with its
+// up vector as close to up as it can be. If target is eye or up is along
+// the line of sight, the vectors are zero, and the camera isn't valid.
+func (ph *PinHole) LookAt(eye, target, up math3d.Vector3) {
+	ph.FocalPoint = eye
+	towards := target.SubtractV(eye)
+	right := up.CrossV(towards)
+	if towards.AbsSquared() == 0 || right.AbsSquared() == 0 {
+		ph.Up, ph.Right, ph.Towards = math3d.Vector3{}, math3d.Vector3{}, math3d.Vector3{}
+		return
+	}
+	ph.Towards = towards.NormalizedV()
+	ph.Right = right.NormalizedV()
+	ph.Up = ph.Towards.CrossV(ph.Right)
+}
+
+// StereoPair returns the left and right cameras of a rectified stereo pair
+// centered on the camera, baseline apart along its right vector. They look
+// the same way, so the same point is seen in the same row by both, in the
+// left image disparity pixels right of where it's seen in the right one.
+func (ph *PinHole) StereoPair(baseline float64) (left, right PinHole) {
+	offset := ph.Right.NormalizedV().MultiplyV(baseline / 2)
+	left, right = *ph, *ph
+	left.FocalPoint = ph.FocalPoint.SubtractV(offset)
+	right.FocalPoint = ph.FocalPoint.AddV(offset)
+	return left, right
+}
+
+// Fly moves the camera along its own axes, forward where it looks, right
+// and up, and then turns it yaw radians right around the vertical, the Y
+// axis, and pitch radians up, as the cameras of games fly through scenes.
+// Turning around the vertical keeps the horizon level, and the camera
+// doesn't pitch any closer to straight up or down than about 8 degrees.
+func (ph *PinHole) Fly(forward, right, up, yaw, pitch float64) {
+	ph.FocalPoint = ph.FocalPoint.AddV(ph.Towards.MultiplyV(forward)).AddV(ph.Right.MultiplyV(right)).AddV(ph.Up.MultiplyV(up))
+	turn := math3d.AxisAngle(math3d.UnitY, yaw)
+	towards, side := turn.Rotate(ph.Towards), turn.Rotate(ph.Right)
+	// Pitching up turns the line of sight towards the up vector, which is
+	// a negative angle around the right vector
+	if pitched := math3d.AxisAngle(side, -pitch).Rotate(towards); math.Abs(pitched.NormalizedV().Y) < 0.99 {
+		towards = pitched
+	}
+	ph.Towards = towards.NormalizedV()
+	ph.Right = side.NormalizedV()
+	ph.Up = ph.Towards.CrossV(ph.Right)
+}
+
+// SetFieldOfView sets the vertical field of view of the camera in degrees,
+// keeping its view plane distance
+func (ph *PinHole) SetFieldOfView(degrees float64) {
+	ph.FoV = 2 * math.Atan(ph.ViewPlaneDistance*math.Tan(degrees*math.Pi/360))
+}
+
+// SetAspect sets the width over the height of the images the field of view
+// is for. See Aspect.
+func (ph *PinHole) SetAspect(aspect float64) {
+	ph.Aspect = aspect
+}
+
+// PixelSize returns the side of the pixels of an image of width x height
+// rendered from the camera, on its view plane. For the equirectangular
+// projection, it's the angle they cover at the horizon, which is their
+// size at a view plane distance of 1.
+func (ph *PinHole) PixelSize(width, height int) float64 {
+	if ph.Projection == Equirectangular {
+		if ph.Stereo == TopBottom {
+			height /= 2
+		}
+		return math.Pi / float64(height)
+	}
+	if ph.Aspect > 0 {
+		return 2.0 * math.Tan(ph.FoV/2.0) * ph.Aspect / float64(width)
+	}
+	return 2.0 * math.Tan(ph.FoV/2.0) / float64(height)
 }
 
 // DefaultPinHole returns a default PinHole camera
@@ -31,21 +140,117 @@ func DefaultPinHole() PinHole {
 // to render an image from a PinHole camera.
 func (ph *PinHole) GetIterator(width, height int) *TracingTargetIterator {
 	middlePoint := ph.FocalPoint.Add(ph.Towards.Multiply(ph.ViewPlaneDistance))
-	pixelSize := float64((2.0 * math.Tan(ph.FoV/2.0)) / float64(height))
+	pixelSize := ph.PixelSize(width, height)
 	firstPoint := middlePoint.
 		Subtract(ph.Right.Multiply(float64(width-1) / 2.0 * pixelSize).
 			Add(ph.Up.Multiply(float64(height-1) / 2.0 * pixelSize)))
-	return &TracingTargetIterator{currx: 0, curry: 0, firstPoint: *firstPoint, height: height, width: width, pxsize: pixelSize}
-}
-
-// PinHoleFromMap returns the pinhole camera defined in the map
-func PinHoleFromMap(m map[string]interface{}) PinHole {
-	ph := PinHole{}
-	ph.FocalPoint = math3d.VectorFromMap(m["focalpoint"].(map[string]interface{}))
-	ph.FoV, _ = m["fieldofview"].(float64)
-	ph.ViewPlaneDistance, _ = m["viewplanedistance"].(float64)
-	ph.Up = math3d.VectorFromMap(m["up"].(map[string]interface{}))
-	ph.Right = math3d.VectorFromMap(m["right"].(map[string]interface{}))
-	ph.Towards = math3d.VectorFromMap(m["towards"].(map[string]interface{}))
-	return ph
+	return &TracingTargetIterator{currx: 0, curry: 0, firstPoint: *firstPoint, right: ph.Right, up: ph.Up, height: height, width: width, pxsize: pixelSize, camera: *ph}
+}
+
+// Project returns the pixel coordinates where point is seen in an image
+// of width x height rendered from the camera. ok is false if the point is
+// behind the camera, or if the camera isn't a perspective one.
+func (ph *PinHole) Project(point *math3d.Vector3, width, height int) (x, y float64, ok bool) {
+	if ph.Projection == Equirectangular {
+		return 0, 0, false
+	}
+	v := point.Subtract(&ph.FocalPoint)
+	depth := v.Dot(&ph.Towards)
+	if depth <= 0 {
+		return 0, 0, false
+	}
+	pixelSize := ph.PixelSize(width, height)
+	scale := ph.ViewPlaneDistance / depth / pixelSize
+	x = v.Dot(&ph.Right)*scale + float64(width-1)/2.0
+	y = v.Dot(&ph.Up)*scale + float64(height-1)/2.0
+	return x, y, true
+}
+
+// jsonPinHole is a PinHole without its JSON methods, to encode it
+type jsonPinHole PinHole
+
+// MarshalJSON returns the camera as an object with the keys of its fields.
+// It fails if the camera can't be decoded back.
+func (ph PinHole) MarshalJSON() ([]byte, error) {
+	if err := ph.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPinHole(ph))
+}
+
+// UnmarshalJSON sets the camera from an object with every key of its
+// fields and nothing else, failing if the camera isn't valid. The camera
+// can also be an object with the keys "eye", "target", "up" and
+// "fieldofview", and optionally "aspect", which looks at the target as
+// LookAt does, with a view plane distance of 1.
+func (ph *PinHole) UnmarshalJSON(data []byte) error {
+	var keys map[string]json.RawMessage
+	json.Unmarshal(data, &keys)
+	_, eye := keys["eye"]
+	_, target := keys["target"]
+	var decoded PinHole
+	var err error
+	if eye || target {
+		var eye, target math3d.Vector3
+		err = jsonutil.Object(data, map[string]interface{}{
+			"eye":         &eye,
+			"target":      &target,
+			"up":          &decoded.Up,
+			"fieldofview": &decoded.FoV,
+			"aspect":      &decoded.Aspect,
+			"projection":  &decoded.Projection,
+			"stereo":      &decoded.Stereo,
+			"ipd":         &decoded.IPD,
+			"convergence": &decoded.Convergence,
+		}, "eye", "target", "up", "fieldofview")
+		decoded.ViewPlaneDistance = 1
+		decoded.LookAt(eye, target, decoded.Up)
+	} else {
+		err = jsonutil.Object(data, map[string]interface{}{
+			"up":                &decoded.Up,
+			"right":             &decoded.Right,
+			"towards":           &decoded.Towards,
+			"focalpoint":        &decoded.FocalPoint,
+			"fieldofview":       &decoded.FoV,
+			"viewplanedistance": &decoded.ViewPlaneDistance,
+			"aspect":            &decoded.Aspect,
+			"projection":        &decoded.Projection,
+			"stereo":            &decoded.Stereo,
+			"ipd":               &decoded.IPD,
+			"convergence":       &decoded.Convergence,
+		}, "up", "right", "towards", "focalpoint", "fieldofview", "viewplanedistance")
+	}
+	if err != nil {
+		return err
+	}
+	if err := decoded.Validate(); err != nil {
+		return err
+	}
+	*ph = decoded
+	return nil
+}
+
+// Validate returns an error if the camera can't render images
+func (ph *PinHole) Validate() error {
+	if err := jsonutil.Finite(ph.FocalPoint.X, ph.FocalPoint.Y, ph.FocalPoint.Z); err != nil {
+		return fmt.Errorf("the focal point must be finite")
+	}
+	if !(ph.FoV > 0 && ph.FoV < math.Pi) {
+		return fmt.Errorf("the field of view must be between 0 and pi")
+	}
+	if !(ph.ViewPlaneDistance > 0) || math.IsInf(ph.ViewPlaneDistance, 1) {
+		return fmt.Errorf("the view plane distance must be positive and finite")
+	}
+	if !(ph.Aspect >= 0) || math.IsInf(ph.Aspect, 1) {
+		return fmt.Errorf("the aspect must be finite and can't be negative")
+	}
+	if err := ph.validateProjection(); err != nil {
+		return err
+	}
+	for _, v := range []math3d.Vector3{ph.Up, ph.Right, ph.Towards} {
+		if jsonutil.Finite(v.X, v.Y, v.Z) != nil || v.AbsSquared() == 0 {
+			return fmt.Errorf("the up, right and towards vectors must be finite and non zero")
+		}
+	}
+	return nil
 }
@@ -1,7 +1,10 @@
 package camera
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/ProjectMOA/goraytrace/math3d"
@@ -21,3 +24,99 @@ func TestPointIterator(t *testing.T) {
 		fmt.Println(iterator.Next())
 	}
 }
+
+func TestPinHoleJSON(t *testing.T) {
+	data, err := json.Marshal(DefaultPinHole())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded PinHole
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != DefaultPinHole() {
+		t.Errorf("%s should decode to the default camera, not %v (%v)", data, decoded, err)
+	}
+	valid := string(data)
+	for _, invalid := range []string{
+		strings.Replace(valid, `"fieldofview":0.3490659`, `"fieldofview":4`, 1),
+		strings.Replace(valid, `"viewplanedistance":1`, `"viewplanedistance":0`, 1),
+		strings.Replace(valid, `"up":{"x":0,"y":1,"z":0}`, `"up":{"x":0,"y":0,"z":0}`, 1),
+		strings.Replace(valid, `"up":{"x":0,"y":1,"z":0},`, ``, 1),
+		strings.Replace(valid, `"up"`, `"down"`, 1),
+	} {
+		if invalid == valid {
+			t.Fatalf("The camera should encode as the tests expect, not as %s", valid)
+		}
+		if err := json.Unmarshal([]byte(invalid), &decoded); err == nil {
+			t.Errorf("%s shouldn't decode", invalid)
+		}
+	}
+	if _, err := json.Marshal(PinHole{}); err == nil {
+		t.Error("A camera that can't render shouldn't encode")
+	}
+}
+
+func TestLookAt(t *testing.T) {
+	eye, target := math3d.Vector3{X: 1, Y: 2, Z: 3}, math3d.Vector3{X: 1, Y: 2, Z: 5}
+	ph := NewLookAt(eye, target, math3d.Vector3{Y: 2, Z: 1}, 90, 0)
+	if !ph.Towards.Equal(&math3d.UnitZ) || !ph.Right.Equal(&math3d.UnitX) || !ph.Up.Equal(&math3d.UnitY) {
+		t.Errorf("The camera should look along Z with Y up, not along %s with %s up", ph.Towards.String(), ph.Up.String())
+	}
+	// A field of view of 90 degrees sees a point as far up as it's ahead
+	// at the top edge of the image
+	top := math3d.Vector3{X: 1, Y: 4, Z: 5}
+	if _, y, ok := ph.Project(&top, 100, 101); !ok || math.Abs(y-100.5) > 1e-9 {
+		t.Errorf("The point should be at the top edge, not at y = %.3f", y)
+	}
+	ph.SetAspect(2)
+	// Images twice as high keep the horizontal field of view
+	right := math3d.Vector3{X: 5, Y: 2, Z: 5}
+	if x, _, _ := ph.Project(&right, 100, 100); math.Abs(x-99.5) > 1e-9 {
+		t.Errorf("The point should be at the right edge, not at x = %.3f", x)
+	}
+	if nowhere := NewLookAt(eye, eye, math3d.UnitY, 60, 0); nowhere.Validate() == nil {
+		t.Error("A camera that looks at its eye can't render")
+	}
+	var decoded PinHole
+	lookAt := `{"eye": {"x": 1, "y": 2, "z": 3}, "target": {"x": 1, "y": 2, "z": 5}, "up": {"x": 0, "y": 2, "z": 1}, "fieldofview": 1.5707963267948966, "aspect": 2}`
+	if err := json.Unmarshal([]byte(lookAt), &decoded); err != nil || decoded != ph {
+		t.Errorf("%s should decode to the camera looking at the target, not %v (%v)", lookAt, decoded, err)
+	}
+	if err := json.Unmarshal([]byte(strings.Replace(lookAt, `"target"`, `"towards"`, 1)), &decoded); err == nil {
+		t.Error("A camera can't mix the look at keys with the others")
+	}
+}
+
+func TestStereoPair(t *testing.T) {
+	ph := NewLookAt(math3d.Vector3{Z: -1}, math3d.Vector3{}, math3d.UnitY, 40, 0)
+	left, right := ph.StereoPair(0.5)
+	if d, want := right.FocalPoint.SubtractV(left.FocalPoint), ph.Right.MultiplyV(0.5); !d.Equal(&want) {
+		t.Errorf("The cameras should be 0.5 apart along the right vector, not %s", d.String())
+	}
+	point := math3d.Vector3{X: 0.1, Y: 0.2, Z: 2}
+	xl, yl, _ := left.Project(&point, 100, 100)
+	xr, yr, _ := right.Project(&point, 100, 100)
+	if math.Abs(yl-yr) > 1e-9 || xl <= xr {
+		t.Errorf("The left camera should see the point in the same row, right of where the right one does, not at %g, %g and %g, %g", xl, yl, xr, yr)
+	}
+}
+
+func TestFly(t *testing.T) {
+	ph := NewLookAt(math3d.Vector3{}, math3d.UnitZ, math3d.UnitY, 60, 0)
+	ph.Fly(2, 1, 0.5, 0, 0)
+	if want := (math3d.Vector3{X: 1, Y: 0.5, Z: 2}); !ph.FocalPoint.Equal(&want) {
+		t.Errorf("The camera should fly along its own axes to %s, not to %s", want.String(), ph.FocalPoint.String())
+	}
+	// Turning right a quarter turn looks along X, keeping the horizon level
+	ph.Fly(0, 0, 0, math.Pi/2, 0)
+	if !ph.Towards.Equal(&math3d.UnitX) || !ph.Up.Equal(&math3d.UnitY) {
+		t.Errorf("The camera should look along X with Y up, not along %s with %s up", ph.Towards.String(), ph.Up.String())
+	}
+	ph.Fly(0, 0, 0, 0, math.Pi/4)
+	if math.Abs(ph.Towards.Y-math.Sqrt2/2) > 1e-9 || math.Abs(ph.Towards.X-math.Sqrt2/2) > 1e-9 || ph.Validate() != nil {
+		t.Errorf("The camera should look 45 degrees up, not along %s", ph.Towards.String())
+	}
+	// Pitching straight up would lose the horizon, so the camera stays put
+	ph.Fly(0, 0, 0, 0, math.Pi/2)
+	if math.Abs(ph.Towards.Y-math.Sqrt2/2) > 1e-9 {
+		t.Errorf("The camera shouldn't pitch straight up, it looks along %s", ph.Towards.String())
+	}
+}
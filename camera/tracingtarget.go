@@ -7,7 +7,9 @@ import "github.com/ProjectMOA/goraytrace/math3d"
 type TracingTargetIterator struct {
 	width, height, currx, curry int
 	firstPoint                  math3d.Vector3
+	right, up                   math3d.Vector3
 	pxsize                      float64
+	camera                      PinHole
 }
 
 // HasNext returns true if there are some points left to trace.
@@ -17,7 +19,7 @@ func (tti *TracingTargetIterator) HasNext() bool {
 
 // Next returns the next point that must be traced.
 func (tti *TracingTargetIterator) Next() (*math3d.Vector3, int, int) {
-	retVal := tti.firstPoint.Add(math3d.UnitX.Multiply(tti.pxsize).Multiply(float64(tti.currx)).Add(math3d.UnitY.Multiply(tti.pxsize).Multiply(float64(tti.curry))))
+	retVal := tti.PointAt(tti.currx, tti.curry)
 	rx, ry := tti.currx, tti.curry
 	tti.currx++
 	if tti.currx == tti.width {
@@ -26,3 +28,59 @@ func (tti *TracingTargetIterator) Next() (*math3d.Vector3, int, int) {
 	}
 	return retVal, rx, ry
 }
+
+// PointAt returns the point that must be traced for the pixel x, y.
+func (tti *TracingTargetIterator) PointAt(x, y int) *math3d.Vector3 {
+	p := tti.firstPoint.
+		AddV(tti.right.MultiplyV(tti.pxsize * float64(x))).
+		AddV(tti.up.MultiplyV(tti.pxsize * float64(y)))
+	return &p
+}
+
+// JitteredPointAt returns a point inside the pixel x, y. u and v in [0, 1)
+// select the point along the width and the height of the pixel.
+func (tti *TracingTargetIterator) JitteredPointAt(x, y int, u, v float64) *math3d.Vector3 {
+	p := tti.jitteredPoint(x, y, u, v)
+	return &p
+}
+
+// jitteredPoint returns the point JitteredPointAt does as a value, so the
+// rays of every sample don't allocate it
+func (tti *TracingTargetIterator) jitteredPoint(x, y int, u, v float64) math3d.Vector3 {
+	return tti.firstPoint.
+		AddV(tti.right.MultiplyV(tti.pxsize * (float64(x) + u - 0.5))).
+		AddV(tti.up.MultiplyV(tti.pxsize * (float64(y) + v - 0.5)))
+}
+
+// Ray returns the lightray that leaves the camera through the point of the
+// pixel x, y that u and v in [0, 1) select along its width and height
+func (tti *TracingTargetIterator) Ray(x, y int, u, v float64) math3d.LightRay {
+	if tti.camera.Projection == Equirectangular {
+		return tti.camera.panoramicRay(float64(x)+u, float64(y)+v, tti.width, tti.height)
+	}
+	p := tti.jitteredPoint(x, y, u, v)
+	return math3d.LightRay{Direction: p.SubtractV(tti.camera.FocalPoint).NormalizedV(), Source: p}
+}
+
+// RayDifferential returns the lightray that Ray returns, with the
+// differential of the lightrays through the same point of the pixels
+// right of it and below it
+func (tti *TracingTargetIterator) RayDifferential(x, y int, u, v float64) math3d.LightRay {
+	lr, differential := tti.RayAndDifferential(x, y, u, v)
+	lr.Differential = &differential
+	return lr
+}
+
+// RayAndDifferential returns the lightray that Ray returns and the
+// differential that RayDifferential gives it, apart, so that callers that
+// keep the differential elsewhere don't allocate it
+func (tti *TracingTargetIterator) RayAndDifferential(x, y int, u, v float64) (math3d.LightRay, math3d.Differential) {
+	lr := tti.Ray(x, y, u, v)
+	right, below := tti.Ray(x+1, y, u, v), tti.Ray(x, y+1, u, v)
+	return lr, math3d.Differential{
+		SourceX:    right.Source.SubtractV(lr.Source),
+		SourceY:    below.Source.SubtractV(lr.Source),
+		DirectionX: right.Direction.SubtractV(lr.Direction),
+		DirectionY: below.Direction.SubtractV(lr.Direction),
+	}
+}
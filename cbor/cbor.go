@@ -0,0 +1,358 @@
+// Package cbor encodes and decodes the values of scene files in CBOR
+// (RFC 8949), a binary format with the same data model as JSON that is
+// smaller and faster to parse. Numbers that are whole are encoded as
+// integers and the others as 32 bit floats when that loses nothing, which
+// makes the samples of heightfields and the points of curves take a
+// fraction of the space of their decimal text.
+//
+// Decoding gives the same values encoding/json gives when decoding into an
+// interface{}: map[string]interface{}, []interface{}, string, float64, bool
+// and nil, so the parsers of JSON objects can take them as they are. Byte
+// strings, which JSON doesn't have, decode as []byte.
+package cbor
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Major types of CBOR data items
+const (
+	majorUnsigned byte = iota
+	majorNegative
+	majorBytes
+	majorText
+	majorArray
+	majorMap
+	majorTag
+	majorSimple
+)
+
+// Simple values and floats, as the additional information of majorSimple
+const (
+	simpleFalse   byte = 20
+	simpleTrue    byte = 21
+	simpleNull    byte = 22
+	simpleFloat16 byte = 25
+	simpleFloat32 byte = 26
+	simpleFloat64 byte = 27
+)
+
+// maxDepth limits the nesting of the arrays and maps that are decoded
+const maxDepth = 1000
+
+// Marshal returns the CBOR encoding of v, which may hold maps with string
+// keys, slices, arrays, strings, numbers, bools, nil and pointers to them.
+// Map keys are sorted, so the same value always gives the same bytes.
+func Marshal(v interface{}) ([]byte, error) {
+	e := &encoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, majorSimple<<5|simpleNull)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, majorSimple<<5|simpleNull)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, majorSimple<<5|simpleTrue)
+		} else {
+			e.buf = append(e.buf, majorSimple<<5|simpleFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.integer(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.head(majorUnsigned, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return e.float(v.Float())
+	case reflect.String:
+		e.head(majorText, uint64(v.Len()))
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.buf = append(e.buf, majorSimple<<5|simpleNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.head(majorBytes, uint64(v.Len()))
+			if v.Kind() == reflect.Slice {
+				e.buf = append(e.buf, v.Bytes()...)
+				return nil
+			}
+			for i := 0; i < v.Len(); i++ {
+				e.buf = append(e.buf, byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+		e.head(majorArray, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("cbor: map keys must be strings, not %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			e.buf = append(e.buf, majorSimple<<5|simpleNull)
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		e.head(majorMap, uint64(len(keys)))
+		for _, k := range keys {
+			e.head(majorText, uint64(k.Len()))
+			e.buf = append(e.buf, k.String()...)
+			if err := e.encode(v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: can't encode a %s", v.Type())
+	}
+	return nil
+}
+
+// head appends the first bytes of a data item of the major type, holding
+// n in as few bytes as possible
+func (e *encoder) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, major<<5|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, major<<5|25)
+		e.bigEndian(n, 2)
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, major<<5|26)
+		e.bigEndian(n, 4)
+	default:
+		e.buf = append(e.buf, major<<5|27)
+		e.bigEndian(n, 8)
+	}
+}
+
+// bigEndian appends the size lowest bytes of n, the highest first
+func (e *encoder) bigEndian(n uint64, size int) {
+	for shift := 8 * (size - 1); shift >= 0; shift -= 8 {
+		e.buf = append(e.buf, byte(n>>uint(shift)))
+	}
+}
+
+func (e *encoder) integer(n int64) {
+	if n >= 0 {
+		e.head(majorUnsigned, uint64(n))
+	} else {
+		e.head(majorNegative, uint64(-(n + 1)))
+	}
+}
+
+// float appends f as an integer if it's whole, or else as the shortest
+// float that holds it exactly. It fails if f isn't finite, like JSON.
+func (e *encoder) float(f float64) error {
+	switch {
+	case math.IsNaN(f) || math.IsInf(f, 0):
+		return fmt.Errorf("cbor: %v isn't finite", f)
+	case f == math.Trunc(f) && math.Abs(f) < 1<<53 && !(f == 0 && math.Signbit(f)):
+		e.integer(int64(f))
+	case float64(float32(f)) == f:
+		e.buf = append(e.buf, majorSimple<<5|simpleFloat32)
+		e.bigEndian(uint64(math.Float32bits(float32(f))), 4)
+	default:
+		e.buf = append(e.buf, majorSimple<<5|simpleFloat64)
+		e.bigEndian(math.Float64bits(f), 8)
+	}
+	return nil
+}
+
+// Unmarshal returns the value encoded in data. Every number is a float64,
+// every map a map[string]interface{} and every array an []interface{}.
+// Like in JSON, numbers must be finite. Tags, undefined and indefinite
+// lengths aren't supported, and data must hold exactly one data item.
+func Unmarshal(data []byte) (interface{}, error) {
+	return unmarshal(&decoder{data: data})
+}
+
+// UnmarshalShared decodes data like Unmarshal, but the byte strings it
+// returns share the memory of data instead of being copied, such as the
+// pages of a file mapped into memory. data must not change while they're
+// in use.
+func UnmarshalShared(data []byte) (interface{}, error) {
+	return unmarshal(&decoder{data: data, shared: true})
+}
+
+func unmarshal(d *decoder) (interface{}, error) {
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("cbor: %d bytes after the value", len(d.data)-d.pos)
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+	// shared makes the byte strings slices of data
+	shared bool
+}
+
+func (d *decoder) decode(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("cbor: nested too deep")
+	}
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorUnsigned:
+		return float64(n), nil
+	case majorNegative:
+		return -1 - float64(n), nil
+	case majorBytes, majorText:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		if major == majorText {
+			return string(b), nil
+		}
+		if d.shared {
+			return b[:len(b):len(b)], nil
+		}
+		return append(make([]byte, 0, len(b)), b...), nil
+	case majorArray:
+		// Every item takes at least a byte, which bounds what's allocated
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, errTruncated
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case majorMap:
+		if n > uint64(len(d.data)-d.pos)/2 {
+			return nil, errTruncated
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("cbor: map keys must be strings")
+			}
+			if m[k], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case majorSimple:
+		switch info {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull:
+			return nil, nil
+		case simpleFloat16, simpleFloat32, simpleFloat64:
+			f := math.Float64frombits(n)
+			if info == simpleFloat16 {
+				f = float16(uint16(n))
+			} else if info == simpleFloat32 {
+				f = float64(math.Float32frombits(uint32(n)))
+			}
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("cbor: %v isn't finite", f)
+			}
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("cbor: unsupported data item %#x", major<<5|info)
+}
+
+var errTruncated = fmt.Errorf("cbor: unexpected end of data")
+
+// head reads the first bytes of a data item: its major type, additional
+// information and the number that follows, if any
+func (d *decoder) head() (major, info byte, n uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		b, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, digit := range b {
+			n = n<<8 | uint64(digit)
+		}
+		return major, info, n, nil
+	}
+	return 0, 0, 0, fmt.Errorf("cbor: unsupported data item %#x", b[0])
+}
+
+// next returns the next n bytes of the data
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// float16 returns the value of a half precision float
+func float16(bits uint16) float64 {
+	exponent := int(bits>>10) & 0x1f
+	mantissa := float64(bits & 0x3ff)
+	var f float64
+	switch exponent {
+	case 0:
+		f = math.Ldexp(mantissa, -24)
+	case 0x1f:
+		if mantissa == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mantissa+1024, exponent-25)
+	}
+	if bits&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
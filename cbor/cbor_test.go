@@ -0,0 +1,138 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	// The encodings are the ones of the examples of RFC 8949
+	tests := []struct {
+		value interface{}
+		hex   string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{2.0, "02"},
+		{0.5, "fa3f000000"},
+		{1.1, "fb3ff199999999999a"},
+		{math.Copysign(0, -1), "fa80000000"},
+		{"IETF", "6449455446"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]int{1, 2, 3}, "83010203"},
+		{map[string]interface{}{"b": []float64{2, 3}, "a": 1}, "a26161016162820203"},
+		{[]byte{1, 2}, "420102"},
+	}
+	for _, test := range tests {
+		want, _ := hex.DecodeString(test.hex)
+		got, err := Marshal(test.value)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%#v should encode as %x, not %x (%v)", test.value, want, got, err)
+		}
+	}
+	for _, invalid := range []interface{}{math.NaN(), math.Inf(-1), map[int]int{1: 2}, struct{}{}} {
+		if _, err := Marshal(invalid); err == nil {
+			t.Errorf("%#v shouldn't encode", invalid)
+		}
+	}
+}
+
+func TestUnmarshalGivesWhatJSONGives(t *testing.T) {
+	const text = `{"camera": {"fieldofview": 0.3490659, "up": {"x": 0, "y": 1, "z": -2}},
+		"shapes": [{"heights": [[0, 0.25], [1e10, -3.5]], "name": "ground", "twosided": false}],
+		"medium": null}`
+	var want interface{}
+	if err := json.Unmarshal([]byte(text), &want); err != nil {
+		t.Fatal(err)
+	}
+	data, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Unmarshal(data)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Decoding should give %v, not %v (%v)", want, got, err)
+	}
+}
+
+func TestUnmarshalFloat16(t *testing.T) {
+	for encoded, want := range map[string]float64{"f93c00": 1, "f97bff": 65504, "f90001": 5.960464477539063e-8, "f9c400": -4} {
+		data, _ := hex.DecodeString(encoded)
+		if got, err := Unmarshal(data); err != nil || got != want {
+			t.Errorf("%s should decode to %g, not %v (%v)", encoded, want, got, err)
+		}
+	}
+}
+
+func TestUnmarshalSharedByteStrings(t *testing.T) {
+	data, _ := Marshal(map[string]interface{}{"buffer": []byte{1, 2, 3}})
+	copied, _ := Unmarshal(data)
+	shared, err := UnmarshalShared(data)
+	if err != nil || !reflect.DeepEqual(shared, copied) {
+		t.Fatalf("Shared byte strings should decode to the same value, not %v (%v)", shared, err)
+	}
+	data[len(data)-1] = 9
+	if copied.(map[string]interface{})["buffer"].([]byte)[2] != 3 {
+		t.Error("Byte strings should be copied from the data")
+	}
+	buffer := shared.(map[string]interface{})["buffer"].([]byte)
+	if buffer[2] != 9 {
+		t.Error("Shared byte strings should be slices of the data")
+	}
+	if cap(buffer) != 3 {
+		t.Error("Appending to shared byte strings shouldn't write over the data after them")
+	}
+}
+
+func TestUnmarshalRejectsInvalidData(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"1903",               // truncated number
+		"6449",               // truncated string
+		"9bffffffffffffffff", // huge array
+		"a10102",             // integer key
+		"f97c00",             // infinity
+		"fb7ff8000000000000", // NaN
+		"c11a514b67b0",       // tag
+		"9f01ff",             // indefinite length
+		"0000",               // two values
+		"f7",                 // undefined
+	} {
+		data, _ := hex.DecodeString(encoded)
+		if v, err := Unmarshal(data); err == nil {
+			t.Errorf("%s shouldn't decode, it gave %v", encoded, v)
+		}
+	}
+	deep := append(bytes.Repeat([]byte{0x81}, maxDepth+1), 0)
+	if _, err := Unmarshal(deep); err == nil {
+		t.Error("Too deeply nested arrays shouldn't decode")
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	f.Add([]byte{0xa2, 0x61, 0x61, 0x01, 0x61, 0x62, 0x82, 0x02, 0xfa, 0x3f, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, err := Unmarshal(data)
+		if err != nil {
+			return
+		}
+		// Whatever decodes encodes back to bytes that decode the same
+		again, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, err := Unmarshal(again); err != nil || !reflect.DeepEqual(v, w) {
+			t.Errorf("%v encoded again decodes to %v (%v)", v, w, err)
+		}
+	})
+}
@@ -0,0 +1,356 @@
+// Package config reads the user configuration file and merges it with the
+// scene file and the command line into the options of a render.
+//
+// The configuration file is TOML. Its top level holds the options for
+// every render, and every [profile.NAME] table holds a named set of
+// options that can be selected for a render:
+//
+//	samples = 16
+//	workers = 4
+//	outputdir = "renders"
+//	colorspace = "srgb"
+//
+//	[profile.preview]
+//	samples = 1
+//	nice = true
+//
+// The keys are the ones of the "render" section of scene files (samples,
+// minsamples, adaptivethreshold, volumestep, shadowstep, seed, colorspace,
+// exposure, highlightclip, shadowclip, integrator, accelerator,
+// splitbudget, backfaces, rayoffset, maxdepth, mindepth, roulettedepth,
+// photons, photonradius, probeexponent, aorays, aodistance, stats, report,
+// maximagesize, imagememory, detail, shutter, indirectclamp,
+// outlierthreshold, wavelengths, toonbands, outlinewidth, transparent, crop
+// and cropframe)
+// plus workers, nice, lowpriority, cpulimit, halfbuffers, outputdir and
+// preview, which are named after the command line flags.
+//
+// Options are merged from lowest to highest precedence:
+//
+//  1. the built-in defaults
+//  2. the top level of the configuration file
+//  3. the "render" section of the scene file
+//  4. the selected profile
+//  5. the environment variables, such as GORAYTRACE_SAMPLES=64
+//  6. the -set flags, such as -set render.samples=64
+//  7. the other command line flags
+//
+// So the configuration file holds the user's defaults, scene files can
+// override them, and profiles and flags override both for a single render.
+// Render farms can change the quality of the scenes they render with the
+// environment or -set, without editing them.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// Options are the options of a render
+type Options struct {
+	// Settings are the render settings of the scene
+	Settings scene.Settings
+	// Workers is the number of goroutines rendering tiles, or 0 to use as
+	// many as CPUs the process may use
+	Workers int
+	// Nice makes the render leave CPU time to other programs
+	Nice bool
+	// LowPriority makes the operating system run the render at a lower
+	// priority than the other programs
+	LowPriority bool
+	// CPULimit is the most percent of the time of all the CPUs the render
+	// uses, or 0 to use as much as it can
+	CPULimit float64
+	// HalfBuffers makes progressive renders accumulate their samples in
+	// half precision, using a quarter of the memory
+	HalfBuffers bool
+	// OutputDir is the directory renders are saved to
+	OutputDir string
+	// Preview is the width in columns of the preview printed in the
+	// terminal, or 0 not to print it
+	Preview int
+}
+
+// Config holds the contents of a configuration file
+type Config struct {
+	defaults map[string]interface{}
+	profiles map[string]map[string]interface{}
+}
+
+// DefaultPath returns the path of the configuration file of the user,
+// goraytrace/config.toml in the user's configuration directory
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goraytrace", "config.toml")
+}
+
+// Load reads the configuration file at path
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	c, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Parse reads a configuration file, checking that every option in it is
+// known and has a valid value
+func Parse(r io.Reader) (*Config, error) {
+	root, err := parseTOML(r)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{defaults: root, profiles: make(map[string]map[string]interface{})}
+	if profiles, ok := root["profile"]; ok {
+		delete(root, "profile")
+		tables, ok := profiles.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("profile must be a table")
+		}
+		for name, table := range tables {
+			values, ok := table.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("profile.%s must be a table", name)
+			}
+			if err := apply(&Options{}, values); err != nil {
+				return nil, fmt.Errorf("profile.%s: %v", name, err)
+			}
+			c.profiles[name] = values
+		}
+	}
+	if err := apply(&Options{}, root); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Profiles returns the names of the profiles in the configuration
+func (c *Config) Profiles() []string {
+	names := make([]string, 0, len(c.profiles))
+	for name := range c.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the options of a render of the scene with the profile,
+// which may be empty, and the overrides, keyed like the configuration
+// file: the environment, the -set flags and the other flags given in the
+// command line, each one overriding the ones before. c may be nil if
+// there's no configuration file.
+func (c *Config) Resolve(s *scene.Scene, profile string, overrides ...map[string]interface{}) (Options, error) {
+	opts := Options{Settings: scene.DefaultSettings(), OutputDir: "."}
+	var defaults, selected map[string]interface{}
+	if c != nil {
+		defaults = c.defaults
+		selected = c.profiles[profile]
+	}
+	if profile != "" && selected == nil {
+		return opts, fmt.Errorf("there is no profile named %q", profile)
+	}
+	layers := append([]map[string]interface{}{defaults, sceneSettings(s), selected}, overrides...)
+	for _, layer := range layers {
+		if err := apply(&opts, layer); err != nil {
+			return opts, err
+		}
+	}
+	if err := opts.Settings.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// sceneSettings returns the render settings that the scene file of the
+// scene sets, keyed like the configuration file
+func sceneSettings(s *scene.Scene) map[string]interface{} {
+	marshaled, err := json.Marshal(s.Settings)
+	if err != nil {
+		panic(err)
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(marshaled, &all); err != nil {
+		panic(err)
+	}
+	set := make(map[string]interface{})
+	for k, v := range all {
+		if s.SetsSetting(k) {
+			set[k] = v
+		}
+	}
+	return set
+}
+
+// apply sets the options with the values, returning an error for unknown
+// keys and values of the wrong type
+func apply(opts *Options, values map[string]interface{}) error {
+	for k, v := range values {
+		var err error
+		switch k {
+		case "samples":
+			opts.Settings.Samples, err = toInt(v)
+		case "minsamples":
+			opts.Settings.MinSamples, err = toInt(v)
+		case "adaptivethreshold":
+			opts.Settings.AdaptiveThreshold, err = toFloat(v)
+		case "volumestep":
+			opts.Settings.VolumeStep, err = toFloat(v)
+		case "shadowstep":
+			opts.Settings.ShadowStep, err = toFloat(v)
+		case "seed":
+			var seed int
+			seed, err = toInt(v)
+			opts.Settings.Seed = uint64(seed)
+		case "colorspace":
+			opts.Settings.ColorSpace, err = toString(v)
+		case "exposure":
+			opts.Settings.Exposure, err = toFloat(v)
+		case "highlightclip":
+			opts.Settings.HighlightClip, err = toFloat(v)
+		case "shadowclip":
+			opts.Settings.ShadowClip, err = toFloat(v)
+		case "integrator":
+			opts.Settings.Integrator, err = toString(v)
+		case "accelerator":
+			opts.Settings.Accelerator, err = toString(v)
+		case "splitbudget":
+			opts.Settings.SplitBudget, err = toFloat(v)
+		case "backfaces":
+			opts.Settings.Backfaces, err = toString(v)
+		case "rayoffset":
+			opts.Settings.RayOffset, err = toFloat(v)
+		case "maxdepth":
+			opts.Settings.MaxDepth, err = toInt(v)
+		case "mindepth":
+			opts.Settings.MinDepth, err = toInt(v)
+		case "roulettedepth":
+			opts.Settings.RouletteDepth, err = toInt(v)
+		case "photons":
+			opts.Settings.Photons, err = toInt(v)
+		case "photonradius":
+			opts.Settings.PhotonRadius, err = toFloat(v)
+		case "probeexponent":
+			opts.Settings.ProbeExponent, err = toFloat(v)
+		case "aorays":
+			opts.Settings.AORays, err = toInt(v)
+		case "aodistance":
+			opts.Settings.AODistance, err = toFloat(v)
+		case "stats":
+			opts.Settings.Stats, err = toBool(v)
+		case "report":
+			opts.Settings.Report, err = toBool(v)
+		case "transparent":
+			opts.Settings.Transparent, err = toBool(v)
+		case "crop":
+			opts.Settings.Crop, err = toString(v)
+		case "cropframe":
+			opts.Settings.CropFrame, err = toBool(v)
+		case "maximagesize":
+			opts.Settings.MaxImageSize, err = toInt(v)
+		case "imagememory":
+			opts.Settings.ImageMemory, err = toFloat(v)
+		case "detail":
+			opts.Settings.Detail, err = toFloat(v)
+		case "shutter":
+			opts.Settings.Shutter, err = toFloat(v)
+		case "indirectclamp":
+			opts.Settings.IndirectClamp, err = toFloat(v)
+		case "outlierthreshold":
+			opts.Settings.OutlierThreshold, err = toFloat(v)
+		case "wavelengths":
+			opts.Settings.Wavelengths, err = toInt(v)
+		case "toonbands":
+			opts.Settings.ToonBands, err = toInt(v)
+		case "outlinewidth":
+			opts.Settings.OutlineWidth, err = toFloat(v)
+		case "workers":
+			opts.Workers, err = toInt(v)
+		case "nice":
+			opts.Nice, err = toBool(v)
+		case "lowpriority":
+			opts.LowPriority, err = toBool(v)
+		case "cpulimit":
+			if opts.CPULimit, err = toFloat(v); err == nil && !(opts.CPULimit >= 0 && opts.CPULimit <= 100) {
+				err = fmt.Errorf("the CPU limit must be a percent between 0 and 100")
+			}
+		case "halfbuffers":
+			opts.HalfBuffers, err = toBool(v)
+		case "outputdir":
+			opts.OutputDir, err = toString(v)
+		case "preview":
+			opts.Preview, err = toInt(v)
+		default:
+			return fmt.Errorf("unknown option %q", k)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case override:
+		if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func toInt(v interface{}) (int, error) {
+	f, err := toFloat(v)
+	if err != nil || f != math.Trunc(f) || f < 0 || f > math.MaxInt32 {
+		return 0, fmt.Errorf("%v is not a non negative integer", v)
+	}
+	return int(f), nil
+}
+
+func toBool(v interface{}) (bool, error) {
+	if o, ok := v.(override); ok {
+		if b, err := strconv.ParseBool(string(o)); err == nil {
+			return b, nil
+		}
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v is not true or false", v)
+	}
+	return b, nil
+}
+
+func toString(v interface{}) (string, error) {
+	if o, ok := v.(override); ok {
+		return string(o), nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%v is not a string", v)
+	}
+	return s, nil
+}
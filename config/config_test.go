@@ -0,0 +1,156 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+const testScene = `{
+	"camera": {
+		"up": {"x": 0, "y": 1, "z": 0},
+		"right": {"x": 1, "y": 0, "z": 0},
+		"towards": {"x": 0, "y": 0, "z": 1},
+		"focalpoint": {"x": 0, "y": 0, "z": -1},
+		"fieldofview": 1.5,
+		"viewplanedistance": 1
+	},
+	"lights": [],
+	"shapes": [],
+	"render": {"samples": 8}
+}`
+
+const testConfig = `
+# Defaults for every render
+samples = 64
+workers = 2
+colorspace = "srgb" # the scene may override it
+outputdir = 'renders'
+
+[profile.draft]
+samples = 2
+nice = true
+preview = 80
+`
+
+func loadTestScene(t *testing.T) *scene.Scene {
+	s, _, err := scene.ParseScene([]byte(testScene), scene.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profiles := c.Profiles(); len(profiles) != 1 || profiles[0] != "draft" {
+		t.Errorf("Expected the draft profile, got %v", profiles)
+	}
+	invalid := []string{
+		"sample = 4",
+		"samples = -4",
+		"samples = \"many\"",
+		"nice = yes",
+		"[profile.fast]\nspeed = 11",
+		"colorspace = \"srgb",
+		"[profile",
+	}
+	for _, config := range invalid {
+		if _, err := Parse(strings.NewReader(config)); err == nil {
+			t.Errorf("%q should be invalid", config)
+		}
+	}
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	c, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := loadTestScene(t)
+
+	opts, err := c.Resolve(s, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The scene overrides the configuration
+	if opts.Settings.Samples != 8 || opts.Settings.ColorSpace != scene.SRGB || opts.Workers != 2 || opts.OutputDir != "renders" {
+		t.Errorf("Unexpected options without a profile: %+v", opts)
+	}
+
+	opts, err = c.Resolve(s, "draft", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The profile overrides the scene
+	if opts.Settings.Samples != 2 || !opts.Nice || opts.Preview != 80 || opts.Workers != 2 {
+		t.Errorf("Unexpected options with the draft profile: %+v", opts)
+	}
+
+	opts, err = c.Resolve(s, "draft", map[string]interface{}{"samples": 4, "outputdir": "/tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The flags override everything
+	if opts.Settings.Samples != 4 || opts.OutputDir != "/tmp" || !opts.Nice {
+		t.Errorf("Unexpected options with flags: %+v", opts)
+	}
+
+	if _, err := c.Resolve(s, "final", nil); err == nil {
+		t.Error("Resolving with an unknown profile should fail")
+	}
+	if _, err := c.Resolve(s, "", map[string]interface{}{"colorspace": "cmyk"}); err == nil {
+		t.Error("Resolving to invalid settings should fail")
+	}
+}
+
+func TestResolveWithoutConfig(t *testing.T) {
+	var c *Config
+	opts, err := c.Resolve(loadTestScene(t), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := scene.DefaultSettings()
+	if opts.Settings.Samples != 8 || opts.Settings.ColorSpace != defaults.ColorSpace || opts.OutputDir != "." {
+		t.Errorf("Unexpected options without a configuration file: %+v", opts)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	c, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := loadTestScene(t)
+	env := Environment([]string{"GORAYTRACE_SAMPLES=32", "GORAYTRACE_NICE=true", "HOME=/root", "GORAYTRACE_COLORSPACE=linear"})
+	set, err := Assignments([]string{"render.samples=64", "outputdir=/tmp/farm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := c.Resolve(s, "draft", env, set, map[string]interface{}{"workers": 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The environment overrides the profile, and -set the environment
+	if opts.Settings.Samples != 64 || opts.Settings.ColorSpace != scene.Linear || !opts.Nice || opts.OutputDir != "/tmp/farm" || opts.Workers != 8 {
+		t.Errorf("Unexpected options with overrides: %+v", opts)
+	}
+
+	if _, err := Assignments([]string{"render.samples"}); err == nil {
+		t.Error("An assignment needs a value")
+	}
+	invalid := []string{"samples=many", "nice=maybe", "speed=11", "render.seed=-1", "cpulimit=150"}
+	for _, assignment := range invalid {
+		set, err := Assignments([]string{assignment})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Resolve(s, "", set); err == nil {
+			t.Errorf("%q should be invalid", assignment)
+		}
+	}
+}
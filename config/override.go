@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvPrefix starts the names of the environment variables that set
+// options: GORAYTRACE_SAMPLES=64 sets samples, GORAYTRACE_OUTPUTDIR sets
+// outputdir and so on
+const EnvPrefix = "GORAYTRACE_"
+
+// override is an option given as text, in an environment variable or a
+// -set flag. Its value is parsed as the option it sets needs.
+type override string
+
+// Environment returns the options set by the environment variables in
+// environ, as os.Environ returns them, keyed like the configuration file.
+// Variables without EnvPrefix are ignored.
+func Environment(environ []string) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, variable := range environ {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], EnvPrefix) {
+			continue
+		}
+		values[strings.ToLower(strings.TrimPrefix(parts[0], EnvPrefix))] = override(parts[1])
+	}
+	return values
+}
+
+// Assignments returns the options set by assignments such as
+// "render.samples=64" or "nice=true", keyed like the configuration file.
+// The options of the "render" section of scene files may be prefixed with
+// "render.", as they are in scene files.
+func Assignments(assignments []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, assignment := range assignments {
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%q isn't an assignment such as render.samples=64", assignment)
+		}
+		values[strings.TrimPrefix(parts[0], "render.")] = override(parts[1])
+	}
+	return values, nil
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML that configuration files use: tables
+// with dotted names, and keys with string, integer, float or boolean
+// values. Comments start with # and arrays aren't supported. Integers
+// are returned as int64 and floats as float64.
+func parseTOML(r io.Reader) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	table := root
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") || strings.HasPrefix(text, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header %q", line, text)
+			}
+			var err error
+			table, err = openTable(root, strings.TrimSpace(text[1:len(text)-1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			continue
+		}
+		equals := strings.Index(text, "=")
+		if equals < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key := strings.TrimSpace(text[:equals])
+		if !validKey(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", line, key)
+		}
+		if _, exists := table[key]; exists {
+			return nil, fmt.Errorf("line %d: %s is defined twice", line, key)
+		}
+		value, err := parseValue(strings.TrimSpace(text[equals+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		table[key] = value
+	}
+	return root, scanner.Err()
+}
+
+// openTable returns the table with the dotted name, creating it and the
+// ones it's nested in if they don't exist
+func openTable(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	table := root
+	for _, part := range strings.Split(name, ".") {
+		part = strings.TrimSpace(part)
+		if !validKey(part) {
+			return nil, fmt.Errorf("invalid table name %q", name)
+		}
+		next, exists := table[part]
+		if !exists {
+			next = make(map[string]interface{})
+			table[part] = next
+		}
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is already a value", part)
+		}
+		table = nested
+	}
+	return table, nil
+}
+
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func parseValue(text string) (interface{}, error) {
+	switch {
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") || strings.Contains(text[1:len(text)-1], "'") {
+			return nil, fmt.Errorf("invalid string %s", text)
+		}
+		return text[1 : len(text)-1], nil
+	}
+	number := strings.Replace(text, "_", "", -1)
+	if i, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %s", text)
+}
+
+// stripComment returns the line without the comment at its end, if any,
+// leaving the # inside strings alone
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
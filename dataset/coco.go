@@ -0,0 +1,73 @@
+package dataset
+
+import "sort"
+
+// COCO is a set of annotated renders in the format of the COCO dataset,
+// which most object detection and segmentation tools read. The objects
+// are its categories, by their names, and each object a render shows is
+// an annotation of it.
+type COCO struct {
+	Images      []COCOImage      `json:"images"`
+	Annotations []COCOAnnotation `json:"annotations"`
+	Categories  []COCOCategory   `json:"categories"`
+}
+
+// COCOImage is a render of a COCO set
+type COCOImage struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// COCOAnnotation is an object a render of a COCO set shows
+type COCOAnnotation struct {
+	ID         int `json:"id"`
+	ImageID    int `json:"image_id"`
+	CategoryID int `json:"category_id"`
+	// BBox is the bounding box of the pixels of the object, and Area how
+	// many pixels it has
+	BBox         [4]int `json:"bbox"`
+	Area         int    `json:"area"`
+	Segmentation RLE    `json:"segmentation"`
+	// IsCrowd is 1 for the annotations whose segmentation is encoded, as
+	// COCO tools expect of them
+	IsCrowd int `json:"iscrowd"`
+	// Box is the bounding box of the object in the scene, which COCO tools
+	// ignore
+	Box Box3D `json:"bbox_3d"`
+}
+
+// COCOCategory is an object of the scene the renders of a COCO set show
+type COCOCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// NewCOCO returns the COCO set of the annotated renders, numbered from 1
+// in their order. The categories are the names of the objects, sorted.
+func NewCOCO(annotations []*Annotation) *COCO {
+	c := &COCO{Images: []COCOImage{}, Annotations: []COCOAnnotation{}, Categories: []COCOCategory{}}
+	names := make(map[string]int)
+	for _, a := range annotations {
+		for _, o := range a.Objects {
+			names[o.Name] = 0
+		}
+	}
+	for name := range names {
+		c.Categories = append(c.Categories, COCOCategory{Name: name})
+	}
+	sort.Slice(c.Categories, func(i, j int) bool { return c.Categories[i].Name < c.Categories[j].Name })
+	for i := range c.Categories {
+		c.Categories[i].ID = i + 1
+		names[c.Categories[i].Name] = i + 1
+	}
+	for i, a := range annotations {
+		c.Images = append(c.Images, COCOImage{ID: i + 1, FileName: a.Image, Width: a.Width, Height: a.Height})
+		for _, o := range a.Objects {
+			c.Annotations = append(c.Annotations, COCOAnnotation{ID: len(c.Annotations) + 1, ImageID: i + 1,
+				CategoryID: names[o.Name], BBox: o.BBox, Area: o.Pixels, Segmentation: o.Segmentation, IsCrowd: 1, Box: o.Box})
+		}
+	}
+	return c
+}
@@ -0,0 +1,404 @@
+// Package dataset varies a scene at random within ranges, the lights, the
+// materials and the pose of the camera, and annotates renders with what
+// objects they show and where, to make synthetic datasets for training
+// machine learning models with domain randomization.
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	stdimg "image"
+	"io/ioutil"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Range is the range of a value, from its minimum to its maximum
+type Range [2]float64
+
+// at returns the value of the range at the uniform number u in [0, 1)
+func (r *Range) at(u float64) float64 {
+	return r[0] + (r[1]-r[0])*u
+}
+
+// validate returns an error if the range isn't finite and sorted, or it
+// goes below min
+func (r *Range) validate(min float64) error {
+	if !(r[0] <= r[1]) || math.IsInf(r[0], 0) || math.IsInf(r[1], 0) {
+		return fmt.Errorf("the range must be finite and go from the minimum to the maximum")
+	}
+	if r[0] < min {
+		return fmt.Errorf("the range can't go below %g", min)
+	}
+	return nil
+}
+
+// Lights are the ranges the lights are varied within
+type Lights struct {
+	// Intensity scales the intensity of every light, nothing if nil
+	Intensity *Range `json:"intensity,omitempty"`
+	// Offset is how far along each axis the lights may move
+	Offset float64 `json:"offset,omitempty"`
+}
+
+// Materials are the ranges the materials of the shapes are varied within
+type Materials struct {
+	// Albedo is the range of each channel of the albedos of the materials,
+	// which keep theirs if it's nil
+	Albedo *Range `json:"albedo,omitempty"`
+}
+
+// Camera is the range of poses of the camera
+type Camera struct {
+	// Orbit is the range of degrees the camera turns around the center of
+	// the shapes, about its up vector, nothing if nil
+	Orbit *Range `json:"orbit,omitempty"`
+	// Offset is how far along each axis the camera may move after turning
+	Offset float64 `json:"offset,omitempty"`
+	// FoV is the range of the vertical field of view in degrees, which
+	// stays as it is if it's nil
+	FoV *Range `json:"fov,omitempty"`
+}
+
+// Spec describes a dataset: how many variations of a scene it has and what
+// varies between them. The same spec always makes the same variations.
+type Spec struct {
+	// Count is the number of variations
+	Count     int        `json:"count"`
+	Seed      uint64     `json:"seed"`
+	Lights    *Lights    `json:"lights,omitempty"`
+	Materials *Materials `json:"materials,omitempty"`
+	Camera    *Camera    `json:"camera,omitempty"`
+}
+
+// Load returns the spec in the JSON file at path
+func Load(path string) (*Spec, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	if err := json.Unmarshal(bytes, spec); err != nil {
+		return nil, err
+	}
+	return spec, spec.Validate()
+}
+
+// Validate returns an error if the variations of the spec can't be made
+func (spec *Spec) Validate() error {
+	if spec.Count < 1 {
+		return fmt.Errorf("there must be at least 1 variation")
+	}
+	if l := spec.Lights; l != nil {
+		if l.Intensity != nil {
+			if err := l.Intensity.validate(0); err != nil {
+				return fmt.Errorf("lights: intensity: %v", err)
+			}
+		}
+		if !(l.Offset >= 0) || math.IsInf(l.Offset, 1) {
+			return fmt.Errorf("lights: the offset must be finite and non negative")
+		}
+	}
+	if m := spec.Materials; m != nil && m.Albedo != nil {
+		if err := m.Albedo.validate(0); err != nil {
+			return fmt.Errorf("materials: albedo: %v", err)
+		}
+	}
+	if c := spec.Camera; c != nil {
+		if c.Orbit != nil {
+			if err := c.Orbit.validate(math.Inf(-1)); err != nil {
+				return fmt.Errorf("camera: orbit: %v", err)
+			}
+		}
+		if !(c.Offset >= 0) || math.IsInf(c.Offset, 1) {
+			return fmt.Errorf("camera: the offset must be finite and non negative")
+		}
+		if c.FoV != nil {
+			if err := c.FoV.validate(0); err != nil || !(c.FoV[0] > 0 && c.FoV[1] < 180) {
+				return fmt.Errorf("camera: the field of view must be between 0 and 180 degrees")
+			}
+		}
+	}
+	return nil
+}
+
+// Vary returns the variation of the scene at index, a copy of it with its
+// lights, materials and camera varied as the spec says. The copy is made
+// by parsing the scene file of the scene again, so the files it refers to,
+// such as meshes, are read again.
+func (spec *Spec) Vary(s *scene.Scene, index int) (*scene.Scene, error) {
+	rng := sampling.New(spec.Seed, uint64(index))
+	data, err := s.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if spec.Lights != nil {
+		lights, _ := m["lights"].([]interface{})
+		for _, l := range lights {
+			spec.Lights.vary(l.(map[string]interface{}), rng)
+		}
+	}
+	if spec.Materials != nil && spec.Materials.Albedo != nil {
+		shapes, _ := m["shapes"].([]interface{})
+		nodes, _ := m["nodes"].([]interface{})
+		varyMaterials(append(shapes, nodes...), *spec.Materials.Albedo, rng)
+	}
+	if data, err = json.Marshal(m); err != nil {
+		return nil, err
+	}
+	varied, _, err := scene.ParseScene(data, scene.Lenient)
+	if err != nil {
+		return nil, err
+	}
+	// The settings may not all be the ones of the scene file, such as the
+	// ones given in the command line
+	varied.Settings = s.Settings
+	if spec.Camera != nil {
+		spec.Camera.vary(varied, rng)
+	}
+	return varied, nil
+}
+
+// vary varies the light in the map of a light
+func (l *Lights) vary(m map[string]interface{}, rng *sampling.Rand) {
+	if l.Intensity != nil {
+		scale := l.Intensity.at(rng.Float64())
+		for _, key := range []string{"intensity", "radiance", "irradiance"} {
+			if color, ok := m[key].(map[string]interface{}); ok {
+				for channel, value := range color {
+					if v, ok := value.(float64); ok {
+						color[channel] = v * scale
+					}
+				}
+			}
+		}
+	}
+	if position, ok := m["position"].(map[string]interface{}); ok && l.Offset > 0 {
+		for _, axis := range []string{"x", "y", "z"} {
+			if v, ok := position[axis].(float64); ok {
+				position[axis] = v + l.Offset*(2*rng.Float64()-1)
+			}
+		}
+	}
+}
+
+// varyMaterials gives the materials of the shapes in the maps, and of the
+// shapes of delayed shapes and of the nodes of the scene graph among them,
+// albedos of channels within the range, in the order of the scene file
+func varyMaterials(maps []interface{}, albedo Range, rng *sampling.Rand) {
+	for _, value := range maps {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if mat, ok := m["material"].(map[string]interface{}); ok {
+			if _, ok := mat["albedo"]; ok {
+				mat["albedo"] = map[string]interface{}{
+					"r": albedo.at(rng.Float64()), "g": albedo.at(rng.Float64()), "b": albedo.at(rng.Float64())}
+			}
+		}
+		if source, ok := m["shape"]; ok {
+			varyMaterials([]interface{}{source}, albedo, rng)
+		}
+		shapes, _ := m["shapes"].([]interface{})
+		children, _ := m["children"].([]interface{})
+		varyMaterials(append(shapes, children...), albedo, rng)
+	}
+}
+
+// vary turns and moves the camera of the scene, and changes its field of
+// view
+func (c *Camera) vary(s *scene.Scene, rng *sampling.Rand) {
+	cam := &s.Camera
+	if c.Orbit != nil && len(s.Shapes) > 0 {
+		bounds := *s.Shapes[0].Bounds()
+		for _, sh := range s.Shapes[1:] {
+			bounds = *bounds.Union(sh.Bounds())
+		}
+		center := bounds.Min.AddV(bounds.Max).MultiplyV(0.5)
+		turn := math3d.AxisAngle(cam.Up, c.Orbit.at(rng.Float64())*math.Pi/180)
+		cam.FocalPoint = center.AddV(turn.Rotate(cam.FocalPoint.SubtractV(center)))
+		cam.Right, cam.Towards = turn.Rotate(cam.Right), turn.Rotate(cam.Towards)
+	}
+	if c.Offset > 0 {
+		cam.FocalPoint = cam.FocalPoint.AddV(math3d.Vector3{
+			X: c.Offset * (2*rng.Float64() - 1), Y: c.Offset * (2*rng.Float64() - 1), Z: c.Offset * (2*rng.Float64() - 1)})
+	}
+	if c.FoV != nil {
+		cam.SetFieldOfView(c.FoV.at(rng.Float64()))
+	}
+}
+
+// Object is an object an annotated render shows
+type Object struct {
+	// ID is the value of the pixels of the object in the mask
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// BBox is the bounding box of the pixels of the object: the column and
+	// the row of its top left corner, its width and its height
+	BBox [4]int `json:"bbox"`
+	// Pixels is how many pixels of the mask are of the object
+	Pixels int `json:"pixels"`
+	// Segmentation is the mask of the object alone
+	Segmentation RLE `json:"segmentation"`
+	// Box is the bounding box of the object in the scene
+	Box Box3D `json:"box"`
+}
+
+// RLE is a mask of width x height run-length encoded as COCO does without
+// compressing it: the lengths of the runs of pixels, column after column,
+// alternating between the ones out of the mask and the ones in it, starting
+// with the ones out of it
+type RLE struct {
+	// Size is the height and the width of the mask
+	Size   [2]int `json:"size"`
+	Counts []int  `json:"counts"`
+}
+
+// Box3D is the bounding box of an object in world space, aligned with the
+// axes, and where its corners are seen
+type Box3D struct {
+	Min math3d.Vector3 `json:"min"`
+	Max math3d.Vector3 `json:"max"`
+	// Corners are the columns and rows where the 8 corners of the box are
+	// seen in the render, the ones with the minimum x first, then with
+	// the minimum y and then with the minimum z. They're left out if any
+	// of them is behind the camera.
+	Corners [][2]float64 `json:"corners,omitempty"`
+}
+
+// Annotation is what objects a render shows and where
+type Annotation struct {
+	// Image and Mask are the files of the render and of the mask
+	Image  string `json:"image"`
+	Mask   string `json:"mask"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Objects are the objects the mask has some pixels of, by their IDs
+	Objects []Object `json:"objects"`
+}
+
+// Annotate returns the annotation of a render of width x height of the
+// scene, without the names of its files, and its segmentation mask: the
+// ID of the object of every pixel, or 0 where the background shows. The
+// objects are the shapes, by their names, and the object of a pixel is the
+// one the object matte of TraceCryptomatte says covers the most of it, if
+// it covers at least half. The bounding boxes of the objects in the scene
+// are the ones of all the shapes with their names.
+func Annotate(s *scene.Scene, width, height int) (*Annotation, *stdimg.Gray16) {
+	objects := s.TraceCryptomatte(width, height)[0]
+	ids := make(map[float32]int, len(objects.Names))
+	for i, name := range objects.Names {
+		ids[image.CryptomatteID(name)] = i + 1
+	}
+	mask := stdimg.NewGray16(stdimg.Rect(0, 0, width, height))
+	found := make([]Object, len(objects.Names))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			ranks := objects.Ranks[y*width+x]
+			if len(ranks) == 0 || ranks[0].Coverage < 0.5 {
+				continue
+			}
+			id := ids[ranks[0].ID]
+			mask.Pix[mask.PixOffset(x, y)], mask.Pix[mask.PixOffset(x, y)+1] = uint8(id>>8), uint8(id)
+			o := &found[id-1]
+			if o.Pixels == 0 {
+				o.BBox = [4]int{x, y, x + 1, y + 1}
+			}
+			o.Pixels++
+			o.BBox[0], o.BBox[1] = minInt(o.BBox[0], x), minInt(o.BBox[1], y)
+			o.BBox[2], o.BBox[3] = maxInt(o.BBox[2], x+1), maxInt(o.BBox[3], y+1)
+		}
+	}
+	a := &Annotation{Width: width, Height: height, Objects: []Object{}}
+	for i, o := range found {
+		if o.Pixels == 0 {
+			continue
+		}
+		o.ID, o.Name = i+1, objects.Names[i]
+		o.BBox[2], o.BBox[3] = o.BBox[2]-o.BBox[0], o.BBox[3]-o.BBox[1]
+		o.Segmentation = encode(mask, uint16(o.ID))
+		o.Box = box(s, o.Name, width, height)
+		a.Objects = append(a.Objects, o)
+	}
+	return a, mask
+}
+
+// encode returns the run-length encoding of the pixels of the mask with
+// the ID
+func encode(mask *stdimg.Gray16, id uint16) RLE {
+	width, height := mask.Rect.Dx(), mask.Rect.Dy()
+	rle := RLE{Size: [2]int{height, width}}
+	run, in := 0, false
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if (mask.Gray16At(x, y).Y == id) != in {
+				rle.Counts = append(rle.Counts, run)
+				run, in = 0, !in
+			}
+			run++
+		}
+	}
+	rle.Counts = append(rle.Counts, run)
+	return rle
+}
+
+// box returns the bounding box of the shapes of the scene with the name,
+// with its corners in a render of width x height if they're all in front
+// of the camera
+func box(s *scene.Scene, name string, width, height int) Box3D {
+	var bounds *math3d.AABB
+	for i, sh := range s.Shapes {
+		if shape.NameOf(sh, i) != name {
+			continue
+		}
+		if bounds == nil {
+			bounds = sh.Bounds()
+		} else {
+			bounds = bounds.Union(sh.Bounds())
+		}
+	}
+	b := Box3D{Min: bounds.Min, Max: bounds.Max}
+	for i := 0; i < 8; i++ {
+		corner := bounds.Min
+		if i&4 != 0 {
+			corner.X = bounds.Max.X
+		}
+		if i&2 != 0 {
+			corner.Y = bounds.Max.Y
+		}
+		if i&1 != 0 {
+			corner.Z = bounds.Max.Z
+		}
+		x, y, ok := s.Camera.Project(&corner, width, height)
+		if !ok {
+			b.Corners = nil
+			break
+		}
+		b.Corners = append(b.Corners, [2]float64{x, y})
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,165 @@
+package dataset
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func twoBalls() *scene.Scene {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Name: "left", Position: math3d.Vector3{X: -0.3, Z: 3}, Radius: 0.2,
+		Material: &material.Lambertian{Albedo: image.White}})
+	s.AddShape(&shape.Sphere{Name: "right", Position: math3d.Vector3{X: 0.3, Z: 3}, Radius: 0.2})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	return s
+}
+
+func TestVary(t *testing.T) {
+	s := twoBalls()
+	spec := &Spec{Count: 2, Seed: 7,
+		Lights:    &Lights{Intensity: &Range{0.5, 2}, Offset: 1},
+		Materials: &Materials{Albedo: &Range{0.2, 0.8}},
+		Camera:    &Camera{Orbit: &Range{-30, 30}, Offset: 0.1, FoV: &Range{30, 50}}}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := spec.Vary(s, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := spec.Vary(s, 0)
+	second, _ := spec.Vary(s, 1)
+	if !reflect.DeepEqual(first.Camera, again.Camera) || !reflect.DeepEqual(first.Lights, again.Lights) {
+		t.Error("The same variation should always be the same")
+	}
+	if reflect.DeepEqual(first.Camera, second.Camera) || reflect.DeepEqual(first.Lights, second.Lights) {
+		t.Error("Different variations should vary")
+	}
+
+	light := first.Lights[0].(*lighting.PointLight)
+	if scale := light.Intensity.R; scale < 0.5 || scale > 2 || light.Intensity.G != scale {
+		t.Errorf("The intensity should be scaled within the range, not to %v", light.Intensity)
+	}
+	if offset := light.Position.SubtractV(math3d.Vector3{Y: 3}); offset.X > 1 || offset.X < -1 || offset == (math3d.Vector3{}) {
+		t.Errorf("The light should move up to 1 along each axis, not by %v", offset)
+	}
+	albedo := first.Shapes[0].(*shape.Sphere).Material.(*material.Lambertian).Albedo
+	if albedo.R < 0.2 || albedo.R > 0.8 || albedo == image.White {
+		t.Errorf("The albedo should be within the range, not %v", albedo)
+	}
+	if first.Shapes[1].(*shape.Sphere).Material != nil {
+		t.Error("Shapes without materials should keep the default one")
+	}
+	// The camera turns around the center of the spheres, at the same
+	// distance from it, give or take the offset
+	center := math3d.Vector3{Z: 3}
+	distance := first.Camera.FocalPoint.SubtractV(center).Abs()
+	if original := s.Camera.FocalPoint.SubtractV(center).Abs(); distance < original-0.2 || distance > original+0.2 {
+		t.Errorf("The camera should orbit at a distance of about %g, not %g", original, distance)
+	}
+	if s.Shapes[0].(*shape.Sphere).Material.(*material.Lambertian).Albedo != image.White || s.Lights[0].(*lighting.PointLight).Intensity != image.White {
+		t.Error("Varying the scene shouldn't change it")
+	}
+
+	for _, invalid := range []Spec{
+		{},
+		{Count: 1, Lights: &Lights{Intensity: &Range{-1, 1}}},
+		{Count: 1, Materials: &Materials{Albedo: &Range{1, 0}}},
+		{Count: 1, Camera: &Camera{FoV: &Range{10, 180}}},
+		{Count: 1, Camera: &Camera{Offset: -1}},
+	} {
+		if invalid.Validate() == nil {
+			t.Errorf("%+v shouldn't be valid", invalid)
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	a, mask := Annotate(twoBalls(), 32, 32)
+	if len(a.Objects) != 2 || a.Objects[0].Name != "left" || a.Objects[1].Name != "right" {
+		t.Fatalf("Both spheres should be annotated, not %+v", a.Objects)
+	}
+	for _, o := range a.Objects {
+		x, y, w, h := o.BBox[0], o.BBox[1], o.BBox[2], o.BBox[3]
+		if w <= 0 || h <= 0 || o.Pixels > w*h {
+			t.Errorf("The bounding box of %s should hold its %d pixels, not %v", o.Name, o.Pixels, o.BBox)
+		}
+		// The box is tight around the pixels of the object in the mask
+		count := 0
+		for py := 0; py < 32; py++ {
+			for px := 0; px < 32; px++ {
+				if int(mask.Gray16At(px, py).Y) != o.ID {
+					continue
+				}
+				count++
+				if px < x || px >= x+w || py < y || py >= y+h {
+					t.Errorf("The pixel %d, %d of %s is out of its bounding box %v", px, py, o.Name, o.BBox)
+				}
+			}
+		}
+		if count != o.Pixels {
+			t.Errorf("%s should have %d pixels in the mask, not %d", o.Name, o.Pixels, count)
+		}
+		// Decoding the segmentation gives back the pixels of the object
+		pixel, in := 0, false
+		for _, run := range o.Segmentation.Counts {
+			for end := pixel + run; pixel < end; pixel++ {
+				if px, py := pixel/32, pixel%32; (int(mask.Gray16At(px, py).Y) == o.ID) != in {
+					t.Fatalf("The segmentation of %s doesn't match the mask at %d, %d", o.Name, px, py)
+				}
+			}
+			in = !in
+		}
+		if pixel != 32*32 || o.Segmentation.Size != [2]int{32, 32} {
+			t.Errorf("The segmentation of %s should cover the 32x32 mask, not %d pixels of %v", o.Name, pixel, o.Segmentation.Size)
+		}
+		// The corners of the box are seen around the pixels of the object
+		if len(o.Box.Corners) != 8 {
+			t.Fatalf("The 8 corners of the box of %s should be seen, not %v", o.Name, o.Box.Corners)
+		}
+		minX, maxX := o.Box.Corners[0][0], o.Box.Corners[0][0]
+		for _, c := range o.Box.Corners {
+			minX, maxX = math.Min(minX, c[0]), math.Max(maxX, c[0])
+		}
+		if minX > float64(x) || maxX < float64(x+w-1) {
+			t.Errorf("The corners of the box of %s should be around its pixels %v, not %v", o.Name, o.BBox, o.Box.Corners)
+		}
+	}
+	if a.Objects[0].Box.Min != (math3d.Vector3{X: -0.5, Y: -0.2, Z: 2.8}) {
+		t.Errorf("Unexpected bounding box of the left sphere %+v", a.Objects[0].Box)
+	}
+	if left, right := a.Objects[0].BBox, a.Objects[1].BBox; left[0]+left[2] > right[0] {
+		t.Errorf("The left sphere should be left of the right one, not at %v and %v", left, right)
+	}
+	if mask.Gray16At(0, 0).Y != 0 {
+		t.Error("The background should be 0 in the mask")
+	}
+}
+
+func TestNewCOCO(t *testing.T) {
+	first, _ := Annotate(twoBalls(), 16, 16)
+	second := &Annotation{Image: "b.png", Width: 16, Height: 16, Objects: []Object{first.Objects[1]}}
+	first.Image = "a.png"
+	c := NewCOCO([]*Annotation{first, second})
+	if len(c.Images) != 2 || c.Images[1].ID != 2 || c.Images[1].FileName != "b.png" {
+		t.Errorf("Unexpected images %+v", c.Images)
+	}
+	if !reflect.DeepEqual(c.Categories, []COCOCategory{{ID: 1, Name: "left"}, {ID: 2, Name: "right"}}) {
+		t.Errorf("Unexpected categories %+v", c.Categories)
+	}
+	if len(c.Annotations) != 3 {
+		t.Fatalf("Expected an annotation per object of every image, not %+v", c.Annotations)
+	}
+	last := c.Annotations[2]
+	if last.ID != 3 || last.ImageID != 2 || last.CategoryID != 2 || last.Area != second.Objects[0].Pixels {
+		t.Errorf("Unexpected annotation %+v", last)
+	}
+}
@@ -0,0 +1,191 @@
+// Package exr writes images in the OpenEXR format, which compositors such
+// as Nuke and Natron read. Only what the renderer saves is written:
+// uncompressed scan lines or tiles of 32 bit float channels, with string
+// metadata.
+package exr
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// magic is the number every OpenEXR file starts with
+var magic = []byte{0x76, 0x2f, 0x31, 0x01}
+
+const (
+	// version is the version of the format, with no flags set
+	version = 2
+	// tiled is the flag of files of tiles instead of scan lines
+	tiled = 0x200
+	// longNames is the flag of files with attribute or channel names
+	// longer than 31 bytes
+	longNames = 0x400
+	// randomY is the line order of tiled files whose tiles are in any
+	// order
+	randomY = 2
+	// pixelFloat is the type of the channels of 32 bit floats
+	pixelFloat = 2
+)
+
+// Channel is a channel of an image, such as R or CryptoObject00.G, with
+// its values row after row
+type Channel struct {
+	Name   string
+	Values []float32
+}
+
+// Encode writes the image of width x height with the channels to w. The
+// attributes are written as string metadata of the header. The first row
+// of the channels is the top row of the image, as in image.Image.
+func Encode(w io.Writer, width, height int, channels []Channel, attributes map[string]string) error {
+	if width <= 0 || height <= 0 {
+		return errors.New("the image must have pixels")
+	}
+	// The channels are stored sorted by name
+	sorted := append([]Channel(nil), channels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	names := make([]string, len(sorted))
+	for i, c := range sorted {
+		if len(c.Values) != width*height {
+			return errors.New("every channel must have a value for every pixel")
+		}
+		names[i] = c.Name
+	}
+	header, err := header(width, height, names, attributes, nil)
+	if err != nil {
+		return err
+	}
+
+	// Every scan line is a block of its own, after the table of where
+	// they start
+	lineSize := 8 + 4*width*len(sorted)
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	start := uint64(len(header) + 8*height)
+	offsets := make([]byte, 0, 8*height)
+	for y := 0; y < height; y++ {
+		offsets = appendUint64(offsets, start+uint64(y*lineSize))
+	}
+	if _, err := bw.Write(offsets); err != nil {
+		return err
+	}
+	line := make([]byte, 0, lineSize)
+	for y := 0; y < height; y++ {
+		line = appendUint32(line[:0], uint32(y))
+		line = appendUint32(line, uint32(lineSize-8))
+		for _, c := range sorted {
+			for _, v := range c.Values[y*width : (y+1)*width] {
+				line = appendUint32(line, math.Float32bits(v))
+			}
+		}
+		if _, err := bw.Write(line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// header returns the header of an image of width x height with the
+// channels of the names, which must be sorted, and the attributes as
+// string metadata. tiles is the tile description of tiled images, whose
+// tiles may come in any order, and nil for the images of scan lines.
+func header(width, height int, names []string, attributes map[string]string, tiles []byte) ([]byte, error) {
+	if len(names) == 0 {
+		return nil, errors.New("the image must have channels")
+	}
+	flags := uint32(version)
+	if tiles != nil {
+		flags |= tiled
+	}
+	for i, name := range names {
+		if name == "" || i > 0 && name == names[i-1] {
+			return nil, errors.New("the channels must have different names")
+		}
+		if len(name) > 31 {
+			flags |= longNames
+		}
+	}
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+		if len(key) > 31 {
+			flags |= longNames
+		}
+	}
+	sort.Strings(keys)
+
+	var header []byte
+	header = append(header, magic...)
+	header = appendUint32(header, flags)
+	attribute := func(name, kind string, value []byte) {
+		header = append(header, name...)
+		header = append(header, 0)
+		header = append(header, kind...)
+		header = append(header, 0)
+		header = appendUint32(header, uint32(len(value)))
+		header = append(header, value...)
+	}
+	var list []byte
+	for _, name := range names {
+		list = append(list, name...)
+		list = append(list, 0)
+		list = appendUint32(list, pixelFloat)
+		// Not perceptually linear, and three reserved bytes
+		list = append(list, 0, 0, 0, 0)
+		list = appendUint32(list, 1)
+		list = appendUint32(list, 1)
+	}
+	list = append(list, 0)
+	attribute("channels", "chlist", list)
+	attribute("compression", "compression", []byte{0})
+	window := box(width, height)
+	attribute("dataWindow", "box2i", window)
+	attribute("displayWindow", "box2i", window)
+	if tiles != nil {
+		attribute("lineOrder", "lineOrder", []byte{randomY})
+		attribute("tiles", "tiledesc", tiles)
+	} else {
+		attribute("lineOrder", "lineOrder", []byte{0})
+	}
+	attribute("pixelAspectRatio", "float", float32s(1))
+	attribute("screenWindowCenter", "v2f", float32s(0, 0))
+	attribute("screenWindowWidth", "float", float32s(1))
+	for _, key := range keys {
+		attribute(key, "string", []byte(attributes[key]))
+	}
+	return append(header, 0), nil
+}
+
+// box returns the box2i value of the window of the pixels of an image of
+// width x height
+func box(width, height int) []byte {
+	var b []byte
+	for _, v := range []int{0, 0, width - 1, height - 1} {
+		b = appendUint32(b, uint32(v))
+	}
+	return b
+}
+
+// float32s returns the little endian bytes of the values
+func float32s(values ...float32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = appendUint32(b, math.Float32bits(v))
+	}
+	return b
+}
+
+// appendUint32 appends the little endian bytes of v to b
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// appendUint64 appends the little endian bytes of v to b
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
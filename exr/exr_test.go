@@ -0,0 +1,227 @@
+package exr
+
+import (
+	"bytes"
+	"encoding/binary"
+	stdimg "image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// decoded is what decode reads back of an uncompressed image
+type decoded struct {
+	attributes map[string][]byte
+	kinds      map[string]string
+	channels   []string
+	// values holds the values of every channel, row after row
+	values map[string][]float32
+}
+
+// decode reads the images that Encode writes
+func decode(t *testing.T, data []byte, width, height int) decoded {
+	d, r := decodeHeader(t, data)
+	for y := 0; y < height; y++ {
+		var offset uint64
+		binary.Read(r, binary.LittleEndian, &offset)
+		line := data[offset:]
+		if got := int(binary.LittleEndian.Uint32(line)); got != y {
+			t.Fatalf("The block of line %d says it's line %d", y, got)
+		}
+		line = line[8:]
+		for _, c := range d.channels {
+			for x := 0; x < width; x++ {
+				d.values[c] = append(d.values[c], math.Float32frombits(binary.LittleEndian.Uint32(line)))
+				line = line[4:]
+			}
+		}
+	}
+	return d
+}
+
+// decodeTiles reads the images that TiledWriter writes
+func decodeTiles(t *testing.T, data []byte, width, height, tileSize int) decoded {
+	d, r := decodeHeader(t, data)
+	for _, c := range d.channels {
+		d.values[c] = make([]float32, width*height)
+	}
+	for row := 0; row < (height+tileSize-1)/tileSize; row++ {
+		for column := 0; column < (width+tileSize-1)/tileSize; column++ {
+			var offset uint64
+			binary.Read(r, binary.LittleEndian, &offset)
+			block := data[offset:]
+			if x, y := int(binary.LittleEndian.Uint32(block)), int(binary.LittleEndian.Uint32(block[4:])); x != column || y != row {
+				t.Fatalf("The block of tile %d, %d says it's tile %d, %d", column, row, x, y)
+			}
+			block = block[20:]
+			for y := row * tileSize; y < (row+1)*tileSize && y < height; y++ {
+				for _, c := range d.channels {
+					for x := column * tileSize; x < (column+1)*tileSize && x < width; x++ {
+						d.values[c][y*width+x] = math.Float32frombits(binary.LittleEndian.Uint32(block))
+						block = block[4:]
+					}
+				}
+			}
+		}
+	}
+	return d
+}
+
+// decodeHeader reads the header of an image, returning the reader of what
+// follows it
+func decodeHeader(t *testing.T, data []byte) (decoded, *bytes.Reader) {
+	if !bytes.HasPrefix(data, magic) {
+		t.Fatal("The file should start with the magic number")
+	}
+	d := decoded{attributes: map[string][]byte{}, kinds: map[string]string{}, values: map[string][]float32{}}
+	r := bytes.NewReader(data[8:])
+	text := func() string {
+		var s []byte
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				t.Fatal("The header ends too soon")
+			}
+			if b == 0 {
+				return string(s)
+			}
+			s = append(s, b)
+		}
+	}
+	for {
+		name := text()
+		if name == "" {
+			break
+		}
+		d.kinds[name] = text()
+		var size uint32
+		binary.Read(r, binary.LittleEndian, &size)
+		value := make([]byte, size)
+		r.Read(value)
+		d.attributes[name] = value
+	}
+	for list := d.attributes["channels"]; len(list) > 1; {
+		end := bytes.IndexByte(list, 0)
+		d.channels = append(d.channels, string(list[:end]))
+		list = list[end+17:]
+	}
+	return d, r
+}
+
+func TestEncode(t *testing.T) {
+	var buf bytes.Buffer
+	channels := []Channel{
+		{Name: "G", Values: []float32{1, 2, 3, 4, 5, 6}},
+		{Name: "A", Values: []float32{-1, 0.5, 0, 0, 0, 1e30}},
+	}
+	attributes := map[string]string{"comment": "rendered", strings.Repeat("long", 10): "name"}
+	if err := Encode(&buf, 3, 2, channels, attributes); err != nil {
+		t.Fatal(err)
+	}
+	if flags := binary.LittleEndian.Uint32(buf.Bytes()[4:]); flags != version|longNames {
+		t.Errorf("The version should be 2 with the flag of long names, not %#x", flags)
+	}
+	d := decode(t, buf.Bytes(), 3, 2)
+	if len(d.channels) != 2 || d.channels[0] != "A" || d.channels[1] != "G" {
+		t.Errorf("The channels should be stored sorted by name, not as %v", d.channels)
+	}
+	for _, c := range channels {
+		for i, v := range c.Values {
+			if d.values[c.Name][i] != v {
+				t.Fatalf("The values of %s should be %v, not %v", c.Name, c.Values, d.values[c.Name])
+			}
+		}
+	}
+	if string(d.attributes["comment"]) != "rendered" || d.kinds["comment"] != "string" {
+		t.Errorf("The attributes should be strings of the header, comment is a %s %q", d.kinds["comment"], d.attributes["comment"])
+	}
+	if window := d.attributes["dataWindow"]; !bytes.Equal(window, box(3, 2)) || binary.LittleEndian.Uint32(window[8:]) != 2 {
+		t.Errorf("The data window should go from 0, 0 to 2, 1, not %v", window)
+	}
+
+	for _, bad := range [][]Channel{
+		nil,
+		{{Name: "R", Values: []float32{1}}},
+		{{Name: "R", Values: make([]float32, 6)}, {Name: "R", Values: make([]float32, 6)}},
+	} {
+		if err := Encode(&buf, 3, 2, bad, nil); err == nil {
+			t.Errorf("%v shouldn't encode", bad)
+		}
+	}
+}
+
+func TestTiledWriter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "tiled.exr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewTiledWriter(f, 5, 3, 2, []string{"G", "A"}, map[string]string{"comment": "tiled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := func(channel string, x, y int) float32 {
+		if channel == "A" {
+			return -float32(y*5 + x)
+		}
+		return float32(y*5 + x)
+	}
+	tile := func(r stdimg.Rectangle) []Channel {
+		channels := []Channel{{Name: "A"}, {Name: "G"}}
+		for i, c := range channels {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					channels[i].Values = append(channels[i].Values, value(c.Name, x, y))
+				}
+			}
+		}
+		return channels
+	}
+	// The tiles come in any order, and the first one never does
+	for _, r := range []stdimg.Rectangle{stdimg.Rect(4, 2, 5, 3), stdimg.Rect(2, 0, 4, 2), stdimg.Rect(0, 2, 2, 3), stdimg.Rect(4, 0, 5, 2), stdimg.Rect(2, 2, 4, 3)} {
+		if err := w.WriteTile(r, tile(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []stdimg.Rectangle{stdimg.Rect(1, 0, 3, 2), stdimg.Rect(4, 2, 6, 4), stdimg.Rect(0, 0, 2, 2).Add(stdimg.Pt(0, 4))} {
+		if err := w.WriteTile(r, tile(r)); err == nil {
+			t.Errorf("%v isn't a tile of the image and shouldn't be written", r)
+		}
+	}
+	if err := w.WriteTile(stdimg.Rect(0, 0, 2, 2), tile(stdimg.Rect(0, 0, 2, 2))[:1]); err == nil {
+		t.Error("A tile without every channel shouldn't be written")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := binary.LittleEndian.Uint32(data[4:]); flags != version|tiled {
+		t.Errorf("The version should be 2 with the flag of tiles, not %#x", flags)
+	}
+	d := decodeTiles(t, data, 5, 3, 2)
+	if tiles := d.attributes["tiles"]; d.kinds["tiles"] != "tiledesc" || !bytes.Equal(tiles, []byte{2, 0, 0, 0, 2, 0, 0, 0, 0}) {
+		t.Errorf("The tiles should be described as 2x2 on one level, not %v", tiles)
+	}
+	if string(d.attributes["comment"]) != "tiled" {
+		t.Errorf("The attributes should be strings of the header, comment is %q", d.attributes["comment"])
+	}
+	for _, c := range []string{"A", "G"} {
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 5; x++ {
+				want := value(c, x, y)
+				if x < 2 && y < 2 {
+					want = 0
+				}
+				if got := d.values[c][y*5+x]; got != want {
+					t.Errorf("The value of %s at %d, %d should be %v, not %v", c, x, y, want, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package exr
+
+import (
+	"errors"
+	stdimg "image"
+	"io"
+	"math"
+	"sort"
+)
+
+// TiledWriter writes an image in tiles as they are done, in any order, so
+// that images too large to hold in memory, such as the float pixels of
+// very large renders, can be saved while they are rendered. It only holds
+// the tile being written. It mustn't be used from several goroutines at
+// once.
+type TiledWriter struct {
+	w                       io.WriteSeeker
+	width, height, tileSize int
+	// columns is the number of tiles in every row of tiles
+	columns int
+	// names are the names of the channels, sorted
+	names []string
+	// table is where the table of the offsets of the tiles starts, and
+	// offsets where every tile starts, 0 for those not written yet
+	table   int64
+	offsets []uint64
+	// end is where the next tile is written
+	end int64
+}
+
+// NewTiledWriter writes to w the header of an image of width x height in
+// tiles of tileSize x tileSize pixels, with the channels of the names and
+// the attributes as string metadata. The tiles in the last row and column
+// may be smaller, as those of render.Tiles are.
+func NewTiledWriter(w io.WriteSeeker, width, height, tileSize int, names []string, attributes map[string]string) (*TiledWriter, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("the image must have pixels")
+	}
+	if tileSize <= 0 {
+		return nil, errors.New("the tiles must have pixels")
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	// One level of tiles, rounded down, since there are no mipmaps
+	tiles := append(appendUint32(appendUint32(nil, uint32(tileSize)), uint32(tileSize)), 0)
+	header, err := header(width, height, sorted, attributes, tiles)
+	if err != nil {
+		return nil, err
+	}
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	columns, rows := (width+tileSize-1)/tileSize, (height+tileSize-1)/tileSize
+	t := &TiledWriter{w: w, width: width, height: height, tileSize: tileSize, columns: columns, names: sorted,
+		table: start + int64(len(header)), offsets: make([]uint64, columns*rows)}
+	// The offsets are written once every tile is
+	if _, err := w.Write(append(header, make([]byte, 8*len(t.offsets))...)); err != nil {
+		return nil, err
+	}
+	t.end = t.table + int64(8*len(t.offsets))
+	return t, nil
+}
+
+// WriteTile writes the tile with the values of every channel, row after
+// row of the tile. The tile must be one of the grid of tiles of the image.
+// Writing a tile again replaces it, but its pixels take space in the file
+// twice.
+func (t *TiledWriter) WriteTile(tile stdimg.Rectangle, channels []Channel) error {
+	column, row := tile.Min.X/t.tileSize, tile.Min.Y/t.tileSize
+	grid := stdimg.Rect(column*t.tileSize, row*t.tileSize, (column+1)*t.tileSize, (row+1)*t.tileSize)
+	if tile.Empty() || tile != grid.Intersect(stdimg.Rect(0, 0, t.width, t.height)) {
+		return errors.New("the tile isn't one of the tiles of the image")
+	}
+	values := make(map[string][]float32, len(channels))
+	for _, c := range channels {
+		if len(c.Values) != tile.Dx()*tile.Dy() {
+			return errors.New("every channel must have a value for every pixel of the tile")
+		}
+		values[c.Name] = c.Values
+	}
+	if len(values) != len(t.names) {
+		return errors.New("the tile must have every channel of the image once")
+	}
+	block := make([]byte, 0, 20+4*len(values)*tile.Dx()*tile.Dy())
+	block = appendUint32(block, uint32(column))
+	block = appendUint32(block, uint32(row))
+	// The level, which is always the first one
+	block = appendUint32(block, 0)
+	block = appendUint32(block, 0)
+	block = appendUint32(block, uint32(4*len(values)*tile.Dx()*tile.Dy()))
+	for y := 0; y < tile.Dy(); y++ {
+		for _, name := range t.names {
+			v, ok := values[name]
+			if !ok {
+				return errors.New("the tile must have every channel of the image once")
+			}
+			for _, v := range v[y*tile.Dx() : (y+1)*tile.Dx()] {
+				block = appendUint32(block, math.Float32bits(v))
+			}
+		}
+	}
+	if _, err := t.w.Write(block); err != nil {
+		return err
+	}
+	t.offsets[row*t.columns+column] = uint64(t.end)
+	t.end += int64(len(block))
+	return nil
+}
+
+// Close writes the tiles not written yet with every value 0, so that the
+// image is complete, and the table of where the tiles are. It doesn't
+// close the writer of the image.
+func (t *TiledWriter) Close() error {
+	for i, offset := range t.offsets {
+		if offset != 0 {
+			continue
+		}
+		x, y := (i%t.columns)*t.tileSize, (i/t.columns)*t.tileSize
+		tile := stdimg.Rect(x, y, x+t.tileSize, y+t.tileSize).Intersect(stdimg.Rect(0, 0, t.width, t.height))
+		channels := make([]Channel, len(t.names))
+		for c, name := range t.names {
+			channels[c] = Channel{Name: name, Values: make([]float32, tile.Dx()*tile.Dy())}
+		}
+		if err := t.WriteTile(tile, channels); err != nil {
+			return err
+		}
+	}
+	table := make([]byte, 0, 8*len(t.offsets))
+	for _, offset := range t.offsets {
+		table = appendUint64(table, offset)
+	}
+	if _, err := t.w.Seek(t.table, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := t.w.Write(table); err != nil {
+		return err
+	}
+	_, err := t.w.Seek(t.end, io.SeekStart)
+	return err
+}
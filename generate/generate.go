@@ -0,0 +1,219 @@
+// Package generate creates random scenes from a seed and a few
+// parameters, such as how many shapes and lights they have. They are
+// meant for benchmarks, fuzzing and showing how renders scale with the
+// size of the scene, not for looking good.
+package generate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Params are the parameters of a generated scene. The same parameters
+// always generate the same scene.
+type Params struct {
+	Seed uint64
+	// Spheres and Curves are the number of shapes of each kind
+	Spheres, Curves int
+	// PointLights and SphereLights are the number of lights of each kind
+	PointLights, SphereLights int
+	// Lambertian, Glossy and Subsurface weigh how often the shapes get
+	// each kind of material
+	Lambertian, Glossy, Subsurface float64
+	// Size is the side of the cube the shapes are scattered in
+	Size float64
+}
+
+// DefaultParams returns the parameters of a hundred spheres lit by a few
+// lights
+func DefaultParams() Params {
+	return Params{
+		Seed:         1,
+		Spheres:      100,
+		PointLights:  2,
+		SphereLights: 1,
+		Lambertian:   1,
+		Glossy:       1,
+		Subsurface:   0.2,
+		Size:         2}
+}
+
+// ParseParams returns the parameters described by spec, a comma separated
+// list of key=value pairs such as "spheres=1000,curves=50,seed=3". The
+// keys are the names of the fields in lowercase and the missing ones keep
+// their default values.
+func ParseParams(spec string) (Params, error) {
+	p := DefaultParams()
+	counts := map[string]*int{"spheres": &p.Spheres, "curves": &p.Curves, "pointlights": &p.PointLights, "spherelights": &p.SphereLights}
+	weights := map[string]*float64{"lambertian": &p.Lambertian, "glossy": &p.Glossy, "subsurface": &p.Subsurface, "size": &p.Size}
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return p, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		var err error
+		if key == "seed" {
+			p.Seed, err = strconv.ParseUint(value, 10, 64)
+		} else if count, ok := counts[key]; ok {
+			*count, err = strconv.Atoi(value)
+		} else if weight, ok := weights[key]; ok {
+			*weight, err = strconv.ParseFloat(value, 64)
+		} else {
+			return p, fmt.Errorf("unknown parameter %q", key)
+		}
+		if err != nil {
+			return p, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return p, p.Validate()
+}
+
+// Validate returns an error if a scene can't be generated with the
+// parameters
+func (p *Params) Validate() error {
+	if p.Spheres < 0 || p.Curves < 0 || p.PointLights < 0 || p.SphereLights < 0 {
+		return fmt.Errorf("the number of shapes and lights can't be negative")
+	}
+	weights := []float64{p.Lambertian, p.Glossy, p.Subsurface}
+	for _, w := range weights {
+		if !(w >= 0) || math.IsInf(w, 1) {
+			return fmt.Errorf("the weights of the materials must be finite and non negative")
+		}
+	}
+	if p.Spheres+p.Curves > 0 && p.Lambertian+p.Glossy+p.Subsurface == 0 {
+		return fmt.Errorf("at least one material must have a positive weight")
+	}
+	if !(p.Size > 0) || math.IsInf(p.Size, 1) {
+		return fmt.Errorf("the size must be positive and finite")
+	}
+	return nil
+}
+
+// Scene returns the scene generated with the parameters. The shapes are
+// scattered in a cube centered at the origin, the lights around it on
+// the side of the camera and the camera is far enough to see the whole
+// cube. It panics if the parameters aren't valid.
+func Scene(p Params) *scene.Scene {
+	if err := p.Validate(); err != nil {
+		panic(err)
+	}
+	g := &generator{Params: p, rng: sampling.New(p.Seed, 0)}
+	s := scene.New()
+	half := p.Size / 2
+	s.Camera.FocalPoint = math3d.Vector3{Z: -half - 1.1*half*math.Sqrt2/math.Tan(s.Camera.FoV/2)}
+	// The shapes get smaller as there are more of them, so they keep
+	// filling about the same space
+	radius := 0.3 * p.Size / math.Cbrt(float64(p.Spheres+p.Curves+1))
+	for i := 0; i < p.Spheres; i++ {
+		s.AddShape(&shape.Sphere{
+			Position: g.point(),
+			Radius:   radius * (0.3 + 0.7*g.rng.Float64()),
+			Name:     fmt.Sprint("sphere", i),
+			Material: g.material(radius)})
+	}
+	for i := 0; i < p.Curves; i++ {
+		s.AddShape(g.curve(radius*4, fmt.Sprint("curve", i)))
+	}
+	lights := p.PointLights + p.SphereLights
+	for i := 0; i < p.PointLights; i++ {
+		s.AddLight(&lighting.PointLight{Position: g.lightPosition(), Intensity: *g.tint().Divide(float64(lights))})
+	}
+	for i := 0; i < p.SphereLights; i++ {
+		position := g.lightPosition()
+		lightRadius := 0.1 * p.Size
+		// As bright as a point light at the distance of the center of the
+		// cube, where the sphere fills a cone of sin(a) = radius / distance
+		sine := lightRadius / position.Abs()
+		s.AddLight(&lighting.SphereLight{
+			Position: position,
+			Radius:   lightRadius,
+			Radiance: *g.tint().Divide(float64(lights) * math.Pi * sine * sine)})
+	}
+	return s
+}
+
+// generator holds the state of the generation of a scene
+type generator struct {
+	Params
+	rng *sampling.Rand
+}
+
+// point returns a random point of the cube of the scene
+func (g *generator) point() math3d.Vector3 {
+	return math3d.Vector3{
+		X: (g.rng.Float64() - 0.5) * g.Size,
+		Y: (g.rng.Float64() - 0.5) * g.Size,
+		Z: (g.rng.Float64() - 0.5) * g.Size}
+}
+
+// direction returns a random unit vector
+func (g *generator) direction() math3d.Vector3 {
+	z := 2*g.rng.Float64() - 1
+	r, phi := math.Sqrt(1-z*z), 2*math.Pi*g.rng.Float64()
+	return math3d.Vector3{X: r * math.Cos(phi), Y: r * math.Sin(phi), Z: z}
+}
+
+// lightPosition returns a random point at twice the size of the scene
+// from its center, on the side of the camera
+func (g *generator) lightPosition() math3d.Vector3 {
+	d := g.direction()
+	d.Z = -math.Abs(d.Z)
+	return d.MultiplyV(2 * g.Size)
+}
+
+// tint returns a random color close to white
+func (g *generator) tint() *image.Color {
+	return &image.Color{R: 0.8 + 0.2*g.rng.Float64(), G: 0.8 + 0.2*g.rng.Float64(), B: 0.8 + 0.2*g.rng.Float64()}
+}
+
+// albedo returns a random color that isn't too dark nor too bright
+func (g *generator) albedo() image.Color {
+	return image.Color{R: 0.2 + 0.7*g.rng.Float64(), G: 0.2 + 0.7*g.rng.Float64(), B: 0.2 + 0.7*g.rng.Float64()}
+}
+
+// material returns a random material, chosen with the weights of the
+// parameters, for shapes of about the given size
+func (g *generator) material(size float64) material.Material {
+	choice := g.rng.Float64() * (g.Lambertian + g.Glossy + g.Subsurface)
+	switch {
+	case choice < g.Lambertian:
+		return &material.Lambertian{Albedo: g.albedo()}
+	case choice < g.Lambertian+g.Glossy:
+		return &material.Glossy{Albedo: g.albedo(), Exponent: math.Round(10 + 490*g.rng.Float64())}
+	default:
+		// Light goes through a good part of the shape
+		mfp := g.albedo()
+		return &material.Subsurface{Albedo: g.albedo(), MeanFreePath: *mfp.Multiply(0.2 * size)}
+	}
+}
+
+// curve returns a random curve of about the given length, such as a hair,
+// that tapers from its root to its tip
+func (g *generator) curve(length float64, name string) *shape.Curve {
+	c := &shape.Curve{Name: name, Material: g.material(length / 20)}
+	c.Points[0] = g.point()
+	heading := g.direction()
+	for i := 1; i < 4; i++ {
+		// Bending a bit at every control point
+		jitter := g.direction()
+		heading = heading.AddV(jitter.MultiplyV(0.5)).NormalizedV()
+		c.Points[i] = c.Points[i-1].AddV(heading.MultiplyV(length / 3))
+	}
+	root := length / 20
+	c.Widths = [4]float64{root, root * 2 / 3, root / 3, 0}
+	return c
+}
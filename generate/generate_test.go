@@ -0,0 +1,125 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/render"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+func TestScenesAreReproducible(t *testing.T) {
+	p := DefaultParams()
+	p.Spheres, p.Curves = 30, 10
+	a, b := Scene(p), Scene(p)
+	if len(a.Shapes) != 40 || len(a.Lights) != 3 {
+		t.Fatalf("Expected 40 shapes and 3 lights, got %d and %d", len(a.Shapes), len(a.Lights))
+	}
+	if !bytes.Equal(a.TraceScene(24, 24).Pix, b.TraceScene(24, 24).Pix) {
+		t.Error("The same parameters should generate the same scene")
+	}
+	p.Seed++
+	if bytes.Equal(a.TraceScene(24, 24).Pix, Scene(p).TraceScene(24, 24).Pix) {
+		t.Error("Another seed should generate another scene")
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	p, err := ParseParams("spheres=1000, curves=5,seed=9,glossy=0,size=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Spheres != 1000 || p.Curves != 5 || p.Seed != 9 || p.Glossy != 0 || p.Size != 4 || p.PointLights != DefaultParams().PointLights {
+		t.Errorf("Unexpected parameters %+v", p)
+	}
+	for _, spec := range []string{"spheres", "cubes=3", "spheres=-1", "size=0", "lambertian=0,glossy=0,subsurface=0", "seed=x"} {
+		if _, err := ParseParams(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	for _, spheres := range []int{10, 1000, 100000} {
+		p := DefaultParams()
+		p.Spheres = spheres
+		s := Scene(p)
+		s.Prepare()
+		b.Run(fmt.Sprint(spheres, "spheres"), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				render.Scene(context.Background(), s, 64, 64, render.Options{})
+			}
+		})
+	}
+}
+
+func FuzzScene(f *testing.F) {
+	f.Add(uint64(1), uint8(20), uint8(5), uint8(2), uint8(1))
+	f.Fuzz(func(t *testing.T, seed uint64, spheres, curves, pointLights, sphereLights uint8) {
+		p := DefaultParams()
+		p.Seed = seed
+		p.Spheres, p.Curves = int(spheres), int(curves)
+		p.PointLights, p.SphereLights = int(pointLights%8), int(sphereLights%8)
+		s := Scene(p)
+		for i, sh := range s.Shapes {
+			if b := sh.Bounds(); !b.Contains(b.Centroid()) {
+				t.Fatalf("Shape %d has invalid bounds %v", i, b)
+			}
+		}
+		// Tracing in parallel must give the same render, which also checks
+		// that tracing the scene doesn't panic
+		parallel, _ := render.Scene(context.Background(), s, 8, 8, render.Options{Workers: 2})
+		if !bytes.Equal(s.TraceScene(8, 8).Pix, parallel.Pix) {
+			t.Error("Tracing the tiles in parallel should give the same render")
+		}
+	})
+}
+
+func BenchmarkManyLights(b *testing.B) {
+	for _, lights := range []int{4, 100, 10000} {
+		p := DefaultParams()
+		p.PointLights, p.SphereLights = 0, lights
+		s := Scene(p)
+		s.Prepare()
+		b.Run(fmt.Sprint(lights, "lights"), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				render.Scene(context.Background(), s, 64, 64, render.Options{})
+			}
+		})
+	}
+}
+
+func BenchmarkSerialization(b *testing.B) {
+	p := DefaultParams()
+	p.Spheres, p.Curves = 10000, 1000
+	s := Scene(p)
+	encodings := []struct {
+		name      string
+		marshal   func() ([]byte, error)
+		unmarshal func([]byte) (*scene.Scene, []string, error)
+	}{
+		{"json", s.Marshal, func(data []byte) (*scene.Scene, []string, error) { return scene.ParseScene(data, scene.Strict) }},
+		{"cbor", s.MarshalCBOR, func(data []byte) (*scene.Scene, []string, error) { return scene.ParseSceneCBOR(data, scene.Strict) }},
+	}
+	for _, encoding := range encodings {
+		data, err := encoding.marshal()
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(encoding.name+"/marshal", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				encoding.marshal()
+			}
+			b.ReportMetric(float64(len(data)), "bytes")
+		})
+		b.Run(encoding.name+"/parse", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := encoding.unmarshal(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,11 +1,43 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"flag"
 	"fmt"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/ProjectMOA/goraytrace/animation"
+	"github.com/ProjectMOA/goraytrace/auth"
+	"github.com/ProjectMOA/goraytrace/bridge"
+	"github.com/ProjectMOA/goraytrace/bundle"
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/config"
+	"github.com/ProjectMOA/goraytrace/dataset"
+	"github.com/ProjectMOA/goraytrace/generate"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/internal/priority"
+	"github.com/ProjectMOA/goraytrace/netrender"
+	"github.com/ProjectMOA/goraytrace/pbrt"
+	"github.com/ProjectMOA/goraytrace/query"
+	"github.com/ProjectMOA/goraytrace/render"
 	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/sceneedit"
+	"github.com/ProjectMOA/goraytrace/shaderball"
+	"github.com/ProjectMOA/goraytrace/shape"
 )
 
 func paniciferr(err error) {
@@ -15,25 +47,1261 @@ func paniciferr(err error) {
 }
 
 func main() {
+	bridgeAddr := flag.String("bridge", "", "serve the render engine bridge protocol on this address instead of rendering a scene file")
+	bridgeRate := flag.Float64("bridgerate", 0, "token, scene and render messages a second every client of -bridge may send, by default unlimited")
+	bridgeBurst := flag.Int("bridgeburst", 10, "token, scene and render messages every client of -bridge may send at once, within -bridgerate")
+	bridgeMaxPixels := flag.Int("bridgemaxpixels", 0, "most pixels the frames -bridge renders may have, by default unlimited")
+	bridgeMaxSamples := flag.Int("bridgemaxsamples", 0, "most samples per pixel the scenes -bridge renders may take, by default unlimited")
+	bridgeMaxTime := flag.Duration("bridgemaxtime", 0, "longest a render of -bridge may take, by default unlimited")
+	bridgeMaxMemory := flag.Float64("bridgemaxmemory", 0, "most GiB of memory a scene of -bridge and a frame of it may take, by default unlimited")
+	coordinatorAddr := flag.String("coordinator", "", "serve the tiles of the render to workers on this address instead of rendering them")
+	dashboard := flag.Bool("dashboard", false, "also serve a dashboard of the progress of the workers and the frames to browsers on the address of -coordinator")
+	editAddr := flag.String("edit", "", "serve the scene for external editors to change it and watch its render on this address instead of rendering it")
+	workerURL := flag.String("worker", "", "render tiles for the coordinator at this URL instead of rendering a scene file")
+	workerMemory := flag.Float64("workermemory", 0, "only hand the tiles of the coordinator to workers with this many GiB of memory")
+	workerFeatures := flag.String("workerfeatures", "", "only hand the tiles of the coordinator to workers with these comma separated features, such as exr")
+	token := flag.String("token", os.Getenv("GORAYTRACE_TOKEN"), "token that -serve, -coordinator, -edit and -bridge require from their clients, and that -worker sends, by default the GORAYTRACE_TOKEN environment variable")
+	tlsCert := flag.String("tlscert", "", "serve -serve, -coordinator, -edit and -bridge over TLS with the certificate in this PEM file")
+	tlsKey := flag.String("tlskey", "", "key of the -tlscert certificate, in PEM")
+	tlsCA := flag.String("tlsca", "", "trust the certificates in this PEM file for -worker, such as the self-signed one of the coordinator")
+	strict := flag.Bool("strict", false, "fail on unknown keys and invalid values in the scene file instead of skipping them")
+	configPath := flag.String("config", config.DefaultPath(), "configuration file with the default options and the profiles")
+	profile := flag.String("profile", "", "profile of the configuration file to render with")
+	materialsPath := flag.String("materials", "", "render every material of this file, a material or a JSON object of them by name, on a shader ball, in name.png, and all of them in catalog.png, instead of rendering a scene file")
+	materialQuality := flag.String("materialquality", shaderball.Preview, "quality of the shader balls of -materials, draft, preview or final")
+	generated := flag.String("generate", "", "render a random scene with these parameters, such as \"spheres=1000,curves=50,seed=3\", instead of a scene file")
+	// These flags override the configuration file and the scene file
+	bake := flag.String("bake", "", "bake the lightmap of the shape with this name instead of rendering the camera view")
+	bakeSize := flag.Int("bakesize", 512, "width and height of the baked lightmap")
+	bakePadding := flag.Int("bakepadding", 2, "texels the baked lightmap is padded with around the surface")
+	animationPath := flag.String("animation", "", "render the frames of the animation in this file instead of a single image")
+	datasetPath := flag.String("dataset", "", "render the variations of the scene the dataset spec in this file describes, with their segmentation masks and annotations, also in the COCO format in sample.coco.json, instead of a single image")
+	frames := flag.String("frames", "", "frames of the animation to render, such as \"10-20\", by default all of them")
+	resume := flag.Bool("resume", false, "resume rendering the animation or the dataset from the last frame or sample saved in the output directory, or the render from its checkpoint")
+	serveAddr := flag.String("serve", "", "render progressively and serve a page to watch and control the render on this address")
+	checkpoint := flag.String("checkpoint", "", "render progressively, saving the samples taken so far to this file every -checkpointinterval")
+	checkpointInterval := flag.Duration("checkpointinterval", render.DefaultCheckpointInterval, "how often the checkpoint is saved")
+	cachePath := flag.String("cache", "", "save the scene to this binary scene cache, which renders with its meshes mapped from the file rather than read into memory and its BVH loaded rather than built, instead of rendering it")
+	pbrtPath := flag.String("pbrt", "", "write the scene in the PBRT-v4 format to this file, to check the render against pbrt, instead of rendering it")
+	dryRun := flag.Bool("dryrun", false, "estimate the time and memory the render takes, tracing a few of its pixels, instead of rendering it")
+	cryptomatte := flag.Bool("cryptomatte", false, "also save the object and material IDs of the render as cryptomatte mattes, in main.cryptomatte.exr")
+	autoExposure := flag.Bool("autoexposure", false, "expose the render by the exposure a quick prepass of it picks, bringing its log-average luminance to middle grey within the highlight and shadow clipping budgets of the settings, printing the exposure picked")
+	heatmap := flag.String("heatmap", "", "also save the luminance, samples or cost of every pixel, the work of the acceleration structure tracing it, in false colors, in main.luminance.png, main.samples.png or main.cost.png")
+	depth := flag.Bool("depth", false, "also save the depth of the camera view as main.depth.exr and main.depth.pfm")
+	pointCloud := flag.Bool("pointcloud", false, "also save the points the camera sees, in world space and in the colors of the render, as main.ply")
+	watch := flag.Bool("watch", false, "render progressively, rendering the scene file again every time it changes, only where the changes show when they can, until interrupted")
+	fly := flag.Bool("fly", false, "let the page served by -serve fly the camera through the scene, rendering again from every new viewpoint until interrupted")
+	annotate := flag.Bool("annotate", false, "also save the segmentation mask of the render, in main.mask.png, and the objects it shows with their masks and bounding boxes in the COCO format, in main.coco.json")
+	stereo := flag.Float64("stereo", 0, "render a rectified stereo pair of cameras this far apart, in main.left.png and main.right.png, with the ground truth disparity of the left image in main.disparity.pfm and where the right camera sees it in main.disparity.mask.png, instead of a single image")
+	lidarPath := flag.String("lidar", "", "scan the scene with the LiDAR scanner in this file and save what it measures as a point cloud, in main.lidar.ply, instead of rendering it")
+	daylightPath := flag.String("daylight", "", "measure the illuminance at the sensors of the grid in this file, in lux, and save it as a table, in main.daylight.csv, instead of rendering the scene")
+	viewFactors := flag.Int("viewfactors", 0, "compute the view factors between the surfaces of the shapes with the same names, and the sky, with this many rays leaving every surface, in main.viewfactors.csv, instead of rendering the scene")
+	saveEXR := flag.Bool("exr", false, "save the linear radiance of the render in main.exr, writing every tile as soon as it's traced so the render is never held in memory whole, instead of main.png")
+	svg := flag.Bool("svg", false, "also save the outlines of the hiddenline or toon integrator as a vector drawing, in main.svg")
+	bundlePath := flag.String("bundle", "", "pack the scene file and the files it refers to into this scene bundle, encrypted with -bundlekey and signed with -signkey if they're set, instead of rendering it")
+	bundleKey := flag.String("bundlekey", "", "file with the key in hexadecimal that -bundle encrypts the scene bundle with, and that the scene bundle rendered is decrypted with")
+	signKey := flag.String("signkey", "", "file with the key in hexadecimal that -bundle signs the scene bundle with, printing the key that verifies it")
+	verifyKey := flag.String("verifykey", "", "file with the key in hexadecimal that verifies the scene bundle rendered was signed with the signing key of whoever sent it")
+	newKey := flag.String("newkey", "", "write a random key in hexadecimal to this file, for -bundlekey or -signkey, instead of rendering")
+	timeout := flag.Duration("timeout", 0, "stop rendering after this long and save what is rendered by then, by default never")
+	var set assignments
+	flag.Var(&set, "set", "override an option, such as render.samples=64, and may be repeated. GORAYTRACE_SAMPLES=64 in the environment does the same")
+	flag.Int("preview", 0, "print a preview of the render this many columns wide in the terminal")
+	flag.Int("workers", 0, "number of goroutines rendering tiles, by default as many as CPUs the process may use")
+	flag.Bool("nice", false, "render in the background, leaving CPU time to other programs")
+	flag.Bool("lowpriority", false, "render at a lower priority than other programs, as the operating system schedules them")
+	flag.Float64("cpulimit", 0, "most percent of the time of all the CPUs the render uses, by default as much as it can")
+	flag.String("crop", "", "trace only this part of the render and save only it, as left,top,right,bottom in pixels, or in fractions of the width and height of the render if none is above 1")
+	flag.Bool("cropframe", false, "save renders with a crop window at full size, pasting the crop window over the previous render")
+	flag.Bool("halfbuffers", false, "accumulate the samples of progressive renders in half precision, using less memory")
+	flag.Int("samples", 1, "samples per pixel")
+	flag.String("colorspace", scene.Linear, "color space of the render, linear, srgb, rec709 or displayp3")
+	flag.String("integrator", scene.Direct, "how the light reaching the camera is computed, direct, bdpt, ao, fixedpath, toon or hiddenline")
+	flag.String("outputdir", ".", "directory the render is saved to")
+	flag.Parse()
+
+	// Interrupting the program stops the render like the timeout does, so
+	// what's rendered so far is saved
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	security := Security{Token: *token, CertFile: *tlsCert, KeyFile: *tlsKey}
+	if *bridgeAddr != "" {
+		l, err := auth.Listen(*bridgeAddr, *tlsCert, *tlsKey)
+		paniciferr(err)
+		server := &bridge.Server{Token: *token, Limits: bridge.Limits{Rate: *bridgeRate, Burst: *bridgeBurst, MaxPixels: *bridgeMaxPixels,
+			MaxSamples: *bridgeMaxSamples, MaxTime: *bridgeMaxTime, MaxMemory: uint64(*bridgeMaxMemory * (1 << 30))}}
+		paniciferr(server.Serve(l))
+		return
+	}
+	if *workerURL != "" {
+		workerOpts := render.Options{Workers: render.DefaultWorkers()}
+		if workers, ok := overridingFlags()["workers"].(int); ok {
+			workerOpts.Workers = workers
+		}
+		lowPriority, _ := overridingFlags()["lowpriority"].(bool)
+		cpuLimit, _ := overridingFlags()["cpulimit"].(float64)
+		background(&workerOpts, lowPriority, cpuLimit)
+		client, err := auth.Client(*token, *tlsCA)
+		if err != nil {
+			fmt.Println("Can't set up the worker: " + err.Error())
+			os.Exit(1)
+		}
+		worker := &netrender.Worker{Capabilities: netrender.LocalCapabilities(workerOpts.Workers), Client: client}
+		if err := worker.Work(ctx, *workerURL, workerOpts); err != nil {
+			fmt.Println("Can't work for the coordinator: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *materialsPath != "" {
+		opts := render.Options{Workers: render.DefaultWorkers()}
+		if workers, ok := overridingFlags()["workers"].(int); ok {
+			opts.Workers = workers
+		}
+		dir, _ := overridingFlags()["outputdir"].(string)
+		if dir == "" {
+			dir = "."
+		}
+		if err := RenderMaterials(ctx, *materialsPath, *materialQuality, dir, opts); err != nil {
+			fmt.Println("Can't render the materials: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *newKey != "" {
+		if err := SaveKey(*newKey); err != nil {
+			fmt.Println("Can't save the key: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	keys, err := loadKeys(*bundleKey, *signKey, *verifyKey)
+	if err != nil {
+		fmt.Println("Can't load the keys of the scene bundle: " + err.Error())
+		os.Exit(1)
+	}
+	if *bundlePath != "" {
+		if flag.NArg() < 1 {
+			fmt.Println("Need a scene file as a parameter!")
+			os.Exit(1)
+		}
+		if err := SaveBundle(flag.Arg(0), *bundlePath, keys); err != nil {
+			fmt.Println("Can't save the scene bundle: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Setting up a scene
-	if len(os.Args) < 2 {
+	myScene, err := setUpScene(*generated, *strict, keys)
+	if err != nil {
+		fmt.Println("Can't load the scene: " + err.Error())
+		os.Exit(1)
+	}
+
+	conf, err := config.Load(*configPath)
+	if os.IsNotExist(err) && !isFlagSet("config") {
+		// Users don't need a configuration file unless they ask for one
+		conf, err = nil, nil
+	}
+	if err != nil {
+		fmt.Println("Can't load the configuration: " + err.Error())
+		os.Exit(1)
+	}
+	assigned, err := config.Assignments(set)
+	if err != nil {
+		fmt.Println("Invalid options: " + err.Error())
+		os.Exit(1)
+	}
+	opts, err := conf.Resolve(myScene, *profile, config.Environment(os.Environ()), assigned, overridingFlags())
+	if err != nil {
+		fmt.Println("Invalid options: " + err.Error())
+		os.Exit(1)
+	}
+	myScene.Settings = opts.Settings
+	if *cachePath != "" {
+		if err := SaveCache(myScene, *cachePath); err != nil {
+			fmt.Println("Can't save the scene cache: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	// Scenes are rendered at 1000x1000, as RenderScene does
+	for _, r := range myScene.DownscaleImages(1000, 1000) {
+		fmt.Printf("Downscaled %s from %dx%d to %dx%d samples, from %s to %s\n",
+			r.Name, r.FromColumns, r.FromRows, r.Columns, r.Rows, megabytes(r.FromMemory), megabytes(r.Memory))
+	}
+
+	renderOpts := render.Options{Workers: opts.Workers, HalfBuffers: opts.HalfBuffers}
+	if opts.Nice {
+		renderOpts.Duty = render.NiceDuty
+	}
+	background(&renderOpts, opts.LowPriority, opts.CPULimit)
+	if isTerminal(os.Stderr) {
+		renderOpts.Progress = render.NewTerminalProgress(os.Stderr)
+	}
+	if *dryRun {
+		DryRun(myScene, renderOpts, os.Stdout)
+		return
+	}
+	if *pbrtPath != "" {
+		if err := ExportPBRT(myScene, *pbrtPath); err != nil {
+			fmt.Println("Can't export the scene: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *autoExposure {
+		exposure, err := render.EstimateExposure(ctx, myScene, 1000, 1000, render.Options{Workers: renderOpts.Workers})
+		if err != nil {
+			fmt.Println("Can't pick the exposure: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Exposed by %+.2f stops\n", exposure)
+		myScene.Settings.Exposure = exposure
+	}
+	paniciferr(os.MkdirAll(opts.OutputDir, 0755))
+	if *bake != "" {
+		if err := BakeShape(ctx, myScene, *bake, *bakeSize, *bakePadding, opts.OutputDir); err != nil {
+			fmt.Println("Can't bake the lightmap: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *editAddr != "" {
+		if err := Edit(myScene, *editAddr, security); err != nil {
+			fmt.Println("Can't serve the scene to editors: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *coordinatorAddr != "" {
+		requires := netrender.Requirements{Memory: uint64(*workerMemory * (1 << 30))}
+		if *workerFeatures != "" {
+			requires.Features = strings.Split(*workerFeatures, ",")
+		}
+		if err := Coordinate(myScene, *coordinatorAddr, *animationPath, *frames, opts.OutputDir, requires, security, *dashboard); err != nil {
+			fmt.Println("Can't coordinate the render: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *animationPath != "" {
+		if err := RenderAnimation(ctx, myScene, *animationPath, *frames, *resume, opts.OutputDir, renderOpts); err != nil {
+			fmt.Println("Can't render the animation: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *datasetPath != "" {
+		if err := RenderDataset(ctx, myScene, *datasetPath, *resume, opts.OutputDir, renderOpts); err != nil {
+			fmt.Println("Can't render the dataset: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *stereo != 0 {
+		if err := RenderStereo(ctx, myScene, filepath.Join(opts.OutputDir, "main"), *stereo, renderOpts); err != nil {
+			fmt.Println("Can't render the stereo pair: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *lidarPath != "" {
+		if err := SaveScan(myScene, *lidarPath, filepath.Join(opts.OutputDir, "main")); err != nil {
+			fmt.Println("Can't scan the scene: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *daylightPath != "" {
+		if err := SaveIlluminance(myScene, *daylightPath, filepath.Join(opts.OutputDir, "main")); err != nil {
+			fmt.Println("Can't measure the illuminance: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *viewFactors != 0 {
+		if err := SaveViewFactors(myScene, *viewFactors, filepath.Join(opts.OutputDir, "main")); err != nil {
+			fmt.Println("Can't compute the view factors: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *saveEXR {
+		if err := StreamEXR(ctx, myScene, filepath.Join(opts.OutputDir, "main"), renderOpts); err != nil {
+			fmt.Println("Can't render: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if *heatmap != "" && render.AOVChannels(*heatmap) != 1 {
+		fmt.Printf("Can't save a heatmap of %q, only of luminance, samples or cost\n", *heatmap)
+		os.Exit(1)
+	}
+	if *fly && *serveAddr == "" {
+		fmt.Println("Can't fly the camera without a page to fly it from, set -serve too")
+		os.Exit(1)
+	}
+	if *serveAddr != "" || *checkpoint != "" || *watch {
+		progressive := Progressive{Serve: *serveAddr, Security: security, Checkpoint: *checkpoint, Interval: *checkpointInterval, Resume: *resume, Fly: *fly}
+		if *watch {
+			if isFlagSet("generate") {
+				fmt.Println("Can't watch a generated scene, only a scene file")
+				os.Exit(1)
+			}
+			progressive.Watch = flag.Arg(0)
+			progressive.Reload = func() (*scene.Scene, error) {
+				reloaded, err := setUpScene(*generated, *strict, keys)
+				if err != nil {
+					return nil, err
+				}
+				opts, err := conf.Resolve(reloaded, *profile, config.Environment(os.Environ()), assigned, overridingFlags())
+				if err != nil {
+					return nil, err
+				}
+				reloaded.Settings = opts.Settings
+				if *autoExposure {
+					// The exposure is picked once, so that the renders of
+					// the changes are exposed alike
+					reloaded.Settings.Exposure = myScene.Settings.Exposure
+				}
+				reloaded.DownscaleImages(1000, 1000)
+				return reloaded, nil
+			}
+		}
+		if err := RenderProgressively(ctx, myScene, filepath.Join(opts.OutputDir, "main"), progressive, renderOpts); err != nil {
+			fmt.Println("Can't render: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	var rendered *image.Image
+	if *heatmap != "" {
+		rendered, err = RenderAnalysis(ctx, myScene, filepath.Join(opts.OutputDir, "main"), renderOpts, *heatmap)
+	} else {
+		rendered, err = RenderScene(ctx, myScene, filepath.Join(opts.OutputDir, "main"), renderOpts, true)
+	}
+	if err != nil {
+		fmt.Println("The render was stopped: " + err.Error())
+	}
+	if *cryptomatte {
+		if err := SaveCryptomatte(myScene, filepath.Join(opts.OutputDir, "main")); err != nil {
+			fmt.Println("Can't save the cryptomatte: " + err.Error())
+		}
+	}
+	if *depth || *pointCloud {
+		if err := SaveDepth(myScene, filepath.Join(opts.OutputDir, "main"), rendered, *depth, *pointCloud); err != nil {
+			fmt.Println("Can't save the depth: " + err.Error())
+		}
+	}
+	if *annotate {
+		if err := render.SaveAnnotation(myScene, 1000, 1000, filepath.Join(opts.OutputDir, "main")); err != nil {
+			fmt.Println("Can't save the annotation: " + err.Error())
+		}
+	}
+	if *svg {
+		if err := SaveLines(myScene, filepath.Join(opts.OutputDir, "main")); err != nil {
+			fmt.Println("Can't save the line drawing: " + err.Error())
+		}
+	}
+	if opts.Preview > 0 {
+		paniciferr(rendered.WriteANSI(os.Stdout, opts.Preview))
+	}
+}
+
+// setUpScene returns the scene generated with the parameters, or if there
+// are none, the one in the scene file or the scene bundle given as an
+// argument, read with the keys
+func setUpScene(generated string, strict bool, keys bundle.Keys) (*scene.Scene, error) {
+	if isFlagSet("generate") {
+		params, err := generate.ParseParams(generated)
+		if err != nil {
+			return nil, err
+		}
+		return generate.Scene(params), nil
+	}
+	if flag.NArg() < 1 {
 		fmt.Println("Need a scene file as a parameter!")
 		os.Exit(1)
 	}
+	mode := scene.Lenient
+	if strict {
+		mode = scene.Strict
+	}
+	parse := scene.ParseSceneFile
+	bundled, err := bundle.IsBundle(flag.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	if bundled {
+		parse = func(path string, mode scene.ParseMode) (*scene.Scene, []string, error) {
+			return bundle.Open(path, mode, keys)
+		}
+	}
+	myScene, warnings, err := parse(flag.Arg(0), mode)
+	for _, w := range warnings {
+		fmt.Println("Warning: " + w)
+	}
+	return myScene, err
+}
+
+// overridingFlags returns the values of the flags given in the command
+// line that override the configuration, keyed by their names
+func overridingFlags() map[string]interface{} {
+	overriding := map[string]bool{"preview": true, "workers": true, "nice": true, "lowpriority": true, "cpulimit": true, "halfbuffers": true, "crop": true, "cropframe": true, "samples": true, "colorspace": true, "integrator": true, "outputdir": true}
+	values := make(map[string]interface{})
+	flag.Visit(func(f *flag.Flag) {
+		if overriding[f.Name] {
+			values[f.Name] = f.Value.(flag.Getter).Get()
+		}
+	})
+	return values
+}
+
+// assignments are the values of a flag that may be repeated
+type assignments []string
+
+func (a *assignments) String() string {
+	return strings.Join(*a, " ")
+}
+
+func (a *assignments) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+// background lowers the priority of the process if lowPriority is set, and
+// lowers the duty cycle of the options to keep the workers under the CPU
+// limit, in percent, if it's set and their duty cycle doesn't already
+func background(opts *render.Options, lowPriority bool, cpuLimit float64) {
+	if lowPriority {
+		if err := priority.Lower(); err != nil {
+			fmt.Println("Can't lower the priority: " + err.Error())
+		}
+	}
+	if cpuLimit > 0 {
+		if duty := render.CPULimitDuty(cpuLimit, opts.Workers); opts.Duty <= 0 || opts.Duty >= 1 || duty < opts.Duty {
+			opts.Duty = duty
+		}
+	}
+}
+
+// isTerminal returns whether the file is a terminal rather than a file or
+// a pipe, where progress lines would only be noise
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-	myScene := scene.LoadSceneFile(os.Args[1])
-	RenderScene(myScene, "main", true)
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
 }
 
 // RenderScene renders the scene passed as a parameter and saves the image
-// with the name
-func RenderScene(aScene *scene.Scene, name string, showTime bool) {
+// with the name. If the context is done first, the part rendered by then
+// is saved and returned with its error.
+func RenderScene(ctx context.Context, aScene *scene.Scene, name string, opts render.Options, showTime bool) (*image.Image, error) {
+	before := aScene.Statistics()
 	start := time.Now()
-	render := aScene.TraceScene(1000, 1000)
+	build := prepare(aScene)
+	rendered, err := render.Scene(ctx, aScene, 1000, 1000, opts)
 	elapsed := time.Since(start)
 	if showTime {
-		fmt.Printf("Rendered in: %s", elapsed)
+		fmt.Printf("Rendered in: %s\n", elapsed)
+	}
+
+	cropOutput(aScene, rendered, name).Save(name)
+	saveReport(aScene, name, build, elapsed, before)
+	return rendered, err
+}
+
+// RenderAnalysis renders the scene and saves the image with the name as
+// RenderScene does, and saves the heatmap of the one channel AOV named
+// heatmap with the name as name.<heatmap>. The luminance is mapped to the
+// colors by its logarithm. If the context is done first, the part rendered
+// by then is saved and returned with its error.
+func RenderAnalysis(ctx context.Context, aScene *scene.Scene, name string, opts render.Options, heatmap string) (*image.Image, error) {
+	if render.AOVChannels(heatmap) != 1 {
+		return nil, fmt.Errorf("there's no heatmap of the %q AOV", heatmap)
+	}
+	before := aScene.Statistics()
+	start := time.Now()
+	build := prepare(aScene)
+	result, err := render.InMemory(ctx, aScene, 1000, 1000, opts, heatmap)
+	if result == nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Rendered in: %s\n", elapsed)
+	rendered := &image.Image{NRGBA: *result.Image}
+	cropOutput(aScene, rendered, name).Save(name)
+	saveReport(aScene, name, build, elapsed, before)
+	colors, heatmapErr := render.Heatmap(result.AOVs[heatmap], 1000, 1000, heatmap == render.AOVLuminance)
+	if heatmapErr != nil {
+		return rendered, heatmapErr
+	}
+	(&image.Image{NRGBA: *colors}).Save(name + "." + heatmap)
+	return rendered, err
+}
+
+// StreamEXR renders the scene and saves its linear radiance with the name
+// in name.exr, writing the tiles as they are traced. If the context is
+// done first, the tiles not traced by then are left black.
+func StreamEXR(ctx context.Context, aScene *scene.Scene, name string, opts render.Options) error {
+	f, err := os.Create(name + ".exr")
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = render.SceneEXR(ctx, aScene, 1000, 1000, opts, f)
+	if err == ctx.Err() && err != nil {
+		fmt.Println("The render was stopped: " + err.Error())
+		err = nil
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Printf("Rendered in: %s\n", time.Since(start))
+	return f.Close()
+}
+
+// cropOutput returns the render to save with the name: the render itself
+// if the scene has no crop window, and only the crop window otherwise, or
+// the crop window pasted over the render saved before with the name if
+// the crop frame is kept. The rest of the frame is black if there's no
+// render of its size to paste it over.
+func cropOutput(aScene *scene.Scene, rendered *image.Image, name string) *image.Image {
+	bounds := rendered.Bounds()
+	window := aScene.Settings.CropWindow(bounds.Dx(), bounds.Dy())
+	if window == bounds {
+		return rendered
+	}
+	if !aScene.Settings.CropFrame {
+		cropped := image.New(window.Dx(), window.Dy())
+		draw.Draw(cropped, cropped.Bounds(), rendered, window.Min, draw.Src)
+		return cropped
+	}
+	frame := image.New(bounds.Dx(), bounds.Dy())
+	if f, err := os.Open(name + ".png"); err == nil {
+		previous, err := png.Decode(f)
+		f.Close()
+		if err == nil && previous.Bounds().Size() == bounds.Size() {
+			draw.Draw(frame, bounds, previous, previous.Bounds().Min, draw.Src)
+		}
+	}
+	draw.Draw(frame, window, rendered, window.Min, draw.Src)
+	return frame
+}
+
+// SaveCryptomatte traces the object and material mattes of the scene, at
+// the size of the renders, and saves them with the name in
+// name.cryptomatte.exr, to mask the render with in a compositor
+func SaveCryptomatte(aScene *scene.Scene, name string) error {
+	mattes := aScene.TraceCryptomatte(1000, 1000)
+	f, err := os.Create(name + ".cryptomatte.exr")
+	if err != nil {
+		return err
+	}
+	if err := scene.WriteCryptomatte(f, mattes); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SaveDepth traces the depth map of the scene, at the size of the renders,
+// and saves it with the name as name.depth.exr and name.depth.pfm if depth
+// is set, and as a point cloud in the colors of the render in name.ply if
+// pointCloud is
+func SaveDepth(aScene *scene.Scene, name string, rendered *image.Image, depth, pointCloud bool) error {
+	d := aScene.TraceDepth(1000, 1000)
+	var files []string
+	if depth {
+		files = append(files, ".depth.exr", ".depth.pfm")
+	}
+	if pointCloud {
+		files = append(files, ".ply")
+	}
+	for _, ext := range files {
+		f, err := os.Create(name + ext)
+		if err != nil {
+			return err
+		}
+		switch ext {
+		case ".depth.exr":
+			err = d.WriteEXR(f)
+		case ".depth.pfm":
+			err = d.WritePFM(f)
+		default:
+			err = d.WritePLY(f, rendered)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderStereo renders the images of the left and right cameras of the
+// stereo pair of the camera of the scene with the baseline, and saves them
+// with the name as name.left and name.right, and the ground truth
+// disparity of the left image in name.disparity.pfm, with its mask in
+// name.disparity.mask.png. If the context is done first, the images
+// rendered by then are saved and its error is returned.
+func RenderStereo(ctx context.Context, aScene *scene.Scene, name string, baseline float64, opts render.Options) error {
+	disparity, err := aScene.TraceDisparity(1000, 1000, baseline)
+	if err != nil {
+		return err
+	}
+	center := aScene.Camera
+	defer func() { aScene.Camera = center }()
+	left, right := center.StereoPair(baseline)
+	for _, eye := range []struct {
+		camera camera.PinHole
+		name   string
+	}{{left, name + ".left"}, {right, name + ".right"}} {
+		aScene.Camera = eye.camera
+		if _, err := RenderScene(ctx, aScene, eye.name, opts, true); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(name + ".disparity.pfm")
+	if err != nil {
+		return err
+	}
+	if err := disparity.WritePFM(f); err != nil {
+		f.Close()
+		return err
 	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if f, err = os.Create(name + ".disparity.mask.png"); err != nil {
+		return err
+	}
+	if err := png.Encode(f, disparity.MaskImage()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	render.Save(name)
+// SaveScan scans the scene with the LiDAR scanner of the file, and saves
+// what it measures with the name as a point cloud, in name.lidar.ply
+func SaveScan(aScene *scene.Scene, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	scanner, err := scene.ReadScanner(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	returns, err := aScene.Scan(scanner)
+	if err != nil {
+		return err
+	}
+	if f, err = os.Create(name + ".lidar.ply"); err != nil {
+		return err
+	}
+	if err := scene.WriteLidarPLY(f, returns); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SaveIlluminance measures the illuminance at the sensors of the grid of
+// the file, and saves it with the name as a table, in name.daylight.csv,
+// with a row for every sensor with its cell, position and illuminance in
+// lux, all of it and the part that arrives straight from the lights
+func SaveIlluminance(aScene *scene.Scene, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	grid, err := scene.ReadSensorGrid(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	readings, err := aScene.Illuminance(grid)
+	if err != nil {
+		return err
+	}
+	if f, err = os.Create(name + ".daylight.csv"); err != nil {
+		return err
+	}
+	if err := scene.WriteIlluminanceCSV(f, readings); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SaveViewFactors computes the view factors between the surfaces of the
+// shapes of the scene with the same names, with the rays leaving every
+// surface, and saves them with the name as a table, in
+// name.viewfactors.csv, with a row for every surface and a column for
+// every surface and the sky
+func SaveViewFactors(aScene *scene.Scene, rays int, name string) error {
+	geometry := query.FromScene(aScene)
+	surfaces := geometry.SurfacesByName()
+	factors, err := geometry.ViewFactors(surfaces, rays, aScene.Settings.Seed)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(name + ".viewfactors.csv")
+	if err != nil {
+		return err
+	}
+	if err := query.WriteViewFactorsCSV(f, surfaces, factors); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SaveLines traces the lines of the scene that the hidden line integrator
+// draws, at the size of the renders, and saves them with the name as a
+// vector drawing, in name.svg
+func SaveLines(aScene *scene.Scene, name string) error {
+	lines := aScene.TraceLines(1000, 1000)
+	f, err := os.Create(name + ".svg")
+	if err != nil {
+		return err
+	}
+	if err := lines.WriteSVG(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// prepare builds the structures of the scene ahead of its render and
+// returns how long it took, for its report
+func prepare(aScene *scene.Scene) time.Duration {
+	start := time.Now()
+	aScene.Prepare()
+	return time.Since(start)
+}
+
+// saveReport writes the report of the render of the scene saved with the
+// name, which took elapsed, build of it building the structures, with the
+// work done since before, the statistics of the scene when it started, if
+// the settings ask for statistics or for a report
+func saveReport(aScene *scene.Scene, name string, build, elapsed time.Duration, before scene.Statistics) {
+	if !aScene.Settings.Stats && !aScene.Settings.Report {
+		return
+	}
+	report := render.NewReport(aScene, 1000, 1000, elapsed, before)
+	report.Build = build
+	if aScene.Settings.Report {
+		report.EstimateObjects(aScene)
+	}
+	if err := writeReport(report, name); err != nil {
+		fmt.Println("Can't save the statistics: " + err.Error())
+	}
+}
+
+// writeReport prints the statistics report of the render saved with the
+// name if the settings ask for statistics, and saves it next to the render
+// as JSON, in name.stats.json
+func writeReport(report *render.Report, name string) error {
+	if report.Settings.Stats {
+		if err := report.WriteText(os.Stdout); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(name + ".stats.json")
+	if err != nil {
+		return err
+	}
+	if err := report.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dryRunObjects is the number of the most costly objects DryRun lists
+const dryRunObjects = 10
+
+// DryRun estimates the cost of rendering the scene with the options,
+// writing it to w with a breakdown by build step and by what the pixels
+// show
+func DryRun(aScene *scene.Scene, opts render.Options, w io.Writer) {
+	const width, height = 1000, 1000
+	e := aScene.Estimate(width, height)
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = render.DefaultWorkers()
+	}
+	wall := time.Duration(float64(e.TraceTime()) / float64(workers))
+	if opts.Duty > 0 && opts.Duty < 1 {
+		wall = time.Duration(float64(wall) / opts.Duty)
+	}
+	pixels := uint64(width * height)
+
+	fmt.Fprintf(w, "Estimated from %d of the %d pixels of a %dx%d render\n\n", e.Pixels, pixels, width, height)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Time\t%s\t%s to build and %s to trace on %d workers\n", (e.BuildTime() + wall).Round(time.Millisecond), e.BuildTime().Round(time.Millisecond), wall.Round(time.Millisecond), workers)
+	fmt.Fprintf(tw, "Memory\t%s\t%s of scene, %s of image, %s more for progressive renders\n", megabytes(e.Memory+4*pixels), megabytes(e.Memory), megabytes(4*pixels), megabytes(32*pixels))
+	fmt.Fprintf(tw, "Samples\t%.1f per pixel\t%.1f rays per sample\n", e.SamplesPerPixel, e.RaysPerSample)
+	fmt.Fprintln(tw, "\nBuild\tTime\tMemory")
+	for _, c := range e.Build {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name, c.Time.Round(time.Millisecond), megabytes(c.Memory))
+	}
+	fmt.Fprintln(tw, "\nFeature\tCPU time\tPixels")
+	for _, c := range e.Features {
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\n", c.Name, c.Time.Round(time.Millisecond), 100*c.Pixels)
+	}
+	fmt.Fprintln(tw, "\nObject\tCPU time\tPixels")
+	others := scene.Cost{}
+	for i, c := range e.Trace {
+		if i >= dryRunObjects {
+			others.Time += c.Time
+			others.Pixels += c.Pixels
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\n", c.Name, c.Time.Round(time.Millisecond), 100*c.Pixels)
+	}
+	if len(e.Trace) > dryRunObjects {
+		fmt.Fprintf(tw, "%d others\t%s\t%.1f%%\n", len(e.Trace)-dryRunObjects, others.Time.Round(time.Millisecond), 100*others.Pixels)
+	}
+	tw.Flush()
+}
+
+// megabytes returns the number of bytes in megabytes
+func megabytes(bytes uint64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+}
+
+// Progressive holds the options of progressive renders
+type Progressive struct {
+	// Serve is the address to serve a page to watch the render on, if any
+	Serve    string
+	Security Security
+	// Checkpoint is the file the samples taken so far are saved to every
+	// interval, and when the program is interrupted, if any
+	Checkpoint string
+	Interval   time.Duration
+	// Resume resumes the render from the checkpoint if it exists
+	Resume bool
+	// Watch is the scene file to watch, if any. Every time it changes, the
+	// scene Reload returns replaces the one being rendered, keeping the
+	// samples of the pixels the changes can't have changed.
+	Watch  string
+	Reload func() (*scene.Scene, error)
+	// Fly lets the page served move the camera, rendering again from every
+	// new viewpoint until the context is done
+	Fly bool
+}
+
+// watchInterval is how often the scene file of progressive renders is
+// checked for changes
+const watchInterval = 500 * time.Millisecond
+
+// RenderProgressively renders the scene with a progressive renderer as
+// the options say, and saves the image with the name once it has every
+// sample or the context is done. When watching the scene file, it's saved
+// every time the render of a version of the scene has every sample, until
+// the context is done.
+func RenderProgressively(ctx context.Context, aScene *scene.Scene, name string, p Progressive, opts render.Options) error {
+	r := render.NewRenderer(aScene, 1000, 1000, opts)
+	if p.Checkpoint != "" && p.Resume {
+		err := r.LoadCheckpoint(p.Checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			fmt.Printf("Resuming from %d samples\n", r.Passes())
+		}
+	}
+	if p.Serve != "" {
+		l, err := p.Security.listen(p.Serve)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		server := render.NewServer(r, render.DefaultInterval)
+		server.Live = p.Watch != "" || p.Fly
+		server.Fly = p.Fly
+		go http.Serve(l, auth.Require(p.Security.Token, server))
+		fmt.Printf("Watch the render at %s://%s/\n", p.Security.scheme(), l.Addr())
+		if p.Security.Token != "" {
+			fmt.Println("Open it with ?token= and the token the first time")
+		}
+	}
+	// A nil channel never ticks, so without a checkpoint nothing is saved
+	var ticks <-chan time.Time
+	if p.Checkpoint != "" {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	// Without a scene file to watch, a nil channel never ticks either
+	var polls <-chan time.Time
+	var modified time.Time
+	if p.Watch != "" {
+		info, err := os.Stat(p.Watch)
+		if err != nil {
+			return err
+		}
+		modified = info.ModTime()
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		polls = ticker.C
+		fmt.Printf("Watching %s for changes\n", p.Watch)
+	}
+	before := aScene.Statistics()
+	start := time.Now()
+	build := prepare(aScene)
+	finish := func() error {
+		elapsed := time.Since(start)
+		fmt.Printf("Rendered %d samples in: %s\n", r.Passes(), elapsed)
+		if err := r.Err(); err != nil {
+			fmt.Println("The render was stopped: " + err.Error())
+		}
+		if p.Checkpoint != "" {
+			if err := r.SaveCheckpoint(p.Checkpoint); err != nil {
+				return err
+			}
+		}
+		r.Image().Save(name)
+		saveReport(aScene, name, build, elapsed, before)
+		return nil
+	}
+	r.Start(ctx)
+	done := r.Done()
+	for finished := false; !finished; {
+		select {
+		case <-ticks:
+			if err := r.SaveCheckpoint(p.Checkpoint); err != nil {
+				fmt.Println("Can't save the checkpoint: " + err.Error())
+			}
+		case <-polls:
+			info, err := os.Stat(p.Watch)
+			if err != nil || !info.ModTime().After(modified) {
+				continue
+			}
+			modified = info.ModTime()
+			reloaded, err := p.Reload()
+			if err != nil {
+				fmt.Println("Can't reload the scene: " + err.Error())
+				continue
+			}
+			before, start = reloaded.Statistics(), time.Now()
+			build = prepare(reloaded)
+			region := r.Reload(reloaded)
+			fmt.Printf("Reloaded the scene, tracing %dx%d pixels again\n", region.Dx(), region.Dy())
+			aScene, done = reloaded, r.Done()
+		case <-done:
+			if p.Watch == "" && !p.Fly {
+				finished = true
+				continue
+			}
+			if err := finish(); err != nil {
+				return err
+			}
+			// The render is done until the scene is reloaded, or until the
+			// camera flies, when it's saved once the context is done
+			done = nil
+		case <-ctx.Done():
+			// The renderer stops too, unless it's already done
+			<-r.Done()
+			finished = true
+		}
+	}
+	if err := finish(); err != nil {
+		return err
+	}
+	if p.Serve != "" {
+		// Streams get the last image before the server is gone
+		time.Sleep(2 * render.DefaultInterval)
+	}
+	return nil
+}
+
+// SaveCache saves the scene to a scene cache at path, which renders like
+// the scene file with the options the scene has
+func SaveCache(aScene *scene.Scene, path string) error {
+	data, err := aScene.MarshalCache()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// loadKeys returns the keys of scene bundles in the files at the paths
+// that aren't empty
+func loadKeys(encryption, signing, verifying string) (bundle.Keys, error) {
+	var keys bundle.Keys
+	for _, k := range []struct {
+		path string
+		key  *[]byte
+	}{{encryption, &keys.Encryption}, {signing, &keys.Signing}, {verifying, &keys.Verifying}} {
+		if k.path == "" {
+			continue
+		}
+		key, err := bundle.LoadKey(k.path)
+		if err != nil {
+			return keys, err
+		}
+		*k.key = key
+	}
+	return keys, nil
+}
+
+// SaveKey writes a random key for scene bundles in hexadecimal to the
+// file at path, which only its owner may read
+func SaveKey(path string) error {
+	key, err := bundle.NewKey()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
+
+// SaveBundle writes the scene bundle of the scene file to the file at
+// path, encrypted and signed with the keys that aren't nil, and prints the
+// key that verifies its signature
+func SaveBundle(scenePath, path string, keys bundle.Keys) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = bundle.Write(file, scenePath, keys)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if keys.Signing != nil {
+		fmt.Printf("Signed, the key that verifies the signature is %x\n", bundle.VerifyingKey(keys.Signing))
+	}
+	return nil
+}
+
+// ExportPBRT writes the scene in the PBRT-v4 format to the file at path,
+// rendering the image that a render of it saves to an EXR file named
+// after it, and prints what pbrt renders differently
+func ExportPBRT(aScene *scene.Scene, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	film := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".exr"
+	warnings, err := pbrt.Export(file, aScene, 1000, 1000, film)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	for _, w := range warnings {
+		fmt.Println("Warning: " + w)
+	}
+	return err
+}
+
+// BakeShape bakes the lightmap of the shape with the name and saves it in
+// dir, named after the shape. If the context is done first, the part baked
+// by then is saved and its error returned.
+func BakeShape(ctx context.Context, aScene *scene.Scene, name string, size, padding int, dir string) error {
+	for i, sh := range aScene.Shapes {
+		if shape.NameOf(sh, i) != name {
+			continue
+		}
+		lightmap, err := aScene.Bake(ctx, i, size, size, padding)
+		if lightmap != nil {
+			lightmap.Save(filepath.Join(dir, "lightmap-"+name))
+		}
+		return err
+	}
+	return fmt.Errorf("there is no shape named %s", name)
+}
+
+// RenderMaterials renders the shader ball of every material of the library
+// in the file at the quality, saving them in dir as name.png and all of
+// them, in the order of their names, in catalog.png. If the context is done
+// first, the materials rendered by then are saved and its error returned.
+func RenderMaterials(ctx context.Context, path, quality, dir string, opts render.Options) error {
+	q, err := shaderball.ParseQuality(quality)
+	if err != nil {
+		return err
+	}
+	entries, err := shaderball.LoadLibrary(path)
+	if err != nil {
+		return err
+	}
+	previews := make([]*image.Image, 0, len(entries))
+	for _, e := range entries {
+		preview, err := shaderball.Render(ctx, e.Material, q, opts)
+		if err != nil {
+			return err
+		}
+		if err := savePNG(preview, filepath.Join(dir, e.Name+".png")); err != nil {
+			return err
+		}
+		fmt.Printf("Rendered %s\n", e.Name)
+		previews = append(previews, preview)
+	}
+	return savePNG(shaderball.Catalog(previews), filepath.Join(dir, "catalog.png"))
+}
+
+// savePNG saves the image as a PNG in the file
+func savePNG(img *image.Image, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := img.EncodePNG(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// RenderAnimation renders the frames of the animation in the file, given
+// as a range such as "10-20" or all of them if empty, and saves them in dir
+// until the context is done
+func RenderAnimation(ctx context.Context, aScene *scene.Scene, path, frames string, resume bool, dir string, opts render.Options) error {
+	a, first, last, err := loadAnimation(path, frames)
+	if err != nil {
+		return err
+	}
+	return render.RenderAnimation(ctx, aScene, a, first, last, 1000, 1000, filepath.Join(dir, "frame"), opts, resume, func(frame int) {
+		fmt.Printf("Rendered frame %d\n", frame)
+	})
+}
+
+// RenderDataset renders the variations of the scene the dataset spec in the
+// file describes, and saves them in dir with their masks and annotations
+// until the context is done
+func RenderDataset(ctx context.Context, aScene *scene.Scene, path string, resume bool, dir string, opts render.Options) error {
+	spec, err := dataset.Load(path)
+	if err != nil {
+		return err
+	}
+	return render.RenderDataset(ctx, aScene, spec, 1000, 1000, filepath.Join(dir, "sample"), opts, resume, func(sample int) {
+		fmt.Printf("Rendered sample %d of %d\n", sample+1, spec.Count)
+	})
+}
+
+// Coordinate serves the tiles of the render of the scene, or of the frames
+// of the animation in the file if there's one, to the workers that connect
+// to addr and meet the requirements, and its dashboard to browsers if
+// dashboard is set, secured as security says, and saves the frames in dir
+// as they're done
+func Coordinate(aScene *scene.Scene, addr, path, frames, dir string, requires netrender.Requirements, security Security, dashboard bool) error {
+	job := netrender.Job{Scene: aScene, Width: 1000, Height: 1000, TileSize: 64, Requires: requires}
+	name := func(int) string { return filepath.Join(dir, "main") }
+	if path != "" {
+		var err error
+		if job.Animation, job.First, job.Last, err = loadAnimation(path, frames); err != nil {
+			return err
+		}
+		name = func(frame int) string { return render.FrameName(filepath.Join(dir, "frame"), frame) }
+	}
+	c, err := netrender.NewCoordinator(job, netrender.DefaultLease, func(frame int, img *image.Image) {
+		img.Save(name(frame))
+		fmt.Printf("Rendered frame %d\n", frame)
+	})
+	if err != nil {
+		return err
+	}
+	c.Dashboard = dashboard
+	l, err := security.listen(addr)
+	if err != nil {
+		return err
+	}
+	go http.Serve(l, auth.Require(security.Token, c))
+	<-c.Done()
+	// Workers asking for more work meanwhile hear that the job is done
+	// rather than finding the coordinator gone
+	time.Sleep(2 * time.Second)
+	return l.Close()
+}
+
+// Edit serves the scene to the editors that connect to addr, secured as
+// security says, for them to change it and watch its render
+func Edit(aScene *scene.Scene, addr string, security Security) error {
+	l, err := security.listen(addr)
+	if err != nil {
+		return err
+	}
+	return http.Serve(l, auth.Require(security.Token, sceneedit.NewEditor(aScene)))
+}
+
+// Security holds how the services of the program are secured so they can
+// be reached from beyond localhost
+type Security struct {
+	// Token is the token the clients must send, if any
+	Token string
+	// CertFile and KeyFile hold the certificate and the key to serve TLS
+	// with, if any
+	CertFile, KeyFile string
+}
+
+// listen listens on the address, with TLS if there's a certificate
+func (s Security) listen(addr string) (net.Listener, error) {
+	return auth.Listen(addr, s.CertFile, s.KeyFile)
+}
+
+// scheme returns the scheme of the URLs of the services
+func (s Security) scheme() string {
+	if s.CertFile != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// loadAnimation returns the animation in the file and the first and last
+// frames of the range, or of the whole animation if it's empty
+func loadAnimation(path, frames string) (*animation.Animation, int, int, error) {
+	a, err := animation.Load(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if frames == "" {
+		return a, 0, a.Frames - 1, nil
+	}
+	first, last, err := parseFrames(frames)
+	return a, first, last, err
+}
+
+// parseFrames returns the first and last frames of a range such as
+// "10-20", or of a single frame such as "7"
+func parseFrames(frames string) (int, int, error) {
+	parts := strings.SplitN(frames, "-", 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid frames %q", frames)
+	}
+	if len(parts) == 1 {
+		return first, first, nil
+	}
+	last, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid frames %q", frames)
+	}
+	return first, last, nil
 }
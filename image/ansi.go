@@ -0,0 +1,62 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	stdcol "image/color"
+	"io"
+)
+
+// WriteANSI writes a preview of the image for terminals that support 24
+// bit ANSI colors, scaled down to the number of columns. Every character
+// shows two pixels with the upper half block, so the preview keeps the
+// proportions of the image in terminals whose cells are twice as tall
+// as wide.
+func (img *Image) WriteANSI(w io.Writer, columns int) error {
+	b := img.Bounds()
+	if columns <= 0 || columns > b.Dx() {
+		columns = b.Dx()
+	}
+	cell := float64(b.Dx()) / float64(columns)
+	rows := int(float64(b.Dy()) / cell / 2)
+	if rows == 0 {
+		rows = 1
+	}
+
+	bw := bufio.NewWriter(w)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			top := img.average(col, 2*row, cell)
+			bottom := img.average(col, 2*row+1, cell)
+			fmt.Fprintf(bw, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀",
+				top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+	return bw.Flush()
+}
+
+// average returns the mean color of the pixels covered by the preview
+// pixel x, y of side cell
+func (img *Image) average(x, y int, cell float64) stdcol.NRGBA {
+	b := img.Bounds()
+	x0, y0 := b.Min.X+int(float64(x)*cell), b.Min.Y+int(float64(y)*cell)
+	x1, y1 := b.Min.X+int(float64(x+1)*cell), b.Min.Y+int(float64(y+1)*cell)
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+	var r, g, bl, n int
+	for py := y0; py < y1 && py < b.Max.Y; py++ {
+		for px := x0; px < x1 && px < b.Max.X; px++ {
+			c := img.NRGBAAt(px, py)
+			r, g, bl, n = r+int(c.R), g+int(c.G), bl+int(c.B), n+1
+		}
+	}
+	if n == 0 {
+		return stdcol.NRGBA{A: 255}
+	}
+	return stdcol.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: 255}
+}
@@ -0,0 +1,85 @@
+package image
+
+import "math"
+
+// Physical constants of Planck's law, in SI units
+const (
+	planck     = 6.62607015e-34
+	lightSpeed = 299792458
+	boltzmann  = 1.380649e-23
+)
+
+// Blackbody returns the linear color of the light of a blackbody at the
+// temperature in kelvin, with a luminance of 1. The colors out of the
+// gamut, such as the deep reds of the coolest temperatures, are clipped.
+func Blackbody(kelvin float64) Color {
+	x, y, z := blackbodyXYZ(kelvin)
+	if y == 0 {
+		return Black
+	}
+	return xyzToRGB(x/y, 1, z/y)
+}
+
+// FromChromaticity returns the linear color of the CIE xyY coordinates: the
+// chromaticity x, y and the luminance. The colors out of the gamut are
+// clipped.
+func FromChromaticity(x, y, luminance float64) Color {
+	if y <= 0 {
+		return Black
+	}
+	return xyzToRGB(x/y*luminance, luminance, (1-x-y)/y*luminance)
+}
+
+// xyzToRGB returns the linear sRGB color of the CIE XYZ coordinates, with
+// the colors out of the gamut clipped
+func xyzToRGB(x, y, z float64) Color {
+	return Color{
+		R: math.Max(0, 3.2404542*x-1.5371385*y-0.4985314*z),
+		G: math.Max(0, -0.9692660*x+1.8760108*y+0.0415560*z),
+		B: math.Max(0, 0.0556434*x-0.2040259*y+1.0572252*z)}
+}
+
+// BlackbodyLuminance returns the luminance of a blackbody at the
+// temperature in kelvin, relative to the one of a blackbody at 1000 K.
+// Visible light grows very fast with the temperature: it's 0.003 at
+// 800 K, 50 at 1200 K and almost 3000 at 1500 K.
+func BlackbodyLuminance(kelvin float64) float64 {
+	_, y, _ := blackbodyXYZ(kelvin)
+	_, reference, _ := blackbodyXYZ(1000)
+	return y / reference
+}
+
+// blackbodyXYZ returns the CIE XYZ coordinates of the radiance of a
+// blackbody at the temperature, integrating Planck's law over the visible
+// wavelengths against the color matching functions
+func blackbodyXYZ(kelvin float64) (x, y, z float64) {
+	if !(kelvin > 0) {
+		return 0, 0, 0
+	}
+	for nm := 360.0; nm <= 830; nm += 5 {
+		lambda := nm * 1e-9
+		radiance := 2 * planck * lightSpeed * lightSpeed / math.Pow(lambda, 5) /
+			math.Expm1(planck*lightSpeed/(lambda*boltzmann*kelvin))
+		cx, cy, cz := colorMatching(nm)
+		x, y, z = x+radiance*cx, y+radiance*cy, z+radiance*cz
+	}
+	return x, y, z
+}
+
+// colorMatching returns the CIE 1931 color matching functions at the
+// wavelength in nanometers, with the multi-lobe fit of Wyman, Sloan and
+// Shirley
+func colorMatching(nm float64) (x, y, z float64) {
+	lobe := func(mean, below, above float64) float64 {
+		sigma := above
+		if nm < mean {
+			sigma = below
+		}
+		t := (nm - mean) / sigma
+		return math.Exp(-t * t / 2)
+	}
+	x = 1.056*lobe(599.8, 37.9, 31.0) + 0.362*lobe(442.0, 16.0, 26.7) - 0.065*lobe(501.1, 20.4, 26.2)
+	y = 0.821*lobe(568.8, 46.9, 40.5) + 0.286*lobe(530.9, 16.3, 31.1)
+	z = 1.217*lobe(437.0, 11.8, 36.0) + 0.681*lobe(459.0, 26.0, 13.8)
+	return x, y, z
+}
@@ -1,9 +1,13 @@
 package image
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	stdcol "image/color"
+	"math"
 
+	"github.com/ProjectMOA/goraytrace/jsonutil"
 	"github.com/ProjectMOA/goraytrace/math3d"
 )
 
@@ -26,7 +30,10 @@ var (
 	Cyan = Color{R: 0, G: 1, B: 1}
 )
 
-// Color defines an RGB color with floating point precision
+// Color defines an RGB color with floating point precision. Colors are
+// linear, with the primaries and the white point of sRGB and Rec. 709,
+// everywhere but in the pixels of renders, which are encoded in the color
+// space of the output right before saving or displaying them.
 type Color struct {
 	R float64 `json:"r"`
 	G float64 `json:"g"`
@@ -54,9 +61,127 @@ func (c *Color) CMultiply(c2 *Color) *Color {
 	return &Color{R: c.R * c2.R, G: c.G * c2.G, B: c.B * c2.B}
 }
 
-// ColorFromMap returns the color defined in the map
-func ColorFromMap(m map[string]float64) Color {
-	return Color{R: m["r"], G: m["g"], B: m["b"]}
+// Luminance returns the relative luminance of the linear color
+func (c *Color) Luminance() float64 {
+	return 0.2126*c.R + 0.7152*c.G + 0.0722*c.B
+}
+
+// Lerp returns the linear interpolation between c and c2, returning c
+// when t is 0 and c2 when t is 1
+func (c *Color) Lerp(c2 *Color, t float64) *Color {
+	return &Color{R: c.R + (c2.R-c.R)*t, G: c.G + (c2.G-c.G)*t, B: c.B + (c2.B-c.B)*t}
+}
+
+// Clamp returns the color with every channel limited to [min, max]
+func (c *Color) Clamp(min, max float64) *Color {
+	return &Color{R: math3d.Clamp(c.R, min, max),
+		G: math3d.Clamp(c.G, min, max),
+		B: math3d.Clamp(c.B, min, max)}
+}
+
+// ToSRGB returns the color encoded with the sRGB transfer function.
+// Colors are linear everywhere else, so this must only be used right
+// before displaying or saving them.
+func (c *Color) ToSRGB() *Color {
+	return &Color{R: linearToSRGB(c.R), G: linearToSRGB(c.G), B: linearToSRGB(c.B)}
+}
+
+// ToRec709 returns the color encoded with the transfer function of Rec.
+// 709, which is what HD video expects. Rec. 709 has the primaries of sRGB.
+func (c *Color) ToRec709() *Color {
+	return &Color{R: linearToRec709(c.R), G: linearToRec709(c.G), B: linearToRec709(c.B)}
+}
+
+// ToDisplayP3 returns the color in Display P3, whose primaries are those
+// of DCI-P3, wider than the ones of sRGB, with the white point and the
+// transfer function of sRGB. It's what the wide gamut displays of most
+// phones and laptops expect.
+func (c *Color) ToDisplayP3() *Color {
+	p3 := Color{
+		R: 0.8224621*c.R + 0.1775380*c.G,
+		G: 0.0331941*c.R + 0.9668058*c.G,
+		B: 0.0170827*c.R + 0.0723974*c.G + 0.9105199*c.B}
+	return p3.ToSRGB()
+}
+
+// FromSRGB returns the linear color of a color encoded with the sRGB
+// transfer function, such as the ones read from most image files.
+func FromSRGB(c *Color) *Color {
+	return &Color{R: sRGBToLinear(c.R), G: sRGBToLinear(c.G), B: sRGBToLinear(c.B)}
+}
+
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func linearToRec709(v float64) float64 {
+	if v < 0.018 {
+		return 4.5 * v
+	}
+	return 1.099*math.Pow(v, 0.45) - 0.099
+}
+
+func sRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// jsonColor is a Color without its JSON methods, to encode it
+type jsonColor Color
+
+// MarshalJSON returns the color as an object with r, g and b. It fails if
+// they aren't finite, which JSON can't hold.
+func (c Color) MarshalJSON() ([]byte, error) {
+	if err := jsonutil.Finite(c.R, c.G, c.B); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonColor(c))
+}
+
+// UnmarshalJSON sets the color from an object with the numbers r, g and
+// b, and nothing else. The missing ones are 0.
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var decoded Color
+	if err := jsonutil.Object(data, map[string]interface{}{"r": &decoded.R, "g": &decoded.G, "b": &decoded.B}); err != nil {
+		return err
+	}
+	*c = decoded
+	return nil
+}
+
+// NonNegative returns whether no component of the color is negative, as
+// with every amount of light and every albedo
+func (c *Color) NonNegative() bool {
+	return c.R >= 0 && c.G >= 0 && c.B >= 0
+}
+
+// ColorFromMap returns the color in value, which must be an object whose
+// r, g and b are numbers, as encoding/json decodes it. The ones missing
+// are 0, and its other keys are ignored.
+func ColorFromMap(value interface{}) (Color, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return Color{}, errors.New("must be an object with the numbers r, g and b")
+	}
+	var c Color
+	for _, channel := range []struct {
+		key   string
+		value *float64
+	}{{"r", &c.R}, {"g", &c.G}, {"b", &c.B}} {
+		if v, present := m[channel.key]; present {
+			n, ok := v.(float64)
+			if !ok {
+				return Color{}, fmt.Errorf("%s: must be a number", channel.key)
+			}
+			*channel.value = n
+		}
+	}
+	return c, nil
 }
 
 // RGBA returns the alpha-premultiplied red, green, blue and alpha values
@@ -0,0 +1,137 @@
+package image
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestSRGBRoundTrip(t *testing.T) {
+	for _, v := range []float64{0, 0.001, 0.18, 0.5, 1} {
+		c := Color{R: v, G: v, B: v}
+		back := FromSRGB(c.ToSRGB())
+		if math.Abs(back.R-v) > 1e-9 {
+			t.Errorf("sRGB round trip of %.3f returned %.3f", v, back.R)
+		}
+	}
+	mid := Color{R: 0.5, G: 0.5, B: 0.5}
+	if srgb := mid.ToSRGB(); math.Abs(srgb.R-0.7354) > 1e-3 {
+		t.Errorf("Linear 0.5 should be encoded as 0.735, not %.3f", srgb.R)
+	}
+}
+
+func TestOutputColorSpaces(t *testing.T) {
+	mid := Color{R: 0.5, G: 0.5, B: 0.5}
+	if rec709 := mid.ToRec709(); math.Abs(rec709.R-0.7055) > 1e-3 {
+		t.Errorf("Linear 0.5 should be encoded as 0.706 in Rec. 709, not %.3f", rec709.R)
+	}
+	if dark := (&Color{R: 0.01}).ToRec709(); math.Abs(dark.R-0.045) > 1e-9 {
+		t.Errorf("Linear 0.01 should be encoded as 0.045 in Rec. 709, not %g", dark.R)
+	}
+	for _, c := range []Color{White, mid} {
+		p3, srgb := c.ToDisplayP3(), c.ToSRGB()
+		if math.Abs(p3.R-srgb.R) > 1e-6 || math.Abs(p3.G-srgb.G) > 1e-6 || math.Abs(p3.B-srgb.B) > 1e-6 {
+			t.Errorf("Greys should be the same in Display P3 as in sRGB, %s is %s", srgb.String(), p3.String())
+		}
+	}
+	// The primaries of sRGB are inside the wider gamut of Display P3
+	if red := Red.ToDisplayP3(); !(red.R < 1 && red.G > 0 && red.B > 0) {
+		t.Errorf("The red of sRGB should be less saturated in Display P3, not %s", red.String())
+	}
+}
+
+func TestColorLerpAndClamp(t *testing.T) {
+	c := Black.Lerp(&White, 0.25)
+	if *c != (Color{R: 0.25, G: 0.25, B: 0.25}) {
+		t.Error("Wrong interpolation " + c.String())
+	}
+	over := Color{R: 2, G: -1, B: 0.5}
+	if *over.Clamp(0, 1) != (Color{R: 1, G: 0, B: 0.5}) {
+		t.Error("Wrong clamping " + over.Clamp(0, 1).String())
+	}
+}
+
+func TestColorJSON(t *testing.T) {
+	tests := []struct {
+		json  string
+		color Color
+		ok    bool
+	}{
+		{`{"r": 0.5, "g": 1, "b": 2}`, Color{R: 0.5, G: 1, B: 2}, true},
+		{`{"g": 1}`, Color{G: 1}, true},
+		{`{"r": 1, "alpha": 1}`, Color{}, false},
+		{`{"r": true}`, Color{}, false},
+		{`"white"`, Color{}, false},
+	}
+	for _, test := range tests {
+		c := Color{R: 9, G: 9, B: 9}
+		err := json.Unmarshal([]byte(test.json), &c)
+		if test.ok && (err != nil || c != test.color) {
+			t.Errorf("%s should decode to %v, not %v (%v)", test.json, test.color, c, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s shouldn't decode", test.json)
+		}
+	}
+	if _, err := json.Marshal(Color{R: math.Inf(1)}); err == nil {
+		t.Error("A color that isn't finite shouldn't encode")
+	}
+}
+
+func TestBlackbodyGoesFromRedToBlue(t *testing.T) {
+	previous := Blackbody(1000)
+	if !(previous.R > previous.G && previous.G > previous.B) {
+		t.Errorf("A blackbody at 1000 K should be red, not %s", previous.String())
+	}
+	for _, kelvin := range []float64{1500, 2500, 4000, 6500, 10000, 20000} {
+		c := Blackbody(kelvin)
+		if math.Abs(c.Luminance()-1) > 0.02 {
+			t.Errorf("The color at %.0f K should have a luminance of 1, not %.3f", kelvin, c.Luminance())
+		}
+		if (c.G+c.B)/c.R <= (previous.G+previous.B)/previous.R {
+			t.Errorf("The color at %.0f K should be bluer than the cooler one, it's %s", kelvin, c.String())
+		}
+		previous = c
+	}
+	if white := Blackbody(6500); math.Abs(white.R-1) > 0.1 || math.Abs(white.B-1) > 0.1 {
+		t.Errorf("A blackbody at 6500 K should be close to white, not %s", white.String())
+	}
+	if c := Blackbody(0); c != Black {
+		t.Errorf("A blackbody at 0 K should be black, not %s", c.String())
+	}
+	if l := BlackbodyLuminance(1000); math.Abs(l-1) > 1e-12 {
+		t.Errorf("The luminance is relative to 1000 K, found %.3f", l)
+	}
+	if l := BlackbodyLuminance(1500); l < 1000 || l > 10000 {
+		t.Errorf("A blackbody at 1500 K should be a lot brighter than at 1000 K, it's %.1f times", l)
+	}
+}
+
+func TestWavelengthColorsAverageToWhite(t *testing.T) {
+	for _, n := range []int{1, 4, 16} {
+		sum := Color{}
+		const heroes = 470
+		for i := 0; i < heroes; i++ {
+			weight := SpectralWeight(MinWavelength+(float64(i)+0.5)*(MaxWavelength-MinWavelength)/heroes, n)
+			sum = *sum.Add(&weight)
+		}
+		mean := *sum.Divide(heroes)
+		if math.Abs(mean.R-1) > 0.01 || math.Abs(mean.G-1) > 0.01 || math.Abs(mean.B-1) > 0.01 {
+			t.Errorf("The sensor should record white from all the wavelengths alike with %d of them, not %s", n, mean.String())
+		}
+	}
+	if red, blue := WavelengthColor(650), WavelengthColor(450); red.R <= red.B || blue.B <= blue.R {
+		t.Errorf("650 nm should be red and 450 nm blue, not %s and %s", red.String(), blue.String())
+	}
+}
+
+func TestColorFromMap(t *testing.T) {
+	if c, err := ColorFromMap(map[string]interface{}{"r": 0.5, "b": 1.0, "a": "opaque"}); err != nil || c != (Color{R: 0.5, B: 1}) {
+		t.Errorf("The missing channels should be 0 and the other keys ignored, not %v (%v)", c, err)
+	}
+	for _, invalid := range []interface{}{nil, 0.5, map[string]interface{}{"r": "red"}, map[string]interface{}{"g": true}} {
+		if _, err := ColorFromMap(invalid); err == nil {
+			t.Errorf("%v shouldn't be read as a color", invalid)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package image
+
+import (
+	"math"
+	"math/bits"
+)
+
+// CryptomatteHash returns the hash of name in cryptomatte mattes, which
+// is its MurmurHash3 (x86, 32 bits) with a seed of 0. Their manifests list
+// the names with their hashes in hexadecimal.
+func CryptomatteHash(name string) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	data := []byte(name)
+	mix := func(k uint32) uint32 {
+		return bits.RotateLeft32(k*c1, 15) * c2
+	}
+	var h uint32
+	for ; len(data) >= 4; data = data[4:] {
+		k := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+		h = bits.RotateLeft32(h^mix(k), 13)*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		h ^= mix(k)
+	}
+	h ^= uint32(len(name))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// CryptomatteID returns the ID of name in cryptomatte mattes, which is its
+// hash read as the bits of a float32. The hashes that would be denormal,
+// infinite or NaN get another exponent, so the ID survives compositors
+// that flush or clean such values.
+func CryptomatteID(name string) float32 {
+	h := CryptomatteHash(name)
+	if exponent := h >> 23 & 0xff; exponent == 0 || exponent == 0xff {
+		h ^= 1 << 23
+	}
+	return math.Float32frombits(h)
+}
@@ -0,0 +1,91 @@
+package image
+
+import "math"
+
+// ThumbnailSize is the most pixels of the sides of the thumbnails that the
+// servers of the renders send when they aren't asked for a size
+const ThumbnailSize = 256
+
+// Downsample returns the image scaled down to fit in width x height,
+// keeping its proportions, so that previews don't need the whole image.
+// Every pixel of it is the mean of the box of pixels of the image it
+// covers, weighted by their alpha, so the pixels not rendered yet, which
+// are transparent, don't darken their neighbours. Images that already fit
+// are copied as they are.
+func (img *Image) Downsample(width, height int) *Image {
+	b := img.Bounds()
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	scale := math.Max(float64(b.Dx())/float64(width), float64(b.Dy())/float64(height))
+	if scale <= 1 {
+		small := New(b.Dx(), b.Dy())
+		for y := 0; y < b.Dy(); y++ {
+			copy(small.Pix[y*small.Stride:], img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):img.PixOffset(b.Max.X, b.Min.Y+y)])
+		}
+		return small
+	}
+	small := New(boxes(b.Dx(), scale), boxes(b.Dy(), scale))
+	// columns[x] is the first column of the image that the column x of the
+	// small one covers, and columns[x+1] the one after its last
+	columns := boxEdges(b.Dx(), small.Rect.Dx())
+	rows := boxEdges(b.Dy(), small.Rect.Dy())
+	// The sums of a row of the small image are gathered going over the rows
+	// of the image it covers once, in the order of their pixels
+	sums := make([][4]int, small.Rect.Dx())
+	for y := 0; y < small.Rect.Dy(); y++ {
+		for x := range sums {
+			sums[x] = [4]int{}
+		}
+		for sy := rows[y]; sy < rows[y+1]; sy++ {
+			line := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+sy):]
+			for x := range sums {
+				sum := &sums[x]
+				for sx := columns[x]; sx < columns[x+1]; sx++ {
+					pixel := line[4*sx : 4*sx+4]
+					a := int(pixel[3])
+					sum[0] += int(pixel[0]) * a
+					sum[1] += int(pixel[1]) * a
+					sum[2] += int(pixel[2]) * a
+					sum[3] += a
+				}
+			}
+		}
+		for x, sum := range sums {
+			pixel := small.Pix[small.PixOffset(x, y):]
+			if sum[3] > 0 {
+				pixel[0] = uint8(sum[0] / sum[3])
+				pixel[1] = uint8(sum[1] / sum[3])
+				pixel[2] = uint8(sum[2] / sum[3])
+			}
+			pixel[3] = uint8(sum[3] / ((columns[x+1] - columns[x]) * (rows[y+1] - rows[y])))
+		}
+	}
+	return small
+}
+
+// boxes returns the pixels of a side of length pixels scaled down by the
+// scale, at least 1
+func boxes(length int, scale float64) int {
+	n := int(math.Round(float64(length) / scale))
+	if n < 1 {
+		return 1
+	}
+	if n > length {
+		return length
+	}
+	return n
+}
+
+// boxEdges returns the edges of the n boxes that split a side of length
+// pixels, each of them at least a pixel wide, as n+1 pixels
+func boxEdges(length, n int) []int {
+	edges := make([]int, n+1)
+	for i := range edges {
+		edges[i] = i * length / n
+	}
+	return edges
+}
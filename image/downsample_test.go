@@ -0,0 +1,39 @@
+package image
+
+import (
+	stdimg "image"
+	stdcol "image/color"
+	"testing"
+)
+
+func TestDownsampleAveragesBoxesOfPixels(t *testing.T) {
+	img := New(600, 300)
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x += 3 {
+			// Every box of 3 x 3 pixels holds a column of red, one of
+			// blue and one not rendered yet
+			img.SetNRGBA(x, y, stdcol.NRGBA{R: 255, A: 255})
+			img.SetNRGBA(x+1, y, stdcol.NRGBA{B: 255, A: 255})
+		}
+	}
+	small := img.Downsample(200, 200)
+	if small.Rect != stdimg.Rect(0, 0, 200, 100) {
+		t.Fatalf("The image should be scaled down to 200 x 100, not %v", small.Rect)
+	}
+	if c := small.NRGBAAt(10, 10); c != (stdcol.NRGBA{R: 127, B: 127, A: 170}) {
+		t.Errorf("The transparent pixels shouldn't darken the box, which should be %v, not %v",
+			stdcol.NRGBA{R: 127, B: 127, A: 170}, c)
+	}
+
+	if tall := img.Downsample(1000, 30); tall.Rect != stdimg.Rect(0, 0, 60, 30) {
+		t.Errorf("The image should keep its proportions, not be scaled down to %v", tall.Rect)
+	}
+	if tiny := img.Downsample(0, 0); tiny.Rect != stdimg.Rect(0, 0, 1, 1) {
+		t.Errorf("The image should be scaled down to a pixel at least, not %v", tiny.Rect)
+	}
+	whole := img.SubImage(stdimg.Rect(3, 0, 603, 300)).(*stdimg.NRGBA)
+	if copied := (&Image{*whole}).Downsample(1000, 1000); copied.Rect != stdimg.Rect(0, 0, 597, 300) ||
+		copied.NRGBAAt(0, 0) != img.NRGBAAt(3, 0) {
+		t.Errorf("Images that fit should be copied as they are, not become %v", copied.Rect)
+	}
+}
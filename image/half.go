@@ -0,0 +1,63 @@
+package image
+
+import "math"
+
+// Half is an IEEE 754 half precision float, which takes two bytes. It has
+// 11 significant bits, a little over 3 significant digits, and holds
+// numbers up to 65504; smaller ones down to 6.1e-5 keep every bit, and
+// they lose them down to 6e-8, under which they are 0.
+type Half uint16
+
+// ToHalf returns the half nearest to f, rounding ties to even. Numbers too
+// large for a half become infinities.
+func ToHalf(f float32) Half {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int32(bits>>23&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+	switch {
+	case bits&0x7fffffff > 0x7f800000:
+		// NaN
+		return Half(sign | 0x7e00)
+	case exponent >= 0x1f:
+		return Half(sign | 0x7c00)
+	case exponent <= 0:
+		// Subnormal, with the implicit bit of the mantissa made explicit
+		if exponent < -10 {
+			return Half(sign)
+		}
+		mantissa |= 0x800000
+		shift := uint32(14 - exponent)
+		half := mantissa >> shift
+		rest, tie := mantissa&(1<<shift-1), uint32(1)<<(shift-1)
+		if rest > tie || rest == tie && half&1 == 1 {
+			half++
+		}
+		return Half(sign | uint16(half))
+	}
+	// Rounding up may carry into the exponent, which is still right, even
+	// when it makes the half infinite
+	half := uint32(exponent)<<10 | mantissa>>13
+	if rest := mantissa & 0x1fff; rest > 0x1000 || rest == 0x1000 && half&1 == 1 {
+		half++
+	}
+	return Half(sign | uint16(half))
+}
+
+// Float32 returns the half as a float32, which holds it exactly
+func (h Half) Float32() float32 {
+	sign := uint32(h&0x8000) << 16
+	exponent := uint32(h>>10) & 0x1f
+	mantissa := uint32(h & 0x3ff)
+	switch exponent {
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mantissa<<13)
+	case 0:
+		f := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	}
+	return math.Float32frombits(sign | (exponent+127-15)<<23 | mantissa<<13)
+}
@@ -0,0 +1,62 @@
+package image
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHalfRoundTrip(t *testing.T) {
+	for _, f := range []float32{0, 1, -2, 0.5, 0.099975586, 1024, 65504, 6.1035156e-05, 5.9604645e-08, -3.0517578e-05} {
+		if back := ToHalf(f).Float32(); back != f {
+			t.Errorf("%g should be held exactly by a half, not as %g", f, back)
+		}
+	}
+	for _, h := range []Half{0x0001, 0x03ff, 0x0400, 0x3c00, 0x7bff, 0x8001, 0xfbff} {
+		if back := ToHalf(h.Float32()); back != h {
+			t.Errorf("The half %#04x should convert back to itself, not %#04x", uint16(h), uint16(back))
+		}
+	}
+}
+
+func TestHalfRounding(t *testing.T) {
+	cases := []struct {
+		f    float32
+		want float32
+	}{
+		// Halves between 1 and 2 are 1/1024 apart
+		{1 + 0.4/1024, 1},
+		{1 + 0.6/1024, 1 + 1.0/1024},
+		// Ties go to the even mantissa
+		{1 + 0.5/1024, 1},
+		{1 + 1.5/1024, 1 + 2.0/1024},
+		{0.1, 0.099975586},
+		// Subnormals round too, and the smallest ones to 0
+		{7e-8, 5.9604645e-08},
+		{2e-8, 0},
+		// Rounding up carries into the exponent
+		{2047.9, 2048},
+	}
+	for _, c := range cases {
+		if got := ToHalf(c.f).Float32(); got != c.want {
+			t.Errorf("%g should round to the half %g, not %g", c.f, c.want, got)
+		}
+	}
+}
+
+func TestHalfLimits(t *testing.T) {
+	for _, f := range []float32{65520, 1e10, float32(math.Inf(1))} {
+		if h := ToHalf(f); !math.IsInf(float64(h.Float32()), 1) {
+			t.Errorf("%g should overflow to infinity, not %g", f, h.Float32())
+		}
+	}
+	if h := ToHalf(float32(math.Inf(-1))); !math.IsInf(float64(h.Float32()), -1) {
+		t.Errorf("-Inf should stay -Inf, not %g", h.Float32())
+	}
+	if h := ToHalf(float32(math.NaN())); !math.IsNaN(float64(h.Float32())) {
+		t.Errorf("NaN should stay NaN, not %g", h.Float32())
+	}
+	// The largest number under the infinity rounds down to it
+	if h := ToHalf(65519); h.Float32() != 65504 {
+		t.Errorf("65519 should round to 65504, not %g", h.Float32())
+	}
+}
@@ -0,0 +1,45 @@
+package image
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// IDColor returns a color that identifies name in object and material ID
+// passes. The color only depends on the name, so the same object gets the
+// same color in every frame and in every render of the scene.
+func IDColor(name string) Color {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	sum := h.Sum32()
+	// The low bits pick the hue and the high bits vary saturation and value
+	// a little so that names with close hues are still told apart.
+	hue := float64(sum&0xffff) / 0x10000 * 360
+	saturation := 0.6 + 0.4*float64((sum>>16)&0xff)/0xff
+	value := 0.7 + 0.3*float64(sum>>24)/0xff
+	return colorFromHSV(hue, saturation, value)
+}
+
+// colorFromHSV returns the color with the given hue in degrees and
+// saturation and value in [0, 1]
+func colorFromHSV(h, s, v float64) Color {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return Color{R: r + m, G: g + m, B: b + m}
+}
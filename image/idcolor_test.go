@@ -0,0 +1,38 @@
+package image
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIDColorIsStable(t *testing.T) {
+	c1, c2 := IDColor("teapot"), IDColor("teapot")
+	if c1 != c2 {
+		t.Error("The same name should always get the same color")
+	}
+	if IDColor("teapot") == IDColor("floor") {
+		t.Error("Different names should get different colors")
+	}
+	for _, c := range []Color{c1, IDColor("floor"), IDColor("")} {
+		if c.R < 0 || c.R > 1 || c.G < 0 || c.G > 1 || c.B < 0 || c.B > 1 {
+			t.Error("ID color out of range " + c.String())
+		}
+	}
+}
+
+func TestCryptomatteIDs(t *testing.T) {
+	// The hashes of reference implementations of MurmurHash3
+	for name, hash := range map[string]uint32{
+		"":      0,
+		"hello": 0x248bfa47,
+		"The quick brown fox jumps over the lazy dog": 0x2e4ff723,
+	} {
+		if h := CryptomatteHash(name); h != hash {
+			t.Errorf("The hash of %q should be %08x, not %08x", name, hash, h)
+		}
+	}
+	// The empty name hashes to the bits of 0, which is denormal
+	if id := CryptomatteID(""); id == 0 || math.IsInf(float64(id), 0) || math.IsNaN(float64(id)) {
+		t.Errorf("IDs should be normal floats, not %g", id)
+	}
+}
@@ -3,6 +3,7 @@ package image
 import (
 	stdimg "image"
 	"image/png"
+	"io"
 	"log"
 	"os"
 )
@@ -30,7 +31,7 @@ func (img *Image) Save(filename string) {
 		log.Fatal(err)
 	}
 
-	if err := png.Encode(f, img); err != nil {
+	if err := img.EncodePNG(f); err != nil {
 		f.Close()
 		log.Fatal(err)
 	}
@@ -39,3 +40,9 @@ func (img *Image) Save(filename string) {
 		log.Fatal(err)
 	}
 }
+
+// EncodePNG writes the image to w as a PNG, such as an HTTP response, a
+// buffer or an entry of an archive
+func (img *Image) EncodePNG(w io.Writer) error {
+	return png.Encode(w, img)
+}
@@ -0,0 +1,27 @@
+package image
+
+import (
+	"bytes"
+	stdcol "image/color"
+	"image/png"
+	"testing"
+)
+
+func TestEncodePNG(t *testing.T) {
+	img := New(3, 2)
+	img.Set(2, 1, stdcol.NRGBA{R: 255, G: 128, A: 255})
+	var b bytes.Buffer
+	if err := img.EncodePNG(&b); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("The PNG should be %v, not %v", img.Bounds(), decoded.Bounds())
+	}
+	if c := stdcol.NRGBAModel.Convert(decoded.At(2, 1)); c != (stdcol.NRGBA{R: 255, G: 128, A: 255}) {
+		t.Errorf("The pixel should stay as it was, not become %v", c)
+	}
+}
@@ -0,0 +1,56 @@
+package image
+
+import "math"
+
+// MinWavelength and MaxWavelength bound the visible spectrum that spectral
+// renders sample, in nanometers
+const (
+	MinWavelength = 360.0
+	MaxWavelength = 830.0
+)
+
+// sensorScale scales the colors of the wavelengths so that they average
+// to white over the visible spectrum
+var sensorScale = func() Color {
+	sum := Color{}
+	steps := 0
+	for nm := MinWavelength; nm <= MaxWavelength; nm++ {
+		c := xyzToRGB(colorMatching(nm))
+		sum = *sum.Add(&c)
+		steps++
+	}
+	return Color{R: float64(steps) / sum.R, G: float64(steps) / sum.G, B: float64(steps) / sum.B}
+}()
+
+// WavelengthColor returns the color that the sensor records of light of
+// the wavelength in nanometers. Light of every visible wavelength alike
+// is white: the colors are scaled so that they average to white over the
+// visible spectrum. The colors out of the gamut, such as the ones of the
+// pure greens, are clipped.
+func WavelengthColor(nm float64) Color {
+	if nm < MinWavelength || nm > MaxWavelength {
+		return Black
+	}
+	c := xyzToRGB(colorMatching(nm))
+	return *c.CMultiply(&sensorScale)
+}
+
+// HeroWavelength returns the wavelength i of the n of hero wavelength
+// sampling: the hero one is the first, and the others are evenly spaced
+// from it across the visible spectrum, wrapping around at its end
+func HeroWavelength(hero float64, i, n int) float64 {
+	span := MaxWavelength - MinWavelength
+	return MinWavelength + math.Mod(hero-MinWavelength+float64(i)*span/float64(n), span)
+}
+
+// SpectralWeight returns the average of the colors of the n wavelengths of
+// the hero one, which is what the sensor records of light that all of them
+// carry alike
+func SpectralWeight(hero float64, n int) Color {
+	weight := Color{}
+	for i := 0; i < n; i++ {
+		c := WavelengthColor(HeroWavelength(hero, i, n))
+		weight = *weight.Add(&c)
+	}
+	return *weight.Divide(float64(n))
+}
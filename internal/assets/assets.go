@@ -0,0 +1,78 @@
+// Package assets opens the files scenes refer to, such as their meshes,
+// textures and profiles. They're read from the disk, unless they're among
+// the files mounted in memory, such as the ones of a decrypted scene
+// bundle, which are never written to the disk.
+package assets
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/internal/mmap"
+)
+
+// mounted holds the contents of the files mounted, by their clean paths
+var mounted = struct {
+	sync.RWMutex
+	files map[string][]byte
+}{files: make(map[string][]byte)}
+
+// Mount mounts the files, by their slash separated paths relative to dir,
+// so that they're opened from memory as if they were in dir, shadowing the
+// files on the disk. They stay mounted until unmount is called.
+func Mount(dir string, files map[string][]byte) (unmount func()) {
+	paths := make([]string, 0, len(files))
+	mounted.Lock()
+	defer mounted.Unlock()
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		mounted.files[path] = contents
+		paths = append(paths, path)
+	}
+	return func() {
+		mounted.Lock()
+		defer mounted.Unlock()
+		for _, path := range paths {
+			delete(mounted.files, path)
+		}
+	}
+}
+
+// contents returns the contents of the file mounted at path, if there's
+// one
+func contents(path string) ([]byte, bool) {
+	mounted.RLock()
+	defer mounted.RUnlock()
+	data, ok := mounted.files[filepath.Clean(path)]
+	return data, ok
+}
+
+// Open opens the file at path for reading
+func Open(path string) (io.ReadCloser, error) {
+	if data, ok := contents(path); ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return os.Open(path)
+}
+
+// ReadFile returns the contents of the file at path. The ones of mounted
+// files are shared, and must not be written to.
+func ReadFile(path string) ([]byte, error) {
+	if data, ok := contents(path); ok {
+		return data, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Map returns the contents of the file at path as mmap.Map does, or the
+// ones of the file mounted there, which are already in memory
+func Map(path string) ([]byte, error) {
+	if data, ok := contents(path); ok {
+		return data, nil
+	}
+	return mmap.Map(path)
+}
@@ -0,0 +1,40 @@
+package assets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMountedFilesShadowTheDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mesh.obj")
+	if err := ioutil.WriteFile(path, []byte("on disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unmount := Mount(dir, map[string][]byte{"mesh.obj": []byte("mounted"), "textures/wood.png": []byte("wood")})
+	file, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(file)
+	file.Close()
+	if string(data) != "mounted" {
+		t.Errorf("the mounted file should shadow the one on the disk, but %q was read", data)
+	}
+	if data, err := ReadFile(filepath.Join(dir, "textures", "..", "textures", "wood.png")); err != nil || string(data) != "wood" {
+		t.Errorf("mounted files should be found by any path to them, not %q (%v)", data, err)
+	}
+	unmount()
+	if data, err := Map(path); err != nil || string(data) != "on disk" {
+		t.Errorf("once unmounted, the file on the disk should be read, not %q (%v)", data, err)
+	}
+	if _, err := ReadFile(filepath.Join(dir, "textures", "wood.png")); err == nil {
+		t.Error("the files only mounted should be gone once unmounted")
+	}
+}
@@ -0,0 +1,32 @@
+// Package mmap maps files into memory read only, so that the operating
+// system reads their pages as they're touched and can drop them again
+// under memory pressure. Where mapping files isn't supported they're read
+// into the heap instead.
+package mmap
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// Map returns the contents of the file at path mapped into memory. The
+// mapping stays for as long as the process runs, and writing to it
+// crashes the process.
+func Map(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return []byte{}, nil
+	}
+	if int64(int(info.Size())) != info.Size() {
+		return ioutil.ReadAll(file)
+	}
+	return mapFile(file, int(info.Size()))
+}
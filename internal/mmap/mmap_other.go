@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package mmap
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// mapFile reads the file, which can't be mapped into memory here
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return ioutil.ReadAll(file)
+}
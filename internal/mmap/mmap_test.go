@@ -0,0 +1,33 @@
+package mmap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	want := bytes.Repeat([]byte("mapped"), 1000)
+	if err := ioutil.WriteFile(path, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Map(path); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("The mapping should hold the contents of the file (%v)", err)
+	}
+	empty := filepath.Join(dir, "empty")
+	ioutil.WriteFile(empty, nil, 0644)
+	if got, err := Map(empty); err != nil || len(got) != 0 {
+		t.Errorf("Empty files should map to no bytes, not %d (%v)", len(got), err)
+	}
+	if _, err := Map(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Missing files can't be mapped")
+	}
+}
@@ -0,0 +1,8 @@
+// Package priority lowers the priority the operating system schedules the
+// process with, so that renders in the background leave the CPUs to the
+// programs of the user first rather than only resting now and then.
+package priority
+
+// Niceness is the nice value of the processes whose priority is lowered on
+// Unix systems, halfway to the lowest priority
+const Niceness = 10
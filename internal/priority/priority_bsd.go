@@ -0,0 +1,18 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package priority
+
+import "syscall"
+
+// Lower lowers the priority of the process to Niceness, leaving it alone if
+// it's already below it
+func Lower() error {
+	nice, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return err
+	}
+	if nice >= Niceness {
+		return nil
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, Niceness)
+}
@@ -0,0 +1,42 @@
+package priority
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+)
+
+// Lower lowers the priority of every thread of the process to Niceness,
+// leaving the ones already below it alone. Linux gives every thread a nice
+// value of its own, which the threads they start inherit, so the threads
+// the Go runtime starts later are lowered too.
+func Lower() error {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return lower(0)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		// The threads that ended since the directory was read are gone
+		if err := lower(tid); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// lower lowers the priority of the thread to Niceness if it's above it.
+// The system call returns 20 minus the nice value of the thread.
+func lower(tid int) error {
+	priority, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+	if err != nil {
+		return err
+	}
+	if 20-priority >= Niceness {
+		return nil
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, tid, Niceness)
+}
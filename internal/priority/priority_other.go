@@ -0,0 +1,10 @@
+//go:build !(linux || windows || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package priority
+
+import "errors"
+
+// Lower fails, since the priority of processes can't be lowered here
+func Lower() error {
+	return errors.New("the priority of the process can't be lowered on this system")
+}
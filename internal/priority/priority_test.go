@@ -0,0 +1,18 @@
+package priority
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestLowerLowersThePriorityOnce(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		t.Skip("the priority can't be lowered on " + runtime.GOOS)
+	}
+	// Lowering it again, when it's already low, must work too
+	for i := 0; i < 2; i++ {
+		if err := Lower(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
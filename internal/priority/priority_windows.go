@@ -0,0 +1,20 @@
+package priority
+
+import "syscall"
+
+// belowNormalPriorityClass is the BELOW_NORMAL_PRIORITY_CLASS of Windows
+const belowNormalPriorityClass = 0x4000
+
+var setPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+// Lower moves the process to the below normal priority class of Windows
+func Lower() error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	if ok, _, err := setPriorityClass.Call(uintptr(process), belowNormalPriorityClass); ok == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Package simd tests packets of lightrays against bounding boxes several
+// lanes at once, so a BVH traverses its nodes once for the whole packet
+// rather than once for every lightray. The lightrays are stored as
+// structures of arrays so every lane of an operation reads contiguous
+// memory. On amd64 the kernel is SSE2 assembly, which every amd64 CPU has;
+// the pure Go kernel is used everywhere else and with the purego build
+// tag. Both give the same lanes.
+package simd
+
+import "github.com/ProjectMOA/goraytrace/math3d"
+
+// Lanes is the number of lightrays in a packet
+const Lanes = 4
+
+// Vec3x4 holds four 3D vectors, one per lane
+type Vec3x4 struct {
+	X, Y, Z [Lanes]float64
+}
+
+// Set stores v in the lane i
+func (v *Vec3x4) Set(i int, v3 *math3d.Vector3) {
+	v.X[i], v.Y[i], v.Z[i] = v3.X, v3.Y, v3.Z
+}
+
+// RayPacket4 holds four lightrays that are traced together. The inverse
+// of the directions is stored because it's what the box tests need.
+type RayPacket4 struct {
+	Source, InvDirection Vec3x4
+}
+
+// SetRay stores the lightray in the lane i of the packet
+func (p *RayPacket4) SetRay(i int, lr *math3d.LightRay) {
+	p.Source.Set(i, &lr.Source)
+	p.InvDirection.Set(i, &math3d.Vector3{X: 1 / lr.Direction.X, Y: 1 / lr.Direction.Y, Z: 1 / lr.Direction.Z})
+}
+
+// IntersectAABB returns a mask with the bit i set if the lightray in the
+// lane i enters the box closer than maxDistance[i], as the slab test of
+// math3d.AABB.Intersect finds it. The lightrays that are parallel to a
+// side of the box and lie on its plane may be taken as entering it.
+func (p *RayPacket4) IntersectAABB(box *math3d.AABB, maxDistance *[Lanes]float64) uint8 {
+	return slabs4(box, p, maxDistance)
+}
+
+// gamma3 bounds the relative rounding error of three floating point
+// operations, as math3d does
+const gamma3 = 3 * 0x1p-53 / (1 - 3*0x1p-53)
+
+// farScale pushes back the distances at which the lightrays leave the
+// boxes by the most that rounding can take away from them, as
+// math3d.AABB.IntersectRange does. The assembly kernel reads it too.
+var farScale = 1 + 2*gamma3
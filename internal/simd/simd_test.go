@@ -0,0 +1,94 @@
+package simd
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func randomVector(rng *rand.Rand) math3d.Vector3 {
+	return math3d.Vector3{X: rng.Float64()*4 - 2, Y: rng.Float64()*4 - 2, Z: rng.Float64()*4 - 2}
+}
+
+func TestIntersectAABBIsTheSlabTest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 10000; n++ {
+		a, b := randomVector(rng), randomVector(rng)
+		box := math3d.AABB{Min: math3d.Vector3{X: math.Min(a.X, b.X), Y: math.Min(a.Y, b.Y), Z: math.Min(a.Z, b.Z)},
+			Max: math3d.Vector3{X: math.Max(a.X, b.X), Y: math.Max(a.Y, b.Y), Z: math.Max(a.Z, b.Z)}}
+		var p RayPacket4
+		var rays [Lanes]math3d.LightRay
+		var maxDistance [Lanes]float64
+		for i := range rays {
+			rays[i] = math3d.LightRay{Source: randomVector(rng), Direction: randomVector(rng)}
+			// Some of the lightrays are parallel to a side of the box
+			if rng.Intn(8) == 0 {
+				rays[i].Direction.Y = 0
+			}
+			p.SetRay(i, &rays[i])
+			maxDistance[i] = rng.Float64() * 3
+		}
+		mask := p.IntersectAABB(&box, &maxDistance)
+		if generic := slabs4Go(&box, &p, &maxDistance); mask != generic {
+			t.Fatalf("The kernel gives the lanes %04b and the Go one %04b for %v and %v", mask, generic, box, rays)
+		}
+		for i := range rays {
+			entered := box.Intersect(&rays[i]) < maxDistance[i]
+			if entered != (mask&(1<<uint(i)) != 0) {
+				t.Fatalf("The lane %d of %04b should be %v for %v and %v", i, mask, entered, box, rays[i])
+			}
+		}
+	}
+}
+
+func TestIntersectAABBOfEdgeCases(t *testing.T) {
+	box := math3d.AABB{Min: math3d.Vector3{X: -1, Y: -1, Z: -1}, Max: math3d.Vector3{X: 1, Y: 1, Z: 1}}
+	var p RayPacket4
+	for i, lr := range []math3d.LightRay{
+		// Inside the box
+		{Direction: math3d.Vector3{X: 1}},
+		// Along an edge, parallel to two sides, which the slab test misses
+		// as math3d does
+		{Source: math3d.Vector3{X: -3, Y: 1, Z: 1}, Direction: math3d.Vector3{X: 1}},
+		// Away from the box
+		{Source: math3d.Vector3{X: 3}, Direction: math3d.Vector3{X: 1}},
+		// Behind the maximum distance
+		{Source: math3d.Vector3{Z: -10}, Direction: math3d.Vector3{Z: 1}},
+	} {
+		p.SetRay(i, &lr)
+	}
+	maxDistance := [Lanes]float64{math.MaxFloat64, math.MaxFloat64, math.MaxFloat64, 5}
+	if mask, generic := p.IntersectAABB(&box, &maxDistance), slabs4Go(&box, &p, &maxDistance); mask != 0b0001 || generic != mask {
+		t.Errorf("Only the first lightray should enter the box, not %04b and %04b", mask, generic)
+	}
+}
+
+func BenchmarkIntersectAABB(b *testing.B) {
+	box := math3d.AABB{Min: math3d.Vector3{X: -1, Y: -1, Z: -1}, Max: math3d.Vector3{X: 1, Y: 1, Z: 1}}
+	var p RayPacket4
+	var rays [Lanes]math3d.LightRay
+	for i := range rays {
+		rays[i] = math3d.LightRay{Source: math3d.Vector3{X: float64(i) * 0.1, Z: -5}, Direction: math3d.Vector3{X: 0.01, Y: 0.02, Z: 1}}
+		p.SetRay(i, &rays[i])
+	}
+	maxDistance := [Lanes]float64{math.MaxFloat64, math.MaxFloat64, math.MaxFloat64, math.MaxFloat64}
+	b.Run("packet", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			p.IntersectAABB(&box, &maxDistance)
+		}
+	})
+	b.Run("go", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			slabs4Go(&box, &p, &maxDistance)
+		}
+	})
+	b.Run("scalar", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := range rays {
+				_ = box.Intersect(&rays[i]) < maxDistance[i]
+			}
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package simd
+
+import "github.com/ProjectMOA/goraytrace/math3d"
+
+// slabs4Go runs the slab test on every lane in Go. Its minimum and maximum
+// return the second value if either is NaN, as the MINPD and MAXPD
+// instructions of the assembly kernel do, so both give the same lanes.
+func slabs4Go(box *math3d.AABB, p *RayPacket4, maxDistance *[Lanes]float64) uint8 {
+	mask := uint8(0)
+	for i := 0; i < Lanes; i++ {
+		tx1, tx2 := (box.Min.X-p.Source.X[i])*p.InvDirection.X[i], (box.Max.X-p.Source.X[i])*p.InvDirection.X[i]
+		ty1, ty2 := (box.Min.Y-p.Source.Y[i])*p.InvDirection.Y[i], (box.Max.Y-p.Source.Y[i])*p.InvDirection.Y[i]
+		tz1, tz2 := (box.Min.Z-p.Source.Z[i])*p.InvDirection.Z[i], (box.Max.Z-p.Source.Z[i])*p.InvDirection.Z[i]
+		near := maxpd(maxpd(maxpd(minpd(tx1, tx2), minpd(ty1, ty2)), minpd(tz1, tz2)), 0)
+		far := minpd(minpd(maxpd(tx1, tx2), maxpd(ty1, ty2)), maxpd(tz1, tz2)) * farScale
+		if near <= far && near < maxDistance[i] {
+			mask |= 1 << uint(i)
+		}
+	}
+	return mask
+}
+
+func minpd(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxpd(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
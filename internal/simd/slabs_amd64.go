@@ -0,0 +1,10 @@
+//go:build amd64 && !purego
+
+package simd
+
+import "github.com/ProjectMOA/goraytrace/math3d"
+
+// slabs4 is implemented with SSE2 in slabs_amd64.s, two lanes at a time.
+//
+//go:noescape
+func slabs4(box *math3d.AABB, p *RayPacket4, maxDistance *[Lanes]float64) uint8
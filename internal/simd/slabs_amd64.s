@@ -0,0 +1,81 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// PAIR runs the slab test on the two lanes of the packet in BX at the byte
+// offset off, leaving their mask in R8. X8 to X13 hold the box, X14 the
+// scale of the exits and X15 zero.
+#define PAIR(off) \
+	MOVUPD off(BX), X0 \
+	MOVUPD off+96(BX), X1 \
+	MOVAPD X8, X2 \
+	SUBPD  X0, X2 \
+	MULPD  X1, X2 \
+	MOVAPD X11, X3 \
+	SUBPD  X0, X3 \
+	MULPD  X1, X3 \
+	MOVAPD X2, X4 \
+	MINPD  X3, X4 \
+	MAXPD  X3, X2 \
+	MOVUPD off+32(BX), X0 \
+	MOVUPD off+128(BX), X1 \
+	MOVAPD X9, X3 \
+	SUBPD  X0, X3 \
+	MULPD  X1, X3 \
+	MOVAPD X12, X5 \
+	SUBPD  X0, X5 \
+	MULPD  X1, X5 \
+	MOVAPD X3, X6 \
+	MINPD  X5, X6 \
+	MAXPD  X5, X3 \
+	MAXPD  X6, X4 \
+	MINPD  X3, X2 \
+	MOVUPD off+64(BX), X0 \
+	MOVUPD off+160(BX), X1 \
+	MOVAPD X10, X3 \
+	SUBPD  X0, X3 \
+	MULPD  X1, X3 \
+	MOVAPD X13, X5 \
+	SUBPD  X0, X5 \
+	MULPD  X1, X5 \
+	MOVAPD X3, X6 \
+	MINPD  X5, X6 \
+	MAXPD  X5, X3 \
+	MAXPD  X6, X4 \
+	MINPD  X3, X2 \
+	MAXPD  X15, X4 \
+	MULPD  X14, X2 \
+	MOVAPD X4, X7 \
+	CMPPD  X2, X7, $2 \
+	MOVUPD off(CX), X0 \
+	CMPPD  X0, X4, $1 \
+	ANDPD  X4, X7 \
+	MOVMSKPD X7, R8
+
+// func slabs4(box *math3d.AABB, p *RayPacket4, maxDistance *[Lanes]float64) uint8
+TEXT ·slabs4(SB), NOSPLIT, $0-25
+	MOVQ box+0(FP), AX
+	MOVQ p+8(FP), BX
+	MOVQ maxDistance+16(FP), CX
+	MOVSD    0(AX), X8
+	UNPCKLPD X8, X8
+	MOVSD    8(AX), X9
+	UNPCKLPD X9, X9
+	MOVSD    16(AX), X10
+	UNPCKLPD X10, X10
+	MOVSD    24(AX), X11
+	UNPCKLPD X11, X11
+	MOVSD    32(AX), X12
+	UNPCKLPD X12, X12
+	MOVSD    40(AX), X13
+	UNPCKLPD X13, X13
+	MOVSD    ·farScale(SB), X14
+	UNPCKLPD X14, X14
+	XORPD    X15, X15
+	PAIR(0)
+	MOVQ R8, DX
+	PAIR(16)
+	SHLQ $2, R8
+	ORQ  R8, DX
+	MOVB DX, ret+24(FP)
+	RET
@@ -0,0 +1,10 @@
+//go:build !amd64 || purego
+
+package simd
+
+import "github.com/ProjectMOA/goraytrace/math3d"
+
+// slabs4 is the portable version of the slab test kernel
+func slabs4(box *math3d.AABB, p *RayPacket4, maxDistance *[Lanes]float64) uint8 {
+	return slabs4Go(box, p, maxDistance)
+}
@@ -0,0 +1,67 @@
+// Package jsonutil decodes the JSON objects of scene files strictly, so
+// that mistyped keys and values are errors instead of zero values.
+package jsonutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Object decodes the JSON object in data into fields, which holds a
+// pointer to decode the value of every known key into. It fails if data
+// isn't an object, if it has a key that isn't known or a null value, if
+// it lacks one of the required keys or if a value can't be decoded into
+// its pointer. The keys that are missing leave their pointers untouched.
+func Object(data []byte, fields map[string]interface{}, required ...string) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil || values == nil {
+		return fmt.Errorf("not an object")
+	}
+	// Sorted keys make the error the same every time
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		field, known := fields[k]
+		if !known {
+			return fmt.Errorf("unknown key %q", k)
+		}
+		if bytes.Equal(values[k], []byte("null")) {
+			return fmt.Errorf("%s: null", k)
+		}
+		if err := json.Unmarshal(values[k], field); err != nil {
+			return fmt.Errorf("%s: %v", k, describe(err))
+		}
+	}
+	for _, k := range required {
+		if _, present := values[k]; !present {
+			return fmt.Errorf("missing key %q", k)
+		}
+	}
+	return nil
+}
+
+// Finite returns an error if any of the values is NaN or infinite, which
+// JSON can't represent
+func Finite(values ...float64) error {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%v isn't finite", v)
+		}
+	}
+	return nil
+}
+
+// describe shortens the errors of encoding/json about mistyped values,
+// which name Go types rather than JSON ones
+func describe(err error) error {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		return fmt.Errorf("a %s can't be a %s", typeErr.Value, typeErr.Type)
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package jsonutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestObject(t *testing.T) {
+	tests := []struct {
+		json string
+		err  string
+	}{
+		{`{"a": 1, "b": "two"}`, ""},
+		{`{"a": 1}`, ""},
+		{`{"b": "two"}`, `missing key "a"`},
+		{`{"a": 1, "c": 3}`, `unknown key "c"`},
+		{`{"a": "one"}`, "a: a string can't be a float64"},
+		{`{"a": null}`, "a: null"},
+		{`[1, 2]`, "not an object"},
+		{`null`, "not an object"},
+		{`{"a": 1e999}`, "a:"},
+	}
+	for _, test := range tests {
+		var a float64
+		var b string
+		err := Object([]byte(test.json), map[string]interface{}{"a": &a, "b": &b}, "a")
+		if test.err == "" && err != nil {
+			t.Errorf("%s should decode, but %v", test.json, err)
+		}
+		if test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)) {
+			t.Errorf("%s should fail with %q, not %v", test.json, test.err, err)
+		}
+	}
+}
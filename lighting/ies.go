@@ -0,0 +1,205 @@
+package lighting
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Profile is the distribution of the light of a luminaire over the
+// directions, read from an IES LM-63 photometric file, as manufacturers
+// publish them. The luminaire points down: the vertical angles go from
+// straight down (-Y) at 0 degrees to straight up at 180, and the
+// horizontal ones go around Y from +X at 0 degrees to -Z at 90. The
+// intensities are relative to the brightest direction, which is 1.
+type Profile struct {
+	// File is the path of the file the profile was read from
+	File string
+	// vertical and horizontal are the angles in degrees, and candela the
+	// relative intensities for every horizontal angle at every vertical one
+	vertical, horizontal []float64
+	candela              [][]float64
+}
+
+// LoadProfile reads the profile of the IES file at path
+func LoadProfile(path string) (*Profile, error) {
+	file, err := assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	p, err := ParseIES(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the IES profile %s: %v", path, err)
+	}
+	p.File = path
+	return p, nil
+}
+
+// ParseIES reads a profile in the IES LM-63 format. Only the type C
+// photometry of almost every luminaire is supported, and the tilt of the
+// lamp is ignored.
+func ParseIES(r io.Reader) (*Profile, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	// The keywords of the header end with the tilt of the lamp
+	tilt := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "TILT=") {
+			tilt = strings.TrimPrefix(line, "TILT=")
+			break
+		}
+	}
+	if tilt == "" {
+		return nil, errors.New("there's no TILT line")
+	}
+	var numbers []float64
+	for scanner.Scan() {
+		for _, field := range strings.FieldsFunc(scanner.Text(), func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' }) {
+			n, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q isn't a number", field)
+			}
+			numbers = append(numbers, n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	next := func(count int) ([]float64, error) {
+		if count < 0 || count > len(numbers) {
+			return nil, errors.New("the file ends too soon")
+		}
+		taken := numbers[:count]
+		numbers = numbers[count:]
+		return taken, nil
+	}
+	switch tilt {
+	case "NONE":
+	case "INCLUDE":
+		// The geometry of the lamp, and the angles and factors of the tilt
+		head, err := next(2)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := next(2 * int(head[1])); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("the tilt file %s isn't supported", tilt)
+	}
+
+	// The lamps and their lumens, the multiplier of the intensities, the
+	// number of angles, the type of photometry, the units and the size,
+	// and then the ballast factors and the watts
+	head, err := next(13)
+	if err != nil {
+		return nil, err
+	}
+	multiplier, verticals, horizontals := head[2], int(head[3]), int(head[4])
+	if head[5] != 1 {
+		return nil, errors.New("only type C photometry is supported")
+	}
+	if verticals < 1 || horizontals < 1 || verticals*horizontals > 1<<20 {
+		return nil, errors.New("the number of angles is wrong")
+	}
+	p := &Profile{}
+	if p.vertical, err = next(verticals); err != nil {
+		return nil, err
+	}
+	if p.horizontal, err = next(horizontals); err != nil {
+		return nil, err
+	}
+	if !sort.Float64sAreSorted(p.vertical) || !sort.Float64sAreSorted(p.horizontal) {
+		return nil, errors.New("the angles must go up")
+	}
+	peak := 0.0
+	for i := 0; i < horizontals; i++ {
+		row, err := next(verticals)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range row {
+			if !(c*multiplier >= 0) || math.IsInf(c*multiplier, 0) {
+				return nil, errors.New("the intensities must be finite and non negative")
+			}
+			peak = math.Max(peak, c*multiplier)
+		}
+		p.candela = append(p.candela, row)
+	}
+	if len(numbers) > 0 {
+		return nil, errors.New("there are more intensities than angles")
+	}
+	if peak == 0 {
+		return nil, errors.New("the luminaire emits no light")
+	}
+	for _, row := range p.candela {
+		for j := range row {
+			row[j] *= multiplier / peak
+		}
+	}
+	return p, nil
+}
+
+// At returns the relative intensity of the luminaire towards the unit
+// direction dir
+func (p *Profile) At(dir *math3d.Vector3) float64 {
+	vertical := math.Acos(math.Max(-1, math.Min(1, -dir.Y))) * 180 / math.Pi
+	horizontal := math.Atan2(-dir.Z, dir.X) * 180 / math.Pi
+	if horizontal < 0 {
+		horizontal += 360
+	}
+	// The horizontal angles of symmetric luminaires only cover a half or
+	// a quarter of the circle, or a single angle
+	switch last := p.horizontal[len(p.horizontal)-1]; {
+	case last == 90:
+		if horizontal > 180 {
+			horizontal = 360 - horizontal
+		}
+		if horizontal > 90 {
+			horizontal = 180 - horizontal
+		}
+	case last == 180 && horizontal > 180:
+		horizontal = 360 - horizontal
+	}
+	if vertical < p.vertical[0] || vertical > p.vertical[len(p.vertical)-1] {
+		return 0
+	}
+	i, s := interval(p.horizontal, horizontal)
+	j, t := interval(p.vertical, vertical)
+	at := func(i int) float64 {
+		row := p.candela[i]
+		return row[j]*(1-t) + row[minInt(j+1, len(row)-1)]*t
+	}
+	return at(i)*(1-s) + at(minInt(i+1, len(p.candela)-1))*s
+}
+
+// interval returns the index of the last of the sorted angles that isn't
+// after angle, and how far angle is from it to the next one, as a fraction
+// of the interval between both. Angles outside of them are clamped.
+func interval(angles []float64, angle float64) (int, float64) {
+	i := sort.SearchFloat64s(angles, angle)
+	switch {
+	case i == 0:
+		return 0, 0
+	case i == len(angles):
+		return len(angles) - 1, 0
+	}
+	return i - 1, (angle - angles[i-1]) / (angles[i] - angles[i-1])
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
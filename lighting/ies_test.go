@@ -0,0 +1,100 @@
+package lighting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// downlight is a luminaire that only lights downwards, twice as brightly
+// along X as along Z
+const downlight = `IESNA:LM-63-2002
+[MANUFAC] goraytrace
+TILT=NONE
+1 1000 2 3 2 1 1 0 0 0
+1 1 10
+0 45 90
+0 90
+50 25 0
+25, 12.5, 0
+`
+
+func TestParseIES(t *testing.T) {
+	p, err := ParseIES(strings.NewReader(downlight))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		dir       math3d.Vector3
+		intensity float64
+	}{
+		{math3d.Vector3{Y: -1}, 1},
+		{math3d.Vector3{X: 1, Y: -1}, 0.5},
+		{math3d.Vector3{X: -1, Y: -1}, 0.5},
+		{math3d.Vector3{Z: 1, Y: -1}, 0.25},
+		{math3d.Vector3{X: math.Sin(math.Pi / 8), Y: -math.Cos(math.Pi / 8)}, 0.75},
+		{math3d.Vector3{X: 1, Y: 1}, 0},
+	} {
+		dir := test.dir.NormalizedV()
+		if intensity := p.At(&dir); math.Abs(intensity-test.intensity) > 1e-9 {
+			t.Errorf("The intensity towards %v should be %g, not %g", test.dir, test.intensity, intensity)
+		}
+	}
+	for _, invalid := range []string{
+		"IESNA:LM-63-2002\n1 1000 1 3 1 1 1 0 0 0\n1 1 10\n0 45 90\n0\n1 1 1\n",
+		strings.Replace(downlight, "1 1000 2 3 2 1 1", "1 1000 2 3 2 2 1", 1),
+		strings.Replace(downlight, "25, 12.5, 0\n", "25, 12.5\n", 1),
+		strings.Replace(downlight, "25, 12.5, 0\n", "25, 12.5, 0, 1\n", 1),
+		strings.Replace(downlight, "TILT=NONE", "TILT=lamp.tlt", 1),
+		strings.Replace(downlight, "0 45 90", "0 90 45", 1),
+	} {
+		if _, err := ParseIES(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q shouldn't be read", invalid)
+		}
+	}
+}
+
+func TestPointLightsWithProfilesAndTemperatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "downlight.ies")
+	if err := ioutil.WriteFile(path, []byte(downlight), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"type": "point", "position": map[string]float64{"x": 0, "y": 2, "z": 0}, "intensity": map[string]float64{"r": 4, "g": 4, "b": 4},
+		"temperature": 2700, "profile": path,
+	})
+	light, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	below := light.Sample(&math3d.Vector3{}, 0, 0).Radiance
+	if !(below.R > below.G && below.G > below.B) || math.Abs(below.Luminance()-4) > 0.1 {
+		t.Errorf("The light should be warm with the intensity of its brightest direction below it, not %s", below.String())
+	}
+	if above := light.Sample(&math3d.Vector3{Y: 4}, 0, 0).Radiance; above != image.Black {
+		t.Errorf("The downlight shouldn't light above it, it gives %s", above.String())
+	}
+	encoded, err := json.Marshal(light)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := Unmarshal(encoded); err != nil || decoded.(*PointLight).Profile.File != path || decoded.(*PointLight).Temperature != 2700 {
+		t.Errorf("%s should keep the profile and the temperature (%v)", encoded, err)
+	}
+	missing := strings.Replace(string(data), "downlight.ies", "missing.ies", 1)
+	if _, err := Unmarshal([]byte(missing)); err == nil {
+		t.Error("A light whose profile can't be read shouldn't decode")
+	}
+}
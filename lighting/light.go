@@ -1,4 +1,122 @@
 package lighting
 
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
 // Light defines types that contain all the functionality of a light source
-type Light interface{}
+type Light interface {
+	// Sample returns the light arriving at point from a point of the light
+	// chosen with two uniform numbers u, v in [0, 1)
+	Sample(point *math3d.Vector3, u, v float64) Sample
+	// Pdf returns the density with respect to solid angle with which
+	// Sample chooses the unit direction dir from point. It's 0 if dir
+	// misses the light, and for lights that directions can't hit.
+	Pdf(point, dir *math3d.Vector3) float64
+	// Intersect returns the distance at which the lightray hits the light
+	// and the radiance it emits towards the source of the lightray. The
+	// distance is math.MaxFloat64 if it misses it.
+	Intersect(lr *math3d.LightRay) (float64, image.Color)
+	Bounds() *math3d.AABB
+	Translate(offset *math3d.Vector3)
+	AsMap() map[string]interface{}
+}
+
+// AreaLight is a light with a surface that emits light, so paths of light
+// can start on it and lightrays can hit it
+type AreaLight interface {
+	Light
+	// SampleSurface returns a point of the surface of the light and its
+	// normal there, chosen with two uniform numbers u, v in [0, 1). Every
+	// point is as likely, with a density of one over the area.
+	SampleSurface(u, v float64) (point, normal math3d.Vector3)
+	// NormalAt returns the normal of the surface of the light at point
+	NormalAt(point *math3d.Vector3) math3d.Vector3
+	// Area returns the area of the surface of the light
+	Area() float64
+	// Emission returns the radiance that a point of the surface with the
+	// normal emits towards the unit direction dir
+	Emission(normal, dir *math3d.Vector3) image.Color
+	// SampleEmission returns a direction in which the light leaves a point
+	// of the surface with the normal, chosen with two uniform numbers u, v
+	// in [0, 1), and its density with respect to solid angle
+	SampleEmission(normal *math3d.Vector3, u, v float64) (math3d.Vector3, float64)
+	// EmissionPdf returns the density with which SampleEmission chooses
+	// the unit direction dir
+	EmissionPdf(normal, dir *math3d.Vector3) float64
+}
+
+// Far is the distance at which lightrays hit distant lights. It's farther
+// than any shape, but finite so that lightrays that miss every shape still
+// hit them.
+const Far = 1e30
+
+// Distant is a light so far away that its light arrives at every point of
+// the scene alike, such as the sky and the sun. Its bounds are empty, and
+// lightrays hit it at a distance of about Far.
+type Distant interface {
+	Light
+	// Radiance returns the radiance arriving from the light along the
+	// unit direction dir, towards the light
+	Radiance(dir *math3d.Vector3) image.Color
+}
+
+// Sample is the light arriving at a point from a point of a light
+type Sample struct {
+	// Direction is the unit vector from the point towards the light
+	Direction math3d.Vector3
+	// Distance is the distance from the point to the light
+	Distance float64
+	// Radiance is the light arriving at the point along Direction, not
+	// counting the shapes and media in between
+	Radiance image.Color
+	// Pdf is the density with respect to solid angle of the direction. It
+	// is 0 if there's no light arriving at the point.
+	Pdf float64
+	// Delta is true if the light can only arrive from Direction, as with
+	// point lights. Then Radiance holds the whole contribution of the light
+	// and Pdf is 1.
+	Delta bool
+}
+
+// AsMap turns the input slice of lights to a slice of maps that can be
+// serialized.
+func AsMap(lights []Light) []map[string]interface{} {
+	retval := make([]map[string]interface{}, 0, len(lights))
+	for _, l := range lights {
+		retval = append(retval, l.AsMap())
+	}
+	return retval
+}
+
+// Unmarshal returns the light in the JSON object, whose "type" is the
+// type of light. Lights without a type are point lights.
+func Unmarshal(data []byte) (Light, error) {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, fmt.Errorf("not an object")
+	}
+	var light Light
+	switch typed.Type {
+	case "", "point":
+		light = &PointLight{}
+	case "sphere":
+		light = &SphereLight{}
+	case "sky":
+		light = &SkyLight{}
+	case "sun":
+		light = &SunLight{}
+	default:
+		return nil, fmt.Errorf("unknown light type %q", typed.Type)
+	}
+	if err := json.Unmarshal(data, light); err != nil {
+		return nil, err
+	}
+	return light, nil
+}
@@ -0,0 +1,106 @@
+package lighting
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestLightJSON(t *testing.T) {
+	lights := []Light{
+		&PointLight{Position: math3d.Vector3{X: 1, Y: 2, Z: 3}, Intensity: image.Color{R: 4, G: 5, B: 6}},
+		&SphereLight{Position: math3d.UnitY, Radius: 0.5, Radiance: image.White, TwoSided: true},
+		&SphereLight{Position: math3d.UnitY, Radius: 0.5, Radiance: image.White, Temperature: 3200},
+		NewSkyLight(math3d.UnitY, 4, image.Color{R: 0.2, G: 0.3, B: 0.1}, 0.5),
+		&SunLight{Direction: math3d.UnitY, AngularRadius: 0.01, Irradiance: image.Color{R: 100, G: 90, B: 80}},
+	}
+	for _, l := range lights {
+		data, err := json.Marshal(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Unmarshal(data)
+		if err != nil || !reflect.DeepEqual(decoded, l) {
+			t.Errorf("%s should decode to %v, not %v (%v)", data, l, decoded, err)
+		}
+	}
+	for _, invalid := range []string{
+		`{"type": "spot", "position": {"x": 0, "y": 0, "z": 0}, "intensity": {}}`,
+		`{"position": {"x": 0, "y": 0, "z": 0}}`,
+		`{"position": {"x": 0, "y": 0, "z": 0}, "intensity": {"r": -1}}`,
+		`{"position": {"x": 0, "y": 0, "z": 0}, "intensity": {}, "radius": 1}`,
+		`{"type": "sphere", "position": {"x": 0, "y": 0, "z": 0}, "radiance": {}, "radius": 0}`,
+		`{"type": "sphere", "position": {"x": 0, "y": 0, "z": 0}, "radiance": {}, "radius": 1, "twosided": 1}`,
+		`{"type": "sky", "sundirection": {"x": 0, "y": 0, "z": 0}}`,
+		`{"type": "sky", "sundirection": {"x": 0, "y": 1, "z": 0}, "turbidity": 20}`,
+		`{"type": "sun", "direction": {"x": 0, "y": 1, "z": 0}}`,
+		`{"type": "sun", "direction": {"x": 0, "y": 1, "z": 0}, "irradiance": {}, "turbidity": 3}`,
+		`{"type": "sun", "direction": {"x": 0, "y": 1, "z": 0}, "irradiance": {}, "strength": 2}`,
+		`{"type": "sun", "direction": {"x": 0, "y": 1, "z": 0}, "irradiance": {}, "angularradius": 2}`,
+		`{"type": "sphere", "position": {"x": 0, "y": 0, "z": 0}, "radiance": {}, "radius": 1, "temperature": -1}`,
+		`[]`,
+	} {
+		if _, err := Unmarshal([]byte(invalid)); err == nil {
+			t.Errorf("%s shouldn't decode", invalid)
+		}
+	}
+}
+
+func TestSkyIsBlueOverheadAndBrightestAroundTheSun(t *testing.T) {
+	sunDirection := math3d.Vector3{X: 1, Y: 1}.NormalizedV()
+	sky := NewSkyLight(sunDirection, 3, image.Color{R: 0.3, G: 0.3, B: 0.3}, 1)
+	zenith := sky.Radiance(&math3d.UnitY)
+	if !(zenith.B > zenith.R) || zenith.Luminance() < 1 || zenith.Luminance() > 20 {
+		t.Errorf("The sky overhead should be blue and a few thousand cd/m², not %s", zenith.String())
+	}
+	nearSun := math3d.Vector3{X: 1, Y: 0.9}.NormalizedV()
+	away := math3d.Vector3{X: -1, Y: 0.9}.NormalizedV()
+	if near, far := sky.Radiance(&nearSun), sky.Radiance(&away); near.Luminance() <= 2*far.Luminance() {
+		t.Errorf("The sky around the sun should be brighter than away from it, %s against %s", near.String(), far.String())
+	}
+	down := math3d.Vector3{Y: -1}
+	if ground := sky.Radiance(&down); !(ground.Luminance() > 0) || math.Abs(ground.R-ground.B) > ground.R {
+		t.Errorf("The ground should be lit and greyish, not %s", ground.String())
+	}
+	hazy := NewSkyLight(sunDirection, 8, image.Black, 1)
+	clear, haze := sky.Sun().Irradiance, hazy.Sun().Irradiance
+	if haze.Luminance() >= clear.Luminance() || haze.B/haze.R >= clear.B/clear.R {
+		t.Errorf("The sun should be dimmer and redder through haze, %s against %s", haze.String(), clear.String())
+	}
+	decoded, err := Unmarshal([]byte(`{"type": "sun", "direction": {"x": 0, "y": 1, "z": 0}, "turbidity": 3}`))
+	if sun := NewSkyLight(math3d.UnitY, 3, image.Black, 1).Sun(); err != nil || !reflect.DeepEqual(decoded, sun) {
+		t.Errorf("A sun with a turbidity should be the sun of the sky, %v, not %v (%v)", sun, decoded, err)
+	}
+	if sunset := NewSkyLight(math3d.Vector3{X: 1}, 3, image.Black, 1).Sun(); sunset.Irradiance != image.Black {
+		t.Errorf("The sun below the horizon shouldn't light anything, it gives %s", sunset.Irradiance.String())
+	}
+}
+
+func TestSunSamplesMatchItsIrradiance(t *testing.T) {
+	sun := &SunLight{Direction: math3d.Vector3{Y: 1, Z: 1}.NormalizedV(), AngularRadius: 0.1, Irradiance: image.White}
+	point := math3d.Vector3{}
+	// The radiance over the cone of the sun adds up to its irradiance
+	sum := 0.0
+	const n = 1000
+	for i := 0; i < n; i++ {
+		s := sun.Sample(&point, (float64(i)+0.5)/n, float64(i%7)/7)
+		if s.Distance != Far/2 || math.Abs(sun.Pdf(&point, &s.Direction)-s.Pdf) > 1e-9 {
+			t.Fatalf("The sample %v should be far away and have the density of Pdf", s)
+		}
+		sum += s.Radiance.R / s.Pdf
+	}
+	if math.Abs(sum/n-1) > 1e-6 {
+		t.Errorf("The sun should give an irradiance of 1, not %f", sum/n)
+	}
+	if d, _ := sun.Intersect(&math3d.LightRay{Direction: math3d.UnitY}); d != math.MaxFloat64 {
+		t.Errorf("A ray away from the sun shouldn't hit it, it does at %g", d)
+	}
+	sharp := &SunLight{Direction: math3d.UnitY, Irradiance: image.White}
+	if s := sharp.Sample(&point, 0.5, 0.5); !s.Delta || s.Direction != math3d.UnitY {
+		t.Errorf("A sun without an angular radius should light from its direction alone, not %v", s)
+	}
+}
@@ -1,8 +1,13 @@
 package lighting
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+
 	"github.com/ProjectMOA/goraytrace/image"
-	"github.com/ProjectMOA/goraytrace/maputil"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
 	"github.com/ProjectMOA/goraytrace/math3d"
 )
 
@@ -10,17 +15,136 @@ import (
 type PointLight struct {
 	Position  math3d.Vector3 `json:"position"`
 	Intensity image.Color    `json:"intensity"`
+	// Temperature tints the intensity with the color of a blackbody at
+	// this many kelvin, if it's positive
+	Temperature float64 `json:"temperature,omitempty"`
+	// Profile spreads the intensity over the directions as a luminaire
+	// does, if it isn't nil. The intensity is then the one of the
+	// brightest direction.
+	Profile *Profile `json:"-"`
+}
+
+// Sample returns the light arriving at point from the position of the
+// light, which is the only one it can arrive from
+func (pl *PointLight) Sample(point *math3d.Vector3, u, v float64) Sample {
+	toLight := pl.Position.SubtractV(*point)
+	distance := toLight.Abs()
+	dir := toLight.DivideV(distance)
+	intensity := tint(pl.Intensity, pl.Temperature)
+	if pl.Profile != nil {
+		intensity = *intensity.Multiply(pl.Profile.At(dir.Multiply(-1)))
+	}
+	return Sample{Direction: dir, Distance: distance, Radiance: intensity, Pdf: 1, Delta: true}
+}
+
+// Pdf returns 0, as no direction can hit a point
+func (pl *PointLight) Pdf(point, dir *math3d.Vector3) float64 {
+	return 0
+}
+
+// Intersect returns math.MaxFloat64, as no lightray can hit a point
+func (pl *PointLight) Intersect(lr *math3d.LightRay) (float64, image.Color) {
+	return math.MaxFloat64, image.Black
+}
+
+// Bounds returns the box holding only the position of the light
+func (pl *PointLight) Bounds() *math3d.AABB {
+	return &math3d.AABB{Min: pl.Position, Max: pl.Position}
+}
+
+// Translate moves the light by offset
+func (pl *PointLight) Translate(offset *math3d.Vector3) {
+	pl.Position = *pl.Position.Add(offset)
+}
+
+// AsMap returns a map representation of this light
+func (pl *PointLight) AsMap() map[string]interface{} {
+	m := map[string]interface{}{"type": "point", "position": pl.Position.AsMap(), "intensity": colorAsMap(&pl.Intensity)}
+	if pl.Temperature > 0 {
+		m["temperature"] = pl.Temperature
+	}
+	if pl.Profile != nil {
+		m["profile"] = pl.Profile.File
+	}
+	return m
 }
 
-// PointLightsFromMap returns the point light defined in the map
-func PointLightsFromMap(m []map[string]interface{}) []PointLight {
-	retval := make([]PointLight, 0, len(m))
-	for _, v := range m {
-		pl := PointLight{}
-		pl.Position = math3d.VectorFromMap(v["position"].(map[string]interface{}))
-		pl.Intensity = image.ColorFromMap(maputil.ToMapOfFloat64(v["intensity"].(map[string]interface{})))
-		retval = append(retval, pl)
+// jsonPointLight is a PointLight without its JSON methods, to encode it
+type jsonPointLight PointLight
+
+// MarshalJSON returns the light as an object with its type, position,
+// intensity, temperature and the path of its profile
+func (pl *PointLight) MarshalJSON() ([]byte, error) {
+	profile := ""
+	if pl.Profile != nil {
+		profile = pl.Profile.File
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonPointLight
+		Profile string `json:"profile,omitempty"`
+	}{"point", jsonPointLight(*pl), profile})
+}
+
+// UnmarshalJSON sets the light from an object with its position and
+// intensity, and optionally its type, its temperature and the path of the
+// IES file of its profile, which it reads. It fails if the intensity or
+// the temperature is negative or the profile can't be read.
+func (pl *PointLight) UnmarshalJSON(data []byte) error {
+	var decoded PointLight
+	var typename, profile string
+	err := jsonutil.Object(data, map[string]interface{}{
+		"type":        &typename,
+		"position":    &decoded.Position,
+		"intensity":   &decoded.Intensity,
+		"temperature": &decoded.Temperature,
+		"profile":     &profile,
+	}, "position", "intensity")
+	if err != nil {
+		return err
+	}
+	if typename != "" && typename != "point" {
+		return fmt.Errorf("not a point light")
+	}
+	if !decoded.Intensity.NonNegative() {
+		return fmt.Errorf("the intensity can't be negative")
+	}
+	if !validTemperature(decoded.Temperature) {
+		return fmt.Errorf("the temperature must be finite and non negative")
+	}
+	if profile != "" {
+		if decoded.Profile, err = LoadProfile(profile); err != nil {
+			return err
+		}
 	}
+	*pl = decoded
+	return nil
+}
+
+// blackbodies caches the colors of the temperatures of the lights, which
+// are looked up for every sample
+var blackbodies sync.Map
+
+// tint returns the color c tinted with the color of a blackbody at the
+// temperature in kelvin, whose luminance is 1, or c itself if the
+// temperature is 0
+func tint(c image.Color, kelvin float64) image.Color {
+	if kelvin <= 0 {
+		return c
+	}
+	cached, ok := blackbodies.Load(kelvin)
+	if !ok {
+		cached, _ = blackbodies.LoadOrStore(kelvin, image.Blackbody(kelvin))
+	}
+	blackbody := cached.(image.Color)
+	return *c.CMultiply(&blackbody)
+}
+
+// validTemperature returns whether the temperature of a light can be used
+func validTemperature(kelvin float64) bool {
+	return kelvin >= 0 && !math.IsInf(kelvin, 0)
+}
 
-	return retval
+func colorAsMap(c *image.Color) map[string]float64 {
+	return map[string]float64{"r": c.R, "g": c.G, "b": c.B}
 }
@@ -0,0 +1,234 @@
+package lighting
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+// SkyLight is the light of a clear sky around the scene, with the analytic
+// model of Preetham, Shirley and Smits: the sky is blue overhead, whiter
+// towards the horizon and brightest around the sun. Y is up. Below the
+// horizon there's a ground of the albedo as far as the eye can see, lit
+// by the sky and its sun. Radiances are in thousands of cd/m² times the
+// strength, so the sky is about 5 overhead on a clear day.
+//
+// Sky lights must be made with NewSkyLight or decoded from JSON, which
+// prepare the model, and their fields must not change afterwards. The sky
+// doesn't hold the sun itself, which is the light that Sun returns.
+type SkyLight struct {
+	// SunDirection is the unit vector towards the sun
+	SunDirection math3d.Vector3 `json:"sundirection"`
+	// Turbidity is the haze of the air, from 2 for very clear skies to 10
+	// for hazy ones
+	Turbidity    float64     `json:"turbidity"`
+	GroundAlbedo image.Color `json:"groundalbedo"`
+	Strength     float64     `json:"strength"`
+	// The coefficients of the luminance and the chromaticity, their value
+	// at the zenith, and the radiance of the ground
+	luminance, x, y perez
+	zenith          [3]float64
+	ground          image.Color
+}
+
+// Turbidities bound the haze for which the sky model holds
+const (
+	MinTurbidity = 1.7
+	MaxTurbidity = 10.0
+)
+
+// SunAngularRadius is the angular radius of the sun seen from the earth,
+// in radians
+const SunAngularRadius = 0.00465
+
+// perez holds the five coefficients of the Perez formula for the
+// distribution of a quantity over the sky
+type perez [5]float64
+
+// at returns the Perez formula for a direction with the cosine of its
+// zenith angle and its angle with the sun
+func (p *perez) at(cosTheta, gamma float64) float64 {
+	cosGamma := math.Cos(gamma)
+	return (1 + p[0]*math.Exp(p[1]/cosTheta)) * (1 + p[2]*math.Exp(p[3]*gamma) + p[4]*cosGamma*cosGamma)
+}
+
+// NewSkyLight returns the sky with the sun towards sunDirection, which
+// needn't be a unit vector, the turbidity of the air, the albedo of the
+// ground and the strength that scales its radiance
+func NewSkyLight(sunDirection math3d.Vector3, turbidity float64, groundAlbedo image.Color, strength float64) *SkyLight {
+	sky := &SkyLight{SunDirection: sunDirection.NormalizedV(), Turbidity: turbidity, GroundAlbedo: groundAlbedo, Strength: strength}
+	t := turbidity
+	sky.luminance = perez{0.1787*t - 1.4630, -0.3554*t + 0.4275, -0.0227*t + 5.3251, 0.1206*t - 2.5771, -0.0670*t + 0.3703}
+	sky.x = perez{-0.0193*t - 0.2592, -0.0665*t + 0.0008, -0.0004*t + 0.2125, -0.0641*t - 0.8989, -0.0033*t + 0.0452}
+	sky.y = perez{-0.0167*t - 0.2608, -0.0950*t + 0.0092, -0.0079*t + 0.2102, -0.0441*t - 1.6537, -0.0109*t + 0.0529}
+
+	theta := math.Acos(math.Max(-1, math.Min(1, sky.SunDirection.Y)))
+	chi := (4.0/9 - t/120) * (math.Pi - 2*theta)
+	cubic := func(a, b, c, d float64) float64 {
+		return ((a*theta+b)*theta+c)*theta + d
+	}
+	sky.zenith = [3]float64{
+		math.Max(0, (4.0453*t-4.9710)*math.Tan(chi)-0.2155*t+2.4192),
+		t*t*cubic(0.00166, -0.00375, 0.00209, 0) + t*cubic(-0.02903, 0.06377, -0.03202, 0.00394) + cubic(0.11693, -0.21196, 0.06052, 0.25886),
+		t*t*cubic(0.00275, -0.00610, 0.00317, 0) + t*cubic(-0.04214, 0.08970, -0.04153, 0.00516) + cubic(0.15346, -0.26756, 0.06670, 0.26688),
+	}
+
+	// The ground reflects the irradiance of the sky over the upper
+	// hemisphere, integrated numerically, and of the sun
+	const thetas, phis = 32, 64
+	irradiance := sky.Sun().Irradiance
+	irradiance = *irradiance.Multiply(math.Max(0, sky.SunDirection.Y))
+	for i := 0; i < thetas; i++ {
+		zenith := (float64(i) + 0.5) * math.Pi / 2 / thetas
+		for j := 0; j < phis; j++ {
+			phi := (float64(j) + 0.5) * 2 * math.Pi / phis
+			dir := math3d.Vector3{X: math.Sin(zenith) * math.Cos(phi), Y: math.Cos(zenith), Z: math.Sin(zenith) * math.Sin(phi)}
+			radiance := sky.skyRadiance(&dir)
+			weight := math.Cos(zenith) * math.Sin(zenith) * (math.Pi / 2 / thetas) * (2 * math.Pi / phis)
+			irradiance = *irradiance.Add(radiance.Multiply(weight))
+		}
+	}
+	sky.ground = *irradiance.CMultiply(&groundAlbedo).Multiply(1 / math.Pi)
+	return sky
+}
+
+// Sun returns the sun of the sky: a sun light towards its sun, whose light
+// the air dims and reddens on its way down as it does the sky's, of the
+// same strength
+func (sky *SkyLight) Sun() *SunLight {
+	sun := &SunLight{Direction: sky.SunDirection, AngularRadius: SunAngularRadius}
+	cosTheta := sky.SunDirection.Y
+	if cosTheta <= 0 {
+		return sun
+	}
+	// The optical mass of the air the light goes through, relative to the
+	// one overhead, and the transmittance of its molecules (Rayleigh) and
+	// of the haze (aerosols) at wavelengths in micrometers standing for
+	// the channels
+	degrees := math.Acos(cosTheta) * 180 / math.Pi
+	mass := 1 / (cosTheta + 0.15*math.Pow(93.885-degrees, -1.253))
+	beta := 0.04608*sky.Turbidity - 0.04586
+	transmittance := func(micrometers float64) float64 {
+		rayleigh := math.Exp(-0.008735 * math.Pow(micrometers, -4.08) * mass)
+		aerosol := math.Exp(-beta * math.Pow(micrometers, -1.3) * mass)
+		return rayleigh * aerosol
+	}
+	// Sunlight outside the atmosphere gives 128 thousand lux
+	sun.Irradiance = image.Blackbody(5778)
+	sun.Irradiance = *sun.Irradiance.CMultiply(&image.Color{R: transmittance(0.61), G: transmittance(0.55), B: transmittance(0.465)}).
+		Multiply(128 * sky.Strength)
+	return sun
+}
+
+// Radiance returns the radiance of the sky, or of the ground below the
+// horizon, arriving along the unit direction dir
+func (sky *SkyLight) Radiance(dir *math3d.Vector3) image.Color {
+	if dir.Y < 0 {
+		return sky.ground
+	}
+	return sky.skyRadiance(dir)
+}
+
+// skyRadiance returns the radiance of the sky in the direction dir above
+// the horizon
+func (sky *SkyLight) skyRadiance(dir *math3d.Vector3) image.Color {
+	cosTheta := dir.Y
+	gamma := math.Acos(math.Max(-1, math.Min(1, dir.DotV(sky.SunDirection))))
+	sunTheta := math.Acos(math.Max(-1, math.Min(1, sky.SunDirection.Y)))
+	relative := func(p *perez, zenith float64) float64 {
+		return zenith * p.at(cosTheta, gamma) / p.at(1, sunTheta)
+	}
+	luminance := math.Max(0, relative(&sky.luminance, sky.zenith[0]))
+	c := image.FromChromaticity(relative(&sky.x, sky.zenith[1]), relative(&sky.y, sky.zenith[2]), luminance)
+	return *c.Multiply(sky.Strength)
+}
+
+// Sample returns the light arriving at point from a direction of the whole
+// sphere, with every direction as likely
+func (sky *SkyLight) Sample(point *math3d.Vector3, u, v float64) Sample {
+	dir := sampling.UniformCone(&math3d.UnitY, -1, u, v)
+	return Sample{Direction: dir, Distance: Far, Radiance: sky.Radiance(&dir), Pdf: sampling.UniformConePdf(-1)}
+}
+
+// Pdf returns the density of Sample choosing dir, which is the same for
+// every direction
+func (sky *SkyLight) Pdf(point, dir *math3d.Vector3) float64 {
+	return sampling.UniformConePdf(-1)
+}
+
+// Intersect returns Far and the radiance of the sky, which every lightray
+// hits
+func (sky *SkyLight) Intersect(lr *math3d.LightRay) (float64, image.Color) {
+	return Far, sky.Radiance(&lr.Direction)
+}
+
+// Bounds returns an empty box, as the sky is infinitely far
+func (sky *SkyLight) Bounds() *math3d.AABB {
+	return math3d.EmptyAABB()
+}
+
+// Translate does nothing, as moving doesn't bring the sky any closer
+func (sky *SkyLight) Translate(offset *math3d.Vector3) {}
+
+// AsMap returns a map representation of this light
+func (sky *SkyLight) AsMap() map[string]interface{} {
+	return map[string]interface{}{
+		"type":         "sky",
+		"sundirection": sky.SunDirection.AsMap(),
+		"turbidity":    sky.Turbidity,
+		"groundalbedo": colorAsMap(&sky.GroundAlbedo),
+		"strength":     sky.Strength}
+}
+
+// jsonSkyLight is a SkyLight without its JSON methods, to encode it
+type jsonSkyLight SkyLight
+
+// MarshalJSON returns the light as an object with its type and fields
+func (sky *SkyLight) MarshalJSON() ([]byte, error) {
+	if err := jsonutil.Finite(sky.Turbidity, sky.Strength); err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonSkyLight
+	}{"sky", jsonSkyLight(*sky)})
+}
+
+// UnmarshalJSON sets the light from an object with the type "sky" and the
+// direction towards the sun, and optionally the turbidity (3 if missing),
+// the albedo of the ground (black if missing) and the strength (1 if
+// missing). It fails if the direction is zero, the turbidity is out of
+// range, the albedo is negative or the strength isn't positive.
+func (sky *SkyLight) UnmarshalJSON(data []byte) error {
+	var typename string
+	decoded := SkyLight{Turbidity: 3, Strength: 1}
+	err := jsonutil.Object(data, map[string]interface{}{
+		"type":         &typename,
+		"sundirection": &decoded.SunDirection,
+		"turbidity":    &decoded.Turbidity,
+		"groundalbedo": &decoded.GroundAlbedo,
+		"strength":     &decoded.Strength,
+	}, "type", "sundirection")
+	if err != nil {
+		return err
+	}
+	switch {
+	case typename != "sky":
+		return fmt.Errorf("not a sky light")
+	case !(decoded.SunDirection.Abs() > 0):
+		return fmt.Errorf("the sun direction can't be zero")
+	case !(decoded.Turbidity >= MinTurbidity && decoded.Turbidity <= MaxTurbidity):
+		return fmt.Errorf("the turbidity must be between %g and %g", MinTurbidity, MaxTurbidity)
+	case !decoded.GroundAlbedo.NonNegative():
+		return fmt.Errorf("the ground albedo can't be negative")
+	case !(decoded.Strength > 0):
+		return fmt.Errorf("the strength must be positive")
+	}
+	*sky = *NewSkyLight(decoded.SunDirection, decoded.Turbidity, decoded.GroundAlbedo, decoded.Strength)
+	return nil
+}
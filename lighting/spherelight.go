@@ -0,0 +1,232 @@
+package lighting
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// SphereLight defines a spheric light that emits the same radiance from
+// every point of its surface and towards every direction outside of it,
+// such as a bulb. Two sided sphere lights also emit towards the inside.
+type SphereLight struct {
+	Position math3d.Vector3 `json:"position"`
+	Radius   float64        `json:"radius"`
+	Radiance image.Color    `json:"radiance"`
+	TwoSided bool           `json:"twosided,omitempty"`
+	// Temperature tints the radiance with the color of a blackbody at this
+	// many kelvin, if it's positive
+	Temperature float64 `json:"temperature,omitempty"`
+}
+
+// Sample returns the light arriving at point from a direction of the cone
+// that the sphere fills as seen from point. Points inside the sphere get
+// light from every direction if it's two sided, and none otherwise.
+func (sl *SphereLight) Sample(point *math3d.Vector3, u, v float64) Sample {
+	axis, distance, cosMax, ok := sl.cone(point)
+	if !ok {
+		if !sl.TwoSided {
+			return Sample{}
+		}
+		dir := sampling.UniformCone(&math3d.UnitZ, -1, u, v)
+		sphere := sl.sphere()
+		toSurface := sphere.Intersect(&math3d.LightRay{Source: *point, Direction: dir})
+		return Sample{Direction: dir, Distance: toSurface, Radiance: sl.radiance(), Pdf: sampling.UniformConePdf(-1)}
+	}
+	dir := sampling.UniformCone(&axis, cosMax, u, v)
+	// The nearest intersection of the direction with the sphere
+	cosine := dir.DotV(axis)
+	sine2 := math.Max(0, 1-cosine*cosine)
+	toSurface := distance*cosine - math.Sqrt(math.Max(0, sl.Radius*sl.Radius-distance*distance*sine2))
+	return Sample{Direction: dir, Distance: toSurface, Radiance: sl.radiance(), Pdf: sampling.UniformConePdf(cosMax)}
+}
+
+// Pdf returns the density of Sample choosing dir from point, which is the
+// same for every direction of the cone that the sphere fills
+func (sl *SphereLight) Pdf(point, dir *math3d.Vector3) float64 {
+	axis, _, cosMax, ok := sl.cone(point)
+	if !ok && sl.TwoSided {
+		return sampling.UniformConePdf(-1)
+	}
+	if !ok || dir.DotV(axis) < cosMax {
+		return 0
+	}
+	return sampling.UniformConePdf(cosMax)
+}
+
+// Intersect returns the distance at which the lightray hits the sphere and
+// its radiance, which is black if it hits the inside of a one sided
+// sphere
+func (sl *SphereLight) Intersect(lr *math3d.LightRay) (float64, image.Color) {
+	sphere := sl.sphere()
+	distance := sphere.Intersect(lr)
+	if distance == math.MaxFloat64 {
+		return distance, image.Black
+	}
+	if !sl.TwoSided {
+		point := lr.Source.AddV(lr.Direction.MultiplyV(distance))
+		if normal := sphere.NormalAt(&point); normal.DotV(lr.Direction) > 0 {
+			return distance, image.Black
+		}
+	}
+	return distance, sl.radiance()
+}
+
+// SampleSurface returns a point of the sphere and its normal there, with
+// every point as likely
+func (sl *SphereLight) SampleSurface(u, v float64) (point, normal math3d.Vector3) {
+	normal = sampling.UniformCone(&math3d.UnitZ, -1, u, v)
+	return sl.Position.AddV(normal.MultiplyV(sl.Radius)), normal
+}
+
+// NormalAt returns the normal of the sphere at point
+func (sl *SphereLight) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	return point.SubtractV(sl.Position).NormalizedV()
+}
+
+// Area returns the area of the sphere
+func (sl *SphereLight) Area() float64 {
+	return 4 * math.Pi * sl.Radius * sl.Radius
+}
+
+// Emission returns the radiance of the sphere towards dir, which is black
+// towards the inside of one sided spheres
+func (sl *SphereLight) Emission(normal, dir *math3d.Vector3) image.Color {
+	if !sl.TwoSided && dir.DotV(*normal) <= 0 {
+		return image.Black
+	}
+	return sl.radiance()
+}
+
+// SampleEmission returns a direction with a density proportional to its
+// cosine with the normal, on both sides of the surface if the sphere is
+// two sided
+func (sl *SphereLight) SampleEmission(normal *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	if !sl.TwoSided {
+		dir := sampling.CosineHemisphere(normal, u, v)
+		return dir, sl.EmissionPdf(normal, &dir)
+	}
+	// The first number chooses the side and is reused for the direction
+	side := *normal
+	if u < 0.5 {
+		side, u = normal.MultiplyV(-1), 2*u
+	} else {
+		u = 2*u - 1
+	}
+	dir := sampling.CosineHemisphere(&side, u, v)
+	return dir, sl.EmissionPdf(normal, &dir)
+}
+
+// EmissionPdf returns the density of SampleEmission choosing dir
+func (sl *SphereLight) EmissionPdf(normal, dir *math3d.Vector3) float64 {
+	cosine := dir.DotV(*normal)
+	if sl.TwoSided {
+		return math.Abs(cosine) / (2 * math.Pi)
+	}
+	return math.Max(0, cosine) / math.Pi
+}
+
+// Bounds returns the bounding box of the sphere
+func (sl *SphereLight) Bounds() *math3d.AABB {
+	sphere := sl.sphere()
+	return sphere.Bounds()
+}
+
+// Translate moves the light by offset
+func (sl *SphereLight) Translate(offset *math3d.Vector3) {
+	sl.Position = *sl.Position.Add(offset)
+}
+
+// AsMap returns a map representation of this light
+func (sl *SphereLight) AsMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"type":     "sphere",
+		"position": sl.Position.AsMap(),
+		"radius":   sl.Radius,
+		"radiance": colorAsMap(&sl.Radiance)}
+	if sl.TwoSided {
+		m["twosided"] = true
+	}
+	if sl.Temperature > 0 {
+		m["temperature"] = sl.Temperature
+	}
+	return m
+}
+
+// jsonSphereLight is a SphereLight without its JSON methods, to encode it
+type jsonSphereLight SphereLight
+
+// MarshalJSON returns the light as an object with its type and fields
+func (sl *SphereLight) MarshalJSON() ([]byte, error) {
+	if err := jsonutil.Finite(sl.Radius); err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonSphereLight
+	}{"sphere", jsonSphereLight(*sl)})
+}
+
+// UnmarshalJSON sets the light from an object with the type "sphere", its
+// position, radius and radiance, and optionally whether it's two sided and
+// its temperature. It fails if the radius isn't positive or the radiance
+// or the temperature is negative.
+func (sl *SphereLight) UnmarshalJSON(data []byte) error {
+	var decoded SphereLight
+	var typename string
+	err := jsonutil.Object(data, map[string]interface{}{
+		"type":        &typename,
+		"position":    &decoded.Position,
+		"radius":      &decoded.Radius,
+		"radiance":    &decoded.Radiance,
+		"twosided":    &decoded.TwoSided,
+		"temperature": &decoded.Temperature,
+	}, "type", "position", "radius", "radiance")
+	if err != nil {
+		return err
+	}
+	if typename != "sphere" {
+		return fmt.Errorf("not a sphere light")
+	}
+	if !(decoded.Radius > 0) {
+		return fmt.Errorf("the radius must be positive")
+	}
+	if !decoded.Radiance.NonNegative() {
+		return fmt.Errorf("the radiance can't be negative")
+	}
+	if !validTemperature(decoded.Temperature) {
+		return fmt.Errorf("the temperature must be finite and non negative")
+	}
+	*sl = decoded
+	return nil
+}
+
+// cone returns the axis and the cosine of the angle of the cone that the
+// sphere fills as seen from point, and the distance to its center. ok is
+// false if point is inside the sphere.
+func (sl *SphereLight) cone(point *math3d.Vector3) (axis math3d.Vector3, distance, cosMax float64, ok bool) {
+	toCenter := sl.Position.SubtractV(*point)
+	distance = toCenter.Abs()
+	if distance <= sl.Radius {
+		return axis, distance, 0, false
+	}
+	sine := sl.Radius / distance
+	return toCenter.DivideV(distance), distance, math.Sqrt(1 - sine*sine), true
+}
+
+// radiance returns the radiance of the surface, tinted by the temperature
+func (sl *SphereLight) radiance() image.Color {
+	return tint(sl.Radiance, sl.Temperature)
+}
+
+// sphere returns the shape of the light, as a value so it's not allocated
+// every time a lightray is tested against the light
+func (sl *SphereLight) sphere() shape.Sphere {
+	return shape.Sphere{Position: sl.Position, Radius: sl.Radius}
+}
@@ -0,0 +1,156 @@
+package lighting
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+// SunLight is the light of a sun: a disc infinitely far away, with the same
+// radiance over the cone it fills. Its angular radius softens the edges of
+// the shadows, and a sun without one casts perfectly sharp shadows from a
+// single direction. Lightrays that hit it do so in front of the sky.
+type SunLight struct {
+	// Direction is the unit vector towards the center of the sun
+	Direction math3d.Vector3 `json:"direction"`
+	// AngularRadius is the angle between the center and the edge of the
+	// sun, in radians
+	AngularRadius float64 `json:"angularradius"`
+	// Irradiance is the light the sun gives to a surface facing it
+	Irradiance image.Color `json:"irradiance"`
+}
+
+// Sample returns the light arriving at point from a direction of the cone
+// of the sun, with every direction as likely, or from its center if it has
+// no angular radius
+func (sun *SunLight) Sample(point *math3d.Vector3, u, v float64) Sample {
+	if sun.AngularRadius == 0 {
+		return Sample{Direction: sun.Direction, Distance: Far / 2, Radiance: sun.Irradiance, Pdf: 1, Delta: true}
+	}
+	cosMax := math.Cos(sun.AngularRadius)
+	dir := sampling.UniformCone(&sun.Direction, cosMax, u, v)
+	return Sample{Direction: dir, Distance: Far / 2, Radiance: sun.Radiance(&dir), Pdf: sampling.UniformConePdf(cosMax)}
+}
+
+// Pdf returns the density of Sample choosing dir, which is the same for
+// every direction of the cone of the sun
+func (sun *SunLight) Pdf(point, dir *math3d.Vector3) float64 {
+	cosMax := math.Cos(sun.AngularRadius)
+	if sun.AngularRadius == 0 || dir.DotV(sun.Direction) < cosMax {
+		return 0
+	}
+	return sampling.UniformConePdf(cosMax)
+}
+
+// Radiance returns the radiance of the sun along dir, which is black out
+// of its cone
+func (sun *SunLight) Radiance(dir *math3d.Vector3) image.Color {
+	cosMax := math.Cos(sun.AngularRadius)
+	if sun.AngularRadius == 0 || dir.DotV(sun.Direction) < cosMax {
+		return image.Black
+	}
+	// The irradiance spread over the solid angle of the cone
+	return *sun.Irradiance.Multiply(sampling.UniformConePdf(cosMax))
+}
+
+// Intersect returns the distance at which the lightray hits the sun, half
+// of Far so that it's in front of the sky, and its radiance. The distance
+// is math.MaxFloat64 if the lightray misses it.
+func (sun *SunLight) Intersect(lr *math3d.LightRay) (float64, image.Color) {
+	radiance := sun.Radiance(&lr.Direction)
+	if radiance == image.Black {
+		return math.MaxFloat64, radiance
+	}
+	return Far / 2, radiance
+}
+
+// Bounds returns an empty box, as the sun is infinitely far
+func (sun *SunLight) Bounds() *math3d.AABB {
+	return math3d.EmptyAABB()
+}
+
+// Translate does nothing, as moving doesn't bring the sun any closer
+func (sun *SunLight) Translate(offset *math3d.Vector3) {}
+
+// AsMap returns a map representation of this light
+func (sun *SunLight) AsMap() map[string]interface{} {
+	return map[string]interface{}{
+		"type":          "sun",
+		"direction":     sun.Direction.AsMap(),
+		"angularradius": sun.AngularRadius,
+		"irradiance":    colorAsMap(&sun.Irradiance)}
+}
+
+// jsonSunLight is a SunLight without its JSON methods, to encode it
+type jsonSunLight SunLight
+
+// MarshalJSON returns the light as an object with its type and fields
+func (sun *SunLight) MarshalJSON() ([]byte, error) {
+	if err := jsonutil.Finite(sun.AngularRadius); err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonSunLight
+	}{"sun", jsonSunLight(*sun)})
+}
+
+// UnmarshalJSON sets the light from an object with the type "sun", the
+// direction towards it and optionally its angular radius, which is the one
+// of the sun seen from the earth if it's missing. Its irradiance is
+// either given or the one of the sun of a sky light with the turbidity
+// and the strength (1 if missing). It fails if the direction is zero, the
+// angular radius is negative or 90 degrees or more, or the irradiance is
+// negative.
+func (sun *SunLight) UnmarshalJSON(data []byte) error {
+	var typename string
+	var turbidity float64
+	decoded, strength := SunLight{AngularRadius: SunAngularRadius}, 1.0
+	fields := map[string]interface{}{
+		"type":          &typename,
+		"direction":     &decoded.Direction,
+		"angularradius": &decoded.AngularRadius,
+		"irradiance":    &decoded.Irradiance,
+		"turbidity":     &turbidity,
+		"strength":      &strength,
+	}
+	if err := jsonutil.Object(data, fields, "type", "direction"); err != nil {
+		return err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	_, irradiance := keys["irradiance"]
+	_, sky := keys["turbidity"]
+	_, strengthened := keys["strength"]
+	switch {
+	case typename != "sun":
+		return fmt.Errorf("not a sun light")
+	case !(decoded.Direction.Abs() > 0):
+		return fmt.Errorf("the direction can't be zero")
+	case !(decoded.AngularRadius >= 0 && decoded.AngularRadius < math.Pi/2):
+		return fmt.Errorf("the angular radius must be at least 0 and less than 90 degrees")
+	case irradiance == sky:
+		return fmt.Errorf("the sun must have either an irradiance or a turbidity")
+	case !decoded.Irradiance.NonNegative():
+		return fmt.Errorf("the irradiance can't be negative")
+	case sky && !(turbidity >= MinTurbidity && turbidity <= MaxTurbidity):
+		return fmt.Errorf("the turbidity must be between %g and %g", MinTurbidity, MaxTurbidity)
+	case strengthened && !sky:
+		return fmt.Errorf("only suns with a turbidity have a strength")
+	case sky && !(strength > 0):
+		return fmt.Errorf("the strength must be positive")
+	}
+	decoded.Direction = decoded.Direction.NormalizedV()
+	if sky {
+		decoded.Irradiance = NewSkyLight(decoded.Direction, turbidity, image.Black, strength).Sun().Irradiance
+	}
+	*sun = decoded
+	return nil
+}
@@ -0,0 +1,227 @@
+package lighting
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Tree is a bounding volume hierarchy over lights. It chooses the light
+// that lights a point with a probability proportional to an estimate of
+// how much light it gives to the point. Every node estimates that for all
+// its lights at once from their bounds and their total power, so choosing
+// a light takes logarithmic time in the number of lights, as does finding
+// the light a lightray hits. It's a simplified version of the light trees
+// of Conty and Kulla.
+type Tree struct {
+	lights []Light
+	nodes  []treeNode
+	// leaves holds the node of every light, and parents the parent of
+	// every node
+	leaves, parents []int
+}
+
+// treeNode is either an inner node with two children or a leaf with a
+// light. The left child of an inner node is always the next node.
+type treeNode struct {
+	bounds math3d.AABB
+	// power is the estimate of the irradiance the lights give at a
+	// distance of 1, for the lights whose light falls off with the square
+	// of the distance, and constant for those whose light doesn't
+	power, constant float64
+	right           int
+	light           int
+}
+
+// NewTree builds a light tree over the lights. The lights must not move
+// afterwards, and they can't be distant ones, which have no bounds.
+func NewTree(lights []Light) *Tree {
+	t := &Tree{lights: lights, leaves: make([]int, len(lights))}
+	indices := make([]int, len(lights))
+	for i := range indices {
+		indices[i] = i
+	}
+	if len(lights) > 0 {
+		t.nodes = make([]treeNode, 0, 2*len(lights))
+		t.build(indices, -1)
+	}
+	return t
+}
+
+// build creates the subtree for the lights with the indices and returns
+// the index of its root node
+func (t *Tree) build(indices []int, parent int) int {
+	current := len(t.nodes)
+	t.nodes = append(t.nodes, treeNode{light: -1})
+	t.parents = append(t.parents, parent)
+	if len(indices) == 1 {
+		l := t.lights[indices[0]]
+		n := &t.nodes[current]
+		n.bounds, n.light = *l.Bounds(), indices[0]
+		if power, fallsOff := emission(l); fallsOff {
+			n.power = power
+		} else {
+			n.constant = power
+		}
+		t.leaves[indices[0]] = current
+		return current
+	}
+
+	// Split by the median center along the axis where the centers spread
+	// most, as the BVH of the shapes does
+	centers := math3d.EmptyAABB()
+	for _, i := range indices {
+		centers = centers.Expand(t.lights[i].Bounds().Centroid())
+	}
+	size := centers.Max.SubtractV(centers.Min)
+	axis := func(v *math3d.Vector3) float64 {
+		if size.X >= size.Y && size.X >= size.Z {
+			return v.X
+		} else if size.Y >= size.Z {
+			return v.Y
+		}
+		return v.Z
+	}
+	sort.Slice(indices, func(a, b int) bool {
+		return axis(t.lights[indices[a]].Bounds().Centroid()) < axis(t.lights[indices[b]].Bounds().Centroid())
+	})
+	middle := len(indices) / 2
+	left := t.build(indices[:middle], current)
+	right := t.build(indices[middle:], current)
+	n, l, r := &t.nodes[current], &t.nodes[left], &t.nodes[right]
+	n.bounds = *l.bounds.Union(&r.bounds)
+	n.power, n.constant = l.power+r.power, l.constant+r.constant
+	n.right = right
+	return current
+}
+
+// Size returns the number of lights in the tree
+func (t *Tree) Size() int {
+	return len(t.lights)
+}
+
+// Sample chooses a light that lights point, given a uniform number u in
+// [0, 1), and returns it with the probability of choosing it. normal is
+// the normal of the surface at point, or nil if point isn't on a surface.
+// The light is nil if no light can light point.
+func (t *Tree) Sample(point, normal *math3d.Vector3, u float64) (Light, float64) {
+	if len(t.nodes) == 0 {
+		return nil, 0
+	}
+	current, probability := 0, 1.0
+	for t.nodes[current].light < 0 {
+		left, right := current+1, t.nodes[current].right
+		pLeft := t.leftProbability(current, point, normal)
+		if math.IsNaN(pLeft) {
+			return nil, 0
+		}
+		// Reusing u, stretched over the side it chose
+		if u < pLeft {
+			current, probability, u = left, probability*pLeft, u/pLeft
+		} else {
+			current, probability, u = right, probability*(1-pLeft), (u-pLeft)/(1-pLeft)
+		}
+		u = math.Min(u, math.Nextafter(1, 0))
+	}
+	return t.lights[t.nodes[current].light], probability
+}
+
+// Probability returns the probability of Sample choosing the light with
+// the index in the slice the tree was built with
+func (t *Tree) Probability(point, normal *math3d.Vector3, index int) float64 {
+	probability := 1.0
+	for child := t.leaves[index]; t.parents[child] >= 0; child = t.parents[child] {
+		parent := t.parents[child]
+		pLeft := t.leftProbability(parent, point, normal)
+		if math.IsNaN(pLeft) {
+			return 0
+		}
+		if child == parent+1 {
+			probability *= pLeft
+		} else {
+			probability *= 1 - pLeft
+		}
+	}
+	return probability
+}
+
+// Intersect returns the distance at which the lightray hits the nearest
+// light of the tree and that light, only visiting the nodes whose bounds
+// the lightray enters. The distance is math.MaxFloat64 and the light nil
+// if it misses them all.
+func (t *Tree) Intersect(lr *math3d.LightRay) (float64, Light) {
+	nearestDistance, nearest := math.MaxFloat64, Light(nil)
+	if len(t.nodes) == 0 {
+		return nearestDistance, nearest
+	}
+	stack := make([]int, 1, 64)
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n := &t.nodes[current]
+		if n.bounds.Intersect(lr) >= nearestDistance {
+			continue
+		}
+		if n.light < 0 {
+			stack = append(stack, n.right, current+1)
+			continue
+		}
+		if d, _ := t.lights[n.light].Intersect(lr); d < nearestDistance {
+			nearestDistance, nearest = d, t.lights[n.light]
+		}
+	}
+	return nearestDistance, nearest
+}
+
+// leftProbability returns the probability of choosing the left child of
+// the inner node, or NaN if neither child can light point
+func (t *Tree) leftProbability(node int, point, normal *math3d.Vector3) float64 {
+	left := t.nodes[node+1].importance(point, normal)
+	right := t.nodes[t.nodes[node].right].importance(point, normal)
+	if left+right == 0 {
+		return math.NaN()
+	}
+	return left / (left + right)
+}
+
+// importance returns an estimate of the irradiance that the lights of the
+// node give to point. It's only 0 if no light of the node can light
+// point, because they are all below the surface.
+func (n *treeNode) importance(point, normal *math3d.Vector3) float64 {
+	center := n.bounds.Centroid()
+	toCenter := center.SubtractV(*point)
+	distance2 := toCenter.AbsSquared()
+	// The squared radius of the sphere around the bounds
+	radius2 := n.bounds.Max.SubtractV(*center).AbsSquared()
+	// Points near or inside the bounds could be as close to a light as
+	// anything, so they're taken as at the radius
+	irradiance := n.power/math.Max(distance2, radius2) + n.constant
+	if normal == nil || distance2 <= radius2 {
+		return irradiance
+	}
+	distance, radius := math.Sqrt(distance2), math.Sqrt(radius2)
+	// The largest cosine of the normal with a direction towards the sphere
+	angle := math.Acos(math.Max(-1, math.Min(1, toCenter.DotV(*normal)/distance)))
+	spread := math.Asin(radius / distance)
+	if angle-spread >= math.Pi/2 {
+		return 0
+	}
+	return irradiance * math.Cos(math.Max(0, angle-spread))
+}
+
+// emission returns an estimate of the irradiance that the light gives to
+// a surface facing it at a distance of 1, as a luminance, and whether it
+// falls off with the square of the distance. Point lights give the same
+// irradiance at any distance.
+func emission(l Light) (float64, bool) {
+	switch l := l.(type) {
+	case *PointLight:
+		intensity := tint(l.Intensity, l.Temperature)
+		return intensity.Luminance(), false
+	case *SphereLight:
+		radiance := l.radiance()
+		return math.Pi * l.Radius * l.Radius * radiance.Luminance(), true
+	}
+	return 1, true
+}
@@ -0,0 +1,119 @@
+package lighting
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func randomLights(n int) []Light {
+	r := rand.New(rand.NewSource(5))
+	lights := make([]Light, 0, n)
+	for i := 0; i < n; i++ {
+		position := math3d.Vector3{X: r.Float64()*20 - 10, Y: r.Float64()*20 - 10, Z: r.Float64()*20 - 10}
+		if i%3 == 0 {
+			lights = append(lights, &PointLight{Position: position, Intensity: image.Color{R: r.Float64(), G: 1, B: 1}})
+		} else {
+			lights = append(lights, &SphereLight{Position: position, Radius: r.Float64(), Radiance: image.White})
+		}
+	}
+	return lights
+}
+
+func TestTreeProbabilities(t *testing.T) {
+	lights := randomLights(100)
+	tree := NewTree(lights)
+	point, normal := math3d.Vector3{X: 1, Y: -2}, math3d.UnitY
+	// In a medium every light may light the point
+	total := 0.0
+	for i := range lights {
+		total += tree.Probability(&point, nil, i)
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("The probabilities of choosing every light should add up to 1, they add up to %f", total)
+	}
+	// On a surface, the lights below it can't light the point and the
+	// tree may choose none
+	total = 0
+	for i, l := range lights {
+		p := tree.Probability(&point, &normal, i)
+		if p == 0 && l.Bounds().Max.Y > point.Y {
+			t.Errorf("Light %d may light the point but can't be chosen", i)
+		}
+		total += p
+	}
+	if total > 1+1e-9 {
+		t.Errorf("The probabilities of choosing every light add up to %f", total)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	const samples = 100000
+	chosen := make(map[Light]int)
+	for i := 0; i < samples; i++ {
+		l, probability := tree.Sample(&point, &normal, r.Float64())
+		if l == nil {
+			continue
+		}
+		chosen[l]++
+		if index := indexOf(lights, l); math.Abs(probability-tree.Probability(&point, &normal, index)) > 1e-9 {
+			t.Fatal("Sample and Probability disagree on the probability of a light")
+		}
+	}
+	for i, l := range lights {
+		expected := tree.Probability(&point, &normal, i) * samples
+		if math.Abs(float64(chosen[l])-expected) > 5*math.Sqrt(expected)+1 {
+			t.Errorf("Light %d was chosen %d times, expected about %.0f", i, chosen[l], expected)
+		}
+	}
+}
+
+func TestTreeSkipsLightsBelowTheSurface(t *testing.T) {
+	below := &SphereLight{Position: math3d.Vector3{Y: -5}, Radius: 1, Radiance: image.White}
+	above := &SphereLight{Position: math3d.Vector3{Y: 5}, Radius: 1, Radiance: image.White}
+	tree := NewTree([]Light{below, above})
+	normal := math3d.UnitY
+	if p := tree.Probability(&math3d.Vector3{}, &normal, 0); p != 0 {
+		t.Errorf("A light below the surface shouldn't be chosen, its probability is %f", p)
+	}
+	// In a medium there is no surface to be below of
+	if p := tree.Probability(&math3d.Vector3{}, nil, 0); p != 0.5 {
+		t.Errorf("Lights as bright and as far should be as likely, the probability is %f", p)
+	}
+	down := math3d.Vector3{Y: -1}
+	if l, _ := NewTree([]Light{above}).Sample(&math3d.Vector3{}, &down, 0.5); l != above {
+		// A single light is always chosen, without estimating anything
+		t.Error("The only light should be chosen")
+	}
+}
+
+func indexOf(lights []Light, l Light) int {
+	for i := range lights {
+		if lights[i] == l {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestTreeIntersectMatchesBruteForce(t *testing.T) {
+	lights := randomLights(200)
+	tree := NewTree(lights)
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 1000; i++ {
+		lr := math3d.LightRay{
+			Source:    math3d.Vector3{Z: -20},
+			Direction: math3d.Vector3{X: r.Float64() - 0.5, Y: r.Float64() - 0.5, Z: 1}.NormalizedV()}
+		expected := math.MaxFloat64
+		for _, l := range lights {
+			if d, _ := l.Intersect(&lr); d < expected {
+				expected = d
+			}
+		}
+		if d, l := tree.Intersect(&lr); d != expected || (l == nil) != (d == math.MaxFloat64) {
+			t.Fatalf("The tree found a light at %f but the nearest is at %f", d, expected)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package material
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Fraunhofer lines at which the index of refraction and the Abbe number
+// of glasses are given, in nanometers
+const (
+	lineD = 587.6
+	lineF = 486.1
+	lineC = 656.3
+)
+
+// Dielectric defines a smooth transparent material, such as glass or
+// water, that reflects and refracts light following the Fresnel
+// equations. The surfaces of the shapes made of it are the boundaries
+// between the material and the air, so the shapes should be closed.
+type Dielectric struct {
+	// Albedo is the fraction of the light that the surface lets through or
+	// reflects
+	Albedo image.Color `json:"albedo"`
+	// IOR is the index of refraction at 587.6 nm. It can't be less than 1.
+	IOR float64 `json:"ior"`
+	// Abbe is the Abbe number, which is lower the more the index of
+	// refraction changes with the wavelength. Light is only split into
+	// its wavelengths in spectral renders. There's no dispersion if it's
+	// 0.
+	Abbe float64 `json:"abbe,omitempty"`
+}
+
+// BRDF returns black, as the light is only reflected in the mirror
+// direction. The light leaving the surface is given by Scatter instead.
+func (d *Dielectric) BRDF(normal, in, out *math3d.Vector3) image.Color {
+	return image.Black
+}
+
+// SampleDirection returns a density of 0, as the directions of Scatter
+// have no density
+func (d *Dielectric) SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	return math3d.Vector3{}, 0
+}
+
+// DirectionPdf returns 0, as SampleDirection never returns a direction
+func (d *Dielectric) DirectionPdf(normal, in, out *math3d.Vector3) float64 {
+	return 0
+}
+
+// IORAt returns the index of refraction at the wavelength in nanometers,
+// following Cauchy's equation fitted to IOR and Abbe, or IOR if the
+// wavelength is 0
+func (d *Dielectric) IORAt(nm float64) float64 {
+	if nm == 0 || !d.Dispersive() {
+		return d.IOR
+	}
+	b := (d.IOR - 1) / (d.Abbe * (1/(lineF*lineF) - 1/(lineC*lineC)))
+	return d.IOR + b*(1/(nm*nm)-1/(lineD*lineD))
+}
+
+// Dispersive returns true if the index of refraction changes with the
+// wavelength
+func (d *Dielectric) Dispersive() bool {
+	return d.Abbe > 0
+}
+
+// Scatter returns the direction in which light arriving at the surface is
+// reflected or refracted towards out, given a uniform number in [0, 1),
+// and the fraction of the light it carries divided by the probability of
+// choosing it. The normal points out of the material, and the light has
+// the wavelength in nanometers, or is white if it's 0. The radiance isn't
+// scaled by the ratio of the indices, which cancels out once the light
+// leaves the material it entered.
+func (d *Dielectric) Scatter(normal, out *math3d.Vector3, wavelength, u float64) (math3d.Vector3, image.Color) {
+	n, eta := *normal, d.IORAt(wavelength)
+	cosOut := out.DotV(n)
+	if cosOut < 0 {
+		// out is inside the material
+		n, eta, cosOut = n.MultiplyV(-1), 1/eta, -cosOut
+	}
+	reflected := out.MultiplyV(-1).ReflectV(n)
+	// Snell's law, with the angles of the light inside and outside
+	sinIn2 := (1 - cosOut*cosOut) / (eta * eta)
+	if sinIn2 >= 1 {
+		// Total internal reflection
+		return reflected, d.Albedo
+	}
+	cosIn := math.Sqrt(1 - sinIn2)
+	if u < fresnel(cosOut, cosIn, eta) {
+		return reflected, d.Albedo
+	}
+	refracted := out.MultiplyV(-1 / eta).AddV(n.MultiplyV(cosOut/eta - cosIn))
+	return refracted.NormalizedV(), d.Albedo
+}
+
+// fresnel returns the fraction of unpolarized light reflected at the
+// boundary of two materials whose ratio of indices is eta, with the
+// cosines of the angles of the light with the normal on both sides
+func fresnel(cosOut, cosIn, eta float64) float64 {
+	parallel := (eta*cosOut - cosIn) / (eta*cosOut + cosIn)
+	perpendicular := (cosOut - eta*cosIn) / (cosOut + eta*cosIn)
+	return (parallel*parallel + perpendicular*perpendicular) / 2
+}
+
+// AsMap returns a map representation of this material
+func (d *Dielectric) AsMap() map[string]interface{} {
+	m := map[string]interface{}{"type": "dielectric", "albedo": colorAsMap(&d.Albedo), "ior": d.IOR}
+	if d.Abbe != 0 {
+		m["abbe"] = d.Abbe
+	}
+	return m
+}
+
+// DielectricFromMap returns the dielectric material defined in the map.
+// The index of refraction is 1.5 if it's missing.
+func DielectricFromMap(m map[string]interface{}) *Dielectric {
+	d := &Dielectric{Albedo: colorFromMap(m["albedo"]), IOR: 1.5}
+	if ior, ok := m["ior"].(float64); ok {
+		d.IOR = ior
+	}
+	d.Abbe, _ = m["abbe"].(float64)
+	if !(d.IOR >= 1) || math.IsInf(d.IOR, 1) {
+		panic("The index of refraction of a dielectric material must be finite and at least 1")
+	}
+	if !(d.Abbe >= 0) || math.IsInf(d.Abbe, 1) {
+		panic("The Abbe number of a dielectric material must be finite and non negative")
+	}
+	return d
+}
+
+// jsonDielectric is a Dielectric without its JSON methods, to encode it
+type jsonDielectric Dielectric
+
+// MarshalJSON returns the material as an object with its type, albedo,
+// index of refraction and Abbe number, if it has one
+func (d *Dielectric) MarshalJSON() ([]byte, error) {
+	if err := jsonutil.Finite(d.IOR, d.Abbe); err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonDielectric
+	}{"dielectric", jsonDielectric(*d)})
+}
+
+// UnmarshalJSON sets the material from an object with the type
+// "dielectric", its albedo, white if it's missing, its index of
+// refraction, 1.5 if it's missing, and its Abbe number, none if it's
+// missing. It fails if the albedo is negative, the index of refraction is
+// less than 1 or the Abbe number is negative.
+func (d *Dielectric) UnmarshalJSON(data []byte) error {
+	decoded := Dielectric{Albedo: image.White, IOR: 1.5}
+	var typename string
+	err := jsonutil.Object(data, map[string]interface{}{
+		"type":   &typename,
+		"albedo": &decoded.Albedo,
+		"ior":    &decoded.IOR,
+		"abbe":   &decoded.Abbe,
+	}, "type")
+	if err != nil {
+		return err
+	}
+	if typename != "dielectric" {
+		return fmt.Errorf("not a dielectric material")
+	}
+	if !decoded.Albedo.NonNegative() {
+		return fmt.Errorf("the albedo can't be negative")
+	}
+	if decoded.IOR < 1 {
+		return fmt.Errorf("the index of refraction can't be less than 1")
+	}
+	if decoded.Abbe < 0 {
+		return fmt.Errorf("the Abbe number can't be negative")
+	}
+	*d = decoded
+	return nil
+}
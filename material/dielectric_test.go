@@ -0,0 +1,73 @@
+package material
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+var glass = &Dielectric{Albedo: image.White, IOR: 1.5}
+
+func TestDielectricFollowsFresnel(t *testing.T) {
+	normal := math3d.Vector3{Y: 1}
+	out := math3d.Vector3{Y: 1}
+	// Head on, (n-1)^2/(n+1)^2 of the light is reflected
+	reflectance := 0.04
+	if in, _ := glass.Scatter(&normal, &out, 0, reflectance-0.001); in != out {
+		t.Errorf("Light arriving head on should be reflected back with u below %g, not towards %v", reflectance, in)
+	}
+	if in, _ := glass.Scatter(&normal, &out, 0, reflectance+0.001); in.DotV(normal) > -0.999999 {
+		t.Errorf("Light arriving head on should go straight through with u above %g, not towards %v", reflectance, in)
+	}
+
+	// Snell's law going in at 45 degrees
+	out = math3d.Vector3{X: 1, Y: 1}.NormalizedV()
+	in, weight := glass.Scatter(&normal, &out, 0, 0.999)
+	if sin := math.Sqrt(in.X*in.X + in.Z*in.Z); math.Abs(sin*1.5-math.Sqrt(0.5)) > 1e-9 || in.Y >= 0 || in.X >= 0 {
+		t.Errorf("The light should be refracted below the surface following Snell's law, not towards %v", in)
+	}
+	if weight != image.White {
+		t.Errorf("The weight of the chosen direction should be the albedo, not %s", weight.String())
+	}
+}
+
+func TestDielectricReflectsTotallyInside(t *testing.T) {
+	normal := math3d.Vector3{Y: 1}
+	// From inside, beyond the critical angle of asin(1/1.5), about 42 degrees
+	out := math3d.Vector3{X: 1, Y: -1}.NormalizedV()
+	for _, u := range []float64{0, 0.5, 0.999} {
+		in, _ := glass.Scatter(&normal, &out, 0, u)
+		if math.Abs(in.X+out.X) > 1e-9 || math.Abs(in.Y-out.Y) > 1e-9 {
+			t.Errorf("Light inside beyond the critical angle should be reflected, not sent towards %v", in)
+		}
+	}
+}
+
+func TestDielectricDispersion(t *testing.T) {
+	flint := &Dielectric{Albedo: image.White, IOR: 1.62, Abbe: 36}
+	if n := flint.IORAt(587.6); math.Abs(n-1.62) > 1e-12 {
+		t.Errorf("The index of refraction at 587.6 nm should be the IOR, not %g", n)
+	}
+	blue, red := flint.IORAt(486.1), flint.IORAt(656.3)
+	if abbe := (flint.IORAt(587.6) - 1) / (blue - red); math.Abs(abbe-36) > 1e-9 {
+		t.Errorf("The indices of refraction should have an Abbe number of 36, not %g", abbe)
+	}
+	if glass.Dispersive() || glass.IORAt(450) != 1.5 || flint.IORAt(0) != 1.62 {
+		t.Error("Without an Abbe number or a wavelength, the IOR shouldn't change")
+	}
+}
+
+func TestDielectricFromMap(t *testing.T) {
+	d := FromMap(map[string]interface{}{"type": "dielectric", "abbe": 50.0}).(*Dielectric)
+	if d.IOR != 1.5 || d.Abbe != 50 || d.Albedo != image.White {
+		t.Errorf("Unexpected material %+v", d)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("An index of refraction below 1 should be rejected")
+		}
+	}()
+	DielectricFromMap(map[string]interface{}{"ior": 0.9})
+}
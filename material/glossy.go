@@ -0,0 +1,110 @@
+package material
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+// Glossy defines a shiny material, such as polished metal or plastic,
+// that reflects light in a lobe around the mirror direction. It follows
+// the normalized Phong model.
+type Glossy struct {
+	// Albedo is the fraction of the light that is reflected
+	Albedo image.Color `json:"albedo"`
+	// Exponent is the sharpness of the reflections. The higher it is, the
+	// narrower the lobe. It can't be negative.
+	Exponent float64 `json:"exponent"`
+}
+
+// BRDF returns the albedo scaled by the power of the cosine of in with the
+// mirror direction of out
+func (g *Glossy) BRDF(normal, in, out *math3d.Vector3) image.Color {
+	cosine := in.DotV(mirror(normal, out))
+	if cosine <= 0 || in.Dot(normal) <= 0 {
+		return image.Black
+	}
+	return *g.Albedo.Multiply((g.Exponent + 2) / (2 * math.Pi) * math.Pow(cosine, g.Exponent))
+}
+
+// SampleDirection returns a direction around the mirror direction of out,
+// following the lobe of the BRDF. It may point below the surface, where
+// the BRDF is black.
+func (g *Glossy) SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	reflected := mirror(normal, out)
+	in := sampling.PowerCosine(&reflected, g.Exponent, u, v)
+	return in, sampling.PowerCosinePdf(g.Exponent, in.DotV(reflected))
+}
+
+// DirectionPdf returns the density of the lobe around the mirror direction
+// of out in the direction in
+func (g *Glossy) DirectionPdf(normal, in, out *math3d.Vector3) float64 {
+	return sampling.PowerCosinePdf(g.Exponent, in.DotV(mirror(normal, out)))
+}
+
+// AsMap returns a map representation of this material
+func (g *Glossy) AsMap() map[string]interface{} {
+	return map[string]interface{}{"type": "glossy", "albedo": colorAsMap(&g.Albedo), "exponent": g.Exponent}
+}
+
+// GlossyFromMap returns the glossy material defined in the map
+func GlossyFromMap(m map[string]interface{}) *Glossy {
+	exponent, _ := m["exponent"].(float64)
+	if !(exponent >= 0) || math.IsInf(exponent, 1) {
+		panic("The exponent of a glossy material must be finite and non negative")
+	}
+	return &Glossy{Albedo: colorFromMap(m["albedo"]), Exponent: exponent}
+}
+
+// jsonGlossy is a Glossy without its JSON methods, to encode it
+type jsonGlossy Glossy
+
+// MarshalJSON returns the material as an object with its type, albedo and
+// exponent
+func (g *Glossy) MarshalJSON() ([]byte, error) {
+	if err := jsonutil.Finite(g.Exponent); err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonGlossy
+	}{"glossy", jsonGlossy(*g)})
+}
+
+// UnmarshalJSON sets the material from an object with the type "glossy",
+// its exponent and its albedo, white if it's missing. It fails if the
+// exponent or the albedo are negative.
+func (g *Glossy) UnmarshalJSON(data []byte) error {
+	decoded := Glossy{Albedo: image.White}
+	var typename string
+	err := jsonutil.Object(data, map[string]interface{}{
+		"type":     &typename,
+		"albedo":   &decoded.Albedo,
+		"exponent": &decoded.Exponent,
+	}, "type", "exponent")
+	if err != nil {
+		return err
+	}
+	if typename != "glossy" {
+		return fmt.Errorf("not a glossy material")
+	}
+	if decoded.Exponent < 0 {
+		return fmt.Errorf("the exponent can't be negative")
+	}
+	if !decoded.Albedo.NonNegative() {
+		return fmt.Errorf("the albedo can't be negative")
+	}
+	*g = decoded
+	return nil
+}
+
+// mirror returns the direction of the perfect reflection of the unit
+// vector out off the surface with the normal
+func mirror(normal, out *math3d.Vector3) math3d.Vector3 {
+	return out.MultiplyV(-1).ReflectV(*normal)
+}
@@ -0,0 +1,92 @@
+package material
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestSampledDirectionsMatchTheirPdf(t *testing.T) {
+	normal := math3d.Vector3{X: 0.2, Y: 1, Z: 0.1}.NormalizedV()
+	out := math3d.Vector3{X: 1, Y: 1, Z: 0}.NormalizedV()
+	r := rand.New(rand.NewSource(3))
+	for _, mat := range []Material{&Lambertian{Albedo: image.White}, &Glossy{Albedo: image.White, Exponent: 50}} {
+		// Estimating the reflected fraction of uniform light, which can't be
+		// more than the albedo
+		reflected := 0.0
+		const samples = 100000
+		for i := 0; i < samples; i++ {
+			in, pdf := mat.SampleDirection(&normal, &out, r.Float64(), r.Float64())
+			if pdf <= 0 {
+				continue
+			}
+			if other := mat.DirectionPdf(&normal, &in, &out); math.Abs(other-pdf) > 1e-9*pdf {
+				t.Fatalf("%T: DirectionPdf returned %f for a direction sampled with a density of %f", mat, other, pdf)
+			}
+			brdf := mat.BRDF(&normal, &in, &out)
+			reflected += brdf.R * math.Max(0, in.DotV(normal)) / pdf
+		}
+		reflected /= samples
+		if reflected > 1.01 || reflected < 0.5 {
+			t.Errorf("%T: a white material should reflect most but not more of the light, it reflects %f", mat, reflected)
+		}
+	}
+}
+
+func TestGlossyFromMap(t *testing.T) {
+	g := FromMap(map[string]interface{}{"type": "glossy", "exponent": 20.0}).(*Glossy)
+	if g.Exponent != 20 || g.Albedo != image.White {
+		t.Errorf("Unexpected material %+v", g)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("A negative exponent should be rejected")
+		}
+	}()
+	GlossyFromMap(map[string]interface{}{"exponent": -1.0})
+}
+
+func TestMaterialJSON(t *testing.T) {
+	materials := []Material{
+		&Lambertian{Albedo: image.Color{R: 0.5, G: 0.25}},
+		&Glossy{Albedo: image.White, Exponent: 30},
+		&Subsurface{Albedo: image.White, MeanFreePath: image.Color{R: 1, G: 0.5, B: 0.25}},
+		&Dielectric{Albedo: image.White, IOR: 1.5, Abbe: 40},
+		&Standard{Albedo: image.White, Roughness: 0.3, IOR: 1.5, Coat: 1, CoatIOR: 1.6, ThinFilmIOR: 1.5},
+	}
+	for _, mat := range materials {
+		data, err := json.Marshal(mat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Unmarshal(data)
+		if err != nil || !reflect.DeepEqual(decoded, mat) {
+			t.Errorf("%s should decode to %v, not %v (%v)", data, mat, decoded, err)
+		}
+	}
+	if mat, err := Unmarshal([]byte(`{"type": "glossy", "exponent": 2}`)); err != nil || mat.(*Glossy).Albedo != image.White {
+		t.Errorf("A material without an albedo should be white, not %v (%v)", mat, err)
+	}
+	for _, invalid := range []string{
+		`{"albedo": {}}`,
+		`{"type": "metal"}`,
+		`{"type": "lambertian", "albedo": {"r": -1}}`,
+		`{"type": "lambertian", "exponent": 2}`,
+		`{"type": "glossy"}`,
+		`{"type": "glossy", "exponent": -1}`,
+		`{"type": "subsurface", "meanfreepath": {"r": 1, "g": 1}}`,
+		`{"type": "dielectric", "ior": 0.5}`,
+		`{"type": "dielectric", "abbe": -1}`,
+		`{"type": "standard", "roughness": 2}`,
+		`{"type": "standard", "coatior": 0.9}`,
+	} {
+		if _, err := Unmarshal([]byte(invalid)); err == nil {
+			t.Errorf("%s shouldn't decode", invalid)
+		}
+	}
+}
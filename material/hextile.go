@@ -0,0 +1,176 @@
+package material
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+// gaussianSigma is the standard deviation of the Gaussian the histograms of
+// the textures are mapped to before they're blended, so that the values of
+// up to 3 deviations from the mean stay in [0, 1]
+const gaussianSigma = 1.0 / 6
+
+// inverseSize is the number of values of the tables that map the Gaussian
+// values back to the histogram of the textures
+const inverseSize = 256
+
+// hexGridScale is how many rows of the triangular grid of hex tiling span
+// a repetition of the texture, 2√3 as in Heitz and Neyret's paper
+const hexGridScale = 3.464101615137754
+
+// gaussianized is a texture whose channels were mapped, texel by texel, to
+// the values of a Gaussian of the same ranks, so that blends of it that
+// preserve its variance have the same histogram, and the tables that map
+// them back. It's the transform of Heitz and Neyret's "High-Performance
+// By-Example Noise using a Histogram-Preserving Blending Operator", done
+// for every channel on its own rather than along decorrelated axes.
+type gaussianized struct {
+	texture *Texture
+	// inverse holds, for every channel, the value of the texture at
+	// inverseSize Gaussian values spread evenly over [0, 1]
+	inverse [3][]float64
+}
+
+// gaussianized returns the texture gaussianized, doing it the first time
+func (t *Texture) gaussianized() *gaussianized {
+	t.gaussianOnce.Do(func() {
+		t.gaussian = newGaussianized(t.levels[0])
+	})
+	return t.gaussian
+}
+
+// newGaussianized returns the texture of the level gaussianized, with its
+// own mipmap
+func newGaussianized(base mipLevel) *gaussianized {
+	n := len(base.texels)
+	g := &gaussianized{}
+	gaussian := mipLevel{width: base.width, height: base.height, texels: make([]image.Color, n)}
+	ranks := make([]int, n)
+	for channel := 0; channel < 3; channel++ {
+		for i := range ranks {
+			ranks[i] = i
+		}
+		sort.SliceStable(ranks, func(i, j int) bool {
+			return channelOf(&base.texels[ranks[i]], channel) < channelOf(&base.texels[ranks[j]], channel)
+		})
+		for rank, i := range ranks {
+			*channelPointer(&gaussian.texels[i], channel) = 0.5 + gaussianSigma*inverseNormal((float64(rank)+0.5)/float64(n))
+		}
+		g.inverse[channel] = make([]float64, inverseSize)
+		for i := range g.inverse[channel] {
+			quantile := normal(((float64(i)+0.5)/inverseSize - 0.5) / gaussianSigma)
+			rank := minInt(int(quantile*float64(n)), n-1)
+			g.inverse[channel][i] = channelOf(&base.texels[ranks[rank]], channel)
+		}
+	}
+	g.texture = &Texture{levels: []mipLevel{gaussian}}
+	for last := gaussian; last.width > 1 || last.height > 1; {
+		last = last.halved()
+		g.texture.levels = append(g.texture.levels, last)
+	}
+	return g
+}
+
+// normal returns the cumulative distribution of the standard normal
+// distribution at x
+func normal(x float64) float64 {
+	return 0.5 * (1 + math.Erf(x/math.Sqrt2))
+}
+
+// inverseNormal returns the value of the standard normal distribution
+// whose cumulative distribution is p
+func inverseNormal(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// invert maps the Gaussian value of the channel back to the histogram of
+// the texture, interpolating its table
+func (g *gaussianized) invert(value float64, channel int) float64 {
+	table := g.inverse[channel]
+	x := math.Min(math.Max(value*inverseSize-0.5, 0), inverseSize-1)
+	i := minInt(int(x), inverseSize-2)
+	f := x - float64(i)
+	return table[i]*(1-f) + table[i+1]*f
+}
+
+func channelOf(c *image.Color, channel int) float64 {
+	return *channelPointer(c, channel)
+}
+
+func channelPointer(c *image.Color, channel int) *float64 {
+	switch channel {
+	case 0:
+		return &c.R
+	case 1:
+		return &c.G
+	}
+	return &c.B
+}
+
+// hexTileNode is a node of an image texture tiled over a hexagonal grid,
+// every tile of which takes the texture at a random offset, blended with
+// its neighbours without changing the histogram of the texture, so that
+// small textures that repeat, such as grass or bricks, cover large
+// surfaces without showing how they repeat
+type hexTileNode struct {
+	texture *Texture
+	filter  string
+}
+
+func (n hexTileNode) Eval(p *ShadingPoint) image.Color {
+	g := n.texture.gaussianized()
+	vertices, weights := triangleGrid(p.U*hexGridScale, p.V*hexGridScale)
+	var blend [3]float64
+	squares := 0.0
+	for i, vertex := range vertices {
+		du, dv := hexOffset(vertex)
+		c := g.texture.Lookup(p.U+du, p.V+dv, p.DUDX, p.DVDX, p.DUDY, p.DVDY, n.filter)
+		for channel := range blend {
+			blend[channel] += weights[i] * (channelOf(&c, channel) - 0.5)
+		}
+		squares += weights[i] * weights[i]
+	}
+	// Dividing by the length of the weights keeps the variance of the
+	// blend the one of the tiles, where averaging them would lower it
+	scale := 1 / math.Sqrt(squares)
+	var c image.Color
+	for channel, value := range blend {
+		*channelPointer(&c, channel) = g.invert(0.5+value*scale, channel)
+	}
+	return c
+}
+
+func (n hexTileNode) value() interface{} {
+	m := map[string]interface{}{"node": "hextile", "file": n.texture.Image}
+	if n.filter != Trilinear {
+		m["filter"] = n.filter
+	}
+	return m
+}
+
+// triangleGrid returns the vertices of the triangle of the regular
+// triangular grid that holds the point, which are the centers of the
+// hexagonal tiles around it, and the barycentric weights of the point in
+// it. The grid is skewed so that its vertices have integer coordinates.
+func triangleGrid(x, y float64) ([3][2]int, [3]float64) {
+	skewedX, skewedY := x-y/math.Sqrt(3), y*2/math.Sqrt(3)
+	baseX, baseY := math.Floor(skewedX), math.Floor(skewedY)
+	fx, fy := skewedX-baseX, skewedY-baseY
+	bx, by := int(baseX), int(baseY)
+	if z := 1 - fx - fy; z > 0 {
+		return [3][2]int{{bx, by}, {bx, by + 1}, {bx + 1, by}}, [3]float64{z, fy, fx}
+	}
+	return [3][2]int{{bx + 1, by + 1}, {bx + 1, by}, {bx, by + 1}}, [3]float64{fx + fy - 1, 1 - fy, 1 - fx}
+}
+
+// hexOffset returns the random offset of the texture in the tile of the
+// vertex, in [0, 1) in u and in v
+func hexOffset(vertex [2]int) (float64, float64) {
+	h := uint64(uint32(vertex[0]))*0x9e3779b97f4a7c15 ^ uint64(uint32(vertex[1]))*0xc2b2ae3d27d4eb4f
+	h ^= h >> 31
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 29
+	return float64(h>>40) / (1 << 24), float64(h&(1<<24-1)) / (1 << 24)
+}
@@ -0,0 +1,104 @@
+package material
+
+import (
+	stdimg "image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHexTilingKeepsTheHistogramOfTheTexture(t *testing.T) {
+	img := stdimg.NewGray(stdimg.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x*37 + y*101) % 256)})
+		}
+	}
+	path := filepath.Join(t.TempDir(), "noise.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, img)
+	f.Close()
+	node, err := ParseNode(map[string]interface{}{"node": "hextile", "file": path, "filter": "nearest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiled, ok := node.(hexTileNode)
+	if !ok {
+		t.Fatalf("A hextile node should tile the texture, not be a %T", node)
+	}
+	if again, _ := ParseNode(node.value()); again != node {
+		t.Errorf("The node should parse back from its value %v", node.value())
+	}
+
+	moments := func(values []float64) (float64, float64) {
+		mean, squares := 0.0, 0.0
+		for _, v := range values {
+			mean += v
+			squares += v * v
+		}
+		mean /= float64(len(values))
+		return mean, math.Sqrt(squares/float64(len(values)) - mean*mean)
+	}
+	texels := make([]float64, 0, 32*32)
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, c := range tiled.texture.levels[0].texels {
+		texels = append(texels, c.R)
+		low, high = math.Min(low, c.R), math.Max(high, c.R)
+	}
+	r := rand.New(rand.NewSource(1))
+	samples := make([]float64, 20000)
+	for i := range samples {
+		c := node.Eval(&ShadingPoint{U: r.Float64() * 10, V: r.Float64() * 10})
+		if c.R < low-1e-9 || c.R > high+1e-9 || c.R != c.G || c.G != c.B {
+			t.Fatalf("The tiling should only take grays of the texture, not %v", c)
+		}
+		samples[i] = c.R
+	}
+	mean, deviation := moments(texels)
+	tiledMean, tiledDeviation := moments(samples)
+	if math.Abs(tiledMean-mean) > 0.03 || math.Abs(tiledDeviation-deviation) > 0.1*deviation {
+		t.Errorf("The tiling should keep the mean %.3f and the deviation %.3f of the texture, not %.3f and %.3f",
+			mean, deviation, tiledMean, tiledDeviation)
+	}
+
+	for _, bad := range []map[string]interface{}{
+		{"node": "hextile"},
+		{"node": "hextile", "file": path, "filter": "cubic"},
+	} {
+		if _, err := ParseNode(bad); err == nil {
+			t.Errorf("%v should be an error", bad)
+		}
+	}
+}
+
+func TestTriangleGridWeighsTheTilesAroundThePoint(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		x, y := r.Float64()*20-10, r.Float64()*20-10
+		vertices, weights := triangleGrid(x, y)
+		// The vertices, out of the skewed grid, weighted by the weights
+		// are the point
+		sum, px, py := 0.0, 0.0, 0.0
+		for j, w := range weights {
+			if w < -1e-12 {
+				t.Fatalf("The weights of %v, %v should be positive, not %v", x, y, weights)
+			}
+			sum += w
+			px += w * (float64(vertices[j][0]) + float64(vertices[j][1])/2)
+			py += w * float64(vertices[j][1]) * math.Sqrt(3) / 2
+		}
+		if math.Hypot(px-x, py-y) > 1e-9 {
+			t.Fatalf("The vertices weighted should be %v, %v, not %v, %v", x, y, px, py)
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Fatalf("The weights of %v, %v should add up to 1, not %v", x, y, sum)
+		}
+	}
+}
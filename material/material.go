@@ -0,0 +1,215 @@
+package material
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+// Material defines how light scatters at the surface of a shape
+type Material interface {
+	// BRDF returns the fraction of the light arriving from direction in
+	// that is reflected towards out, at a point of the surface with the
+	// given normal. All the vectors point away from the surface.
+	BRDF(normal, in, out *math3d.Vector3) image.Color
+	// SampleDirection returns a direction in from which light arriving at
+	// the surface may be reflected towards out, given two uniform numbers
+	// in [0, 1), and the density with respect to solid angle of choosing
+	// it. The density is 0 if the material can't be sampled.
+	SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64)
+	// DirectionPdf returns the density with which SampleDirection returns
+	// the direction in
+	DirectionPdf(normal, in, out *math3d.Vector3) float64
+	AsMap() map[string]interface{}
+}
+
+// Specular is a material that scatters the light arriving from a single
+// direction into a single direction, such as glass. Its SampleDirection
+// never returns a direction; the integrators follow Scatter instead.
+type Specular interface {
+	Material
+	// Scatter returns the direction in which light arriving at the surface
+	// is scattered towards out, given a uniform number in [0, 1), and the
+	// fraction of the light it carries divided by the probability of
+	// choosing it. normal points away from the front of the surface, and
+	// the light has the wavelength in nanometers, or is white if it's 0.
+	Scatter(normal, out *math3d.Vector3, wavelength, u float64) (math3d.Vector3, image.Color)
+	// Dispersive returns true if the direction depends on the wavelength
+	Dispersive() bool
+}
+
+// Default is the material of the shapes that don't have one
+var Default Material = &Lambertian{Albedo: image.White}
+
+// Tint returns a copy of the material with its albedo multiplied by the
+// color, such as the color of the vertices of a scanned mesh. Materials
+// without an albedo are returned as they are.
+func Tint(m Material, c image.Color) Material {
+	switch m := m.(type) {
+	case *Lambertian:
+		return &Lambertian{Albedo: *m.Albedo.CMultiply(&c)}
+	case *Glossy:
+		tinted := *m
+		tinted.Albedo = *m.Albedo.CMultiply(&c)
+		return &tinted
+	case *Subsurface:
+		tinted := *m
+		tinted.Albedo = *m.Albedo.CMultiply(&c)
+		return &tinted
+	case *Dielectric:
+		tinted := *m
+		tinted.Albedo = *m.Albedo.CMultiply(&c)
+		return &tinted
+	case *Standard:
+		tinted := *m
+		tinted.Albedo = *m.Albedo.CMultiply(&c)
+		return &tinted
+	}
+	return m
+}
+
+// Lambertian defines a perfectly diffuse material, that reflects light
+// the same towards every direction
+type Lambertian struct {
+	// Albedo is the fraction of the light that is reflected
+	Albedo image.Color `json:"albedo"`
+}
+
+// BRDF returns the albedo over pi no matter the directions
+func (l *Lambertian) BRDF(normal, in, out *math3d.Vector3) image.Color {
+	return *l.Albedo.Divide(math.Pi)
+}
+
+// SampleDirection returns a direction of the hemisphere of the normal,
+// with a density proportional to its cosine with the normal
+func (l *Lambertian) SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	in := sampling.CosineHemisphere(normal, u, v)
+	return in, l.DirectionPdf(normal, &in, out)
+}
+
+// DirectionPdf returns the cosine of in with the normal over pi
+func (l *Lambertian) DirectionPdf(normal, in, out *math3d.Vector3) float64 {
+	return math.Max(0, in.Dot(normal)) / math.Pi
+}
+
+// AsMap returns a map representation of this material
+func (l *Lambertian) AsMap() map[string]interface{} {
+	return map[string]interface{}{"type": "lambertian", "albedo": colorAsMap(&l.Albedo)}
+}
+
+// jsonLambertian is a Lambertian without its JSON methods, to encode it
+type jsonLambertian Lambertian
+
+// MarshalJSON returns the material as an object with its type and albedo
+func (l *Lambertian) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonLambertian
+	}{"lambertian", jsonLambertian(*l)})
+}
+
+// UnmarshalJSON sets the material from an object with the type
+// "lambertian" and its albedo, white if it's missing
+func (l *Lambertian) UnmarshalJSON(data []byte) error {
+	decoded := Lambertian{Albedo: image.White}
+	var typename string
+	err := jsonutil.Object(data, map[string]interface{}{"type": &typename, "albedo": &decoded.Albedo}, "type")
+	if err != nil {
+		return err
+	}
+	if typename != "lambertian" {
+		return fmt.Errorf("not a lambertian material")
+	}
+	if !decoded.Albedo.NonNegative() {
+		return fmt.Errorf("the albedo can't be negative")
+	}
+	*l = decoded
+	return nil
+}
+
+// Unmarshal returns the material in the JSON object, whose "type" is the
+// type of material, of the package or registered by RegisterMaterial
+func Unmarshal(data []byte) (Material, error) {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, fmt.Errorf("not an object")
+	}
+	var mat Material
+	switch typed.Type {
+	case "lambertian":
+		mat = &Lambertian{}
+	case "glossy":
+		mat = &Glossy{}
+	case "subsurface":
+		mat = &Subsurface{}
+	case "dielectric":
+		mat = &Dielectric{}
+	case "standard":
+		mat = &Standard{}
+	case "holdout":
+		mat = &Holdout{}
+	case "shadowcatcher":
+		mat = &ShadowCatcher{}
+	case "procedural":
+		mat = &Procedural{}
+	default:
+		t, ok := lookup(typed.Type)
+		if !ok {
+			return nil, fmt.Errorf("unknown material type %q", typed.Type)
+		}
+		return unmarshalRegistered(t, data)
+	}
+	if err := json.Unmarshal(data, mat); err != nil {
+		return nil, err
+	}
+	return mat, nil
+}
+
+// FromMap returns the material defined in the map, of the types of the
+// package or registered by RegisterMaterial
+func FromMap(m map[string]interface{}) Material {
+	switch m["type"] {
+	case "lambertian":
+		return &Lambertian{Albedo: colorFromMap(m["albedo"])}
+	case "glossy":
+		return GlossyFromMap(m)
+	case "subsurface":
+		return SubsurfaceFromMap(m)
+	case "dielectric":
+		return DielectricFromMap(m)
+	case "standard":
+		return StandardFromMap(m)
+	case "holdout":
+		return &Holdout{}
+	case "shadowcatcher":
+		return &ShadowCatcher{}
+	case "procedural":
+		return ProceduralFromMap(m)
+	default:
+		t, ok := lookup(m["type"])
+		if !ok {
+			panic("That material is not implemented yet or the type field is empty")
+		}
+		return t.fromMap(m)
+	}
+}
+
+func colorAsMap(c *image.Color) map[string]float64 {
+	return map[string]float64{"r": c.R, "g": c.G, "b": c.B}
+}
+
+// colorFromMap returns the color in value, or white if there is none
+func colorFromMap(value interface{}) image.Color {
+	c, err := image.ColorFromMap(value)
+	if err != nil {
+		return image.White
+	}
+	return c
+}
@@ -0,0 +1,101 @@
+package material
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Holdout is the material of the shapes that stand in for the objects of
+// a photograph the render is composited over, such as a table the
+// rendered objects stand behind. They hide what's behind them and cast
+// shadows, but they are black and, in transparent renders, they cut the
+// alpha of the render to let the photograph show through.
+type Holdout struct{}
+
+// BRDF returns black, as holdouts reflect no light
+func (h *Holdout) BRDF(normal, in, out *math3d.Vector3) image.Color {
+	return image.Black
+}
+
+// SampleDirection returns a density of 0, as holdouts reflect no light
+func (h *Holdout) SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	return math3d.Vector3{}, 0
+}
+
+// DirectionPdf returns 0, as SampleDirection never returns a direction
+func (h *Holdout) DirectionPdf(normal, in, out *math3d.Vector3) float64 {
+	return 0
+}
+
+// AsMap returns a map representation of this material
+func (h *Holdout) AsMap() map[string]interface{} {
+	return map[string]interface{}{"type": "holdout"}
+}
+
+// MarshalJSON returns the material as an object with its type
+func (h *Holdout) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.AsMap())
+}
+
+// UnmarshalJSON sets the material from an object with the type "holdout"
+func (h *Holdout) UnmarshalJSON(data []byte) error {
+	return unmarshalMatte(data, "holdout")
+}
+
+// ShadowCatcher is the material of the shapes that stand in for the
+// surfaces of a photograph the render is composited over, such as the
+// floor the rendered objects stand on, to catch their shadows. They are
+// black, and in transparent renders their alpha is how much of the light
+// arriving at them the other shapes hide, so that compositing the render
+// darkens the photograph where the shadows fall.
+type ShadowCatcher struct{}
+
+// BRDF returns black, as the shadow catchers only show their shadows in
+// the alpha of the render
+func (c *ShadowCatcher) BRDF(normal, in, out *math3d.Vector3) image.Color {
+	return image.Black
+}
+
+// SampleDirection returns a density of 0, as shadow catchers reflect no
+// light
+func (c *ShadowCatcher) SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	return math3d.Vector3{}, 0
+}
+
+// DirectionPdf returns 0, as SampleDirection never returns a direction
+func (c *ShadowCatcher) DirectionPdf(normal, in, out *math3d.Vector3) float64 {
+	return 0
+}
+
+// AsMap returns a map representation of this material
+func (c *ShadowCatcher) AsMap() map[string]interface{} {
+	return map[string]interface{}{"type": "shadowcatcher"}
+}
+
+// MarshalJSON returns the material as an object with its type
+func (c *ShadowCatcher) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.AsMap())
+}
+
+// UnmarshalJSON sets the material from an object with the type
+// "shadowcatcher"
+func (c *ShadowCatcher) UnmarshalJSON(data []byte) error {
+	return unmarshalMatte(data, "shadowcatcher")
+}
+
+// unmarshalMatte returns an error if the data isn't an object with only
+// the type, which must be typename
+func unmarshalMatte(data []byte, typename string) error {
+	var decoded string
+	if err := jsonutil.Object(data, map[string]interface{}{"type": &decoded}, "type"); err != nil {
+		return err
+	}
+	if decoded != typename {
+		return fmt.Errorf("not a %s material", typename)
+	}
+	return nil
+}
@@ -0,0 +1,578 @@
+package material
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// ShadingPoint is a point of a surface being shaded
+type ShadingPoint struct {
+	// Point is the point of the surface, and Normal its unit normal there
+	Point, Normal math3d.Vector3
+	// Out is the unit direction from the point towards the viewer
+	Out math3d.Vector3
+	// U and V are the texture coordinates of the point, or 0 on the
+	// surfaces of shapes without any
+	U, V float64
+	// DUDX and DVDX are how much the texture coordinates change from the
+	// point to the one the pixel right of it sees, and DUDY and DVDY to
+	// the one the pixel below sees, so that image textures are filtered
+	// over the footprint of the pixel. They're 0 where it isn't known,
+	// which filters them the least.
+	DUDX, DVDX, DUDY, DVDY float64
+}
+
+// Varying is a material that varies across the surface, such as a
+// procedural one. At returns the material at a shading point, which
+// doesn't vary. The methods of Material shade it as it is at the origin,
+// for what doesn't know where the surface is shaded.
+type Varying interface {
+	Material
+	At(p *ShadingPoint) Material
+}
+
+// Node is a node of the shader graph of a procedural material, which
+// computes a color at every shading point. Nodes that compute numbers
+// compute grays, and the nodes that take numbers take the average of the
+// channels of the colors of their inputs.
+//
+// In scene files, a node is a number, a color, the name of an input of the
+// shading point, or an object with the kind of node in "node" and its
+// inputs:
+//
+//	0.5, {"r": 1, "g": 0, "b": 0}   constants
+//	"u", "v", "x", "y", "z"          the texture coordinates and the point
+//	{"node": "mix", "a": A, "b": B, "factor": F}
+//	                                 A where F is 0 and B where it's 1
+//	{"node": "multiply", "a": A, "b": B}
+//	{"node": "noise", "scale": 4, "octaves": 3}
+//	                                 Perlin noise in [0, 1] of the point
+//	                                 times scale, 1 if missing, summed
+//	                                 over octaves, 1 if missing
+//	{"node": "ramp", "input": I, "stops": [{"at": 0, "color": C}, ...]}
+//	                                 the colors of the stops interpolated
+//	                                 at I, sorted by at
+//	{"node": "fresnel", "ior": 1.5}  the fraction of the light a
+//	                                 dielectric surface reflects
+//	{"node": "image", "file": "wood.png", "filter": "trilinear"}
+//	                                 the image at the texture coordinates,
+//	                                 filtered with nearest, bilinear,
+//	                                 trilinear, the default, or
+//	                                 anisotropic filtering
+//	{"node": "hextile", "file": "grass.png", "filter": "trilinear"}
+//	                                 the image tiled over hexagons at
+//	                                 random offsets, blended keeping its
+//	                                 histogram, so that it covers large
+//	                                 surfaces without visible repetition
+//
+// Scene files give the files of the image and hextile nodes relative to
+// their own directory.
+type Node interface {
+	Eval(p *ShadingPoint) image.Color
+	// value returns the node in the scene file format
+	value() interface{}
+}
+
+// Procedural is a material whose color, and how glossy it is, are the
+// colors of the nodes of a shader graph at every shading point, so that
+// procedural looks, such as marble or rust, can be made in scene files.
+// It's lambertian if it has no exponent, and glossy otherwise.
+type Procedural struct {
+	Color Node
+	// Exponent is the exponent of the glossy material, nil for a
+	// lambertian one
+	Exponent Node
+}
+
+// At returns the material at the shading point, with the color and the
+// exponent the nodes compute there. Negative channels are taken as 0.
+func (p *Procedural) At(sp *ShadingPoint) Material {
+	color := p.Color.Eval(sp)
+	color = image.Color{R: math.Max(color.R, 0), G: math.Max(color.G, 0), B: math.Max(color.B, 0)}
+	if p.Exponent == nil {
+		return &Lambertian{Albedo: color}
+	}
+	exponent := p.Exponent.Eval(sp)
+	return &Glossy{Albedo: color, Exponent: math.Max(average(exponent), 0)}
+}
+
+// BRDF returns the BRDF of the material at the origin
+func (p *Procedural) BRDF(normal, in, out *math3d.Vector3) image.Color {
+	return p.At(&ShadingPoint{Normal: *normal, Out: *out}).BRDF(normal, in, out)
+}
+
+// SampleDirection samples a direction of the material at the origin
+func (p *Procedural) SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	return p.At(&ShadingPoint{Normal: *normal, Out: *out}).SampleDirection(normal, out, u, v)
+}
+
+// DirectionPdf returns the density of the directions of the material at
+// the origin
+func (p *Procedural) DirectionPdf(normal, in, out *math3d.Vector3) float64 {
+	return p.At(&ShadingPoint{Normal: *normal, Out: *out}).DirectionPdf(normal, in, out)
+}
+
+// AsMap returns a map representation of this material
+func (p *Procedural) AsMap() map[string]interface{} {
+	m := map[string]interface{}{"type": "procedural", "color": p.Color.value()}
+	if p.Exponent != nil {
+		m["exponent"] = p.Exponent.value()
+	}
+	return m
+}
+
+// ProceduralFromMap returns the procedural material defined in the map. It
+// panics if its nodes aren't valid.
+func ProceduralFromMap(m map[string]interface{}) *Procedural {
+	p, err := proceduralFromMap(m)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// proceduralFromMap returns the procedural material defined in the map, or
+// why it can't be made
+func proceduralFromMap(m map[string]interface{}) (*Procedural, error) {
+	for k := range m {
+		if k != "type" && k != "color" && k != "exponent" {
+			return nil, fmt.Errorf("unknown key %q", k)
+		}
+	}
+	value, ok := m["color"]
+	if !ok {
+		return nil, fmt.Errorf("missing key %q", "color")
+	}
+	p := &Procedural{}
+	var err error
+	if p.Color, err = ParseNode(value); err != nil {
+		return nil, fmt.Errorf("color: %v", err)
+	}
+	if value, ok := m["exponent"]; ok {
+		if p.Exponent, err = ParseNode(value); err != nil {
+			return nil, fmt.Errorf("exponent: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// MarshalJSON returns the material as an object with its type and nodes
+func (p *Procedural) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.AsMap())
+}
+
+// UnmarshalJSON sets the material from an object with the type
+// "procedural" and its nodes
+func (p *Procedural) UnmarshalJSON(data []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil || m == nil {
+		return fmt.Errorf("not an object")
+	}
+	if m["type"] != "procedural" {
+		return fmt.Errorf("not a procedural material")
+	}
+	decoded, err := proceduralFromMap(m)
+	if err != nil {
+		return err
+	}
+	*p = *decoded
+	return nil
+}
+
+// ParseNode returns the node of a shader graph in value, decoded from the
+// JSON of a scene file
+func ParseNode(value interface{}) (Node, error) {
+	switch v := value.(type) {
+	case float64:
+		return constant{R: v, G: v, B: v}, nil
+	case string:
+		if !contains([]string{"u", "v", "x", "y", "z"}, v) {
+			return nil, fmt.Errorf("unknown input %q", v)
+		}
+		return input(v), nil
+	case map[string]interface{}:
+		if _, ok := v["node"]; !ok {
+			if err := keys(v, []string{"r", "g", "b"}, "r", "g", "b"); err != nil {
+				return nil, err
+			}
+			c := constant{}
+			var ok [3]bool
+			c.R, ok[0] = v["r"].(float64)
+			c.G, ok[1] = v["g"].(float64)
+			c.B, ok[2] = v["b"].(float64)
+			if ok != [3]bool{true, true, true} {
+				return nil, fmt.Errorf("the channels of a color must be numbers")
+			}
+			return c, nil
+		}
+		return parseOperation(v)
+	}
+	return nil, fmt.Errorf("a node must be a number, a color, an input or an object with a node")
+}
+
+// parseOperation returns the node in the object of a node with a "node"
+func parseOperation(m map[string]interface{}) (Node, error) {
+	inputs := func(names ...string) ([]Node, error) {
+		nodes := make([]Node, len(names))
+		for i, name := range names {
+			node, err := ParseNode(m[name])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			nodes[i] = node
+		}
+		return nodes, nil
+	}
+	kind, _ := m["node"].(string)
+	switch kind {
+	case "mix":
+		if err := keys(m, []string{"node", "a", "b", "factor"}, "a", "b", "factor"); err != nil {
+			return nil, err
+		}
+		nodes, err := inputs("a", "b", "factor")
+		if err != nil {
+			return nil, err
+		}
+		return mix{a: nodes[0], b: nodes[1], factor: nodes[2]}, nil
+	case "multiply":
+		if err := keys(m, []string{"node", "a", "b"}, "a", "b"); err != nil {
+			return nil, err
+		}
+		nodes, err := inputs("a", "b")
+		if err != nil {
+			return nil, err
+		}
+		return multiply{a: nodes[0], b: nodes[1]}, nil
+	case "noise":
+		if err := keys(m, []string{"node", "scale", "octaves"}); err != nil {
+			return nil, err
+		}
+		n := noise{scale: 1, octaves: 1}
+		if scale, present := m["scale"]; present {
+			n.scale, _ = scale.(float64)
+		}
+		if octaves, present := m["octaves"]; present {
+			value, _ := octaves.(float64)
+			n.octaves = int(value)
+			if float64(n.octaves) != value {
+				n.octaves = 0
+			}
+		}
+		if !(n.scale > 0) || math.IsInf(n.scale, 1) || n.octaves < 1 || n.octaves > 16 {
+			return nil, fmt.Errorf("the scale of noise must be positive and finite, and the octaves from 1 to 16")
+		}
+		return n, nil
+	case "ramp":
+		if err := keys(m, []string{"node", "input", "stops"}, "input", "stops"); err != nil {
+			return nil, err
+		}
+		nodes, err := inputs("input")
+		if err != nil {
+			return nil, err
+		}
+		return parseRamp(nodes[0], m["stops"])
+	case "fresnel":
+		if err := keys(m, []string{"node", "ior"}, "ior"); err != nil {
+			return nil, err
+		}
+		ior, _ := m["ior"].(float64)
+		if !(ior >= 1) || math.IsInf(ior, 1) {
+			return nil, fmt.Errorf("the index of refraction must be finite and at least 1")
+		}
+		return fresnelNode{ior: ior}, nil
+	case "image", "hextile":
+		return parseTexture(m)
+	}
+	return nil, fmt.Errorf("unknown node %q", kind)
+}
+
+// parseRamp returns the ramp of the input with the stops in value
+func parseRamp(in Node, value interface{}) (Node, error) {
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("stops: must be a list of at least 1 stop")
+	}
+	r := ramp{input: in}
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("stops: not an object")
+		}
+		if err := keys(m, []string{"at", "color"}, "at", "color"); err != nil {
+			return nil, fmt.Errorf("stops: %v", err)
+		}
+		color, err := ParseNode(m["color"])
+		c, isConstant := color.(constant)
+		if err != nil || !isConstant {
+			return nil, fmt.Errorf("stops: the colors must be numbers or colors")
+		}
+		at, _ := m["at"].(float64)
+		if math.IsInf(at, 0) || (i > 0 && !(at > r.stops[i-1].at)) {
+			return nil, fmt.Errorf("stops: the stops must be finite and sorted")
+		}
+		r.stops = append(r.stops, stop{at: at, color: image.Color(c)})
+	}
+	return r, nil
+}
+
+// keys returns an error if m has a key that isn't known, a value that
+// isn't a number for any of the numbers, or lacks one of the required
+// keys. Only the numbers are checked, as the other values are nodes. The
+// channels of colors are checked where they're parsed, as "b" is also an
+// input of nodes.
+func keys(m map[string]interface{}, known []string, required ...string) error {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	// Sorted keys make the error the same every time
+	sort.Strings(names)
+	numbers := []string{"scale", "octaves", "at", "ior"}
+	for _, k := range names {
+		if !contains(known, k) {
+			return fmt.Errorf("unknown key %q", k)
+		}
+		if _, ok := m[k].(float64); contains(numbers, k) && !ok {
+			return fmt.Errorf("%s: must be a number", k)
+		}
+	}
+	for _, k := range required {
+		if _, present := m[k]; !present {
+			return fmt.Errorf("missing key %q", k)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// average returns the average of the channels of the color
+func average(c image.Color) float64 {
+	return (c.R + c.G + c.B) / 3
+}
+
+// constant is a node of a constant color
+type constant image.Color
+
+func (c constant) Eval(p *ShadingPoint) image.Color {
+	return image.Color(c)
+}
+
+func (c constant) value() interface{} {
+	if c.R == c.G && c.G == c.B {
+		return c.R
+	}
+	return map[string]float64{"r": c.R, "g": c.G, "b": c.B}
+}
+
+// input is a node of a coordinate of the shading point
+type input string
+
+func (in input) Eval(p *ShadingPoint) image.Color {
+	var v float64
+	switch in {
+	case "u":
+		v = p.U
+	case "v":
+		v = p.V
+	case "x":
+		v = p.Point.X
+	case "y":
+		v = p.Point.Y
+	case "z":
+		v = p.Point.Z
+	}
+	return image.Color{R: v, G: v, B: v}
+}
+
+func (in input) value() interface{} {
+	return string(in)
+}
+
+// mix is a node that blends a into b by factor
+type mix struct {
+	a, b, factor Node
+}
+
+func (m mix) Eval(p *ShadingPoint) image.Color {
+	a, b, f := m.a.Eval(p), m.b.Eval(p), average(m.factor.Eval(p))
+	return image.Color{R: a.R + (b.R-a.R)*f, G: a.G + (b.G-a.G)*f, B: a.B + (b.B-a.B)*f}
+}
+
+func (m mix) value() interface{} {
+	return map[string]interface{}{"node": "mix", "a": m.a.value(), "b": m.b.value(), "factor": m.factor.value()}
+}
+
+// multiply is a node that multiplies the channels of a and b
+type multiply struct {
+	a, b Node
+}
+
+func (m multiply) Eval(p *ShadingPoint) image.Color {
+	a, b := m.a.Eval(p), m.b.Eval(p)
+	return *a.CMultiply(&b)
+}
+
+func (m multiply) value() interface{} {
+	return map[string]interface{}{"node": "multiply", "a": m.a.value(), "b": m.b.value()}
+}
+
+// noise is a node of fractal Perlin noise of the point
+type noise struct {
+	scale   float64
+	octaves int
+}
+
+func (n noise) Eval(p *ShadingPoint) image.Color {
+	sum, amplitude, total := 0.0, 1.0, 0.0
+	point := p.Point.MultiplyV(n.scale)
+	for i := 0; i < n.octaves; i++ {
+		sum += amplitude * perlin(point.X, point.Y, point.Z)
+		total += amplitude
+		amplitude /= 2
+		point = point.MultiplyV(2)
+	}
+	v := math.Min(math.Max(sum/total*0.5+0.5, 0), 1)
+	return image.Color{R: v, G: v, B: v}
+}
+
+func (n noise) value() interface{} {
+	return map[string]interface{}{"node": "noise", "scale": n.scale, "octaves": n.octaves}
+}
+
+// stop is a color of a ramp, at a value of its input
+type stop struct {
+	at    float64
+	color image.Color
+}
+
+// ramp is a node that maps its input to the colors of its stops
+type ramp struct {
+	input Node
+	stops []stop
+}
+
+func (r ramp) Eval(p *ShadingPoint) image.Color {
+	at := average(r.input.Eval(p))
+	next := sort.Search(len(r.stops), func(i int) bool { return r.stops[i].at > at })
+	if next == 0 {
+		return r.stops[0].color
+	}
+	if next == len(r.stops) {
+		return r.stops[next-1].color
+	}
+	s1, s2 := r.stops[next-1], r.stops[next]
+	u := (at - s1.at) / (s2.at - s1.at)
+	return image.Color{R: s1.color.R + (s2.color.R-s1.color.R)*u,
+		G: s1.color.G + (s2.color.G-s1.color.G)*u, B: s1.color.B + (s2.color.B-s1.color.B)*u}
+}
+
+func (r ramp) value() interface{} {
+	stops := make([]interface{}, len(r.stops))
+	for i, s := range r.stops {
+		stops[i] = map[string]interface{}{"at": s.at, "color": constant(s.color).value()}
+	}
+	return map[string]interface{}{"node": "ramp", "input": r.input.value(), "stops": stops}
+}
+
+// fresnelNode is a node of the fraction of the light a dielectric surface
+// with the index of refraction reflects towards the viewer
+type fresnelNode struct {
+	ior float64
+}
+
+func (f fresnelNode) Eval(p *ShadingPoint) image.Color {
+	cosOut := math.Min(math.Abs(p.Out.DotV(p.Normal)), 1)
+	cosIn := math.Sqrt(1 - (1-cosOut*cosOut)/(f.ior*f.ior))
+	v := fresnel(cosOut, cosIn, f.ior)
+	return image.Color{R: v, G: v, B: v}
+}
+
+func (f fresnelNode) value() interface{} {
+	return map[string]interface{}{"node": "fresnel", "ior": f.ior}
+}
+
+// perlin returns the gradient noise of Ken Perlin's improved noise at the
+// point, in [-1, 1]
+func perlin(x, y, z float64) float64 {
+	fx, fy, fz := math.Floor(x), math.Floor(y), math.Floor(z)
+	X, Y, Z := int(fx)&255, int(fy)&255, int(fz)&255
+	x, y, z = x-fx, y-fy, z-fz
+	u, v, w := fade(x), fade(y), fade(z)
+	p := &permutation
+	a, b := int(p[X])+Y, int(p[X+1])+Y
+	aa, ab, ba, bb := int(p[a])+Z, int(p[a+1])+Z, int(p[b])+Z, int(p[b+1])+Z
+	return lerp(w,
+		lerp(v, lerp(u, grad(p[aa], x, y, z), grad(p[ba], x-1, y, z)),
+			lerp(u, grad(p[ab], x, y-1, z), grad(p[bb], x-1, y-1, z))),
+		lerp(v, lerp(u, grad(p[aa+1], x, y, z-1), grad(p[ba+1], x-1, y, z-1)),
+			lerp(u, grad(p[ab+1], x, y-1, z-1), grad(p[bb+1], x-1, y-1, z-1))))
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(t, a, b float64) float64 {
+	return a + t*(b-a)
+}
+
+// grad returns the dot product of the point with one of the 12 gradients of
+// improved noise, picked by the hash
+func grad(hash uint8, x, y, z float64) float64 {
+	h := hash & 15
+	u, v := y, z
+	if h < 8 {
+		u = x
+	}
+	if h < 4 {
+		v = y
+	} else if h == 12 || h == 14 {
+		v = x
+	}
+	if h&1 != 0 {
+		u = -u
+	}
+	if h&2 != 0 {
+		v = -v
+	}
+	return u + v
+}
+
+// permutation is Ken Perlin's permutation of 0 to 255, twice over so that
+// the hashes of neighbouring cells don't wrap around
+var permutation = func() [512]uint8 {
+	base := [256]uint8{151, 160, 137, 91, 90, 15, 131, 13, 201, 95, 96, 53, 194, 233, 7, 225,
+		140, 36, 103, 30, 69, 142, 8, 99, 37, 240, 21, 10, 23, 190, 6, 148,
+		247, 120, 234, 75, 0, 26, 197, 62, 94, 252, 219, 203, 117, 35, 11, 32,
+		57, 177, 33, 88, 237, 149, 56, 87, 174, 20, 125, 136, 171, 168, 68, 175,
+		74, 165, 71, 134, 139, 48, 27, 166, 77, 146, 158, 231, 83, 111, 229, 122,
+		60, 211, 133, 230, 220, 105, 92, 41, 55, 46, 245, 40, 244, 102, 143, 54,
+		65, 25, 63, 161, 1, 216, 80, 73, 209, 76, 132, 187, 208, 89, 18, 169,
+		200, 196, 135, 130, 116, 188, 159, 86, 164, 100, 109, 198, 173, 186, 3, 64,
+		52, 217, 226, 250, 124, 123, 5, 202, 38, 147, 118, 126, 255, 82, 85, 212,
+		207, 206, 59, 227, 47, 16, 58, 17, 182, 189, 28, 42, 223, 183, 170, 213,
+		119, 248, 152, 2, 44, 154, 163, 70, 221, 153, 101, 155, 167, 43, 172, 9,
+		129, 22, 39, 253, 19, 98, 108, 110, 79, 113, 224, 232, 178, 185, 112, 104,
+		218, 246, 97, 228, 251, 34, 242, 193, 238, 210, 144, 12, 191, 179, 162, 241,
+		81, 51, 145, 235, 249, 14, 239, 107, 49, 192, 214, 31, 181, 199, 106, 157,
+		184, 84, 204, 176, 115, 121, 50, 45, 127, 4, 150, 254, 138, 236, 205, 93,
+		222, 114, 67, 29, 24, 72, 243, 141, 128, 195, 78, 66, 215, 61, 156, 180}
+	var p [512]uint8
+	for i := range p {
+		p[i] = base[i&255]
+	}
+	return p
+}()
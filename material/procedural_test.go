@@ -0,0 +1,124 @@
+package material
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestProceduralNodes(t *testing.T) {
+	var graph interface{}
+	// A ramp from red to blue along u, darkened by v
+	err := json.Unmarshal([]byte(`{"node": "multiply",
+		"a": {"node": "ramp", "input": "u", "stops": [
+			{"at": 0, "color": {"r": 1, "g": 0, "b": 0}},
+			{"at": 1, "color": {"r": 0, "g": 0, "b": 1}}]},
+		"b": {"node": "mix", "a": 1, "b": 0, "factor": "v"}}`), &graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := ParseNode(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := node.Eval(&ShadingPoint{U: 0.25, V: 0.5})
+	if want := (image.Color{R: 0.375, B: 0.125}); math.Abs(c.R-want.R) > 1e-12 || c.G != 0 || math.Abs(c.B-want.B) > 1e-12 {
+		t.Errorf("Expected %v, got %v", want, c)
+	}
+	if c := node.Eval(&ShadingPoint{U: 2}); c != (image.Color{B: 1}) {
+		t.Errorf("A ramp should keep the color of its last stop past it, got %v", c)
+	}
+}
+
+func TestNoiseAndFresnelNodes(t *testing.T) {
+	n, err := ParseNode(map[string]interface{}{"node": "noise", "scale": 3.0, "octaves": 4.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	varies := false
+	first := n.Eval(&ShadingPoint{Point: math3d.Vector3{X: 0.1, Y: 0.2, Z: 0.3}})
+	for i := 0; i < 100; i++ {
+		p := ShadingPoint{Point: math3d.Vector3{X: float64(i) * 0.37, Y: float64(i) * 0.11, Z: 0.3}}
+		c := n.Eval(&p)
+		if c.R < 0 || c.R > 1 || c.R != c.G || c.G != c.B {
+			t.Fatalf("Noise should be a gray in [0, 1], got %v", c)
+		}
+		if again := n.Eval(&p); again != c {
+			t.Fatalf("Noise should be the same at the same point, got %v and %v", c, again)
+		}
+		varies = varies || c != first
+	}
+	if !varies {
+		t.Error("Noise should vary across the points")
+	}
+
+	f, err := ParseNode(map[string]interface{}{"node": "fresnel", "ior": 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	normal := math3d.Vector3{Y: 1}
+	head := f.Eval(&ShadingPoint{Normal: normal, Out: normal})
+	if math.Abs(head.R-0.04) > 1e-9 {
+		t.Errorf("Glass should reflect 4%% of the light head on, got %v", head.R)
+	}
+	grazing := f.Eval(&ShadingPoint{Normal: normal, Out: math3d.Vector3{X: 1, Y: 0.01}.NormalizedV()})
+	if grazing.R < 0.9 {
+		t.Errorf("Glass should reflect most of the light at grazing angles, got %v", grazing.R)
+	}
+}
+
+func TestProceduralAt(t *testing.T) {
+	p := FromMap(map[string]interface{}{"type": "procedural", "color": "x"}).(*Procedural)
+	if mat := p.At(&ShadingPoint{Point: math3d.Vector3{X: 0.5}}); !reflect.DeepEqual(mat, &Lambertian{Albedo: image.Color{R: 0.5, G: 0.5, B: 0.5}}) {
+		t.Errorf("Expected a lambertian material of the color at the point, got %+v", mat)
+	}
+	if mat := p.At(&ShadingPoint{Point: math3d.Vector3{X: -1}}); mat.(*Lambertian).Albedo != (image.Color{}) {
+		t.Errorf("Negative colors should be taken as black, got %+v", mat)
+	}
+	p.Exponent = constant{R: 20, G: 20, B: 20}
+	if mat, ok := p.At(&ShadingPoint{}).(*Glossy); !ok || mat.Exponent != 20 {
+		t.Errorf("Expected a glossy material with the exponent of the node, got %+v", p.At(&ShadingPoint{}))
+	}
+}
+
+func TestProceduralJSON(t *testing.T) {
+	data := []byte(`{"type": "procedural", "exponent": 30,
+		"color": {"node": "mix", "a": {"r": 1, "g": 0.5, "b": 0}, "b": 0.2,
+			"factor": {"node": "ramp", "input": {"node": "noise", "scale": 2, "octaves": 3},
+				"stops": [{"at": 0.4, "color": 0}, {"at": 0.6, "color": 1}]}}}`)
+	mat, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(mat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Unmarshal(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mat, decoded) {
+		t.Errorf("Expected %+v, got %+v", mat, decoded)
+	}
+
+	for _, invalid := range []string{
+		`{"type": "procedural"}`,
+		`{"type": "procedural", "color": "w"}`,
+		`{"type": "procedural", "color": {"node": "blur"}}`,
+		`{"type": "procedural", "color": {"node": "mix", "a": 1, "b": 0}}`,
+		`{"type": "procedural", "color": {"node": "noise", "scale": -1}}`,
+		`{"type": "procedural", "color": {"node": "fresnel", "ior": 0.5}}`,
+		`{"type": "procedural", "color": {"node": "ramp", "input": "u", "stops": [{"at": 1, "color": 0}, {"at": 0, "color": 1}]}}`,
+		`{"type": "procedural", "color": {"node": "ramp", "input": "u", "stops": [{"at": 0, "color": "u"}]}}`,
+		`{"type": "procedural", "color": 1, "albedo": 1}`,
+	} {
+		if _, err := Unmarshal([]byte(invalid)); err == nil {
+			t.Errorf("%s should be rejected", invalid)
+		}
+	}
+}
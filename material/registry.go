@@ -0,0 +1,73 @@
+package material
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// registeredType is a type of material that a program registered
+type registeredType struct {
+	keys    []string
+	fromMap func(map[string]interface{}) Material
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]registeredType)
+)
+
+// builtinTypes are the types of the materials of the package, which can't
+// be registered again
+var builtinTypes = []string{"lambertian", "glossy", "subsurface", "dielectric", "standard", "holdout", "shadowcatcher", "procedural"}
+
+// RegisterMaterial registers a type of material, so that programs that use
+// the package can add materials of their own to the scene file format. The
+// materials of the type in scene files have the keys, "type" among them,
+// and fromMap returns the material of the map of one, panicking if the map
+// isn't valid as FromMap does. The AsMap of the materials must return such
+// a map, with the type in "type", so that they are written back to scene
+// files. Registering is meant for the init functions of programs, and
+// panics if the type is already a type of material.
+func RegisterMaterial(typename string, keys []string, fromMap func(map[string]interface{}) Material) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, registered := registry[typename]; registered || contains(builtinTypes, typename) || typename == "" {
+		panic(fmt.Sprintf("There's already a material type %q", typename))
+	}
+	registry[typename] = registeredType{keys: keys, fromMap: fromMap}
+}
+
+// MaterialKeys returns the keys of the materials of a type registered by
+// RegisterMaterial, and whether there's one
+func MaterialKeys(typename string) ([]string, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	t, ok := registry[typename]
+	return t.keys, ok
+}
+
+// lookup returns the type of material registered with the name, and
+// whether there's one
+func lookup(typename interface{}) (registeredType, bool) {
+	name, _ := typename.(string)
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	t, ok := registry[name]
+	return t, ok
+}
+
+// unmarshalRegistered returns the material of a registered type in the
+// JSON object, with an error instead of the panics of its fromMap
+func unmarshalRegistered(t registeredType, data []byte) (mat Material, err error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("not an object")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			mat, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	return t.fromMap(m), nil
+}
@@ -0,0 +1,371 @@
+package material
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// minAlpha is the width of the sharpest microfacet distribution, so that
+// smooth standard materials still have a lobe that can be sampled
+const minAlpha = 1e-3
+
+// filmWavelengths are the wavelengths in nanometers whose interference
+// gives the red, green and blue of thin films
+var filmWavelengths = [3]float64{650, 510, 440}
+
+// Standard is a physically based material with the parameters of the
+// Disney and Standard Surface models: a diffuse base under a specular
+// layer of microfacets, which is a dielectric or a metal depending on its
+// metalness, optionally under a clear coat, such as car paint or
+// varnished wood, and with a thin film on the base, such as the ones of
+// soap bubbles or heated metals. The microfacets follow the GGX
+// distribution with the Smith shadowing.
+type Standard struct {
+	// Albedo is the color of the diffuse base, or the reflectance of the
+	// metal straight on
+	Albedo image.Color `json:"albedo"`
+	// Metalness is how much of a metal the base is, from 0 to 1
+	Metalness float64 `json:"metalness,omitempty"`
+	// Roughness is how rough the specular layer is, from 0, a mirror, to
+	// 1. The width of the distribution is its square.
+	Roughness float64 `json:"roughness"`
+	// IOR is the index of refraction of the specular layer when it isn't
+	// a metal
+	IOR float64 `json:"ior"`
+	// Coat is how much of the base is under the clear coat, from 0 to 1,
+	// CoatRoughness how rough the coat is and CoatIOR its index of
+	// refraction
+	Coat          float64 `json:"coat,omitempty"`
+	CoatRoughness float64 `json:"coatroughness,omitempty"`
+	CoatIOR       float64 `json:"coatior"`
+	// ThinFilmThickness is the thickness in nanometers of the film on the
+	// base, none if it's 0, and ThinFilmIOR its index of refraction
+	ThinFilmThickness float64 `json:"thinfilmthickness,omitempty"`
+	ThinFilmIOR       float64 `json:"thinfilmior"`
+}
+
+// alpha returns the width of the GGX distribution of the roughness
+func alpha(roughness float64) float64 {
+	return math.Max(roughness*roughness, minAlpha)
+}
+
+// ggx returns the density of the microfacets whose normals have the cosine
+// with the normal of the surface
+func ggx(cosine, a float64) float64 {
+	if cosine <= 0 {
+		return 0
+	}
+	d := cosine*cosine*(a*a-1) + 1
+	return a * a / (math.Pi * d * d)
+}
+
+// smith returns the fraction of the microfacets seen from a direction with
+// the cosine with the normal that aren't hidden by others
+func smith(cosine, a float64) float64 {
+	return 2 * cosine / (cosine + math.Sqrt(a*a+(1-a*a)*cosine*cosine))
+}
+
+// sampleGGX returns a microfacet normal around the normal, with a density
+// of ggx times its cosine with the normal
+func sampleGGX(normal *math3d.Vector3, a, u, v float64) math3d.Vector3 {
+	cosine := math.Sqrt((1 - u) / (1 + (a*a-1)*u))
+	sine := math.Sqrt(math.Max(0, 1-cosine*cosine))
+	phi := 2 * math.Pi * v
+	tangent, bitangent := math3d.OrthonormalBasis(*normal)
+	return normal.MultiplyV(cosine).AddV(tangent.MultiplyV(sine * math.Cos(phi))).AddV(bitangent.MultiplyV(sine * math.Sin(phi)))
+}
+
+// ggxPdf returns the density with respect to solid angle of the direction
+// in reflected towards out off the microfacet with the normal h
+func ggxPdf(normal, h, out *math3d.Vector3, a float64) float64 {
+	cosine := h.DotV(*normal)
+	oh := math.Abs(out.DotV(*h))
+	if cosine <= 0 || oh == 0 {
+		return 0
+	}
+	return ggx(cosine, a) * cosine / (4 * oh)
+}
+
+// dielectricReflectance returns the fraction of unpolarized light with the
+// cosine with the normal reflected by a material with the index of
+// refraction, seen from the air
+func dielectricReflectance(cosine, eta float64) float64 {
+	sinIn2 := (1 - cosine*cosine) / (eta * eta)
+	if sinIn2 >= 1 {
+		return 1
+	}
+	return fresnel(cosine, math.Sqrt(1-sinIn2), eta)
+}
+
+// filmReflectance returns the fraction of unpolarized light of the
+// wavelength in nanometers with the cosine with the normal reflected by a
+// film of the thickness in nanometers and the index of refraction over a
+// material with the index eta, seen from the air. The light reflected by
+// both sides of the film interferes following Airy's formula.
+func filmReflectance(cosine, thickness, filmIOR, eta, wavelength float64) float64 {
+	sin2 := 1 - cosine*cosine
+	cosFilm := math.Sqrt(1 - sin2/(filmIOR*filmIOR))
+	cosBase := math.Sqrt(math.Max(0, 1-sin2/(eta*eta)))
+	phase := 4 * math.Pi * filmIOR * thickness * cosFilm / wavelength
+	airy := func(r12, r23 float64) float64 {
+		c := 2 * r12 * r23 * math.Cos(phase)
+		return (r12*r12 + r23*r23 + c) / (1 + r12*r12*r23*r23 + c)
+	}
+	perpendicular := airy((cosine-filmIOR*cosFilm)/(cosine+filmIOR*cosFilm),
+		(filmIOR*cosFilm-eta*cosBase)/(filmIOR*cosFilm+eta*cosBase))
+	parallel := airy((filmIOR*cosine-cosFilm)/(filmIOR*cosine+cosFilm),
+		(eta*cosFilm-filmIOR*cosBase)/(eta*cosFilm+filmIOR*cosBase))
+	return (perpendicular + parallel) / 2
+}
+
+// specularReflectance returns the color of the light with the cosine with
+// a microfacet that the specular layer reflects. Metals are dielectrics
+// whose index of refraction reflects their albedo straight on, for every
+// channel.
+func (s *Standard) specularReflectance(cosine float64) image.Color {
+	f := (s.IOR - 1) / (s.IOR + 1)
+	dielectric := f * f
+	channels := [3]float64{s.Albedo.R, s.Albedo.G, s.Albedo.B}
+	for i, c := range channels {
+		f0 := math.Min(dielectric+(math.Max(c, 0)-dielectric)*s.Metalness, 0.999)
+		eta := (1 + math.Sqrt(f0)) / (1 - math.Sqrt(f0))
+		if s.ThinFilmThickness > 0 {
+			channels[i] = filmReflectance(cosine, s.ThinFilmThickness, s.ThinFilmIOR, eta, filmWavelengths[i])
+		} else {
+			channels[i] = dielectricReflectance(cosine, eta)
+		}
+	}
+	return image.Color{R: channels[0], G: channels[1], B: channels[2]}
+}
+
+// coatTransmittance returns the fraction of the light with the cosine with
+// the normal that goes through the coat
+func (s *Standard) coatTransmittance(cosine float64) float64 {
+	return 1 - s.Coat*dielectricReflectance(cosine, s.CoatIOR)
+}
+
+// BRDF returns the sum of the diffuse base, its specular layer and the
+// coat, each attenuated by the layers above it
+func (s *Standard) BRDF(normal, in, out *math3d.Vector3) image.Color {
+	cosIn, cosOut := in.DotV(*normal), out.DotV(*normal)
+	if cosIn <= 0 || cosOut <= 0 {
+		return image.Black
+	}
+	h := in.AddV(*out).NormalizedV()
+	cosH, cosInH := h.DotV(*normal), in.DotV(h)
+	a := alpha(s.Roughness)
+	f := s.specularReflectance(cosInH)
+	specular := f.Multiply(ggx(cosH, a) * smith(cosIn, a) * smith(cosOut, a) / (4 * cosIn * cosOut))
+	// The diffuse base is lit by the light the dielectric part of the
+	// specular layer lets through on the way in and out
+	ior := s.IOR
+	diffuse := s.Albedo.Multiply((1 - s.Metalness) / math.Pi *
+		(1 - dielectricReflectance(cosIn, ior)) * (1 - dielectricReflectance(cosOut, ior)))
+	base := specular.Add(diffuse)
+	if s.Coat == 0 {
+		return *base
+	}
+	base = base.Multiply(s.coatTransmittance(cosIn) * s.coatTransmittance(cosOut))
+	ac := alpha(s.CoatRoughness)
+	coat := s.Coat * dielectricReflectance(cosInH, s.CoatIOR) *
+		ggx(cosH, ac) * smith(cosIn, ac) * smith(cosOut, ac) / (4 * cosIn * cosOut)
+	return *base.Add(&image.Color{R: coat, G: coat, B: coat})
+}
+
+// lobeWeights returns the probabilities of sampling the diffuse base, the
+// specular layer and the coat towards out, roughly as much as they reflect
+func (s *Standard) lobeWeights(cosOut float64) (diffuse, specular, coat float64) {
+	f := s.specularReflectance(cosOut)
+	transmittance := s.coatTransmittance(cosOut)
+	diffuse = (1 - s.Metalness) * math.Max(s.Albedo.Luminance(), 0) * (1 - dielectricReflectance(cosOut, s.IOR)) * transmittance
+	specular = f.Luminance() * transmittance
+	coat = s.Coat * dielectricReflectance(cosOut, s.CoatIOR)
+	total := diffuse + specular + coat
+	if !(total > 0) {
+		return 1, 0, 0
+	}
+	return diffuse / total, specular / total, coat / total
+}
+
+// SampleDirection returns a direction of the diffuse base, the specular
+// layer or the coat, picked roughly as much as they reflect. The
+// directions of the specular layer and the coat may point below the
+// surface, where the BRDF is black.
+func (s *Standard) SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	cosOut := out.DotV(*normal)
+	if cosOut <= 0 {
+		return math3d.Vector3{}, 0
+	}
+	diffuse, specular, _ := s.lobeWeights(cosOut)
+	var in math3d.Vector3
+	switch {
+	case u < diffuse:
+		lambertian := Lambertian{}
+		in, _ = lambertian.SampleDirection(normal, out, u/diffuse, v)
+	case u < diffuse+specular:
+		h := sampleGGX(normal, alpha(s.Roughness), (u-diffuse)/specular, v)
+		in = out.MultiplyV(-1).ReflectV(h)
+	default:
+		h := sampleGGX(normal, alpha(s.CoatRoughness), math.Min((u-diffuse-specular)/(1-diffuse-specular), 1), v)
+		in = out.MultiplyV(-1).ReflectV(h)
+	}
+	return in, s.DirectionPdf(normal, &in, out)
+}
+
+// DirectionPdf returns the density of the mixture of the lobes of
+// SampleDirection in the direction in
+func (s *Standard) DirectionPdf(normal, in, out *math3d.Vector3) float64 {
+	cosOut := out.DotV(*normal)
+	if cosOut <= 0 {
+		return 0
+	}
+	diffuse, specular, coat := s.lobeWeights(cosOut)
+	h := in.AddV(*out)
+	if h.AbsSquared() == 0 {
+		return 0
+	}
+	h = h.NormalizedV()
+	pdf := diffuse * math.Max(0, in.DotV(*normal)) / math.Pi
+	if specular > 0 {
+		pdf += specular * ggxPdf(normal, &h, out, alpha(s.Roughness))
+	}
+	if coat > 0 {
+		pdf += coat * ggxPdf(normal, &h, out, alpha(s.CoatRoughness))
+	}
+	return pdf
+}
+
+// AsMap returns a map representation of this material
+func (s *Standard) AsMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"type":      "standard",
+		"albedo":    colorAsMap(&s.Albedo),
+		"roughness": s.Roughness,
+		"ior":       s.IOR,
+	}
+	if s.Metalness != 0 {
+		m["metalness"] = s.Metalness
+	}
+	if s.Coat != 0 {
+		m["coat"] = s.Coat
+		m["coatroughness"] = s.CoatRoughness
+		m["coatior"] = s.CoatIOR
+	}
+	if s.ThinFilmThickness != 0 {
+		m["thinfilmthickness"] = s.ThinFilmThickness
+		m["thinfilmior"] = s.ThinFilmIOR
+	}
+	return m
+}
+
+// validate returns an error if the parameters of the material are out of
+// range
+func (s *Standard) validate() error {
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"metalness", s.Metalness}, {"roughness", s.Roughness}, {"coat", s.Coat}, {"coat roughness", s.CoatRoughness}} {
+		if !(p.value >= 0 && p.value <= 1) {
+			return fmt.Errorf("the %s must be between 0 and 1", p.name)
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"index of refraction", s.IOR}, {"coat index of refraction", s.CoatIOR}, {"thin film index of refraction", s.ThinFilmIOR}} {
+		if !(p.value >= 1) || math.IsInf(p.value, 1) {
+			return fmt.Errorf("the %s must be finite and at least 1", p.name)
+		}
+	}
+	if !(s.ThinFilmThickness >= 0) || math.IsInf(s.ThinFilmThickness, 1) {
+		return fmt.Errorf("the thin film thickness must be finite and non negative")
+	}
+	if !s.Albedo.NonNegative() {
+		return fmt.Errorf("the albedo can't be negative")
+	}
+	return nil
+}
+
+// defaultStandard is the standard material of the parameters that are
+// missing: a white, smooth dielectric without a coat or a film
+var defaultStandard = Standard{Albedo: image.White, IOR: 1.5, CoatIOR: 1.5, ThinFilmIOR: 1.5}
+
+// StandardFromMap returns the standard material defined in the map. The
+// indices of refraction are 1.5 if they're missing.
+func StandardFromMap(m map[string]interface{}) *Standard {
+	s := defaultStandard
+	s.Albedo = colorFromMap(m["albedo"])
+	for key, value := range map[string]*float64{
+		"metalness":         &s.Metalness,
+		"roughness":         &s.Roughness,
+		"ior":               &s.IOR,
+		"coat":              &s.Coat,
+		"coatroughness":     &s.CoatRoughness,
+		"coatior":           &s.CoatIOR,
+		"thinfilmthickness": &s.ThinFilmThickness,
+		"thinfilmior":       &s.ThinFilmIOR,
+	} {
+		if v, ok := m[key].(float64); ok {
+			*value = v
+		}
+	}
+	if err := s.validate(); err != nil {
+		panic("Invalid standard material: " + err.Error())
+	}
+	return &s
+}
+
+// jsonStandard is a Standard without its JSON methods, to encode it
+type jsonStandard Standard
+
+// MarshalJSON returns the material as an object with its type and
+// parameters, leaving out the coat and the film if it has none
+func (s *Standard) MarshalJSON() ([]byte, error) {
+	err := jsonutil.Finite(s.Metalness, s.Roughness, s.IOR, s.Coat, s.CoatRoughness, s.CoatIOR, s.ThinFilmThickness, s.ThinFilmIOR)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonStandard
+	}{"standard", jsonStandard(*s)})
+}
+
+// UnmarshalJSON sets the material from an object with the type "standard"
+// and its parameters. The albedo is white and the indices of refraction
+// are 1.5 if they're missing, and the rest of the parameters are 0. It
+// fails if the metalness, the roughnesses or the coat aren't between 0 and
+// 1, the indices of refraction are less than 1, or the thickness of the
+// film or the albedo are negative.
+func (s *Standard) UnmarshalJSON(data []byte) error {
+	decoded := defaultStandard
+	var typename string
+	err := jsonutil.Object(data, map[string]interface{}{
+		"type":              &typename,
+		"albedo":            &decoded.Albedo,
+		"metalness":         &decoded.Metalness,
+		"roughness":         &decoded.Roughness,
+		"ior":               &decoded.IOR,
+		"coat":              &decoded.Coat,
+		"coatroughness":     &decoded.CoatRoughness,
+		"coatior":           &decoded.CoatIOR,
+		"thinfilmthickness": &decoded.ThinFilmThickness,
+		"thinfilmior":       &decoded.ThinFilmIOR,
+	}, "type")
+	if err != nil {
+		return err
+	}
+	if typename != "standard" {
+		return fmt.Errorf("not a standard material")
+	}
+	if err := decoded.validate(); err != nil {
+		return err
+	}
+	*s = decoded
+	return nil
+}
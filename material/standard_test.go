@@ -0,0 +1,105 @@
+package material
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestStandardConservesEnergy(t *testing.T) {
+	normal := math3d.Vector3{X: 0.1, Y: 1, Z: -0.2}.NormalizedV()
+	r := rand.New(rand.NewSource(5))
+	for _, s := range []*Standard{
+		{Albedo: image.White, Roughness: 0.5, IOR: 1.5, CoatIOR: 1.5, ThinFilmIOR: 1.5},
+		{Albedo: image.White, Metalness: 1, Roughness: 0.2, IOR: 1.5, CoatIOR: 1.5, ThinFilmIOR: 1.5},
+		{Albedo: image.White, Roughness: 0.8, IOR: 1.5, Coat: 1, CoatRoughness: 0.05, CoatIOR: 1.5, ThinFilmIOR: 1.5},
+		{Albedo: image.White, Metalness: 1, Roughness: 0.3, IOR: 1.5, CoatIOR: 1.5, ThinFilmThickness: 400, ThinFilmIOR: 1.33},
+	} {
+		for _, elevation := range []float64{0.1, 0.7, 1.4} {
+			out := normal.MultiplyV(math.Sin(elevation)).AddV(math3d.Vector3{X: math.Cos(elevation)})
+			out = out.NormalizedV()
+			var reflected image.Color
+			const samples = 100000
+			for i := 0; i < samples; i++ {
+				in, pdf := s.SampleDirection(&normal, &out, r.Float64(), r.Float64())
+				if pdf <= 0 {
+					continue
+				}
+				if other := s.DirectionPdf(&normal, &in, &out); math.Abs(other-pdf) > 1e-9*pdf {
+					t.Fatalf("%+v: DirectionPdf returned %f for a direction sampled with a density of %f", s, other, pdf)
+				}
+				brdf := s.BRDF(&normal, &in, &out)
+				reflected = *reflected.Add(brdf.Multiply(math.Max(0, in.DotV(normal)) / pdf / samples))
+			}
+			for _, c := range []float64{reflected.R, reflected.G, reflected.B} {
+				if c > 1.02 || c < 0.3 {
+					t.Errorf("%+v: a white material should reflect most but not more of the light, it reflects %v at %f", s, reflected, elevation)
+				}
+			}
+		}
+	}
+}
+
+func TestStandardCoatAddsSharpReflection(t *testing.T) {
+	normal := math3d.Vector3{Y: 1}
+	out := math3d.Vector3{X: 1, Y: 1}.NormalizedV()
+	in := math3d.Vector3{X: -1, Y: 1}.NormalizedV()
+	rough := &Standard{Albedo: image.Color{R: 0.5}, Roughness: 0.9, IOR: 1.5, CoatIOR: 1.5, ThinFilmIOR: 1.5}
+	coated := *rough
+	coated.Coat = 1
+	plain, shiny := rough.BRDF(&normal, &in, &out), coated.BRDF(&normal, &in, &out)
+	if shiny.G < 10*plain.G+1 {
+		t.Errorf("A smooth coat should reflect the mirror direction much more, %v rather than %v", shiny, plain)
+	}
+	side := math3d.Vector3{Y: 1, Z: 1}.NormalizedV()
+	plain, shiny = rough.BRDF(&normal, &side, &out), coated.BRDF(&normal, &side, &out)
+	if !(shiny.R < plain.R) {
+		t.Errorf("The coat should dim the base away from its reflection, %v rather than %v", shiny, plain)
+	}
+}
+
+func TestThinFilmInterference(t *testing.T) {
+	// Without a film, or with one as thin as nothing, a dielectric reflects
+	// the same in every channel
+	s := &Standard{Albedo: image.White, IOR: 1.5, CoatIOR: 1.5, ThinFilmIOR: 1.5}
+	f := s.specularReflectance(1)
+	if math.Abs(f.R-0.04) > 1e-9 || f.R != f.G || f.G != f.B {
+		t.Errorf("A dielectric with an index of 1.5 should reflect 4%% of every color straight on, not %v", f)
+	}
+	s.ThinFilmThickness, s.ThinFilmIOR = 1e-9, 1.33
+	if thin := s.specularReflectance(1); math.Abs(thin.R-f.R) > 1e-6 || math.Abs(thin.B-f.B) > 1e-6 {
+		t.Errorf("A vanishing film should reflect as the base does, %v rather than %v", thin, f)
+	}
+	// A quarter wave film of an index between the air and the base is
+	// antireflective, for the wavelength of the green channel
+	s.ThinFilmIOR = math.Sqrt(1.5)
+	s.ThinFilmThickness = filmWavelengths[1] / (4 * s.ThinFilmIOR)
+	coated := s.specularReflectance(1)
+	if coated.G > 1e-9 || coated.R <= coated.G || coated.B <= coated.G {
+		t.Errorf("A quarter wave film should only cancel the reflection of green, it reflects %v", coated)
+	}
+	// Colors shift with the angle
+	grazing := s.specularReflectance(0.5)
+	if grazing.G <= coated.G {
+		t.Errorf("The reflection of a film should change color with the angle, %v rather than %v", grazing, coated)
+	}
+}
+
+func TestStandardFromMap(t *testing.T) {
+	s := FromMap(map[string]interface{}{"type": "standard", "roughness": 0.4, "coat": 0.5}).(*Standard)
+	if s.Albedo != image.White || s.Roughness != 0.4 || s.Coat != 0.5 || s.IOR != 1.5 || s.CoatIOR != 1.5 {
+		t.Errorf("Unexpected material %+v", s)
+	}
+	if back := StandardFromMap(s.AsMap()); *back != *s {
+		t.Errorf("The map of %+v should give it back, not %+v", s, back)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("A metalness over 1 should be rejected")
+		}
+	}()
+	StandardFromMap(map[string]interface{}{"metalness": 1.5})
+}
@@ -0,0 +1,138 @@
+package material
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/jsonutil"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Subsurface defines a translucent material, such as marble, skin or wax.
+// Light enters its surface, scatters below it and leaves it at nearby
+// points. How far it travels follows the normalized diffusion profile by
+// Christensen and Burley.
+type Subsurface struct {
+	// Albedo is the fraction of the light that leaves the surface again
+	Albedo image.Color `json:"albedo"`
+	// MeanFreePath is how far light travels below the surface before it
+	// leaves, per color channel and in scene units. It must be positive.
+	MeanFreePath image.Color `json:"meanfreepath"`
+}
+
+// BRDF returns black, as all the light that reaches the surface goes below
+// it. The light leaving the surface is given by Diffusion instead.
+func (s *Subsurface) BRDF(normal, in, out *math3d.Vector3) image.Color {
+	return image.Black
+}
+
+// SampleDirection returns a density of 0, as the surface doesn't reflect
+// any light
+func (s *Subsurface) SampleDirection(normal, out *math3d.Vector3, u, v float64) (math3d.Vector3, float64) {
+	return math3d.Vector3{}, 0
+}
+
+// DirectionPdf returns 0, as SampleDirection never returns a direction
+func (s *Subsurface) DirectionPdf(normal, in, out *math3d.Vector3) float64 {
+	return 0
+}
+
+// Diffusion returns the fraction of the light entering the surface at a
+// point that leaves it at distance r of that point, per unit of distance.
+// Integrating it over every distance gives the albedo.
+func (s *Subsurface) Diffusion(r float64) image.Color {
+	return image.Color{
+		R: s.Albedo.R * radialProfile(r, s.MeanFreePath.R),
+		G: s.Albedo.G * radialProfile(r, s.MeanFreePath.G),
+		B: s.Albedo.B * radialProfile(r, s.MeanFreePath.B)}
+}
+
+// SampleRadius returns a distance distributed as the diffusion profile of
+// a random color channel, given two uniform numbers in [0, 1). Pdf returns
+// the probability density of the distances it returns.
+func (s *Subsurface) SampleRadius(u, v float64) float64 {
+	d := s.MeanFreePath.B
+	if u < 1.0/3 {
+		d = s.MeanFreePath.R
+	} else if u < 2.0/3 {
+		d = s.MeanFreePath.G
+	}
+	// The profile is a mix of two exponentials, one weighing 1/4 and the
+	// other one, three times as long, weighing 3/4
+	if v < 0.25 {
+		return -d * math.Log(1-v*4)
+	}
+	return -3 * d * math.Log(1-(v-0.25)/0.75)
+}
+
+// Pdf returns the probability density of SampleRadius returning r
+func (s *Subsurface) Pdf(r float64) float64 {
+	return (radialProfile(r, s.MeanFreePath.R) +
+		radialProfile(r, s.MeanFreePath.G) +
+		radialProfile(r, s.MeanFreePath.B)) / 3
+}
+
+// AsMap returns a map representation of this material
+func (s *Subsurface) AsMap() map[string]interface{} {
+	return map[string]interface{}{
+		"type":         "subsurface",
+		"albedo":       colorAsMap(&s.Albedo),
+		"meanfreepath": colorAsMap(&s.MeanFreePath)}
+}
+
+// SubsurfaceFromMap returns the subsurface material defined in the map
+func SubsurfaceFromMap(m map[string]interface{}) *Subsurface {
+	s := &Subsurface{Albedo: colorFromMap(m["albedo"]), MeanFreePath: colorFromMap(m["meanfreepath"])}
+	if s.MeanFreePath.R <= 0 || s.MeanFreePath.G <= 0 || s.MeanFreePath.B <= 0 {
+		panic("The mean free path of a subsurface material must be positive")
+	}
+	return s
+}
+
+// jsonSubsurface is a Subsurface without its JSON methods, to encode it
+type jsonSubsurface Subsurface
+
+// MarshalJSON returns the material as an object with its type, albedo and
+// mean free path
+func (s *Subsurface) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		jsonSubsurface
+	}{"subsurface", jsonSubsurface(*s)})
+}
+
+// UnmarshalJSON sets the material from an object with the type
+// "subsurface", its albedo and its mean free path, white if they're
+// missing. It fails if the albedo is negative or the mean free path isn't
+// positive.
+func (s *Subsurface) UnmarshalJSON(data []byte) error {
+	decoded := Subsurface{Albedo: image.White, MeanFreePath: image.White}
+	var typename string
+	err := jsonutil.Object(data, map[string]interface{}{
+		"type":         &typename,
+		"albedo":       &decoded.Albedo,
+		"meanfreepath": &decoded.MeanFreePath,
+	}, "type")
+	if err != nil {
+		return err
+	}
+	if typename != "subsurface" {
+		return fmt.Errorf("not a subsurface material")
+	}
+	if !decoded.Albedo.NonNegative() {
+		return fmt.Errorf("the albedo can't be negative")
+	}
+	if !(decoded.MeanFreePath.R > 0 && decoded.MeanFreePath.G > 0 && decoded.MeanFreePath.B > 0) {
+		return fmt.Errorf("the mean free path must be positive")
+	}
+	*s = decoded
+	return nil
+}
+
+// radialProfile returns the normalized diffusion profile, multiplied by
+// the circumference of radius r so it integrates to 1 over r
+func radialProfile(r, d float64) float64 {
+	return (math.Exp(-r/d) + math.Exp(-r/(3*d))) / (4 * d)
+}
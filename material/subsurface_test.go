@@ -0,0 +1,46 @@
+package material
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+var wax = &Subsurface{
+	Albedo:       image.Color{R: 0.9, G: 0.8, B: 0.6},
+	MeanFreePath: image.Color{R: 0.3, G: 0.1, B: 0.05}}
+
+func TestDiffusionIntegratesToAlbedo(t *testing.T) {
+	var total image.Color
+	step := 1e-4
+	for r := step / 2; r < 10; r += step {
+		diffusion := wax.Diffusion(r)
+		total = *total.Add(diffusion.Multiply(step))
+	}
+	if math.Abs(total.R-wax.Albedo.R) > 1e-3 || math.Abs(total.G-wax.Albedo.G) > 1e-3 || math.Abs(total.B-wax.Albedo.B) > 1e-3 {
+		t.Errorf("The diffusion profile should integrate to the albedo, got %s", total.String())
+	}
+}
+
+func TestSampleRadiusFollowsPdf(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const n = 200000
+	mean := 0.0
+	for i := 0; i < n; i++ {
+		mean += wax.SampleRadius(rng.Float64(), rng.Float64()) / n
+	}
+	// Each channel's profile has a mean of 1/4*d + 3/4*3d
+	expected := 2.5 * (wax.MeanFreePath.R + wax.MeanFreePath.G + wax.MeanFreePath.B) / 3
+	if math.Abs(mean-expected) > 0.01*expected {
+		t.Errorf("The mean sampled radius should be %.4f, it is %.4f", expected, mean)
+	}
+	integral, step := 0.0, 1e-4
+	for r := step / 2; r < 10; r += step {
+		integral += wax.Pdf(r) * step
+	}
+	if math.Abs(integral-1) > 1e-3 {
+		t.Errorf("The pdf should integrate to 1, not %.4f", integral)
+	}
+}
@@ -0,0 +1,281 @@
+package material
+
+import (
+	"fmt"
+	stdimg "image"
+	"image/color"
+	"math"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+)
+
+// The filters of image textures, from the sharpest and most aliased to the
+// smoothest
+const (
+	// Nearest takes the texel nearest to the texture coordinates
+	Nearest = "nearest"
+	// Bilinear interpolates the 4 texels around the texture coordinates
+	Bilinear = "bilinear"
+	// Trilinear interpolates bilinearly the 2 levels of the mipmap whose
+	// texels are as big as the footprint of the pixel, so distant
+	// textures don't shimmer
+	Trilinear = "trilinear"
+	// Anisotropic averages trilinear lookups along the footprint of the
+	// pixel, in the levels whose texels are as big as its width, so the
+	// textures seen at grazing angles are sharper than with Trilinear
+	Anisotropic = "anisotropic"
+)
+
+// maxAnisotropy is how many times longer than wide a footprint can be
+// averaged along by Anisotropic filtering. Longer ones are taken as wider.
+const maxAnisotropy = 8
+
+// Texture is an image mapped over texture space, with its mipmap: the
+// image and smaller and smaller versions of it, each half the size of the
+// one before down to a single texel, which filtering picks from to fit the
+// footprints of the pixels
+type Texture struct {
+	// Image is the path of the image
+	Image  string
+	levels []mipLevel
+	// gaussian is the texture gaussianized for hex tiling, once a node
+	// tiles it
+	gaussian     *gaussianized
+	gaussianOnce sync.Once
+}
+
+// mipLevel is a level of the mipmap of a texture, with the linear colors
+// of its texels row after row from the top
+type mipLevel struct {
+	width, height int
+	texels        []image.Color
+}
+
+// textures are the textures loaded, by the paths of their images, so the
+// nodes of the same image share its mipmap
+var textures = struct {
+	sync.Mutex
+	byPath map[string]*Texture
+}{byPath: make(map[string]*Texture)}
+
+// NewTexture returns the texture of the image, whose colors are in sRGB,
+// with its mipmap
+func NewTexture(img stdimg.Image) *Texture {
+	bounds := img.Bounds()
+	base := mipLevel{width: bounds.Dx(), height: bounds.Dy()}
+	base.texels = make([]image.Color, 0, base.width*base.height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			srgb := image.Color{R: float64(c.R) / 0xffff, G: float64(c.G) / 0xffff, B: float64(c.B) / 0xffff}
+			base.texels = append(base.texels, *image.FromSRGB(&srgb))
+		}
+	}
+	t := &Texture{levels: []mipLevel{base}}
+	for last := base; last.width > 1 || last.height > 1; {
+		last = last.halved()
+		t.levels = append(t.levels, last)
+	}
+	return t
+}
+
+// LoadTexture returns the texture of the image file, reading it the first
+// time. See NewTexture.
+func LoadTexture(path string) (*Texture, error) {
+	textures.Lock()
+	defer textures.Unlock()
+	if t, ok := textures.byPath[path]; ok {
+		return t, nil
+	}
+	file, err := assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := stdimg.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode the texture %s: %v", path, err)
+	}
+	t := NewTexture(img)
+	t.Image = path
+	textures.byPath[path] = t
+	return t, nil
+}
+
+// halved returns the next level of the mipmap, half as wide and as high,
+// but at least a texel, whose texels average the ones they cover. The last
+// row or column of odd sizes is averaged into the one before.
+func (l mipLevel) halved() mipLevel {
+	next := mipLevel{width: maxInt(l.width/2, 1), height: maxInt(l.height/2, 1)}
+	next.texels = make([]image.Color, next.width*next.height)
+	for y := 0; y < l.height; y++ {
+		for x := 0; x < l.width; x++ {
+			i := minInt(y/2, next.height-1)*next.width + minInt(x/2, next.width-1)
+			next.texels[i] = *next.texels[i].Add(&l.texels[y*l.width+x])
+		}
+	}
+	counts := make([]float64, len(next.texels))
+	for y := 0; y < l.height; y++ {
+		for x := 0; x < l.width; x++ {
+			counts[minInt(y/2, next.height-1)*next.width+minInt(x/2, next.width-1)]++
+		}
+	}
+	for i := range next.texels {
+		next.texels[i] = *next.texels[i].Multiply(1 / counts[i])
+	}
+	return next
+}
+
+// texel returns the texel x, y of the level, which repeats outside it
+func (l *mipLevel) texel(x, y int) *image.Color {
+	x, y = x%l.width, y%l.height
+	if x < 0 {
+		x += l.width
+	}
+	if y < 0 {
+		y += l.height
+	}
+	return &l.texels[y*l.width+x]
+}
+
+// nearest returns the texel of the level nearest to the texture
+// coordinates. Like the images the renders are saved to, v grows upwards
+// from the last row.
+func (l *mipLevel) nearest(u, v float64) image.Color {
+	return *l.texel(int(math.Floor(u*float64(l.width))), int(math.Floor((1-v)*float64(l.height))))
+}
+
+// bilinear returns the texels of the level around the texture coordinates
+// interpolated
+func (l *mipLevel) bilinear(u, v float64) image.Color {
+	x, y := u*float64(l.width)-0.5, (1-v)*float64(l.height)-0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+	var c image.Color
+	for _, corner := range [4]struct {
+		dx, dy int
+		weight float64
+	}{{0, 0, (1 - fx) * (1 - fy)}, {1, 0, fx * (1 - fy)}, {0, 1, (1 - fx) * fy}, {1, 1, fx * fy}} {
+		c = *c.Add(l.texel(ix+corner.dx, iy+corner.dy).Multiply(corner.weight))
+	}
+	return c
+}
+
+// trilinear returns the texels around the texture coordinates
+// interpolated in the levels whose texels are about width texels of the
+// image wide
+func (t *Texture) trilinear(u, v, width float64) image.Color {
+	lod := math.Log2(math.Max(width, 1))
+	if lod >= float64(len(t.levels)-1) {
+		return t.levels[len(t.levels)-1].bilinear(u, v)
+	}
+	level := int(lod)
+	f := lod - float64(level)
+	a, b := t.levels[level].bilinear(u, v), t.levels[level+1].bilinear(u, v)
+	return *a.Multiply(1 - f).Add(b.Multiply(f))
+}
+
+// Lookup returns the color of the texture at the texture coordinates u, v,
+// which repeat outside the unit square, with the filter, for a pixel whose
+// footprint spans du and dv from the pixel to the one right of it, and
+// du2 and dv2 to the one below. The footprint doesn't matter to Nearest
+// and Bilinear filtering, and a footprint of 0 takes the image itself.
+func (t *Texture) Lookup(u, v, du, dv, du2, dv2 float64, filter string) image.Color {
+	switch filter {
+	case Nearest:
+		return t.levels[0].nearest(u, v)
+	case Bilinear:
+		return t.levels[0].bilinear(u, v)
+	}
+	// The axes of the footprint, in texels of the image
+	w, h := float64(t.levels[0].width), float64(t.levels[0].height)
+	x := math.Hypot(du*w, dv*h)
+	y := math.Hypot(du2*w, dv2*h)
+	if filter != Anisotropic {
+		return t.trilinear(u, v, math.Max(x, y))
+	}
+	major, minor := x, y
+	majorU, majorV := du, dv
+	if y > x {
+		major, minor = y, x
+		majorU, majorV = du2, dv2
+	}
+	minor = math.Max(minor, major/maxAnisotropy)
+	if major <= 1 {
+		return t.trilinear(u, v, major)
+	}
+	// Lookups spread along the major axis as far apart as the minor one is
+	// wide
+	n := int(math.Ceil(major / minor))
+	var c image.Color
+	for i := 0; i < n; i++ {
+		offset := (float64(i)+0.5)/float64(n) - 0.5
+		sample := t.trilinear(u+majorU*offset, v+majorV*offset, minor)
+		c = *c.Add(&sample)
+	}
+	return *c.Multiply(1 / float64(n))
+}
+
+// textureNode is a node of an image texture at the texture coordinates of
+// the shading point
+type textureNode struct {
+	texture *Texture
+	filter  string
+}
+
+func (n textureNode) Eval(p *ShadingPoint) image.Color {
+	return n.texture.Lookup(p.U, p.V, p.DUDX, p.DVDX, p.DUDY, p.DVDY, n.filter)
+}
+
+func (n textureNode) value() interface{} {
+	m := map[string]interface{}{"node": "image", "file": n.texture.Image}
+	if n.filter != Trilinear {
+		m["filter"] = n.filter
+	}
+	return m
+}
+
+// parseTexture returns the node of the image texture of the object of an
+// image or a hextile node
+func parseTexture(m map[string]interface{}) (Node, error) {
+	if err := keys(m, []string{"node", "file", "filter"}, "file"); err != nil {
+		return nil, err
+	}
+	path, ok := m["file"].(string)
+	if !ok {
+		return nil, fmt.Errorf("file: must be a string")
+	}
+	n := textureNode{filter: Trilinear}
+	if filter, present := m["filter"]; present {
+		n.filter, _ = filter.(string)
+		if !contains([]string{Nearest, Bilinear, Trilinear, Anisotropic}, n.filter) {
+			return nil, fmt.Errorf("filter: must be %s, %s, %s or %s", Nearest, Bilinear, Trilinear, Anisotropic)
+		}
+	}
+	t, err := LoadTexture(path)
+	if err != nil {
+		return nil, err
+	}
+	n.texture = t
+	if m["node"] == "hextile" {
+		return hexTileNode(n), nil
+	}
+	return n, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
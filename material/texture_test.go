@@ -0,0 +1,107 @@
+package material
+
+import (
+	stdimg "image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+// checkerboard returns a checkerboard of black and white texels of the
+// size
+func checkerboard(size int) *stdimg.Gray {
+	img := stdimg.NewGray(stdimg.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x+y)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestTexture(t *testing.T) {
+	tex := NewTexture(checkerboard(16))
+	if len(tex.levels) != 5 {
+		t.Fatalf("A mipmap of 16x16 texels should have 5 levels, not %d", len(tex.levels))
+	}
+	for _, l := range tex.levels[1:] {
+		for _, c := range l.texels {
+			if math.Abs(c.R-0.5) > 1e-9 {
+				t.Fatalf("The smaller levels should average the checkerboard, not %v", c)
+			}
+		}
+	}
+	// The first texel of the last row is black, and v grows upwards
+	if c := tex.Lookup(0.01, 0.01, 0, 0, 0, 0, Nearest); c != (image.Color{}) {
+		t.Errorf("Expected the black texel, not %v", c)
+	}
+	if c := tex.Lookup(1.01+1.0/16, 0.01, 0, 0, 0, 0, Nearest); c != image.White {
+		t.Errorf("The texture should repeat, not %v", c)
+	}
+	if c := tex.Lookup(1.0/16, 0.5, 0, 0, 0, 0, Bilinear); math.Abs(c.R-0.5) > 1e-9 {
+		t.Errorf("Between the texels the colors should be interpolated, not %v", c)
+	}
+	// Footprints as big as the texels take the image, and bigger ones the
+	// levels that average it
+	u, v := 0.5/16, 0.5/16
+	if c := tex.Lookup(u, v, 1.0/16, 0, 0, 1.0/16, Trilinear); c != (image.Color{}) {
+		t.Errorf("A footprint of a texel should take the texel, not %v", c)
+	}
+	if c := tex.Lookup(u, v, 0.5, 0, 0, 0.5, Trilinear); math.Abs(c.R-0.5) > 1e-9 {
+		t.Errorf("A distant checkerboard should be gray, not %v", c)
+	}
+	// A footprint long across the texels and thin along them stays as sharp
+	// as it is thin with anisotropic filtering
+	if c := tex.Lookup(u, v, 0, 0.5, 1.0/16/8, 0, Trilinear); math.Abs(c.R-0.5) > 1e-9 {
+		t.Errorf("Trilinear filtering should blur it as much as it's long, not %v", c)
+	}
+	stripes := stdimg.NewGray(stdimg.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x += 2 {
+			stripes.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	striped := NewTexture(stripes)
+	if c := striped.Lookup(u, v, 1.0/16, 0, 0, 0.5, Anisotropic); c.R < 0.99 {
+		t.Errorf("Anisotropic filtering should keep the stripes the footprint runs along, not %v", c)
+	}
+	if c := striped.Lookup(u, v, 1.0/16, 0, 0, 0.5, Trilinear); c.R > 0.9 {
+		t.Errorf("Trilinear filtering should blur the stripes, not %v", c)
+	}
+}
+
+func TestTextureNode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checker.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, checkerboard(4))
+	f.Close()
+	node, err := ParseNode(map[string]interface{}{"node": "image", "file": path, "filter": "nearest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := node.Eval(&ShadingPoint{U: 0.1, V: 0.1}); c != (image.Color{}) {
+		t.Errorf("Expected the black texel, not %v", c)
+	}
+	if again, _ := ParseNode(node.value()); again.(textureNode).texture != node.(textureNode).texture {
+		t.Error("The nodes of the same image should share the texture")
+	}
+	for _, bad := range []map[string]interface{}{
+		{"node": "image"},
+		{"node": "image", "file": path, "filter": "cubic"},
+		{"node": "image", "file": filepath.Join(t.TempDir(), "missing.png")},
+	} {
+		if _, err := ParseNode(bad); err == nil {
+			t.Errorf("%v should be an error", bad)
+		}
+	}
+}
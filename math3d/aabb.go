@@ -0,0 +1,102 @@
+package math3d
+
+import "math"
+
+// AABB defines an axis aligned bounding box in 3D space.
+type AABB struct {
+	Min Vector3 `json:"min"`
+	Max Vector3 `json:"max"`
+}
+
+// EmptyAABB returns a bounding box that contains nothing. Expanding or
+// joining it with anything returns the other operand.
+func EmptyAABB() *AABB {
+	inf := math.Inf(1)
+	return &AABB{
+		Min: Vector3{X: inf, Y: inf, Z: inf},
+		Max: Vector3{X: -inf, Y: -inf, Z: -inf}}
+}
+
+// Union returns the smallest bounding box that contains both boxes
+func (b *AABB) Union(b2 *AABB) *AABB {
+	return &AABB{
+		Min: Vector3{X: math.Min(b.Min.X, b2.Min.X), Y: math.Min(b.Min.Y, b2.Min.Y), Z: math.Min(b.Min.Z, b2.Min.Z)},
+		Max: Vector3{X: math.Max(b.Max.X, b2.Max.X), Y: math.Max(b.Max.Y, b2.Max.Y), Z: math.Max(b.Max.Z, b2.Max.Z)}}
+}
+
+// Expand returns the smallest bounding box that contains the box
+// and the point
+func (b *AABB) Expand(point *Vector3) *AABB {
+	return b.Union(&AABB{Min: *point, Max: *point})
+}
+
+// Contains returns true if the point is inside the box or on its
+// surface, within a margin of error.
+func (b *AABB) Contains(point *Vector3) bool {
+	return point.GreaterOrEqual(&b.Min) && point.LesserOrEqual(&b.Max)
+}
+
+// Distance returns the distance from the point to the nearest point of
+// the box, which is 0 if the point is inside it
+func (b *AABB) Distance(point *Vector3) float64 {
+	outside := Vector3{
+		X: math.Max(0, math.Max(b.Min.X-point.X, point.X-b.Max.X)),
+		Y: math.Max(0, math.Max(b.Min.Y-point.Y, point.Y-b.Max.Y)),
+		Z: math.Max(0, math.Max(b.Min.Z-point.Z, point.Z-b.Max.Z))}
+	return outside.Abs()
+}
+
+// Overlaps returns true if both boxes share any point
+func (b *AABB) Overlaps(b2 *AABB) bool {
+	return b.Min.X <= b2.Max.X && b2.Min.X <= b.Max.X &&
+		b.Min.Y <= b2.Max.Y && b2.Min.Y <= b.Max.Y &&
+		b.Min.Z <= b2.Max.Z && b2.Min.Z <= b.Max.Z
+}
+
+// Centroid returns the point in the middle of the box
+func (b *AABB) Centroid() *Vector3 {
+	return b.Min.Add(&b.Max).Multiply(0.5)
+}
+
+// SurfaceArea returns the area of the six faces of the box
+func (b *AABB) SurfaceArea() float64 {
+	d := b.Max.Subtract(&b.Min)
+	if d.X < 0 || d.Y < 0 || d.Z < 0 {
+		return 0
+	}
+	return 2 * (d.X*d.Y + d.Y*d.Z + d.Z*d.X)
+}
+
+// Intersect returns the distance at which the lightray enters the box.
+// It returns 0 if the source of the lightray is inside the box and
+// math.MaxFloat64 if the lightray misses it.
+func (b *AABB) Intersect(lr *LightRay) float64 {
+	near, _ := b.IntersectRange(lr)
+	return near
+}
+
+// gamma3 bounds the relative rounding error of three floating point
+// operations
+const gamma3 = 3 * 0x1p-53 / (1 - 3*0x1p-53)
+
+// IntersectRange returns the distances at which the lightray enters and
+// leaves the box. The entry is 0 if the source of the lightray is inside
+// the box, and both are math.MaxFloat64 if the lightray misses it.
+func (b *AABB) IntersectRange(lr *LightRay) (float64, float64) {
+	// Slab test. Dividing by a zero direction yields infinities that the
+	// min/max chain handles without special cases.
+	invX, invY, invZ := 1/lr.Direction.X, 1/lr.Direction.Y, 1/lr.Direction.Z
+	tx1, tx2 := (b.Min.X-lr.Source.X)*invX, (b.Max.X-lr.Source.X)*invX
+	ty1, ty2 := (b.Min.Y-lr.Source.Y)*invY, (b.Max.Y-lr.Source.Y)*invY
+	tz1, tz2 := (b.Min.Z-lr.Source.Z)*invZ, (b.Max.Z-lr.Source.Z)*invZ
+	tNear := math.Max(math.Max(math.Min(tx1, tx2), math.Min(ty1, ty2)), math.Min(tz1, tz2))
+	tFar := math.Min(math.Min(math.Max(tx1, tx2), math.Max(ty1, ty2)), math.Max(tz1, tz2))
+	// Rounding may put the exit just before the entry for lightrays through
+	// an edge or a corner, so the exit is pushed back by the most that the
+	// rounding can take away from it
+	tFar *= 1 + 2*gamma3
+	if tFar < math.Max(tNear, 0) || math.IsNaN(tNear) || math.IsNaN(tFar) {
+		return math.MaxFloat64, math.MaxFloat64
+	}
+	return math.Max(tNear, 0), tFar
+}
@@ -0,0 +1,56 @@
+package math3d
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAABBUnionAndExpand(t *testing.T) {
+	box := EmptyAABB().Expand(&Vector3{X: 1, Y: 2, Z: 3})
+	box = box.Union(&AABB{Min: Vector3{X: -1, Y: 0, Z: 0}, Max: Vector3{X: 0, Y: 1, Z: 1}})
+	if !box.Min.Equal(&Vector3{X: -1, Y: 0, Z: 0}) || !box.Max.Equal(&Vector3{X: 1, Y: 2, Z: 3}) {
+		t.Errorf("Wrong union of boxes: %s %s", box.Min.String(), box.Max.String())
+	}
+	if !box.Contains(&Vector3{X: 0, Y: 1, Z: 1}) || box.Contains(&Vector3{X: 2, Y: 0, Z: 0}) {
+		t.Error("Wrong containment check")
+	}
+	if !box.Centroid().Equal(&Vector3{X: 0, Y: 1, Z: 1.5}) {
+		t.Error("Wrong centroid " + box.Centroid().String())
+	}
+	if box.SurfaceArea() != 2*(2*2+2*3+3*2) {
+		t.Errorf("Wrong surface area %.3f", box.SurfaceArea())
+	}
+	if EmptyAABB().SurfaceArea() != 0 {
+		t.Error("An empty box should have no surface")
+	}
+}
+
+func TestAABBIntersection(t *testing.T) {
+	box := AABB{Min: Vector3{X: -1, Y: -1, Z: -1}, Max: Vector3{X: 1, Y: 1, Z: 1}}
+	hit := LightRay{Source: Vector3{X: 0, Y: 0, Z: -3}, Direction: UnitZ}
+	if d := box.Intersect(&hit); d != 2 {
+		t.Errorf("The lightray should enter the box at D=2.0 but it enters at %.3f", d)
+	}
+	inside := LightRay{Source: Vector3{}, Direction: UnitX}
+	if d := box.Intersect(&inside); d != 0 {
+		t.Errorf("A lightray from inside the box should enter at D=0.0, not %.3f", d)
+	}
+	miss := LightRay{Source: Vector3{X: 2, Y: 0, Z: -3}, Direction: UnitZ}
+	if box.Intersect(&miss) != math.MaxFloat64 {
+		t.Error("The lightray should miss the box")
+	}
+	behind := LightRay{Source: Vector3{X: 0, Y: 0, Z: 3}, Direction: UnitZ}
+	if box.Intersect(&behind) != math.MaxFloat64 {
+		t.Error("The box is behind the lightray")
+	}
+}
+
+func TestAABBDistance(t *testing.T) {
+	box := AABB{Min: Vector3{}, Max: Vector3{X: 1, Y: 1, Z: 1}}
+	if d := box.Distance(&Vector3{X: 0.5, Y: 0.2, Z: 1}); d != 0 {
+		t.Errorf("Points inside the box are 0 away from it, not %.3f", d)
+	}
+	if d := box.Distance(&Vector3{X: 4, Y: 0.5, Z: -4}); d != 5 {
+		t.Errorf("The point should be 5 away from the nearest edge, not %.3f", d)
+	}
+}
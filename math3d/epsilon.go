@@ -0,0 +1,39 @@
+package math3d
+
+import "math"
+
+// Epsilon is the tolerance of the comparisons of math3d, relative to the
+// magnitude of the values compared once it's above 1
+const Epsilon = threshold
+
+// RelativeEpsilon is the error of the points that lightrays hit, relative
+// to the magnitude of their coordinates, which the rounding errors of
+// intersecting shapes grow with. It's well above those errors in float64,
+// and well below any detail of the scenes.
+const RelativeEpsilon = 1e-9
+
+// ApproxEqual returns whether a and b are the same within eps, relative to
+// the larger of their magnitudes once it's above 1. Comparing floats with
+// a single absolute tolerance fails far from the origin, where the gaps
+// between the floats grow larger than it.
+func ApproxEqual(a, b, eps float64) bool {
+	return math.Abs(a-b) <= eps*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// MaxAbs returns the largest magnitude of the coordinates of the vector
+func (v Vector3) MaxAbs() float64 {
+	return math.Max(math.Abs(v.X), math.Max(math.Abs(v.Y), math.Abs(v.Z)))
+}
+
+// OffsetOrigin returns the point of a surface with the normal moved off it
+// along the normal, to the side direction leaves towards, by eps plus
+// RelativeEpsilon times the magnitude of its coordinates. Lightrays
+// leaving from there don't hit the surface, nor the ones touching it,
+// right where they leave, which is what makes shadow acne.
+func OffsetOrigin(point, normal, direction Vector3, eps float64) Vector3 {
+	offset := eps + RelativeEpsilon*point.MaxAbs()
+	if direction.DotV(normal) < 0 {
+		offset = -offset
+	}
+	return point.AddV(normal.MultiplyV(offset))
+}
@@ -0,0 +1,35 @@
+package math3d
+
+import (
+	"math"
+	"testing"
+)
+
+func TestApproxEqualIsRelative(t *testing.T) {
+	if !ApproxEqual(1, 1+1e-6, 1e-5) || ApproxEqual(1, 1+1e-4, 1e-5) {
+		t.Error("Near 1 the tolerance should be absolute")
+	}
+	if !ApproxEqual(1e6, 1e6+1, 1e-5) || ApproxEqual(1e6, 1e6+100, 1e-5) {
+		t.Error("Far from the origin the tolerance should grow with the values")
+	}
+	// The gap between the floats near 1e12 is above the absolute tolerance
+	a := Vector3{X: 1e12, Y: -3, Z: 0.5}
+	b := Vector3{X: math.Nextafter(1e12, 0), Y: -3, Z: 0.5}
+	if !a.Equal(&b) {
+		t.Error("Neighbouring floats should be equal vectors")
+	}
+}
+
+func TestOffsetOrigin(t *testing.T) {
+	point, normal := Vector3{X: 1, Y: 2, Z: 3}, UnitY
+	if up := OffsetOrigin(point, normal, Vector3{X: 1, Y: 1}, 1e-4); !up.Equal(&Vector3{X: 1, Y: 2.0001, Z: 3}) || up.Y <= point.Y {
+		t.Errorf("Lightrays leaving above the surface should start above it, not at %v", up)
+	}
+	if down := OffsetOrigin(point, normal, Vector3{Y: -1}, 1e-4); down.Y >= point.Y {
+		t.Errorf("Lightrays leaving below the surface should start below it, not at %v", down)
+	}
+	far := Vector3{X: 1e7}
+	if offset := OffsetOrigin(far, normal, normal, 1e-4).Y; math.Abs(offset-(1e-4+RelativeEpsilon*1e7)) > 1e-12 {
+		t.Errorf("The offset should grow with the coordinates, not be %v", offset)
+	}
+}
@@ -0,0 +1,52 @@
+package math3d
+
+import "math"
+
+// Vector32 is a vector in float32, half the size of a Vector3, for the
+// structures that hold so many vectors that their memory and the caches
+// they miss cost more than the precision they lose. The arithmetic is done
+// in float64 after widening them back with Float64.
+type Vector32 struct {
+	X, Y, Z float32
+}
+
+// Float64 returns the vector in float64
+func (v Vector32) Float64() Vector3 {
+	return Vector3{X: float64(v.X), Y: float64(v.Y), Z: float64(v.Z)}
+}
+
+// AABB32 is a bounding box in float32, whose bounds are rounded outwards
+// so it holds everything the float64 box it comes from does
+type AABB32 struct {
+	Min, Max Vector32
+}
+
+// Bounds32 returns the smallest box in float32 that holds the box
+func Bounds32(b *AABB) AABB32 {
+	return AABB32{
+		Min: Vector32{down32(b.Min.X), down32(b.Min.Y), down32(b.Min.Z)},
+		Max: Vector32{up32(b.Max.X), up32(b.Max.Y), up32(b.Max.Z)}}
+}
+
+// Float64 returns the box in float64
+func (b *AABB32) Float64() AABB {
+	return AABB{Min: b.Min.Float64(), Max: b.Max.Float64()}
+}
+
+// down32 returns the largest float32 that isn't above v
+func down32(v float64) float32 {
+	f := float32(v)
+	if float64(f) > v {
+		f = math.Nextafter32(f, float32(math.Inf(-1)))
+	}
+	return f
+}
+
+// up32 returns the smallest float32 that isn't below v
+func up32(v float64) float32 {
+	f := float32(v)
+	if float64(f) < v {
+		f = math.Nextafter32(f, float32(math.Inf(1)))
+	}
+	return f
+}
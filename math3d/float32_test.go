@@ -0,0 +1,27 @@
+package math3d
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBounds32HoldTheBox(t *testing.T) {
+	for _, box := range []AABB{
+		{Min: Vector3{X: 0.1, Y: -0.1, Z: 1e-40}, Max: Vector3{X: 0.3, Y: 1e10 + 1, Z: 1e300}},
+		{Min: Vector3{X: -1e300, Y: 1.5, Z: -0.7}, Max: Vector3{X: -1e-300, Y: 1.5, Z: 0}},
+	} {
+		b32 := Bounds32(&box)
+		wide := b32.Float64()
+		if !wide.Contains(&box.Min) || !wide.Contains(&box.Max) {
+			t.Errorf("The float32 box %v should hold the box %v", wide, box)
+		}
+		// Exact values stay as they are
+		if box.Min.Y == 1.5 && (wide.Min.Y != 1.5 || wide.Max.Y != 1.5) {
+			t.Errorf("1.5 is a float32, and shouldn't be rounded to %v", wide)
+		}
+	}
+	empty := Bounds32(EmptyAABB())
+	if wide := empty.Float64(); !math.IsInf(wide.Min.X, 1) || !math.IsInf(wide.Max.X, -1) {
+		t.Errorf("An empty box should stay empty in float32, not %v", wide)
+	}
+}
@@ -4,4 +4,43 @@ package math3d
 type LightRay struct {
 	Source    Vector3
 	Direction Vector3
+	// Origin is the primitive the ray leaves, nil if it doesn't leave one.
+	// Intersectors ignore the ray hitting it again right at the source,
+	// which happens when rounding puts the source just behind its surface.
+	Origin interface{}
+	// Time is when the ray is traced, in seconds after the shutter opens
+	Time float64
+	// Wavelength is the hero wavelength the ray carries in spectral
+	// renders, in nanometers, 0 if the render isn't spectral
+	Wavelength float64
+	// Dispersed is true once the ray went through a dispersive material,
+	// after which it only carries its hero wavelength
+	Dispersed bool
+	// Differential is how the ray changes to the ones through the next
+	// pixels, nil if it isn't tracked, such as after diffuse bounces
+	Differential *Differential
+}
+
+// Differential is how the source and the direction of a lightray change
+// from the one through a pixel to the ones through the pixel right of it,
+// in X, and the one below it, in Y, so the footprint of the pixel on the
+// surfaces the lightray hits can be estimated
+type Differential struct {
+	SourceX, SourceY       Vector3
+	DirectionX, DirectionY Vector3
+}
+
+// Scaled returns the differential scaled by f, the one of lightrays f
+// pixels apart
+func (d *Differential) Scaled(f float64) *Differential {
+	scaled := d.ScaledV(f)
+	return &scaled
+}
+
+// ScaledV returns the differential scaled by f as a value
+func (d Differential) ScaledV(f float64) Differential {
+	return Differential{
+		SourceX: d.SourceX.MultiplyV(f), SourceY: d.SourceY.MultiplyV(f),
+		DirectionX: d.DirectionX.MultiplyV(f), DirectionY: d.DirectionY.MultiplyV(f),
+	}
 }
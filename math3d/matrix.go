@@ -8,6 +8,44 @@ type Matrix struct {
 	m, n, o, p float64
 }
 
+// MatrixFromArray returns the matrix with the values of the array, row
+// after row
+func MatrixFromArray(values [16]float64) Matrix {
+	return Matrix{
+		a: values[0], b: values[1], c: values[2], d: values[3],
+		e: values[4], f: values[5], g: values[6], h: values[7],
+		i: values[8], j: values[9], k: values[10], l: values[11],
+		m: values[12], n: values[13], o: values[14], p: values[15]}
+}
+
+// MatrixFromSlice returns the matrix with the 16 values of the slice, row
+// after row, as gonum's mat.Dense holds them. It panics if the slice
+// doesn't hold 16 values.
+func MatrixFromSlice(values []float64) Matrix {
+	if len(values) != 16 {
+		panic("A matrix needs 16 values")
+	}
+	var array [16]float64
+	copy(array[:], values)
+	return MatrixFromArray(array)
+}
+
+// AsArray returns the values of the matrix, row after row
+func (mat *Matrix) AsArray() [16]float64 {
+	return [16]float64{
+		mat.a, mat.b, mat.c, mat.d,
+		mat.e, mat.f, mat.g, mat.h,
+		mat.i, mat.j, mat.k, mat.l,
+		mat.m, mat.n, mat.o, mat.p}
+}
+
+// AsSlice returns the values of the matrix, row after row, so that
+// mat.NewDense(4, 4, m.AsSlice()) makes a gonum matrix of it
+func (mat *Matrix) AsSlice() []float64 {
+	array := mat.AsArray()
+	return array[:]
+}
+
 // MultiplyPoint returns point multiplied by the matrix
 func (mat *Matrix) MultiplyPoint(point *Vector3) *Vector3 {
 	x := mat.a*point.X + mat.b*point.Y + mat.c*point.Z + mat.d
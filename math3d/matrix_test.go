@@ -0,0 +1,30 @@
+package math3d
+
+import (
+	"testing"
+)
+
+func TestMatrixArrayConversions(t *testing.T) {
+	// Translates by 1, 2, 3 stored row after row
+	translation := [16]float64{
+		1, 0, 0, 1,
+		0, 1, 0, 2,
+		0, 0, 1, 3,
+		0, 0, 0, 1}
+	m := MatrixFromArray(translation)
+	if p := m.MultiplyPoint(&Vector3{X: 1, Y: 1, Z: 1}); !p.Equal(&Vector3{X: 2, Y: 3, Z: 4}) {
+		t.Error("The rows of the array should be the rows of the matrix, the point moved to " + p.String())
+	}
+	if a := m.AsArray(); a != translation {
+		t.Errorf("The matrix should survive a round trip through an array, not become %v", a)
+	}
+	if r := MatrixFromSlice(m.AsSlice()); r != m {
+		t.Errorf("The matrix should survive a round trip through a slice, not become %v", r.AsArray())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("A slice without 16 values should panic")
+		}
+	}()
+	MatrixFromSlice(translation[:15])
+}
@@ -0,0 +1,150 @@
+package math3d
+
+import (
+	"fmt"
+	"math"
+)
+
+// Quaternion holds a rotation in 3D space as a unit quaternion
+type Quaternion struct {
+	W float64 `json:"w"`
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// IdentityQuaternion is the quaternion of the rotation that doesn't rotate
+var IdentityQuaternion = Quaternion{W: 1}
+
+// AxisAngle returns the quaternion of the rotation by angle radians
+// around the axis, counterclockwise as seen from where it points. The
+// axis doesn't need to be unit length, and a zero axis doesn't rotate.
+func AxisAngle(axis Vector3, angle float64) Quaternion {
+	length := axis.Abs()
+	if length == 0 {
+		return IdentityQuaternion
+	}
+	sine := math.Sin(angle/2) / length
+	return Quaternion{W: math.Cos(angle / 2), X: axis.X * sine, Y: axis.Y * sine, Z: axis.Z * sine}
+}
+
+// Multiply returns the quaternion of rotating by r and then by q
+func (q Quaternion) Multiply(r Quaternion) Quaternion {
+	return Quaternion{
+		W: q.W*r.W - q.X*r.X - q.Y*r.Y - q.Z*r.Z,
+		X: q.W*r.X + q.X*r.W + q.Y*r.Z - q.Z*r.Y,
+		Y: q.W*r.Y - q.X*r.Z + q.Y*r.W + q.Z*r.X,
+		Z: q.W*r.Z + q.X*r.Y - q.Y*r.X + q.Z*r.W}
+}
+
+// Conjugate returns the quaternion of the inverse rotation
+func (q Quaternion) Conjugate() Quaternion {
+	return Quaternion{W: q.W, X: -q.X, Y: -q.Y, Z: -q.Z}
+}
+
+// Dot returns the dot product of the quaternions
+func (q Quaternion) Dot(r Quaternion) float64 {
+	return q.W*r.W + q.X*r.X + q.Y*r.Y + q.Z*r.Z
+}
+
+// Normalized returns the quaternion scaled to unit length
+func (q Quaternion) Normalized() Quaternion {
+	return q.scale(1 / math.Sqrt(q.Dot(q)))
+}
+
+// Rotate returns v rotated by the quaternion
+func (q Quaternion) Rotate(v Vector3) Vector3 {
+	r := q.Multiply(Quaternion{X: v.X, Y: v.Y, Z: v.Z}).Multiply(q.Conjugate())
+	return Vector3{X: r.X, Y: r.Y, Z: r.Z}
+}
+
+// Equal returns true if both quaternions are the same rotation within a
+// margin of error. q and -q are the same rotation.
+func (q Quaternion) Equal(r Quaternion) bool {
+	return 1-math.Abs(q.Dot(r)) < threshold
+}
+
+func (q Quaternion) String() string {
+	return fmt.Sprintf("[%.3f, %.3f, %.3f, %.3f]", q.W, q.X, q.Y, q.Z)
+}
+
+// SlerpQuaternion returns the rotation at t of the shortest arc from the
+// rotation a to the rotation b, turning at a constant speed
+func SlerpQuaternion(a, b Quaternion, t float64) Quaternion {
+	if a.Dot(b) < 0 {
+		b = b.scale(-1)
+	}
+	return slerp(a, b, t)
+}
+
+// Squad returns the rotation at t of a smooth curve through the rotations
+// q0, q1, q2 and q3, between q1 at 0 and q2 at 1. Unlike chaining slerps,
+// the angular velocity doesn't jump at q1 and q2, so the curve suits
+// keyframes the way Catmull-Rom splines suit points.
+func Squad(q0, q1, q2, q3 Quaternion, t float64) Quaternion {
+	// Every rotation is taken on the side of the previous one, so the
+	// curve takes the shortest arcs
+	if q0.Dot(q1) < 0 {
+		q0 = q0.scale(-1)
+	}
+	if q1.Dot(q2) < 0 {
+		q2 = q2.scale(-1)
+	}
+	if q2.Dot(q3) < 0 {
+		q3 = q3.scale(-1)
+	}
+	s1, s2 := squadControl(q0, q1, q2), squadControl(q1, q2, q3)
+	return slerp(slerp(q1, q2, t), slerp(s1, s2, t), 2*t*(1-t))
+}
+
+// squadControl returns the inner control rotation of Squad at q, between
+// the rotations previous and next
+func squadControl(previous, q, next Quaternion) Quaternion {
+	inverse := q.Conjugate()
+	sum := inverse.Multiply(next).log().add(inverse.Multiply(previous).log())
+	return q.Multiply(sum.scale(-0.25).exp())
+}
+
+// slerp returns the rotation at t of the arc from a to b, which is the
+// longest one if their dot product is negative
+func slerp(a, b Quaternion, t float64) Quaternion {
+	cosine := Clamp(a.Dot(b), -1, 1)
+	angle := math.Acos(cosine)
+	sine := math.Sin(angle)
+	if sine < threshold {
+		// The arc is too short to tell its direction
+		return a.scale(1 - t).add(b.scale(t)).Normalized()
+	}
+	return a.scale(math.Sin((1-t)*angle) / sine).add(b.scale(math.Sin(t*angle) / sine))
+}
+
+// log returns the logarithm of the unit quaternion, which has no real
+// part
+func (q Quaternion) log() Quaternion {
+	angle := math.Acos(Clamp(q.W, -1, 1))
+	sine := math.Sin(angle)
+	if sine < threshold {
+		return Quaternion{}
+	}
+	k := angle / sine
+	return Quaternion{X: q.X * k, Y: q.Y * k, Z: q.Z * k}
+}
+
+// exp returns the exponential of the quaternion without real part, which
+// is a unit quaternion
+func (q Quaternion) exp() Quaternion {
+	angle := math.Sqrt(q.X*q.X + q.Y*q.Y + q.Z*q.Z)
+	if angle < threshold {
+		return IdentityQuaternion
+	}
+	k := math.Sin(angle) / angle
+	return Quaternion{W: math.Cos(angle), X: q.X * k, Y: q.Y * k, Z: q.Z * k}
+}
+
+func (q Quaternion) add(r Quaternion) Quaternion {
+	return Quaternion{W: q.W + r.W, X: q.X + r.X, Y: q.Y + r.Y, Z: q.Z + r.Z}
+}
+
+func (q Quaternion) scale(k float64) Quaternion {
+	return Quaternion{W: q.W * k, X: q.X * k, Y: q.Y * k, Z: q.Z * k}
+}
@@ -0,0 +1,67 @@
+package math3d
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQuaternionRotations(t *testing.T) {
+	quarter := AxisAngle(UnitZ, math.Pi/2)
+	tests := []struct {
+		name string
+		got  Vector3
+		want Vector3
+	}{
+		{"identity", IdentityQuaternion.Rotate(Vector3{X: 1, Y: 2, Z: 3}), Vector3{X: 1, Y: 2, Z: 3}},
+		{"quarter turn", quarter.Rotate(UnitX), UnitY},
+		{"axis not unit", AxisAngle(Vector3{Z: 5}, math.Pi/2).Rotate(UnitX), UnitY},
+		{"around itself", quarter.Rotate(UnitZ), UnitZ},
+		{"composed", quarter.Multiply(quarter).Rotate(UnitX), UnitX.MultiplyV(-1)},
+		{"then around X", AxisAngle(UnitX, math.Pi/2).Multiply(quarter).Rotate(UnitX), UnitZ},
+		{"inverse", quarter.Conjugate().Rotate(UnitY), UnitX},
+		{"zero axis", AxisAngle(Vector3{}, 1).Rotate(UnitX), UnitX},
+	}
+	for _, test := range tests {
+		if !test.got.Equal(&test.want) {
+			t.Errorf("%s: got %s, want %s", test.name, test.got.String(), test.want.String())
+		}
+	}
+}
+
+func TestSlerpQuaternion(t *testing.T) {
+	a, b := IdentityQuaternion, AxisAngle(UnitY, math.Pi/2)
+	tests := []struct {
+		name string
+		got  Quaternion
+		want Quaternion
+	}{
+		{"start", SlerpQuaternion(a, b, 0), a},
+		{"end", SlerpQuaternion(a, b, 1), b},
+		{"middle", SlerpQuaternion(a, b, 0.5), AxisAngle(UnitY, math.Pi/4)},
+		{"shortest arc", SlerpQuaternion(a, b.scale(-1), 0.5), AxisAngle(UnitY, math.Pi/4)},
+		{"same", SlerpQuaternion(b, b, 0.3), b},
+	}
+	for _, test := range tests {
+		if !test.got.Equal(test.want) {
+			t.Errorf("%s: got %s, want %s", test.name, test.got.String(), test.want.String())
+		}
+	}
+}
+
+func TestSquadGoesThroughTheKeys(t *testing.T) {
+	q0, q1 := AxisAngle(UnitX, 0.3), AxisAngle(UnitY, 1)
+	q2, q3 := AxisAngle(Vector3{X: 1, Y: 1}, 2), AxisAngle(UnitZ, -1)
+	if q := Squad(q0, q1, q2, q3, 0); !q.Equal(q1) {
+		t.Error("Squad should start at q1, not " + q.String())
+	}
+	if q := Squad(q0, q1, q2, q3, 1); !q.Equal(q2) {
+		t.Error("Squad should end at q2, not " + q.String())
+	}
+	// Keys evenly spread around an axis turn at a constant speed
+	for _, u := range []float64{0.25, 0.5, 0.8} {
+		q := Squad(AxisAngle(UnitZ, 0), AxisAngle(UnitZ, 0.5), AxisAngle(UnitZ, 1), AxisAngle(UnitZ, 1.5), u)
+		if want := AxisAngle(UnitZ, 0.5+0.5*u); !q.Equal(want) {
+			t.Errorf("At %v Squad should be %s, not %s", u, want.String(), q.String())
+		}
+	}
+}
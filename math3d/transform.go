@@ -0,0 +1,189 @@
+package math3d
+
+import (
+	"errors"
+	"math"
+)
+
+// IdentityMatrix returns the matrix that leaves points where they are
+func IdentityMatrix() Matrix {
+	return Matrix{a: 1, f: 1, k: 1, p: 1}
+}
+
+// TranslationMatrix returns the matrix that moves points by offset
+func TranslationMatrix(offset Vector3) Matrix {
+	m := IdentityMatrix()
+	m.d, m.h, m.l = offset.X, offset.Y, offset.Z
+	return m
+}
+
+// ScaleMatrix returns the matrix that scales points by the factors along
+// every axis, from the origin
+func ScaleMatrix(factors Vector3) Matrix {
+	return Matrix{a: factors.X, f: factors.Y, k: factors.Z, p: 1}
+}
+
+// RotationMatrix returns the matrix of the rotation of the quaternion
+func RotationMatrix(q Quaternion) Matrix {
+	m := IdentityMatrix()
+	x, y, z := q.Rotate(UnitX), q.Rotate(UnitY), q.Rotate(UnitZ)
+	m.a, m.e, m.i = x.X, x.Y, x.Z
+	m.b, m.f, m.j = y.X, y.Y, y.Z
+	m.c, m.g, m.k = z.X, z.Y, z.Z
+	return m
+}
+
+// Affine returns whether the matrix is an affine transform, whose last row
+// is 0, 0, 0, 1, so it keeps straight lines straight and parallel lines
+// parallel
+func (mat *Matrix) Affine() bool {
+	return mat.m == 0 && mat.n == 0 && mat.o == 0 && mat.p == 1
+}
+
+// Translation returns the offset the matrix moves points by, and whether
+// moving them is all it does. The rest of an affine matrix may be off the
+// identity by rounding, as it is after undoing a rotation with its inverse.
+func (mat *Matrix) Translation() (Vector3, bool) {
+	const tolerance = 1e-9
+	moves := mat.Affine()
+	for i, v := range [9]float64{mat.a, mat.b, mat.c, mat.e, mat.f, mat.g, mat.i, mat.j, mat.k} {
+		identity := 0.0
+		if i%4 == 0 {
+			identity = 1
+		}
+		moves = moves && math.Abs(v-identity) <= tolerance
+	}
+	return Vector3{X: mat.d, Y: mat.h, Z: mat.l}, moves
+}
+
+// Determinant returns the determinant of the upper 3x3 part of the
+// matrix, which is how much an affine transform scales volumes. It's
+// negative if the transform mirrors them.
+func (mat *Matrix) Determinant() float64 {
+	return mat.a*(mat.f*mat.k-mat.g*mat.j) - mat.b*(mat.e*mat.k-mat.g*mat.i) + mat.c*(mat.e*mat.j-mat.f*mat.i)
+}
+
+// Inverse returns the inverse of the affine matrix. ok is false if it
+// can't be inverted, because it flattens space.
+func (mat *Matrix) Inverse() (inverse Matrix, ok bool) {
+	det := mat.Determinant()
+	if det == 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return Matrix{}, false
+	}
+	inverse = Matrix{
+		a: (mat.f*mat.k - mat.g*mat.j) / det, b: (mat.c*mat.j - mat.b*mat.k) / det, c: (mat.b*mat.g - mat.c*mat.f) / det,
+		e: (mat.g*mat.i - mat.e*mat.k) / det, f: (mat.a*mat.k - mat.c*mat.i) / det, g: (mat.c*mat.e - mat.a*mat.g) / det,
+		i: (mat.e*mat.j - mat.f*mat.i) / det, j: (mat.b*mat.i - mat.a*mat.j) / det, k: (mat.a*mat.f - mat.b*mat.e) / det,
+		p: 1}
+	// The translation is undone after the rest
+	t := inverse.MultiplyVector(&Vector3{X: mat.d, Y: mat.h, Z: mat.l})
+	inverse.d, inverse.h, inverse.l = -t.X, -t.Y, -t.Z
+	return inverse, true
+}
+
+// MultiplyNormal returns the normal of a surface after transforming the
+// surface by the affine matrix, which is the normal multiplied by the
+// transpose of its inverse. It isn't normalized.
+func (mat *Matrix) MultiplyNormal(normal *Vector3) *Vector3 {
+	inverse, ok := mat.Inverse()
+	if !ok {
+		return &Vector3{}
+	}
+	return &Vector3{
+		X: inverse.a*normal.X + inverse.e*normal.Y + inverse.i*normal.Z,
+		Y: inverse.b*normal.X + inverse.f*normal.Y + inverse.j*normal.Z,
+		Z: inverse.c*normal.X + inverse.g*normal.Y + inverse.k*normal.Z}
+}
+
+// TransformFromMap returns the transform in value, which is either the 16
+// values of the matrix, row after row, or an object with the keys
+// "translate", "rotate" and "scale", all of them optional:
+//
+//   - scale is a vector of the factors along X, Y and Z, or a number that
+//     scales along all of them
+//   - rotate is a vector of the angles in degrees of the rotations around X,
+//     Y and Z, counterclockwise as seen from where the axes point
+//   - translate is the vector points are moved by
+//
+// They are applied in that order: points are scaled, then rotated around
+// X, then around Y, then around Z, and then translated. It returns an
+// error if the transform isn't affine or can't be inverted.
+func TransformFromMap(value interface{}) (Matrix, error) {
+	var m Matrix
+	switch value := value.(type) {
+	case []interface{}:
+		if len(value) != 16 {
+			return Matrix{}, errors.New("a transform matrix needs 16 values")
+		}
+		values := make([]float64, 16)
+		for i, v := range value {
+			number, ok := v.(float64)
+			if !ok {
+				return Matrix{}, errors.New("the values of a transform matrix must be numbers")
+			}
+			values[i] = number
+		}
+		m = MatrixFromSlice(values)
+	case map[string]interface{}:
+		for k := range value {
+			if k != "translate" && k != "rotate" && k != "scale" {
+				return Matrix{}, errors.New("a transform has only translate, rotate and scale")
+			}
+		}
+		scale := Vector3{X: 1, Y: 1, Z: 1}
+		switch s := value["scale"].(type) {
+		case nil:
+		case float64:
+			scale = Vector3{X: s, Y: s, Z: s}
+		default:
+			var ok bool
+			if scale, ok = vectorFrom(s); !ok {
+				return Matrix{}, errors.New("the scale must be a number or a vector")
+			}
+		}
+		var angles, offset Vector3
+		if r, present := value["rotate"]; present {
+			var ok bool
+			if angles, ok = vectorFrom(r); !ok {
+				return Matrix{}, errors.New("the rotation must be a vector of angles")
+			}
+			angles = angles.MultiplyV(math.Pi / 180)
+		}
+		if t, present := value["translate"]; present {
+			var ok bool
+			if offset, ok = vectorFrom(t); !ok {
+				return Matrix{}, errors.New("the translation must be a vector")
+			}
+		}
+		rotation := AxisAngle(UnitZ, angles.Z).Multiply(AxisAngle(UnitY, angles.Y)).Multiply(AxisAngle(UnitX, angles.X))
+		translation, rotate, scaling := TranslationMatrix(offset), RotationMatrix(rotation), ScaleMatrix(scale)
+		m = *translation.ComposeMatrix(rotate.ComposeMatrix(&scaling))
+	default:
+		return Matrix{}, errors.New("a transform must be a matrix or an object")
+	}
+	for _, v := range m.AsArray() {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Matrix{}, errors.New("the transform must be finite")
+		}
+	}
+	if !m.Affine() {
+		return Matrix{}, errors.New("the last row of a transform matrix must be 0, 0, 0, 1")
+	}
+	if _, ok := m.Inverse(); !ok || math.Abs(m.Determinant()) < 1e-12 {
+		return Matrix{}, errors.New("the transform can't be inverted")
+	}
+	return m, nil
+}
+
+// vectorFrom returns the vector in value, if it's an object with the
+// numbers x, y and z
+func vectorFrom(value interface{}) (Vector3, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 3 {
+		return Vector3{}, false
+	}
+	x, okX := m["x"].(float64)
+	y, okY := m["y"].(float64)
+	z, okZ := m["z"].(float64)
+	return Vector3{X: x, Y: y, Z: z}, okX && okY && okZ
+}
@@ -0,0 +1,72 @@
+package math3d
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func transformFromJSON(t *testing.T, text string) (Matrix, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		t.Fatal(err)
+	}
+	return TransformFromMap(value)
+}
+
+func TestTransformComposesScaleRotateTranslate(t *testing.T) {
+	m, err := transformFromJSON(t, `{"translate": {"x": 1, "y": 0, "z": 0}, "rotate": {"x": 90, "y": 0, "z": 90}, "scale": 2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Scaled to (0, 2, 0), rotated around X to (0, 0, 2), unmoved by the
+	// rotation around Z and translated
+	if p := m.MultiplyPoint(&UnitY); Distance(p, &Vector3{X: 1, Z: 2}) > 1e-12 {
+		t.Error("The point should be scaled, rotated around X, then Z and then translated, not moved to " + p.String())
+	}
+	inverse, ok := m.Inverse()
+	if !ok {
+		t.Fatal("The transform should be invertible")
+	}
+	point := Vector3{X: 0.3, Y: -2, Z: 5}
+	if back := inverse.MultiplyPoint(m.MultiplyPoint(&point)); Distance(back, &point) > 1e-12 {
+		t.Error("The inverse should undo the transform, not move the point to " + back.String())
+	}
+	full, err := transformFromJSON(t, `[2, 0, 0, 1, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stretched along X, the normal of the plane x = y leans towards Y
+	normal := full.MultiplyNormal(&Vector3{X: 1, Y: -1}).NormalizedV()
+	if along := (Vector3{X: 2, Y: 1}); normal.DotV(along) > 1e-12 {
+		t.Error("The normal should stay perpendicular to the transformed surface, not be " + normal.String())
+	}
+}
+
+func TestTransformRejectsBadMatrices(t *testing.T) {
+	for _, bad := range []string{
+		`{"scale": 0}`,
+		`{"scale": {"x": 1, "y": 0, "z": 1}}`,
+		`[1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1]`,
+		`[1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0]`,
+		`{"rotate": 90}`,
+		`{"shear": 1}`,
+		`"identity"`,
+	} {
+		if _, err := transformFromJSON(t, bad); err == nil {
+			t.Errorf("%s should be an error", bad)
+		}
+	}
+}
+
+func TestTranslation(t *testing.T) {
+	moved := TranslationMatrix(Vector3{X: 1, Y: 2, Z: 3})
+	if offset, ok := moved.Translation(); !ok || offset != (Vector3{X: 1, Y: 2, Z: 3}) {
+		t.Errorf("The translation should move by 1, 2, 3, not %s (%v)", offset.String(), ok)
+	}
+	turned := RotationMatrix(AxisAngle(UnitY, 0.5))
+	translation := TranslationMatrix(Vector3{X: 1})
+	turned = *translation.ComposeMatrix(&turned)
+	if _, ok := turned.Translation(); ok {
+		t.Error("A rotation does more than moving points")
+	}
+}
@@ -1,8 +1,12 @@
 package math3d
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+
+	"github.com/ProjectMOA/goraytrace/jsonutil"
 )
 
 const threshold float64 = 0.00001
@@ -25,50 +29,127 @@ type Vector3 struct {
 }
 
 // Abs returns the distance from the origin
-func (v *Vector3) Abs() float64 {
-	return math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+func (v Vector3) Abs() float64 {
+	return math.Sqrt(v.AbsSquared())
+}
+
+// AbsSquared returns the squared distance from the origin, which is
+// cheaper than Abs when only comparing lengths
+func (v Vector3) AbsSquared() float64 {
+	return v.X*v.X + v.Y*v.Y + v.Z*v.Z
 }
 
 // Normalized returns the normalized 3D vector
 func (v *Vector3) Normalized() *Vector3 {
-	return v.Divide(v.Abs())
+	r := v.NormalizedV()
+	return &r
 }
 
 // Divide returns a vector result of dividing all the values in
 // the vector by k
 func (v *Vector3) Divide(k float64) *Vector3 {
-	return &Vector3{v.X / k, v.Y / k, v.Z / k}
+	r := v.DivideV(k)
+	return &r
 }
 
 // Multiply returns a vector result of multiplying all the values
 // in the vector by k
 func (v *Vector3) Multiply(k float64) *Vector3 {
-	return &Vector3{v.X * k, v.Y * k, v.Z * k}
+	r := v.MultiplyV(k)
+	return &r
 }
 
 // Add returns the result of adding two vectors
 func (v *Vector3) Add(v2 *Vector3) *Vector3 {
-	return &Vector3{v.X + v2.X, v.Y + v2.Y, v.Z + v2.Z}
+	r := v.AddV(*v2)
+	return &r
 }
 
 // Subtract returns the result of subtracting two vectors
 func (v *Vector3) Subtract(v2 *Vector3) *Vector3 {
-	return &Vector3{v.X - v2.X, v.Y - v2.Y, v.Z - v2.Z}
+	r := v.SubtractV(*v2)
+	return &r
 }
 
 // Dot returns the dot product of the 3D vectors
 func (v *Vector3) Dot(v2 *Vector3) float64 {
-	return v.X*v2.X + v.Y*v2.Y + v.Z*v2.Z
+	return v.DotV(*v2)
 }
 
 // Cross returns the cross product of the 3D vectors
 func (v *Vector3) Cross(v2 *Vector3) *Vector3 {
-	return &Vector3{
+	r := v.CrossV(*v2)
+	return &r
+}
+
+// The methods ending in V work on values instead of pointers, so they
+// don't allocate and are the ones to use in the rendering loops.
+
+// NormalizedV returns the normalized 3D vector
+func (v Vector3) NormalizedV() Vector3 {
+	return v.DivideV(v.Abs())
+}
+
+// DivideV returns a vector result of dividing all the values in
+// the vector by k
+func (v Vector3) DivideV(k float64) Vector3 {
+	return Vector3{v.X / k, v.Y / k, v.Z / k}
+}
+
+// MultiplyV returns a vector result of multiplying all the values
+// in the vector by k
+func (v Vector3) MultiplyV(k float64) Vector3 {
+	return Vector3{v.X * k, v.Y * k, v.Z * k}
+}
+
+// AddV returns the result of adding two vectors
+func (v Vector3) AddV(v2 Vector3) Vector3 {
+	return Vector3{v.X + v2.X, v.Y + v2.Y, v.Z + v2.Z}
+}
+
+// SubtractV returns the result of subtracting two vectors
+func (v Vector3) SubtractV(v2 Vector3) Vector3 {
+	return Vector3{v.X - v2.X, v.Y - v2.Y, v.Z - v2.Z}
+}
+
+// DotV returns the dot product of the 3D vectors
+func (v Vector3) DotV(v2 Vector3) float64 {
+	return v.X*v2.X + v.Y*v2.Y + v.Z*v2.Z
+}
+
+// CrossV returns the cross product of the 3D vectors
+func (v Vector3) CrossV(v2 Vector3) Vector3 {
+	return Vector3{
 		v.Y*v2.Z - v.Z*v2.Y,
 		v.Z*v2.X - v.X*v2.Z,
 		v.X*v2.Y - v.Y*v2.X}
 }
 
+// AddInPlace adds v2 to the vector
+func (v *Vector3) AddInPlace(v2 Vector3) {
+	v.X, v.Y, v.Z = v.X+v2.X, v.Y+v2.Y, v.Z+v2.Z
+}
+
+// SubtractInPlace subtracts v2 from the vector
+func (v *Vector3) SubtractInPlace(v2 Vector3) {
+	v.X, v.Y, v.Z = v.X-v2.X, v.Y-v2.Y, v.Z-v2.Z
+}
+
+// MultiplyInPlace multiplies all the values in the vector by k
+func (v *Vector3) MultiplyInPlace(k float64) {
+	v.X, v.Y, v.Z = v.X*k, v.Y*k, v.Z*k
+}
+
+// DivideInPlace divides all the values in the vector by k
+func (v *Vector3) DivideInPlace(k float64) {
+	v.X, v.Y, v.Z = v.X/k, v.Y/k, v.Z/k
+}
+
+// NormalizeInPlace makes the vector unit length
+func (v *Vector3) NormalizeInPlace() {
+	v.DivideInPlace(v.Abs())
+}
+
 // Subtract returns the vector that goes from pointA to
 // pointB.
 func Subtract(pointA *Vector3, pointB *Vector3) *Vector3 {
@@ -82,18 +163,87 @@ func Distance(pointA *Vector3, pointB *Vector3) float64 {
 	return Subtract(pointA, pointB).Abs()
 }
 
+// DistanceSquared returns the squared distance from pointA to pointB.
+func DistanceSquared(pointA *Vector3, pointB *Vector3) float64 {
+	return Subtract(pointA, pointB).AbsSquared()
+}
+
+// AngleBetween returns the angle in radians between the vectors, from 0
+// to Pi. It's 0 if any of them is zero.
+func AngleBetween(a, b Vector3) float64 {
+	// The arctangent keeps its precision for nearly parallel vectors,
+	// unlike the arccosine of the dot product
+	return math.Atan2(a.CrossV(b).Abs(), a.DotV(b))
+}
+
+// Lerp returns the point at t of the segment from a to b, which is a
+// at 0 and b at 1
+func Lerp(a, b Vector3, t float64) Vector3 {
+	return a.MultiplyV(1 - t).AddV(b.MultiplyV(t))
+}
+
+// Slerp returns the unit vector at t of the arc from the unit vector a to
+// the unit vector b, turning at a constant speed. The arc between
+// opposite vectors goes through any vector perpendicular to them.
+func Slerp(a, b Vector3, t float64) Vector3 {
+	angle := AngleBetween(a, b)
+	sine := math.Sin(angle)
+	if sine < threshold {
+		if a.DotV(b) > 0 {
+			return Lerp(a, b, t).NormalizedV()
+		}
+		perpendicular, _ := OrthonormalBasis(a)
+		return a.MultiplyV(math.Cos(t * math.Pi)).AddV(perpendicular.MultiplyV(math.Sin(t * math.Pi)))
+	}
+	return a.MultiplyV(math.Sin((1-t)*angle) / sine).AddV(b.MultiplyV(math.Sin(t*angle) / sine))
+}
+
+// OrthonormalBasis returns two unit vectors that are perpendicular to
+// each other and to the unit vector v
+func OrthonormalBasis(v Vector3) (Vector3, Vector3) {
+	helper := UnitX
+	if math.Abs(v.X) > 0.9 {
+		helper = UnitY
+	}
+	first := v.CrossV(helper).NormalizedV()
+	return first, v.CrossV(first)
+}
+
 // Reflect returns the vector reflected off the surface with
-// the given normal
+// the given unit normal
 func (v *Vector3) Reflect(normal *Vector3) *Vector3 {
-	return v.Subtract(normal).Multiply(v.Dot(normal) * 2)
+	r := v.ReflectV(*normal)
+	return &r
+}
+
+// ReflectV returns the vector reflected off the surface with
+// the given unit normal
+func (v Vector3) ReflectV(normal Vector3) Vector3 {
+	return v.SubtractV(normal.MultiplyV(2 * v.DotV(normal)))
+}
+
+// ProjectV returns the component of the vector along onto. It's zero if
+// onto is zero.
+func (v Vector3) ProjectV(onto Vector3) Vector3 {
+	length2 := onto.AbsSquared()
+	if length2 == 0 {
+		return Vector3{}
+	}
+	return onto.MultiplyV(v.DotV(onto) / length2)
+}
+
+// RejectV returns the component of the vector perpendicular to onto, so
+// that it adds up to the vector with ProjectV
+func (v Vector3) RejectV(onto Vector3) Vector3 {
+	return v.SubtractV(v.ProjectV(onto))
 }
 
 // Equal returns true if both vectors are the same within a
-// margin of error
+// margin of error, relative to the magnitude of their coordinates
 func (v *Vector3) Equal(v2 *Vector3) bool {
-	return math.Abs(v.X-v2.X) < threshold &&
-		math.Abs(v.Y-v2.Y) < threshold &&
-		math.Abs(v.Z-v2.Z) < threshold
+	return ApproxEqual(v.X, v2.X, threshold) &&
+		ApproxEqual(v.Y, v2.Y, threshold) &&
+		ApproxEqual(v.Z, v2.Z, threshold)
 }
 
 // Differ returns true if the vectors are not the same within a
@@ -132,7 +282,66 @@ func (v *Vector3) AsMap() map[string]float64 {
 	return map[string]float64{"x": v.X, "y": v.Y, "z": v.Z}
 }
 
-// VectorFromMap returns the vector defined in the map
-func VectorFromMap(m map[string]interface{}) Vector3 {
-	return Vector3{X: m["x"].(float64), Y: m["y"].(float64), Z: m["z"].(float64)}
+// AsArray returns the X, Y and Z values of the vector
+func (v Vector3) AsArray() [3]float64 {
+	return [3]float64{v.X, v.Y, v.Z}
+}
+
+// AsSlice returns the X, Y and Z values of the vector, so that
+// mat.NewVecDense(3, v.AsSlice()) makes a gonum vector of it
+func (v Vector3) AsSlice() []float64 {
+	return []float64{v.X, v.Y, v.Z}
+}
+
+// VectorFromArray returns the vector with the X, Y and Z values of the
+// array
+func VectorFromArray(values [3]float64) Vector3 {
+	return Vector3{X: values[0], Y: values[1], Z: values[2]}
+}
+
+// VectorFromSlice returns the vector with the X, Y and Z values of the
+// slice, such as the RawVector().Data of a gonum vector. It panics if the
+// slice doesn't hold 3 values.
+func VectorFromSlice(values []float64) Vector3 {
+	if len(values) != 3 {
+		panic("A vector needs 3 values")
+	}
+	return Vector3{X: values[0], Y: values[1], Z: values[2]}
+}
+
+// jsonVector3 is a Vector3 without its JSON methods, to encode it
+type jsonVector3 Vector3
+
+// MarshalJSON returns the vector as an object with x, y and z. It fails
+// if they aren't finite, which JSON can't hold.
+func (v Vector3) MarshalJSON() ([]byte, error) {
+	if err := jsonutil.Finite(v.X, v.Y, v.Z); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonVector3(v))
+}
+
+// UnmarshalJSON sets the vector from an object with the numbers x, y and
+// z, and nothing else
+func (v *Vector3) UnmarshalJSON(data []byte) error {
+	var decoded Vector3
+	if err := jsonutil.Object(data, map[string]interface{}{"x": &decoded.X, "y": &decoded.Y, "z": &decoded.Z}, "x", "y", "z"); err != nil {
+		return err
+	}
+	*v = decoded
+	return nil
+}
+
+// VectorFromMap returns the vector in value, which must be an object with
+// the numbers x, y and z, as encoding/json decodes it. Its other keys are
+// ignored.
+func VectorFromMap(value interface{}) (Vector3, error) {
+	m, _ := value.(map[string]interface{})
+	x, okX := m["x"].(float64)
+	y, okY := m["y"].(float64)
+	z, okZ := m["z"].(float64)
+	if !okX || !okY || !okZ {
+		return Vector3{}, errors.New("must be an object with the numbers x, y and z")
+	}
+	return Vector3{X: x, Y: y, Z: z}, nil
 }
@@ -0,0 +1,62 @@
+package math3d
+
+import (
+	"fmt"
+	"math"
+)
+
+// Vector2 holds two floats that represent 2D space, such as texture
+// coordinates or positions in the image plane.
+type Vector2 struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Abs returns the distance from the origin
+func (v *Vector2) Abs() float64 {
+	return math.Sqrt(v.X*v.X + v.Y*v.Y)
+}
+
+// Add returns the result of adding two vectors
+func (v *Vector2) Add(v2 *Vector2) *Vector2 {
+	return &Vector2{v.X + v2.X, v.Y + v2.Y}
+}
+
+// Subtract returns the result of subtracting two vectors
+func (v *Vector2) Subtract(v2 *Vector2) *Vector2 {
+	return &Vector2{v.X - v2.X, v.Y - v2.Y}
+}
+
+// Multiply returns a vector result of multiplying all the values
+// in the vector by k
+func (v *Vector2) Multiply(k float64) *Vector2 {
+	return &Vector2{v.X * k, v.Y * k}
+}
+
+// Divide returns a vector result of dividing all the values in
+// the vector by k
+func (v *Vector2) Divide(k float64) *Vector2 {
+	return &Vector2{v.X / k, v.Y / k}
+}
+
+// Dot returns the dot product of the 2D vectors
+func (v *Vector2) Dot(v2 *Vector2) float64 {
+	return v.X*v2.X + v.Y*v2.Y
+}
+
+// Lerp returns the linear interpolation between v and v2, returning v
+// when t is 0 and v2 when t is 1
+func (v *Vector2) Lerp(v2 *Vector2, t float64) *Vector2 {
+	return &Vector2{v.X + (v2.X-v.X)*t, v.Y + (v2.Y-v.Y)*t}
+}
+
+// Equal returns true if both vectors are the same within a
+// margin of error
+func (v *Vector2) Equal(v2 *Vector2) bool {
+	return math.Abs(v.X-v2.X) < threshold &&
+		math.Abs(v.Y-v2.Y) < threshold
+}
+
+func (v *Vector2) String() string {
+	return fmt.Sprintf("[%.3f, %.3f]", v.X, v.Y)
+}
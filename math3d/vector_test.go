@@ -1,6 +1,8 @@
 package math3d
 
 import (
+	"encoding/json"
+	"math"
 	"testing"
 )
 
@@ -30,3 +32,170 @@ func TestVectorDivision(t *testing.T) {
 		t.Error("Division went wrong" + v.String())
 	}
 }
+
+func TestValueAndPointerMethodsAgree(t *testing.T) {
+	a := Vector3{X: 1.0, Y: -2.0, Z: 0.5}
+	b := Vector3{X: 0.3, Y: 4.0, Z: -1.0}
+	if r := a.AddV(b); !r.Equal(a.Add(&b)) {
+		t.Error("AddV and Add differ " + r.String())
+	}
+	if r := a.CrossV(b); !r.Equal(a.Cross(&b)) {
+		t.Error("CrossV and Cross differ " + r.String())
+	}
+	if r := a.NormalizedV(); !r.Equal(a.Normalized()) {
+		t.Error("NormalizedV and Normalized differ " + r.String())
+	}
+	c := a
+	c.SubtractInPlace(b)
+	c.MultiplyInPlace(2)
+	if !c.Equal(a.Subtract(&b).Multiply(2)) {
+		t.Error("In place operations went wrong " + c.String())
+	}
+}
+
+var benchResult Vector3
+
+func BenchmarkPointerMethods(b *testing.B) {
+	v1 := &Vector3{X: 1.0, Y: 2.0, Z: 3.0}
+	v2 := &Vector3{X: 0.5, Y: -1.0, Z: 2.0}
+	var r *Vector3
+	for i := 0; i < b.N; i++ {
+		r = v1.Add(v2).Multiply(0.5).Cross(v2).Normalized()
+	}
+	benchResult = *r
+}
+
+func BenchmarkValueMethods(b *testing.B) {
+	v1 := Vector3{X: 1.0, Y: 2.0, Z: 3.0}
+	v2 := Vector3{X: 0.5, Y: -1.0, Z: 2.0}
+	var r Vector3
+	for i := 0; i < b.N; i++ {
+		r = v1.AddV(v2).MultiplyV(0.5).CrossV(v2).NormalizedV()
+	}
+	benchResult = r
+}
+
+func BenchmarkInPlaceMethods(b *testing.B) {
+	v2 := Vector3{X: 0.5, Y: -1.0, Z: 2.0}
+	var r Vector3
+	for i := 0; i < b.N; i++ {
+		r = Vector3{X: 1.0, Y: 2.0, Z: 3.0}
+		r.AddInPlace(v2)
+		r.MultiplyInPlace(0.5)
+		r.NormalizeInPlace()
+	}
+	benchResult = r
+}
+
+func TestVectorMath(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Vector3
+		want Vector3
+	}{
+		{"reflect off a floor", (&Vector3{X: 1, Y: -1, Z: 0}).ReflectV(UnitY), Vector3{X: 1, Y: 1, Z: 0}},
+		{"reflect head on", UnitZ.MultiplyV(-2).ReflectV(UnitZ), Vector3{X: 0, Y: 0, Z: 2}},
+		{"reflect along the surface", UnitX.ReflectV(UnitY), UnitX},
+		{"reflect pointer", *(&Vector3{X: 1, Y: -1, Z: 0}).Reflect(&UnitY), Vector3{X: 1, Y: 1, Z: 0}},
+		{"project", (&Vector3{X: 3, Y: 4, Z: 0}).ProjectV(Vector3{X: 2, Y: 0, Z: 0}), Vector3{X: 3, Y: 0, Z: 0}},
+		{"project onto zero", UnitX.ProjectV(Vector3{}), Vector3{}},
+		{"reject", (&Vector3{X: 3, Y: 4, Z: 0}).RejectV(Vector3{X: 2, Y: 0, Z: 0}), Vector3{X: 0, Y: 4, Z: 0}},
+		{"lerp start", Lerp(UnitX, UnitY, 0), UnitX},
+		{"lerp middle", Lerp(Vector3{X: 2, Y: 0, Z: 0}, Vector3{X: 0, Y: 4, Z: 0}, 0.5), Vector3{X: 1, Y: 2, Z: 0}},
+		{"lerp end", Lerp(UnitX, UnitY, 1), UnitY},
+		{"slerp middle", Slerp(UnitX, UnitY, 0.5), Vector3{X: math.Sqrt2 / 2, Y: math.Sqrt2 / 2, Z: 0}},
+		{"slerp third", Slerp(UnitX, UnitY, 1.0/3), Vector3{X: math.Sqrt(3) / 2, Y: 0.5, Z: 0}},
+		{"slerp end", Slerp(UnitX, UnitY, 1), UnitY},
+		{"slerp same", Slerp(UnitZ, UnitZ, 0.3), UnitZ},
+	}
+	for _, test := range tests {
+		if !test.got.Equal(&test.want) {
+			t.Errorf("%s: got %s, want %s", test.name, test.got.String(), test.want.String())
+		}
+	}
+}
+
+func TestVectorMeasures(t *testing.T) {
+	a := Vector3{X: 1, Y: 2, Z: 2}
+	b := Vector3{X: 4, Y: 6, Z: 2}
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"abs squared", a.AbsSquared(), 9},
+		{"distance squared", DistanceSquared(&a, &b), 25},
+		{"right angle", AngleBetween(UnitX, UnitZ), math.Pi / 2},
+		{"opposite", AngleBetween(UnitX, UnitX.MultiplyV(-3)), math.Pi},
+		{"same direction", AngleBetween(a, a.MultiplyV(2)), 0},
+		{"forty five degrees", AngleBetween(UnitX, Vector3{X: 2, Y: 2, Z: 0}), math.Pi / 4},
+		{"zero vector", AngleBetween(Vector3{}, UnitX), 0},
+	}
+	for _, test := range tests {
+		if math.Abs(test.got-test.want) > threshold {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestSlerpOfOppositeVectorsStaysUnit(t *testing.T) {
+	for _, step := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		v := Slerp(UnitY, UnitY.MultiplyV(-1), step)
+		if math.Abs(v.Abs()-1) > threshold || math.Abs(AngleBetween(UnitY, v)-step*math.Pi) > threshold {
+			t.Errorf("Slerp at %v went wrong %s", step, v.String())
+		}
+	}
+}
+
+func TestVectorArrayConversions(t *testing.T) {
+	v := Vector3{X: 1, Y: -2, Z: 3.5}
+	if a := v.AsArray(); a != [3]float64{1, -2, 3.5} {
+		t.Errorf("Wrong array %v", a)
+	}
+	if r := VectorFromArray(v.AsArray()); r != v {
+		t.Error("The vector should survive a round trip through an array, not become " + r.String())
+	}
+	if r := VectorFromSlice(v.AsSlice()); r != v {
+		t.Error("The vector should survive a round trip through a slice, not become " + r.String())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("A slice without 3 values should panic")
+		}
+	}()
+	VectorFromSlice([]float64{1, 2})
+}
+
+func TestVectorJSON(t *testing.T) {
+	v := Vector3{X: 1, Y: -2.5, Z: 3}
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Vector3
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != v {
+		t.Errorf("%s should decode to %v, not %v (%v)", data, v, decoded, err)
+	}
+	for _, invalid := range []string{`{"x": 1, "y": 2}`, `{"x": 1, "y": 2, "z": 3, "w": 4}`, `{"x": "1", "y": 2, "z": 3}`, `[1, 2, 3]`} {
+		if err := json.Unmarshal([]byte(invalid), &decoded); err == nil {
+			t.Errorf("%s shouldn't decode", invalid)
+		}
+	}
+	if _, err := json.Marshal(Vector3{X: math.NaN()}); err == nil {
+		t.Error("A vector that isn't finite shouldn't encode")
+	}
+}
+
+func TestVectorFromMap(t *testing.T) {
+	var m interface{}
+	json.Unmarshal([]byte(`{"x": 1, "y": -2.5, "z": 3, "w": 4}`), &m)
+	if v, err := VectorFromMap(m); err != nil || v != (Vector3{X: 1, Y: -2.5, Z: 3}) {
+		t.Errorf("The vector should be read, ignoring w, not %v (%v)", v, err)
+	}
+	for _, invalid := range []interface{}{nil, "1, 2, 3", []interface{}{1.0, 2.0, 3.0},
+		map[string]interface{}{"x": 1.0, "y": 2.0}, map[string]interface{}{"x": "1", "y": 2.0, "z": 3.0}} {
+		if _, err := VectorFromMap(invalid); err == nil {
+			t.Errorf("%v shouldn't be read as a vector", invalid)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package medium
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Grid defines a participating medium inside a box whose density changes
+// from place to place, such as a cloud of smoke. The density is sampled
+// on a regular grid with samples on the faces of the box, and interpolated
+// trilinearly between them. Coefficients are per unit of length at a
+// density of 1.
+//
+// The medium may move with a grid of velocities, sampled at the same
+// points. The density at a time after the shutter opens is the one found
+// by going back along the velocity for that time, so renders whose shutter
+// stays open blur the medium where it moves.
+//
+// The medium may also glow, like fire, with a grid of temperatures sampled
+// at the same points and moved the same way. Every point emits the light
+// of a blackbody at its temperature, whatever its density.
+type Grid struct {
+	// Position is the corner of the box with the smallest coordinates and
+	// Size the length of its sides
+	Position math3d.Vector3 `json:"position"`
+	Size     math3d.Vector3 `json:"size"`
+	// Resolution is the number of samples along X, Y and Z
+	Resolution [3]int `json:"resolution"`
+	// Density holds the samples with X growing first, then Y and then Z
+	Density []float64 `json:"density"`
+	// Velocity holds the velocities at the samples in units per second, in
+	// the same order, or nothing if the medium doesn't move
+	Velocity   []math3d.Vector3 `json:"velocity,omitempty"`
+	Absorption image.Color      `json:"absorption"`
+	Scattering image.Color      `json:"scattering"`
+	// G is the asymmetry of the Henyey-Greenstein phase function
+	G float64 `json:"g"`
+	// Temperature holds the temperatures at the samples in kelvin, in the
+	// same order, or nothing if the medium doesn't glow
+	Temperature []float64 `json:"temperature,omitempty"`
+	// Emission is the radiance emitted per unit of length where the
+	// temperature is 1000 K. Hotter places are brighter, as blackbodies
+	// are, so 1200 K already glows 50 times as much.
+	Emission float64 `json:"emission,omitempty"`
+}
+
+// Validate returns an error if the grid can't be used to render
+func (g *Grid) Validate() error {
+	samples := 1
+	for _, n := range g.Resolution {
+		if n < 2 || n > 1<<10 {
+			return errors.New("the resolution must be between 2 and 1024 along every axis")
+		}
+		samples *= n
+	}
+	switch {
+	case !(g.Size.X > 0 && g.Size.Y > 0 && g.Size.Z > 0) || !finite(g.Size.X, g.Size.Y, g.Size.Z, g.Position.X, g.Position.Y, g.Position.Z):
+		return errors.New("the size must be positive and finite")
+	case len(g.Density) != samples:
+		return errors.New("there must be a density for every sample of the resolution")
+	case g.Velocity != nil && len(g.Velocity) != samples:
+		return errors.New("there must be a velocity for every sample of the resolution")
+	case g.Temperature != nil && len(g.Temperature) != samples:
+		return errors.New("there must be a temperature for every sample of the resolution")
+	case !(g.Emission >= 0) || !finite(g.Emission):
+		return errors.New("the emission must be finite and non negative")
+	case !g.Absorption.NonNegative() || !g.Scattering.NonNegative() ||
+		!finite(g.Absorption.R, g.Absorption.G, g.Absorption.B, g.Scattering.R, g.Scattering.G, g.Scattering.B):
+		return errors.New("the coefficients must be finite and non negative")
+	case !(math.Abs(g.G) < 1):
+		return errors.New("g must be between -1 and 1")
+	}
+	for _, d := range g.Density {
+		if !(d >= 0) || !finite(d) {
+			return errors.New("the densities must be finite and non negative")
+		}
+	}
+	for _, v := range g.Velocity {
+		if !finite(v.X, v.Y, v.Z) {
+			return errors.New("the velocities must be finite")
+		}
+	}
+	for _, t := range g.Temperature {
+		if !(t >= 0) || !finite(t) {
+			return errors.New("the temperatures must be finite and non negative")
+		}
+	}
+	return nil
+}
+
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Extinction returns the fraction of light that the medium takes away
+// from a ray per unit of length at a density of 1
+func (g *Grid) Extinction() *image.Color {
+	return g.Absorption.Add(&g.Scattering)
+}
+
+// Phase returns the Henyey-Greenstein phase function of the medium for
+// light that is scattered by an angle whose cosine is cosTheta
+func (g *Grid) Phase(cosTheta float64) float64 {
+	return HenyeyGreenstein(cosTheta, g.G)
+}
+
+// Bounds returns the box that holds the medium at every time up to
+// duration seconds after the shutter opens
+func (g *Grid) Bounds(duration float64) *math3d.AABB {
+	speed := 0.0
+	if duration > 0 {
+		for _, v := range g.Velocity {
+			speed = math.Max(speed, v.Abs())
+		}
+	}
+	reach := math3d.Vector3{X: speed * duration, Y: speed * duration, Z: speed * duration}
+	return &math3d.AABB{Min: g.Position.SubtractV(reach), Max: g.Position.AddV(g.Size).AddV(reach)}
+}
+
+// DensityAt returns the density of the medium at point, time seconds after
+// the shutter opens. Going back along the velocity at point is exact for
+// velocities that don't change over the distance moved, and close enough
+// for the short times a shutter is open.
+func (g *Grid) DensityAt(point *math3d.Vector3, time float64) float64 {
+	return g.sample(g.Density, point, time)
+}
+
+// TemperatureAt returns the temperature of the medium in kelvin at point,
+// time seconds after the shutter opens, moving it as DensityAt does. It's
+// 0 where the medium doesn't glow.
+func (g *Grid) TemperatureAt(point *math3d.Vector3, time float64) float64 {
+	if g.Temperature == nil {
+		return 0
+	}
+	return g.sample(g.Temperature, point, time)
+}
+
+// Glows returns whether the medium emits any light
+func (g *Grid) Glows() bool {
+	return g.Temperature != nil && g.Emission > 0
+}
+
+// EmissionAt returns the radiance emitted per unit of length by the medium
+// at point, time seconds after the shutter opens
+func (g *Grid) EmissionAt(point *math3d.Vector3, time float64) image.Color {
+	if !g.Glows() {
+		return image.Black
+	}
+	kelvin := g.TemperatureAt(point, time)
+	if kelvin == 0 {
+		return image.Black
+	}
+	color := image.Blackbody(kelvin)
+	return *color.Multiply(g.Emission * image.BlackbodyLuminance(kelvin))
+}
+
+// sample interpolates the values, one for every sample of the grid, at
+// point as it was time seconds after the shutter opens
+func (g *Grid) sample(values []float64, point *math3d.Vector3, time float64) float64 {
+	p := *point
+	if g.Velocity != nil && time != 0 {
+		p = p.SubtractV(g.VelocityAt(&p).MultiplyV(time))
+	}
+	i, j, k, fx, fy, fz, ok := g.cell(&p)
+	if !ok {
+		return 0
+	}
+	lerp := func(a, b, t float64) float64 { return a + (b-a)*t }
+	d := func(di, dj, dk int) float64 { return values[g.index(i+di, j+dj, k+dk)] }
+	return lerp(
+		lerp(lerp(d(0, 0, 0), d(1, 0, 0), fx), lerp(d(0, 1, 0), d(1, 1, 0), fx), fy),
+		lerp(lerp(d(0, 0, 1), d(1, 0, 1), fx), lerp(d(0, 1, 1), d(1, 1, 1), fx), fy),
+		fz)
+}
+
+// VelocityAt returns the velocity of the medium at point. Outside the box
+// it's the velocity at the nearest point of the box, so the medium that
+// leaves the box keeps moving.
+func (g *Grid) VelocityAt(point *math3d.Vector3) math3d.Vector3 {
+	if g.Velocity == nil {
+		return math3d.Vector3{}
+	}
+	max := g.Position.AddV(g.Size)
+	p := math3d.Vector3{
+		X: math.Max(g.Position.X, math.Min(max.X, point.X)),
+		Y: math.Max(g.Position.Y, math.Min(max.Y, point.Y)),
+		Z: math.Max(g.Position.Z, math.Min(max.Z, point.Z))}
+	i, j, k, fx, fy, fz, _ := g.cell(&p)
+	v := func(di, dj, dk int) math3d.Vector3 { return g.Velocity[g.index(i+di, j+dj, k+dk)] }
+	lerp := func(a, b math3d.Vector3, t float64) math3d.Vector3 { return a.AddV(b.SubtractV(a).MultiplyV(t)) }
+	return lerp(
+		lerp(lerp(v(0, 0, 0), v(1, 0, 0), fx), lerp(v(0, 1, 0), v(1, 1, 0), fx), fy),
+		lerp(lerp(v(0, 0, 1), v(1, 0, 1), fx), lerp(v(0, 1, 1), v(1, 1, 1), fx), fy),
+		fz)
+}
+
+// cell returns the indices of the sample at the low corner of the cell
+// holding point and where point is inside it, from 0 to 1 along every
+// axis. ok is false if point is outside the box.
+func (g *Grid) cell(point *math3d.Vector3) (i, j, k int, fx, fy, fz float64, ok bool) {
+	locate := func(x, position, size float64, samples int) (int, float64, bool) {
+		u := (x - position) / size * float64(samples-1)
+		if !(u >= 0 && u <= float64(samples-1)) {
+			return 0, 0, false
+		}
+		cell := int(math.Min(math.Floor(u), float64(samples-2)))
+		return cell, u - float64(cell), true
+	}
+	i, fx, okX := locate(point.X, g.Position.X, g.Size.X, g.Resolution[0])
+	j, fy, okY := locate(point.Y, g.Position.Y, g.Size.Y, g.Resolution[1])
+	k, fz, okZ := locate(point.Z, g.Position.Z, g.Size.Z, g.Resolution[2])
+	return i, j, k, fx, fy, fz, okX && okY && okZ
+}
+
+func (g *Grid) index(i, j, k int) int {
+	return (k*g.Resolution[1]+j)*g.Resolution[0] + i
+}
+
+// Transmittance returns the fraction of light that goes through the
+// medium along lr up to distance, time seconds after the shutter opens. It
+// marches with steps of length step starting at a fraction offset of the
+// first, which should be random for the estimate to have no banding.
+func (g *Grid) Transmittance(lr *math3d.LightRay, distance, time, step, offset float64) image.Color {
+	depth := g.OpticalDepth(lr, distance, time, step, offset)
+	e := g.Extinction()
+	return image.Color{R: math.Exp(-e.R * depth), G: math.Exp(-e.G * depth), B: math.Exp(-e.B * depth)}
+}
+
+// OpticalDepth returns the density integrated along lr up to distance,
+// time seconds after the shutter opens, marching as Transmittance does
+func (g *Grid) OpticalDepth(lr *math3d.LightRay, distance, time, step, offset float64) float64 {
+	enter, exit := g.Bounds(time).IntersectRange(lr)
+	if enter == math.MaxFloat64 {
+		return 0
+	}
+	// Every sample stands for a whole step, and the last one is only taken
+	// as often as the part of its step before the end, so the estimate is
+	// unbiased
+	depth := 0.0
+	for t := enter + step*offset; t < math.Min(exit, distance); t += step {
+		point := lr.Source.AddV(lr.Direction.MultiplyV(t))
+		depth += g.DensityAt(&point, time) * step
+	}
+	return depth
+}
@@ -0,0 +1,95 @@
+package medium
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// ramp returns a unit box whose density grows from 0 to 1 along X
+func ramp() *Grid {
+	g := &Grid{Size: math3d.Vector3{X: 1, Y: 1, Z: 1}, Resolution: [3]int{2, 2, 2},
+		Scattering: image.Color{R: 1, G: 1, B: 1}}
+	for k := 0; k < 2; k++ {
+		for j := 0; j < 2; j++ {
+			g.Density = append(g.Density, 0, 1)
+		}
+	}
+	return g
+}
+
+func TestGridInterpolatesTheDensity(t *testing.T) {
+	g := ramp()
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []float64{0, 0.25, 0.7, 1} {
+		if d := g.DensityAt(&math3d.Vector3{X: x, Y: 0.3, Z: 0.6}, 0); math.Abs(d-x) > 1e-12 {
+			t.Errorf("The density at x=%.2f should be %.2f, not %.3f", x, x, d)
+		}
+	}
+	if d := g.DensityAt(&math3d.Vector3{X: 1.5, Y: 0.5, Z: 0.5}, 0); d != 0 {
+		t.Errorf("There should be nothing outside the box, found %.3f", d)
+	}
+	// The ramp integrates to 1/2 across the box
+	lr := math3d.LightRay{Source: math3d.Vector3{X: -1, Y: 0.5, Z: 0.5}, Direction: math3d.UnitX}
+	if depth := g.OpticalDepth(&lr, math.MaxFloat64, 0, 0.01, 0.5); math.Abs(depth-0.5) > 1e-3 {
+		t.Errorf("The optical depth across the box should be 0.5, not %.4f", depth)
+	}
+}
+
+func TestGridIsAdvectedByTheVelocity(t *testing.T) {
+	g := ramp()
+	for range g.Density {
+		g.Velocity = append(g.Velocity, math3d.Vector3{X: 0.5})
+	}
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// After 0.4 seconds the density found at x was at x-0.2
+	if d := g.DensityAt(&math3d.Vector3{X: 0.7, Y: 0.5, Z: 0.5}, 0.4); math.Abs(d-0.5) > 1e-12 {
+		t.Errorf("The density should have moved by 0.2, found %.3f", d)
+	}
+	// The medium that leaves the box is still there
+	if d := g.DensityAt(&math3d.Vector3{X: 1.1, Y: 0.5, Z: 0.5}, 0.4); math.Abs(d-0.9) > 1e-12 {
+		t.Errorf("The density should have left the box, found %.3f", d)
+	}
+	if bounds := g.Bounds(0.4); bounds.Max.X != 1.2 || bounds.Min.X != -0.2 {
+		t.Errorf("The bounds should grow by the distance moved, they are %v", bounds)
+	}
+	g.Velocity = g.Velocity[1:]
+	if g.Validate() == nil {
+		t.Error("A velocity should be required for every sample")
+	}
+}
+
+func TestGridGlowsWithItsTemperature(t *testing.T) {
+	g := ramp()
+	g.Emission = 0.5
+	for range g.Density {
+		g.Temperature = append(g.Temperature, 1000)
+	}
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// The emission doesn't depend on the density
+	point := math3d.Vector3{X: 0, Y: 0.5, Z: 0.5}
+	if e := g.EmissionAt(&point, 0); math.Abs(e.Luminance()-0.5) > 1e-2 || !(e.R > e.B) {
+		t.Errorf("The medium should glow red with a luminance of 0.5, it's %s", e.String())
+	}
+	for i := range g.Temperature {
+		g.Temperature[i] = 1200
+	}
+	if hotter := g.EmissionAt(&point, 0); hotter.Luminance() < 10 {
+		t.Errorf("Hotter medium should glow a lot brighter, it's %s", hotter.String())
+	}
+	if e := g.EmissionAt(&math3d.Vector3{X: 2}, 0); e != image.Black {
+		t.Errorf("There should be no light outside the box, found %s", e.String())
+	}
+	g.Temperature = g.Temperature[1:]
+	if g.Validate() == nil {
+		t.Error("A temperature should be required for every sample")
+	}
+}
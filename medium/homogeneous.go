@@ -0,0 +1,87 @@
+package medium
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+// Homogeneous defines a participating medium, such as fog or smoke, that
+// has the same density everywhere. Coefficients are per unit of length
+// and per color channel.
+type Homogeneous struct {
+	// Absorption is the fraction of light absorbed per unit of length
+	Absorption image.Color `json:"absorption"`
+	// Scattering is the fraction of light scattered per unit of length
+	Scattering image.Color `json:"scattering"`
+	// G is the asymmetry of the Henyey-Greenstein phase function, from -1
+	// (light scatters backwards) to 1 (light keeps going forward)
+	G float64 `json:"g"`
+}
+
+// Extinction returns the fraction of light that the medium takes away
+// from a ray per unit of length, absorbing or scattering it
+func (m *Homogeneous) Extinction() *image.Color {
+	return m.Absorption.Add(&m.Scattering)
+}
+
+// Transmittance returns the fraction of light that goes through
+// distance units of the medium
+func (m *Homogeneous) Transmittance(distance float64) image.Color {
+	if distance == math.MaxFloat64 {
+		return image.Black
+	}
+	e := m.Extinction()
+	return image.Color{R: math.Exp(-e.R * distance), G: math.Exp(-e.G * distance), B: math.Exp(-e.B * distance)}
+}
+
+// Phase returns the Henyey-Greenstein phase function of the medium for
+// light that is scattered by an angle whose cosine is cosTheta
+func (m *Homogeneous) Phase(cosTheta float64) float64 {
+	return HenyeyGreenstein(cosTheta, m.G)
+}
+
+// HenyeyGreenstein returns the density of light scattered by an angle
+// whose cosine is cosTheta, for the asymmetry g
+func HenyeyGreenstein(cosTheta, g float64) float64 {
+	denominator := 1 + g*g - 2*g*cosTheta
+	return (1 - g*g) / (4 * math.Pi * denominator * math.Sqrt(denominator))
+}
+
+// SampleDistance returns a distance sampled proportionally to the
+// transmittance of the average channel of the medium, and its probability
+// density. u must be uniformly distributed in [0, 1).
+func (m *Homogeneous) SampleDistance(u float64) (float64, float64) {
+	e := m.Extinction()
+	sigma := (e.R + e.G + e.B) / 3
+	t := -math.Log(1-u) / sigma
+	return t, sigma * math.Exp(-sigma*t)
+}
+
+// HomogeneousFromMap returns the medium defined in the map, whose
+// absorption, scattering and g are all optional. It returns an error if
+// any of them has the wrong type.
+func HomogeneousFromMap(m map[string]interface{}) (*Homogeneous, error) {
+	retval := &Homogeneous{}
+	for _, c := range []struct {
+		key   string
+		color *image.Color
+	}{{"absorption", &retval.Absorption}, {"scattering", &retval.Scattering}} {
+		if value, present := m[c.key]; present {
+			var err error
+			if *c.color, err = image.ColorFromMap(value); err != nil {
+				return nil, fmt.Errorf("%s: %v", c.key, err)
+			}
+		}
+	}
+	if value, present := m["g"]; present {
+		g, ok := value.(float64)
+		if !ok {
+			return nil, errors.New("g: must be a number")
+		}
+		retval.G = g
+	}
+	return retval, nil
+}
@@ -0,0 +1,52 @@
+package medium
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+func TestPhaseFunctionIsNormalized(t *testing.T) {
+	for _, g := range []float64{-0.5, 0, 0.3, 0.8} {
+		// Integrate over the sphere, where d(solid angle) = 2*pi*d(cos)
+		steps := 100000
+		integral := 0.0
+		for i := 0; i < steps; i++ {
+			cos := -1 + (float64(i)+0.5)*2/float64(steps)
+			integral += HenyeyGreenstein(cos, g) * 2 * math.Pi * 2 / float64(steps)
+		}
+		if math.Abs(integral-1) > 1e-3 {
+			t.Errorf("The phase function with g=%.1f integrates to %.4f", g, integral)
+		}
+	}
+}
+
+func TestTransmittance(t *testing.T) {
+	fog := Homogeneous{Absorption: image.Color{R: 0.5, G: 0.5, B: 0.5}, Scattering: image.Color{R: 0.5, G: 0.5, B: 0.5}}
+	if tr := fog.Transmittance(1); math.Abs(tr.R-math.Exp(-1)) > 1e-12 {
+		t.Errorf("Wrong transmittance %s", tr.String())
+	}
+	if tr := fog.Transmittance(0); tr != image.White {
+		t.Errorf("Nothing should be lost in no distance, got %s", tr.String())
+	}
+	if d, pdf := fog.SampleDistance(0.5); math.Abs(d-math.Ln2) > 1e-12 || pdf <= 0 {
+		t.Errorf("The median distance should be ln(2), not %.3f", d)
+	}
+}
+
+func TestHomogeneousFromMap(t *testing.T) {
+	fog, err := HomogeneousFromMap(map[string]interface{}{"scattering": map[string]interface{}{"r": 0.5, "g": 0.5, "b": 0.5}, "g": 0.3})
+	if err != nil || fog.Scattering.G != 0.5 || fog.Absorption != image.Black || fog.G != 0.3 {
+		t.Errorf("The fog should be read with no absorption, not %v (%v)", fog, err)
+	}
+	for _, invalid := range []map[string]interface{}{
+		{"absorption": 0.5},
+		{"scattering": map[string]interface{}{"r": "0.5"}},
+		{"g": "forward"},
+	} {
+		if _, err := HomogeneousFromMap(invalid); err == nil {
+			t.Errorf("%v shouldn't be read as a medium", invalid)
+		}
+	}
+}
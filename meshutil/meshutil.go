@@ -0,0 +1,260 @@
+// Package meshutil cleans the triangles of imported meshes before they're
+// added to a scene: it welds their vertices, fixes their winding,
+// recomputes their normals and bakes transforms into them.
+package meshutil
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// indexed returns the distinct positions of the vertices of the
+// triangles, told apart by their bits, and the indices of the positions
+// of the vertices of every triangle
+func indexed(triangles []*shape.Triangle) ([]math3d.Vector3, [][3]int) {
+	indices := make(map[[3]uint64]int)
+	var positions []math3d.Vector3
+	faces := make([][3]int, len(triangles))
+	for i, t := range triangles {
+		for j, v := range t.Vertices {
+			key := [3]uint64{math.Float64bits(v.X), math.Float64bits(v.Y), math.Float64bits(v.Z)}
+			index, ok := indices[key]
+			if !ok {
+				index = len(positions)
+				indices[key] = index
+				positions = append(positions, v)
+			}
+			faces[i][j] = index
+		}
+	}
+	return positions, faces
+}
+
+// Weld moves the vertices of the triangles that are at most threshold
+// apart to the same position, that of the first of them, so the
+// triangles around them share them, and returns the triangles that still
+// have an area. Scanners and exporters that write every face on its own
+// leave cracks between them otherwise, and the normals can't be smoothed
+// across them.
+func Weld(triangles []*shape.Triangle, threshold float64) []*shape.Triangle {
+	positions, faces := indexed(triangles)
+	welded := make([]math3d.Vector3, len(positions))
+	copy(welded, positions)
+	if threshold > 0 {
+		// The positions kept, by the cells of a grid as big as the
+		// threshold, so only the ones of the cells around are compared
+		type cell [3]int64
+		cellOf := func(v math3d.Vector3) cell {
+			return cell{int64(math.Floor(v.X / threshold)), int64(math.Floor(v.Y / threshold)), int64(math.Floor(v.Z / threshold))}
+		}
+		grid := make(map[cell][]int)
+		for i, p := range positions {
+			c := cellOf(p)
+			kept := -1
+			for dx := int64(-1); dx <= 1 && kept < 0; dx++ {
+				for dy := int64(-1); dy <= 1 && kept < 0; dy++ {
+					for dz := int64(-1); dz <= 1 && kept < 0; dz++ {
+						for _, k := range grid[cell{c[0] + dx, c[1] + dy, c[2] + dz}] {
+							if positions[k].SubtractV(p).Abs() <= threshold {
+								kept = k
+								break
+							}
+						}
+					}
+				}
+			}
+			if kept >= 0 {
+				welded[i] = positions[kept]
+				continue
+			}
+			grid[c] = append(grid[c], i)
+		}
+	}
+	kept := triangles[:0:0]
+	for i, t := range triangles {
+		for j := range t.Vertices {
+			t.Vertices[j] = welded[faces[i][j]]
+		}
+		if t.Vertices[1].SubtractV(t.Vertices[0]).CrossV(t.Vertices[2].SubtractV(t.Vertices[0])).AbsSquared() > 0 {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// RecomputeNormals replaces the normals of the triangles with ones
+// generated from their faces: at every vertex, the average of the normals
+// of the triangles around it that are at most smoothAngle degrees apart
+// from the triangle, weighted by their areas. The triangles share the
+// vertices at the same positions, so they need welding first if they
+// don't. A smoothAngle of 0 shades them flat.
+func RecomputeNormals(triangles []*shape.Triangle, smoothAngle float64) {
+	if smoothAngle <= 0 {
+		for _, t := range triangles {
+			t.Normals = [3]math3d.Vector3{}
+		}
+		return
+	}
+	positions, faces := indexed(triangles)
+	// areaNormals holds the normals of the triangles scaled by twice their
+	// areas
+	areaNormals := make([]math3d.Vector3, len(triangles))
+	around := make([][]int, len(positions))
+	for i, t := range triangles {
+		areaNormals[i] = t.Vertices[1].SubtractV(t.Vertices[0]).CrossV(t.Vertices[2].SubtractV(t.Vertices[0]))
+		if areaNormals[i].AbsSquared() == 0 {
+			continue
+		}
+		for _, v := range faces[i] {
+			around[v] = append(around[v], i)
+		}
+	}
+	cosine := math.Cos(smoothAngle * math.Pi / 180)
+	for i, t := range triangles {
+		if areaNormals[i].AbsSquared() == 0 {
+			t.Normals = [3]math3d.Vector3{}
+			continue
+		}
+		normal := areaNormals[i].NormalizedV()
+		for j, v := range faces[i] {
+			var sum math3d.Vector3
+			for _, f := range around[v] {
+				if areaNormals[f].NormalizedV().DotV(normal) >= cosine {
+					sum.AddInPlace(areaNormals[f])
+				}
+			}
+			t.Normals[j] = sum.NormalizedV()
+		}
+	}
+}
+
+// FixWinding turns the triangles that go around their vertices the other
+// way than the ones they share edges with, so all of them face the same
+// side, and returns how many it turned. The meshes that are closed, whose
+// edges are all shared by two triangles, face outwards; the others face
+// the side most of their triangles faced. The normals of the triangles
+// turned are left as they are, so they need recomputing if they were
+// generated from the faces.
+func FixWinding(triangles []*shape.Triangle) int {
+	_, faces := indexed(triangles)
+	// The triangles by the edges they have, from the lower vertex
+	edges := make(map[[2]int][]int)
+	edgeOf := func(a, b int) [2]int {
+		if a > b {
+			a, b = b, a
+		}
+		return [2]int{a, b}
+	}
+	for i, f := range faces {
+		for j := range f {
+			e := edgeOf(f[j], f[(j+1)%3])
+			edges[e] = append(edges[e], i)
+		}
+	}
+	// forward returns whether the triangle goes along the edge from its
+	// lower vertex, once it's turned if it must be
+	turn := make([]bool, len(triangles))
+	forward := func(i int, e [2]int) bool {
+		f := faces[i]
+		for j := range f {
+			if f[j] == e[0] && f[(j+1)%3] == e[1] {
+				return !turn[i]
+			}
+		}
+		return turn[i]
+	}
+	visited := make([]bool, len(triangles))
+	turned := 0
+	for start := range triangles {
+		if visited[start] {
+			continue
+		}
+		// Turning the triangles joined to the start like it, across the
+		// edges they share
+		component := []int{start}
+		visited[start] = true
+		closed := true
+		for next := 0; next < len(component); next++ {
+			i := component[next]
+			f := faces[i]
+			for j := range f {
+				e := edgeOf(f[j], f[(j+1)%3])
+				if len(edges[e]) != 2 {
+					closed = false
+				}
+				for _, k := range edges[e] {
+					if visited[k] {
+						continue
+					}
+					visited[k] = true
+					turn[k] = forward(k, e) == forward(i, e)
+					component = append(component, k)
+				}
+			}
+		}
+		// The component faces outwards if the volume it encloses,
+		// summing the tetrahedra of its triangles, is positive
+		turning, volume := 0, 0.0
+		for _, i := range component {
+			v := triangles[i].Vertices
+			signed := v[0].DotV(v[1].CrossV(v[2]))
+			if turn[i] {
+				turning++
+				signed = -signed
+			}
+			volume += signed
+		}
+		if closed && volume < 0 || !closed && 2*turning > len(component) {
+			for _, i := range component {
+				turn[i] = !turn[i]
+			}
+			turning = len(component) - turning
+		}
+		for _, i := range component {
+			if turn[i] {
+				turnTriangle(triangles[i])
+			}
+		}
+		turned += turning
+	}
+	return turned
+}
+
+// turnTriangle makes the triangle go around its vertices the other way,
+// keeping the attributes of every vertex with it
+func turnTriangle(t *shape.Triangle) {
+	if t.UVs == [3]math3d.Vector2{} {
+		t.UVs = [3]math3d.Vector2{{}, {X: 1}, {Y: 1}}
+	}
+	t.Vertices[1], t.Vertices[2] = t.Vertices[2], t.Vertices[1]
+	t.Normals[1], t.Normals[2] = t.Normals[2], t.Normals[1]
+	t.UVs[1], t.UVs[2] = t.UVs[2], t.UVs[1]
+	t.Colors[1], t.Colors[2] = t.Colors[2], t.Colors[1]
+	t.Velocities[1], t.Velocities[2] = t.Velocities[2], t.Velocities[1]
+}
+
+// Bounds returns the bounding box of the triangles, wherever they are
+// while the shutter is open
+func Bounds(triangles []*shape.Triangle) *math3d.AABB {
+	bounds := math3d.EmptyAABB()
+	for _, t := range triangles {
+		bounds = bounds.Union(t.Bounds())
+	}
+	return bounds
+}
+
+// Bake transforms the triangles with the matrix, so they are where the
+// transform puts them without transforming the lightrays that hit them.
+// It fails if the matrix isn't affine or flattens them.
+func Bake(triangles []*shape.Triangle, m *math3d.Matrix) error {
+	if !m.Affine() || m.Determinant() == 0 {
+		return fmt.Errorf("only affine transforms that can be inverted can be baked")
+	}
+	for _, t := range triangles {
+		t.Transform(m)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package meshutil
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// cube returns the 12 triangles of a cube from 0 to 1, facing outwards,
+// whose vertices are moved by up to jitter
+func cube(jitter float64) []*shape.Triangle {
+	corner := func(i int) math3d.Vector3 {
+		return math3d.Vector3{X: float64(i & 1), Y: float64(i >> 1 & 1), Z: float64(i >> 2 & 1)}
+	}
+	quads := [][4]int{{0, 2, 3, 1}, {4, 5, 7, 6}, {0, 1, 5, 4}, {2, 6, 7, 3}, {0, 4, 6, 2}, {1, 3, 7, 5}}
+	var triangles []*shape.Triangle
+	for _, q := range quads {
+		for _, f := range [][3]int{{q[0], q[1], q[2]}, {q[0], q[2], q[3]}} {
+			t := &shape.Triangle{}
+			for j, i := range f {
+				// A different jitter for every triangle the corner is in
+				offset := jitter * float64(len(triangles)%3) / 2
+				t.Vertices[j] = corner(i).AddV(math3d.Vector3{X: offset, Y: -offset})
+			}
+			triangles = append(triangles, t)
+		}
+	}
+	return triangles
+}
+
+func outwards(t *shape.Triangle) bool {
+	n := t.Vertices[1].SubtractV(t.Vertices[0]).CrossV(t.Vertices[2].SubtractV(t.Vertices[0]))
+	center := math3d.Vector3{X: 0.5, Y: 0.5, Z: 0.5}
+	return n.DotV(t.Vertices[0].SubtractV(center)) > 0
+}
+
+func TestWeld(t *testing.T) {
+	triangles := cube(1e-4)
+	if positions, _ := indexed(triangles); len(positions) == 8 {
+		t.Fatal("The jittered cube should have cracks")
+	}
+	welded := Weld(triangles, 1e-3)
+	if positions, _ := indexed(welded); len(positions) != 8 || len(welded) != 12 {
+		t.Errorf("The cube should have 8 vertices and 12 triangles once welded, not %d and %d", len(positions), len(welded))
+	}
+	// Welding a thin triangle away
+	thin := &shape.Triangle{Vertices: [3]math3d.Vector3{{}, {X: 1}, {X: 1, Y: 1e-6}}}
+	if kept := Weld([]*shape.Triangle{thin}, 1e-3); len(kept) != 0 {
+		t.Error("The triangles that lose their areas should be left out")
+	}
+}
+
+func TestFixWinding(t *testing.T) {
+	triangles := cube(0)
+	for _, i := range []int{0, 5, 6, 7, 11} {
+		turnTriangle(triangles[i])
+	}
+	if turned := FixWinding(triangles); turned != 5 {
+		t.Errorf("Expected 5 triangles turned, not %d", turned)
+	}
+	for i, tri := range triangles {
+		if !outwards(tri) {
+			t.Errorf("Triangle %d should face outwards", i)
+		}
+	}
+	// A closed mesh faces outwards even if most of it faced inwards
+	for i := 0; i < 9; i++ {
+		turnTriangle(triangles[i])
+	}
+	if turned := FixWinding(triangles); turned != 9 {
+		t.Errorf("Expected 9 triangles turned, not %d", turned)
+	}
+	for i, tri := range triangles {
+		if !outwards(tri) {
+			t.Errorf("Triangle %d of the closed mesh should face outwards", i)
+		}
+	}
+	// An open one, 3 sides around a corner, faces the side most of it
+	// faced
+	open := []*shape.Triangle{triangles[0], triangles[1], triangles[4], triangles[5], triangles[8], triangles[9]}
+	turnTriangle(open[0])
+	if turned := FixWinding(open); turned != 1 || !outwards(open[0]) {
+		t.Errorf("The triangle that faced the other way should be turned, not %d", turned)
+	}
+}
+
+func TestRecomputeNormals(t *testing.T) {
+	triangles := cube(0)
+	RecomputeNormals(triangles, 60)
+	for i, tri := range triangles {
+		n := tri.Vertices[1].SubtractV(tri.Vertices[0]).CrossV(tri.Vertices[2].SubtractV(tri.Vertices[0])).NormalizedV()
+		for _, normal := range tri.Normals {
+			if normal.SubtractV(n).Abs() > 1e-9 {
+				t.Fatalf("The edges of the cube are sharper than 60 degrees, so triangle %d should be flat, not %v", i, tri.Normals)
+			}
+		}
+	}
+	RecomputeNormals(triangles, 100)
+	for _, tri := range triangles {
+		for j, normal := range tri.Normals {
+			// The corners average the normals of 3 faces, weighted by
+			// the areas of the triangles around them
+			if math.Abs(normal.Abs()-1) > 1e-9 || normal.DotV(tri.Vertices[j].SubtractV(math3d.Vector3{X: 0.5, Y: 0.5, Z: 0.5})) <= 0 {
+				t.Fatalf("The normals should be smoothed outwards, not %v", tri.Normals)
+			}
+		}
+	}
+	RecomputeNormals(triangles, 0)
+	if triangles[0].Normals != [3]math3d.Vector3{} {
+		t.Error("A smoothing angle of 0 should shade the triangles flat")
+	}
+}
+
+func TestBake(t *testing.T) {
+	triangles := cube(0)
+	if b := Bounds(triangles); b.Min != (math3d.Vector3{}) || b.Max != (math3d.Vector3{X: 1, Y: 1, Z: 1}) {
+		t.Errorf("Unexpected bounds %v", b)
+	}
+	mirror := math3d.ScaleMatrix(math3d.Vector3{X: -2, Y: 1, Z: 1})
+	if err := Bake(triangles, &mirror); err != nil {
+		t.Fatal(err)
+	}
+	if b := Bounds(triangles); b.Min != (math3d.Vector3{X: -2}) || b.Max != (math3d.Vector3{Y: 1, Z: 1}) {
+		t.Errorf("Unexpected bounds once mirrored %v", b)
+	}
+	if turned := FixWinding(triangles); turned != 0 {
+		t.Errorf("Mirroring should keep the triangles facing outwards, but %d were turned", turned)
+	}
+	flat := math3d.ScaleMatrix(math3d.Vector3{X: 1, Y: 0, Z: 1})
+	if err := Bake(triangles, &flat); err == nil {
+		t.Error("Flattening the triangles should be an error")
+	}
+}
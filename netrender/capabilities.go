@@ -0,0 +1,116 @@
+package netrender
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// FeatureEXR is the feature of workers that can write OpenEXR images.
+// Features are names that builds with optional parts, such as a denoiser,
+// announce, so jobs that need them skip the workers without them.
+const FeatureEXR = "exr"
+
+// Features are the features of the workers of this build
+var Features = []string{FeatureEXR}
+
+// The files that hold the memory of the machine, and the memory limit of
+// the container the process runs in with cgroup v2 and v1
+const (
+	memInfo        = "/proc/meminfo"
+	cgroupV2Memory = "/sys/fs/cgroup/memory.max"
+	cgroupV1Memory = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+)
+
+// Capabilities are what a worker tells the coordinator it can do when it
+// joins a job
+type Capabilities struct {
+	// Features are the optional features the worker was built with
+	Features []string `json:"features"`
+	// Memory is the memory the worker may use in bytes, 0 if unknown
+	Memory uint64 `json:"memory"`
+	// Threads is the number of tiles the worker renders at once
+	Threads int `json:"threads"`
+}
+
+// Requirements are what a worker needs to be handed the tiles of a job,
+// so heavy scenes only go to the machines that can hold them
+type Requirements struct {
+	// Features are the features every worker must have
+	Features []string
+	// Memory is the memory in bytes every worker must have. Workers that
+	// don't know theirs are turned down too.
+	Memory uint64
+}
+
+// empty returns whether any worker meets the requirements, even one
+// that didn't tell its capabilities
+func (r *Requirements) empty() bool {
+	return len(r.Features) == 0 && r.Memory == 0
+}
+
+// check returns why a worker with the capabilities can't work on the job,
+// or nil if it can
+func (r *Requirements) check(c Capabilities) error {
+	for _, feature := range r.Features {
+		if !hasFeature(c.Features, feature) {
+			return fmt.Errorf("the job needs the %s feature", feature)
+		}
+	}
+	if c.Memory < r.Memory {
+		return fmt.Errorf("the job needs %.1f GiB of memory and the worker has %.1f GiB", gib(r.Memory), gib(c.Memory))
+	}
+	return nil
+}
+
+func hasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func gib(bytes uint64) float64 {
+	return float64(bytes) / (1 << 30)
+}
+
+// LocalCapabilities returns the capabilities of a worker of this build on
+// this machine that renders threads tiles at once. Its memory is the one
+// of the machine, lowered to the limit of the container the process runs
+// in if it has one, or 0 where it can't be read, as outside Linux.
+func LocalCapabilities(threads int) Capabilities {
+	c := Capabilities{Features: Features, Threads: threads}
+	if contents, err := ioutil.ReadFile(memInfo); err == nil {
+		c.Memory = parseMemTotal(string(contents))
+	}
+	limit, err := ioutil.ReadFile(cgroupV2Memory)
+	if err != nil {
+		limit, err = ioutil.ReadFile(cgroupV1Memory)
+	}
+	// Unlimited cgroups hold max, or a huge number in v1
+	if err == nil {
+		if l, err := strconv.ParseUint(strings.TrimSpace(string(limit)), 10, 64); err == nil && l > 0 && (c.Memory == 0 || l < c.Memory) {
+			c.Memory = l
+		}
+	}
+	return c
+}
+
+// parseMemTotal returns the memory in the contents of /proc/meminfo, whose
+// MemTotal line holds it in KiB, or 0 if there's none
+func parseMemTotal(contents string) uint64 {
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			kib, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kib << 10
+		}
+	}
+	return 0
+}
@@ -0,0 +1,464 @@
+package netrender
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	stdimg "image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/animation"
+	"github.com/ProjectMOA/goraytrace/cbor"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/proto/renderpb"
+	"github.com/ProjectMOA/goraytrace/render"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// DefaultLease is how long a worker has to send the pixels of a tile, or
+// to tell the coordinator it's still alive, before the tile is handed out
+// again
+const DefaultLease = time.Minute
+
+// Job is what a coordinator renders
+type Job struct {
+	Scene *scene.Scene
+	// Animation is the animation of the scene, nil for a still
+	Animation *animation.Animation
+	// First and Last are the frames to render. They're 0 for a still.
+	First, Last   int
+	Width, Height int
+	// TileSize is the side in pixels of the tiles the frames are split
+	// in. If it's 0 every tile is a whole frame.
+	TileSize int
+	// Requires is what the workers need to be handed tiles of the job
+	Requires Requirements
+}
+
+// jobMessage is the job as workers get it
+type jobMessage struct {
+	Scene     json.RawMessage      `json:"scene"`
+	Animation *animation.Animation `json:"animation"`
+	Width     int                  `json:"width"`
+	Height    int                  `json:"height"`
+	// Encoding is the content coding the coordinator takes the pixels of
+	// the tiles in, tileEncoding, or empty if it only takes them as they
+	// are
+	Encoding string `json:"encoding,omitempty"`
+	// Lease is how many seconds the tiles are leased for, which workers
+	// keep renewing while they're alive, or 0 if they can't
+	Lease float64 `json:"lease,omitempty"`
+	// SceneCBOR is the scene of jobs sent in CBOR, instead of Scene
+	SceneCBOR []byte `json:"-"`
+}
+
+// cborType is the media type of CBOR, which workers accept the job in
+const cborType = "application/cbor"
+
+// protobufType is the media type of protobuf, which workers accept the
+// tiles in and send their pixels in as the messages of proto/render.proto
+const protobufType = "application/x-protobuf"
+
+// unitMessage is a tile as workers get it
+type unitMessage struct {
+	Unit   int `json:"unit"`
+	Lease  int `json:"lease"`
+	Frame  int `json:"frame"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// protobuf is whether the tile came as a renderpb.Tile, so its pixels
+	// go back as a renderpb.TileResult
+	protobuf bool
+}
+
+// unit is a tile of a frame to render
+type unit struct {
+	frame int
+	tile  stdimg.Rectangle
+	// lease is the number of the last time the unit was handed out, and
+	// expires when it can be handed out again. worker is the worker it
+	// was handed out to, which renews the lease while it's alive.
+	lease   int
+	expires time.Time
+	worker  string
+	done    bool
+}
+
+// Coordinator hands out the tiles of a job to the workers over HTTP and
+// puts their pixels together in frames.
+type Coordinator struct {
+	// Dashboard makes the coordinator serve a dashboard of the job to
+	// browsers too, as the package documentation describes. It must not
+	// be changed once the coordinator serves.
+	Dashboard bool
+
+	// job and jobCBOR are the job encoded in JSON and in CBOR
+	job      []byte
+	jobCBOR  []byte
+	width    int
+	height   int
+	lease    time.Duration
+	requires Requirements
+	onFrame  func(frame int, img *image.Image)
+
+	mu    sync.Mutex
+	units []unit
+	// workers holds the capabilities of the workers that joined the job
+	workers map[string]Capabilities
+	// frames holds the frames with tiles done, and left how many tiles
+	// each one still needs
+	frames map[int]*image.Image
+	left   map[int]int
+	// next is the first unit that may not be done
+	next     int
+	finished chan struct{}
+	// started is when the coordinator was created, progress holds the
+	// work of every worker by its id, and last and lastFrame the frame
+	// done last, for the dashboard
+	started   time.Time
+	progress  map[string]*workerProgress
+	last      *image.Image
+	lastFrame int
+}
+
+// NewCoordinator returns a coordinator of the job that leases tiles for
+// the duration, DefaultLease if it's 0. onFrame is called with every frame
+// once all its tiles are done, one at a time.
+func NewCoordinator(job Job, lease time.Duration, onFrame func(frame int, img *image.Image)) (*Coordinator, error) {
+	if job.Width <= 0 || job.Height <= 0 {
+		return nil, fmt.Errorf("invalid frame size %dx%d", job.Width, job.Height)
+	}
+	if job.Animation == nil && (job.First != 0 || job.Last != 0) {
+		return nil, fmt.Errorf("a still only has frame 0")
+	}
+	if job.Animation != nil && (job.First < 0 || job.Last >= job.Animation.Frames || job.First > job.Last) {
+		return nil, fmt.Errorf("the frames must be between 0 and %d", job.Animation.Frames-1)
+	}
+	sceneJSON, err := job.Scene.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if lease == 0 {
+		lease = DefaultLease
+	}
+	message, err := json.Marshal(jobMessage{Scene: sceneJSON, Animation: job.Animation, Width: job.Width, Height: job.Height,
+		Encoding: tileEncoding, Lease: lease.Seconds()})
+	if err != nil {
+		return nil, err
+	}
+	messageCBOR, err := marshalJobCBOR(job, lease)
+	if err != nil {
+		return nil, err
+	}
+	c := &Coordinator{job: message, jobCBOR: messageCBOR, width: job.Width, height: job.Height, lease: lease, requires: job.Requires,
+		onFrame: onFrame, workers: make(map[string]Capabilities), frames: make(map[int]*image.Image), left: make(map[int]int),
+		finished: make(chan struct{}), started: time.Now(), progress: make(map[string]*workerProgress)}
+	tileSize := job.TileSize
+	if tileSize <= 0 {
+		tileSize = maxInt(job.Width, job.Height)
+	}
+	for frame := job.First; frame <= job.Last; frame++ {
+		tiles := render.Tiles(job.Width, job.Height, tileSize)
+		for _, tile := range tiles {
+			c.units = append(c.units, unit{frame: frame, tile: tile})
+		}
+		c.left[frame] = len(tiles)
+	}
+	return c, nil
+}
+
+// Done returns a channel that's closed once every frame is done
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.finished
+}
+
+// Workers returns the capabilities of the workers that joined the job by
+// their ids
+func (c *Coordinator) Workers() map[string]Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	workers := make(map[string]Capabilities, len(c.workers))
+	for id, capabilities := range c.workers {
+		workers[id] = capabilities
+	}
+	return workers
+}
+
+// ServeHTTP serves the requests of the workers
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/job" && r.Method == http.MethodGet:
+		if strings.Contains(r.Header.Get("Accept"), cborType) {
+			w.Header().Set("Content-Type", cborType)
+			w.Write(c.jobCBOR)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(c.job)
+	case r.URL.Path == "/hello" && r.Method == http.MethodPost:
+		c.serveHello(w, r)
+	case r.URL.Path == "/work" && r.Method == http.MethodPost:
+		c.serveWork(w, r.URL.Query().Get("worker"), strings.Contains(r.Header.Get("Accept"), protobufType))
+	case r.URL.Path == "/heartbeat" && r.Method == http.MethodPost:
+		c.serveHeartbeat(w, r.URL.Query().Get("worker"))
+	case r.URL.Path == "/result" && r.Method == http.MethodPost:
+		c.serveResult(w, r)
+	case r.URL.Path == "/" && r.Method == http.MethodGet && c.Dashboard:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(dashboardPage))
+	case r.URL.Path == "/status" && r.Method == http.MethodGet && c.Dashboard:
+		c.serveStatus(w)
+	case r.URL.Path == "/preview.png" && r.Method == http.MethodGet && c.Dashboard:
+		c.servePreview(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// maxHello is the most bytes of capabilities read from a worker
+const maxHello = 64 << 10
+
+// serveHello lets the worker join the job if its capabilities meet the
+// requirements of the job, and turns it down with the reason otherwise
+func (c *Coordinator) serveHello(w http.ResponseWriter, r *http.Request) {
+	worker := r.URL.Query().Get("worker")
+	var capabilities Capabilities
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxHello)).Decode(&capabilities); err != nil || worker == "" {
+		http.Error(w, "the worker must have an id and capabilities", http.StatusBadRequest)
+		return
+	}
+	if err := c.requires.check(capabilities); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	c.mu.Lock()
+	c.workers[worker] = capabilities
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveWork leases the first unit that isn't done nor leased to the
+// worker. Units leased to workers that died are leased again once their
+// leases expire, since the workers stop renewing them. Jobs with
+// requirements only lease units to the workers that joined them. The unit
+// is a renderpb.Tile if protobuf is true, and JSON otherwise.
+func (c *Coordinator) serveWork(w http.ResponseWriter, worker string, protobuf bool) {
+	c.mu.Lock()
+	if _, joined := c.workers[worker]; !joined && !c.requires.empty() {
+		c.mu.Unlock()
+		http.Error(w, "the job has requirements the worker didn't say it meets", http.StatusForbidden)
+		return
+	}
+	if c.next == len(c.units) {
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	now := time.Now()
+	for i := c.next; i < len(c.units); i++ {
+		u := &c.units[i]
+		if u.done || now.Before(u.expires) {
+			continue
+		}
+		u.lease++
+		u.expires, u.worker = now.Add(c.lease), worker
+		c.workerProgress(worker, now)
+		message := unitMessage{Unit: i, Lease: u.lease, Frame: u.frame,
+			X: u.tile.Min.X, Y: u.tile.Min.Y, Width: u.tile.Dx(), Height: u.tile.Dy()}
+		c.mu.Unlock()
+		if protobuf {
+			w.Header().Set("Content-Type", protobufType)
+			w.Write((&renderpb.Tile{Unit: int32(message.Unit), Lease: int32(message.Lease), Frame: int32(message.Frame),
+				X: int32(message.X), Y: int32(message.Y), Width: int32(message.Width), Height: int32(message.Height)}).Marshal())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(message)
+		return
+	}
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveHeartbeat renews the leases of the units leased to the worker that
+// aren't done, since it's alive to render them
+func (c *Coordinator) serveHeartbeat(w http.ResponseWriter, worker string) {
+	if worker == "" {
+		http.Error(w, "unknown worker", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	now := time.Now()
+	c.workerProgress(worker, now)
+	for i := c.next; i < len(c.units); i++ {
+		u := &c.units[i]
+		if !u.done && u.worker == worker && now.Before(u.expires) {
+			u.expires = now.Add(c.lease)
+		}
+	}
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveResult copies the pixels of a unit into its frame. Pixels that
+// arrive after the unit was leased again are as good as any, so the lease
+// number is only checked to be one that was handed out, and the pixels of
+// a unit already done are ignored, however many workers send them.
+func (c *Coordinator) serveResult(w http.ResponseWriter, r *http.Request) {
+	index, lease, encoding, body, err := c.readResult(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if index < 0 || index >= len(c.units) {
+		http.Error(w, "unknown unit", http.StatusBadRequest)
+		return
+	}
+	tile := c.units[index].tile
+	var pixels []byte
+	var readErr error
+	switch encoding {
+	case tileEncoding:
+		pixels, readErr = decodeTile(body, tile.Dx(), tile.Dy())
+	case "":
+		pixels, readErr = ioutil.ReadAll(io.LimitReader(body, int64(4*tile.Dx()*tile.Dy()+1)))
+	default:
+		http.Error(w, "unknown content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	if readErr != nil || len(pixels) != 4*tile.Dx()*tile.Dy() {
+		http.Error(w, "the pixels don't fill the tile", http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u := &c.units[index]
+	if lease < 1 || lease > u.lease {
+		http.Error(w, "unknown lease", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	if u.done {
+		return
+	}
+	u.done = true
+	progress := c.workerProgress(u.worker, time.Now())
+	progress.tiles++
+	progress.pixels += tile.Dx() * tile.Dy()
+	frame := c.frames[u.frame]
+	if frame == nil {
+		frame = image.New(c.width, c.height)
+		c.frames[u.frame] = frame
+	}
+	for y := 0; y < tile.Dy(); y++ {
+		start := frame.PixOffset(tile.Min.X, tile.Min.Y+y)
+		copy(frame.Pix[start:start+4*tile.Dx()], pixels[4*tile.Dx()*y:])
+	}
+	c.left[u.frame]--
+	if c.left[u.frame] == 0 {
+		delete(c.frames, u.frame)
+		c.last, c.lastFrame = frame, u.frame
+		if c.onFrame != nil {
+			c.onFrame(u.frame, frame)
+		}
+	}
+	for c.next < len(c.units) && c.units[c.next].done {
+		c.next++
+	}
+	if c.next == len(c.units) {
+		close(c.finished)
+	}
+}
+
+// readResult returns the unit and lease numbers of the pixels the request
+// sends, their content coding and the body that holds them. They come in
+// a renderpb.TileResult if the request's content type is protobuf, and
+// in the query and the body otherwise. The unit is -1 if it's missing.
+func (c *Coordinator) readResult(r *http.Request) (index, lease int, encoding string, body io.Reader, err error) {
+	if r.Header.Get("Content-Type") != protobufType {
+		query := r.URL.Query()
+		if index, err = strconv.Atoi(query.Get("unit")); err != nil {
+			index = -1
+		}
+		lease, _ = strconv.Atoi(query.Get("lease"))
+		return index, lease, r.Header.Get("Content-Encoding"), r.Body, nil
+	}
+	// No tile is bigger than a frame, and its pixels take a few bytes
+	// more than it at most compressed
+	size := 4 * c.width * c.height
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(size+size/16+1024)))
+	if err != nil {
+		return 0, 0, "", nil, err
+	}
+	var result renderpb.TileResult
+	if err := result.Unmarshal(data); err != nil {
+		return 0, 0, "", nil, fmt.Errorf("invalid tile result: %v", err)
+	}
+	return int(result.Unit), int(result.Lease), result.Encoding, bytes.NewReader(result.Rgba), nil
+}
+
+// marshalJobCBOR returns the job as workers get it in CBOR, with the
+// tiles leased for the duration. The scene is a byte string holding the
+// scene in CBOR, and the animation is encoded as its JSON would be.
+func marshalJobCBOR(job Job, lease time.Duration) ([]byte, error) {
+	sceneCBOR, err := job.Scene.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	var a interface{}
+	if job.Animation != nil {
+		animationJSON, err := json.Marshal(job.Animation)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(animationJSON, &a); err != nil {
+			return nil, err
+		}
+	}
+	return cbor.Marshal(map[string]interface{}{"scene": sceneCBOR, "animation": a, "width": job.Width, "height": job.Height,
+		"encoding": tileEncoding, "lease": lease.Seconds()})
+}
+
+// unmarshalJobCBOR returns the job encoded in CBOR by marshalJobCBOR
+func unmarshalJobCBOR(data []byte) (*jobMessage, error) {
+	value, err := cbor.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := value.(map[string]interface{})
+	sceneCBOR, isBytes := m["scene"].([]byte)
+	width, widthOK := m["width"].(float64)
+	height, heightOK := m["height"].(float64)
+	if !isBytes || !widthOK || !heightOK {
+		return nil, fmt.Errorf("invalid job")
+	}
+	encoding, _ := m["encoding"].(string)
+	lease, _ := m["lease"].(float64)
+	job := &jobMessage{SceneCBOR: sceneCBOR, Width: int(width), Height: int(height), Encoding: encoding, Lease: lease}
+	if a := m["animation"]; a != nil {
+		animationJSON, err := json.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(animationJSON, &job.Animation); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
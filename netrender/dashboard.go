@@ -0,0 +1,253 @@
+package netrender
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+// workerProgress is the work a worker did for the job
+type workerProgress struct {
+	// tiles and pixels are the tiles done and their pixels
+	tiles, pixels int
+	// first is when the worker was first heard from, and last when it
+	// was last
+	first, last time.Time
+}
+
+// workerProgress returns the progress of the worker, heard from now,
+// adding it if it's new. It must be called with the lock held.
+func (c *Coordinator) workerProgress(worker string, now time.Time) *workerProgress {
+	p := c.progress[worker]
+	if p == nil {
+		p = &workerProgress{first: now}
+		c.progress[worker] = p
+	}
+	p.last = now
+	return p
+}
+
+// frameStatus is the progress of a frame, as the dashboard gets it
+type frameStatus struct {
+	Frame  int `json:"frame"`
+	Tiles  int `json:"tiles"`
+	Done   int `json:"done"`
+	Leased int `json:"leased"`
+}
+
+// workerStatus is the work of a worker, as the dashboard gets it
+type workerStatus struct {
+	ID      string `json:"id"`
+	Threads int    `json:"threads"`
+	Tiles   int    `json:"tiles"`
+	// PixelsPerSecond is the pixels of the tiles the worker sent over the
+	// time from when it was first heard from to when it was last
+	PixelsPerSecond float64 `json:"pixelspersecond"`
+	// Idle is the seconds since the worker was last heard from
+	Idle float64 `json:"idle"`
+}
+
+// tileStatus is a tile of the current frame, as the dashboard gets it
+type tileStatus struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	State  string `json:"state"`
+}
+
+// The states of the tiles
+const (
+	tilePending = "pending"
+	tileLeased  = "leased"
+	tileDone    = "done"
+)
+
+// dashboardStatus is the progress of the job, as the dashboard gets it
+type dashboardStatus struct {
+	Width   int            `json:"width"`
+	Height  int            `json:"height"`
+	Elapsed float64        `json:"elapsed"`
+	Done    bool           `json:"done"`
+	Frames  []frameStatus  `json:"frames"`
+	Workers []workerStatus `json:"workers"`
+	// Frame is the first frame that isn't done, or the last one once the
+	// job is done, whose tiles Tiles holds
+	Frame int          `json:"frame"`
+	Tiles []tileStatus `json:"tiles"`
+}
+
+// status returns the progress of the job at the time
+func (c *Coordinator) status(now time.Time) dashboardStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := dashboardStatus{Width: c.width, Height: c.height, Elapsed: now.Sub(c.started).Seconds(), Done: c.next == len(c.units)}
+	st.Frame = c.currentFrame()
+	for i := range c.units {
+		u := &c.units[i]
+		if len(st.Frames) == 0 || st.Frames[len(st.Frames)-1].Frame != u.frame {
+			st.Frames = append(st.Frames, frameStatus{Frame: u.frame})
+		}
+		f := &st.Frames[len(st.Frames)-1]
+		f.Tiles++
+		state := tilePending
+		if u.done {
+			state = tileDone
+			f.Done++
+		} else if now.Before(u.expires) {
+			state = tileLeased
+			f.Leased++
+		}
+		if u.frame == st.Frame {
+			st.Tiles = append(st.Tiles, tileStatus{X: u.tile.Min.X, Y: u.tile.Min.Y, Width: u.tile.Dx(), Height: u.tile.Dy(), State: state})
+		}
+	}
+	for id, p := range c.progress {
+		w := workerStatus{ID: id, Threads: c.workers[id].Threads, Tiles: p.tiles, Idle: now.Sub(p.last).Seconds()}
+		if busy := p.last.Sub(p.first).Seconds(); busy > 0 {
+			w.PixelsPerSecond = float64(p.pixels) / busy
+		}
+		st.Workers = append(st.Workers, w)
+	}
+	sort.Slice(st.Workers, func(i, j int) bool { return st.Workers[i].ID < st.Workers[j].ID })
+	return st
+}
+
+// currentFrame returns the first frame that isn't done, or the last one
+// once the job is done. It must be called with the lock held.
+func (c *Coordinator) currentFrame() int {
+	if c.next < len(c.units) {
+		return c.units[c.next].frame
+	}
+	return c.units[len(c.units)-1].frame
+}
+
+// serveStatus sends the progress of the job as JSON
+func (c *Coordinator) serveStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.status(time.Now()))
+}
+
+// servePreview sends the frame of the query, or the current one if there's
+// none, scaled down to fit in the width and the height of the query, or in
+// a thumbnail of image.ThumbnailSize pixels if they're missing, as a PNG.
+// The tiles of frames in progress that aren't done yet are transparent,
+// and the frames that are done are only kept until the next one is.
+func (c *Coordinator) servePreview(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	width, height := image.ThumbnailSize, image.ThumbnailSize
+	for _, size := range []struct {
+		name  string
+		value *int
+	}{{"width", &width}, {"height", &height}} {
+		if value := query.Get(size.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				http.Error(w, "the "+size.name+" must be a positive number", http.StatusBadRequest)
+				return
+			}
+			*size.value = n
+		}
+	}
+	c.mu.Lock()
+	frame := c.currentFrame()
+	if value := query.Get("frame"); value != "" {
+		var err error
+		if frame, err = strconv.Atoi(value); err != nil {
+			c.mu.Unlock()
+			http.Error(w, "the frame must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+	img := c.frames[frame]
+	if img == nil && c.last != nil && c.lastFrame == frame {
+		img = c.last
+	}
+	if img == nil {
+		c.mu.Unlock()
+		http.Error(w, "the frame has no tiles done or was done before the last one", http.StatusNotFound)
+		return
+	}
+	preview := img.Downsample(width, height)
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "image/png")
+	preview.EncodePNG(w)
+}
+
+// dashboardPage shows the frames of the job, the workers and the tiles of
+// the current frame, over a preview of it, updating them every second
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>goraytrace coordinator</title>
+<style>
+body { background: #222; color: #ddd; font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { padding: 0.2em 0.8em; text-align: right; }
+th { border-bottom: 1px solid #555; }
+#map { position: relative; display: inline-block; }
+#map img, #map canvas { display: block; width: 512px; image-rendering: pixelated; }
+#map img { position: absolute; top: 0; left: 0; }
+#map canvas { position: relative; }
+progress { width: 8em; }
+</style>
+</head>
+<body>
+<p id="summary">loading</p>
+<div id="map"><img id="preview" alt="" onload="this.hidden = false" onerror="this.hidden = true"><canvas id="tiles"></canvas></div>
+<h3>Frames</h3>
+<table><thead><tr><th>Frame</th><th>Done</th><th>Leased</th><th>Tiles</th><th></th></tr></thead><tbody id="frames"></tbody></table>
+<h3>Workers</h3>
+<table><thead><tr><th>Worker</th><th>Threads</th><th>Tiles</th><th>Pixels/s</th><th>Idle</th></tr></thead><tbody id="workers"></tbody></table>
+<script>
+const colors = {pending: "rgba(0, 0, 0, 0.5)", leased: "rgba(255, 200, 0, 0.35)", done: "rgba(0, 0, 0, 0)"};
+function row(cells) {
+	let tr = document.createElement("tr");
+	for (const cell of cells) {
+		let td = document.createElement("td");
+		if (cell instanceof Node) {
+			td.appendChild(cell);
+		} else {
+			td.textContent = cell;
+		}
+		tr.appendChild(td);
+	}
+	return tr;
+}
+function update() {
+	fetch("status").then(r => r.json()).then(s => {
+		let done = s.frames.reduce((n, f) => n + f.done, 0), tiles = s.frames.reduce((n, f) => n + f.tiles, 0);
+		document.getElementById("summary").textContent = (s.done ? "Done, " : "Rendering, ") + done + " of " + tiles +
+			" tiles in " + Math.round(s.elapsed) + " s, frame " + s.frame;
+		document.getElementById("frames").replaceChildren(...s.frames.map(f => {
+			let bar = document.createElement("progress");
+			bar.max = f.tiles;
+			bar.value = f.done;
+			return row([f.frame, f.done, f.leased, f.tiles, bar]);
+		}));
+		document.getElementById("workers").replaceChildren(...(s.workers || []).map(w =>
+			row([w.id, w.threads, w.tiles, Math.round(w.pixelspersecond), w.idle.toFixed(1) + " s"])));
+		let canvas = document.getElementById("tiles");
+		canvas.width = s.width;
+		canvas.height = s.height;
+		let context = canvas.getContext("2d");
+		for (const t of s.tiles) {
+			context.fillStyle = colors[t.state];
+			context.fillRect(t.x, t.y, t.width, t.height);
+			context.strokeStyle = "rgba(255, 255, 255, 0.15)";
+			context.strokeRect(t.x + 0.5, t.y + 0.5, t.width - 1, t.height - 1);
+		}
+		document.getElementById("preview").src = "preview.png?frame=" + s.frame + "&width=512&height=512&t=" + Date.now();
+	});
+}
+update();
+setInterval(update, 1000);
+</script>
+</body>
+</html>
+`
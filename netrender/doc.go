@@ -0,0 +1,86 @@
+/*
+Package netrender renders a scene, or the frames of its animation, on
+several machines at once. A coordinator splits the frames into tiles and
+hands them out over HTTP to the workers, which pull tiles, render them
+and send the pixels back until every frame is complete.
+
+Workers ask for work rather than being sent it, so they can join and
+leave at any time. A tile is leased to a worker for a while, which the
+worker renews while it's alive, and handed out again to another one if
+the lease expires before its pixels arrive, so the frames get done even
+if workers crash, lose their connection or are preempted, as spot
+instances are. The pixels of a tile that arrive once it's done, from a
+worker thought dead, are ignored. Workers retry the requests that fail
+for a while, so they survive the coordinator restarting behind the same
+address too.
+
+Coordinators reached from beyond localhost should be served behind
+auth.Require, over TLS, and their workers send the token and trust the
+certificate with the auth.Client of their Worker.
+
+The coordinator answers:
+
+	GET  /job     the job as JSON: "scene" is the scene in the scene file
+	              format, "animation" the animation in the animation file
+	              format or null for a still, and "width" and "height" the
+	              size of the frames. If the request accepts
+	              application/cbor, the job is in CBOR instead, and
+	              "scene" is a byte string holding the scene in CBOR, as
+	              scene.ParseSceneCBOR parses it. "encoding" is
+	              "x-delta-deflate" if the coordinator takes the pixels
+	              of the tiles compressed, and "lease" how many seconds
+	              the tiles are leased for.
+	POST /hello?worker=W
+	              the body is the capabilities of the worker as JSON: the
+	              optional "features" it was built with, such as "exr",
+	              the bytes of "memory" it may use, 0 if it doesn't know,
+	              and the "threads" it renders tiles in. It's 204 No
+	              Content if the worker meets the requirements of the
+	              job, and 403 Forbidden with the reason if it doesn't,
+	              so heavy scenes only go to the workers with the memory
+	              to hold them. Workers say hello once, before asking for
+	              work, and jobs with requirements turn down the workers
+	              that didn't.
+	POST /work?worker=W
+	              the next tile to render as JSON, with the "unit" and
+	              "lease" numbers to send its pixels with, the "frame" and
+	              the "x", "y", "width" and "height" of the tile. It's
+	              204 No Content if every tile left is leased, so the
+	              worker should ask again later, and 410 Gone once the job
+	              is done. W is a random id of the worker, which it renews
+	              its leases with. If the request accepts
+	              application/x-protobuf, the tile is the Tile message of
+	              proto/render.proto instead.
+	POST /heartbeat?worker=W
+	              renews the leases of the tiles leased to the worker W
+	              that aren't done. Workers post it every third of the
+	              lease.
+	POST /result?unit=U&lease=L
+	              the body is the width*height pixels of the tile in 8 bit
+	              RGBA, row after row, as image.NRGBA holds them. With
+	              the Content-Encoding x-delta-deflate, every byte of a
+	              pixel but the first of a row is replaced by its
+	              difference, modulo 256, with the same byte of the pixel
+	              on its left, and the bytes are compressed with DEFLATE
+	              (RFC 1951). The pixels of renders compress to a fraction
+	              of their size, since neighbours are alike.
+	POST /result  the body is the TileResult message of proto/render.proto,
+	              with the Content-Type application/x-protobuf: the unit
+	              and lease numbers, the pixels and "x-delta-deflate" in
+	              encoding if they are compressed. Workers send it for the
+	              tiles they got as Tile messages.
+
+Coordinators with a Dashboard serve browsers a page to watch the job too:
+
+	GET  /        the dashboard: the tiles done and leased of every
+	              frame, the tiles and the throughput of every worker,
+	              and the tiles of the current frame over a preview of
+	              it, updated every second
+	GET  /status  what the dashboard shows, as JSON
+	GET  /preview.png?frame=F&width=W&height=H
+	              the frame F, or the current one without F, scaled
+	              down to fit in W x H, or in 256 x 256 without them,
+	              with the tiles not done yet transparent. Only the
+	              frames in progress and the one done last have one.
+*/
+package netrender
@@ -0,0 +1,443 @@
+package netrender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	stdimg "image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/animation"
+	"github.com/ProjectMOA/goraytrace/auth"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/proto/renderpb"
+	"github.com/ProjectMOA/goraytrace/render"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func testScene() *scene.Scene {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Name: "ball", Position: math3d.Vector3{Z: 3}, Radius: 0.3})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -101, Z: 3}, Radius: 100})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	return s
+}
+
+func TestWorkersRenderTheFramesOfTheCoordinator(t *testing.T) {
+	a := &animation.Animation{FPS: 1, Frames: 4, Shapes: map[string]*animation.Track{
+		"ball": {Keys: []animation.Keyframe{{Time: 0, Position: math3d.Vector3{X: -0.5}}, {Time: 3, Position: math3d.Vector3{X: 0.5}}}}}}
+	frames := make(map[int]*image.Image)
+	c, err := NewCoordinator(Job{Scene: testScene(), Animation: a, First: 1, Last: 3, Width: 32, Height: 24, TileSize: 10},
+		50*time.Millisecond, func(frame int, img *image.Image) { frames[frame] = img })
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	// A worker that leases a tile and dies never sends it, so it's
+	// handed out again
+	response, err := http.Post(server.URL+"/work", "", nil)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("The coordinator should hand out a tile, it answered %v %v", response.Status, err)
+	}
+	response.Body.Close()
+	if err := Work(context.Background(), server.URL, render.Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("The job should be done once the workers stop")
+	}
+
+	local := testScene()
+	player, err := animation.NewPlayer(a, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for frame := 1; frame <= 3; frame++ {
+		player.SeekFrame(frame)
+		expected, _ := render.Scene(context.Background(), local, 32, 24, render.Options{Workers: 1})
+		if frames[frame] == nil || !bytes.Equal(frames[frame].Pix, expected.Pix) {
+			t.Errorf("Frame %d should be the same as a local render", frame)
+		}
+	}
+	if len(frames) != 3 {
+		t.Errorf("Only frames 1 to 3 should be rendered, not %d frames", len(frames))
+	}
+}
+
+func TestCoordinatorRejectsBadResults(t *testing.T) {
+	c, err := NewCoordinator(Job{Scene: testScene(), Width: 8, Height: 8}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c)
+	defer server.Close()
+	for _, test := range []struct {
+		query  string
+		pixels int
+	}{
+		{"unit=1&lease=1", 64},
+		{"unit=0&lease=1", 64},
+		{"unit=0&lease=0", 64},
+	} {
+		response, err := http.Post(server.URL+"/result?"+test.query, "", bytes.NewReader(make([]byte, 4*test.pixels)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s should be rejected, it was answered %s", test.query, response.Status)
+		}
+	}
+	if _, err := NewCoordinator(Job{Scene: testScene(), Width: 8, Height: 8, Last: 2}, 0, nil); err == nil {
+		t.Error("A still only has one frame")
+	}
+}
+
+func TestDashboardShowsTheProgressOfTheJob(t *testing.T) {
+	c, err := NewCoordinator(Job{Scene: testScene(), Width: 32, Height: 24, TileSize: 10}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c)
+	defer server.Close()
+	if response, err := http.Get(server.URL + "/status"); err != nil || response.StatusCode != http.StatusNotFound {
+		t.Fatalf("The dashboard should only be served when it's enabled, it was answered %v %v", response.Status, err)
+	}
+	c.Dashboard = true
+	if response, err := http.Get(server.URL + "/preview.png"); err != nil || response.StatusCode != http.StatusNotFound {
+		t.Fatalf("A frame without tiles done has no preview, it was answered %v %v", response.Status, err)
+	}
+	if err := Work(context.Background(), server.URL, render.Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status dashboardStatus
+	err = json.NewDecoder(response.Body).Decode(&status)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Done || len(status.Frames) != 1 || status.Frames[0].Tiles != 12 || status.Frames[0].Done != 12 {
+		t.Errorf("The status should show the 12 tiles of the frame done, it's %+v", status)
+	}
+	if len(status.Tiles) != 12 || status.Tiles[11] != (tileStatus{X: 30, Y: 20, Width: 2, Height: 4, State: tileDone}) {
+		t.Errorf("The status should hold the tiles of the frame, it holds %+v", status.Tiles)
+	}
+	if len(status.Workers) != 1 || status.Workers[0].Tiles != 12 || status.Workers[0].Threads != 2 {
+		t.Errorf("The status should show the worker that rendered the frame, it shows %+v", status.Workers)
+	}
+
+	response, err = http.Get(server.URL + "/preview.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, err := png.Decode(response.Body)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Bounds().Dx() != 32 || preview.Bounds().Dy() != 24 {
+		t.Errorf("A frame smaller than the thumbnails should be previewed whole, not as %v", preview.Bounds())
+	}
+	response, err = http.Get(server.URL + "/preview.png?width=16&height=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, err = png.Decode(response.Body)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Bounds().Dx() != 16 || preview.Bounds().Dy() != 12 {
+		t.Errorf("The preview should be scaled down to 16 x 12, not %v", preview.Bounds())
+	}
+	if response, err := http.Get(server.URL + "/preview.png?width=0"); err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("A preview must have a positive size, it was answered %v %v", response.Status, err)
+	}
+	if response, err := http.Get(server.URL + "/preview.png?frame=2"); err != nil || response.StatusCode != http.StatusNotFound {
+		t.Errorf("A frame out of the job has no preview, it was answered %v %v", response.Status, err)
+	}
+}
+
+func TestCoordinatorSendsTheJobInJSONOrCBOR(t *testing.T) {
+	a := &animation.Animation{FPS: 2, Frames: 3}
+	c, err := NewCoordinator(Job{Scene: testScene(), Animation: a, Last: 2, Width: 8, Height: 6}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c)
+	defer server.Close()
+	response, err := http.Get(server.URL + "/job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON jobMessage
+	err = json.NewDecoder(response.Body).Decode(&fromJSON)
+	response.Body.Close()
+	if err != nil || response.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Requests that don't accept CBOR should get JSON: %v", err)
+	}
+	fromCBOR, err := (&Worker{}).fetchJob(context.Background(), server.URL)
+	if err != nil || fromCBOR.SceneCBOR == nil {
+		t.Fatalf("Workers should get the job in CBOR: %v", err)
+	}
+	if len(fromCBOR.SceneCBOR) >= len(fromJSON.Scene) {
+		t.Errorf("The scene takes %d bytes in CBOR, and only %d in JSON", len(fromCBOR.SceneCBOR), len(fromJSON.Scene))
+	}
+	if fromCBOR.Width != 8 || fromCBOR.Height != 6 || fromCBOR.Animation.FPS != 2 || fromCBOR.Animation.Frames != 3 {
+		t.Errorf("Unexpected job %+v", fromCBOR)
+	}
+}
+
+func TestTilesAreCompressed(t *testing.T) {
+	rendered := testScene().TraceScene(40, 30)
+	compressed := encodeTile(rendered.Pix, 40)
+	if len(compressed) > len(rendered.Pix)/4 {
+		t.Errorf("The render should compress to a quarter of its %d bytes at most, not %d", len(rendered.Pix), len(compressed))
+	}
+	pixels, err := decodeTile(bytes.NewReader(compressed), 40, 30)
+	if err != nil || !bytes.Equal(pixels, rendered.Pix) {
+		t.Errorf("Decoding the tile should give its pixels back: %v", err)
+	}
+	if _, err := decodeTile(bytes.NewReader(compressed), 40, 20); err == nil {
+		t.Error("Tiles with too many pixels should be rejected")
+	}
+
+	// Coordinators take the pixels compressed or not
+	c, err := NewCoordinator(Job{Scene: testScene(), Width: 40, Height: 30}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c)
+	defer server.Close()
+	job, err := (&Worker{}).fetchJob(context.Background(), server.URL)
+	if err != nil || job.Encoding != tileEncoding {
+		t.Fatalf("The job should announce that tiles may be compressed: %v", err)
+	}
+	u, _, err := (&Worker{}).lease(context.Background(), server.URL, "test")
+	if err != nil || u == nil {
+		t.Fatalf("The coordinator should hand out the tile: %v", err)
+	}
+	for _, encoding := range []string{tileEncoding, "", "gzip"} {
+		body := rendered.Pix
+		if encoding == tileEncoding {
+			body = compressed
+		}
+		request, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/result?unit=%d&lease=%d", server.URL, u.Unit, u.Lease), bytes.NewReader(body))
+		request.Header.Set("Content-Encoding", encoding)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		want := http.StatusNoContent
+		if encoding == "gzip" {
+			want = http.StatusUnsupportedMediaType
+		}
+		if response.StatusCode != want {
+			t.Errorf("Pixels with the encoding %q should be answered %d, not %s", encoding, want, response.Status)
+		}
+	}
+}
+
+func TestWorkersExchangeTilesInProtobuf(t *testing.T) {
+	rendered := testScene().TraceScene(40, 30)
+	var done *image.Image
+	c, err := NewCoordinator(Job{Scene: testScene(), Width: 40, Height: 30, TileSize: 20}, 0, func(frame int, img *image.Image) { done = img })
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c)
+	defer server.Close()
+	for _, body := range [][]byte{[]byte("{not protobuf"), (&renderpb.TileResult{Unit: 7, Lease: 1, Rgba: rendered.Pix}).Marshal()} {
+		response, err := http.Post(server.URL+"/result", protobufType, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Invalid tile results should be rejected, %q was answered %s", body, response.Status)
+		}
+	}
+	for i, compress := range []bool{true, false, true, false} {
+		u, _, err := (&Worker{}).lease(context.Background(), server.URL, "test")
+		if err != nil || u == nil || !u.protobuf {
+			t.Fatalf("The coordinator should hand out the tile %d in protobuf: %v", i, err)
+		}
+		tile := stdimg.Rect(u.X, u.Y, u.X+u.Width, u.Y+u.Height)
+		if err := (&Worker{}).sendResult(context.Background(), server.URL, u, rendered, tile, compress); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, finished, _ := (&Worker{}).lease(context.Background(), server.URL, "test"); !finished || done == nil {
+		t.Fatal("The job should be done once the pixels of every tile arrive")
+	}
+	if !bytes.Equal(done.Pix, rendered.Pix) {
+		t.Error("The frame should be put together from the pixels in protobuf")
+	}
+}
+
+func TestLeasesLastWhileTheWorkerIsAlive(t *testing.T) {
+	c, err := NewCoordinator(Job{Scene: testScene(), Width: 8, Height: 8}, 100*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c)
+	defer server.Close()
+	u, _, err := (&Worker{}).lease(context.Background(), server.URL, "alive")
+	if err != nil || u == nil {
+		t.Fatalf("The coordinator should hand out the tile: %v", err)
+	}
+	// The worker takes three leases to render the tile, but keeps saying
+	// it's alive
+	alive, stop := context.WithCancel(context.Background())
+	go (&Worker{}).heartbeat(alive, server.URL, "alive", 30*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	if other, _, err := (&Worker{}).lease(context.Background(), server.URL, "other"); err != nil || other != nil {
+		t.Fatalf("The tile of a worker that's alive shouldn't be handed out again: %v", err)
+	}
+	// Once it dies, the tile is handed out again after the lease
+	stop()
+	time.Sleep(150 * time.Millisecond)
+	other, _, err := (&Worker{}).lease(context.Background(), server.URL, "other")
+	if err != nil || other == nil || other.Lease != 2 {
+		t.Fatalf("The tile of a dead worker should be handed out again: %v", err)
+	}
+}
+
+func TestWorkersRetryFailedRequests(t *testing.T) {
+	c, err := NewCoordinator(Job{Scene: testScene(), Width: 16, Height: 16, TileSize: 8}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The coordinator fails every other request, as one restarting behind
+	// a proxy does
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		fail := requests%2 == 1
+		mu.Unlock()
+		if fail {
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+			return
+		}
+		c.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	if err := Work(context.Background(), server.URL, render.Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("The job should be done despite the failed requests")
+	}
+}
+
+func TestCoordinatorOnlyTakesWorkersThatMeetTheRequirements(t *testing.T) {
+	c, err := NewCoordinator(Job{Scene: testScene(), Width: 16, Height: 16, TileSize: 8,
+		Requires: Requirements{Features: []string{FeatureEXR}, Memory: 16 << 30}}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(c)
+	defer server.Close()
+	for _, capabilities := range []Capabilities{
+		{Features: []string{FeatureEXR}, Memory: 8 << 30, Threads: 2},
+		{Memory: 32 << 30, Threads: 2},
+	} {
+		err := (&Worker{Capabilities: capabilities}).Work(context.Background(), server.URL, render.Options{Workers: 2})
+		if err == nil || !strings.Contains(err.Error(), "turned the worker down") {
+			t.Errorf("A worker with %+v should be turned down, not %v", capabilities, err)
+		}
+	}
+	if u, _, err := (&Worker{}).lease(context.Background(), server.URL, "unknown"); err == nil || u != nil {
+		t.Error("A worker that didn't say hello shouldn't get tiles of a job with requirements")
+	}
+	if len(c.Workers()) != 0 {
+		t.Errorf("No worker should have joined, not %v", c.Workers())
+	}
+
+	big := Capabilities{Features: []string{"denoise", FeatureEXR}, Memory: 64 << 30, Threads: 2}
+	if err := (&Worker{Capabilities: big}).Work(context.Background(), server.URL, render.Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("The worker that meets the requirements should do the job")
+	}
+	workers := c.Workers()
+	for _, capabilities := range workers {
+		if len(workers) != 1 || capabilities.Memory != big.Memory || capabilities.Threads != 2 {
+			t.Errorf("Only the big worker should have joined, not %v", workers)
+		}
+	}
+}
+
+func TestParseMemTotal(t *testing.T) {
+	contents := "MemTotal:       16318256 kB\nMemFree:         1234567 kB\n"
+	if memory := parseMemTotal(contents); memory != 16318256<<10 {
+		t.Errorf("The memory should be %d bytes, not %d", 16318256<<10, memory)
+	}
+	if memory := parseMemTotal("MemFree: 1 kB\n"); memory != 0 {
+		t.Errorf("Without a total the memory is unknown, not %d", memory)
+	}
+}
+
+func TestWorkersSendTheTokenOverTLS(t *testing.T) {
+	c, err := NewCoordinator(Job{Scene: testScene(), Width: 16, Height: 16, TileSize: 8}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(auth.Require("secret", c))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, certificate, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	trusting, err := auth.Client("", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Worker{Client: trusting}).Work(context.Background(), server.URL, render.Options{Workers: 1}); err == nil {
+		t.Error("A worker without the token shouldn't get the job")
+	}
+	client, err := auth.Client("secret", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Worker{Client: client}).Work(context.Background(), server.URL, render.Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("The worker with the token should do the job")
+	}
+}
@@ -0,0 +1,55 @@
+package netrender
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// tileEncoding is the content coding of the pixels of the tiles that
+// workers compress, which coordinators announce in the job. Every byte of
+// a pixel is sent as its difference with the same byte of the pixel on
+// its left, as PNG's Sub filter does, since neighbouring pixels of
+// renders are alike, and the differences are compressed with DEFLATE.
+const tileEncoding = "x-delta-deflate"
+
+// encodeTile returns the pixels of a tile width pixels wide, in 8 bit
+// RGBA, compressed as tileEncoding says
+func encodeTile(pixels []byte, width int) []byte {
+	deltas := make([]byte, len(pixels))
+	for i := range pixels {
+		if i%(4*width) < 4 {
+			deltas[i] = pixels[i]
+		} else {
+			deltas[i] = pixels[i] - pixels[i-4]
+		}
+	}
+	var compressed bytes.Buffer
+	w, _ := flate.NewWriter(&compressed, flate.DefaultCompression)
+	w.Write(deltas)
+	w.Close()
+	return compressed.Bytes()
+}
+
+// decodeTile returns the pixels of a width x height tile compressed by
+// encodeTile, reading no more than the tile holds from r
+func decodeTile(r io.Reader, width, height int) ([]byte, error) {
+	size := 4 * width * height
+	// DEFLATE grows incompressible data by a few bytes every block at most
+	r = io.LimitReader(r, int64(size+size/16+1024))
+	pixels, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(r), int64(size+1)))
+	if err != nil {
+		return nil, err
+	}
+	if len(pixels) != size {
+		return nil, fmt.Errorf("the pixels don't fill the tile")
+	}
+	for i := range pixels {
+		if i%(4*width) >= 4 {
+			pixels[i] += pixels[i-4]
+		}
+	}
+	return pixels, nil
+}
@@ -0,0 +1,351 @@
+package netrender
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	stdimg "image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/animation"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/proto/renderpb"
+	"github.com/ProjectMOA/goraytrace/render"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// pollInterval is how long workers wait before asking for work again when
+// every tile left is leased
+const pollInterval = 500 * time.Millisecond
+
+// Workers send the requests that fail up to retries times, waiting
+// retryDelay before the second time and twice as long before every other
+// one, so they ride out the coordinator or the network being down for
+// half a minute
+const (
+	retries    = 8
+	retryDelay = 250 * time.Millisecond
+)
+
+// Worker renders the tiles of the jobs of coordinators
+type Worker struct {
+	// Capabilities are what the worker tells the coordinator it can do
+	Capabilities Capabilities
+	// Client sends the requests of the worker, or http.DefaultClient if
+	// it's nil, such as an auth.Client for coordinators that require a
+	// token or serve TLS with a certificate of their own
+	Client *http.Client
+}
+
+// Work renders tiles of the job of the coordinator at url until it's
+// done, in as many goroutines as the options say, as a Worker with the
+// LocalCapabilities of the goroutines
+func Work(ctx context.Context, url string, opts render.Options) error {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = render.DefaultWorkers()
+	}
+	w := &Worker{Capabilities: LocalCapabilities(workers)}
+	return w.Work(ctx, url, opts)
+}
+
+// Work renders tiles of the job of the coordinator at url until it's
+// done, in as many goroutines as the options say. Every goroutine poses
+// its own copy of the scene, since they may render different frames. It
+// stops asking for tiles when the context is done, and returns its error.
+// The coordinator hands the tiles leased then to other workers. While it
+// works, it tells the coordinator it's alive, so its tiles are only handed
+// out again if it dies, however long they take. It joins the job with its
+// capabilities, and returns an error if the coordinator turns it down.
+func (w *Worker) Work(ctx context.Context, url string, opts render.Options) error {
+	url = strings.TrimSuffix(url, "/")
+	job, err := w.fetchJob(ctx, url)
+	if err != nil {
+		return err
+	}
+	id := workerID()
+	if err := w.hello(ctx, url, id); err != nil {
+		return err
+	}
+	if job.Lease > 0 {
+		alive, stop := context.WithCancel(ctx)
+		defer stop()
+		go w.heartbeat(alive, url, id, time.Duration(job.Lease*float64(time.Second))/3)
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = render.DefaultWorkers()
+	}
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = w.work(ctx, url, id, job)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchJob asks the coordinator for the job, in CBOR since it's smaller
+// than JSON. Coordinators that only send JSON are fine too.
+func (w *Worker) fetchJob(ctx context.Context, url string) (*jobMessage, error) {
+	response, err := w.do(ctx, func() (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodGet, url+"/job", nil)
+		if err == nil {
+			request.Header.Set("Accept", cborType+", application/json")
+		}
+		return request, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the coordinator answered %s", response.Status)
+	}
+	if response.Header.Get("Content-Type") == cborType {
+		data, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		return unmarshalJobCBOR(data)
+	}
+	job := &jobMessage{}
+	return job, json.NewDecoder(response.Body).Decode(job)
+}
+
+// hello tells the coordinator the capabilities of the worker with the id.
+// Coordinators that don't know about capabilities take every worker.
+func (w *Worker) hello(ctx context.Context, url, id string) error {
+	body, err := json.Marshal(w.Capabilities)
+	if err != nil {
+		return err
+	}
+	response, err := w.post(ctx, url+"/hello?worker="+id, body, "application/json", "")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil
+	case http.StatusForbidden:
+		reason, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxHello))
+		return fmt.Errorf("the coordinator turned the worker down: %s", strings.TrimSpace(string(reason)))
+	}
+	return fmt.Errorf("the coordinator answered %s", response.Status)
+}
+
+// work renders tiles of the job until it's done, as the worker with the id
+func (w *Worker) work(ctx context.Context, url, id string, job *jobMessage) error {
+	var s *scene.Scene
+	var err error
+	if job.SceneCBOR != nil {
+		s, _, err = scene.ParseSceneCBOR(job.SceneCBOR, scene.Lenient)
+	} else {
+		s, _, err = scene.ParseScene(job.Scene, scene.Lenient)
+	}
+	if err != nil {
+		return err
+	}
+	var player *animation.Player
+	if job.Animation != nil {
+		if player, err = animation.NewPlayer(job.Animation, s); err != nil {
+			return err
+		}
+	}
+	frame := image.New(job.Width, job.Height)
+	posed := -1
+	for {
+		u, done, err := w.lease(ctx, url, id)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if u == nil {
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		if player != nil && u.Frame != posed {
+			player.SeekFrame(u.Frame)
+			posed = u.Frame
+		}
+		tile := stdimg.Rect(u.X, u.Y, u.X+u.Width, u.Y+u.Height)
+		s.TraceRegion(frame, tile)
+		if err := w.sendResult(ctx, url, u, frame, tile, job.Encoding == tileEncoding); err != nil {
+			return err
+		}
+	}
+}
+
+// lease asks the coordinator for a tile for the worker with the id, as a
+// renderpb.Tile since it's smaller than JSON. Coordinators that only send
+// JSON are fine too. It's nil if there's none to render now, and done is
+// true if there will be no more.
+func (w *Worker) lease(ctx context.Context, url, id string) (*unitMessage, bool, error) {
+	response, err := w.do(ctx, func() (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodPost, url+"/work?worker="+id, nil)
+		if err == nil {
+			request.Header.Set("Accept", protobufType+", application/json")
+		}
+		return request, err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusGone:
+		return nil, true, nil
+	case http.StatusNoContent:
+		return nil, false, nil
+	case http.StatusOK:
+		if response.Header.Get("Content-Type") == protobufType {
+			data, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				return nil, false, err
+			}
+			var tile renderpb.Tile
+			if err := tile.Unmarshal(data); err != nil {
+				return nil, false, err
+			}
+			return &unitMessage{Unit: int(tile.Unit), Lease: int(tile.Lease), Frame: int(tile.Frame), X: int(tile.X), Y: int(tile.Y),
+				Width: int(tile.Width), Height: int(tile.Height), protobuf: true}, false, nil
+		}
+		u := &unitMessage{}
+		return u, false, json.NewDecoder(response.Body).Decode(u)
+	}
+	return nil, false, fmt.Errorf("the coordinator answered %s", response.Status)
+}
+
+// sendResult sends the pixels of the tile of frame to the coordinator,
+// compressed if compress is true, in a renderpb.TileResult if the tile
+// came as a renderpb.Tile
+func (w *Worker) sendResult(ctx context.Context, url string, u *unitMessage, frame *image.Image, tile stdimg.Rectangle, compress bool) error {
+	pixels := make([]byte, 0, 4*tile.Dx()*tile.Dy())
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		start := frame.PixOffset(tile.Min.X, y)
+		pixels = append(pixels, frame.Pix[start:start+4*tile.Dx()]...)
+	}
+	encoding := ""
+	if compress {
+		pixels, encoding = encodeTile(pixels, tile.Dx()), tileEncoding
+	}
+	var response *http.Response
+	var err error
+	if u.protobuf {
+		result := &renderpb.TileResult{Unit: int32(u.Unit), Lease: int32(u.Lease), Rgba: pixels, Encoding: encoding}
+		response, err = w.post(ctx, url+"/result", result.Marshal(), protobufType, "")
+	} else {
+		response, err = w.post(ctx, fmt.Sprintf("%s/result?unit=%d&lease=%d", url, u.Unit, u.Lease), pixels, "application/octet-stream", encoding)
+	}
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("the coordinator answered %s", response.Status)
+	}
+	return nil
+}
+
+// heartbeat tells the coordinator that the worker with the id is alive
+// every interval until the context is done, so that the leases of its
+// tiles don't expire while it renders them
+func (w *Worker) heartbeat(ctx context.Context, url, id string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// The leases outlast a few missed beats
+			if response, err := w.post(ctx, url+"/heartbeat?worker="+id, nil, "", ""); err == nil {
+				response.Body.Close()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// post sends body of the content type to url, as http.Post does, with the
+// content encoding if it isn't empty, retrying as do does
+func (w *Worker) post(ctx context.Context, url string, body []byte, contentType, encoding string) (*http.Response, error) {
+	return w.do(ctx, func() (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			request.Header.Set("Content-Type", contentType)
+		}
+		if encoding != "" {
+			request.Header.Set("Content-Encoding", encoding)
+		}
+		return request, nil
+	})
+}
+
+// do sends the request that newRequest makes until the context is done,
+// and again after network errors and the server errors of a coordinator
+// in trouble, up to retries times, returning the last answer
+func (w *Worker) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		request, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		response, err := w.client().Do(request.WithContext(ctx))
+		if err == nil && response.StatusCode < http.StatusInternalServerError || attempt == retries || ctx.Err() != nil {
+			return response, err
+		}
+		if err == nil {
+			response.Body.Close()
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// client returns the client of the worker
+func (w *Worker) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return http.DefaultClient
+}
+
+// workerID returns a random id that tells the worker from the others
+func workerID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,369 @@
+// Package pbrt writes scenes in the format of PBRT-v4, so that renders can
+// be checked against a reference renderer:
+//
+//	goraytrace -pbrt main.pbrt scene.json
+//	pbrt main.pbrt
+//
+// The camera, shapes, lights, materials, medium and integrator are written
+// as their nearest equivalent in pbrt. Not everything has an exact one,
+// such as point lights, which don't fall off with distance here, so Export
+// returns a warning for everything pbrt renders differently.
+//
+// The rows of the renders of goraytrace go from the bottom of the view to
+// the top, so the view of the exported scene is mirrored vertically for
+// both renders to line up pixel for pixel.
+package pbrt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/medium"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// mediumName is the name of the medium that fills the scene
+const mediumName = "medium"
+
+// exporter writes a scene, collecting the warnings
+type exporter struct {
+	buf      bytes.Buffer
+	warnings []string
+	warned   map[string]bool
+}
+
+// Export writes the scene as a PBRT-v4 scene that renders a width x height
+// image to the file filename, and returns what pbrt renders differently
+func Export(w io.Writer, s *scene.Scene, width, height int, filename string) ([]string, error) {
+	e := &exporter{warned: make(map[string]bool)}
+	e.printf("# Exported from goraytrace\n\n")
+	e.camera(s, width, height)
+	e.settings(s, width, height, filename)
+	e.printf("\nWorldBegin\n")
+	if s.Medium != nil {
+		e.printf("MediumInterface %s %s\n", quote(mediumName), quote(mediumName))
+	}
+	for _, l := range s.Lights {
+		e.light(l)
+	}
+	for i, sh := range s.Shapes {
+		// pbrt loads the geometry of delayed shapes up front
+		if d, ok := sh.(*scene.Delayed); ok {
+			for _, loaded := range d.Shapes() {
+				e.shape(loaded, shape.NameOf(sh, i))
+			}
+			continue
+		}
+		e.shape(sh, shape.NameOf(sh, i))
+	}
+	if s.Section != nil {
+		e.warn("the section was left out")
+	}
+	if len(s.Volumes) > 0 {
+		e.warn("the volumes were left out")
+	}
+	_, err := w.Write(e.buf.Bytes())
+	return e.warnings, err
+}
+
+func (e *exporter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&e.buf, format, args...)
+}
+
+// warn records the warning once, however many times it's found
+func (e *exporter) warn(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	if !e.warned[warning] {
+		e.warned[warning] = true
+		e.warnings = append(e.warnings, warning)
+	}
+}
+
+// camera writes the transform and the camera of the view
+func (e *exporter) camera(s *scene.Scene, width, height int) {
+	c := &s.Camera
+	if math.Abs(c.Up.DotV(c.Towards)) > 1e-6 || c.Up.CrossV(c.Towards).NormalizedV().DotV(c.Right.NormalizedV()) < 1-1e-6 ||
+		math.Abs(c.Up.Abs()-1) > 1e-6 || math.Abs(c.Right.Abs()-1) > 1e-6 {
+		e.warn("the camera vectors aren't orthonormal, so the view is only approximated")
+	}
+	if c.Projection == camera.Equirectangular {
+		e.warn("the equirectangular camera was exported as a perspective one")
+		perspective := *c
+		perspective.Projection, perspective.Stereo = camera.Perspective, ""
+		c = &perspective
+	}
+	target := c.FocalPoint.AddV(c.Towards)
+	e.printf("Scale 1 -1 1\n")
+	e.printf("LookAt %s  %s  %s\n", vector(c.FocalPoint), vector(target), vector(c.Up))
+	// The view plane is ViewPlaneDistance away, and its pixels are
+	// PixelSize wide. pbrt takes the field of view along the shorter side
+	// of the image.
+	tangent := c.PixelSize(width, height) * float64(height) / 2 / c.ViewPlaneDistance
+	if width < height {
+		tangent *= float64(width) / float64(height)
+	}
+	fov := 2 * math.Atan(tangent) * 180 / math.Pi
+	if s.Medium != nil {
+		e.medium(s.Medium)
+		e.printf("MediumInterface %s %s\n", quote(mediumName), quote(mediumName))
+	}
+	e.printf("Camera \"perspective\" \"float fov\" %s\n", floats(fov))
+}
+
+// settings writes the sampler, the film and the integrator
+func (e *exporter) settings(s *scene.Scene, width, height int, filename string) {
+	settings := &s.Settings
+	if settings.Backfaces == scene.Cull {
+		e.warn("pbrt doesn't cull back faces")
+	}
+	if settings.AdaptiveThreshold > 0 {
+		e.warn("pbrt takes all the samples of every pixel rather than sampling adaptively")
+	}
+	e.printf("Sampler \"independent\" \"integer pixelsamples\" %s\n", ints(settings.Samples))
+	e.printf("Film \"rgb\" \"integer xresolution\" %s \"integer yresolution\" %s \"string filename\" %s\n",
+		ints(width), ints(height), quote(filename))
+	path := "path"
+	if s.Medium != nil {
+		path = "volpath"
+	}
+	switch settings.Integrator {
+	case scene.Bidirectional:
+		if s.Medium != nil {
+			e.warn("pbrt's bidirectional path tracer ignores the medium")
+		}
+		e.printf("Integrator \"bdpt\" \"integer maxdepth\" %s\n", ints(settings.MaxDepth))
+	case scene.AmbientOcclusion:
+		e.printf("Integrator \"ambientocclusion\" \"float maxdistance\" %s \"bool cossample\" true\n", floats(settings.AODistance))
+	case scene.FixedPath:
+		if settings.MinDepth > 0 {
+			e.warn("pbrt can't leave out the paths shorter than the minimum depth %d", settings.MinDepth)
+		}
+		e.printf("Integrator %s \"integer maxdepth\" %s\n", quote(path), ints(settings.MaxDepth))
+	case scene.Toon, scene.HiddenLine:
+		e.warn("pbrt has no %s integrator, so the scene was exported with direct lighting", settings.Integrator)
+		e.printf("Integrator %s \"integer maxdepth\" %s\n", quote(path), ints(1))
+	default:
+		if scene.RegisteredIntegrator(settings.Integrator) {
+			e.warn("pbrt has no %s integrator, so the scene was exported with direct lighting", settings.Integrator)
+		} else if settings.Photons > 0 {
+			e.warn("pbrt's direct lighting has no caustics from photons")
+		}
+		e.printf("Integrator %s \"integer maxdepth\" %s\n", quote(path), ints(1))
+	}
+}
+
+// medium writes the medium that fills the scene
+func (e *exporter) medium(m *medium.Homogeneous) {
+	e.printf("MakeNamedMedium %s \"string type\" \"homogeneous\" \"rgb sigma_a\" %s \"rgb sigma_s\" %s \"float scale\" 1 \"float g\" %s\n",
+		quote(mediumName), color(m.Absorption), color(m.Scattering), floats(m.G))
+}
+
+// light writes the light
+func (e *exporter) light(l lighting.Light) {
+	switch l := l.(type) {
+	case *lighting.PointLight:
+		e.warn("point lights don't fall off with distance in goraytrace, but they do in pbrt")
+		if l.Profile != nil {
+			e.warn("the IES profiles of the point lights were left out")
+		}
+		e.printf("LightSource \"point\" \"point3 from\" %s \"rgb I\" %s\n", floats(l.Position.X, l.Position.Y, l.Position.Z), color(tinted(l.Intensity, l.Temperature)))
+	case *lighting.SphereLight:
+		e.printf("AttributeBegin\n")
+		e.printf("  Translate %s\n", vector(l.Position))
+		e.printf("  AreaLightSource \"diffuse\" \"rgb L\" %s \"bool twosided\" %t\n", color(tinted(l.Radiance, l.Temperature)), l.TwoSided)
+		// Lights don't reflect light in goraytrace
+		e.printf("  Material \"diffuse\" \"rgb reflectance\" [ 0 0 0 ]\n")
+		e.printf("  Shape \"sphere\" \"float radius\" %s\n", floats(l.Radius))
+		e.printf("AttributeEnd\n")
+	case *lighting.SunLight:
+		if l.AngularRadius > 0 {
+			e.warn("pbrt's distant lights cast sharp shadows, unlike the sun")
+		}
+		e.printf("LightSource \"distant\" \"point3 from\" %s \"point3 to\" [ 0 0 0 ] \"rgb L\" %s\n",
+			floats(l.Direction.X, l.Direction.Y, l.Direction.Z), color(l.Irradiance))
+	default:
+		e.warn("lights of type %T were left out", l)
+	}
+}
+
+// shape writes the shape and its material
+func (e *exporter) shape(sh shape.Shape, name string) {
+	e.printf("AttributeBegin\n")
+	e.printf("  # %s\n", name)
+	e.material(shape.MaterialOf(sh))
+	if t, ok := sh.(*shape.MeshTriangle); ok {
+		sh = t.Triangle()
+	}
+	switch sh := sh.(type) {
+	case *shape.Sphere:
+		if sh.Opacity != nil {
+			e.warn("the opacity maps were left out")
+		}
+		e.printf("  Translate %s\n", vector(sh.Position))
+		e.printf("  Shape \"sphere\" \"float radius\" %s\n", floats(sh.Radius))
+	case *shape.Curve:
+		// pbrt interpolates the width linearly between the ends, which is
+		// what the Bézier widths do if the inner ones are a third of the way
+		w := sh.Widths
+		if math.Abs(w[1]-(2*w[0]+w[3])/3) > 1e-9 || math.Abs(w[2]-(w[0]+2*w[3])/3) > 1e-9 {
+			e.warn("pbrt interpolates the widths of curves linearly between their ends")
+		}
+		points := make([]string, len(sh.Points))
+		for i, p := range sh.Points {
+			points[i] = numbers(p.X, p.Y, p.Z)
+		}
+		e.printf("  Shape \"curve\" \"string type\" \"cylinder\" \"point3 P\" [ %s ] \"float width0\" %s \"float width1\" %s\n",
+			strings.Join(points, "  "), floats(w[0]), floats(w[3]))
+	case *shape.Heightfield:
+		e.heightfield(sh)
+	case *shape.Triangle:
+		if sh.Opacity != nil {
+			e.warn("the opacity maps were left out")
+		}
+		if sh.Velocities != [3]math3d.Vector3{} {
+			e.warn("the velocities of the triangles were left out, so they don't blur")
+		}
+		if sh.Colors != [3]image.Color{} {
+			e.warn("the colors of the vertices were left out")
+		}
+		v := sh.Vertices
+		e.printf("  Shape \"trianglemesh\" \"point3 P\" [ %s  %s  %s ] \"integer indices\" [ 0 1 2 ]", vector(v[0]), vector(v[1]), vector(v[2]))
+		if n := sh.Normals; n != [3]math3d.Vector3{} {
+			e.printf(" \"normal N\" [ %s  %s  %s ]", vector(n[0]), vector(n[1]), vector(n[2]))
+		}
+		e.printf("\n")
+	default:
+		e.warn("shapes of type %T were left out", sh)
+	}
+	e.printf("AttributeEnd\n")
+}
+
+// heightfield writes the triangles of the heightfield as a mesh, with the
+// normals it's shaded with
+func (e *exporter) heightfield(h *shape.Heightfield) {
+	columns, rows := h.Samples()
+	var points, normals, indices bytes.Buffer
+	for j := 0; j < rows; j++ {
+		for i := 0; i < columns; i++ {
+			p, n := h.Vertex(i, j)
+			fmt.Fprintf(&points, " %s", numbers(p.X, p.Y, p.Z))
+			fmt.Fprintf(&normals, " %s", numbers(n.X, n.Y, n.Z))
+		}
+	}
+	for j := 0; j+1 < rows; j++ {
+		for i := 0; i+1 < columns; i++ {
+			a, b, c, d := j*columns+i, j*columns+i+1, (j+1)*columns+i, (j+1)*columns+i+1
+			fmt.Fprintf(&indices, " %d %d %d %d %d %d", a, b, d, a, d, c)
+		}
+	}
+	e.printf("  Shape \"trianglemesh\"\n    \"point3 P\" [%s ]\n    \"normal N\" [%s ]\n    \"integer indices\" [%s ]\n",
+		points.String(), normals.String(), indices.String())
+}
+
+// material writes the material
+func (e *exporter) material(m material.Material) {
+	switch m := m.(type) {
+	case *material.Lambertian:
+		e.printf("  Material \"diffuse\" \"rgb reflectance\" %s\n", color(m.Albedo))
+	case *material.Glossy:
+		// The roughness of the Beckmann distribution whose lobe is the
+		// nearest to the Phong lobe of the exponent
+		e.warn("glossy materials are approximated with rough conductors")
+		roughness := math.Sqrt(2 / (m.Exponent + 2))
+		e.printf("  Material \"conductor\" \"rgb reflectance\" %s \"float roughness\" %s \"bool remaproughness\" false\n",
+			color(m.Albedo), floats(roughness))
+	case *material.Subsurface:
+		e.warn("subsurface materials have a glossy dielectric boundary in pbrt")
+		e.printf("  Material \"subsurface\" \"rgb reflectance\" %s \"rgb mfp\" %s\n", color(m.Albedo), color(m.MeanFreePath))
+	case *material.Dielectric:
+		if m.Albedo != image.White {
+			e.warn("the albedo of dielectric materials was left out")
+		}
+		if m.Dispersive() {
+			e.warn("dielectric materials don't disperse light in pbrt with a constant index of refraction")
+		}
+		e.printf("  Material \"dielectric\" \"float eta\" %s\n", floats(m.IOR))
+	case *material.Standard:
+		// The roughnesses are squared into the widths of the distributions,
+		// as pbrt takes them without remapping
+		e.warn("standard materials are approximated with pbrt's diffuse, conductor and coated materials")
+		if m.ThinFilmThickness > 0 {
+			e.warn("the thin films of standard materials were left out")
+		}
+		roughness := floats(m.Roughness * m.Roughness)
+		switch {
+		case m.Metalness >= 0.5 && m.Coat > 0:
+			e.printf("  Material \"coatedconductor\" \"rgb reflectance\" %s \"float conductor.roughness\" %s "+
+				"\"float interface.roughness\" %s \"float interface.eta\" %s \"bool remaproughness\" false\n",
+				color(m.Albedo), roughness, floats(m.CoatRoughness*m.CoatRoughness), floats(m.CoatIOR))
+		case m.Metalness >= 0.5:
+			e.printf("  Material \"conductor\" \"rgb reflectance\" %s \"float roughness\" %s \"bool remaproughness\" false\n",
+				color(m.Albedo), roughness)
+		default:
+			e.printf("  Material \"coateddiffuse\" \"rgb reflectance\" %s \"float roughness\" %s \"float eta\" %s "+
+				"\"bool remaproughness\" false\n", color(m.Albedo), roughness, floats(m.IOR))
+		}
+	default:
+		e.warn("materials of type %T were written as white diffuse ones", m)
+		e.printf("  Material \"diffuse\"\n")
+	}
+}
+
+// numbers returns the numbers separated by spaces, as short as they can
+// be written without rounding them
+func numbers(values ...float64) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		if v == 0 {
+			// Without the sign of negative zeros
+			v = 0
+		}
+		formatted[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(formatted, " ")
+}
+
+// floats returns the parameter value holding the numbers
+func floats(values ...float64) string {
+	return "[ " + numbers(values...) + " ]"
+}
+
+func ints(values ...int) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = strconv.Itoa(v)
+	}
+	return "[ " + strings.Join(formatted, " ") + " ]"
+}
+
+func vector(v math3d.Vector3) string {
+	return numbers(v.X, v.Y, v.Z)
+}
+
+func color(c image.Color) string {
+	return floats(c.R, c.G, c.B)
+}
+
+// tinted returns the color of a light tinted by its temperature, as pbrt's
+// blackbody spectra are normalized differently
+func tinted(c image.Color, kelvin float64) image.Color {
+	if kelvin <= 0 {
+		return c
+	}
+	blackbody := image.Blackbody(kelvin)
+	return *c.CMultiply(&blackbody)
+}
+
+func quote(s string) string {
+	return strconv.Quote(s)
+}
@@ -0,0 +1,96 @@
+package pbrt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/medium"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// parameter returns the numbers in the brackets after the name of the
+// parameter
+func parameter(t *testing.T, exported, name string) []string {
+	start := strings.Index(exported, name)
+	if start < 0 {
+		t.Fatalf("The export has no %s", name)
+	}
+	rest := exported[start+len(name):]
+	open, end := strings.Index(rest, "["), strings.Index(rest, "]")
+	return strings.Fields(rest[open+1 : end])
+}
+
+func TestExport(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100, Name: "floor"})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 1}, Radius: 1,
+		Material: &material.Glossy{Albedo: image.Color{R: 0.8, G: 0.8, B: 0.8}, Exponent: 98}})
+	s.AddShape(shape.NewHeightfield(math3d.Vector3{X: -2, Z: 2}, math3d.Vector3{X: 4, Y: 1, Z: 4}, 4, 3,
+		func(x, z float64) float64 { return x * z }))
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{Y: 4}, Radius: 0.5, Radiance: image.Color{R: 10, G: 9, B: 8}})
+	s.Settings.Samples = 64
+	s.Settings.Integrator = scene.Bidirectional
+	s.Settings.MaxDepth = 7
+
+	var out bytes.Buffer
+	warnings, err := Export(&out, s, 400, 300, "main.exr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := out.String()
+	for _, expected := range []string{
+		"Scale 1 -1 1\nLookAt 0 0 -1.5  0 0 -0.5  0 1 0\n",
+		`Integrator "bdpt" "integer maxdepth" [ 7 ]`,
+		`"integer pixelsamples" [ 64 ]`,
+		`"integer xresolution" [ 400 ] "integer yresolution" [ 300 ] "string filename" "main.exr"`,
+		"  # floor\n  Material \"diffuse\" \"rgb reflectance\" [ 1 1 1 ]\n  Translate 0 -100 0\n",
+		`AreaLightSource "diffuse" "rgb L" [ 10 9 8 ] "bool twosided" false`,
+		// The Beckmann roughness of the Phong exponent 98 is sqrt(2/100)
+		`Material "conductor" "rgb reflectance" [ 0.8 0.8 0.8 ] "float roughness" [ 0.1414213562373095 ]`,
+	} {
+		if !strings.Contains(exported, expected) {
+			t.Errorf("The export should contain %q:\n%s", expected, exported)
+		}
+	}
+	// The default field of view is 20° vertically, the shorter side
+	if fov := parameter(t, exported, `"float fov"`); len(fov) != 1 || !strings.HasPrefix(fov[0], "20.00000") {
+		t.Errorf("The field of view should be 20 degrees, it's %v", fov)
+	}
+	if points, indices := parameter(t, exported, `"point3 P"`), parameter(t, exported, `"integer indices"`); len(points) != 3*4*3 || len(indices) != 6*3*2 {
+		t.Errorf("The heightfield should have 12 points and 12 triangles, it has %d coordinates and %d indices", len(points), len(indices))
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "glossy") {
+		t.Errorf("Only the glossy material should be approximated, the warnings are %q", warnings)
+	}
+}
+
+func TestExportWarnsOfWhatPBRTRendersDifferently(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Curve{Widths: [4]float64{0.1, 0.2, 0.2, 0.1}})
+	s.AddLight(&lighting.PointLight{Intensity: image.White})
+	s.Medium = &medium.Homogeneous{Absorption: image.Color{R: 0.1, G: 0.1, B: 0.1}}
+	s.Settings.Integrator = scene.FixedPath
+	s.Settings.MinDepth = 2
+	s.Settings.AdaptiveThreshold = 0.01
+
+	var out bytes.Buffer
+	warnings, err := Export(&out, s, 100, 100, "main.exr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 4 {
+		t.Errorf("There should be a warning for the widths of the curve, the point light, the minimum depth and the adaptive sampling, there are %q", warnings)
+	}
+	exported := out.String()
+	for _, expected := range []string{`MakeNamedMedium "medium"`, `Integrator "volpath" "integer maxdepth" [ 5 ]`, `LightSource "point" "point3 from" [ 0 0 0 ]`, `Shape "curve"`} {
+		if !strings.Contains(exported, expected) {
+			t.Errorf("The export should contain %q:\n%s", expected, exported)
+		}
+	}
+}
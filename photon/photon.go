@@ -0,0 +1,168 @@
+// Package photon stores the photons traced from the lights in a map that
+// finds the ones nearest to a point, to estimate the light arriving there.
+package photon
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Photon is a packet of light that arrived at a surface
+type Photon struct {
+	Position math3d.Vector3
+	// Direction is the unit vector towards where the photon came from
+	Direction math3d.Vector3
+	// Power is the flux that the photon carries
+	Power image.Color
+}
+
+// Map is a kd-tree over photons that finds the photons nearest to a point.
+// The tree is balanced and stored implicitly: the photon in the middle of
+// a range of photons splits the rest of the range along an axis, the
+// photons before it are on the lower side and the ones after it on the
+// upper side.
+type Map struct {
+	photons []Photon
+	// axes holds the axis each photon splits its range along
+	axes []uint8
+}
+
+// NewMap builds a photon map over the photons, which it reorders
+func NewMap(photons []Photon) *Map {
+	m := &Map{photons: photons, axes: make([]uint8, len(photons))}
+	m.build(0, len(photons))
+	return m
+}
+
+// Size returns the number of photons in the map
+func (m *Map) Size() int {
+	return len(m.photons)
+}
+
+// build splits photons[first:end] by the median along the axis where they
+// spread most, and the two halves after it
+func (m *Map) build(first, end int) {
+	if end-first <= 1 {
+		return
+	}
+	bounds := math3d.EmptyAABB()
+	for i := first; i < end; i++ {
+		bounds = bounds.Expand(&m.photons[i].Position)
+	}
+	extent := bounds.Max.SubtractV(bounds.Min)
+	axis := uint8(2)
+	if extent.X >= extent.Y && extent.X >= extent.Z {
+		axis = 0
+	} else if extent.Y >= extent.Z {
+		axis = 1
+	}
+	slice := m.photons[first:end]
+	sort.Slice(slice, func(a, b int) bool {
+		return component(&slice[a].Position, axis) < component(&slice[b].Position, axis)
+	})
+	middle := first + (end-first)/2
+	m.axes[middle] = axis
+	m.build(first, middle)
+	m.build(middle+1, end)
+}
+
+// Nearest returns the k photons nearest to point that are closer than
+// maxDistance, and the radius of the sphere around point that holds them.
+// It's the distance to the farthest one if there are k of them, and
+// maxDistance if there are fewer.
+func (m *Map) Nearest(point *math3d.Vector3, k int, maxDistance float64) ([]*Photon, float64) {
+	if k <= 0 {
+		return nil, maxDistance
+	}
+	q := &queue{maxDistance2: maxDistance * maxDistance, k: k}
+	m.search(point, 0, len(m.photons), q)
+	photons := make([]*Photon, len(q.items))
+	for i, item := range q.items {
+		photons[i] = item.photon
+	}
+	if len(q.items) < k {
+		return photons, maxDistance
+	}
+	return photons, math.Sqrt(q.items[0].distance2)
+}
+
+// search adds the photons of photons[first:end] nearer than the farthest
+// one in the queue to it
+func (m *Map) search(point *math3d.Vector3, first, end int, q *queue) {
+	if first >= end {
+		return
+	}
+	middle := first + (end-first)/2
+	p := &m.photons[middle]
+	offset := p.Position.SubtractV(*point)
+	q.offer(p, offset.AbsSquared())
+	// Search the side of point first, and the other side only if it's
+	// nearer than the farthest photon found
+	along := component(point, m.axes[middle]) - component(&p.Position, m.axes[middle])
+	near, far := [2]int{first, middle}, [2]int{middle + 1, end}
+	if along > 0 {
+		near, far = far, near
+	}
+	m.search(point, near[0], near[1], q)
+	if along*along < q.limit() {
+		m.search(point, far[0], far[1], q)
+	}
+}
+
+// queue holds the k nearest photons found so far, with the farthest one
+// first
+type queue struct {
+	items        []queueItem
+	k            int
+	maxDistance2 float64
+}
+
+type queueItem struct {
+	photon    *Photon
+	distance2 float64
+}
+
+// offer adds the photon at the squared distance if it's nearer than the
+// limit, dropping the farthest photon if there are more than k
+func (q *queue) offer(p *Photon, distance2 float64) {
+	if distance2 >= q.limit() {
+		return
+	}
+	heap.Push(q, queueItem{p, distance2})
+	if len(q.items) > q.k {
+		heap.Pop(q)
+	}
+}
+
+// limit returns the squared distance photons must be nearer than to be
+// added
+func (q *queue) limit() float64 {
+	if len(q.items) == q.k {
+		return q.items[0].distance2
+	}
+	return q.maxDistance2
+}
+
+func (q *queue) Len() int           { return len(q.items) }
+func (q *queue) Less(i, j int) bool { return q.items[i].distance2 > q.items[j].distance2 }
+func (q *queue) Swap(i, j int)      { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *queue) Push(x interface{}) { q.items = append(q.items, x.(queueItem)) }
+func (q *queue) Pop() interface{} {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+func component(v *math3d.Vector3, axis uint8) float64 {
+	switch axis {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
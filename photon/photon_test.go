@@ -0,0 +1,55 @@
+package photon
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func randomPhotons(n int) []Photon {
+	r := rand.New(rand.NewSource(5))
+	photons := make([]Photon, n)
+	for i := range photons {
+		photons[i].Position = math3d.Vector3{X: r.Float64(), Y: r.Float64(), Z: r.Float64() * 0.1}
+	}
+	return photons
+}
+
+func TestNearestMatchesBruteForce(t *testing.T) {
+	m := NewMap(randomPhotons(2000))
+	r := rand.New(rand.NewSource(9))
+	for i := 0; i < 200; i++ {
+		point := math3d.Vector3{X: r.Float64(), Y: r.Float64(), Z: r.Float64() * 0.1}
+		maxDistance := r.Float64() * 0.1
+		distances := []float64{}
+		for j := range m.photons {
+			if d := math3d.Distance(&point, &m.photons[j].Position); d < maxDistance {
+				distances = append(distances, d)
+			}
+		}
+		sort.Float64s(distances)
+		if len(distances) > 20 {
+			distances = distances[:20]
+		}
+
+		photons, radius := m.Nearest(&point, 20, maxDistance)
+		if len(photons) != len(distances) {
+			t.Fatalf("Found %d photons instead of %d", len(photons), len(distances))
+		}
+		for _, p := range photons {
+			if d := math3d.Distance(&point, &p.Position); d > distances[len(distances)-1] {
+				t.Fatalf("The photon at %f isn't one of the nearest", d)
+			}
+		}
+		expected := maxDistance
+		if len(distances) == 20 {
+			expected = distances[19]
+		}
+		if math.Abs(radius-expected) > 1e-12 {
+			t.Fatalf("The radius should be %f, it's %f", expected, radius)
+		}
+	}
+}
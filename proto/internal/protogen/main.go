@@ -0,0 +1,723 @@
+// Command protogen generates the Go types of the protobuf schemas of the
+// proto directory, with methods that encode and decode them in the wire
+// format through package wire, so the messages need nothing beyond the
+// standard library. It takes the .proto files, and writes the messages of
+// each one to <name>.pb.go in the directory of the last element of its
+// go_package, next to the files.
+//
+// It understands the subset of proto3 the schemas use: messages with
+// scalar, message, repeated, oneof and map fields. Services are skipped,
+// since the packages that use the messages serve them over transports of
+// their own.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wireImport is the import path of package wire
+const wireImport = "github.com/ProjectMOA/goraytrace/proto/internal/wire"
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("protogen: ")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("usage: protogen file.proto...")
+	}
+	files, err := parseFiles(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range files {
+		source, err := generate(f, files)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dir := filepath.Join(filepath.Dir(f.path), path.Base(f.goPackage))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		out := filepath.Join(dir, strings.TrimSuffix(filepath.Base(f.path), ".proto")+".pb.go")
+		if err := ioutil.WriteFile(out, source, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// file is a parsed .proto file
+type file struct {
+	path      string
+	comment   []string
+	pkg       string
+	goPackage string
+	messages  []*message
+}
+
+// goName returns the name of the Go package of the file
+func (f *file) goName() string {
+	return path.Base(f.goPackage)
+}
+
+type message struct {
+	name    string
+	comment []string
+	fields  []*field
+	oneofs  []*oneof
+}
+
+type oneof struct {
+	name    string
+	comment []string
+	fields  []*field
+}
+
+type field struct {
+	name     string
+	comment  []string
+	typ      string
+	num      int
+	repeated bool
+	// mapValue is the type of the values of map fields, whose keys are
+	// strings
+	mapValue string
+	oneof    *oneof
+}
+
+// scalars maps the scalar types of protobuf to the Go types they are
+// decoded in and to the names of their functions in package wire
+var scalars = map[string]struct{ goType, wire string }{
+	"double": {"float64", "Double"},
+	"float":  {"float32", "Float"},
+	"int32":  {"int32", "Int32"},
+	"int64":  {"int64", "Int64"},
+	"uint32": {"uint32", "Uint32"},
+	"uint64": {"uint64", "Uint64"},
+	"bool":   {"bool", "Bool"},
+	"string": {"string", "String"},
+	"bytes":  {"[]byte", "Bytes"},
+}
+
+// parseFiles parses the .proto files, which may import each other
+func parseFiles(paths []string) ([]*file, error) {
+	var files []*file
+	for _, p := range paths {
+		source, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parse(p, string(source))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// token is a word or a symbol of a .proto file, with the comment lines
+// right above it
+type token struct {
+	text    string
+	line    int
+	comment []string
+}
+
+// tokenize splits the source in tokens. Only line comments are kept, for
+// the declarations below them.
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	var comment []string
+	commentLine := 0
+	line := 1
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(source[i:], "//"):
+			end := strings.IndexByte(source[i:], '\n')
+			if end < 0 {
+				end = len(source) - i
+			}
+			if n := len(tokens); n > 0 && tokens[n-1].line == line {
+				// Comments after a declaration don't document the next one
+				i += end
+				continue
+			}
+			if commentLine != line-1 {
+				comment = nil
+			}
+			comment = append(comment, strings.TrimSpace(strings.TrimPrefix(source[i:i+end], "//")))
+			commentLine = line
+			i += end
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(source[i:i+end], "\n")
+			i += end + 2
+		case c == '"':
+			end := strings.IndexByte(source[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			tokens = append(tokens, token{text: source[i : i+end+2], line: line})
+			i += end + 2
+		case strings.IndexByte("{}=;<>,()[]", c) >= 0:
+			tokens = append(tokens, token{text: string(c), line: line})
+			i++
+		default:
+			start := i
+			for i < len(source) && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])) || source[i] == '_' || source[i] == '.') {
+				i++
+			}
+			if i == start {
+				return nil, fmt.Errorf("line %d: unexpected %q", line, c)
+			}
+			tokens = append(tokens, token{text: source[start:i], line: line})
+		}
+		if n := len(tokens); n > 0 && tokens[n-1].comment == nil && tokens[n-1].line == commentLine+1 && comment != nil {
+			tokens[n-1].comment = comment
+			comment = nil
+		}
+	}
+	return tokens, nil
+}
+
+// parser reads the declarations of a .proto file from its tokens
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(text string) error {
+	if t := p.next(); t.text != text {
+		return fmt.Errorf("line %d: expected %q, found %q", t.line, text, t.text)
+	}
+	return nil
+}
+
+// statement returns the tokens up to the next semicolon, which it skips
+func (p *parser) statement() ([]string, error) {
+	var words []string
+	for {
+		t := p.next()
+		switch t.text {
+		case ";":
+			return words, nil
+		case "", "{", "}":
+			return nil, fmt.Errorf("line %d: expected a semicolon", t.line)
+		}
+		words = append(words, t.text)
+	}
+}
+
+// skipBlock skips the block that starts at the next token
+func (p *parser) skipBlock() error {
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for depth := 1; depth > 0; {
+		switch p.next().text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		case "":
+			return fmt.Errorf("unterminated block")
+		}
+	}
+	return nil
+}
+
+func parse(filePath, source string) (*file, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	f := &file{path: filePath, comment: p.peek().comment}
+	for p.pos < len(p.tokens) {
+		t := p.next()
+		switch t.text {
+		case "syntax":
+			words, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			if strings.Join(words, " ") != `= "proto3"` {
+				return nil, fmt.Errorf("line %d: only proto3 is supported", t.line)
+			}
+		case "package":
+			words, err := p.statement()
+			if err != nil || len(words) != 1 {
+				return nil, fmt.Errorf("line %d: invalid package", t.line)
+			}
+			f.pkg = words[0]
+		case "import":
+			if _, err := p.statement(); err != nil {
+				return nil, err
+			}
+		case "option":
+			words, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			if len(words) == 3 && words[0] == "go_package" {
+				f.goPackage, _ = strconv.Unquote(words[2])
+			}
+		case "message":
+			m, err := p.message(t)
+			if err != nil {
+				return nil, err
+			}
+			f.messages = append(f.messages, m)
+		case "service":
+			p.next()
+			if err := p.skipBlock(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("line %d: %q isn't supported", t.line, t.text)
+		}
+	}
+	if f.goPackage == "" {
+		return nil, fmt.Errorf("the go_package option is missing")
+	}
+	return f, nil
+}
+
+func (p *parser) message(start token) (*message, error) {
+	m := &message{name: p.next().text, comment: start.comment}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch t.text {
+		case "}":
+			p.next()
+			return m, nil
+		case "oneof":
+			p.next()
+			o := &oneof{name: p.next().text, comment: t.comment}
+			if err := p.expect("{"); err != nil {
+				return nil, err
+			}
+			for p.peek().text != "}" {
+				f, err := p.field()
+				if err != nil {
+					return nil, err
+				}
+				if f.repeated || f.mapValue != "" {
+					return nil, fmt.Errorf("line %d: oneof fields can't be repeated", t.line)
+				}
+				f.oneof = o
+				o.fields = append(o.fields, f)
+				m.fields = append(m.fields, f)
+			}
+			p.next()
+			m.oneofs = append(m.oneofs, o)
+		default:
+			f, err := p.field()
+			if err != nil {
+				return nil, err
+			}
+			m.fields = append(m.fields, f)
+		}
+	}
+}
+
+func (p *parser) field() (*field, error) {
+	first := p.peek()
+	words, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	f := &field{comment: first.comment}
+	if len(words) > 0 && words[0] == "repeated" {
+		f.repeated = true
+		words = words[1:]
+	}
+	if len(words) == 9 && words[0] == "map" && words[1] == "<" && words[3] == "," && words[5] == ">" {
+		if words[2] != "string" {
+			return nil, fmt.Errorf("line %d: only maps with string keys are supported", first.line)
+		}
+		f.mapValue = words[4]
+		words = append([]string{"map"}, words[6:]...)
+	}
+	if len(words) != 4 || words[2] != "=" {
+		return nil, fmt.Errorf("line %d: invalid field %q", first.line, strings.Join(words, " "))
+	}
+	f.typ, f.name = words[0], words[1]
+	if f.num, err = strconv.Atoi(words[3]); err != nil || f.num < 1 {
+		return nil, fmt.Errorf("line %d: invalid field number %q", first.line, words[3])
+	}
+	return f, nil
+}
+
+// generator writes the Go source of a file
+type generator struct {
+	f       *file
+	files   []*file
+	imports map[string]bool
+	buf     bytes.Buffer
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+// comment writes the comment lines, indented by the prefix
+func (g *generator) comment(lines []string, prefix string) {
+	for _, l := range lines {
+		if l == "" {
+			g.p("%s//", prefix)
+		} else {
+			g.p("%s// %s", prefix, l)
+		}
+	}
+}
+
+// resolve returns the Go type of the message type name, qualified with its
+// package if it's in another file's package, and whether it's a message
+func (g *generator) resolve(name string) (string, error) {
+	for _, f := range g.files {
+		local := name
+		if strings.HasPrefix(name, f.pkg+".") {
+			local = strings.TrimPrefix(name, f.pkg+".")
+		} else if f.pkg != g.f.pkg || strings.Contains(name, ".") {
+			continue
+		}
+		for _, m := range f.messages {
+			if m.name != local {
+				continue
+			}
+			if f.goPackage == g.f.goPackage {
+				return m.name, nil
+			}
+			g.imports[f.goPackage] = true
+			return f.goName() + "." + m.name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown type %s", name)
+}
+
+// camel returns the Go name of a protobuf name
+func camel(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// goType returns the Go type of the field, without the slice of repeated
+// fields
+func (g *generator) goType(typ string) (string, bool, error) {
+	if s, ok := scalars[typ]; ok {
+		return s.goType, false, nil
+	}
+	t, err := g.resolve(typ)
+	return "*" + t, true, err
+}
+
+// generate returns the Go source of the messages of the file
+func generate(f *file, files []*file) ([]byte, error) {
+	g := &generator{f: f, files: files, imports: map[string]bool{wireImport: true}}
+	for _, m := range f.messages {
+		if err := g.message(m); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", f.path, m.name, err)
+		}
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by protogen from %s. DO NOT EDIT.\n\n", filepath.Base(f.path))
+	fmt.Fprintf(&out, "// Package %s holds the messages of %s.\n", f.goName(), filepath.Base(f.path))
+	if len(f.comment) > 0 {
+		out.WriteString("//\n")
+		for _, l := range f.comment {
+			out.WriteString(strings.TrimRight("// "+l, " ") + "\n")
+		}
+	}
+	fmt.Fprintf(&out, "package %s\n\nimport (\n", f.goName())
+	// The packages of the standard library go first, as goimports puts them
+	var std, others []string
+	for i := range g.imports {
+		if strings.Contains(i, ".") {
+			others = append(others, i)
+		} else {
+			std = append(std, i)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(others)
+	for _, i := range std {
+		fmt.Fprintf(&out, "\t%q\n", i)
+	}
+	if len(std) > 0 {
+		out.WriteString("\n")
+	}
+	for _, i := range others {
+		fmt.Fprintf(&out, "\t%q\n", i)
+	}
+	out.WriteString(")\n\n")
+	out.Write(g.buf.Bytes())
+	return format.Source(out.Bytes())
+}
+
+func (g *generator) message(m *message) error {
+	// The type
+	g.comment(m.comment, "")
+	g.p("type %s struct {", m.name)
+	for _, f := range m.fields {
+		if f.oneof != nil {
+			if f == f.oneof.fields[0] {
+				o := f.oneof
+				g.comment(o.comment, "\t")
+				var wrappers []string
+				for _, of := range o.fields {
+					wrappers = append(wrappers, "*"+m.name+"_"+camel(of.name))
+				}
+				last := len(wrappers) - 1
+				g.p("\t// %s is one of %s or %s, or nil", camel(o.name), strings.Join(wrappers[:last], ", "), wrappers[last])
+				g.p("\t%s is%s_%s", camel(o.name), m.name, camel(o.name))
+			}
+			continue
+		}
+		t, err := g.fieldType(f)
+		if err != nil {
+			return err
+		}
+		g.comment(f.comment, "\t")
+		g.p("\t%s %s", camel(f.name), t)
+	}
+	g.p("}\n")
+
+	// The oneofs
+	for _, o := range m.oneofs {
+		iface := "is" + m.name + "_" + camel(o.name)
+		g.p("type %s interface {\n\t%s()\n}\n", iface, iface)
+		for _, f := range o.fields {
+			t, _, err := g.goType(f.typ)
+			if err != nil {
+				return err
+			}
+			wrapper := m.name + "_" + camel(f.name)
+			g.comment(f.comment, "")
+			g.p("type %s struct {\n\t%s %s\n}\n", wrapper, camel(f.name), t)
+			g.p("func (*%s) %s() {}\n", wrapper, iface)
+		}
+	}
+
+	// The getters
+	for _, f := range m.fields {
+		t, err := g.fieldType(f)
+		if err != nil {
+			return err
+		}
+		name := camel(f.name)
+		g.p("// Get%s returns the %s of the message, or its zero value if the message is nil", name, f.name)
+		g.p("func (m *%s) Get%s() %s {", m.name, name, t)
+		if f.oneof != nil {
+			g.p("\tif v, ok := m.Get%s().(*%s_%s); ok {\n\t\treturn v.%s\n\t}", camel(f.oneof.name), m.name, name, name)
+			g.p("\treturn %s\n}\n", zero(t))
+		} else {
+			g.p("\tif m == nil {\n\t\treturn %s\n\t}\n\treturn m.%s\n}\n", zero(t), name)
+		}
+	}
+	for _, o := range m.oneofs {
+		name := camel(o.name)
+		g.p("// Get%s returns the %s of the message, or nil if the message is nil", name, o.name)
+		g.p("func (m *%s) Get%s() is%s_%s {\n\tif m == nil {\n\t\treturn nil\n\t}\n\treturn m.%s\n}\n", m.name, name, m.name, name, name)
+	}
+
+	// Marshal
+	g.p("// Marshal returns the message in the protobuf wire format")
+	g.p("func (m *%s) Marshal() []byte {", m.name)
+	g.p("\tif m == nil {\n\t\treturn nil\n\t}")
+	g.p("\tvar b []byte")
+	for _, f := range m.fields {
+		if f.oneof != nil {
+			if f == f.oneof.fields[0] {
+				g.p("\tswitch v := m.%s.(type) {", camel(f.oneof.name))
+				for _, of := range f.oneof.fields {
+					g.p("\tcase *%s_%s:", m.name, camel(of.name))
+					g.p("\t\tb = %s", appendValue(of, "v."+camel(of.name)))
+				}
+				g.p("\t}")
+			}
+			continue
+		}
+		if err := g.marshalField(f); err != nil {
+			return err
+		}
+	}
+	g.p("\treturn b\n}\n")
+
+	// Unmarshal
+	g.p("// Unmarshal replaces the message with the one in b, in the protobuf")
+	g.p("// wire format. The fields it doesn't know are skipped.")
+	g.p("func (m *%s) Unmarshal(b []byte) error {", m.name)
+	g.p("\t*m = %s{}", m.name)
+	g.p("\tr := wire.NewReader(b)")
+	g.p("\tfor r.Next() {")
+	g.p("\t\tswitch r.Field() {")
+	for _, f := range m.fields {
+		if err := g.unmarshalField(m, f); err != nil {
+			return err
+		}
+	}
+	g.p("\t\tdefault:\n\t\t\tr.Skip()\n\t\t}\n\t}\n\treturn r.Err()\n}\n")
+	return nil
+}
+
+// fieldType returns the Go type of the field
+func (g *generator) fieldType(f *field) (string, error) {
+	if f.mapValue != "" {
+		t, _, err := g.goType(f.mapValue)
+		return "map[string]" + t, err
+	}
+	t, _, err := g.goType(f.typ)
+	if f.repeated {
+		t = "[]" + t
+	}
+	return t, err
+}
+
+// zero returns the zero value of the Go type
+func zero(t string) string {
+	switch {
+	case t == "string":
+		return `""`
+	case t == "bool":
+		return "false"
+	case strings.HasPrefix(t, "*") || strings.HasPrefix(t, "[]") || strings.HasPrefix(t, "map["):
+		return "nil"
+	}
+	return "0"
+}
+
+// appendValue returns the expression that appends the value of the field,
+// which isn't repeated, to b
+func appendValue(f *field, value string) string {
+	if s, ok := scalars[f.typ]; ok {
+		return fmt.Sprintf("wire.Append%s(b, %d, %s)", s.wire, f.num, value)
+	}
+	return fmt.Sprintf("wire.AppendBytes(b, %d, %s.Marshal())", f.num, value)
+}
+
+func (g *generator) marshalField(f *field) error {
+	name := "m." + camel(f.name)
+	switch {
+	case f.mapValue != "":
+		if _, ok := scalars[f.mapValue]; ok {
+			return fmt.Errorf("%s: only maps of messages are supported", f.name)
+		}
+		g.p("\tkeys := make([]string, 0, len(%s))", name)
+		g.p("\tfor k := range %s {\n\t\tkeys = append(keys, k)\n\t}", name)
+		g.p("\tsort.Strings(keys)")
+		g.p("\tfor _, k := range keys {\n\t\tb = wire.AppendEntry(b, %d, k, %s[k].Marshal())\n\t}", f.num, name)
+		g.imports["sort"] = true
+	case f.repeated && f.typ == "double":
+		g.p("\tif len(%s) > 0 {\n\t\tb = wire.AppendDoubles(b, %d, %s)\n\t}", name, f.num, name)
+	case f.repeated:
+		if s, ok := scalars[f.typ]; ok && s.wire != "String" && s.wire != "Bytes" {
+			return fmt.Errorf("%s: repeated %s isn't supported", f.name, f.typ)
+		}
+		g.p("\tfor _, v := range %s {\n\t\tb = %s\n\t}", name, appendValue(f, "v"))
+	default:
+		var present string
+		switch t, _, _ := g.goType(f.typ); t {
+		case "bool":
+			present = name
+		case "string":
+			present = name + ` != ""`
+		case "[]byte":
+			present = "len(" + name + ") > 0"
+		default:
+			if strings.HasPrefix(t, "*") {
+				present = name + " != nil"
+			} else {
+				present = name + " != 0"
+			}
+		}
+		g.p("\tif %s {\n\t\tb = %s\n\t}", present, appendValue(f, name))
+	}
+	return nil
+}
+
+func (g *generator) unmarshalField(m *message, f *field) error {
+	name := "m." + camel(f.name)
+	g.p("\t\tcase %d:", f.num)
+	if f.mapValue != "" {
+		t, _, err := g.goType(f.mapValue)
+		if err != nil {
+			return err
+		}
+		g.p("\t\t\tif %s == nil {\n\t\t\t\t%s = make(map[string]%s)\n\t\t\t}", name, name, t)
+		g.p("\t\t\tvar k string\n\t\t\tv := new(%s)\n\t\t\tr.Entry(&k, v)\n\t\t\t%s[k] = v", t[1:], name)
+		return nil
+	}
+	s, scalar := scalars[f.typ]
+	read := ""
+	if scalar {
+		read = "r." + s.wire + "()"
+		if s.wire == "String" {
+			read = "r.Text()"
+		}
+	}
+	t, _, err := g.goType(f.typ)
+	if err != nil {
+		return err
+	}
+	switch {
+	case f.repeated && f.typ == "double":
+		g.p("\t\t\t%s = r.Doubles(%s)", name, name)
+	case f.repeated && scalar:
+		g.p("\t\t\t%s = append(%s, %s)", name, name, read)
+	case f.repeated:
+		g.p("\t\t\tv := new(%s)\n\t\t\tr.Message(v)\n\t\t\t%s = append(%s, v)", t[1:], name, name)
+	case f.oneof != nil && scalar:
+		g.p("\t\t\tm.%s = &%s_%s{%s}", camel(f.oneof.name), m.name, camel(f.name), read)
+	case f.oneof != nil:
+		g.p("\t\t\tv := new(%s)\n\t\t\tr.Message(v)\n\t\t\tm.%s = &%s_%s{v}", t[1:], camel(f.oneof.name), m.name, camel(f.name))
+	case scalar:
+		g.p("\t\t\t%s = %s", name, read)
+	default:
+		g.p("\t\t\t%s = new(%s)\n\t\t\tr.Message(%s)", name, t[1:], name)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeneratedFilesAreUpToDate(t *testing.T) {
+	files, err := parseFiles([]string{"../../scene.proto", "../../render.proto", "../../sceneedit.proto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		source, err := generate(f, files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated := filepath.Join("../..", f.goName(), filepath.Base(f.path[:len(f.path)-len(".proto")])+".pb.go")
+		committed, err := ioutil.ReadFile(generated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(source, committed) {
+			t.Errorf("%s isn't what %s generates, run go generate in the proto directory", generated, f.path)
+		}
+	}
+}
+
+func TestUnsupportedSchemas(t *testing.T) {
+	for _, source := range []string{
+		`syntax = "proto2"; option go_package = "x/y";`,
+		`syntax = "proto3"; message M { int32 a = 1; }`,
+		`syntax = "proto3"; option go_package = "x/y"; enum E { A = 0; }`,
+		`syntax = "proto3"; option go_package = "x/y"; message M { map<int32, M> a = 1; }`,
+		`syntax = "proto3"; option go_package = "x/y"; message M { int32 a = 0; }`,
+		`syntax = "proto3"; option go_package = "x/y"; message M { oneof o { repeated int32 a = 1; } }`,
+	} {
+		if _, err := parse("test.proto", source); err == nil {
+			t.Errorf("%s shouldn't be parsed", source)
+		}
+	}
+	f, err := parse("test.proto", `syntax = "proto3"; option go_package = "x/y"; message M { Unknown a = 1; repeated int32 b = 2; }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generate(f, []*file{f}); err == nil {
+		t.Error("fields of unknown types and repeated int32 shouldn't be generated")
+	}
+}
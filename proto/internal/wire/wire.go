@@ -0,0 +1,373 @@
+// Package wire reads and writes the protobuf wire format, for the
+// messages that protogen generates. Only the parts of the format that the
+// schemas of the proto directory use are there: varints, 64 and 32 bit
+// numbers and length delimited values, which hold strings, bytes,
+// embedded messages, map entries and packed doubles.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Type is the wire type of a field, which says how its value is encoded
+type Type int
+
+// The wire types
+const (
+	Varint  Type = 0
+	Fixed64 Type = 1
+	Bytes   Type = 2
+	Fixed32 Type = 5
+)
+
+// errTruncated is the error of the messages cut short
+var errTruncated = errors.New("the message is cut short")
+
+// AppendTag appends the tag of the field num of the wire type to b
+func AppendTag(b []byte, num int, typ Type) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// AppendDouble appends the field num holding v to b
+func AppendDouble(b []byte, num int, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(AppendTag(b, num, Fixed64), math.Float64bits(v))
+}
+
+// AppendFloat appends the field num holding v to b
+func AppendFloat(b []byte, num int, v float32) []byte {
+	return binary.LittleEndian.AppendUint32(AppendTag(b, num, Fixed32), math.Float32bits(v))
+}
+
+// AppendInt32 appends the field num holding v to b. Negative numbers take
+// 10 bytes, as they are sign extended to 64 bits.
+func AppendInt32(b []byte, num int, v int32) []byte {
+	return binary.AppendUvarint(AppendTag(b, num, Varint), uint64(int64(v)))
+}
+
+// AppendInt64 appends the field num holding v to b
+func AppendInt64(b []byte, num int, v int64) []byte {
+	return binary.AppendUvarint(AppendTag(b, num, Varint), uint64(v))
+}
+
+// AppendUint32 appends the field num holding v to b
+func AppendUint32(b []byte, num int, v uint32) []byte {
+	return binary.AppendUvarint(AppendTag(b, num, Varint), uint64(v))
+}
+
+// AppendUint64 appends the field num holding v to b
+func AppendUint64(b []byte, num int, v uint64) []byte {
+	return binary.AppendUvarint(AppendTag(b, num, Varint), v)
+}
+
+// AppendBool appends the field num holding v to b
+func AppendBool(b []byte, num int, v bool) []byte {
+	var n uint64
+	if v {
+		n = 1
+	}
+	return binary.AppendUvarint(AppendTag(b, num, Varint), n)
+}
+
+// AppendBytes appends the field num holding v to b. It's how strings and
+// embedded messages are encoded too.
+func AppendBytes(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(AppendTag(b, num, Bytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendString appends the field num holding v to b
+func AppendString(b []byte, num int, v string) []byte {
+	b = binary.AppendUvarint(AppendTag(b, num, Bytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendDoubles appends the repeated field num holding vs to b, packed
+func AppendDoubles(b []byte, num int, vs []float64) []byte {
+	b = binary.AppendUvarint(AppendTag(b, num, Bytes), uint64(8*len(vs)))
+	for _, v := range vs {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// AppendEntry appends the entry of the map field num of the key and the
+// message encoded in value to b
+func AppendEntry(b []byte, num int, key string, value []byte) []byte {
+	entry := AppendString(nil, 1, key)
+	entry = AppendBytes(entry, 2, value)
+	return AppendBytes(b, num, entry)
+}
+
+// Unmarshaler is a message that can be decoded from the wire format
+type Unmarshaler interface {
+	Unmarshal(b []byte) error
+}
+
+// Reader reads the fields of a message one after the other. Next moves to
+// the next field, whose value is then read by the method of its type, or
+// skipped. The reader keeps the first error, after which Next returns
+// false and the values read are zero.
+type Reader struct {
+	b   []byte
+	num int
+	typ Type
+	err error
+	// read is whether the value of the current field was read
+	read bool
+}
+
+// NewReader returns a reader of the message encoded in b
+func NewReader(b []byte) *Reader {
+	return &Reader{b: b, read: true}
+}
+
+// Next moves to the next field, and returns false if there's none or the
+// message isn't valid
+func (r *Reader) Next() bool {
+	if !r.read {
+		r.Skip()
+	}
+	if r.err != nil || len(r.b) == 0 {
+		return false
+	}
+	tag, n := binary.Uvarint(r.b)
+	if n <= 0 || tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+		r.err = errors.New("invalid field tag")
+		return false
+	}
+	r.b = r.b[n:]
+	r.num, r.typ, r.read = int(tag>>3), Type(tag&7), false
+	return true
+}
+
+// Field returns the number of the current field
+func (r *Reader) Field() int {
+	return r.num
+}
+
+// Err returns the first error of the reader
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// fail keeps the error, unless there's one already
+func (r *Reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.b = nil
+}
+
+// expect returns whether the current field has the wire type, failing if
+// it doesn't
+func (r *Reader) expect(typ Type) bool {
+	r.read = true
+	if r.err != nil {
+		return false
+	}
+	if r.typ != typ {
+		r.fail(fmt.Errorf("field %d has wire type %d, not %d", r.num, r.typ, typ))
+		return false
+	}
+	return true
+}
+
+// Skip skips the value of the current field, whichever its type
+func (r *Reader) Skip() {
+	r.read = true
+	switch r.typ {
+	case Varint:
+		r.varint()
+	case Fixed64:
+		r.fixed(8)
+	case Fixed32:
+		r.fixed(4)
+	case Bytes:
+		r.bytes()
+	default:
+		r.fail(fmt.Errorf("field %d has the unknown wire type %d", r.num, r.typ))
+	}
+}
+
+func (r *Reader) varint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail(errTruncated)
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *Reader) fixed(size int) []byte {
+	if len(r.b) < size {
+		r.fail(errTruncated)
+		return nil
+	}
+	v := r.b[:size]
+	r.b = r.b[size:]
+	return v
+}
+
+func (r *Reader) bytes() []byte {
+	length := r.varint()
+	if r.err != nil {
+		return nil
+	}
+	if length > uint64(len(r.b)) {
+		r.fail(errTruncated)
+		return nil
+	}
+	v := r.b[:length]
+	r.b = r.b[length:]
+	return v
+}
+
+// Double returns the value of the current field as a double
+func (r *Reader) Double() float64 {
+	if !r.expect(Fixed64) {
+		return 0
+	}
+	if v := r.fixed(8); v != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(v))
+	}
+	return 0
+}
+
+// Float returns the value of the current field as a float
+func (r *Reader) Float() float32 {
+	if !r.expect(Fixed32) {
+		return 0
+	}
+	if v := r.fixed(4); v != nil {
+		return math.Float32frombits(binary.LittleEndian.Uint32(v))
+	}
+	return 0
+}
+
+// Int32 returns the value of the current field as an int32, truncating it
+// as protobuf does
+func (r *Reader) Int32() int32 {
+	if !r.expect(Varint) {
+		return 0
+	}
+	return int32(r.varint())
+}
+
+// Int64 returns the value of the current field as an int64
+func (r *Reader) Int64() int64 {
+	if !r.expect(Varint) {
+		return 0
+	}
+	return int64(r.varint())
+}
+
+// Uint32 returns the value of the current field as a uint32, truncating
+// it as protobuf does
+func (r *Reader) Uint32() uint32 {
+	if !r.expect(Varint) {
+		return 0
+	}
+	return uint32(r.varint())
+}
+
+// Uint64 returns the value of the current field as a uint64
+func (r *Reader) Uint64() uint64 {
+	if !r.expect(Varint) {
+		return 0
+	}
+	return r.varint()
+}
+
+// Bool returns the value of the current field as a bool
+func (r *Reader) Bool() bool {
+	if !r.expect(Varint) {
+		return false
+	}
+	return r.varint() != 0
+}
+
+// Text returns the value of the current field as a string, which must be
+// valid UTF-8
+func (r *Reader) Text() string {
+	if !r.expect(Bytes) {
+		return ""
+	}
+	v := r.bytes()
+	if !utf8.Valid(v) {
+		r.fail(fmt.Errorf("field %d isn't valid UTF-8", r.num))
+		return ""
+	}
+	return string(v)
+}
+
+// Bytes returns a copy of the value of the current field as bytes
+func (r *Reader) Bytes() []byte {
+	if !r.expect(Bytes) {
+		return nil
+	}
+	return append([]byte{}, r.bytes()...)
+}
+
+// Doubles appends the values of the current field, a repeated double
+// either packed or not, to vs and returns them
+func (r *Reader) Doubles(vs []float64) []float64 {
+	if r.typ == Fixed64 {
+		return append(vs, r.Double())
+	}
+	if !r.expect(Bytes) {
+		return vs
+	}
+	packed := r.bytes()
+	if len(packed)%8 != 0 {
+		r.fail(fmt.Errorf("field %d holds %d bytes, which aren't doubles", r.num, len(packed)))
+		return vs
+	}
+	for i := 0; i < len(packed); i += 8 {
+		vs = append(vs, math.Float64frombits(binary.LittleEndian.Uint64(packed[i:])))
+	}
+	return vs
+}
+
+// Message decodes the value of the current field, an embedded message,
+// into m
+func (r *Reader) Message(m Unmarshaler) {
+	if !r.expect(Bytes) {
+		return
+	}
+	if v := r.bytes(); r.err == nil {
+		if err := m.Unmarshal(v); err != nil {
+			r.fail(fmt.Errorf("field %d: %v", r.num, err))
+		}
+	}
+}
+
+// Entry decodes the value of the current field, an entry of a map from
+// strings to messages, into the key and the value
+func (r *Reader) Entry(key *string, value Unmarshaler) {
+	if !r.expect(Bytes) {
+		return
+	}
+	entry := NewReader(r.bytes())
+	var encoded []byte
+	for entry.Next() {
+		switch entry.Field() {
+		case 1:
+			*key = entry.Text()
+		case 2:
+			if entry.expect(Bytes) {
+				encoded = entry.bytes()
+			}
+		}
+	}
+	if entry.err == nil {
+		entry.err = value.Unmarshal(encoded)
+	}
+	if entry.err != nil {
+		r.fail(fmt.Errorf("field %d: %v", r.num, entry.err))
+	}
+}
@@ -0,0 +1,83 @@
+package wire
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var b []byte
+	b = AppendDouble(b, 1, math.Pi)
+	b = AppendInt32(b, 2, -7)
+	b = AppendUint64(b, 3, math.MaxUint64)
+	b = AppendBool(b, 4, true)
+	b = AppendString(b, 5, "tile")
+	b = AppendDoubles(b, 6, []float64{1, 2})
+	b = AppendDouble(b, 6, 3)
+	b = AppendFloat(b, 99, 1.5)
+	b = AppendBytes(b, 7, []byte{0, 255})
+	r := NewReader(b)
+	var doubles []float64
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			if v := r.Double(); v != math.Pi {
+				t.Errorf("field 1 should be pi, not %v", v)
+			}
+		case 2:
+			if v := r.Int32(); v != -7 {
+				t.Errorf("field 2 should be -7, not %d", v)
+			}
+		case 3:
+			if v := r.Uint64(); v != math.MaxUint64 {
+				t.Errorf("field 3 should be the largest uint64, not %d", v)
+			}
+		case 4:
+			if !r.Bool() {
+				t.Error("field 4 should be true")
+			}
+		case 5:
+			if v := r.Text(); v != "tile" {
+				t.Errorf("field 5 should be tile, not %q", v)
+			}
+		case 6:
+			doubles = r.Doubles(doubles)
+		case 7:
+			if v := r.Bytes(); len(v) != 2 || v[1] != 255 {
+				t.Errorf("field 7 should be 2 bytes, not %v", v)
+			}
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	if len(doubles) != 3 || doubles[2] != 3 {
+		t.Errorf("packed and unpacked doubles should both be read, not %v", doubles)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	valid := AppendString(AppendDouble(nil, 1, 1), 2, "text")
+	for name, b := range map[string][]byte{
+		"truncated":        valid[:len(valid)-1],
+		"field 0":          AppendDouble(nil, 0, 1),
+		"wrong wire type":  AppendInt32(nil, 1, 1),
+		"invalid UTF-8":    AppendBytes(nil, 2, []byte{0xff}),
+		"unknown type":     {1<<3 | 7},
+		"overlong length":  {2<<3 | 2, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		"truncated varint": {3<<3 | 0, 0x80},
+	} {
+		r := NewReader(b)
+		for r.Next() {
+			switch r.Field() {
+			case 1:
+				r.Double()
+			case 2:
+				r.Text()
+			}
+		}
+		if r.Err() == nil {
+			t.Errorf("the %s message should fail", name)
+		}
+	}
+}
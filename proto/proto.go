@@ -0,0 +1,10 @@
+// Package proto holds the protobuf schemas of the messages that the
+// programs of goraytrace exchange, and the Go types generated from them,
+// each in a package of its own: scenepb for scenes, renderpb for the
+// tiles of distributed renders and sceneeditpb for the changes external
+// editors make to live scenes. The generated types encode and decode
+// themselves with the standard library alone, and are generated again
+// with go generate after the schemas change.
+package proto
+
+//go:generate go run ./internal/protogen scene.proto render.proto sceneedit.proto
@@ -0,0 +1,493 @@
+// Code generated by protogen from render.proto. DO NOT EDIT.
+
+// Package renderpb holds the messages of render.proto.
+//
+// Messages of distributed rendering.
+//
+// They carry the same data as the JSON and raw pixels exchanged by
+// package netrender, whose coordinator hands out tiles of the frames of
+// a job to the workers and puts their pixels together. Its coordinators
+// and workers exchange Tile and TileResult, the others describe the rest
+// of the protocol.
+package renderpb
+
+import (
+	"github.com/ProjectMOA/goraytrace/proto/internal/wire"
+	"github.com/ProjectMOA/goraytrace/proto/scenepb"
+)
+
+// Job is what workers render.
+type Job struct {
+	Scene *scenepb.Scene
+	// The job is a still if the animation is missing.
+	Animation *scenepb.Animation
+	Width     int32
+	Height    int32
+}
+
+// GetScene returns the scene of the message, or its zero value if the message is nil
+func (m *Job) GetScene() *scenepb.Scene {
+	if m == nil {
+		return nil
+	}
+	return m.Scene
+}
+
+// GetAnimation returns the animation of the message, or its zero value if the message is nil
+func (m *Job) GetAnimation() *scenepb.Animation {
+	if m == nil {
+		return nil
+	}
+	return m.Animation
+}
+
+// GetWidth returns the width of the message, or its zero value if the message is nil
+func (m *Job) GetWidth() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Width
+}
+
+// GetHeight returns the height of the message, or its zero value if the message is nil
+func (m *Job) GetHeight() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Height
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Job) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Scene != nil {
+		b = wire.AppendBytes(b, 1, m.Scene.Marshal())
+	}
+	if m.Animation != nil {
+		b = wire.AppendBytes(b, 2, m.Animation.Marshal())
+	}
+	if m.Width != 0 {
+		b = wire.AppendInt32(b, 3, m.Width)
+	}
+	if m.Height != 0 {
+		b = wire.AppendInt32(b, 4, m.Height)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Job) Unmarshal(b []byte) error {
+	*m = Job{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Scene = new(scenepb.Scene)
+			r.Message(m.Scene)
+		case 2:
+			m.Animation = new(scenepb.Animation)
+			r.Message(m.Animation)
+		case 3:
+			m.Width = r.Int32()
+		case 4:
+			m.Height = r.Int32()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Tile is a rectangle of a frame leased to a worker. Its pixels are sent
+// back with the unit and lease numbers.
+type Tile struct {
+	Unit   int32
+	Lease  int32
+	Frame  int32
+	X      int32
+	Y      int32
+	Width  int32
+	Height int32
+}
+
+// GetUnit returns the unit of the message, or its zero value if the message is nil
+func (m *Tile) GetUnit() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Unit
+}
+
+// GetLease returns the lease of the message, or its zero value if the message is nil
+func (m *Tile) GetLease() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Lease
+}
+
+// GetFrame returns the frame of the message, or its zero value if the message is nil
+func (m *Tile) GetFrame() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Frame
+}
+
+// GetX returns the x of the message, or its zero value if the message is nil
+func (m *Tile) GetX() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.X
+}
+
+// GetY returns the y of the message, or its zero value if the message is nil
+func (m *Tile) GetY() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Y
+}
+
+// GetWidth returns the width of the message, or its zero value if the message is nil
+func (m *Tile) GetWidth() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Width
+}
+
+// GetHeight returns the height of the message, or its zero value if the message is nil
+func (m *Tile) GetHeight() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Height
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Tile) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Unit != 0 {
+		b = wire.AppendInt32(b, 1, m.Unit)
+	}
+	if m.Lease != 0 {
+		b = wire.AppendInt32(b, 2, m.Lease)
+	}
+	if m.Frame != 0 {
+		b = wire.AppendInt32(b, 3, m.Frame)
+	}
+	if m.X != 0 {
+		b = wire.AppendInt32(b, 4, m.X)
+	}
+	if m.Y != 0 {
+		b = wire.AppendInt32(b, 5, m.Y)
+	}
+	if m.Width != 0 {
+		b = wire.AppendInt32(b, 6, m.Width)
+	}
+	if m.Height != 0 {
+		b = wire.AppendInt32(b, 7, m.Height)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Tile) Unmarshal(b []byte) error {
+	*m = Tile{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Unit = r.Int32()
+		case 2:
+			m.Lease = r.Int32()
+		case 3:
+			m.Frame = r.Int32()
+		case 4:
+			m.X = r.Int32()
+		case 5:
+			m.Y = r.Int32()
+		case 6:
+			m.Width = r.Int32()
+		case 7:
+			m.Height = r.Int32()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// TileResult carries the pixels of a tile as 8 bit non premultiplied RGBA
+// rows, as image.NRGBA holds them.
+type TileResult struct {
+	Unit  int32
+	Lease int32
+	Rgba  []byte
+	// The content coding of rgba: "x-delta-deflate" if the pixels are
+	// compressed as package netrender describes, or empty if they aren't.
+	Encoding string
+}
+
+// GetUnit returns the unit of the message, or its zero value if the message is nil
+func (m *TileResult) GetUnit() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Unit
+}
+
+// GetLease returns the lease of the message, or its zero value if the message is nil
+func (m *TileResult) GetLease() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Lease
+}
+
+// GetRgba returns the rgba of the message, or its zero value if the message is nil
+func (m *TileResult) GetRgba() []byte {
+	if m == nil {
+		return nil
+	}
+	return m.Rgba
+}
+
+// GetEncoding returns the encoding of the message, or its zero value if the message is nil
+func (m *TileResult) GetEncoding() string {
+	if m == nil {
+		return ""
+	}
+	return m.Encoding
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *TileResult) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Unit != 0 {
+		b = wire.AppendInt32(b, 1, m.Unit)
+	}
+	if m.Lease != 0 {
+		b = wire.AppendInt32(b, 2, m.Lease)
+	}
+	if len(m.Rgba) > 0 {
+		b = wire.AppendBytes(b, 3, m.Rgba)
+	}
+	if m.Encoding != "" {
+		b = wire.AppendString(b, 4, m.Encoding)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *TileResult) Unmarshal(b []byte) error {
+	*m = TileResult{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Unit = r.Int32()
+		case 2:
+			m.Lease = r.Int32()
+		case 3:
+			m.Rgba = r.Bytes()
+		case 4:
+			m.Encoding = r.Text()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type JobRequest struct {
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *JobRequest) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *JobRequest) Unmarshal(b []byte) error {
+	*m = JobRequest{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type LeaseRequest struct {
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *LeaseRequest) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *LeaseRequest) Unmarshal(b []byte) error {
+	*m = LeaseRequest{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type LeaseResponse struct {
+	// Lease is one of *LeaseResponse_Tile, *LeaseResponse_Wait or *LeaseResponse_Done, or nil
+	Lease isLeaseResponse_Lease
+}
+
+type isLeaseResponse_Lease interface {
+	isLeaseResponse_Lease()
+}
+
+type LeaseResponse_Tile struct {
+	Tile *Tile
+}
+
+func (*LeaseResponse_Tile) isLeaseResponse_Lease() {}
+
+// Every tile left is leased, ask again later.
+type LeaseResponse_Wait struct {
+	Wait bool
+}
+
+func (*LeaseResponse_Wait) isLeaseResponse_Lease() {}
+
+// The job is done.
+type LeaseResponse_Done struct {
+	Done bool
+}
+
+func (*LeaseResponse_Done) isLeaseResponse_Lease() {}
+
+// GetTile returns the tile of the message, or its zero value if the message is nil
+func (m *LeaseResponse) GetTile() *Tile {
+	if v, ok := m.GetLease().(*LeaseResponse_Tile); ok {
+		return v.Tile
+	}
+	return nil
+}
+
+// GetWait returns the wait of the message, or its zero value if the message is nil
+func (m *LeaseResponse) GetWait() bool {
+	if v, ok := m.GetLease().(*LeaseResponse_Wait); ok {
+		return v.Wait
+	}
+	return false
+}
+
+// GetDone returns the done of the message, or its zero value if the message is nil
+func (m *LeaseResponse) GetDone() bool {
+	if v, ok := m.GetLease().(*LeaseResponse_Done); ok {
+		return v.Done
+	}
+	return false
+}
+
+// GetLease returns the lease of the message, or nil if the message is nil
+func (m *LeaseResponse) GetLease() isLeaseResponse_Lease {
+	if m == nil {
+		return nil
+	}
+	return m.Lease
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *LeaseResponse) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	switch v := m.Lease.(type) {
+	case *LeaseResponse_Tile:
+		b = wire.AppendBytes(b, 1, v.Tile.Marshal())
+	case *LeaseResponse_Wait:
+		b = wire.AppendBool(b, 2, v.Wait)
+	case *LeaseResponse_Done:
+		b = wire.AppendBool(b, 3, v.Done)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *LeaseResponse) Unmarshal(b []byte) error {
+	*m = LeaseResponse{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			v := new(Tile)
+			r.Message(v)
+			m.Lease = &LeaseResponse_Tile{v}
+		case 2:
+			m.Lease = &LeaseResponse_Wait{r.Bool()}
+		case 3:
+			m.Lease = &LeaseResponse_Done{r.Bool()}
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type ResultResponse struct {
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *ResultResponse) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *ResultResponse) Unmarshal(b []byte) error {
+	*m = ResultResponse{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
@@ -0,0 +1,813 @@
+// Code generated by protogen from sceneedit.proto. DO NOT EDIT.
+
+// Package sceneeditpb holds the messages of sceneedit.proto.
+//
+// Scene mutation protocol for external editors.
+//
+// A DCC plugin (e.g. a Blender add-on) keeps a gotrace scene in sync by
+// sending mutations as the user edits, and receives the re-rendered parts
+// of the frame. Every mutation maps to one of the live editing methods of
+// scene.Scene (AddShape, RemoveShape, MoveShape, AddLight, RemoveLight,
+// MoveLight, SetCamera), so only the dirty region of the render is traced
+// again. Package sceneedit serves the service over HTTP.
+package sceneeditpb
+
+import (
+	"github.com/ProjectMOA/goraytrace/proto/internal/wire"
+	"github.com/ProjectMOA/goraytrace/proto/scenepb"
+)
+
+// LoadScene replaces the whole scene with a scene file in the JSON format
+// used by scene.LoadSceneFile.
+type LoadScene struct {
+	SceneJson []byte
+}
+
+// GetSceneJson returns the scene_json of the message, or its zero value if the message is nil
+func (m *LoadScene) GetSceneJson() []byte {
+	if m == nil {
+		return nil
+	}
+	return m.SceneJson
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *LoadScene) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if len(m.SceneJson) > 0 {
+		b = wire.AppendBytes(b, 1, m.SceneJson)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *LoadScene) Unmarshal(b []byte) error {
+	*m = LoadScene{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.SceneJson = r.Bytes()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type AddShape struct {
+	Shape *scenepb.Shape
+}
+
+// GetShape returns the shape of the message, or its zero value if the message is nil
+func (m *AddShape) GetShape() *scenepb.Shape {
+	if m == nil {
+		return nil
+	}
+	return m.Shape
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *AddShape) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Shape != nil {
+		b = wire.AppendBytes(b, 1, m.Shape.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *AddShape) Unmarshal(b []byte) error {
+	*m = AddShape{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Shape = new(scenepb.Shape)
+			r.Message(m.Shape)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Shapes and lights are addressed by their index in the scene, which
+// shifts down by one for every element removed before them.
+type RemoveShape struct {
+	Index int32
+}
+
+// GetIndex returns the index of the message, or its zero value if the message is nil
+func (m *RemoveShape) GetIndex() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Index
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *RemoveShape) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Index != 0 {
+		b = wire.AppendInt32(b, 1, m.Index)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *RemoveShape) Unmarshal(b []byte) error {
+	*m = RemoveShape{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Index = r.Int32()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type MoveShape struct {
+	Index  int32
+	Offset *scenepb.Vector3
+}
+
+// GetIndex returns the index of the message, or its zero value if the message is nil
+func (m *MoveShape) GetIndex() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Index
+}
+
+// GetOffset returns the offset of the message, or its zero value if the message is nil
+func (m *MoveShape) GetOffset() *scenepb.Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Offset
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *MoveShape) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Index != 0 {
+		b = wire.AppendInt32(b, 1, m.Index)
+	}
+	if m.Offset != nil {
+		b = wire.AppendBytes(b, 2, m.Offset.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *MoveShape) Unmarshal(b []byte) error {
+	*m = MoveShape{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Index = r.Int32()
+		case 2:
+			m.Offset = new(scenepb.Vector3)
+			r.Message(m.Offset)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type AddLight struct {
+	Light *scenepb.PointLight
+}
+
+// GetLight returns the light of the message, or its zero value if the message is nil
+func (m *AddLight) GetLight() *scenepb.PointLight {
+	if m == nil {
+		return nil
+	}
+	return m.Light
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *AddLight) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Light != nil {
+		b = wire.AppendBytes(b, 1, m.Light.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *AddLight) Unmarshal(b []byte) error {
+	*m = AddLight{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Light = new(scenepb.PointLight)
+			r.Message(m.Light)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type RemoveLight struct {
+	Index int32
+}
+
+// GetIndex returns the index of the message, or its zero value if the message is nil
+func (m *RemoveLight) GetIndex() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Index
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *RemoveLight) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Index != 0 {
+		b = wire.AppendInt32(b, 1, m.Index)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *RemoveLight) Unmarshal(b []byte) error {
+	*m = RemoveLight{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Index = r.Int32()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type MoveLight struct {
+	Index  int32
+	Offset *scenepb.Vector3
+}
+
+// GetIndex returns the index of the message, or its zero value if the message is nil
+func (m *MoveLight) GetIndex() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Index
+}
+
+// GetOffset returns the offset of the message, or its zero value if the message is nil
+func (m *MoveLight) GetOffset() *scenepb.Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Offset
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *MoveLight) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Index != 0 {
+		b = wire.AppendInt32(b, 1, m.Index)
+	}
+	if m.Offset != nil {
+		b = wire.AppendBytes(b, 2, m.Offset.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *MoveLight) Unmarshal(b []byte) error {
+	*m = MoveLight{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Index = r.Int32()
+		case 2:
+			m.Offset = new(scenepb.Vector3)
+			r.Message(m.Offset)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type SetCamera struct {
+	Camera *scenepb.Camera
+}
+
+// GetCamera returns the camera of the message, or its zero value if the message is nil
+func (m *SetCamera) GetCamera() *scenepb.Camera {
+	if m == nil {
+		return nil
+	}
+	return m.Camera
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *SetCamera) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Camera != nil {
+		b = wire.AppendBytes(b, 1, m.Camera.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *SetCamera) Unmarshal(b []byte) error {
+	*m = SetCamera{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Camera = new(scenepb.Camera)
+			r.Message(m.Camera)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Mutation struct {
+	// Mutation is one of *Mutation_LoadScene, *Mutation_AddShape, *Mutation_RemoveShape, *Mutation_MoveShape, *Mutation_AddLight, *Mutation_RemoveLight, *Mutation_MoveLight or *Mutation_SetCamera, or nil
+	Mutation isMutation_Mutation
+}
+
+type isMutation_Mutation interface {
+	isMutation_Mutation()
+}
+
+type Mutation_LoadScene struct {
+	LoadScene *LoadScene
+}
+
+func (*Mutation_LoadScene) isMutation_Mutation() {}
+
+type Mutation_AddShape struct {
+	AddShape *AddShape
+}
+
+func (*Mutation_AddShape) isMutation_Mutation() {}
+
+type Mutation_RemoveShape struct {
+	RemoveShape *RemoveShape
+}
+
+func (*Mutation_RemoveShape) isMutation_Mutation() {}
+
+type Mutation_MoveShape struct {
+	MoveShape *MoveShape
+}
+
+func (*Mutation_MoveShape) isMutation_Mutation() {}
+
+type Mutation_AddLight struct {
+	AddLight *AddLight
+}
+
+func (*Mutation_AddLight) isMutation_Mutation() {}
+
+type Mutation_RemoveLight struct {
+	RemoveLight *RemoveLight
+}
+
+func (*Mutation_RemoveLight) isMutation_Mutation() {}
+
+type Mutation_MoveLight struct {
+	MoveLight *MoveLight
+}
+
+func (*Mutation_MoveLight) isMutation_Mutation() {}
+
+type Mutation_SetCamera struct {
+	SetCamera *SetCamera
+}
+
+func (*Mutation_SetCamera) isMutation_Mutation() {}
+
+// GetLoadScene returns the load_scene of the message, or its zero value if the message is nil
+func (m *Mutation) GetLoadScene() *LoadScene {
+	if v, ok := m.GetMutation().(*Mutation_LoadScene); ok {
+		return v.LoadScene
+	}
+	return nil
+}
+
+// GetAddShape returns the add_shape of the message, or its zero value if the message is nil
+func (m *Mutation) GetAddShape() *AddShape {
+	if v, ok := m.GetMutation().(*Mutation_AddShape); ok {
+		return v.AddShape
+	}
+	return nil
+}
+
+// GetRemoveShape returns the remove_shape of the message, or its zero value if the message is nil
+func (m *Mutation) GetRemoveShape() *RemoveShape {
+	if v, ok := m.GetMutation().(*Mutation_RemoveShape); ok {
+		return v.RemoveShape
+	}
+	return nil
+}
+
+// GetMoveShape returns the move_shape of the message, or its zero value if the message is nil
+func (m *Mutation) GetMoveShape() *MoveShape {
+	if v, ok := m.GetMutation().(*Mutation_MoveShape); ok {
+		return v.MoveShape
+	}
+	return nil
+}
+
+// GetAddLight returns the add_light of the message, or its zero value if the message is nil
+func (m *Mutation) GetAddLight() *AddLight {
+	if v, ok := m.GetMutation().(*Mutation_AddLight); ok {
+		return v.AddLight
+	}
+	return nil
+}
+
+// GetRemoveLight returns the remove_light of the message, or its zero value if the message is nil
+func (m *Mutation) GetRemoveLight() *RemoveLight {
+	if v, ok := m.GetMutation().(*Mutation_RemoveLight); ok {
+		return v.RemoveLight
+	}
+	return nil
+}
+
+// GetMoveLight returns the move_light of the message, or its zero value if the message is nil
+func (m *Mutation) GetMoveLight() *MoveLight {
+	if v, ok := m.GetMutation().(*Mutation_MoveLight); ok {
+		return v.MoveLight
+	}
+	return nil
+}
+
+// GetSetCamera returns the set_camera of the message, or its zero value if the message is nil
+func (m *Mutation) GetSetCamera() *SetCamera {
+	if v, ok := m.GetMutation().(*Mutation_SetCamera); ok {
+		return v.SetCamera
+	}
+	return nil
+}
+
+// GetMutation returns the mutation of the message, or nil if the message is nil
+func (m *Mutation) GetMutation() isMutation_Mutation {
+	if m == nil {
+		return nil
+	}
+	return m.Mutation
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Mutation) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	switch v := m.Mutation.(type) {
+	case *Mutation_LoadScene:
+		b = wire.AppendBytes(b, 1, v.LoadScene.Marshal())
+	case *Mutation_AddShape:
+		b = wire.AppendBytes(b, 2, v.AddShape.Marshal())
+	case *Mutation_RemoveShape:
+		b = wire.AppendBytes(b, 3, v.RemoveShape.Marshal())
+	case *Mutation_MoveShape:
+		b = wire.AppendBytes(b, 4, v.MoveShape.Marshal())
+	case *Mutation_AddLight:
+		b = wire.AppendBytes(b, 5, v.AddLight.Marshal())
+	case *Mutation_RemoveLight:
+		b = wire.AppendBytes(b, 6, v.RemoveLight.Marshal())
+	case *Mutation_MoveLight:
+		b = wire.AppendBytes(b, 7, v.MoveLight.Marshal())
+	case *Mutation_SetCamera:
+		b = wire.AppendBytes(b, 8, v.SetCamera.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Mutation) Unmarshal(b []byte) error {
+	*m = Mutation{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			v := new(LoadScene)
+			r.Message(v)
+			m.Mutation = &Mutation_LoadScene{v}
+		case 2:
+			v := new(AddShape)
+			r.Message(v)
+			m.Mutation = &Mutation_AddShape{v}
+		case 3:
+			v := new(RemoveShape)
+			r.Message(v)
+			m.Mutation = &Mutation_RemoveShape{v}
+		case 4:
+			v := new(MoveShape)
+			r.Message(v)
+			m.Mutation = &Mutation_MoveShape{v}
+		case 5:
+			v := new(AddLight)
+			r.Message(v)
+			m.Mutation = &Mutation_AddLight{v}
+		case 6:
+			v := new(RemoveLight)
+			r.Message(v)
+			m.Mutation = &Mutation_RemoveLight{v}
+		case 7:
+			v := new(MoveLight)
+			r.Message(v)
+			m.Mutation = &Mutation_MoveLight{v}
+		case 8:
+			v := new(SetCamera)
+			r.Message(v)
+			m.Mutation = &Mutation_SetCamera{v}
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type MutationBatch struct {
+	// Mutations are applied in order before the render is updated.
+	Mutations []*Mutation
+}
+
+// GetMutations returns the mutations of the message, or its zero value if the message is nil
+func (m *MutationBatch) GetMutations() []*Mutation {
+	if m == nil {
+		return nil
+	}
+	return m.Mutations
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *MutationBatch) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Mutations {
+		b = wire.AppendBytes(b, 1, v.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *MutationBatch) Unmarshal(b []byte) error {
+	*m = MutationBatch{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			v := new(Mutation)
+			r.Message(v)
+			m.Mutations = append(m.Mutations, v)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type MutationResult struct {
+	// Errors are reported per mutation, in the same order.
+	Errors []string
+}
+
+// GetErrors returns the errors of the message, or its zero value if the message is nil
+func (m *MutationResult) GetErrors() []string {
+	if m == nil {
+		return nil
+	}
+	return m.Errors
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *MutationResult) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Errors {
+		b = wire.AppendString(b, 1, v)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *MutationResult) Unmarshal(b []byte) error {
+	*m = MutationResult{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Errors = append(m.Errors, r.Text())
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type RenderRequest struct {
+	Width  int32
+	Height int32
+}
+
+// GetWidth returns the width of the message, or its zero value if the message is nil
+func (m *RenderRequest) GetWidth() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Width
+}
+
+// GetHeight returns the height of the message, or its zero value if the message is nil
+func (m *RenderRequest) GetHeight() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Height
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *RenderRequest) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Width != 0 {
+		b = wire.AppendInt32(b, 1, m.Width)
+	}
+	if m.Height != 0 {
+		b = wire.AppendInt32(b, 2, m.Height)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *RenderRequest) Unmarshal(b []byte) error {
+	*m = RenderRequest{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Width = r.Int32()
+		case 2:
+			m.Height = r.Int32()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// RegionUpdate carries the pixels of a rectangle of the render as 8 bit
+// non premultiplied RGBA rows.
+type RegionUpdate struct {
+	X      int32
+	Y      int32
+	Width  int32
+	Height int32
+	Rgba   []byte
+}
+
+// GetX returns the x of the message, or its zero value if the message is nil
+func (m *RegionUpdate) GetX() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.X
+}
+
+// GetY returns the y of the message, or its zero value if the message is nil
+func (m *RegionUpdate) GetY() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Y
+}
+
+// GetWidth returns the width of the message, or its zero value if the message is nil
+func (m *RegionUpdate) GetWidth() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Width
+}
+
+// GetHeight returns the height of the message, or its zero value if the message is nil
+func (m *RegionUpdate) GetHeight() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Height
+}
+
+// GetRgba returns the rgba of the message, or its zero value if the message is nil
+func (m *RegionUpdate) GetRgba() []byte {
+	if m == nil {
+		return nil
+	}
+	return m.Rgba
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *RegionUpdate) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.X != 0 {
+		b = wire.AppendInt32(b, 1, m.X)
+	}
+	if m.Y != 0 {
+		b = wire.AppendInt32(b, 2, m.Y)
+	}
+	if m.Width != 0 {
+		b = wire.AppendInt32(b, 3, m.Width)
+	}
+	if m.Height != 0 {
+		b = wire.AppendInt32(b, 4, m.Height)
+	}
+	if len(m.Rgba) > 0 {
+		b = wire.AppendBytes(b, 5, m.Rgba)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *RegionUpdate) Unmarshal(b []byte) error {
+	*m = RegionUpdate{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.X = r.Int32()
+		case 2:
+			m.Y = r.Int32()
+		case 3:
+			m.Width = r.Int32()
+		case 4:
+			m.Height = r.Int32()
+		case 5:
+			m.Rgba = r.Bytes()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
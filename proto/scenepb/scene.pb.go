@@ -0,0 +1,1757 @@
+// Code generated by protogen from scene.proto. DO NOT EDIT.
+
+// Package scenepb holds the messages of scene.proto.
+//
+// Scene messages shared by every protocol that sends scenes.
+//
+// They mirror the scene file format, but hold the shapes as they are
+// after loading: heightfields carry their samples and curves files are
+// expanded into curves, so a receiver on another machine needs no other
+// files. Colors are linear RGB and angles are in radians.
+package scenepb
+
+import (
+	"sort"
+
+	"github.com/ProjectMOA/goraytrace/proto/internal/wire"
+)
+
+type Vector3 struct {
+	X float64
+	Y float64
+	Z float64
+}
+
+// GetX returns the x of the message, or its zero value if the message is nil
+func (m *Vector3) GetX() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.X
+}
+
+// GetY returns the y of the message, or its zero value if the message is nil
+func (m *Vector3) GetY() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.Y
+}
+
+// GetZ returns the z of the message, or its zero value if the message is nil
+func (m *Vector3) GetZ() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.Z
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Vector3) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.X != 0 {
+		b = wire.AppendDouble(b, 1, m.X)
+	}
+	if m.Y != 0 {
+		b = wire.AppendDouble(b, 2, m.Y)
+	}
+	if m.Z != 0 {
+		b = wire.AppendDouble(b, 3, m.Z)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Vector3) Unmarshal(b []byte) error {
+	*m = Vector3{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.X = r.Double()
+		case 2:
+			m.Y = r.Double()
+		case 3:
+			m.Z = r.Double()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Color struct {
+	R float64
+	G float64
+	B float64
+}
+
+// GetR returns the r of the message, or its zero value if the message is nil
+func (m *Color) GetR() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.R
+}
+
+// GetG returns the g of the message, or its zero value if the message is nil
+func (m *Color) GetG() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.G
+}
+
+// GetB returns the b of the message, or its zero value if the message is nil
+func (m *Color) GetB() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.B
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Color) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.R != 0 {
+		b = wire.AppendDouble(b, 1, m.R)
+	}
+	if m.G != 0 {
+		b = wire.AppendDouble(b, 2, m.G)
+	}
+	if m.B != 0 {
+		b = wire.AppendDouble(b, 3, m.B)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Color) Unmarshal(b []byte) error {
+	*m = Color{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.R = r.Double()
+		case 2:
+			m.G = r.Double()
+		case 3:
+			m.B = r.Double()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Camera mirrors camera.PinHole.
+type Camera struct {
+	FocalPoint        *Vector3
+	Up                *Vector3
+	Right             *Vector3
+	Towards           *Vector3
+	FieldOfView       float64
+	ViewPlaneDistance float64
+}
+
+// GetFocalPoint returns the focal_point of the message, or its zero value if the message is nil
+func (m *Camera) GetFocalPoint() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.FocalPoint
+}
+
+// GetUp returns the up of the message, or its zero value if the message is nil
+func (m *Camera) GetUp() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Up
+}
+
+// GetRight returns the right of the message, or its zero value if the message is nil
+func (m *Camera) GetRight() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Right
+}
+
+// GetTowards returns the towards of the message, or its zero value if the message is nil
+func (m *Camera) GetTowards() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Towards
+}
+
+// GetFieldOfView returns the field_of_view of the message, or its zero value if the message is nil
+func (m *Camera) GetFieldOfView() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.FieldOfView
+}
+
+// GetViewPlaneDistance returns the view_plane_distance of the message, or its zero value if the message is nil
+func (m *Camera) GetViewPlaneDistance() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.ViewPlaneDistance
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Camera) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.FocalPoint != nil {
+		b = wire.AppendBytes(b, 1, m.FocalPoint.Marshal())
+	}
+	if m.Up != nil {
+		b = wire.AppendBytes(b, 2, m.Up.Marshal())
+	}
+	if m.Right != nil {
+		b = wire.AppendBytes(b, 3, m.Right.Marshal())
+	}
+	if m.Towards != nil {
+		b = wire.AppendBytes(b, 4, m.Towards.Marshal())
+	}
+	if m.FieldOfView != 0 {
+		b = wire.AppendDouble(b, 5, m.FieldOfView)
+	}
+	if m.ViewPlaneDistance != 0 {
+		b = wire.AppendDouble(b, 6, m.ViewPlaneDistance)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Camera) Unmarshal(b []byte) error {
+	*m = Camera{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.FocalPoint = new(Vector3)
+			r.Message(m.FocalPoint)
+		case 2:
+			m.Up = new(Vector3)
+			r.Message(m.Up)
+		case 3:
+			m.Right = new(Vector3)
+			r.Message(m.Right)
+		case 4:
+			m.Towards = new(Vector3)
+			r.Message(m.Towards)
+		case 5:
+			m.FieldOfView = r.Double()
+		case 6:
+			m.ViewPlaneDistance = r.Double()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Lambertian struct {
+	Albedo *Color
+}
+
+// GetAlbedo returns the albedo of the message, or its zero value if the message is nil
+func (m *Lambertian) GetAlbedo() *Color {
+	if m == nil {
+		return nil
+	}
+	return m.Albedo
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Lambertian) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Albedo != nil {
+		b = wire.AppendBytes(b, 1, m.Albedo.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Lambertian) Unmarshal(b []byte) error {
+	*m = Lambertian{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Albedo = new(Color)
+			r.Message(m.Albedo)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Glossy struct {
+	Albedo   *Color
+	Exponent float64
+}
+
+// GetAlbedo returns the albedo of the message, or its zero value if the message is nil
+func (m *Glossy) GetAlbedo() *Color {
+	if m == nil {
+		return nil
+	}
+	return m.Albedo
+}
+
+// GetExponent returns the exponent of the message, or its zero value if the message is nil
+func (m *Glossy) GetExponent() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.Exponent
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Glossy) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Albedo != nil {
+		b = wire.AppendBytes(b, 1, m.Albedo.Marshal())
+	}
+	if m.Exponent != 0 {
+		b = wire.AppendDouble(b, 2, m.Exponent)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Glossy) Unmarshal(b []byte) error {
+	*m = Glossy{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Albedo = new(Color)
+			r.Message(m.Albedo)
+		case 2:
+			m.Exponent = r.Double()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Subsurface struct {
+	Albedo       *Color
+	MeanFreePath *Color
+}
+
+// GetAlbedo returns the albedo of the message, or its zero value if the message is nil
+func (m *Subsurface) GetAlbedo() *Color {
+	if m == nil {
+		return nil
+	}
+	return m.Albedo
+}
+
+// GetMeanFreePath returns the mean_free_path of the message, or its zero value if the message is nil
+func (m *Subsurface) GetMeanFreePath() *Color {
+	if m == nil {
+		return nil
+	}
+	return m.MeanFreePath
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Subsurface) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Albedo != nil {
+		b = wire.AppendBytes(b, 1, m.Albedo.Marshal())
+	}
+	if m.MeanFreePath != nil {
+		b = wire.AppendBytes(b, 2, m.MeanFreePath.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Subsurface) Unmarshal(b []byte) error {
+	*m = Subsurface{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Albedo = new(Color)
+			r.Message(m.Albedo)
+		case 2:
+			m.MeanFreePath = new(Color)
+			r.Message(m.MeanFreePath)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Material struct {
+	// Material is one of *Material_Lambertian, *Material_Glossy or *Material_Subsurface, or nil
+	Material isMaterial_Material
+}
+
+type isMaterial_Material interface {
+	isMaterial_Material()
+}
+
+type Material_Lambertian struct {
+	Lambertian *Lambertian
+}
+
+func (*Material_Lambertian) isMaterial_Material() {}
+
+type Material_Glossy struct {
+	Glossy *Glossy
+}
+
+func (*Material_Glossy) isMaterial_Material() {}
+
+type Material_Subsurface struct {
+	Subsurface *Subsurface
+}
+
+func (*Material_Subsurface) isMaterial_Material() {}
+
+// GetLambertian returns the lambertian of the message, or its zero value if the message is nil
+func (m *Material) GetLambertian() *Lambertian {
+	if v, ok := m.GetMaterial().(*Material_Lambertian); ok {
+		return v.Lambertian
+	}
+	return nil
+}
+
+// GetGlossy returns the glossy of the message, or its zero value if the message is nil
+func (m *Material) GetGlossy() *Glossy {
+	if v, ok := m.GetMaterial().(*Material_Glossy); ok {
+		return v.Glossy
+	}
+	return nil
+}
+
+// GetSubsurface returns the subsurface of the message, or its zero value if the message is nil
+func (m *Material) GetSubsurface() *Subsurface {
+	if v, ok := m.GetMaterial().(*Material_Subsurface); ok {
+		return v.Subsurface
+	}
+	return nil
+}
+
+// GetMaterial returns the material of the message, or nil if the message is nil
+func (m *Material) GetMaterial() isMaterial_Material {
+	if m == nil {
+		return nil
+	}
+	return m.Material
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Material) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	switch v := m.Material.(type) {
+	case *Material_Lambertian:
+		b = wire.AppendBytes(b, 1, v.Lambertian.Marshal())
+	case *Material_Glossy:
+		b = wire.AppendBytes(b, 2, v.Glossy.Marshal())
+	case *Material_Subsurface:
+		b = wire.AppendBytes(b, 3, v.Subsurface.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Material) Unmarshal(b []byte) error {
+	*m = Material{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			v := new(Lambertian)
+			r.Message(v)
+			m.Material = &Material_Lambertian{v}
+		case 2:
+			v := new(Glossy)
+			r.Message(v)
+			m.Material = &Material_Glossy{v}
+		case 3:
+			v := new(Subsurface)
+			r.Message(v)
+			m.Material = &Material_Subsurface{v}
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Shapes without a material have the default one, a white lambertian.
+type Sphere struct {
+	Position *Vector3
+	Radius   float64
+	Name     string
+	Material *Material
+}
+
+// GetPosition returns the position of the message, or its zero value if the message is nil
+func (m *Sphere) GetPosition() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Position
+}
+
+// GetRadius returns the radius of the message, or its zero value if the message is nil
+func (m *Sphere) GetRadius() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.Radius
+}
+
+// GetName returns the name of the message, or its zero value if the message is nil
+func (m *Sphere) GetName() string {
+	if m == nil {
+		return ""
+	}
+	return m.Name
+}
+
+// GetMaterial returns the material of the message, or its zero value if the message is nil
+func (m *Sphere) GetMaterial() *Material {
+	if m == nil {
+		return nil
+	}
+	return m.Material
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Sphere) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Position != nil {
+		b = wire.AppendBytes(b, 1, m.Position.Marshal())
+	}
+	if m.Radius != 0 {
+		b = wire.AppendDouble(b, 2, m.Radius)
+	}
+	if m.Name != "" {
+		b = wire.AppendString(b, 3, m.Name)
+	}
+	if m.Material != nil {
+		b = wire.AppendBytes(b, 4, m.Material.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Sphere) Unmarshal(b []byte) error {
+	*m = Sphere{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Position = new(Vector3)
+			r.Message(m.Position)
+		case 2:
+			m.Radius = r.Double()
+		case 3:
+			m.Name = r.Text()
+		case 4:
+			m.Material = new(Material)
+			r.Message(m.Material)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Heightfield holds its columns*rows samples row after row, rows growing
+// along Z.
+type Heightfield struct {
+	Position *Vector3
+	Size     *Vector3
+	Name     string
+	Material *Material
+	Columns  int32
+	Rows     int32
+	Heights  []float64
+}
+
+// GetPosition returns the position of the message, or its zero value if the message is nil
+func (m *Heightfield) GetPosition() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Position
+}
+
+// GetSize returns the size of the message, or its zero value if the message is nil
+func (m *Heightfield) GetSize() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Size
+}
+
+// GetName returns the name of the message, or its zero value if the message is nil
+func (m *Heightfield) GetName() string {
+	if m == nil {
+		return ""
+	}
+	return m.Name
+}
+
+// GetMaterial returns the material of the message, or its zero value if the message is nil
+func (m *Heightfield) GetMaterial() *Material {
+	if m == nil {
+		return nil
+	}
+	return m.Material
+}
+
+// GetColumns returns the columns of the message, or its zero value if the message is nil
+func (m *Heightfield) GetColumns() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Columns
+}
+
+// GetRows returns the rows of the message, or its zero value if the message is nil
+func (m *Heightfield) GetRows() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Rows
+}
+
+// GetHeights returns the heights of the message, or its zero value if the message is nil
+func (m *Heightfield) GetHeights() []float64 {
+	if m == nil {
+		return nil
+	}
+	return m.Heights
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Heightfield) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Position != nil {
+		b = wire.AppendBytes(b, 1, m.Position.Marshal())
+	}
+	if m.Size != nil {
+		b = wire.AppendBytes(b, 2, m.Size.Marshal())
+	}
+	if m.Name != "" {
+		b = wire.AppendString(b, 3, m.Name)
+	}
+	if m.Material != nil {
+		b = wire.AppendBytes(b, 4, m.Material.Marshal())
+	}
+	if m.Columns != 0 {
+		b = wire.AppendInt32(b, 5, m.Columns)
+	}
+	if m.Rows != 0 {
+		b = wire.AppendInt32(b, 6, m.Rows)
+	}
+	if len(m.Heights) > 0 {
+		b = wire.AppendDoubles(b, 7, m.Heights)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Heightfield) Unmarshal(b []byte) error {
+	*m = Heightfield{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Position = new(Vector3)
+			r.Message(m.Position)
+		case 2:
+			m.Size = new(Vector3)
+			r.Message(m.Size)
+		case 3:
+			m.Name = r.Text()
+		case 4:
+			m.Material = new(Material)
+			r.Message(m.Material)
+		case 5:
+			m.Columns = r.Int32()
+		case 6:
+			m.Rows = r.Int32()
+		case 7:
+			m.Heights = r.Doubles(m.Heights)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Curve holds the 4 control points and widths of a cubic Bézier curve.
+type Curve struct {
+	Points   []*Vector3
+	Widths   []float64
+	Name     string
+	Material *Material
+}
+
+// GetPoints returns the points of the message, or its zero value if the message is nil
+func (m *Curve) GetPoints() []*Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Points
+}
+
+// GetWidths returns the widths of the message, or its zero value if the message is nil
+func (m *Curve) GetWidths() []float64 {
+	if m == nil {
+		return nil
+	}
+	return m.Widths
+}
+
+// GetName returns the name of the message, or its zero value if the message is nil
+func (m *Curve) GetName() string {
+	if m == nil {
+		return ""
+	}
+	return m.Name
+}
+
+// GetMaterial returns the material of the message, or its zero value if the message is nil
+func (m *Curve) GetMaterial() *Material {
+	if m == nil {
+		return nil
+	}
+	return m.Material
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Curve) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Points {
+		b = wire.AppendBytes(b, 1, v.Marshal())
+	}
+	if len(m.Widths) > 0 {
+		b = wire.AppendDoubles(b, 2, m.Widths)
+	}
+	if m.Name != "" {
+		b = wire.AppendString(b, 3, m.Name)
+	}
+	if m.Material != nil {
+		b = wire.AppendBytes(b, 4, m.Material.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Curve) Unmarshal(b []byte) error {
+	*m = Curve{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			v := new(Vector3)
+			r.Message(v)
+			m.Points = append(m.Points, v)
+		case 2:
+			m.Widths = r.Doubles(m.Widths)
+		case 3:
+			m.Name = r.Text()
+		case 4:
+			m.Material = new(Material)
+			r.Message(m.Material)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Shape struct {
+	// Shape is one of *Shape_Sphere, *Shape_Heightfield or *Shape_Curve, or nil
+	Shape isShape_Shape
+}
+
+type isShape_Shape interface {
+	isShape_Shape()
+}
+
+type Shape_Sphere struct {
+	Sphere *Sphere
+}
+
+func (*Shape_Sphere) isShape_Shape() {}
+
+type Shape_Heightfield struct {
+	Heightfield *Heightfield
+}
+
+func (*Shape_Heightfield) isShape_Shape() {}
+
+type Shape_Curve struct {
+	Curve *Curve
+}
+
+func (*Shape_Curve) isShape_Shape() {}
+
+// GetSphere returns the sphere of the message, or its zero value if the message is nil
+func (m *Shape) GetSphere() *Sphere {
+	if v, ok := m.GetShape().(*Shape_Sphere); ok {
+		return v.Sphere
+	}
+	return nil
+}
+
+// GetHeightfield returns the heightfield of the message, or its zero value if the message is nil
+func (m *Shape) GetHeightfield() *Heightfield {
+	if v, ok := m.GetShape().(*Shape_Heightfield); ok {
+		return v.Heightfield
+	}
+	return nil
+}
+
+// GetCurve returns the curve of the message, or its zero value if the message is nil
+func (m *Shape) GetCurve() *Curve {
+	if v, ok := m.GetShape().(*Shape_Curve); ok {
+		return v.Curve
+	}
+	return nil
+}
+
+// GetShape returns the shape of the message, or nil if the message is nil
+func (m *Shape) GetShape() isShape_Shape {
+	if m == nil {
+		return nil
+	}
+	return m.Shape
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Shape) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	switch v := m.Shape.(type) {
+	case *Shape_Sphere:
+		b = wire.AppendBytes(b, 1, v.Sphere.Marshal())
+	case *Shape_Heightfield:
+		b = wire.AppendBytes(b, 2, v.Heightfield.Marshal())
+	case *Shape_Curve:
+		b = wire.AppendBytes(b, 3, v.Curve.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Shape) Unmarshal(b []byte) error {
+	*m = Shape{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			v := new(Sphere)
+			r.Message(v)
+			m.Shape = &Shape_Sphere{v}
+		case 2:
+			v := new(Heightfield)
+			r.Message(v)
+			m.Shape = &Shape_Heightfield{v}
+		case 3:
+			v := new(Curve)
+			r.Message(v)
+			m.Shape = &Shape_Curve{v}
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type PointLight struct {
+	Position  *Vector3
+	Intensity *Color
+}
+
+// GetPosition returns the position of the message, or its zero value if the message is nil
+func (m *PointLight) GetPosition() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Position
+}
+
+// GetIntensity returns the intensity of the message, or its zero value if the message is nil
+func (m *PointLight) GetIntensity() *Color {
+	if m == nil {
+		return nil
+	}
+	return m.Intensity
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *PointLight) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Position != nil {
+		b = wire.AppendBytes(b, 1, m.Position.Marshal())
+	}
+	if m.Intensity != nil {
+		b = wire.AppendBytes(b, 2, m.Intensity.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *PointLight) Unmarshal(b []byte) error {
+	*m = PointLight{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Position = new(Vector3)
+			r.Message(m.Position)
+		case 2:
+			m.Intensity = new(Color)
+			r.Message(m.Intensity)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type SphereLight struct {
+	Position *Vector3
+	Radius   float64
+	Radiance *Color
+	TwoSided bool
+}
+
+// GetPosition returns the position of the message, or its zero value if the message is nil
+func (m *SphereLight) GetPosition() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Position
+}
+
+// GetRadius returns the radius of the message, or its zero value if the message is nil
+func (m *SphereLight) GetRadius() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.Radius
+}
+
+// GetRadiance returns the radiance of the message, or its zero value if the message is nil
+func (m *SphereLight) GetRadiance() *Color {
+	if m == nil {
+		return nil
+	}
+	return m.Radiance
+}
+
+// GetTwoSided returns the two_sided of the message, or its zero value if the message is nil
+func (m *SphereLight) GetTwoSided() bool {
+	if m == nil {
+		return false
+	}
+	return m.TwoSided
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *SphereLight) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Position != nil {
+		b = wire.AppendBytes(b, 1, m.Position.Marshal())
+	}
+	if m.Radius != 0 {
+		b = wire.AppendDouble(b, 2, m.Radius)
+	}
+	if m.Radiance != nil {
+		b = wire.AppendBytes(b, 3, m.Radiance.Marshal())
+	}
+	if m.TwoSided {
+		b = wire.AppendBool(b, 4, m.TwoSided)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *SphereLight) Unmarshal(b []byte) error {
+	*m = SphereLight{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Position = new(Vector3)
+			r.Message(m.Position)
+		case 2:
+			m.Radius = r.Double()
+		case 3:
+			m.Radiance = new(Color)
+			r.Message(m.Radiance)
+		case 4:
+			m.TwoSided = r.Bool()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Light struct {
+	// Light is one of *Light_Point or *Light_Sphere, or nil
+	Light isLight_Light
+}
+
+type isLight_Light interface {
+	isLight_Light()
+}
+
+type Light_Point struct {
+	Point *PointLight
+}
+
+func (*Light_Point) isLight_Light() {}
+
+type Light_Sphere struct {
+	Sphere *SphereLight
+}
+
+func (*Light_Sphere) isLight_Light() {}
+
+// GetPoint returns the point of the message, or its zero value if the message is nil
+func (m *Light) GetPoint() *PointLight {
+	if v, ok := m.GetLight().(*Light_Point); ok {
+		return v.Point
+	}
+	return nil
+}
+
+// GetSphere returns the sphere of the message, or its zero value if the message is nil
+func (m *Light) GetSphere() *SphereLight {
+	if v, ok := m.GetLight().(*Light_Sphere); ok {
+		return v.Sphere
+	}
+	return nil
+}
+
+// GetLight returns the light of the message, or nil if the message is nil
+func (m *Light) GetLight() isLight_Light {
+	if m == nil {
+		return nil
+	}
+	return m.Light
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Light) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	switch v := m.Light.(type) {
+	case *Light_Point:
+		b = wire.AppendBytes(b, 1, v.Point.Marshal())
+	case *Light_Sphere:
+		b = wire.AppendBytes(b, 2, v.Sphere.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Light) Unmarshal(b []byte) error {
+	*m = Light{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			v := new(PointLight)
+			r.Message(v)
+			m.Light = &Light_Point{v}
+		case 2:
+			v := new(SphereLight)
+			r.Message(v)
+			m.Light = &Light_Sphere{v}
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Medium mirrors medium.Homogeneous.
+type Medium struct {
+	Absorption *Color
+	Scattering *Color
+	G          float64
+}
+
+// GetAbsorption returns the absorption of the message, or its zero value if the message is nil
+func (m *Medium) GetAbsorption() *Color {
+	if m == nil {
+		return nil
+	}
+	return m.Absorption
+}
+
+// GetScattering returns the scattering of the message, or its zero value if the message is nil
+func (m *Medium) GetScattering() *Color {
+	if m == nil {
+		return nil
+	}
+	return m.Scattering
+}
+
+// GetG returns the g of the message, or its zero value if the message is nil
+func (m *Medium) GetG() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.G
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Medium) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Absorption != nil {
+		b = wire.AppendBytes(b, 1, m.Absorption.Marshal())
+	}
+	if m.Scattering != nil {
+		b = wire.AppendBytes(b, 2, m.Scattering.Marshal())
+	}
+	if m.G != 0 {
+		b = wire.AppendDouble(b, 3, m.G)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Medium) Unmarshal(b []byte) error {
+	*m = Medium{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Absorption = new(Color)
+			r.Message(m.Absorption)
+		case 2:
+			m.Scattering = new(Color)
+			r.Message(m.Scattering)
+		case 3:
+			m.G = r.Double()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// RenderSettings mirrors scene.Settings. Fields left at 0 or empty take
+// the value of scene.DefaultSettings, and color_space and integrator take
+// the names of the scene file format, such as "srgb" or "bdpt".
+type RenderSettings struct {
+	Samples           int32
+	MinSamples        int32
+	AdaptiveThreshold float64
+	VolumeStep        float64
+	Seed              uint64
+	ColorSpace        string
+	Integrator        string
+	MaxDepth          int32
+	Photons           int32
+	PhotonRadius      float64
+	AoRays            int32
+	AoDistance        float64
+}
+
+// GetSamples returns the samples of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetSamples() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Samples
+}
+
+// GetMinSamples returns the min_samples of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetMinSamples() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.MinSamples
+}
+
+// GetAdaptiveThreshold returns the adaptive_threshold of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetAdaptiveThreshold() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.AdaptiveThreshold
+}
+
+// GetVolumeStep returns the volume_step of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetVolumeStep() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.VolumeStep
+}
+
+// GetSeed returns the seed of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetSeed() uint64 {
+	if m == nil {
+		return 0
+	}
+	return m.Seed
+}
+
+// GetColorSpace returns the color_space of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetColorSpace() string {
+	if m == nil {
+		return ""
+	}
+	return m.ColorSpace
+}
+
+// GetIntegrator returns the integrator of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetIntegrator() string {
+	if m == nil {
+		return ""
+	}
+	return m.Integrator
+}
+
+// GetMaxDepth returns the max_depth of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetMaxDepth() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.MaxDepth
+}
+
+// GetPhotons returns the photons of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetPhotons() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Photons
+}
+
+// GetPhotonRadius returns the photon_radius of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetPhotonRadius() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.PhotonRadius
+}
+
+// GetAoRays returns the ao_rays of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetAoRays() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.AoRays
+}
+
+// GetAoDistance returns the ao_distance of the message, or its zero value if the message is nil
+func (m *RenderSettings) GetAoDistance() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.AoDistance
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *RenderSettings) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Samples != 0 {
+		b = wire.AppendInt32(b, 1, m.Samples)
+	}
+	if m.MinSamples != 0 {
+		b = wire.AppendInt32(b, 2, m.MinSamples)
+	}
+	if m.AdaptiveThreshold != 0 {
+		b = wire.AppendDouble(b, 3, m.AdaptiveThreshold)
+	}
+	if m.VolumeStep != 0 {
+		b = wire.AppendDouble(b, 4, m.VolumeStep)
+	}
+	if m.Seed != 0 {
+		b = wire.AppendUint64(b, 5, m.Seed)
+	}
+	if m.ColorSpace != "" {
+		b = wire.AppendString(b, 6, m.ColorSpace)
+	}
+	if m.Integrator != "" {
+		b = wire.AppendString(b, 7, m.Integrator)
+	}
+	if m.MaxDepth != 0 {
+		b = wire.AppendInt32(b, 8, m.MaxDepth)
+	}
+	if m.Photons != 0 {
+		b = wire.AppendInt32(b, 9, m.Photons)
+	}
+	if m.PhotonRadius != 0 {
+		b = wire.AppendDouble(b, 10, m.PhotonRadius)
+	}
+	if m.AoRays != 0 {
+		b = wire.AppendInt32(b, 11, m.AoRays)
+	}
+	if m.AoDistance != 0 {
+		b = wire.AppendDouble(b, 12, m.AoDistance)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *RenderSettings) Unmarshal(b []byte) error {
+	*m = RenderSettings{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Samples = r.Int32()
+		case 2:
+			m.MinSamples = r.Int32()
+		case 3:
+			m.AdaptiveThreshold = r.Double()
+		case 4:
+			m.VolumeStep = r.Double()
+		case 5:
+			m.Seed = r.Uint64()
+		case 6:
+			m.ColorSpace = r.Text()
+		case 7:
+			m.Integrator = r.Text()
+		case 8:
+			m.MaxDepth = r.Int32()
+		case 9:
+			m.Photons = r.Int32()
+		case 10:
+			m.PhotonRadius = r.Double()
+		case 11:
+			m.AoRays = r.Int32()
+		case 12:
+			m.AoDistance = r.Double()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Scene struct {
+	Camera *Camera
+	Shapes []*Shape
+	Lights []*Light
+	// The scene has no medium if it's missing.
+	Medium *Medium
+	Render *RenderSettings
+}
+
+// GetCamera returns the camera of the message, or its zero value if the message is nil
+func (m *Scene) GetCamera() *Camera {
+	if m == nil {
+		return nil
+	}
+	return m.Camera
+}
+
+// GetShapes returns the shapes of the message, or its zero value if the message is nil
+func (m *Scene) GetShapes() []*Shape {
+	if m == nil {
+		return nil
+	}
+	return m.Shapes
+}
+
+// GetLights returns the lights of the message, or its zero value if the message is nil
+func (m *Scene) GetLights() []*Light {
+	if m == nil {
+		return nil
+	}
+	return m.Lights
+}
+
+// GetMedium returns the medium of the message, or its zero value if the message is nil
+func (m *Scene) GetMedium() *Medium {
+	if m == nil {
+		return nil
+	}
+	return m.Medium
+}
+
+// GetRender returns the render of the message, or its zero value if the message is nil
+func (m *Scene) GetRender() *RenderSettings {
+	if m == nil {
+		return nil
+	}
+	return m.Render
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Scene) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Camera != nil {
+		b = wire.AppendBytes(b, 1, m.Camera.Marshal())
+	}
+	for _, v := range m.Shapes {
+		b = wire.AppendBytes(b, 2, v.Marshal())
+	}
+	for _, v := range m.Lights {
+		b = wire.AppendBytes(b, 3, v.Marshal())
+	}
+	if m.Medium != nil {
+		b = wire.AppendBytes(b, 4, m.Medium.Marshal())
+	}
+	if m.Render != nil {
+		b = wire.AppendBytes(b, 5, m.Render.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Scene) Unmarshal(b []byte) error {
+	*m = Scene{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Camera = new(Camera)
+			r.Message(m.Camera)
+		case 2:
+			v := new(Shape)
+			r.Message(v)
+			m.Shapes = append(m.Shapes, v)
+		case 3:
+			v := new(Light)
+			r.Message(v)
+			m.Lights = append(m.Lights, v)
+		case 4:
+			m.Medium = new(Medium)
+			r.Message(m.Medium)
+		case 5:
+			m.Render = new(RenderSettings)
+			r.Message(m.Render)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Keyframe mirrors animation.Keyframe: the position offset and the
+// rotation of the axis and angle at the time in seconds.
+type Keyframe struct {
+	Time     float64
+	Position *Vector3
+	Axis     *Vector3
+	Angle    float64
+}
+
+// GetTime returns the time of the message, or its zero value if the message is nil
+func (m *Keyframe) GetTime() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.Time
+}
+
+// GetPosition returns the position of the message, or its zero value if the message is nil
+func (m *Keyframe) GetPosition() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Position
+}
+
+// GetAxis returns the axis of the message, or its zero value if the message is nil
+func (m *Keyframe) GetAxis() *Vector3 {
+	if m == nil {
+		return nil
+	}
+	return m.Axis
+}
+
+// GetAngle returns the angle of the message, or its zero value if the message is nil
+func (m *Keyframe) GetAngle() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.Angle
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Keyframe) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Time != 0 {
+		b = wire.AppendDouble(b, 1, m.Time)
+	}
+	if m.Position != nil {
+		b = wire.AppendBytes(b, 2, m.Position.Marshal())
+	}
+	if m.Axis != nil {
+		b = wire.AppendBytes(b, 3, m.Axis.Marshal())
+	}
+	if m.Angle != 0 {
+		b = wire.AppendDouble(b, 4, m.Angle)
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Keyframe) Unmarshal(b []byte) error {
+	*m = Keyframe{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Time = r.Double()
+		case 2:
+			m.Position = new(Vector3)
+			r.Message(m.Position)
+		case 3:
+			m.Axis = new(Vector3)
+			r.Message(m.Axis)
+		case 4:
+			m.Angle = r.Double()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+type Track struct {
+	// "linear", or "cubic" for Catmull-Rom and Squad interpolation.
+	Interpolation string
+	Keys          []*Keyframe
+}
+
+// GetInterpolation returns the interpolation of the message, or its zero value if the message is nil
+func (m *Track) GetInterpolation() string {
+	if m == nil {
+		return ""
+	}
+	return m.Interpolation
+}
+
+// GetKeys returns the keys of the message, or its zero value if the message is nil
+func (m *Track) GetKeys() []*Keyframe {
+	if m == nil {
+		return nil
+	}
+	return m.Keys
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Track) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Interpolation != "" {
+		b = wire.AppendString(b, 1, m.Interpolation)
+	}
+	for _, v := range m.Keys {
+		b = wire.AppendBytes(b, 2, v.Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Track) Unmarshal(b []byte) error {
+	*m = Track{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Interpolation = r.Text()
+		case 2:
+			v := new(Keyframe)
+			r.Message(v)
+			m.Keys = append(m.Keys, v)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// Animation mirrors animation.Animation.
+type Animation struct {
+	Fps    float64
+	Frames int32
+	Camera *Track
+	// Tracks of the shapes, keyed by their names.
+	Shapes map[string]*Track
+}
+
+// GetFps returns the fps of the message, or its zero value if the message is nil
+func (m *Animation) GetFps() float64 {
+	if m == nil {
+		return 0
+	}
+	return m.Fps
+}
+
+// GetFrames returns the frames of the message, or its zero value if the message is nil
+func (m *Animation) GetFrames() int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Frames
+}
+
+// GetCamera returns the camera of the message, or its zero value if the message is nil
+func (m *Animation) GetCamera() *Track {
+	if m == nil {
+		return nil
+	}
+	return m.Camera
+}
+
+// GetShapes returns the shapes of the message, or its zero value if the message is nil
+func (m *Animation) GetShapes() map[string]*Track {
+	if m == nil {
+		return nil
+	}
+	return m.Shapes
+}
+
+// Marshal returns the message in the protobuf wire format
+func (m *Animation) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Fps != 0 {
+		b = wire.AppendDouble(b, 1, m.Fps)
+	}
+	if m.Frames != 0 {
+		b = wire.AppendInt32(b, 2, m.Frames)
+	}
+	if m.Camera != nil {
+		b = wire.AppendBytes(b, 3, m.Camera.Marshal())
+	}
+	keys := make([]string, 0, len(m.Shapes))
+	for k := range m.Shapes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = wire.AppendEntry(b, 4, k, m.Shapes[k].Marshal())
+	}
+	return b
+}
+
+// Unmarshal replaces the message with the one in b, in the protobuf
+// wire format. The fields it doesn't know are skipped.
+func (m *Animation) Unmarshal(b []byte) error {
+	*m = Animation{}
+	r := wire.NewReader(b)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			m.Fps = r.Double()
+		case 2:
+			m.Frames = r.Int32()
+		case 3:
+			m.Camera = new(Track)
+			r.Message(m.Camera)
+		case 4:
+			if m.Shapes == nil {
+				m.Shapes = make(map[string]*Track)
+			}
+			var k string
+			v := new(Track)
+			r.Entry(&k, v)
+			m.Shapes[k] = v
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
@@ -0,0 +1,214 @@
+// Package query answers visibility and collision queries about geometry
+// with the acceleration structures and the intersection tests of the
+// renderer, without rendering it: where rays hit first, whether they hit
+// anything at all, everything they go through, and bundles of rays at
+// once.
+package query
+
+import (
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// skipDistance is how far past a hit the rays of Hits look for the next
+// one. Hits nearer than that to each other, such as the ones on the edges
+// that triangles share, count once.
+const skipDistance = 1e-4
+
+// Ray is a ray to query. It hits the shapes up to MaxDistance along it,
+// or as far as it goes if MaxDistance is 0.
+type Ray struct {
+	Source, Direction math3d.Vector3
+	MaxDistance       float64
+}
+
+// Hit is where a ray hits a shape
+type Hit struct {
+	// Shape is the index of the shape hit, -1 if the ray hit nothing
+	Shape int
+	// Distance is how far along the ray the shape is hit, in the units of
+	// the scene whatever the length of the direction of the ray
+	Distance float64
+	// Point is where the ray hits the shape and Normal is the unit normal
+	// of the shape there, which faces the front of the surface and not
+	// necessarily the ray
+	Point, Normal math3d.Vector3
+}
+
+// miss is the hit of the rays that hit nothing
+var miss = Hit{Shape: -1, Distance: math.Inf(1)}
+
+// Geometry is a set of shapes to query, which many goroutines may query at
+// once. The shapes must not change while it's queried.
+type Geometry struct {
+	shapes    []shape.Shape
+	structure accel.Accelerator
+}
+
+// New returns the geometry of the shapes, building a bounding volume
+// hierarchy over them
+func New(shapes []shape.Shape) *Geometry {
+	primitives := make([]accel.Primitive, len(shapes))
+	for i, sh := range shapes {
+		primitives[i] = sh
+	}
+	return &Geometry{shapes: shapes, structure: accel.NewBVH(primitives)}
+}
+
+// Load returns the geometry of the shapes of the scene file, with the ones
+// of the delayed shapes loaded. The cameras, the lights and the settings of
+// the file don't matter to it.
+func Load(path string) (*Geometry, error) {
+	s, _, err := scene.ParseSceneFile(path, scene.Lenient)
+	if err != nil {
+		return nil, err
+	}
+	return FromScene(s), nil
+}
+
+// FromScene returns the geometry of the shapes of the scene, with the ones
+// of the delayed shapes loaded
+func FromScene(s *scene.Scene) *Geometry {
+	var shapes []shape.Shape
+	for _, sh := range s.Shapes {
+		if d, ok := sh.(*scene.Delayed); ok {
+			shapes = append(shapes, d.Shapes()...)
+			continue
+		}
+		shapes = append(shapes, sh)
+	}
+	return New(shapes)
+}
+
+// Shapes returns the shapes of the geometry, in the order the hits refer
+// to them
+func (g *Geometry) Shapes() []shape.Shape {
+	return g.shapes
+}
+
+// Bounds returns the bounding box of the geometry
+func (g *Geometry) Bounds() *math3d.AABB {
+	return g.structure.Bounds()
+}
+
+// lightRay returns the lightray of the ray, with a unit direction, and how
+// far it goes. ok is false if the ray has no direction.
+func (r *Ray) lightRay() (lr math3d.LightRay, max float64, ok bool) {
+	length := r.Direction.Abs()
+	if !(length > 0) || math.IsInf(length, 1) {
+		return lr, 0, false
+	}
+	max = r.MaxDistance
+	if max <= 0 {
+		max = math.MaxFloat64
+	}
+	return math3d.LightRay{Source: r.Source, Direction: r.Direction.MultiplyV(1 / length)}, max, true
+}
+
+// hit returns the hit of the lightray with the shape with the index at the
+// distance
+func (g *Geometry) hit(lr *math3d.LightRay, index int, distance float64) Hit {
+	h := shape.HitAt(g.shapes[index], lr, distance)
+	return Hit{Shape: index, Distance: distance, Point: h.Point, Normal: h.Normal}
+}
+
+// ClosestHit returns where the ray hits the nearest shape, or a hit with a
+// Shape of -1 and an infinite Distance if it hits none
+func (g *Geometry) ClosestHit(r Ray) Hit {
+	lr, max, ok := r.lightRay()
+	if !ok {
+		return miss
+	}
+	distance, index := g.structure.Intersect(&lr)
+	if index < 0 || distance > max {
+		return miss
+	}
+	return g.hit(&lr, index, distance)
+}
+
+// AnyHit returns whether the ray hits any shape, which is faster to find
+// out than where it hits the nearest one, such as whether two points see
+// each other
+func (g *Geometry) AnyHit(r Ray) bool {
+	lr, max, ok := r.lightRay()
+	return ok && g.structure.Occluded(&lr, max)
+}
+
+// Hits returns where the ray hits the n nearest shapes, or all of them if
+// n is 0, from the nearest. A shape is hit as many times as the ray goes
+// through its surface, such as the near and the far side of a sphere.
+func (g *Geometry) Hits(r Ray, n int) []Hit {
+	lr, max, ok := r.lightRay()
+	if !ok {
+		return nil
+	}
+	var hits []Hit
+	travelled := 0.0
+	for n == 0 || len(hits) < n {
+		distance, index := g.structure.Intersect(&lr)
+		if index < 0 || travelled+distance > max {
+			break
+		}
+		h := g.hit(&lr, index, distance)
+		travelled += distance
+		h.Distance = travelled
+		hits = append(hits, h)
+		lr.Source = h.Point.AddV(lr.Direction.MultiplyV(skipDistance))
+		travelled += skipDistance
+	}
+	return hits
+}
+
+// ClosestHits returns the closest hits of a bundle of rays, in the order of
+// the rays, querying them on as many goroutines as the process may run
+// at once
+func (g *Geometry) ClosestHits(rays []Ray) []Hit {
+	hits := make([]Hit, len(rays))
+	inParallel(len(rays), bundleSize, func(i int) {
+		hits[i] = g.ClosestHit(rays[i])
+	})
+	return hits
+}
+
+// AnyHits returns whether every ray of a bundle hits any shape, in the
+// order of the rays, as ClosestHits does
+func (g *Geometry) AnyHits(rays []Ray) []bool {
+	hit := make([]bool, len(rays))
+	inParallel(len(rays), bundleSize, func(i int) {
+		hit[i] = g.AnyHit(rays[i])
+	})
+	return hit
+}
+
+// bundleSize is how many rays of a bundle every goroutine queries at a
+// time
+const bundleSize = 256
+
+// inParallel calls f with every index up to n on as many goroutines as the
+// process may run at once, which take size indices at a time
+func inParallel(n, size int, f func(i int)) {
+	starts := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range starts {
+				for i := start; i < n && i < start+size; i++ {
+					f(i)
+				}
+			}
+		}()
+	}
+	for start := 0; start < n; start += size {
+		starts <- start
+	}
+	close(starts)
+	wg.Wait()
+}
@@ -0,0 +1,91 @@
+package query
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// row returns 3 balls of radius 1 along Z, at 5, 10 and 15
+func row() *Geometry {
+	var shapes []shape.Shape
+	for _, z := range []float64{5, 10, 15} {
+		shapes = append(shapes, &shape.Sphere{Position: math3d.Vector3{Z: z}, Radius: 1})
+	}
+	return New(shapes)
+}
+
+func TestClosestHit(t *testing.T) {
+	g := row()
+	h := g.ClosestHit(Ray{Direction: math3d.Vector3{Z: 2}})
+	if h.Shape != 0 || math.Abs(h.Distance-4) > 1e-9 || !h.Normal.Equal(&math3d.Vector3{Z: -1}) {
+		t.Errorf("The ray should hit the first ball 4 away, not %+v", h)
+	}
+	if h := g.ClosestHit(Ray{Direction: math3d.UnitZ, MaxDistance: 3}); h.Shape != -1 || !math.IsInf(h.Distance, 1) {
+		t.Errorf("The ray shouldn't reach the balls, not %+v", h)
+	}
+	if h := g.ClosestHit(Ray{Direction: math3d.UnitY}); h.Shape != -1 {
+		t.Errorf("The ray should miss the balls, not %+v", h)
+	}
+	if h := g.ClosestHit(Ray{}); h.Shape != -1 {
+		t.Errorf("A ray without a direction should hit nothing, not %+v", h)
+	}
+	if !g.AnyHit(Ray{Source: math3d.Vector3{Y: 0.5}, Direction: math3d.UnitZ}) || g.AnyHit(Ray{Direction: math3d.UnitZ, MaxDistance: 3.9}) {
+		t.Error("Only the rays that reach the balls should hit them")
+	}
+}
+
+func TestHits(t *testing.T) {
+	g := row()
+	hits := g.Hits(Ray{Direction: math3d.UnitZ}, 0)
+	if len(hits) != 6 {
+		t.Fatalf("The ray should go through both sides of the 3 balls, not %+v", hits)
+	}
+	for i, h := range hits {
+		want := 5 + 5*float64(i/2) + float64(2*(i%2)-1)
+		if h.Shape != i/2 || math.Abs(h.Distance-want) > 1e-9 {
+			t.Errorf("Hit %d should be on ball %d %g away, not %+v", i, i/2, want, h)
+		}
+	}
+	if hits := g.Hits(Ray{Direction: math3d.UnitZ}, 2); len(hits) != 2 || hits[1].Shape != 0 {
+		t.Errorf("Expected the 2 nearest hits, on the first ball, not %+v", hits)
+	}
+	if hits := g.Hits(Ray{Direction: math3d.UnitZ, MaxDistance: 10}, 0); len(hits) != 3 {
+		t.Errorf("Expected the 3 hits within 10, not %+v", hits)
+	}
+}
+
+func TestBundles(t *testing.T) {
+	g := row()
+	rays := make([]Ray, 1000)
+	for i := range rays {
+		// Fanning out from the origin, so the first ones hit the balls
+		rays[i] = Ray{Direction: math3d.Vector3{X: float64(i) / 1000, Z: 1}}
+	}
+	hits, any := g.ClosestHits(rays), g.AnyHits(rays)
+	for i := range rays {
+		if h := g.ClosestHit(rays[i]); h != hits[i] || any[i] != (h.Shape >= 0) {
+			t.Fatalf("Ray %d of the bundle should hit as it does on its own, not %+v", i, hits[i])
+		}
+	}
+	if hits[0].Shape != 0 || hits[999].Shape != -1 {
+		t.Error("The bundle should hit the balls only at first")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	g, err := Load("../scene-examples/mesh.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Through the middle of the mesh
+	h := g.ClosestHit(Ray{Source: math3d.Vector3{X: 0.01, Y: -0.1, Z: -1.5}, Direction: math3d.UnitZ})
+	if h.Shape < 0 || !g.Bounds().Contains(&h.Point) {
+		t.Errorf("The ray should hit the mesh of the scene file, not %+v", h)
+	}
+	if _, ok := g.Shapes()[h.Shape].(*shape.Triangle); !ok {
+		t.Errorf("The shape hit should be a triangle of the mesh, not %T", g.Shapes()[h.Shape])
+	}
+}
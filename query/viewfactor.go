@@ -0,0 +1,207 @@
+package query
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Surface is a surface of the geometry made of some of its shapes, such
+// as the walls of a room or the panels of a radiator, between which view
+// factors are computed
+type Surface struct {
+	Name string
+	// Shapes are the indices of the shapes of the surface
+	Shapes []int
+}
+
+// SurfacesByName returns the surfaces of the shapes with the same names,
+// as shape.NameOf names them, sorted by name
+func (g *Geometry) SurfacesByName() []Surface {
+	byName := make(map[string][]int)
+	for i, sh := range g.shapes {
+		name := shape.NameOf(sh, i)
+		byName[name] = append(byName[name], i)
+	}
+	surfaces := make([]Surface, 0, len(byName))
+	for name, shapes := range byName {
+		surfaces = append(surfaces, Surface{Name: name, Shapes: shapes})
+	}
+	sort.Slice(surfaces, func(i, j int) bool { return surfaces[i].Name < surfaces[j].Name })
+	return surfaces
+}
+
+// ViewFactors returns the view factors between the surfaces: the fraction
+// of the radiation that leaves every surface diffusely and reaches every
+// surface before any other shape, which is also the fraction of its view,
+// weighted by the cosine, that every surface covers. Radiation leaves the
+// front of the surfaces, the side their normals face.
+//
+// The view factors are estimated by Monte Carlo, with the given number of
+// rays leaving every surface, from points evenly spread over its area
+// towards a cosine weighted hemisphere, so the error shrinks with the
+// square root of the rays. They are in a row for every surface with a
+// column for every surface and another for the sky, what the rays that
+// hit nothing reach. The rays that hit shapes that aren't part of any
+// surface only count in the rows, which add up to less than 1.
+//
+// It fails if any surface has shapes other than triangles and spheres,
+// which are the ones whose surfaces it can sample.
+func (g *Geometry) ViewFactors(surfaces []Surface, rays int, seed uint64) ([][]float64, error) {
+	if rays < 1 {
+		return nil, fmt.Errorf("there must be at least 1 ray")
+	}
+	// The surface of every shape, -1 for the shapes of none
+	surfaceOf := make([]int, len(g.shapes))
+	for i := range surfaceOf {
+		surfaceOf[i] = -1
+	}
+	samplers := make([]*areaSampler, len(surfaces))
+	for i, s := range surfaces {
+		for _, index := range s.Shapes {
+			if index < 0 || index >= len(g.shapes) {
+				return nil, fmt.Errorf("%s: shape %d is out of range", s.Name, index)
+			}
+			surfaceOf[index] = i
+		}
+		sampler, err := g.newAreaSampler(s.Shapes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s.Name, err)
+		}
+		samplers[i] = sampler
+	}
+	// The rays of every surface are traced in batches, each with its own
+	// stream, so the estimates don't depend on the goroutines
+	batches := (rays + viewFactorBatch - 1) / viewFactorBatch
+	counts := make([][]int, len(surfaces)*batches)
+	inParallel(len(counts), 1, func(item int) {
+		i, batch := item/batches, item%batches
+		rng := sampling.New(seed, uint64(item))
+		counts[item] = make([]int, len(surfaces)+1)
+		for n := batch * viewFactorBatch; n < rays && n < (batch+1)*viewFactorBatch; n++ {
+			index, point, normal := samplers[i].sample(rng)
+			direction := sampling.CosineHemisphere(&normal, rng.Float64(), rng.Float64())
+			lr := math3d.LightRay{Source: point, Direction: direction, Origin: g.shapes[index]}
+			_, hit := g.structure.Intersect(&lr)
+			switch {
+			case hit < 0:
+				counts[item][len(surfaces)]++
+			case surfaceOf[hit] >= 0:
+				counts[item][surfaceOf[hit]]++
+			}
+		}
+	})
+	factors := make([][]float64, len(surfaces))
+	for i := range factors {
+		factors[i] = make([]float64, len(surfaces)+1)
+		for _, batch := range counts[i*batches : (i+1)*batches] {
+			for j, c := range batch {
+				factors[i][j] += float64(c) / float64(rays)
+			}
+		}
+	}
+	return factors, nil
+}
+
+// viewFactorBatch is how many rays of a surface a goroutine traces at a
+// time while computing view factors
+const viewFactorBatch = 4096
+
+// areaSampler picks points evenly spread over the area of some shapes
+type areaSampler struct {
+	shapes []int
+	// cdf holds the sums of the areas of the shapes up to every one,
+	// which picks them as often as they are big
+	cdf []float64
+	g   *Geometry
+}
+
+// newAreaSampler returns the sampler of the area of the shapes with the
+// indices, which must be triangles or spheres
+func (g *Geometry) newAreaSampler(shapes []int) (*areaSampler, error) {
+	a := &areaSampler{shapes: shapes, g: g}
+	total := 0.0
+	for _, index := range shapes {
+		var area float64
+		switch sh := g.shapes[index].(type) {
+		case *shape.Triangle:
+			area = triangleArea(sh)
+		case *shape.MeshTriangle:
+			area = triangleArea(sh.Triangle())
+		case *shape.Sphere:
+			area = 4 * math.Pi * sh.Radius * sh.Radius
+		default:
+			return nil, fmt.Errorf("the surfaces of %T can't be sampled, only the ones of triangles and spheres", sh)
+		}
+		total += area
+		a.cdf = append(a.cdf, total)
+	}
+	if !(total > 0) {
+		return nil, fmt.Errorf("the surface has no area")
+	}
+	return a, nil
+}
+
+// sample returns the index of the shape of a point of the surfaces, the
+// point and the unit normal of the shape there
+func (a *areaSampler) sample(rng *sampling.Rand) (int, math3d.Vector3, math3d.Vector3) {
+	total := a.cdf[len(a.cdf)-1]
+	i := sort.SearchFloat64s(a.cdf, rng.Float64()*total)
+	if i == len(a.cdf) {
+		i--
+	}
+	index := a.shapes[i]
+	u, v := rng.Float64(), rng.Float64()
+	switch sh := a.g.shapes[index].(type) {
+	case *shape.Sphere:
+		normal := sampling.UniformCone(&math3d.UnitZ, -1, u, v)
+		return index, sh.Position.AddV(normal.MultiplyV(sh.Radius)), normal
+	case *shape.MeshTriangle:
+		point, normal := triangleSample(sh.Triangle(), u, v)
+		return index, point, normal
+	}
+	point, normal := triangleSample(a.g.shapes[index].(*shape.Triangle), u, v)
+	return index, point, normal
+}
+
+// triangleArea returns the area of the triangle
+func triangleArea(t *shape.Triangle) float64 {
+	return t.Vertices[1].SubtractV(t.Vertices[0]).CrossV(t.Vertices[2].SubtractV(t.Vertices[0])).Abs() / 2
+}
+
+// triangleSample returns the point of the triangle that u and v in [0, 1)
+// pick, evenly spread over its area, and the normal of its front face
+func triangleSample(t *shape.Triangle, u, v float64) (math3d.Vector3, math3d.Vector3) {
+	a, b, c := t.Vertices[0], t.Vertices[1], t.Vertices[2]
+	root := math.Sqrt(u)
+	point := a.MultiplyV(1 - root).AddV(b.MultiplyV(root * (1 - v))).AddV(c.MultiplyV(root * v))
+	return point, b.SubtractV(a).CrossV(c.SubtractV(a)).NormalizedV()
+}
+
+// WriteViewFactorsCSV writes the view factors between the surfaces that
+// ViewFactors returns to w as a table, with a row for every surface and a
+// column for every surface and the sky
+func WriteViewFactorsCSV(w io.Writer, surfaces []Surface, factors [][]float64) error {
+	out := csv.NewWriter(w)
+	header := []string{"from"}
+	for _, s := range surfaces {
+		header = append(header, s.Name)
+	}
+	out.Write(append(header, "sky"))
+	for i, row := range factors {
+		record := []string{surfaces[i].Name}
+		for _, factor := range row {
+			record = append(record, strconv.FormatFloat(factor, 'g', -1, 64))
+		}
+		out.Write(record)
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,79 @@
+package query
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// square returns the 2 triangles of a square of the size centered on the
+// point at the height, facing up or down
+func square(name string, size, height float64, up bool) []shape.Shape {
+	h := size / 2
+	a, b, c, d := math3d.Vector3{X: -h, Y: height, Z: -h}, math3d.Vector3{X: h, Y: height, Z: -h}, math3d.Vector3{X: h, Y: height, Z: h}, math3d.Vector3{X: -h, Y: height, Z: h}
+	if !up {
+		b, d = d, b
+	}
+	return []shape.Shape{
+		&shape.Triangle{Name: name, Vertices: [3]math3d.Vector3{a, d, c}},
+		&shape.Triangle{Name: name, Vertices: [3]math3d.Vector3{a, c, b}},
+	}
+}
+
+func TestViewFactors(t *testing.T) {
+	// Two squares of side 1 facing each other 1 apart see 0.19982 of each
+	// other
+	shapes := append(square("floor", 1, 0, true), square("ceiling", 1, 1, false)...)
+	g := New(shapes)
+	surfaces := g.SurfacesByName()
+	if len(surfaces) != 2 || surfaces[0].Name != "ceiling" || len(surfaces[1].Shapes) != 2 {
+		t.Fatalf("Expected the ceiling and the floor, not %+v", surfaces)
+	}
+	factors, err := g.ViewFactors(surfaces, 200000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range factors {
+		if math.Abs(row[1-i]-0.19982) > 0.005 || row[i] != 0 || math.Abs(row[0]+row[1]+row[2]-1) > 1e-9 {
+			t.Errorf("Row %d should be 0.19982 of the other square and the rest of the sky, not %v", i, row)
+		}
+	}
+
+	// A ball above a huge floor sees half of it
+	shapes = append(square("floor", 10000, 0, true), &shape.Sphere{Name: "ball", Position: math3d.Vector3{Y: 2}, Radius: 1})
+	g = New(shapes)
+	factors, err = g.ViewFactors(g.SurfacesByName(), 100000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ball := factors[0]; math.Abs(ball[1]-0.5) > 0.01 || math.Abs(ball[2]-0.5) > 0.01 {
+		t.Errorf("The ball should see the floor in half its view and the sky in the other, not %v", ball)
+	}
+
+	for _, bad := range [][]Surface{
+		{{Name: "nothing", Shapes: []int{}}},
+		{{Name: "out", Shapes: []int{7}}},
+	} {
+		if _, err := g.ViewFactors(bad, 10, 1); err == nil {
+			t.Errorf("%+v should be an error", bad)
+		}
+	}
+	curves := New([]shape.Shape{&shape.Curve{}})
+	if _, err := curves.ViewFactors(curves.SurfacesByName(), 10, 1); err == nil {
+		t.Error("The surfaces of curves can't be sampled")
+	}
+}
+
+func TestWriteViewFactorsCSV(t *testing.T) {
+	surfaces := []Surface{{Name: "floor"}, {Name: "ceiling"}}
+	var b strings.Builder
+	if err := WriteViewFactorsCSV(&b, surfaces, [][]float64{{0, 0.2, 0.8}, {0.2, 0, 0.8}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "from,floor,ceiling,sky\nfloor,0,0.2,0.8\nceiling,0.2,0,0.8\n"; b.String() != want {
+		t.Errorf("The table should be\n%s\nnot\n%s", want, b.String())
+	}
+}
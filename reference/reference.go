@@ -0,0 +1,148 @@
+// Package reference builds scenes whose radiance is known exactly, so the
+// integrators can be checked against it quantitatively. Every case is a
+// scene, a camera ray and the radiance arriving along it, which the
+// estimates of an unbiased integrator approach as they take more samples.
+//
+// Scenes have neither environment lights nor disk lights, so a two sided
+// sphere light around the scene stands for a constant environment, and a
+// sphere light, whose irradiance also has a closed form, lights the plane.
+package reference
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Case is a scene with a camera ray whose radiance is known
+type Case struct {
+	Name  string
+	Scene *scene.Scene
+	Ray   math3d.LightRay
+	// Radiance returns the radiance arriving along Ray, the same in every
+	// channel, made of the light that bounces from minDepth to maxDepth
+	// times on its way from the lights
+	Radiance func(minDepth, maxDepth int) float64
+	// Inside is true if the camera sees the inside of a shape, which only
+	// the integrators that light both sides of surfaces see lit
+	Inside bool
+}
+
+// Cases returns the standard reference cases
+func Cases() []*Case {
+	return []*Case{
+		Environment(0.7, 2),
+		Furnace(0.6, 1),
+		LitPlane(0.8, 10, 0.5, math3d.Vector3{X: 1, Y: 2}),
+	}
+}
+
+// grey returns a material reflecting the albedo in every channel
+func grey(albedo float64) *material.Lambertian {
+	return &material.Lambertian{Albedo: image.Color{R: albedo, G: albedo, B: albedo}}
+}
+
+// bounces returns value if light bouncing depth times is between minDepth
+// and maxDepth, and 0 otherwise
+func bounces(depth, minDepth, maxDepth int, value float64) float64 {
+	if depth < minDepth || depth > maxDepth {
+		return 0
+	}
+	return value
+}
+
+// Environment returns a lambertian sphere under a constant environment of
+// the radiance. The sphere is convex, so its points only see the
+// environment, which they reflect once.
+func Environment(albedo, radiance float64) *Case {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Radius: 1, Material: grey(albedo)})
+	s.AddLight(&lighting.SphereLight{Radius: 100, Radiance: image.Color{R: radiance, G: radiance, B: radiance}, TwoSided: true})
+	return &Case{
+		Name:  "environment",
+		Scene: s,
+		Ray:   math3d.LightRay{Source: math3d.Vector3{X: 0.3, Z: -5}, Direction: math3d.UnitZ},
+		Radiance: func(minDepth, maxDepth int) float64 {
+			return bounces(1, minDepth, maxDepth, EnvironmentRadiance(albedo, radiance))
+		},
+	}
+}
+
+// EnvironmentRadiance returns the radiance that a convex lambertian shape
+// reflects under a constant environment of the radiance
+func EnvironmentRadiance(albedo, radiance float64) float64 {
+	return albedo * radiance
+}
+
+// Furnace returns the inside of a closed lambertian sphere lit by a point
+// light at its center. Every point of the wall gets the same light from
+// the center and from the rest of the wall, so the light bouncing k times
+// is the direct light times albedo^(k-1).
+func Furnace(albedo, intensity float64) *Case {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Radius: 1, Material: grey(albedo)})
+	s.AddLight(&lighting.PointLight{Intensity: image.Color{R: intensity, G: intensity, B: intensity}})
+	return &Case{
+		Name:  "furnace",
+		Scene: s,
+		Ray:   math3d.LightRay{Source: math3d.Vector3{X: 0.3, Y: -0.2}, Direction: math3d.Vector3{X: 1, Y: 1, Z: 1}.NormalizedV()},
+		Radiance: func(minDepth, maxDepth int) float64 {
+			return FurnaceRadiance(albedo, intensity, minDepth, maxDepth)
+		},
+		Inside: true,
+	}
+}
+
+// FurnaceRadiance returns the radiance of the wall of a closed lambertian
+// sphere lit by a point light at its center, made of the light bouncing
+// from minDepth to maxDepth times. Point lights give the same irradiance
+// at every distance, so it doesn't depend on the radius.
+func FurnaceRadiance(albedo, intensity float64, minDepth, maxDepth int) float64 {
+	radiance := 0.0
+	for depth := maxInt(1, minDepth); depth <= maxDepth; depth++ {
+		radiance += intensity / math.Pi * math.Pow(albedo, float64(depth))
+	}
+	return radiance
+}
+
+// LitPlane returns a lambertian plane at y = 0 lit by a sphere light
+// centered at light, seen from straight above the origin. The plane is a
+// huge sphere whose top is at the origin, where it's exactly flat.
+func LitPlane(albedo, radiance, radius float64, light math3d.Vector3) *Case {
+	const planeRadius = 1000
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -planeRadius}, Radius: planeRadius, Material: grey(albedo)})
+	s.AddLight(&lighting.SphereLight{Position: light, Radius: radius, Radiance: image.Color{R: radiance, G: radiance, B: radiance}})
+	distance := light.Abs()
+	irradiance := SphereLightIrradiance(radiance, radius, distance, light.Y/distance)
+	return &Case{
+		Name:  "plane",
+		Scene: s,
+		Ray:   math3d.LightRay{Source: math3d.Vector3{Y: 0.5}, Direction: math3d.Vector3{Y: -1}},
+		Radiance: func(minDepth, maxDepth int) float64 {
+			return bounces(1, minDepth, maxDepth, albedo/math.Pi*irradiance)
+		},
+	}
+}
+
+// SphereLightIrradiance returns the irradiance that a sphere light of the
+// radiance and radius gives a surface at distance from its center, with
+// cosine the cosine of the angle between the normal of the surface and
+// the direction to the center. The whole sphere must be above the
+// surface, that is cosine must not be smaller than radius/distance.
+func SphereLightIrradiance(radiance, radius, distance, cosine float64) float64 {
+	sine := radius / distance
+	return math.Pi * radiance * sine * sine * cosine
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package reference
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+const samples = 40000
+
+// estimate returns the mean of the radiance the integrator estimates for
+// the case, and the standard error of the mean
+func estimate(c *Case, integrator scene.Integrator) (float64, float64) {
+	rng := sampling.New(1, 0)
+	var sum, sum2 float64
+	for i := 0; i < samples; i++ {
+		v := integrator.Radiance(c.Scene, &c.Ray, rng).R
+		sum += v
+		sum2 += v * v
+	}
+	mean := sum / samples
+	return mean, math.Sqrt((sum2/samples - mean*mean) / samples)
+}
+
+func TestIntegratorsMatchTheReferences(t *testing.T) {
+	const maxDepth = 6
+	for _, c := range Cases() {
+		integrators := map[string]scene.Integrator{
+			"fixedpath": &scene.FixedPathTracer{MaxDepth: maxDepth},
+		}
+		if !c.Inside {
+			integrators["direct"] = scene.DirectLighting{}
+			integrators["bdpt"] = &scene.BidirectionalPathTracer{MaxDepth: maxDepth}
+		}
+		for name, integrator := range integrators {
+			expected := c.Radiance(0, maxDepth)
+			if name == "direct" {
+				expected = c.Radiance(0, 1)
+			}
+			mean, err := estimate(c, integrator)
+			// Some cases have no variance at all, so allow for rounding
+			if math.Abs(mean-expected) > 4*err+1e-9 {
+				t.Errorf("%s: %s estimates %f ± %f, but the radiance is %f", c.Name, name, mean, err, expected)
+			}
+		}
+	}
+}
+
+func TestFixedPathMatchesEveryDepth(t *testing.T) {
+	for _, c := range Cases() {
+		for depth := 0; depth <= 3; depth++ {
+			expected := c.Radiance(depth, depth)
+			mean, err := estimate(c, &scene.FixedPathTracer{MinDepth: depth, MaxDepth: depth})
+			if math.Abs(mean-expected) > 4*err+1e-9 {
+				t.Errorf("%s: the light bouncing %d times is estimated %f ± %f, but it's %f", c.Name, depth, mean, err, expected)
+			}
+		}
+	}
+}
+
+func TestSphereLightIrradiance(t *testing.T) {
+	// A sphere filling half the sky, seen straight above, gives π times its
+	// radiance, as a constant environment over the whole hemisphere does
+	if e := SphereLightIrradiance(1, 1, 1, 1); math.Abs(e-math.Pi) > 1e-12 {
+		t.Errorf("A sphere touching the surface should give π, it gives %f", e)
+	}
+	// Far away it's a point of intensity radiance·π·r²
+	if e := SphereLightIrradiance(2, 0.1, 100, 0.5); math.Abs(e-2*math.Pi*0.01/1e4*0.5) > 1e-15 {
+		t.Errorf("A far sphere light should fall off with the square of the distance, it gives %g", e)
+	}
+}
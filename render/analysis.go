@@ -0,0 +1,262 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	stdimg "image"
+	stdcol "image/color"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// ExposureKey is the luminance that AutoExposure maps the log-average
+// luminance of the frames to, the middle grey of photographers
+const ExposureKey = 0.18
+
+// logDelta keeps the black pixels from taking the logarithm of 0
+const logDelta = 1e-4
+
+// LogAverageLuminance returns the log-average luminance of the radiance,
+// the R, G and B of every pixel as the radiance AOV holds them: the
+// exponential of the mean of the logarithms of their luminances, which
+// isn't swayed by a few bright pixels as the mean is. The pixels that
+// aren't finite are left out. It's 0 if no pixel is left.
+func LogAverageLuminance(radiance []float32) float64 {
+	sum, n := 0.0, 0
+	for i := 0; i+2 < len(radiance); i += 3 {
+		c := image.Color{R: float64(radiance[i]), G: float64(radiance[i+1]), B: float64(radiance[i+2])}
+		l := c.Luminance()
+		if math.IsNaN(l) || math.IsInf(l, 0) {
+			continue
+		}
+		sum += math.Log(logDelta + math.Max(l, 0))
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Exp(sum / float64(n))
+}
+
+// AutoExposure returns the exposure, in stops, that brings the
+// log-average luminance of the radiance to ExposureKey, so that the
+// frames of dark and bright scenes alike show their middle tones as middle
+// grey. It's 0 for black frames.
+func AutoExposure(radiance []float32) float64 {
+	average := LogAverageLuminance(radiance)
+	if average <= logDelta {
+		return 0
+	}
+	return math.Log2(ExposureKey / average)
+}
+
+// Histogram counts the pixels of a frame by their luminance, in bins one
+// stop wide
+type Histogram struct {
+	// MinStop is the stop of the first bin, which holds the luminances
+	// from 2^MinStop to twice as much. The luminances below it are counted
+	// in the first bin and the ones above the last bin in the last one.
+	MinStop int
+	// Counts holds the number of pixels in every bin
+	Counts []int
+	// Black is the number of pixels whose luminance isn't positive, which
+	// no bin holds
+	Black int
+}
+
+// LuminanceHistogram returns the histogram of the luminances of the
+// radiance, the R, G and B of every pixel, from 2^minStop to 2^maxStop.
+// The pixels that aren't finite are left out.
+func LuminanceHistogram(radiance []float32, minStop, maxStop int) (*Histogram, error) {
+	if maxStop <= minStop {
+		return nil, fmt.Errorf("the histogram must span at least a stop")
+	}
+	h := &Histogram{MinStop: minStop, Counts: make([]int, maxStop-minStop)}
+	for i := 0; i+2 < len(radiance); i += 3 {
+		c := image.Color{R: float64(radiance[i]), G: float64(radiance[i+1]), B: float64(radiance[i+2])}
+		l := c.Luminance()
+		switch {
+		case math.IsNaN(l) || math.IsInf(l, 0):
+			continue
+		case l <= 0:
+			h.Black++
+			continue
+		}
+		bin := int(math.Floor(math.Log2(l))) - minStop
+		if bin < 0 {
+			bin = 0
+		} else if bin >= len(h.Counts) {
+			bin = len(h.Counts) - 1
+		}
+		h.Counts[bin]++
+	}
+	return h, nil
+}
+
+// Quantile returns the luminance below which the fraction of the pixels
+// the bins hold are, interpolated within its bin as if the luminances of
+// the bin were spread evenly over its stop. It's 0 if the bins are empty.
+func (h *Histogram) Quantile(fraction float64) float64 {
+	total := 0
+	for _, n := range h.Counts {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	target := math.Min(math.Max(fraction, 0), 1) * float64(total)
+	below := 0.0
+	for bin, n := range h.Counts {
+		if n > 0 && below+float64(n) >= target {
+			return math.Exp2(float64(h.MinStop+bin) + (target-below)/float64(n))
+		}
+		below += float64(n)
+	}
+	return math.Exp2(float64(h.MinStop + len(h.Counts)))
+}
+
+// The stops of the histograms auto exposure picks the exposures from, wide
+// enough for the luminances of any scene
+const (
+	exposureMinStop = -24
+	exposureMaxStop = 24
+)
+
+// ShadowFloor is the luminance below which the pixels are too dark to tell
+// from black, the one of the darkest grey of 8 bit sRGB images
+const ShadowFloor = 1 / (255 * 12.92)
+
+// HistogramExposure returns the exposure, in stops, that brings the
+// log-average luminance of the radiance, the R, G and B of every pixel, to
+// ExposureKey as AutoExposure does, but within the budgets: the fractions
+// of the lit pixels that may end up brighter than white, and darker than
+// ShadowFloor. Clipping the highlights is worse than losing the shadows, so
+// if both budgets can't be kept, the highlights one is. It's 0 for black
+// frames.
+func HistogramExposure(radiance []float32, highlights, shadows float64) float64 {
+	exposure := AutoExposure(radiance)
+	h, err := LuminanceHistogram(radiance, exposureMinStop, exposureMaxStop)
+	if err != nil {
+		return exposure
+	}
+	if dark := h.Quantile(shadows); dark > 0 {
+		exposure = math.Max(exposure, math.Log2(ShadowFloor/dark))
+	}
+	if bright := h.Quantile(1 - highlights); bright > 0 {
+		exposure = math.Min(exposure, -math.Log2(bright))
+	}
+	return exposure
+}
+
+// prepassSize is the most pixels of the sides of the prepasses of
+// EstimateExposure, and prepassSamples the most samples per pixel they take
+const (
+	prepassSize    = 128
+	prepassSamples = 4
+)
+
+// EstimateExposure returns the exposure HistogramExposure picks, within the
+// clipping budgets of the settings, for a width x height render of the
+// scene, from a quick prepass of it: the whole frame at most prepassSize
+// pixels on a side, with at most prepassSamples samples per pixel. Setting
+// the exposure of the settings to it exposes the renders without trying
+// exposures by hand.
+func EstimateExposure(ctx context.Context, s *scene.Scene, width, height int, opts Options) (float64, error) {
+	settings := s.Settings
+	defer func() { s.Settings = settings }()
+	if s.Settings.Samples > prepassSamples {
+		s.Settings.Samples = prepassSamples
+	}
+	s.Settings.Crop = ""
+	scale := math.Min(1, prepassSize/math.Max(float64(width), float64(height)))
+	w, h := int(math.Max(1, math.Round(float64(width)*scale))), int(math.Max(1, math.Round(float64(height)*scale)))
+	result, err := InMemory(ctx, s, w, h, opts, AOVRadiance)
+	if err != nil {
+		return 0, err
+	}
+	highlights, shadows := settings.ClippingBudgets()
+	return HistogramExposure(result.AOVs[AOVRadiance], highlights, shadows), nil
+}
+
+// Expose encodes the image of the result again from its radiance, and its
+// alpha if it has one, brighter by the exposure, in stops, on top of the
+// exposure of the settings of the scene, in the color space of them. Only
+// the pixels in the crop window of the settings are encoded, as InMemory
+// traces them. The result must hold the radiance AOV.
+func (r *Result) Expose(s *scene.Scene, exposure float64) error {
+	radiance, alpha := r.AOVs[AOVRadiance], r.AOVs[AOVAlpha]
+	if radiance == nil {
+		return fmt.Errorf("exposing a render needs its radiance")
+	}
+	scale := math.Exp2(exposure)
+	window := s.Settings.CropWindow(r.Width, r.Height)
+	for y := window.Min.Y; y < window.Max.Y; y++ {
+		for x := window.Min.X; x < window.Max.X; x++ {
+			i := y*r.Width + x
+			c := image.Color{R: float64(radiance[3*i]) * scale, G: float64(radiance[3*i+1]) * scale, B: float64(radiance[3*i+2]) * scale}
+			a := 1.0
+			if alpha != nil {
+				a = float64(alpha[i])
+			}
+			r.Image.SetNRGBA(x, y, s.EncodePixel(&c, a))
+		}
+	}
+	return nil
+}
+
+// falseColors are the colors of the heatmaps, from the lowest values to
+// the highest, already encoded for display
+var falseColors = [...]image.Color{
+	{R: 0, G: 0, B: 0.5}, {R: 0, G: 0.3, B: 1}, {R: 0, G: 0.9, B: 0.9}, {R: 0.2, G: 0.9, B: 0.2},
+	{R: 1, G: 0.9, B: 0}, {R: 1, G: 0.4, B: 0}, {R: 0.8, G: 0, B: 0}}
+
+// Heatmap returns the values of a one channel AOV of a width x height
+// render, such as the luminance, the samples or the cost, in false colors,
+// from dark blue for the lowest of them to red for the highest, so the
+// scenes and the renders can be inspected by eye. With logarithmic set,
+// the colors follow the logarithms of the values, which suits the ones
+// spanning orders of magnitude such as the luminance. The values that
+// aren't finite, and the ones that aren't positive with logarithmic set,
+// are black.
+func Heatmap(values []float32, width, height int, logarithmic bool) (*stdimg.NRGBA, error) {
+	if len(values) != width*height {
+		return nil, fmt.Errorf("a heatmap needs a value for every pixel, not %d values for %d pixels", len(values), width*height)
+	}
+	mapped := make([]float64, len(values))
+	low, high := math.Inf(1), math.Inf(-1)
+	for i, v := range values {
+		m := float64(v)
+		if logarithmic {
+			m = math.Log(m)
+		}
+		if math.IsNaN(m) || math.IsInf(m, 0) {
+			m = math.NaN()
+		} else {
+			low, high = math.Min(low, m), math.Max(high, m)
+		}
+		mapped[i] = m
+	}
+	heatmap := stdimg.NewNRGBA(stdimg.Rect(0, 0, width, height))
+	for i, m := range mapped {
+		if math.IsNaN(m) {
+			heatmap.SetNRGBA(i%width, i/width, stdcol.NRGBA{A: 255})
+			continue
+		}
+		t := 0.0
+		if high > low {
+			t = (m - low) / (high - low)
+		}
+		heatmap.SetNRGBA(i%width, i/width, falseColor(t).ToNRGBA())
+	}
+	return heatmap, nil
+}
+
+// falseColor returns the false color at t, from 0 to 1
+func falseColor(t float64) *image.Color {
+	position := t * float64(len(falseColors)-1)
+	i := int(math.Min(position, float64(len(falseColors)-2)))
+	c := falseColors[i]
+	return c.Lerp(&falseColors[i+1], position-float64(i))
+}
@@ -0,0 +1,156 @@
+package render
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestAutoExposureBringsTheFrameToMiddleGrey(t *testing.T) {
+	radiance := []float32{0.01, 0.01, 0.01, 0.04, 0.04, 0.04, float32(math.NaN()), 0, 0}
+	if average := LogAverageLuminance(radiance); math.Abs(average-0.02) > 1e-3 {
+		t.Errorf("The log-average luminance should be the geometric mean 0.02, not %v", average)
+	}
+	exposure := AutoExposure(radiance)
+	if want := math.Log2(ExposureKey / LogAverageLuminance(radiance)); exposure != want {
+		t.Errorf("The exposure should be %v stops, not %v", want, exposure)
+	}
+	if exposure := AutoExposure(make([]float32, 30)); exposure != 0 {
+		t.Errorf("Black frames shouldn't be exposed, not by %v stops", exposure)
+	}
+}
+
+func TestLuminanceHistogram(t *testing.T) {
+	radiance := []float32{1, 1, 1, 0.3, 0.3, 0.3, 0, 0, 0, 100, 100, 100, 0.001, 0.001, 0.001}
+	h, err := LuminanceHistogram(radiance, -4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 is in the bin of stop 0, 0.3 in the one of -2, and 100 and 0.001
+	// in the last and the first
+	want := []int{1, 0, 1, 0, 1, 0, 0, 1}
+	for i, n := range want {
+		if h.Counts[i] != n {
+			t.Fatalf("The counts should be %v, not %v", want, h.Counts)
+		}
+	}
+	if h.Black != 1 {
+		t.Errorf("There should be 1 black pixel, not %d", h.Black)
+	}
+	if _, err := LuminanceHistogram(radiance, 2, 2); err == nil {
+		t.Error("A histogram without bins should fail")
+	}
+}
+
+// pixels returns the radiance of n grey pixels of the luminance
+func pixels(n int, luminance float32) []float32 {
+	radiance := make([]float32, 3*n)
+	for i := range radiance {
+		radiance[i] = luminance
+	}
+	return radiance
+}
+
+func TestHistogramExposureKeepsTheClippingBudgets(t *testing.T) {
+	// Middle grey would turn the 2% of bright pixels white
+	radiance := append(pixels(98, 0.01), pixels(2, 10)...)
+	key := AutoExposure(radiance)
+	if exposure := HistogramExposure(radiance, 0.01, 0.02); !(exposure < key && exposure <= -3) {
+		t.Errorf("The exposure should keep the bright pixels below white, not be %v stops with the key at %v", exposure, key)
+	}
+	if exposure := HistogramExposure(radiance, 0.05, 0.02); exposure != key {
+		t.Errorf("With a budget for them, the bright pixels shouldn't change the exposure from %v to %v", key, exposure)
+	}
+	// Middle grey would leave the 10% of dark pixels black
+	radiance = append(pixels(90, 0.01), pixels(10, 1e-7)...)
+	key = AutoExposure(radiance)
+	exposure := HistogramExposure(radiance, 0.01, 0.05)
+	if !(exposure > key) {
+		t.Errorf("The exposure should lift the dark pixels, not be %v stops with the key at %v", exposure, key)
+	}
+	if 0.01*math.Exp2(exposure) > 1 {
+		t.Errorf("Lifting the dark pixels shouldn't clip the others, as %v stops do", exposure)
+	}
+	if exposure := HistogramExposure(make([]float32, 30), 0.01, 0.02); exposure != 0 {
+		t.Errorf("Black frames shouldn't be exposed, not by %v stops", exposure)
+	}
+}
+
+func TestEstimateExposureFromAPrepass(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.Color{R: 0.01, G: 0.01, B: 0.01}})
+	s.Settings.Samples = 16
+	s.Settings.Crop = "0,0,0.5,0.5"
+	exposure, err := EstimateExposure(context.Background(), s, 400, 300, Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(exposure > 0) {
+		t.Errorf("The dim scene should be brightened, not exposed by %v stops", exposure)
+	}
+	if s.Settings.Samples != 16 || s.Settings.Crop != "0,0,0.5,0.5" {
+		t.Errorf("The prepass should leave the settings as they were, not %+v", s.Settings)
+	}
+	dim := s.Settings.Encode(&image.Color{R: 0.01, G: 0.01, B: 0.01})
+	s.Settings.Exposure = exposure
+	if exposed := s.Settings.Encode(&image.Color{R: 0.01, G: 0.01, B: 0.01}); !(exposed.R > dim.R) {
+		t.Errorf("The exposure should brighten the pixels, not turn %v into %v", dim, exposed)
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	heatmap, err := Heatmap([]float32{1, 10, 100, 0}, 2, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, middle, high, black := heatmap.NRGBAAt(0, 0), heatmap.NRGBAAt(1, 0), heatmap.NRGBAAt(0, 1), heatmap.NRGBAAt(1, 1)
+	if !(low.B > low.R && high.R > high.B) {
+		t.Errorf("The lowest values should be blue and the highest red, not %v and %v", low, high)
+	}
+	if middle == low || middle == high {
+		t.Error("The value in the middle should have a color of its own")
+	}
+	if black.R != 0 || black.G != 0 || black.B != 0 {
+		t.Errorf("The values without a logarithm should be black, not %v", black)
+	}
+	if _, err := Heatmap([]float32{1}, 2, 2, false); err == nil {
+		t.Error("A heatmap without a value for every pixel should fail")
+	}
+}
+
+func TestInMemoryAnalysisAOVs(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	s.Settings.Samples = 4
+	result, err := InMemory(context.Background(), s, 20, 20, Options{Workers: 2}, AOVRadiance, AOVLuminance, AOVSamples, AOVCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	middle := 10*20 + 10
+	radiance := image.Color{R: float64(result.AOVs[AOVRadiance][3*middle]), G: float64(result.AOVs[AOVRadiance][3*middle+1]), B: float64(result.AOVs[AOVRadiance][3*middle+2])}
+	if l := result.AOVs[AOVLuminance][middle]; math.Abs(float64(l)-radiance.Luminance()) > 1e-6 {
+		t.Errorf("The luminance should be %v, not %v", radiance.Luminance(), l)
+	}
+	if n := result.AOVs[AOVSamples][middle]; n != 4 {
+		t.Errorf("The pixel should have taken 4 samples, not %v", n)
+	}
+	if cost := result.AOVs[AOVCost][middle]; !(cost > 0) {
+		t.Error("Tracing the sphere should cost some work")
+	}
+
+	before := result.Image.NRGBAAt(10, 10)
+	if err := result.Expose(s, 1); err != nil {
+		t.Fatal(err)
+	}
+	if after := result.Image.NRGBAAt(10, 10); !(after.R > before.R) {
+		t.Errorf("Exposing the render by a stop should brighten it, not turn %v into %v", before, after)
+	}
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ProjectMOA/goraytrace/animation"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// RenderAnimation renders the frames first to last of the animation of
+// the scene, width x height, and saves them numbered after name as
+// RenderShaderAnimation does. With resume, the frames before the last one
+// already saved are skipped, and that one is rendered again in case it was
+// cut short. frame is called with every frame as it's saved. If the
+// context is done first, the frame being rendered is saved as it is, so
+// resuming renders it again, and the error of the context is returned.
+func RenderAnimation(ctx context.Context, s *scene.Scene, a *animation.Animation, first, last, width, height int, name string, opts Options, resume bool, frame func(int)) error {
+	if first < 0 || last >= a.Frames || first > last {
+		return fmt.Errorf("the frames must be between 0 and %d", a.Frames-1)
+	}
+	player, err := animation.NewPlayer(a, s)
+	if err != nil {
+		return err
+	}
+	if resume {
+		first = lastSaved(name, first, last)
+	}
+	for f := first; f <= last; f++ {
+		player.SeekFrame(f)
+		render, err := Scene(ctx, s, width, height, opts)
+		render.Save(FrameName(name, f))
+		if err != nil {
+			return err
+		}
+		if frame != nil {
+			frame(f)
+		}
+	}
+	return nil
+}
+
+// FrameName returns the name the frame is saved with, without the
+// extension
+func FrameName(name string, frame int) string {
+	return fmt.Sprintf("%s%04d", name, frame)
+}
+
+// lastSaved returns the last frame from first to last saved after name,
+// or first if there's none
+func lastSaved(name string, first, last int) int {
+	for f := last; f > first; f-- {
+		if _, err := os.Stat(FrameName(name, f) + ".png"); err == nil {
+			return f
+		}
+	}
+	return first
+}
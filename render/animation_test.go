@@ -0,0 +1,85 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/animation"
+	"github.com/ProjectMOA/goraytrace/dataset"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestRenderAnimationResumes(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Name: "ball", Position: math3d.Vector3{Z: 3}, Radius: 0.2})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	a := &animation.Animation{FPS: 1, Frames: 4, Shapes: map[string]*animation.Track{
+		"ball": {Keys: []animation.Keyframe{{Time: 0}, {Time: 3, Position: math3d.Vector3{X: 0.3}}}}}}
+	name := filepath.Join(t.TempDir(), "frame")
+	var rendered []int
+	record := func(frame int) { rendered = append(rendered, frame) }
+
+	if err := RenderAnimation(context.Background(), s, a, 0, 3, 16, 16, name, Options{}, true, record); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2, 3}; !reflect.DeepEqual(rendered, want) {
+		t.Errorf("The frames rendered should be %v, not %v", want, rendered)
+	}
+	// A render cut short while saving the last frame resumes from it
+	if err := os.Remove(FrameName(name, 3) + ".png"); err != nil {
+		t.Fatal(err)
+	}
+	rendered = nil
+	if err := RenderAnimation(context.Background(), s, a, 0, 3, 16, 16, name, Options{}, true, record); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 3}; !reflect.DeepEqual(rendered, want) {
+		t.Errorf("The frames rendered when resuming should be %v, not %v", want, rendered)
+	}
+	if err := RenderAnimation(context.Background(), s, a, 2, 4, 16, 16, name, Options{}, false, nil); err == nil {
+		t.Error("Rendering past the last frame should fail")
+	}
+}
+
+func TestRenderDataset(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Name: "ball", Position: math3d.Vector3{Z: 3}, Radius: 0.2})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	spec := &dataset.Spec{Count: 2, Camera: &dataset.Camera{Offset: 0.1}}
+	name := filepath.Join(t.TempDir(), "sample")
+	if err := RenderDataset(context.Background(), s, spec, 16, 16, name, Options{}, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		for _, extension := range []string{".png", ".mask.png", ".json"} {
+			if _, err := os.Stat(FrameName(name, i) + extension); err != nil {
+				t.Errorf("The sample %d should be saved with its %s: %v", i, extension, err)
+			}
+		}
+	}
+	data, err := ioutil.ReadFile(FrameName(name, 1) + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a dataset.Annotation
+	if err := json.Unmarshal(data, &a); err != nil || a.Image != "sample0001.png" || len(a.Objects) != 1 || a.Objects[0].Name != "ball" {
+		t.Errorf("The annotation should show the ball in sample0001.png, not %s", data)
+	}
+	data, err = ioutil.ReadFile(name + ".coco.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c dataset.COCO
+	if err := json.Unmarshal(data, &c); err != nil || len(c.Images) != 2 || len(c.Annotations) != 2 || len(c.Categories) != 1 {
+		t.Errorf("The COCO set should have both samples of the ball, not %s", data)
+	}
+}
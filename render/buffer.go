@@ -0,0 +1,81 @@
+package render
+
+import "github.com/ProjectMOA/goraytrace/image"
+
+// buffer accumulates the samples of every pixel of a progressive render
+type buffer interface {
+	// add adds the sample to the pixel i, as its nth
+	add(i int, sample *image.Color, n int)
+	// mean returns the mean of the n samples of the pixel i
+	mean(i, n int) image.Color
+	// sum returns the sum of the n samples of the pixel i
+	sum(i, n int) image.Color
+	// load sets the sum of the n samples of the pixel i, as checkpoints
+	// hold it
+	load(i int, sum *image.Color, n int)
+}
+
+// newBuffer returns a buffer of n pixels, of halves if half is true
+func newBuffer(n int, half bool) buffer {
+	if half {
+		return make(halfBuffer, 3*n)
+	}
+	return make(fullBuffer, n)
+}
+
+// fullBuffer holds the sum of the samples of every pixel, in float64
+type fullBuffer []image.Color
+
+func (b fullBuffer) add(i int, sample *image.Color, n int) {
+	b[i] = *b[i].Add(sample)
+}
+
+func (b fullBuffer) mean(i, n int) image.Color {
+	return *b[i].Divide(float64(n))
+}
+
+func (b fullBuffer) sum(i, n int) image.Color {
+	return b[i]
+}
+
+func (b fullBuffer) load(i int, sum *image.Color, n int) {
+	b[i] = *sum
+}
+
+// halfBuffer holds the mean of the samples of every pixel as three
+// halves, which take a quarter of the memory of a fullBuffer. The mean is
+// updated with every sample in float32 and rounded to halves, so it keeps
+// about 3 significant digits. Every sample moves it by its difference
+// from it over the number of samples, which is lost when it's under half
+// the precision of the mean, so after thousands of samples the mean may be
+// off by a few units of its last digit. That is below what 8 bit renders
+// show, but loses the faint details of HDR renders, and means brighter
+// than 65504 become infinite. Unlike a sum, the mean doesn't grow with
+// the samples, so it doesn't overflow in long renders.
+type halfBuffer []image.Half
+
+func (b halfBuffer) add(i int, sample *image.Color, n int) {
+	for c, v := range [3]float64{sample.R, sample.G, sample.B} {
+		mean := b[3*i+c].Float32()
+		b[3*i+c] = image.ToHalf(mean + (float32(v)-mean)/float32(n))
+	}
+}
+
+func (b halfBuffer) mean(i, n int) image.Color {
+	return image.Color{R: float64(b[3*i].Float32()), G: float64(b[3*i+1].Float32()), B: float64(b[3*i+2].Float32())}
+}
+
+func (b halfBuffer) sum(i, n int) image.Color {
+	mean := b.mean(i, n)
+	return *mean.Multiply(float64(n))
+}
+
+func (b halfBuffer) load(i int, sum *image.Color, n int) {
+	mean := image.Color{}
+	if n > 0 {
+		mean = *sum.Divide(float64(n))
+	}
+	b[3*i] = image.ToHalf(float32(mean.R))
+	b[3*i+1] = image.ToHalf(float32(mean.G))
+	b[3*i+2] = image.ToHalf(float32(mean.B))
+}
@@ -0,0 +1,51 @@
+package render
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+func TestHalfBufferKeepsTheMean(t *testing.T) {
+	b := newBuffer(1, true)
+	sum := 0.0
+	n := 4096
+	for i := 1; i <= n; i++ {
+		// Noisy samples around 0.3
+		v := 0.3 + 0.2*math.Sin(float64(i)*2.3)
+		sum += v
+		b.add(0, &image.Color{R: v, G: 10 * v, B: v / 10}, i)
+	}
+	want := sum / float64(n)
+	mean := b.mean(0, n)
+	for _, c := range []struct{ got, want float64 }{{mean.R, want}, {mean.G, 10 * want}, {mean.B, want / 10}} {
+		if math.Abs(c.got-c.want) > 3e-3*c.want {
+			t.Errorf("The mean of the samples should be about %.5f, not %.5f", c.want, c.got)
+		}
+	}
+	if s := b.sum(0, n); math.Abs(s.R-sum) > 3e-3*sum {
+		t.Errorf("The sum of the samples should be about %.2f, not %.2f", sum, s.R)
+	}
+	b.load(0, &image.Color{R: 30, G: 60, B: 90}, 10)
+	if mean := b.mean(0, 10); mean != (image.Color{R: 3, G: 6, B: 9}) {
+		t.Errorf("Loading a sum of 10 samples should make their mean, not %v", mean)
+	}
+}
+
+func TestHalfBuffersRenderLikeFullOnes(t *testing.T) {
+	s := checkpointScene(8)
+	full := NewRenderer(s, 40, 40, Options{Workers: 2})
+	full.Start(context.Background())
+	<-full.Done()
+	half := NewRenderer(s, 40, 40, Options{Workers: 2, HalfBuffers: true})
+	half.Start(context.Background())
+	<-half.Done()
+	want, got := full.Image().Pix, half.Image().Pix
+	for i := range want {
+		if d := int(want[i]) - int(got[i]); d < -1 || d > 1 {
+			t.Fatalf("The byte %d of the render should be %d, as with full buffers, not %d", i, want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package render
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// DefaultCheckpointInterval is how often long renders should save their
+// checkpoints, which takes a moment, while losing little when they die
+const DefaultCheckpointInterval = 5 * time.Minute
+
+// checkpointMagic starts every checkpoint file
+const checkpointMagic = "GRTCKPT1"
+
+// checkpointHeader follows the magic in checkpoint files. Then come the
+// sums of the samples of every pixel, as three float64, and the number of
+// samples of every pixel as an uint32, row after row. Everything is little
+// endian.
+type checkpointHeader struct {
+	Width, Height uint32
+	Passes        uint32
+	// Scene is the hash of the scene the samples are of
+	Scene [sha256.Size]byte
+}
+
+// SaveCheckpoint saves the samples accumulated so far to the file at
+// path, so that a renderer of the same scene can resume from them with
+// LoadCheckpoint. It can be called while the renderer runs. The file is
+// replaced at once, so a crash while saving leaves the previous one.
+func (r *Renderer) SaveCheckpoint(path string) error {
+	hash, err := sceneHash(r.scene)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	header := checkpointHeader{Width: uint32(r.width), Height: uint32(r.height), Passes: uint32(r.passes), Scene: hash}
+	sum := make([]image.Color, len(r.count))
+	count := make([]uint32, len(r.count))
+	for i, c := range r.count {
+		sum[i], count[i] = r.pixels.sum(i, c), uint32(c)
+	}
+	r.mu.Unlock()
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	w := bufio.NewWriter(file)
+	w.WriteString(checkpointMagic)
+	binary.Write(w, binary.LittleEndian, &header)
+	rgb := make([]float64, 0, 3*len(sum))
+	for _, c := range sum {
+		rgb = append(rgb, c.R, c.G, c.B)
+	}
+	binary.Write(w, binary.LittleEndian, rgb)
+	binary.Write(w, binary.LittleEndian, count)
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// LoadCheckpoint makes the renderer resume from the samples saved in the
+// checkpoint file at path. It must be called before Start, and fails if
+// the checkpoint is of another scene or size. The samples to stop at may
+// differ, so a finished render can be resumed to take more.
+func (r *Renderer) LoadCheckpoint(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	in := bufio.NewReader(file)
+	magic := make([]byte, len(checkpointMagic))
+	var header checkpointHeader
+	if _, err := io.ReadFull(in, magic); err != nil || string(magic) != checkpointMagic {
+		return fmt.Errorf("%s isn't a checkpoint", path)
+	}
+	if err := binary.Read(in, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if int(header.Width) != r.width || int(header.Height) != r.height {
+		return fmt.Errorf("the checkpoint is of a %dx%d render", header.Width, header.Height)
+	}
+	hash, err := sceneHash(r.scene)
+	if err != nil {
+		return err
+	}
+	if header.Scene != hash {
+		return errors.New("the checkpoint is of another scene or render settings")
+	}
+	rgb := make([]float64, 3*r.width*r.height)
+	count := make([]uint32, r.width*r.height)
+	if err := binary.Read(in, binary.LittleEndian, rgb); err != nil {
+		return err
+	}
+	if err := binary.Read(in, binary.LittleEndian, count); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.passes = int(header.Passes)
+	for i, c := range count {
+		r.pixels.load(i, &image.Color{R: rgb[3*i], G: rgb[3*i+1], B: rgb[3*i+2]}, int(c))
+		r.count[i] = int(c)
+	}
+	return nil
+}
+
+// sceneHash returns the hash of the scene file of the scene, leaving out
+// the samples per pixel, which don't change the samples already taken
+func sceneHash(s *scene.Scene) ([sha256.Size]byte, error) {
+	data, err := s.Marshal()
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	if settings, ok := m["render"].(map[string]interface{}); ok {
+		delete(settings, "samples")
+	}
+	if data, err = json.Marshal(m); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
@@ -0,0 +1,77 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func checkpointScene(samples int) *scene.Scene {
+	s := scene.New()
+	s.Settings.Samples = samples
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{Y: 3}, Radius: 0.5, Radiance: image.White})
+	return s
+}
+
+func TestResumingFromACheckpointGivesTheSameRender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	// Stopping may leave a pass half done, whose tiles mustn't be traced
+	// twice when resuming
+	stopped := NewRenderer(checkpointScene(1<<20), 40, 40, Options{Workers: 1})
+	stopped.Start(context.Background())
+	for stopped.tracedTiles() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	stopped.Stop()
+	if err := stopped.SaveCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+	samples := stopped.Passes() + 2
+
+	resumed := NewRenderer(checkpointScene(samples), 40, 40, Options{Workers: 2})
+	if err := resumed.LoadCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+	resumed.Start(context.Background())
+	<-resumed.Done()
+	whole := NewRenderer(checkpointScene(samples), 40, 40, Options{Workers: 2})
+	whole.Start(context.Background())
+	<-whole.Done()
+	if resumed.Passes() != samples || !bytes.Equal(resumed.Image().Pix, whole.Image().Pix) {
+		t.Errorf("The resumed render should have the %d samples of the whole one, it has %d passes", samples, resumed.Passes())
+	}
+}
+
+func TestCheckpointsOfOtherRendersAreRejected(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "checkpoint")
+	r := NewRenderer(checkpointScene(1), 8, 8, Options{})
+	r.Start(context.Background())
+	<-r.Done()
+	if err := r.SaveCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRenderer(checkpointScene(1), 8, 9, Options{}).LoadCheckpoint(path); err == nil {
+		t.Error("A checkpoint of another size shouldn't load")
+	}
+	other := checkpointScene(1)
+	other.Settings.Seed++
+	if err := NewRenderer(other, 8, 8, Options{}).LoadCheckpoint(path); err == nil {
+		t.Error("A checkpoint of another scene shouldn't load")
+	}
+	notCheckpoint := filepath.Join(dir, "image")
+	ioutil.WriteFile(notCheckpoint, []byte("\x89PNG"), 0644)
+	if err := NewRenderer(checkpointScene(1), 8, 8, Options{}).LoadCheckpoint(notCheckpoint); err == nil {
+		t.Error("Only checkpoints should load")
+	}
+}
@@ -0,0 +1,103 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ProjectMOA/goraytrace/dataset"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// RenderDataset renders the variations of the scene the spec describes,
+// width x height, and saves them numbered after name as RenderAnimation
+// saves frames, each with its segmentation mask, in a .mask.png of 16 bits
+// per pixel, and its annotation, in a .json. Once they're all saved, the
+// annotations of all of them are saved in the COCO format as
+// name.coco.json. With resume, the variations before the last one already
+// saved are skipped, and that one is rendered again in case it was cut
+// short. sample is called with every variation as it's saved. If the
+// context is done first, the error of the context is returned, and the
+// variation being rendered isn't annotated.
+func RenderDataset(ctx context.Context, s *scene.Scene, spec *dataset.Spec, width, height int, name string, opts Options, resume bool, sample func(int)) error {
+	first := 0
+	if resume {
+		first = lastSaved(name, first, spec.Count-1)
+	}
+	for i := first; i < spec.Count; i++ {
+		varied, err := spec.Vary(s, i)
+		if err != nil {
+			return err
+		}
+		render, err := Scene(ctx, varied, width, height, opts)
+		render.Save(FrameName(name, i))
+		if err != nil {
+			return err
+		}
+		a, err := saveMask(varied, width, height, FrameName(name, i))
+		if err != nil {
+			return err
+		}
+		if err := writeJSON(FrameName(name, i)+".json", a); err != nil {
+			return err
+		}
+		if sample != nil {
+			sample(i)
+		}
+	}
+	// The annotations of the variations saved before resuming are read
+	// back from their files
+	annotations := make([]*dataset.Annotation, spec.Count)
+	for i := range annotations {
+		data, err := ioutil.ReadFile(FrameName(name, i) + ".json")
+		if err != nil {
+			return err
+		}
+		annotations[i] = &dataset.Annotation{}
+		if err := json.Unmarshal(data, annotations[i]); err != nil {
+			return fmt.Errorf("%s: %v", FrameName(name, i)+".json", err)
+		}
+	}
+	return writeJSON(name+".coco.json", dataset.NewCOCO(annotations))
+}
+
+// SaveAnnotation saves the segmentation mask of the render of width x
+// height of the scene saved with the name, in name.mask.png, and the
+// objects it shows, with their masks and bounding boxes, in the COCO
+// format in name.coco.json
+func SaveAnnotation(s *scene.Scene, width, height int, name string) error {
+	a, err := saveMask(s, width, height, name)
+	if err != nil {
+		return err
+	}
+	return writeJSON(name+".coco.json", dataset.NewCOCO([]*dataset.Annotation{a}))
+}
+
+// saveMask saves the segmentation mask of the render of the scene saved
+// with the name and returns its annotation
+func saveMask(s *scene.Scene, width, height int, name string) (*dataset.Annotation, error) {
+	a, mask := dataset.Annotate(s, width, height)
+	a.Image, a.Mask = filepath.Base(name)+".png", filepath.Base(name)+".mask.png"
+	f, err := os.Create(name + ".mask.png")
+	if err != nil {
+		return nil, err
+	}
+	if err := png.Encode(f, mask); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, f.Close()
+}
+
+// writeJSON saves the value as indented JSON in the file at path
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,147 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	stdimg "image"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// The AOVs, the arbitrary output variables, that InMemory can return along
+// with the image
+const (
+	// AOVRadiance is the linear radiance of every pixel, as its R, G and B
+	AOVRadiance = "radiance"
+	// AOVAlpha is the alpha of every pixel, 1 unless the render is
+	// transparent
+	AOVAlpha = "alpha"
+	// AOVDepth is the depth of every pixel, as DepthMap holds it
+	AOVDepth = "depth"
+	// AOVNormal is the unit normal of the surface every pixel sees, as its
+	// X, Y and Z in world space, or zero where the background shows
+	AOVNormal = "normal"
+	// AOVPosition is the point every pixel sees, as its X, Y and Z in
+	// world space, or zero where the background shows
+	AOVPosition = "position"
+	// AOVLuminance is the relative luminance of the radiance of every
+	// pixel
+	AOVLuminance = "luminance"
+	// AOVSamples is the number of samples every pixel took, fewer than
+	// the settings ask for where adaptive sampling stopped early
+	AOVSamples = "samples"
+	// AOVCost is the work of the acceleration structure tracing every
+	// pixel, as TraceCost counts it. The pixels are traced again for it,
+	// one after the other, once the render is done.
+	AOVCost = "cost"
+)
+
+var aovChannels = map[string]int{AOVRadiance: 3, AOVAlpha: 1, AOVDepth: 1, AOVNormal: 3, AOVPosition: 3,
+	AOVLuminance: 1, AOVSamples: 1, AOVCost: 1}
+
+// AOVChannels returns the number of values every pixel has in the AOV, or
+// 0 if there's no such AOV
+func AOVChannels(name string) int {
+	return aovChannels[name]
+}
+
+// Result is a render held in memory, for the programs that embed the
+// renderer, such as web services and tests, and have no use for files
+type Result struct {
+	Width, Height int
+	// Image is the render, as Scene returns it
+	Image *stdimg.NRGBA
+	// AOVs holds the values of the AOVs asked for, by their names. Every
+	// one holds AOVChannels values for every pixel, pixel after pixel and
+	// row after row from the top.
+	AOVs map[string][]float32
+}
+
+// InMemory traces a width x height render of the scene in tiles as Scene
+// does, and returns it with the AOVs named. The radiance and the alpha
+// are those of the samples the image is encoded from, so asking for them
+// costs nothing but memory, and the depth, the normals and the positions
+// are traced through the center of every pixel, as TraceDepth does. Only
+// the tiles in the crop window of the settings are traced. If the context
+// is done first, the pixels not traced yet are zero and its error is
+// returned with the result.
+func InMemory(ctx context.Context, s *scene.Scene, width, height int, opts Options, aovs ...string) (*Result, error) {
+	result := &Result{Width: width, Height: height, AOVs: make(map[string][]float32, len(aovs))}
+	for _, name := range aovs {
+		channels := AOVChannels(name)
+		if channels == 0 {
+			return nil, fmt.Errorf("there's no %q AOV", name)
+		}
+		result.AOVs[name] = make([]float32, channels*width*height)
+	}
+	render := image.New(width, height)
+	result.Image = &render.NRGBA
+	radiance, alpha := result.AOVs[AOVRadiance], result.AOVs[AOVAlpha]
+	luminance, samples := result.AOVs[AOVLuminance], result.AOVs[AOVSamples]
+	var depth *scene.DepthMap
+	if result.AOVs[AOVDepth] != nil || result.AOVs[AOVNormal] != nil || result.AOVs[AOVPosition] != nil {
+		depth = scene.NewDepthMap(width, height)
+	}
+
+	s.Prepare()
+	targetIt := s.Camera.GetIterator(width, height)
+	window := s.Settings.CropWindow(width, height)
+	var tiles []stdimg.Rectangle
+	for _, tile := range Tiles(width, height, DefaultTileSize) {
+		if tile = tile.Intersect(window); !tile.Empty() {
+			tiles = append(tiles, tile)
+		}
+	}
+	progress := newTracker(opts.Progress, s, 0)
+	err := ForEachTileWith(ctx, tiles, opts, func(tile stdimg.Rectangle) {
+		start := time.Now()
+		for y := tile.Min.Y; y < tile.Max.Y; y++ {
+			for x := tile.Min.X; x < tile.Max.X; x++ {
+				c, a, n := s.TracePixelSamples(targetIt, x, y)
+				render.Set(x, y, s.EncodePixel(&c, a))
+				i := y*width + x
+				if radiance != nil {
+					radiance[3*i], radiance[3*i+1], radiance[3*i+2] = float32(c.R), float32(c.G), float32(c.B)
+				}
+				if alpha != nil {
+					alpha[i] = float32(a)
+				}
+				if luminance != nil {
+					luminance[i] = float32(c.Luminance())
+				}
+				if samples != nil {
+					samples[i] = float32(n)
+				}
+			}
+		}
+		if depth != nil {
+			s.TraceDepthRegion(depth, tile)
+		}
+		progress.tileDone(tile, 0, start, len(tiles))
+	})
+	if depth != nil {
+		if values := result.AOVs[AOVDepth]; values != nil {
+			copy(values, depth.Depth)
+		}
+		for i := range depth.Points {
+			if values := result.AOVs[AOVNormal]; values != nil {
+				values[3*i], values[3*i+1], values[3*i+2] = float32(depth.Normals[i].X), float32(depth.Normals[i].Y), float32(depth.Normals[i].Z)
+			}
+			if values := result.AOVs[AOVPosition]; values != nil {
+				values[3*i], values[3*i+1], values[3*i+2] = float32(depth.Points[i].X), float32(depth.Points[i].Y), float32(depth.Points[i].Z)
+			}
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+	if values := result.AOVs[AOVCost]; values != nil {
+		for i, c := range s.TraceCost(width, height, window) {
+			values[i] = float32(c)
+		}
+	}
+	s.MarkTraced()
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestInMemoryReturnsTheImageAndTheAOVs(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	result, err := InMemory(context.Background(), s, 70, 40, Options{Workers: 3}, AOVRadiance, AOVAlpha, AOVDepth, AOVNormal, AOVPosition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render, _ := Scene(context.Background(), s, 70, 40, Options{Workers: 1})
+	if !bytes.Equal(result.Image.Pix, render.Pix) {
+		t.Error("The image should be the one Scene renders")
+	}
+	for name, values := range result.AOVs {
+		if len(values) != AOVChannels(name)*70*40 {
+			t.Errorf("The %s AOV should have %d values for every pixel, not %d values in all", name, AOVChannels(name), len(values))
+		}
+	}
+
+	targetIt := s.Camera.GetIterator(70, 40)
+	depth := s.TraceDepth(70, 40)
+	for _, p := range [][2]int{{35, 20}, {0, 0}} {
+		i := p[1]*70 + p[0]
+		radiance := s.TracePixel(targetIt, p[0], p[1])
+		if got := result.AOVs[AOVRadiance][3*i : 3*i+3]; got[0] != float32(radiance.R) || got[1] != float32(radiance.G) || got[2] != float32(radiance.B) {
+			t.Errorf("The radiance of the pixel %v should be %v, not %v", p, radiance, got)
+		}
+		if alpha := result.AOVs[AOVAlpha][i]; alpha != 1 {
+			t.Errorf("Opaque renders should have an alpha of 1, not %v", alpha)
+		}
+		if d := result.AOVs[AOVDepth][i]; d != depth.Depth[i] {
+			t.Errorf("The depth of the pixel %v should be %v, not %v", p, depth.Depth[i], d)
+		}
+		if n := result.AOVs[AOVNormal][3*i+2]; n != float32(depth.Normals[i].Z) {
+			t.Errorf("The normal of the pixel %v should be %v, not Z %v", p, depth.Normals[i], n)
+		}
+		if z := result.AOVs[AOVPosition][3*i+2]; z != float32(depth.Points[i].Z) {
+			t.Errorf("The position of the pixel %v should be %v, not Z %v", p, depth.Points[i], z)
+		}
+	}
+	if d := result.AOVs[AOVDepth][0]; !math.IsInf(float64(d), 1) {
+		t.Errorf("The background should be infinitely deep, not %v", d)
+	}
+	if d := result.AOVs[AOVDepth][20*70+35]; math.IsInf(float64(d), 1) {
+		t.Error("The sphere should show in the middle of the render")
+	}
+
+	if _, err := InMemory(context.Background(), s, 8, 8, Options{}, "albedo"); err == nil {
+		t.Error("Asking for an unknown AOV should fail")
+	}
+}
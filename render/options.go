@@ -0,0 +1,83 @@
+package render
+
+import (
+	"fmt"
+
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// Option is an option of the renders of New and NewOptions. Options fail
+// if they're given values no render can use.
+type Option func(o *Options) error
+
+// NewOptions returns the default options changed by the options, in
+// order, or the error of the first one that fails
+func NewOptions(options ...Option) (Options, error) {
+	var o Options
+	for _, option := range options {
+		if err := option(&o); err != nil {
+			return Options{}, err
+		}
+	}
+	return o, nil
+}
+
+// New returns a progressive renderer of width x height renders of the
+// scene, as NewRenderer does, with the options. It fails if the size
+// isn't positive, if the settings of the scene can't be rendered with or
+// if an option fails, so adding options to renderers never breaks the
+// programs that create them.
+func New(s *scene.Scene, width, height int, options ...Option) (*Renderer, error) {
+	if width < 1 || height < 1 {
+		return nil, fmt.Errorf("the render must be at least 1x1 pixels, not %dx%d", width, height)
+	}
+	if err := s.Settings.Validate(); err != nil {
+		return nil, err
+	}
+	o, err := NewOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+	return NewRenderer(s, width, height, o), nil
+}
+
+// WithThreads makes the renders trace threads tiles at once, instead of
+// DefaultWorkers
+func WithThreads(threads int) Option {
+	return func(o *Options) error {
+		if threads < 1 {
+			return fmt.Errorf("there must be at least 1 thread, not %d", threads)
+		}
+		o.Workers = threads
+		return nil
+	}
+}
+
+// WithDuty makes the workers spend the fraction of the time rendering, as
+// Options.Duty says, such as NiceDuty
+func WithDuty(duty float64) Option {
+	return func(o *Options) error {
+		if !(duty > 0 && duty <= 1) {
+			return fmt.Errorf("the duty cycle must be above 0 and at most 1, not %v", duty)
+		}
+		o.Duty = duty
+		return nil
+	}
+}
+
+// WithProgress makes the renders tell p about their progress
+func WithProgress(p ProgressReporter) Option {
+	return func(o *Options) error {
+		o.Progress = p
+		return nil
+	}
+}
+
+// WithHalfBuffers makes progressive renders accumulate their samples in
+// half precision, as Options.HalfBuffers says
+func WithHalfBuffers() Option {
+	return func(o *Options) error {
+		o.HalfBuffers = true
+		return nil
+	}
+}
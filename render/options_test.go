@@ -0,0 +1,31 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+func TestNew(t *testing.T) {
+	s := scene.New()
+	r, err := New(s, 32, 16, WithThreads(3), WithDuty(NiceDuty), WithHalfBuffers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Options{Workers: 3, Duty: NiceDuty, HalfBuffers: true}); r.opts != want || r.width != 32 || r.height != 16 {
+		t.Errorf("The renderer should render 32x16 with %+v, not %dx%d with %+v", want, r.width, r.height, r.opts)
+	}
+
+	if _, err := New(s, 0, 16); err == nil {
+		t.Error("A render with no pixels should fail")
+	}
+	for _, option := range []Option{WithThreads(0), WithDuty(0), WithDuty(1.5)} {
+		if _, err := New(s, 32, 16, option); err == nil {
+			t.Error("Invalid options should fail")
+		}
+	}
+	s.Settings.Samples = 0
+	if _, err := New(s, 32, 16); err == nil {
+		t.Error("A scene that can't be rendered should fail")
+	}
+}
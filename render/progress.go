@@ -0,0 +1,137 @@
+package render
+
+import (
+	"fmt"
+	stdimg "image"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// Progress is the progress of a render when one of its tiles is done
+type Progress struct {
+	// Tile is the tile that is done, Pass the pass of progressive renders
+	// it belongs to, and TileTime how long it took to trace
+	Tile     stdimg.Rectangle
+	Pass     int
+	TileTime time.Duration
+	// TilesDone of the Tiles of the whole render are done. The tiles of
+	// progressive renders are counted once per pass.
+	TilesDone, Tiles int
+	// Samples and Rays are the number of samples and rays traced since
+	// the render started, in Elapsed
+	Samples, Rays                   uint64
+	Elapsed                         time.Duration
+	SamplesPerSecond, RaysPerSecond float64
+	// ETA is the time left to finish the render at the pace so far
+	ETA time.Duration
+}
+
+// Fraction returns the fraction of the render that is done, from 0 to 1
+func (p *Progress) Fraction() float64 {
+	if p.Tiles == 0 {
+		return 1
+	}
+	return float64(p.TilesDone) / float64(p.Tiles)
+}
+
+// ProgressReporter is told about the progress of renders
+type ProgressReporter interface {
+	// Report is called every time a tile is done. It's called from the
+	// goroutines rendering tiles, but never from two at once, and it
+	// holds them up, so it should return quickly.
+	Report(p Progress)
+}
+
+// tracker counts the tiles of a render as they are done and reports the
+// progress to a reporter
+type tracker struct {
+	reporter ProgressReporter
+	scene    *scene.Scene
+	start    time.Time
+	// samples and rays are the stats of the scene when the render started
+	samples, rays uint64
+
+	mu sync.Mutex
+	// done is the number of tiles done, of which started were done before
+	// the render started, as when resuming it
+	done, started int
+}
+
+// newTracker returns a tracker of a render of the scene that reports to
+// the reporter, which may be nil, with done tiles already done
+func newTracker(reporter ProgressReporter, s *scene.Scene, done int) *tracker {
+	t := &tracker{reporter: reporter, scene: s, start: time.Now(), done: done, started: done}
+	t.samples, t.rays = s.Stats()
+	return t
+}
+
+// tileDone reports that the tile of the pass, which started being traced
+// at start, is done, out of tiles in the whole render
+func (t *tracker) tileDone(tile stdimg.Rectangle, pass int, start time.Time, tiles int) {
+	if t.reporter == nil {
+		return
+	}
+	now := time.Now()
+	samples, rays := t.scene.Stats()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done++
+	p := Progress{
+		Tile: tile, Pass: pass, TileTime: now.Sub(start),
+		TilesDone: t.done, Tiles: tiles,
+		Samples: samples - t.samples, Rays: rays - t.rays,
+		Elapsed: now.Sub(t.start)}
+	if seconds := p.Elapsed.Seconds(); seconds > 0 {
+		p.SamplesPerSecond = float64(p.Samples) / seconds
+		p.RaysPerSecond = float64(p.Rays) / seconds
+	}
+	if left := tiles - t.done; left > 0 {
+		p.ETA = time.Duration(float64(p.Elapsed) * float64(left) / float64(t.done-t.started))
+	}
+	t.reporter.Report(p)
+}
+
+// TerminalProgress reports the progress of renders on a line of a
+// terminal, which it rewrites at most every Interval
+type TerminalProgress struct {
+	w        io.Writer
+	Interval time.Duration
+	last     time.Time
+}
+
+// NewTerminalProgress returns a reporter that writes the progress to w,
+// which should be a terminal, such as os.Stderr, ten times a second
+func NewTerminalProgress(w io.Writer) *TerminalProgress {
+	return &TerminalProgress{w: w, Interval: 100 * time.Millisecond}
+}
+
+// Report rewrites the line with the progress, and ends it once the render
+// is done
+func (t *TerminalProgress) Report(p Progress) {
+	finished := p.TilesDone >= p.Tiles
+	if !finished && time.Since(t.last) < t.Interval {
+		return
+	}
+	t.last = time.Now()
+	fmt.Fprintf(t.w, "\r%5.1f%%  %d/%d tiles  %s samples/s  %s rays/s  ETA %s\x1b[K",
+		100*p.Fraction(), p.TilesDone, p.Tiles, metric(p.SamplesPerSecond), metric(p.RaysPerSecond), p.ETA.Round(time.Second))
+	if finished {
+		fmt.Fprintln(t.w)
+	}
+}
+
+// metric returns the number with a metric prefix, such as 1.5M
+func metric(n float64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fG", n/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", n/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fk", n/1e3)
+	}
+	return fmt.Sprintf("%.0f", n)
+}
@@ -0,0 +1,72 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// recorder keeps every progress reported
+type recorder []Progress
+
+func (r *recorder) Report(p Progress) {
+	*r = append(*r, p)
+}
+
+func progressScene(samples int) *scene.Scene {
+	s := scene.New()
+	s.Settings.Samples = samples
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	return s
+}
+
+func TestSceneReportsEveryTile(t *testing.T) {
+	var reports recorder
+	if _, err := Scene(context.Background(), progressScene(2), 40, 40, Options{Workers: 2, Progress: &reports}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 4 {
+		t.Fatalf("Each of the 4 tiles should be reported, there are %d reports", len(reports))
+	}
+	for i, p := range reports {
+		if p.TilesDone != i+1 || p.Tiles != 4 || p.Rays < p.Samples {
+			t.Errorf("Report %d is wrong: %+v", i, p)
+		}
+	}
+	last := reports[3]
+	if last.Samples != 2*40*40 || last.ETA != 0 || last.Fraction() != 1 {
+		t.Errorf("The render takes 2 samples of 1600 pixels and is done, but the last report is %+v", last)
+	}
+}
+
+func TestRendererReportsTheTilesOfEveryPass(t *testing.T) {
+	var reports recorder
+	r := NewRenderer(progressScene(3), 40, 40, Options{Workers: 1, Progress: &reports})
+	r.Start(context.Background())
+	<-r.Done()
+	if len(reports) != 12 || reports[11].TilesDone != 12 || reports[11].Tiles != 12 || reports[11].Pass != 2 {
+		t.Errorf("The 4 tiles of the 3 passes should be reported, the reports are %+v", reports)
+	}
+	if samples, _ := r.scene.Stats(); reports[11].Samples != samples {
+		t.Errorf("Every sample of the scene is the render's, but %d of %d are reported", reports[11].Samples, samples)
+	}
+}
+
+func TestTerminalProgressEndsTheLineWhenDone(t *testing.T) {
+	var out bytes.Buffer
+	terminal := NewTerminalProgress(&out)
+	terminal.Report(Progress{TilesDone: 1, Tiles: 2, SamplesPerSecond: 1500, RaysPerSecond: 2.5e6})
+	terminal.Report(Progress{TilesDone: 2, Tiles: 2})
+	lines := out.String()
+	if !strings.Contains(lines, "1.5k samples/s") || !strings.Contains(lines, "2.5M rays/s") || !strings.HasSuffix(lines, "\n") {
+		t.Errorf("Unexpected progress %q", lines)
+	}
+}
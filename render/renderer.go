@@ -0,0 +1,404 @@
+package render
+
+import (
+	"context"
+	stdimg "image"
+	"sync"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// Renderer traces a scene progressively in the background. Every pass adds
+// a sample to every pixel, until there are as many as the settings of the
+// scene say or SetSamples asks for. It can be paused and resumed without
+// losing the samples accumulated so far. The scene mustn't be edited while
+// the renderer runs, but Reload can replace it with a new version.
+type Renderer struct {
+	width, height int
+	opts          Options
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// scene is the scene being traced, and targetIt its camera's rays.
+	// Reloading replaces them and counts a new generation, so that the
+	// tiles started before carry on with the new scene.
+	scene      *scene.Scene
+	targetIt   *camera.TracingTargetIterator
+	generation int
+	// ctx is the context given to Start, and running whether the passes
+	// are being traced, until the renderer is done
+	ctx     context.Context
+	running bool
+	// pixels holds the radiance of the samples of every pixel so far,
+	// and count how many samples there are
+	pixels buffer
+	count  []int
+	passes int
+	// samples is the number of passes to make, and traced the number of
+	// tiles traced so far
+	samples int
+	traced  int
+	// busy is the number of tiles being traced
+	busy            int
+	paused, stopped bool
+	// err is the error of the context that stopped the renderer, if any
+	err  error
+	done chan struct{}
+}
+
+// NewRenderer returns a renderer of width x height renders of the scene
+// that traces tiles as the options say. It doesn't start until Start is
+// called.
+func NewRenderer(s *scene.Scene, width, height int, opts Options) *Renderer {
+	r := &Renderer{
+		scene: s, targetIt: s.Camera.GetIterator(width, height), width: width, height: height, opts: opts,
+		pixels:  newBuffer(width*height, opts.HalfBuffers),
+		count:   make([]int, width*height),
+		samples: s.Settings.Samples,
+		done:    make(chan struct{})}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// Start starts tracing in the background. When the context is done, the
+// renderer stops as Stop does and Err returns the error of the context. A
+// renderer stopped before it started doesn't start.
+func (r *Renderer) Start(ctx context.Context) {
+	r.scene.Prepare()
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return
+	}
+	r.ctx, r.running = ctx, true
+	done := r.done
+	r.mu.Unlock()
+	r.launch(done)
+}
+
+// launch traces the passes in the background until the renderer is done,
+// closing done then, and stops it when the context is done
+func (r *Renderer) launch(done chan struct{}) {
+	go r.run(done)
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			r.mu.Lock()
+			if !r.stopped && r.passes < r.samples {
+				r.err = r.ctx.Err()
+			}
+			r.mu.Unlock()
+			r.Stop()
+		case <-done:
+		}
+	}()
+}
+
+func (r *Renderer) run(done chan struct{}) {
+	tiles := Tiles(r.width, r.height, DefaultTileSize)
+	progress := newTracker(r.opts.Progress, r.scene, r.tilesDone(tiles))
+	for {
+		// The renderer is done as soon as there are no passes left, so that
+		// Reload knows whether to start it again
+		r.mu.Lock()
+		if r.stopped || r.passes >= r.samples {
+			r.running = false
+			close(done)
+			r.mu.Unlock()
+			return
+		}
+		pass, generation := r.passes, r.generation
+		r.mu.Unlock()
+		// Stopping is up to acquire, which also waits while paused
+		ForEachTileWith(context.Background(), tiles, r.opts, func(tile stdimg.Rectangle) {
+			s, targetIt, ok := r.acquire(generation)
+			if !ok {
+				return
+			}
+			defer r.release()
+			if r.hasSample(tile, pass) {
+				return
+			}
+			start := time.Now()
+			samples := make([]image.Color, 0, tile.Dx()*tile.Dy())
+			for y := tile.Min.Y; y < tile.Max.Y; y++ {
+				for x := tile.Min.X; x < tile.Max.X; x++ {
+					samples = append(samples, s.TraceSample(targetIt, x, y, pass))
+				}
+			}
+			r.mu.Lock()
+			i := 0
+			for y := tile.Min.Y; y < tile.Max.Y; y++ {
+				for x := tile.Min.X; x < tile.Max.X; x++ {
+					r.count[y*r.width+x]++
+					r.pixels.add(y*r.width+x, &samples[i], r.count[y*r.width+x])
+					i++
+				}
+			}
+			r.traced++
+			r.mu.Unlock()
+			progress.tileDone(tile, pass, start, len(tiles)*r.Samples())
+		})
+		r.mu.Lock()
+		if !r.stopped && r.generation == generation {
+			r.passes++
+		}
+		r.mu.Unlock()
+	}
+}
+
+// tilesDone returns the number of tiles of the passes done so far, and of
+// the current pass if a checkpoint was saved in the middle of it
+func (r *Renderer) tilesDone(tiles []stdimg.Rectangle) int {
+	pass := r.Passes()
+	done := pass * len(tiles)
+	for _, tile := range tiles {
+		if r.hasSample(tile, pass) {
+			done++
+		}
+	}
+	return done
+}
+
+// acquire waits while the renderer is paused and marks a tile of a pass of
+// the generation as being traced, returning the scene and the rays to
+// trace it with. ok is false if the renderer was stopped instead, or the
+// scene was reloaded since the pass started, which leaves the rest of the
+// pass to the passes of the new generation.
+func (r *Renderer) acquire(generation int) (s *scene.Scene, targetIt *camera.TracingTargetIterator, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.paused && !r.stopped {
+		r.cond.Wait()
+	}
+	if r.stopped || r.generation != generation {
+		return nil, nil, false
+	}
+	r.busy++
+	return r.scene, r.targetIt, true
+}
+
+func (r *Renderer) release() {
+	r.mu.Lock()
+	r.busy--
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+// Pause stops tracing new tiles and waits for the ones being traced to
+// finish, so the renderer doesn't use the CPU until Resume is called.
+func (r *Renderer) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = true
+	for r.busy > 0 {
+		r.cond.Wait()
+	}
+}
+
+// Resume continues tracing after Pause, from where it was left
+func (r *Renderer) Resume() {
+	r.mu.Lock()
+	r.paused = false
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+// Stop stops tracing for good and waits for the renderer to finish. The
+// samples accumulated so far are kept. A renderer that never started is
+// done as soon as it's stopped.
+func (r *Renderer) Stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.cond.Broadcast()
+	done := r.done
+	if r.ctx == nil {
+		// Only run closes done once started, so nothing else would
+		select {
+		case <-done:
+		default:
+			close(done)
+		}
+	}
+	r.mu.Unlock()
+	<-done
+}
+
+// Done returns a channel that is closed when the renderer finishes,
+// either because it has every sample or because it was stopped. Reloading
+// a renderer that finished starts it again, with a new channel.
+func (r *Renderer) Done() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done
+}
+
+// Reload replaces the scene the renderer traces with a new version of it,
+// such as the one of its scene file after an edit. The samples of the
+// pixels that the changes found by DiffFrom can't have changed are kept,
+// and the rest are traced again from the first. The samples to stop at are
+// the ones of the new scene. A renderer that was done starts again if
+// there's anything left to trace, unless it was stopped. It returns the
+// region of the render traced again.
+func (r *Renderer) Reload(s *scene.Scene) stdimg.Rectangle {
+	r.mu.Lock()
+	old := r.scene
+	r.mu.Unlock()
+	region := stdimg.Rectangle{}
+	if s.DiffFrom(old) {
+		region = s.DirtyRegion(r.width, r.height)
+	}
+	s.Prepare()
+	s.MarkTraced()
+	targetIt := s.Camera.GetIterator(r.width, r.height)
+
+	// The tiles being traced finish with the old scene, and the rest of
+	// their pass is left to the new generation
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.busy > 0 {
+		r.cond.Wait()
+	}
+	r.scene, r.targetIt, r.samples = s, targetIt, s.Settings.Samples
+	r.generation++
+	tiles := Tiles(r.width, r.height, DefaultTileSize)
+	for _, tile := range tiles {
+		if !tile.Overlaps(region) {
+			continue
+		}
+		for y := tile.Min.Y; y < tile.Max.Y; y++ {
+			for x := tile.Min.X; x < tile.Max.X; x++ {
+				r.pixels.load(y*r.width+x, &image.Color{}, 0)
+				r.count[y*r.width+x] = 0
+			}
+		}
+	}
+	// The passes done are the ones every tile has
+	r.passes = -1
+	for _, tile := range tiles {
+		if count := r.count[tile.Min.Y*r.width+tile.Min.X]; r.passes < 0 || count < r.passes {
+			r.passes = count
+		}
+	}
+	r.traced++
+	r.cond.Broadcast()
+	r.relaunch()
+	return region
+}
+
+// SetCamera moves the camera of the scene the renderer traces and traces
+// the render again from the first sample, as flying through the scene
+// does. The tiles being traced finish first, and their samples are thrown
+// away. A renderer that was done starts again, unless it was stopped.
+func (r *Renderer) SetCamera(c camera.PinHole) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.busy > 0 {
+		r.cond.Wait()
+	}
+	r.scene.Camera = c
+	r.targetIt = r.scene.Camera.GetIterator(r.width, r.height)
+	r.generation++
+	for i := range r.count {
+		r.pixels.load(i, &image.Color{}, 0)
+		r.count[i] = 0
+	}
+	r.passes = 0
+	r.traced++
+	r.cond.Broadcast()
+	r.relaunch()
+}
+
+// Camera returns the camera of the scene the renderer traces
+func (r *Renderer) Camera() camera.PinHole {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.scene.Camera
+}
+
+// relaunch starts the renderer again if it was done but has passes left
+// to trace, unless it was stopped or never started. It must be called
+// with the lock held.
+func (r *Renderer) relaunch() {
+	if !r.running && !r.stopped && r.ctx != nil && r.passes < r.samples {
+		r.running, r.done = true, make(chan struct{})
+		r.launch(r.done)
+	}
+}
+
+// Err returns the error of the context given to Start if the renderer
+// stopped because it was done, or nil otherwise
+func (r *Renderer) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Passes returns the number of passes finished so far
+func (r *Renderer) Passes() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.passes
+}
+
+// Image returns the render with the samples accumulated so far. Pixels
+// without samples are black.
+func (r *Renderer) Image() *image.Image {
+	render := image.New(r.width, r.height)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			i := y*r.width + x
+			if r.count[i] > 0 {
+				mean := r.pixels.mean(i, r.count[i])
+				render.Set(x, y, r.scene.Settings.Encode(&mean))
+			}
+		}
+	}
+	return render
+}
+
+// SetSamples changes the number of samples of every pixel the renderer
+// stops at. Lowering it below the passes finished so far stops it after
+// the current pass. Raising it once the renderer is done has no effect.
+func (r *Renderer) SetSamples(samples int) {
+	r.mu.Lock()
+	r.samples = samples
+	r.mu.Unlock()
+}
+
+// Samples returns the number of samples of every pixel the renderer
+// stops at
+func (r *Renderer) Samples() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.samples
+}
+
+// Paused returns whether the renderer is paused
+func (r *Renderer) Paused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// hasSample returns whether the pixels of the tile already have the
+// sample of the pass, as those of a checkpoint saved in the middle of it
+// do. Every pixel of a tile has as many samples as the others.
+func (r *Renderer) hasSample(tile stdimg.Rectangle, pass int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count[tile.Min.Y*r.width+tile.Min.X] > pass
+}
+
+// tracedTiles returns the number of tiles traced so far, which changes
+// every time Image does
+func (r *Renderer) tracedTiles() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.traced
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestRendererPausesAndResumes(t *testing.T) {
+	s := scene.New()
+	s.Settings.Samples = 3
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	r := NewRenderer(s, 64, 64, Options{Workers: 2})
+	r.Pause()
+	r.Start(context.Background())
+	time.Sleep(20 * time.Millisecond)
+	if passes := r.Passes(); passes != 0 {
+		t.Errorf("A paused renderer shouldn't make progress, it made %d passes", passes)
+	}
+	r.Resume()
+	select {
+	case <-r.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("The renderer should finish after it's resumed")
+	}
+	if passes := r.Passes(); passes != 3 {
+		t.Errorf("The renderer should stop after 3 passes, it made %d", passes)
+	}
+	// The samples are the same ones TraceScene takes
+	if !bytes.Equal(r.Image().Pix, s.TraceScene(64, 64).Pix) {
+		t.Error("The accumulated render should match tracing the scene at once")
+	}
+}
+
+func TestRendererStop(t *testing.T) {
+	s := scene.New()
+	s.Settings.Samples = 1 << 20
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	r := NewRenderer(s, 16, 16, Options{Workers: 1})
+	r.Start(context.Background())
+	r.Stop()
+	if r.Passes() == 1<<20 {
+		t.Error("A stopped renderer shouldn't finish every pass")
+	}
+}
+
+func TestRendererStopBeforeStart(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	r := NewRenderer(s, 16, 16, Options{Workers: 1})
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		r.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("A renderer that never started should stop right away")
+	}
+	r.Start(context.Background())
+	r.Stop()
+	if r.Passes() != 0 {
+		t.Error("A stopped renderer shouldn't start")
+	}
+}
+
+func TestRendererStopsWhenTheContextIsDone(t *testing.T) {
+	s := scene.New()
+	s.Settings.Samples = 1 << 20
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	r := NewRenderer(s, 16, 16, Options{Workers: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r.Start(ctx)
+	select {
+	case <-r.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("The renderer should stop at the deadline")
+	}
+	if r.Err() != context.DeadlineExceeded || r.Passes() == 0 {
+		t.Errorf("The renderer should keep the passes made by the deadline, it made %d and its error is %v", r.Passes(), r.Err())
+	}
+
+	finished := NewRenderer(s, 16, 16, Options{})
+	finished.SetSamples(1)
+	ctx, cancel = context.WithCancel(context.Background())
+	finished.Start(ctx)
+	<-finished.Done()
+	cancel()
+	if finished.Err() != nil {
+		t.Errorf("A render that finished shouldn't have an error, it has %v", finished.Err())
+	}
+}
+
+func TestRendererReload(t *testing.T) {
+	s := scene.New()
+	s.Settings.Samples = 2
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: -0.5, Z: 3}, Radius: 0.2})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 0.5, Z: 3}, Radius: 0.2})
+	r := NewRenderer(s, 64, 64, Options{Workers: 2})
+	r.Start(context.Background())
+	<-r.Done()
+
+	// Growing a sphere traces its part of the render again, and the
+	// renderer starts again to trace it
+	edited := scene.New()
+	edited.Settings.Samples = 2
+	edited.AddShape(&shape.Sphere{Position: math3d.Vector3{X: -0.5, Z: 3}, Radius: 0.3})
+	edited.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 0.5, Z: 3}, Radius: 0.2})
+	region := r.Reload(edited)
+	if region.Empty() || region.Dx() == 64 {
+		t.Errorf("Growing a sphere should trace part of the render again, not %v", region)
+	}
+	select {
+	case <-r.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("The renderer should finish the reloaded scene")
+	}
+	if passes := r.Passes(); passes != 2 {
+		t.Errorf("The renderer should stop after 2 passes of the reloaded scene, it made %d", passes)
+	}
+	if !bytes.Equal(r.Image().Pix, edited.TraceScene(64, 64).Pix) {
+		t.Error("The reloaded render should match tracing the edited scene at once")
+	}
+}
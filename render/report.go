@@ -0,0 +1,97 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// Report is the statistics report of a render, written at its end when
+// the render settings ask for statistics or for a report
+type Report struct {
+	Width   int           `json:"width"`
+	Height  int           `json:"height"`
+	Elapsed time.Duration `json:"elapsed"`
+	// Build is the part of Elapsed spent building the structures of the
+	// scene, if it was measured
+	Build time.Duration `json:"build,omitempty"`
+	// Statistics is the work done by the render alone
+	Statistics scene.Statistics `json:"statistics"`
+	Memory     Memory           `json:"memory"`
+	// Objects is the time tracing the pixels took by the object the camera
+	// rays hit first, the most costly first, if it was estimated
+	Objects []scene.Cost `json:"objects,omitempty"`
+	// Settings are the render settings of the scene
+	Settings scene.Settings `json:"settings"`
+}
+
+// Memory is the memory the program used at the end of a render, in bytes
+type Memory struct {
+	// Heap is the memory of the objects on the heap, which hold the scene
+	// and the image
+	Heap uint64 `json:"heap"`
+	// System is the memory the program got from the operating system,
+	// which stays about as high as the most it ever used
+	System uint64 `json:"system"`
+}
+
+// NewReport returns the report of a width x height render of the scene
+// that took elapsed, with the work done since before, the statistics of the
+// scene when it started, and the memory the program uses now
+func NewReport(s *scene.Scene, width, height int, elapsed time.Duration, before scene.Statistics) *Report {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	return &Report{Width: width, Height: height, Elapsed: elapsed, Statistics: s.Statistics().Since(before),
+		Memory: Memory{Heap: memory.HeapAlloc, System: memory.Sys}, Settings: s.Settings}
+}
+
+// EstimateObjects sets the objects of the report to the cost of tracing
+// the render of the scene by the object the camera rays hit first, which
+// Scene.ObjectCosts estimates by tracing a sparse grid of its pixels
+// again. The statistics of the report leave them out.
+func (r *Report) EstimateObjects(s *scene.Scene) {
+	r.Objects = s.ObjectCosts(r.Width, r.Height)
+}
+
+// WriteJSON writes the report to w as a JSON object. The durations are in
+// nanoseconds.
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes the report to w as a table for people to read, with the
+// counts per second and per ray
+func (r *Report) WriteText(w io.Writer) error {
+	st := &r.Statistics
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Render\t%dx%d\t%s\n", r.Width, r.Height, r.Elapsed.Round(time.Millisecond))
+	if r.Build > 0 {
+		fmt.Fprintf(tw, "Build\t\t%s\n", r.Build.Round(time.Millisecond))
+	}
+	row := func(name string, n uint64, per string, of uint64) {
+		fmt.Fprintf(tw, "%s\t%d", name, n)
+		if seconds := r.Elapsed.Seconds(); seconds > 0 {
+			fmt.Fprintf(tw, "\t%s/s", metric(float64(n)/seconds))
+		}
+		if per != "" && of > 0 {
+			fmt.Fprintf(tw, "\t%.2f per %s", float64(n)/float64(of), per)
+		}
+		fmt.Fprintln(tw)
+	}
+	row("Samples", st.Samples, "pixel", uint64(r.Width*r.Height))
+	row("Primary rays", st.PrimaryRays, "", 0)
+	row("Rays", st.Rays, "sample", st.Samples)
+	row("Shadow rays", st.ShadowRays, "ray", st.Rays)
+	if st.NodeVisits > 0 {
+		row("Nodes visited", st.NodeVisits, "ray", st.Rays)
+		row("Intersection tests", st.IntersectionTests, "ray", st.Rays)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportCountsTheWorkOfTheRender(t *testing.T) {
+	s := progressScene(2)
+	s.Settings.Stats = true
+	if _, err := Scene(context.Background(), s, 20, 20, Options{Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	before := s.Statistics()
+	if _, err := Scene(context.Background(), s, 20, 20, Options{Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	report := NewReport(s, 20, 20, time.Second, before)
+	st := report.Statistics
+	if st.Samples != 2*20*20 || st.PrimaryRays != st.Samples || st.ShadowRays == 0 || st.Rays < st.PrimaryRays+st.ShadowRays {
+		t.Errorf("The rays of the second render are miscounted: %+v", st)
+	}
+	if st.NodeVisits < st.Rays || st.IntersectionTests == 0 {
+		t.Errorf("Every ray visits the root of the BVH, but counted %+v", st)
+	}
+
+	var encoded bytes.Buffer
+	if err := report.WriteJSON(&encoded); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(encoded.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded, *report) {
+		t.Errorf("The JSON report %s doesn't decode to the report: %v", encoded.String(), err)
+	}
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "2.00 per pixel") || !strings.Contains(text.String(), "Intersection tests") {
+		t.Errorf("Unexpected text report:\n%s", text.String())
+	}
+}
+
+func TestReportLeavesOutTheBVHUnlessCounted(t *testing.T) {
+	s := progressScene(1)
+	before := s.Statistics()
+	if _, err := Scene(context.Background(), s, 20, 20, Options{Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	report := NewReport(s, 20, 20, time.Second, before)
+	if st := report.Statistics; st.NodeVisits != 0 || st.IntersectionTests != 0 {
+		t.Errorf("The BVH shouldn't be counted without the stats setting: %+v", st)
+	}
+	var text bytes.Buffer
+	report.WriteText(&text)
+	if strings.Contains(text.String(), "Nodes visited") {
+		t.Errorf("The text report shouldn't list the BVH:\n%s", text.String())
+	}
+}
+
+func TestReportEstimatesTheCostOfTheObjects(t *testing.T) {
+	s := progressScene(1)
+	s.Settings.Report = true
+	before := s.Statistics()
+	if _, err := Scene(context.Background(), s, 20, 20, Options{Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	report := NewReport(s, 20, 20, time.Second, before)
+	rendered := report.Statistics
+	report.EstimateObjects(s)
+	if len(report.Objects) == 0 {
+		t.Fatal("The report should hold the cost of the objects")
+	}
+	pixels := 0.0
+	for _, c := range report.Objects {
+		pixels += c.Pixels
+	}
+	if math.Abs(pixels-1) > 1e-9 {
+		t.Errorf("The objects should cover every pixel, but they cover %f of them", pixels)
+	}
+	if report.Statistics != rendered {
+		t.Error("The statistics of the report should leave out the pixels traced to estimate the objects")
+	}
+	if !report.Settings.Report || report.Memory.Heap == 0 || report.Memory.System < report.Memory.Heap {
+		t.Errorf("The report should hold the settings and the memory used, not %+v and %+v", report.Settings, report.Memory)
+	}
+	var encoded bytes.Buffer
+	if err := report.WriteJSON(&encoded); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"elapsed", "statistics", "memory", "objects", "settings"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("The JSON report should have %q: %s", key, encoded.String())
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package render
+
+import (
+	"context"
+	stdimg "image"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// Scene traces a width x height render of the scene, splitting it in
+// tiles that are traced in parallel as the options say. The render is the
+// same TraceScene returns, only faster, and only the tiles in the crop
+// window of the settings are traced. If the context is done first, the
+// tiles not traced yet are left black and its error is returned with the
+// render.
+func Scene(ctx context.Context, s *scene.Scene, width, height int, opts Options) (*image.Image, error) {
+	render := image.New(width, height)
+	s.Prepare()
+	window := s.Settings.CropWindow(width, height)
+	var tiles []stdimg.Rectangle
+	for _, tile := range Tiles(width, height, DefaultTileSize) {
+		if tile = tile.Intersect(window); !tile.Empty() {
+			tiles = append(tiles, tile)
+		}
+	}
+	progress := newTracker(opts.Progress, s, 0)
+	err := ForEachTileWith(ctx, tiles, opts, func(tile stdimg.Rectangle) {
+		start := time.Now()
+		s.TraceRegion(render, tile)
+		progress.tileDone(tile, 0, start, len(tiles))
+	})
+	if err != nil {
+		return render, err
+	}
+	s.MarkTraced()
+	return render, nil
+}
@@ -0,0 +1,295 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+// DefaultInterval is how often the preview stream of a server checks for
+// new samples
+const DefaultInterval = time.Second
+
+// Server serves a web page to watch a renderer progress, and to pause,
+// resume or change the samples of the render from a browser, or to fly
+// the camera through the scene to find the shot for the final render.
+// It answers:
+//
+//	GET  /              the page
+//	GET  /stream        the render as MJPEG, a new JPEG every time it has
+//	                    new samples, until the renderer is done, or for
+//	                    as long as the browser watches if the server is
+//	                    live
+//	GET  /image.png     the render so far
+//	GET  /thumbnail.png?width=W&height=H
+//	                    the render so far scaled down to fit in W x H, or
+//	                    in 256 x 256 without them, for pages that poll
+//	                    it without fetching the whole render every time
+//	GET  /status        the "passes" done, the "samples" to stop at,
+//	                    whether the renderer is "paused" or "done" and
+//	                    whether the camera can "fly", as JSON
+//	GET  /camera        the camera, as the "camera" of scene files, to
+//	                    paste in the scene file once it's where it should
+//	POST /pause         pauses the renderer
+//	POST /resume        resumes the renderer
+//	POST /samples?n=N   sets the samples to stop at
+//	POST /fly?forward=F&right=R&up=U&yaw=Y&pitch=P
+//	                    moves the camera as camera.PinHole's Fly does, by
+//	                    the parameters given, and renders again from the
+//	                    first sample, if the server lets the camera fly
+//
+// With the camera flying, the page moves it with W, A, S and D, and Q and
+// E to go down and up, and turns it by dragging the render.
+type Server struct {
+	// Live keeps the streams going once the renderer is done, for
+	// renderers that start again when their scene is reloaded
+	Live bool
+	// Fly lets the browser move the camera, which makes the renderer
+	// start again, so the server should be live too
+	Fly      bool
+	renderer *Renderer
+	interval time.Duration
+}
+
+// NewServer returns a server of the renderer whose stream checks for new
+// samples every interval, DefaultInterval if it's 0
+func NewServer(r *Renderer, interval time.Duration) *Server {
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	return &Server{renderer: r, interval: interval}
+}
+
+// ServeHTTP serves the requests of the browser
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(previewPage))
+	case r.URL.Path == "/stream" && r.Method == http.MethodGet:
+		s.serveStream(w, r)
+	case r.URL.Path == "/image.png" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, s.renderer.Image())
+	case r.URL.Path == "/thumbnail.png" && r.Method == http.MethodGet:
+		s.serveThumbnail(w, r)
+	case r.URL.Path == "/status" && r.Method == http.MethodGet:
+		s.serveStatus(w)
+	case r.URL.Path == "/camera" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.renderer.Camera())
+	case r.URL.Path == "/fly" && r.Method == http.MethodPost && s.Fly:
+		s.serveFly(w, r)
+	case r.URL.Path == "/pause" && r.Method == http.MethodPost:
+		s.renderer.Pause()
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/resume" && r.Method == http.MethodPost:
+		s.renderer.Resume()
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/samples" && r.Method == http.MethodPost:
+		samples, err := strconv.Atoi(r.URL.Query().Get("n"))
+		if err != nil || samples < 1 {
+			http.Error(w, "the samples must be a positive number", http.StatusBadRequest)
+			return
+		}
+		s.renderer.SetSamples(samples)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveStream sends the render as a JPEG every time it changes, until the
+// renderer is done, unless the server is live, or the browser goes away
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+parts.Boundary())
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	sent := -1
+	for {
+		// Checking before taking the image makes sure the last one has
+		// every sample
+		done := s.isDone()
+		if traced := s.renderer.tracedTiles(); traced != sent {
+			if err := s.sendFrame(w, parts); err != nil {
+				return
+			}
+			sent = traced
+		}
+		if done && !s.Live {
+			parts.Close()
+			return
+		}
+		// A nil channel never closes, so done renderers are only checked
+		// again on the next tick
+		var finished <-chan struct{}
+		if !done {
+			finished = s.renderer.Done()
+		}
+		select {
+		case <-ticker.C:
+		case <-finished:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// sendFrame writes the render as the next part of the stream
+func (s *Server) sendFrame(w http.ResponseWriter, parts *multipart.Writer) error {
+	var frame bytes.Buffer
+	if err := jpeg.Encode(&frame, s.renderer.Image(), &jpeg.Options{Quality: 90}); err != nil {
+		return err
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "image/jpeg")
+	header.Set("Content-Length", strconv.Itoa(frame.Len()))
+	part, err := parts.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(frame.Bytes()); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// serveFly moves the camera by the parameters of the request
+func (s *Server) serveFly(w http.ResponseWriter, r *http.Request) {
+	var moves [5]float64
+	for i, name := range []string{"forward", "right", "up", "yaw", "pitch"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			http.Error(w, name+" must be a number", http.StatusBadRequest)
+			return
+		}
+		moves[i] = v
+	}
+	c := s.renderer.Camera()
+	c.Fly(moves[0], moves[1], moves[2], moves[3], moves[4])
+	s.renderer.SetCamera(c)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveThumbnail sends the render so far scaled down to the size of the
+// request
+func (s *Server) serveThumbnail(w http.ResponseWriter, r *http.Request) {
+	width, height := image.ThumbnailSize, image.ThumbnailSize
+	for _, size := range []struct {
+		name  string
+		value *int
+	}{{"width", &width}, {"height", &height}} {
+		if value := r.URL.Query().Get(size.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				http.Error(w, "the "+size.name+" must be a positive number", http.StatusBadRequest)
+				return
+			}
+			*size.value = n
+		}
+	}
+	w.Header().Set("Content-Type", "image/png")
+	s.renderer.Image().Downsample(width, height).EncodePNG(w)
+}
+
+func (s *Server) serveStatus(w http.ResponseWriter) {
+	status := struct {
+		Passes  int  `json:"passes"`
+		Samples int  `json:"samples"`
+		Paused  bool `json:"paused"`
+		Done    bool `json:"done"`
+		Fly     bool `json:"fly"`
+	}{s.renderer.Passes(), s.renderer.Samples(), s.renderer.Paused(), s.isDone(), s.Fly}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Server) isDone() bool {
+	select {
+	case <-s.renderer.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// previewPage shows the stream and the status, updating it every second
+const previewPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>goraytrace</title>
+<style>
+body { background: #222; color: #ddd; font-family: sans-serif; text-align: center; }
+img { max-width: 100%; image-rendering: pixelated; }
+</style>
+</head>
+<body>
+<p><img src="stream" alt="render"></p>
+<p>
+<span id="status">loading</span>
+<button onclick="post('pause')">Pause</button>
+<button onclick="post('resume')">Resume</button>
+<input id="samples" type="number" min="1" size="6">
+<button onclick="post('samples?n=' + document.getElementById('samples').value)">Set samples</button>
+</p>
+<p id="fly" hidden>
+Fly with W, A, S, D, Q and E, and drag the render to turn.
+Step <input id="step" type="number" min="0" step="0.1" value="0.1" size="4">
+<a href="camera" target="_blank">Camera</a>
+</p>
+<script>
+function post(path) {
+	fetch(path, {method: "POST"}).then(update);
+}
+function update() {
+	fetch("status").then(r => r.json()).then(s => {
+		let state = s.done ? "done" : s.paused ? "paused" : "rendering";
+		document.getElementById("status").textContent = s.passes + " of " + s.samples + " samples, " + state;
+		document.getElementById("fly").hidden = !s.fly;
+	});
+}
+const keys = {w: [1, 0, 0], s: [-1, 0, 0], d: [0, 1, 0], a: [0, -1, 0], e: [0, 0, 1], q: [0, 0, -1]};
+document.addEventListener("keydown", e => {
+	let move = keys[e.key.toLowerCase()];
+	if (document.getElementById("fly").hidden || !move || e.target.tagName == "INPUT") {
+		return;
+	}
+	let step = parseFloat(document.getElementById("step").value) || 0;
+	post("fly?forward=" + move[0] * step + "&right=" + move[1] * step + "&up=" + move[2] * step);
+});
+let drag = null;
+const render = document.querySelector("img");
+render.addEventListener("mousedown", e => { drag = [e.clientX, e.clientY]; e.preventDefault(); });
+document.addEventListener("mouseup", e => {
+	if (drag && !document.getElementById("fly").hidden) {
+		let yaw = (e.clientX - drag[0]) * 0.005, pitch = (drag[1] - e.clientY) * 0.005;
+		if (yaw || pitch) {
+			post("fly?yaw=" + yaw + "&pitch=" + pitch);
+		}
+	}
+	drag = null;
+});
+update();
+setInterval(update, 1000);
+</script>
+</body>
+</html>
+`
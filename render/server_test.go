@@ -0,0 +1,159 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestServerStreamsTheRenderUntilItsDone(t *testing.T) {
+	s := scene.New()
+	s.Settings.Samples = 1 << 20
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	r := NewRenderer(s, 32, 32, Options{Workers: 1})
+	server := httptest.NewServer(NewServer(r, 10*time.Millisecond))
+	defer server.Close()
+
+	post := func(path string) int {
+		response, err := http.Post(server.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+	if status := post("/pause"); status != http.StatusNoContent {
+		t.Fatalf("Pausing should succeed, it answered %d", status)
+	}
+	r.Start(context.Background())
+	if status := post("/samples?n=0"); status != http.StatusBadRequest {
+		t.Errorf("A render needs some samples, but setting 0 answered %d", status)
+	}
+	post("/samples?n=2")
+	post("/resume")
+
+	response, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("The stream should be MJPEG, not %q", response.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(response.Body, params["boundary"])
+	frames := 0
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			break
+		}
+		if _, err := jpeg.Decode(part); err != nil {
+			t.Fatalf("Frame %d isn't a JPEG: %v", frames, err)
+		}
+		frames++
+	}
+	if frames == 0 {
+		t.Error("The stream should send at least the finished render")
+	}
+
+	var status struct {
+		Passes, Samples int
+		Paused, Done    bool
+	}
+	statusResponse, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer statusResponse.Body.Close()
+	if err := json.NewDecoder(statusResponse.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Passes != 2 || status.Samples != 2 || status.Paused || !status.Done {
+		t.Errorf("The stream should end once the 2 samples are done, the status is %+v", status)
+	}
+
+	thumbnail, err := http.Get(server.URL + "/thumbnail.png?width=8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thumbnail.Body.Close()
+	small, err := png.Decode(thumbnail.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Bounds().Dx() != 8 || small.Bounds().Dy() != 8 {
+		t.Errorf("The thumbnail should be the render scaled down to 8 x 8, not %v", small.Bounds())
+	}
+}
+
+func TestServerFliesTheCamera(t *testing.T) {
+	s := scene.New()
+	s.Settings.Samples = 2
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	r := NewRenderer(s, 32, 32, Options{Workers: 1})
+	handler := NewServer(r, 10*time.Millisecond)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	want := s.Camera
+	want.Fly(1, 0, 0, 0.1, 0)
+	r.Start(context.Background())
+	<-r.Done()
+
+	post := func(path string) int {
+		response, err := http.Post(server.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+	if status := post("/fly?forward=1"); status != http.StatusNotFound {
+		t.Errorf("The camera shouldn't fly unless the server lets it, it answered %d", status)
+	}
+	handler.Fly = true
+	if status := post("/fly?forward=x"); status != http.StatusBadRequest {
+		t.Errorf("Flying needs numbers, it answered %d", status)
+	}
+	if status := post("/fly?forward=1&yaw=0.1"); status != http.StatusNoContent {
+		t.Fatalf("Flying should succeed, it answered %d", status)
+	}
+	select {
+	case <-r.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("The renderer should render from the new viewpoint")
+	}
+	if r.Passes() != 2 || r.Camera() != want {
+		t.Errorf("The renderer should render 2 samples from %v, not %d from %v", want, r.Passes(), r.Camera())
+	}
+	if !bytes.Equal(r.Image().Pix, s.TraceScene(32, 32).Pix) {
+		t.Error("The render from the new viewpoint shouldn't keep samples from the old one")
+	}
+
+	response, err := http.Get(server.URL + "/camera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var decoded camera.PinHole
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil || decoded != want {
+		t.Errorf("The server should answer the camera %v, not %v (%v)", want, decoded, err)
+	}
+}
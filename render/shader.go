@@ -0,0 +1,49 @@
+package render
+
+import (
+	"context"
+	stdimg "image"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Shader returns the color of a point of a 2D image, in the manner of
+// Shadertoy. uv goes from (0, 0) in the bottom left corner of the image
+// to (1, 1) in the top right one, and time is the time of the frame in
+// seconds.
+type Shader func(uv math3d.Vector2, time float64) image.Color
+
+// RenderShader evaluates the shader at the center of every pixel of a
+// width x height image at the given time. If the context is done first,
+// the tiles left are black and its error is returned with the image.
+func RenderShader(ctx context.Context, shader Shader, width, height int, time float64) (*image.Image, error) {
+	img := image.New(width, height)
+	err := ForEachTile(ctx, Tiles(width, height, DefaultTileSize), 0, func(tile stdimg.Rectangle) {
+		for y := tile.Min.Y; y < tile.Max.Y; y++ {
+			for x := tile.Min.X; x < tile.Max.X; x++ {
+				uv := math3d.Vector2{
+					X: (float64(x) + 0.5) / float64(width),
+					Y: 1 - (float64(y)+0.5)/float64(height)}
+				color := shader(uv, time)
+				img.Set(x, y, color.ToNRGBA())
+			}
+		}
+	})
+	return img, err
+}
+
+// RenderShaderAnimation renders frames images of the shader starting at
+// time 0, fps frames per second, and saves them numbered after name, as
+// in name0000.png, name0001.png and so on. It stops at the frame being
+// rendered when the context is done, and returns its error.
+func RenderShaderAnimation(ctx context.Context, shader Shader, width, height, frames int, fps float64, name string) error {
+	for frame := 0; frame < frames; frame++ {
+		img, err := RenderShader(ctx, shader, width, height, float64(frame)/fps)
+		img.Save(FrameName(name, frame))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package render
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestTilesCoverTheImage(t *testing.T) {
+	covered := make([]int, 70*45)
+	for _, tile := range Tiles(70, 45, 16) {
+		for y := tile.Min.Y; y < tile.Max.Y; y++ {
+			for x := tile.Min.X; x < tile.Max.X; x++ {
+				covered[y*70+x]++
+			}
+		}
+	}
+	for i, c := range covered {
+		if c != 1 {
+			t.Fatalf("Pixel %d is covered by %d tiles", i, c)
+		}
+	}
+}
+
+func TestRenderShader(t *testing.T) {
+	// Red grows to the right and green to the top of the image
+	gradient := func(uv math3d.Vector2, time float64) image.Color {
+		return image.Color{R: uv.X, G: uv.Y, B: time}
+	}
+	img, _ := RenderShader(context.Background(), gradient, 64, 64, 1)
+	topLeft, bottomRight := img.NRGBAAt(0, 0), img.NRGBAAt(63, 63)
+	if topLeft.R > 5 || topLeft.G < 250 || topLeft.B != 255 {
+		t.Errorf("The top left corner should be green, not %v", topLeft)
+	}
+	if bottomRight.R < 250 || bottomRight.G > 5 {
+		t.Errorf("The bottom right corner should be red, not %v", bottomRight)
+	}
+}
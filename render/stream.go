@@ -0,0 +1,73 @@
+package render
+
+import (
+	"context"
+	stdimg "image"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/exr"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// SceneEXR traces a width x height render of the scene in tiles as Scene
+// does, and writes the linear radiance of its pixels to w as a tiled
+// OpenEXR image with R, G and B channels. Every tile is written as soon as
+// it's traced, so the render is never held in memory whole, however large
+// it is, such as a panorama 16384 pixels wide. The tiles outside the crop
+// window of the settings are black, and so are the ones not traced yet if
+// the context is done first, whose error is returned once the image is
+// written. Transparent renders have no alpha channel.
+func SceneEXR(ctx context.Context, s *scene.Scene, width, height int, opts Options, w io.WriteSeeker) error {
+	out, err := exr.NewTiledWriter(w, width, height, DefaultTileSize, []string{"R", "G", "B"}, nil)
+	if err != nil {
+		return err
+	}
+	s.Prepare()
+	targetIt := s.Camera.GetIterator(width, height)
+	window := s.Settings.CropWindow(width, height)
+	var tiles []stdimg.Rectangle
+	for _, tile := range Tiles(width, height, DefaultTileSize) {
+		if tile.Overlaps(window) {
+			tiles = append(tiles, tile)
+		}
+	}
+	progress := newTracker(opts.Progress, s, 0)
+	var mu sync.Mutex
+	var writeErr error
+	err = ForEachTileWith(ctx, tiles, opts, func(tile stdimg.Rectangle) {
+		start := time.Now()
+		channels := []exr.Channel{
+			{Name: "R", Values: make([]float32, tile.Dx()*tile.Dy())},
+			{Name: "G", Values: make([]float32, tile.Dx()*tile.Dy())},
+			{Name: "B", Values: make([]float32, tile.Dx()*tile.Dy())},
+		}
+		for y := tile.Min.Y; y < tile.Max.Y; y++ {
+			for x := tile.Min.X; x < tile.Max.X; x++ {
+				if !(stdimg.Point{X: x, Y: y}).In(window) {
+					continue
+				}
+				radiance := s.TracePixel(targetIt, x, y)
+				i := (y-tile.Min.Y)*tile.Dx() + x - tile.Min.X
+				channels[0].Values[i], channels[1].Values[i], channels[2].Values[i] = float32(radiance.R), float32(radiance.G), float32(radiance.B)
+			}
+		}
+		mu.Lock()
+		if writeErr == nil {
+			writeErr = out.WriteTile(tile, channels)
+		}
+		mu.Unlock()
+		progress.tileDone(tile, 0, start, len(tiles))
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err == nil {
+		s.MarkTraced()
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestSceneEXRWritesTheRadianceOfEveryTile(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	path := filepath.Join(t.TempDir(), "render.exr")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SceneEXR(context.Background(), s, 70, 40, Options{Workers: 3}, f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every tile is a block of its coordinates, its level and its size,
+	// and then every row of its pixels, with B, G and R in turn
+	targetIt := s.Camera.GetIterator(70, 40)
+	for _, tile := range Tiles(70, 40, DefaultTileSize) {
+		block := make([]byte, 20)
+		binary.LittleEndian.PutUint32(block, uint32(tile.Min.X/DefaultTileSize))
+		binary.LittleEndian.PutUint32(block[4:], uint32(tile.Min.Y/DefaultTileSize))
+		binary.LittleEndian.PutUint32(block[16:], uint32(12*tile.Dx()*tile.Dy()))
+		for y := tile.Min.Y; y < tile.Max.Y; y++ {
+			for c := 0; c < 3; c++ {
+				for x := tile.Min.X; x < tile.Max.X; x++ {
+					radiance := s.TracePixel(targetIt, x, y)
+					v := [3]float64{radiance.B, radiance.G, radiance.R}[c]
+					var bits [4]byte
+					binary.LittleEndian.PutUint32(bits[:], math.Float32bits(float32(v)))
+					block = append(block, bits[:]...)
+				}
+			}
+		}
+		if !bytes.Contains(data, block) {
+			t.Errorf("The image should hold the tile %v", tile)
+		}
+	}
+}
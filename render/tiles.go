@@ -0,0 +1,69 @@
+package render
+
+import (
+	"context"
+	stdimg "image"
+	"sync"
+	"time"
+)
+
+// DefaultTileSize is the side in pixels of the tiles images are split in
+const DefaultTileSize = 32
+
+// Tiles splits a width x height image in tiles of size x size pixels,
+// row by row. The tiles in the last row and column may be smaller.
+func Tiles(width, height, size int) []stdimg.Rectangle {
+	bounds := stdimg.Rect(0, 0, width, height)
+	tiles := make([]stdimg.Rectangle, 0, ((width+size-1)/size)*((height+size-1)/size))
+	for y := 0; y < height; y += size {
+		for x := 0; x < width; x += size {
+			tiles = append(tiles, stdimg.Rect(x, y, x+size, y+size).Intersect(bounds))
+		}
+	}
+	return tiles
+}
+
+// ForEachTile calls render with every tile from workers goroutines and
+// returns once all of them are done. Tiles are handed out in order, so
+// the ones at the start of the slice finish first. If workers isn't
+// positive, DefaultWorkers goroutines are used. If the context is done
+// before every tile is handed out, the rest are skipped and its error is
+// returned once the tiles being rendered are done.
+func ForEachTile(ctx context.Context, tiles []stdimg.Rectangle, workers int, render func(tile stdimg.Rectangle)) error {
+	return ForEachTileWith(ctx, tiles, Options{Workers: workers}, render)
+}
+
+// ForEachTileWith calls render with every tile like ForEachTile, with the
+// workers and the duty cycle in the options.
+func ForEachTileWith(ctx context.Context, tiles []stdimg.Rectangle, opts Options, render func(tile stdimg.Rectangle)) error {
+	workers := opts.workers()
+	queue := make(chan stdimg.Rectangle)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tile := range queue {
+				start := time.Now()
+				render(tile)
+				if opts.Duty > 0 && opts.Duty < 1 {
+					busy := time.Since(start)
+					time.Sleep(time.Duration(float64(busy) * (1 - opts.Duty) / opts.Duty))
+				}
+			}
+		}()
+	}
+	var err error
+handOut:
+	for _, tile := range tiles {
+		select {
+		case queue <- tile:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break handOut
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return err
+}
@@ -0,0 +1,113 @@
+package render
+
+import (
+	"io/ioutil"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// NiceDuty is the duty cycle of the workers of background renders, which
+// leave half of the CPU time they could use to other programs
+const NiceDuty = 0.5
+
+// cgroupFiles are the files that hold the CPU quota of the container the
+// process runs in, for cgroup v2 and v1
+const (
+	cgroupV2Max    = "/sys/fs/cgroup/cpu.max"
+	cgroupV1Quota  = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+	cgroupV1Period = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+)
+
+// Options control how the tiles of a render are handed out to workers
+type Options struct {
+	// Workers is the number of goroutines that render tiles. If it isn't
+	// positive, DefaultWorkers goroutines are used.
+	Workers int
+	// Duty is the fraction of the time that workers spend rendering. They
+	// rest after every tile for as long as needed to keep it, so renders
+	// in the background don't starve interactive programs. If it isn't
+	// between 0 and 1, workers never rest.
+	Duty float64
+	// Progress is told about the progress of the render, if it isn't nil
+	Progress ProgressReporter
+	// HalfBuffers makes progressive renderers accumulate the samples of
+	// every pixel in half precision floats, which take 6 bytes instead of
+	// 24, for very large renders. The samples are still traced in double
+	// precision, and the mean of every pixel keeps about 3 significant
+	// digits, which is enough for 8 bit images but not for HDR ones.
+	HalfBuffers bool
+}
+
+// CPULimitDuty returns the duty cycle that keeps the workers from using
+// more than the percent of the time of all the CPUs of the machine, so
+// that 25 leaves three quarters of it to other programs however many
+// workers there are. It's 1 if they can't use more.
+func CPULimitDuty(percent float64, workers int) float64 {
+	if workers < 1 {
+		workers = DefaultWorkers()
+	}
+	return math.Min(percent/100*float64(runtime.NumCPU())/float64(workers), 1)
+}
+
+// workers returns the number of workers to use
+func (o *Options) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return DefaultWorkers()
+}
+
+// DefaultWorkers returns GOMAXPROCS, lowered to the CPU quota of the
+// container the process runs in if it has one. Go doesn't take cgroup
+// limits into account for GOMAXPROCS, and running more workers than the
+// quota allows only makes the container get throttled.
+func DefaultWorkers() int {
+	workers := runtime.GOMAXPROCS(0)
+	if quota := cpuQuota(); quota > 0 && quota < float64(workers) {
+		workers = int(math.Ceil(quota))
+	}
+	return workers
+}
+
+// cpuQuota returns the number of CPUs the cgroup of the process may use,
+// or 0 if it isn't limited or the limit can't be read.
+func cpuQuota() float64 {
+	if max, err := ioutil.ReadFile(cgroupV2Max); err == nil {
+		return parseCPUMax(string(max))
+	}
+	quota, err := ioutil.ReadFile(cgroupV1Quota)
+	if err != nil {
+		return 0
+	}
+	period, err := ioutil.ReadFile(cgroupV1Period)
+	if err != nil {
+		return 0
+	}
+	return parseCFSQuota(string(quota), string(period))
+}
+
+// parseCPUMax returns the CPUs in the contents of a cgroup v2 cpu.max
+// file, which holds the quota and the period, or 0 if there's no quota
+func parseCPUMax(contents string) float64 {
+	fields := strings.Fields(contents)
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0
+	}
+	return parseCFSQuota(fields[0], fields[1])
+}
+
+// parseCFSQuota returns the CPUs in a cgroup quota and period, or 0 if
+// there's no quota
+func parseCFSQuota(quota, period string) float64 {
+	q, err := strconv.ParseFloat(strings.TrimSpace(quota), 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(strings.TrimSpace(period), 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
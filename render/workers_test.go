@@ -0,0 +1,105 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	stdimg "image"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestParseCPUQuota(t *testing.T) {
+	cases := []struct {
+		contents string
+		cpus     float64
+	}{
+		{"max 100000\n", 0},
+		{"150000 100000\n", 1.5},
+		{"400000 100000", 4},
+		{"garbage", 0},
+	}
+	for _, c := range cases {
+		if cpus := parseCPUMax(c.contents); cpus != c.cpus {
+			t.Errorf("%q should be %.1f CPUs, not %.1f", c.contents, c.cpus, cpus)
+		}
+	}
+	if cpus := parseCFSQuota("-1\n", "100000\n"); cpus != 0 {
+		t.Errorf("A quota of -1 means no limit, not %.1f CPUs", cpus)
+	}
+	if cpus := parseCFSQuota("50000\n", "100000\n"); cpus != 0.5 {
+		t.Errorf("The quota should be 0.5 CPUs, not %.1f", cpus)
+	}
+	if DefaultWorkers() < 1 {
+		t.Error("There should always be a worker")
+	}
+}
+
+func TestDutyMakesWorkersRest(t *testing.T) {
+	tiles := Tiles(4, 1, 1)
+	busy := func(tile stdimg.Rectangle) { time.Sleep(10 * time.Millisecond) }
+	start := time.Now()
+	ForEachTileWith(context.Background(), tiles, Options{Workers: 1, Duty: 0.25}, busy)
+	// Every 10ms tile is followed by a 30ms rest
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Working a quarter of the time, 40ms of tiles should take 160ms, not %s", elapsed)
+	}
+}
+
+func TestCPULimitDuty(t *testing.T) {
+	cpus := float64(runtime.NumCPU())
+	if duty := CPULimitDuty(25, runtime.NumCPU()); math.Abs(duty-0.25) > 1e-9 {
+		t.Errorf("As many workers as CPUs should work a quarter of the time to use 25%%, not %v", duty)
+	}
+	if duty := CPULimitDuty(50, 2*runtime.NumCPU()); math.Abs(duty-0.25) > 1e-9 {
+		t.Errorf("Twice as many workers as CPUs should work a quarter of the time to use 50%%, not %v", duty)
+	}
+	if duty := CPULimitDuty(100*2/cpus, 1); duty != 1 {
+		t.Errorf("A worker that may use 2 CPUs shouldn't rest, not work %v of the time", duty)
+	}
+}
+
+func TestSceneMatchesTraceScene(t *testing.T) {
+	s := scene.New()
+	s.Settings.Samples = 2
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	parallel, _ := Scene(context.Background(), s, 70, 40, Options{Workers: 3})
+	if !bytes.Equal(parallel.Pix, s.TraceScene(70, 40).Pix) {
+		t.Error("Tracing the tiles in parallel should give the same render")
+	}
+	// Crop windows cut the tiles across
+	s.Settings.Crop = "0.2,0.1,0.9,0.5"
+	parallel, _ = Scene(context.Background(), s, 70, 40, Options{Workers: 3})
+	if !bytes.Equal(parallel.Pix, s.TraceScene(70, 40).Pix) {
+		t.Error("Tracing the tiles of a crop window in parallel should give the same render")
+	}
+}
+
+func TestCancelledSceneKeepsTheTilesTraced(t *testing.T) {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	ctx, cancel := context.WithCancel(context.Background())
+	traced := 0
+	err := ForEachTileWith(ctx, Tiles(64, 64, 16), Options{Workers: 1}, func(tile stdimg.Rectangle) {
+		if traced++; traced == 3 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || traced >= 16 {
+		t.Errorf("Cancelling should skip the tiles left, %d of 16 were traced and the error is %v", traced, err)
+	}
+
+	render, err := Scene(ctx, s, 64, 64, Options{})
+	if err != context.Canceled || render == nil {
+		t.Errorf("A cancelled render should return what's traced and the error, not %v", err)
+	}
+}
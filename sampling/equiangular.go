@@ -0,0 +1,31 @@
+package sampling
+
+import "math"
+
+// EquiAngular returns a distance along a ray between near and far, with a
+// density proportional to the inverse square of the distance to a point
+// that is height away from the ray, level with it at the distance along.
+// Seen from the point every angle is as likely, so when the point is a
+// light, the distances where it lights the most are sampled the most,
+// which is what single scattering in media needs. It also returns the
+// density of the distance.
+func EquiAngular(along, height, near, far, u float64) (distance, pdf float64) {
+	// Rays through the point would have every sample at it
+	height = math.Max(height, 1e-6)
+	thetaNear, thetaFar := math.Atan((near-along)/height), math.Atan((far-along)/height)
+	offset := height * math.Tan(thetaNear+u*(thetaFar-thetaNear))
+	distance = math.Max(near, math.Min(far, along+offset))
+	return distance, EquiAngularPdf(along, height, near, far, distance)
+}
+
+// EquiAngularPdf returns the density with which EquiAngular returns the
+// distance
+func EquiAngularPdf(along, height, near, far, distance float64) float64 {
+	if distance < near || distance > far {
+		return 0
+	}
+	height = math.Max(height, 1e-6)
+	thetaNear, thetaFar := math.Atan((near-along)/height), math.Atan((far-along)/height)
+	offset := distance - along
+	return height / ((thetaFar - thetaNear) * (height*height + offset*offset))
+}
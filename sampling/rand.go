@@ -0,0 +1,88 @@
+package sampling
+
+// pcgMultiplier is the LCG multiplier of the PCG32 generator
+const pcgMultiplier = 6364136223846793005
+
+// Rand is a PCG32 pseudo random number generator. Unlike math/rand, its
+// output only depends on the seed and stream it was created with, which
+// is what makes renders reproducible.
+type Rand struct {
+	state, inc uint64
+}
+
+// New returns a generator for the stream of the seed. Different streams
+// of the same seed are independent sequences.
+func New(seed, stream uint64) *Rand {
+	r := &Rand{}
+	r.Seed(seed, stream)
+	return r
+}
+
+// Seed starts the generator over as New(seed, stream), so the one memory
+// can serve sample after sample
+func (r *Rand) Seed(seed, stream uint64) {
+	*r = Rand{inc: stream<<1 | 1}
+	r.Uint32()
+	r.state += seed
+	r.Uint32()
+}
+
+// ForPixel returns the generator for the pixel x, y of a render with the
+// given seed. The pixels get the same numbers no matter the order in which
+// they are rendered or the goroutine that renders them.
+func ForPixel(seed uint64, x, y int) *Rand {
+	return ForSample(seed, x, y, -1)
+}
+
+// ForSample returns the generator for the sample of the pixel x, y of a
+// render with the given seed, so samples can be taken in any order too.
+func ForSample(seed uint64, x, y, sample int) *Rand {
+	r := &Rand{}
+	r.SeedSample(seed, x, y, sample)
+	return r
+}
+
+// SeedSample starts the generator over as ForSample(seed, x, y, sample)
+func (r *Rand) SeedSample(seed uint64, x, y, sample int) {
+	stream := mix(mix(mix(uint64(x))^uint64(y)) ^ uint64(sample))
+	r.Seed(mix(seed^stream), stream)
+}
+
+// FrameSeed returns the seed of the frame of an animation rendered with the
+// seed. Every frame gets numbers of its own, so the noise of the frames
+// doesn't stay still on the screen as the animation plays, but always the
+// same ones, so a frame rendered again is the same.
+func FrameSeed(seed uint64, frame int) uint64 {
+	return mix(seed ^ mix(uint64(frame)))
+}
+
+// Uint32 returns a pseudo random 32 bit value
+func (r *Rand) Uint32() uint32 {
+	old := r.state
+	r.state = old*pcgMultiplier + r.inc
+	xorshifted := uint32(((old >> 18) ^ old) >> 27)
+	rot := uint32(old >> 59)
+	return xorshifted>>rot | xorshifted<<((-rot)&31)
+}
+
+// Float64 returns a pseudo random number in [0, 1)
+func (r *Rand) Float64() float64 {
+	bits := uint64(r.Uint32())<<21 ^ uint64(r.Uint32())>>11
+	return float64(bits) / (1 << 53)
+}
+
+// Intn returns a pseudo random number in [0, n)
+func (r *Rand) Intn(n int) int {
+	return int(r.Float64() * float64(n))
+}
+
+// mix is the finalizer of splitmix64. It scatters close inputs, such as
+// neighbouring pixel coordinates, all over the 64 bit range.
+func mix(v uint64) uint64 {
+	v ^= v >> 30
+	v *= 0xbf58476d1ce4e5b9
+	v ^= v >> 27
+	v *= 0x94d049bb133111eb
+	v ^= v >> 31
+	return v
+}
@@ -0,0 +1,41 @@
+package sampling
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStreamsAreReproducible(t *testing.T) {
+	a, b := ForSample(42, 10, 20, 3), ForSample(42, 10, 20, 3)
+	for i := 0; i < 100; i++ {
+		if a.Uint32() != b.Uint32() {
+			t.Fatal("The same seed and stream should give the same numbers")
+		}
+	}
+	c, d := ForSample(42, 10, 20, 3), ForSample(42, 11, 20, 3)
+	same := 0
+	for i := 0; i < 100; i++ {
+		if c.Uint32() == d.Uint32() {
+			same++
+		}
+	}
+	if same > 2 {
+		t.Errorf("Neighbouring pixels should get different numbers, %d of 100 were equal", same)
+	}
+}
+
+func TestFloat64Distribution(t *testing.T) {
+	r := New(1, 0)
+	sum := 0.0
+	n := 100000
+	for i := 0; i < n; i++ {
+		f := r.Float64()
+		if f < 0 || f >= 1 {
+			t.Fatalf("%f is out of [0, 1)", f)
+		}
+		sum += f
+	}
+	if mean := sum / float64(n); math.Abs(mean-0.5) > 0.01 {
+		t.Errorf("The mean should be close to 0.5, not %f", mean)
+	}
+}
@@ -0,0 +1,72 @@
+package sampling
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// The warps turn two uniform random numbers u, v in [0, 1) into
+// directions around the axis, following the distribution they are named
+// after.
+
+// CosineHemisphere returns a direction of the hemisphere around the unit
+// vector axis, with a density proportional to the cosine of its angle
+// with the axis, which is cosine / pi.
+func CosineHemisphere(axis *math3d.Vector3, u, v float64) math3d.Vector3 {
+	r, phi := math.Sqrt(u), 2*math.Pi*v
+	return aroundAxis(axis, math.Sqrt(math.Max(0, 1-u)), r*math.Cos(phi), r*math.Sin(phi))
+}
+
+// UniformCone returns a direction inside the cone around the unit vector
+// axis whose angle with it has the cosine cosMax. Every direction is as
+// likely, with a density of UniformConePdf(cosMax).
+func UniformCone(axis *math3d.Vector3, cosMax, u, v float64) math3d.Vector3 {
+	cosine := 1 - u*(1-cosMax)
+	sine, phi := math.Sqrt(math.Max(0, 1-cosine*cosine)), 2*math.Pi*v
+	return aroundAxis(axis, cosine, sine*math.Cos(phi), sine*math.Sin(phi))
+}
+
+// UniformConePdf returns the density of the directions of UniformCone
+func UniformConePdf(cosMax float64) float64 {
+	return 1 / (2 * math.Pi * (1 - cosMax))
+}
+
+// PowerCosine returns a direction around the unit vector axis with a
+// density proportional to the power of the cosine of its angle with the
+// axis, which is PowerCosinePdf(exponent, cosine).
+func PowerCosine(axis *math3d.Vector3, exponent, u, v float64) math3d.Vector3 {
+	cosine := math.Pow(1-u, 1/(exponent+1))
+	sine, phi := math.Sqrt(math.Max(0, 1-cosine*cosine)), 2*math.Pi*v
+	return aroundAxis(axis, cosine, sine*math.Cos(phi), sine*math.Sin(phi))
+}
+
+// PowerCosinePdf returns the density of the directions of PowerCosine
+// whose angle with the axis has the given cosine
+func PowerCosinePdf(exponent, cosine float64) float64 {
+	if cosine <= 0 {
+		return 0
+	}
+	return (exponent + 1) / (2 * math.Pi) * math.Pow(cosine, exponent)
+}
+
+// PowerHeuristic returns the weight of a sample taken with the strategy
+// of density pdf when it's combined with a strategy of density otherPdf,
+// as in Veach's multiple importance sampling with an exponent of 2.
+func PowerHeuristic(pdf, otherPdf float64) float64 {
+	if math.IsInf(pdf, 1) {
+		return 1
+	}
+	pdf2, other2 := pdf*pdf, otherPdf*otherPdf
+	if pdf2+other2 == 0 {
+		return 0
+	}
+	return pdf2 / (pdf2 + other2)
+}
+
+// aroundAxis returns the direction with the components along the axis
+// and two directions perpendicular to it
+func aroundAxis(axis *math3d.Vector3, along, x, y float64) math3d.Vector3 {
+	tangent, bitangent := math3d.OrthonormalBasis(*axis)
+	return axis.MultiplyV(along).AddV(tangent.MultiplyV(x)).AddV(bitangent.MultiplyV(y))
+}
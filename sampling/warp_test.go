@@ -0,0 +1,78 @@
+package sampling
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestWarpsStayAroundTheAxis(t *testing.T) {
+	axis := math3d.Vector3{X: 1, Y: 2, Z: -2}.NormalizedV()
+	r := New(7, 0)
+	cosMax := math.Cos(0.3)
+	for i := 0; i < 1000; i++ {
+		u, v := r.Float64(), r.Float64()
+		for name, dir := range map[string]math3d.Vector3{
+			"CosineHemisphere": CosineHemisphere(&axis, u, v),
+			"UniformCone":      UniformCone(&axis, cosMax, u, v),
+			"PowerCosine":      PowerCosine(&axis, 20, u, v),
+		} {
+			if math.Abs(dir.Abs()-1) > 1e-9 {
+				t.Fatalf("%s returned a direction of length %f", name, dir.Abs())
+			}
+			if dir.DotV(axis) < 0 {
+				t.Fatalf("%s returned a direction away from the axis", name)
+			}
+		}
+		if cone := UniformCone(&axis, cosMax, u, v); cone.DotV(axis) < cosMax-1e-9 {
+			t.Fatal("UniformCone returned a direction outside of the cone")
+		}
+	}
+}
+
+func TestPowerCosinePdfIntegratesToOne(t *testing.T) {
+	// Integrating over the hemisphere in rings of constant cosine
+	const rings = 100000
+	total := 0.0
+	for i := 0; i < rings; i++ {
+		cosine := (float64(i) + 0.5) / rings
+		total += PowerCosinePdf(20, cosine) * 2 * math.Pi / rings
+	}
+	if math.Abs(total-1) > 1e-3 {
+		t.Errorf("The density should integrate to 1, it integrates to %f", total)
+	}
+}
+
+func TestPowerHeuristic(t *testing.T) {
+	if w := PowerHeuristic(1, 1); w != 0.5 {
+		t.Errorf("Equal densities should weight 0.5, got %f", w)
+	}
+	if w := PowerHeuristic(3, 1) + PowerHeuristic(1, 3); math.Abs(w-1) > 1e-12 {
+		t.Errorf("The weights of both strategies should add up to 1, they add up to %f", w)
+	}
+	if w := PowerHeuristic(2, 0); w != 1 {
+		t.Errorf("A strategy combined with one that can't take the sample should weight 1, got %f", w)
+	}
+}
+
+func TestEquiAngularIsUnbiased(t *testing.T) {
+	// Integrating the inverse square distance to a point off a segment,
+	// which equi-angular sampling does with no variance at all
+	along, height, near, far := 1.5, 0.3, 0.0, 4.0
+	f := func(x float64) float64 { return 1 / (height*height + (x-along)*(x-along)) }
+	exact := (math.Atan((far-along)/height) - math.Atan((near-along)/height)) / height
+	r := New(3, 0)
+	for i := 0; i < 100; i++ {
+		x, pdf := EquiAngular(along, height, near, far, r.Float64())
+		if x < near || x > far {
+			t.Fatalf("The distance %f is outside the segment", x)
+		}
+		if estimate := f(x) / pdf; math.Abs(estimate-exact) > 1e-9*exact {
+			t.Fatalf("The estimate %f should be exactly %f", estimate, exact)
+		}
+	}
+	if EquiAngularPdf(along, height, near, far, far+1) != 0 {
+		t.Error("The distances outside the segment are never sampled")
+	}
+}
@@ -0,0 +1,125 @@
+package scene
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Bake renders the lightmap of the shape at index: a width x height image
+// of its texture space, with v growing upwards, whose texels hold the
+// light arriving at the point of the surface they map to. It's the
+// ambient occlusion with the ao integrator, and otherwise the direct
+// light that a white lambertian surface would reflect there. Every texel
+// averages the samples per pixel of the settings. The texels that the
+// surface doesn't cover take the light of the covered ones up to padding
+// texels away, so filtering the lightmap doesn't blend in their black at
+// the edges of the surface. If the context is done first, the rows left
+// are dilated into like uncovered texels and its error is returned with
+// the lightmap.
+func (s *Scene) Bake(ctx context.Context, index, width, height, padding int) (*image.Image, error) {
+	if index < 0 || index >= len(s.Shapes) {
+		return nil, fmt.Errorf("there is no shape %d", index)
+	}
+	sh := s.Shapes[index]
+	surface, ok := sh.(shape.Parametric)
+	if !ok {
+		return nil, fmt.Errorf("%s has no texture space to bake", shape.NameOf(sh, index))
+	}
+	texels := make([]image.Color, width*height)
+	covered := make([]bool, width*height)
+	var err error
+	for y := 0; y < height; y++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		for x := 0; x < width; x++ {
+			texels[y*width+x], covered[y*width+x] = s.bakeTexel(sh, surface, x, y, width, height)
+		}
+	}
+	dilate(texels, covered, width, height, padding)
+
+	lightmap := image.New(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			lightmap.Set(x, height-1-y, s.Settings.Encode(&texels[y*width+x]))
+		}
+	}
+	return lightmap, err
+}
+
+// bakeTexel returns the light of the texel x, y of a width x height
+// lightmap of the shape, and false if the surface doesn't cover it
+func (s *Scene) bakeTexel(sh shape.Shape, surface shape.Parametric, x, y, width, height int) (image.Color, bool) {
+	light := image.Color{}
+	hits := 0
+	for n := 0; n < s.Settings.Samples; n++ {
+		rng := sampling.ForSample(s.Settings.Seed, x, y, n)
+		du, dv := 0.5, 0.5
+		if s.Settings.Samples > 1 {
+			du, dv = rng.Float64(), rng.Float64()
+		}
+		point, normal, ok := surface.SurfaceAt((float64(x)+du)/float64(width), (float64(y)+dv)/float64(height))
+		if !ok {
+			continue
+		}
+		arriving := s.lightAt(sh, &point, &normal, rng)
+		light = *light.Add(&arriving)
+		hits++
+	}
+	if hits == 0 {
+		return image.Black, false
+	}
+	return *light.Divide(float64(hits)), true
+}
+
+// lightAt returns the light that Bake stores for point on the shape sh
+func (s *Scene) lightAt(sh shape.Shape, point, normal *math3d.Vector3, rng *sampling.Rand) image.Color {
+	if ao, ok := s.integrator().(*AmbientOcclusionIntegrator); ok {
+		return *image.White.Multiply(ao.unoccluded(s, sh, point, normal, 0, rng))
+	}
+	origin := shape.ShadowOrigin(sh, point)
+	radiance := image.Color{}
+	s.forLights(&origin, normal, rng, func(ls lighting.Light, weight float64) {
+		if _, irradiance, ok := s.lightArriving(sh, &origin, normal, ls, 0, rng); ok {
+			radiance = *radiance.Add(irradiance.Multiply(weight / math.Pi))
+		}
+	})
+	return radiance
+}
+
+// dilate grows the covered texels by padding texels: on every pass, the
+// texels next to covered ones take the average of them
+func dilate(texels []image.Color, covered []bool, width, height, padding int) {
+	for pass := 0; pass < padding; pass++ {
+		grown := append([]bool(nil), covered...)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if covered[y*width+x] {
+					continue
+				}
+				sum := image.Color{}
+				count := 0
+				for ny := y - 1; ny <= y+1; ny++ {
+					for nx := x - 1; nx <= x+1; nx++ {
+						if nx >= 0 && nx < width && ny >= 0 && ny < height && covered[ny*width+nx] {
+							sum = *sum.Add(&texels[ny*width+nx])
+							count++
+						}
+					}
+				}
+				if count > 0 {
+					texels[y*width+x] = *sum.Divide(float64(count))
+					grown[y*width+x] = true
+				}
+			}
+		}
+		copy(covered, grown)
+	}
+}
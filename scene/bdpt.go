@@ -0,0 +1,406 @@
+package scene
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// BidirectionalPathTracer is the integrator that follows the light that
+// bounces up to MaxDepth times on its way from the lights to the camera.
+// For every camera ray it traces a path from the camera and another one
+// from a point of an area light, bouncing on the materials they hit, and
+// joins every vertex of one path with every vertex of the other. Paths
+// from the camera find the lights they hit and the ones they sample, and
+// paths from the lights find the light that reaches the camera through
+// caustics and small openings. Every path of light can be built in
+// several of these ways, so their contributions are weighted with
+// multiple importance sampling and the power heuristic, as in Veach's
+// thesis.
+//
+// Paths of light only start on area lights; the light of point lights is
+// only found by sampling them from the camera paths. Paths from the lights
+// aren't joined to the camera itself, which would need a lens with an
+// area. The medium is ignored, subsurface materials reflect the light as
+// lambertian ones with their albedo, and specular materials end the paths
+// that reach them.
+//
+// Past RouletteDepth bounces, the paths are cut short with Russian roulette:
+// they go on with the probability of the fraction of their contribution
+// that's left, and the ones that go on are weighed up to make up for the
+// ones that don't, so the paths that would add little light are mostly
+// not traced and the estimate stays unbiased.
+type BidirectionalPathTracer struct {
+	// RouletteDepth is the bounces that every path makes before Russian
+	// roulette may end it
+	RouletteDepth int
+	// MaxDepth is the most times light bounces on the surfaces
+	MaxDepth int
+	// Clamp is the most luminance that every way of joining the paths
+	// finds of the light that bounces more than once, none if it's 0
+	Clamp float64
+}
+
+// pathVertex is a point of a path traced from the camera or a light
+type pathVertex struct {
+	// point is the point of the surface and origin is where the rays
+	// leaving it start
+	point, origin math3d.Vector3
+	// normal is the normal of the surface at point
+	normal math3d.Vector3
+	// previous is the unit vector towards the previous vertex of the path
+	previous math3d.Vector3
+	// sh is the shape the vertex is on and mat its material, both nil on
+	// lights
+	sh  shape.Shape
+	mat material.Material
+	// light is the light the vertex is on, nil on shapes
+	light lighting.AreaLight
+	// beta is the contribution of the path up to the vertex divided by
+	// its density
+	beta image.Color
+}
+
+// Radiance returns the radiance arriving at the source of lr, joining the
+// paths traced from the camera and the lights in every possible way
+func (b *BidirectionalPathTracer) Radiance(s *Scene, lr *math3d.LightRay, rng *sampling.Rand) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	// The paths are traced in the buffers of the scratch, which keep the
+	// memory they grow to
+	cameraPath := s.randomWalk(sc.cameraPath[:0], *lr, image.White, b.MaxDepth+1, b.RouletteDepth, false, rng)
+	sc.cameraPath = cameraPath
+	if len(cameraPath) == 0 {
+		return image.Black
+	}
+	lightPath := s.lightPath(sc.lightPath[:0], b.MaxDepth, b.RouletteDepth, rng)
+	sc.lightPath = lightPath
+	radiance := image.Color{}
+	for t := 1; t <= len(cameraPath); t++ {
+		z := &cameraPath[t-1]
+		if z.light != nil {
+			// The camera path hit a light
+			// The light bounced on every vertex of the camera path before it
+			emitted := z.light.Emission(&z.normal, &z.previous)
+			sc.joined = joinPaths(sc.joined, nil, cameraPath[:t])
+			weight := s.misWeight(sc.joined, lr.Source, 0)
+			hit := clampIndirect(*emitted.CMultiply(&z.beta).Multiply(weight), t-1, b.Clamp)
+			radiance = *radiance.Add(&hit)
+			continue
+		}
+		if t <= b.MaxDepth {
+			sampled := clampIndirect(s.sampleLights(cameraPath[:t], lr.Source, rng), t, b.Clamp)
+			radiance = *radiance.Add(&sampled)
+		}
+		for lightVertices := 2; lightVertices <= len(lightPath) && lightVertices+t <= b.MaxDepth+1; lightVertices++ {
+			// The first vertex of the light path is on the light
+			joined := s.connect(lightPath[:lightVertices], cameraPath[:t], lr.Source)
+			joined = clampIndirect(joined, t+lightVertices-1, b.Clamp)
+			radiance = *radiance.Add(&joined)
+		}
+	}
+	return radiance
+}
+
+// lightPath appends to path, which is empty, a path with up to the given
+// number of vertices from a point of a light chosen uniformly, and
+// returns it, cut short with Russian roulette past rouletteDepth bounces. It's
+// empty if the light isn't an area light.
+func (s *Scene) lightPath(path []pathVertex, vertices, rouletteDepth int, rng *sampling.Rand) []pathVertex {
+	if vertices == 0 || len(s.Lights) == 0 {
+		return path
+	}
+	area, ok := s.Lights[rng.Intn(len(s.Lights))].(lighting.AreaLight)
+	if !ok {
+		return path
+	}
+	sc := getScratch()
+	defer sc.release()
+	point, sampled := area.SampleSurface(rng.Float64(), rng.Float64())
+	normal := sc.vector(sampled)
+	emitted, pdf := area.SampleEmission(normal, rng.Float64(), rng.Float64())
+	if pdf == 0 {
+		return path
+	}
+	dir := sc.vector(emitted)
+	emission := area.Emission(normal, dir)
+	start := pathVertex{point: point, origin: point, normal: *normal, light: area,
+		beta: *emission.Multiply(float64(len(s.Lights)) * area.Area())}
+	ray := math3d.LightRay{Source: point, Direction: *dir}
+	path = s.randomWalk(append(path, start), ray, *start.beta.Multiply(math.Abs(dir.DotV(*normal)) / pdf), vertices, rouletteDepth, true, rng)
+	if path[len(path)-1].light != nil && len(path) > 1 {
+		// Paths of light don't go on from the lights they hit
+		path = path[:len(path)-1]
+	}
+	return path
+}
+
+// randomWalk appends to path the vertices where the ray and the rays that
+// bounce after it hit, up to the given number of vertices, and returns
+// it. The rays bounce in the directions sampled from the materials, and
+// the walk ends at the first light they hit or at the back of a surface.
+// beta is the contribution of the path up to the ray divided by its
+// density. Past rouletteDepth bounces, the walk goes on with the probability
+// of the fraction of beta that's left, and beta is divided by it.
+// fromLight is true if the path starts on a light, so the light travels
+// along the rays instead of against them.
+func (s *Scene) randomWalk(path []pathVertex, start math3d.LightRay, beta image.Color, vertices, rouletteDepth int, fromLight bool, rng *sampling.Rand) []pathVertex {
+	sc := getScratch()
+	defer sc.release()
+	first, initial := len(path), maxComponent(&beta)
+	ray, hit, point, next := sc.ray(start), sc.hit(shape.Hit{}), sc.vector(math3d.Vector3{}), sc.vector(math3d.Vector3{})
+	for len(path) < vertices {
+		distance, sh := s.getNearestIntersection(ray)
+		lightDistance, ls := s.lightHit(ray)
+		previous := ray.Direction.MultiplyV(-1)
+		if lightDistance < distance {
+			area, ok := ls.(lighting.AreaLight)
+			if !ok {
+				break
+			}
+			*point = ray.Source.AddV(ray.Direction.MultiplyV(lightDistance))
+			return append(path, pathVertex{point: *point, origin: *point, normal: area.NormalAt(point),
+				previous: previous, light: area, beta: beta})
+		}
+		if sh == nil {
+			break
+		}
+		*hit = shape.HitAt(sh, ray, distance)
+		if hit.Backface {
+			break
+		}
+		path = append(path, pathVertex{point: hit.Point, normal: hit.Normal, previous: previous, beta: beta})
+		// The vertex is used where it's kept, so it doesn't escape
+		v := &path[len(path)-1]
+		v.sh, v.origin = sh, shape.ShadowOrigin(sh, &v.point)
+		v.mat = scatteringMaterial(shape.MaterialAtHit(sh, hit, &v.previous))
+
+		var pdf float64
+		*next, pdf = v.mat.SampleDirection(&v.normal, &v.previous, rng.Float64(), rng.Float64())
+		cosine := next.DotV(v.normal)
+		if pdf == 0 || cosine <= 0 {
+			break
+		}
+		brdf := v.mat.BRDF(&v.normal, next, &v.previous)
+		if fromLight {
+			brdf = v.mat.BRDF(&v.normal, &v.previous, next)
+		}
+		beta = *brdf.CMultiply(&beta).Multiply(cosine / pdf)
+		if len(path)-first > rouletteDepth && len(path) < vertices {
+			// The probability only depends on the path so far, so the
+			// weights of the ways of joining the paths still add up to 1
+			survival := math.Min(maxComponent(&beta)/initial, 1)
+			if !(rng.Float64() < survival) {
+				break
+			}
+			beta = *beta.Multiply(1 / survival)
+		}
+		*ray = math3d.LightRay{Source: s.leaving(&v.origin, &v.normal, next), Direction: *next, Origin: sh}
+	}
+	return path
+}
+
+// maxComponent returns the largest of the components of the color
+func maxComponent(c *image.Color) float64 {
+	return math.Max(c.R, math.Max(c.G, c.B))
+}
+
+// sampleLights returns the light that reaches the camera along the camera
+// path, sampling the lights from its last vertex
+func (s *Scene) sampleLights(cameraPath []pathVertex, eye math3d.Vector3, rng *sampling.Rand) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	z := &cameraPath[len(cameraPath)-1]
+	radiance := image.Color{}
+	shadowRay, toLight, point := sc.ray(math3d.LightRay{Source: z.origin, Origin: z.sh}), sc.vector(math3d.Vector3{}), sc.vector(math3d.Vector3{})
+	// The vertex on the light is the only one of its light path
+	sc.lightPath = append(sc.lightPath[:0], pathVertex{})
+	s.forLights(&z.origin, &z.normal, rng, func(ls lighting.Light, weight float64) {
+		sample := ls.Sample(&z.origin, rng.Float64(), rng.Float64())
+		cosine := sample.Direction.DotV(z.normal)
+		if sample.Pdf == 0 || cosine <= 0 {
+			return
+		}
+		shadowRay.Source, shadowRay.Direction = s.leaving(&z.origin, &z.normal, &sample.Direction), sample.Direction
+		if s.inShadow(shadowRay, sample.Distance) {
+			return
+		}
+		*toLight = sample.Direction
+		brdf := z.mat.BRDF(&z.normal, toLight, &z.previous)
+		contribution := sample.Radiance.CMultiply(&brdf).CMultiply(&z.beta).Multiply(cosine * weight / sample.Pdf)
+		if area, ok := ls.(lighting.AreaLight); ok && !sample.Delta {
+			// Other paths can also find the light of area lights
+			*point = z.origin.AddV(sample.Direction.MultiplyV(sample.Distance))
+			sc.lightPath[0] = pathVertex{point: *point, origin: *point, normal: area.NormalAt(point), light: area}
+			sc.joined = joinPaths(sc.joined, sc.lightPath, cameraPath)
+			contribution = contribution.Multiply(s.misWeight(sc.joined, eye, 1))
+		}
+		radiance = *radiance.Add(contribution)
+	})
+	return radiance
+}
+
+// connect returns the light that reaches the camera along the light path
+// and the camera path, joining their last vertices
+func (s *Scene) connect(lightPath, cameraPath []pathVertex, eye math3d.Vector3) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	y, z := &lightPath[len(lightPath)-1], &cameraPath[len(cameraPath)-1]
+	toLight := y.origin.SubtractV(z.origin)
+	distance := toLight.Abs()
+	dir := sc.vector(toLight.DivideV(distance))
+	back := sc.vector(dir.MultiplyV(-1))
+	cosZ, cosY := dir.DotV(z.normal), back.DotV(y.normal)
+	if cosZ <= 0 || cosY <= 0 {
+		return image.Black
+	}
+	ray := sc.ray(math3d.LightRay{Source: z.origin, Direction: *dir, Origin: z.sh})
+	if s.inShadow(ray, distance*(1-1e-4)) {
+		return image.Black
+	}
+	brdfZ, brdfY := z.mat.BRDF(&z.normal, dir, &z.previous), y.mat.BRDF(&y.normal, &y.previous, back)
+	contribution := z.beta.CMultiply(&brdfZ).CMultiply(&brdfY).CMultiply(&y.beta)
+	sc.joined = joinPaths(sc.joined, lightPath, cameraPath)
+	weight := s.misWeight(sc.joined, eye, len(lightPath))
+	return *contribution.Multiply(cosZ * cosY / (distance * distance) * weight)
+}
+
+// misWeight returns the weight of the contribution of the path, sorted
+// from the light to the camera at eye, when its first lightVertices
+// vertices are traced from the light and the rest from the camera. It's
+// the power heuristic over the densities of the path being built in each
+// of the ways the integrator builds paths.
+func (s *Scene) misWeight(path []*pathVertex, eye math3d.Vector3, lightVertices int) float64 {
+	sc := getScratch()
+	defer sc.release()
+	n := len(path)
+	// The densities with respect to area of tracing each vertex from the
+	// camera and from the light. The first vertex from the camera is
+	// traced in every way, so its density doesn't change the weights.
+	sc.fromCamera, sc.fromLight = floats(sc.fromCamera, n), floats(sc.fromLight, n)
+	fromCamera, fromLight := sc.fromCamera, sc.fromLight
+	fromCamera[n-1] = 1
+	for i := n - 2; i >= 0; i-- {
+		next := eye
+		if i+2 < n {
+			next = path[i+2].point
+		}
+		fromCamera[i] = scatterPdf(path[i+1], next, path[i])
+	}
+	fromLight[0] = 1 / (float64(len(s.Lights)) * path[0].light.Area())
+	for i := 1; i < n; i++ {
+		var previous math3d.Vector3
+		if i >= 2 {
+			previous = path[i-2].point
+		}
+		fromLight[i] = scatterPdf(path[i-1], previous, path[i])
+	}
+	sampled := 0.0
+	if n > 1 {
+		sampled = s.sampledPdf(path[0], path[1])
+	}
+
+	density := func(lightVertices int) float64 {
+		pdf := 1.0
+		for i := range path {
+			switch {
+			case i >= lightVertices:
+				pdf *= fromCamera[i]
+			case lightVertices == 1:
+				// The light is sampled instead of traced
+				pdf *= sampled
+			default:
+				pdf *= fromLight[i]
+			}
+		}
+		return pdf
+	}
+	own, sum := density(lightVertices), 0.0
+	for i := range path {
+		d := density(i)
+		sum += d * d
+	}
+	if sum == 0 {
+		return 0
+	}
+	return own * own / sum
+}
+
+// scatterPdf returns the density with respect to area with which a path
+// that arrived at the vertex at from traces the vertex to next
+func scatterPdf(at *pathVertex, from math3d.Vector3, to *pathVertex) float64 {
+	sc := getScratch()
+	defer sc.release()
+	toNext := to.point.SubtractV(at.point)
+	distance2 := toNext.AbsSquared()
+	dir := sc.vector(toNext.DivideV(math.Sqrt(distance2)))
+	var pdf float64
+	if at.mat == nil {
+		pdf = at.light.EmissionPdf(&at.normal, dir)
+	} else {
+		out := sc.vector(from.SubtractV(at.point).NormalizedV())
+		pdf = at.mat.DirectionPdf(&at.normal, dir, out)
+	}
+	return pdf * math.Abs(dir.DotV(to.normal)) / distance2
+}
+
+// sampledPdf returns the density with respect to area with which
+// sampleLights samples the vertex y on a light from the vertex z
+func (s *Scene) sampledPdf(y, z *pathVertex) float64 {
+	sc := getScratch()
+	defer sc.release()
+	toLight := y.point.SubtractV(z.origin)
+	distance2 := toLight.AbsSquared()
+	dir := sc.vector(toLight.DivideV(math.Sqrt(distance2)))
+	pdf := s.lightProbability(&z.origin, &z.normal, y.light) * y.light.Pdf(&z.origin, dir)
+	return pdf * math.Abs(dir.DotV(y.normal)) / distance2
+}
+
+// lightProbability returns the probability with which forLights chooses
+// the light ls to light point
+func (s *Scene) lightProbability(point, normal *math3d.Vector3, ls lighting.Light) float64 {
+	if _, distant := ls.(lighting.Distant); distant || len(s.Lights) <= manyLights {
+		return 1
+	}
+	tree := s.lightSampler()
+	index := 0
+	for _, l := range s.Lights {
+		if _, distant := l.(lighting.Distant); distant {
+			continue
+		}
+		if l == ls {
+			return tree.Probability(point, normal, index)
+		}
+		index++
+	}
+	return 0
+}
+
+// joinPaths returns the vertices of the light path followed by the ones
+// of the camera path in reverse, which is the path from the light to the
+// camera, in the memory of buffer
+func joinPaths(buffer []*pathVertex, lightPath, cameraPath []pathVertex) []*pathVertex {
+	path := buffer[:0]
+	for i := range lightPath {
+		path = append(path, &lightPath[i])
+	}
+	for i := len(cameraPath) - 1; i >= 0; i-- {
+		path = append(path, &cameraPath[i])
+	}
+	return path
+}
+
+// scatteringMaterial returns the material that paths bounce on for mat.
+// Paths don't go below the surface of subsurface materials, which reflect
+// the light as lambertian ones instead.
+func scatteringMaterial(mat material.Material) material.Material {
+	if subsurface, ok := mat.(*material.Subsurface); ok {
+		return &material.Lambertian{Albedo: subsurface.Albedo}
+	}
+	return mat
+}
@@ -0,0 +1,160 @@
+package scene
+
+import (
+	"fmt"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Builder puts a scene together and compiles it, once, into one ready to
+// render. Its methods return the builder, so the calls chain, and the
+// first of them that fails makes Build fail with its error, so that the
+// scene is never half built:
+//
+//	s, err := scene.NewBuilder(scene.WithSamples(16)).
+//		SetCamera(cam).
+//		AddMesh(triangles).
+//		AddLight(light).
+//		Build()
+//
+// Scenes edited after they're built work as well, rebuilding what the
+// edits change when they're next traced, as the scenes created with New
+// do.
+type Builder struct {
+	scene *Scene
+	// err is the first error of the calls, which Build returns
+	err error
+	// built is whether Build was called, after which the builder can't be
+	// used
+	built bool
+}
+
+// NewBuilder returns a builder of an empty scene with the default pinhole
+// camera and the default settings changed by the options
+func NewBuilder(options ...Option) *Builder {
+	b := &Builder{scene: New()}
+	for _, option := range options {
+		if err := option(b.scene); err != nil {
+			b.fail(err)
+		}
+	}
+	return b
+}
+
+// fail records the error if it's the first one
+func (b *Builder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// usable returns whether the builder can still be changed, recording an
+// error if it was already built
+func (b *Builder) usable() bool {
+	if b.built {
+		b.fail(fmt.Errorf("the scene was already built"))
+	}
+	return b.err == nil
+}
+
+// SetCamera makes the camera the one of the scene
+func (b *Builder) SetCamera(c camera.PinHole) *Builder {
+	if b.usable() {
+		b.scene.Camera = c
+	}
+	return b
+}
+
+// AddShape adds the shapes to the scene
+func (b *Builder) AddShape(shapes ...shape.Shape) *Builder {
+	if b.usable() {
+		for _, sh := range shapes {
+			if sh == nil {
+				b.fail(fmt.Errorf("shapes[%d]: the shape is nil", len(b.scene.Shapes)))
+				break
+			}
+			b.scene.AddShape(sh)
+		}
+	}
+	return b
+}
+
+// AddMesh adds the triangles of a mesh, such as the ones LoadOBJ reads,
+// to the scene
+func (b *Builder) AddMesh(triangles []*shape.Triangle) *Builder {
+	if b.usable() {
+		for _, t := range triangles {
+			if t == nil {
+				b.fail(fmt.Errorf("shapes[%d]: the triangle is nil", len(b.scene.Shapes)))
+				break
+			}
+			b.scene.AddShape(t)
+		}
+	}
+	return b
+}
+
+// AddLight adds the lights to the scene
+func (b *Builder) AddLight(lights ...lighting.Light) *Builder {
+	if b.usable() {
+		for _, l := range lights {
+			b.scene.AddLight(l)
+		}
+	}
+	return b
+}
+
+// Instance adds a node of the scene graph named name, at the top of the
+// graph, that places the shapes by the transform, as AddNode does. The
+// shapes are transformed, not copied, so every instance of a mesh needs
+// shapes of its own, such as the ones its file is loaded to again.
+func (b *Builder) Instance(name string, transform math3d.Matrix, shapes ...shape.Shape) *Builder {
+	for _, sh := range shapes {
+		if sh == nil {
+			b.fail(fmt.Errorf("the instance %s: a shape is nil", name))
+		}
+	}
+	if b.usable() {
+		if _, ok := transform.Inverse(); !ok || !transform.Affine() {
+			b.fail(fmt.Errorf("the transform of the instance %s must be affine and invertible", name))
+		} else if err := b.scene.AddNode(nil, NewNode(name, transform), shapes...); err != nil {
+			b.fail(fmt.Errorf("the instance %s: %v", name, err))
+		}
+	}
+	return b
+}
+
+// Build validates the camera, the settings, the shapes and the lights of
+// the scene, which can't be nil, and then builds its acceleration
+// structure, its light tree and its caustics, as Prepare does, so the scene
+// it returns renders without building anything first. It returns the first
+// error of the calls to the builder instead if one failed, and the builder
+// can't be used after it.
+func (b *Builder) Build() (*Scene, error) {
+	if !b.usable() {
+		return nil, b.err
+	}
+	b.built = true
+	s := b.scene
+	if err := s.Camera.Validate(); err != nil {
+		return nil, fmt.Errorf("camera: %v", err)
+	}
+	if err := s.Settings.Validate(); err != nil {
+		return nil, fmt.Errorf("render: %v", err)
+	}
+	for i, sh := range s.Shapes {
+		if problem := invalidShape(sh); problem != "" {
+			return nil, fmt.Errorf("shapes[%d]: %s", i, problem)
+		}
+	}
+	for i, l := range s.Lights {
+		if l == nil {
+			return nil, fmt.Errorf("lights[%d]: the light is nil", i)
+		}
+	}
+	s.Prepare()
+	return s, nil
+}
@@ -0,0 +1,69 @@
+package scene
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestBuilderBuildsASceneReadyToRender(t *testing.T) {
+	light := &lighting.PointLight{Position: math3d.Vector3{Y: 2}, Intensity: image.White}
+	triangle := &shape.Triangle{Vertices: [3]math3d.Vector3{{X: -1, Y: -1, Z: 3}, {X: 1, Y: -1, Z: 3}, {Y: 1, Z: 3}}}
+	s, err := NewBuilder(WithSamples(2)).
+		SetCamera(camera.DefaultPinHole()).
+		AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 2}, Radius: 0.5}).
+		AddMesh([]*shape.Triangle{triangle}).
+		AddLight(light).
+		Instance("moved", math3d.TranslationMatrix(math3d.Vector3{X: 1}), &shape.Sphere{Position: math3d.Vector3{Z: 2}, Radius: 0.2}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.structure == nil || s.structure.Size() != 3 {
+		t.Error("Building the scene should build its acceleration structure")
+	}
+	if n, ok := s.Node("moved"); !ok || n.Shapes()[0].(*shape.Sphere).Position.X != 1 {
+		t.Error("The instance should place its shapes")
+	}
+
+	want := New()
+	want.Settings.Samples = 2
+	want.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 2}, Radius: 0.5})
+	want.AddShape(triangle)
+	want.AddLight(light)
+	want.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 1, Z: 2}, Radius: 0.2})
+	if !bytes.Equal(s.TraceScene(16, 16).Pix, want.TraceScene(16, 16).Pix) {
+		t.Error("The built scene should render like the one put together by hand")
+	}
+}
+
+func TestBuilderFailsWithTheFirstError(t *testing.T) {
+	broken := camera.DefaultPinHole()
+	broken.FoV = 0
+	for name, b := range map[string]*Builder{
+		"invalid option": NewBuilder(WithSamples(0)),
+		"invalid camera": NewBuilder().SetCamera(broken),
+		"invalid shape":  NewBuilder().AddShape(&shape.Sphere{Radius: -1}),
+		"nil shape":      NewBuilder().AddShape(nil),
+		"nil light":      NewBuilder().AddLight(nil),
+		"scaled sphere":  NewBuilder().Instance("big", math3d.ScaleMatrix(math3d.Vector3{X: 2, Y: 1, Z: 1}), &shape.Sphere{Radius: 1}),
+		"singular":       NewBuilder().Instance("flat", math3d.Matrix{}, &shape.Sphere{Radius: 1}),
+	} {
+		if _, err := b.AddLight(&lighting.PointLight{Intensity: image.White}).Build(); err == nil {
+			t.Errorf("%s: building the scene should fail", name)
+		}
+	}
+
+	b := NewBuilder()
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.AddShape(&shape.Sphere{Radius: 1}).Build(); err == nil {
+		t.Error("A builder shouldn't be used once it built its scene")
+	}
+}
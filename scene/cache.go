@@ -0,0 +1,96 @@
+package scene
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/cbor"
+)
+
+// CacheVersion is the version of the format of the scene caches that
+// MarshalCache returns. The caches of other versions fail to parse and
+// must be saved again.
+const CacheVersion = 1
+
+// cacheMagic starts the header of the scene caches. Its first byte isn't
+// the head of a CBOR map, so the caches saved before they had a header,
+// which are the CBOR of the scene alone, are told apart from them.
+const cacheMagic = "GTSC"
+
+// cacheHeaderSize is the number of bytes of the header of a scene cache:
+// the magic, the version as a little endian 32 bit integer, and the
+// lengths of the scene and of the BVH as little endian 64 bit integers
+const cacheHeaderSize = len(cacheMagic) + 4 + 8 + 8
+
+// MarshalCache returns the scene as a scene cache, which ParseSceneFile
+// parses once it's saved to a file. It holds a header with its version,
+// the scene as MarshalCBOR returns it, and the BVH over its shapes as
+// accel.BVH.MarshalBinary encodes it, if the settings use one, so that
+// renders of the cache load the BVH instead of building it again. The
+// BVH is built over the shapes of the scene the cache parses to, which
+// may be in a different order than the ones of s.
+func (s *Scene) MarshalCache() ([]byte, error) {
+	encoded, err := s.MarshalCBOR()
+	if err != nil {
+		return nil, err
+	}
+	var bvh []byte
+	if s.Settings.accelerator() == BVH {
+		cached, _, err := ParseSceneCBOR(encoded, Lenient)
+		if err != nil {
+			return nil, err
+		}
+		if bvh, err = cached.accelerator().(*accel.BVH).MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	data := make([]byte, cacheHeaderSize, cacheHeaderSize+len(encoded)+len(bvh))
+	copy(data, cacheMagic)
+	binary.LittleEndian.PutUint32(data[len(cacheMagic):], CacheVersion)
+	binary.LittleEndian.PutUint64(data[len(cacheMagic)+4:], uint64(len(encoded)))
+	binary.LittleEndian.PutUint64(data[len(cacheMagic)+12:], uint64(len(bvh)))
+	data = append(data, encoded...)
+	return append(data, bvh...), nil
+}
+
+// isCacheHeader returns whether the bytes start with the magic of the
+// header of a scene cache
+func isCacheHeader(start []byte) bool {
+	return len(start) >= len(cacheMagic) && string(start[:len(cacheMagic)]) == cacheMagic
+}
+
+// ParseSceneCache parses a scene cache, as MarshalCache returns them, like
+// ParseSceneCBOR parses the scene alone
+func ParseSceneCache(data []byte, mode ParseMode) (*Scene, []string, error) {
+	return parseSceneCache(data, mode, cbor.Unmarshal)
+}
+
+// parseSceneCache parses the scene cache in data, decoding its scene with
+// unmarshal, and loads its BVH, if it has one and the settings use it
+func parseSceneCache(data []byte, mode ParseMode, unmarshal func([]byte) (interface{}, error)) (*Scene, []string, error) {
+	if len(data) < cacheHeaderSize || !isCacheHeader(data) {
+		return nil, nil, fmt.Errorf("the scene cache has no header")
+	}
+	if version := binary.LittleEndian.Uint32(data[len(cacheMagic):]); version != CacheVersion {
+		return nil, nil, fmt.Errorf("the scene cache is of version %d, not %d, and must be saved again", version, CacheVersion)
+	}
+	sceneLength := binary.LittleEndian.Uint64(data[len(cacheMagic)+4:])
+	bvhLength := binary.LittleEndian.Uint64(data[len(cacheMagic)+12:])
+	data = data[cacheHeaderSize:]
+	if sceneLength > uint64(len(data)) || bvhLength != uint64(len(data))-sceneLength {
+		return nil, nil, fmt.Errorf("the scene cache is truncated")
+	}
+	s, warnings, err := parseSceneCBOR(data[:sceneLength], mode, unmarshal)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bvhLength > 0 && s.Settings.accelerator() == BVH {
+		bvh, err := accel.LoadBVH(s.primitives(), data[sceneLength:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("the BVH of the scene cache: %v", err)
+		}
+		s.useStructure(bvh)
+	}
+	return s, warnings, nil
+}
@@ -0,0 +1,131 @@
+package scene
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/photon"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// photonStream is the stream of random numbers of the photons, apart from
+// the ones of the pixels
+const photonStream = 0x70686f746f6e
+
+// causticNeighbours is the number of photons that light a point
+const causticNeighbours = 50
+
+// causticMap returns the photon map of the caustics, tracing the photons
+// if the scene changed since they were last traced. It's nil if the
+// settings don't ask for caustics or the integrator finds them itself.
+func (s *Scene) causticMap() *photon.Map {
+	if !s.rendersCaustics() {
+		return nil
+	}
+	if s.caustics == nil {
+		s.caustics = photon.NewMap(s.tracePhotons(s.Settings.Photons))
+	}
+	return s.caustics
+}
+
+// rendersCaustics returns true if the settings ask for the caustics to be
+// rendered with photons
+func (s *Scene) rendersCaustics() bool {
+	return s.Settings.Photons > 0 && s.Settings.Integrator != Bidirectional
+}
+
+// tracePhotons traces photons from points of the lights chosen uniformly
+// and returns the caustic ones: those that bounced on glossy or specular
+// surfaces, up to MaxDepth times, before arriving at a lambertian one.
+// Only area lights emit photons, as the light of point lights doesn't
+// fall off with the distance like photons do.
+func (s *Scene) tracePhotons(count int) []photon.Photon {
+	var photons []photon.Photon
+	rng := sampling.New(s.Settings.Seed, photonStream)
+	for i := 0; i < count && len(s.Lights) > 0; i++ {
+		area, ok := s.Lights[rng.Intn(len(s.Lights))].(lighting.AreaLight)
+		if !ok {
+			continue
+		}
+		point, normal := area.SampleSurface(rng.Float64(), rng.Float64())
+		dir, pdf := area.SampleEmission(&normal, rng.Float64(), rng.Float64())
+		if pdf == 0 {
+			continue
+		}
+		emission := area.Emission(&normal, &dir)
+		// The flux of the light divided by the density of the photon
+		power := *emission.Multiply(math.Abs(dir.DotV(normal)) / pdf * area.Area() * float64(len(s.Lights)) / float64(count))
+		ray := math3d.LightRay{Source: point, Direction: dir}
+		if s.Settings.Wavelengths > 0 {
+			// Every photon carries a single wavelength, which the dispersive
+			// materials it goes through bend alone
+			ray.Wavelength = image.MinWavelength + (image.MaxWavelength-image.MinWavelength)*rng.Float64()
+			ray.Dispersed = true
+			sensor := image.WavelengthColor(ray.Wavelength)
+			power = *power.CMultiply(&sensor)
+		}
+		for bounce := 0; ; bounce++ {
+			distance, sh := s.getNearestIntersection(&ray)
+			if sh == nil {
+				break
+			}
+			if lightDistance, _ := s.lightHit(&ray); lightDistance < distance {
+				break
+			}
+			hit := shape.HitAt(sh, &ray, distance)
+			previous := ray.Direction.MultiplyV(-1)
+			mat := shape.MaterialAt(sh, &hit.Point, &hit.Normal, &previous)
+			if specular, ok := mat.(material.Specular); ok {
+				if bounce == s.Settings.MaxDepth {
+					break
+				}
+				var differential math3d.Differential
+				weight := s.scatterSpecular(specular, &hit, &ray, rng, &ray, &differential)
+				power = *power.CMultiply(&weight)
+				continue
+			}
+			if hit.Backface {
+				break
+			}
+			if _, glossy := mat.(*material.Glossy); !glossy {
+				if _, lambertian := mat.(*material.Lambertian); lambertian && bounce > 0 {
+					photons = append(photons, photon.Photon{Position: hit.Point, Direction: previous, Power: power})
+				}
+				break
+			}
+			if bounce == s.Settings.MaxDepth {
+				break
+			}
+			next, pdf := mat.SampleDirection(&hit.Normal, &previous, rng.Float64(), rng.Float64())
+			cosine := next.DotV(hit.Normal)
+			if pdf == 0 || cosine <= 0 {
+				break
+			}
+			brdf := mat.BRDF(&hit.Normal, &previous, &next)
+			power = *brdf.CMultiply(&power).Multiply(cosine / pdf)
+			ray = math3d.LightRay{Source: shape.ShadowOrigin(sh, &hit.Point), Direction: next, Origin: sh, Wavelength: ray.Wavelength, Dispersed: ray.Dispersed}
+		}
+	}
+	return photons
+}
+
+// causticRadiance returns the radiance of the caustics that the lambertian
+// material at point reflects towards out, estimated from the density of
+// the photons around point
+func (s *Scene) causticRadiance(caustics *photon.Map, point, normal, out *math3d.Vector3, mat material.Material) image.Color {
+	photons, radius := caustics.Nearest(point, causticNeighbours, s.Settings.PhotonRadius)
+	flux := image.Color{}
+	for _, p := range photons {
+		if p.Direction.DotV(*normal) <= 0 {
+			// It lit the other side of a thin surface
+			continue
+		}
+		brdf := mat.BRDF(normal, &p.Direction, out)
+		flux = *flux.Add(brdf.CMultiply(&p.Power))
+	}
+	return *flux.Divide(math.Pi * radius * radius)
+}
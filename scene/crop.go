@@ -0,0 +1,56 @@
+package scene
+
+import (
+	"errors"
+	stdimg "image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CropWindow returns the pixels of a width x height render that the crop
+// window of the settings holds, which are the only ones traced, or the
+// whole render if there's no crop window
+func (s *Settings) CropWindow(width, height int) stdimg.Rectangle {
+	bounds := stdimg.Rect(0, 0, width, height)
+	if s.Crop == "" {
+		return bounds
+	}
+	window, err := parseCrop(s.Crop)
+	if err != nil {
+		return bounds
+	}
+	if window[0] <= 1 && window[1] <= 1 && window[2] <= 1 && window[3] <= 1 {
+		// The pixels the fractions of the render start and end in
+		w, h := float64(width), float64(height)
+		return stdimg.Rect(int(math.Floor(window[0]*w)), int(math.Floor(window[1]*h)),
+			int(math.Ceil(window[2]*w)), int(math.Ceil(window[3]*h))).Intersect(bounds)
+	}
+	return stdimg.Rect(int(window[0]), int(window[1]), int(window[2]), int(window[3])).Intersect(bounds)
+}
+
+// parseCrop returns the left, top, right and bottom of a crop window
+func parseCrop(crop string) ([4]float64, error) {
+	var window [4]float64
+	fields := strings.Split(crop, ",")
+	if len(fields) != 4 {
+		return window, errors.New("the crop window must be left,top,right,bottom")
+	}
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || !(v >= 0) || math.IsInf(v, 0) {
+			return window, errors.New("the crop window must be 4 finite non negative numbers")
+		}
+		window[i] = v
+	}
+	if window[0] >= window[2] || window[1] >= window[3] {
+		return window, errors.New("the crop window must be left of its right and above its bottom")
+	}
+	fractions := window[0] <= 1 && window[1] <= 1 && window[2] <= 1 && window[3] <= 1
+	for _, v := range window {
+		if !fractions && v != math.Trunc(v) {
+			return window, errors.New("the crop window must be fractions of at most 1 or whole pixels")
+		}
+	}
+	return window, nil
+}
@@ -0,0 +1,65 @@
+package scene
+
+import (
+	stdimg "image"
+	stdcol "image/color"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestCropWindow(t *testing.T) {
+	cases := []struct {
+		crop string
+		want stdimg.Rectangle
+	}{
+		{"", stdimg.Rect(0, 0, 200, 100)},
+		{"10,20,110,60", stdimg.Rect(10, 20, 110, 60)},
+		// Fractions take the pixels they start and end in
+		{"0.25, 0.5, 0.5, 1", stdimg.Rect(50, 50, 100, 100)},
+		{"0.001,0,0.999,0.5", stdimg.Rect(0, 0, 200, 50)},
+		// Pixels outside the render are left out
+		{"150,50,400,300", stdimg.Rect(150, 50, 200, 100)},
+	}
+	for _, c := range cases {
+		settings := DefaultSettings()
+		settings.Crop = c.crop
+		if err := settings.Validate(); err != nil {
+			t.Errorf("The crop window %q should be valid: %v", c.crop, err)
+		}
+		if got := settings.CropWindow(200, 100); got != c.want {
+			t.Errorf("The crop window %q should be %v, not %v", c.crop, c.want, got)
+		}
+	}
+	for _, crop := range []string{"10,20,110", "a,b,c,d", "10,20,5,60", "0.5,0,0.5,1", "-1,0,10,10", "0.5,0,10.5,10"} {
+		settings := DefaultSettings()
+		settings.Crop = crop
+		if settings.Validate() == nil {
+			t.Errorf("The crop window %q should be invalid", crop)
+		}
+	}
+}
+
+func TestCroppedRenderTracesOnlyTheWindow(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	whole := s.TraceScene(32, 32)
+	s.Settings.Crop = "8,4,20,30"
+	cropped := s.TraceScene(32, 32)
+	window := stdimg.Rect(8, 4, 20, 30)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			want := stdcol.NRGBA{}
+			if (stdimg.Point{X: x, Y: y}).In(window) {
+				want = whole.NRGBAAt(x, y)
+			}
+			if got := cropped.NRGBAAt(x, y); got != want {
+				t.Fatalf("The pixel %d, %d of the cropped render should be %v, not %v", x, y, want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package scene
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/exr"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// CryptomatteRanks is how many of the IDs seen in a pixel cryptomatte
+// mattes keep, the ones that cover the most of it
+const CryptomatteRanks = 6
+
+// cryptomatteSamples is the fewest samples per pixel that mattes are traced
+// with, so that the edges of the objects are antialiased even in renders
+// of a single sample
+const cryptomatteSamples = 16
+
+// Matte is a layer of IDs in the cryptomatte convention, such as the one
+// of the objects or the one of the materials. Compositors pick IDs from it
+// to mask the render with how much of every pixel they cover.
+type Matte struct {
+	// Name is the name of the layer, which names its channels
+	Name          string
+	Width, Height int
+	// Names are the names of the IDs seen in the layer
+	Names []string
+	// Ranks holds the IDs seen in every pixel, row after row, from the one
+	// that covers the most of it down to CryptomatteRanks of them
+	Ranks [][]Coverage
+}
+
+// Coverage is an ID of a matte and the fraction of a pixel it covers
+type Coverage struct {
+	ID       float32
+	Coverage float64
+}
+
+// TraceCryptomatte traces the object and the material mattes of a render
+// of width x height: CryptoObject with the names of the shapes and
+// CryptoMaterial with their materials. The background isn't in them, so
+// the coverage of the pixels where it shows adds up to less than 1.
+func (s *Scene) TraceCryptomatte(width, height int) []Matte {
+	s.Prepare()
+	objects := make(map[shape.Shape]string, len(s.Shapes))
+	materials := make(map[shape.Shape]string, len(s.Shapes))
+	for i, sh := range s.Shapes {
+		objects[sh] = shape.NameOf(sh, i)
+		m, _ := json.Marshal(shape.MaterialOf(sh).AsMap())
+		materials[sh] = string(m)
+	}
+	mattes := []Matte{
+		{Name: "CryptoObject", Names: uniqueNames(objects)},
+		{Name: "CryptoMaterial", Names: uniqueNames(materials)},
+	}
+	for i := range mattes {
+		mattes[i].Width, mattes[i].Height = width, height
+		mattes[i].Ranks = make([][]Coverage, width*height)
+	}
+	samples := s.Settings.Samples
+	if samples < cryptomatteSamples {
+		samples = cryptomatteSamples
+	}
+	targetIt := s.Camera.GetIterator(width, height)
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range rows {
+				for x := 0; x < width; x++ {
+					seen := make(map[shape.Shape]int)
+					for n := 0; n < samples; n++ {
+						rng := sampling.ForSample(s.Settings.Seed, x, y, n)
+						lr := targetIt.Ray(x, y, rng.Float64(), rng.Float64())
+						if s.Settings.Shutter > 0 {
+							lr.Time = s.Settings.Shutter * rng.Float64()
+						}
+						if _, sh, index := s.nearestShape(&lr); sh != nil {
+							seen[s.Shapes[index]]++
+						}
+					}
+					mattes[0].Ranks[y*width+x] = ranked(seen, objects, samples)
+					mattes[1].Ranks[y*width+x] = ranked(seen, materials, samples)
+				}
+			}
+		}()
+	}
+	for y := 0; y < height; y++ {
+		rows <- y
+	}
+	close(rows)
+	wg.Wait()
+	return mattes
+}
+
+// uniqueNames returns the names of the shapes, sorted and without repeats
+func uniqueNames(names map[shape.Shape]string) []string {
+	unique := make(map[string]bool)
+	for _, name := range names {
+		unique[name] = true
+	}
+	sorted := make([]string, 0, len(unique))
+	for name := range unique {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// ranked returns the coverage of the names of the shapes seen in the
+// samples of a pixel, by how many samples saw every shape, keeping the
+// CryptomatteRanks that cover the most
+func ranked(seen map[shape.Shape]int, names map[shape.Shape]string, samples int) []Coverage {
+	counts := make(map[string]int)
+	for sh, n := range seen {
+		counts[names[sh]] += n
+	}
+	ranks := make([]Coverage, 0, len(counts))
+	for name, n := range counts {
+		ranks = append(ranks, Coverage{ID: image.CryptomatteID(name), Coverage: float64(n) / float64(samples)})
+	}
+	// Ties are broken by the ID so that the ranks don't depend on the order
+	// of the map
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].Coverage != ranks[j].Coverage {
+			return ranks[i].Coverage > ranks[j].Coverage
+		}
+		return ranks[i].ID < ranks[j].ID
+	})
+	if len(ranks) > CryptomatteRanks {
+		ranks = ranks[:CryptomatteRanks]
+	}
+	return ranks
+}
+
+// WriteCryptomatte writes the mattes to w as an OpenEXR image in the
+// cryptomatte convention, which the cryptomatte plugins of Nuke and Natron
+// read. Every layer stores two ranks of IDs and coverages in the RGBA
+// channels of Name00, Name01 and so on, and the header holds the manifest
+// of the names of its IDs. The rows are in the order of the renders.
+func WriteCryptomatte(w io.Writer, mattes []Matte) error {
+	if len(mattes) == 0 {
+		return fmt.Errorf("there are no mattes to write")
+	}
+	width, height := mattes[0].Width, mattes[0].Height
+	var channels []exr.Channel
+	attributes := make(map[string]string)
+	for _, m := range mattes {
+		if m.Width != width || m.Height != height {
+			return fmt.Errorf("the mattes must have the same size")
+		}
+		for layer := 0; layer < (CryptomatteRanks+1)/2; layer++ {
+			rgba := make([][]float32, 4)
+			for c := range rgba {
+				rgba[c] = make([]float32, width*height)
+			}
+			for p, ranks := range m.Ranks {
+				for r := 0; r < 2 && 2*layer+r < len(ranks); r++ {
+					rank := ranks[2*layer+r]
+					rgba[2*r][p], rgba[2*r+1][p] = rank.ID, float32(rank.Coverage)
+				}
+			}
+			for c, values := range rgba {
+				channels = append(channels, exr.Channel{Name: fmt.Sprintf("%s%02d.%c", m.Name, layer, "RGBA"[c]), Values: values})
+			}
+		}
+		manifest := make(map[string]string, len(m.Names))
+		for _, name := range m.Names {
+			manifest[name] = fmt.Sprintf("%08x", image.CryptomatteHash(name))
+		}
+		encoded, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		// The metadata of a layer is keyed by the start of the hash of its name
+		key := fmt.Sprintf("cryptomatte/%07x/", image.CryptomatteHash(m.Name)>>4)
+		attributes[key+"name"] = m.Name
+		attributes[key+"hash"] = "MurmurHash3_32"
+		attributes[key+"conversion"] = "uint32_to_float32"
+		attributes[key+"manifest"] = string(encoded)
+	}
+	return exr.Encode(w, width, height, channels, attributes)
+}
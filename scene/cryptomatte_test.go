@@ -0,0 +1,81 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// quad returns the two triangles of the square from x0 to x1 across the
+// view of the default camera, both with the name
+func quad(name string, x0, x1 float64) []*shape.Triangle {
+	a, b := math3d.Vector3{X: x0, Y: -10, Z: 1}, math3d.Vector3{X: x1, Y: -10, Z: 1}
+	c, d := math3d.Vector3{X: x1, Y: 10, Z: 1}, math3d.Vector3{X: x0, Y: 10, Z: 1}
+	return []*shape.Triangle{
+		{Vertices: [3]math3d.Vector3{a, c, b}, Name: name},
+		{Vertices: [3]math3d.Vector3{a, d, c}, Name: name},
+	}
+}
+
+func TestCryptomatteCoverage(t *testing.T) {
+	s := New()
+	for _, tri := range append(quad("left", -10, 0), quad("right", 0, 10)...) {
+		s.AddShape(tri)
+	}
+	// The middle column of 9 is split in half by the edge between the quads,
+	// and the middle row starts at 9
+	mattes := s.TraceCryptomatte(9, 3)
+	if len(mattes) != 2 || mattes[0].Name != "CryptoObject" || mattes[1].Name != "CryptoMaterial" {
+		t.Fatalf("There should be an object and a material matte, there are %d", len(mattes))
+	}
+	objects := mattes[0]
+	if len(objects.Names) != 2 || objects.Names[0] != "left" || objects.Names[1] != "right" {
+		t.Errorf("The triangles of a quad should share its name, the names are %v", objects.Names)
+	}
+	side, other := objects.Ranks[9], objects.Ranks[9+8]
+	if len(side) != 1 || side[0].Coverage != 1 || len(other) != 1 || other[0].Coverage != 1 || side[0].ID == other[0].ID {
+		t.Errorf("The sides should be covered by one quad each, they're %v and %v", side, other)
+	}
+	middle := objects.Ranks[9+4]
+	if len(middle) != 2 || math.Abs(middle[0].Coverage+middle[1].Coverage-1) > 1e-9 || middle[1].Coverage < 0.2 {
+		t.Errorf("Both quads should cover part of the middle, they cover %v", middle)
+	}
+	if middle[0].Coverage < middle[1].Coverage {
+		t.Error("The ranks should go from the most coverage to the least")
+	}
+	if materials := mattes[1].Ranks[9+4]; len(materials) != 1 || materials[0].Coverage != 1 {
+		t.Errorf("Both quads have the default material, which should cover the middle, not %v", materials)
+	}
+
+	s.Shapes = s.Shapes[:2]
+	if right := s.TraceCryptomatte(9, 3)[0].Ranks[9+8]; len(right) != 0 {
+		t.Errorf("The background shouldn't be in the mattes, it's %v", right)
+	}
+}
+
+func TestWriteCryptomatte(t *testing.T) {
+	id := image.CryptomatteID("left")
+	matte := Matte{Name: "CryptoObject", Width: 2, Height: 1, Names: []string{"left"},
+		Ranks: [][]Coverage{{{ID: id, Coverage: 1}}, nil}}
+	var buf bytes.Buffer
+	if err := WriteCryptomatte(&buf, []Matte{matte}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := json.Marshal(map[string]string{"left": fmt.Sprintf("%08x", image.CryptomatteHash("left"))})
+	key := fmt.Sprintf("cryptomatte/%07x/", image.CryptomatteHash("CryptoObject")>>4)
+	for _, attribute := range []string{key + "name\x00string", key + "manifest\x00string", string(manifest), "CryptoObject02.A"} {
+		if !bytes.Contains(buf.Bytes(), []byte(attribute)) {
+			t.Errorf("The header should have %q", attribute)
+		}
+	}
+	matte.Width = 3
+	if err := WriteCryptomatte(&buf, []Matte{matte, {Name: "CryptoMaterial", Width: 2, Height: 1}}); err == nil {
+		t.Error("Mattes of different sizes shouldn't be written together")
+	}
+}
@@ -0,0 +1,200 @@
+package scene
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+// luxPerUnit is the illuminance in lux of an irradiance of 1, as the sky
+// and the sun give their radiances in thousands of cd/m²
+const luxPerUnit = 1000
+
+// SensorGrid is a grid of illuminance sensors over a plane, such as the
+// work plane of a room in a daylighting study. The sensors are at the
+// centers of the cells of the grid and face the same way.
+type SensorGrid struct {
+	// Corner is a corner of the grid, and Across and Along are its edges
+	// from there
+	Corner math3d.Vector3 `json:"corner"`
+	Across math3d.Vector3 `json:"across"`
+	Along  math3d.Vector3 `json:"along"`
+	// Columns are the cells across the grid and Rows the ones along it
+	Columns int `json:"columns"`
+	Rows    int `json:"rows"`
+	// Normal is the direction the sensors face. They face up, along Y as
+	// the sky does, if it's zero.
+	Normal math3d.Vector3 `json:"normal,omitempty"`
+	// Samples is how many directions every sensor gathers light from
+	Samples int `json:"samples"`
+	// Seed is the seed of the directions
+	Seed uint64 `json:"seed,omitempty"`
+}
+
+// SensorReading is the light a sensor of a grid measures
+type SensorReading struct {
+	// Row and Column are the cell of the sensor
+	Row, Column int
+	// Point is where the sensor is and Normal the unit vector it faces
+	Point, Normal math3d.Vector3
+	// Irradiance is the light arriving at the sensor, and Direct the part
+	// of it that arrives straight from the lights
+	Irradiance, Direct image.Color
+}
+
+// Illuminance returns the illuminance of the irradiance in lux, taking the
+// radiances of the scene in thousands of cd/m² as the ones of the sky are
+func (r *SensorReading) Illuminance() float64 {
+	return r.Irradiance.Luminance() * luxPerUnit
+}
+
+// DirectIlluminance returns the illuminance in lux of the light arriving
+// straight from the lights
+func (r *SensorReading) DirectIlluminance() float64 {
+	return r.Direct.Luminance() * luxPerUnit
+}
+
+// WriteIlluminanceCSV writes the readings to w as a table, with a row for
+// every sensor with its cell, position and illuminance in lux, all of it
+// and the part that arrives straight from the lights
+func WriteIlluminanceCSV(w io.Writer, readings []SensorReading) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"row", "column", "x", "y", "z", "illuminance", "direct"})
+	for _, r := range readings {
+		out.Write([]string{strconv.Itoa(r.Row), strconv.Itoa(r.Column), formatFloat(r.Point.X), formatFloat(r.Point.Y), formatFloat(r.Point.Z),
+			formatFloat(r.Illuminance()), formatFloat(r.DirectIlluminance())})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ReadSensorGrid reads a sensor grid in JSON from r and validates it
+func ReadSensorGrid(r io.Reader) (*SensorGrid, error) {
+	g := &SensorGrid{}
+	if err := json.NewDecoder(r).Decode(g); err != nil {
+		return nil, err
+	}
+	return g, g.Validate()
+}
+
+// Validate returns an error if the grid can't measure
+func (g *SensorGrid) Validate() error {
+	if g.Columns < 1 || g.Rows < 1 {
+		return fmt.Errorf("there must be at least 1 column and 1 row")
+	}
+	if g.Samples < 1 {
+		return fmt.Errorf("there must be at least 1 sample")
+	}
+	if g.Across.CrossV(g.Along).AbsSquared() == 0 {
+		return fmt.Errorf("across and along must be non zero and not parallel")
+	}
+	return nil
+}
+
+// sensor returns the position of the sensor of the row and the column and
+// the unit normal it faces
+func (g *SensorGrid) sensor(row, column int) (math3d.Vector3, math3d.Vector3) {
+	normal := math3d.UnitY
+	if g.Normal.AbsSquared() > 0 {
+		normal = g.Normal.NormalizedV()
+	}
+	u := (float64(column) + 0.5) / float64(g.Columns)
+	v := (float64(row) + 0.5) / float64(g.Rows)
+	return g.Corner.AddV(g.Across.MultiplyV(u)).AddV(g.Along.MultiplyV(v)), normal
+}
+
+// Illuminance measures the light arriving at the sensors of the grid as
+// the shutter opens, and returns their readings in the order of the rows
+// and the columns. The sensors see the whole hemisphere they face, lit by
+// the lights of the scene, such as the sky and its sun, and by the
+// surfaces around, which reflect the light as the integrator of the scene
+// renders them: only once with direct lighting, and bouncing on with path
+// tracing. The sensors cast no shadows.
+//
+// The light arriving straight from every light is estimated by sampling
+// it, and the light reflected by the surfaces by following the samples of
+// the grid in directions distributed as their cosine with the normal.
+func (s *Scene) Illuminance(g *SensorGrid) ([]SensorReading, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	s.Prepare()
+	readings := make([]SensorReading, g.Rows*g.Columns)
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range rows {
+				for column := 0; column < g.Columns; column++ {
+					i := row*g.Columns + column
+					// Every sensor has its own stream, so the readings don't
+					// depend on the goroutine that measures them
+					readings[i] = s.measure(g, row, column, sampling.New(g.Seed, uint64(i)))
+				}
+			}
+		}()
+	}
+	for row := 0; row < g.Rows; row++ {
+		rows <- row
+	}
+	close(rows)
+	wg.Wait()
+	return readings, nil
+}
+
+// measure returns the reading of the sensor of the row and the column of
+// the grid
+func (s *Scene) measure(g *SensorGrid, row, column int, rng *sampling.Rand) SensorReading {
+	point, normal := g.sensor(row, column)
+	var direct, reflected image.Color
+	for n := 0; n < g.Samples; n++ {
+		time := 0.0
+		if s.Settings.Shutter > 0 {
+			time = s.Settings.Shutter * rng.Float64()
+		}
+		s.forLights(&point, &normal, rng, func(ls lighting.Light, weight float64) {
+			if _, irradiance, ok := s.lightArriving(nil, &point, &normal, ls, time, rng); ok {
+				direct = *direct.Add(irradiance.Multiply(weight))
+			}
+		})
+		// The light of the surfaces in front of the lights, as the light
+		// sampled above is the one of the lights they don't hide
+		ray := math3d.LightRay{Source: point, Direction: sampling.CosineHemisphere(&normal, rng.Float64(), rng.Float64()), Time: time}
+		distance, sh := s.getNearestIntersection(&ray)
+		if sh == nil {
+			continue
+		}
+		if lightDistance, _ := s.lightHit(&ray); lightDistance < distance {
+			continue
+		}
+		radiance := s.integrator().Radiance(s, &ray, rng)
+		// The cosine over the density of the direction is pi
+		reflected = *reflected.Add(radiance.Multiply(math.Pi))
+	}
+	samples := float64(g.Samples)
+	direct = *direct.Divide(samples)
+	return SensorReading{
+		Row:        row,
+		Column:     column,
+		Point:      point,
+		Normal:     normal,
+		Irradiance: *direct.Add(reflected.Divide(samples)),
+		Direct:     direct,
+	}
+}
@@ -0,0 +1,84 @@
+package scene
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestIlluminance(t *testing.T) {
+	s := New()
+	// A sun overhead giving 1000 lux, over a vast gray floor, with a ball
+	// shading a sensor
+	s.AddLight(&lighting.SunLight{Direction: math3d.UnitY, Irradiance: image.White})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -1000}, Radius: 1000, Material: &material.Lambertian{Albedo: image.Color{R: 0.5, G: 0.5, B: 0.5}}})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 1.5, Y: 3}, Radius: 0.5})
+	g := &SensorGrid{
+		Corner:  math3d.Vector3{X: -2, Y: 1, Z: -1},
+		Across:  math3d.Vector3{X: 4},
+		Along:   math3d.Vector3{Z: 2},
+		Columns: 4,
+		Rows:    1,
+		Samples: 4000,
+	}
+	readings, err := s.Illuminance(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 4 || readings[2].Point != (math3d.Vector3{X: 0.5, Y: 1}) || readings[2].Column != 2 {
+		t.Fatalf("Unexpected sensors %+v", readings)
+	}
+	for i, r := range readings {
+		want := 1000.0
+		if i == 3 {
+			want = 0
+		}
+		if math.Abs(r.DirectIlluminance()-want) > 1e-9 {
+			t.Errorf("Sensor %d should measure %g lux straight from the sun, not %g", i, want, r.DirectIlluminance())
+		}
+		// Facing up, the sensors only see the ball
+		if r.Illuminance() > r.DirectIlluminance()+50 {
+			t.Errorf("Sensor %d should barely see the light of the ball, it measures %g lux", i, r.Illuminance())
+		}
+	}
+
+	// Facing down, the sensors see the floor reflect half the sunlight
+	g.Normal = math3d.Vector3{Y: -1}
+	readings, _ = s.Illuminance(g)
+	if r := readings[0]; r.Direct != image.Black || math.Abs(r.Illuminance()-500) > 20 {
+		t.Errorf("The floor should give 500 lux and no direct light, not %g and %g", r.Illuminance(), r.DirectIlluminance())
+	}
+}
+
+func TestReadSensorGrid(t *testing.T) {
+	g, err := ReadSensorGrid(strings.NewReader(`{"corner": {"x": 0, "y": 0.8, "z": 0}, "across": {"x": 5, "y": 0, "z": 0}, "along": {"x": 0, "y": 0, "z": 3}, "columns": 10, "rows": 6, "samples": 64}`))
+	if err != nil || g.Columns != 10 || g.Rows != 6 || g.Samples != 64 {
+		t.Errorf("Unexpected grid %+v (%v)", g, err)
+	}
+	for _, invalid := range []string{
+		`{"across": {"x": 1, "y": 0, "z": 0}, "along": {"x": 0, "y": 0, "z": 1}, "columns": 1, "rows": 1}`,
+		`{"across": {"x": 1, "y": 0, "z": 0}, "along": {"x": 2, "y": 0, "z": 0}, "columns": 1, "rows": 1, "samples": 1}`,
+		`{"across": {"x": 1, "y": 0, "z": 0}, "along": {"x": 0, "y": 0, "z": 1}, "columns": 0, "rows": 1, "samples": 1}`,
+	} {
+		if _, err := ReadSensorGrid(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%s should be rejected", invalid)
+		}
+	}
+}
+
+func TestWriteIlluminanceCSV(t *testing.T) {
+	readings := []SensorReading{{Row: 1, Column: 2, Point: math3d.Vector3{X: 0.5, Y: 1}, Irradiance: image.White, Direct: image.Color{R: 0.5, G: 0.5, B: 0.5}}}
+	var b strings.Builder
+	if err := WriteIlluminanceCSV(&b, readings); err != nil {
+		t.Fatal(err)
+	}
+	if want := "row,column,x,y,z,illuminance,direct\n1,2,0.5,1,0,1000,500\n"; b.String() != want {
+		t.Errorf("The table should be\n%s\nnot\n%s", want, b.String())
+	}
+}
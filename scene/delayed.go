@@ -0,0 +1,203 @@
+package scene
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Delayed is a shape whose geometry is only loaded, from the files of its
+// source shape or by generating it, when a lightray first reaches its
+// bounds. Scenes with huge environments start rendering right away, and
+// the parts of them that no ray reaches never take any memory. The
+// lightrays that hit it hit one of the shapes it loaded, which the scene
+// gives back instead of it.
+type Delayed struct {
+	Name string
+	// Box bounds the shapes of Source, which are taken as missed by the
+	// lightrays that don't reach it
+	Box math3d.AABB
+	// Source is the map of the shape to load, such as a mesh with its file
+	Source map[string]interface{}
+	// Material is the material of Source, the default one if nil
+	Material material.Material
+
+	// loaded is set atomically once the shapes are loaded, so that the
+	// lightrays don't take the lock after that
+	loaded    uint32
+	mu        sync.Mutex
+	shapes    []shape.Shape
+	structure accel.Accelerator
+	// culling, shutter and counters are the settings of the renders, which
+	// the shapes take when they're loaded, and hits filters their hits
+	culling  bool
+	shutter  float64
+	counters *accel.Counters
+	hits     accel.Filter
+}
+
+// load loads the shapes of the source, once. The ones that can't be used
+// are left out with a warning, as lenient parsing would.
+func (d *Delayed) load() {
+	if atomic.LoadUint32(&d.loaded) == 1 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.loaded == 1 {
+		return
+	}
+	shapes, err := shape.FromMap([]map[string]interface{}{d.Source})
+	if err != nil {
+		log.Println("Warning: " + d.describe() + " can't be loaded: " + err.Error())
+	}
+	d.shapes = d.shapes[:0]
+	for _, sh := range shapes {
+		if problem := invalidShape(sh); problem != "" {
+			log.Println("Warning: " + d.describe() + ": " + problem)
+			continue
+		}
+		d.shapes = append(d.shapes, sh)
+	}
+	d.build()
+	if bounds := d.structure.Bounds(); len(d.shapes) > 0 && !(d.Box.Contains(&bounds.Min) && d.Box.Contains(&bounds.Max)) {
+		log.Println("Warning: " + d.describe() + " reaches out of its bounds, the lightrays that miss them miss it")
+	}
+	atomic.StoreUint32(&d.loaded, 1)
+}
+
+// build builds the structure over the shapes with the settings of the
+// renders
+func (d *Delayed) build() {
+	primitives := make([]accel.Primitive, len(d.shapes))
+	for i, sh := range d.shapes {
+		prepareShape(sh, d.culling, d.shutter)
+		primitives[i] = sh
+	}
+	d.structure = accel.NewBVH(primitives)
+	d.structure.Count(d.counters)
+	d.structure.Filter(d.hits)
+}
+
+// prepare gives the shapes the settings of the renders, now if they're
+// loaded and otherwise when they are. It must not be called while the
+// shape is traced.
+func (d *Delayed) prepare(culling bool, shutter float64, counters *accel.Counters) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.culling, d.shutter, d.counters = culling, shutter, counters
+	if d.loaded == 1 {
+		d.build()
+	}
+}
+
+// refit refits the structure over the shapes after they were deformed, if
+// they're loaded
+func (d *Delayed) refit() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.loaded == 1 {
+		d.structure.Refit()
+	}
+}
+
+// filter makes the hits on the shapes count only if f accepts them, now
+// if they're loaded and otherwise when they are. It must not be called
+// while the shape is traced.
+func (d *Delayed) filter(f accel.Filter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hits = f
+	if d.loaded == 1 {
+		d.structure.Filter(f)
+	}
+}
+
+// describe returns how the warnings name the shape
+func (d *Delayed) describe() string {
+	if d.Name != "" {
+		return fmt.Sprintf("the delayed shape %q", d.Name)
+	}
+	return fmt.Sprintf("the delayed %s shape", d.Source["type"])
+}
+
+// Shapes returns the shapes of the source, loading them if they weren't
+func (d *Delayed) Shapes() []shape.Shape {
+	d.load()
+	return d.shapes
+}
+
+// Loaded returns whether the shapes of the source were loaded
+func (d *Delayed) Loaded() bool {
+	return atomic.LoadUint32(&d.loaded) == 1
+}
+
+// Intersect returns the distance at which the lightray intersects the
+// nearest of the shapes, loading them if it's the first to reach them
+func (d *Delayed) Intersect(lr *math3d.LightRay) float64 {
+	distance, _ := d.nearest(lr)
+	return distance
+}
+
+// nearest returns the distance at which the lightray intersects the
+// nearest of the shapes and that shape, or math.MaxFloat64 and nil if it
+// misses them all
+func (d *Delayed) nearest(lr *math3d.LightRay) (float64, shape.Shape) {
+	if d.Box.Intersect(lr) == math.MaxFloat64 {
+		return math.MaxFloat64, nil
+	}
+	d.load()
+	distance, index := d.structure.Intersect(lr)
+	if index < 0 {
+		return distance, nil
+	}
+	return distance, d.shapes[index]
+}
+
+// NormalAt returns the normal of the nearest shape at the point, although
+// the scene shades the shapes that were hit instead
+func (d *Delayed) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	d.load()
+	nearest, nearestDistance := shape.Shape(nil), math.MaxFloat64
+	for _, sh := range d.shapes {
+		if distance := sh.Bounds().Distance(point); distance < nearestDistance {
+			nearest, nearestDistance = sh, distance
+		}
+	}
+	if nearest == nil {
+		return math3d.UnitY
+	}
+	return nearest.NormalAt(point)
+}
+
+// Surface returns the material of the source
+func (d *Delayed) Surface() material.Material {
+	return d.Material
+}
+
+// Bounds returns the bounds of the source, known without loading it
+func (d *Delayed) Bounds() *math3d.AABB {
+	bounds := d.Box
+	return &bounds
+}
+
+// AsMap returns a map representation of the delayed shape, with the map of
+// its source
+func (d *Delayed) AsMap() map[string]interface{} {
+	delayed := map[string]interface{}{
+		"type":   "delayed",
+		"bounds": map[string]interface{}{"min": d.Box.Min.AsMap(), "max": d.Box.Max.AsMap()},
+		"shape":  d.Source,
+	}
+	if d.Name != "" {
+		delayed["name"] = d.Name
+	}
+	return delayed
+}
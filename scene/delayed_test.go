@@ -0,0 +1,108 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// delayedMesh returns the mesh example scene and the same scene with its
+// mesh delayed
+func delayedMesh(t *testing.T) (*Scene, *Scene) {
+	data, err := ioutil.ReadFile("../scene-examples/mesh.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _, err := ParseSceneFile("../scene-examples/mesh.json", Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := math3d.EmptyAABB()
+	for _, sh := range s.Shapes {
+		bounds = bounds.Union(sh.Bounds())
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	mesh := m["shapes"].([]interface{})[0].(map[string]interface{})
+	mesh["file"] = "../scene-examples/icosphere.obj"
+	m["shapes"] = []interface{}{map[string]interface{}{
+		"type":   "delayed",
+		"name":   "icosphere",
+		"bounds": map[string]interface{}{"min": bounds.Min.AsMap(), "max": bounds.Max.AsMap()},
+		"shape":  mesh,
+	}}
+	data, _ = json.Marshal(m)
+	delayed, _, err := ParseScene(data, Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, delayed
+}
+
+func TestDelayedShapesLoadWhenHit(t *testing.T) {
+	s, delayed := delayedMesh(t)
+	d := delayed.Shapes[0].(*Delayed)
+	away := math3d.LightRay{Source: delayed.Camera.FocalPoint, Direction: math3d.Vector3{Z: -1}}
+	if _, sh := delayed.getNearestIntersection(&away); sh != nil || d.Loaded() {
+		t.Fatal("The mesh shouldn't be loaded before a lightray reaches its bounds")
+	}
+	// The middle of the image shows the mesh
+	ahead := delayed.Camera.GetIterator(16, 16).Ray(8, 8, 0.5, 0.5)
+	distance, sh := delayed.getNearestIntersection(&ahead)
+	if _, ok := sh.(*shape.Triangle); !ok || !d.Loaded() {
+		t.Fatalf("A lightray through the bounds should load the mesh and hit one of its triangles, not %T", sh)
+	}
+	if want, _ := s.getNearestIntersection(&ahead); distance != want {
+		t.Errorf("The loaded mesh should be hit at %g, not %g", want, distance)
+	}
+	if !bytes.Equal(delayed.TraceScene(16, 16).Pix, s.TraceScene(16, 16).Pix) {
+		t.Error("The delayed mesh should render like the mesh")
+	}
+	color := image.IDColor("icosphere")
+	if idpass := delayed.TraceIDPass(16, 16); idpass.NRGBAAt(8, 8) != color.ToNRGBA() {
+		t.Error("The triangles of the delayed mesh should take its color in the ID pass")
+	}
+}
+
+func TestDelayedShapesMarshal(t *testing.T) {
+	_, delayed := delayedMesh(t)
+	data, err := delayed.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := ParseScene(data, Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := parsed.Shapes[0].(*Delayed)
+	if !ok || d.Loaded() || d.Name != "icosphere" || d.Box != delayed.Shapes[0].(*Delayed).Box {
+		t.Errorf("The delayed mesh should be saved as it was parsed, not as %s", data)
+	}
+}
+
+func TestParseDelayedProblems(t *testing.T) {
+	for _, shape := range []string{
+		`{"type": "delayed", "shape": {"type": "sphere", "radius": 1}}`,
+		`{"type": "delayed", "bounds": {"min": {"x": 1, "y": 1, "z": 1}, "max": {"x": 0, "y": 0, "z": 0}}, "shape": {"type": "sphere", "radius": 1}}`,
+		`{"type": "delayed", "bounds": {"min": {"x": 0, "y": 0, "z": 0}, "max": {"x": 1, "y": 1, "z": 1}}}`,
+		`{"type": "delayed", "bounds": {"min": {"x": 0, "y": 0, "z": 0}, "max": {"x": 1, "y": 1, "z": 1}}, "shape": {"type": "cube"}}`,
+		`{"type": "delayed", "bounds": {"min": {"x": 0, "y": 0, "z": 0}, "max": {"x": 1, "y": 1, "z": 1}}, "shape": {"type": "delayed"}}`,
+	} {
+		scene := strings.Replace(validScene, `{"type": "sphere", "position": {"x": 0, "y": 0, "z": 3}, "radius": 1}`, shape, 1)
+		if _, _, err := ParseScene([]byte(scene), Strict); err == nil {
+			t.Errorf("%s shouldn't parse", shape)
+		}
+		s, warnings, err := ParseScene([]byte(scene), Lenient)
+		if err != nil || len(warnings) == 0 || len(s.Shapes) != 0 {
+			t.Errorf("%s should be left out with a warning (%v)", shape, err)
+		}
+	}
+}
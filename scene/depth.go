@@ -0,0 +1,194 @@
+package scene
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	stdimg "image"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/exr"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// DepthMap is what the camera sees through the center of every pixel of a
+// render, as the synthetic data of computer vision needs it: how far the
+// surfaces are, and where they are in the scene. The depth is the distance
+// along the viewing direction of the camera, as the Z buffers of
+// perspective renders hold it, or the distance from the camera in
+// equirectangular renders, which look all around. It's +Inf where the
+// background shows.
+type DepthMap struct {
+	Width, Height int
+	// Depth holds the depth of every pixel, row after row from the top
+	Depth []float32
+	// Points and Normals hold the point the camera sees through every
+	// pixel that sees a surface, and the unit normal of the surface there,
+	// in world space
+	Points, Normals []math3d.Vector3
+}
+
+// TraceDepth traces the depth map of a render of width x height of the
+// scene, as the shutter opens
+func (s *Scene) TraceDepth(width, height int) *DepthMap {
+	s.Prepare()
+	d := NewDepthMap(width, height)
+	if _, ok := s.accelerator().(accel.BatchIntersector); ok {
+		// The structures that trace batches trace the whole map at once
+		s.TraceDepthRegion(d, stdimg.Rect(0, 0, width, height))
+		return d
+	}
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range rows {
+				s.TraceDepthRegion(d, stdimg.Rect(0, y, width, y+1))
+			}
+		}()
+	}
+	for y := 0; y < height; y++ {
+		rows <- y
+	}
+	close(rows)
+	wg.Wait()
+	return d
+}
+
+// NewDepthMap returns a width x height depth map with no pixel traced
+func NewDepthMap(width, height int) *DepthMap {
+	return &DepthMap{Width: width, Height: height, Depth: make([]float32, width*height),
+		Points: make([]math3d.Vector3, width*height), Normals: make([]math3d.Vector3, width*height)}
+}
+
+// TraceDepthRegion traces the pixels of the depth map inside region, so
+// the regions of one map can be traced in parallel. The structures that
+// trace batches, such as the GPU accelerator, trace the region at once.
+// The scene must be prepared first.
+func (s *Scene) TraceDepthRegion(d *DepthMap, region stdimg.Rectangle) {
+	towards := s.Camera.Towards.NormalizedV()
+	planar := s.Camera.Projection != camera.Equirectangular
+	targetIt := s.Camera.GetIterator(d.Width, d.Height)
+	region = region.Intersect(stdimg.Rect(0, 0, d.Width, d.Height))
+	set := func(x, y int, lr *math3d.LightRay, distance float64, sh shape.Shape) {
+		i := y*d.Width + x
+		if sh == nil {
+			d.Depth[i] = float32(math.Inf(1))
+			return
+		}
+		hit := shape.HitAt(sh, lr, distance)
+		d.Points[i], d.Normals[i] = hit.Point, hit.Normal
+		depth := distance * lr.Direction.Abs()
+		if planar {
+			depth = hit.Point.SubtractV(s.Camera.FocalPoint).DotV(towards)
+		}
+		d.Depth[i] = float32(depth)
+	}
+	if _, ok := s.accelerator().(accel.BatchIntersector); ok {
+		rays := make([]math3d.LightRay, 0, region.Dx()*region.Dy())
+		for y := region.Min.Y; y < region.Max.Y; y++ {
+			for x := region.Min.X; x < region.Max.X; x++ {
+				rays = append(rays, targetIt.Ray(x, y, 0.5, 0.5))
+			}
+		}
+		lrs := make([]*math3d.LightRay, len(rays))
+		for i := range rays {
+			lrs[i] = &rays[i]
+		}
+		distances, shapes := s.nearestShapesOf(lrs)
+		for i, lr := range lrs {
+			set(region.Min.X+i%region.Dx(), region.Min.Y+i/region.Dx(), lr, distances[i], shapes[i])
+		}
+		return
+	}
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		// Neighbouring pixels are traced together, as their lightrays visit
+		// the same nodes of the acceleration structure
+		for x := region.Min.X; x < region.Max.X; x += accel.PacketSize {
+			var rays [accel.PacketSize]math3d.LightRay
+			var lrs [accel.PacketSize]*math3d.LightRay
+			for lane := 0; lane < accel.PacketSize && x+lane < region.Max.X; lane++ {
+				rays[lane] = targetIt.Ray(x+lane, y, 0.5, 0.5)
+				lrs[lane] = &rays[lane]
+			}
+			distances, shapes := s.nearestShapes(&lrs)
+			for lane := 0; lane < accel.PacketSize && x+lane < region.Max.X; lane++ {
+				set(x+lane, y, lrs[lane], distances[lane], shapes[lane])
+			}
+		}
+	}
+}
+
+// WriteEXR writes the depth to w as an OpenEXR image with a single Z
+// channel, as compositors expect depth
+func (d *DepthMap) WriteEXR(w io.Writer) error {
+	return exr.Encode(w, d.Width, d.Height, []exr.Channel{{Name: "Z", Values: d.Depth}}, nil)
+}
+
+// WritePFM writes the depth to w as a grayscale portable float map, whose
+// rows go from the bottom up, in little endian
+func (d *DepthMap) WritePFM(w io.Writer) error {
+	return writePFM(w, d.Width, d.Height, d.Depth)
+}
+
+// writePFM writes the values of an image of width x height, row after row
+// from the top, to w as a grayscale portable float map
+func writePFM(w io.Writer, width, height int, values []float32) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "Pf\n%d %d\n-1.0\n", width, height)
+	for y := height - 1; y >= 0; y-- {
+		if err := binary.Write(out, binary.LittleEndian, values[y*width:(y+1)*width]); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// WritePLY writes the points the camera sees to w as a point cloud in the
+// binary PLY format, with their normals, in the order of the pixels. The
+// points take the colors of the pixels of the render if it isn't nil,
+// which must be of the size of the depth map.
+func (d *DepthMap) WritePLY(w io.Writer, render *image.Image) error {
+	if render != nil && (render.Bounds().Dx() != d.Width || render.Bounds().Dy() != d.Height) {
+		return fmt.Errorf("the render must be %dx%d like the depth map", d.Width, d.Height)
+	}
+	points := 0
+	for _, depth := range d.Depth {
+		if !math.IsInf(float64(depth), 1) {
+			points++
+		}
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "ply\nformat binary_little_endian 1.0\ncomment goraytrace camera view\nelement vertex %d\n", points)
+	for _, property := range []string{"x", "y", "z", "nx", "ny", "nz"} {
+		fmt.Fprintf(out, "property float %s\n", property)
+	}
+	if render != nil {
+		fmt.Fprint(out, "property uchar red\nproperty uchar green\nproperty uchar blue\n")
+	}
+	fmt.Fprint(out, "end_header\n")
+	for i, depth := range d.Depth {
+		if math.IsInf(float64(depth), 1) {
+			continue
+		}
+		p, n := d.Points[i], d.Normals[i]
+		values := []float32{float32(p.X), float32(p.Y), float32(p.Z), float32(n.X), float32(n.Y), float32(n.Z)}
+		if err := binary.Write(out, binary.LittleEndian, values); err != nil {
+			return err
+		}
+		if render != nil {
+			c := render.NRGBAAt(i%d.Width, i/d.Width)
+			out.Write([]byte{c.R, c.G, c.B})
+		}
+	}
+	return out.Flush()
+}
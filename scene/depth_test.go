@@ -0,0 +1,127 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	stdimg "image"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestDepthMapTracesPacketsAsLightrays(t *testing.T) {
+	s, _, err := ParseScene([]byte(validScene), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 16
+	s.Prepare()
+	d := NewDepthMap(size, size)
+	// The rows are 13 pixels wide, so the last packet of each is partly
+	// empty
+	s.TraceDepthRegion(d, stdimg.Rect(1, 0, size-2, size))
+	targetIt := s.Camera.GetIterator(size, size)
+	for y := 0; y < size; y++ {
+		for x := 1; x < size-2; x++ {
+			lr := targetIt.Ray(x, y, 0.5, 0.5)
+			distance, sh := s.getNearestIntersection(&lr)
+			i := y*size + x
+			if (sh == nil) != math.IsInf(float64(d.Depth[i]), 1) || sh != nil && d.Points[i] != shape.HitAt(sh, &lr, distance).Point {
+				t.Fatalf("The pixel %d, %d should see what its lightray hits", x, y)
+			}
+		}
+	}
+}
+
+func TestDepthMapOfTheGPUAccelerator(t *testing.T) {
+	s := New()
+	for y := -4; y < 4; y++ {
+		for x := -4; x < 4; x++ {
+			a := math3d.Vector3{X: float64(x) / 4, Y: float64(y) / 4, Z: 3 + float64(x*y)/16}
+			b, c := a.AddV(math3d.Vector3{X: 0.25}), a.AddV(math3d.Vector3{Y: 0.25, Z: 0.1})
+			s.AddShape(&shape.Triangle{Vertices: [3]math3d.Vector3{a, b, c}})
+		}
+	}
+	const size = 32
+	expected := s.TraceDepth(size, size)
+	s.Settings.Accelerator = GPU
+	d := s.TraceDepth(size, size)
+	if _, ok := s.accelerator().(accel.BatchIntersector); !ok {
+		t.Fatal("The depth should be traced in a batch")
+	}
+	for i := range d.Depth {
+		if math.Abs(float64(d.Depth[i]-expected.Depth[i])) > 1e-6 || d.Points[i].SubtractV(expected.Points[i]).Abs() > 1e-6 {
+			t.Fatalf("The pixel %d should be %g deep, not %g", i, expected.Depth[i], d.Depth[i])
+		}
+	}
+}
+
+func TestDepthMap(t *testing.T) {
+	s, _, err := ParseScene([]byte(validScene), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 16
+	d := s.TraceDepth(size, size)
+	// The camera is at Z -1 and the sphere faces it at Z 2
+	center := size/2*size + size/2
+	if depth := d.Depth[center]; math.Abs(float64(depth)-3) > 0.05 {
+		t.Errorf("The sphere should be 3 away, not %g", depth)
+	}
+	if p := d.Points[center]; math.Abs(p.Z-2) > 0.05 || d.Normals[center].Z > -0.9 {
+		t.Errorf("The camera should see the front of the sphere, not %s facing %s", p.String(), d.Normals[center].String())
+	}
+	if depth := d.Depth[0]; !math.IsInf(float64(depth), 1) {
+		t.Errorf("The background should be infinitely far, not %g", depth)
+	}
+	// Off center, the camera sees the sphere farther away
+	for i, depth := range d.Depth {
+		if !math.IsInf(float64(depth), 1) && depth < 2 {
+			t.Errorf("The pixel %d can't see the sphere nearer than its front, at %g", i, depth)
+		}
+	}
+
+	var pfm bytes.Buffer
+	if err := d.WritePFM(&pfm); err != nil {
+		t.Fatal(err)
+	}
+	header := fmt.Sprintf("Pf\n%d %d\n-1.0\n", size, size)
+	if !strings.HasPrefix(pfm.String(), header) || pfm.Len() != len(header)+4*size*size {
+		t.Errorf("The PFM should have the header %q and a float for every pixel, it has %d bytes", header, pfm.Len())
+	}
+	// The rows are stored from the bottom up
+	var bottomLeft float32
+	binary.Read(bytes.NewReader(pfm.Bytes()[len(header):]), binary.LittleEndian, &bottomLeft)
+	if bottomLeft != d.Depth[(size-1)*size] {
+		t.Errorf("The PFM should start with the bottom row, not %g", bottomLeft)
+	}
+
+	var ply bytes.Buffer
+	render := s.TraceScene(size, size)
+	if err := d.WritePLY(&ply, render); err != nil {
+		t.Fatal(err)
+	}
+	points := 0
+	for _, depth := range d.Depth {
+		if !math.IsInf(float64(depth), 1) {
+			points++
+		}
+	}
+	parts := strings.SplitN(ply.String(), "end_header\n", 2)
+	if len(parts) != 2 || !strings.Contains(parts[0], fmt.Sprintf("element vertex %d\n", points)) || len(parts[1]) != points*(6*4+3) {
+		t.Errorf("The PLY should have the %d points the camera sees with their normals and colors:\n%s", points, parts[0])
+	}
+	if err := d.WritePLY(&ply, s.TraceScene(size, 2*size)); err == nil {
+		t.Error("A render of another size can't color the points")
+	}
+
+	var z bytes.Buffer
+	if err := d.WriteEXR(&z); err != nil || !bytes.Contains(z.Bytes(), []byte("Z\x00")) {
+		t.Errorf("The EXR should have a Z channel (%v)", err)
+	}
+}
@@ -0,0 +1,108 @@
+package scene
+
+import (
+	"fmt"
+	stdimg "image"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// The values of the pixels of the masks of disparity maps, as the
+// Middlebury stereo datasets have them
+const (
+	// DisparityVisible pixels see a surface that the right camera sees too
+	DisparityVisible = 255
+	// DisparityOccluded pixels see a surface that the right camera can't
+	// see, because another one hides it or it's out of the right image
+	DisparityOccluded = 128
+	// DisparityUnknown pixels see the background, whose disparity is 0
+	DisparityUnknown = 0
+)
+
+// DisparityMap is the ground truth of a rectified stereo pair, the images
+// of the left and right cameras that StereoPair returns, to build and
+// evaluate stereo matching with: how many pixels left of where the left
+// camera sees every point through the center of a pixel the right camera
+// sees it.
+type DisparityMap struct {
+	Width, Height int
+	// Disparity holds the disparity of every pixel of the left image, row
+	// after row from the top, which is 0 where the background shows
+	Disparity []float32
+	// Mask holds whether the right camera sees what every pixel of the
+	// left image sees, Visible, Occluded or Unknown
+	Mask []uint8
+}
+
+// TraceDisparity traces the disparity map of the stereo pair that
+// StereoPair returns of the camera of the scene, with the baseline, for
+// images of width x height, as the shutter opens. It fails if the camera
+// doesn't have a perspective projection.
+func (s *Scene) TraceDisparity(width, height int, baseline float64) (*DisparityMap, error) {
+	if s.Camera.Projection == camera.Equirectangular {
+		return nil, fmt.Errorf("only the %s projection renders rectified stereo pairs", camera.Perspective)
+	}
+	if !(baseline > 0) || math.IsInf(baseline, 1) {
+		return nil, fmt.Errorf("the baseline must be positive and finite")
+	}
+	s.Prepare()
+	left, right := s.Camera.StereoPair(baseline)
+	d := &DisparityMap{Width: width, Height: height, Disparity: make([]float32, width*height), Mask: make([]uint8, width*height)}
+	targetIt := left.GetIterator(width, height)
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range rows {
+				for x := 0; x < width; x++ {
+					i := y*width + x
+					lr := targetIt.Ray(x, y, 0.5, 0.5)
+					distance, sh := s.getNearestIntersection(&lr)
+					if sh == nil {
+						continue
+					}
+					point := lr.Source.AddV(lr.Direction.MultiplyV(distance))
+					xl, _, _ := left.Project(&point, width, height)
+					xr, _, _ := right.Project(&point, width, height)
+					d.Disparity[i], d.Mask[i] = float32(xl-xr), DisparityOccluded
+					if xr < -0.5 || xr >= float64(width)-0.5 {
+						continue
+					}
+					// The right camera sees the point if nothing is nearer
+					// along the way, give or take the error of the hits
+					toPoint := point.SubtractV(right.FocalPoint)
+					far := toPoint.Abs()
+					seen := math3d.LightRay{Source: right.FocalPoint, Direction: toPoint.MultiplyV(1 / far)}
+					if nearest, _ := s.getNearestIntersection(&seen); nearest >= far*(1-1e-6) {
+						d.Mask[i] = DisparityVisible
+					}
+				}
+			}
+		}()
+	}
+	for y := 0; y < height; y++ {
+		rows <- y
+	}
+	close(rows)
+	wg.Wait()
+	return d, nil
+}
+
+// WritePFM writes the disparity to w as a grayscale portable float map,
+// whose rows go from the bottom up, in little endian, as the Middlebury
+// stereo datasets have it
+func (d *DisparityMap) WritePFM(w io.Writer) error {
+	return writePFM(w, d.Width, d.Height, d.Disparity)
+}
+
+// MaskImage returns the mask of the disparity as a grayscale image
+func (d *DisparityMap) MaskImage() *stdimg.Gray {
+	return &stdimg.Gray{Pix: d.Mask, Stride: d.Width, Rect: stdimg.Rect(0, 0, d.Width, d.Height)}
+}
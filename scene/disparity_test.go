@@ -0,0 +1,64 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestDisparityMap(t *testing.T) {
+	s := New()
+	// A ball in front of a wall that fills the view
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 1001}, Radius: 1000})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 0.5}, Radius: 0.1})
+	s.Camera = camera.NewLookAt(math3d.Vector3{Z: -1}, math3d.Vector3{}, math3d.UnitY, 40, 0)
+	const size, baseline = 64, 0.2
+	d, err := s.TraceDisparity(size, size, baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	focal := s.Camera.ViewPlaneDistance / s.Camera.PixelSize(size, size)
+	left, _ := s.Camera.StereoPair(baseline)
+	// The pixels of the row through the middle see the ball and the wall
+	y := size / 2
+	for x := 0; x < size; x++ {
+		i := y*size + x
+		lr := left.GetIterator(size, size).Ray(x, y, 0.5, 0.5)
+		distance, _ := s.getNearestIntersection(&lr)
+		depth := lr.Source.AddV(lr.Direction.MultiplyV(distance)).SubtractV(left.FocalPoint).DotV(s.Camera.Towards)
+		if want := focal * baseline / depth; math.Abs(float64(d.Disparity[i])-want) > 1e-6*want {
+			t.Fatalf("The disparity at %d should be %g, not %g", x, want, d.Disparity[i])
+		}
+	}
+	// The ball hides a strip of the wall left of it from the right camera,
+	// and the left edge of the image is out of its view
+	center := y*size + size/2
+	if d.Mask[center] != DisparityVisible || d.Disparity[center] < d.Disparity[y*size+size-1]+2 {
+		t.Errorf("Both cameras should see the ball, nearer than the wall, not %d with %g", d.Mask[center], d.Disparity[center])
+	}
+	if d.Mask[y*size] != DisparityOccluded {
+		t.Error("The right camera can't see the left edge of the left image")
+	}
+	occluded, visible := false, false
+	for x := 1; x < size/2; x++ {
+		occluded = occluded || visible && d.Mask[y*size+x] == DisparityOccluded
+		visible = visible || d.Mask[y*size+x] == DisparityVisible
+	}
+	if !occluded {
+		t.Error("The ball should hide part of the wall from the right camera")
+	}
+
+	s.RemoveShape(1)
+	s.RemoveShape(0)
+	d, _ = s.TraceDisparity(size, size, baseline)
+	if d.Mask[0] != DisparityUnknown || d.Disparity[0] != 0 {
+		t.Errorf("The background should have no disparity, not %d with %g", d.Mask[0], d.Disparity[0])
+	}
+	s.Camera.Projection = camera.Equirectangular
+	if _, err := s.TraceDisparity(size, size, baseline); err == nil {
+		t.Error("Only perspective cameras should render stereo pairs")
+	}
+}
@@ -0,0 +1,135 @@
+package scene
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Reduction is a heightfield that DownscaleImages downscaled
+type Reduction struct {
+	Name string
+	// Columns and Rows are the samples it has now, of the FromColumns and
+	// FromRows of its image
+	FromColumns, FromRows, Columns, Rows int
+	// FromMemory and Memory are the bytes it took before and takes now
+	FromMemory, Memory uint64
+}
+
+// DownscaleImages downscales the heightfields read from images that are
+// larger than the settings allow in renders of width x height: first to
+// the Detail the camera sees them with, then to MaxImageSize and then, if
+// they still take more than ImageMemory, all by the same factor until
+// they fit or can't be downscaled any further. It returns the
+// heightfields it downscaled, in the order of the shapes.
+func (s *Scene) DownscaleImages(width, height int) []Reduction {
+	var reductions []Reduction
+	var images []*shape.Heightfield
+	byShape := make(map[*shape.Heightfield]int)
+	for i, sh := range s.Shapes {
+		h, ok := sh.(*shape.Heightfield)
+		if !ok || h.Image == "" {
+			continue
+		}
+		columns, rows := h.Samples()
+		byShape[h] = len(reductions)
+		reductions = append(reductions, Reduction{Name: shape.NameOf(sh, i), FromColumns: columns, FromRows: rows, FromMemory: h.Memory()})
+		images = append(images, h)
+	}
+	if detail := s.Settings.Detail; detail > 0 {
+		// The size of a pixel at a distance of 1 from the camera
+		pixel := s.Camera.PixelSize(width, height) / s.Camera.ViewPlaneDistance
+		for _, h := range images {
+			// The terrain keeps all its samples if the camera is over it
+			distance := h.Bounds().Distance(&s.Camera.FocalPoint)
+			if distance == 0 {
+				continue
+			}
+			// fit returns how many samples along a side of the size are
+			// at least detail pixels apart
+			fit := func(samples int, size float64) int {
+				if most := size/(detail*pixel*distance) + 1; most < float64(samples) {
+					return maxInt(2, int(most))
+				}
+				return samples
+			}
+			columns, rows := h.Samples()
+			h.Downscale(fit(columns, h.Size.X), fit(rows, h.Size.Z))
+		}
+	}
+	if limit := s.Settings.MaxImageSize; limit > 0 {
+		for _, h := range images {
+			columns, rows := h.Samples()
+			if longest := maxInt(columns, rows); longest > limit {
+				downscale(h, float64(limit-1)/float64(longest-1))
+			}
+		}
+	}
+	if s.Settings.ImageMemory > 0 {
+		budget := uint64(s.Settings.ImageMemory * (1 << 20))
+		// Every pass shrinks the images by about the factor that makes them
+		// fit. The samples at the edges take the same memory as the inner
+		// ones, so a few passes may be needed to make up for rounding.
+		for {
+			total := uint64(0)
+			for _, h := range images {
+				total += h.Memory()
+			}
+			if total <= budget || !downscaleAll(images, math.Sqrt(float64(budget)/float64(total))) {
+				break
+			}
+		}
+	}
+	downscaled := reductions[:0]
+	for _, h := range images {
+		r := reductions[byShape[h]]
+		r.Columns, r.Rows = h.Samples()
+		if r.Columns == r.FromColumns && r.Rows == r.FromRows {
+			continue
+		}
+		r.Memory = h.Memory()
+		downscaled = append(downscaled, r)
+	}
+	if len(downscaled) > 0 {
+		s.structure = nil
+		s.dirtyAll = true
+	}
+	return downscaled
+}
+
+// downscaleAll downscales every heightfield by the factor, returning false
+// if none of them could be downscaled any further
+func downscaleAll(heightfields []*shape.Heightfield, factor float64) bool {
+	shrunk := false
+	for _, h := range heightfields {
+		if downscale(h, factor) {
+			shrunk = true
+		}
+	}
+	return shrunk
+}
+
+// downscale downscales the intervals between the samples of the
+// heightfield by the factor, keeping at least 2x2 samples, and returns
+// true if it lost any samples. A factor below 1 always loses a sample
+// along the sides with more than 2.
+func downscale(h *shape.Heightfield, factor float64) bool {
+	columns, rows := h.Samples()
+	scaled := func(n int) int {
+		// The epsilon keeps rounding from taking a sample too many
+		return maxInt(2, int(float64(n-1)*factor+1e-9)+1)
+	}
+	newColumns, newRows := scaled(columns), scaled(rows)
+	if newColumns == columns && newRows == rows {
+		return false
+	}
+	h.Downscale(newColumns, newRows)
+	return true
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
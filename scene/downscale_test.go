@@ -0,0 +1,82 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func imageTerrain(columns, rows int) *shape.Heightfield {
+	h := shape.NewHeightfield(math3d.Vector3{}, math3d.Vector3{X: 1, Y: 1, Z: 1}, columns, rows,
+		func(x, z float64) float64 { return x * z })
+	h.Image = "terrain.png"
+	return h
+}
+
+func TestDownscaleImagesToTheMaximumSize(t *testing.T) {
+	s := New()
+	s.AddShape(imageTerrain(101, 51))
+	s.AddShape(imageTerrain(33, 33))
+	inline := imageTerrain(200, 200)
+	inline.Image = ""
+	s.AddShape(inline)
+	s.Settings.MaxImageSize = 64
+	reductions := s.DownscaleImages(100, 100)
+	if len(reductions) != 1 {
+		t.Fatalf("Only the first heightfield is larger than 64 samples, but %d were downscaled", len(reductions))
+	}
+	r := reductions[0]
+	if r.Name != "heightfield0" || r.FromColumns != 101 || r.FromRows != 51 || r.Columns != 64 || r.Rows != 32 || r.Memory >= r.FromMemory {
+		t.Errorf("The heightfield should be downscaled from 101x51 to 64x32, it was %+v", r)
+	}
+	if columns, _ := inline.Samples(); columns != 200 {
+		t.Error("Heightfields that aren't read from images shouldn't be downscaled")
+	}
+}
+
+func TestDownscaleImagesToTheMemoryBudget(t *testing.T) {
+	s := New()
+	s.AddShape(imageTerrain(257, 257))
+	s.AddShape(imageTerrain(129, 65))
+	total := uint64(0)
+	for _, sh := range s.Shapes {
+		total += sh.(*shape.Heightfield).Memory()
+	}
+	s.Settings.ImageMemory = float64(total) / 4 / (1 << 20)
+	reductions := s.DownscaleImages(100, 100)
+	if len(reductions) != 2 {
+		t.Fatalf("Both heightfields should be downscaled, %d were", len(reductions))
+	}
+	downscaled := reductions[0].Memory + reductions[1].Memory
+	if downscaled > total/4 || downscaled < total/8 {
+		t.Errorf("The heightfields should take a little less than a quarter of the %d bytes, they take %d", total, downscaled)
+	}
+	if len(s.DownscaleImages(100, 100)) != 0 {
+		t.Error("Heightfields that fit shouldn't be downscaled again")
+	}
+}
+
+func TestDownscaleImagesToTheDetailOnScreen(t *testing.T) {
+	s := New()
+	near, far := imageTerrain(257, 257), imageTerrain(257, 257)
+	far.Translate(&math3d.Vector3{Z: 20})
+	s.AddShape(near)
+	s.AddShape(far)
+	s.Settings.Detail = 1
+	s.DownscaleImages(100, 100)
+	// From 1.5 and 21.5 away, a pixel of the default camera covers about
+	// 0.0053 and 0.076 of the side of the terrains
+	if columns, rows := near.Samples(); columns != 190 || rows != 190 {
+		t.Errorf("The near terrain should keep 190x190 samples, it has %dx%d", columns, rows)
+	}
+	if columns, rows := far.Samples(); columns != 14 || rows != 14 {
+		t.Errorf("The far terrain should keep 14x14 samples, it has %dx%d", columns, rows)
+	}
+	over := imageTerrain(65, 65)
+	s.Shapes = []shape.Shape{over}
+	s.Camera.FocalPoint = math3d.Vector3{X: 0.5, Y: 0.5, Z: 0.5}
+	if len(s.DownscaleImages(100, 100)) != 0 {
+		t.Error("The terrain the camera is over should keep all its samples")
+	}
+}
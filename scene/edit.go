@@ -0,0 +1,334 @@
+package scene
+
+import (
+	stdimg "image"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// RemoveShape removes the shape at index from the scene.
+func (s *Scene) RemoveShape(index int) {
+	s.mustBeEditable()
+	s.markDirty(s.Shapes[index].Bounds())
+	delete(s.moving, s.Shapes[index])
+	delete(s.meshes, s.Shapes[index])
+	delete(s.fast, s.Shapes[index])
+	delete(s.filters, s.Shapes[index])
+	s.Shapes = append(s.Shapes[:index], s.Shapes[index+1:]...)
+	s.structure = nil
+}
+
+// MoveShape moves the shape at index by offset. A BVH is refitted instead
+// of being built again. The two level structure gives the shape an
+// instance of its own the first time it moves, and from then on only
+// refits that one.
+func (s *Scene) MoveShape(index int, offset *math3d.Vector3) {
+	s.mustBeEditable()
+	sh := s.Shapes[index]
+	movable, ok := sh.(shape.Movable)
+	if !ok {
+		panic("That shape can't be moved")
+	}
+	s.markDirty(sh.Bounds())
+	movable.Translate(offset)
+	s.markDirty(sh.Bounds())
+	s.moved(index)
+}
+
+// TransformShape transforms the shape at index by the affine matrix, as
+// MoveShape moves it. Shapes that can't be rotated or scaled can still be
+// transformed by translations. It panics if the shape can't be transformed
+// by the matrix.
+func (s *Scene) TransformShape(index int, m *math3d.Matrix) {
+	s.mustBeEditable()
+	sh := s.Shapes[index]
+	s.markDirty(sh.Bounds())
+	if !transformShape(sh, m) {
+		panic("That shape can't be transformed by the matrix")
+	}
+	s.markDirty(sh.Bounds())
+	s.moved(index)
+}
+
+// transformShape transforms the shape by the affine matrix, and returns
+// whether it could
+func transformShape(sh shape.Shape, m *math3d.Matrix) bool {
+	if !canTransform(sh, m) {
+		return false
+	}
+	if transformable, ok := sh.(shape.Transformable); ok {
+		transformable.Transform(m)
+	} else {
+		offset, _ := m.Translation()
+		sh.(shape.Movable).Translate(&offset)
+	}
+	return true
+}
+
+// canTransform returns whether the shape can be transformed by the affine
+// matrix: whether it can be, or it can be moved and the matrix only moves
+// it
+func canTransform(sh shape.Shape, m *math3d.Matrix) bool {
+	if _, ok := sh.(shape.Transformable); ok {
+		return true
+	}
+	_, translation := m.Translation()
+	_, movable := sh.(shape.Movable)
+	return movable && translation
+}
+
+// moved updates the acceleration structure after the shape at index moved
+func (s *Scene) moved(index int) {
+	sh := s.Shapes[index]
+	if s.moving == nil {
+		s.moving = make(map[shape.Shape]bool)
+	}
+	moved := s.moving[sh]
+	s.moving[sh] = true
+	switch structure := s.structure.(type) {
+	case nil:
+	case *accel.TwoLevel:
+		if moved {
+			structure.Moved(index)
+		} else {
+			s.structure = nil
+		}
+	default:
+		structure.Refit()
+	}
+}
+
+// DeformShapes calls deform, which changes the shapes at the indices in
+// place, such as the vertices of the triangles of a mesh as it bends from
+// a frame to the next, and updates the acceleration structure without
+// building it again. A BVH is refitted: the bounds of its nodes are
+// recomputed from the new ones of the shapes. The two level structure
+// gives the shapes an instance of their own, a BVH over them alone, the
+// first time they're deformed together, and from then on only refits that
+// one, however many other shapes the scene has. The structures are only
+// as good as before if the shapes don't move far, so meshes that change a
+// lot should be added again instead.
+func (s *Scene) DeformShapes(indices []int, deform func()) {
+	s.mustBeEditable()
+	for _, i := range indices {
+		s.markDirty(s.Shapes[i].Bounds())
+	}
+	deform()
+	for _, i := range indices {
+		s.markDirty(s.Shapes[i].Bounds())
+		if d, ok := s.Shapes[i].(*Delayed); ok {
+			d.refit()
+		}
+	}
+	if len(indices) > 0 {
+		s.deformed(indices)
+	}
+}
+
+// SetFastBuild makes the two level structure build the instance of the
+// shapes at the indices, such as the triangles of a mesh that deforms
+// every frame, the fast way of accel.NewLBVH if fast is true, or the usual
+// way if it's false. The shapes get an instance of their own, as if they
+// were deformed together, which DeformShapes builds again rather than
+// refits, so it stays as good as when it was built however much they
+// change, but lightrays are traced through it slower than through a BVH
+// built the usual way. The other structures build as they do.
+func (s *Scene) SetFastBuild(indices []int, fast bool) {
+	s.mustBeEditable()
+	if len(indices) == 0 {
+		return
+	}
+	if s.fast == nil {
+		s.fast = make(map[shape.Shape]bool)
+	}
+	for _, i := range indices {
+		if fast {
+			s.fast[s.Shapes[i]] = true
+		} else {
+			delete(s.fast, s.Shapes[i])
+		}
+	}
+	s.group(indices)
+	if _, ok := s.structure.(*accel.TwoLevel); ok {
+		s.structure = nil
+	}
+}
+
+// group puts the shapes at the indices in a group of their own, unless
+// they already are, and returns whether they were
+func (s *Scene) group(indices []int) bool {
+	if s.meshes == nil {
+		s.meshes = make(map[shape.Shape]int)
+	}
+	group, grouped := s.meshes[s.Shapes[indices[0]]]
+	for _, i := range indices[1:] {
+		if g, ok := s.meshes[s.Shapes[i]]; !ok || g != group {
+			grouped = false
+		}
+	}
+	if !grouped {
+		s.meshCount++
+		for _, i := range indices {
+			s.meshes[s.Shapes[i]] = s.meshCount
+		}
+	}
+	return grouped
+}
+
+// deformed updates the acceleration structure after the shapes at the
+// indices were deformed
+func (s *Scene) deformed(indices []int) {
+	grouped := s.group(indices)
+	switch structure := s.structure.(type) {
+	case nil:
+	case *accel.TwoLevel:
+		if grouped {
+			// Moved refits the instance of the group, which holds all of
+			// the shapes, or builds it again if it's fast
+			structure.Moved(indices[0])
+		} else {
+			s.structure = nil
+		}
+	default:
+		structure.Refit()
+	}
+}
+
+// RemoveLight removes the light at index from the scene.
+func (s *Scene) RemoveLight(index int) {
+	s.mustBeEditable()
+	s.Lights = append(s.Lights[:index], s.Lights[index+1:]...)
+	s.lightTree, s.caustics, s.probeMaps = nil, nil, nil
+	s.dirtyAll = true
+}
+
+// MoveLight moves the light at index by offset.
+func (s *Scene) MoveLight(index int, offset *math3d.Vector3) {
+	s.mustBeEditable()
+	s.Lights[index].Translate(offset)
+	s.lightTree, s.caustics, s.probeMaps = nil, nil, nil
+	s.dirtyAll = true
+}
+
+// SetCamera replaces the camera of the scene, which changes the whole
+// render.
+func (s *Scene) SetCamera(c camera.PinHole) {
+	s.mustBeEditable()
+	s.Camera = c
+	s.dirtyAll = true
+}
+
+// markDirty records that the region inside bounds changed. The caustics
+// of the shapes can land anywhere, and the probes reflect them anywhere,
+// so they change the whole render.
+func (s *Scene) markDirty(bounds *math3d.AABB) {
+	s.dirty = append(s.dirty, *bounds)
+	s.caustics, s.probeMaps = nil, nil
+	if s.rendersCaustics() || s.rendersProbes() {
+		s.dirtyAll = true
+	}
+}
+
+// DirtyRegion returns the rectangle of a width x height render that may
+// have changed since the scene was last traced. Changes to lights affect
+// the whole render. Changes to shapes affect where the shapes are seen
+// and where they may cast shadows.
+func (s *Scene) DirtyRegion(width, height int) stdimg.Rectangle {
+	full := stdimg.Rect(0, 0, width, height)
+	if s.dirtyAll {
+		return full
+	}
+	region := stdimg.Rectangle{}
+	for i := range s.dirty {
+		points, ok := s.footprint(&s.dirty[i])
+		if !ok {
+			return full
+		}
+		for _, p := range points {
+			x, y, inFront := s.Camera.Project(p, width, height)
+			if !inFront {
+				return full
+			}
+			px := stdimg.Rect(int(math.Floor(x)), int(math.Floor(y)), int(math.Ceil(x))+1, int(math.Ceil(y))+1)
+			region = region.Union(px)
+		}
+	}
+	return region.Intersect(full)
+}
+
+// footprint returns the points whose convex hull holds the box and the
+// shadows it may cast on the rest of the scene. ok is false if the
+// shadows could reach anywhere.
+func (s *Scene) footprint(box *math3d.AABB) ([]*math3d.Vector3, bool) {
+	corners := boxCorners(box)
+	points := corners
+	if len(s.Lights) == 0 {
+		return points, true
+	}
+	// Shadows can only fall on shapes, so extruding the corners away from
+	// the light by the size of the scene is far enough.
+	extent := s.accelerator().Bounds().Union(box)
+	for _, l := range s.Lights {
+		if _, distant := l.(lighting.Distant); distant {
+			// Light from far away, such as the sky's, can cast shadows
+			// anywhere
+			return nil, false
+		}
+		// The shadows of a light are inside the hull of the shadows of the
+		// corners of its bounds, which are a single point for point lights
+		lightBounds := l.Bounds()
+		if box.Overlaps(lightBounds) {
+			return nil, false
+		}
+		reach := extent.Union(lightBounds)
+		distance := math3d.Distance(&reach.Max, &reach.Min)
+		sources := boxCorners(lightBounds)
+		if lightBounds.Min == lightBounds.Max {
+			sources = sources[:1]
+		}
+		for _, source := range sources {
+			for _, c := range corners {
+				away := c.Subtract(source).Normalized().Multiply(distance)
+				points = append(points, c.Add(away))
+			}
+		}
+	}
+	return points, true
+}
+
+func boxCorners(box *math3d.AABB) []*math3d.Vector3 {
+	corners := make([]*math3d.Vector3, 0, 8)
+	for _, x := range []float64{box.Min.X, box.Max.X} {
+		for _, y := range []float64{box.Min.Y, box.Max.Y} {
+			for _, z := range []float64{box.Min.Z, box.Max.Z} {
+				corners = append(corners, &math3d.Vector3{X: x, Y: y, Z: z})
+			}
+		}
+	}
+	return corners
+}
+
+// UpdateRender traces again the pixels of render that may have changed
+// since the scene was last traced, leaving the rest untouched.
+func (s *Scene) UpdateRender(render *image.Image) {
+	width, height := render.Bounds().Dx(), render.Bounds().Dy()
+	s.TraceRegion(render, s.DirtyRegion(width, height))
+	s.MarkTraced()
+}
+
+// TraceRegion traces the pixels of render inside region.
+func (s *Scene) TraceRegion(render *image.Image, region stdimg.Rectangle) {
+	targetIt := s.Camera.GetIterator(render.Bounds().Dx(), render.Bounds().Dy())
+	region = region.Intersect(render.Bounds())
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			render.Set(x, y, s.encodedPixel(targetIt, x, y))
+		}
+	}
+}
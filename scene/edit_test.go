@@ -0,0 +1,124 @@
+package scene
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func testScene() *Scene {
+	s := New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: -0.2, Y: 0, Z: 1}, Radius: 0.1})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 0.2, Y: 0, Z: 1}, Radius: 0.1})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 0, Y: -10.3, Z: 1}, Radius: 10})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{X: 0, Y: 2, Z: 0}, Intensity: image.White})
+	return s
+}
+
+func TestMoveShapeUpdatesDirtyRegion(t *testing.T) {
+	s := testScene()
+	render := s.TraceScene(64, 64)
+	if !s.DirtyRegion(64, 64).Empty() {
+		t.Error("Nothing should be dirty right after tracing")
+	}
+
+	s.MoveShape(0, &math3d.Vector3{X: 0, Y: 0.05, Z: 0})
+	region := s.DirtyRegion(64, 64)
+	if region.Empty() || region.Dx() == 64 && region.Dy() == 64 {
+		t.Errorf("Moving a small sphere should dirty part of the render, not %v", region)
+	}
+	s.UpdateRender(render)
+
+	expected := s.TraceScene(64, 64)
+	if !bytes.Equal(render.Pix, expected.Pix) {
+		t.Error("Updating the dirty region should match tracing the whole scene")
+	}
+}
+
+// wavyMesh returns the triangles of a strip in front of the camera of
+// testScene, split in columns
+func wavyMesh(columns int) []*shape.Triangle {
+	var triangles []*shape.Triangle
+	for c := 0; c < columns; c++ {
+		x0, x1 := -0.3+0.6*float64(c)/float64(columns), -0.3+0.6*float64(c+1)/float64(columns)
+		a, b := math3d.Vector3{X: x0, Y: -0.2, Z: 0.8}, math3d.Vector3{X: x1, Y: -0.2, Z: 0.8}
+		d, e := math3d.Vector3{X: x0, Y: -0.05, Z: 0.8}, math3d.Vector3{X: x1, Y: -0.05, Z: 0.8}
+		triangles = append(triangles, &shape.Triangle{Vertices: [3]math3d.Vector3{a, b, e}}, &shape.Triangle{Vertices: [3]math3d.Vector3{a, e, d}})
+	}
+	return triangles
+}
+
+func TestDeformShapesRefitsTheStructures(t *testing.T) {
+	for _, accelerator := range []string{BVH, TwoLevel} {
+		s := testScene()
+		s.Settings.Accelerator = accelerator
+		mesh := wavyMesh(8)
+		var indices []int
+		for _, triangle := range mesh {
+			indices = append(indices, len(s.Shapes))
+			s.AddShape(triangle)
+		}
+		for frame := 0; frame < 3; frame++ {
+			if frame > 0 {
+				s.DeformShapes(indices, func() {
+					for _, triangle := range mesh {
+						for i := range triangle.Vertices {
+							v := &triangle.Vertices[i]
+							v.Y += 0.05 * math.Sin(20*v.X+float64(frame))
+						}
+					}
+				})
+			}
+			refitted := s.TraceScene(48, 48)
+			if structure, ok := s.accelerator().(*accel.TwoLevel); ok && frame > 0 && structure.Instances() != 2 {
+				t.Errorf("The mesh should have an instance of its own, but there are %d", structure.Instances())
+			}
+			s.structure = nil
+			if !bytes.Equal(refitted.Pix, s.TraceScene(48, 48).Pix) {
+				t.Fatalf("%s, frame %d: the refitted structure should find the same shapes as a new one", accelerator, frame)
+			}
+		}
+	}
+}
+
+func TestFastBuildShapesHaveAnInstanceBuiltEveryFrame(t *testing.T) {
+	s := testScene()
+	s.Settings.Accelerator = TwoLevel
+	mesh := wavyMesh(8)
+	var indices []int
+	for _, triangle := range mesh {
+		indices = append(indices, len(s.Shapes))
+		s.AddShape(triangle)
+	}
+	s.SetFastBuild(indices, true)
+	for frame := 0; frame < 3; frame++ {
+		if frame > 0 {
+			s.DeformShapes(indices, func() {
+				for _, triangle := range mesh {
+					for i := range triangle.Vertices {
+						triangle.Vertices[i].Y += 0.2 * float64(i-1)
+					}
+				}
+			})
+		}
+		built := s.TraceScene(48, 48)
+		structure := s.accelerator()
+		if instances := structure.(*accel.TwoLevel).Instances(); instances != 2 {
+			t.Fatalf("Frame %d: the mesh should have an instance of its own, but there are %d", frame, instances)
+		}
+		s.structure = nil
+		s.Settings.Accelerator = BVH
+		if !bytes.Equal(built.Pix, s.TraceScene(48, 48).Pix) {
+			t.Fatalf("Frame %d: the instance built fast should find the same shapes as a BVH", frame)
+		}
+		// The next frame updates the two level structure of this one
+		s.Settings.Accelerator = TwoLevel
+		s.structure = structure
+	}
+}
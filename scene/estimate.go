@@ -0,0 +1,224 @@
+package scene
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// estimatePixels is about the number of pixels Estimate traces
+const estimatePixels = 4096
+
+// Names of the costs of Estimate that aren't shapes
+const (
+	// CostLights is the cost of the pixels where camera rays hit a light
+	CostLights = "lights"
+	// CostBackground is the cost of the pixels where they hit nothing
+	CostBackground = "background"
+)
+
+// Estimate is the estimated cost of a render of a scene
+type Estimate struct {
+	// Width and Height are the size of the render, of which Pixels were
+	// traced to estimate it
+	Width, Height, Pixels int
+	// SamplesPerPixel and RaysPerSample are the averages of the pixels
+	// traced. Adaptive sampling may take fewer samples than the settings.
+	SamplesPerPixel, RaysPerSample float64
+	// Build holds the costs of building the structures of the scene
+	Build []Cost
+	// Trace holds the costs of tracing the pixels of the whole render on
+	// a single CPU, by what camera rays hit first, the most costly first.
+	// Features holds the same costs by the type and material of the shapes
+	// hit, such as "sphere glossy".
+	Trace, Features []Cost
+	// Memory is the number of bytes of the heap in use once the scene is
+	// built, which holds the scene and its structures
+	Memory uint64
+}
+
+// Cost is the time and memory something of a render takes. Time is in
+// nanoseconds in JSON.
+type Cost struct {
+	Name string        `json:"name"`
+	Time time.Duration `json:"time"`
+	// Memory is the number of bytes it keeps, if it's known
+	Memory uint64 `json:"memory,omitempty"`
+	// Pixels is the fraction of the pixels of the render it's in, for the
+	// costs of tracing
+	Pixels float64 `json:"pixels,omitempty"`
+}
+
+// TraceTime returns the estimated time to trace every pixel on one CPU
+func (e *Estimate) TraceTime() time.Duration {
+	var total time.Duration
+	for _, c := range e.Trace {
+		total += c.Time
+	}
+	return total
+}
+
+// BuildTime returns the time building the structures of the scene took
+func (e *Estimate) BuildTime() time.Duration {
+	var total time.Duration
+	for _, c := range e.Build {
+		total += c.Time
+	}
+	return total
+}
+
+// Estimate estimates the cost of a width x height render of the scene
+// without rendering it. It builds the structures of the scene as Prepare
+// does, measuring each one, and traces a sparse grid of pixels, charging
+// the time of each to what its camera ray hits first. The scene is left
+// prepared, so rendering it afterwards doesn't build them again.
+func (s *Scene) Estimate(width, height int) *Estimate {
+	e := &Estimate{Width: width, Height: height}
+	build := func(name string, f func()) {
+		before := heapInUse()
+		start := time.Now()
+		f()
+		elapsed := time.Since(start)
+		kept := uint64(0)
+		if after := heapInUse(); after > before {
+			kept = after - before
+		}
+		e.Build = append(e.Build, Cost{Name: name, Time: elapsed, Memory: kept})
+	}
+	build(s.Settings.accelerator(), func() { s.accelerator() })
+	if len(s.Lights) > manyLights {
+		build("light tree", func() { s.lightSampler() })
+	}
+	if s.rendersCaustics() {
+		build("caustics", func() { s.causticMap() })
+	}
+	if s.rendersProbes() {
+		build("reflection probes", func() { s.reflectionProbes() })
+	}
+	e.Memory = heapInUse()
+	s.estimateTrace(e)
+	return e
+}
+
+// ObjectCosts estimates the time tracing every pixel of a width x height
+// render of the scene takes on a single CPU, by the object that camera
+// rays hit first, as the Trace costs of Estimate do. It builds the
+// structures of the scene if they aren't, without measuring them, and
+// the pixels it traces are counted in the statistics of the scene.
+func (s *Scene) ObjectCosts(width, height int) []Cost {
+	s.Prepare()
+	e := &Estimate{Width: width, Height: height}
+	s.estimateTrace(e)
+	return e.Trace
+}
+
+// estimateTrace traces a sparse grid of the pixels of the render of the
+// estimate, setting its costs of tracing and its samples and rays
+func (s *Scene) estimateTrace(e *Estimate) {
+	width, height := e.Width, e.Height
+	// The grid is spaced the same in both directions, so every part of
+	// the image is sampled alike
+	stride := int(math.Max(1, math.Sqrt(float64(width*height)/estimatePixels)))
+	targetIt := s.Camera.GetIterator(width, height)
+	objects, features := make(map[string]*Cost), make(map[string]*Cost)
+	// Only the shapes hit are named, as naming some of them takes a while
+	names := make(map[shape.Shape][2]string, len(s.Shapes))
+	indices := make(map[shape.Shape]int, len(s.Shapes))
+	for i, sh := range s.Shapes {
+		indices[sh] = i
+	}
+	samples, rays := s.Stats()
+	for y := stride / 2; y < height; y += stride {
+		for x := stride / 2; x < width; x += stride {
+			lr := targetIt.Ray(x, y, 0.5, 0.5)
+			sh, name := s.firstHit(&lr)
+			feature := name
+			if sh != nil {
+				named, ok := names[sh]
+				if !ok {
+					material := shape.MaterialOf(sh).AsMap()["type"]
+					named = [2]string{shape.NameOf(sh, indices[sh]), fmt.Sprint(sh.AsMap()["type"], " ", material)}
+					names[sh] = named
+				}
+				name, feature = named[0], named[1]
+			}
+			start := time.Now()
+			s.TracePixel(targetIt, x, y)
+			elapsed := time.Since(start)
+			charge(objects, name, elapsed)
+			charge(features, feature, elapsed)
+			e.Pixels++
+		}
+	}
+	if e.Pixels == 0 {
+		return
+	}
+	// firstHit casts a ray of its own for every pixel
+	tracedSamples, tracedRays := s.Stats()
+	tracedSamples -= samples
+	tracedRays -= rays + uint64(e.Pixels)
+	e.SamplesPerPixel = float64(tracedSamples) / float64(e.Pixels)
+	if tracedSamples > 0 {
+		e.RaysPerSample = float64(tracedRays) / float64(tracedSamples)
+	}
+	e.Trace = scaled(objects, e.Pixels, width*height)
+	e.Features = scaled(features, e.Pixels, width*height)
+}
+
+// charge adds a pixel that took elapsed to trace to the cost named name
+func charge(costs map[string]*Cost, name string, elapsed time.Duration) {
+	c := costs[name]
+	if c == nil {
+		c = &Cost{Name: name}
+		costs[name] = c
+	}
+	c.Time += elapsed
+	c.Pixels++
+}
+
+// scaled returns the costs of traced of the pixels scaled to all of them,
+// the most costly first
+func scaled(costs map[string]*Cost, traced, pixels int) []Cost {
+	scale := float64(pixels) / float64(traced)
+	sorted := make([]Cost, 0, len(costs))
+	for _, c := range costs {
+		c.Time = time.Duration(float64(c.Time) * scale)
+		c.Pixels /= float64(traced)
+		sorted = append(sorted, *c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Time != sorted[j].Time {
+			return sorted[i].Time > sorted[j].Time
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// firstHit returns the shape of the scene the camera ray lr hits first,
+// the Delayed one for the shapes it loaded, or nil and CostLights or
+// CostBackground if it doesn't hit any
+func (s *Scene) firstHit(lr *math3d.LightRay) (shape.Shape, string) {
+	distance, sh, index := s.nearestShape(lr)
+	if lightDistance, _ := s.lightHit(lr); lightDistance < distance {
+		return nil, CostLights
+	}
+	if sh == nil {
+		return nil, CostBackground
+	}
+	return s.Shapes[index], ""
+}
+
+// heapInUse returns the bytes of the heap in use after collecting the
+// garbage
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
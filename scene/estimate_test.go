@@ -0,0 +1,40 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestEstimateChargesPixelsToWhatTheyHit(t *testing.T) {
+	s := New()
+	s.Settings.Samples = 2
+	s.AddShape(&shape.Sphere{Name: "ball", Position: math3d.Vector3{Z: 3}, Radius: 0.3})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.White})
+	e := s.Estimate(128, 128)
+	if e.Pixels != 64*64 || e.SamplesPerPixel != 2 || e.RaysPerSample < 1 {
+		t.Errorf("Every other pixel should be traced with 2 samples, the estimate is %+v", e)
+	}
+	if len(e.Build) != 1 || e.Build[0].Name != "bvh" {
+		t.Errorf("Only the BVH needs building, the estimate builds %+v", e.Build)
+	}
+	pixels := map[string]float64{}
+	total := 0.0
+	for _, c := range e.Trace {
+		pixels[c.Name] = c.Pixels
+		total += c.Pixels
+	}
+	if len(pixels) != 2 || pixels["ball"] == 0 || pixels[CostBackground] <= pixels["ball"] || math.Abs(total-1) > 1e-9 {
+		t.Errorf("The ball should cover some of the pixels, and the background the rest, not %v", pixels)
+	}
+	if len(e.Features) != 2 || e.Features[0].Name != "sphere lambertian" && e.Features[1].Name != "sphere lambertian" {
+		t.Errorf("The features should be the lambertian sphere and the background, not %+v", e.Features)
+	}
+	if e.TraceTime() <= 0 {
+		t.Error("Tracing the render should take some time")
+	}
+}
@@ -0,0 +1,89 @@
+package scene
+
+import (
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// HitFilter decides whether the lightray hitting a shape at distance
+// counts. The lightrays go on past the hits it rejects, as if the shape
+// weren't there. It's called for the camera rays and the shadow rays
+// alike, by many goroutines at once.
+type HitFilter func(lr *math3d.LightRay, distance float64) bool
+
+// SetFilter makes the hits on the shape at index count only if f accepts
+// them, such as to prune geometry stochastically or to cut sections away,
+// or makes them all count again if f is nil. It must not be called while
+// the scene is traced.
+func (s *Scene) SetFilter(index int, f HitFilter) {
+	s.mustBeEditable()
+	sh := s.Shapes[index]
+	if f == nil {
+		delete(s.filters, sh)
+	} else {
+		if s.filters == nil {
+			s.filters = make(map[shape.Shape]HitFilter)
+		}
+		s.filters[sh] = f
+	}
+	s.markDirty(sh.Bounds())
+	if s.structure != nil {
+		s.installFilter()
+	}
+}
+
+// installFilter makes the acceleration structure and the delayed shapes
+// filter the hits with the filters of the shapes and the section
+func (s *Scene) installFilter() {
+	f := s.filter()
+	s.structure.Filter(f)
+	for i, sh := range s.Shapes {
+		if d, ok := sh.(*Delayed); ok {
+			d.filter(delayedFilter(f, i))
+		}
+	}
+}
+
+// filter returns the filter of the acceleration structure that calls the
+// filters of the shapes and cuts away what the section doesn't keep, or
+// nil if there is nothing to filter
+func (s *Scene) filter() accel.Filter {
+	section := s.Section
+	if len(s.filters) == 0 && section == nil {
+		return nil
+	}
+	filters := make([]HitFilter, len(s.Shapes))
+	for i, sh := range s.Shapes {
+		filters[i] = s.filters[sh]
+	}
+	return func(index int, lr *math3d.LightRay, distance float64) bool {
+		if section != nil {
+			if hit := lr.Source.AddV(lr.Direction.MultiplyV(distance)); !section.keeps(&hit) {
+				return false
+			}
+		}
+		f := filters[index]
+		return f == nil || f(lr, distance)
+	}
+}
+
+// delayedFilter returns the filter of the shapes of the delayed shape at
+// index, which are filtered like it, or nil if f is nil
+func delayedFilter(f accel.Filter, index int) accel.Filter {
+	if f == nil {
+		return nil
+	}
+	return func(_ int, lr *math3d.LightRay, distance float64) bool {
+		return f(index, lr, distance)
+	}
+}
+
+// Cutaway returns a filter that cuts away the part of a shape in front of
+// the plane through point with the normal, showing its insides
+func Cutaway(point, normal math3d.Vector3) HitFilter {
+	return func(lr *math3d.LightRay, distance float64) bool {
+		hit := lr.Source.AddV(lr.Direction.MultiplyV(distance))
+		return hit.SubtractV(point).DotV(normal) <= 0
+	}
+}
@@ -0,0 +1,34 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestCutawayFilter(t *testing.T) {
+	s, _, err := ParseScene([]byte(validScene), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr := math3d.LightRay{Source: s.Camera.FocalPoint, Direction: math3d.UnitZ}
+	if distance, _ := s.getNearestIntersection(&lr); math.Abs(distance-3) > 1e-6 {
+		t.Fatalf("The ray should hit the front of the sphere at 3, not at %g", distance)
+	}
+	// The half of the sphere facing the camera is cut away
+	s.SetFilter(0, Cutaway(math3d.Vector3{Z: 3}, math3d.Vector3{Z: -1}))
+	if distance, sh := s.getNearestIntersection(&lr); sh != s.Shapes[0] || math.Abs(distance-5) > 1e-6 {
+		t.Errorf("The ray should see the inside of the back of the sphere at 5, not at %g", distance)
+	}
+	if s.DirtyRegion(16, 16).Empty() {
+		t.Error("Filtering the sphere should change where it's seen")
+	}
+	if s.inShadow(&lr, 4) {
+		t.Error("The cut half of the sphere shouldn't cast shadows")
+	}
+	s.SetFilter(0, nil)
+	if distance, _ := s.getNearestIntersection(&lr); math.Abs(distance-3) > 1e-6 {
+		t.Errorf("Without the filter the ray should hit the front of the sphere again, not at %g", distance)
+	}
+}
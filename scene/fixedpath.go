@@ -0,0 +1,106 @@
+package scene
+
+import (
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// FixedPathTracer is the integrator that traces a single path from the
+// camera, bouncing MaxDepth times, and gathers the light that bounces from
+// MinDepth to MaxDepth times on its way to the camera. It's meant for
+// validating renders against analytical solutions and other renderers:
+// paths are never cut short at random, so every bounce count gets the same
+// number of samples, and the paths don't depend on the depths gathered, so
+// renders of the depths one at a time add up to a render of all of them.
+//
+// The lights are sampled at every vertex, so paths end at the lights they
+// hit without adding their light again. Depth 0 is the light of the lights
+// the camera sees. Specular materials scatter the light without a vertex
+// of their own, but the bounce counts. Surfaces are two sided, the medium
+// is ignored, and subsurface materials reflect the light as lambertian
+// ones with their albedo.
+type FixedPathTracer struct {
+	// MinDepth and MaxDepth are the fewest and the most times that the
+	// light gathered bounces
+	MinDepth, MaxDepth int
+	// Clamp is the most luminance of the light that bounces more than
+	// once, none if it's 0
+	Clamp float64
+}
+
+// Radiance returns the radiance arriving at the source of lr after
+// bouncing from MinDepth to MaxDepth times
+func (f *FixedPathTracer) Radiance(s *Scene, lr *math3d.LightRay, rng *sampling.Rand) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	// The path goes on in the same lightray, hit and vectors
+	ray, differential := sc.ray(*lr), sc.differential(math3d.Differential{})
+	hit, out, origin, next := sc.hit(shape.Hit{}), sc.vector(math3d.Vector3{}), sc.vector(math3d.Vector3{}), sc.vector(math3d.Vector3{})
+	distance, sh := s.getNearestIntersection(ray)
+	if lightDistance, emitted := s.nearestLight(ray); lightDistance < distance {
+		if f.MinDepth == 0 {
+			return emitted
+		}
+		return image.Black
+	}
+	radiance := image.Color{}
+	beta := image.White
+	for depth := 1; depth <= f.MaxDepth && sh != nil; depth++ {
+		*hit = shape.HitAt(sh, ray, distance)
+		*out = ray.Direction.MultiplyV(-1)
+		surface := shape.MaterialAtHit(sh, hit, out)
+		if specular, ok := surface.(material.Specular); ok {
+			// No light can be sampled through a specular surface, so the
+			// light it scatters is the light of the lights it sees
+			weight := s.scatterSpecular(specular, hit, ray, rng, ray, differential)
+			beta = *beta.CMultiply(&weight)
+			distance, sh = s.getNearestIntersection(ray)
+			if lightDistance, emitted := s.nearestLight(ray); lightDistance < distance {
+				if depth >= f.MinDepth {
+					gathered := clampIndirect(*emitted.CMultiply(&beta), depth, f.Clamp)
+					radiance = *radiance.Add(&gathered)
+				}
+				break
+			}
+			continue
+		}
+		*origin = shape.ShadowOrigin(sh, &hit.Point)
+		if hit.Backface {
+			hit.Normal, *origin = hit.Normal.MultiplyV(-1), hit.Point
+		}
+		mat := scatteringMaterial(surface)
+		// The lights are sampled even at the depths that aren't gathered,
+		// so the paths use the same random numbers whatever they gather
+		direct := image.Color{}
+		s.forLights(origin, &hit.Normal, rng, func(ls lighting.Light, weight float64) {
+			light := s.directLight(sh, origin, &hit.Normal, out, mat, ls, ray.Time, rng)
+			direct = *direct.Add(light.Multiply(weight))
+		})
+		if depth >= f.MinDepth {
+			gathered := clampIndirect(*direct.CMultiply(&beta), depth, f.Clamp)
+			radiance = *radiance.Add(&gathered)
+		}
+		if depth == f.MaxDepth {
+			break
+		}
+
+		var pdf float64
+		*next, pdf = mat.SampleDirection(&hit.Normal, out, rng.Float64(), rng.Float64())
+		cosine := next.DotV(hit.Normal)
+		if pdf == 0 || cosine <= 0 {
+			break
+		}
+		brdf := mat.BRDF(&hit.Normal, next, out)
+		beta = *brdf.CMultiply(&beta).Multiply(cosine / pdf)
+		*ray = math3d.LightRay{Source: s.leaving(origin, &hit.Normal, next), Direction: *next, Origin: sh, Time: ray.Time, Wavelength: ray.Wavelength, Dispersed: ray.Dispersed}
+		distance, sh = s.getNearestIntersection(ray)
+		if lightDistance, _ := s.lightHit(ray); lightDistance < distance {
+			break
+		}
+	}
+	return radiance
+}
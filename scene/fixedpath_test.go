@@ -0,0 +1,131 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestFixedPathTracerMatchesTheSphereFurnace(t *testing.T) {
+	// Inside a closed lambertian sphere lit from its center, every point of
+	// the wall sees the same light and the same part of the wall, so the
+	// light bouncing k times is the direct light times albedo^(k-1)
+	const albedo, radius = 0.6, 1.0
+	s := New()
+	s.AddShape(&shape.Sphere{Radius: radius, Material: &material.Lambertian{Albedo: image.Color{R: albedo, G: albedo, B: albedo}}})
+	s.AddLight(&lighting.PointLight{Intensity: image.White})
+	view := &math3d.LightRay{Source: math3d.Vector3{X: 0.3, Y: -0.2}, Direction: math3d.Vector3{X: 1, Y: 1, Z: 1}.NormalizedV()}
+	rng := sampling.New(1, 0)
+	direct := albedo / math.Pi / (radius * radius)
+	for depth := 1; depth <= 4; depth++ {
+		c := (&FixedPathTracer{MinDepth: depth, MaxDepth: depth}).Radiance(s, view, rng)
+		if expected := direct * math.Pow(albedo, float64(depth-1)); math.Abs(c.R-expected) > 1e-9 {
+			t.Errorf("The light bouncing %d times should be %g, it's %g", depth, expected, c.R)
+		}
+	}
+	all := (&FixedPathTracer{MinDepth: 1, MaxDepth: 50}).Radiance(s, view, rng)
+	if expected := direct * (1 - math.Pow(albedo, 50)) / (1 - albedo); math.Abs(all.R-expected) > 1e-9 {
+		t.Errorf("The light bouncing up to 50 times should be %g, it's %g", expected, all.R)
+	}
+}
+
+func TestIndirectClamp(t *testing.T) {
+	const albedo = 0.6
+	s := New()
+	s.AddShape(&shape.Sphere{Radius: 1, Material: &material.Lambertian{Albedo: image.Color{R: albedo, G: albedo, B: albedo}}})
+	s.AddLight(&lighting.PointLight{Intensity: image.White})
+	view := &math3d.LightRay{Source: math3d.Vector3{X: 0.3, Y: -0.2}, Direction: math3d.Vector3{X: 1, Y: 1, Z: 1}.NormalizedV()}
+	direct := albedo / math.Pi
+	// The direct light is above the clamp, but only the light bouncing
+	// twice, direct * albedo, is dimmed to it
+	const clamp = 0.05
+	for depth, expected := range []float64{1: direct, 2: clamp} {
+		if depth == 0 {
+			continue
+		}
+		c := (&FixedPathTracer{MinDepth: depth, MaxDepth: depth, Clamp: clamp}).Radiance(s, view, sampling.New(1, 0))
+		if math.Abs(c.R-expected) > 1e-9 {
+			t.Errorf("The light bouncing %d times should be %g, it's %g", depth, expected, c.R)
+		}
+	}
+
+	// A floor lit by a small bright light, and by the light it reflects on
+	// a wall
+	s = New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: -101}, Radius: 100})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 0.5, Y: 1}, Radius: 0.2, Radiance: image.Color{R: 20, G: 20, B: 20}})
+	view = &math3d.LightRay{Source: math3d.Vector3{X: -0.5, Y: 3}, Direction: math3d.Vector3{Y: -1}}
+	dimmed := false
+	for seed := uint64(0); seed < 20; seed++ {
+		direct := (&BidirectionalPathTracer{MaxDepth: 1, Clamp: clamp}).Radiance(s, view, sampling.New(seed, 0))
+		if unclamped := (&BidirectionalPathTracer{MaxDepth: 1}).Radiance(s, view, sampling.New(seed, 0)); direct != unclamped {
+			t.Fatalf("The light bouncing once shouldn't be clamped, it's %v instead of %v", direct, unclamped)
+		}
+		clamped := (&BidirectionalPathTracer{MaxDepth: 3, Clamp: clamp}).Radiance(s, view, sampling.New(seed, 0))
+		unclamped := (&BidirectionalPathTracer{MaxDepth: 3}).Radiance(s, view, sampling.New(seed, 0))
+		// Each of the 7 ways of finding the light bouncing 2 and 3 times,
+		// hitting the light, sampling it and joining the paths, brings at
+		// most the clamp
+		if clamped.R > unclamped.R+1e-12 || clamped.R > direct.R+7*clamp+1e-12 {
+			t.Fatalf("The clamped light should be at most %g, it's %g", math.Min(unclamped.R, direct.R+7*clamp), clamped.R)
+		}
+		dimmed = dimmed || clamped.R < unclamped.R
+	}
+	if !dimmed {
+		t.Error("Some of the light bouncing more than once should be brighter than the clamp")
+	}
+}
+
+func TestFixedPathDepthsAddUp(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 1}, Radius: 1,
+		Material: &material.Glossy{Albedo: image.Color{R: 0.8, G: 0.8, B: 0.8}, Exponent: 20}})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 1, Y: 4}, Radius: 0.5, Radiance: image.Color{R: 10, G: 10, B: 10}})
+	for _, x := range []float64{1.5, -1.5, 0.3} {
+		view := &math3d.LightRay{Source: math3d.Vector3{X: x, Y: 3}, Direction: math3d.Vector3{Y: -1}}
+		for seed := uint64(0); seed < 20; seed++ {
+			all := (&FixedPathTracer{MinDepth: 0, MaxDepth: 3}).Radiance(s, view, sampling.New(seed, 0))
+			sum := 0.0
+			for depth := 0; depth <= 3; depth++ {
+				c := (&FixedPathTracer{MinDepth: depth, MaxDepth: depth}).Radiance(s, view, sampling.New(seed, 0))
+				sum += c.R
+			}
+			if math.Abs(sum-all.R) > 1e-9*math.Max(1, all.R) {
+				t.Fatalf("The depths one at a time add up to %g, but all of them at once give %g", sum, all.R)
+			}
+		}
+	}
+
+	// Bouncing once, it's the direct lighting
+	view := &math3d.LightRay{Source: math3d.Vector3{X: 1.5, Y: 3}, Direction: math3d.Vector3{Y: -1}}
+	rng := sampling.New(1, 0)
+	once := &FixedPathTracer{MinDepth: 1, MaxDepth: 1}
+	mean, variance := estimate(20000, func() float64 {
+		c := once.Radiance(s, view, rng)
+		return c.R
+	})
+	direct, directVariance := estimate(20000, func() float64 {
+		c := DirectLighting{}.Radiance(s, view, rng)
+		return c.R
+	})
+	if math.Abs(mean-direct) > 4*math.Sqrt((variance+directVariance)/20000) {
+		t.Errorf("With a single bounce the estimate %f should match the direct lighting %f", mean, direct)
+	}
+
+	// The light itself is only seen at depth 0
+	atLight := &math3d.LightRay{Source: math3d.Vector3{X: 1, Y: 6}, Direction: math3d.Vector3{Y: -1}}
+	if c := (&FixedPathTracer{MinDepth: 0, MaxDepth: 0}).Radiance(s, atLight, rng); c.R != 10 {
+		t.Errorf("At depth 0 the camera should see the radiance of the light, it sees %s", c.String())
+	}
+	if c := (&FixedPathTracer{MinDepth: 1, MaxDepth: 3}).Radiance(s, atLight, rng); c.Luminance() != 0 {
+		t.Errorf("Past depth 0 the light the camera sees shouldn't be gathered, it's %s", c.String())
+	}
+}
@@ -0,0 +1,287 @@
+package scene
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Node is a node of the scene graph, which groups shapes under a name so
+// that they can be found and moved together, as the hierarchies of
+// modelling tools and glTF files do. A node is placed by its transform
+// relative to its parent, or to the scene at the top of the graph, and its
+// shapes and children are placed relative to it. The shapes are in the
+// Shapes of the scene too, already transformed to where the node puts
+// them.
+type Node struct {
+	// Name names the node among its siblings. It can't have slashes,
+	// which separate the names of the paths of the nodes.
+	Name     string
+	Children []*Node
+	// local is the transform relative to the parent, and world the one
+	// relative to the scene, which the shapes were transformed by
+	local, world math3d.Matrix
+	shapes       []shape.Shape
+	parent       *Node
+}
+
+// NewNode returns a node without shapes or children, placed by the local
+// transform relative to its parent
+func NewNode(name string, local math3d.Matrix) *Node {
+	return &Node{Name: name, local: local, world: local}
+}
+
+// Local returns the transform of the node relative to its parent
+func (n *Node) Local() math3d.Matrix {
+	return n.local
+}
+
+// World returns the transform of the node relative to the scene
+func (n *Node) World() math3d.Matrix {
+	return n.world
+}
+
+// Shapes returns the shapes of the node itself, without the ones of its
+// children
+func (n *Node) Shapes() []shape.Shape {
+	return n.shapes
+}
+
+// Parent returns the parent of the node, or nil if it's at the top of the
+// scene graph
+func (n *Node) Parent() *Node {
+	return n.parent
+}
+
+// Path returns the path of the node: the names of the nodes from the top of
+// the scene graph down to it, separated by slashes, such as
+// "robot/arm/hand"
+func (n *Node) Path() string {
+	if n.parent == nil {
+		return n.Name
+	}
+	return n.parent.Path() + "/" + n.Name
+}
+
+// Walk calls visit with the node and its descendants, parents first
+func (n *Node) Walk(visit func(*Node)) {
+	visit(n)
+	for _, child := range n.Children {
+		child.Walk(visit)
+	}
+}
+
+// child returns the child of the node with the name
+func child(nodes []*Node, name string) *Node {
+	for _, n := range nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// validNodeName returns why a node can't have the name among its
+// siblings, or an empty string if it can
+func validNodeName(siblings []*Node, name string) string {
+	switch {
+	case name == "":
+		return "the name can't be empty"
+	case strings.Contains(name, "/"):
+		return "the name can't have slashes"
+	case child(siblings, name) != nil:
+		return fmt.Sprintf("there's another node named %s", name)
+	}
+	return ""
+}
+
+// Node returns the node at the path, the names of the nodes from the top of
+// the scene graph down to it separated by slashes, and whether there's one
+func (s *Scene) Node(path string) (*Node, bool) {
+	nodes := s.Nodes
+	var n *Node
+	for _, name := range strings.Split(path, "/") {
+		if n = child(nodes, name); n == nil {
+			return nil, false
+		}
+		nodes = n.Children
+	}
+	return n, true
+}
+
+// NodeNamed returns the first node with the name, parents first and in the
+// order of the children, and whether there's one, to find the nodes whose
+// names are unique in the scene without their paths
+func (s *Scene) NodeNamed(name string) (*Node, bool) {
+	var found *Node
+	for _, root := range s.Nodes {
+		root.Walk(func(n *Node) {
+			if found == nil && n.Name == name {
+				found = n
+			}
+		})
+	}
+	return found, found != nil
+}
+
+// AddNode adds the node to the scene graph, as a child of parent or at the
+// top of the graph if parent is nil, with the shapes, which are placed
+// relative to it and added to the scene. It fails, leaving the scene as it
+// was, if the name of the node isn't valid among its siblings, it already
+// has shapes or children, or a shape can't be transformed to where it
+// puts it.
+func (s *Scene) AddNode(parent *Node, n *Node, shapes ...shape.Shape) error {
+	if s.shared {
+		return fmt.Errorf("the views of a prepared scene can't be edited")
+	}
+	siblings := s.Nodes
+	n.world = n.local
+	if parent != nil {
+		siblings = parent.Children
+		n.world = *parent.world.ComposeMatrix(&n.local)
+	}
+	if problem := validNodeName(siblings, n.Name); problem != "" {
+		return fmt.Errorf("%s", problem)
+	}
+	if len(n.shapes) > 0 || len(n.Children) > 0 {
+		return fmt.Errorf("the node %s must be empty", n.Name)
+	}
+	for _, sh := range shapes {
+		if !canTransform(sh, &n.world) {
+			return fmt.Errorf("shapes of type %T can only be moved, not rotated or scaled", sh)
+		}
+	}
+	n.parent = parent
+	if parent != nil {
+		parent.Children = append(parent.Children, n)
+	} else {
+		s.Nodes = append(s.Nodes, n)
+	}
+	for _, sh := range shapes {
+		transformShape(sh, &n.world)
+		n.shapes = append(n.shapes, sh)
+		s.AddShape(sh)
+	}
+	return nil
+}
+
+// SetNodeTransform sets the transform of the node relative to its parent,
+// moving its shapes and the ones of its descendants along, as
+// TransformShape does. It fails, leaving the scene as it was, if the
+// transform isn't affine or can't be inverted, or it would rotate or scale
+// shapes that can only be moved.
+func (s *Scene) SetNodeTransform(n *Node, local math3d.Matrix) error {
+	if s.shared {
+		return fmt.Errorf("the views of a prepared scene can't be edited")
+	}
+	if _, ok := local.Inverse(); !ok || !local.Affine() {
+		return fmt.Errorf("the transform of %s must be affine and invertible", n.Path())
+	}
+	// The shapes of every node are transformed from where the old world
+	// transform put them to where the new one does
+	worlds := make(map[*Node]math3d.Matrix)
+	var place func(n *Node, parentWorld *math3d.Matrix, local math3d.Matrix)
+	place = func(n *Node, parentWorld *math3d.Matrix, local math3d.Matrix) {
+		world := local
+		if parentWorld != nil {
+			world = *parentWorld.ComposeMatrix(&local)
+		}
+		worlds[n] = world
+		for _, child := range n.Children {
+			place(child, &world, child.local)
+		}
+	}
+	var parentWorld *math3d.Matrix
+	if n.parent != nil {
+		parentWorld = &n.parent.world
+	}
+	place(n, parentWorld, local)
+	deltas := make(map[*Node]math3d.Matrix, len(worlds))
+	for node, world := range worlds {
+		// World transforms are invertible, as the local ones are
+		inverse, _ := node.world.Inverse()
+		deltas[node] = *world.ComposeMatrix(&inverse)
+		for _, sh := range node.shapes {
+			delta := deltas[node]
+			if !canTransform(sh, &delta) {
+				return fmt.Errorf("%s has shapes of type %T, which can only be moved, not rotated or scaled", node.Path(), sh)
+			}
+		}
+	}
+	indices := make(map[shape.Shape]int, len(s.Shapes))
+	for i, sh := range s.Shapes {
+		indices[sh] = i
+	}
+	n.local = local
+	for node, world := range worlds {
+		node.world = world
+		delta := deltas[node]
+		for _, sh := range node.shapes {
+			if i, ok := indices[sh]; ok {
+				s.TransformShape(i, &delta)
+			}
+		}
+	}
+	return nil
+}
+
+// nodeShapes returns the shapes of the nodes of the scene graph
+func (s *Scene) nodeShapes() map[shape.Shape]bool {
+	shapes := make(map[shape.Shape]bool)
+	for _, root := range s.Nodes {
+		root.Walk(func(n *Node) {
+			for _, sh := range n.shapes {
+				shapes[sh] = true
+			}
+		})
+	}
+	return shapes
+}
+
+// asMap returns the node in the scene file format, with its shapes where
+// they are relative to it
+func (n *Node) asMap() map[string]interface{} {
+	m := map[string]interface{}{"name": n.Name}
+	if n.local != math3d.IdentityMatrix() {
+		m["transform"] = n.local.AsSlice()
+	}
+	if len(n.shapes) > 0 {
+		inverse, _ := n.world.Inverse()
+		shapes := make([]map[string]interface{}, 0, len(n.shapes))
+		for _, sh := range n.shapes {
+			shapes = append(shapes, localShapeMap(sh, &n.world, &inverse))
+		}
+		m["shapes"] = shapes
+	}
+	if len(n.Children) > 0 {
+		children := make([]map[string]interface{}, 0, len(n.Children))
+		for _, child := range n.Children {
+			children = append(children, child.asMap())
+		}
+		m["children"] = children
+	}
+	return m
+}
+
+// localShapeMap returns the map of a shape of a node with the world
+// transform, undoing the transform: the shapes that can be transformed
+// are transformed back by the inverse, and the ones that can only be moved
+// are moved back
+func localShapeMap(sh shape.Shape, world, inverse *math3d.Matrix) map[string]interface{} {
+	m := sh.AsMap()
+	if *world == math3d.IdentityMatrix() {
+		return m
+	}
+	if _, ok := sh.(shape.Transformable); ok {
+		m["transform"] = inverse.AsSlice()
+		return m
+	}
+	if position, ok := m["position"].(map[string]float64); ok {
+		offset, _ := world.Translation()
+		local := math3d.Vector3{X: position["x"], Y: position["y"], Z: position["z"]}.SubtractV(offset)
+		m["position"] = local.AsMap()
+	}
+	return m
+}
@@ -0,0 +1,136 @@
+package scene
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// robotScene has a robot whose arm turns a quarter around Y and holds a
+// hand one unit along it, with a triangle of the hand at its origin
+const robotScene = `"nodes": [{
+	"name": "robot",
+	"transform": {"translate": {"x": 0, "y": 0, "z": 5}},
+	"shapes": [{"type": "sphere", "name": "head", "position": {"x": 0, "y": 1, "z": 0}, "radius": 0.5}],
+	"children": [{
+		"name": "arm",
+		"transform": {"rotate": {"x": 0, "y": 90, "z": 0}},
+		"children": [{
+			"name": "hand",
+			"transform": {"translate": {"x": 1, "y": 0, "z": 0}},
+			"shapes": [{"type": "triangle", "name": "finger", "vertices": [{"x": 0, "y": 0, "z": 0}, {"x": 0.1, "y": 0, "z": 0}, {"x": 0, "y": 0.1, "z": 0}]}]
+		}]
+	}]
+}], "lights"`
+
+func parseRobot(t *testing.T) *Scene {
+	s, _, err := ParseScene([]byte(strings.Replace(validScene, `"lights"`, robotScene, 1)), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestNodesPlaceTheirShapes(t *testing.T) {
+	s := parseRobot(t)
+	if len(s.Shapes) != 3 || len(s.Nodes) != 1 {
+		t.Fatalf("The scene should have 3 shapes and a node at the top, not %d and %d", len(s.Shapes), len(s.Nodes))
+	}
+	hand, ok := s.Node("robot/arm/hand")
+	if !ok || hand.Path() != "robot/arm/hand" {
+		t.Fatal("The hand should be found by its path")
+	}
+	if named, ok := s.NodeNamed("hand"); !ok || named != hand {
+		t.Error("The hand should be found by its name")
+	}
+	if _, ok := s.Node("robot/hand"); ok {
+		t.Error("The hand isn't a child of the robot")
+	}
+	head := s.Shapes[1].(*shape.Sphere)
+	if want := (math3d.Vector3{Y: 1, Z: 5}); !head.Position.Equal(&want) {
+		t.Errorf("The head should be at %s, not %s", want.String(), head.Position.String())
+	}
+	// Turning X a quarter around Y takes it to -Z
+	finger := hand.Shapes()[0].(*shape.Triangle)
+	if want := (math3d.Vector3{Z: 4}); !finger.Vertices[0].Equal(&want) {
+		t.Errorf("The finger should be at %s, not %s", want.String(), finger.Vertices[0].String())
+	}
+
+	// Straightening the arm moves the hand along
+	arm, _ := s.Node("robot/arm")
+	if err := s.SetNodeTransform(arm, math3d.IdentityMatrix()); err != nil {
+		t.Fatal(err)
+	}
+	if want := (math3d.Vector3{X: 1, Z: 5}); !finger.Vertices[0].Equal(&want) {
+		t.Errorf("The finger should have moved to %s, not %s", want.String(), finger.Vertices[0].String())
+	}
+	// The head can be moved, but not turned
+	robot, _ := s.Node("robot")
+	if err := s.SetNodeTransform(robot, math3d.RotationMatrix(math3d.AxisAngle(math3d.UnitX, 1))); err == nil {
+		t.Error("The sphere of the robot can't be turned")
+	}
+	if err := s.SetNodeTransform(robot, math3d.TranslationMatrix(math3d.Vector3{Z: 6})); err != nil {
+		t.Fatal(err)
+	}
+	if want := (math3d.Vector3{Y: 1, Z: 6}); !head.Position.Equal(&want) {
+		t.Errorf("The head should have moved to %s, not %s", want.String(), head.Position.String())
+	}
+	if want := (math3d.Vector3{X: 1, Z: 6}); !finger.Vertices[0].Equal(&want) {
+		t.Errorf("The finger should have moved with the robot to %s, not %s", want.String(), finger.Vertices[0].String())
+	}
+	lr := math3d.LightRay{Source: math3d.Vector3{Y: 1}, Direction: math3d.Vector3{Z: 1}}
+	if distance, sh := s.getNearestIntersection(&lr); sh != head || distance != 5.5 {
+		t.Errorf("The lightray should hit the moved head at 5.5, not %v at %g", sh, distance)
+	}
+}
+
+func TestNodesSurviveMarshaling(t *testing.T) {
+	s := parseRobot(t)
+	data, err := s.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := ParseScene(data, Strict)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	if len(decoded.Shapes) != len(s.Shapes) {
+		t.Fatalf("The scene should keep its %d shapes, not %d", len(s.Shapes), len(decoded.Shapes))
+	}
+	hand, ok := decoded.Node("robot/arm/hand")
+	if !ok {
+		t.Fatal("The hand should be kept")
+	}
+	finger := hand.Shapes()[0].(*shape.Triangle)
+	if want := (math3d.Vector3{Z: 4}); !finger.Vertices[0].Equal(&want) {
+		t.Errorf("The finger should stay at %s, not %s", want.String(), finger.Vertices[0].String())
+	}
+	robot, _ := decoded.NodeNamed("robot")
+	if position := robot.Shapes()[0].(*shape.Sphere).Position; !position.Equal(&math3d.Vector3{Y: 1, Z: 5}) {
+		t.Errorf("The head should stay where it was, not at %s", position.String())
+	}
+}
+
+func TestInvalidNodes(t *testing.T) {
+	for _, invalid := range []string{
+		`"nodes": {}, "lights"`,
+		`"nodes": [{"transform": {}}], "lights"`,
+		`"nodes": [{"name": "a/b"}], "lights"`,
+		`"nodes": [{"name": "a"}, {"name": "a"}], "lights"`,
+		`"nodes": [{"name": "a", "colour": "red"}], "lights"`,
+		`"nodes": [{"name": "a", "transform": {"scale": 0}}], "lights"`,
+		`"nodes": [{"name": "a", "transform": {"scale": 2}, "shapes": [{"type": "sphere", "position": {"x": 0, "y": 0, "z": 0}, "radius": 1}]}], "lights"`,
+		`"nodes": [{"name": "a", "shapes": [{"type": "delayed", "bounds": {"min": {"x": 0, "y": 0, "z": 0}, "max": {"x": 1, "y": 1, "z": 1}}, "shape": {"type": "sphere", "position": {"x": 0, "y": 0, "z": 0}, "radius": 1}}]}], "lights"`,
+		`"nodes": [{"name": "a", "children": [{"name": "b"}, {"name": "b"}]}], "lights"`,
+	} {
+		data := []byte(strings.Replace(validScene, `"lights"`, invalid, 1))
+		if _, _, err := ParseScene(data, Strict); err == nil {
+			t.Errorf("%s should be an error", invalid)
+		}
+		if s, warnings, err := ParseScene(data, Lenient); err != nil || len(warnings) == 0 || len(s.Shapes) != 1 {
+			t.Errorf("%s should be skipped with a warning: %v %v", invalid, err, warnings)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package scene
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+// HiddenLineIntegrator is the integrator of line drawings, such as the
+// figures of patents and technical documents. Everything is as white as
+// paper, and the scene draws the lines of the outlines over it: the
+// silhouettes of the shapes, their creases and the lines where they meet,
+// leaving out the ones that other shapes hide.
+type HiddenLineIntegrator struct{}
+
+// Radiance returns white, the paper the lines are drawn on
+func (hl HiddenLineIntegrator) Radiance(s *Scene, lr *math3d.LightRay, rng *sampling.Rand) image.Color {
+	return image.White
+}
+
+// Lines are the lines of a hidden line drawing of the scene: the pixels
+// the outlines go through
+type Lines struct {
+	Width, Height int
+	// LineWidth is how many pixels wide the lines are
+	LineWidth float64
+	// on holds whether every pixel is on a line, row after row
+	on []bool
+}
+
+// At returns whether the pixel x, y is on a line
+func (l *Lines) At(x, y int) bool {
+	return x >= 0 && x < l.Width && y >= 0 && y < l.Height && l.on[y*l.Width+x]
+}
+
+// TraceLines traces the lines of a drawing of width x height of the scene,
+// the outlines that the hidden line and the toon integrators draw, through
+// the center of every pixel. They are as wide as the outlines of the
+// settings, or 1 pixel if they have none.
+func (s *Scene) TraceLines(width, height int) *Lines {
+	s.Prepare()
+	lines := &Lines{Width: width, Height: height, LineWidth: s.Settings.OutlineWidth, on: make([]bool, width*height)}
+	if lines.LineWidth == 0 {
+		lines.LineWidth = 1
+	}
+	targetIt := s.Camera.GetIterator(width, height)
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range rows {
+				for x := 0; x < width; x++ {
+					lr := targetIt.Ray(x, y, 0.5, 0.5)
+					lines.on[y*width+x] = s.onOutline(targetIt, x, y, 0.5, 0.5, lines.LineWidth, &lr)
+				}
+			}
+		}()
+	}
+	for y := 0; y < height; y++ {
+		rows <- y
+	}
+	close(rows)
+	wg.Wait()
+	return lines
+}
+
+// svgTolerance is how many pixels the strokes of SVG drawings may stray
+// from the centers of the pixels of their lines
+const svgTolerance = 0.5
+
+// WriteSVG writes the lines as an SVG drawing of black strokes on white,
+// of the size of the drawing they were traced for. Every line is a stroke
+// through the centers of its pixels, straightened where they don't stray
+// from a straight segment by more than svgTolerance, and lone pixels are
+// dots, so the drawing stays sharp at any size it's printed at.
+func (l *Lines) WriteSVG(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		l.Width, l.Height, l.Width, l.Height)
+	fmt.Fprintf(out, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", l.Width, l.Height)
+	fmt.Fprintf(out, "<path fill=\"none\" stroke=\"black\" stroke-width=\"%g\" stroke-linecap=\"round\" stroke-linejoin=\"round\" d=\"", l.LineWidth)
+	for _, polyline := range l.polylines() {
+		polyline = simplify(polyline, svgTolerance)
+		fmt.Fprintf(out, "M%g %g", float64(polyline[0][0])+0.5, float64(polyline[0][1])+0.5)
+		if len(polyline) == 1 {
+			fmt.Fprint(out, "h0")
+			continue
+		}
+		fmt.Fprint(out, "L")
+		for i, p := range polyline[1:] {
+			if i > 0 {
+				fmt.Fprint(out, " ")
+			}
+			fmt.Fprintf(out, "%g %g", float64(p[0])+0.5, float64(p[1])+0.5)
+		}
+	}
+	fmt.Fprint(out, "\"/>\n</svg>\n")
+	return out.Flush()
+}
+
+// neighbours returns the pixels of the lines joined to the pixel x, y. Those
+// next to it are, and the diagonal ones too unless a pixel next to both of
+// them already joins them.
+func (l *Lines) neighbours(x, y int) [][2]int {
+	var joined [][2]int
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if (dx == 0 && dy == 0) || !l.At(x+dx, y+dy) {
+				continue
+			}
+			if dx != 0 && dy != 0 && (l.At(x+dx, y) || l.At(x, y+dy)) {
+				continue
+			}
+			joined = append(joined, [2]int{x + dx, y + dy})
+		}
+	}
+	return joined
+}
+
+// polylines returns the lines as the pixels of polylines that go from one
+// end or fork of a line to the next, around the loops, and as the lone
+// pixels, in the order of their first pixels
+func (l *Lines) polylines() [][][2]int {
+	type edge [2][2]int
+	key := func(a, b [2]int) edge {
+		if a[1] < b[1] || (a[1] == b[1] && a[0] < b[0]) {
+			return edge{a, b}
+		}
+		return edge{b, a}
+	}
+	visited := make(map[edge]bool)
+	// follow returns the polyline that starts along the edge from a to b,
+	// up to where the line ends, forks or comes back
+	follow := func(a, b [2]int) [][2]int {
+		polyline := [][2]int{a, b}
+		visited[key(a, b)] = true
+		for {
+			current := polyline[len(polyline)-1]
+			next := l.neighbours(current[0], current[1])
+			if len(next) != 2 {
+				return polyline
+			}
+			found := false
+			for _, n := range next {
+				if !visited[key(current, n)] {
+					visited[key(current, n)] = true
+					polyline = append(polyline, n)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return polyline
+			}
+		}
+	}
+	var polylines [][][2]int
+	// Lines start at their ends and forks, and then the loops that are
+	// left start anywhere
+	for _, loops := range []bool{false, true} {
+		for y := 0; y < l.Height; y++ {
+			for x := 0; x < l.Width; x++ {
+				if !l.At(x, y) {
+					continue
+				}
+				p := [2]int{x, y}
+				next := l.neighbours(x, y)
+				if len(next) == 0 {
+					if !loops {
+						polylines = append(polylines, [][2]int{p})
+					}
+					continue
+				}
+				if !loops && len(next) == 2 {
+					continue
+				}
+				for _, n := range next {
+					if !visited[key(p, n)] {
+						polylines = append(polylines, follow(p, n))
+					}
+				}
+			}
+		}
+	}
+	return polylines
+}
+
+// simplify returns the points of the polyline that the Ramer-Douglas-Peucker
+// algorithm keeps, so that the polyline through them strays from the
+// original by at most tolerance
+func simplify(polyline [][2]int, tolerance float64) [][2]int {
+	if len(polyline) < 3 {
+		return polyline
+	}
+	first, last := polyline[0], polyline[len(polyline)-1]
+	dx, dy := float64(last[0]-first[0]), float64(last[1]-first[1])
+	length := math.Hypot(dx, dy)
+	farthest, distance := 0, 0.0
+	for i, p := range polyline[1 : len(polyline)-1] {
+		px, py := float64(p[0]-first[0]), float64(p[1]-first[1])
+		d := math.Hypot(px, py)
+		if length > 0 {
+			d = math.Abs(dx*py-dy*px) / length
+		}
+		if d > distance {
+			farthest, distance = i+1, d
+		}
+	}
+	if distance <= tolerance {
+		return [][2]int{first, last}
+	}
+	left := simplify(polyline[:farthest+1], tolerance)
+	return append(left[:len(left)-1:len(left)-1], simplify(polyline[farthest:], tolerance)...)
+}
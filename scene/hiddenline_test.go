@@ -0,0 +1,82 @@
+package scene
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHiddenLinesDrawTheSilhouette(t *testing.T) {
+	render := `"render": {"integrator": "hiddenline"}, "lights"`
+	s, _, err := ParseScene([]byte(strings.Replace(validScene, `"lights"`, render, 1)), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 32
+	img := s.TraceScene(size, size)
+	if c := img.NRGBAAt(size/2, size/2); c.R != 255 || c.G != 255 || c.B != 255 {
+		t.Errorf("The middle of the sphere should be white, not %v", c)
+	}
+	if c := img.NRGBAAt(0, 0); c.R != 255 {
+		t.Errorf("The background should be white, not %v", c)
+	}
+	lines := s.TraceLines(size, size)
+	drawn := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if lines.At(x, y) {
+				drawn++
+				if c := img.NRGBAAt(x, y); c.R != 0 {
+					t.Errorf("The pixel %d, %d is on a line, but the render is %v there", x, y, c)
+				}
+			}
+		}
+	}
+	if drawn == 0 || lines.At(size/2, size/2) || lines.At(0, 0) {
+		t.Errorf("Only the silhouette of the sphere should be drawn, %d pixels are", drawn)
+	}
+}
+
+func TestLinesAsSVG(t *testing.T) {
+	lines := &Lines{Width: 4, Height: 3, LineWidth: 1, on: []bool{
+		true, true, true, false,
+		false, false, false, true,
+		false, true, false, false,
+	}}
+	var svg bytes.Buffer
+	if err := lines.WriteSVG(&svg); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`viewBox="0 0 4 3"`, `d="M0.5 0.5L2.5 0.5 3.5 1.5M1.5 2.5h0"`} {
+		if !strings.Contains(svg.String(), want) {
+			t.Errorf("%s should contain %s", svg.String(), want)
+		}
+	}
+}
+
+func TestLinesAreSimplified(t *testing.T) {
+	// The outline of a rectangle is a single closed stroke through its
+	// corners
+	const size = 32
+	lines := &Lines{Width: size, Height: size, LineWidth: 1, on: make([]bool, size*size)}
+	for x := 5; x <= 30; x++ {
+		lines.on[5*size+x], lines.on[20*size+x] = true, true
+	}
+	for y := 5; y <= 20; y++ {
+		lines.on[y*size+5], lines.on[y*size+30] = true, true
+	}
+	polylines := lines.polylines()
+	if len(polylines) != 1 {
+		t.Fatalf("The rectangle should be a single line, not %d", len(polylines))
+	}
+	rectangle := simplify(polylines[0], svgTolerance)
+	if want := [][2]int{{5, 5}, {30, 5}, {30, 20}, {5, 20}, {5, 5}}; fmt.Sprint(rectangle) != fmt.Sprint(want) {
+		t.Errorf("The rectangle should go through its corners %v, not %v", want, rectangle)
+	}
+	// A staircase straightens into a single segment
+	staircase := [][2]int{{0, 0}, {1, 0}, {2, 1}, {3, 1}, {4, 2}, {5, 2}, {6, 3}}
+	if straight := simplify(staircase, svgTolerance); len(straight) != 2 {
+		t.Errorf("The staircase should be a segment, not %v", straight)
+	}
+}
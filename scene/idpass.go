@@ -0,0 +1,35 @@
+package scene
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// TraceIDPass renders the object ID debug pass of the scene: every pixel
+// gets the color of the name of the nearest shape it sees, or black if
+// it doesn't see any.
+func (s *Scene) TraceIDPass(width, height int) *image.Image {
+	colors := make(map[shape.Shape]image.Color, len(s.Shapes))
+	for i, sh := range s.Shapes {
+		colors[sh] = image.IDColor(shape.NameOf(sh, i))
+	}
+
+	targetIt := s.Camera.GetIterator(width, height)
+	render := image.New(width, height)
+	for targetIt.HasNext() {
+		_, x, y := targetIt.Next()
+		lr := targetIt.Ray(x, y, 0.5, 0.5)
+		// The shapes that Delayed ones load take their color
+		nearestDistance, _, index := s.nearestShape(&lr)
+		if nearestDistance != math.MaxFloat64 {
+			color := colors[s.Shapes[index]]
+			render.Set(x, y, color.ToNRGBA())
+		} else {
+			render.Set(x, y, image.Black.ToNRGBA())
+		}
+	}
+
+	return render
+}
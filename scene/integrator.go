@@ -0,0 +1,166 @@
+package scene
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Integrator computes the light that reaches the camera
+type Integrator interface {
+	// Radiance returns the radiance arriving at the source of the camera
+	// ray lr from the scene. rng provides the random numbers of the
+	// sample.
+	Radiance(s *Scene, lr *math3d.LightRay, rng *sampling.Rand) image.Color
+}
+
+// DirectLighting is the integrator that only follows the light that
+// bounces once on its way from the lights to the camera, and the light
+// scattered once by the medium and the volumes. The light goes through
+// specular materials, such as glass, up to MaxDepth times in the settings
+// on its way to the camera; it can only reach the surfaces behind them
+// through the photons of the caustics.
+type DirectLighting struct{}
+
+// Radiance returns the radiance that the shape seen by lr reflects from the
+// lights, or the radiance of the light it sees
+func (d DirectLighting) Radiance(s *Scene, lr *math3d.LightRay, rng *sampling.Rand) image.Color {
+	return d.radiance(s, lr, s.Settings.MaxDepth, rng)
+}
+
+// radiance returns the radiance arriving along lr, which may still be
+// scattered by the given number of specular surfaces
+func (d DirectLighting) radiance(s *Scene, lr *math3d.LightRay, specular int, rng *sampling.Rand) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	// Check intersections with the shapes in the scene
+	nearestDistance, nearestShape := s.getNearestIntersection(lr)
+
+	// If the lightray doesn't intersect any shape, the pixel is black
+	radiance := image.Black
+	if lightDistance, emitted := s.nearestLight(lr); lightDistance < nearestDistance {
+		// The lightray sees a light before any shape
+		nearestDistance, radiance = lightDistance, emitted
+	} else if nearestDistance != math.MaxFloat64 {
+		// The lightray intersected a shape
+		hit := sc.hit(shape.HitAt(nearestShape, lr, nearestDistance))
+		out := sc.vector(lr.Direction.MultiplyV(-1))
+		if mat, ok := shape.MaterialAtHit(nearestShape, hit, out).(material.Specular); ok && specular > 0 {
+			next := sc.ray(math3d.LightRay{})
+			weight := s.scatterSpecular(mat, hit, lr, rng, next, sc.differential(math3d.Differential{}))
+			radiance = d.radiance(s, next, specular-1, rng)
+			radiance = *radiance.CMultiply(&weight)
+		} else {
+			intersection := sc.vector(lr.Source.AddV(lr.Direction.MultiplyV(nearestDistance)))
+			// Calculate the radiance at the intersection
+			radiance = s.calculateRadianceAt(intersection, lr, nearestShape, rng)
+		}
+	}
+	if s.Medium != nil {
+		radiance = s.throughMedium(lr, nearestDistance, radiance, rng)
+	}
+	if len(s.Volumes) > 0 {
+		radiance = s.throughVolumes(lr, nearestDistance, radiance, rng)
+	}
+	return radiance
+}
+
+// builtinIntegrators holds an integrator of every kind built in, so the one the
+// settings choose can be set up in it without allocating
+type builtinIntegrators struct {
+	bidirectional BidirectionalPathTracer
+	ao            AmbientOcclusionIntegrator
+	fixedPath     FixedPathTracer
+	toon          ToonIntegrator
+}
+
+// integrator returns the integrator chosen in the settings
+func (s *Scene) integrator() Integrator {
+	return s.integratorIn(&builtinIntegrators{})
+}
+
+// integratorIn returns the integrator chosen in the settings, set up in
+// the integrators if it's built in
+func (s *Scene) integratorIn(in *builtinIntegrators) Integrator {
+	switch s.Settings.Integrator {
+	case Bidirectional:
+		in.bidirectional = BidirectionalPathTracer{RouletteDepth: s.Settings.RouletteDepth, MaxDepth: s.Settings.MaxDepth, Clamp: s.Settings.IndirectClamp}
+		return &in.bidirectional
+	case AmbientOcclusion:
+		in.ao = AmbientOcclusionIntegrator{Rays: s.Settings.AORays, MaxDistance: s.Settings.AODistance}
+		return &in.ao
+	case FixedPath:
+		in.fixedPath = FixedPathTracer{MinDepth: s.Settings.MinDepth, MaxDepth: s.Settings.MaxDepth, Clamp: s.Settings.IndirectClamp}
+		return &in.fixedPath
+	case Toon:
+		in.toon = ToonIntegrator{Bands: s.Settings.toonBands()}
+		return &in.toon
+	case HiddenLine:
+		return HiddenLineIntegrator{}
+	}
+	if newIntegrator, ok := registeredIntegrator(s.Settings.Integrator); ok {
+		return newIntegrator(&s.Settings)
+	}
+	return DirectLighting{}
+}
+
+// clampIndirect returns the light that bounced the given number of times
+// on its way to the camera, dimmed so that its luminance is at most max if
+// it bounced more than once. There's no limit if max is 0.
+func clampIndirect(light image.Color, bounces int, max float64) image.Color {
+	if luminance := light.Luminance(); bounces > 1 && max > 0 && luminance > max {
+		return *light.Multiply(max / luminance)
+	}
+	return light
+}
+
+// AmbientOcclusionIntegrator is the integrator that ignores the lights and
+// shades every surface by the fraction of the rays leaving it in a cosine
+// weighted hemisphere that don't hit other shapes within MaxDistance. It's
+// cheap, and the renders show the shapes as if they were made of clay.
+type AmbientOcclusionIntegrator struct {
+	// Rays is the number of rays traced from every point
+	Rays int
+	// MaxDistance is the distance within which shapes occlude a point
+	MaxDistance float64
+}
+
+// Radiance returns white dimmed by the occlusion of the surface that lr
+// sees, or black if it doesn't see any
+func (ao *AmbientOcclusionIntegrator) Radiance(s *Scene, lr *math3d.LightRay, rng *sampling.Rand) image.Color {
+	distance, sh := s.getNearestIntersection(lr)
+	if sh == nil {
+		return image.Black
+	}
+	sc := getScratch()
+	defer sc.release()
+	hit := shape.HitAt(sh, lr, distance)
+	point, normal := sc.vector(hit.Point), sc.vector(hit.Normal)
+	if hit.Backface {
+		*normal = normal.MultiplyV(-1)
+	}
+	return *image.White.Multiply(ao.unoccluded(s, sh, point, normal, lr.Time, rng))
+}
+
+// unoccluded returns the fraction of the rays leaving point on the shape
+// sh at the time, around the normal, that don't hit other shapes within
+// MaxDistance
+func (ao *AmbientOcclusionIntegrator) unoccluded(s *Scene, sh shape.Shape, point, normal *math3d.Vector3, time float64, rng *sampling.Rand) float64 {
+	sc := getScratch()
+	defer sc.release()
+	origin := shape.ShadowOrigin(sh, point)
+	open := 0
+	ray := sc.ray(math3d.LightRay{Source: origin, Origin: sh, Time: time})
+	for i := 0; i < ao.Rays; i++ {
+		ray.Direction = sampling.CosineHemisphere(normal, rng.Float64(), rng.Float64())
+		ray.Source = s.leaving(&origin, normal, &ray.Direction)
+		if !s.inShadow(ray, ao.MaxDistance) {
+			open++
+		}
+	}
+	return float64(open) / float64(ao.Rays)
+}
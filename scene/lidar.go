@@ -0,0 +1,247 @@
+package scene
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Scanner is a LiDAR sensor, which measures the distances to the surfaces
+// around it firing beams of laser light in a pattern of directions: at
+// every azimuth of its sweep, a beam for every one of its channels.
+type Scanner struct {
+	// Position is where the sensor is, looking along Forward with Up as its
+	// up
+	Position math3d.Vector3 `json:"position"`
+	Forward  math3d.Vector3 `json:"forward"`
+	Up       math3d.Vector3 `json:"up"`
+	// Azimuth are the angles in degrees the beams are fired at around Up,
+	// from Forward turning right
+	Azimuth Sweep `json:"azimuth"`
+	// Elevations are the angles in degrees above the plane of the sweep of
+	// the channels, the beams fired at every azimuth
+	Elevations []float64 `json:"elevations"`
+	// Range is how far the beams measure
+	Range float64 `json:"range"`
+	// Noise is the standard deviation of the errors of the distances
+	// measured
+	Noise float64 `json:"noise,omitempty"`
+	// Seed is the seed of the noise
+	Seed uint64 `json:"seed,omitempty"`
+	// Returns is how many surfaces a beam measures at most, going through
+	// the dielectric ones it meets first. It's 1 if it's 0.
+	Returns int `json:"returns,omitempty"`
+}
+
+// Sweep are Steps angles in degrees evenly spaced from From up to To,
+// which is left out, so that a sweep all around doesn't fire twice in the
+// same direction
+type Sweep struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Steps int     `json:"steps"`
+}
+
+// LidarReturn is the measure of a surface a beam of a scanner hit
+type LidarReturn struct {
+	// Point is the point measured, in world space, and Normal is the
+	// normal of the surface there, facing the scanner
+	Point, Normal math3d.Vector3
+	// Range is the distance measured, noise included
+	Range float64
+	// Intensity is the fraction of the light of the beam that comes back
+	// from the point
+	Intensity float64
+	// Step is the index of the azimuth of the beam in the sweep and
+	// Channel is the index of its elevation
+	Step, Channel int
+	// Return is the index of the surface among the ones the beam hit,
+	// 0 for the nearest one
+	Return int
+	// Object is the index of the shape of the scene hit
+	Object int
+}
+
+// ReadScanner reads a scanner in JSON from r and validates it
+func ReadScanner(r io.Reader) (*Scanner, error) {
+	sc := &Scanner{}
+	if err := json.NewDecoder(r).Decode(sc); err != nil {
+		return nil, err
+	}
+	return sc, sc.Validate()
+}
+
+// Validate returns an error if the scanner can't scan
+func (sc *Scanner) Validate() error {
+	if sc.Forward.AbsSquared() == 0 || sc.Forward.CrossV(sc.Up).AbsSquared() == 0 {
+		return fmt.Errorf("forward and up must be non zero and not parallel")
+	}
+	if sc.Azimuth.Steps < 1 {
+		return fmt.Errorf("azimuth: there must be at least 1 step")
+	}
+	if math.IsNaN(sc.Azimuth.From) || math.IsInf(sc.Azimuth.From, 0) || math.IsNaN(sc.Azimuth.To) || math.IsInf(sc.Azimuth.To, 0) {
+		return fmt.Errorf("azimuth: the angles must be finite")
+	}
+	if len(sc.Elevations) == 0 {
+		return fmt.Errorf("there must be at least 1 elevation")
+	}
+	for _, e := range sc.Elevations {
+		if !(e >= -90 && e <= 90) {
+			return fmt.Errorf("the elevations must be between -90 and 90 degrees")
+		}
+	}
+	if !(sc.Range > 0) || math.IsInf(sc.Range, 1) {
+		return fmt.Errorf("the range must be positive and finite")
+	}
+	if !(sc.Noise >= 0) || math.IsInf(sc.Noise, 1) {
+		return fmt.Errorf("the noise must be finite and non negative")
+	}
+	if sc.Returns < 0 || sc.Returns > 255 {
+		return fmt.Errorf("the returns must be between 0 and 255")
+	}
+	return nil
+}
+
+// direction returns the direction of the beam of the step and the channel
+func (sc *Scanner) direction(step, channel int) math3d.Vector3 {
+	forward := sc.Forward.NormalizedV()
+	right := sc.Up.CrossV(forward).NormalizedV()
+	up := forward.CrossV(right)
+	azimuth := (sc.Azimuth.From + (sc.Azimuth.To-sc.Azimuth.From)*float64(step)/float64(sc.Azimuth.Steps)) * math.Pi / 180
+	elevation := sc.Elevations[channel] * math.Pi / 180
+	flat := forward.MultiplyV(math.Cos(azimuth)).AddV(right.MultiplyV(math.Sin(azimuth)))
+	return flat.MultiplyV(math.Cos(elevation)).AddV(up.MultiplyV(math.Sin(elevation)))
+}
+
+// Scan fires the beams of the scanner at the scene as the shutter opens,
+// and returns what they measure, in the order of the steps, the channels
+// and the returns. Beams that hit nothing in range return nothing.
+//
+// The surfaces reflect the beams back as diffuse ones, as much as the
+// luminance of their albedo and the cosine of the angle the beams hit them
+// at. Dielectric surfaces reflect only as much as they do straight on,
+// and the rest of the beam goes on through them without bending, as
+// through the windows of a car, so that the surfaces behind can return it
+// too.
+func (s *Scene) Scan(sc *Scanner) ([]LidarReturn, error) {
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	s.Prepare()
+	maxReturns := sc.Returns
+	if maxReturns == 0 {
+		maxReturns = 1
+	}
+	byStep := make([][]LidarReturn, sc.Azimuth.Steps)
+	steps := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for step := range steps {
+				// Every step has its own stream, so the noise doesn't
+				// depend on the goroutine that scans it
+				rng := sampling.New(sc.Seed, uint64(step))
+				for channel := range sc.Elevations {
+					byStep[step] = s.fire(sc, step, channel, maxReturns, rng, byStep[step])
+				}
+			}
+		}()
+	}
+	for step := 0; step < sc.Azimuth.Steps; step++ {
+		steps <- step
+	}
+	close(steps)
+	wg.Wait()
+	var returns []LidarReturn
+	for _, r := range byStep {
+		returns = append(returns, r...)
+	}
+	return returns, nil
+}
+
+// fire appends to returns what the beam of the step and the channel of the
+// scanner measures, up to maxReturns surfaces
+func (s *Scene) fire(sc *Scanner, step, channel, maxReturns int, rng *sampling.Rand, returns []LidarReturn) []LidarReturn {
+	ray := math3d.LightRay{Source: sc.Position, Direction: sc.direction(step, channel)}
+	out := ray.Direction.MultiplyV(-1)
+	travelled, transmittance := 0.0, 1.0
+	for r := 0; r < maxReturns; r++ {
+		distance, sh, index := s.nearestShape(&ray)
+		if sh == nil || travelled+distance > sc.Range {
+			break
+		}
+		travelled += distance
+		hit := shape.HitAt(sh, &ray, distance)
+		normal := hit.Normal
+		if normal.DotV(out) < 0 {
+			normal = normal.MultiplyV(-1)
+		}
+		mat := shape.MaterialAt(sh, &hit.Point, &normal, &out)
+		c := albedo(mat)
+		reflectance := c.Luminance() * normal.DotV(out)
+		dielectric, through := mat.(*material.Dielectric)
+		if through {
+			f := (dielectric.IOR - 1) / (dielectric.IOR + 1)
+			reflectance = f * f
+		}
+		measured := travelled
+		if sc.Noise > 0 {
+			// Box-Muller
+			u := 1 - rng.Float64()
+			measured += sc.Noise * math.Sqrt(-2*math.Log(u)) * math.Cos(2*math.Pi*rng.Float64())
+			measured = math.Max(measured, 0)
+		}
+		returns = append(returns, LidarReturn{
+			Point:     sc.Position.AddV(ray.Direction.MultiplyV(measured)),
+			Normal:    normal,
+			Range:     measured,
+			Intensity: transmittance * math.Max(reflectance, 0),
+			Step:      step,
+			Channel:   channel,
+			Return:    r,
+			Object:    index,
+		})
+		if !through {
+			break
+		}
+		transmittance *= 1 - reflectance
+		ray = math3d.LightRay{Source: hit.Point, Direction: ray.Direction, Origin: sh}
+	}
+	return returns
+}
+
+// WriteLidarPLY writes the returns to w as a point cloud in the binary PLY
+// format, with their normals, intensities, ranges, steps, channels, the
+// indices of the returns and the indices of the shapes hit
+func WriteLidarPLY(w io.Writer, returns []LidarReturn) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "ply\nformat binary_little_endian 1.0\ncomment goraytrace lidar scan\nelement vertex %d\n", len(returns))
+	for _, property := range []string{"x", "y", "z", "nx", "ny", "nz", "intensity", "range"} {
+		fmt.Fprintf(out, "property float %s\n", property)
+	}
+	fmt.Fprint(out, "property uint step\nproperty ushort channel\nproperty uchar return\nproperty int object\nend_header\n")
+	for _, r := range returns {
+		p, n := r.Point, r.Normal
+		values := []float32{float32(p.X), float32(p.Y), float32(p.Z), float32(n.X), float32(n.Y), float32(n.Z), float32(r.Intensity), float32(r.Range)}
+		if err := binary.Write(out, binary.LittleEndian, values); err != nil {
+			return err
+		}
+		binary.Write(out, binary.LittleEndian, uint32(r.Step))
+		binary.Write(out, binary.LittleEndian, uint16(r.Channel))
+		binary.Write(out, binary.LittleEndian, uint8(r.Return))
+		binary.Write(out, binary.LittleEndian, int32(r.Object))
+	}
+	return out.Flush()
+}
@@ -0,0 +1,95 @@
+package scene
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestScan(t *testing.T) {
+	s := New()
+	// A glass ball in a room, 20 away all around the scanner
+	s.AddShape(&shape.Sphere{Radius: 20, Material: &material.Lambertian{Albedo: image.Color{R: 0.5, G: 0.5, B: 0.5}}})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 5}, Radius: 1, Material: &material.Dielectric{Albedo: image.White, IOR: 1.5}})
+	sc := &Scanner{
+		Forward:    math3d.UnitZ,
+		Up:         math3d.UnitY,
+		Azimuth:    Sweep{From: -60, To: 60, Steps: 4},
+		Elevations: []float64{0, 10},
+		Range:      100,
+		Returns:    3,
+	}
+	returns, err := s.Scan(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The beam straight ahead goes through both sides of the ball
+	var ahead []LidarReturn
+	for _, r := range returns {
+		if r.Step == 2 && r.Channel == 0 {
+			ahead = append(ahead, r)
+		}
+	}
+	if len(ahead) != 3 || ahead[0].Object != 1 || ahead[2].Object != 0 {
+		t.Fatalf("The beam should go through the ball to the room, not %+v", ahead)
+	}
+	if math.Abs(ahead[0].Range-4) > 1e-9 || math.Abs(ahead[1].Range-6) > 1e-9 || math.Abs(ahead[2].Range-20) > 1e-9 {
+		t.Errorf("Expected ranges of 4, 6 and 20, not %g, %g and %g", ahead[0].Range, ahead[1].Range, ahead[2].Range)
+	}
+	if want := 0.96 * 0.96 * 0.5; math.Abs(ahead[2].Intensity-want) > 1e-6 || math.Abs(ahead[0].Intensity-0.04) > 1e-9 {
+		t.Errorf("Expected intensities of 0.04 and %g, not %g and %g", want, ahead[0].Intensity, ahead[2].Intensity)
+	}
+	// The beams to the sides miss the ball
+	for _, r := range returns {
+		if r.Step != 0 || r.Channel != 0 {
+			continue
+		}
+		if r.Object != 0 || math.Abs(r.Range-20) > 1e-9 || math.Abs(r.Intensity-0.5) > 1e-9 {
+			t.Errorf("The beam at -60 degrees should hit the room 20 away, not %+v", r)
+		}
+		if r.Point.X > -17 {
+			t.Errorf("The beams should turn right from forward, as the azimuth grows, not to %v", r.Point)
+		}
+	}
+	for _, r := range returns {
+		if r.Channel == 1 && r.Point.Y <= 0 {
+			t.Errorf("The beams of positive elevations should go up, not to %v", r.Point)
+		}
+	}
+
+	// The noise is the same however the steps are scanned
+	sc.Noise, sc.Seed, sc.Returns = 0.1, 7, 1
+	noisy, _ := s.Scan(sc)
+	again, _ := s.Scan(sc)
+	for i := range noisy {
+		if noisy[i] != again[i] {
+			t.Fatal("The noise should depend only on the seed")
+		}
+	}
+	var b bytes.Buffer
+	if err := WriteLidarPLY(&b, noisy); err != nil {
+		t.Fatal(err)
+	}
+	if header := fmt.Sprintf("element vertex %d\n", len(noisy)); !strings.Contains(b.String(), header) {
+		t.Errorf("The point cloud should have a vertex for every return")
+	}
+
+	for _, bad := range []string{
+		`{"forward": {"z": 1}, "up": {"z": 1}, "azimuth": {"steps": 1}, "elevations": [0], "range": 1}`,
+		`{"forward": {"z": 1}, "up": {"y": 1}, "azimuth": {"steps": 0}, "elevations": [0], "range": 1}`,
+		`{"forward": {"z": 1}, "up": {"y": 1}, "azimuth": {"steps": 1}, "elevations": [], "range": 1}`,
+		`{"forward": {"z": 1}, "up": {"y": 1}, "azimuth": {"steps": 1}, "elevations": [91], "range": 1}`,
+		`{"forward": {"z": 1}, "up": {"y": 1}, "azimuth": {"steps": 1}, "elevations": [0], "range": 0}`,
+	} {
+		if _, err := ReadScanner(strings.NewReader(bad)); err == nil {
+			t.Errorf("%s should be an error", bad)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package scene
+
+import (
+	stdcol "image/color"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// encodedPixel returns the pixel x, y of a render, with the alpha of the
+// pixel if the render is transparent
+func (s *Scene) encodedPixel(targetIt *camera.TracingTargetIterator, x, y int) stdcol.NRGBA {
+	radiance, alpha := s.TracePixelAlpha(targetIt, x, y)
+	return s.EncodePixel(&radiance, alpha)
+}
+
+// TracePixelAlpha returns the radiance of the pixel x, y, as TracePixel
+// does, and its alpha, which is 1 unless the render is transparent
+func (s *Scene) TracePixelAlpha(targetIt *camera.TracingTargetIterator, x, y int) (image.Color, float64) {
+	radiance, alpha, _ := s.TracePixelSamples(targetIt, x, y)
+	return radiance, alpha
+}
+
+// TracePixelSamples returns the radiance and the alpha of the pixel x, y,
+// as TracePixelAlpha does, and the number of samples it took, which is
+// below the samples of the settings where adaptive sampling stopped early
+func (s *Scene) TracePixelSamples(targetIt *camera.TracingTargetIterator, x, y int) (image.Color, float64, int) {
+	radiance, n := s.samplePixel(targetIt, x, y)
+	if !s.Settings.Transparent {
+		return radiance, 1, n
+	}
+	alpha := 0.0
+	for i := 0; i < n; i++ {
+		alpha += s.sampleAlpha(targetIt, x, y, i)
+	}
+	return radiance, alpha / float64(n), n
+}
+
+// EncodePixel returns the pixel of a render with the radiance and the
+// alpha TracePixelAlpha returns, in the color space of the settings
+func (s *Scene) EncodePixel(radiance *image.Color, alpha float64) stdcol.NRGBA {
+	if !s.Settings.Transparent {
+		return s.Settings.Encode(radiance)
+	}
+	if alpha == 0 {
+		return stdcol.NRGBA{}
+	}
+	// The radiance is the one of the shapes darkened by the fraction of
+	// the pixel they cover, which the alpha already holds
+	pixel := s.Settings.Encode(radiance.Divide(alpha))
+	pixel.A = uint8(math.Round(alpha * 255))
+	return pixel
+}
+
+// sampleAlpha returns the alpha of the sample n of the pixel x, y, through
+// the same point of the pixel as the sample of the render: 0 where it sees
+// no shape or a holdout, the shadow on a shadow catcher, and 1 elsewhere
+func (s *Scene) sampleAlpha(targetIt *camera.TracingTargetIterator, x, y, n int) float64 {
+	rng := sampling.ForSample(s.Settings.Seed, x, y, n)
+	u, v := 0.5, 0.5
+	if s.Settings.Samples > 1 {
+		u, v = rng.Float64(), rng.Float64()
+	}
+	lr := targetIt.Ray(x, y, u, v)
+	if s.Settings.Shutter > 0 {
+		lr.Time = s.Settings.Shutter * rng.Float64()
+	}
+	distance, sh := s.getNearestIntersection(&lr)
+	if sh == nil {
+		return 0
+	}
+	switch shape.MaterialOf(sh).(type) {
+	case *material.Holdout:
+		return 0
+	case *material.ShadowCatcher:
+		point := lr.Source.AddV(lr.Direction.MultiplyV(distance))
+		return s.shadowAt(sh, &point, &lr, rng)
+	}
+	return 1
+}
+
+// shadowAt returns the shadow at the point of the shape that the lightray
+// hits: the fraction of the direct light arriving at the point, weighted
+// by its luminance, that the shapes hide from it
+func (s *Scene) shadowAt(sh shape.Shape, point *math3d.Vector3, lr *math3d.LightRay, rng *sampling.Rand) float64 {
+	normal := sh.NormalAt(point).NormalizedV()
+	origin := shape.ShadowOrigin(sh, point)
+	if normal.DotV(lr.Direction) > 0 {
+		normal, origin = normal.MultiplyV(-1), *point
+	}
+	var arriving, unoccluded float64
+	s.forLights(&origin, &normal, rng, func(ls lighting.Light, weight float64) {
+		sample := ls.Sample(&origin, rng.Float64(), rng.Float64())
+		cosine := sample.Direction.DotV(normal)
+		if sample.Pdf == 0 || cosine <= 0 {
+			return
+		}
+		light := sample.Radiance.Luminance() * cosine / sample.Pdf * weight
+		unoccluded += light
+		shadowRay := math3d.LightRay{Source: origin, Direction: sample.Direction, Origin: sh, Time: lr.Time}
+		if !s.inShadow(&shadowRay, sample.Distance) {
+			arriving += light
+		}
+	})
+	if !(unoccluded > 0) {
+		return 0
+	}
+	return 1 - arriving/unoccluded
+}
@@ -0,0 +1,66 @@
+package scene
+
+import (
+	stdcol "image/color"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestTransparentRender(t *testing.T) {
+	s := New()
+	// A ball in front of a wall that catches its shadow, with a holdout
+	// beside it, lit by a sun behind the camera
+	ball := &shape.Sphere{Position: math3d.Vector3{Z: 2}, Radius: 0.3}
+	s.AddShape(ball)
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 0.4, Y: 0.4, Z: 2}, Radius: 0.15, Material: &material.Holdout{}})
+	s.AddShape(&shape.Triangle{
+		Vertices: [3]math3d.Vector3{{X: -3, Y: -3, Z: 3}, {X: 3, Y: -3, Z: 3}, {X: -3, Y: 3, Z: 3}},
+		Material: &material.ShadowCatcher{},
+	})
+	sun := math3d.Vector3{X: 0.5, Z: -1}.NormalizedV()
+	s.AddLight(&lighting.SunLight{Direction: sun, Irradiance: image.White})
+	s.Settings.Transparent = true
+	const size = 32
+	render := s.TraceScene(size, size)
+
+	targetIt := s.Camera.GetIterator(size, size)
+	seen := make(map[string]bool)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			lr := targetIt.Ray(x, y, 0.5, 0.5)
+			distance, sh, index := s.nearestShape(&lr)
+			want, what := uint8(255), "ball"
+			switch {
+			case sh == nil:
+				want, what = 0, "background"
+			case index == 1:
+				want, what = 0, "holdout"
+			case index == 2:
+				point := lr.Source.AddV(lr.Direction.MultiplyV(distance))
+				want, what = 0, "lit catcher"
+				if d := ball.Intersect(&math3d.LightRay{Source: point, Direction: sun}); d != math.MaxFloat64 {
+					want, what = 255, "shadow"
+				}
+			}
+			seen[what] = true
+			pixel := render.NRGBAAt(x, y)
+			if pixel.A != want || what != "ball" && pixel != (stdcol.NRGBA{A: want}) {
+				t.Fatalf("The pixel %d, %d sees the %s, it should be black with an alpha of %d, not %v", x, y, what, want, pixel)
+			}
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("The render should show the ball, the holdout, the background and the catcher in and out of the shadow, only shows %v", seen)
+	}
+
+	s.Settings.Transparent = false
+	if pixel := s.TraceScene(size, size).NRGBAAt(0, 0); pixel.A != 255 {
+		t.Errorf("Renders should be opaque unless they are transparent, not %v", pixel)
+	}
+}
@@ -0,0 +1,32 @@
+package scene
+
+import (
+	"unsafe"
+
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Memory returns about how many bytes of memory the geometry of the scene
+// takes: its shapes, the buffers of their meshes, the samples of its
+// heightfields and volumes, and its acceleration structure once Prepare
+// has built it. It's worked out from their sizes rather than measured on
+// the heap, so it's quick, and it doesn't depend on what else the process
+// holds at the time.
+func (s *Scene) Memory() uint64 {
+	bytes := uint64(len(s.Shapes)) * uint64(unsafe.Sizeof(shape.Shape(nil)))
+	meshes := make(map[*shape.PackedMesh]bool)
+	for _, sh := range s.Shapes {
+		bytes += shape.Memory(sh)
+		if t, ok := sh.(*shape.MeshTriangle); ok && !meshes[t.Mesh] {
+			meshes[t.Mesh] = true
+			bytes += t.Mesh.Memory()
+		}
+	}
+	for _, v := range s.Volumes {
+		bytes += 8 * uint64(len(v.Density)+3*len(v.Velocity)+len(v.Temperature))
+	}
+	if structure, ok := s.structure.(interface{ Memory() uint64 }); ok {
+		bytes += structure.Memory()
+	}
+	return bytes
+}
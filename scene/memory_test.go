@@ -0,0 +1,45 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestMemoryGrowsWithTheGeometry(t *testing.T) {
+	s, _, err := ParseSceneFile("../scene-examples/mesh.json", Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbuilt := s.Memory()
+	s.Prepare()
+	built := s.Memory()
+	if unbuilt == 0 || built <= unbuilt {
+		t.Errorf("The structure should add to the memory of the shapes, but it goes from %d to %d bytes", unbuilt, built)
+	}
+	var triangles []*shape.Triangle
+	for _, sh := range s.Shapes {
+		if tri, ok := sh.(*shape.Triangle); ok {
+			triangles = append(triangles, tri)
+		}
+	}
+	if len(triangles) == 0 {
+		t.Fatal("The mesh scene should have triangles")
+	}
+	packed := New()
+	for _, sh := range shape.PackTriangles(triangles).Triangles() {
+		packed.AddShape(sh)
+	}
+	if p := packed.Memory(); p == 0 || p >= unbuilt {
+		t.Errorf("The packed mesh should take less memory than its %d bytes of triangles, not %d", unbuilt, p)
+	}
+	one, many := New(), New()
+	one.AddShape(&shape.Sphere{Radius: 0.5})
+	for i := 0; i < 1000; i++ {
+		many.AddShape(&shape.Sphere{Position: math3d.Vector3{X: float64(i)}, Radius: 0.5})
+	}
+	if many.Memory() != 1000*one.Memory() {
+		t.Errorf("1000 spheres should take 1000 times the %d bytes of one, not %d", one.Memory(), many.Memory())
+	}
+}
@@ -0,0 +1,58 @@
+package scene
+
+import (
+	"fmt"
+	"math"
+)
+
+// FormatVersion is the version of the scene file format Marshal writes.
+// Scene files of older versions are upgraded to it when they are parsed,
+// and files without a "version" are of version 1.
+//
+// Version 2 requires every light to have a "type". Version 1 files had
+// point lights without one, from when they were the only lights.
+const FormatVersion = 2
+
+// migrations upgrade scene files from a version of the format to the
+// next: the first one upgrades version 1 files to version 2, and so on.
+// They are given the decoded file and change it in place. Changing the
+// format needs a new version and a migration from the last one, so the
+// files users already have keep working.
+var migrations = []func(scenemap map[string]interface{}){
+	typeLights,
+}
+
+// migrate upgrades the decoded scene file to FormatVersion, setting its
+// "version". Files of versions newer than FormatVersion can't be read, as
+// there's no telling what their keys mean.
+func migrate(scenemap map[string]interface{}) error {
+	version := 1
+	if v, present := scenemap["version"]; present {
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) || n < 1 {
+			return fmt.Errorf("version: not a version number")
+		}
+		if n > FormatVersion {
+			return fmt.Errorf("version: the scene file is version %v, but only versions up to %d can be read", n, FormatVersion)
+		}
+		version = int(n)
+	}
+	for _, upgrade := range migrations[version-1:] {
+		upgrade(scenemap)
+	}
+	scenemap["version"] = float64(FormatVersion)
+	return nil
+}
+
+// typeLights upgrades version 1 files, whose lights without a type are
+// point lights, to version 2
+func typeLights(scenemap map[string]interface{}) {
+	lights, _ := scenemap["lights"].([]interface{})
+	for _, l := range lights {
+		if m, ok := l.(map[string]interface{}); ok {
+			if _, present := m["type"]; !present {
+				m["type"] = "point"
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package scene
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOlderSceneFilesAreUpgraded(t *testing.T) {
+	// validScene has no version, so it's a version 1 file with an untyped
+	// point light
+	s, _, err := ParseScene([]byte(validScene), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Version int
+		Lights  []struct{ Type string }
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != FormatVersion || len(saved.Lights) != 1 || saved.Lights[0].Type != "point" {
+		t.Errorf("Saving the scene should upgrade it to version %d with a typed light, it's %+v", FormatVersion, saved)
+	}
+	if _, _, err := ParseScene(data, Strict); err != nil {
+		t.Errorf("The upgraded scene should be valid: %v", err)
+	}
+}
+
+func TestSceneVersions(t *testing.T) {
+	untyped := strings.Replace(validScene, `{`, `{"version": 2,`, 1)
+	if _, _, err := ParseScene([]byte(untyped), Strict); err == nil {
+		t.Error("Lights of version 2 files must have a type")
+	}
+	s, warnings, err := ParseScene([]byte(untyped), Lenient)
+	if err != nil || len(s.Lights) != 0 || len(warnings) != 1 {
+		t.Errorf("Lenient mode should skip the untyped light with a warning, it kept %d lights and warned %v: %v", len(s.Lights), warnings, err)
+	}
+	for _, version := range []string{`3`, `0`, `1.5`, `"2"`} {
+		scene := strings.Replace(validScene, `{`, `{"version": `+version+`,`, 1)
+		if _, _, err := ParseScene([]byte(scene), Lenient); err == nil {
+			t.Errorf("Version %s can't be read, even in lenient mode", version)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package scene
+
+import "fmt"
+
+// Option is an option of a scene created with NewWith. Options change the
+// settings of the scene, and fail if they're given values no scene can
+// render with.
+type Option func(s *Scene) error
+
+// NewWith returns an empty scene with the default settings changed by the
+// options, in order. It fails if an option fails or if the settings they
+// make can't be rendered with, so adding settings to scenes never breaks
+// the programs that create them.
+func NewWith(options ...Option) (*Scene, error) {
+	s := New()
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Settings.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WithSamples makes the scene take the samples per pixel
+func WithSamples(samples int) Option {
+	return func(s *Scene) error {
+		if samples < 1 {
+			return fmt.Errorf("there must be at least 1 sample per pixel, not %d", samples)
+		}
+		s.Settings.Samples = samples
+		return nil
+	}
+}
+
+// WithAdaptiveSampling makes the scene stop sampling every pixel after
+// minSamples once its luminance is known within the threshold, as
+// Settings.AdaptiveThreshold says
+func WithAdaptiveSampling(minSamples int, threshold float64) Option {
+	return func(s *Scene) error {
+		if minSamples < 1 || !(threshold > 0) {
+			return fmt.Errorf("adaptive sampling needs at least 1 sample and a positive threshold")
+		}
+		s.Settings.MinSamples, s.Settings.AdaptiveThreshold = minSamples, threshold
+		return nil
+	}
+}
+
+// WithIntegrator makes the integrator with the name, a builtin or a
+// registered one, compute the light reaching the camera
+func WithIntegrator(name string) Option {
+	return func(s *Scene) error {
+		if !builtinIntegrator(name) && !RegisteredIntegrator(name) {
+			return fmt.Errorf("there's no %q integrator", name)
+		}
+		s.Settings.Integrator = name
+		return nil
+	}
+}
+
+// WithMaxDepth makes light bounce at most depth times with the integrators
+// that follow it around the scene
+func WithMaxDepth(depth int) Option {
+	return func(s *Scene) error {
+		if depth < 0 || depth > 1024 {
+			return fmt.Errorf("the maximum depth must be between 0 and 1024, not %d", depth)
+		}
+		s.Settings.MaxDepth = depth
+		return nil
+	}
+}
+
+// WithFilter makes the renders that trace every pixel at once leave out
+// the samples more than threshold standard deviations brighter than the
+// mean of their pixels, the fireflies, as Settings.OutlierThreshold says
+func WithFilter(threshold float64) Option {
+	return func(s *Scene) error {
+		if !(threshold > 0) {
+			return fmt.Errorf("the outlier threshold must be positive, not %v", threshold)
+		}
+		s.Settings.OutlierThreshold = threshold
+		return nil
+	}
+}
+
+// WithSeed makes the scene render with the random numbers of the seed
+func WithSeed(seed uint64) Option {
+	return func(s *Scene) error {
+		s.Settings.Seed = seed
+		return nil
+	}
+}
+
+// WithColorSpace makes the pixels of the renders hold the radiance in the
+// color space, such as SRGB
+func WithColorSpace(colorSpace string) Option {
+	return func(s *Scene) error {
+		switch colorSpace {
+		case Linear, SRGB, Rec709, DisplayP3:
+			s.Settings.ColorSpace = colorSpace
+			return nil
+		}
+		return fmt.Errorf("the color space must be linear, srgb, rec709 or displayp3, not %q", colorSpace)
+	}
+}
+
+// WithAccelerator makes the acceleration structure with the name, such as
+// KDTree, find the shapes the rays hit
+func WithAccelerator(name string) Option {
+	return func(s *Scene) error {
+		switch name {
+		case BVH, KDTree, TwoLevel, SBVH, GPU:
+			s.Settings.Accelerator = name
+			return nil
+		}
+		return fmt.Errorf("the accelerator must be bvh, kdtree, twolevel, sbvh or gpu, not %q", name)
+	}
+}
+
+// WithSettings changes the settings of the scene with change, for the
+// settings without options of their own. They're validated with the
+// rest once every option is applied.
+func WithSettings(change func(*Settings)) Option {
+	return func(s *Scene) error {
+		change(&s.Settings)
+		return nil
+	}
+}
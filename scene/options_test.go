@@ -0,0 +1,32 @@
+package scene
+
+import "testing"
+
+func TestNewWith(t *testing.T) {
+	s, err := NewWith(WithSamples(16), WithIntegrator(Bidirectional), WithMaxDepth(8), WithFilter(3), WithSeed(7),
+		WithColorSpace(SRGB), WithAccelerator(KDTree), WithSettings(func(s *Settings) { s.Shutter = 0.01 }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultSettings()
+	want.Samples, want.Integrator, want.MaxDepth, want.OutlierThreshold, want.Seed = 16, Bidirectional, 8, 3, 7
+	want.ColorSpace, want.Accelerator, want.Shutter = SRGB, KDTree, 0.01
+	if s.Settings != want {
+		t.Errorf("The settings should be %+v, not %+v", want, s.Settings)
+	}
+
+	for name, option := range map[string]Option{
+		"no samples":         WithSamples(0),
+		"unknown integrator": WithIntegrator("photonmap"),
+		"negative depth":     WithMaxDepth(-1),
+		"no threshold":       WithFilter(0),
+		"no adaptive":        WithAdaptiveSampling(0, 0.1),
+		"unknown space":      WithColorSpace("cmyk"),
+		"unknown structure":  WithAccelerator("octree"),
+		"invalid settings":   WithSettings(func(s *Settings) { s.VolumeStep = 0 }),
+	} {
+		if _, err := NewWith(option); err == nil {
+			t.Errorf("With %s, creating the scene should fail", name)
+		}
+	}
+}
@@ -0,0 +1,957 @@
+package scene
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/cbor"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/medium"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// ParseMode selects what ParseScene does when a scene file has problems
+type ParseMode int
+
+const (
+	// Strict fails on unknown keys and invalid values
+	Strict ParseMode = iota
+	// Lenient warns about unknown keys and invalid values, skipping the
+	// lights and shapes that can't be used and falling back to the default
+	// render settings. The camera must always be valid.
+	Lenient
+)
+
+// Known keys of every object in a scene file
+var (
+	sceneKeys  = []string{"version", "camera", "shapes", "nodes", "lights", "medium", "volumes", "probes", "section", "render"}
+	cameraKeys = []string{"up", "right", "towards", "focalpoint", "fieldofview", "viewplanedistance", "eye", "target", "aspect", "projection", "stereo", "ipd", "convergence"}
+	lightKeys  = map[string][]string{
+		"point":  {"type", "position", "intensity", "temperature", "profile"},
+		"sphere": {"type", "position", "radius", "radiance", "twosided", "temperature"},
+		"sky":    {"type", "sundirection", "turbidity", "groundalbedo", "strength"},
+		"sun":    {"type", "direction", "angularradius", "irradiance", "turbidity", "strength"},
+	}
+	mediumKeys   = []string{"absorption", "scattering", "g", "temperature", "emission"}
+	volumeKeys   = []string{"position", "size", "resolution", "density", "velocity", "absorption", "scattering", "g", "temperature", "emission"}
+	probeKeys    = []string{"position", "box", "resolution"}
+	sectionKeys  = []string{"point", "normal", "box", "cap"}
+	settingsKeys = []string{"samples", "minsamples", "adaptivethreshold", "volumestep", "shadowstep", "seed", "colorspace", "exposure", "highlightclip", "shadowclip", "integrator", "accelerator", "splitbudget", "backfaces", "rayoffset", "maxdepth", "mindepth", "roulettedepth", "photons", "photonradius", "probeexponent", "aorays", "aodistance", "stats", "report", "maximagesize", "imagememory", "detail", "shutter", "indirectclamp", "outlierthreshold", "wavelengths", "toonbands", "outlinewidth", "transparent", "crop", "cropframe"}
+	vectorKeys   = []string{"x", "y", "z"}
+	colorKeys    = []string{"r", "g", "b"}
+	// vectorFields and colorFields are the keys whose values are vectors
+	// and colors
+	vectorFields = []string{"up", "right", "towards", "focalpoint", "position", "size", "sundirection", "direction"}
+	colorFields  = []string{"intensity", "radiance", "albedo", "meanfreepath", "groundalbedo", "irradiance"}
+	shapeKeys    = map[string][]string{
+		"sphere":      {"type", "name", "position", "radius", "material", "opacity", "cutoff"},
+		"heightfield": {"type", "name", "position", "size", "image", "heights", "material"},
+		"curve":       {"type", "name", "points", "widths", "material", "transform"},
+		"curves":      {"type", "name", "file", "material", "transform"},
+		"point":       {"type", "name", "position", "radius", "color", "splat", "material", "transform"},
+		"points":      {"type", "name", "file", "radius", "splat", "material", "transform"},
+		"triangle":    {"type", "name", "vertices", "normals", "uvs", "colors", "velocities", "material", "opacity", "cutoff", "transform"},
+		"mesh":        {"type", "name", "file", "buffers", "smoothangle", "subdivision", "velocities", "material", "opacity", "cutoff", "transform"},
+	}
+	// nodeKeys are the keys of the nodes of the scene graph
+	nodeKeys = []string{"name", "transform", "shapes", "children"}
+	// delayedKeys are the keys of delayed shapes, whose shape is loaded
+	// when a lightray first reaches their bounds
+	delayedKeys = []string{"type", "name", "bounds", "shape"}
+	// pathKeys are the keys of shapes whose values are file paths
+	pathKeys     = []string{"file", "image", "opacity", "profile"}
+	materialKeys = map[string][]string{
+		"lambertian":    {"type", "albedo"},
+		"glossy":        {"type", "albedo", "exponent"},
+		"subsurface":    {"type", "albedo", "meanfreepath"},
+		"dielectric":    {"type", "albedo", "ior", "abbe"},
+		"standard":      {"type", "albedo", "metalness", "roughness", "ior", "coat", "coatroughness", "coatior", "thinfilmthickness", "thinfilmior"},
+		"holdout":       {"type"},
+		"shadowcatcher": {"type"},
+		"procedural":    {"type", "color", "exponent"},
+	}
+)
+
+// ParseSceneFile reads a scene file and parses it with ParseScene. The
+// relative paths of the files the scene refers to, such as curves files,
+// are relative to the directory of the scene file.
+//
+// Scene caches, the scenes that MarshalCache encodes saved to a file, are
+// parsed with ParseSceneCache instead, and so are the ones that MarshalCBOR
+// encodes, with ParseSceneCBOR. Their files are mapped into memory, and the
+// buffers of their meshes are left there rather than copied, so that scenes
+// larger than the memory can be rendered. The BVHs of the caches with one
+// are loaded in a pass over the file, so large meshes start rendering
+// without waiting for their BVHs to be built. The file must not change
+// while the scene is in use. The paths in them were already joined to the
+// directory of the scene file they were saved from, so like the ones of
+// ParseSceneCBOR they're relative to the working directory.
+func ParseSceneFile(path string, mode ParseMode) (*Scene, []string, error) {
+	cached, err := isSceneCache(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cached {
+		data, err := assets.Map(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isCacheHeader(data) {
+			return parseSceneCache(data, mode, cbor.UnmarshalShared)
+		}
+		return parseSceneCBOR(data, mode, cbor.UnmarshalShared)
+	}
+	bytes, err := assets.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseScene(bytes, mode, filepath.Dir(path))
+}
+
+// isSceneCache returns whether the file at path is a scene cache, which
+// starts with the header of one, or with the head of a CBOR map if it was
+// saved before they had headers, rather than a scene file, whose JSON
+// starts with a brace or white space
+func isSceneCache(path string) (bool, error) {
+	file, err := assets.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	start := make([]byte, len(cacheMagic))
+	n, _ := io.ReadFull(file, start)
+	if n == 0 {
+		// Empty files fail to parse as JSON
+		return false, nil
+	}
+	return isCacheHeader(start[:n]) || start[0]>>5 == 5, nil
+}
+
+// ParseScene parses the contents of a scene file. It never panics, no
+// matter what the contents are. In lenient mode, it also returns a
+// warning for every problem it worked around. The relative paths of the
+// files the scene refers to are relative to the working directory.
+func ParseScene(bytes []byte, mode ParseMode) (*Scene, []string, error) {
+	return parseScene(bytes, mode, "")
+}
+
+// ParseSceneCBOR parses a scene in the scene file format encoded in CBOR,
+// as MarshalCBOR returns them, like ParseScene parses JSON
+func ParseSceneCBOR(bytes []byte, mode ParseMode) (*Scene, []string, error) {
+	return parseSceneCBOR(bytes, mode, cbor.Unmarshal)
+}
+
+// parseSceneCBOR parses the scene in CBOR, decoding it with unmarshal
+func parseSceneCBOR(bytes []byte, mode ParseMode, unmarshal func([]byte) (interface{}, error)) (*Scene, []string, error) {
+	value, err := unmarshal(bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	scenemap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("the scene isn't a map")
+	}
+	return parseSceneMap(scenemap, mode, "")
+}
+
+func parseScene(bytes []byte, mode ParseMode, dir string) (*Scene, []string, error) {
+	var scenemap map[string]interface{}
+	if err := json.Unmarshal(bytes, &scenemap); err != nil {
+		return nil, nil, err
+	}
+	return parseSceneMap(scenemap, mode, dir)
+}
+
+func parseSceneMap(scenemap map[string]interface{}, mode ParseMode, dir string) (*Scene, []string, error) {
+	p := &parser{mode: mode, dir: dir}
+	s := &Scene{Settings: DefaultSettings(), Shapes: make([]shape.Shape, 0, 10)}
+	if err := migrate(scenemap); err != nil {
+		return nil, nil, err
+	}
+	if err := p.checkKeys("scene", scenemap, sceneKeys); err != nil {
+		return nil, nil, err
+	}
+
+	cameramap, ok := scenemap["camera"].(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("camera: missing or not an object")
+	}
+	if err := p.parseCamera(cameramap, &s.Camera); err != nil {
+		return nil, nil, err
+	}
+	if err := p.parseLights(scenemap["lights"], s); err != nil {
+		return nil, nil, err
+	}
+	if err := p.parseShapes(scenemap["shapes"], s); err != nil {
+		return nil, nil, err
+	}
+	if v, present := scenemap["nodes"]; present {
+		if err := p.parseNodes(v, s); err != nil {
+			return nil, nil, err
+		}
+	}
+	if m, present := scenemap["medium"]; present {
+		if err := p.parseMedium(m, s); err != nil {
+			return nil, nil, err
+		}
+	}
+	if v, present := scenemap["volumes"]; present {
+		if err := p.parseVolumes(v, s); err != nil {
+			return nil, nil, err
+		}
+	}
+	if v, present := scenemap["probes"]; present {
+		if err := p.parseProbes(v, s); err != nil {
+			return nil, nil, err
+		}
+	}
+	if m, present := scenemap["section"]; present {
+		if err := p.parseSection(m, s); err != nil {
+			return nil, nil, err
+		}
+	}
+	if m, present := scenemap["render"]; present {
+		if err := p.parseSettings(m, s); err != nil {
+			return nil, nil, err
+		}
+	}
+	return s, p.warnings, nil
+}
+
+// parser holds the state of a single call to ParseScene
+type parser struct {
+	mode ParseMode
+	// dir is the directory relative paths are relative to
+	dir      string
+	warnings []string
+}
+
+// problem returns the problem as an error in strict mode. In lenient mode
+// it records it as a warning and returns nil.
+func (p *parser) problem(path, format string, args ...interface{}) error {
+	msg := path + ": " + fmt.Sprintf(format, args...)
+	if p.mode == Strict {
+		return fmt.Errorf("%s", msg)
+	}
+	p.warnings = append(p.warnings, msg)
+	return nil
+}
+
+// checkKeys reports every key of m that isn't one of known
+func (p *parser) checkKeys(path string, m map[string]interface{}, known []string) error {
+	for k := range m {
+		if !contains(known, k) {
+			if err := p.problem(path, "unknown key %q", k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseCamera(m map[string]interface{}, ph *camera.PinHole) error {
+	known, err := p.known("camera", m, cameraKeys)
+	if err != nil {
+		return err
+	}
+	// The camera can't be skipped, so its problems are errors in both modes
+	if err := json.Unmarshal(known, ph); err != nil {
+		return fmt.Errorf("camera: %v", err)
+	}
+	return nil
+}
+
+func (p *parser) parseLights(value interface{}, s *Scene) error {
+	lights, ok := value.([]interface{})
+	if !ok {
+		return p.problem("lights", "missing or not an array")
+	}
+	for i, v := range lights {
+		path := fmt.Sprintf("lights[%d]", i)
+		light, err := p.parseLight(path, v)
+		if err != nil {
+			return err
+		}
+		if light != nil {
+			s.Lights = append(s.Lights, light)
+		}
+	}
+	return nil
+}
+
+// parseLight returns the light defined in value, or nil if it can't be
+// used and the parser is lenient
+func (p *parser) parseLight(path string, value interface{}) (lighting.Light, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, p.problem(path, "not an object")
+	}
+	t, present := m["type"]
+	if !present {
+		return nil, p.problem(path, "missing type")
+	}
+	typename, _ := t.(string)
+	known, ok := lightKeys[typename]
+	if !ok {
+		return nil, p.problem(path, "unknown light type %q", typename)
+	}
+	data, err := p.known(path, p.resolvePaths(m), known)
+	if err != nil {
+		return nil, err
+	}
+	light, err := lighting.Unmarshal(data)
+	if err != nil {
+		return nil, p.problem(path, "%v", err)
+	}
+	return light, nil
+}
+
+func (p *parser) parseShapes(value interface{}, s *Scene) error {
+	shapes, ok := value.([]interface{})
+	if !ok {
+		return p.problem("shapes", "missing or not an array")
+	}
+	for i, v := range shapes {
+		path := fmt.Sprintf("shapes[%d]", i)
+		shapes, err := p.parseShape(path, v)
+		if err != nil {
+			return err
+		}
+		s.Shapes = append(s.Shapes, shapes...)
+	}
+	return nil
+}
+
+// parseNodes adds the nodes of the scene graph in value to the scene
+func (p *parser) parseNodes(value interface{}, s *Scene) error {
+	nodes, ok := value.([]interface{})
+	if !ok {
+		return p.problem("nodes", "not an array")
+	}
+	for i, v := range nodes {
+		if err := p.parseNode(fmt.Sprintf("nodes[%d]", i), v, nil, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseNode adds the node defined in value to the scene, as a child of
+// parent, with its shapes and its children. The ones that can't be used
+// are left out if the parser is lenient.
+func (p *parser) parseNode(path string, value interface{}, parent *Node, s *Scene) error {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return p.problem(path, "not an object")
+	}
+	if err := p.checkKeys(path, m, nodeKeys); err != nil {
+		return err
+	}
+	name, _ := m["name"].(string)
+	local := math3d.IdentityMatrix()
+	if t, present := m["transform"]; present {
+		var err error
+		if local, err = math3d.TransformFromMap(t); err != nil {
+			return p.problem(path+".transform", "%v", err)
+		}
+	}
+	var shapes []shape.Shape
+	if v, present := m["shapes"]; present {
+		values, ok := v.([]interface{})
+		if !ok {
+			return p.problem(path+".shapes", "not an array")
+		}
+		for i, v := range values {
+			shapePath := fmt.Sprintf("%s.shapes[%d]", path, i)
+			parsed, err := p.parseShape(shapePath, v)
+			if err != nil {
+				return err
+			}
+			if len(parsed) > 0 {
+				if _, ok := parsed[0].(*Delayed); ok {
+					if err := p.problem(shapePath, "delayed shapes can't be in nodes"); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			shapes = append(shapes, parsed...)
+		}
+	}
+	n := NewNode(name, local)
+	if err := s.AddNode(parent, n, shapes...); err != nil {
+		return p.problem(path, "%v", err)
+	}
+	if v, present := m["children"]; present {
+		children, ok := v.([]interface{})
+		if !ok {
+			return p.problem(path+".children", "not an array")
+		}
+		for i, child := range children {
+			if err := p.parseNode(fmt.Sprintf("%s.children[%d]", path, i), child, n, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseShape returns the shapes defined in value, which may be many for
+// the types that load them from a file, or none if they can't be used and
+// the parser is lenient
+func (p *parser) parseShape(path string, value interface{}) ([]shape.Shape, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, p.problem(path, "not an object")
+	}
+	if m["type"] == "delayed" {
+		return p.parseDelayed(path, m)
+	}
+	m, ok, err := p.checkShape(path, m)
+	if !ok {
+		return nil, err
+	}
+	shapes, err := shape.FromMap([]map[string]interface{}{m})
+	if err != nil {
+		return nil, p.problem(path, "%v", err)
+	}
+	for _, sh := range shapes {
+		if problem := invalidShape(sh); problem != "" {
+			return nil, p.problem(path, "%s", problem)
+		}
+	}
+	return shapes, nil
+}
+
+// checkShape reports the problems of the map of a shape, returning it with
+// its paths resolved. ok is false if the shape can't be used.
+func (p *parser) checkShape(path string, m map[string]interface{}) (map[string]interface{}, bool, error) {
+	typename, _ := m["type"].(string)
+	known, ok := shapeKeys[typename]
+	if !ok {
+		known, ok = shape.PrimitiveKeys(typename)
+	}
+	if !ok {
+		return nil, false, p.problem(path, "unknown shape type %q", typename)
+	}
+	if err := p.checkKeys(path, m, known); err != nil {
+		return nil, false, err
+	}
+	if contains(known, "position") {
+		if err := p.checkVector(path+".position", m["position"]); err != nil {
+			return nil, false, err
+		}
+	}
+	// The textures of the material are loaded as it's checked
+	m = p.resolvePaths(m)
+	if mat, present := m["material"]; present {
+		if ok, err := p.checkMaterial(path+".material", mat); !ok {
+			return nil, false, err
+		}
+	}
+	return m, true, nil
+}
+
+// parseDelayed returns the delayed shape defined in m, whose source shape
+// is checked but not loaded
+func (p *parser) parseDelayed(path string, m map[string]interface{}) ([]shape.Shape, error) {
+	if err := p.checkKeys(path, m, delayedKeys); err != nil {
+		return nil, err
+	}
+	source, ok := m["shape"].(map[string]interface{})
+	if !ok {
+		return nil, p.problem(path+".shape", "missing or not an object")
+	}
+	if source["type"] == "delayed" {
+		return nil, p.problem(path+".shape", "delayed shapes can't be delayed again")
+	}
+	source, ok, err := p.checkShape(path+".shape", source)
+	if !ok {
+		return nil, err
+	}
+	d := &Delayed{Source: source}
+	d.Name, _ = m["name"].(string)
+	if d.Box, err = boxFromMap(m["bounds"]); err != nil {
+		return nil, p.problem(path+".bounds", "%v", err)
+	}
+	if b := d.Box; !finite(b.Min.X, b.Min.Y, b.Min.Z, b.Max.X, b.Max.Y, b.Max.Z) || !b.Min.LesserOrEqual(&b.Max) {
+		return nil, p.problem(path+".bounds", "the min must be finite and below the max")
+	}
+	if mat, ok := source["material"].(map[string]interface{}); ok {
+		d.Material = material.FromMap(mat)
+	}
+	return []shape.Shape{d}, nil
+}
+
+// resolvePaths returns a copy of the map of a shape or a light whose
+// relative file paths, with the ones of the textures of its material, are
+// joined to the directory of the scene file
+func (p *parser) resolvePaths(m map[string]interface{}) map[string]interface{} {
+	resolved := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if path, ok := v.(string); ok && contains(pathKeys, k) && !filepath.IsAbs(path) {
+			v = filepath.Join(p.dir, path)
+		} else if k == "material" {
+			v = p.resolveTextures(v)
+		}
+		resolved[k] = v
+	}
+	return resolved
+}
+
+// resolveTextures returns a copy of the value of a material whose texture
+// nodes have their relative file paths joined to the directory of the
+// scene file
+func (p *parser) resolveTextures(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			resolved[k] = p.resolveTextures(child)
+		}
+		if path, ok := v["file"].(string); ok && isTextureNode(v) && !filepath.IsAbs(path) {
+			resolved["file"] = filepath.Join(p.dir, path)
+		}
+		return resolved
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, child := range v {
+			resolved[i] = p.resolveTextures(child)
+		}
+		return resolved
+	}
+	return v
+}
+
+// isTextureNode returns whether the map of a node of a material is one of
+// the nodes that read their image from a file
+func isTextureNode(m map[string]interface{}) bool {
+	return m["node"] == "image" || m["node"] == "hextile"
+}
+
+// ReferencedFiles returns the paths of the files the shapes and the lights
+// of a scene file and their materials refer to, such as meshes, profiles
+// and textures, as they're written in it, once each and sorted.
+// ParseSceneFile joins the relative ones to the directory of the scene
+// file.
+func ReferencedFiles(bytes []byte) ([]string, error) {
+	var scenemap map[string]interface{}
+	if err := json.Unmarshal(bytes, &scenemap); err != nil {
+		return nil, err
+	}
+	var paths []string
+	add := func(path interface{}) {
+		if path, ok := path.(string); ok && !contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	// walk adds the paths of the value, which is part of a material if
+	// inMaterial is true
+	var walk func(v interface{}, inMaterial bool)
+	walk = func(v interface{}, inMaterial bool) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if !inMaterial {
+				for _, k := range pathKeys {
+					add(v[k])
+				}
+			} else if isTextureNode(v) {
+				add(v["file"])
+			}
+			for k, child := range v {
+				walk(child, inMaterial || k == "material")
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child, inMaterial)
+			}
+		}
+	}
+	for _, k := range []string{"lights", "shapes", "nodes"} {
+		walk(scenemap[k], false)
+	}
+	if section, ok := scenemap["section"].(map[string]interface{}); ok {
+		walk(section["cap"], true)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// invalidShape returns why the shape can't be used, or an empty string if
+// it can
+func invalidShape(sh shape.Shape) string {
+	switch sh := sh.(type) {
+	case *shape.Sphere:
+		if !(sh.Radius > 0) {
+			return "the radius must be positive"
+		}
+	case *shape.Heightfield:
+		if !(sh.Size.X > 0 && sh.Size.Z > 0) {
+			return "the size along X and Z must be positive"
+		}
+	case *shape.Triangle:
+		for _, v := range sh.Velocities {
+			if !finite(v.X, v.Y, v.Z) {
+				return "the velocities must be finite"
+			}
+		}
+	case *shape.Curve:
+		for _, w := range sh.Widths {
+			if !(w >= 0) {
+				return "the widths can't be negative"
+			}
+		}
+	case *shape.Point:
+		if !(sh.Radius > 0) {
+			return "the radius must be positive"
+		}
+	}
+	bounds := sh.Bounds()
+	if !finite(bounds.Min.X, bounds.Min.Y, bounds.Min.Z, bounds.Max.X, bounds.Max.Y, bounds.Max.Z) {
+		return "the shape must be finite"
+	}
+	return ""
+}
+
+// checkMaterial reports the problems of the material in value. ok is false
+// if the shape that has it can't be used.
+func (p *parser) checkMaterial(path string, value interface{}) (bool, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return false, p.problem(path, "not an object")
+	}
+	typename, _ := m["type"].(string)
+	known, ok := materialKeys[typename]
+	if !ok {
+		known, ok = material.MaterialKeys(typename)
+	}
+	if !ok {
+		return false, p.problem(path, "unknown material type %q", typename)
+	}
+	data, err := p.known(path, m, known)
+	if err != nil {
+		return false, err
+	}
+	if _, err := material.Unmarshal(data); err != nil {
+		return false, p.problem(path, "%v", err)
+	}
+	return true, nil
+}
+
+func (p *parser) parseMedium(value interface{}, s *Scene) error {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return p.problem("medium", "not an object")
+	}
+	if err := p.checkKeys("medium", m, mediumKeys); err != nil {
+		return err
+	}
+	for _, k := range []string{"absorption", "scattering"} {
+		if err := p.checkColor("medium."+k, m[k]); err != nil {
+			return err
+		}
+	}
+	med, err := medium.HomogeneousFromMap(m)
+	if err != nil {
+		return p.problem("medium", "%v", err)
+	}
+	if !validColor(&med.Absorption) || !validColor(&med.Scattering) {
+		return p.problem("medium", "the coefficients must be finite and non negative")
+	}
+	if !(math.Abs(med.G) < 1) {
+		return p.problem("medium", "g must be between -1 and 1")
+	}
+	s.Medium = med
+	return nil
+}
+
+func (p *parser) parseVolumes(value interface{}, s *Scene) error {
+	volumes, ok := value.([]interface{})
+	if !ok {
+		return p.problem("volumes", "not an array")
+	}
+	for i, v := range volumes {
+		path := fmt.Sprintf("volumes[%d]", i)
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			if err := p.problem(path, "not an object"); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, k := range []string{"absorption", "scattering"} {
+			if err := p.checkColor(path+"."+k, m[k]); err != nil {
+				return err
+			}
+		}
+		data, err := p.known(path, m, volumeKeys)
+		if err != nil {
+			return err
+		}
+		grid := &medium.Grid{}
+		if err := json.Unmarshal(data, grid); err != nil {
+			if err := p.problem(path, "%v", err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := grid.Validate(); err != nil {
+			if err := p.problem(path, "%v", err); err != nil {
+				return err
+			}
+			continue
+		}
+		s.Volumes = append(s.Volumes, grid)
+	}
+	return nil
+}
+
+func (p *parser) parseProbes(value interface{}, s *Scene) error {
+	probes, ok := value.([]interface{})
+	if !ok {
+		return p.problem("probes", "not an array")
+	}
+	for i, v := range probes {
+		path := fmt.Sprintf("probes[%d]", i)
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			if err := p.problem(path, "not an object"); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := p.known(path, m, probeKeys)
+		if err != nil {
+			return err
+		}
+		probe := &Probe{}
+		if err := json.Unmarshal(data, probe); err != nil {
+			if err := p.problem(path, "%v", err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := probe.Validate(); err != nil {
+			if err := p.problem(path, "%v", err); err != nil {
+				return err
+			}
+			continue
+		}
+		s.Probes = append(s.Probes, probe)
+	}
+	return nil
+}
+
+func (p *parser) parseSection(value interface{}, s *Scene) error {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return p.problem("section", "not an object")
+	}
+	if err := p.checkKeys("section", m, sectionKeys); err != nil {
+		return err
+	}
+	section := &Section{}
+	for _, k := range []string{"point", "normal"} {
+		v, present := m[k]
+		if !present {
+			continue
+		}
+		vector, err := math3d.VectorFromMap(v)
+		if err != nil {
+			return p.problem("section."+k, "%v", err)
+		}
+		if k == "point" {
+			section.Point = &vector
+		} else {
+			section.Normal = &vector
+		}
+	}
+	if v, present := m["box"]; present {
+		box, err := boxFromMap(v)
+		if err != nil {
+			return p.problem("section.box", "%v", err)
+		}
+		section.Box = &box
+	}
+	if v, present := m["cap"]; present {
+		v = p.resolveTextures(v)
+		if ok, err := p.checkMaterial("section.cap", v); !ok {
+			return err
+		}
+		section.Cap = material.FromMap(v.(map[string]interface{}))
+	}
+	if err := section.Validate(); err != nil {
+		return p.problem("section", "%v", err)
+	}
+	s.Section = section
+	return nil
+}
+
+func (p *parser) parseSettings(value interface{}, s *Scene) error {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return p.problem("render", "not an object")
+	}
+	if err := p.checkKeys("render", m, settingsKeys); err != nil {
+		return err
+	}
+	for k, v := range m {
+		if k == "colorspace" || k == "integrator" || k == "accelerator" || k == "backfaces" || k == "crop" {
+			continue
+		}
+		if k == "stats" || k == "transparent" || k == "cropframe" {
+			if _, ok := v.(bool); !ok {
+				if err := p.problem("render."+k, "must be true or false"); err != nil {
+					return err
+				}
+				delete(m, k)
+			}
+			continue
+		}
+		if f, ok := v.(float64); !ok || !finite(f) || f < 0 {
+			if err := p.problem("render."+k, "must be a non negative number"); err != nil {
+				return err
+			}
+			delete(m, k)
+		}
+	}
+	settings := SettingsFromMap(m)
+	if err := settings.Validate(); err != nil {
+		return p.problem("render", "%v", err)
+	}
+	s.Settings = settings
+	s.fileSettings = make(map[string]bool, len(m))
+	for k := range m {
+		s.fileSettings[k] = true
+	}
+	return nil
+}
+
+// known returns the JSON of m with only its known keys, and with only x,
+// y and z or r, g and b in the vectors and colors among them, reporting
+// the others. Strict decoders accept it even if lenient mode let unknown
+// keys through.
+func (p *parser) known(path string, m map[string]interface{}, keys []string) ([]byte, error) {
+	if err := p.checkKeys(path, m, keys); err != nil {
+		return nil, err
+	}
+	filtered := make(map[string]interface{}, len(m))
+	for _, k := range keys {
+		v, present := m[k]
+		if !present {
+			continue
+		}
+		var nestedKeys []string
+		switch {
+		case contains(vectorFields, k):
+			nestedKeys = vectorKeys
+		case contains(colorFields, k):
+			nestedKeys = colorKeys
+		}
+		if nested, isObject := v.(map[string]interface{}); isObject && nestedKeys != nil {
+			if err := p.checkKeys(path+"."+k, nested, nestedKeys); err != nil {
+				return nil, err
+			}
+			v = only(nested, nestedKeys)
+		}
+		filtered[k] = v
+	}
+	return json.Marshal(filtered)
+}
+
+// only returns a copy of m with only the keys
+func only(m map[string]interface{}, keys []string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		if v, present := m[k]; present {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// checkVector reports a value that isn't an object with x, y and z
+func (p *parser) checkVector(path string, value interface{}) error {
+	return p.checkNumbers(path, value, vectorKeys, true)
+}
+
+// checkColor reports a value that isn't an object with r, g and b. A
+// missing color is fine, it's taken as black.
+func (p *parser) checkColor(path string, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	return p.checkNumbers(path, value, colorKeys, false)
+}
+
+func (p *parser) checkNumbers(path string, value interface{}, known []string, required bool) error {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return p.problem(path, "missing or not an object")
+	}
+	if err := p.checkKeys(path, m, known); err != nil {
+		return err
+	}
+	for _, k := range known {
+		v, present := m[k]
+		if !present && !required {
+			continue
+		}
+		if _, ok := v.(float64); !ok {
+			if err := p.problem(path, "%q is missing or not a number", k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// boxFromMap returns the box in value, which must be an object with the
+// min and the max vectors
+func boxFromMap(value interface{}) (math3d.AABB, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return math3d.AABB{}, errors.New("must be an object with the min and the max vectors")
+	}
+	min, err := math3d.VectorFromMap(m["min"])
+	if err != nil {
+		return math3d.AABB{}, fmt.Errorf("min: %v", err)
+	}
+	max, err := math3d.VectorFromMap(m["max"])
+	if err != nil {
+		return math3d.AABB{}, fmt.Errorf("max: %v", err)
+	}
+	return math3d.AABB{Min: min, Max: max}, nil
+}
+
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func validColor(c *image.Color) bool {
+	return finite(c.R, c.G, c.B) && c.R >= 0 && c.G >= 0 && c.B >= 0
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+package scene
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+const validScene = `{
+	"camera": {
+		"up": {"x": 0, "y": 1, "z": 0},
+		"right": {"x": 1, "y": 0, "z": 0},
+		"towards": {"x": 0, "y": 0, "z": 1},
+		"focalpoint": {"x": 0, "y": 0, "z": -1},
+		"fieldofview": 1.5,
+		"viewplanedistance": 1
+	},
+	"lights": [{"position": {"x": 0, "y": 2, "z": 0}, "intensity": {"r": 1, "g": 1, "b": 1}}],
+	"shapes": [{"type": "sphere", "position": {"x": 0, "y": 0, "z": 3}, "radius": 1}]
+}`
+
+func TestParseSceneAcceptsValidScenes(t *testing.T) {
+	for _, mode := range []ParseMode{Strict, Lenient} {
+		s, warnings, err := ParseScene([]byte(validScene), mode)
+		if err != nil || len(warnings) > 0 {
+			t.Fatalf("The scene should be valid: %v %v", err, warnings)
+		}
+		if len(s.Shapes) != 1 || len(s.Lights) != 1 {
+			t.Errorf("Expected 1 shape and 1 light, got %d and %d", len(s.Shapes), len(s.Lights))
+		}
+	}
+}
+
+func TestParseSceneModes(t *testing.T) {
+	broken := strings.Replace(validScene, `"radius": 1}`, `"radius": -1}, {"type": "cube"}`, 1)
+	broken = strings.Replace(broken, `"lights"`, `"extra": true, "lights"`, 1)
+	if _, _, err := ParseScene([]byte(broken), Strict); err == nil {
+		t.Error("Strict mode should fail on invalid shapes and unknown keys")
+	}
+	s, warnings, err := ParseScene([]byte(broken), Lenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Shapes) != 0 || len(warnings) != 3 {
+		t.Errorf("Lenient mode should skip both shapes and warn 3 times, it kept %d shapes and warned %v", len(s.Shapes), warnings)
+	}
+	noCamera := strings.Replace(validScene, `"fieldofview": 1.5`, `"fieldofview": "wide"`, 1)
+	if _, _, err := ParseScene([]byte(noCamera), Lenient); err == nil {
+		t.Error("A scene without a valid camera can't be used, even in lenient mode")
+	}
+}
+
+func TestLenientModeDecodesKnownKeysOnly(t *testing.T) {
+	extra := strings.Replace(validScene, `"y": 2, "z": 0}`, `"y": 2, "z": 0, "w": 1}`, 1)
+	extra = strings.Replace(extra, `"radius": 1}`, `"radius": 1, "material": {"type": "glossy", "exponent": 5, "shiny": true}}`, 1)
+	if _, _, err := ParseScene([]byte(extra), Strict); err == nil {
+		t.Error("Strict mode should fail on unknown keys of vectors and materials")
+	}
+	s, warnings, err := ParseScene([]byte(extra), Lenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Lights) != 1 || len(s.Shapes) != 1 || len(warnings) != 2 {
+		t.Errorf("Lenient mode should keep the light and the shape and warn twice, it kept %d and %d and warned %v", len(s.Lights), len(s.Shapes), warnings)
+	}
+}
+
+func TestParseVolumes(t *testing.T) {
+	volume := `"volumes": [{
+		"position": {"x": -1, "y": -1, "z": 2}, "size": {"x": 2, "y": 2, "z": 2},
+		"resolution": [2, 2, 2], "density": [0, 1, 0, 1, 0, 1, 0, 1],
+		"scattering": {"r": 1, "g": 1, "b": 1}, "absorption": {"r": 0, "g": 0, "b": 0}, "g": 0.2}], "lights"`
+	withVolume := strings.Replace(validScene, `"lights"`, volume, 1)
+	s, _, err := ParseScene([]byte(withVolume), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Volumes) != 1 || s.Volumes[0].G != 0.2 {
+		t.Fatalf("Expected the volume, got %v", s.Volumes)
+	}
+	missing := strings.Replace(withVolume, `[0, 1, 0, 1, 0, 1, 0, 1]`, `[0, 1]`, 1)
+	if _, _, err := ParseScene([]byte(missing), Strict); err == nil {
+		t.Error("Strict mode should fail on volumes without a density for every sample")
+	}
+	s, warnings, err := ParseScene([]byte(missing), Lenient)
+	if err != nil || len(s.Volumes) != 0 || len(warnings) != 1 {
+		t.Errorf("Lenient mode should skip the volume and warn once, it kept %d and warned %v", len(s.Volumes), warnings)
+	}
+}
+
+func TestExampleScenesAreStrictlyValid(t *testing.T) {
+	paths, _ := filepath.Glob("../scene-examples/*.json")
+	for _, path := range paths {
+		if _, _, err := ParseSceneFile(path, Strict); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestReferencedFiles(t *testing.T) {
+	data, err := ioutil.ReadFile("../scene-examples/cutout.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths, err := ReferencedFiles(data); err != nil || len(paths) != 1 || paths[0] != "fence.png" {
+		t.Errorf("the cutout scene should refer to fence.png alone, not %v (%v)", paths, err)
+	}
+	delayed := `{"shapes": [{"type": "delayed", "shape": {"type": "mesh", "file": "mesh.obj",
+		"material": {"type": "procedural", "color": {"node": "image", "file": "wood.png"}}}}],
+		"lights": [{"type": "point", "profile": "lamp.ies"}],
+		"section": {"cap": {"type": "procedural", "color": {"node": "hextile", "file": "cap.png"}}}}`
+	if paths, err := ReferencedFiles([]byte(delayed)); err != nil || strings.Join(paths, " ") != "cap.png lamp.ies mesh.obj wood.png" {
+		t.Errorf("the files of the nested shapes and of the textures of the materials should be found, unlike %v (%v)", paths, err)
+	}
+}
+
+func TestParseSceneCBORGivesTheSameScene(t *testing.T) {
+	paths, _ := filepath.Glob("../scene-examples/*.json")
+	for _, path := range paths {
+		s, _, err := ParseSceneFile(path, Strict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := s.MarshalCBOR()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		decoded, _, err := ParseSceneCBOR(encoded, Strict)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		want, _ := s.Marshal()
+		got, _ := decoded.Marshal()
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: the scene decoded from CBOR should be the same", path)
+		}
+	}
+	if _, _, err := ParseSceneCBOR([]byte{0x83, 1, 2, 3}, Lenient); err == nil {
+		t.Error("A scene must be a map")
+	}
+}
+
+func TestParseSceneCacheFile(t *testing.T) {
+	s, _, err := ParseSceneFile("../scene-examples/mesh.json", Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, marshal := range []func() ([]byte, error){s.MarshalCache, s.MarshalCBOR} {
+		encoded, err := marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "mesh.cache")
+		if err := ioutil.WriteFile(path, encoded, 0644); err != nil {
+			t.Fatal(err)
+		}
+		cached, _, err := ParseSceneFile(path, Strict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSceneCache(t, s, cached)
+	}
+}
+
+func TestParseSceneCacheLoadsTheBVH(t *testing.T) {
+	s, _, err := ParseSceneFile("../scene-examples/mesh.json", Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := s.MarshalCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, _, err := ParseSceneCache(encoded, Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := cached.structure
+	if _, ok := loaded.(*accel.BVH); !ok {
+		t.Fatal("The BVH of the cache should be loaded with the scene")
+	}
+	if cached.Prepare(); cached.structure != loaded {
+		t.Error("The BVH of the cache shouldn't be built again")
+	}
+	checkSceneCache(t, s, cached)
+
+	if _, _, err := ParseSceneCache(encoded[:len(encoded)-1], Strict); err == nil {
+		t.Error("A truncated cache shouldn't parse")
+	}
+	old := append([]byte(nil), encoded...)
+	old[len(cacheMagic)]++
+	if _, _, err := ParseSceneCache(old, Strict); err == nil {
+		t.Error("A cache of another version shouldn't parse")
+	}
+	s.Settings.Accelerator = KDTree
+	if encoded, err = s.MarshalCache(); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _, err = ParseSceneCache(encoded, Strict); err != nil {
+		t.Fatal(err)
+	}
+	if cached.structure != nil {
+		t.Error("The caches of scenes that don't use a BVH shouldn't hold one")
+	}
+}
+
+// checkSceneCache checks that the scene parsed from a cache is the scene
+// the cache was saved from
+func checkSceneCache(t *testing.T, s, cached *Scene) {
+	t.Helper()
+	packed := 0
+	for _, sh := range cached.Shapes {
+		if _, ok := sh.(*shape.MeshTriangle); ok {
+			packed++
+		}
+	}
+	if packed == 0 {
+		t.Error("The triangles of the mesh should be read from the buffers of the cache")
+	}
+	want, _ := s.Marshal()
+	got, _ := cached.Marshal()
+	if !bytes.Equal(got, want) {
+		t.Error("The scene parsed from the cache should be the same")
+	}
+	if !bytes.Equal(cached.TraceScene(16, 16).Pix, s.TraceScene(16, 16).Pix) {
+		t.Error("The scene parsed from the cache should render the same")
+	}
+}
+
+func FuzzParseScene(f *testing.F) {
+	f.Add([]byte(validScene))
+	paths, _ := filepath.Glob("../scene-examples/*.json")
+	for _, path := range paths {
+		if bytes, err := ioutil.ReadFile(path); err == nil {
+			f.Add(bytes)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		strict, _, strictErr := ParseScene(data, Strict)
+		lenient, _, lenientErr := ParseScene(data, Lenient)
+		if strictErr == nil && (lenientErr != nil || len(strict.Shapes) != len(lenient.Shapes)) {
+			t.Error("Lenient mode should accept everything strict mode accepts")
+		}
+		if lenientErr == nil {
+			// Whatever is accepted must be safe to trace
+			lenient.TraceScene(2, 2)
+		}
+	})
+}
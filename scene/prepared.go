@@ -0,0 +1,98 @@
+package scene
+
+import (
+	"fmt"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+)
+
+// PreparedScene is a scene prepared for rendering that doesn't change any
+// more, which any number of renders see through views of their own, with
+// cameras and settings of their own, at once, such as the eyes of a
+// stereo pair or the views of a multi-view capture. The views share the
+// shapes, the lights, the acceleration structure and the light tree of
+// the scene rather than copying them, so they cost next to nothing.
+type PreparedScene struct {
+	scene *Scene
+}
+
+// NewPreparedScene prepares the scene, as Prepare does, and returns it as
+// a prepared scene. The scene must not be edited afterwards, nor traced
+// but through the views of the prepared scene.
+func NewPreparedScene(s *Scene) *PreparedScene {
+	s.Prepare()
+	return &PreparedScene{scene: s}
+}
+
+// Camera returns the camera of the scene, to derive the ones of the views
+// from
+func (p *PreparedScene) Camera() camera.PinHole {
+	return p.scene.Camera
+}
+
+// Settings returns the settings of the scene, to derive the ones of the
+// views from
+func (p *PreparedScene) Settings() Settings {
+	return p.scene.Settings
+}
+
+// View returns a scene that renders the prepared scene with the camera and
+// the settings. It can be traced while the other views are, but it can't be
+// edited: its editing methods panic, and the ones that return errors fail.
+// The settings must be valid, and they can't change the acceleration
+// structure, the split budget of an SBVH, the culling of the back faces or
+// the shutter, which the views share. The caustics and the reflection
+// probes are shared with the views whose settings trace the same photons,
+// and traced again by the rest when they're prepared. The views count their
+// own samples and rays, but not the work of the acceleration structure.
+func (p *PreparedScene) View(c camera.PinHole, settings Settings) (*Scene, error) {
+	s := p.scene
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("camera: %v", err)
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, fmt.Errorf("render: %v", err)
+	}
+	if settings.accelerator() != s.Settings.accelerator() || settings.Backfaces != s.Settings.Backfaces || settings.Shutter != s.Settings.Shutter ||
+		settings.accelerator() == SBVH && settings.splitBudget() != s.Settings.splitBudget() {
+		return nil, fmt.Errorf("the views of a prepared scene can't change its accelerator, split budget, backfaces or shutter")
+	}
+	view := &Scene{
+		Camera: c,
+		// The slices are capped, so that nothing appended to them, were
+		// it done, would write to the ones of the other views
+		Shapes:        s.Shapes[:len(s.Shapes):len(s.Shapes)],
+		Lights:        s.Lights[:len(s.Lights):len(s.Lights)],
+		Nodes:         s.Nodes[:len(s.Nodes):len(s.Nodes)],
+		Medium:        s.Medium,
+		Volumes:       s.Volumes,
+		Probes:        s.Probes,
+		Section:       s.Section,
+		Settings:      settings,
+		structure:     s.structure,
+		moving:        s.moving,
+		meshes:        s.meshes,
+		fast:          s.fast,
+		filters:       s.filters,
+		culling:       s.culling,
+		shutter:       s.shutter,
+		splitBudget:   s.splitBudget,
+		lightTree:     s.lightTree,
+		distantLights: s.distantLights,
+		fileSettings:  s.fileSettings,
+		shared:        true,
+	}
+	if settings.Photons == s.Settings.Photons && settings.Seed == s.Settings.Seed && settings.MaxDepth == s.Settings.MaxDepth &&
+		settings.Wavelengths == s.Settings.Wavelengths {
+		view.caustics, view.probeMaps = s.caustics, s.probeMaps
+	}
+	return view, nil
+}
+
+// mustBeEditable panics if the scene is a view of a prepared scene, which
+// can't be edited
+func (s *Scene) mustBeEditable() {
+	if s.shared {
+		panic("The views of a prepared scene can't be edited")
+	}
+}
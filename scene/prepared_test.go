@@ -0,0 +1,100 @@
+package scene
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestViewsOfAPreparedSceneRenderAtOnce(t *testing.T) {
+	prepared := NewPreparedScene(testScene())
+	left, right := prepared.Camera(), prepared.Camera()
+	left.FocalPoint.X, right.FocalPoint.X = -0.05, 0.05
+	settings := prepared.Settings()
+	settings.Samples = 2
+	cameras := []struct {
+		camera   math3d.Vector3
+		settings Settings
+	}{{left.FocalPoint, settings}, {right.FocalPoint, prepared.Settings()}}
+
+	var want [][]byte
+	for _, c := range cameras {
+		s := testScene()
+		s.Camera.FocalPoint, s.Settings = c.camera, c.settings
+		want = append(want, s.TraceScene(24, 24).Pix)
+	}
+	got := make([][]byte, len(cameras))
+	var wg sync.WaitGroup
+	for i, c := range cameras {
+		cam := prepared.Camera()
+		cam.FocalPoint = c.camera
+		view, err := prepared.View(cam, c.settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.structure != prepared.scene.structure {
+			t.Error("The views should share the acceleration structure")
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = view.TraceScene(24, 24).Pix
+		}(i)
+	}
+	wg.Wait()
+	for i := range cameras {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("The view %d should render like a scene of its own", i)
+		}
+	}
+}
+
+func TestViewsOfAPreparedSceneCantBeEdited(t *testing.T) {
+	prepared := NewPreparedScene(testScene())
+	shapes := len(prepared.scene.Shapes)
+	settings := prepared.Settings()
+	settings.Accelerator = KDTree
+	if _, err := prepared.View(prepared.Camera(), settings); err == nil {
+		t.Error("A view shouldn't change the acceleration structure")
+	}
+	view, err := prepared.View(prepared.Camera(), prepared.Settings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := view.AddNode(nil, NewNode("node", math3d.IdentityMatrix())); err == nil {
+		t.Error("Adding a node to a view should fail")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Adding a shape to a view should panic")
+		}
+		if len(prepared.scene.Shapes) != shapes {
+			t.Error("The prepared scene shouldn't change")
+		}
+	}()
+	view.AddShape(&shape.Sphere{Radius: 1})
+}
+
+func TestViewsShareTheSBVH(t *testing.T) {
+	s := testScene()
+	s.Settings.Accelerator = SBVH
+	prepared := NewPreparedScene(s)
+	view, err := prepared.View(prepared.Camera(), prepared.Settings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tracing builds the structure again if the view takes it as built
+	// with another split budget, which views can't
+	view.TraceScene(8, 8)
+	if view.structure != prepared.scene.structure {
+		t.Error("The views should share the SBVH")
+	}
+	settings := prepared.Settings()
+	settings.SplitBudget = 1
+	if _, err := prepared.View(prepared.Camera(), settings); err == nil {
+		t.Error("A view shouldn't change the split budget of the SBVH")
+	}
+}
@@ -0,0 +1,295 @@
+package scene
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+// probeStream is the stream of random numbers of the reflection probes,
+// apart from the ones of the pixels and of the photons
+const probeStream = 0x70726f6265
+
+// DefaultProbeResolution is the texels on a side of the faces of the cube
+// maps of the probes that don't set theirs
+const DefaultProbeResolution = 16
+
+// maxProbeResolution is the most texels on a side of the faces of the cube
+// maps of the probes, which are prefiltered texel by texel
+const maxProbeResolution = 32
+
+// probeSamples is the number of lightrays traced for every texel of the
+// cube maps of the probes
+const probeSamples = 4
+
+// probeLevels is the number of levels of the prefiltered cube maps of the
+// probes. The level k holds the light reflected by the glossy lobes of
+// exponent 4^k, so the last one is as sharp as an exponent of 256.
+const probeLevels = 5
+
+// Probe is a local reflection probe: a cube map of the light that arrives
+// at a point of a room, which the rough glossy materials inside its box
+// reflect instead of tracing their reflections, as the ProbeExponent of
+// the settings says. The cube map is corrected for the parallax of the
+// points away from where it was captured by taking the walls of the room
+// to be the faces of the box, so the box should fit the room.
+type Probe struct {
+	// Position is where the probe sees the scene from, inside the box
+	Position math3d.Vector3 `json:"position"`
+	// Box is the room whose surfaces reflect the probe
+	Box math3d.AABB `json:"box"`
+	// Resolution is the texels on a side of the faces of the cube map,
+	// DefaultProbeResolution if it's 0
+	Resolution int `json:"resolution,omitempty"`
+}
+
+// Validate returns an error if the probe can't be used
+func (p *Probe) Validate() error {
+	b := &p.Box
+	switch {
+	case !finite(b.Min.X, b.Min.Y, b.Min.Z, b.Max.X, b.Max.Y, b.Max.Z) || !b.Min.LesserOrEqual(&b.Max):
+		return errors.New("the min of the box must be finite and below the max")
+	case !finite(p.Position.X, p.Position.Y, p.Position.Z) || !b.Contains(&p.Position):
+		return errors.New("the position must be inside the box")
+	case p.Resolution < 0 || p.Resolution > maxProbeResolution:
+		return errors.New("the resolution must be between 0 and 32")
+	}
+	return nil
+}
+
+// resolution returns the texels on a side of the faces of the cube map
+func (p *Probe) resolution() int {
+	if p.Resolution == 0 {
+		return DefaultProbeResolution
+	}
+	return p.Resolution
+}
+
+// probeMap is the cube map of a probe, prefiltered for glossy lobes of
+// several exponents
+type probeMap struct {
+	probe      *Probe
+	resolution int
+	// levels holds the texels of the 6 faces of every level, face after
+	// face and row after row
+	levels [probeLevels][]image.Color
+}
+
+// reflectionProbes returns the cube maps of the probes, capturing them if
+// the scene changed since they were last captured. It's nil if the
+// settings don't ask for the probes to be reflected.
+func (s *Scene) reflectionProbes() []*probeMap {
+	if !s.rendersProbes() {
+		return nil
+	}
+	if s.probeMaps == nil {
+		// The probes aren't reflected while they're captured, since the
+		// list is empty until they are
+		s.probeMaps = []*probeMap{}
+		maps := make([]*probeMap, len(s.Probes))
+		for i, p := range s.Probes {
+			maps[i] = s.captureProbe(p)
+		}
+		s.probeMaps = maps
+	}
+	return s.probeMaps
+}
+
+// rendersProbes returns true if the settings ask for the probes to be
+// reflected and the integrator reflects them
+func (s *Scene) rendersProbes() bool {
+	return s.Settings.ProbeExponent > 0 && len(s.Probes) > 0 && (s.Settings.Integrator == "" || s.Settings.Integrator == Direct)
+}
+
+// captureProbe traces the cube map of the probe with the direct lighting
+// integrator and prefilters it. The lights it sees are left out, since the
+// glossy materials find them by sampling them.
+func (s *Scene) captureProbe(p *Probe) *probeMap {
+	m := &probeMap{probe: p, resolution: p.resolution()}
+	n := m.resolution
+	rng := sampling.New(s.Settings.Seed, probeStream)
+	captured := make([]image.Color, 6*n*n)
+	for face := 0; face < 6; face++ {
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				var sum image.Color
+				for i := 0; i < probeSamples; i++ {
+					u := 2*(float64(x)+rng.Float64())/float64(n) - 1
+					v := 2*(float64(y)+rng.Float64())/float64(n) - 1
+					ray := math3d.LightRay{Source: p.Position, Direction: cubeDirection(face, u, v).NormalizedV()}
+					distance, _ := s.getNearestIntersection(&ray)
+					if lightDistance, _ := s.nearestLight(&ray); lightDistance < distance || distance == math.MaxFloat64 {
+						continue
+					}
+					radiance := DirectLighting{}.radiance(s, &ray, s.Settings.MaxDepth, rng)
+					sum = *sum.Add(&radiance)
+				}
+				captured[(face*n+y)*n+x] = *sum.Multiply(1.0 / probeSamples)
+			}
+		}
+	}
+	m.prefilter(captured)
+	return m
+}
+
+// prefilter sets the levels of the cube map to the captured texels
+// averaged over the glossy lobes of their exponents around the directions
+// of the texels
+func (m *probeMap) prefilter(captured []image.Color) {
+	n := m.resolution
+	directions := make([]math3d.Vector3, len(captured))
+	solidAngles := make([]float64, len(captured))
+	for face := 0; face < 6; face++ {
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				u, v := 2*(float64(x)+0.5)/float64(n)-1, 2*(float64(y)+0.5)/float64(n)-1
+				i := (face*n+y)*n + x
+				directions[i] = cubeDirection(face, u, v).NormalizedV()
+				solidAngles[i] = 4 / float64(n*n) / math.Pow(1+u*u+v*v, 1.5)
+			}
+		}
+	}
+	for level := range m.levels {
+		m.levels[level] = make([]image.Color, len(captured))
+	}
+	for i, direction := range directions {
+		var sums [probeLevels]image.Color
+		var weights [probeLevels]float64
+		for j, c := range captured {
+			cosine := direction.DotV(directions[j])
+			if cosine <= 0 {
+				continue
+			}
+			// The exponents are powers of 4, so their powers are squares
+			// of squares of the cosine
+			power := cosine
+			for level := range sums {
+				weight := power * solidAngles[j]
+				sums[level] = *sums[level].Add(c.Multiply(weight))
+				weights[level] += weight
+				power *= power
+				power *= power
+			}
+		}
+		for level := range sums {
+			if weights[level] > 0 {
+				m.levels[level][i] = *sums[level].Multiply(1 / weights[level])
+			}
+		}
+	}
+}
+
+// lookup returns the light arriving at the probe from the direction,
+// averaged over the glossy lobe of the exponent around it
+func (m *probeMap) lookup(direction *math3d.Vector3, exponent float64) image.Color {
+	level := math.Min(math.Max(math.Log2(math.Max(exponent, 1))/2, 0), probeLevels-1)
+	low := int(level)
+	c := m.texel(low, direction)
+	if f := level - float64(low); f > 0 {
+		next := m.texel(low+1, direction)
+		c = *c.Multiply(1 - f).Add(next.Multiply(f))
+	}
+	return c
+}
+
+// texel returns the texels of the level around the direction interpolated
+// bilinearly within its face
+func (m *probeMap) texel(level int, direction *math3d.Vector3) image.Color {
+	n := m.resolution
+	face, u, v := cubeFace(direction)
+	x := math.Min(math.Max((u+1)/2*float64(n)-0.5, 0), float64(n-1))
+	y := math.Min(math.Max((v+1)/2*float64(n)-0.5, 0), float64(n-1))
+	// The texels at the edges of the faces are clamped to rather than
+	// interpolated with the ones of the next faces
+	x0, y0 := int(x), int(y)
+	x1, y1 := x0+1, y0+1
+	if x1 == n {
+		x1 = x0
+	}
+	if y1 == n {
+		y1 = y0
+	}
+	fx, fy := x-float64(x0), y-float64(y0)
+	texels := m.levels[level][face*n*n:]
+	c := *texels[y0*n+x0].Multiply((1 - fx) * (1 - fy))
+	c = *c.Add(texels[y0*n+x1].Multiply(fx * (1 - fy)))
+	c = *c.Add(texels[y1*n+x0].Multiply((1 - fx) * fy))
+	return *c.Add(texels[y1*n+x1].Multiply(fx * fy))
+}
+
+// cubeDirection returns the direction of the point u, v, from -1 to 1, of
+// the face of a cube map: +X, -X, +Y, -Y, +Z and -Z
+func cubeDirection(face int, u, v float64) math3d.Vector3 {
+	switch face {
+	case 0:
+		return math3d.Vector3{X: 1, Y: v, Z: -u}
+	case 1:
+		return math3d.Vector3{X: -1, Y: v, Z: u}
+	case 2:
+		return math3d.Vector3{X: u, Y: 1, Z: -v}
+	case 3:
+		return math3d.Vector3{X: u, Y: -1, Z: v}
+	case 4:
+		return math3d.Vector3{X: u, Y: v, Z: 1}
+	}
+	return math3d.Vector3{X: -u, Y: v, Z: -1}
+}
+
+// cubeFace returns the face of a cube map that the direction points to and
+// the point u, v of it, the opposite of cubeDirection
+func cubeFace(d *math3d.Vector3) (int, float64, float64) {
+	x, y, z := math.Abs(d.X), math.Abs(d.Y), math.Abs(d.Z)
+	switch {
+	case x >= y && x >= z && d.X > 0:
+		return 0, -d.Z / x, d.Y / x
+	case x >= y && x >= z:
+		return 1, d.Z / x, d.Y / x
+	case y >= z && d.Y > 0:
+		return 2, d.X / y, -d.Z / y
+	case y >= z:
+		return 3, d.X / y, d.Z / y
+	case d.Z > 0:
+		return 4, d.X / z, d.Y / z
+	}
+	return 5, -d.X / z, d.Y / z
+}
+
+// probeReflection returns the light of the nearest probe whose box holds
+// the point that the glossy material reflects towards out, if the settings
+// ask for its reflections to come from the probes, or black. The direction
+// to look the probe up in is corrected for the parallax of the point by
+// intersecting its reflection with the box.
+func (s *Scene) probeReflection(point, normal, out *math3d.Vector3, glossy *material.Glossy) image.Color {
+	if glossy.Exponent > s.Settings.ProbeExponent {
+		return image.Black
+	}
+	var nearest *probeMap
+	nearestDistance := math.Inf(1)
+	for _, m := range s.reflectionProbes() {
+		if d := m.probe.Position.SubtractV(*point).AbsSquared(); m.probe.Box.Contains(point) && d < nearestDistance {
+			nearest, nearestDistance = m, d
+		}
+	}
+	if nearest == nil {
+		return image.Black
+	}
+	reflected := out.MultiplyV(-1).ReflectV(*normal)
+	cosine := reflected.DotV(*normal)
+	if cosine <= 0 {
+		return image.Black
+	}
+	direction := reflected
+	if _, exit := nearest.probe.Box.IntersectRange(&math3d.LightRay{Source: *point, Direction: reflected}); exit != math.MaxFloat64 {
+		direction = point.AddV(reflected.MultiplyV(exit)).SubtractV(nearest.probe.Position)
+	}
+	light := nearest.lookup(&direction, glossy.Exponent)
+	// The normalized Phong lobe integrates to (e+2)/(e+1) times the light
+	// it averages, and the cosine of the surface is taken at the mirror
+	// direction
+	e := glossy.Exponent
+	return *light.CMultiply(&glossy.Albedo).Multiply((e + 2) / (e + 1) * cosine)
+}
@@ -0,0 +1,119 @@
+package scene
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestProbeCubeMapsPrefilterTheLobes(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		d := math3d.Vector3{X: r.Float64()*2 - 1, Y: r.Float64()*2 - 1, Z: r.Float64()*2 - 1}
+		face, u, v := cubeFace(&d)
+		back := cubeDirection(face, u, v).NormalizedV()
+		if back.SubtractV(d.NormalizedV()).Abs() > 1e-9 {
+			t.Fatalf("%v should be on the face %d at %v, %v, which is %v", d, face, u, v, back)
+		}
+	}
+
+	m := &probeMap{resolution: 8}
+	captured := make([]image.Color, 6*8*8)
+	for i := range captured {
+		captured[i] = image.White
+	}
+	m.prefilter(captured)
+	for level := range m.levels {
+		for _, c := range m.levels[level] {
+			if math.Abs(c.R-1) > 1e-9 {
+				t.Fatalf("A uniform probe should stay uniform at every level, not %v at level %d", c, level)
+			}
+		}
+	}
+	// Only the +X face is lit, so the sharp lobes see it head on, and the
+	// rough ones see some of it from the sides
+	for i := range captured {
+		captured[i] = image.Black
+		if i < 8*8 {
+			captured[i] = image.White
+		}
+	}
+	m.prefilter(captured)
+	right, left, up := math3d.Vector3{X: 1}, math3d.Vector3{X: -1}, math3d.Vector3{Y: 1}
+	if c := m.lookup(&right, 256); c.R < 0.99 {
+		t.Errorf("A sharp lobe should see the lit face, not %v", c)
+	}
+	if c := m.lookup(&left, 1); c.R != 0 {
+		t.Errorf("No lobe should see the lit face from behind, not %v", c)
+	}
+	if rough, sharp := m.lookup(&up, 1), m.lookup(&up, 256); !(rough.R > 0.05 && sharp.R < 0.01) {
+		t.Errorf("Only the rough lobes should see the lit face from the side, not %v and %v", rough, sharp)
+	}
+}
+
+func TestGlossySurfacesReflectTheProbes(t *testing.T) {
+	s := New()
+	s.Settings.Backfaces = TwoSided
+	s.AddShape(&shape.Sphere{Radius: 5, Material: &material.Lambertian{Albedo: image.White}})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 1, Material: &material.Glossy{Albedo: image.White, Exponent: 4}})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 3}, Intensity: image.Color{R: 10, G: 10, B: 10}})
+	s.Probes = []*Probe{{Position: math3d.Vector3{Z: -2}, Box: math3d.AABB{Min: math3d.Vector3{X: -5, Y: -5, Z: -5}, Max: math3d.Vector3{X: 5, Y: 5, Z: 5}}, Resolution: 8}}
+	targetIt := s.Camera.GetIterator(16, 16)
+	without := s.TracePixel(targetIt, 8, 8)
+
+	s.Settings.ProbeExponent = 2
+	if c := s.TracePixel(targetIt, 8, 8); c != without {
+		t.Errorf("The glossy materials sharper than the probe exponent shouldn't reflect the probes, %v became %v", without, c)
+	}
+	s.Settings.ProbeExponent = 16
+	s.Prepare()
+	if len(s.probeMaps) != 1 {
+		t.Fatalf("Preparing the scene should capture its probe")
+	}
+	with := s.TracePixel(targetIt, 8, 8)
+	if !(with.Luminance() > without.Luminance()+0.01) {
+		t.Errorf("The glossy sphere should reflect the lit room, but %v only became %v", without, with)
+	}
+	s.MoveLight(0, &math3d.Vector3{Y: -1})
+	if s.probeMaps != nil {
+		t.Error("Moving the light should capture the probes again")
+	}
+	s.Settings.Integrator = FixedPath
+	if s.reflectionProbes() != nil {
+		t.Error("Only the direct integrator should reflect the probes")
+	}
+}
+
+func TestParseProbes(t *testing.T) {
+	scene := validScene[:len(validScene)-2] + `,
+	"probes": [{"position": {"x": 0, "y": 0, "z": 0}, "box": {"min": {"x": -1, "y": -1, "z": -1}, "max": {"x": 1, "y": 1, "z": 4}}, "resolution": 8}],
+	"render": {"probeexponent": 32}
+}`
+	s, _, err := ParseScene([]byte(scene), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Probes) != 1 || s.Probes[0].Box.Max.Z != 4 || s.Probes[0].Resolution != 8 || s.Settings.ProbeExponent != 32 {
+		t.Fatalf("The probe and the exponent should be parsed, not %+v and %v", s.Probes, s.Settings.ProbeExponent)
+	}
+	data, err := s.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := ParseScene(data, Strict)
+	if err != nil || len(again.Probes) != 1 || *again.Probes[0] != *s.Probes[0] {
+		t.Errorf("The probes should be saved with the scene: %v", err)
+	}
+	outside := validScene[:len(validScene)-2] + `,
+	"probes": [{"position": {"x": 0, "y": 9, "z": 0}, "box": {"min": {"x": -1, "y": -1, "z": -1}, "max": {"x": 1, "y": 1, "z": 1}}}]
+}`
+	if _, _, err := ParseScene([]byte(outside), Strict); err == nil {
+		t.Error("A probe outside its box should be an error")
+	}
+}
@@ -0,0 +1,157 @@
+package scene
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Bounce is where a ray propagated through the scene hits a surface
+type Bounce struct {
+	// Depth is how many surfaces the ray hit before this one
+	Depth int
+	// Incoming is the ray that hits the surface, Distance how far along it
+	// the surface is, and Travelled the length of the whole path up to
+	// the surface, which is what times the arrival of sound rays
+	Incoming            math3d.LightRay
+	Distance, Travelled float64
+	// Point is where the ray hits the surface, and Normal the unit normal
+	// of the surface there, facing the incoming ray
+	Point, Normal math3d.Vector3
+	// Backface is true if the ray hits the back of the surface, the side
+	// the normal of the shape points away from, such as the inside of a
+	// sphere
+	Backface bool
+	// Shape is the shape hit and Index the index of the shape of the
+	// scene it's part of
+	Shape shape.Shape
+	Index int
+	// Material is the material of the surface at the point, for the
+	// propagators that ask it how the surface scatters
+	Material material.Material
+}
+
+// Scatter samples how the material of the surface scatters the ray, as
+// the light integrators do: it returns the direction the ray goes on in
+// and the fraction of its payload it carries, divided by the probability
+// of choosing the direction, or false if the material absorbs it.
+// Specular materials, such as mirrors, reflect or refract it; the others
+// take a direction their reflections are likely in.
+func (b *Bounce) Scatter(rng *sampling.Rand) (math3d.Vector3, image.Color, bool) {
+	out := b.Incoming.Direction.MultiplyV(-1)
+	if specular, ok := b.Material.(material.Specular); ok {
+		// Specular materials tell the inside from the outside by the
+		// normal of the shape
+		front := b.Normal
+		if b.Backface {
+			front = front.MultiplyV(-1)
+		}
+		in, weight := specular.Scatter(&front, &out, b.Incoming.Wavelength, rng.Float64())
+		return in, weight, weight != image.Black
+	}
+	in, pdf := b.Material.SampleDirection(&b.Normal, &out, rng.Float64(), rng.Float64())
+	cosine := in.DotV(b.Normal)
+	if pdf == 0 || cosine <= 0 {
+		return in, image.Black, false
+	}
+	brdf := b.Material.BRDF(&b.Normal, &in, &out)
+	return in, *brdf.Multiply(cosine / pdf), true
+}
+
+// Propagator follows a ray through the scene as Propagate traces it, and
+// holds the payload the ray carries, such as the energy of a sound ray or
+// the impulse response it adds to
+type Propagator interface {
+	// Bounce is called at every surface the ray hits, and returns the
+	// direction the ray goes on in from the point, or false to stop it
+	Bounce(b *Bounce, rng *sampling.Rand) (math3d.Vector3, bool)
+	// Escape is called when the ray hits nothing more, after travelling
+	// the given length and hitting depth surfaces
+	Escape(lr *math3d.LightRay, travelled float64, depth int)
+}
+
+// Propagate traces the ray through the shapes of the scene, bounce after
+// bounce, and lets the propagator decide where it goes at every surface it
+// hits, up to maxDepth surfaces, or for as long as the propagator goes on
+// if maxDepth is 0. It's the traversal of the light integrators without
+// their light, for whatever else travels along rays, such as sound. The
+// lights and the volumes of the scene don't matter to it.
+func (s *Scene) Propagate(lr math3d.LightRay, p Propagator, maxDepth int, rng *sampling.Rand) {
+	s.Prepare()
+	s.propagate(lr, p, maxDepth, rng)
+}
+
+// propagate traces the ray as Propagate does, with the scene prepared
+func (s *Scene) propagate(lr math3d.LightRay, p Propagator, maxDepth int, rng *sampling.Rand) {
+	travelled := 0.0
+	for depth := 0; maxDepth == 0 || depth < maxDepth; depth++ {
+		distance, sh, index := s.nearestShape(&lr)
+		if sh == nil {
+			p.Escape(&lr, travelled, depth)
+			return
+		}
+		travelled += distance
+		hit := shape.HitAt(sh, &lr, distance)
+		if hit.Backface {
+			hit.Normal = hit.Normal.MultiplyV(-1)
+		}
+		out := lr.Direction.MultiplyV(-1)
+		b := &Bounce{
+			Depth:     depth,
+			Incoming:  lr,
+			Distance:  distance,
+			Travelled: travelled,
+			Point:     hit.Point,
+			Normal:    hit.Normal,
+			Backface:  hit.Backface,
+			Shape:     sh,
+			Index:     index,
+			Material:  shape.MaterialAt(sh, &hit.Point, &hit.Normal, &out),
+		}
+		direction, ok := p.Bounce(b, rng)
+		if !ok {
+			return
+		}
+		// Rays that go through the surface leave it from the other side, and
+		// the ones it reflects from the point itself, ignoring the shape
+		// right there
+		source := hit.Point
+		if direction.DotV(hit.Normal) < 0 {
+			source = source.AddV(hit.Normal.MultiplyV(-specularOffset))
+		}
+		lr = math3d.LightRay{Source: source, Direction: direction.NormalizedV(), Origin: sh, Time: lr.Time, Wavelength: lr.Wavelength}
+	}
+}
+
+// PropagateAll propagates n rays through the scene as Propagate does, on
+// as many goroutines as the process may run at once. For every index up
+// to n, start returns the ray and its propagator, given the stream of
+// random numbers of the ray, which is the same whatever goroutine
+// propagates it. The propagators of different rays are called at once, so
+// they must not share payloads unless they guard them.
+func (s *Scene) PropagateAll(n, maxDepth int, seed uint64, start func(i int, rng *sampling.Rand) (math3d.LightRay, Propagator)) {
+	s.Prepare()
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				rng := sampling.New(seed, uint64(i))
+				lr, p := start(i, rng)
+				s.propagate(lr, p, maxDepth, rng)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
@@ -0,0 +1,107 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// recorder is a propagator that records the bounces of a ray, which
+// bounce says where to go next
+type recorder struct {
+	bounces []Bounce
+	escaped bool
+	depth   int
+	bounce  func(b *Bounce, rng *sampling.Rand) (math3d.Vector3, bool)
+}
+
+func (r *recorder) Bounce(b *Bounce, rng *sampling.Rand) (math3d.Vector3, bool) {
+	r.bounces = append(r.bounces, *b)
+	return r.bounce(b, rng)
+}
+
+func (r *recorder) Escape(lr *math3d.LightRay, travelled float64, depth int) {
+	r.escaped, r.depth = true, depth
+}
+
+// mirror bounces the rays as mirrors do
+func mirror(b *Bounce, rng *sampling.Rand) (math3d.Vector3, bool) {
+	return reflect(b.Incoming.Direction, b.Normal), true
+}
+
+func TestPropagate(t *testing.T) {
+	// Inside a sphere, a ray from the center comes back through it after
+	// every bounce
+	s := New()
+	s.AddShape(&shape.Sphere{Radius: 2})
+	r := &recorder{bounce: mirror}
+	s.Propagate(math3d.LightRay{Direction: math3d.Vector3{X: 0.6, Y: 0.8}}, r, 3, sampling.New(1, 0))
+	if len(r.bounces) != 3 || r.escaped {
+		t.Fatalf("The ray should bounce 3 times and stay inside, not %+v", r)
+	}
+	for i, b := range r.bounces {
+		distance := 4.0
+		if i == 0 {
+			distance = 2
+		}
+		if b.Depth != i || !b.Backface || b.Index != 0 || math.Abs(b.Travelled-float64(2+4*i)) > 1e-6 || math.Abs(b.Distance-distance) > 1e-6 {
+			t.Errorf("Unexpected bounce %d %+v", i, b)
+		}
+		if b.Normal.DotV(b.Incoming.Direction) >= 0 {
+			t.Errorf("The normal of bounce %d should face the ray, not %v", i, b.Normal)
+		}
+	}
+
+	// Rays that hit nothing escape
+	s = New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 5}, Radius: 1})
+	r = &recorder{bounce: mirror}
+	s.Propagate(math3d.LightRay{Source: math3d.Vector3{Z: 2}, Direction: math3d.UnitZ}, r, 0, sampling.New(1, 0))
+	if len(r.bounces) != 1 || !r.escaped || r.depth != 1 || r.bounces[0].Backface {
+		t.Errorf("The ray should bounce off the ball back out of the scene, not %+v", r)
+	}
+}
+
+func TestPropagateAll(t *testing.T) {
+	// Sound rays from the center of a room, losing half their energy on
+	// every bounce
+	s := New()
+	s.AddShape(&shape.Sphere{Radius: 2, Material: &material.Lambertian{Albedo: image.Color{R: 0.5, G: 0.5, B: 0.5}}})
+	const rays = 100
+	energy := make([][]float64, rays)
+	propagate := func() {
+		s.PropagateAll(rays, 4, 7, func(i int, rng *sampling.Rand) (math3d.LightRay, Propagator) {
+			direction := sampling.UniformCone(&math3d.UnitY, -1, rng.Float64(), rng.Float64())
+			carried := 1.0
+			return math3d.LightRay{Direction: direction}, &recorder{bounce: func(b *Bounce, rng *sampling.Rand) (math3d.Vector3, bool) {
+				if b.Depth == 0 && math.Abs(b.Travelled-2) > 1e-6 {
+					t.Errorf("Ray %d should hit the walls 2 away first, not %g", i, b.Travelled)
+				}
+				in, weight, ok := b.Scatter(rng)
+				carried *= weight.R
+				energy[i] = append(energy[i], carried)
+				return in, ok
+			}}
+		})
+	}
+	propagate()
+	first := make([]float64, rays)
+	for i, e := range energy {
+		if len(e) != 4 || math.Abs(e[3]-0.0625) > 1e-9 {
+			t.Fatalf("Ray %d should bounce 4 times down to a sixteenth of its energy, not %v", i, e)
+		}
+		first[i] = e[0]
+	}
+	energy = make([][]float64, rays)
+	propagate()
+	for i := range energy {
+		if energy[i][0] != first[i] {
+			t.Errorf("Ray %d should propagate the same every time", i)
+		}
+	}
+}
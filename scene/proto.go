@@ -0,0 +1,231 @@
+package scene
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/proto/scenepb"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// ParseSceneProto parses a scene encoded as the Scene message of
+// proto/scene.proto. The message mirrors the scene file format, so it's
+// turned into the map of the scene file it stands for and checked in the
+// mode as ParseScene checks scene files, with the same warnings.
+func ParseSceneProto(data []byte, mode ParseMode) (*Scene, []string, error) {
+	var message scenepb.Scene
+	if err := message.Unmarshal(data); err != nil {
+		return nil, nil, err
+	}
+	return parseSceneMap(protoSceneMap(&message), mode, "")
+}
+
+// LoadSceneProto loads a scene object from its Scene message, as
+// LoadScene loads one from the contents of a scene file
+func LoadSceneProto(bytes []byte) *Scene {
+	return mustLoad(ParseSceneProto(bytes, Lenient))
+}
+
+// ShapeFromProto returns the shape of the message, checked as ParseScene
+// checks the shapes of scene files in strict mode
+func ShapeFromProto(message *scenepb.Shape) (shape.Shape, error) {
+	p := &parser{mode: Strict}
+	shapes, err := p.parseShape("shape", protoShapeMap(message))
+	if err != nil {
+		return nil, err
+	}
+	if len(shapes) != 1 {
+		return nil, fmt.Errorf("shape: the message holds %d shapes", len(shapes))
+	}
+	return shapes[0], nil
+}
+
+// LightFromProto returns the light of the message, checked as ParseScene
+// checks the lights of scene files in strict mode
+func LightFromProto(message *scenepb.Light) (lighting.Light, error) {
+	p := &parser{mode: Strict}
+	return p.parseLight("light", protoLightMap(message))
+}
+
+// CameraFromProto returns the camera of the message, which must be valid
+func CameraFromProto(message *scenepb.Camera) (camera.PinHole, error) {
+	var ph camera.PinHole
+	if message == nil {
+		return ph, errors.New("camera: missing")
+	}
+	p := &parser{mode: Strict}
+	if err := p.parseCamera(protoCameraMap(message), &ph); err != nil {
+		return ph, err
+	}
+	if err := ph.Validate(); err != nil {
+		return ph, fmt.Errorf("camera: %v", err)
+	}
+	return ph, nil
+}
+
+// protoSceneMap returns the map of the scene file the message stands for,
+// as encoding/json would decode it. The messages that are missing leave
+// their keys out, so the parser reports the ones that are required.
+func protoSceneMap(message *scenepb.Scene) map[string]interface{} {
+	m := map[string]interface{}{}
+	if message.Camera != nil {
+		m["camera"] = protoCameraMap(message.Camera)
+	}
+	shapes := make([]interface{}, 0, len(message.Shapes))
+	for _, s := range message.Shapes {
+		shapes = append(shapes, protoShapeMap(s))
+	}
+	m["shapes"] = shapes
+	lights := make([]interface{}, 0, len(message.Lights))
+	for _, l := range message.Lights {
+		lights = append(lights, protoLightMap(l))
+	}
+	m["lights"] = lights
+	if med := message.Medium; med != nil {
+		medium := map[string]interface{}{"g": med.G}
+		putColor(medium, "absorption", med.Absorption)
+		putColor(medium, "scattering", med.Scattering)
+		m["medium"] = medium
+	}
+	if r := message.Render; r != nil {
+		render := map[string]interface{}{}
+		for key, value := range map[string]float64{
+			"samples": float64(r.Samples), "minsamples": float64(r.MinSamples), "adaptivethreshold": r.AdaptiveThreshold,
+			"volumestep": r.VolumeStep, "seed": float64(r.Seed), "maxdepth": float64(r.MaxDepth), "photons": float64(r.Photons),
+			"photonradius": r.PhotonRadius, "aorays": float64(r.AoRays), "aodistance": r.AoDistance,
+		} {
+			if value != 0 {
+				render[key] = value
+			}
+		}
+		if r.ColorSpace != "" {
+			render["colorspace"] = r.ColorSpace
+		}
+		if r.Integrator != "" {
+			render["integrator"] = r.Integrator
+		}
+		m["render"] = render
+	}
+	return m
+}
+
+// protoCameraMap returns the map of the camera in the message
+func protoCameraMap(c *scenepb.Camera) map[string]interface{} {
+	camera := map[string]interface{}{"fieldofview": c.FieldOfView, "viewplanedistance": c.ViewPlaneDistance}
+	putVector(camera, "focalpoint", c.FocalPoint)
+	putVector(camera, "up", c.Up)
+	putVector(camera, "right", c.Right)
+	putVector(camera, "towards", c.Towards)
+	return camera
+}
+
+// protoLightMap returns the map of the light in the message, without a
+// type if it holds none
+func protoLightMap(message *scenepb.Light) map[string]interface{} {
+	light := map[string]interface{}{}
+	switch v := message.GetLight().(type) {
+	case *scenepb.Light_Point:
+		light["type"] = "point"
+		putVector(light, "position", v.Point.GetPosition())
+		putColor(light, "intensity", v.Point.GetIntensity())
+	case *scenepb.Light_Sphere:
+		light["type"] = "sphere"
+		putVector(light, "position", v.Sphere.GetPosition())
+		light["radius"] = v.Sphere.GetRadius()
+		putColor(light, "radiance", v.Sphere.GetRadiance())
+		light["twosided"] = v.Sphere.GetTwoSided()
+	}
+	return light
+}
+
+// protoShapeMap returns the map of the shape in the message, without a
+// type if it holds none
+func protoShapeMap(message *scenepb.Shape) map[string]interface{} {
+	shape := map[string]interface{}{}
+	var name string
+	var mat *scenepb.Material
+	switch v := message.GetShape().(type) {
+	case *scenepb.Shape_Sphere:
+		shape["type"] = "sphere"
+		putVector(shape, "position", v.Sphere.GetPosition())
+		shape["radius"] = v.Sphere.GetRadius()
+		name, mat = v.Sphere.GetName(), v.Sphere.GetMaterial()
+	case *scenepb.Shape_Heightfield:
+		h := v.Heightfield
+		shape["type"] = "heightfield"
+		putVector(shape, "position", h.GetPosition())
+		putVector(shape, "size", h.GetSize())
+		// The samples are split in rows of the columns, and the shape is
+		// invalid unless they fill the rows
+		heights := h.GetHeights()
+		rows := []interface{}{}
+		for columns := int(h.GetColumns()); columns > 0 && len(heights) > 0; {
+			row := make([]interface{}, 0, columns)
+			for len(row) < columns && len(heights) > 0 {
+				row = append(row, heights[0])
+				heights = heights[1:]
+			}
+			rows = append(rows, row)
+		}
+		if int32(len(rows)) != h.GetRows() {
+			rows = append(rows, []interface{}{})
+		}
+		shape["heights"] = rows
+		name, mat = h.GetName(), h.GetMaterial()
+	case *scenepb.Shape_Curve:
+		c := v.Curve
+		shape["type"] = "curve"
+		points := make([]interface{}, 0, len(c.GetPoints()))
+		for _, p := range c.GetPoints() {
+			points = append(points, protoVectorMap(p))
+		}
+		widths := make([]interface{}, 0, len(c.GetWidths()))
+		for _, w := range c.GetWidths() {
+			widths = append(widths, w)
+		}
+		shape["points"], shape["widths"] = points, widths
+		name, mat = c.GetName(), c.GetMaterial()
+	}
+	if name != "" {
+		shape["name"] = name
+	}
+	if mat != nil {
+		material := map[string]interface{}{}
+		switch v := mat.GetMaterial().(type) {
+		case *scenepb.Material_Lambertian:
+			material["type"] = "lambertian"
+			putColor(material, "albedo", v.Lambertian.GetAlbedo())
+		case *scenepb.Material_Glossy:
+			material["type"] = "glossy"
+			putColor(material, "albedo", v.Glossy.GetAlbedo())
+			material["exponent"] = v.Glossy.GetExponent()
+		case *scenepb.Material_Subsurface:
+			material["type"] = "subsurface"
+			putColor(material, "albedo", v.Subsurface.GetAlbedo())
+			putColor(material, "meanfreepath", v.Subsurface.GetMeanFreePath())
+		}
+		shape["material"] = material
+	}
+	return shape
+}
+
+// protoVectorMap returns the map of the vector in the message
+func protoVectorMap(v *scenepb.Vector3) map[string]interface{} {
+	return map[string]interface{}{"x": v.GetX(), "y": v.GetY(), "z": v.GetZ()}
+}
+
+// putVector puts the vector in the map as the key, unless it's missing
+func putVector(m map[string]interface{}, key string, v *scenepb.Vector3) {
+	if v != nil {
+		m[key] = protoVectorMap(v)
+	}
+}
+
+// putColor puts the color in the map as the key, unless it's missing
+func putColor(m map[string]interface{}, key string, c *scenepb.Color) {
+	if c != nil {
+		m[key] = map[string]interface{}{"r": c.R, "g": c.G, "b": c.B}
+	}
+}
@@ -0,0 +1,60 @@
+package scene
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/proto/scenepb"
+)
+
+// validSceneProto returns validScene as the message of proto/scene.proto
+func validSceneProto() *scenepb.Scene {
+	return &scenepb.Scene{
+		Camera: &scenepb.Camera{Up: &scenepb.Vector3{Y: 1}, Right: &scenepb.Vector3{X: 1}, Towards: &scenepb.Vector3{Z: 1},
+			FocalPoint: &scenepb.Vector3{Z: -1}, FieldOfView: 1.5, ViewPlaneDistance: 1},
+		Lights: []*scenepb.Light{{Light: &scenepb.Light_Point{Point: &scenepb.PointLight{
+			Position: &scenepb.Vector3{Y: 2}, Intensity: &scenepb.Color{R: 1, G: 1, B: 1}}}}},
+		Shapes: []*scenepb.Shape{{Shape: &scenepb.Shape_Sphere{Sphere: &scenepb.Sphere{Position: &scenepb.Vector3{Z: 3}, Radius: 1}}}},
+	}
+}
+
+func TestParseSceneProtoGivesTheSameScene(t *testing.T) {
+	want, _, err := ParseScene([]byte(validScene), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, warnings, err := ParseSceneProto(validSceneProto().Marshal(), Strict)
+	if err != nil || len(warnings) > 0 {
+		t.Fatalf("the scene should be valid: %v %v", err, warnings)
+	}
+	wantJSON, _ := want.Marshal()
+	gotJSON, _ := got.Marshal()
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("the scene of the message should be the scene of the file it mirrors, not\n%s", gotJSON)
+	}
+}
+
+func TestParseSceneProtoChecksTheScene(t *testing.T) {
+	field := validSceneProto()
+	field.Shapes = append(field.Shapes, &scenepb.Shape{Shape: &scenepb.Shape_Heightfield{Heightfield: &scenepb.Heightfield{
+		Position: &scenepb.Vector3{}, Size: &scenepb.Vector3{X: 1, Y: 1, Z: 1}, Columns: 2, Rows: 2, Heights: []float64{0, 1, 0}}}})
+	if _, _, err := ParseSceneProto(field.Marshal(), Strict); err == nil {
+		t.Error("a heightfield without a sample for every row and column shouldn't parse")
+	}
+	s, warnings, err := ParseSceneProto(field.Marshal(), Lenient)
+	if err != nil || len(s.Shapes) != 1 || len(warnings) != 1 {
+		t.Errorf("lenient mode should skip the heightfield with a warning, not keep %d shapes (%v %v)", len(s.Shapes), warnings, err)
+	}
+	field.Shapes[1].GetHeightfield().Heights = append(field.Shapes[1].GetHeightfield().Heights, 1)
+	if s, _, err := ParseSceneProto(field.Marshal(), Strict); err != nil || len(s.Shapes) != 2 {
+		t.Errorf("the heightfield with its samples should parse (%v)", err)
+	}
+	noCamera := validSceneProto()
+	noCamera.Camera = nil
+	if _, _, err := ParseSceneProto(noCamera.Marshal(), Lenient); err == nil {
+		t.Error("a scene without a camera can't be used")
+	}
+	if _, _, err := ParseSceneProto([]byte{0x0a, 0x05, 1}, Lenient); err == nil {
+		t.Error("a message cut short shouldn't parse")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package scene
+
+// raceEnabled is set when the tests run with the race detector, under which
+// sync.Pool drops items at random, so the pooled buffers allocate again
+const raceEnabled = true
@@ -0,0 +1,54 @@
+package scene
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	integratorsMu sync.RWMutex
+	integrators   = make(map[string]func(*Settings) Integrator)
+)
+
+// RegisterIntegrator registers an integrator, so that programs that use the
+// package can render scenes with integrators of their own by giving their
+// name in the settings, as "integrator" in scene files and configurations.
+// newIntegrator returns the integrator the settings ask for, as the
+// integrators of the package are made for every sample, so it must be
+// cheap. Registering is meant for the init
+// functions of programs, and panics if there's already an integrator with
+// the name.
+func RegisterIntegrator(name string, newIntegrator func(settings *Settings) Integrator) {
+	integratorsMu.Lock()
+	defer integratorsMu.Unlock()
+	if _, registered := integrators[name]; registered || builtinIntegrator(name) || name == "" {
+		panic(fmt.Sprintf("There's already an integrator %q", name))
+	}
+	integrators[name] = newIntegrator
+}
+
+// RegisteredIntegrator returns whether there's an integrator with the name
+// registered by RegisterIntegrator
+func RegisteredIntegrator(name string) bool {
+	_, ok := registeredIntegrator(name)
+	return ok
+}
+
+// registeredIntegrator returns the function that returns the integrator
+// registered with the name, and whether there's one
+func registeredIntegrator(name string) (func(*Settings) Integrator, bool) {
+	integratorsMu.RLock()
+	defer integratorsMu.RUnlock()
+	newIntegrator, ok := integrators[name]
+	return newIntegrator, ok
+}
+
+// builtinIntegrator returns whether the integrator with the name is one of
+// the package
+func builtinIntegrator(name string) bool {
+	switch name {
+	case Direct, Bidirectional, AmbientOcclusion, FixedPath, Toon, HiddenLine:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package scene
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// ball is a sphere of a type of shape of its own, as the ones programs
+// register
+type ball struct {
+	*shape.Sphere
+}
+
+func (b ball) AsMap() map[string]interface{} {
+	m := b.Sphere.AsMap()
+	m["type"] = "ball"
+	return m
+}
+
+// tinted is a lambertian material of a type of its own
+type tinted struct {
+	*material.Lambertian
+}
+
+func (t tinted) AsMap() map[string]interface{} {
+	return map[string]interface{}{"type": "tinted", "tint": t.Albedo.R}
+}
+
+// depthIntegrator shades everything with the maximum depth of the
+// settings it was made with
+type depthIntegrator struct {
+	depth int
+}
+
+func (d depthIntegrator) Radiance(s *Scene, lr *math3d.LightRay, rng *sampling.Rand) image.Color {
+	return image.Color{R: float64(d.depth) / 10}
+}
+
+func init() {
+	shape.RegisterPrimitive("ball", []string{"type", "position", "radius", "material"}, func(m map[string]interface{}) (shape.Shape, error) {
+		m["type"] = "sphere"
+		s, err := shape.SphereFromMap(m)
+		if err != nil {
+			return nil, err
+		}
+		return ball{s}, nil
+	})
+	material.RegisterMaterial("tinted", []string{"type", "tint"}, func(m map[string]interface{}) material.Material {
+		tint := m["tint"].(float64)
+		return tinted{&material.Lambertian{Albedo: image.Color{R: tint, G: tint, B: tint}}}
+	})
+	RegisterIntegrator("depth", func(settings *Settings) Integrator {
+		return depthIntegrator{depth: settings.MaxDepth}
+	})
+}
+
+// withCamera returns the scene file with the keys, and the camera and the
+// lights of validScene
+func withCamera(keys string) string {
+	return validScene[:strings.Index(validScene, `"shapes"`)] + keys + "}"
+}
+
+func TestRegisteredTypes(t *testing.T) {
+	file := withCamera(`"shapes": [{"type": "ball", "position": {"x": 0, "y": 0, "z": 3}, "radius": 1,
+		"material": {"type": "tinted", "tint": 0.5}}],
+		"render": {"integrator": "depth", "maxdepth": 4}`)
+	s, warnings, err := ParseScene([]byte(file), Strict)
+	if err != nil || len(warnings) > 0 {
+		t.Fatalf("A scene of registered types should parse, got %v and %v", err, warnings)
+	}
+	b, ok := s.Shapes[0].(ball)
+	if !ok {
+		t.Fatalf("The shape should be a ball, not %T", s.Shapes[0])
+	}
+	if mat, ok := b.Material.(tinted); !ok || mat.Albedo.R != 0.5 {
+		t.Errorf("The ball should be tinted by 0.5, not %#v", b.Material)
+	}
+	if c := s.TraceScene(4, 4).NRGBAAt(2, 2); c.R == 0 || c.G != 0 {
+		t.Errorf("The registered integrator should render red, not %v", c)
+	}
+
+	// The scene is written back with the registered types
+	data, err := s.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ParseScene(data, Strict); err != nil || !strings.Contains(string(data), `"ball"`) {
+		t.Errorf("The written scene should parse back with its ball, got %v for %s", err, data)
+	}
+
+	for _, invalid := range []string{
+		`"shapes": [{"type": "ball", "position": {"x": 0, "y": 0, "z": 3}, "radius": 1, "height": 2}]`,
+		`"shapes": [{"type": "ball", "position": {"x": 0, "y": 0, "z": 3}, "radius": 1, "material": {"type": "tinted"}}]`,
+		`"render": {"integrator": "unknown"}`,
+	} {
+		if _, _, err := ParseScene([]byte(withCamera(invalid)), Strict); err == nil {
+			t.Errorf("%s shouldn't parse", invalid)
+		}
+	}
+}
+
+func TestRegisteringTwicePanics(t *testing.T) {
+	for name, register := range map[string]func(){
+		"builtin shape":      func() { shape.RegisterPrimitive("sphere", nil, nil) },
+		"registered shape":   func() { shape.RegisterPrimitive("ball", nil, nil) },
+		"builtin material":   func() { material.RegisterMaterial("glossy", nil, nil) },
+		"builtin integrator": func() { RegisterIntegrator(Bidirectional, nil) },
+		"registered integrator": func() {
+			RegisterIntegrator("depth", nil)
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Registering a %s again should panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
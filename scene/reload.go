@@ -0,0 +1,101 @@
+package scene
+
+import (
+	"encoding/json"
+
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// DiffFrom compares the scene with an older version of it, such as the
+// one of a scene file before it was edited, and marks what changed since
+// as dirty, as if the old scene had been edited into this one, so that
+// DirtyRegion returns the pixels to trace again. Shapes that were added,
+// removed or changed, such as by tweaking their materials, dirty where
+// they are seen and may cast shadows, and changes to anything else, the
+// camera, the lights or the render settings, dirty the whole render. The
+// samples per pixel don't change the samples already taken, so they are
+// left out. Whatever was dirty before is forgotten. It returns whether
+// anything else changed.
+func (s *Scene) DiffFrom(old *Scene) bool {
+	s.MarkTraced()
+	if !sameJSON(s.globals(), old.globals()) {
+		s.dirtyAll = true
+		return true
+	}
+	// Shapes are told apart by their scene file representation, so moving
+	// one to another index in the file changes nothing
+	unchanged := make(map[string]int)
+	for _, sh := range old.Shapes {
+		if key := shapeKey(sh); key != "" {
+			unchanged[key]++
+		}
+	}
+	var added []shape.Shape
+	for _, sh := range s.Shapes {
+		if key := shapeKey(sh); key != "" && unchanged[key] > 0 {
+			unchanged[key]--
+			continue
+		}
+		added = append(added, sh)
+	}
+	for _, sh := range added {
+		s.markDirty(sh.Bounds())
+	}
+	changed := len(added) > 0
+	// The old shapes left are the ones that were removed or changed
+	for _, sh := range old.Shapes {
+		key := shapeKey(sh)
+		if key != "" && unchanged[key] == 0 {
+			continue
+		}
+		if key != "" {
+			unchanged[key]--
+		}
+		s.markDirty(sh.Bounds())
+		changed = true
+	}
+	return changed
+}
+
+// globals returns everything of the scene that changes the whole render
+// when it changes, in the scene file format
+func (s *Scene) globals() interface{} {
+	settings := s.Settings
+	settings.Samples = 0
+	return struct {
+		Camera   interface{}
+		Lights   []map[string]interface{}
+		Medium   interface{}
+		Volumes  interface{}
+		Section  interface{}
+		Settings Settings
+	}{s.Camera, lighting.AsMap(s.Lights), s.Medium, s.Volumes, s.Section, settings}
+}
+
+// shapeKey returns the shape in the scene file format, with its bounds,
+// which tell apart the delayed shapes whose sources were loaded
+func shapeKey(sh shape.Shape) string {
+	data, err := json.Marshal(struct {
+		Shape  map[string]interface{}
+		Bounds *math3d.AABB
+	}{sh.AsMap(), sh.Bounds()})
+	if err != nil {
+		// Shapes that can't be written, such as the ones with infinite
+		// values, are always different
+		return ""
+	}
+	return string(data)
+}
+
+// sameJSON returns whether a and b have the same JSON, and are written
+// without errors
+func sameJSON(a, b interface{}) bool {
+	first, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	second, err := json.Marshal(b)
+	return err == nil && string(first) == string(second)
+}
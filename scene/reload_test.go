@@ -0,0 +1,42 @@
+package scene
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestDiffFrom(t *testing.T) {
+	old := testScene()
+	render := old.TraceScene(64, 64)
+
+	same := testScene()
+	if same.DiffFrom(old) || !same.DirtyRegion(64, 64).Empty() {
+		t.Error("Reloading the same scene shouldn't change anything")
+	}
+
+	// Tweaking the material of a small sphere only dirties around it
+	tweaked := testScene()
+	tweaked.Shapes[0].(*shape.Sphere).Material = &material.Lambertian{Albedo: image.Color{R: 1}}
+	if !tweaked.DiffFrom(old) {
+		t.Fatal("Tweaking a material should change the scene")
+	}
+	region := tweaked.DirtyRegion(64, 64)
+	if region.Empty() || region.Dx() == 64 && region.Dy() == 64 {
+		t.Errorf("Tweaking the material of a small sphere should dirty part of the render, not %v", region)
+	}
+	tweaked.TraceRegion(render, region)
+	if !bytes.Equal(render.Pix, tweaked.TraceScene(64, 64).Pix) {
+		t.Error("Tracing the dirty region again should match tracing the whole scene")
+	}
+
+	moved := testScene()
+	moved.Camera.FocalPoint = math3d.Vector3{X: 0.1}
+	if !moved.DiffFrom(old) || moved.DirtyRegion(64, 64).Dx() != 64 || moved.DirtyRegion(64, 64).Dy() != 64 {
+		t.Error("Moving the camera should dirty the whole render")
+	}
+}
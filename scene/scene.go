@@ -3,27 +3,120 @@ package scene
 import (
 	"encoding/json"
 	"fmt"
+	stdimg "image"
 	"io/ioutil"
+	"log"
 	"math"
+	"sync/atomic"
 
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/accel/gpu"
 	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/cbor"
 	"github.com/ProjectMOA/goraytrace/image"
 	"github.com/ProjectMOA/goraytrace/lighting"
-	"github.com/ProjectMOA/goraytrace/maputil"
+	"github.com/ProjectMOA/goraytrace/material"
 	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/medium"
+	"github.com/ProjectMOA/goraytrace/photon"
+	"github.com/ProjectMOA/goraytrace/sampling"
 	"github.com/ProjectMOA/goraytrace/shape"
 )
 
 // Scene defines a 3D scene that holds volumetric shapes
 type Scene struct {
-	Camera camera.PinHole        `json:"camera"`
-	Shapes []shape.Shape         `json:"shapes"`
-	Lights []lighting.PointLight `json:"lights"`
+	// samples, rays and shadowRays count what's traced, atomically, and
+	// traversal the work of the acceleration structure when Settings.Stats
+	// is set. They come first so that they are 64 bit aligned on 32 bit
+	// platforms.
+	samples, rays, shadowRays uint64
+	traversal                 accel.Counters
+
+	Camera camera.PinHole   `json:"camera"`
+	Shapes []shape.Shape    `json:"shapes"`
+	Lights []lighting.Light `json:"lights"`
+	// Nodes are the top of the scene graph, which names groups of the
+	// shapes. They must be changed with AddNode and SetNodeTransform.
+	Nodes []*Node `json:"-"`
+	// Medium fills the space between shapes when it isn't nil
+	Medium *medium.Homogeneous `json:"medium,omitempty"`
+	// Volumes are the media of varying density, such as smoke, inside
+	// boxes of the scene. Only the direct lighting integrator renders them.
+	Volumes []*medium.Grid `json:"volumes,omitempty"`
+	// Probes are the reflection probes of the rooms of the scene, which the
+	// rough glossy materials reflect with the direct lighting integrator
+	// if the settings have a ProbeExponent
+	Probes []*Probe `json:"probes,omitempty"`
+	// Section cuts the shapes to show their insides when it isn't nil. It
+	// must be changed with SetSection once the scene was traced.
+	Section *Section `json:"section,omitempty"`
+	// Settings control how the scene is rendered
+	Settings Settings `json:"render"`
+
+	// structure accelerates the intersection tests against Shapes, with
+	// the accelerator of the settings. It is built lazily and thrown away
+	// when shapes are added or removed.
+	structure accel.Accelerator
+	// moving holds the shapes that were moved, which the two level
+	// structure gives instances of their own
+	moving map[shape.Shape]bool
+	// meshes holds the group of every shape that was deformed or set to
+	// build fast, shared by the shapes deformed or set together, which the
+	// two level structure gives an instance per group. meshCount is the
+	// number of groups so far.
+	meshes    map[shape.Shape]int
+	meshCount int
+	// fast holds the shapes whose instances build fast
+	fast map[shape.Shape]bool
+	// filters holds the filters of the hits on the shapes that have one
+	filters map[shape.Shape]HitFilter
+	// culling is whether the triangles of the shapes in the structure cull
+	// their back faces
+	culling bool
+	// shutter is the shutter of the settings the bounds of the moving
+	// triangles in the structure cover
+	shutter float64
+	// splitBudget is the split budget of the settings the structure was
+	// built with, if it's an SBVH
+	splitBudget float64
+	// lightTree chooses the lights that light a point in scenes with many
+	// lights. It's built lazily and thrown away when lights change.
+	lightTree *lighting.Tree
+	// distantLights are the lights left out of the tree, which light every
+	// point alike
+	distantLights []lighting.Light
+	// caustics holds the photons of the caustics. It's traced lazily and
+	// thrown away when shapes or lights change.
+	caustics *photon.Map
+	// probeMaps holds the cube maps of the probes. They're captured lazily
+	// and thrown away when shapes or lights change.
+	probeMaps []*probeMap
+	// dirty holds the world space regions that changed since the last render
+	dirty    []math3d.AABB
+	dirtyAll bool
+	// fileSettings holds the keys of the render settings that the scene
+	// file set
+	fileSettings map[string]bool
+	// shared is whether the scene is a view of a prepared scene, which
+	// shares its shapes and its acceleration structure with the other
+	// views, so neither can change
+	shared bool
 }
 
+// manyLights is the number of lights above which every point is lit by
+// one light chosen with the light tree rather than by all of them, so the
+// time it takes to light a point doesn't grow with the number of lights
+const manyLights = 8
+
 // New creates a new empty scene with a default pinhole camera
 func New() *Scene {
-	return &Scene{Camera: camera.DefaultPinHole(), Shapes: make([]shape.Shape, 0, 10)}
+	return &Scene{Camera: camera.DefaultPinHole(), Shapes: make([]shape.Shape, 0, 10), Settings: DefaultSettings()}
+}
+
+// SetsSetting returns true if the scene file the scene was loaded from
+// sets the render setting with the key, rather than leaving it default
+func (s *Scene) SetsSetting(key string) bool {
+	return s.fileSettings[key]
 }
 
 // Elements returns the number of elements in the scene
@@ -33,134 +126,702 @@ func (s *Scene) Elements() int {
 
 // AddShape adds a shape to the scene.
 func (s *Scene) AddShape(aShape shape.Shape) {
+	s.mustBeEditable()
 	s.Shapes = append(s.Shapes, aShape)
+	s.markDirty(aShape.Bounds())
+	s.structure = nil
 }
 
 // AddLight adds a light to the scene.
-func (s *Scene) AddLight(aLightsource lighting.PointLight) {
+func (s *Scene) AddLight(aLightsource lighting.Light) {
+	s.mustBeEditable()
 	s.Lights = append(s.Lights, aLightsource)
+	s.lightTree, s.caustics, s.probeMaps = nil, nil, nil
+	s.dirtyAll = true
 }
 
 // TraceScene traces the scene as it currently is, returning
-// the final image.
+// the final image. Only the pixels in the crop window of the settings are
+// traced.
 func (s *Scene) TraceScene(width, height int) *image.Image {
 	targetIt := s.Camera.GetIterator(width, height)
+	window := s.Settings.CropWindow(width, height)
 	var x, y int
-	var point *math3d.Vector3
 	render := image.New(width, height)
 	for targetIt.HasNext() {
-		point, x, y = targetIt.Next()
-		s.traceRay(point, x, y, render)
+		_, x, y = targetIt.Next()
+		if (stdimg.Point{X: x, Y: y}).In(window) {
+			render.Set(x, y, s.encodedPixel(targetIt, x, y))
+		}
 	}
+	s.MarkTraced()
 
 	return render
 }
 
-func (s *Scene) traceRay(p *math3d.Vector3, x int, y int, img *image.Image) {
-	// Construct the light ray
-	lr := &math3d.LightRay{Direction: *p.Subtract(&s.Camera.FocalPoint).Normalized(), Source: *p}
-	// Check intersections with the shapes in the scene
-	nearestDistance, nearestShape := s.getNearestIntersection(lr)
+// Prepare builds the acceleration structures of the scene, traces the
+// photons of its caustics and captures its reflection probes, which is
+// otherwise done when it's first traced. After it, TraceRegion can be
+// called from several goroutines at once as long as the scene isn't
+// edited meanwhile.
+func (s *Scene) Prepare() {
+	if structure := s.accelerator(); !s.shared {
+		structure.Count(s.counters())
+	}
+	if len(s.Lights) > manyLights {
+		s.lightSampler()
+	}
+	s.causticMap()
+	s.reflectionProbes()
+}
+
+// Stats returns the number of samples and of rays, camera rays and shadow
+// rays alike, traced since the scene was created. It can be called while
+// the scene is traced.
+func (s *Scene) Stats() (samples, rays uint64) {
+	return atomic.LoadUint64(&s.samples), atomic.LoadUint64(&s.rays)
+}
+
+// MarkTraced records that the whole scene was traced as it is now, so
+// DirtyRegion only holds the changes made from now on.
+func (s *Scene) MarkTraced() {
+	s.dirty, s.dirtyAll = nil, false
+}
+
+// TracePixel returns the radiance of the pixel x, y averaging the number
+// of samples in the settings. It's linear, as the radiance is before
+// Encode turns it into the pixel of a render.
+func (s *Scene) TracePixel(targetIt *camera.TracingTargetIterator, x, y int) image.Color {
+	radiance, _ := s.samplePixel(targetIt, x, y)
+	return radiance
+}
+
+// samplePixel returns the radiance of the pixel x, y and the number of
+// samples it took to estimate it. The outliers among the samples are left
+// out of the radiance if the settings reject them.
+func (s *Scene) samplePixel(targetIt *camera.TracingTargetIterator, x, y int) (image.Color, int) {
+	if s.Settings.Samples <= 1 {
+		sc := getScratch()
+		defer sc.release()
+		sc.rng.SeedSample(s.Settings.Seed, x, y, 0)
+		return s.traceCameraRay(targetIt, x, y, 0.5, 0.5, &sc.rng), 1
+	}
+	radiance := image.Color{}
+	// Running mean and variance of the luminance (Welford's algorithm)
+	var mean, m2 float64
+	n := 0
+	var samples []image.Color
+	if s.Settings.OutlierThreshold > 0 {
+		samples = make([]image.Color, 0, s.Settings.Samples)
+	}
+	for n < s.Settings.Samples {
+		sample := s.TraceSample(targetIt, x, y, n)
+		radiance = *radiance.Add(&sample)
+		if samples != nil {
+			samples = append(samples, sample)
+		}
+		n++
 
-	if nearestDistance != math.MaxFloat64 {
-		// The lightray intersected a shape
-		intersection := lr.Source.Add(lr.Direction.Multiply(nearestDistance))
-		// Calculate the radiance at the intersection
-		radiance := s.calculateRadianceAt(intersection, lr, nearestShape)
-		img.Set(x, y, radiance.ToNRGBA())
-	} else {
-		// The lightray didn't intersect any shape. Just fill the pixel in black
-		img.Set(x, y, image.Black.ToNRGBA())
+		luminance := sample.Luminance()
+		delta := luminance - mean
+		mean += delta / float64(n)
+		m2 += delta * (luminance - mean)
+		if s.converged(n, mean, m2) {
+			break
+		}
+	}
+	if samples != nil {
+		return rejectOutliers(samples, mean+s.Settings.OutlierThreshold*math.Sqrt(m2/float64(n-1))), n
 	}
+	return *radiance.Divide(float64(n)), n
 }
 
-func (s *Scene) calculateRadianceAt(intersection *math3d.Vector3, incidentalRay *math3d.LightRay, sh shape.Shape) image.Color {
-	// trace shadow rays towards all light sources
+// rejectOutliers returns the mean of the samples whose luminance isn't
+// above the limit. They can't all be, as long as the limit isn't below
+// their mean luminance.
+func rejectOutliers(samples []image.Color, limit float64) image.Color {
 	radiance := image.Color{}
-	for _, ls := range s.Lights {
-		pointToLightVector := ls.Position.Subtract(intersection)
-		shadowRay := math3d.LightRay{Direction: *pointToLightVector.Normalized(), Source: *intersection}
-		if !s.inShadow(&shadowRay, pointToLightVector.Abs()) {
-			normal := sh.NormalAt(&ls.Position).Normalized()
-			// Cosine of the ray of light with the visible normal.
-			cosine := shadowRay.Direction.Dot(normal)
-			if cosine > 0.0 {
-				reflected := incidentalRay.Direction.Multiply(-1).Reflect(normal)
-				rCosine := math3d.Clamp(incidentalRay.Direction.Dot(reflected), 0, 1)
-				shiny := 0.0
-				// Prepared to use phong materials
-				phong := image.White.Divide(math.Pi).Add(image.Black.Multiply((shiny + 2) / (2 * math.Pi) * math.Pow(rCosine, shiny)))
-				radiance = *radiance.Add(ls.Intensity.CMultiply(phong).Multiply(cosine))
-			}
+	kept := 0
+	for i := range samples {
+		if samples[i].Luminance() <= limit {
+			radiance = *radiance.Add(&samples[i])
+			kept++
+		}
+	}
+	return *radiance.Divide(float64(kept))
+}
+
+// TraceSample returns the radiance of the sample n of the pixel x, y,
+// through a random point of the pixel. A sample is the same in every
+// render of the scene with the same seed, so samples can be accumulated
+// over several passes.
+func (s *Scene) TraceSample(targetIt *camera.TracingTargetIterator, x, y, n int) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	sc.rng.SeedSample(s.Settings.Seed, x, y, n)
+	return s.traceCameraRay(targetIt, x, y, sc.rng.Float64(), sc.rng.Float64(), &sc.rng)
+}
+
+// traceCameraRay returns the radiance that reaches the camera through the
+// point u, v of the pixel x, y, or black where the toon or the hidden line
+// integrator draws an outline and where transparent renders see no shape
+func (s *Scene) traceCameraRay(targetIt *camera.TracingTargetIterator, x, y int, u, v float64, rng *sampling.Rand) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	ray, differential := targetIt.RayAndDifferential(x, y, u, v)
+	lr := sc.ray(ray)
+	// The more samples a pixel takes, the smaller the footprint of each
+	// of them, down to an eighth of the pixel
+	if samples := s.Settings.Samples; samples > 1 {
+		differential = differential.ScaledV(math.Max(1/math.Sqrt(float64(samples)), 0.125))
+	}
+	lr.Differential = sc.differential(differential)
+	width := s.Settings.outlineWidth()
+	if (s.Settings.Integrator == Toon || s.Settings.Integrator == HiddenLine) && width > 0 &&
+		s.onOutline(targetIt, x, y, u, v, width, lr) {
+		return image.Black
+	}
+	if s.Settings.Transparent {
+		// The background is left out of transparent renders
+		if distance, _ := s.getNearestIntersection(lr); distance == math.MaxFloat64 {
+			return image.Black
+		}
+	}
+	return s.traceRay(lr, rng, &sc.integrators)
+}
+
+// converged returns true if adaptive sampling can stop sampling a pixel
+// after n samples with the given mean and sum of squared differences.
+func (s *Scene) converged(n int, mean, m2 float64) bool {
+	if s.Settings.AdaptiveThreshold <= 0 || n < s.Settings.MinSamples || n < 2 {
+		return false
+	}
+	variance := m2 / float64(n-1)
+	halfWidth := 1.96 * math.Sqrt(variance/float64(n))
+	// Dark pixels would otherwise need endless samples to meet a relative
+	// threshold, so the mean is never taken as less than a small floor.
+	return halfWidth <= s.Settings.AdaptiveThreshold*math.Max(mean, 1e-3)
+}
+
+// traceRay returns the radiance that reaches the camera along the camera
+// ray lr. rng provides the random numbers of the sample, and the
+// integrator is set up in integrators.
+func (s *Scene) traceRay(lr *math3d.LightRay, rng *sampling.Rand, integrators *builtinIntegrators) image.Color {
+	atomic.AddUint64(&s.samples, 1)
+	if s.Settings.Shutter > 0 {
+		lr.Time = s.Settings.Shutter * rng.Float64()
+	}
+	if s.Settings.Wavelengths > 0 {
+		lr.Wavelength = image.MinWavelength + (image.MaxWavelength-image.MinWavelength)*rng.Float64()
+		radiance := s.integratorIn(integrators).Radiance(s, lr, rng)
+		sensor := image.SpectralWeight(lr.Wavelength, s.Settings.Wavelengths)
+		return *radiance.CMultiply(&sensor)
+	}
+	return s.integratorIn(integrators).Radiance(s, lr, rng)
+}
+
+// calculateRadianceAt returns the radiance that leaves the intersection
+// with the shape towards the source of the incidental ray
+func (s *Scene) calculateRadianceAt(intersection *math3d.Vector3, incidentalRay *math3d.LightRay, sh shape.Shape, rng *sampling.Rand) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	normal := sc.vector(sh.NormalAt(intersection).NormalizedV())
+	origin := sc.vector(shape.ShadowOrigin(sh, intersection))
+	if s.Settings.Backfaces == TwoSided && normal.DotV(incidentalRay.Direction) > 0 {
+		// Smooth shaded shapes move the shadow origin off the front, so
+		// from the back the rays leave the intersection itself
+		*normal, *origin = normal.MultiplyV(-1), *intersection
+	}
+	out := sc.vector(incidentalRay.Direction.MultiplyV(-1))
+	hit := sc.hit(shape.Hit{Point: *intersection, Normal: *normal})
+	if _, varying := shape.MaterialOf(sh).(material.Varying); varying && incidentalRay.Differential != nil {
+		// The footprint of the pixel filters the textures
+		footprint := shape.HitAt(sh, incidentalRay, intersection.SubtractV(incidentalRay.Source).Abs())
+		hit.DPDX, hit.DPDY = footprint.DPDX, footprint.DPDY
+	}
+	mat := shape.MaterialAtHit(sh, hit, out)
+	if subsurface, ok := mat.(*material.Subsurface); ok {
+		return s.subsurfaceRadiance(intersection, normal, sh, subsurface, incidentalRay.Time, rng)
+	}
+	radiance := image.Color{}
+	s.forLights(origin, normal, rng, func(ls lighting.Light, weight float64) {
+		direct := s.directLight(sh, origin, normal, out, mat, ls, incidentalRay.Time, rng)
+		radiance = *radiance.Add(direct.Multiply(weight))
+	})
+	if glossy, ok := mat.(*material.Glossy); ok && s.rendersProbes() {
+		reflection := s.probeReflection(intersection, normal, out, glossy)
+		radiance = *radiance.Add(&reflection)
+	}
+	if caustics := s.causticMap(); caustics != nil {
+		if _, lambertian := mat.(*material.Lambertian); lambertian {
+			caustic := s.causticRadiance(caustics, intersection, normal, out, mat)
+			radiance = *radiance.Add(&caustic)
 		}
 	}
 	return radiance
 }
 
+// forLights calls f with the lights that light point and the weights of
+// their light in an estimate of the light arriving at point. With few
+// lights these are all of them with a weight of 1. With many, it's the
+// distant lights with a weight of 1 and one of the others chosen with the
+// light tree, weighted by one over the probability of choosing it. normal
+// is the normal of the surface at point, or nil if point isn't on a
+// surface.
+func (s *Scene) forLights(point, normal *math3d.Vector3, rng *sampling.Rand, f func(ls lighting.Light, weight float64)) {
+	if len(s.Lights) <= manyLights {
+		for _, ls := range s.Lights {
+			f(ls, 1)
+		}
+		return
+	}
+	tree := s.lightSampler()
+	for _, ls := range s.distantLights {
+		f(ls, 1)
+	}
+	if ls, probability := tree.Sample(point, normal, rng.Float64()); ls != nil {
+		f(ls, 1/probability)
+	}
+}
+
+// directLight returns the radiance of the light ls reflected by the
+// material at point towards out. It combines a direction sampled from the
+// light with one sampled from the material using multiple importance
+// sampling: light sampling finds small lights and material sampling finds
+// the lights in the reflections of glossy materials, and weighting both
+// with the power heuristic keeps the best of each. point is on the shape
+// from, which the rays leaving it ignore right at point, and time is when
+// the light arrives.
+func (s *Scene) directLight(from shape.Shape, point, normal, out *math3d.Vector3, mat material.Material, ls lighting.Light, time float64, rng *sampling.Rand) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	radiance := image.Color{}
+	sample, irradiance, ok := s.lightArriving(from, point, normal, ls, time, rng)
+	if ok {
+		toLight := sc.vector(sample.Direction)
+		brdf := mat.BRDF(normal, toLight, out)
+		weight := 1.0
+		if !sample.Delta {
+			weight = sampling.PowerHeuristic(sample.Pdf, mat.DirectionPdf(normal, toLight, out))
+		}
+		radiance = *irradiance.CMultiply(&brdf).Multiply(weight)
+	}
+	if ok && sample.Delta {
+		// No direction sampled from the material can hit the light
+		return radiance
+	}
+
+	sampled, pdf := mat.SampleDirection(normal, out, rng.Float64(), rng.Float64())
+	in := sc.vector(sampled)
+	cosine := in.DotV(*normal)
+	if pdf == 0 || cosine <= 0 {
+		return radiance
+	}
+	ray := sc.ray(math3d.LightRay{Source: s.leaving(point, normal, in), Direction: *in, Origin: from, Time: time})
+	distance, emitted := ls.Intersect(ray)
+	if distance == math.MaxFloat64 || s.inShadow(ray, distance) {
+		return radiance
+	}
+	if len(s.Volumes) > 0 {
+		transmittance := s.volumeTransmittance(ray, distance, rng)
+		emitted = *emitted.CMultiply(&transmittance)
+	}
+	if s.Medium != nil {
+		transmittance := s.Medium.Transmittance(distance)
+		emitted = *emitted.CMultiply(&transmittance)
+	}
+	brdf := mat.BRDF(normal, in, out)
+	weight := sampling.PowerHeuristic(pdf, ls.Pdf(point, in))
+	return *radiance.Add(emitted.CMultiply(&brdf).Multiply(cosine * weight / pdf))
+}
+
+// leaving returns where the lightrays leaving point, on a surface with the
+// normal, towards direction start from, moved off the surface as the ray
+// offset of the settings says
+func (s *Scene) leaving(point, normal, direction *math3d.Vector3) math3d.Vector3 {
+	if s.Settings.RayOffset == 0 {
+		return *point
+	}
+	return math3d.OffsetOrigin(*point, *normal, *direction, s.Settings.RayOffset)
+}
+
+// lightArriving samples the light arriving at point from ls, returning the
+// sample and the irradiance it gives to a surface with the given normal,
+// divided by the density of the sample. ok is false if no light arrives,
+// because the point is in shadow or faces away. from is the shape point is
+// on, nil if it isn't on a shape, and time is when the light arrives.
+func (s *Scene) lightArriving(from shape.Shape, point, normal *math3d.Vector3, ls lighting.Light, time float64, rng *sampling.Rand) (lighting.Sample, image.Color, bool) {
+	sample := ls.Sample(point, rng.Float64(), rng.Float64())
+	if sample.Pdf == 0 {
+		return sample, image.Black, false
+	}
+	sc := getScratch()
+	defer sc.release()
+	shadowRay := sc.ray(math3d.LightRay{Direction: sample.Direction, Source: s.leaving(point, normal, &sample.Direction), Origin: from, Time: time})
+	// Cosine of the ray of light with the visible normal.
+	cosine := shadowRay.Direction.DotV(*normal)
+	if cosine <= 0 || s.inShadow(shadowRay, sample.Distance) {
+		return sample, image.Black, false
+	}
+	irradiance := sample.Radiance.Multiply(cosine / sample.Pdf)
+	if s.Medium != nil {
+		transmittance := s.Medium.Transmittance(sample.Distance)
+		irradiance = irradiance.CMultiply(&transmittance)
+	}
+	if len(s.Volumes) > 0 {
+		transmittance := s.volumeTransmittance(shadowRay, sample.Distance, rng)
+		irradiance = irradiance.CMultiply(&transmittance)
+	}
+	return sample, *irradiance, true
+}
+
+// nearestLight returns the distance at which the lightray hits the nearest
+// light and the radiance it emits towards the source of the lightray. The
+// distance is math.MaxFloat64 if it misses them all.
+func (s *Scene) nearestLight(lr *math3d.LightRay) (float64, image.Color) {
+	distance, ls := s.lightHit(lr)
+	if ls == nil {
+		return distance, image.Black
+	}
+	_, radiance := ls.Intersect(lr)
+	return distance, radiance
+}
+
+// lightHit returns the distance at which the lightray hits the nearest
+// light and that light, or math.MaxFloat64 and nil if it misses them all
+func (s *Scene) lightHit(lr *math3d.LightRay) (float64, lighting.Light) {
+	lights := s.Lights
+	nearestDistance, nearest := math.MaxFloat64, lighting.Light(nil)
+	if len(s.Lights) > manyLights {
+		nearestDistance, nearest = s.lightSampler().Intersect(lr)
+		lights = s.distantLights
+	}
+	for _, ls := range lights {
+		if distance, _ := ls.Intersect(lr); distance < nearestDistance {
+			nearestDistance, nearest = distance, ls
+		}
+	}
+	return nearestDistance, nearest
+}
+
 func (s *Scene) getNearestIntersection(lr *math3d.LightRay) (float64, shape.Shape) {
-	var nearestShape shape.Shape
-	nearestDistance := math.MaxFloat64
-	for _, s := range s.Shapes {
-		intersectionDistance := s.Intersect(lr)
-		if intersectionDistance < nearestDistance {
-			nearestDistance = intersectionDistance
-			nearestShape = s
+	nearestDistance, nearest, _ := s.nearestShape(lr)
+	return nearestDistance, nearest
+}
+
+// nearestShape returns the distance at which the lightray intersects the
+// nearest shape, that shape and the index of the shape of the scene it's
+// part of. The shape is the one at the index unless that one is Delayed,
+// in which case it's the one of its shapes that was hit, or the lightray
+// enters it through a cut of the section, in which case it's the cap of
+// the cut. It returns
+// math.MaxFloat64, nil and -1 if the lightray misses them all.
+func (s *Scene) nearestShape(lr *math3d.LightRay) (float64, shape.Shape, int) {
+	atomic.AddUint64(&s.rays, 1)
+	nearestDistance, nearest := s.accelerator().Intersect(lr)
+	if nearest < 0 {
+		return nearestDistance, nil, -1
+	}
+	sh := s.Shapes[nearest]
+	if d, ok := sh.(*Delayed); ok {
+		nearestDistance, sh = d.nearest(lr)
+	}
+	nearestDistance, sh, _ = s.capOf(lr, sh, nearestDistance)
+	return nearestDistance, sh, nearest
+}
+
+// nearestShapes returns the distances and the shapes that nearestShape
+// returns for every lightray of the packet, which are traced together
+// through the structures that can. The lanes whose lightrays are nil miss.
+func (s *Scene) nearestShapes(lrs *[accel.PacketSize]*math3d.LightRay) ([accel.PacketSize]float64, [accel.PacketSize]shape.Shape) {
+	var shapes [accel.PacketSize]shape.Shape
+	for _, lr := range lrs {
+		if lr != nil {
+			atomic.AddUint64(&s.rays, 1)
+		}
+	}
+	distances, indices := accel.IntersectPacket(s.accelerator(), lrs)
+	for i, lr := range lrs {
+		if indices[i] < 0 {
+			continue
+		}
+		sh := s.Shapes[indices[i]]
+		if d, ok := sh.(*Delayed); ok {
+			distances[i], sh = d.nearest(lr)
+		}
+		distances[i], shapes[i], _ = s.capOf(lr, sh, distances[i])
+	}
+	return distances, shapes
+}
+
+// nearestShapesOf returns the distances and the shapes that nearestShape
+// returns for every lightray, which are traced at once through the
+// structures that can, such as the ones on a GPU
+func (s *Scene) nearestShapesOf(lrs []*math3d.LightRay) ([]float64, []shape.Shape) {
+	atomic.AddUint64(&s.rays, uint64(len(lrs)))
+	distances, indices := make([]float64, len(lrs)), make([]int, len(lrs))
+	shapes := make([]shape.Shape, len(lrs))
+	accel.IntersectRays(s.accelerator(), lrs, distances, indices)
+	for i, lr := range lrs {
+		if indices[i] < 0 {
+			continue
+		}
+		sh := s.Shapes[indices[i]]
+		if d, ok := sh.(*Delayed); ok {
+			distances[i], sh = d.nearest(lr)
 		}
+		distances[i], shapes[i], _ = s.capOf(lr, sh, distances[i])
 	}
-	return nearestDistance, nearestShape
+	return distances, shapes
 }
 
 // inShadow returns true if the lightray intersects any shape
 // at a distance that is smaller than distance
 func (s *Scene) inShadow(lr *math3d.LightRay, distance float64) bool {
-	for _, s := range s.Shapes {
-		intersectionDistance := s.Intersect(lr)
-		if intersectionDistance < distance {
-			return true
+	atomic.AddUint64(&s.rays, 1)
+	atomic.AddUint64(&s.shadowRays, 1)
+	return s.accelerator().Occluded(lr, distance)
+}
+
+// accelerator returns the acceleration structure over the shapes in the
+// scene, building it if the shapes or the accelerator of the settings
+// changed since it was last built.
+func (s *Scene) accelerator() accel.Accelerator {
+	culling, shutter := s.Settings.Backfaces == Cull, s.Settings.Shutter
+	if s.structure == nil || s.structure.Size() != len(s.Shapes) || acceleratorOf(s.structure) != s.Settings.accelerator() ||
+		s.culling != culling || s.shutter != shutter || s.Settings.accelerator() == SBVH && s.splitBudget != s.Settings.splitBudget() {
+		s.mustBeEditable()
+		primitives := s.primitives()
+		var structure accel.Accelerator
+		switch s.Settings.accelerator() {
+		case KDTree:
+			structure = accel.NewKDTree(primitives)
+		case TwoLevel:
+			structure = s.twoLevel()
+		case SBVH:
+			s.splitBudget = s.Settings.splitBudget()
+			structure = accel.NewSBVH(primitives, s.splitBudget)
+		case GPU:
+			g := gpu.New(primitives)
+			if g.Err != nil {
+				log.Println("Warning: tracing the depth on the CPU, " + g.Err.Error())
+			}
+			structure = g
+		default:
+			structure = accel.NewBVH(primitives)
+		}
+		s.useStructure(structure)
+	}
+	return s.structure
+}
+
+// primitives gives the shapes the settings of the renders and returns them
+// as the primitives of an acceleration structure
+func (s *Scene) primitives() []accel.Primitive {
+	culling, shutter := s.Settings.Backfaces == Cull, s.Settings.Shutter
+	primitives := make([]accel.Primitive, 0, len(s.Shapes))
+	for _, sh := range s.Shapes {
+		if d, ok := sh.(*Delayed); ok {
+			d.prepare(culling, shutter, s.counters())
+		}
+		prepareShape(sh, culling, shutter)
+		primitives = append(primitives, sh)
+	}
+	s.culling, s.shutter = culling, shutter
+	return primitives
+}
+
+// useStructure makes the structure, built over the primitives that
+// primitives returns, the one that accelerates the intersection tests
+// against the shapes
+func (s *Scene) useStructure(structure accel.Accelerator) {
+	s.structure = structure
+	s.structure.Count(s.counters())
+	s.installFilter()
+}
+
+// prepareShape gives the shape the settings of the renders that the
+// shapes have fields for
+func prepareShape(sh shape.Shape, culling bool, shutter float64) {
+	switch sh := sh.(type) {
+	case *shape.Heightfield:
+		sh.CullBackfaces = culling
+	case *shape.Triangle:
+		sh.CullBackfaces = culling
+		sh.Shutter = shutter
+	case *shape.MeshTriangle:
+		sh.Mesh.CullBackfaces = culling
+	}
+}
+
+// twoLevel returns a two level structure with an instance holding the
+// shapes that never moved, one for every group of shapes deformed or set
+// to build fast together and one for every other shape that moved
+func (s *Scene) twoLevel() *accel.TwoLevel {
+	still := accel.Instance{Indices: []int{}}
+	instances := []accel.Instance{still}
+	// groups holds the instance of every group of deformed shapes
+	groups := make(map[int]int)
+	for i, sh := range s.Shapes {
+		if group, ok := s.meshes[sh]; ok {
+			in, ok := groups[group]
+			if !ok {
+				in = len(instances)
+				groups[group] = in
+				instances = append(instances, accel.Instance{Indices: []int{}, Fast: s.fast[sh]})
+			}
+			instances[in].Primitives = append(instances[in].Primitives, sh)
+			instances[in].Indices = append(instances[in].Indices, i)
+		} else if s.moving[sh] {
+			instances = append(instances, accel.Instance{Primitives: []accel.Primitive{sh}, Indices: []int{i}})
+		} else {
+			instances[0].Primitives = append(instances[0].Primitives, sh)
+			instances[0].Indices = append(instances[0].Indices, i)
+		}
+	}
+	return accel.NewTwoLevel(instances)
+}
+
+// acceleratorOf returns the name of the kind of acceleration structure
+func acceleratorOf(structure accel.Accelerator) string {
+	switch structure := structure.(type) {
+	case *accel.KDTree:
+		return KDTree
+	case *accel.TwoLevel:
+		return TwoLevel
+	case *gpu.BVH:
+		return GPU
+	case *accel.BVH:
+		if structure.Spatial() {
+			return SBVH
+		}
+	}
+	return BVH
+}
+
+// counters returns the counters of the work of the acceleration structure
+// if the settings ask for statistics, or nil
+func (s *Scene) counters() *accel.Counters {
+	if !s.Settings.Stats {
+		return nil
+	}
+	return &s.traversal
+}
+
+// lightSampler returns the light tree over the lights in the scene,
+// building it if the lights changed since it was last built. The distant
+// lights, which have no bounds, are left out of it.
+func (s *Scene) lightSampler() *lighting.Tree {
+	if s.lightTree == nil || s.lightTree.Size()+len(s.distantLights) != len(s.Lights) {
+		var near []lighting.Light
+		s.distantLights = nil
+		for _, ls := range s.Lights {
+			if _, distant := ls.(lighting.Distant); distant {
+				s.distantLights = append(s.distantLights, ls)
+			} else {
+				near = append(near, ls)
+			}
 		}
+		s.lightTree = lighting.NewTree(near)
 	}
-	return false
+	return s.lightTree
 }
 
 // SaveSceneFile saves the scene as a file that can be loaded later
 func (s *Scene) SaveSceneFile(path string) {
-	marshaledScene, err := json.Marshal(s)
+	marshaledScene, err := s.Marshal()
 	if err != nil {
 		panic(err)
 	}
-	var mappedScene map[string]interface{}
-	err = json.Unmarshal(marshaledScene, &mappedScene)
+
+	fmt.Println(string(marshaledScene))
+
+	err = ioutil.WriteFile(path, marshaledScene, 0644)
 	if err != nil {
 		panic(err)
 	}
-	mappedScene["shapes"] = shape.AsMap(s.Shapes)
-	marshaledScene, err = json.MarshalIndent(mappedScene, "", "\t")
+}
+
+// Marshal returns the scene in the scene file format
+func (s *Scene) Marshal() ([]byte, error) {
+	mappedScene, err := s.asMap()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return json.MarshalIndent(mappedScene, "", "\t")
+}
 
-	fmt.Println(string(marshaledScene))
+// MarshalCBOR returns the scene in the scene file format encoded in CBOR,
+// which ParseSceneCBOR parses. It's a fraction of the size of the JSON of
+// scenes with many shapes. The triangles of meshes are packed in the
+// buffers of a shape.PackedMesh each, so that ParseSceneFile can map them
+// from a scene cache saved to a file instead of reading them into memory.
+func (s *Scene) MarshalCBOR() ([]byte, error) {
+	mappedScene, err := s.asMap()
+	if err != nil {
+		return nil, err
+	}
+	mappedScene["shapes"] = shape.PackMeshes(s.looseShapes())
+	return cbor.Marshal(mappedScene)
+}
 
-	err = ioutil.WriteFile(path, marshaledScene, 0644)
+// asMap returns a map representation of the scene in the scene file format
+func (s *Scene) asMap() (map[string]interface{}, error) {
+	marshaledScene, err := json.Marshal(s)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	var mappedScene map[string]interface{}
+	if err = json.Unmarshal(marshaledScene, &mappedScene); err != nil {
+		return nil, err
+	}
+	mappedScene["shapes"] = shape.AsMap(s.looseShapes())
+	mappedScene["lights"] = lighting.AsMap(s.Lights)
+	mappedScene["version"] = FormatVersion
+	if len(s.Nodes) > 0 {
+		nodes := make([]map[string]interface{}, 0, len(s.Nodes))
+		for _, n := range s.Nodes {
+			nodes = append(nodes, n.asMap())
+		}
+		mappedScene["nodes"] = nodes
 	}
+	return mappedScene, nil
 }
 
-// LoadSceneFile loads a scene file to a scene object
+// looseShapes returns the shapes that aren't in the scene graph, which
+// scene files hold in their "shapes"
+func (s *Scene) looseShapes() []shape.Shape {
+	if len(s.Nodes) == 0 {
+		return s.Shapes
+	}
+	inNodes := s.nodeShapes()
+	loose := make([]shape.Shape, 0, len(s.Shapes)-len(inNodes))
+	for _, sh := range s.Shapes {
+		if !inNodes[sh] {
+			loose = append(loose, sh)
+		}
+	}
+	return loose
+}
+
+// LoadSceneFile loads a scene file to a scene object. See LoadScene.
 func LoadSceneFile(path string) *Scene {
-	bytes, err := ioutil.ReadFile(path)
+	return mustLoad(ParseSceneFile(path, Lenient))
+}
+
+// LoadScene loads a scene object from the contents of a scene file. It
+// parses them in lenient mode, logging the warnings, and panics if the
+// scene can't be used.
+func LoadScene(bytes []byte) *Scene {
+	return mustLoad(ParseScene(bytes, Lenient))
+}
+
+func mustLoad(retscene *Scene, warnings []string, err error) *Scene {
 	if err != nil {
 		panic(err)
 	}
-	var scenemap map[string]interface{}
-	err = json.Unmarshal(bytes, &scenemap)
-	if err != nil {
-		panic(err)
+	for _, w := range warnings {
+		log.Println("Warning: " + w)
 	}
-	retscene := &Scene{}
-	retscene.Camera = camera.PinHoleFromMap(scenemap["camera"].(map[string]interface{}))
-	retscene.Lights = lighting.PointLightsFromMap(maputil.ToSliceOfMap(scenemap["lights"].([]interface{})))
-	retscene.Shapes = shape.FromMap(maputil.ToSliceOfMap(scenemap["shapes"].([]interface{})))
 	return retscene
 }
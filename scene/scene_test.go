@@ -0,0 +1,825 @@
+package scene
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/medium"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestRendersAreReproducible(t *testing.T) {
+	s := testScene()
+	s.Settings = Settings{Samples: 4, Seed: 7}
+	first := s.TraceScene(32, 32)
+	if !bytes.Equal(first.Pix, s.TraceScene(32, 32).Pix) {
+		t.Error("Rendering with the same seed should give the same image")
+	}
+	s.Settings.Seed = 8
+	if bytes.Equal(first.Pix, s.TraceScene(32, 32).Pix) {
+		t.Error("Rendering with another seed should jitter the samples differently")
+	}
+}
+
+func TestAdaptiveSamplingStopsEarlyOnFlatPixels(t *testing.T) {
+	s := testScene()
+	s.Settings = Settings{Samples: 64, MinSamples: 4, AdaptiveThreshold: 0.05}
+	targetIt := s.Camera.GetIterator(32, 32)
+	// The corner of the image sees only the background
+	if _, n := s.samplePixel(targetIt, 0, 0); n != 4 {
+		t.Errorf("A flat pixel should stop after the minimum of samples, took %d", n)
+	}
+	s.Settings.AdaptiveThreshold = 0
+	if _, n := s.samplePixel(targetIt, 0, 0); n != 64 {
+		t.Errorf("Without adaptive sampling every pixel should take all the samples, took %d", n)
+	}
+}
+
+func TestOutlierRejection(t *testing.T) {
+	gray, firefly := image.Color{R: 0.1, G: 0.1, B: 0.1}, image.Color{R: 100, G: 100, B: 100}
+	samples := []image.Color{gray, gray, gray, firefly}
+	if c := rejectOutliers(samples, 1); math.Abs(c.R-0.1) > 1e-9 {
+		t.Errorf("The samples brighter than the limit should be left out, the mean is %v", c)
+	}
+	if c := rejectOutliers(samples, 200); math.Abs(c.R-25.075) > 1e-9 {
+		t.Errorf("No sample is brighter than the limit, so the mean should be 25.075, not %g", c.R)
+	}
+
+	s := testScene()
+	s.Settings = Settings{Samples: 16, OutlierThreshold: 3}
+	targetIt := s.Camera.GetIterator(32, 32)
+	filtered, _ := s.samplePixel(targetIt, 0, 0)
+	s.Settings.OutlierThreshold = 0
+	if all, _ := s.samplePixel(targetIt, 0, 0); filtered != all {
+		t.Errorf("A flat pixel has no outliers, it should be %v, not %v", all, filtered)
+	}
+}
+
+func TestMediumScattersAndAttenuates(t *testing.T) {
+	clear := testScene()
+	foggy := testScene()
+	foggy.Medium = &medium.Homogeneous{
+		Absorption: image.Color{R: 0.1, G: 0.1, B: 0.1},
+		Scattering: image.Color{R: 0.3, G: 0.3, B: 0.3}}
+	targetIt := clear.Camera.GetIterator(32, 32)
+
+	// The pixel in the corner sees only the background, which the fog lights up
+	if c := foggy.TracePixel(targetIt, 0, 31); c.Luminance() <= 0 {
+		t.Error("The fog should scatter light towards the camera")
+	}
+	// The floor is seen through the fog, which takes part of its light away
+	clearFloor, foggyFloor := clear.TracePixel(targetIt, 16, 2), foggy.TracePixel(targetIt, 16, 2)
+	if clearFloor.Luminance() <= 0 {
+		t.Fatal("The floor should be lit")
+	}
+	if foggyFloor.Luminance() >= clearFloor.Luminance() {
+		t.Errorf("The fog changed the floor from %s to %s", clearFloor.String(), foggyFloor.String())
+	}
+}
+
+func TestMediumLightShaftsConverge(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -10.3, Z: 1}, Radius: 10})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{Y: 0.05, Z: 1}, Radius: 0.01, Radiance: image.White})
+	s.Medium = &medium.Homogeneous{Scattering: image.Color{R: 0.5, G: 0.5, B: 0.5}}
+	s.Settings.VolumeStep = 0.25
+	// The ray passes right by the light, whose shaft is all in a few
+	// hundredths of its length
+	lr := math3d.LightRay{Source: math3d.Vector3{Z: -1}, Direction: math3d.UnitZ}
+	exact := 0.0
+	rng := sampling.New(1, 0)
+	for x := 0.0; x < 2; x += 1e-5 {
+		point := lr.Source.AddV(lr.Direction.MultiplyV(x + 0.5e-5))
+		c := s.inscatteredFrom(s.Lights[0], &point, &lr.Direction, lr.Time, rng)
+		exact += c.R * s.Medium.Transmittance(x).R * 1e-5
+	}
+	// Marching as many evenly spaced points misses the shaft most of the
+	// time, and overshoots when it doesn't
+	var sampledError, marchedError float64
+	for i := 0; i < 20; i++ {
+		rng := sampling.New(1, uint64(i))
+		c := s.throughMedium(&lr, 2, image.Black, rng)
+		sampledError += math.Abs(c.R-exact) / exact / 20
+		marched := 0.0
+		for j := 0; j < 8; j++ {
+			x := (float64(j) + rng.Float64()) / 4
+			point := lr.Source.AddV(lr.Direction.MultiplyV(x))
+			marched += s.inscatteredFrom(s.Lights[0], &point, &lr.Direction, lr.Time, rng).R * s.Medium.Transmittance(x).R / 4
+		}
+		marchedError += math.Abs(marched-exact) / exact / 20
+	}
+	if sampledError > 0.2 || sampledError > marchedError/3 {
+		t.Errorf("The shaft should be off by less than 20%% and a third of the %.0f%% of marching, it's off by %.0f%%", 100*marchedError, 100*sampledError)
+	}
+}
+
+// smoke returns a cube of smoke of the density over the floor of the test
+// scene, drifting along X at speed
+func smoke(density, speed float64) *medium.Grid {
+	g := &medium.Grid{
+		Position:   math3d.Vector3{X: -0.1, Y: -0.3, Z: 0.9},
+		Size:       math3d.Vector3{X: 0.2, Y: 0.2, Z: 0.2},
+		Resolution: [3]int{2, 2, 2},
+		Absorption: image.Color{R: 5, G: 5, B: 5},
+		Scattering: image.Color{R: 5, G: 5, B: 5}}
+	for i := 0; i < 8; i++ {
+		g.Density = append(g.Density, density)
+		g.Velocity = append(g.Velocity, math3d.Vector3{X: speed})
+	}
+	return g
+}
+
+func TestVolumesScatterAndAttenuate(t *testing.T) {
+	clear := testScene()
+	smoky := testScene()
+	smoky.Volumes = []*medium.Grid{smoke(1, 0)}
+	smoky.Settings.Samples = 16
+	targetIt := clear.Camera.GetIterator(32, 32)
+
+	// The center of the image sees the floor through the smoke
+	clearFloor, smokyFloor := clear.TracePixel(targetIt, 16, 4), smoky.TracePixel(targetIt, 16, 4)
+	if smokyFloor.Luminance() >= clearFloor.Luminance() {
+		t.Errorf("The smoke changed the floor from %s to %s", clearFloor.String(), smokyFloor.String())
+	}
+	smoky.Volumes[0].Absorption = image.Black
+	if c := smoky.TracePixel(targetIt, 16, 4); c.Luminance() <= smokyFloor.Luminance() {
+		t.Error("Smoke that only scatters should light up more than smoke that also absorbs")
+	}
+	// The smoke shadows the floor under it
+	point, normal, out := math3d.Vector3{Y: -0.3, Z: 1}, math3d.Vector3{Y: 1}, math3d.Vector3{Y: 1}
+	rng := sampling.New(1, 0)
+	lit := clear.directLight(nil, &point, &normal, &out, material.Default, clear.Lights[0], 0, rng)
+	shadowed := smoky.directLight(nil, &point, &normal, &out, material.Default, smoky.Lights[0], 0, rng)
+	if shadowed.Luminance() >= lit.Luminance() {
+		t.Errorf("The smoke should shadow the floor, it went from %s to %s", lit.String(), shadowed.String())
+	}
+}
+
+func TestShutterBlursMovingVolumes(t *testing.T) {
+	s := testScene()
+	s.Volumes = []*medium.Grid{smoke(1, 2)}
+	lr := math3d.LightRay{Source: math3d.Vector3{X: 0.15, Y: -0.2, Z: 0}, Direction: math3d.UnitZ}
+	rng := sampling.New(1, 0)
+	if tr := s.volumeTransmittance(&lr, math.MaxFloat64, rng); tr != image.White {
+		t.Errorf("The smoke shouldn't be there when the shutter opens, the transmittance is %s", tr.String())
+	}
+	lr.Time = 0.1
+	if tr := s.volumeTransmittance(&lr, math.MaxFloat64, rng); tr.R > 0.5 {
+		t.Errorf("The smoke should have drifted in front of the ray, the transmittance is %s", tr.String())
+	}
+
+	// Sampled over the shutter, the ray sees the smoke part of the time,
+	// which is blurred between how it looks when the shutter opens and
+	// closes
+	average := func(shutter float64) float64 {
+		s.Settings.Shutter = shutter
+		sum := 0.0
+		for i := 0; i < 200; i++ {
+			camera := math3d.LightRay{Source: lr.Source, Direction: lr.Direction}
+			c := s.traceRay(&camera, sampling.New(1, uint64(i)), &builtinIntegrators{})
+			sum += c.R
+		}
+		return sum / 200
+	}
+	still := average(0)
+	s.Volumes[0].Position.X += 0.2
+	moved := average(0)
+	s.Volumes[0].Position.X -= 0.2
+	if blurred := average(0.1); !(blurred > still && blurred < moved) {
+		t.Errorf("The blurred radiance %.4f should be between %.4f and %.4f", blurred, still, moved)
+	}
+}
+
+func TestShutterBlursMovingTriangles(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Triangle{
+		Vertices:   [3]math3d.Vector3{{X: 0, Z: 0}, {X: 0, Z: 1}, {X: 0.5, Z: 0}},
+		Velocities: [3]math3d.Vector3{{X: 10}, {X: 10}, {X: 10}}})
+	down := math3d.LightRay{Source: math3d.Vector3{X: 0.75, Y: 1, Z: 0.1}, Direction: math3d.Vector3{Y: -1}, Time: 0.05}
+	if _, sh := s.getNearestIntersection(&down); sh != nil {
+		t.Error("With the shutter closed at once, the triangle shouldn't be hit after it")
+	}
+	// The structure is rebuilt around where the triangle goes while the
+	// shutter is open
+	s.Settings.Shutter = 0.1
+	if d, sh := s.getNearestIntersection(&down); sh == nil || math.Abs(d-1) > 1e-9 {
+		t.Errorf("The triangle should have moved under the lightray, which hits at %.3f", d)
+	}
+	down.Time = 0
+	if _, sh := s.getNearestIntersection(&down); sh != nil {
+		t.Error("The triangle shouldn't be under the lightray when the shutter opens")
+	}
+}
+
+func TestShadowStepMarchesTheRaysTowardsTheLights(t *testing.T) {
+	s := testScene()
+	s.Volumes = []*medium.Grid{smoke(1, 0)}
+	lr := math3d.LightRay{Source: math3d.Vector3{Y: -0.2}, Direction: math3d.UnitZ}
+	// Steps that fit 4 times in the smoke find all of its density
+	if tr := s.volumeTransmittance(&lr, math.MaxFloat64, sampling.New(1, 0)); math.Abs(tr.R-math.Exp(-2)) > 1e-9 {
+		t.Errorf("Without a shadow step the volume step should be used, the transmittance is %s", tr.String())
+	}
+	// Longer ones look it up once at most, and either miss it or count it
+	// for a whole step
+	s.Settings.ShadowStep = 1
+	seen := map[float64]bool{}
+	for i := 0; i < 50; i++ {
+		tr := s.volumeTransmittance(&lr, math.MaxFloat64, sampling.New(1, uint64(i)))
+		seen[math.Round(tr.R*1e6)/1e6] = true
+	}
+	if len(seen) != 2 || !seen[1] || !seen[math.Round(math.Exp(-10)*1e6)/1e6] {
+		t.Errorf("The transmittance should be 1 or e^-10, it was %v", seen)
+	}
+	s.Settings.ShadowStep = -1
+	if s.Settings.Validate() == nil {
+		t.Error("The shadow step can't be negative")
+	}
+}
+
+func TestGlowingVolumesAreSeenButDontLight(t *testing.T) {
+	s := testScene()
+	fire := smoke(0, 0)
+	fire.Velocity = nil
+	for range fire.Density {
+		fire.Temperature = append(fire.Temperature, 1200)
+	}
+	fire.Emission = 1
+	lr := math3d.LightRay{Source: math3d.Vector3{Y: -0.2}, Direction: math3d.UnitZ}
+	rng := sampling.New(1, 0)
+	s.Volumes = []*medium.Grid{fire}
+	glow := s.throughVolumes(&lr, math.MaxFloat64, image.Black, rng)
+	if glow.Luminance() <= 0 || !(glow.R > glow.B) {
+		t.Errorf("The ray through the fire should see it glow red, it sees %s", glow.String())
+	}
+	// With no density it doesn't hide what's behind it either
+	if c := s.throughVolumes(&lr, math.MaxFloat64, image.White, rng); math.Abs(c.B-glow.B-1) > 1e-9 {
+		t.Errorf("The fire shouldn't take away any light, it gives %s", c.String())
+	}
+}
+
+func TestBackfaceSettings(t *testing.T) {
+	s := New()
+	terrain := shape.NewHeightfield(math3d.Vector3{X: -1, Z: -1}, math3d.Vector3{X: 2, Y: 0.1, Z: 2}, 9, 9,
+		func(x, z float64) float64 { return x * z })
+	s.AddShape(terrain)
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: -1}, Intensity: image.White})
+	up := math3d.LightRay{Source: math3d.Vector3{X: 0.1, Y: -0.5, Z: 0.2}, Direction: math3d.UnitY}
+	rng := sampling.New(1, 0)
+
+	if c := (DirectLighting{}).Radiance(s, &up, rng); c.Luminance() != 0 {
+		t.Errorf("The terrain should be shaded as seen from above, and be dark, not %s", c.String())
+	}
+	s.Settings.Backfaces = TwoSided
+	if c := (DirectLighting{}).Radiance(s, &up, rng); c.Luminance() <= 0 {
+		t.Error("Two sided, the light under the terrain should light it")
+	}
+	s.Settings.Backfaces = Cull
+	if d, sh := s.getNearestIntersection(&up); sh != nil {
+		t.Errorf("Culling, the lightray from below should go through the terrain, it hit at %.3f", d)
+	}
+	s.Settings.Backfaces = ""
+	if _, sh := s.getNearestIntersection(&up); sh == nil {
+		t.Error("Without culling, the lightray from below should hit the terrain again")
+	}
+}
+
+func TestSubsurfaceBleedsPastTheTerminator(t *testing.T) {
+	sphere := &shape.Sphere{Radius: 1}
+	s := New()
+	s.AddShape(sphere)
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{X: 10}, Intensity: image.White})
+	// A point just past where the light stops reaching the sphere
+	point := math3d.Vector3{X: -0.05, Y: math.Sqrt(1 - 0.05*0.05)}
+	view := &math3d.LightRay{Source: math3d.Vector3{Y: 5}, Direction: math3d.Vector3{Y: -1}}
+
+	if c := s.calculateRadianceAt(&point, view, sphere, sampling.New(1, 0)); c.Luminance() != 0 {
+		t.Fatalf("A lambertian sphere should be dark past the terminator, it's %s", c.String())
+	}
+	sphere.Material = &material.Subsurface{
+		Albedo:       image.White,
+		MeanFreePath: image.Color{R: 0.2, G: 0.2, B: 0.2}}
+	if c := s.calculateRadianceAt(&point, view, sphere, sampling.New(1, 0)); c.Luminance() <= 0 {
+		t.Error("Light should bleed past the terminator of a translucent sphere")
+	}
+}
+
+func TestDirectLightFromSphereLights(t *testing.T) {
+	floor := &shape.Sphere{Position: math3d.Vector3{Y: -1}, Radius: 1}
+	light := &lighting.SphereLight{Position: math3d.Vector3{Y: 4}, Radius: 1, Radiance: image.Color{R: 16, G: 16, B: 16}}
+	s := New()
+	s.AddShape(floor)
+	s.AddLight(light)
+	point, normal := math3d.Vector3{}, math3d.UnitY
+	// Seen from the mirror direction of the light, glossy floors reflect it
+	out := math3d.UnitY
+	rng := sampling.New(1, 0)
+
+	// A lambertian floor under a sphere of angular radius a gets an
+	// irradiance of pi * L * sin(a)^2, which it reflects as L * sin(a)^2
+	mean, _ := estimate(20000, func() float64 {
+		c := s.directLight(floor, &point, &normal, &out, material.Default, light, 0, rng)
+		return c.R
+	})
+	if math.Abs(mean-1) > 0.02 {
+		t.Errorf("The floor should reflect a radiance of 1, it reflects %f", mean)
+	}
+
+	// Light sampling alone can hardly find the narrow lobe of a glossy
+	// material, multiple importance sampling finds it through the material
+	glossy := &material.Glossy{Albedo: image.White, Exponent: 1000}
+	misMean, misVariance := estimate(20000, func() float64 {
+		c := s.directLight(floor, &point, &normal, &out, glossy, light, 0, rng)
+		return c.R
+	})
+	lightMean, lightVariance := estimate(200000, func() float64 {
+		sample, irradiance, ok := s.lightArriving(floor, &point, &normal, light, 0, rng)
+		if !ok {
+			return 0
+		}
+		brdf := glossy.BRDF(&normal, &sample.Direction, &out)
+		return irradiance.R * brdf.R
+	})
+	if math.Abs(misMean-lightMean) > 0.03*lightMean {
+		t.Errorf("Both estimators should converge to the same radiance, got %f and %f", misMean, lightMean)
+	}
+	if misVariance > lightVariance/10 {
+		t.Errorf("Multiple importance sampling should reduce the variance a lot, it's %f against %f", misVariance, lightVariance)
+	}
+}
+
+// estimate returns the mean and variance of n samples of the estimator f
+func estimate(n int, f func() float64) (float64, float64) {
+	var sum, sum2 float64
+	for i := 0; i < n; i++ {
+		v := f()
+		sum += v
+		sum2 += v * v
+	}
+	mean := sum / float64(n)
+	return mean, sum2/float64(n) - mean*mean
+}
+
+func TestLightTreeIsUnbiased(t *testing.T) {
+	floor := &shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100}
+	s := New()
+	s.AddShape(floor)
+	for i := 0; i < 50; i++ {
+		x := float64(i%10) - 4.5
+		s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: x, Y: 2 + float64(i/10), Z: x * x / 4}, Radius: 0.2, Radiance: image.White})
+	}
+	point, normal := math3d.Vector3{X: 1}, math3d.UnitY
+	view := &math3d.LightRay{Source: math3d.Vector3{X: 1, Y: 5, Z: -5}, Direction: math3d.Vector3{Y: -5, Z: 5}.NormalizedV()}
+	out := view.Direction.MultiplyV(-1)
+	rng := sampling.New(3, 0)
+
+	exact := 0.0
+	for _, ls := range s.Lights {
+		mean, _ := estimate(2000, func() float64 {
+			c := s.directLight(floor, &point, &normal, &out, material.Default, ls, 0, rng)
+			return c.R
+		})
+		exact += mean
+	}
+	mean, _ := estimate(100000, func() float64 {
+		c := s.calculateRadianceAt(&point, view, floor, rng)
+		return c.R
+	})
+	if math.Abs(mean-exact) > 0.02*exact {
+		t.Errorf("Sampling one light with the tree should estimate the light of all of them, %f, not %f", exact, mean)
+	}
+}
+
+func TestShadowTerminatorOfCoarseHeightfields(t *testing.T) {
+	// A coarse dome lit from the side
+	dome := shape.NewHeightfield(math3d.Vector3{X: -1, Z: -1}, math3d.Vector3{X: 2, Y: 1, Z: 2}, 9, 9,
+		func(x, z float64) float64 { return math.Sqrt(math.Max(0, 1-4*(x-0.5)*(x-0.5)-4*(z-0.5)*(z-0.5))) })
+	s := New()
+	s.AddShape(dome)
+	toLight := math3d.Vector3{X: 1, Y: 0.3}.NormalizedV()
+
+	r := sampling.New(4, 0)
+	var lit, shadowedFromPoint, shadowedFromOrigin int
+	for i := 0; i < 2000; i++ {
+		down := math3d.LightRay{Source: math3d.Vector3{X: 2*r.Float64() - 1, Y: 3, Z: r.Float64() - 0.5}, Direction: math3d.Vector3{Y: -1}}
+		point := down.Source.AddV(down.Direction.MultiplyV(dome.Intersect(&down)))
+		if dome.NormalAt(&point).DotV(toLight) <= 0 {
+			continue
+		}
+		// The smooth normals face the light, so the point should be lit
+		lit++
+		fromPoint := math3d.LightRay{Source: point, Direction: toLight}
+		if s.inShadow(&fromPoint, math.MaxFloat64) {
+			shadowedFromPoint++
+		}
+		fromOrigin := math3d.LightRay{Source: dome.ShadowOrigin(&point), Direction: toLight}
+		if s.inShadow(&fromOrigin, math.MaxFloat64) {
+			shadowedFromOrigin++
+		}
+	}
+	if shadowedFromPoint == 0 {
+		t.Fatal("The facets should shadow some points that face the light")
+	}
+	if shadowedFromOrigin*4 > shadowedFromPoint {
+		t.Errorf("Of %d points facing the light, %d were shadowed by the facets and still %d from their shadow origins",
+			lit, shadowedFromPoint, shadowedFromOrigin)
+	}
+}
+
+func TestBidirectionalPathTracerIsUnbiased(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 1}, Radius: 1,
+		Material: &material.Glossy{Albedo: image.Color{R: 0.8, G: 0.8, B: 0.8}, Exponent: 20}})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 1, Y: 4}, Radius: 0.5, Radiance: image.Color{R: 10, G: 10, B: 10}})
+	bdpt := &BidirectionalPathTracer{MaxDepth: 3}
+	rng := sampling.New(1, 0)
+
+	// Points of the floor lit by the light, and in the shadow of the
+	// sphere where only the light it reflects arrives
+	for _, x := range []float64{1.5, -1.5} {
+		view := &math3d.LightRay{Source: math3d.Vector3{X: x, Y: 3}, Direction: math3d.Vector3{Y: -1}}
+		mean, variance := estimate(20000, func() float64 {
+			c := bdpt.Radiance(s, view, rng)
+			return c.R
+		})
+		// Tracing paths from the camera alone until they hit the light
+		// finds the same light, with a lot more noise
+		reference, referenceVariance := estimate(200000, func() float64 {
+			path := s.randomWalk(nil, *view, image.White, bdpt.MaxDepth+1, bdpt.MaxDepth, false, rng)
+			if last := path[len(path)-1]; last.light != nil {
+				emitted := last.light.Emission(&last.normal, &last.previous)
+				return emitted.CMultiply(&last.beta).R
+			}
+			return 0
+		})
+		if mean <= 0 {
+			t.Fatalf("The floor at x=%g should be lit", x)
+		}
+		if tolerance := 4 * math.Sqrt(variance/20000+referenceVariance/200000); math.Abs(mean-reference) > tolerance {
+			t.Errorf("The bidirectional estimate %f at x=%g should match the one from the camera %f", mean, x, reference)
+		}
+		if variance >= referenceVariance {
+			t.Errorf("Joining paths should have less variance at x=%g, it has %f instead of %f", x, variance, referenceVariance)
+		}
+	}
+
+	// Bouncing once, it's the direct lighting, which is less than the
+	// light bouncing three times
+	view := &math3d.LightRay{Source: math3d.Vector3{X: 1.5, Y: 3}, Direction: math3d.Vector3{Y: -1}}
+	deep, _ := estimate(20000, func() float64 {
+		c := bdpt.Radiance(s, view, rng)
+		return c.R
+	})
+	bdpt.MaxDepth = 1
+	mean, variance := estimate(20000, func() float64 {
+		c := bdpt.Radiance(s, view, rng)
+		return c.R
+	})
+	direct, directVariance := estimate(20000, func() float64 {
+		c := DirectLighting{}.Radiance(s, view, rng)
+		return c.R
+	})
+	if math.Abs(mean-direct) > 4*math.Sqrt((variance+directVariance)/20000) {
+		t.Errorf("With a single bounce the estimate %f should match the direct lighting %f", mean, direct)
+	}
+	if mean >= deep {
+		t.Errorf("More bounces should bring more light, %f with one and %f with three", mean, deep)
+	}
+}
+
+func TestRussianRouletteLeavesTheEstimateUnbiased(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100,
+		Material: &material.Lambertian{Albedo: image.Color{R: 0.5, G: 0.5, B: 0.5}}})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 1}, Radius: 1,
+		Material: &material.Lambertian{Albedo: image.Color{R: 0.7, G: 0.7, B: 0.7}}})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 1, Y: 4}, Radius: 0.5, Radiance: image.Color{R: 10, G: 10, B: 10}})
+	view := &math3d.LightRay{Source: math3d.Vector3{X: -1.5, Y: 3}, Direction: math3d.Vector3{Y: -1}}
+	roulette, always := &BidirectionalPathTracer{MaxDepth: 6}, &BidirectionalPathTracer{RouletteDepth: 6, MaxDepth: 6}
+	rng := sampling.New(1, 0)
+	mean, variance := estimate(40000, func() float64 {
+		c := roulette.Radiance(s, view, rng)
+		return c.R
+	})
+	reference, referenceVariance := estimate(40000, func() float64 {
+		c := always.Radiance(s, view, rng)
+		return c.R
+	})
+	if tolerance := 4 * math.Sqrt(variance/40000+referenceVariance/40000); math.Abs(mean-reference) > tolerance {
+		t.Errorf("The estimate with Russian roulette %f should match the one without it %f", mean, reference)
+	}
+	// The paths that roulette ends are shorter
+	vertices := func(rouletteDepth int) int {
+		total := 0
+		for i := 0; i < 2000; i++ {
+			total += len(s.randomWalk(nil, *view, image.White, 7, rouletteDepth, false, rng))
+		}
+		return total
+	}
+	if cut, full := vertices(0), vertices(6); cut >= full {
+		t.Errorf("Russian roulette should end paths early, but they have %d vertices, and %d without it", cut, full)
+	}
+}
+
+func TestTwoSidedSphereLightsLightTheInside(t *testing.T) {
+	light := &lighting.SphereLight{Radius: 2, Radiance: image.Color{R: 3, G: 3, B: 3}}
+	s := New()
+	s.AddLight(light)
+	point, normal, out := math3d.Vector3{}, math3d.UnitY, math3d.UnitY
+	view := &math3d.LightRay{Direction: math3d.UnitX}
+	rng := sampling.New(1, 0)
+
+	if c := s.directLight(nil, &point, &normal, &out, material.Default, light, 0, rng); c.Luminance() != 0 {
+		t.Errorf("A one sided light shouldn't light its inside, it gives %s", c.String())
+	}
+	if _, c := s.nearestLight(view); c.Luminance() != 0 {
+		t.Errorf("The inside of a one sided light should look black, it's %s", c.String())
+	}
+	// Surrounded by the light, a white lambertian surface reflects all of it
+	light.TwoSided = true
+	mean, _ := estimate(2000, func() float64 {
+		c := s.directLight(nil, &point, &normal, &out, material.Default, light, 0, rng)
+		return c.R
+	})
+	if math.Abs(mean-3) > 0.05 {
+		t.Errorf("A two sided light should light its inside with a radiance of 3, it gives %f", mean)
+	}
+	if _, c := s.nearestLight(view); c.R != 3 {
+		t.Errorf("The inside of a two sided light should look lit, it's %s", c.String())
+	}
+}
+
+func TestCausticsMatchTheBidirectionalPathTracer(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 1}, Radius: 1,
+		Material: &material.Glossy{Albedo: image.White, Exponent: 1000}})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 2.5, Y: 1.5}, Radius: 0.3, Radiance: image.Color{R: 20, G: 20, B: 20}})
+	s.Settings.Photons, s.Settings.MaxDepth = 1000000, 2
+	// A point of the floor that sees the light in the sphere
+	point, normal := math3d.Vector3{X: 1.25}, math3d.UnitY
+	view := &math3d.LightRay{Source: math3d.Vector3{X: 1.25, Y: 3}, Direction: math3d.Vector3{Y: -1}}
+
+	caustic := s.causticRadiance(s.causticMap(), &point, &normal, &normal, material.Default)
+	withCaustics := DirectLighting{}.Radiance(s, view, sampling.New(1, 0))
+	s.Settings.Photons = 0
+	withoutCaustics := DirectLighting{}.Radiance(s, view, sampling.New(1, 0))
+	if math.Abs(withCaustics.R-withoutCaustics.R-caustic.R) > 1e-9 {
+		t.Errorf("The direct lighting should add the caustics %f, it went from %f to %f", caustic.R, withoutCaustics.R, withCaustics.R)
+	}
+
+	// With two bounces, the only light the direct lighting misses is the
+	// light that the sphere reflects onto the floor
+	rng := sampling.New(2, 0)
+	direct, directVariance := estimate(5000, func() float64 {
+		c := DirectLighting{}.Radiance(s, view, rng)
+		return c.R
+	})
+	bdpt := &BidirectionalPathTracer{MaxDepth: 2}
+	reference, referenceVariance := estimate(50000, func() float64 {
+		c := bdpt.Radiance(s, view, rng)
+		return c.R
+	})
+	// The photons are noisy too, about one over the square root of their
+	// number
+	missing := reference - direct
+	tolerance := 4*math.Sqrt(directVariance/5000+referenceVariance/50000) + caustic.R/math.Sqrt(causticNeighbours)
+	if caustic.R <= 0 || math.Abs(caustic.R-missing) > tolerance {
+		t.Errorf("The caustics should bring the missing %f, they bring %f", missing, caustic.R)
+	}
+}
+
+func TestAmbientOcclusionDarkensCorners(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 1}, Radius: 1})
+	ao := &AmbientOcclusionIntegrator{Rays: 64, MaxDistance: 2}
+	rng := sampling.New(1, 0)
+	down := math3d.Vector3{Y: -1}
+
+	open := ao.Radiance(s, &math3d.LightRay{Source: math3d.Vector3{X: 5, Y: 1}, Direction: down}, rng)
+	if open.R != 1 {
+		t.Errorf("Nothing occludes the floor far from the sphere, it's %f", open.R)
+	}
+	corner := ao.Radiance(s, &math3d.LightRay{Source: math3d.Vector3{X: 1.2, Y: 3}, Direction: down}, rng)
+	if corner.R <= 0 || corner.R >= 0.9 {
+		t.Errorf("The sphere should occlude part of the floor next to it, it's %f", corner.R)
+	}
+	// Shapes beyond the distance don't occlude
+	ao.MaxDistance = 0.01
+	if corner = ao.Radiance(s, &math3d.LightRay{Source: math3d.Vector3{X: 1.2, Y: 3}, Direction: down}, rng); corner.R != 1 {
+		t.Errorf("The sphere is farther than the distance, yet the floor is %f", corner.R)
+	}
+}
+
+func TestBakeLightsTheSideFacingTheLight(t *testing.T) {
+	s := New()
+	s.AddShape(&shape.Sphere{Radius: 1})
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 10}, Intensity: *image.White.Multiply(0.8 * math.Pi)})
+	lightmap, err := s.Bake(context.Background(), 0, 16, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// v grows upwards, so the first row is the top of the sphere
+	if top := lightmap.NRGBAAt(3, 0); top.R < 190 || top.R > 204 {
+		t.Errorf("The top of the sphere should reflect 0.8 of the light, it's %d", top.R)
+	}
+	if bottom := lightmap.NRGBAAt(3, 15); bottom.R != 0 {
+		t.Errorf("The bottom of the sphere is in shadow, yet it's %d", bottom.R)
+	}
+	s.AddShape(&shape.Curve{})
+	if _, err := s.Bake(context.Background(), 1, 16, 16, 0); err == nil {
+		t.Error("Curves have no texture space to bake")
+	}
+}
+
+// disc is a sphere whose texture space only covers a disc of texels
+type disc struct {
+	*shape.Sphere
+}
+
+func (d disc) SurfaceAt(u, v float64) (point, normal math3d.Vector3, ok bool) {
+	if (u-0.5)*(u-0.5)+(v-0.5)*(v-0.5) > 0.25*0.25 {
+		return point, normal, false
+	}
+	return d.Sphere.SurfaceAt(u, v)
+}
+
+func TestBakePadsTheCoveredTexels(t *testing.T) {
+	s := New()
+	s.AddShape(disc{&shape.Sphere{Radius: 1}})
+	s.Settings.Integrator = AmbientOcclusion
+	for _, test := range []struct {
+		padding  int
+		x        int
+		expected uint8
+	}{
+		{0, 8, 255},
+		{0, 3, 0},
+		{1, 3, 255},
+		{1, 2, 0},
+		{3, 1, 255},
+		{3, 0, 0},
+	} {
+		lightmap, err := s.Bake(context.Background(), 0, 16, 16, test.padding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := lightmap.NRGBAAt(test.x, 8); c.R != test.expected {
+			t.Errorf("With a padding of %d the texel %d should be %d, not %d", test.padding, test.x, test.expected, c.R)
+		}
+	}
+}
+
+func TestKDTreeRendersLikeTheBVH(t *testing.T) {
+	s := testScene()
+	withBVH := s.TraceScene(48, 48)
+	s.Settings.Accelerator = KDTree
+	withKDTree := s.TraceScene(48, 48)
+	if _, ok := s.accelerator().(*accel.KDTree); !ok {
+		t.Fatalf("The scene should trace with a kd-tree, it traces with %T", s.accelerator())
+	}
+	if !bytes.Equal(withBVH.Pix, withKDTree.Pix) {
+		t.Error("The kd-tree should find the same shapes as the BVH")
+	}
+	s.MoveShape(0, &math3d.Vector3{X: 0.1})
+	s.Settings.Accelerator = BVH
+	moved := s.TraceScene(48, 48)
+	s.Settings.Accelerator = KDTree
+	if !bytes.Equal(moved.Pix, s.TraceScene(48, 48).Pix) {
+		t.Error("The kd-tree should be built again when shapes move")
+	}
+}
+
+func TestTwoLevelStructureRendersLikeTheBVH(t *testing.T) {
+	withBVH, withTwoLevel := testScene(), testScene()
+	withTwoLevel.Settings.Accelerator = TwoLevel
+	for frame := 0; frame < 3; frame++ {
+		if frame > 0 {
+			withBVH.MoveShape(0, &math3d.Vector3{X: 0.1})
+			withTwoLevel.MoveShape(0, &math3d.Vector3{X: 0.1})
+		}
+		rendered := withBVH.TraceScene(48, 48)
+		if !bytes.Equal(rendered.Pix, withTwoLevel.TraceScene(48, 48).Pix) {
+			t.Fatalf("Frame %d: the two level structure should find the same shapes as the BVH", frame)
+		}
+		structure, ok := withTwoLevel.accelerator().(*accel.TwoLevel)
+		if !ok {
+			t.Fatalf("The scene should trace with a two level structure, it traces with %T", withTwoLevel.accelerator())
+		}
+		// Once it moved, the shape has an instance of its own
+		expected := 1
+		if frame > 0 {
+			expected = 2
+		}
+		if structure.Instances() != expected {
+			t.Fatalf("Frame %d: there should be %d instances, there are %d", frame, expected, structure.Instances())
+		}
+	}
+}
+
+func TestSBVHRendersLikeTheBVH(t *testing.T) {
+	withBVH, withSBVH := testScene(), testScene()
+	withSBVH.Settings.Accelerator = SBVH
+	if !bytes.Equal(withBVH.TraceScene(48, 48).Pix, withSBVH.TraceScene(48, 48).Pix) {
+		t.Fatal("The SBVH should find the same shapes as the BVH")
+	}
+	built := withSBVH.accelerator()
+	if bvh, ok := built.(*accel.BVH); !ok || !bvh.Spatial() {
+		t.Fatalf("The scene should trace with an SBVH, it traces with %T", built)
+	}
+	withSBVH.Settings.SplitBudget = 1
+	if withSBVH.accelerator() == built {
+		t.Error("The SBVH should be built again when the split budget changes")
+	}
+}
+
+func TestSunCastsSoftShadows(t *testing.T) {
+	floor := &shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100}
+	s := New()
+	s.AddShape(floor)
+	sun := &lighting.SunLight{Direction: math3d.UnitY, AngularRadius: 0.1, Irradiance: image.White}
+	s.AddLight(sun)
+	point, normal, out := math3d.Vector3{}, math3d.UnitY, math3d.UnitY
+	rng := sampling.New(5, 0)
+	lit, _ := estimate(20000, func() float64 {
+		c := s.directLight(floor, &point, &normal, &out, material.Default, sun, 0, rng)
+		return c.R
+	})
+	// A ball half as wide as the sun seen from the floor covers a quarter
+	// of it
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 5}, Radius: 5 * math.Sin(0.05)})
+	penumbra, _ := estimate(20000, func() float64 {
+		c := s.directLight(floor, &point, &normal, &out, material.Default, sun, 0, rng)
+		return c.R
+	})
+	if math.Abs(penumbra/lit-0.75) > 0.02 {
+		t.Errorf("The ball should block a quarter of the sun, it blocks %f", 1-penumbra/lit)
+	}
+}
+
+func TestDistantLightsAreLeftOutOfTheLightTree(t *testing.T) {
+	floor := &shape.Sphere{Position: math3d.Vector3{Y: -100}, Radius: 100}
+	s := New()
+	s.AddShape(floor)
+	sky := lighting.NewSkyLight(math3d.Vector3{X: 1, Y: 2}, 3, image.Color{R: 0.2, G: 0.2, B: 0.2}, 0.1)
+	s.AddLight(sky)
+	s.AddLight(sky.Sun())
+	for i := 0; i < 10; i++ {
+		s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: float64(i) - 4.5, Y: 3}, Radius: 0.2, Radiance: image.White})
+	}
+	point, normal := math3d.Vector3{}, math3d.UnitY
+	view := &math3d.LightRay{Source: math3d.Vector3{Y: 5, Z: -5}, Direction: math3d.Vector3{Y: -5, Z: 5}.NormalizedV()}
+	out := view.Direction.MultiplyV(-1)
+	rng := sampling.New(7, 0)
+
+	exact := 0.0
+	for _, ls := range s.Lights {
+		mean, _ := estimate(4000, func() float64 {
+			c := s.directLight(floor, &point, &normal, &out, material.Default, ls, 0, rng)
+			return c.R
+		})
+		exact += mean
+	}
+	mean, _ := estimate(100000, func() float64 {
+		c := s.calculateRadianceAt(&point, view, floor, rng)
+		return c.R
+	})
+	if math.Abs(mean-exact) > 0.02*exact {
+		t.Errorf("The sky, the sun and the light tree should light the floor with %f, not %f", exact, mean)
+	}
+	up := math3d.LightRay{Source: math3d.Vector3{Y: 1}, Direction: math3d.Vector3{Y: 1, Z: 0.1}.NormalizedV()}
+	if distance, ls := s.lightHit(&up); distance != lighting.Far || ls != sky {
+		t.Errorf("A ray into the sky should hit it far away, not %v at %g", ls, distance)
+	}
+}
+
+func TestRayOffsetRemovesShadowAcne(t *testing.T) {
+	// A face duplicated a hair above the floor, as exported meshes have
+	// them, shadows the floor where it would be lit
+	s := New()
+	floor := &shape.Triangle{Vertices: [3]math3d.Vector3{{X: -1, Z: -1}, {X: 0, Z: 1}, {X: 1, Z: -1}}}
+	duplicate := &shape.Triangle{Vertices: [3]math3d.Vector3{{X: -1, Y: 3e-5, Z: -1}, {X: 0, Y: 3e-5, Z: 1}, {X: 1, Y: 3e-5, Z: -1}}}
+	s.AddShape(floor)
+	s.AddShape(duplicate)
+	light := &lighting.PointLight{Position: math3d.Vector3{Y: 2}, Intensity: image.White}
+	s.AddLight(light)
+	s.Prepare()
+	point, normal := math3d.Vector3{}, math3d.UnitY
+	rng := sampling.New(1, 0)
+	if _, _, ok := s.lightArriving(floor, &point, &normal, light, 0, rng); ok {
+		t.Error("Without an offset, the duplicate face should shadow the floor")
+	}
+	s.Settings.RayOffset = 1e-4
+	if _, _, ok := s.lightArriving(floor, &point, &normal, light, 0, rng); !ok {
+		t.Error("With an offset past the duplicate face, the floor should be lit")
+	}
+}
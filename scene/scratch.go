@@ -0,0 +1,127 @@
+package scene
+
+import (
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// The number of values of every kind a scratch holds. No function that
+// takes a scratch needs more of them at once.
+const (
+	scratchVectors       = 8
+	scratchRays          = 4
+	scratchHits          = 2
+	scratchDifferentials = 2
+)
+
+// scratch is memory that tracing reuses instead of allocating it on the
+// heap. The compiler can't tell what the shapes, the lights, the materials
+// and the acceleration structure do with the pointers they're handed, so
+// the vectors, lightrays and hits whose pointers they get would escape to
+// the heap as local variables, several times for every lightray. The
+// functions that trace take them from a scratch instead, and the buffers
+// that grow with the paths traced are kept in it too.
+//
+// Scratches come from a pool, which ends up holding about one for every
+// goroutine tracing, so tracing a sample allocates nothing once the
+// render is under way. A function takes a scratch with getScratch and
+// gives it back with release when it returns, and the values it took from
+// the scratch mustn't be used after that.
+type scratch struct {
+	vectors       [scratchVectors]math3d.Vector3
+	rays          [scratchRays]math3d.LightRay
+	hits          [scratchHits]shape.Hit
+	differentials [scratchDifferentials]math3d.Differential
+	// The number of values of every kind taken
+	nVectors, nRays, nHits, nDifferentials int
+
+	// rng is the generator of the sample traced with the scratch
+	rng sampling.Rand
+	// integrators holds the integrators the settings choose from
+	integrators builtinIntegrators
+	// The paths of the bidirectional path tracer, the path joining them
+	// and the densities of its vertices
+	cameraPath, lightPath []pathVertex
+	joined                []*pathVertex
+	fromCamera, fromLight []float64
+	// densities holds the densities of the volumes at a point
+	densities []float64
+}
+
+var scratchPool = sync.Pool{New: func() interface{} { return new(scratch) }}
+
+// getScratch returns a scratch from the pool with none of its values taken
+func getScratch() *scratch {
+	return scratchPool.Get().(*scratch)
+}
+
+// release gives the scratch back to the pool
+func (sc *scratch) release() {
+	sc.nVectors, sc.nRays, sc.nHits, sc.nDifferentials = 0, 0, 0, 0
+	// The pool mustn't keep the shapes and the materials of the paths,
+	// nor the lightrays their origins, alive
+	for i := range sc.rays {
+		sc.rays[i] = math3d.LightRay{}
+	}
+	for i := range sc.hits {
+		sc.hits[i] = shape.Hit{}
+	}
+	sc.cameraPath, sc.lightPath = clearPath(sc.cameraPath), clearPath(sc.lightPath)
+	for i := range sc.joined {
+		sc.joined[i] = nil
+	}
+	scratchPool.Put(sc)
+}
+
+// clearPath returns the path emptied, with no vertex keeping its shape,
+// material and light alive
+func clearPath(path []pathVertex) []pathVertex {
+	for i := range path {
+		path[i] = pathVertex{}
+	}
+	return path[:0]
+}
+
+// vector returns a vector of the scratch set to v
+func (sc *scratch) vector(v math3d.Vector3) *math3d.Vector3 {
+	p := &sc.vectors[sc.nVectors]
+	sc.nVectors++
+	*p = v
+	return p
+}
+
+// ray returns a lightray of the scratch set to lr
+func (sc *scratch) ray(lr math3d.LightRay) *math3d.LightRay {
+	p := &sc.rays[sc.nRays]
+	sc.nRays++
+	*p = lr
+	return p
+}
+
+// hit returns a hit of the scratch set to h
+func (sc *scratch) hit(h shape.Hit) *shape.Hit {
+	p := &sc.hits[sc.nHits]
+	sc.nHits++
+	*p = h
+	return p
+}
+
+// differential returns a differential of the scratch set to d
+func (sc *scratch) differential(d math3d.Differential) *math3d.Differential {
+	p := &sc.differentials[sc.nDifferentials]
+	sc.nDifferentials++
+	*p = d
+	return p
+}
+
+// floats returns buffer resized to n numbers, reusing its memory if it's
+// big enough
+func floats(buffer []float64, n int) []float64 {
+	if cap(buffer) < n {
+		return make([]float64, n)
+	}
+	return buffer[:n]
+}
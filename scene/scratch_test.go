@@ -0,0 +1,38 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func TestTracingSamplesDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items at random under the race detector")
+	}
+	for _, integrator := range []string{Direct, FixedPath, Bidirectional, AmbientOcclusion, Toon} {
+		s := testScene()
+		s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 0.1, Z: 0.8}, Radius: 0.1, Material: &material.Dielectric{Albedo: image.White, IOR: 1.5}})
+		s.AddShape(&shape.Triangle{Vertices: [3]math3d.Vector3{{X: -1, Y: -1, Z: 2}, {X: 1, Y: -1, Z: 2}, {X: 0, Y: 1, Z: 2}}})
+		s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 1, Y: 1, Z: 0.5}, Radius: 0.2, Radiance: image.White})
+		s.Settings.Integrator = integrator
+		s.Prepare()
+		targetIt := s.Camera.GetIterator(16, 16)
+		// The first samples fill the pool and grow the buffers of the paths
+		for i := 0; i < 256; i++ {
+			s.TraceSample(targetIt, i%16, i/16, 0)
+		}
+		n := 0
+		allocs := testing.AllocsPerRun(256, func() {
+			s.TraceSample(targetIt, n%16, n/16%16, n)
+			n++
+		})
+		if allocs != 0 {
+			t.Errorf("Tracing a sample with the %s integrator allocated %v times", integrator, allocs)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package scene
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Section cuts away part of every shape of the scene to show their
+// insides, as technical and medical illustrations do. It keeps what's
+// behind a plane, or inside a box, and caps the cuts through closed
+// shapes with a material of their own, so that they look solid rather
+// than hollow. The parts cut away don't cast shadows, and the caps don't
+// either.
+type Section struct {
+	// Point and Normal are a point of the plane and the normal pointing to
+	// the side that is cut away, when there's no box
+	Point  *math3d.Vector3 `json:"point,omitempty"`
+	Normal *math3d.Vector3 `json:"normal,omitempty"`
+	// Box is the box whose inside is kept, if it isn't nil
+	Box *math3d.AABB `json:"box,omitempty"`
+	// Cap is the material of the caps, the default one if it's nil
+	Cap material.Material `json:"cap,omitempty"`
+}
+
+// Validate returns an error if the section has neither a plane nor a box
+// or can't be used
+func (sec *Section) Validate() error {
+	switch {
+	case sec.Box != nil && (sec.Point != nil || sec.Normal != nil):
+		return errors.New("the section must have either a plane or a box, not both")
+	case sec.Box != nil:
+		b := sec.Box
+		if !finite(b.Min.X, b.Min.Y, b.Min.Z, b.Max.X, b.Max.Y, b.Max.Z) || !b.Min.LesserOrEqual(&b.Max) {
+			return errors.New("the min of the box must be finite and below the max")
+		}
+	case sec.Point == nil || sec.Normal == nil:
+		return errors.New("the section must have a point and a normal, or a box")
+	case !finite(sec.Point.X, sec.Point.Y, sec.Point.Z, sec.Normal.X, sec.Normal.Y, sec.Normal.Z):
+		return errors.New("the point and the normal must be finite")
+	case sec.Normal.Abs() == 0:
+		return errors.New("the normal can't be zero")
+	}
+	return nil
+}
+
+// keeps returns whether the point is in the part of the scene that the
+// section keeps
+func (sec *Section) keeps(point *math3d.Vector3) bool {
+	if sec.Box != nil {
+		return sec.Box.Contains(point)
+	}
+	return point.SubtractV(*sec.Point).DotV(*sec.Normal) <= 0
+}
+
+// entry returns the distance at which the lightray enters the part of the
+// scene that the section keeps from the part it cuts away, or
+// math.MaxFloat64 if it starts inside it or never enters it
+func (sec *Section) entry(lr *math3d.LightRay) float64 {
+	if sec.keeps(&lr.Source) {
+		return math.MaxFloat64
+	}
+	if sec.Box != nil {
+		near, _ := sec.Box.IntersectRange(lr)
+		return near
+	}
+	toward := lr.Direction.DotV(*sec.Normal)
+	if toward >= 0 {
+		return math.MaxFloat64
+	}
+	return lr.Source.SubtractV(*sec.Point).DotV(*sec.Normal) / -toward
+}
+
+// normalAt returns the normal of the cut at the point, which points to
+// the part that is cut away
+func (sec *Section) normalAt(point *math3d.Vector3) math3d.Vector3 {
+	if sec.Box == nil {
+		return sec.Normal.NormalizedV()
+	}
+	// The face of the box nearest to the point
+	faces := []struct {
+		distance float64
+		normal   math3d.Vector3
+	}{
+		{point.X - sec.Box.Min.X, math3d.Vector3{X: -1}}, {sec.Box.Max.X - point.X, math3d.Vector3{X: 1}},
+		{point.Y - sec.Box.Min.Y, math3d.Vector3{Y: -1}}, {sec.Box.Max.Y - point.Y, math3d.Vector3{Y: 1}},
+		{point.Z - sec.Box.Min.Z, math3d.Vector3{Z: -1}}, {sec.Box.Max.Z - point.Z, math3d.Vector3{Z: 1}},
+	}
+	nearest := faces[0]
+	for _, f := range faces[1:] {
+		if math.Abs(f.distance) < math.Abs(nearest.distance) {
+			nearest = f
+		}
+	}
+	return nearest.normal
+}
+
+// SetSection cuts the shapes of the scene with the section, or makes them
+// whole again if it's nil. It must not be called while the scene is
+// traced.
+func (s *Scene) SetSection(sec *Section) {
+	s.mustBeEditable()
+	s.Section = sec
+	s.caustics, s.probeMaps = nil, nil
+	s.dirtyAll = true
+	if s.structure != nil {
+		s.installFilter()
+	}
+}
+
+// sectionCap is the cap of the cuts of a section through the shapes. The
+// scene gives it back as the shape that the lightrays hit where they
+// enter a closed shape through a cut.
+type sectionCap Section
+
+// capOf returns the cap of the cut through sh, if the lightray that hits
+// sh at distance enters it through a cut of the section before that
+func (s *Scene) capOf(lr *math3d.LightRay, sh shape.Shape, distance float64) (float64, shape.Shape, bool) {
+	if s.Section == nil {
+		return distance, sh, false
+	}
+	entry := s.Section.entry(lr)
+	if entry >= distance || !shape.HitAt(sh, lr, distance).Backface {
+		return distance, sh, false
+	}
+	return entry, (*sectionCap)(s.Section), true
+}
+
+// Intersect returns the distance at which the lightray enters the part of
+// the scene that the section keeps
+func (c *sectionCap) Intersect(lr *math3d.LightRay) float64 {
+	return (*Section)(c).entry(lr)
+}
+
+// NormalAt returns the normal of the cut at the point
+func (c *sectionCap) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	return (*Section)(c).normalAt(point)
+}
+
+// AsMap returns nil, as the caps are saved with the section rather than
+// as shapes
+func (c *sectionCap) AsMap() map[string]interface{} {
+	return nil
+}
+
+// Bounds returns the box of the section, or the whole space for a plane
+func (c *sectionCap) Bounds() *math3d.AABB {
+	if c.Box != nil {
+		box := *c.Box
+		return &box
+	}
+	inf := math.Inf(1)
+	return &math3d.AABB{Min: math3d.Vector3{X: -inf, Y: -inf, Z: -inf}, Max: math3d.Vector3{X: inf, Y: inf, Z: inf}}
+}
+
+// Surface returns the material of the caps
+func (c *sectionCap) Surface() material.Material {
+	return c.Cap
+}
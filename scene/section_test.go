@@ -0,0 +1,86 @@
+package scene
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+func TestSectionCapsTheCuts(t *testing.T) {
+	s, _, err := ParseScene([]byte(validScene), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	red := &material.Lambertian{Albedo: image.Red}
+	// The half of the sphere facing the camera is cut away
+	s.SetSection(&Section{Point: &math3d.Vector3{Z: 3}, Normal: &math3d.Vector3{Z: -1}, Cap: red})
+	lr := math3d.LightRay{Source: s.Camera.FocalPoint, Direction: math3d.UnitZ}
+	distance, sh, index := s.nearestShape(&lr)
+	if _, ok := sh.(*sectionCap); !ok || index != 0 || math.Abs(distance-4) > 1e-9 {
+		t.Fatalf("The ray should see the cap of the sphere at 4, not %T at %g", sh, distance)
+	}
+	if normal := sh.NormalAt(&math3d.Vector3{Z: 3}); normal != (math3d.Vector3{Z: -1}) {
+		t.Errorf("The cap should face the part cut away, not %v", normal)
+	}
+	if c := (DirectLighting{}).Radiance(s, &lr, sampling.New(1, 0)); !(c.R > 0 && c.G == 0 && c.B == 0) {
+		t.Errorf("The cap should be lit with its red material, not %s", c.String())
+	}
+	if s.inShadow(&lr, 4) {
+		t.Error("The cut half of the sphere shouldn't cast shadows")
+	}
+	// Past the sphere, the ray crosses the plane outside of any shape
+	past := math3d.LightRay{Source: math3d.Vector3{X: 2, Z: -1}, Direction: math3d.UnitZ}
+	if _, sh := s.getNearestIntersection(&past); sh != nil {
+		t.Errorf("The ray missing the sphere shouldn't hit a cap, it hits %T", sh)
+	}
+
+	s.SetSection(&Section{Box: &math3d.AABB{Min: math3d.Vector3{X: -2, Y: -2, Z: 3.5}, Max: math3d.Vector3{X: 2, Y: 2, Z: 10}}})
+	if distance, sh := s.getNearestIntersection(&lr); math.Abs(distance-4.5) > 1e-9 || sh.NormalAt(&math3d.Vector3{Z: 3.5}) != (math3d.Vector3{Z: -1}) {
+		t.Errorf("The ray should see the cap where it enters the box at 4.5, not at %g", distance)
+	}
+	s.SetSection(nil)
+	if distance, _ := s.getNearestIntersection(&lr); math.Abs(distance-3) > 1e-9 {
+		t.Errorf("Without the section the ray should hit the front of the sphere again, not at %g", distance)
+	}
+}
+
+func TestParseSection(t *testing.T) {
+	section := `"section": {"point": {"x": 0, "y": 0, "z": 3}, "normal": {"x": 0, "y": 0, "z": -1}, "cap": {"type": "lambertian", "albedo": {"r": 1, "g": 0, "b": 0}}},
+	"lights"`
+	s, _, err := ParseScene([]byte(strings.Replace(validScene, `"lights"`, section, 1)), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := ParseScene(data, Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sec := parsed.Section; sec == nil || *sec.Normal != (math3d.Vector3{Z: -1}) || sec.Cap.(*material.Lambertian).Albedo != image.Red {
+		t.Errorf("The section should be saved as it was parsed, not as %s", data)
+	}
+	for _, invalid := range []string{
+		`{"point": {"x": 0, "y": 0, "z": 3}}`,
+		`{"point": {"x": 0, "y": 0, "z": 3}, "normal": {"x": 0, "y": 0, "z": 0}}`,
+		`{"box": {"min": {"x": 1, "y": 1, "z": 1}, "max": {"x": 0, "y": 0, "z": 0}}}`,
+		`{"box": {"min": {"x": 0, "y": 0, "z": 0}, "max": {"x": 1, "y": 1, "z": 1}}, "normal": {"x": 0, "y": 0, "z": 1}}`,
+		`{"point": {"x": 0, "y": 0, "z": 3}, "normal": {"x": 0, "y": 0, "z": -1}, "cap": {"type": "metal"}}`,
+	} {
+		scene := strings.Replace(validScene, `"lights"`, `"section": `+invalid+`, "lights"`, 1)
+		if _, _, err := ParseScene([]byte(scene), Strict); err == nil {
+			t.Errorf("The section %s shouldn't parse", invalid)
+		}
+		s, warnings, err := ParseScene([]byte(scene), Lenient)
+		if err != nil || len(warnings) == 0 || s.Section != nil {
+			t.Errorf("The section %s should be left out with a warning (%v)", invalid, err)
+		}
+	}
+}
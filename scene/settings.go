@@ -0,0 +1,529 @@
+package scene
+
+import (
+	"errors"
+	stdcol "image/color"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+// Color spaces of the pixels of renders
+const (
+	// Linear pixels hold the radiance as it is
+	Linear = "linear"
+	// SRGB pixels hold the radiance encoded with the sRGB transfer
+	// function, which is what most displays and image viewers expect
+	SRGB = "srgb"
+	// Rec709 pixels hold the radiance encoded with the Rec. 709 transfer
+	// function, for HD video
+	Rec709 = "rec709"
+	// DisplayP3 pixels hold the radiance in the wider gamut of Display P3,
+	// for the wide gamut displays. The images don't say so, so they must
+	// be viewed as Display P3 ones.
+	DisplayP3 = "displayp3"
+)
+
+// Integrators that compute the light reaching the camera
+const (
+	// Direct only follows the light that bounces once, from the lights
+	// to the camera
+	Direct = "direct"
+	// Bidirectional follows the light that bounces many times, joining
+	// paths traced from the camera and from the lights
+	Bidirectional = "bdpt"
+	// AmbientOcclusion ignores the lights and shades every surface by how
+	// much of the space above it is free of other shapes
+	AmbientOcclusion = "ao"
+	// FixedPath follows the light that bounces a fixed range of times,
+	// tracing paths from the camera that are never cut short at random,
+	// to validate renders at controlled bounce counts
+	FixedPath = "fixedpath"
+	// Toon shades every surface with a few flat bands of its color and
+	// outlines the shapes, as illustrations and cartoons do
+	Toon = "toon"
+	// HiddenLine draws the lines of the shapes that the camera sees, their
+	// silhouettes, creases and the lines where they meet, in black on white,
+	// as technical drawings do
+	HiddenLine = "hiddenline"
+)
+
+// Acceleration structures that find the shapes rays hit
+const (
+	// BVH is a bounding volume hierarchy, which builds quickly and can be
+	// refitted when shapes move
+	BVH = "bvh"
+	// KDTree is a kd-tree built with the surface area heuristic, which
+	// may trace static scenes whose shapes overlap a lot faster
+	KDTree = "kdtree"
+	// TwoLevel keeps the shapes that stay still in a BVH of their own and
+	// gives every shape that moves another one, so animating a few shapes
+	// only rebuilds the small hierarchy above them
+	TwoLevel = "twolevel"
+	// SBVH is a bounding volume hierarchy built with the surface area
+	// heuristic that also splits space, putting the shapes across a split
+	// on both sides, within the SplitBudget, which builds slowly but traces
+	// scenes with large, poorly shaped triangles much faster
+	SBVH = "sbvh"
+	// GPU is a BVH that the depth pass traces on a GPU, in the builds
+	// with the gpu tag, if its shapes are all triangles without holes that
+	// don't move. It's traced as a BVH on the CPU otherwise, and by every
+	// other pass.
+	GPU = "gpu"
+)
+
+// Ways of treating the back faces of surfaces, the sides their normals
+// point away from
+const (
+	// Cull makes the lightrays that hit the back faces of triangles go
+	// through them, as if they weren't there
+	Cull = "cull"
+	// TwoSided shades the back faces as the front faces with every
+	// integrator. The ones that follow the light around the scene always
+	// do, and the direct integrator only does with it.
+	TwoSided = "twosided"
+)
+
+// Settings holds the options that control how a scene is rendered.
+// They are stored in the "render" section of scene files.
+type Settings struct {
+	// Samples is the number of samples taken per pixel, or the maximum
+	// number when sampling is adaptive. With a single sample, the center
+	// of the pixel is traced.
+	Samples int `json:"samples"`
+	// MinSamples is the number of samples every pixel gets before adaptive
+	// sampling can stop sampling it.
+	MinSamples int `json:"minsamples"`
+	// AdaptiveThreshold enables adaptive sampling when it's positive. A
+	// pixel stops being sampled once the 95% confidence interval of its
+	// luminance is narrower than this fraction of its mean luminance.
+	AdaptiveThreshold float64 `json:"adaptivethreshold"`
+	// VolumeStep is the distance between the points where the light
+	// scattered by the medium is gathered along camera rays
+	VolumeStep float64 `json:"volumestep"`
+	// ShadowStep is the distance between the points where the density of
+	// the volumes is looked up along the rays towards the lights. Longer
+	// steps are faster but make the shadows of the volumes noisier. It's
+	// VolumeStep if it's 0.
+	ShadowStep float64 `json:"shadowstep,omitempty"`
+	// Seed selects the random numbers used while rendering. Rendering a
+	// scene twice with the same seed gives the same image.
+	Seed uint64 `json:"seed"`
+	// ColorSpace is the color space of the pixels of renders, Linear if
+	// it's empty
+	ColorSpace string `json:"colorspace,omitempty"`
+	// Exposure brightens the pixels of renders by this many stops, or
+	// darkens them if it's negative, before they're encoded. The radiance
+	// of EXR renders and of the AOVs is left as it is.
+	Exposure float64 `json:"exposure,omitempty"`
+	// HighlightClip is the fraction of the lit pixels that auto exposure
+	// may clip to white, DefaultHighlightClip if it's 0
+	HighlightClip float64 `json:"highlightclip,omitempty"`
+	// ShadowClip is the fraction of the lit pixels that auto exposure may
+	// leave too dark to tell from black, DefaultShadowClip if it's 0
+	ShadowClip float64 `json:"shadowclip,omitempty"`
+	// Integrator computes the light reaching the camera, Direct if it's
+	// empty
+	Integrator string `json:"integrator,omitempty"`
+	// Accelerator is the acceleration structure that finds the shapes rays
+	// hit, BVH if it's empty
+	Accelerator string `json:"accelerator,omitempty"`
+	// SplitBudget is how many more references to shapes than shapes the
+	// spatial splits of the SBVH accelerator may add, relative to their
+	// number, DefaultSplitBudget if it's 0
+	SplitBudget float64 `json:"splitbudget,omitempty"`
+	// Backfaces is how the back faces of surfaces are treated, Cull or
+	// TwoSided. If it's empty, the direct integrator shades them as seen
+	// from the front.
+	Backfaces string `json:"backfaces,omitempty"`
+	// RayOffset moves the lightrays leaving surfaces, towards the lights
+	// and as the light bounces, off them along their normals by this much,
+	// plus a little more the farther from the origin they leave, as
+	// math3d.OffsetOrigin does. It's for the shadow acne of the surfaces
+	// that lightrays hit right where they leave, such as the triangles of
+	// meshes next to the ones they leave. Lightrays only skip the surface
+	// they leave if it's 0, and 1e-4 is a good start.
+	RayOffset float64 `json:"rayoffset,omitempty"`
+	// MaxDepth is the most times light bounces on its way to the camera
+	// with the integrators that follow it around the scene
+	MaxDepth int `json:"maxdepth"`
+	// MinDepth is the fewest times that the light gathered by the fixed
+	// path integrator bounces. With MaxDepth, it selects the bounces to
+	// render, such as only the light bouncing twice.
+	MinDepth int `json:"mindepth,omitempty"`
+	// RouletteDepth is the bounces that the paths of the bidirectional
+	// integrator make before Russian roulette may end them
+	RouletteDepth int `json:"roulettedepth"`
+	// Photons is the number of photons traced from the lights to render
+	// caustics with the direct integrator, none if it's 0
+	Photons int `json:"photons"`
+	// PhotonRadius is the farthest from a point that the photons lighting
+	// it are gathered
+	PhotonRadius float64 `json:"photonradius"`
+	// ProbeExponent is the exponent of the glossy materials, the sharpest
+	// of them, whose reflections come from the reflection probes of the
+	// scene with the direct integrator, which is quick but only right
+	// near the probes and in rooms that fit their boxes, for draft renders
+	// of interiors. The glossy materials only reflect the lights if it's
+	// 0, or out of the boxes of the probes.
+	ProbeExponent float64 `json:"probeexponent,omitempty"`
+	// AORays is the number of rays that the ambient occlusion integrator
+	// traces from every point
+	AORays int `json:"aorays"`
+	// AODistance is the distance within which shapes occlude a point for
+	// the ambient occlusion integrator
+	AODistance float64 `json:"aodistance"`
+	// Stats counts the nodes of the acceleration structure visited and the
+	// intersection tests done while rendering, for the statistics of the
+	// scene. Counting them makes renders a little slower.
+	Stats bool `json:"stats,omitempty"`
+	// Report writes a JSON report of every render next to the image, for
+	// tools to read: its timings, the memory used, the rays traced, what
+	// every object cost and the settings. The cost of the objects is
+	// estimated by tracing a sparse grid of pixels again once the render
+	// is done.
+	Report bool `json:"report,omitempty"`
+	// MaxImageSize is the most samples along either side of the images
+	// that heightfields are read from. Larger ones are downscaled when the
+	// scene is rendered. There's no limit if it's 0.
+	MaxImageSize int `json:"maximagesize,omitempty"`
+	// ImageMemory is the most megabytes that the heightfields read from
+	// images may take. If they take more, they are all downscaled by the
+	// same factor until they fit. There's no limit if it's 0.
+	ImageMemory float64 `json:"imagememory,omitempty"`
+	// Detail is how many pixels apart the samples of the heightfields read
+	// from images must at least be, as the camera sees them where they
+	// are nearest to it. The heightfields whose samples are closer are
+	// downscaled, so distant terrains don't waste triangles smaller than
+	// the pixels. There's no limit if it's 0.
+	Detail float64 `json:"detail,omitempty"`
+	// Shutter is how many seconds the shutter stays open. Every sample sees
+	// the volumes and the triangles at a random time while it's open, so
+	// the ones that move are blurred. It's closed again at once if it's 0.
+	Shutter float64 `json:"shutter,omitempty"`
+	// IndirectClamp is the most luminance that the light bouncing more
+	// than once may bring to a sample with the bdpt and fixedpath
+	// integrators. Brighter light is dimmed to it, which removes the
+	// fireflies of rare bright paths at the cost of some of the light.
+	// There's no limit if it's 0.
+	IndirectClamp float64 `json:"indirectclamp,omitempty"`
+	// OutlierThreshold enables rejecting the samples of a pixel whose
+	// luminance is more than this many standard deviations above the mean
+	// of its samples, when it's positive. Renders that trace every pixel
+	// at once filter them; progressive ones, which add a sample to every
+	// pixel at a time, can't.
+	OutlierThreshold float64 `json:"outlierthreshold,omitempty"`
+	// Wavelengths enables spectral rendering when it's positive: every
+	// sample carries the light of this many wavelengths, a random hero
+	// one and the others evenly spaced across the visible spectrum from
+	// it, and the sensor turns them into colors. Dispersive materials,
+	// such as prisms, split the light by wavelength. Renders in RGB if
+	// it's 0.
+	Wavelengths int `json:"wavelengths,omitempty"`
+	// ToonBands is the number of shades of every color with the toon
+	// integrator, 3 if it's 0
+	ToonBands int `json:"toonbands,omitempty"`
+	// OutlineWidth is how many pixels wide the outlines that the toon
+	// integrator draws around the shapes are. There are none if it's 0,
+	// except with the hidden line integrator, whose lines are 1 pixel wide
+	// then.
+	OutlineWidth float64 `json:"outlinewidth,omitempty"`
+	// Transparent renders are transparent where the camera sees no shape,
+	// holdouts or the shadow catchers out of their shadows, so they can be
+	// composited over a photograph. Their pixels are black there, and the
+	// alpha of the others is the fraction of their samples that see other
+	// shapes. The lights the camera sees, such as the sky, are left out.
+	// Progressive renders are always opaque.
+	Transparent bool `json:"transparent,omitempty"`
+	// Crop is the crop window, the only part of the render traced, as
+	// "left,top,right,bottom": whole pixels, or fractions of the width and
+	// the height of the render if none is above 1. The rest of the render
+	// is black. The whole render is traced if it's empty, and progressive
+	// renders always trace it.
+	Crop string `json:"crop,omitempty"`
+	// CropFrame saves the renders with a crop window the size of the whole
+	// render, pasting the crop window over the previous render saved with
+	// the same name, instead of only the crop window
+	CropFrame bool `json:"cropframe,omitempty"`
+}
+
+// accelerator returns the acceleration structure of the settings
+func (s *Settings) accelerator() string {
+	if s.Accelerator == "" {
+		return BVH
+	}
+	return s.Accelerator
+}
+
+// DefaultSplitBudget is the split budget of the settings that don't set
+// theirs, which lets the spatial splits add up to about 30% more
+// references than shapes
+const DefaultSplitBudget = 0.3
+
+// splitBudget returns the split budget of the settings
+func (s *Settings) splitBudget() float64 {
+	if s.SplitBudget == 0 {
+		return DefaultSplitBudget
+	}
+	return s.SplitBudget
+}
+
+// toonBands returns the number of shades of the toon integrator
+func (s *Settings) toonBands() int {
+	if s.ToonBands == 0 {
+		return 3
+	}
+	return s.ToonBands
+}
+
+// outlineWidth returns the width of the outlines in pixels, which the
+// hidden line integrator always draws
+func (s *Settings) outlineWidth() float64 {
+	if s.Integrator == HiddenLine && s.OutlineWidth == 0 {
+		return 1
+	}
+	return s.OutlineWidth
+}
+
+// shadowStep returns the step of the rays towards the lights through the
+// volumes
+func (s *Settings) shadowStep() float64 {
+	if s.ShadowStep == 0 {
+		return s.VolumeStep
+	}
+	return s.ShadowStep
+}
+
+// Validate returns an error if the settings can't be used to render
+func (s *Settings) Validate() error {
+	switch {
+	case s.Samples < 1:
+		return errors.New("there must be at least 1 sample per pixel")
+	case s.Samples > 1<<16 || s.MinSamples > 1<<16:
+		return errors.New("too many samples per pixel")
+	case s.MinSamples < 0 || s.AdaptiveThreshold < 0:
+		return errors.New("the adaptive sampling settings can't be negative")
+	case !(s.VolumeStep > 0):
+		return errors.New("the volume step must be positive")
+	case !(s.ShadowStep >= 0) || math.IsInf(s.ShadowStep, 0):
+		return errors.New("the shadow step must be finite and non negative")
+	case s.ColorSpace != "" && s.ColorSpace != Linear && s.ColorSpace != SRGB && s.ColorSpace != Rec709 && s.ColorSpace != DisplayP3:
+		return errors.New("the color space must be linear, srgb, rec709 or displayp3")
+	case math.IsNaN(s.Exposure) || math.IsInf(s.Exposure, 0):
+		return errors.New("the exposure must be finite")
+	case !(s.HighlightClip >= 0 && s.HighlightClip < 1) || !(s.ShadowClip >= 0 && s.ShadowClip < 1):
+		return errors.New("the clipping budgets must be between 0 and 1")
+	case s.Integrator != "" && !builtinIntegrator(s.Integrator) && !RegisteredIntegrator(s.Integrator):
+		return errors.New("the integrator must be direct, bdpt, ao, fixedpath, toon, hiddenline or a registered one")
+	case s.Accelerator != "" && s.Accelerator != BVH && s.Accelerator != KDTree && s.Accelerator != TwoLevel && s.Accelerator != SBVH && s.Accelerator != GPU:
+		return errors.New("the accelerator must be bvh, kdtree, twolevel, sbvh or gpu")
+	case !(s.SplitBudget >= 0 && s.SplitBudget <= 4):
+		return errors.New("the split budget must be between 0 and 4")
+	case s.Backfaces != "" && s.Backfaces != Cull && s.Backfaces != TwoSided:
+		return errors.New("the backfaces must be cull or twosided")
+	case !(s.RayOffset >= 0) || s.RayOffset > 0.1:
+		return errors.New("the ray offset must be between 0 and 0.1")
+	case s.MaxDepth < 0 || s.MaxDepth > 1024:
+		return errors.New("the maximum depth must be between 0 and 1024")
+	case s.MinDepth < 0 || s.MinDepth > s.MaxDepth:
+		return errors.New("the minimum depth must be between 0 and the maximum depth")
+	case s.RouletteDepth < 0 || s.RouletteDepth > 1024:
+		return errors.New("the roulette depth must be between 0 and 1024")
+	case s.Photons < 0 || s.Photons > 1<<26:
+		return errors.New("the photons must be between 0 and 67108864")
+	case !(s.PhotonRadius > 0):
+		return errors.New("the photon radius must be positive")
+	case !(s.ProbeExponent >= 0) || math.IsInf(s.ProbeExponent, 0):
+		return errors.New("the probe exponent must be finite and non negative")
+	case s.AORays < 1 || s.AORays > 1024:
+		return errors.New("the ambient occlusion rays must be between 1 and 1024")
+	case !(s.AODistance > 0):
+		return errors.New("the ambient occlusion distance must be positive")
+	case s.MaxImageSize < 0 || s.MaxImageSize == 1:
+		return errors.New("the maximum image size must be 0 or at least 2")
+	case s.ImageMemory < 0:
+		return errors.New("the image memory can't be negative")
+	case !(s.Detail >= 0) || math.IsInf(s.Detail, 0):
+		return errors.New("the detail must be finite and non negative")
+	case !(s.Shutter >= 0) || math.IsInf(s.Shutter, 0):
+		return errors.New("the shutter time must be finite and non negative")
+	case !(s.IndirectClamp >= 0) || math.IsInf(s.IndirectClamp, 0):
+		return errors.New("the indirect clamp must be finite and non negative")
+	case !(s.OutlierThreshold >= 0) || math.IsInf(s.OutlierThreshold, 0):
+		return errors.New("the outlier threshold must be finite and non negative")
+	case s.Wavelengths < 0 || s.Wavelengths > 64:
+		return errors.New("the wavelengths must be between 0 and 64")
+	case s.ToonBands < 0 || s.ToonBands > 64:
+		return errors.New("the toon bands must be between 0 and 64")
+	case !(s.OutlineWidth >= 0) || s.OutlineWidth > 100:
+		return errors.New("the outline width must be between 0 and 100 pixels")
+	}
+	if s.Crop != "" {
+		if _, err := parseCrop(s.Crop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode returns the pixel of a render for the radiance, in the color
+// space of the settings. The radiance is linear, like every color until
+// it's encoded here.
+func (s *Settings) Encode(radiance *image.Color) stdcol.NRGBA {
+	if s.Exposure != 0 {
+		radiance = radiance.Multiply(math.Exp2(s.Exposure))
+	}
+	switch s.ColorSpace {
+	case SRGB:
+		return radiance.ToSRGB().ToNRGBA()
+	case Rec709:
+		return radiance.ToRec709().ToNRGBA()
+	case DisplayP3:
+		return radiance.ToDisplayP3().ToNRGBA()
+	}
+	return radiance.ToNRGBA()
+}
+
+// The clipping budgets of auto exposure when the settings don't set them
+const (
+	DefaultHighlightClip = 0.01
+	DefaultShadowClip    = 0.02
+)
+
+// ClippingBudgets returns the fractions of the lit pixels that auto
+// exposure may clip to white and leave too dark to tell from black
+func (s *Settings) ClippingBudgets() (highlights, shadows float64) {
+	highlights, shadows = s.HighlightClip, s.ShadowClip
+	if highlights == 0 {
+		highlights = DefaultHighlightClip
+	}
+	if shadows == 0 {
+		shadows = DefaultShadowClip
+	}
+	return highlights, shadows
+}
+
+// DefaultSettings returns the settings used when the scene file doesn't
+// have any
+func DefaultSettings() Settings {
+	return Settings{Samples: 1, MinSamples: 8, VolumeStep: 0.05, MaxDepth: 5, RouletteDepth: 3, PhotonRadius: 0.05,
+		AORays: 16, AODistance: 1}
+}
+
+// SettingsFromMap returns the settings defined in the map, using the
+// default ones for the missing values
+func SettingsFromMap(m map[string]interface{}) Settings {
+	settings := DefaultSettings()
+	if samples, ok := m["samples"].(float64); ok {
+		settings.Samples = int(samples)
+	}
+	if minSamples, ok := m["minsamples"].(float64); ok {
+		settings.MinSamples = int(minSamples)
+	}
+	if threshold, ok := m["adaptivethreshold"].(float64); ok {
+		settings.AdaptiveThreshold = threshold
+	}
+	if step, ok := m["volumestep"].(float64); ok {
+		settings.VolumeStep = step
+	}
+	if step, ok := m["shadowstep"].(float64); ok {
+		settings.ShadowStep = step
+	}
+	if seed, ok := m["seed"].(float64); ok {
+		settings.Seed = uint64(seed)
+	}
+	if colorSpace, ok := m["colorspace"].(string); ok {
+		settings.ColorSpace = colorSpace
+	}
+	if exposure, ok := m["exposure"].(float64); ok {
+		settings.Exposure = exposure
+	}
+	if clip, ok := m["highlightclip"].(float64); ok {
+		settings.HighlightClip = clip
+	}
+	if clip, ok := m["shadowclip"].(float64); ok {
+		settings.ShadowClip = clip
+	}
+	if integrator, ok := m["integrator"].(string); ok {
+		settings.Integrator = integrator
+	}
+	if accelerator, ok := m["accelerator"].(string); ok {
+		settings.Accelerator = accelerator
+	}
+	if budget, ok := m["splitbudget"].(float64); ok {
+		settings.SplitBudget = budget
+	}
+	if backfaces, ok := m["backfaces"].(string); ok {
+		settings.Backfaces = backfaces
+	}
+	if offset, ok := m["rayoffset"].(float64); ok {
+		settings.RayOffset = offset
+	}
+	if depth, ok := m["maxdepth"].(float64); ok {
+		settings.MaxDepth = int(depth)
+	}
+	if depth, ok := m["mindepth"].(float64); ok {
+		settings.MinDepth = int(depth)
+	}
+	if depth, ok := m["roulettedepth"].(float64); ok {
+		settings.RouletteDepth = int(depth)
+	}
+	if photons, ok := m["photons"].(float64); ok {
+		settings.Photons = int(photons)
+	}
+	if radius, ok := m["photonradius"].(float64); ok {
+		settings.PhotonRadius = radius
+	}
+	if exponent, ok := m["probeexponent"].(float64); ok {
+		settings.ProbeExponent = exponent
+	}
+	if rays, ok := m["aorays"].(float64); ok {
+		settings.AORays = int(rays)
+	}
+	if distance, ok := m["aodistance"].(float64); ok {
+		settings.AODistance = distance
+	}
+	if size, ok := m["maximagesize"].(float64); ok {
+		settings.MaxImageSize = int(size)
+	}
+	if memory, ok := m["imagememory"].(float64); ok {
+		settings.ImageMemory = memory
+	}
+	if detail, ok := m["detail"].(float64); ok {
+		settings.Detail = detail
+	}
+	if shutter, ok := m["shutter"].(float64); ok {
+		settings.Shutter = shutter
+	}
+	if clamp, ok := m["indirectclamp"].(float64); ok {
+		settings.IndirectClamp = clamp
+	}
+	if threshold, ok := m["outlierthreshold"].(float64); ok {
+		settings.OutlierThreshold = threshold
+	}
+	if wavelengths, ok := m["wavelengths"].(float64); ok {
+		settings.Wavelengths = int(wavelengths)
+	}
+	if bands, ok := m["toonbands"].(float64); ok {
+		settings.ToonBands = int(bands)
+	}
+	if width, ok := m["outlinewidth"].(float64); ok {
+		settings.OutlineWidth = width
+	}
+	if stats, ok := m["stats"].(bool); ok {
+		settings.Stats = stats
+	}
+	if report, ok := m["report"].(bool); ok {
+		settings.Report = report
+	}
+	if transparent, ok := m["transparent"].(bool); ok {
+		settings.Transparent = transparent
+	}
+	if crop, ok := m["crop"].(string); ok {
+		settings.Crop = crop
+	}
+	if frame, ok := m["cropframe"].(bool); ok {
+		settings.CropFrame = frame
+	}
+	return settings
+}
@@ -0,0 +1,77 @@
+package scene
+
+import (
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// specularOffset is how far from the surface the lightrays scattered by
+// specular materials leave, on the side they go towards. Refracted rays
+// go through the surface, so they can't leave it from the shadow origin.
+const specularOffset = 1e-5
+
+// scatterSpecular sets next to the lightray that carries on the path of lr
+// after the specular material scatters it at the hit, and returns the
+// weight of the light it brings back along lr. In spectral renders, the
+// first dispersive material the path goes through leaves only the light of
+// its hero wavelength.
+//
+// The differential of lr, if it has one, goes on with the lightray, kept
+// in differential, so that the textures seen in mirrors and through glass
+// are filtered too. The surface is taken as flat over the footprint of the
+// pixel, and refracted lightrays as spreading as much as the ones before.
+// next and differential may be lr and its differential, so that paths go
+// on in the same memory.
+func (s *Scene) scatterSpecular(mat material.Specular, hit *shape.Hit, lr *math3d.LightRay, rng *sampling.Rand, next *math3d.LightRay, differential *math3d.Differential) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	out := sc.vector(lr.Direction.MultiplyV(-1))
+	in, weight := mat.Scatter(&hit.Normal, out, lr.Wavelength, rng.Float64())
+	offset := hit.Normal.MultiplyV(specularOffset)
+	if in.DotV(hit.Normal) < 0 {
+		offset = offset.MultiplyV(-1)
+	}
+	scattered := math3d.LightRay{Source: hit.Point.AddV(offset), Direction: in, Time: lr.Time, Wavelength: lr.Wavelength, Dispersed: lr.Dispersed}
+	if lr.Wavelength > 0 && !lr.Dispersed && mat.Dispersive() {
+		scattered.Dispersed = true
+		hero := heroOnly(lr.Wavelength, s.Settings.Wavelengths)
+		weight = *weight.CMultiply(&hero)
+	}
+	if d := lr.Differential; d != nil {
+		spread := math3d.Differential{SourceX: hit.DPDX, SourceY: hit.DPDY, DirectionX: d.DirectionX, DirectionY: d.DirectionY}
+		if (in.DotV(hit.Normal) > 0) == (out.DotV(hit.Normal) > 0) {
+			// Mirrored like the direction
+			spread.DirectionX = reflect(d.DirectionX, hit.Normal)
+			spread.DirectionY = reflect(d.DirectionY, hit.Normal)
+		}
+		*differential = spread
+		scattered.Differential = differential
+	}
+	*next = scattered
+	return weight
+}
+
+// reflect returns the vector mirrored across the plane of the unit normal
+func reflect(v, normal math3d.Vector3) math3d.Vector3 {
+	return v.SubtractV(normal.MultiplyV(2 * v.DotV(normal)))
+}
+
+// heroOnly returns the weight that turns what the sensor records of the
+// light of the n wavelengths of the hero one into what it records of the
+// light of the hero wavelength alone
+func heroOnly(hero float64, n int) image.Color {
+	all := image.SpectralWeight(hero, n)
+	alone := image.WavelengthColor(hero)
+	ratio := func(a, b float64) float64 {
+		// The hero wavelength is one of the n, so it has none of a channel
+		// that none of them has
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	}
+	return image.Color{R: ratio(alone.R, all.R), G: ratio(alone.G, all.G), B: ratio(alone.B, all.B)}
+}
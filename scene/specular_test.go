@@ -0,0 +1,68 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// throughGlass returns a scene with a glass ball, a view ray through it
+// and a small light where the view ray leaves it with the wavelength
+func throughGlass(glass *material.Dielectric, nm float64) (*Scene, math3d.LightRay) {
+	s := New()
+	s.AddShape(&shape.Sphere{Radius: 1, Material: glass})
+	view := math3d.LightRay{Source: math3d.Vector3{Y: 0.6, Z: -5}, Direction: math3d.Vector3{Z: 1}}
+	ray := view
+	ray.Wavelength = nm
+	for i := 0; i < 2; i++ {
+		// Refracted into the ball and out of it again
+		distance, sh := s.getNearestIntersection(&ray)
+		hit := shape.HitAt(sh, &ray, distance)
+		out := ray.Direction.MultiplyV(-1)
+		in, _ := glass.Scatter(&hit.Normal, &out, nm, 0.999999)
+		ray = math3d.LightRay{Source: hit.Point.AddV(in.MultiplyV(1e-6)), Direction: in, Wavelength: nm}
+	}
+	s.AddLight(&lighting.SphereLight{Position: ray.Source.AddV(ray.Direction.MultiplyV(20)), Radius: 0.15, Radiance: image.White})
+	return s, view
+}
+
+// meanColor returns the mean of the samples of the camera ray
+func meanColor(s *Scene, view math3d.LightRay, samples int) image.Color {
+	rng := sampling.New(1, 0)
+	sum := image.Color{}
+	for i := 0; i < samples; i++ {
+		lr := view
+		c := s.traceRay(&lr, rng, &builtinIntegrators{})
+		sum = *sum.Add(&c)
+	}
+	return *sum.Divide(float64(samples))
+}
+
+func TestSpectralRendersMatchRGBWithoutDispersion(t *testing.T) {
+	s, view := throughGlass(&material.Dielectric{Albedo: image.White, IOR: 1.5}, 0)
+	rgb := meanColor(s, view, 4000)
+	s.Settings.Wavelengths = 8
+	spectral := meanColor(s, view, 4000)
+	if rgb.R < 0.8 || math.Abs(spectral.R-rgb.R) > 0.05 || math.Abs(spectral.G-rgb.G) > 0.05 || math.Abs(spectral.B-rgb.B) > 0.05 {
+		t.Errorf("The light seen through the glass should be the same in spectral renders, it's %s and %s in RGB",
+			spectral.String(), rgb.String())
+	}
+}
+
+func TestDispersionSplitsWhiteLight(t *testing.T) {
+	prism := &material.Dielectric{Albedo: image.White, IOR: 1.5, Abbe: 10}
+	s, view := throughGlass(prism, 450)
+	if c := meanColor(s, view, 4000); c != image.Black {
+		t.Errorf("Without dispersion, the light where only blue light goes should be missed, it's %s", c.String())
+	}
+	s.Settings.Wavelengths = 8
+	if c := meanColor(s, view, 4000); !(c.B > 0.01 && c.B > 2*c.R && c.B > 2*c.G) {
+		t.Errorf("Through a dispersive ball, only the blue light should reach the light, it's %s", c.String())
+	}
+}
@@ -0,0 +1,80 @@
+package scene
+
+import (
+	stdimg "image"
+	"sync/atomic"
+
+	"github.com/ProjectMOA/goraytrace/accel"
+)
+
+// Statistics counts the work done tracing a scene
+type Statistics struct {
+	// Samples is the number of samples taken, each of which traces a
+	// primary ray from the camera
+	Samples     uint64 `json:"samples"`
+	PrimaryRays uint64 `json:"primaryrays"`
+	// Rays is the number of rays traced, primary, shadow and bounced rays
+	// alike, of which ShadowRays only look for something between a point
+	// and a light
+	Rays       uint64 `json:"rays"`
+	ShadowRays uint64 `json:"shadowrays"`
+	// NodeVisits is the number of nodes of the acceleration structure
+	// visited and IntersectionTests the number of tests against shapes done
+	// by the rays. They are only counted while Settings.Stats is set.
+	NodeVisits        uint64 `json:"nodevisits"`
+	IntersectionTests uint64 `json:"intersectiontests"`
+}
+
+// Statistics returns the work done tracing the scene since it was
+// created. It can be called while the scene is traced.
+func (s *Scene) Statistics() Statistics {
+	samples := atomic.LoadUint64(&s.samples)
+	return Statistics{
+		Samples:           samples,
+		PrimaryRays:       samples,
+		Rays:              atomic.LoadUint64(&s.rays),
+		ShadowRays:        atomic.LoadUint64(&s.shadowRays),
+		NodeVisits:        atomic.LoadUint64(&s.traversal.Nodes),
+		IntersectionTests: atomic.LoadUint64(&s.traversal.Tests)}
+}
+
+// Since returns the work counted in st since before was
+func (st Statistics) Since(before Statistics) Statistics {
+	return Statistics{
+		Samples:           st.Samples - before.Samples,
+		PrimaryRays:       st.PrimaryRays - before.PrimaryRays,
+		Rays:              st.Rays - before.Rays,
+		ShadowRays:        st.ShadowRays - before.ShadowRays,
+		NodeVisits:        st.NodeVisits - before.NodeVisits,
+		IntersectionTests: st.IntersectionTests - before.IntersectionTests}
+}
+
+// TraceCost returns the work of the acceleration structure tracing every
+// pixel of a width x height render: the nodes visited and the shapes
+// tested by all the rays of its samples, pixel after pixel and row after
+// row from the top, where the heavy parts of the scene show. Only the
+// pixels in the region are traced, and the rest cost 0. The work is
+// counted for the whole structure, so the pixels are traced one after the
+// other, and the scene mustn't be traced meanwhile nor be a view of a
+// prepared scene, which shares its structure.
+func (s *Scene) TraceCost(width, height int, region stdimg.Rectangle) []uint64 {
+	if s.shared {
+		panic("The cost of the views of a prepared scene can't be counted")
+	}
+	s.Prepare()
+	var counted accel.Counters
+	structure := s.accelerator()
+	structure.Count(&counted)
+	defer structure.Count(s.counters())
+	targetIt := s.Camera.GetIterator(width, height)
+	cost := make([]uint64, width*height)
+	region = region.Intersect(stdimg.Rect(0, 0, width, height))
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			before := counted.Nodes + counted.Tests
+			s.TracePixel(targetIt, x, y)
+			cost[y*width+x] = counted.Nodes + counted.Tests - before
+		}
+	}
+	return cost
+}
@@ -0,0 +1,61 @@
+package scene
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// subsurfaceProbes is the number of points where light may enter the
+// surface that are sampled for every point where it leaves it
+const subsurfaceProbes = 16
+
+// probeClearance is how far above the surface the probe rays start, so
+// they don't start too close to the point they should find
+const probeClearance = 1e-3
+
+// subsurfaceRadiance returns the radiance that leaves the shape at point
+// after entering it at nearby points. Those are sampled on the plane
+// tangent to the surface, at distances that follow the diffusion profile
+// of the material, and projected onto the shape along the normal. time is
+// when the light arrives.
+func (s *Scene) subsurfaceRadiance(point, normal *math3d.Vector3, sh shape.Shape, mat *material.Subsurface, time float64, rng *sampling.Rand) image.Color {
+	tangent, bitangent := math3d.OrthonormalBasis(*normal)
+	radiance := image.Color{}
+	sc := getScratch()
+	defer sc.release()
+	probe, entry, entryNormal := sc.ray(math3d.LightRay{}), sc.vector(math3d.Vector3{}), sc.vector(math3d.Vector3{})
+	for i := 0; i < subsurfaceProbes; i++ {
+		r := mat.SampleRadius(rng.Float64(), rng.Float64())
+		phi := 2 * math.Pi * rng.Float64()
+		offset := tangent.MultiplyV(r * math.Cos(phi)).AddV(bitangent.MultiplyV(r * math.Sin(phi)))
+		// Starting as high above the plane as the distance covers the
+		// surface curving away from it by up to 45 degrees
+		*probe = math3d.LightRay{
+			Source:    point.AddV(offset).AddV(normal.MultiplyV(r + probeClearance)),
+			Direction: normal.MultiplyV(-1),
+			Time:      time}
+		distance := sh.Intersect(probe)
+		if distance == math.MaxFloat64 {
+			continue
+		}
+		*entry = probe.Source.AddV(probe.Direction.MultiplyV(distance))
+		*entryNormal = sh.NormalAt(entry).NormalizedV()
+		*entry = shape.ShadowOrigin(sh, entry)
+		irradiance := image.Color{}
+		s.forLights(entry, entryNormal, rng, func(ls lighting.Light, weight float64) {
+			if _, arriving, ok := s.lightArriving(sh, entry, entryNormal, ls, time, rng); ok {
+				irradiance = *irradiance.Add(arriving.Multiply(weight))
+			}
+		})
+		weight := mat.Diffusion(r)
+		radiance = *radiance.Add(irradiance.CMultiply(weight.Divide(mat.Pdf(r))))
+	}
+	// The light leaves the surface the same towards every direction
+	return *radiance.Divide(subsurfaceProbes * math.Pi)
+}
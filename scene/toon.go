@@ -0,0 +1,124 @@
+package scene
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// ToonIntegrator is the non-photorealistic integrator of illustrations and
+// cartoons. Surfaces are flat areas of the albedo of their material, in a
+// few bands of brightness from the darkest, where they are in shadow, to
+// the albedo itself, where the lights give them as much light as a white
+// surface facing a light of radiance 1 would reflect. The scene draws the
+// outlines around them, where the shapes seen by neighbouring pixels are
+// apart or turn sharply.
+type ToonIntegrator struct {
+	// Bands is the number of shades of every color
+	Bands int
+}
+
+// Radiance returns the shade of the surface that lr sees, or the radiance
+// of the light it sees
+func (ti *ToonIntegrator) Radiance(s *Scene, lr *math3d.LightRay, rng *sampling.Rand) image.Color {
+	distance, sh := s.getNearestIntersection(lr)
+	if lightDistance, emitted := s.nearestLight(lr); lightDistance < distance {
+		return emitted
+	}
+	if sh == nil {
+		return image.Black
+	}
+	sc := getScratch()
+	defer sc.release()
+	hit := sc.hit(shape.HitAt(sh, lr, distance))
+	normal, origin := sc.vector(hit.Normal), sc.vector(shape.ShadowOrigin(sh, &hit.Point))
+	if hit.Backface {
+		*normal, *origin = normal.MultiplyV(-1), hit.Point
+	}
+	lit := 0.0
+	s.forLights(origin, normal, rng, func(ls lighting.Light, weight float64) {
+		if _, irradiance, ok := s.lightArriving(sh, origin, normal, ls, lr.Time, rng); ok {
+			lit += weight * irradiance.Luminance() / math.Pi
+		}
+	})
+	c := albedo(shape.MaterialAtHit(sh, hit, sc.vector(lr.Direction.MultiplyV(-1))))
+	return *c.Multiply(ti.shade(lit))
+}
+
+// shade returns the brightness of the band of a surface that reflects lit
+// as a white one would, from 1/Bands to 1
+func (ti *ToonIntegrator) shade(lit float64) float64 {
+	bands := float64(ti.Bands)
+	return math.Min(bands, math.Floor(lit*bands)+1) / bands
+}
+
+// albedo returns the color of the material, white for the ones that don't
+// have any
+func albedo(mat material.Material) image.Color {
+	switch mat := mat.(type) {
+	case *material.Lambertian:
+		return mat.Albedo
+	case *material.Glossy:
+		return mat.Albedo
+	case *material.Subsurface:
+		return mat.Albedo
+	case *material.Dielectric:
+		return mat.Albedo
+	case *material.Standard:
+		return mat.Albedo
+	}
+	return image.White
+}
+
+// creaseCosine is the cosine of the sharpest angle between the normals of
+// neighbouring points of a surface that isn't outlined
+var creaseCosine = math.Cos(math.Pi / 6)
+
+// outlineDepth is how much farther or nearer than the surface it sees a
+// neighbouring pixel must see another one, relative to its distance, for
+// an outline to be drawn between them
+const outlineDepth = 0.02
+
+// onOutline returns whether the point u, v of the pixel x, y, which lr goes
+// through, is on an outline of the toon and hidden line integrators:
+// whether the points half the width of the outlines above, below, left and
+// right of it see a different surface, the background, or a part of the
+// same surface that turns by more than a crease. Faraway shapes and the
+// background are told apart from the surface by the distance their
+// lightrays travel past the plane tangent to it.
+func (s *Scene) onOutline(targetIt *camera.TracingTargetIterator, x, y int, u, v, width float64, lr *math3d.LightRay) bool {
+	distance, sh := s.getNearestIntersection(lr)
+	var hit shape.Hit
+	if sh != nil {
+		hit = shape.HitAt(sh, lr, distance)
+	}
+	offset := width / 2
+	for _, d := range [][2]float64{{offset, 0}, {-offset, 0}, {0, offset}, {0, -offset}} {
+		probe := targetIt.Ray(x, y, u+d[0], v+d[1])
+		probeDistance, probeShape := s.getNearestIntersection(&probe)
+		if sh == nil || probeShape == nil {
+			if sh != probeShape {
+				return true
+			}
+			continue
+		}
+		probeHit := shape.HitAt(probeShape, &probe, probeDistance)
+		if hit.Normal.DotV(probeHit.Normal) < creaseCosine {
+			return true
+		}
+		// The distance at which the probe would meet the tangent plane
+		toPlane := hit.Point.SubtractV(probe.Source).DotV(hit.Normal)
+		if along := probe.Direction.DotV(hit.Normal); along != 0 {
+			if expected := toPlane / along; math.Abs(probeDistance-expected) > outlineDepth*distance {
+				return true
+			}
+		}
+	}
+	return false
+}
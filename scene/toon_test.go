@@ -0,0 +1,72 @@
+package scene
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+)
+
+func TestToonShadingHasFlatBands(t *testing.T) {
+	toon := &ToonIntegrator{Bands: 4}
+	for _, test := range []struct{ lit, shade float64 }{{0, 0.25}, {0.2, 0.25}, {0.5, 0.75}, {0.99, 1}, {3, 1}} {
+		if shade := toon.shade(test.lit); shade != test.shade {
+			t.Errorf("A surface lit with %g should have a shade of %g, not %g", test.lit, test.shade, shade)
+		}
+	}
+
+	render := `"render": {"integrator": "toon", "toonbands": 4, "outlinewidth": 2}, "lights"`
+	s, _, err := ParseScene([]byte(strings.Replace(validScene, `"lights"`, render, 1)), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 32
+	img := s.TraceScene(size, size)
+	shades := make(map[uint8]bool)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			shades[img.NRGBAAt(x, y).R] = true
+		}
+	}
+	if len(shades) < 3 || len(shades) > 5 {
+		t.Errorf("The sphere should have 4 bands and black outlines, it has %d shades", len(shades))
+	}
+}
+
+func TestToonOutlinesTheShapes(t *testing.T) {
+	render := `"render": {"integrator": "toon", "outlinewidth": 2}, "lights"`
+	s, _, err := ParseScene([]byte(strings.Replace(validScene, `"lights"`, render, 1)), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 32
+	targetIt := s.Camera.GetIterator(size, size)
+	// The last pixel of the middle row that sees the sphere is on its
+	// silhouette
+	edge := -1
+	for x := size / 2; x < size; x++ {
+		lr := targetIt.Ray(x, size/2, 0.5, 0.5)
+		if _, sh := s.getNearestIntersection(&lr); sh != nil {
+			edge = x
+		}
+	}
+	if edge < 0 || edge == size-1 {
+		t.Fatalf("The sphere should end inside the row, it ends at %d", edge)
+	}
+	center := targetIt.Ray(size/2, size/2, 0.5, 0.5)
+	if s.onOutline(targetIt, size/2, size/2, 0.5, 0.5, 2, &center) {
+		t.Error("The middle of the sphere shouldn't be outlined")
+	}
+	lr := targetIt.Ray(edge, size/2, 0.5, 0.5)
+	if !s.onOutline(targetIt, edge, size/2, 0.5, 0.5, 2, &lr) {
+		t.Error("The silhouette of the sphere should be outlined")
+	}
+	if c := s.TracePixel(targetIt, edge, size/2); c != image.Black {
+		t.Errorf("The outline should be black, not %s", c.String())
+	}
+	s.Settings.OutlineWidth = 0
+	if c := s.TracePixel(targetIt, edge, size/2); c == image.Black || math.IsNaN(c.R) {
+		t.Errorf("Without outlines the silhouette should be shaded, not %s", c.String())
+	}
+}
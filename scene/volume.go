@@ -0,0 +1,200 @@
+package scene
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/sampling"
+)
+
+// throughMedium returns the radiance that reaches the source of lr when
+// radiance leaves the point at distance along it. The medium absorbs part
+// of it on the way, and scatters towards the source some of the light
+// that reaches the medium directly from the lights (single scattering).
+func (s *Scene) throughMedium(lr *math3d.LightRay, distance float64, radiance image.Color, rng *sampling.Rand) image.Color {
+	transmittance := s.Medium.Transmittance(distance)
+	result := *radiance.CMultiply(&transmittance)
+
+	// Past the shapes and the lights there's nothing to scatter, so rays
+	// that leave the scene stop being marched where they leave it. The
+	// bounds of distant lights are empty.
+	bounds := s.accelerator().Bounds()
+	for _, ls := range s.Lights {
+		bounds = bounds.Union(ls.Bounds())
+	}
+	_, exit := bounds.IntersectRange(lr)
+	end := math.Min(distance, exit)
+	if end == math.MaxFloat64 {
+		return result
+	}
+
+	// The light of small lights is scattered mostly near them, falling off
+	// with the square of the distance, so the points where it's gathered
+	// are sampled both equi-angularly from every light and evenly along
+	// the ray, and both are weighted with the power heuristic: the first
+	// makes light shafts converge and the second handles the point
+	// lights, which don't fall off. Half as many of each as marching every
+	// volume step would take, stratified, keep the cost the same.
+	samples := int(math.Ceil(end / s.Settings.VolumeStep / 2))
+	sc := getScratch()
+	defer sc.release()
+	middle, point := sc.vector(lr.Source.AddV(lr.Direction.MultiplyV(end/2))), sc.vector(math3d.Vector3{})
+	s.forLights(middle, nil, rng, func(ls lighting.Light, weight float64) {
+		center := ls.Bounds().Centroid()
+		along := center.SubtractV(lr.Source).DotV(lr.Direction)
+		height := math3d.Distance(center, lr.Source.Add(lr.Direction.Multiply(along)))
+		gather := func(t, pdf, otherPdf float64) {
+			*point = lr.Source.AddV(lr.Direction.MultiplyV(t))
+			inscattered := s.inscatteredFrom(ls, point, &lr.Direction, lr.Time, rng)
+			toSource := s.Medium.Transmittance(t)
+			w := weight * sampling.PowerHeuristic(pdf, otherPdf) / (pdf * float64(samples))
+			result = *result.Add(inscattered.CMultiply(&toSource).Multiply(w))
+		}
+		if _, distant := ls.(lighting.Distant); distant {
+			// The light of distant lights doesn't fall off, so it's only
+			// gathered evenly along the ray
+			for i := 0; i < samples; i++ {
+				gather(end*(float64(i)+rng.Float64())/float64(samples), 1/end, 0)
+			}
+			return
+		}
+		for i := 0; i < samples; i++ {
+			u := (float64(i) + rng.Float64()) / float64(samples)
+			if t, pdf := sampling.EquiAngular(along, height, 0, end, u); pdf > 0 {
+				gather(t, pdf, 1/end)
+			}
+			t := end * (float64(i) + rng.Float64()) / float64(samples)
+			gather(t, 1/end, sampling.EquiAngularPdf(along, height, 0, end, t))
+		}
+	})
+	return result
+}
+
+// inscatteredFrom returns the radiance scattered at point towards the
+// opposite of direction by the light that reaches it from the light ls at
+// the time
+func (s *Scene) inscatteredFrom(ls lighting.Light, point, direction *math3d.Vector3, time float64, rng *sampling.Rand) image.Color {
+	sample := ls.Sample(point, rng.Float64(), rng.Float64())
+	if sample.Pdf == 0 {
+		return image.Color{}
+	}
+	sc := getScratch()
+	defer sc.release()
+	shadowRay := sc.ray(math3d.LightRay{Direction: sample.Direction, Source: *point, Time: time})
+	if s.inShadow(shadowRay, sample.Distance) {
+		return image.Color{}
+	}
+	transmittance := s.Medium.Transmittance(sample.Distance)
+	phase := s.Medium.Phase(direction.DotV(shadowRay.Direction))
+	return *sample.Radiance.CMultiply(&transmittance).Multiply(phase / sample.Pdf).CMultiply(&s.Medium.Scattering)
+}
+
+// throughVolumes returns the radiance that reaches the source of lr when
+// radiance leaves the point at distance along it, going through the
+// volumes as they are at the time of lr. Like throughMedium, it gathers
+// the light they scatter once towards the source, and it adds the light
+// the glowing ones emit. That light is only seen directly: fire doesn't
+// light the shapes or the rest of the volumes.
+func (s *Scene) throughVolumes(lr *math3d.LightRay, distance float64, radiance image.Color, rng *sampling.Rand) image.Color {
+	bounds := math3d.EmptyAABB()
+	for _, v := range s.Volumes {
+		bounds = bounds.Union(v.Bounds(lr.Time))
+	}
+	enter, exit := bounds.IntersectRange(lr)
+	if enter == math.MaxFloat64 {
+		return radiance
+	}
+	// The volumes may overlap, so they are marched together, adding up
+	// their extinctions at every point
+	step := s.Settings.VolumeStep
+	depth := image.Color{}
+	scattered := image.Color{}
+	sc := getScratch()
+	defer sc.release()
+	sc.densities = floats(sc.densities, len(s.Volumes))
+	densities, point := sc.densities, sc.vector(math3d.Vector3{})
+	for t := enter + step*rng.Float64(); t < math.Min(exit, distance); t += step {
+		*point = lr.Source.AddV(lr.Direction.MultiplyV(t))
+		extinction := image.Color{}
+		emitted := image.Color{}
+		dense := false
+		for i, v := range s.Volumes {
+			densities[i] = v.DensityAt(point, lr.Time)
+			extinction = *extinction.Add(v.Extinction().Multiply(densities[i]))
+			dense = dense || densities[i] > 0
+			if v.Glows() {
+				emission := v.EmissionAt(point, lr.Time)
+				emitted = *emitted.Add(&emission)
+			}
+		}
+		if !dense && emitted == image.Black {
+			continue
+		}
+		toSource := exponential(&depth)
+		if s.Medium != nil {
+			toMedium := s.Medium.Transmittance(t)
+			toSource = *toSource.CMultiply(&toMedium)
+		}
+		if dense {
+			inscattered := s.inscatteredInVolumes(point, lr, densities, rng)
+			emitted = *emitted.Add(&inscattered)
+		}
+		scattered = *scattered.Add(emitted.CMultiply(&toSource).Multiply(step))
+		depth = *depth.Add(extinction.Multiply(step))
+	}
+	transmittance := exponential(&depth)
+	return *radiance.CMultiply(&transmittance).Add(&scattered)
+}
+
+// inscatteredInVolumes returns the radiance scattered at point towards the
+// source of lr by the light that reaches it from the lights, where the
+// volumes have the densities
+func (s *Scene) inscatteredInVolumes(point *math3d.Vector3, lr *math3d.LightRay, densities []float64, rng *sampling.Rand) image.Color {
+	sc := getScratch()
+	defer sc.release()
+	radiance := image.Color{}
+	shadowRay := sc.ray(math3d.LightRay{Source: *point, Time: lr.Time})
+	s.forLights(point, nil, rng, func(ls lighting.Light, weight float64) {
+		sample := ls.Sample(point, rng.Float64(), rng.Float64())
+		if sample.Pdf == 0 {
+			return
+		}
+		shadowRay.Direction = sample.Direction
+		if s.inShadow(shadowRay, sample.Distance) {
+			return
+		}
+		arriving := *sample.Radiance.Multiply(weight / sample.Pdf)
+		transmittance := s.volumeTransmittance(shadowRay, sample.Distance, rng)
+		arriving = *arriving.CMultiply(&transmittance)
+		if s.Medium != nil {
+			transmittance := s.Medium.Transmittance(sample.Distance)
+			arriving = *arriving.CMultiply(&transmittance)
+		}
+		cosine := lr.Direction.DotV(shadowRay.Direction)
+		for i, v := range s.Volumes {
+			if densities[i] > 0 {
+				radiance = *radiance.Add(arriving.CMultiply(&v.Scattering).Multiply(densities[i] * v.Phase(cosine)))
+			}
+		}
+	})
+	return radiance
+}
+
+// volumeTransmittance returns the fraction of light that goes through the
+// volumes along lr up to distance, at the time of lr. The lightrays
+// it's used for go towards the lights, so it marches with the shadow step.
+func (s *Scene) volumeTransmittance(lr *math3d.LightRay, distance float64, rng *sampling.Rand) image.Color {
+	transmittance := image.White
+	for _, v := range s.Volumes {
+		through := v.Transmittance(lr, distance, lr.Time, s.Settings.shadowStep(), rng.Float64())
+		transmittance = *transmittance.CMultiply(&through)
+	}
+	return transmittance
+}
+
+// exponential returns e to the minus the optical depth in every channel
+func exponential(depth *image.Color) image.Color {
+	return image.Color{R: math.Exp(-depth.R), G: math.Exp(-depth.G), B: math.Exp(-depth.B)}
+}
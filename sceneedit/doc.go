@@ -0,0 +1,29 @@
+/*
+Package sceneedit serves the SceneEditor service of proto/sceneedit.proto
+over HTTP, so that an external editor, such as a Blender add-on, keeps a
+live scene in sync as its user edits and watches the render change.
+
+The editor sends batches of mutations, each of which maps to one of the
+editing methods of scene.Scene, and the render is traced again only where
+the mutations may have changed it. Every message is in the protobuf wire
+format, with the Content-Type application/x-protobuf.
+
+The server answers:
+
+	POST /apply   the body is a MutationBatch, whose mutations are applied
+	              in order. The answer is a MutationResult with an error
+	              for every mutation, empty if it was applied. The
+	              mutations that fail leave the scene as it was.
+	POST /watch   the body is a RenderRequest. The answer streams the
+	              RegionUpdate of the whole frame of that size first, and
+	              then one of the region that changed after every batch,
+	              until the client goes away. Every update is preceded by
+	              its length as a varint, as protobuf streams are
+	              delimited, and ReadUpdate reads them. The regions of the
+	              batches applied while an update is sent are merged in
+	              the next one.
+
+Editors reached from beyond localhost should be served behind
+auth.Require, over TLS.
+*/
+package sceneedit
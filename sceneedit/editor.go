@@ -0,0 +1,285 @@
+package sceneedit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	stdimg "image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/proto/sceneeditpb"
+	"github.com/ProjectMOA/goraytrace/proto/scenepb"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// protobufType is the media type of the messages
+const protobufType = "application/x-protobuf"
+
+// maxBatch limits the size of the batches clients can send, which may hold
+// whole scenes
+const maxBatch = 64 << 20
+
+// maxPixels limits the size of the frames clients can watch
+const maxPixels = 1 << 26
+
+// Editor holds a live scene that clients change with batches of mutations,
+// and the renders of it they watch.
+type Editor struct {
+	mu       sync.Mutex
+	scene    *scene.Scene
+	watchers map[*watcher]bool
+}
+
+// watcher is a render of the scene a client watches
+type watcher struct {
+	frame *image.Image
+	// pending is the region of the frame traced again since the client
+	// was last sent it, and changed is signalled when it grows
+	pending stdimg.Rectangle
+	changed chan struct{}
+}
+
+// NewEditor returns an editor of the scene, which mustn't be changed but
+// through the editor from then on
+func NewEditor(s *scene.Scene) *Editor {
+	// Every render watched starts with the whole frame, so only the
+	// changes from then on are traced again
+	s.MarkTraced()
+	return &Editor{scene: s, watchers: make(map[*watcher]bool)}
+}
+
+// Apply applies the mutations of the batch to the scene in order, and
+// traces again the regions of the renders watched that they changed. The
+// result has the error of every mutation, empty if it was applied.
+func (e *Editor) Apply(batch *sceneeditpb.MutationBatch) *sceneeditpb.MutationResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result := &sceneeditpb.MutationResult{Errors: make([]string, len(batch.GetMutations()))}
+	loaded := false
+	for i, m := range batch.GetMutations() {
+		if err := protect(func() error { return e.apply(m, &loaded) }); err != nil {
+			result.Errors[i] = err.Error()
+		}
+	}
+	for w := range e.watchers {
+		region := w.frame.Bounds()
+		if !loaded {
+			region = e.scene.DirtyRegion(region.Dx(), region.Dy())
+		}
+		e.trace(w, region)
+	}
+	e.scene.MarkTraced()
+	return result
+}
+
+// apply applies the mutation to the scene, setting loaded if it replaced
+// the scene
+func (e *Editor) apply(m *sceneeditpb.Mutation, loaded *bool) error {
+	s := e.scene
+	switch v := m.GetMutation().(type) {
+	case *sceneeditpb.Mutation_LoadScene:
+		loadedScene, _, err := scene.ParseScene(v.LoadScene.GetSceneJson(), scene.Lenient)
+		if err != nil {
+			return err
+		}
+		e.scene, *loaded = loadedScene, true
+	case *sceneeditpb.Mutation_AddShape:
+		sh, err := scene.ShapeFromProto(v.AddShape.GetShape())
+		if err != nil {
+			return err
+		}
+		s.AddShape(sh)
+	case *sceneeditpb.Mutation_RemoveShape:
+		index := int(v.RemoveShape.GetIndex())
+		if index < 0 || index >= len(s.Shapes) {
+			return fmt.Errorf("there's no shape %d", index)
+		}
+		s.RemoveShape(index)
+	case *sceneeditpb.Mutation_MoveShape:
+		index := int(v.MoveShape.GetIndex())
+		if index < 0 || index >= len(s.Shapes) {
+			return fmt.Errorf("there's no shape %d", index)
+		}
+		s.MoveShape(index, offset(v.MoveShape.GetOffset()))
+	case *sceneeditpb.Mutation_AddLight:
+		light, err := scene.LightFromProto(&scenepb.Light{Light: &scenepb.Light_Point{Point: v.AddLight.GetLight()}})
+		if err != nil {
+			return err
+		}
+		s.AddLight(light)
+	case *sceneeditpb.Mutation_RemoveLight:
+		index := int(v.RemoveLight.GetIndex())
+		if index < 0 || index >= len(s.Lights) {
+			return fmt.Errorf("there's no light %d", index)
+		}
+		s.RemoveLight(index)
+	case *sceneeditpb.Mutation_MoveLight:
+		index := int(v.MoveLight.GetIndex())
+		if index < 0 || index >= len(s.Lights) {
+			return fmt.Errorf("there's no light %d", index)
+		}
+		s.MoveLight(index, offset(v.MoveLight.GetOffset()))
+	case *sceneeditpb.Mutation_SetCamera:
+		c, err := scene.CameraFromProto(v.SetCamera.GetCamera())
+		if err != nil {
+			return err
+		}
+		s.SetCamera(c)
+	default:
+		return errors.New("the mutation is empty")
+	}
+	return nil
+}
+
+// offset returns the vector of the message, zero if it's missing
+func offset(v *scenepb.Vector3) *math3d.Vector3 {
+	return &math3d.Vector3{X: v.GetX(), Y: v.GetY(), Z: v.GetZ()}
+}
+
+// trace traces the region of the render of the watcher again, and signals
+// the watcher that it changed. It must be called with the lock held.
+func (e *Editor) trace(w *watcher, region stdimg.Rectangle) {
+	region = region.Intersect(w.frame.Bounds())
+	if region.Empty() {
+		return
+	}
+	e.scene.TraceRegion(w.frame, region)
+	w.pending = w.pending.Union(region)
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// ServeHTTP serves the requests of the clients
+func (e *Editor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/apply" && r.Method == http.MethodPost:
+		var batch sceneeditpb.MutationBatch
+		if !readMessage(w, r, maxBatch, &batch) {
+			return
+		}
+		w.Header().Set("Content-Type", protobufType)
+		w.Write(e.Apply(&batch).Marshal())
+	case r.URL.Path == "/watch" && r.Method == http.MethodPost:
+		var request sceneeditpb.RenderRequest
+		if !readMessage(w, r, 1<<10, &request) {
+			return
+		}
+		width, height := int64(request.GetWidth()), int64(request.GetHeight())
+		if width <= 0 || height <= 0 || width*height > maxPixels {
+			http.Error(w, fmt.Sprintf("invalid frame size %dx%d", width, height), http.StatusBadRequest)
+			return
+		}
+		e.serveWatch(w, r, int(width), int(height))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// readMessage decodes the body of the request, of limit bytes at most,
+// into m, and answers the request with the error if it can't
+func readMessage(w http.ResponseWriter, r *http.Request, limit int64, m interface{ Unmarshal([]byte) error }) bool {
+	if r.Header.Get("Content-Type") != protobufType {
+		http.Error(w, "the body must be "+protobufType, http.StatusUnsupportedMediaType)
+		return false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err == nil && int64(len(body)) > limit {
+		err = fmt.Errorf("the message is bigger than %d bytes", limit)
+	}
+	if err == nil {
+		err = m.Unmarshal(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// serveWatch streams the updates of a width x height render of the scene
+// to the client until it goes away
+func (e *Editor) serveWatch(rw http.ResponseWriter, r *http.Request, width, height int) {
+	w := &watcher{frame: image.New(width, height), changed: make(chan struct{}, 1)}
+	e.mu.Lock()
+	if err := protect(func() error { e.trace(w, w.frame.Bounds()); return nil }); err != nil {
+		e.mu.Unlock()
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.watchers[w] = true
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.watchers, w)
+		e.mu.Unlock()
+	}()
+
+	rw.Header().Set("Content-Type", protobufType)
+	flusher, _ := rw.(http.Flusher)
+	for {
+		select {
+		case <-w.changed:
+		case <-r.Context().Done():
+			return
+		}
+		e.mu.Lock()
+		update := regionUpdate(w.frame, w.pending)
+		w.pending = stdimg.Rectangle{}
+		e.mu.Unlock()
+		message := update.Marshal()
+		if _, err := rw.Write(append(binary.AppendUvarint(nil, uint64(len(message))), message...)); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// regionUpdate returns the update of the region of the frame
+func regionUpdate(frame *image.Image, region stdimg.Rectangle) *sceneeditpb.RegionUpdate {
+	pixels := make([]byte, 0, 4*region.Dx()*region.Dy())
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		start := frame.PixOffset(region.Min.X, y)
+		pixels = append(pixels, frame.Pix[start:start+4*region.Dx()]...)
+	}
+	return &sceneeditpb.RegionUpdate{X: int32(region.Min.X), Y: int32(region.Min.Y),
+		Width: int32(region.Dx()), Height: int32(region.Dy()), Rgba: pixels}
+}
+
+// ReadUpdate reads the next update of the stream of /watch from r
+func ReadUpdate(r *bufio.Reader) (*sceneeditpb.RegionUpdate, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > 4*maxPixels+1<<10 {
+		return nil, fmt.Errorf("update of %d bytes is too big", length)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	update := &sceneeditpb.RegionUpdate{}
+	return update, update.Unmarshal(message)
+}
+
+// protect runs f turning its panics into errors, since the scene panics on
+// the mutations it can't apply, such as moving a shape that can't be
+// moved
+func protect(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return f()
+}
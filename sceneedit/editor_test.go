@@ -0,0 +1,102 @@
+package sceneedit
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/proto/sceneeditpb"
+	"github.com/ProjectMOA/goraytrace/proto/scenepb"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+func testScene() *scene.Scene {
+	s := scene.New()
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Z: 3}, Radius: 0.1})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{X: 0.5, Z: 4}, Radius: 0.2})
+	// The light is by the camera, so the shadows fall behind the shapes
+	// and the regions they change are small
+	s.AddLight(&lighting.PointLight{Position: math3d.Vector3{Y: 0.2, Z: -1}, Intensity: image.White})
+	return s
+}
+
+func post(t *testing.T, url string, m interface{ Marshal() []byte }) *http.Response {
+	response, err := http.Post(url, protobufType, bytes.NewReader(m.Marshal()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestMutationsUpdateTheRender(t *testing.T) {
+	server := httptest.NewServer(NewEditor(testScene()))
+	defer server.Close()
+	watch := post(t, server.URL+"/watch", &sceneeditpb.RenderRequest{Width: 32, Height: 24})
+	defer watch.Body.Close()
+	updates := bufio.NewReader(watch.Body)
+	first, err := ReadUpdate(updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := testScene().TraceScene(32, 24)
+	if first.X != 0 || first.Y != 0 || first.Width != 32 || first.Height != 24 || !bytes.Equal(first.Rgba, expected.Pix) {
+		t.Fatalf("The whole frame should be sent first, not %dx%d at %d, %d", first.Width, first.Height, first.X, first.Y)
+	}
+
+	batch := &sceneeditpb.MutationBatch{Mutations: []*sceneeditpb.Mutation{
+		{Mutation: &sceneeditpb.Mutation_MoveShape{MoveShape: &sceneeditpb.MoveShape{Index: 0, Offset: &scenepb.Vector3{X: 0.1}}}},
+		{Mutation: &sceneeditpb.Mutation_RemoveShape{RemoveShape: &sceneeditpb.RemoveShape{Index: 9}}},
+		{Mutation: &sceneeditpb.Mutation_AddShape{AddShape: &sceneeditpb.AddShape{Shape: &scenepb.Shape{Shape: &scenepb.Shape_Sphere{
+			Sphere: &scenepb.Sphere{Position: &scenepb.Vector3{X: -0.2, Z: 3}, Radius: 0.05}}}}}},
+		{Mutation: &sceneeditpb.Mutation_AddShape{AddShape: &sceneeditpb.AddShape{Shape: &scenepb.Shape{}}}},
+		{},
+	}}
+	response := post(t, server.URL+"/apply", batch)
+	var result sceneeditpb.MutationResult
+	var body bytes.Buffer
+	body.ReadFrom(response.Body)
+	response.Body.Close()
+	if err := result.Unmarshal(body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 5 || result.Errors[0] != "" || result.Errors[1] == "" || result.Errors[2] != "" || result.Errors[3] == "" || result.Errors[4] == "" {
+		t.Fatalf("Only the mutations that can't be applied should fail: %q", result.Errors)
+	}
+
+	update, err := ReadUpdate(updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Width*update.Height >= 32*24 {
+		t.Errorf("Only the region of the frame that changed should be sent, not %dx%d", update.Width, update.Height)
+	}
+	frame := image.New(32, 24)
+	copy(frame.Pix, first.Rgba)
+	for y := 0; y < int(update.Height); y++ {
+		start := frame.PixOffset(int(update.X), int(update.Y)+y)
+		copy(frame.Pix[start:start+4*int(update.Width)], update.Rgba[4*int(update.Width)*y:])
+	}
+	local := testScene()
+	local.MoveShape(0, &math3d.Vector3{X: 0.1})
+	local.AddShape(&shape.Sphere{Position: math3d.Vector3{X: -0.2, Z: 3}, Radius: 0.05})
+	if !bytes.Equal(frame.Pix, local.TraceScene(32, 24).Pix) {
+		t.Error("The frame updated with the region should be the render of the edited scene")
+	}
+
+	for _, path := range []string{"/apply", "/watch"} {
+		response, err := http.Post(server.URL+path, protobufType, bytes.NewReader([]byte("{not protobuf")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Malformed messages to %s should be rejected, it answered %s", path, response.Status)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package shaderball renders materials on a standard shader ball scene: a
+// ball of the material on a grey floor, in a dark studio lit by a key, a
+// fill and a rim light, always seen from the same camera. Rendering every
+// material of a library the same way, at one of a few preset qualities,
+// makes their previews comparable side by side, as in a catalog.
+package shaderball
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/camera"
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/lighting"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+	"github.com/ProjectMOA/goraytrace/render"
+	"github.com/ProjectMOA/goraytrace/scene"
+	"github.com/ProjectMOA/goraytrace/shape"
+)
+
+// Quality is how a preset renders the shader ball
+type Quality struct {
+	// Size is the width and the height of the renders, in pixels
+	Size int
+	// Samples, Integrator and MaxDepth are the settings of the renders
+	Samples    int
+	Integrator string
+	MaxDepth   int
+}
+
+// The names of the preset qualities
+const (
+	// Draft renders small previews with direct lighting, in moments
+	Draft = "draft"
+	// Preview renders previews with the light bouncing a few times
+	Preview = "preview"
+	// Final renders large previews with the light bouncing many times
+	Final = "final"
+)
+
+// qualities are the preset qualities by their names
+var qualities = map[string]Quality{
+	Draft:   {Size: 128, Samples: 4, Integrator: scene.Direct, MaxDepth: 2},
+	Preview: {Size: 256, Samples: 16, Integrator: scene.Bidirectional, MaxDepth: 4},
+	Final:   {Size: 512, Samples: 64, Integrator: scene.Bidirectional, MaxDepth: 8},
+}
+
+// ParseQuality returns the preset quality with the name
+func ParseQuality(name string) (Quality, error) {
+	q, ok := qualities[name]
+	if !ok {
+		return Quality{}, fmt.Errorf("unknown quality %q, it must be draft, preview or final", name)
+	}
+	return q, nil
+}
+
+// studioRadius is the radius of the sphere that encloses the studio, and
+// floorRadius the one of the sphere whose top is the floor, large enough
+// for it to look flat
+const (
+	studioRadius = 20
+	floorRadius  = 1000
+)
+
+// Scene returns the shader ball scene with the ball of the material, set
+// to render at the quality. The ball has a radius of 1 and sits on the
+// floor at the origin, with its UVs wrapped around it as spheres have
+// them. The renders are in the sRGB color space.
+func Scene(m material.Material, q Quality) *scene.Scene {
+	s := scene.New()
+	s.Camera = camera.NewLookAt(math3d.Vector3{Y: 1.6, Z: -4.5}, math3d.Vector3{Y: 0.9}, math3d.UnitY, 36, 1)
+	s.Settings.Samples = q.Samples
+	s.Settings.Integrator = q.Integrator
+	s.Settings.MaxDepth = q.MaxDepth
+	s.Settings.ColorSpace = scene.SRGB
+	// The direct integrator shades the inside of the studio only if the
+	// back faces are shaded
+	s.Settings.Backfaces = scene.TwoSided
+	grey := &material.Lambertian{Albedo: image.Color{R: 0.5, G: 0.5, B: 0.5}}
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: -floorRadius}, Radius: floorRadius, Name: "floor", Material: grey})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{}, Radius: studioRadius, Name: "studio",
+		Material: &material.Lambertian{Albedo: image.Color{R: 0.15, G: 0.15, B: 0.15}}})
+	s.AddShape(&shape.Sphere{Position: math3d.Vector3{Y: 1}, Radius: 1, Name: "ball", Material: m})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: -3, Y: 4, Z: -3}, Radius: 0.6, Radiance: image.Color{R: 25, G: 24, B: 22}})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 4, Y: 2, Z: -3}, Radius: 0.8, Radiance: image.Color{R: 4, G: 4.2, B: 4.6}})
+	s.AddLight(&lighting.SphereLight{Position: math3d.Vector3{X: 1, Y: 3.5, Z: 4}, Radius: 0.5, Radiance: image.Color{R: 15, G: 15, B: 15}})
+	return s
+}
+
+// Render renders the shader ball of the material at the quality. If the
+// context is done first, the tiles not traced yet are left black and its
+// error is returned with the render.
+func Render(ctx context.Context, m material.Material, q Quality, opts render.Options) (*image.Image, error) {
+	return render.Scene(ctx, Scene(m, q), q.Size, q.Size, opts)
+}
+
+// Entry is a material of a library with its name
+type Entry struct {
+	Name     string
+	Material material.Material
+}
+
+// ParseLibrary returns the materials of a library: a JSON object with a
+// material for every name, in the order of their names, or a single
+// material, with a "type", named name
+func ParseLibrary(data []byte, name string) ([]Entry, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["type"]; ok {
+		fields = map[string]json.RawMessage{name: data}
+	}
+	names := make([]string, 0, len(fields))
+	for n := range fields {
+		// The names name the files of the previews
+		if n == "" || strings.ContainsAny(n, `/\`) || n == "." || n == ".." {
+			return nil, fmt.Errorf("%q can't name a material, since it can't name a file", n)
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	entries := make([]Entry, len(names))
+	for i, n := range names {
+		m, err := material.Unmarshal(fields[n])
+		if err != nil {
+			return nil, fmt.Errorf("material %s: %v", n, err)
+		}
+		entries[i] = Entry{Name: n, Material: m}
+	}
+	return entries, nil
+}
+
+// LoadLibrary returns the materials of the library in the file, named
+// after the file if it holds a single material
+func LoadLibrary(path string) ([]Entry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLibrary(data, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+}
+
+// Catalog returns the previews laid out in a grid of rows of as many of
+// them as the square root of their number, rounded up, one after another.
+// Every cell is as large as the largest preview, which sits at its top
+// left corner.
+func Catalog(previews []*image.Image) *image.Image {
+	if len(previews) == 0 {
+		return image.New(0, 0)
+	}
+	columns := int(math.Ceil(math.Sqrt(float64(len(previews)))))
+	rows := (len(previews) + columns - 1) / columns
+	cellWidth, cellHeight := 0, 0
+	for _, p := range previews {
+		cellWidth = maxInt(cellWidth, p.Rect.Dx())
+		cellHeight = maxInt(cellHeight, p.Rect.Dy())
+	}
+	catalog := image.New(columns*cellWidth, rows*cellHeight)
+	for i, p := range previews {
+		left, top := i%columns*cellWidth, i/columns*cellHeight
+		for y := 0; y < p.Rect.Dy(); y++ {
+			copy(catalog.Pix[catalog.PixOffset(left, top+y):], p.Pix[p.PixOffset(p.Rect.Min.X, p.Rect.Min.Y+y):p.PixOffset(p.Rect.Max.X, p.Rect.Min.Y+y)])
+		}
+	}
+	return catalog
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
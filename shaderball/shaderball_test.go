@@ -0,0 +1,81 @@
+package shaderball
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/render"
+)
+
+func TestTheBallShowsItsMaterial(t *testing.T) {
+	q, err := ParseQuality(Draft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Size, q.Samples = 32, 1
+	for _, c := range []struct {
+		name  string
+		color image.Color
+		check func(r, g, b uint8) bool
+	}{
+		{"red", image.Color{R: 0.8, G: 0.1, B: 0.1}, func(r, g, b uint8) bool { return r > 2*g && r > 2*b }},
+		{"blue", image.Color{R: 0.1, G: 0.1, B: 0.8}, func(r, g, b uint8) bool { return b > 2*r && b > 2*g }},
+	} {
+		rendered, err := Render(context.Background(), &material.Lambertian{Albedo: c.color}, q, render.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The ball fills the middle of the render
+		pixel := rendered.NRGBAAt(16, 14)
+		if !c.check(pixel.R, pixel.G, pixel.B) {
+			t.Errorf("the %s ball should look %s, but its middle is %v", c.name, c.name, pixel)
+		}
+	}
+}
+
+func TestParseQualityRejectsUnknownNames(t *testing.T) {
+	if _, err := ParseQuality("ultra"); err == nil {
+		t.Error("ultra shouldn't be a quality")
+	}
+}
+
+func TestParseLibrary(t *testing.T) {
+	library := `{"steel": {"type": "glossy", "albedo": {"r": 0.6, "g": 0.6, "b": 0.6}, "exponent": 50},
+		"chalk": {"type": "lambertian", "albedo": {"r": 0.9, "g": 0.9, "b": 0.9}}}`
+	entries, err := ParseLibrary([]byte(library), "library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "chalk" || entries[1].Name != "steel" {
+		t.Fatalf("the library should hold chalk and steel in order, not %v", entries)
+	}
+	if _, ok := entries[1].Material.(*material.Glossy); !ok {
+		t.Errorf("steel should be glossy, not %T", entries[1].Material)
+	}
+	single := `{"type": "lambertian", "albedo": {"r": 0.9, "g": 0.9, "b": 0.9}}`
+	if entries, err = ParseLibrary([]byte(single), "chalk"); err != nil || len(entries) != 1 || entries[0].Name != "chalk" {
+		t.Errorf("a single material should be named after the file, not %v, %v", entries, err)
+	}
+	if _, err := ParseLibrary([]byte(`{"../chalk": {"type": "lambertian"}}`), "library"); err == nil {
+		t.Error("names that aren't names of files should be rejected")
+	}
+}
+
+func TestCatalogLaysOutThePreviewsInAGrid(t *testing.T) {
+	previews := make([]*image.Image, 5)
+	for i := range previews {
+		previews[i] = image.New(4, 3)
+		previews[i].Pix[3] = uint8(i + 1)
+	}
+	catalog := Catalog(previews)
+	if b := catalog.Bounds(); b.Dx() != 12 || b.Dy() != 6 {
+		t.Fatalf("5 previews of 4x3 should make a 12x6 catalog, not %v", b)
+	}
+	for i := range previews {
+		if a := catalog.NRGBAAt(i%3*4, i/3*3).A; a != uint8(i+1) {
+			t.Errorf("the preview %d should be at the top left of its cell, but the alpha there is %d", i, a)
+		}
+	}
+}
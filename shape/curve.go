@@ -0,0 +1,341 @@
+package shape
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// maxCurveDepth is the most times a curve is split in two before it's
+// intersected as a straight segment
+const maxCurveDepth = 10
+
+// Curve defines a cubic Bézier curve with a width, such as a hair, a
+// blade of grass or a cable. It's intersected as a flat ribbon that always
+// faces the lightray, but shaded as if it was a tube.
+type Curve struct {
+	// Points are the control points of the curve. It starts at the first
+	// one and ends at the last one.
+	Points [4]math3d.Vector3 `json:"points"`
+	// Widths are the widths of the curve at the control points, which are
+	// interpolated along the curve as the points are
+	Widths [4]float64 `json:"widths"`
+	Name   string     `json:"name,omitempty"`
+	// Material is the material of the surface, the default one if nil
+	Material material.Material `json:"material,omitempty"`
+}
+
+// PointAt returns the point of the curve at u, from 0 to 1
+func (c *Curve) PointAt(u float64) math3d.Vector3 {
+	b := bernstein(u)
+	return c.Points[0].MultiplyV(b[0]).
+		AddV(c.Points[1].MultiplyV(b[1])).
+		AddV(c.Points[2].MultiplyV(b[2])).
+		AddV(c.Points[3].MultiplyV(b[3]))
+}
+
+// WidthAt returns the width of the curve at u, from 0 to 1
+func (c *Curve) WidthAt(u float64) float64 {
+	b := bernstein(u)
+	return b[0]*c.Widths[0] + b[1]*c.Widths[1] + b[2]*c.Widths[2] + b[3]*c.Widths[3]
+}
+
+// bernstein returns the cubic Bernstein polynomials at u
+func bernstein(u float64) [4]float64 {
+	v := 1 - u
+	return [4]float64{v * v * v, 3 * u * v * v, 3 * u * u * v, u * u * u}
+}
+
+// Intersect returns the distance at which the lightray intersects the
+// curve. The curve is moved to a space where the lightray starts at the
+// origin and goes along Z, and split in halves until each one is as good
+// as straight, skipping the halves too far from the Z axis to be hit.
+func (c *Curve) Intersect(lr *math3d.LightRay) float64 {
+	length2 := lr.Direction.AbsSquared()
+	direction := lr.Direction.DivideV(math.Sqrt(length2))
+	x, y := math3d.OrthonormalBasis(direction)
+	var points [4]math3d.Vector3
+	for i, p := range c.Points {
+		toPoint := p.SubtractV(lr.Source)
+		// Z is the distance along the lightray, in units of its direction
+		points[i] = math3d.Vector3{X: toPoint.DotV(x), Y: toPoint.DotV(y), Z: toPoint.DotV(lr.Direction) / length2}
+	}
+
+	// Split until the control points are within a twentieth of the width
+	// of a line, as in pbrt
+	flatness := 0.0
+	for i := 0; i < 2; i++ {
+		second := points[i].SubtractV(points[i+1].MultiplyV(2)).AddV(points[i+2])
+		flatness = math.Max(flatness, math.Hypot(second.X, second.Y))
+	}
+	epsilon := c.maxWidth() / 20
+	depth := 0
+	if flatness > 0 && epsilon > 0 {
+		depth = int(math3d.Clamp(math.Log2(math.Sqrt2*6*flatness/(8*epsilon))/2, 0, maxCurveDepth))
+	}
+	return c.intersectSegment(points, 0, 1, depth, math.MaxFloat64)
+}
+
+// intersectSegment returns the distance to the part of the curve between
+// u0 and u1, whose control points in ray space are points, if it's closer
+// than nearest. Otherwise it returns nearest.
+func (c *Curve) intersectSegment(points [4]math3d.Vector3, u0, u1 float64, depth int, nearest float64) float64 {
+	halfWidth := c.maxWidth() / 2
+	bounds := math3d.EmptyAABB()
+	for i := range points {
+		bounds = bounds.Expand(&points[i])
+	}
+	if bounds.Min.X-halfWidth > 0 || bounds.Max.X+halfWidth < 0 ||
+		bounds.Min.Y-halfWidth > 0 || bounds.Max.Y+halfWidth < 0 ||
+		bounds.Max.Z+halfWidth < 0 || bounds.Min.Z-halfWidth >= nearest {
+		return nearest
+	}
+
+	if depth > 0 {
+		first, second := splitBezier(points)
+		middle := (u0 + u1) / 2
+		nearest = c.intersectSegment(first, u0, middle, depth-1, nearest)
+		return c.intersectSegment(second, middle, u1, depth-1, nearest)
+	}
+
+	// The segment is straight enough. Find the point in it closest to the
+	// Z axis and check whether the lightray goes through the ribbon there.
+	start, end := points[0], points[3]
+	dx, dy := end.X-start.X, end.Y-start.Y
+	length2 := dx*dx + dy*dy
+	w := 0.0
+	if length2 > 0 {
+		w = -(start.X*dx + start.Y*dy) / length2
+	}
+	if w < 0 || w > 1 {
+		return nearest
+	}
+	px, py := start.X+w*dx, start.Y+w*dy
+	width := c.WidthAt(u0 + w*(u1-u0))
+	if px*px+py*py > width*width/4 {
+		return nearest
+	}
+	t := math3d.DiscardIfTooClose(start.Z + w*(end.Z-start.Z))
+	return math.Min(t, nearest)
+}
+
+// splitBezier splits the cubic Bézier curve with the control points in
+// two halves with de Casteljau's algorithm
+func splitBezier(p [4]math3d.Vector3) ([4]math3d.Vector3, [4]math3d.Vector3) {
+	p01, p12, p23 := midpoint(p[0], p[1]), midpoint(p[1], p[2]), midpoint(p[2], p[3])
+	p012, p123 := midpoint(p01, p12), midpoint(p12, p23)
+	middle := midpoint(p012, p123)
+	return [4]math3d.Vector3{p[0], p01, p012, middle}, [4]math3d.Vector3{middle, p123, p23, p[3]}
+}
+
+func midpoint(a, b math3d.Vector3) math3d.Vector3 {
+	return a.AddV(b).MultiplyV(0.5)
+}
+
+func (c *Curve) maxWidth() float64 {
+	return math.Max(math.Max(c.Widths[0], c.Widths[1]), math.Max(c.Widths[2], c.Widths[3]))
+}
+
+// NormalAt returns the normal vector of a point of the curve. It points
+// away from the nearest point of the center line of the curve, so curves
+// are shaded as tubes even if they are intersected as ribbons.
+func (c *Curve) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	u := c.nearestParameter(point)
+	tangent := c.tangentAt(u)
+	away := point.SubtractV(c.PointAt(u))
+	// Only the part of away perpendicular to the curve counts
+	normal := away.SubtractV(tangent.MultiplyV(away.DotV(tangent)))
+	if normal.AbsSquared() == 0 {
+		normal, _ = math3d.OrthonormalBasis(tangent)
+	}
+	return normal.NormalizedV()
+}
+
+// tangentAt returns the unit vector along the curve at u
+func (c *Curve) tangentAt(u float64) math3d.Vector3 {
+	v := 1 - u
+	d := c.Points[1].SubtractV(c.Points[0]).MultiplyV(3 * v * v).
+		AddV(c.Points[2].SubtractV(c.Points[1]).MultiplyV(6 * u * v)).
+		AddV(c.Points[3].SubtractV(c.Points[2]).MultiplyV(3 * u * u))
+	if d.AbsSquared() == 0 {
+		d = c.Points[3].SubtractV(c.Points[0])
+	}
+	return d.NormalizedV()
+}
+
+// nearestParameter returns the u of the point of the curve closest to
+// point, searching it among evenly spaced samples and refining the best.
+func (c *Curve) nearestParameter(point *math3d.Vector3) float64 {
+	const samples = 32
+	best, bestDistance := 0.0, math.MaxFloat64
+	for i := 0; i <= samples; i++ {
+		u := float64(i) / samples
+		p := c.PointAt(u)
+		if d := math3d.Distance(point, &p); d < bestDistance {
+			best, bestDistance = u, d
+		}
+	}
+	step := 1.0 / samples
+	for i := 0; i < 16; i++ {
+		step /= 2
+		for _, u := range []float64{best - step, best + step} {
+			u = math3d.Clamp(u, 0, 1)
+			p := c.PointAt(u)
+			if d := math3d.Distance(point, &p); d < bestDistance {
+				best, bestDistance = u, d
+			}
+		}
+	}
+	return best
+}
+
+// Bounds returns the bounding box of the curve, which holds its control
+// points grown by half the widest width
+func (c *Curve) Bounds() *math3d.AABB {
+	bounds := math3d.EmptyAABB()
+	for i := range c.Points {
+		bounds = bounds.Expand(&c.Points[i])
+	}
+	r := c.maxWidth() / 2
+	grow := math3d.Vector3{X: r, Y: r, Z: r}
+	return &math3d.AABB{Min: bounds.Min.SubtractV(grow), Max: bounds.Max.AddV(grow)}
+}
+
+// Surface returns the material of the curve
+func (c *Curve) Surface() material.Material {
+	return c.Material
+}
+
+// Transform transforms the curve by the affine matrix. Its widths are
+// scaled by how much it scales lengths on average.
+func (c *Curve) Transform(m *math3d.Matrix) {
+	for i := range c.Points {
+		c.Points[i] = *m.MultiplyPoint(&c.Points[i])
+	}
+	scale := math.Cbrt(math.Abs(m.Determinant()))
+	for i := range c.Widths {
+		c.Widths[i] *= scale
+	}
+}
+
+// Translate moves the curve by offset
+func (c *Curve) Translate(offset *math3d.Vector3) {
+	for i := range c.Points {
+		c.Points[i] = c.Points[i].AddV(*offset)
+	}
+}
+
+// AsMap returns a map representation of this shape
+func (c *Curve) AsMap() map[string]interface{} {
+	points := make([]map[string]float64, 0, 4)
+	for i := range c.Points {
+		points = append(points, c.Points[i].AsMap())
+	}
+	m := map[string]interface{}{"type": "curve", "points": points, "widths": c.Widths[:]}
+	if c.Name != "" {
+		m["name"] = c.Name
+	}
+	if c.Material != nil {
+		m["material"] = c.Material.AsMap()
+	}
+	return m
+}
+
+// CurveFromMap returns a curve with the values in the map, or an error if
+// any of them is missing or has the wrong type
+func CurveFromMap(themap map[string]interface{}) (*Curve, error) {
+	d := decoder{m: themap}
+	c := &Curve{}
+	copy(c.Points[:], d.vectors("points", 4))
+	for i, w := range d.list("widths", 4) {
+		width, ok := w.(float64)
+		if !ok {
+			return nil, fmt.Errorf("widths[%d]: must be a number", i)
+		}
+		c.Widths[i] = width
+	}
+	c.Name = d.text("name")
+	c.Material = d.material()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return c, nil
+}
+
+// CurvesFromMap returns the curves in the curves file of the map, all of
+// them with the name and the material of the map, or an error if the file
+// can't be read or a value of the map has the wrong type
+func CurvesFromMap(themap map[string]interface{}) ([]Shape, error) {
+	d := decoder{m: themap}
+	path := d.file("file")
+	name := d.text("name")
+	mat := d.material()
+	if d.err != nil {
+		return nil, d.err
+	}
+	curves, err := LoadCurves(path)
+	if err != nil {
+		return nil, err
+	}
+	shapes := make([]Shape, 0, len(curves))
+	for _, c := range curves {
+		c.Name = name
+		c.Material = mat
+		shapes = append(shapes, c)
+	}
+	return shapes, nil
+}
+
+// LoadCurves reads the curves in a curves file. See ReadCurves.
+func LoadCurves(path string) ([]*Curve, error) {
+	file, err := assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadCurves(file)
+}
+
+// ReadCurves reads curves in the curves file format. Every line holds a
+// curve as the X, Y and Z coordinates and the width of each of its four
+// control points, sixteen numbers in all. Empty lines and the ones
+// starting with # are skipped.
+func ReadCurves(r io.Reader) ([]*Curve, error) {
+	var curves []*Curve
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 16 {
+			return nil, fmt.Errorf("line %d: a curve needs 16 numbers, not %d", line, len(fields))
+		}
+		var numbers [16]float64
+		for i, f := range fields {
+			n, err := strconv.ParseFloat(f, 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				return nil, fmt.Errorf("line %d: %q is not a valid number", line, f)
+			}
+			numbers[i] = n
+		}
+		c := &Curve{}
+		for i := range c.Points {
+			c.Points[i] = math3d.Vector3{X: numbers[4*i], Y: numbers[4*i+1], Z: numbers[4*i+2]}
+			c.Widths[i] = numbers[4*i+3]
+			if c.Widths[i] < 0 {
+				return nil, fmt.Errorf("line %d: widths can't be negative", line)
+			}
+		}
+		curves = append(curves, c)
+	}
+	return curves, scanner.Err()
+}
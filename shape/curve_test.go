@@ -0,0 +1,59 @@
+package shape
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestStraightCurve(t *testing.T) {
+	c := Curve{
+		Points: [4]math3d.Vector3{{X: 0}, {X: 1}, {X: 2}, {X: 3}},
+		Widths: [4]float64{0.2, 0.2, 0.2, 0.2}}
+	hit := math3d.LightRay{Source: math3d.Vector3{X: 1.5, Y: 0.05, Z: -2}, Direction: math3d.UnitZ}
+	if d := c.Intersect(&hit); math.Abs(d-2) > 1e-9 {
+		t.Errorf("The lightray should hit the curve at D=2.0 but it hits at %.3f", d)
+	}
+	miss := math3d.LightRay{Source: math3d.Vector3{X: 1.5, Y: 0.15, Z: -2}, Direction: math3d.UnitZ}
+	if c.Intersect(&miss) != math.MaxFloat64 {
+		t.Error("The lightray should pass by the curve")
+	}
+	if n := c.NormalAt(&math3d.Vector3{X: 1.5, Y: 0.1}); !n.Equal(&math3d.UnitY) {
+		t.Error("The normal should point away from the center line, not " + n.String())
+	}
+}
+
+func TestCurvedCurve(t *testing.T) {
+	c := Curve{
+		Points: [4]math3d.Vector3{{X: 0}, {X: 0, Y: 2}, {X: 2, Y: 2}, {X: 2, Y: 0}},
+		Widths: [4]float64{0.02, 0.04, 0.04, 0.02}}
+	for _, u := range []float64{0.1, 0.3, 0.5, 0.8} {
+		p := c.PointAt(u)
+		lr := math3d.LightRay{Source: math3d.Vector3{X: p.X, Y: p.Y, Z: -1}, Direction: math3d.UnitZ}
+		if d := c.Intersect(&lr); math.Abs(d-1) > 1e-6 {
+			t.Errorf("The lightray through the curve at u=%.1f should hit it at D=1.0, not %.3f", u, d)
+		}
+		// Step sideways, perpendicular to the curve
+		tangent := c.tangentAt(u)
+		lr.Source.X -= tangent.Y * c.WidthAt(u)
+		lr.Source.Y += tangent.X * c.WidthAt(u)
+		if c.Intersect(&lr) != math.MaxFloat64 {
+			t.Errorf("The lightray should pass by the curve at u=%.1f", u)
+		}
+	}
+}
+
+func TestReadCurves(t *testing.T) {
+	curves, err := ReadCurves(strings.NewReader("# grass\n\n0 0 0 .1  0 1 0 .1  0 2 0 .1  0 3 0 0\n"))
+	if err != nil || len(curves) != 1 {
+		t.Fatalf("Expected a curve, got %d and %v", len(curves), err)
+	}
+	if !curves[0].Points[3].Equal(&math3d.Vector3{Y: 3}) || curves[0].Widths[3] != 0 {
+		t.Error("Wrong last control point")
+	}
+	if _, err := ReadCurves(strings.NewReader("0 0 0 1\n")); err == nil {
+		t.Error("A curve with a single control point should be an error")
+	}
+}
@@ -0,0 +1,180 @@
+package shape
+
+import (
+	"fmt"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// decoder reads the values of the map of a shape, as encoding/json decodes
+// it. It keeps the first error, which names the key whose value is missing
+// or has the wrong type, and returns zero values once it has one, so that
+// the FromMap functions check it once they are done. The keys the shapes
+// don't know are ignored, as the scene parser reports them.
+type decoder struct {
+	m   map[string]interface{}
+	err error
+}
+
+// fail keeps the error of the key, unless there's one already
+func (d *decoder) fail(key string, format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...))
+	}
+}
+
+// has returns whether the map has the key
+func (d *decoder) has(key string) bool {
+	_, present := d.m[key]
+	return present
+}
+
+// number returns the number of the key, which must have one
+func (d *decoder) number(key string) float64 {
+	n, ok := d.m[key].(float64)
+	if !ok {
+		d.fail(key, "missing or not a number")
+	}
+	return n
+}
+
+// optionalNumber returns the number of the key, or def if it's missing
+func (d *decoder) optionalNumber(key string, def float64) float64 {
+	if !d.has(key) {
+		return def
+	}
+	return d.number(key)
+}
+
+// text returns the string of the key, or "" if it's missing
+func (d *decoder) text(key string) string {
+	value, present := d.m[key]
+	s, ok := value.(string)
+	if present && !ok {
+		d.fail(key, "must be a string")
+	}
+	return s
+}
+
+// file returns the path of the key, which must have one
+func (d *decoder) file(key string) string {
+	if s, ok := d.m[key].(string); ok && s != "" {
+		return s
+	}
+	d.fail(key, "missing or not a path")
+	return ""
+}
+
+// flag returns the boolean of the key, or false if it's missing
+func (d *decoder) flag(key string) bool {
+	value, present := d.m[key]
+	b, ok := value.(bool)
+	if present && !ok {
+		d.fail(key, "must be true or false")
+	}
+	return b
+}
+
+// vector returns the vector of the key, which must have one
+func (d *decoder) vector(key string) math3d.Vector3 {
+	v, err := math3d.VectorFromMap(d.m[key])
+	if err != nil {
+		d.fail(key, "%v", err)
+	}
+	return v
+}
+
+// list returns the list of the key, which must have one with length
+// elements, or any number of them if length is negative
+func (d *decoder) list(key string, length int) []interface{} {
+	l, ok := d.m[key].([]interface{})
+	switch {
+	case !ok:
+		d.fail(key, "missing or not a list")
+	case length >= 0 && len(l) != length:
+		d.fail(key, "must have %d elements, not %d", length, len(l))
+	default:
+		return l
+	}
+	return nil
+}
+
+// vectors returns the vectors in the list of the key, as list does
+func (d *decoder) vectors(key string, length int) []math3d.Vector3 {
+	l := d.list(key, length)
+	vectors := make([]math3d.Vector3, len(l))
+	for i := range l {
+		v, err := math3d.VectorFromMap(l[i])
+		if err != nil {
+			d.fail(fmt.Sprintf("%s[%d]", key, i), "%v", err)
+			return nil
+		}
+		vectors[i] = v
+	}
+	return vectors
+}
+
+// uvs returns the texture coordinates in the list of the key, as list does
+func (d *decoder) uvs(key string, length int) []math3d.Vector2 {
+	l := d.list(key, length)
+	uvs := make([]math3d.Vector2, len(l))
+	for i := range l {
+		uv, _ := l[i].(map[string]interface{})
+		u, okU := uv["x"].(float64)
+		v, okV := uv["y"].(float64)
+		if !okU || !okV {
+			d.fail(fmt.Sprintf("%s[%d]", key, i), "must be an object with the numbers x and y")
+			return nil
+		}
+		uvs[i] = math3d.Vector2{X: u, Y: v}
+	}
+	return uvs
+}
+
+// color returns the color of the key, which must have one that isn't
+// negative
+func (d *decoder) color(key string) image.Color {
+	c, err := image.ColorFromMap(d.m[key])
+	if err != nil {
+		d.fail(key, "%v", err)
+	} else if !c.NonNegative() {
+		d.fail(key, "can't be negative")
+	}
+	return c
+}
+
+// colors returns the colors in the list of the key, as list does, none of
+// which can be negative
+func (d *decoder) colors(key string, length int) []image.Color {
+	l := d.list(key, length)
+	colors := make([]image.Color, len(l))
+	for i := range l {
+		c, err := image.ColorFromMap(l[i])
+		if err != nil {
+			d.fail(fmt.Sprintf("%s[%d]", key, i), "%v", err)
+			return nil
+		}
+		if !c.NonNegative() {
+			d.fail(fmt.Sprintf("%s[%d]", key, i), "can't be negative")
+			return nil
+		}
+		colors[i] = c
+	}
+	return colors
+}
+
+// material returns the material of the map, or nil if it doesn't have one
+func (d *decoder) material() material.Material {
+	value, present := d.m["material"]
+	if !present {
+		return nil
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		d.fail("material", "must be an object")
+		return nil
+	}
+	return material.FromMap(m)
+}
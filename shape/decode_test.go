@@ -0,0 +1,45 @@
+package shape
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFromMapReturnsErrors(t *testing.T) {
+	for _, c := range []struct {
+		shape string
+		key   string
+	}{
+		{`{"type": "sphere", "position": {"x": 0, "y": 0}, "radius": 1}`, "position"},
+		{`{"type": "sphere", "position": {"x": 0, "y": 0, "z": 0}, "radius": "1"}`, "radius"},
+		{`{"type": "sphere", "position": {"x": 0, "y": 0, "z": 0}, "radius": 1, "material": "glass"}`, "material"},
+		{`{"type": "triangle", "vertices": [{"x": 0, "y": 0, "z": 0}, {"x": 1, "y": 0, "z": 0}]}`, "vertices"},
+		{`{"type": "triangle", "vertices": [{"x": 0, "y": 0, "z": 0}, {"x": 1, "y": 0, "z": 0}, 3]}`, "vertices[2]"},
+		{`{"type": "triangle", "vertices": [{"x": 0, "y": 0, "z": 0}, {"x": 1, "y": 0, "z": 0}, {"x": 0, "y": 0, "z": 1}],
+			"uvs": [{"x": 0, "y": 0}, {"x": 1, "y": 0}, {"x": 0}]}`, "uvs[2]"},
+		{`{"type": "triangle", "vertices": [{"x": 0, "y": 0, "z": 0}, {"x": 1, "y": 0, "z": 0}, {"x": 0, "y": 0, "z": 1}],
+			"colors": [{"r": 1}, {"r": -1}, {"r": 1}]}`, "colors[1]"},
+		{`{"type": "curve", "points": [{"x": 0, "y": 0, "z": 0}, {"x": 1, "y": 0, "z": 0}, {"x": 2, "y": 0, "z": 0}, {"x": 3, "y": 0, "z": 0}],
+			"widths": [1, 1, "1", 1]}`, "widths[2]"},
+		{`{"type": "curves", "file": 7}`, "file"},
+		{`{"type": "point", "position": {"x": 0, "y": 0, "z": 0}, "radius": 1, "color": {"r": "white"}}`, "color"},
+		{`{"type": "point", "position": {"x": 0, "y": 0, "z": 0}, "radius": 1, "splat": "yes"}`, "splat"},
+		{`{"type": "points", "file": "cloud.xyz", "radius": []}`, "radius"},
+		{`{"type": "heightfield", "position": {"x": 0, "y": 0, "z": 0}, "size": {"x": 1, "y": 1, "z": 1}, "heights": [[0, 1], [0, "1"]]}`, "heights[1][1]"},
+		{`{"type": "heightfield", "position": {"x": 0, "y": 0, "z": 0}, "size": {"x": 1, "y": 1, "z": 1}, "heights": [[0, 1], 1]}`, "heights[1]"},
+		{`{"type": "heightfield", "position": {"x": 0, "y": 0, "z": 0}, "size": {"x": 1, "y": 1, "z": 1}, "heights": [[0, 1]]}`, "heights"},
+		{`{"type": "mesh", "file": "mesh.obj", "velocities": [{"x": 0}]}`, "velocities[0]"},
+		{`{"type": "mesh", "file": "mesh.obj", "subdivision": 1.5}`, "subdivision"},
+		{`{"type": "sphere", "position": {"x": 0, "y": 0, "z": 0}, "radius": 1, "transform": {"scale": 2}}`, "transform"},
+		{`{"type": "cube"}`, "type"},
+	} {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(c.shape), &m); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := FromMap([]map[string]interface{}{m}); err == nil || (c.key != "type" && !strings.HasPrefix(err.Error(), c.key+":")) {
+			t.Errorf("%s should fail on its %s, not with %v", c.shape, c.key, err)
+		}
+	}
+}
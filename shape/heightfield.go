@@ -0,0 +1,459 @@
+package shape
+
+import (
+	"errors"
+	"fmt"
+	stdimg "image"
+	"image/color"
+	// Registers the PNG decoder for the heightfield images
+	_ "image/png"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Heightfield defines a terrain whose height over the XZ plane is given
+// by a grid of samples. Every cell of the grid is made of two triangles.
+type Heightfield struct {
+	// Position is the corner of the terrain with the smallest X and Z, at
+	// height 0
+	Position math3d.Vector3 `json:"position"`
+	// Size is the extent of the terrain along X and Z, and the height of
+	// the samples that are 1 along Y
+	Size math3d.Vector3 `json:"size"`
+	Name string         `json:"name,omitempty"`
+	// Image is the path of the grayscale image the samples were read
+	// from, if any
+	Image string `json:"image,omitempty"`
+	// Material is the material of the surface, the default one if nil
+	Material material.Material `json:"material,omitempty"`
+	// CullBackfaces makes the lightrays that hit the terrain from below
+	// go through it
+	CullBackfaces bool `json:"-"`
+
+	columns, rows int
+	// heights holds the samples row after row, rows growing along Z
+	heights []float64
+	// levels holds the quadtree of the height spans of the cells. Level 0
+	// has a span per cell and every level above merges 2x2 spans of the
+	// one below, until a single span covers the whole terrain.
+	levels []spanGrid
+}
+
+// span holds the lowest and highest samples in a region of a heightfield
+type span struct {
+	min, max float64
+}
+
+type spanGrid struct {
+	columns, rows int
+	spans         []span
+}
+
+func (g *spanGrid) at(i, j int) span {
+	return g.spans[j*g.columns+i]
+}
+
+// NewHeightfield returns a heightfield of columns x rows samples of the
+// height function. height is called with x and z between 0 and 1.
+func NewHeightfield(position, size math3d.Vector3, columns, rows int, height func(x, z float64) float64) *Heightfield {
+	h := &Heightfield{Position: position, Size: size}
+	samples := make([]float64, 0, columns*rows)
+	for j := 0; j < rows; j++ {
+		for i := 0; i < columns; i++ {
+			samples = append(samples, height(float64(i)/float64(columns-1), float64(j)/float64(rows-1)))
+		}
+	}
+	h.setHeights(columns, rows, samples)
+	return h
+}
+
+// HeightfieldFromImage returns a heightfield with a sample for every
+// pixel of the image, whose height is the gray level of the pixel. The
+// rows of the image grow along Z. The gray levels are heights rather than
+// colors, so they're taken as they're stored, without decoding them from
+// sRGB like the colors of images.
+func HeightfieldFromImage(position, size math3d.Vector3, img stdimg.Image) *Heightfield {
+	bounds := img.Bounds()
+	h := &Heightfield{Position: position, Size: size}
+	samples := make([]float64, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16)
+			samples = append(samples, float64(gray.Y)/0xffff)
+		}
+	}
+	h.setHeights(bounds.Dx(), bounds.Dy(), samples)
+	return h
+}
+
+// setHeights replaces the samples of the heightfield and builds the
+// quadtree over them
+func (h *Heightfield) setHeights(columns, rows int, samples []float64) {
+	if columns < 2 || rows < 2 || len(samples) != columns*rows {
+		panic("A heightfield needs at least 2x2 samples")
+	}
+	h.columns, h.rows, h.heights = columns, rows, samples
+	cells := spanGrid{columns: columns - 1, rows: rows - 1}
+	for j := 0; j < cells.rows; j++ {
+		for i := 0; i < cells.columns; i++ {
+			s := span{min: math.Inf(1), max: math.Inf(-1)}
+			for _, v := range []float64{h.sample(i, j), h.sample(i+1, j), h.sample(i, j+1), h.sample(i+1, j+1)} {
+				s.min, s.max = math.Min(s.min, v), math.Max(s.max, v)
+			}
+			cells.spans = append(cells.spans, s)
+		}
+	}
+	h.levels = []spanGrid{cells}
+	for below := cells; below.columns > 1 || below.rows > 1; {
+		above := spanGrid{columns: (below.columns + 1) / 2, rows: (below.rows + 1) / 2}
+		for j := 0; j < above.rows; j++ {
+			for i := 0; i < above.columns; i++ {
+				s := span{min: math.Inf(1), max: math.Inf(-1)}
+				for _, c := range h.children(&below, i, j) {
+					child := below.at(c[0], c[1])
+					s.min, s.max = math.Min(s.min, child.min), math.Max(s.max, child.max)
+				}
+				above.spans = append(above.spans, s)
+			}
+		}
+		h.levels = append(h.levels, above)
+		below = above
+	}
+}
+
+// Samples returns the number of columns and rows of samples of the
+// heightfield
+func (h *Heightfield) Samples() (columns, rows int) {
+	return h.columns, h.rows
+}
+
+// Vertex returns the point of the sample at column i and row j, and the
+// normal that the terrain is shaded with there. Every cell of the grid is
+// made of the triangles (i, j), (i+1, j), (i+1, j+1) and (i, j),
+// (i+1, j+1), (i, j+1).
+func (h *Heightfield) Vertex(i, j int) (point, normal math3d.Vector3) {
+	return h.vertex(i, j), h.sampleNormal(i, j)
+}
+
+// Memory returns the number of bytes that the samples of the heightfield
+// and their quadtree take
+func (h *Heightfield) Memory() uint64 {
+	bytes := uint64(len(h.heights)) * 8
+	for _, level := range h.levels {
+		bytes += uint64(len(level.spans)) * 16
+	}
+	return bytes
+}
+
+// Downscale resamples the heightfield to columns x rows samples, which
+// can't be more than it has. Every new sample is the average of the
+// samples around it, so the terrain keeps its shape with less detail.
+func (h *Heightfield) Downscale(columns, rows int) {
+	if columns > h.columns || rows > h.rows {
+		panic("A heightfield can't be downscaled to more samples")
+	}
+	if columns == h.columns && rows == h.rows {
+		return
+	}
+	// footprint returns the range of old samples around the new sample n
+	// of count along an axis of old samples
+	footprint := func(n, count, old int) (int, int) {
+		step := float64(old-1) / float64(count-1)
+		center := float64(n) * step
+		return maxInt(int(math.Ceil(center-step/2)), 0), minInt(int(math.Floor(center+step/2)), old-1)
+	}
+	samples := make([]float64, 0, columns*rows)
+	for j := 0; j < rows; j++ {
+		j0, j1 := footprint(j, rows, h.rows)
+		for i := 0; i < columns; i++ {
+			i0, i1 := footprint(i, columns, h.columns)
+			sum := 0.0
+			for oj := j0; oj <= j1; oj++ {
+				for oi := i0; oi <= i1; oi++ {
+					sum += h.sample(oi, oj)
+				}
+			}
+			samples = append(samples, sum/float64((i1-i0+1)*(j1-j0+1)))
+		}
+	}
+	h.setHeights(columns, rows, samples)
+}
+
+// children returns the coordinates in grid of the nodes under the node
+// i, j of the level above it
+func (h *Heightfield) children(grid *spanGrid, i, j int) [][2]int {
+	children := make([][2]int, 0, 4)
+	for cj := 2 * j; cj < 2*j+2 && cj < grid.rows; cj++ {
+		for ci := 2 * i; ci < 2*i+2 && ci < grid.columns; ci++ {
+			children = append(children, [2]int{ci, cj})
+		}
+	}
+	return children
+}
+
+func (h *Heightfield) sample(i, j int) float64 {
+	return h.heights[j*h.columns+i]
+}
+
+// vertex returns the point of the sample i, j
+func (h *Heightfield) vertex(i, j int) math3d.Vector3 {
+	return math3d.Vector3{
+		X: h.Position.X + float64(i)/float64(h.columns-1)*h.Size.X,
+		Y: h.Position.Y + h.sample(i, j)*h.Size.Y,
+		Z: h.Position.Z + float64(j)/float64(h.rows-1)*h.Size.Z}
+}
+
+// Intersect returns the distance at which the lightray intersects the
+// heightfield. It walks down the quadtree, skipping the regions whose
+// bounds the lightray misses or enters past the nearest hit so far.
+func (h *Heightfield) Intersect(lr *math3d.LightRay) float64 {
+	top := len(h.levels) - 1
+	return h.intersectNode(lr, top, 0, 0, math.MaxFloat64)
+}
+
+func (h *Heightfield) intersectNode(lr *math3d.LightRay, level, i, j int, nearest float64) float64 {
+	if bounds := h.nodeBounds(level, i, j); bounds.Intersect(lr) >= nearest {
+		return nearest
+	}
+	if level == 0 {
+		return math.Min(nearest, h.intersectCell(lr, i, j))
+	}
+	for _, c := range h.children(&h.levels[level-1], i, j) {
+		nearest = h.intersectNode(lr, level-1, c[0], c[1], nearest)
+	}
+	return nearest
+}
+
+// nodeBounds returns the bounding box of the node i, j of the level, as a
+// value so walking down the quadtree doesn't allocate
+func (h *Heightfield) nodeBounds(level, i, j int) math3d.AABB {
+	cells := 1 << uint(level)
+	s := h.levels[level].at(i, j)
+	cellX, cellZ := h.Size.X/float64(h.columns-1), h.Size.Z/float64(h.rows-1)
+	lastI, lastJ := math.Min(float64((i+1)*cells), float64(h.columns-1)), math.Min(float64((j+1)*cells), float64(h.rows-1))
+	return math3d.AABB{
+		Min: math3d.Vector3{
+			X: h.Position.X + float64(i*cells)*cellX,
+			Y: h.Position.Y + math.Min(s.min*h.Size.Y, s.max*h.Size.Y),
+			Z: h.Position.Z + float64(j*cells)*cellZ},
+		Max: math3d.Vector3{
+			X: h.Position.X + lastI*cellX,
+			Y: h.Position.Y + math.Max(s.min*h.Size.Y, s.max*h.Size.Y),
+			Z: h.Position.Z + lastJ*cellZ}}
+}
+
+// intersectCell returns the distance at which the lightray intersects
+// either triangle of the cell i, j. Both are wound so that their front
+// faces up.
+func (h *Heightfield) intersectCell(lr *math3d.LightRay, i, j int) float64 {
+	a, b, c, d := h.vertex(i, j), h.vertex(i+1, j), h.vertex(i, j+1), h.vertex(i+1, j+1)
+	return math.Min(intersectTriangle(lr, &a, &d, &b, h.CullBackfaces), intersectTriangle(lr, &a, &c, &d, h.CullBackfaces))
+}
+
+// NormalAt returns the normal vector of a point of the heightfield. The
+// normals of the samples around it are interpolated, so the terrain
+// looks smooth.
+func (h *Heightfield) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	normal := math3d.Vector3{}
+	h.forCorners(point, func(i, j int, weight float64) {
+		normal.AddInPlace(h.sampleNormal(i, j).MultiplyV(weight))
+	})
+	normal.NormalizeInPlace()
+	return normal
+}
+
+// SurfaceAt returns the point of the heightfield at the texture
+// coordinates u, v and its normal. u goes along X and v along Z, from the
+// corner at Position to the opposite one.
+func (h *Heightfield) SurfaceAt(u, v float64) (point, normal math3d.Vector3, ok bool) {
+	x := math3d.Clamp(u, 0, 1) * float64(h.columns-1)
+	z := math3d.Clamp(v, 0, 1) * float64(h.rows-1)
+	i, j := int(math.Min(x, float64(h.columns-2))), int(math.Min(z, float64(h.rows-2)))
+	fx, fz := x-float64(i), z-float64(j)
+	// The cells are split along the diagonal from i, j to i+1, j+1, like
+	// the triangles intersectCell tests
+	a, d := h.vertex(i, j), h.vertex(i+1, j+1)
+	if fx >= fz {
+		b := h.vertex(i+1, j)
+		point = a.AddV(b.SubtractV(a).MultiplyV(fx)).AddV(d.SubtractV(b).MultiplyV(fz))
+	} else {
+		c := h.vertex(i, j+1)
+		point = a.AddV(c.SubtractV(a).MultiplyV(fz)).AddV(d.SubtractV(c).MultiplyV(fx))
+	}
+	return point, h.NormalAt(&point), true
+}
+
+// ShadowOrigin returns the point from which the rays towards the lights
+// leave the heightfield at point. The normals are smooth but the
+// triangles are flat, so near the terminator shadow rays leaving a
+// triangle towards the lights it seems to face hit the triangles next to
+// it, which shows the facets. As Hanika proposes, the point is moved above
+// the planes tangent to the surface at the samples around it, along their
+// normals, which keeps it on flat regions and lifts it on curved ones.
+func (h *Heightfield) ShadowOrigin(point *math3d.Vector3) math3d.Vector3 {
+	origin := *point
+	h.forCorners(point, func(i, j int, weight float64) {
+		normal, vertex := h.sampleNormal(i, j), h.vertex(i, j)
+		if below := point.SubtractV(vertex).DotV(normal); below < 0 {
+			origin.SubtractInPlace(normal.MultiplyV(weight * below))
+		}
+	})
+	return origin
+}
+
+// forCorners calls f with the samples at the corners of the cell of point
+// and their bilinear weights at point
+func (h *Heightfield) forCorners(point *math3d.Vector3, f func(i, j int, weight float64)) {
+	u := math3d.Clamp((point.X-h.Position.X)/h.Size.X*float64(h.columns-1), 0, float64(h.columns-1))
+	v := math3d.Clamp((point.Z-h.Position.Z)/h.Size.Z*float64(h.rows-1), 0, float64(h.rows-1))
+	i, j := int(math.Min(u, float64(h.columns-2))), int(math.Min(v, float64(h.rows-2)))
+	fu, fv := u-float64(i), v-float64(j)
+	f(i, j, (1-fu)*(1-fv))
+	f(i+1, j, fu*(1-fv))
+	f(i, j+1, (1-fu)*fv)
+	f(i+1, j+1, fu*fv)
+}
+
+// sampleNormal returns the normal of the terrain at the sample i, j from
+// the slopes to the samples next to it
+func (h *Heightfield) sampleNormal(i, j int) math3d.Vector3 {
+	i0, i1 := maxInt(i-1, 0), minInt(i+1, h.columns-1)
+	j0, j1 := maxInt(j-1, 0), minInt(j+1, h.rows-1)
+	cellX, cellZ := h.Size.X/float64(h.columns-1), h.Size.Z/float64(h.rows-1)
+	slopeX := (h.sample(i1, j) - h.sample(i0, j)) * h.Size.Y / (float64(i1-i0) * cellX)
+	slopeZ := (h.sample(i, j1) - h.sample(i, j0)) * h.Size.Y / (float64(j1-j0) * cellZ)
+	return math3d.Vector3{X: -slopeX, Y: 1, Z: -slopeZ}.NormalizedV()
+}
+
+// Bounds returns the bounding box of the heightfield
+func (h *Heightfield) Bounds() *math3d.AABB {
+	top := len(h.levels) - 1
+	bounds := h.nodeBounds(top, 0, 0)
+	return &bounds
+}
+
+// Surface returns the material of the heightfield
+func (h *Heightfield) Surface() material.Material {
+	return h.Material
+}
+
+// Translate moves the heightfield by offset
+func (h *Heightfield) Translate(offset *math3d.Vector3) {
+	h.Position = *h.Position.Add(offset)
+}
+
+// AsMap returns a map representation of this shape. The samples are only
+// included if they weren't read from an image.
+func (h *Heightfield) AsMap() map[string]interface{} {
+	m := map[string]interface{}{"type": "heightfield", "position": h.Position.AsMap(), "size": h.Size.AsMap()}
+	if h.Name != "" {
+		m["name"] = h.Name
+	}
+	if h.Image != "" {
+		m["image"] = h.Image
+	} else {
+		heights := make([][]float64, 0, h.rows)
+		for j := 0; j < h.rows; j++ {
+			heights = append(heights, h.heights[j*h.columns:(j+1)*h.columns])
+		}
+		m["heights"] = heights
+	}
+	if h.Material != nil {
+		m["material"] = h.Material.AsMap()
+	}
+	return m
+}
+
+// HeightfieldFromMap returns a heightfield with the values in the map, or
+// an error if any of them is missing or has the wrong type. The samples
+// are either the rows in "heights" or the gray levels of the image file
+// in "image", of at least 2x2 samples.
+func HeightfieldFromMap(themap map[string]interface{}) (*Heightfield, error) {
+	d := decoder{m: themap}
+	position := d.vector("position")
+	size := d.vector("size")
+	name := d.text("name")
+	mat := d.material()
+	var h *Heightfield
+	if d.has("image") {
+		path := d.file("image")
+		if d.err != nil {
+			return nil, d.err
+		}
+		img, err := readImage(path)
+		if err != nil {
+			return nil, err
+		}
+		if b := img.Bounds(); b.Dx() < 2 || b.Dy() < 2 {
+			return nil, fmt.Errorf("image: %s must have at least 2x2 pixels", path)
+		}
+		h = HeightfieldFromImage(position, size, img)
+		h.Image = path
+	} else {
+		rows := d.list("heights", -1)
+		if d.err != nil {
+			return nil, d.err
+		}
+		samples := make([]float64, 0)
+		columns := 0
+		for j := range rows {
+			row, ok := rows[j].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("heights[%d]: must be a list of numbers", j)
+			}
+			if j == 0 {
+				columns = len(row)
+			} else if len(row) != columns {
+				return nil, fmt.Errorf("heights[%d]: every row must have the same number of samples", j)
+			}
+			for i := range row {
+				v, ok := row[i].(float64)
+				if !ok {
+					return nil, fmt.Errorf("heights[%d][%d]: must be a number", j, i)
+				}
+				samples = append(samples, v)
+			}
+		}
+		if columns < 2 || len(rows) < 2 {
+			return nil, errors.New("heights: must have at least 2x2 samples")
+		}
+		h = &Heightfield{Position: position, Size: size}
+		h.setHeights(columns, len(rows), samples)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	h.Name = name
+	h.Material = mat
+	return h, nil
+}
+
+func readImage(path string) (stdimg.Image, error) {
+	file, err := assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := stdimg.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode the heightfield image %s: %v", path, err)
+	}
+	return img, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
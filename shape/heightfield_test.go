@@ -0,0 +1,151 @@
+package shape
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func bumpyTerrain() *Heightfield {
+	return NewHeightfield(math3d.Vector3{X: -1, Y: 0, Z: -1}, math3d.Vector3{X: 2, Y: 0.5, Z: 2}, 33, 17,
+		func(x, z float64) float64 { return 0.5 + 0.5*math.Sin(9*x)*math.Cos(7*z) })
+}
+
+func TestFlatHeightfield(t *testing.T) {
+	h := NewHeightfield(math3d.Vector3{}, math3d.Vector3{X: 4, Y: 2, Z: 4}, 5, 5,
+		func(x, z float64) float64 { return 0.5 })
+	down := math3d.LightRay{Source: math3d.Vector3{X: 1.3, Y: 3, Z: 2.7}, Direction: math3d.Vector3{Y: -1}}
+	if d := h.Intersect(&down); math.Abs(d-2) > 1e-9 {
+		t.Errorf("The lightray should hit the terrain at D=2.0 but it hits at %.3f", d)
+	}
+	if n := h.NormalAt(&math3d.Vector3{X: 1.3, Y: 1, Z: 2.7}); !n.Equal(&math3d.UnitY) {
+		t.Error("The normal of a flat terrain should point up, not " + n.String())
+	}
+	outside := math3d.LightRay{Source: math3d.Vector3{X: 5, Y: 3, Z: 2}, Direction: math3d.Vector3{Y: -1}}
+	if h.Intersect(&outside) != math.MaxFloat64 {
+		t.Error("The lightray should miss the terrain")
+	}
+}
+
+func TestHeightfieldMatchesBruteForce(t *testing.T) {
+	h := bumpyTerrain()
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		lr := math3d.LightRay{
+			Source:    math3d.Vector3{X: rng.Float64()*4 - 2, Y: rng.Float64()*2 + 0.2, Z: rng.Float64()*4 - 2},
+			Direction: math3d.Vector3{X: rng.Float64()*2 - 1, Y: -rng.Float64(), Z: rng.Float64()*2 - 1}.NormalizedV()}
+		expected := math.MaxFloat64
+		for j := 0; j < h.rows-1; j++ {
+			for i := 0; i < h.columns-1; i++ {
+				expected = math.Min(expected, h.intersectCell(&lr, i, j))
+			}
+		}
+		if d := h.Intersect(&lr); d != expected {
+			t.Fatalf("Ray %d: the quadtree found a hit at %.6f, testing every cell found %.6f", n, d, expected)
+		}
+	}
+}
+
+func TestHeightfieldIsWatertight(t *testing.T) {
+	h := bumpyTerrain()
+	rng := rand.New(rand.NewSource(2))
+	for j := 1; j < h.rows-1; j++ {
+		for i := 1; i < h.columns-1; i++ {
+			// Aiming at the corner and at the middle of the edges of the
+			// cell, which it shares with the cells around it
+			a, b, c, d := h.vertex(i, j), h.vertex(i+1, j), h.vertex(i, j+1), h.vertex(i+1, j+1)
+			for _, target := range []math3d.Vector3{a, a.AddV(b).MultiplyV(0.5), a.AddV(c).MultiplyV(0.5), a.AddV(d).MultiplyV(0.5)} {
+				// Close to straight down, so the lightrays cross the
+				// terrain rather than graze it
+				source := target.AddV(math3d.Vector3{X: rng.Float64()*0.2 - 0.1, Y: 1, Z: rng.Float64()*0.2 - 0.1})
+				lr := math3d.LightRay{Source: source, Direction: target.SubtractV(source).NormalizedV()}
+				if h.Intersect(&lr) == math.MaxFloat64 {
+					t.Fatalf("The lightray towards %s went through the terrain", target.String())
+				}
+			}
+		}
+	}
+}
+
+func TestHeightfieldCullsBackfaces(t *testing.T) {
+	h := bumpyTerrain()
+	up := math3d.LightRay{Source: math3d.Vector3{X: 0.1, Y: -1, Z: 0.2}, Direction: math3d.UnitY}
+	down := math3d.LightRay{Source: math3d.Vector3{X: 0.1, Y: 2, Z: 0.2}, Direction: math3d.Vector3{Y: -1}}
+	if h.Intersect(&up) == math.MaxFloat64 || h.Intersect(&down) == math.MaxFloat64 {
+		t.Fatal("Both sides of the terrain should be hit")
+	}
+	h.CullBackfaces = true
+	if h.Intersect(&up) != math.MaxFloat64 {
+		t.Error("The lightray from below should go through the terrain")
+	}
+	if h.Intersect(&down) == math.MaxFloat64 {
+		t.Error("The lightray from above should still hit the terrain")
+	}
+}
+
+func BenchmarkRayHeightfieldIntersection(b *testing.B) {
+	h := bumpyTerrain()
+	lr := math3d.LightRay{Source: math3d.Vector3{X: -2, Y: 1, Z: -2}, Direction: math3d.Vector3{X: 1, Y: -0.4, Z: 1}.NormalizedV()}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Intersect(&lr)
+	}
+}
+
+func TestShadowOrigin(t *testing.T) {
+	flat := NewHeightfield(math3d.Vector3{}, math3d.Vector3{X: 4, Y: 2, Z: 4}, 5, 5,
+		func(x, z float64) float64 { return 0.5 })
+	point := math3d.Vector3{X: 1.3, Y: 1, Z: 2.7}
+	if origin := flat.ShadowOrigin(&point); !origin.Equal(&point) {
+		t.Errorf("Flat terrains should keep the point where it is, not move it to %s", origin.String())
+	}
+
+	// On a coarse dome the triangles are below the smooth surface, which
+	// is convex, so the points are lifted
+	dome := NewHeightfield(math3d.Vector3{X: -1, Z: -1}, math3d.Vector3{X: 2, Y: 1, Z: 2}, 7, 7,
+		func(x, z float64) float64 { return 1 - (x-0.5)*(x-0.5) - (z-0.5)*(z-0.5) })
+	down := math3d.LightRay{Source: math3d.Vector3{X: 0.4, Y: 3, Z: 0.1}, Direction: math3d.Vector3{Y: -1}}
+	hit := down.Source.AddV(down.Direction.MultiplyV(dome.Intersect(&down)))
+	if origin := dome.ShadowOrigin(&hit); origin.Y <= hit.Y {
+		t.Errorf("The point %s should be lifted off the triangle, it's moved to %s", hit.String(), origin.String())
+	}
+}
+
+func TestSurfaceAtIsOnTheTerrain(t *testing.T) {
+	h := bumpyTerrain()
+	rng := rand.New(rand.NewSource(2))
+	for n := 0; n < 500; n++ {
+		point, normal, ok := h.SurfaceAt(rng.Float64(), rng.Float64())
+		if !ok {
+			t.Fatal("Every point of texture space should be on the terrain")
+		}
+		down := math3d.LightRay{Source: point.AddV(math3d.Vector3{Y: 2}), Direction: math3d.Vector3{Y: -1}}
+		if d := h.Intersect(&down); math.Abs(d-2) > 1e-9 {
+			t.Fatalf("%s should be on the terrain, but a lightray from above hits it at D=%.6f", point.String(), d)
+		}
+		if expected := h.NormalAt(&point); !normal.Equal(&expected) {
+			t.Fatalf("The normal at %s should be %s, not %s", point.String(), expected.String(), normal.String())
+		}
+	}
+}
+
+func TestDownscaledHeightfieldKeepsItsShape(t *testing.T) {
+	h := NewHeightfield(math3d.Vector3{}, math3d.Vector3{X: 4, Y: 1, Z: 2}, 65, 33,
+		func(x, z float64) float64 { return x })
+	bounds, memory := *h.Bounds(), h.Memory()
+	h.Downscale(9, 5)
+	if columns, rows := h.Samples(); columns != 9 || rows != 5 {
+		t.Fatalf("The heightfield should have 9x5 samples, it has %dx%d", columns, rows)
+	}
+	if h.Memory() >= memory/16 {
+		t.Errorf("The downscaled heightfield should take about 1/50 of the %d bytes, it takes %d", memory, h.Memory())
+	}
+	if b := h.Bounds(); b.Min.X != bounds.Min.X || b.Max.X != bounds.Max.X || b.Min.Z != bounds.Min.Z || b.Max.Z != bounds.Max.Z {
+		t.Errorf("The heightfield should cover the same ground, it covers %v instead of %v", b, bounds)
+	}
+	if point, _, _ := h.SurfaceAt(0.5, 0.5); math.Abs(point.Y-0.5) > 1e-9 {
+		t.Errorf("The middle of the ramp should stay at height 0.5, it's at %f", point.Y)
+	}
+}
@@ -0,0 +1,19 @@
+package shape
+
+import "reflect"
+
+// Memory returns about how many bytes the shape takes, with the samples of
+// a heightfield, but without its material, which shapes share, nor the
+// buffers of the mesh of a MeshTriangle, which PackedMesh.Memory returns
+func Memory(sh Shape) uint64 {
+	bytes := uint64(reflect.Indirect(reflect.ValueOf(sh)).Type().Size())
+	if h, ok := sh.(*Heightfield); ok {
+		bytes += h.Memory()
+	}
+	return bytes
+}
+
+// Memory returns the number of bytes that the buffers of the mesh take
+func (m *PackedMesh) Memory() uint64 {
+	return uint64(len(m.Positions) + len(m.Normals) + len(m.UVs) + len(m.Colors) + len(m.Indices))
+}
@@ -0,0 +1,348 @@
+package shape
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// DefaultSmoothAngle is the angle in degrees up to which the faces of
+// meshes without normals are smoothed into each other
+const DefaultSmoothAngle = 60
+
+// MeshFromMap returns the triangles of the OBJ, STL or PLY file of the map,
+// told apart by their extensions, all of them with the name, the material
+// and the opacity map of the map. The normals of the faces that don't have
+// any are generated with the smoothing angle of the map. The vertices move
+// with the velocities of the map, if it has one for every vertex of the
+// file, in the same order. The mesh is refined with the number of levels
+// of Subdivide of the subdivision of the map, if it has one, when it's
+// loaded. The meshes of the scene cache hold the buffers of a PackedMesh
+// instead of a file, already subdivided. It returns an error if the file
+// can't be read or a value of the map is missing or has the wrong type.
+func MeshFromMap(themap map[string]interface{}) ([]Shape, error) {
+	d := decoder{m: themap}
+	if d.has("buffers") {
+		if d.has("file") {
+			return nil, errors.New("a mesh must have either a file or buffers")
+		}
+		m, err := PackedMeshFromMap(themap)
+		if err != nil {
+			return nil, err
+		}
+		return m.Triangles(), nil
+	}
+	path := d.file("file")
+	smoothAngle := d.optionalNumber("smoothangle", DefaultSmoothAngle)
+	if d.err == nil && !(smoothAngle >= 0 && smoothAngle <= 180) {
+		d.fail("smoothangle", "must be between 0 and 180 degrees")
+	}
+	var velocities []math3d.Vector3
+	if d.has("velocities") {
+		velocities = d.vectors("velocities", -1)
+	}
+	levels := d.optionalNumber("subdivision", 0)
+	if d.err == nil && (!(levels >= 0 && levels <= MaxSubdivision) || levels != math.Trunc(levels)) {
+		d.fail("subdivision", "must be a whole number of levels between 0 and %d", MaxSubdivision)
+	}
+	mat := d.material()
+	opacity := d.opacity()
+	name := d.text("name")
+	if d.err != nil {
+		return nil, d.err
+	}
+	triangles, err := loadMesh(path, smoothAngle, velocities)
+	if err != nil {
+		return nil, err
+	}
+	if levels > 0 {
+		triangles = Subdivide(triangles, int(levels), smoothAngle)
+	}
+	shapes := make([]Shape, 0, len(triangles))
+	for _, t := range triangles {
+		t.Name = name
+		t.Material = mat
+		t.Opacity = opacity
+		shapes = append(shapes, t)
+	}
+	return shapes, nil
+}
+
+// LoadOBJ reads the triangles of an OBJ file. See ReadOBJ.
+func LoadOBJ(path string, smoothAngle float64) ([]*Triangle, error) {
+	file, err := assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadOBJ(file, smoothAngle)
+}
+
+// loadMesh reads the triangles of an OBJ, STL or PLY file, by its
+// extension, whose vertices move with the velocities, if they aren't nil.
+// See readOBJ, readSTL and readPLY.
+func loadMesh(path string, smoothAngle float64, velocities []math3d.Vector3) ([]*Triangle, error) {
+	file, err := assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".stl":
+		return readSTL(file, smoothAngle, velocities)
+	case ".ply":
+		return readPLY(file, smoothAngle, velocities)
+	}
+	return readOBJ(file, smoothAngle, velocities)
+}
+
+// corner is a corner of a face of an OBJ file, as the indices of its
+// vertex, its texture coordinates and its normal, which are -1 if it
+// doesn't have them
+type corner struct {
+	vertex, uv, normal int
+}
+
+// ReadOBJ reads the faces of an OBJ file as triangles, splitting the ones
+// with more corners into fans. Only the vertices, the texture
+// coordinates, the normals and the faces are read; groups and materials
+// are ignored.
+//
+// The faces that have normals at all their corners are smoothed with
+// them. The normals of the others are generated at every vertex from the
+// faces around it, except across the edges where the faces are more than
+// smoothAngle degrees apart, so curved surfaces look smooth while the
+// sharper edges stay sharp. A smoothAngle of 0 shades them flat.
+func ReadOBJ(r io.Reader, smoothAngle float64) ([]*Triangle, error) {
+	return readOBJ(r, smoothAngle, nil)
+}
+
+// readOBJ reads the faces of an OBJ file as ReadOBJ does. If velocities
+// isn't nil, it holds the velocities of the vertices of the file, and the
+// triangles move with them.
+func readOBJ(r io.Reader, smoothAngle float64, velocities []math3d.Vector3) ([]*Triangle, error) {
+	var vertices, normals []math3d.Vector3
+	var uvs []math3d.Vector2
+	var faces [][3]corner
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "v", "vn":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: %s needs 3 coordinates", line, fields[0])
+			}
+			var coordinates [3]float64
+			for i := range coordinates {
+				n, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+					return nil, fmt.Errorf("line %d: %q is not a valid number", line, fields[i+1])
+				}
+				coordinates[i] = n
+			}
+			v := math3d.Vector3{X: coordinates[0], Y: coordinates[1], Z: coordinates[2]}
+			if fields[0] == "v" {
+				vertices = append(vertices, v)
+			} else {
+				if v.AbsSquared() == 0 {
+					return nil, fmt.Errorf("line %d: normals can't be zero", line)
+				}
+				normals = append(normals, v.NormalizedV())
+			}
+		case "vt":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: vt needs 2 coordinates", line)
+			}
+			var uv [2]float64
+			for i := range uv {
+				n, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+					return nil, fmt.Errorf("line %d: %q is not a valid number", line, fields[i+1])
+				}
+				uv[i] = n
+			}
+			uvs = append(uvs, math3d.Vector2{X: uv[0], Y: uv[1]})
+		case "f":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: a face needs at least 3 corners", line)
+			}
+			corners := make([]corner, 0, len(fields)-1)
+			for _, f := range fields[1:] {
+				c, err := parseCorner(f, len(vertices), len(uvs), len(normals))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+				corners = append(corners, c)
+			}
+			for i := 1; i+1 < len(corners); i++ {
+				faces = append(faces, [3]corner{corners[0], corners[i], corners[i+1]})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if velocities != nil && len(velocities) != len(vertices) {
+		return nil, fmt.Errorf("there are %d velocities for %d vertices", len(velocities), len(vertices))
+	}
+	return triangulate(vertices, velocities, uvs, normals, nil, faces, smoothAngle), nil
+}
+
+// parseCorner returns the corner of a face written as v, v/vt, v//vn or
+// v/vt/vn, whose indices start at 1 or count back from the last one read
+// if they are negative
+func parseCorner(field string, vertices, uvs, normals int) (corner, error) {
+	parts := strings.Split(field, "/")
+	index := func(s string, count int) (int, error) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a valid index", s)
+		}
+		if i < 0 {
+			i += count + 1
+		}
+		if i < 1 || i > count {
+			return 0, fmt.Errorf("index %s is out of range", s)
+		}
+		return i - 1, nil
+	}
+	c := corner{uv: -1, normal: -1}
+	var err error
+	if c.vertex, err = index(parts[0], vertices); err != nil {
+		return c, err
+	}
+	if len(parts) >= 2 && parts[1] != "" {
+		if c.uv, err = index(parts[1], uvs); err != nil {
+			return c, err
+		}
+	}
+	if len(parts) == 3 && parts[2] != "" {
+		if c.normal, err = index(parts[2], normals); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// triangulate returns the triangles of the faces, leaving out the ones
+// with no area, with the normals of the faces or generated ones, and the
+// velocities and the colors of the vertices if there are any
+func triangulate(vertices, velocities []math3d.Vector3, uvs []math3d.Vector2, normals []math3d.Vector3, colors []image.Color, faces [][3]corner, smoothAngle float64) []*Triangle {
+	// areaNormals holds the normals of the faces scaled by twice their
+	// areas
+	areaNormals := make([]math3d.Vector3, len(faces))
+	for i, f := range faces {
+		a, b, c := vertices[f[0].vertex], vertices[f[1].vertex], vertices[f[2].vertex]
+		areaNormals[i] = b.SubtractV(a).CrossV(c.SubtractV(a))
+	}
+	var smoothed map[[2]int]math3d.Vector3
+	if smoothAngle > 0 {
+		smoothed = smoothNormals(faces, areaNormals, math.Cos(smoothAngle*math.Pi/180))
+	}
+	triangles := make([]*Triangle, 0, len(faces))
+	for i, f := range faces {
+		if areaNormals[i].AbsSquared() == 0 {
+			continue
+		}
+		t := &Triangle{}
+		given := f[0].normal >= 0 && f[1].normal >= 0 && f[2].normal >= 0
+		textured := f[0].uv >= 0 && f[1].uv >= 0 && f[2].uv >= 0
+		for j, c := range f {
+			t.Vertices[j] = vertices[c.vertex]
+			if velocities != nil {
+				t.Velocities[j] = velocities[c.vertex]
+			}
+			if colors != nil {
+				t.Colors[j] = colors[c.vertex]
+			}
+			if textured {
+				t.UVs[j] = uvs[c.uv]
+			}
+			if given {
+				t.Normals[j] = normals[c.normal]
+			} else if smoothed != nil {
+				t.Normals[j] = smoothed[[2]int{i, c.vertex}]
+			}
+		}
+		triangles = append(triangles, t)
+	}
+	return triangles
+}
+
+// smoothNormals returns the normal of every face at every vertex of it,
+// by the indices of both. The faces around a vertex that meet at an edge
+// whose faces are at most the angle of the cosine apart are smooth, and
+// the normal of a face at the vertex averages those of the faces that it
+// reaches crossing smooth edges, weighted by their areas.
+func smoothNormals(faces [][3]corner, areaNormals []math3d.Vector3, cosine float64) map[[2]int]math3d.Vector3 {
+	byVertex := make(map[int][]int)
+	for i, f := range faces {
+		if areaNormals[i].AbsSquared() == 0 {
+			continue
+		}
+		for _, c := range f {
+			byVertex[c.vertex] = append(byVertex[c.vertex], i)
+		}
+	}
+	shares := func(f, g [3]corner, vertex int) bool {
+		// Whether f and g share an edge from the vertex
+		for _, a := range f {
+			if a.vertex == vertex {
+				continue
+			}
+			for _, b := range g {
+				if a.vertex == b.vertex {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	smoothed := make(map[[2]int]math3d.Vector3)
+	for vertex, around := range byVertex {
+		// Grouping the faces around the vertex that smooth edges join
+		group := make([]int, len(around))
+		for i := range group {
+			group[i] = i
+		}
+		var root func(i int) int
+		root = func(i int) int {
+			for group[i] != i {
+				group[i] = group[group[i]]
+				i = group[i]
+			}
+			return i
+		}
+		for i, f := range around {
+			for j := i + 1; j < len(around); j++ {
+				g := around[j]
+				if areaNormals[f].NormalizedV().DotV(areaNormals[g].NormalizedV()) >= cosine && shares(faces[f], faces[g], vertex) {
+					group[root(i)] = root(j)
+				}
+			}
+		}
+		sums := make(map[int]math3d.Vector3)
+		for i, f := range around {
+			r := root(i)
+			sum := sums[r]
+			sum.AddInPlace(areaNormals[f])
+			sums[r] = sum
+		}
+		for i, f := range around {
+			smoothed[[2]int{f, vertex}] = sums[root(i)].NormalizedV()
+		}
+	}
+	return smoothed
+}
@@ -0,0 +1,119 @@
+package shape
+
+import (
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestReadOBJ(t *testing.T) {
+	obj := `# A quad with normals and a triangle without them
+v 0 0 0
+v 1 0 0
+v 1 0 1
+v 0 0 1
+vn 0 2 0
+vt 0 0
+f 1//1 4//1 3//1 2//1
+f -4/1 -1 -3
+`
+	triangles, err := ReadOBJ(strings.NewReader(obj), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triangles) != 3 {
+		t.Fatalf("The quad should be split in 2 triangles, and there are %d triangles in all", len(triangles))
+	}
+	if n := triangles[0].Normals[1]; !n.Equal(&math3d.UnitY) {
+		t.Error("The normals of the file should be used, normalized, not " + n.String())
+	}
+	if triangles[2].smooth() {
+		t.Error("A smoothing angle of 0 should shade the faces without normals flat")
+	}
+	if triangles[0].UVs != [3]math3d.Vector2{} {
+		t.Error("Faces without texture coordinates at every corner should have none")
+	}
+	textured, err := ReadOBJ(strings.NewReader("v 0 0 0\nv 1 0 0\nv 0 0 1\nvt 0.5 0\nvt 1 1\nf 1/1 3/2 2/1\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uv := textured[0].UVs[1]; uv != (math3d.Vector2{X: 1, Y: 1}) {
+		t.Error("The texture coordinates of the file should be used, not " + uv.String())
+	}
+	moving, err := readOBJ(strings.NewReader(obj), 0, []math3d.Vector3{{X: 1}, {X: 2}, {X: 3}, {X: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := moving[0].Velocities; v != [3]math3d.Vector3{{X: 1}, {X: 4}, {X: 3}} {
+		t.Errorf("The triangles should move with the velocities of their vertices, not %v", v)
+	}
+	if _, err := readOBJ(strings.NewReader(obj), 0, []math3d.Vector3{{X: 1}}); err == nil {
+		t.Error("There should be a velocity for every vertex")
+	}
+	for _, bad := range []string{"v 0 0\n", "v 0 0 0\nf 1 1 2\n", "v 0 0 x\n", "f 1 2\n", "vt 0\n", "v 0 0 0\nf 1/2 1 1\n"} {
+		if _, err := ReadOBJ(strings.NewReader(bad), 0); err == nil {
+			t.Errorf("%q should be an error", bad)
+		}
+	}
+}
+
+func TestOBJNormalsSmoothLowPolySpheres(t *testing.T) {
+	triangles, err := LoadOBJ("../scene-examples/icosphere.obj", DefaultSmoothAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	center := math3d.Vector3{Y: -0.1, Z: 1}
+	for _, tri := range triangles {
+		for i, v := range tri.Vertices {
+			// The faces around every vertex are symmetric about the
+			// direction from the center, so it's the generated normal
+			radial := v.SubtractV(center).NormalizedV()
+			if tri.Normals[i].DotV(radial) < 1-1e-3 {
+				t.Fatalf("The normal at %s should point away from the center, not along %s", v.String(), tri.Normals[i].String())
+			}
+		}
+	}
+	// The faces of an icosahedron are 42 degrees apart
+	triangles, _ = LoadOBJ("../scene-examples/icosphere.obj", 30)
+	for _, tri := range triangles {
+		for _, n := range tri.Normals {
+			if flat := tri.geometricNormal(); math.Abs(n.DotV(flat)-1) > 1e-12 {
+				t.Fatal("The edges sharper than the angle should stay sharp")
+			}
+		}
+	}
+}
+
+// checkLoaded checks that the triangles a loader accepted are safe to
+// trace and shade, whatever file they were read from
+func checkLoaded(t *testing.T, triangles []*Triangle) {
+	ray := &math3d.LightRay{Source: math3d.Vector3{Z: -1}, Direction: math3d.Vector3{Z: 1}}
+	for _, tri := range triangles {
+		if distance := tri.Intersect(ray); distance < math.MaxFloat64 {
+			point := ray.Source.AddV(ray.Direction.MultiplyV(distance))
+			tri.NormalAt(&point)
+			tri.TextureAt(&point)
+			tri.ColorAt(&point)
+		}
+	}
+	if len(triangles) > 0 {
+		PackTriangles(triangles).Triangles()
+	}
+}
+
+func FuzzReadOBJ(f *testing.F) {
+	f.Add("v 0 0 0\nv 1 0 0\nv 0 1 0\nvn 0 0 -1\nvt 0 0\nf 1/1/1 2/1/1 3/1/1\n")
+	f.Add("v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\nf -4 -3 -2 -1\n")
+	if obj, err := ioutil.ReadFile("../scene-examples/icosphere.obj"); err == nil {
+		f.Add(string(obj))
+	}
+	f.Fuzz(func(t *testing.T, obj string) {
+		triangles, err := ReadOBJ(strings.NewReader(obj), DefaultSmoothAngle)
+		if err == nil {
+			checkLoaded(t, triangles)
+		}
+	})
+}
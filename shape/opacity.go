@@ -0,0 +1,123 @@
+package shape
+
+import (
+	"fmt"
+	stdimg "image"
+	"image/color"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// DefaultCutoff is the opacity below which surfaces with an opacity map
+// have holes, unless they set another one
+const DefaultCutoff = 0.5
+
+// Opacity is a map of how opaque a surface is over texture space, read
+// from an image. The surface has holes where the opacity is below the
+// cutoff, such as between the leaves of a leaf card or the wires of a
+// fence. The lightrays go through the holes, so the shadows have them
+// too.
+type Opacity struct {
+	// Image is the path of the image. The opacity is its alpha channel,
+	// or its level of grey if it's a grey image. Either is how much of
+	// the texel is covered rather than a color, so it's taken as it's
+	// stored, without decoding it from sRGB.
+	Image  string
+	Cutoff float64
+	width  int
+	height int
+	opaque []bool
+}
+
+// NewOpacity returns the opacity map of the image, whose texels are opaque
+// where their opacity is at least cutoff
+func NewOpacity(img stdimg.Image, cutoff float64) *Opacity {
+	bounds := img.Bounds()
+	o := &Opacity{Cutoff: cutoff, width: bounds.Dx(), height: bounds.Dy()}
+	o.opaque = make([]bool, 0, o.width*o.height)
+	grey := img.ColorModel() == color.GrayModel || img.ColorModel() == color.Gray16Model
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, _, _, a := img.At(x, y).RGBA()
+			if grey {
+				a = r
+			}
+			o.opaque = append(o.opaque, float64(a)/0xffff >= cutoff)
+		}
+	}
+	return o
+}
+
+// LoadOpacity reads the opacity map of the image file. See NewOpacity.
+func LoadOpacity(path string, cutoff float64) (*Opacity, error) {
+	file, err := assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := stdimg.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode the opacity image %s: %v", path, err)
+	}
+	o := NewOpacity(img, cutoff)
+	o.Image = path
+	return o, nil
+}
+
+// Opaque returns whether the surface is there at the texture coordinates
+// u, v, which repeat outside the unit square. The texel nearest to them
+// decides, so the edges of the holes are as sharp as the image. Like the
+// images the renders are saved to, v grows upwards from the last row.
+func (o *Opacity) Opaque(u, v float64) bool {
+	u, v = u-math.Floor(u), v-math.Floor(v)
+	x := minInt(int(u*float64(o.width)), o.width-1)
+	y := minInt(int((1-v)*float64(o.height)), o.height-1)
+	return o.opaque[y*o.width+x]
+}
+
+// opaqueAt returns whether the textured shape is there where the lightray
+// hits it at distance, which it is everywhere without an opacity map
+func opaqueAt(s Textured, o *Opacity, lr *math3d.LightRay, distance float64) bool {
+	if o == nil {
+		return true
+	}
+	point := lr.Source.AddV(lr.Direction.MultiplyV(distance))
+	u, v := s.TextureAt(&point)
+	return o.Opaque(u, v)
+}
+
+// opacity returns the opacity map of the image file in "opacity" with the
+// cutoff in "cutoff", or nil if the map doesn't have one
+func (d *decoder) opacity() *Opacity {
+	if !d.has("opacity") {
+		return nil
+	}
+	path := d.file("opacity")
+	cutoff := d.optionalNumber("cutoff", DefaultCutoff)
+	if d.err != nil {
+		return nil
+	}
+	if !(cutoff >= 0 && cutoff <= 1) {
+		d.fail("cutoff", "must be between 0 and 1")
+		return nil
+	}
+	o, err := LoadOpacity(path, cutoff)
+	if err != nil {
+		d.fail("opacity", "%v", err)
+	}
+	return o
+}
+
+// addOpacityToMap adds the opacity map to the map of a shape, if there is
+// one
+func addOpacityToMap(m map[string]interface{}, o *Opacity) {
+	if o == nil {
+		return
+	}
+	m["opacity"] = o.Image
+	if o.Cutoff != DefaultCutoff {
+		m["cutoff"] = o.Cutoff
+	}
+}
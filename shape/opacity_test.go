@@ -0,0 +1,71 @@
+package shape
+
+import (
+	stdimg "image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// halves returns an opacity map that is opaque on its left half only
+func halves() *Opacity {
+	img := stdimg.NewNRGBA(stdimg.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+			img.Set(x+2, y, color.NRGBA{R: 255, A: 60})
+		}
+	}
+	return NewOpacity(img, DefaultCutoff)
+}
+
+func TestOpacity(t *testing.T) {
+	o := halves()
+	for _, c := range []struct {
+		u, v   float64
+		opaque bool
+	}{{0.1, 0.5, true}, {0.49, 0.9, true}, {0.51, 0.1, false}, {1, 0, true}, {-0.2, 0.5, false}} {
+		if o.Opaque(c.u, c.v) != c.opaque {
+			t.Errorf("The opacity at %.2f, %.2f should be %v", c.u, c.v, c.opaque)
+		}
+	}
+	grey := stdimg.NewGray(stdimg.Rect(0, 0, 1, 2))
+	grey.SetGray(0, 0, color.Gray{Y: 255})
+	o = NewOpacity(grey, 0.3)
+	if !o.Opaque(0.5, 0.9) || o.Opaque(0.5, 0.1) {
+		t.Error("The opacity of grey images is their level of grey, and the first row is the top one")
+	}
+}
+
+func TestCutoutTriangle(t *testing.T) {
+	tri := &Triangle{Vertices: [3]math3d.Vector3{{X: 0, Z: 0}, {X: 1, Z: 0}, {X: 0, Z: 1}}, Opacity: halves()}
+	for _, c := range []struct {
+		x      float64
+		opaque bool
+	}{{0.2, true}, {0.7, false}} {
+		down := math3d.LightRay{Source: math3d.Vector3{X: c.x, Y: 1, Z: 0.1}, Direction: math3d.Vector3{Y: -1}}
+		if tri.Opaque(&down, tri.Intersect(&down)) != c.opaque {
+			t.Errorf("The triangle at x=%.1f should be opaque: %v", c.x, c.opaque)
+		}
+	}
+	// Texture coordinates of its own turn the map around
+	tri.UVs = [3]math3d.Vector2{{X: 1}, {X: 0}, {X: 1, Y: 1}}
+	if u, v := tri.TextureAt(&math3d.Vector3{X: 0.25, Z: 0.5}); math.Abs(u-0.75) > 1e-12 || math.Abs(v-0.5) > 1e-12 {
+		t.Errorf("The texture coordinates should be interpolated, they are %.3f, %.3f", u, v)
+	}
+	if m := tri.AsMap(); m["uvs"] == nil || m["cutoff"] != nil {
+		t.Errorf("The map should have the texture coordinates and no default cutoff: %v", m)
+	}
+}
+
+func TestSphereTextureIsTheInverseOfItsSurface(t *testing.T) {
+	s := &Sphere{Position: math3d.Vector3{X: 1, Y: 2, Z: 3}, Radius: 2}
+	for _, uv := range [][2]float64{{0.1, 0.2}, {0.6, 0.5}, {0.95, 0.9}} {
+		point, _, _ := s.SurfaceAt(uv[0], uv[1])
+		if u, v := s.TextureAt(&point); math.Abs(u-uv[0]) > 1e-9 || math.Abs(v-uv[1]) > 1e-9 {
+			t.Errorf("The point at %.2f, %.2f is at %.3f, %.3f", uv[0], uv[1], u, v)
+		}
+	}
+}
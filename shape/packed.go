@@ -0,0 +1,346 @@
+package shape
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// PackedMesh is a mesh whose vertices and triangles are packed in byte
+// buffers, as the scene cache stores them, instead of held by a Triangle
+// each. The buffers can be mapped from the cache file, so that scenes
+// larger than the memory render: the pages of the meshes are read as the
+// rays reach them, and dropped again when memory runs short. Its
+// triangles are the MeshTriangles that Triangles returns.
+type PackedMesh struct {
+	// Positions holds the X, Y and Z of every vertex, and Normals, UVs and
+	// Colors their normals, texture coordinates and colors, as little
+	// endian 64 bit floats. Normals, UVs and Colors are empty if no
+	// triangle has them.
+	Positions, Normals, UVs, Colors []byte
+	// Indices holds the indices of the three vertices of every triangle,
+	// as little endian 32 bit integers
+	Indices []byte
+	Name    string
+	// Material is the material of the surface, the default one if nil
+	Material material.Material
+	// Opacity cuts holes in the surface, which has none if it's nil
+	Opacity *Opacity
+	// CullBackfaces makes the lightrays that hit the back faces go through
+	CullBackfaces bool
+}
+
+// MeshTriangle is a triangle of a packed mesh. It reads its vertices from
+// the buffers of the mesh whenever they're needed, and it can't be moved.
+type MeshTriangle struct {
+	Mesh  *PackedMesh
+	Index int
+}
+
+// PackTriangles packs the triangles in a mesh, with the name, the material
+// and the opacity map of the first one. The vertices that the triangles
+// share are packed once. The velocities and the settings of the renders,
+// such as CullBackfaces, aren't packed.
+func PackTriangles(triangles []*Triangle) *PackedMesh {
+	m := &PackedMesh{Indices: make([]byte, 0, 12*len(triangles))}
+	if len(triangles) > 0 {
+		m.Name, m.Material, m.Opacity = triangles[0].Name, triangles[0].Material, triangles[0].Opacity
+	}
+	smooth, textured, colored := false, false, false
+	for _, t := range triangles {
+		smooth = smooth || t.smooth()
+		textured = textured || t.UVs != [3]math3d.Vector2{}
+		colored = colored || t.colored()
+	}
+	// The vertices are told apart by their bits, so that -0 stays -0
+	type vertex [11]uint64
+	indices := make(map[vertex]uint32)
+	for _, t := range triangles {
+		for i := range t.Vertices {
+			p, n, uv, c := t.Vertices[i], t.Normals[i], t.UVs[i], t.Colors[i]
+			values := [11]float64{p.X, p.Y, p.Z, n.X, n.Y, n.Z, uv.X, uv.Y, c.R, c.G, c.B}
+			var key vertex
+			for j, v := range values {
+				key[j] = math.Float64bits(v)
+			}
+			index, ok := indices[key]
+			if !ok {
+				index = uint32(len(indices))
+				indices[key] = index
+				m.Positions = appendFloats(m.Positions, values[0:3]...)
+				if smooth {
+					m.Normals = appendFloats(m.Normals, values[3:6]...)
+				}
+				if textured {
+					m.UVs = appendFloats(m.UVs, values[6:8]...)
+				}
+				if colored {
+					m.Colors = appendFloats(m.Colors, values[8:11]...)
+				}
+			}
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], index)
+			m.Indices = append(m.Indices, b[:]...)
+		}
+	}
+	return m
+}
+
+// appendFloats appends the little endian bytes of the values to b
+func appendFloats(b []byte, values ...float64) []byte {
+	var bytes [8]byte
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(bytes[:], math.Float64bits(v))
+		b = append(b, bytes[:]...)
+	}
+	return b
+}
+
+// Len returns the number of triangles of the mesh
+func (m *PackedMesh) Len() int {
+	return len(m.Indices) / 12
+}
+
+// Triangles returns the triangles of the mesh
+func (m *PackedMesh) Triangles() []Shape {
+	shapes := make([]Shape, m.Len())
+	for i := range shapes {
+		shapes[i] = &MeshTriangle{Mesh: m, Index: i}
+	}
+	return shapes
+}
+
+// vertexCount returns the number of vertices of the mesh
+func (m *PackedMesh) vertexCount() int {
+	return len(m.Positions) / 24
+}
+
+// index returns the index of the vertex i of the triangle
+func (m *PackedMesh) index(triangle, i int) int {
+	return int(binary.LittleEndian.Uint32(m.Indices[12*triangle+4*i:]))
+}
+
+// float returns the float i of the buffer
+func float(buffer []byte, i int) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(buffer[8*i:]))
+}
+
+// position returns the position of the vertex
+func (m *PackedMesh) position(vertex int) math3d.Vector3 {
+	return math3d.Vector3{X: float(m.Positions, 3*vertex), Y: float(m.Positions, 3*vertex+1), Z: float(m.Positions, 3*vertex+2)}
+}
+
+// AsMap returns a map representation of the mesh, whose buffers only the
+// scene cache can hold
+func (m *PackedMesh) AsMap() map[string]interface{} {
+	buffers := map[string]interface{}{"positions": m.Positions, "indices": m.Indices}
+	if len(m.Normals) > 0 {
+		buffers["normals"] = m.Normals
+	}
+	if len(m.UVs) > 0 {
+		buffers["uvs"] = m.UVs
+	}
+	if len(m.Colors) > 0 {
+		buffers["colors"] = m.Colors
+	}
+	mesh := map[string]interface{}{"type": "mesh", "buffers": buffers}
+	if m.Name != "" {
+		mesh["name"] = m.Name
+	}
+	if m.Material != nil {
+		mesh["material"] = m.Material.AsMap()
+	}
+	addOpacityToMap(mesh, m.Opacity)
+	return mesh
+}
+
+// PackedMeshFromMap returns the mesh with the buffers of the map, which
+// must be byte strings, and its name, material and opacity map, or an
+// error if the buffers don't make a mesh or a value has the wrong type
+func PackedMeshFromMap(themap map[string]interface{}) (*PackedMesh, error) {
+	buffers, ok := themap["buffers"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("buffers: must be an object")
+	}
+	for name, value := range buffers {
+		if _, ok := value.([]byte); !ok {
+			return nil, fmt.Errorf("buffers.%s: must be a byte string, which only the scene cache holds", name)
+		}
+	}
+	buffer := func(name string) []byte {
+		b, _ := buffers[name].([]byte)
+		return b
+	}
+	m := &PackedMesh{Positions: buffer("positions"), Normals: buffer("normals"), UVs: buffer("uvs"), Colors: buffer("colors"), Indices: buffer("indices")}
+	vertices := m.vertexCount()
+	switch {
+	case len(m.Positions)%24 != 0:
+		return nil, errors.New("the positions of a mesh must be 3 floats of 8 bytes for every vertex")
+	case len(m.Normals) != 0 && len(m.Normals) != len(m.Positions):
+		return nil, errors.New("a mesh with normals must have one for every vertex")
+	case len(m.UVs) != 0 && len(m.UVs) != 16*vertices:
+		return nil, errors.New("a mesh with texture coordinates must have them for every vertex")
+	case len(m.Colors) != 0 && len(m.Colors) != len(m.Positions):
+		return nil, errors.New("a mesh with colors must have one for every vertex")
+	case len(m.Indices)%12 != 0:
+		return nil, errors.New("the indices of a mesh must be 3 integers of 4 bytes for every triangle")
+	}
+	for i := 0; i < len(m.Indices); i += 4 {
+		if int(binary.LittleEndian.Uint32(m.Indices[i:])) >= vertices {
+			return nil, errors.New("the indices of a mesh must be of its vertices")
+		}
+	}
+	d := decoder{m: themap}
+	m.Name = d.text("name")
+	m.Material = d.material()
+	m.Opacity = d.opacity()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
+
+// PackMeshes returns the maps of the shapes like AsMap, except for the
+// runs of triangles that don't move and share a name, a material and an
+// opacity map, such as the ones of a mesh file, which are packed in a mesh
+// each
+func PackMeshes(shapes []Shape) []map[string]interface{} {
+	retval := make([]map[string]interface{}, 0, len(shapes))
+	var run []*Triangle
+	flush := func() {
+		if len(run) > 0 {
+			retval = append(retval, PackTriangles(run).AsMap())
+			run = run[:0]
+		}
+	}
+	for _, s := range shapes {
+		var t *Triangle
+		switch s := s.(type) {
+		case *Triangle:
+			if !s.moving() {
+				t = s
+			}
+		case *MeshTriangle:
+			t = s.Triangle()
+		}
+		if t == nil {
+			flush()
+			retval = append(retval, s.AsMap())
+			continue
+		}
+		if len(run) > 0 && (t.Name != run[0].Name || t.Material != run[0].Material || t.Opacity != run[0].Opacity) {
+			flush()
+		}
+		run = append(run, t)
+	}
+	flush()
+	return retval
+}
+
+// Triangle returns the triangle as a Triangle of its own
+func (t *MeshTriangle) Triangle() *Triangle {
+	triangle := t.triangle()
+	return &triangle
+}
+
+// triangle returns the triangle as a Triangle of its own
+func (t *MeshTriangle) triangle() Triangle {
+	m := t.Mesh
+	triangle := Triangle{Name: m.Name, Material: m.Material, Opacity: m.Opacity, CullBackfaces: m.CullBackfaces}
+	for i := range triangle.Vertices {
+		v := m.index(t.Index, i)
+		triangle.Vertices[i] = m.position(v)
+		if len(m.Normals) > 0 {
+			triangle.Normals[i] = math3d.Vector3{X: float(m.Normals, 3*v), Y: float(m.Normals, 3*v+1), Z: float(m.Normals, 3*v+2)}
+		}
+		if len(m.UVs) > 0 {
+			triangle.UVs[i] = math3d.Vector2{X: float(m.UVs, 2*v), Y: float(m.UVs, 2*v+1)}
+		}
+		if len(m.Colors) > 0 {
+			triangle.Colors[i] = image.Color{R: float(m.Colors, 3*v), G: float(m.Colors, 3*v+1), B: float(m.Colors, 3*v+2)}
+		}
+	}
+	return triangle
+}
+
+// Intersect returns the distance at which the lightray intersects the
+// triangle
+func (t *MeshTriangle) Intersect(lr *math3d.LightRay) float64 {
+	m := t.Mesh
+	a, b, c := m.position(m.index(t.Index, 0)), m.position(m.index(t.Index, 1)), m.position(m.index(t.Index, 2))
+	return intersectTriangle(lr, &a, &b, &c, m.CullBackfaces)
+}
+
+// NormalAt returns the normal vector of a point of the triangle
+func (t *MeshTriangle) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	triangle := t.triangle()
+	return triangle.NormalAt(point)
+}
+
+// TextureAt returns the texture coordinates of a point of the triangle
+func (t *MeshTriangle) TextureAt(point *math3d.Vector3) (u, v float64) {
+	triangle := t.triangle()
+	return triangle.TextureAt(point)
+}
+
+// ColorAt returns the color of a point of the triangle, if the mesh has
+// colors
+func (t *MeshTriangle) ColorAt(point *math3d.Vector3) (image.Color, bool) {
+	if len(t.Mesh.Colors) == 0 {
+		return image.Color{}, false
+	}
+	triangle := t.triangle()
+	return triangle.ColorAt(point)
+}
+
+// Opaque returns whether the triangle is there where the lightray hits it
+// at distance, rather than a hole of the opacity map of the mesh
+func (t *MeshTriangle) Opaque(lr *math3d.LightRay, distance float64) bool {
+	return opaqueAt(t, t.Mesh.Opacity, lr, distance)
+}
+
+// ShadowOrigin returns the point from which the rays towards the lights
+// leave the triangle at point
+func (t *MeshTriangle) ShadowOrigin(point *math3d.Vector3) math3d.Vector3 {
+	triangle := t.triangle()
+	return triangle.ShadowOrigin(point)
+}
+
+// Bounds returns the bounding box of the triangle
+func (t *MeshTriangle) Bounds() *math3d.AABB {
+	m := t.Mesh
+	bounds := math3d.EmptyAABB()
+	for i := 0; i < 3; i++ {
+		p := m.position(m.index(t.Index, i))
+		bounds = bounds.Expand(&p)
+	}
+	return bounds
+}
+
+// ClippedBounds returns the bounding box of the part of the triangle inside
+// the box, as the one of Triangle does
+func (t *MeshTriangle) ClippedBounds(box *math3d.AABB) *math3d.AABB {
+	m := t.Mesh
+	var vertices [3]math3d.Vector3
+	for i := range vertices {
+		vertices[i] = m.position(m.index(t.Index, i))
+	}
+	return clippedBounds(vertices[:], box)
+}
+
+// Surface returns the material of the mesh
+func (t *MeshTriangle) Surface() material.Material {
+	return t.Mesh.Material
+}
+
+// AsMap returns a map representation of the triangle, as a triangle of its
+// own
+func (t *MeshTriangle) AsMap() map[string]interface{} {
+	triangle := t.triangle()
+	return triangle.AsMap()
+}
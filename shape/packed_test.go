@@ -0,0 +1,102 @@
+package shape
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/cbor"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestPackedMeshesGiveTheSameTriangles(t *testing.T) {
+	obj := "v 0 0 0\nv 1 0 0\nv 1 0 1\nv 0 0 1\nv 0 -1 0\nvt 0 0\nvt 1 1\nf 1/1 4/2 3/1 2/2\nf 1 5 2\n"
+	triangles, err := ReadOBJ(strings.NewReader(obj), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat := material.FromMap(map[string]interface{}{"type": "lambertian", "albedo": map[string]interface{}{"r": 0.5, "g": 0.25, "b": 1.0}})
+	for _, tri := range triangles {
+		tri.Name, tri.Material = "quad", mat
+	}
+	triangles[0].Normals = [3]math3d.Vector3{math3d.UnitY, math3d.UnitY, math3d.UnitY}
+	mesh := PackTriangles(triangles)
+	// The smooth half of the quad shares no vertex with the flat one, which
+	// shares two with the triangle without texture coordinates
+	if mesh.Len() != 3 || mesh.vertexCount() != 8 {
+		t.Errorf("The triangles should share the vertices they have in common, there are %d triangles and %d vertices", mesh.Len(), mesh.vertexCount())
+	}
+	// The mesh goes through the scene cache as a map of byte strings
+	encoded, err := cbor.Marshal(mesh.AsMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := cbor.UnmarshalShared(encoded)
+	packed, err := MeshFromMap(decoded.(map[string]interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed) != 3 {
+		t.Fatalf("The mesh should have 3 triangles, not %d", len(packed))
+	}
+	down := math3d.LightRay{Source: math3d.Vector3{X: 0.3, Y: 1, Z: 0.6}, Direction: math3d.Vector3{Y: -1}}
+	for i, sh := range packed {
+		want, _ := json.Marshal(triangles[i].AsMap())
+		got, _ := json.Marshal(sh.AsMap())
+		if string(got) != string(want) {
+			t.Errorf("Triangle %d should be %s, not %s", i, want, got)
+		}
+		if sh.Intersect(&down) != triangles[i].Intersect(&down) || *sh.Bounds() != *triangles[i].Bounds() {
+			t.Errorf("Triangle %d should be hit and bounded as before packing", i)
+		}
+	}
+	if MaterialOf(packed[0]) == material.Default || packed[0].(*MeshTriangle).Mesh.Name != "quad" {
+		t.Error("The mesh should keep the material and the name of the triangles")
+	}
+}
+
+func TestPackMeshes(t *testing.T) {
+	mat := &material.Lambertian{}
+	a, b, c := math3d.Vector3{}, math3d.UnitX, math3d.UnitZ
+	moving := &Triangle{Vertices: [3]math3d.Vector3{a, b, c}, Velocities: [3]math3d.Vector3{b, b, b}}
+	shapes := []Shape{
+		&Triangle{Vertices: [3]math3d.Vector3{a, b, c}, Material: mat},
+		&Triangle{Vertices: [3]math3d.Vector3{c, b, a}, Material: mat},
+		&Triangle{Vertices: [3]math3d.Vector3{a, b, c}},
+		&Sphere{Radius: 1},
+		moving,
+		&Triangle{Vertices: [3]math3d.Vector3{a, b, c}},
+	}
+	maps := PackMeshes(shapes)
+	var types []string
+	for _, m := range maps {
+		types = append(types, m["type"].(string))
+	}
+	if got := strings.Join(types, " "); got != "mesh mesh sphere triangle mesh" {
+		t.Errorf("The runs of still triangles with the same material should be packed, the shapes are %s", got)
+	}
+	if indices := maps[0]["buffers"].(map[string]interface{})["indices"].([]byte); len(indices) != 24 {
+		t.Errorf("The first mesh should have both triangles, it has %d bytes of indices", len(indices))
+	}
+}
+
+func TestInvalidPackedMeshes(t *testing.T) {
+	mesh := PackTriangles([]*Triangle{{Vertices: [3]math3d.Vector3{{}, math3d.UnitX, math3d.UnitZ}}})
+	outOfRange := append([]byte(nil), mesh.Indices...)
+	outOfRange[8] = 3
+	for _, buffers := range []map[string]interface{}{
+		{"positions": mesh.Positions[1:], "indices": mesh.Indices},
+		{"positions": mesh.Positions, "indices": mesh.Indices[4:]},
+		{"positions": mesh.Positions, "indices": outOfRange},
+		{"positions": mesh.Positions, "normals": mesh.Positions[24:], "indices": mesh.Indices},
+		{"positions": "AAAA", "indices": mesh.Indices},
+	} {
+		if _, err := MeshFromMap(map[string]interface{}{"type": "mesh", "buffers": buffers}); err == nil {
+			t.Errorf("A mesh with the buffers %v shouldn't be made", buffers)
+		}
+	}
+	if p := mesh.position(2); !p.Equal(&math3d.UnitZ) {
+		t.Error("The vertices should be where they were packed, not at " + p.String())
+	}
+}
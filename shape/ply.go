@@ -0,0 +1,346 @@
+package shape
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// plyProperty is a property of the elements of a PLY file. Lists have the
+// type of their length in count, which is empty for the other properties.
+type plyProperty struct {
+	name, kind, count string
+}
+
+// plyElement is a kind of element of a PLY file, such as its vertices or
+// its faces, and how many of them the file has
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// plySizes are the sizes in bytes of the types of PLY properties, by the
+// names of the types
+var plySizes = map[string]int{
+	"char": 1, "uchar": 1, "int8": 1, "uint8": 1,
+	"short": 2, "ushort": 2, "int16": 2, "uint16": 2,
+	"int": 4, "uint": 4, "int32": 4, "uint32": 4, "float": 4, "float32": 4,
+	"double": 8, "float64": 8,
+}
+
+// ReadPLY reads the faces of a PLY file, ASCII or binary, as triangles,
+// splitting the ones with more corners into fans. The vertices are read
+// with their normals, nx, ny and nz, their texture coordinates, u and v
+// or s and t, and their colors, red, green and blue, if they have them.
+// Colors of integer types go from 0 to 255 and the others from 0 to 1,
+// and all of them are in sRGB, as scanners save them. The other elements
+// and properties are ignored.
+//
+// The vertices without normals are given normals as ReadOBJ gives them to
+// the faces without normals.
+func ReadPLY(r io.Reader, smoothAngle float64) ([]*Triangle, error) {
+	return readPLY(r, smoothAngle, nil)
+}
+
+// readPLY reads the faces of a PLY file as ReadPLY does. If velocities
+// isn't nil, it holds the velocities of the vertices of the file, and the
+// triangles move with them.
+func readPLY(r io.Reader, smoothAngle float64, velocities []math3d.Vector3) ([]*Triangle, error) {
+	br := bufio.NewReader(r)
+	format, elements, err := plyHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	var read func(kind string) (float64, error)
+	switch format {
+	case "ascii":
+		scanner := bufio.NewScanner(br)
+		scanner.Split(bufio.ScanWords)
+		read = func(kind string) (float64, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.ErrUnexpectedEOF
+			}
+			n, err := strconv.ParseFloat(scanner.Text(), 64)
+			if err != nil {
+				return 0, fmt.Errorf("%q is not a valid number", scanner.Text())
+			}
+			return n, nil
+		}
+	case "binary_little_endian", "binary_big_endian":
+		var order binary.ByteOrder = binary.LittleEndian
+		if format == "binary_big_endian" {
+			order = binary.BigEndian
+		}
+		var buffer [8]byte
+		read = func(kind string) (float64, error) {
+			b := buffer[:plySizes[kind]]
+			if _, err := io.ReadFull(br, b); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, err
+			}
+			return plyValue(kind, b, order), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown PLY format %q", format)
+	}
+
+	var vertices, normals []math3d.Vector3
+	var uvs []math3d.Vector2
+	var colors []image.Color
+	var faces [][3]corner
+	for _, e := range elements {
+		// The values of the properties of every element by their names,
+		// and the lists by the name of the list
+		values := make(map[string]float64, len(e.properties))
+		var list []int
+		for i := 0; i < e.count; i++ {
+			for _, p := range e.properties {
+				kind := p.kind
+				if p.count != "" {
+					kind = p.count
+				}
+				value, err := read(kind)
+				if err != nil {
+					return nil, fmt.Errorf("%s %d: %v", e.name, i, err)
+				}
+				if p.count == "" {
+					values[p.name] = value
+					continue
+				}
+				// value is the length of the list, of the count type
+				length := int(value)
+				if float64(length) != value || length < 0 {
+					return nil, fmt.Errorf("%s %d: invalid length of %s", e.name, i, p.name)
+				}
+				var items []int
+				for j := 0; j < length; j++ {
+					item, err := read(p.kind)
+					if err != nil {
+						return nil, fmt.Errorf("%s %d: %v", e.name, i, err)
+					}
+					items = append(items, int(item))
+				}
+				if e.name == "face" && (p.name == "vertex_indices" || p.name == "vertex_index") {
+					list = items
+				}
+			}
+			switch e.name {
+			case "vertex":
+				v, n, uv, c, err := plyVertex(e, values)
+				if err != nil {
+					return nil, fmt.Errorf("vertex %d: %v", i, err)
+				}
+				vertices, normals, uvs, colors = append(vertices, v), append(normals, n), append(uvs, uv), append(colors, c)
+			case "face":
+				if len(list) < 3 {
+					return nil, fmt.Errorf("face %d: a face needs at least 3 vertices", i)
+				}
+				corners := make([]corner, len(list))
+				for j, index := range list {
+					// The vertices come before the faces in the files
+					// of every program that writes them
+					if index < 0 || index >= len(vertices) {
+						return nil, fmt.Errorf("face %d: index %d is out of range", i, index)
+					}
+					corners[j] = corner{vertex: index, uv: -1, normal: -1}
+				}
+				for j := 1; j+1 < len(corners); j++ {
+					faces = append(faces, [3]corner{corners[0], corners[j], corners[j+1]})
+				}
+				list = nil
+			}
+		}
+	}
+	if velocities != nil && len(velocities) != len(vertices) {
+		return nil, fmt.Errorf("there are %d velocities for %d vertices", len(velocities), len(vertices))
+	}
+	// The vertices have either all the properties or none of them
+	vertex := plyFind(elements, "vertex")
+	if vertex != nil && vertex.has("nx") {
+		for i := range faces {
+			for j := range faces[i] {
+				faces[i][j].normal = faces[i][j].vertex
+			}
+		}
+	} else {
+		normals = nil
+	}
+	if vertex != nil && (vertex.has("u") || vertex.has("s") || vertex.has("texture_u")) {
+		for i := range faces {
+			for j := range faces[i] {
+				faces[i][j].uv = faces[i][j].vertex
+			}
+		}
+	}
+	if vertex == nil || !vertex.has("red") {
+		colors = nil
+	}
+	return triangulate(vertices, velocities, uvs, normals, colors, faces, smoothAngle), nil
+}
+
+// plyHeader reads the header of a PLY file, returning its format and its
+// elements
+func plyHeader(r *bufio.Reader) (string, []plyElement, error) {
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	magic, err := readLine()
+	if err != nil || magic != "ply" {
+		return "", nil, fmt.Errorf("not a PLY file")
+	}
+	format := ""
+	var elements []plyElement
+	for {
+		line, err := readLine()
+		if err != nil {
+			return "", nil, fmt.Errorf("the header of the PLY file doesn't end")
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) != 3 || fields[2] != "1.0" {
+				return "", nil, fmt.Errorf("unknown PLY format %q", line)
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) != 3 {
+				return "", nil, fmt.Errorf("invalid element %q", line)
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return "", nil, fmt.Errorf("invalid element %q", line)
+			}
+			elements = append(elements, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return "", nil, fmt.Errorf("property %q before any element", line)
+			}
+			e := &elements[len(elements)-1]
+			switch {
+			case len(fields) == 3 && plySizes[fields[1]] > 0:
+				e.properties = append(e.properties, plyProperty{name: fields[2], kind: fields[1]})
+			case len(fields) == 5 && fields[1] == "list" && plySizes[fields[2]] > 0 && plySizes[fields[3]] > 0:
+				e.properties = append(e.properties, plyProperty{name: fields[4], kind: fields[3], count: fields[2]})
+			default:
+				return "", nil, fmt.Errorf("invalid property %q", line)
+			}
+		case "end_header":
+			if format == "" {
+				return "", nil, fmt.Errorf("the PLY file has no format")
+			}
+			return format, elements, nil
+		}
+	}
+}
+
+// plyValue returns the value of the type in the bytes
+func plyValue(kind string, b []byte, order binary.ByteOrder) float64 {
+	switch kind {
+	case "char", "int8":
+		return float64(int8(b[0]))
+	case "uchar", "uint8":
+		return float64(b[0])
+	case "short", "int16":
+		return float64(int16(order.Uint16(b)))
+	case "ushort", "uint16":
+		return float64(order.Uint16(b))
+	case "int", "int32":
+		return float64(int32(order.Uint32(b)))
+	case "uint", "uint32":
+		return float64(order.Uint32(b))
+	case "float", "float32":
+		return float64(math.Float32frombits(order.Uint32(b)))
+	}
+	return math.Float64frombits(order.Uint64(b))
+}
+
+// plyFind returns the element with the name, or nil if there's none
+func plyFind(elements []plyElement, name string) *plyElement {
+	for i := range elements {
+		if elements[i].name == name {
+			return &elements[i]
+		}
+	}
+	return nil
+}
+
+// has returns whether the elements have the property
+func (e *plyElement) has(name string) bool {
+	for _, p := range e.properties {
+		if p.name == name && p.count == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// kind returns the type of the property of the elements
+func (e *plyElement) kind(name string) string {
+	for _, p := range e.properties {
+		if p.name == name {
+			return p.kind
+		}
+	}
+	return ""
+}
+
+// plyVertex returns the position, the normal, the texture coordinates and
+// the linear color of a vertex with the values of its properties
+func plyVertex(e plyElement, values map[string]float64) (v, n math3d.Vector3, uv math3d.Vector2, c image.Color, err error) {
+	for _, name := range []string{"x", "y", "z"} {
+		if !e.has(name) {
+			return v, n, uv, c, fmt.Errorf("the vertices need x, y and z")
+		}
+	}
+	for _, value := range values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return v, n, uv, c, fmt.Errorf("the properties must be finite")
+		}
+	}
+	v = math3d.Vector3{X: values["x"], Y: values["y"], Z: values["z"]}
+	if e.has("nx") {
+		n = math3d.Vector3{X: values["nx"], Y: values["ny"], Z: values["nz"]}
+		if n.AbsSquared() == 0 {
+			return v, n, uv, c, fmt.Errorf("normals can't be zero")
+		}
+		n = n.NormalizedV()
+	}
+	for _, names := range [][2]string{{"u", "v"}, {"s", "t"}, {"texture_u", "texture_v"}} {
+		if e.has(names[0]) {
+			uv = math3d.Vector2{X: values[names[0]], Y: values[names[1]]}
+			break
+		}
+	}
+	if e.has("red") {
+		scale := 1.0
+		if kind := e.kind("red"); kind != "float" && kind != "float32" && kind != "double" && kind != "float64" {
+			scale = 255
+		}
+		c = image.Color{R: values["red"] / scale, G: values["green"] / scale, B: values["blue"] / scale}
+		if !c.NonNegative() {
+			return v, n, uv, c, fmt.Errorf("colors can't be negative")
+		}
+		c = *image.FromSRGB(&c)
+	}
+	return v, n, uv, c, nil
+}
@@ -0,0 +1,133 @@
+package shape
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestReadPLY(t *testing.T) {
+	ascii := `ply
+format ascii 1.0
+comment A quad of a scan, with a vertex color
+element vertex 4
+property double x
+property double y
+property double z
+property float nx
+property float ny
+property float nz
+property uchar red
+property uchar green
+property uchar blue
+element face 1
+property list uchar int vertex_indices
+element edge 1
+property int vertex1
+property int vertex2
+end_header
+0.1000000000000000055511151231257827 0 0 0 2 0 255 0 0
+1 0 0 0 1 0 255 255 255
+1 0 1 0 1 0 255 255 255
+0 0 1 0 1 0 0 0 0
+4 0 3 2 1
+0 1
+`
+	triangles, err := ReadPLY(strings.NewReader(ascii), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triangles) != 2 {
+		t.Fatalf("The quad should be split in 2 triangles, not %d", len(triangles))
+	}
+	if triangles[0].Vertices[0].X != 0.1 {
+		t.Errorf("The coordinates should be read in double precision, not %v", triangles[0].Vertices[0])
+	}
+	if n := triangles[0].Normals[0]; n != math3d.UnitY {
+		t.Errorf("The normals of the file should be used, normalized, not %v", n)
+	}
+	if c := triangles[0].Colors; c[0] != (image.Color{R: 1}) || c[1] != (image.Color{}) || c[2] != image.White {
+		t.Errorf("The colors of the vertices should be read, not %v", c)
+	}
+	// The colors tint the material, and go through packed meshes
+	for _, tri := range triangles {
+		tri.Material = &material.Lambertian{Albedo: image.Color{R: 0.5, G: 0.5, B: 0.5}}
+	}
+	corner := triangles[0].Vertices[0]
+	for _, sh := range []Shape{triangles[0], PackTriangles(triangles).Triangles()[0]} {
+		mat := MaterialAt(sh, &corner, &math3d.UnitY, &math3d.UnitY)
+		if albedo := mat.(*material.Lambertian).Albedo; albedo != (image.Color{R: 0.5}) {
+			t.Errorf("%T: The albedo should be tinted by the color of the vertex, not %v", sh, albedo)
+		}
+	}
+	// Half of sRGB is a fifth of linear light
+	gray := strings.Replace(ascii, "0 1 0 255 255 255\n1 0 1", "0 1 0 128 128 128\n1 0 1", 1)
+	grays, _ := ReadPLY(strings.NewReader(gray), 0)
+	if c := grays[1].Colors[2]; math.Abs(c.R-0.2158605) > 1e-6 {
+		t.Errorf("The colors should be turned into linear ones, not %v", c)
+	}
+
+	// A binary triangle with texture coordinates and no normals
+	var b bytes.Buffer
+	b.WriteString("ply\nformat binary_big_endian 1.0\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\n" +
+		"property float s\nproperty float t\nelement face 1\nproperty list uchar uint vertex_index\nend_header\n")
+	for _, v := range [][5]float32{{0, 0, 0, 0, 0}, {1, 0, 0, 1, 0}, {0, 1, 0, 0.5, 1}} {
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	binary.Write(&b, binary.BigEndian, uint8(3))
+	binary.Write(&b, binary.BigEndian, [3]uint32{0, 1, 2})
+	textured, err := ReadPLY(bytes.NewReader(b.Bytes()), DefaultSmoothAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(textured) != 1 || textured[0].UVs[2] != (math3d.Vector2{X: 0.5, Y: 1}) || textured[0].colored() {
+		t.Fatalf("Expected a textured triangle without colors, not %+v", textured)
+	}
+	if n := textured[0].Normals[0]; n != math3d.UnitZ {
+		t.Errorf("The normals should be generated from the faces, not %v", n)
+	}
+	if _, err := readPLY(bytes.NewReader(b.Bytes()), 0, make([]math3d.Vector3, 2)); err == nil {
+		t.Error("There should be a velocity for every vertex")
+	}
+
+	for _, bad := range []string{
+		"",
+		"ply\nformat ascii 1.0\nelement vertex 1\nproperty float x\n",
+		"ply\nformat ascii 2.0\nend_header\n",
+		"ply\nformat ascii 1.0\nelement vertex 1\nproperty float x\nproperty float y\nproperty float z\nend_header\n0 0\n",
+		"ply\nformat ascii 1.0\nelement vertex 1\nproperty float x\nend_header\n0\n",
+		"ply\nformat ascii 1.0\nelement face 1\nproperty list uchar int vertex_indices\nend_header\n3 0 1 2\n",
+		b.String()[:b.Len()-1],
+	} {
+		if _, err := ReadPLY(strings.NewReader(bad), 0); err == nil {
+			t.Errorf("%q should be an error", bad)
+		}
+	}
+}
+
+func FuzzReadPLY(f *testing.F) {
+	f.Add([]byte("ply\nformat ascii 1.0\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\n" +
+		"property uchar red\nproperty uchar green\nproperty uchar blue\nelement face 1\nproperty list uchar int vertex_indices\n" +
+		"end_header\n0 0 0 255 0 0\n1 0 0 0 255 0\n0 1 0 0 0 255\n3 0 1 2\n"))
+	var b bytes.Buffer
+	b.WriteString("ply\nformat binary_little_endian 1.0\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\n" +
+		"property float nx\nproperty float ny\nproperty float nz\nelement face 1\nproperty list uchar uint vertex_index\nend_header\n")
+	for _, v := range [][6]float32{{0, 0, 0, 0, 0, -1}, {1, 0, 0, 0, 0, -1}, {0, 1, 0, 0, 0, -1}} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteByte(3)
+	binary.Write(&b, binary.LittleEndian, [3]uint32{0, 1, 2})
+	f.Add(b.Bytes())
+	f.Fuzz(func(t *testing.T, ply []byte) {
+		triangles, err := ReadPLY(bytes.NewReader(ply), DefaultSmoothAngle)
+		if err == nil {
+			checkLoaded(t, triangles)
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package shape
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/internal/assets"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// DefaultPointRadius is the radius of the points of point cloud files
+// whose maps don't set one
+const DefaultPointRadius = 0.01
+
+// Point defines a point of a point cloud, such as the ones LiDAR scanners
+// measure or photogrammetry reconstructs. It covers the disc of its radius
+// that faces the lightray, bulged as the half of a sphere facing it, so
+// the points fill the surface they sample from every side and are shaded
+// as round even though clouds have no normals.
+type Point struct {
+	Position math3d.Vector3 `json:"position"`
+	Radius   float64        `json:"radius"`
+	// Color is the linear color of the point, which tints the albedo of
+	// the material, or zero if the point has none
+	Color image.Color `json:"color"`
+	// Splat makes the point a Gaussian splat, which is less opaque further
+	// from its center, down to about 1% at its radius, so the points of a
+	// cloud blend into one another as the samples of every pixel add up
+	// instead of showing their edges
+	Splat bool   `json:"splat,omitempty"`
+	Name  string `json:"name,omitempty"`
+	// Material is the material of the surface, the default one if nil
+	Material material.Material `json:"material,omitempty"`
+}
+
+// Intersect returns the distance at which the lightray intersects the
+// point. Splats let a share of the lightrays through that grows with how
+// far from the center they pass, decided by a hash of the lightray and
+// the point, so the same lightray always gets the same answer.
+func (p *Point) Intersect(lr *math3d.LightRay) float64 {
+	v := lr.Source.SubtractV(p.Position)
+	a := lr.Direction.AbsSquared()
+	b := lr.Direction.DotV(v)
+	// The squared distance from the center to the line of the lightray
+	miss := v.AbsSquared() - b*b/a
+	if miss > p.Radius*p.Radius {
+		return math.MaxFloat64
+	}
+	if p.Splat {
+		// A standard deviation of a third of the radius
+		opacity := math.Exp(-4.5 * miss / (p.Radius * p.Radius))
+		if splatHash(lr, &p.Position) >= opacity {
+			return math.MaxFloat64
+		}
+	}
+	root := math.Sqrt(math.Max(p.Radius*p.Radius-miss, 0) * a)
+	return math3d.GetNearestInFront((-b-root)/a, (-b+root)/a)
+}
+
+// splatHash returns a number in [0, 1) that the lightray and the position
+// scatter all over the range
+func splatHash(lr *math3d.LightRay, position *math3d.Vector3) float64 {
+	h := uint64(0)
+	for _, v := range [...]float64{lr.Source.X, lr.Source.Y, lr.Source.Z, lr.Direction.X, lr.Direction.Y, lr.Direction.Z, position.X, position.Y, position.Z} {
+		h = mix(h ^ math.Float64bits(v))
+	}
+	return float64(h>>11) / (1 << 53)
+}
+
+// mix is the finalizer of splitmix64
+func mix(v uint64) uint64 {
+	v ^= v >> 30
+	v *= 0xbf58476d1ce4e5b9
+	v ^= v >> 27
+	v *= 0x94d049bb133111eb
+	v ^= v >> 31
+	return v
+}
+
+// NormalAt returns the normal vector of a point of the surface of the
+// point, that of the sphere it's shaded as
+func (p *Point) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	normal := point.SubtractV(p.Position)
+	if normal.AbsSquared() == 0 {
+		return math3d.UnitY
+	}
+	return normal.NormalizedV()
+}
+
+// ColorAt returns the color of the point, and whether it has one
+func (p *Point) ColorAt(point *math3d.Vector3) (image.Color, bool) {
+	return p.Color, p.Color != image.Color{}
+}
+
+// Bounds returns the bounding box of the point
+func (p *Point) Bounds() *math3d.AABB {
+	r := math3d.Vector3{X: p.Radius, Y: p.Radius, Z: p.Radius}
+	return &math3d.AABB{Min: p.Position.SubtractV(r), Max: p.Position.AddV(r)}
+}
+
+// Surface returns the material of the point
+func (p *Point) Surface() material.Material {
+	return p.Material
+}
+
+// Transform transforms the point by the affine matrix. Its radius is
+// scaled by how much it scales lengths on average.
+func (p *Point) Transform(m *math3d.Matrix) {
+	p.Position = *m.MultiplyPoint(&p.Position)
+	p.Radius *= math.Cbrt(math.Abs(m.Determinant()))
+}
+
+// Translate moves the point by offset
+func (p *Point) Translate(offset *math3d.Vector3) {
+	p.Position = p.Position.AddV(*offset)
+}
+
+// AsMap returns a map representation of this shape
+func (p *Point) AsMap() map[string]interface{} {
+	m := map[string]interface{}{"type": "point", "position": p.Position.AsMap(), "radius": p.Radius}
+	if p.Color != (image.Color{}) {
+		m["color"] = map[string]float64{"r": p.Color.R, "g": p.Color.G, "b": p.Color.B}
+	}
+	if p.Splat {
+		m["splat"] = true
+	}
+	if p.Name != "" {
+		m["name"] = p.Name
+	}
+	if p.Material != nil {
+		m["material"] = p.Material.AsMap()
+	}
+	return m
+}
+
+// PointFromMap returns a point with the values in the map, or an error if
+// any of them is missing or has the wrong type
+func PointFromMap(themap map[string]interface{}) (*Point, error) {
+	d := decoder{m: themap}
+	p := &Point{}
+	p.Position = d.vector("position")
+	p.Radius = d.number("radius")
+	if d.has("color") {
+		p.Color = d.color("color")
+	}
+	p.Splat = d.flag("splat")
+	p.Name = d.text("name")
+	p.Material = d.material()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return p, nil
+}
+
+// PointsFromMap returns the points in the point cloud file of the map,
+// all of them with the radius, the name and the material of the map, and
+// splats if it says so, or an error if the file can't be read or a value
+// of the map has the wrong type
+func PointsFromMap(themap map[string]interface{}) ([]Shape, error) {
+	d := decoder{m: themap}
+	path := d.file("file")
+	radius := d.optionalNumber("radius", DefaultPointRadius)
+	mat := d.material()
+	name := d.text("name")
+	splat := d.flag("splat")
+	if d.err != nil {
+		return nil, d.err
+	}
+	points, err := LoadPoints(path, radius)
+	if err != nil {
+		return nil, err
+	}
+	shapes := make([]Shape, 0, len(points))
+	for _, p := range points {
+		p.Name, p.Material, p.Splat = name, mat, splat
+		shapes = append(shapes, p)
+	}
+	return shapes, nil
+}
+
+// LoadPoints reads the points of an XYZ or PTS file, told apart by their
+// extensions, with the radius. See ReadXYZ and ReadPTS.
+func LoadPoints(path string, radius float64) ([]*Point, error) {
+	file, err := assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if strings.ToLower(filepath.Ext(path)) == ".pts" {
+		return ReadPTS(file, radius)
+	}
+	return ReadXYZ(file, radius)
+}
+
+// ReadXYZ reads the points of an XYZ file, with the radius. Every line
+// holds a point as its X, Y and Z coordinates, optionally followed by its
+// red, green and blue from 0 to 255 in sRGB, as scanners save them. Empty
+// lines and the ones starting with # or // are skipped.
+func ReadXYZ(r io.Reader, radius float64) ([]*Point, error) {
+	return readPoints(r, radius, false)
+}
+
+// ReadPTS reads the points of a PTS file, with the radius. Its first line
+// may hold the number of points, which is ignored, and every other line
+// holds a point as its X, Y and Z coordinates, optionally followed by the
+// intensity of the return, which is ignored, and then by its red, green
+// and blue from 0 to 255 in sRGB.
+func ReadPTS(r io.Reader, radius float64) ([]*Point, error) {
+	return readPoints(r, radius, true)
+}
+
+// readPoints reads the points of an XYZ file, or of a PTS file, whose
+// points have an intensity before their colors, if pts is true
+func readPoints(r io.Reader, radius float64, pts bool) ([]*Point, error) {
+	if !(radius > 0) {
+		return nil, fmt.Errorf("the radius of the points must be positive")
+	}
+	var points []*Point
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "//") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if pts && line == 1 && len(fields) == 1 {
+			continue
+		}
+		colored := !pts && len(fields) == 6 || pts && len(fields) == 7
+		if !colored && len(fields) != 3 && !(pts && len(fields) == 4) {
+			return nil, fmt.Errorf("line %d: a point can't have %d numbers", line, len(fields))
+		}
+		numbers := make([]float64, len(fields))
+		for i, f := range fields {
+			n, err := strconv.ParseFloat(f, 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				return nil, fmt.Errorf("line %d: %q is not a valid number", line, f)
+			}
+			numbers[i] = n
+		}
+		p := &Point{Position: math3d.Vector3{X: numbers[0], Y: numbers[1], Z: numbers[2]}, Radius: radius}
+		if colored {
+			// The colors are the last three numbers
+			rgb := numbers[len(numbers)-3:]
+			for _, v := range rgb {
+				if v < 0 || v > 255 {
+					return nil, fmt.Errorf("line %d: colors go from 0 to 255", line)
+				}
+			}
+			p.Color = *image.FromSRGB(&image.Color{R: rgb[0] / 255, G: rgb[1] / 255, B: rgb[2] / 255})
+		}
+		points = append(points, p)
+	}
+	return points, scanner.Err()
+}
@@ -0,0 +1,107 @@
+package shape
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestPointFacesTheLightray(t *testing.T) {
+	p := Point{Position: math3d.Vector3{Z: 3}, Radius: 0.5, Color: image.Color{R: 0.5}}
+	for _, direction := range []math3d.Vector3{math3d.UnitZ, {X: 0.3, Z: 1}, {Y: -0.2, Z: 2}} {
+		lr := math3d.LightRay{Source: p.Position.SubtractV(direction.MultiplyV(4)), Direction: direction}
+		d := p.Intersect(&lr)
+		hit := lr.Source.AddV(lr.Direction.MultiplyV(d))
+		if math.Abs(math3d.Distance(&hit, &p.Position)-0.5) > 1e-9 {
+			t.Fatalf("The lightray along %s should hit the front of the point, not %s", direction.String(), hit.String())
+		}
+		if n := p.NormalAt(&hit); n.DotV(direction) > -0.99 {
+			t.Errorf("The point should face the lightray through its center, not along %s", n.String())
+		}
+	}
+	miss := math3d.LightRay{Source: math3d.Vector3{X: 0.6}, Direction: math3d.UnitZ}
+	if p.Intersect(&miss) != math.MaxFloat64 {
+		t.Error("The lightray should pass by the point")
+	}
+	scale := math3d.ScaleMatrix(math3d.Vector3{X: 2, Y: 2, Z: 2})
+	p.Transform(&scale)
+	if p.Radius != 1 || p.Position != (math3d.Vector3{Z: 6}) {
+		t.Errorf("Scaling the point should scale its radius too, not make %v", p)
+	}
+	data, err := json.Marshal(p.AsMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := PointFromMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != p {
+		t.Errorf("The point should decode from its map as %v, not %v", p, *decoded)
+	}
+}
+
+func TestSplatsFadeTowardsTheirEdge(t *testing.T) {
+	p := Point{Position: math3d.Vector3{Z: 3}, Radius: 1, Splat: true}
+	// The share of lightrays at a distance from the center that hit
+	coverage := func(offset float64) float64 {
+		hits := 0
+		for i := 0; i < 2000; i++ {
+			lr := math3d.LightRay{Source: math3d.Vector3{X: offset, Y: float64(i) * 1e-9}, Direction: math3d.UnitZ}
+			if p.Intersect(&lr) != math.MaxFloat64 {
+				hits++
+			}
+		}
+		return float64(hits) / 2000
+	}
+	center, middle, edge := coverage(0), coverage(0.5), coverage(0.95)
+	if center != 1 || math.Abs(middle-math.Exp(-4.5*0.25)) > 0.04 || edge > 0.05 {
+		t.Errorf("Splats should be opaque at the center and fade as Gaussians, not cover %.3f, %.3f and %.3f", center, middle, edge)
+	}
+	lr := math3d.LightRay{Source: math3d.Vector3{X: 0.5}, Direction: math3d.UnitZ}
+	if p.Intersect(&lr) != p.Intersect(&lr) {
+		t.Error("The same lightray should always hit the same")
+	}
+}
+
+func TestReadPoints(t *testing.T) {
+	xyz := "# A scan\n0 0 0\n1 2 3 255 0 128\n\n"
+	points, err := ReadXYZ(strings.NewReader(xyz), 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[1].Position != (math3d.Vector3{X: 1, Y: 2, Z: 3}) || points[1].Radius != 0.1 {
+		t.Fatalf("There should be 2 points with the radius, not %v", points)
+	}
+	if _, colored := points[0].ColorAt(&points[0].Position); colored {
+		t.Error("A point without colors shouldn't tint its material")
+	}
+	if c := points[1].Color; c.R != 1 || c.G != 0 || math.Abs(c.B-image.FromSRGB(&image.Color{B: 128.0 / 255}).B) > 1e-12 {
+		t.Errorf("The colors should be decoded from sRGB, not %v", c)
+	}
+
+	pts := "2\n0 0 0 -1024\n1 1 1 12 10 20 30\n"
+	points, err = ReadPTS(strings.NewReader(pts), 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Color != (image.Color{}) || points[1].Color == (image.Color{}) {
+		t.Errorf("The PTS points should skip the count and the intensities, not read %v", points)
+	}
+	for _, bad := range []string{"0 0\n", "0 0 x\n", "0 0 0 1\n", "0 0 0 0 0 300\n"} {
+		if _, err := ReadXYZ(strings.NewReader(bad), 0.1); err == nil {
+			t.Errorf("%q should be an error", bad)
+		}
+	}
+	if _, err := ReadXYZ(strings.NewReader(xyz), 0); err == nil {
+		t.Error("The points need a radius")
+	}
+}
@@ -0,0 +1,71 @@
+package shape
+
+import (
+	"fmt"
+	"sync"
+)
+
+// primitive is a type of shape that a program registered
+type primitive struct {
+	keys    []string
+	fromMap func(map[string]interface{}) (Shape, error)
+}
+
+var (
+	primitivesMu sync.RWMutex
+	primitives   = make(map[string]primitive)
+)
+
+// builtinPrimitives are the types of the shapes of the package, which can't
+// be registered again
+var builtinPrimitives = []string{"sphere", "heightfield", "curve", "curves", "point", "points", "triangle", "mesh", "delayed"}
+
+// RegisterPrimitive registers a type of shape, so that programs that use
+// the package can add shapes of their own to the scene file format. The
+// shapes of the type in scene files have the keys, "type" among them, and
+// fromMap returns the shape of the map of one, or an error if the map
+// isn't valid, as the FromMap functions of the package do. The AsMap of
+// the shapes must return such a map, with the type in "type", so that
+// they are written back to scene files.
+// Registering is meant for the init functions of programs, and panics if
+// the type is already a type of shape.
+func RegisterPrimitive(typename string, keys []string, fromMap func(map[string]interface{}) (Shape, error)) {
+	primitivesMu.Lock()
+	defer primitivesMu.Unlock()
+	if _, registered := primitives[typename]; registered || contains(builtinPrimitives, typename) || typename == "" {
+		panic(fmt.Sprintf("There's already a shape type %q", typename))
+	}
+	primitives[typename] = primitive{keys: keys, fromMap: fromMap}
+}
+
+// PrimitiveKeys returns the keys of the shapes of a type registered by
+// RegisterPrimitive, and whether there's one
+func PrimitiveKeys(typename string) ([]string, bool) {
+	primitivesMu.RLock()
+	defer primitivesMu.RUnlock()
+	p, ok := primitives[typename]
+	return p.keys, ok
+}
+
+// registered returns the shape of the map of a shape of a type registered
+// by RegisterPrimitive, whether there's one, and the error of its fromMap
+func registered(m map[string]interface{}) (Shape, bool, error) {
+	typename, _ := m["type"].(string)
+	primitivesMu.RLock()
+	p, ok := primitives[typename]
+	primitivesMu.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	sh, err := p.fromMap(m)
+	return sh, true, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,178 @@
 package shape
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
 	"github.com/ProjectMOA/goraytrace/math3d"
 )
 
 // Shape defines the methods shared by all 3D shapes
 type Shape interface {
 	Intersect(lr *math3d.LightRay) float64
-	NormalAt(point *math3d.Vector3) *math3d.Vector3
+	NormalAt(point *math3d.Vector3) math3d.Vector3
 	AsMap() map[string]interface{}
+	Bounds() *math3d.AABB
+}
+
+// Movable defines the shapes that can be moved around the scene
+type Movable interface {
+	Translate(offset *math3d.Vector3)
+}
+
+// Transformable defines the shapes that can be moved, rotated and scaled
+// by any affine transform
+type Transformable interface {
+	Transform(m *math3d.Matrix)
+}
+
+// Shaded defines the shapes that can have a material
+type Shaded interface {
+	Surface() material.Material
+}
+
+// SmoothShaded defines the shapes made of flat facets whose normals are
+// interpolated to look smooth. ShadowOrigin returns the point from which
+// the rays towards the lights leave the shape at point, which is moved off
+// the facets so the shadows don't show them.
+type SmoothShaded interface {
+	ShadowOrigin(point *math3d.Vector3) math3d.Vector3
+}
+
+// Parametric defines the shapes whose surface is a map of the unit square
+// of texture space. SurfaceAt returns the point of the surface at the
+// texture coordinates u, v and its unit normal there. ok is false if no
+// point of the surface maps to u, v.
+type Parametric interface {
+	SurfaceAt(u, v float64) (point, normal math3d.Vector3, ok bool)
+}
+
+// Textured defines the shapes that map their surface to texture space.
+// TextureAt returns the texture coordinates of a point of the surface.
+type Textured interface {
+	TextureAt(point *math3d.Vector3) (u, v float64)
+}
+
+// Colored defines the shapes whose vertices have colors, which tint the
+// albedo of their material. ColorAt returns the color at a point of the
+// surface, and ok is false if the shape has no colors.
+type Colored interface {
+	ColorAt(point *math3d.Vector3) (c image.Color, ok bool)
+}
+
+// Hit is the intersection of a lightray with a shape
+type Hit struct {
+	// Distance is how far along the lightray the shape is hit
+	Distance float64
+	// Point is where the lightray hits the shape and Normal is the unit
+	// normal of the shape there
+	Point, Normal math3d.Vector3
+	// Backface is true if the lightray hits the side of the surface that
+	// the normal points away from, such as the inside of a sphere
+	Backface bool
+	// DPDX and DPDY are how far the point is from the ones the lightrays
+	// through the pixels right of it and below it hit, on the plane of the
+	// surface, or zero if the lightray has no differential
+	DPDX, DPDY math3d.Vector3
+}
+
+// hitPoints holds the points HitAt asks the shapes for their normals at,
+// which would escape to the heap as local variables, since the compiler
+// can't tell what the shapes do with them
+var hitPoints = sync.Pool{New: func() interface{} { return new(math3d.Vector3) }}
+
+// HitAt returns the hit of the lightray with the shape at the distance
+// that Intersect returned
+func HitAt(s Shape, lr *math3d.LightRay, distance float64) Hit {
+	at := hitPoints.Get().(*math3d.Vector3)
+	*at = lr.Source.AddV(lr.Direction.MultiplyV(distance))
+	point, normal := *at, s.NormalAt(at).NormalizedV()
+	hitPoints.Put(at)
+	hit := Hit{Distance: distance, Point: point, Normal: normal, Backface: normal.DotV(lr.Direction) > 0}
+	if d := lr.Differential; d != nil {
+		// Where the offset lightrays cross the plane of the surface
+		offset := func(source, direction math3d.Vector3) math3d.Vector3 {
+			o, dir := lr.Source.AddV(source), lr.Direction.AddV(direction)
+			cosine := normal.DotV(dir)
+			if cosine == 0 {
+				return math3d.Vector3{}
+			}
+			t := normal.DotV(point.SubtractV(o)) / cosine
+			return o.AddV(dir.MultiplyV(t)).SubtractV(point)
+		}
+		hit.DPDX, hit.DPDY = offset(d.SourceX, d.DirectionX), offset(d.SourceY, d.DirectionY)
+	}
+	return hit
+}
+
+// ShadowOrigin returns the point from which the rays towards the lights
+// leave the shape at point. It's point itself unless the shape is smooth
+// shaded.
+func ShadowOrigin(s Shape, point *math3d.Vector3) math3d.Vector3 {
+	if smooth, ok := s.(SmoothShaded); ok {
+		return smooth.ShadowOrigin(point)
+	}
+	return *point
+}
+
+// MaterialOf returns the material of the shape, or the default material if
+// it doesn't have one.
+func MaterialOf(s Shape) material.Material {
+	if shaded, ok := s.(Shaded); ok {
+		if m := shaded.Surface(); m != nil {
+			return m
+		}
+	}
+	return material.Default
+}
+
+// MaterialAt returns the material of the shape at a point of its surface
+// with the unit normal, seen from the unit direction out, which is the one
+// of the shading point there for materials that vary across the surface,
+// tinted by the color of the shape there if it's colored
+func MaterialAt(s Shape, point, normal, out *math3d.Vector3) material.Material {
+	return MaterialAtHit(s, &Hit{Point: *point, Normal: *normal}, out)
+}
+
+// MaterialAtHit returns the material of the shape at the hit, seen from
+// the unit direction out, as MaterialAt does, with the footprint of the
+// pixel of the hit in texture space, so image textures are filtered over
+// it
+func MaterialAtHit(s Shape, hit *Hit, out *math3d.Vector3) material.Material {
+	point := &hit.Point
+	mat := MaterialOf(s)
+	if varying, ok := mat.(material.Varying); ok {
+		p := material.ShadingPoint{Point: *point, Normal: hit.Normal, Out: *out}
+		if textured, ok := s.(Textured); ok {
+			p.U, p.V = textured.TextureAt(point)
+			if hit.DPDX != (math3d.Vector3{}) || hit.DPDY != (math3d.Vector3{}) {
+				p.DUDX, p.DVDX = textureDelta(textured, point, &hit.DPDX, p.U, p.V)
+				p.DUDY, p.DVDY = textureDelta(textured, point, &hit.DPDY, p.U, p.V)
+			}
+		}
+		mat = varying.At(&p)
+	}
+	if colored, ok := s.(Colored); ok {
+		if c, ok := colored.ColorAt(point); ok {
+			mat = material.Tint(mat, c)
+		}
+	}
+	return mat
+}
+
+// textureDelta returns how much the texture coordinates u, v of the point
+// change to the ones of the point offset by delta. The texture coordinates
+// repeat, so they change the short way around, as across the seams of
+// spheres.
+func textureDelta(s Textured, point, delta *math3d.Vector3, u, v float64) (du, dv float64) {
+	next := point.AddV(*delta)
+	nextU, nextV := s.TextureAt(&next)
+	du, dv = nextU-u, nextV-v
+	return du - math.Round(du), dv - math.Round(dv)
 }
 
 // AsMap turns the input slice of shapes to a slice of maps that can be
@@ -21,16 +185,87 @@ func AsMap(shapes []Shape) []map[string]interface{} {
 	return retval
 }
 
-// FromMap returns a slice of shapes made from the slice of map
-func FromMap(themap []map[string]interface{}) []Shape {
+// NameOf returns the name of the shape in the scene file. Shapes without
+// a name are named after their type and their index in the scene.
+func NameOf(s Shape, index int) string {
+	m := s.AsMap()
+	if name, ok := m["name"].(string); ok && name != "" {
+		return name
+	}
+	return fmt.Sprint(m["type"], index)
+}
+
+// FromMap returns a slice of shapes made from the slice of map, of the
+// types of the package or registered by RegisterPrimitive. The shapes of a
+// map with a "transform" are transformed by it, as math3d.TransformFromMap
+// reads it. It returns an error if a map isn't a valid shape.
+func FromMap(themap []map[string]interface{}) ([]Shape, error) {
 	shapes := make([]Shape, 0, len(themap))
 	for _, m := range themap {
+		var made []Shape
+		var err error
 		switch m["type"] {
 		case "sphere":
-			shapes = append(shapes, SphereFromMap(m))
+			var s *Sphere
+			s, err = SphereFromMap(m)
+			made = []Shape{s}
+		case "heightfield":
+			var h *Heightfield
+			h, err = HeightfieldFromMap(m)
+			made = []Shape{h}
+		case "curve":
+			var c *Curve
+			c, err = CurveFromMap(m)
+			made = []Shape{c}
+		case "curves":
+			made, err = CurvesFromMap(m)
+		case "point":
+			var p *Point
+			p, err = PointFromMap(m)
+			made = []Shape{p}
+		case "points":
+			made, err = PointsFromMap(m)
+		case "triangle":
+			var t *Triangle
+			t, err = TriangleFromMap(m)
+			made = []Shape{t}
+		case "mesh":
+			made, err = MeshFromMap(m)
 		default:
-			panic("That shape is not implemented yet or the type field is empty")
+			var sh Shape
+			var ok bool
+			sh, ok, err = registered(m)
+			if !ok {
+				err = errors.New("that shape is not implemented yet or the type field is empty")
+			}
+			made = []Shape{sh}
+		}
+		if err == nil {
+			if value, present := m["transform"]; present {
+				err = transform(made, value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		shapes = append(shapes, made...)
+	}
+	return shapes, nil
+}
+
+// transform transforms the shapes by the transform in value, or returns an
+// error if value isn't a transform or a shape can't be transformed
+func transform(shapes []Shape, value interface{}) error {
+	m, err := math3d.TransformFromMap(value)
+	if err != nil {
+		return fmt.Errorf("transform: %v", err)
+	}
+	for _, s := range shapes {
+		transformable, ok := s.(Transformable)
+		if !ok {
+			return fmt.Errorf("transform: shapes of type %T can't be transformed", s)
 		}
+		transformable.Transform(&m)
 	}
-	return shapes
+	return nil
 }
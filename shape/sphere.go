@@ -3,6 +3,7 @@ package shape
 import (
 	"math"
 
+	"github.com/ProjectMOA/goraytrace/material"
 	"github.com/ProjectMOA/goraytrace/math3d"
 )
 
@@ -10,15 +11,20 @@ import (
 type Sphere struct {
 	Position math3d.Vector3 `json:"position"`
 	Radius   float64        `json:"radius"`
+	Name     string         `json:"name,omitempty"`
+	// Material is the material of the surface, the default one if nil
+	Material material.Material `json:"material,omitempty"`
+	// Opacity cuts holes in the surface, which has none if it's nil
+	Opacity *Opacity `json:"-"`
 }
 
 // Intersect returns the distance at which the lightray intersects
 // the sphere
 func (s *Sphere) Intersect(lr *math3d.LightRay) float64 {
-	v := lr.Source.Subtract(&s.Position)
-	a := lr.Direction.Dot(&lr.Direction)
-	b := 2 * lr.Direction.Dot(v)
-	c := v.Dot(v) - s.Radius
+	v := lr.Source.SubtractV(s.Position)
+	a := lr.Direction.DotV(lr.Direction)
+	b := 2 * lr.Direction.DotV(v)
+	c := v.AbsSquared() - s.Radius*s.Radius
 	bb4ac := b*b - 4*a*c
 	if bb4ac < 0 {
 		// The lightray misses the sphere
@@ -36,23 +42,77 @@ func (s *Sphere) Intersect(lr *math3d.LightRay) float64 {
 
 // NormalAt returns the normal vector of a point of the sphere.
 // point must be a point in the surface of the sphere.
-func (s *Sphere) NormalAt(point *math3d.Vector3) *math3d.Vector3 {
-	return point.Subtract(&s.Position).Divide(s.Radius)
+func (s *Sphere) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	return point.SubtractV(s.Position).DivideV(s.Radius)
+}
+
+// SurfaceAt returns the point of the sphere at the texture coordinates
+// u, v and its normal. u goes around the Y axis from the X axis and v from
+// the bottom pole to the top one.
+func (s *Sphere) SurfaceAt(u, v float64) (point, normal math3d.Vector3, ok bool) {
+	phi, theta := 2*math.Pi*u, math.Pi*v
+	normal = math3d.Vector3{X: math.Sin(theta) * math.Cos(phi), Y: -math.Cos(theta), Z: math.Sin(theta) * math.Sin(phi)}
+	return s.Position.AddV(normal.MultiplyV(s.Radius)), normal, true
+}
+
+// TextureAt returns the texture coordinates of a point of the sphere, the
+// inverse of SurfaceAt
+func (s *Sphere) TextureAt(point *math3d.Vector3) (u, v float64) {
+	n := point.SubtractV(s.Position).MultiplyV(1 / s.Radius)
+	u = math.Atan2(n.Z, n.X) / (2 * math.Pi)
+	if u < 0 {
+		u++
+	}
+	return u, math.Acos(math3d.Clamp(-n.Y, -1, 1)) / math.Pi
+}
+
+// Opaque returns whether the sphere is there where the lightray hits it at
+// distance, rather than a hole of its opacity map
+func (s *Sphere) Opaque(lr *math3d.LightRay, distance float64) bool {
+	return opaqueAt(s, s.Opacity, lr, distance)
+}
+
+// Bounds returns the bounding box of the sphere
+func (s *Sphere) Bounds() *math3d.AABB {
+	r := &math3d.Vector3{X: s.Radius, Y: s.Radius, Z: s.Radius}
+	return &math3d.AABB{Min: *s.Position.Subtract(r), Max: *s.Position.Add(r)}
+}
+
+// Surface returns the material of the sphere
+func (s *Sphere) Surface() material.Material {
+	return s.Material
+}
+
+// Translate moves the sphere by offset
+func (s *Sphere) Translate(offset *math3d.Vector3) {
+	s.Position = *s.Position.Add(offset)
 }
 
 // AsMap returns a map representation of this shape
 func (s *Sphere) AsMap() map[string]interface{} {
-	return map[string]interface{}{"type": "sphere", "position": s.Position.AsMap(), "radius": s.Radius}
+	m := map[string]interface{}{"type": "sphere", "position": s.Position.AsMap(), "radius": s.Radius}
+	if s.Name != "" {
+		m["name"] = s.Name
+	}
+	if s.Material != nil {
+		m["material"] = s.Material.AsMap()
+	}
+	addOpacityToMap(m, s.Opacity)
+	return m
 }
 
-// SphereFromMap returns a sphere with the values in the map
-func SphereFromMap(themap map[string]interface{}) *Sphere {
+// SphereFromMap returns a sphere with the values in the map, or an error
+// if any of them is missing or has the wrong type
+func SphereFromMap(themap map[string]interface{}) (*Sphere, error) {
+	d := decoder{m: themap}
 	retval := &Sphere{}
-	var ok bool
-	retval.Position = math3d.VectorFromMap(themap["position"].(map[string]interface{}))
-	retval.Radius, ok = themap["radius"].(float64)
-	if !ok {
-		panic("The sphere's position was empty or isn't a valid float")
+	retval.Position = d.vector("position")
+	retval.Radius = d.number("radius")
+	retval.Name = d.text("name")
+	retval.Material = d.material()
+	retval.Opacity = d.opacity()
+	if d.err != nil {
+		return nil, d.err
 	}
-	return retval
+	return retval, nil
 }
@@ -20,3 +20,26 @@ func TestRaySphereIntersection(t *testing.T) {
 		t.Errorf("The lightray should intersect the sphere at D=1.0 but it intersects at %.3f", intersectionDistance)
 	}
 }
+
+func TestHitReportsBackfaces(t *testing.T) {
+	sphere := &Sphere{Radius: 1}
+	outside := math3d.LightRay{Source: math3d.Vector3{Z: -2}, Direction: math3d.UnitZ}
+	if hit := HitAt(sphere, &outside, sphere.Intersect(&outside)); hit.Backface || hit.Normal.Z != -1 {
+		t.Errorf("A ray from outside should hit the front of the sphere at z=-1, it hit %s with backface %t", hit.Point.String(), hit.Backface)
+	}
+	inside := math3d.LightRay{Direction: math3d.UnitZ}
+	if hit := HitAt(sphere, &inside, sphere.Intersect(&inside)); !hit.Backface || hit.Point.Z != 1 {
+		t.Errorf("A ray from inside should hit the back of the sphere at z=1, it hit %s with backface %t", hit.Point.String(), hit.Backface)
+	}
+}
+
+func BenchmarkRaySphereIntersection(b *testing.B) {
+	mySphere := Sphere{Position: math3d.Vector3{X: 0.0, Y: 0.0, Z: 0.0}, Radius: 1.0}
+	myLightRay := math3d.LightRay{
+		Direction: math3d.Vector3{X: 0.0, Y: 0.0, Z: 1.0},
+		Source:    math3d.Vector3{X: 0.0, Y: 0.0, Z: -2.0}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		mySphere.Intersect(&myLightRay)
+	}
+}
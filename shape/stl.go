@@ -0,0 +1,137 @@
+package shape
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// ReadSTL reads the facets of an STL file, binary or ASCII, as triangles.
+// The normals of the facets are ignored, as most files don't point them
+// the way the vertices go around. STL files repeat the vertices of every
+// facet, so the vertices at the same coordinates are joined, and the
+// normals of the triangles are generated around them as ReadOBJ generates
+// the ones of faces without normals.
+func ReadSTL(r io.Reader, smoothAngle float64) ([]*Triangle, error) {
+	return readSTL(r, smoothAngle, nil)
+}
+
+// readSTL reads the facets of an STL file as ReadSTL does. If velocities
+// isn't nil, it holds the velocities of the distinct vertices of the file,
+// in the order they first appear, and the triangles move with them.
+func readSTL(r io.Reader, smoothAngle float64, velocities []math3d.Vector3) ([]*Triangle, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var facets [][3]math3d.Vector3
+	// Binary files may start with "solid" too, but their size gives them
+	// away
+	if len(data) >= 84 && 84+50*int64(binary.LittleEndian.Uint32(data[80:])) == int64(len(data)) {
+		facets, err = binarySTL(data)
+	} else if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("solid")) {
+		facets, err = asciiSTL(data)
+	} else {
+		err = fmt.Errorf("not an STL file, or a truncated binary one")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The vertices are told apart by their bits, as the ones of packed
+	// meshes are
+	indices := make(map[[3]uint64]int)
+	var vertices []math3d.Vector3
+	faces := make([][3]corner, len(facets))
+	for i, f := range facets {
+		for j, v := range f {
+			key := [3]uint64{math.Float64bits(v.X), math.Float64bits(v.Y), math.Float64bits(v.Z)}
+			index, ok := indices[key]
+			if !ok {
+				index = len(vertices)
+				indices[key] = index
+				vertices = append(vertices, v)
+			}
+			faces[i][j] = corner{vertex: index, uv: -1, normal: -1}
+		}
+	}
+	if velocities != nil && len(velocities) != len(vertices) {
+		return nil, fmt.Errorf("there are %d velocities for %d vertices", len(velocities), len(vertices))
+	}
+	return triangulate(vertices, velocities, nil, nil, nil, faces, smoothAngle), nil
+}
+
+// binarySTL returns the facets of a binary STL file: a header of 80 bytes,
+// the number of facets and 50 bytes for every facet, with its normal, its
+// vertices and 2 bytes of attributes
+func binarySTL(data []byte) ([][3]math3d.Vector3, error) {
+	facets := make([][3]math3d.Vector3, binary.LittleEndian.Uint32(data[80:]))
+	for i := range facets {
+		facet := data[84+50*i:]
+		for j := range facets[i] {
+			var coordinates [3]float64
+			for k := range coordinates {
+				n := float64(math.Float32frombits(binary.LittleEndian.Uint32(facet[12+12*j+4*k:])))
+				if math.IsNaN(n) || math.IsInf(n, 0) {
+					return nil, fmt.Errorf("facet %d: the vertices must be finite", i)
+				}
+				coordinates[k] = n
+			}
+			facets[i][j] = math3d.Vector3{X: coordinates[0], Y: coordinates[1], Z: coordinates[2]}
+		}
+	}
+	return facets, nil
+}
+
+// asciiSTL returns the facets of an ASCII STL file. Facets with more than
+// 3 vertices are split into fans.
+func asciiSTL(data []byte) ([][3]math3d.Vector3, error) {
+	var facets [][3]math3d.Vector3
+	var loop []math3d.Vector3
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "vertex":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: vertex needs 3 coordinates", line)
+			}
+			var coordinates [3]float64
+			for i := range coordinates {
+				n, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+					return nil, fmt.Errorf("line %d: %q is not a valid number", line, fields[i+1])
+				}
+				coordinates[i] = n
+			}
+			loop = append(loop, math3d.Vector3{X: coordinates[0], Y: coordinates[1], Z: coordinates[2]})
+		case "endloop":
+			if len(loop) < 3 {
+				return nil, fmt.Errorf("line %d: a facet needs at least 3 vertices", line)
+			}
+			for i := 1; i+1 < len(loop); i++ {
+				facets = append(facets, [3]math3d.Vector3{loop[0], loop[i], loop[i+1]})
+			}
+			loop = loop[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(facets) == 0 {
+		// Binary files that start with "solid" end up here if they're
+		// cut short
+		return nil, fmt.Errorf("the STL file has no facets, or it's a truncated binary one")
+	}
+	return facets, nil
+}
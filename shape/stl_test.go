@@ -0,0 +1,94 @@
+package shape
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// tetrahedron is the facets of a tetrahedron whose front faces look out
+var tetrahedron = [][3]math3d.Vector3{
+	{{}, {Y: 1}, {X: 1}},
+	{{}, {X: 1}, {Z: 1}},
+	{{}, {Z: 1}, {Y: 1}},
+	{{X: 1}, {Y: 1}, {Z: 1}},
+}
+
+func TestReadSTL(t *testing.T) {
+	var ascii strings.Builder
+	ascii.WriteString("solid tetrahedron\n")
+	for _, f := range tetrahedron {
+		ascii.WriteString("  facet normal 0 0 0\n    outer loop\n")
+		for _, v := range f {
+			fmt.Fprintf(&ascii, "      vertex %g %g %g\n", v.X, v.Y, v.Z)
+		}
+		ascii.WriteString("    endloop\n  endfacet\n")
+	}
+	ascii.WriteString("endsolid tetrahedron\n")
+	// Binary files may start with "solid" too
+	var b bytes.Buffer
+	b.WriteString("solid binary")
+	b.Write(make([]byte, 80-b.Len()))
+	binary.Write(&b, binary.LittleEndian, uint32(len(tetrahedron)))
+	for _, f := range tetrahedron {
+		binary.Write(&b, binary.LittleEndian, [3]float32{})
+		for _, v := range f {
+			binary.Write(&b, binary.LittleEndian, [3]float32{float32(v.X), float32(v.Y), float32(v.Z)})
+		}
+		binary.Write(&b, binary.LittleEndian, uint16(0))
+	}
+
+	for name, data := range map[string]string{"ASCII": ascii.String(), "binary": b.String()} {
+		triangles, err := ReadSTL(strings.NewReader(data), DefaultSmoothAngle)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(triangles) != 4 || triangles[3].Vertices != tetrahedron[3] {
+			t.Fatalf("%s: Expected the 4 facets of the file, not %v", name, triangles)
+		}
+		// The facets meet at more than the smoothing angle, so they're
+		// joined at the vertices but shaded flat
+		center := math3d.Vector3{X: 1.0 / 3, Y: 1.0 / 3, Z: 1.0 / 3}
+		if n := triangles[3].NormalAt(&center); math.Abs(n.X-1/math.Sqrt(3)) > 1e-9 {
+			t.Errorf("%s: The slanted facet should face out, not %v", name, n)
+		}
+		smooth, _ := ReadSTL(strings.NewReader(data), 180)
+		if n := smooth[0].Normals[0]; n.X >= 0 || n.Y >= 0 || n.Z >= 0 || math.Abs(n.Abs()-1) > 1e-9 {
+			t.Errorf("%s: The facets should be smoothed across the vertices they share, not %v", name, n)
+		}
+	}
+	if _, err := readSTL(strings.NewReader(ascii.String()), 0, make([]math3d.Vector3, 4)); err != nil {
+		t.Errorf("The 4 distinct vertices should have a velocity each: %v", err)
+	}
+	for _, bad := range []string{"", "ply\n", "solid\nfacet\nouter loop\nvertex 0 0 0\nvertex 1 0 0\nendloop\n", "solid\nvertex 0 0 x\n", b.String()[:100]} {
+		if _, err := ReadSTL(strings.NewReader(bad), 0); err == nil {
+			t.Errorf("%q should be an error", bad)
+		}
+	}
+}
+
+func FuzzReadSTL(f *testing.F) {
+	f.Add([]byte("solid triangle\nfacet normal 0 0 -1\nouter loop\nvertex 0 0 0\nvertex 1 0 0\nvertex 0 1 0\nendloop\nendfacet\nendsolid triangle\n"))
+	var b bytes.Buffer
+	b.Write(make([]byte, 80))
+	binary.Write(&b, binary.LittleEndian, uint32(len(tetrahedron)))
+	for _, facet := range tetrahedron {
+		binary.Write(&b, binary.LittleEndian, [3]float32{})
+		for _, v := range facet {
+			binary.Write(&b, binary.LittleEndian, [3]float32{float32(v.X), float32(v.Y), float32(v.Z)})
+		}
+		binary.Write(&b, binary.LittleEndian, uint16(0))
+	}
+	f.Add(b.Bytes())
+	f.Fuzz(func(t *testing.T, stl []byte) {
+		triangles, err := ReadSTL(bytes.NewReader(stl), DefaultSmoothAngle)
+		if err == nil {
+			checkLoaded(t, triangles)
+		}
+	})
+}
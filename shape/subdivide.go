@@ -0,0 +1,212 @@
+package shape
+
+import (
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// MaxSubdivision is the most levels meshes are subdivided, since every
+// level makes four times as many triangles
+const MaxSubdivision = 6
+
+// stencil is a point of a subdivided mesh as the weights of the vertices
+// of the mesh before it's subdivided that make it
+type stencil []weight
+
+type weight struct {
+	vertex int
+	weight float64
+}
+
+// Subdivide refines the triangles levels times with Loop subdivision, so
+// that smooth surfaces modelled with few triangles, such as the cages of
+// quad meshes whose faces are split in triangles, render as the limit
+// surface they approximate. Every level splits every triangle in four and
+// moves the vertices towards the average of their neighbours. The edges
+// where the triangles are more than smoothAngle degrees apart are creases,
+// which stay sharp and bend only along themselves, so hard edges don't
+// melt, and so are the edges of the boundary. The vertices where more
+// than two creases meet are corners, which don't move.
+//
+// The velocities and the colors of the vertices are subdivided as their
+// positions are, and the texture coordinates are interpolated across every
+// triangle, so the triangles without any have none either. The normals are
+// generated again from the faces with the smoothing angle, as ReadOBJ
+// does. The triangles that come from one keep its name, material, opacity
+// and the rest of its fields.
+func Subdivide(triangles []*Triangle, levels int, smoothAngle float64) []*Triangle {
+	for level := 0; level < levels; level++ {
+		triangles = subdivide(triangles, smoothAngle)
+	}
+	return triangles
+}
+
+// edge is an edge of a mesh, by the indices of its vertices in order
+type edge [2]int
+
+func edgeOf(a, b int) edge {
+	if a > b {
+		a, b = b, a
+	}
+	return edge{a, b}
+}
+
+// subdivide makes one level of Loop subdivision of the triangles
+func subdivide(triangles []*Triangle, smoothAngle float64) []*Triangle {
+	// The vertices shared by the triangles, told apart by their bits, as
+	// the faces of mesh files share them
+	indices := make(map[[3]uint64]int)
+	var positions, velocities []math3d.Vector3
+	var colors []image.Color
+	faces := make([][3]int, len(triangles))
+	for i, t := range triangles {
+		for j, v := range t.Vertices {
+			key := [3]uint64{math.Float64bits(v.X), math.Float64bits(v.Y), math.Float64bits(v.Z)}
+			index, ok := indices[key]
+			if !ok {
+				index = len(positions)
+				indices[key] = index
+				positions = append(positions, v)
+				velocities = append(velocities, t.Velocities[j])
+				colors = append(colors, t.Colors[j])
+			}
+			faces[i][j] = index
+		}
+	}
+
+	// The faces around every edge, and whether it's a crease. The edges
+	// are in the order the faces reach them, so the points are summed in
+	// the same order every time and the subdivision is the same to the bit.
+	var edges []edge
+	around := make(map[edge][]int)
+	for i, f := range faces {
+		for j := range f {
+			e := edgeOf(f[j], f[(j+1)%3])
+			if _, ok := around[e]; !ok {
+				edges = append(edges, e)
+			}
+			around[e] = append(around[e], i)
+		}
+	}
+	cosine := math.Cos(smoothAngle * math.Pi / 180)
+	normal := func(f [3]int) math3d.Vector3 {
+		a, b, c := positions[f[0]], positions[f[1]], positions[f[2]]
+		return b.SubtractV(a).CrossV(c.SubtractV(a)).NormalizedV()
+	}
+	sharp := func(fs []int) bool {
+		return len(fs) != 2 || normal(faces[fs[0]]).DotV(normal(faces[fs[1]])) < cosine
+	}
+	creases := make(map[int][]int)
+	neighbours := make(map[int][]int)
+	for _, e := range edges {
+		neighbours[e[0]] = append(neighbours[e[0]], e[1])
+		neighbours[e[1]] = append(neighbours[e[1]], e[0])
+		if sharp(around[e]) {
+			creases[e[0]] = append(creases[e[0]], e[1])
+			creases[e[1]] = append(creases[e[1]], e[0])
+		}
+	}
+
+	// The points at the vertices, moved towards their neighbours
+	stencils := make([]stencil, len(positions), len(positions)+len(edges))
+	for v := range positions {
+		switch crease := creases[v]; {
+		case len(crease) == 2:
+			stencils[v] = stencil{{v, 0.75}, {crease[0], 0.125}, {crease[1], 0.125}}
+		case len(crease) > 2:
+			stencils[v] = stencil{{v, 1}}
+		default:
+			n := len(neighbours[v])
+			beta := 3 / (8 * float64(n))
+			if n == 3 {
+				beta = 3.0 / 16
+			}
+			s := stencil{{v, 1 - float64(n)*beta}}
+			for _, u := range neighbours[v] {
+				s = append(s, weight{u, beta})
+			}
+			stencils[v] = s
+		}
+	}
+	// The points on the edges, between their vertices and the ones across
+	// the faces on either side of smooth edges
+	split := make(map[edge]int, len(edges))
+	for _, e := range edges {
+		fs := around[e]
+		split[e] = len(stencils)
+		if sharp(fs) {
+			stencils = append(stencils, stencil{{e[0], 0.5}, {e[1], 0.5}})
+			continue
+		}
+		s := stencil{{e[0], 0.375}, {e[1], 0.375}}
+		for _, f := range fs {
+			for _, v := range faces[f] {
+				if v != e[0] && v != e[1] {
+					s = append(s, weight{v, 0.125})
+				}
+			}
+		}
+		stencils = append(stencils, s)
+	}
+
+	newPositions := make([]math3d.Vector3, len(stencils))
+	newVelocities := make([]math3d.Vector3, len(stencils))
+	newColors := make([]image.Color, len(stencils))
+	for i, s := range stencils {
+		for _, w := range s {
+			newPositions[i].AddInPlace(positions[w.vertex].MultiplyV(w.weight))
+			newVelocities[i].AddInPlace(velocities[w.vertex].MultiplyV(w.weight))
+			newColors[i].R += colors[w.vertex].R * w.weight
+			newColors[i].G += colors[w.vertex].G * w.weight
+			newColors[i].B += colors[w.vertex].B * w.weight
+		}
+	}
+
+	// Every triangle is split in the three at its corners and the one in
+	// the middle, with corners in the same order so they face the same way
+	var corners [][3]corner
+	var uvs []math3d.Vector2
+	parents := make([]*Triangle, 0, 4*len(triangles))
+	for i, t := range triangles {
+		f, uv := faces[i], t.UVs
+		// The corners of the triangle and then the middles of its edges
+		var points [6]corner
+		for j := 0; j < 3; j++ {
+			k := (j + 1) % 3
+			points[j] = corner{vertex: f[j], uv: len(uvs) + j, normal: -1}
+			points[3+j] = corner{vertex: split[edgeOf(f[j], f[k])], uv: len(uvs) + 3 + j, normal: -1}
+		}
+		for j := 0; j < 3; j++ {
+			uvs = append(uvs, uv[j])
+		}
+		for j := 0; j < 3; j++ {
+			k := (j + 1) % 3
+			uvs = append(uvs, math3d.Vector2{X: (uv[j].X + uv[k].X) / 2, Y: (uv[j].Y + uv[k].Y) / 2})
+		}
+		corners = append(corners,
+			[3]corner{points[0], points[3], points[5]},
+			[3]corner{points[3], points[1], points[4]},
+			[3]corner{points[5], points[4], points[2]},
+			[3]corner{points[3], points[4], points[5]})
+		parents = append(parents, t, t, t, t)
+	}
+
+	// triangulate leaves out the triangles with no area, so the parents
+	// of the ones it keeps are found again
+	var areas []*Triangle
+	for i, c := range corners {
+		a, b, d := newPositions[c[0].vertex], newPositions[c[1].vertex], newPositions[c[2].vertex]
+		if b.SubtractV(a).CrossV(d.SubtractV(a)).AbsSquared() != 0 {
+			areas = append(areas, parents[i])
+		}
+	}
+	subdivided := triangulate(newPositions, newVelocities, uvs, nil, newColors, corners, smoothAngle)
+	for i, t := range subdivided {
+		parent := areas[i]
+		t.Name, t.Material, t.Opacity = parent.Name, parent.Material, parent.Opacity
+		t.CullBackfaces, t.Shutter = parent.CullBackfaces, parent.Shutter
+	}
+	return subdivided
+}
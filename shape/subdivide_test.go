@@ -0,0 +1,91 @@
+package shape
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// cube is a cube from -1 to 1 with its quads facing out
+const cube = `v -1 -1 -1
+v 1 -1 -1
+v 1 1 -1
+v -1 1 -1
+v -1 -1 1
+v 1 -1 1
+v 1 1 1
+v -1 1 1
+f 1 4 3 2
+f 5 6 7 8
+f 1 2 6 5
+f 2 3 7 6
+f 3 4 8 7
+f 4 1 5 8
+`
+
+func TestSubdivideRoundsLowPolySpheres(t *testing.T) {
+	triangles, err := LoadOBJ("../scene-examples/icosphere.obj", DefaultSmoothAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	center := math3d.Vector3{Y: -0.1, Z: 1}
+	// How far from a sphere the triangles are, as the ratio of the
+	// distances from the center of their centroids and of their vertices
+	roundness := func(triangles []*Triangle) float64 {
+		worst := 1.0
+		for _, tri := range triangles {
+			centroid := tri.Vertices[0].AddV(tri.Vertices[1]).AddV(tri.Vertices[2]).MultiplyV(1.0 / 3)
+			worst = math.Min(worst, centroid.SubtractV(center).Abs()/tri.Vertices[0].SubtractV(center).Abs())
+		}
+		return worst
+	}
+	subdivided := Subdivide(triangles, 2, DefaultSmoothAngle)
+	if len(subdivided) != 16*len(triangles) {
+		t.Fatalf("Every level should split every triangle in 4, not make %d triangles of %d", len(subdivided), len(triangles))
+	}
+	if before, after := roundness(triangles), roundness(subdivided); after <= before || after < 0.97 {
+		t.Errorf("The subdivided sphere should be rounder, its centroids are %.4f of the way out, and %.4f before", after, before)
+	}
+	for _, tri := range subdivided {
+		outwards := tri.Vertices[0].SubtractV(center)
+		if n := tri.geometricNormal(); n.DotV(outwards) <= 0 || !tri.smooth() {
+			t.Fatal("The triangles should keep facing out, and be smooth")
+		}
+	}
+	if again := Subdivide(triangles, 2, DefaultSmoothAngle); !reflect.DeepEqual(again, subdivided) {
+		t.Error("Subdividing the same triangles should give the same triangles to the bit")
+	}
+}
+
+func TestSubdivideKeepsCreases(t *testing.T) {
+	triangles, err := ReadOBJ(strings.NewReader(cube), DefaultSmoothAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	triangles[0].Name = "box"
+	subdivided := Subdivide(triangles, 2, DefaultSmoothAngle)
+	// The edges of a cube are sharper than the smoothing angle, so it
+	// stays a cube, only with more triangles
+	for _, tri := range subdivided {
+		for _, v := range tri.Vertices {
+			if on := math.Max(math.Abs(v.X), math.Max(math.Abs(v.Y), math.Abs(v.Z))); math.Abs(on-1) > 1e-12 {
+				t.Fatalf("The vertex %s should stay on a face of the cube", v.String())
+			}
+		}
+	}
+	if len(subdivided) != 16*len(triangles) || subdivided[0].Name != "box" || subdivided[16].Name != "" {
+		t.Error("The triangles should keep the fields of the ones they come from")
+	}
+	// Smoothing every edge rounds the cube, pulling its corners in
+	rounded := Subdivide(triangles, 1, 180)
+	for _, tri := range rounded {
+		for _, v := range tri.Vertices {
+			if math.Abs(v.X) > 0.99 && math.Abs(v.Y) > 0.99 && math.Abs(v.Z) > 0.99 {
+				t.Fatalf("The corners of a smooth cube should be rounded, not at %s", v.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package shape
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+// Triangle defines a flat triangle, usually one of the many of a mesh. If
+// it has normals at its vertices, they are interpolated across it, so
+// meshes look smooth even if they have few triangles.
+type Triangle struct {
+	// Vertices are the corners of the triangle. Its front face is the one
+	// that sees them counterclockwise.
+	Vertices [3]math3d.Vector3 `json:"vertices"`
+	// Normals are the unit normals at the vertices, or all zero if the
+	// triangle is shaded flat
+	Normals [3]math3d.Vector3 `json:"normals"`
+	// UVs are the texture coordinates of the vertices. If they are all
+	// zero the vertices are at (0, 0), (1, 0) and (0, 1).
+	UVs [3]math3d.Vector2 `json:"uvs"`
+	// Colors are the linear colors of the vertices, such as the ones of a
+	// scan, which tint the albedo of the material where they're
+	// interpolated, or all zero if the triangle has none, so triangles
+	// whose vertices are all black aren't tinted
+	Colors [3]image.Color `json:"colors"`
+	Name   string         `json:"name,omitempty"`
+	// Material is the material of the surface, the default one if nil
+	Material material.Material `json:"material,omitempty"`
+	// Velocities are the velocities of the vertices in units per second,
+	// such as the ones of a simulation cache, or all zero if the triangle
+	// doesn't move. The vertices are where they are when the shutter
+	// opens and move in straight lines while it's open, so renders whose
+	// shutter stays open blur the triangle. It's shaded as it is when the
+	// shutter opens.
+	Velocities [3]math3d.Vector3 `json:"velocities"`
+	// Opacity cuts holes in the surface, which has none if it's nil
+	Opacity *Opacity `json:"-"`
+	// CullBackfaces makes the lightrays that hit the back face go through
+	CullBackfaces bool `json:"-"`
+	// Shutter is how many seconds the shutter stays open, which the
+	// bounds of a moving triangle cover
+	Shutter float64 `json:"-"`
+}
+
+// Intersect returns the distance at which the lightray intersects the
+// triangle, where it is at the time of the lightray
+func (t *Triangle) Intersect(lr *math3d.LightRay) float64 {
+	if t.moving() {
+		a, b, c := t.verticesAt(lr.Time)
+		return intersectTriangle(lr, &a, &b, &c, t.CullBackfaces)
+	}
+	return intersectTriangle(lr, &t.Vertices[0], &t.Vertices[1], &t.Vertices[2], t.CullBackfaces)
+}
+
+// moving returns whether the vertices of the triangle have velocities
+func (t *Triangle) moving() bool {
+	return t.Velocities != [3]math3d.Vector3{}
+}
+
+// verticesAt returns the vertices of the triangle at the time after the
+// shutter opens
+func (t *Triangle) verticesAt(time float64) (a, b, c math3d.Vector3) {
+	return t.Vertices[0].AddV(t.Velocities[0].MultiplyV(time)),
+		t.Vertices[1].AddV(t.Velocities[1].MultiplyV(time)),
+		t.Vertices[2].AddV(t.Velocities[2].MultiplyV(time))
+}
+
+// intersectTriangle returns the distance at which the lightray intersects
+// the triangle a, b, c, ignoring its back face if cull is true. The front
+// face is the one that sees a, b and c counterclockwise.
+//
+// It's the watertight algorithm of Woop, Benthin and Wald: the triangle is
+// moved into a space where the lightray starts at the origin and goes
+// along Z, and the lightray hits it if the origin is inside its projection
+// onto the XY plane. The edge functions of two triangles that share an
+// edge are computed from the same values, so rays through the edge can't
+// slip between them as they can with Möller-Trumbore.
+func intersectTriangle(lr *math3d.LightRay, a, b, c *math3d.Vector3, cull bool) float64 {
+	// The axis the lightray goes the most along becomes Z, and the other
+	// two are rotated with it so that the space isn't mirrored
+	dx, dy, dz := math.Abs(lr.Direction.X), math.Abs(lr.Direction.Y), math.Abs(lr.Direction.Z)
+	permute := func(v math3d.Vector3) math3d.Vector3 { return v }
+	switch {
+	case dx > dy && dx > dz:
+		permute = func(v math3d.Vector3) math3d.Vector3 { return math3d.Vector3{X: v.Y, Y: v.Z, Z: v.X} }
+	case dy > dz:
+		permute = func(v math3d.Vector3) math3d.Vector3 { return math3d.Vector3{X: v.Z, Y: v.X, Z: v.Y} }
+	}
+	direction := permute(lr.Direction)
+	p0, p1, p2 := permute(a.SubtractV(lr.Source)), permute(b.SubtractV(lr.Source)), permute(c.SubtractV(lr.Source))
+	// Shearing so that the lightray goes along Z
+	sx, sy, sz := -direction.X/direction.Z, -direction.Y/direction.Z, 1/direction.Z
+	p0.X, p0.Y = p0.X+sx*p0.Z, p0.Y+sy*p0.Z
+	p1.X, p1.Y = p1.X+sx*p1.Z, p1.Y+sy*p1.Z
+	p2.X, p2.Y = p2.X+sx*p2.Z, p2.Y+sy*p2.Z
+
+	e0 := p1.X*p2.Y - p1.Y*p2.X
+	e1 := p2.X*p0.Y - p2.Y*p0.X
+	e2 := p0.X*p1.Y - p0.Y*p1.X
+	if (e0 < 0 || e1 < 0 || e2 < 0) && (e0 > 0 || e1 > 0 || e2 > 0) {
+		return math.MaxFloat64
+	}
+	// The determinant is the Z of the normal in the sheared space, whose
+	// sign against the direction tells the face that the lightray hits
+	determinant := e0 + e1 + e2
+	if determinant == 0 || cull && determinant*direction.Z > 0 {
+		return math.MaxFloat64
+	}
+	scaled := e0*p0.Z*sz + e1*p1.Z*sz + e2*p2.Z*sz
+	return math3d.DiscardIfTooClose(scaled / determinant)
+}
+
+// smooth returns whether the triangle has normals at its vertices
+func (t *Triangle) smooth() bool {
+	return t.Normals != [3]math3d.Vector3{}
+}
+
+// geometricNormal returns the unit normal of the plane of the triangle, on
+// the side of its front face
+func (t *Triangle) geometricNormal() math3d.Vector3 {
+	return t.Vertices[1].SubtractV(t.Vertices[0]).CrossV(t.Vertices[2].SubtractV(t.Vertices[0])).NormalizedV()
+}
+
+// barycentric returns the weights of the vertices of the triangle at the
+// point of its plane nearest to point
+func (t *Triangle) barycentric(point *math3d.Vector3) [3]float64 {
+	edge1, edge2 := t.Vertices[1].SubtractV(t.Vertices[0]), t.Vertices[2].SubtractV(t.Vertices[0])
+	toPoint := point.SubtractV(t.Vertices[0])
+	d11, d12, d22 := edge1.DotV(edge1), edge1.DotV(edge2), edge2.DotV(edge2)
+	dp1, dp2 := toPoint.DotV(edge1), toPoint.DotV(edge2)
+	denominator := d11*d22 - d12*d12
+	if denominator == 0 {
+		return [3]float64{1, 0, 0}
+	}
+	v := (d22*dp1 - d12*dp2) / denominator
+	w := (d11*dp2 - d12*dp1) / denominator
+	return [3]float64{1 - v - w, v, w}
+}
+
+// NormalAt returns the normal vector of a point of the triangle, which
+// interpolates the normals of the vertices if it has them
+func (t *Triangle) NormalAt(point *math3d.Vector3) math3d.Vector3 {
+	if !t.smooth() {
+		return t.geometricNormal()
+	}
+	weights := t.barycentric(point)
+	normal := math3d.Vector3{}
+	for i, w := range weights {
+		normal.AddInPlace(t.Normals[i].MultiplyV(w))
+	}
+	if normal.AbsSquared() == 0 {
+		normal = t.geometricNormal()
+	}
+	normal.NormalizeInPlace()
+	return normal
+}
+
+// TextureAt returns the texture coordinates of a point of the triangle,
+// interpolating those of the vertices
+func (t *Triangle) TextureAt(point *math3d.Vector3) (u, v float64) {
+	weights := t.barycentric(point)
+	if t.UVs == [3]math3d.Vector2{} {
+		return weights[1], weights[2]
+	}
+	for i, w := range weights {
+		u, v = u+w*t.UVs[i].X, v+w*t.UVs[i].Y
+	}
+	return u, v
+}
+
+// colored returns whether the vertices of the triangle have colors
+func (t *Triangle) colored() bool {
+	return t.Colors != [3]image.Color{}
+}
+
+// ColorAt returns the color of a point of the triangle, interpolating
+// those of the vertices, if they have any
+func (t *Triangle) ColorAt(point *math3d.Vector3) (image.Color, bool) {
+	if !t.colored() {
+		return image.Color{}, false
+	}
+	weights := t.barycentric(point)
+	c := image.Color{}
+	for i, w := range weights {
+		c = *c.Add(t.Colors[i].Multiply(w))
+	}
+	return c, true
+}
+
+// Opaque returns whether the triangle is there where the lightray hits it
+// at distance, rather than a hole of its opacity map
+func (t *Triangle) Opaque(lr *math3d.LightRay, distance float64) bool {
+	return opaqueAt(t, t.Opacity, lr, distance)
+}
+
+// ShadowOrigin returns the point from which the rays towards the lights
+// leave the triangle at point. Like the ones of heightfields, smooth
+// triangles move it above the planes of the vertices the point is below.
+func (t *Triangle) ShadowOrigin(point *math3d.Vector3) math3d.Vector3 {
+	origin := *point
+	if !t.smooth() {
+		return origin
+	}
+	weights := t.barycentric(point)
+	for i, w := range weights {
+		if below := point.SubtractV(t.Vertices[i]).DotV(t.Normals[i]); below < 0 {
+			origin.SubtractInPlace(t.Normals[i].MultiplyV(w * below))
+		}
+	}
+	return origin
+}
+
+// Bounds returns the bounding box of the triangle, wherever it is while
+// the shutter is open
+func (t *Triangle) Bounds() *math3d.AABB {
+	bounds := math3d.EmptyAABB()
+	for i := range t.Vertices {
+		bounds = bounds.Expand(&t.Vertices[i])
+	}
+	if t.moving() && t.Shutter > 0 {
+		a, b, c := t.verticesAt(t.Shutter)
+		bounds = bounds.Expand(&a).Expand(&b).Expand(&c)
+	}
+	return bounds
+}
+
+// ClippedBounds returns the bounding box of the part of the triangle inside
+// the box, which is much smaller than the box and the bounds of the
+// triangle when a long thin triangle crosses the box diagonally, as the
+// spatial splits of BVHs need. Moving triangles are clipped where they
+// are while the shutter is open, as their bounds are.
+func (t *Triangle) ClippedBounds(box *math3d.AABB) *math3d.AABB {
+	if t.moving() && t.Shutter > 0 {
+		return intersection(t.Bounds(), box)
+	}
+	return clippedBounds(t.Vertices[:], box)
+}
+
+// clippedBounds returns the bounding box of the part of the convex polygon
+// inside the box, clipping it against the planes of the faces of the box
+// one after another. It's empty if the polygon misses the box.
+func clippedBounds(polygon []math3d.Vector3, box *math3d.AABB) *math3d.AABB {
+	// A triangle clipped by the 6 planes has at most 9 vertices
+	var buffers [2][9]math3d.Vector3
+	current := append(buffers[0][:0], polygon...)
+	next := buffers[1][:0]
+	for axis := 0; axis < 3; axis++ {
+		for _, side := range [2]float64{1, -1} {
+			plane := component(&box.Min, axis)
+			if side < 0 {
+				plane = component(&box.Max, axis)
+			}
+			// distance is how far the point is inside the plane
+			distance := func(p *math3d.Vector3) float64 {
+				return side * (component(p, axis) - plane)
+			}
+			next = next[:0]
+			for i := range current {
+				a, b := &current[i], &current[(i+1)%len(current)]
+				da, db := distance(a), distance(b)
+				if da >= 0 {
+					next = append(next, *a)
+				}
+				if (da < 0) != (db < 0) {
+					crossing := math3d.Lerp(*a, *b, da/(da-db))
+					// The crossing is on the plane, whatever the rounding
+					setComponent(&crossing, axis, plane)
+					next = append(next, crossing)
+				}
+			}
+			current, next = next, current
+			if len(current) == 0 {
+				return math3d.EmptyAABB()
+			}
+		}
+	}
+	bounds := math3d.EmptyAABB()
+	for i := range current {
+		bounds = bounds.Expand(&current[i])
+	}
+	return intersection(bounds, box)
+}
+
+// intersection returns the box that both boxes hold, which is empty if
+// they don't overlap
+func intersection(a, b *math3d.AABB) *math3d.AABB {
+	box := &math3d.AABB{
+		Min: math3d.Vector3{X: math.Max(a.Min.X, b.Min.X), Y: math.Max(a.Min.Y, b.Min.Y), Z: math.Max(a.Min.Z, b.Min.Z)},
+		Max: math3d.Vector3{X: math.Min(a.Max.X, b.Max.X), Y: math.Min(a.Max.Y, b.Max.Y), Z: math.Min(a.Max.Z, b.Max.Z)}}
+	if !box.Min.LesserOrEqual(&box.Max) {
+		return math3d.EmptyAABB()
+	}
+	return box
+}
+
+func component(v *math3d.Vector3, axis int) float64 {
+	switch axis {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
+
+func setComponent(v *math3d.Vector3, axis int, value float64) {
+	switch axis {
+	case 0:
+		v.X = value
+	case 1:
+		v.Y = value
+	default:
+		v.Z = value
+	}
+}
+
+// Surface returns the material of the triangle
+func (t *Triangle) Surface() material.Material {
+	return t.Material
+}
+
+// Transform transforms the triangle by the affine matrix. Transforms that
+// mirror it swap two of its vertices, so that its front face stays on the
+// same side of the surface.
+func (t *Triangle) Transform(m *math3d.Matrix) {
+	smooth := t.smooth()
+	for i := range t.Vertices {
+		t.Vertices[i] = *m.MultiplyPoint(&t.Vertices[i])
+		t.Velocities[i] = *m.MultiplyVector(&t.Velocities[i])
+		if smooth {
+			t.Normals[i] = m.MultiplyNormal(&t.Normals[i]).NormalizedV()
+		}
+	}
+	if m.Determinant() < 0 {
+		if t.UVs == [3]math3d.Vector2{} {
+			t.UVs = [3]math3d.Vector2{{}, {X: 1}, {Y: 1}}
+		}
+		t.Vertices[1], t.Vertices[2] = t.Vertices[2], t.Vertices[1]
+		t.Normals[1], t.Normals[2] = t.Normals[2], t.Normals[1]
+		t.UVs[1], t.UVs[2] = t.UVs[2], t.UVs[1]
+		t.Colors[1], t.Colors[2] = t.Colors[2], t.Colors[1]
+		t.Velocities[1], t.Velocities[2] = t.Velocities[2], t.Velocities[1]
+	}
+}
+
+// Translate moves the triangle by offset
+func (t *Triangle) Translate(offset *math3d.Vector3) {
+	for i := range t.Vertices {
+		t.Vertices[i] = t.Vertices[i].AddV(*offset)
+	}
+}
+
+// AsMap returns a map representation of this shape
+func (t *Triangle) AsMap() map[string]interface{} {
+	vertices := make([]map[string]float64, 0, 3)
+	for i := range t.Vertices {
+		vertices = append(vertices, t.Vertices[i].AsMap())
+	}
+	m := map[string]interface{}{"type": "triangle", "vertices": vertices}
+	if t.smooth() {
+		normals := make([]map[string]float64, 0, 3)
+		for i := range t.Normals {
+			normals = append(normals, t.Normals[i].AsMap())
+		}
+		m["normals"] = normals
+	}
+	if t.UVs != [3]math3d.Vector2{} {
+		uvs := make([]map[string]float64, 0, 3)
+		for _, uv := range t.UVs {
+			uvs = append(uvs, map[string]float64{"x": uv.X, "y": uv.Y})
+		}
+		m["uvs"] = uvs
+	}
+	if t.colored() {
+		colors := make([]map[string]float64, 0, 3)
+		for _, c := range t.Colors {
+			colors = append(colors, map[string]float64{"r": c.R, "g": c.G, "b": c.B})
+		}
+		m["colors"] = colors
+	}
+	if t.moving() {
+		velocities := make([]map[string]float64, 0, 3)
+		for i := range t.Velocities {
+			velocities = append(velocities, t.Velocities[i].AsMap())
+		}
+		m["velocities"] = velocities
+	}
+	if t.Name != "" {
+		m["name"] = t.Name
+	}
+	if t.Material != nil {
+		m["material"] = t.Material.AsMap()
+	}
+	addOpacityToMap(m, t.Opacity)
+	return m
+}
+
+// TriangleFromMap returns a triangle with the values in the map, or an
+// error if any of them is missing or has the wrong type. The normals, the
+// texture coordinates, the colors and the velocities are optional, but
+// there must be one of each for every vertex.
+func TriangleFromMap(themap map[string]interface{}) (*Triangle, error) {
+	d := decoder{m: themap}
+	t := &Triangle{}
+	copy(t.Vertices[:], d.vectors("vertices", 3))
+	if d.has("normals") {
+		for i, n := range d.vectors("normals", 3) {
+			if n.AbsSquared() == 0 {
+				return nil, fmt.Errorf("normals[%d]: can't be zero", i)
+			}
+			// Normalizing the ones that already are might change their
+			// last digits, and the scene wouldn't be saved as it was read
+			if math.Abs(n.AbsSquared()-1) > 1e-12 {
+				n = n.NormalizedV()
+			}
+			t.Normals[i] = n
+		}
+	}
+	if d.has("uvs") {
+		copy(t.UVs[:], d.uvs("uvs", 3))
+	}
+	if d.has("colors") {
+		copy(t.Colors[:], d.colors("colors", 3))
+	}
+	if d.has("velocities") {
+		copy(t.Velocities[:], d.vectors("velocities", 3))
+	}
+	t.Name = d.text("name")
+	t.Material = d.material()
+	t.Opacity = d.opacity()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return t, nil
+}
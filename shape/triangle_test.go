@@ -0,0 +1,143 @@
+package shape
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/ProjectMOA/goraytrace/material"
+	"github.com/ProjectMOA/goraytrace/math3d"
+)
+
+func TestTriangle(t *testing.T) {
+	tri := &Triangle{Vertices: [3]math3d.Vector3{{X: 0, Z: 0}, {X: 0, Z: 1}, {X: 1, Z: 0}}}
+	down := math3d.LightRay{Source: math3d.Vector3{X: 0.2, Y: 2, Z: 0.3}, Direction: math3d.Vector3{Y: -1}}
+	if d := tri.Intersect(&down); math.Abs(d-2) > 1e-12 {
+		t.Errorf("The lightray should hit the triangle at D=2.0 but it hits at %.3f", d)
+	}
+	if n := tri.NormalAt(&math3d.Vector3{X: 0.2, Z: 0.3}); !n.Equal(&math3d.UnitY) {
+		t.Error("The normal should point out of the front face, not " + n.String())
+	}
+	outside := math3d.LightRay{Source: math3d.Vector3{X: 0.8, Y: 2, Z: 0.8}, Direction: math3d.Vector3{Y: -1}}
+	if tri.Intersect(&outside) != math.MaxFloat64 {
+		t.Error("The lightray should miss the triangle")
+	}
+	up := math3d.LightRay{Source: math3d.Vector3{X: 0.2, Y: -2, Z: 0.3}, Direction: math3d.UnitY}
+	tri.CullBackfaces = true
+	if tri.Intersect(&up) != math.MaxFloat64 || tri.Intersect(&down) == math.MaxFloat64 {
+		t.Error("Culling, only the front face should be hit")
+	}
+}
+
+func TestSmoothTriangleInterpolatesNormals(t *testing.T) {
+	tilted := [3]math3d.Vector3{
+		math3d.Vector3{X: -1, Y: 1}.NormalizedV(),
+		math3d.Vector3{Y: 1, Z: 1}.NormalizedV(),
+		math3d.Vector3{X: 1, Y: 1}.NormalizedV()}
+	tri := &Triangle{Vertices: [3]math3d.Vector3{{X: 0, Z: 0}, {X: 0, Z: 1}, {X: 1, Z: 0}}, Normals: tilted}
+	for i := range tri.Vertices {
+		if n := tri.NormalAt(&tri.Vertices[i]); math3d.Distance(&n, &tilted[i]) > 1e-12 {
+			t.Errorf("The normal at vertex %d should be its own, not %s", i, n.String())
+		}
+	}
+	center := math3d.Vector3{X: 1.0 / 3, Z: 1.0 / 3}
+	expected := tilted[0].AddV(tilted[1]).AddV(tilted[2]).NormalizedV()
+	if n := tri.NormalAt(&center); math3d.Distance(&n, &expected) > 1e-12 {
+		t.Errorf("The normal at the center should average the vertices, not %s", n.String())
+	}
+	// The normals lean outwards, so the shadows leave from above the plane
+	if origin := tri.ShadowOrigin(&center); !(origin.Y > 0) {
+		t.Errorf("The shadow origin should be moved over the triangle, it's %s", origin.String())
+	}
+}
+
+func TestMirroredTrianglesKeepTheirFrontFace(t *testing.T) {
+	var m map[string]interface{}
+	json.Unmarshal([]byte(`{"type": "triangle",
+		"vertices": [{"x": 0, "y": 0, "z": 0}, {"x": 0, "y": 0, "z": 1}, {"x": 1, "y": 0, "z": 0}],
+		"transform": {"scale": {"x": -1, "y": 1, "z": 1}}}`), &m)
+	shapes, err := FromMap([]map[string]interface{}{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirrored := shapes[0].(*Triangle)
+	point := math3d.Vector3{X: -0.2, Z: 0.3}
+	if n := mirrored.NormalAt(&point); !n.Equal(&math3d.UnitY) {
+		t.Error("The normal should still point up after mirroring, not " + n.String())
+	}
+	if u, v := mirrored.TextureAt(&point); math.Abs(u-0.3) > 1e-12 || math.Abs(v-0.2) > 1e-12 {
+		t.Errorf("The texture should be mirrored with the triangle, it's at %.3f, %.3f", u, v)
+	}
+}
+
+func TestMovingTriangles(t *testing.T) {
+	tri := &Triangle{
+		Vertices:   [3]math3d.Vector3{{X: 0, Z: 0}, {X: 0, Z: 1}, {X: 1, Z: 0}},
+		Velocities: [3]math3d.Vector3{{Y: 2}, {Y: 2}, {Y: 2}},
+		Shutter:    0.5}
+	down := math3d.LightRay{Source: math3d.Vector3{X: 0.2, Y: 2, Z: 0.3}, Direction: math3d.Vector3{Y: -1}, Time: 0.25}
+	if d := tri.Intersect(&down); math.Abs(d-1.5) > 1e-12 {
+		t.Errorf("The lightray should hit the triangle where it has risen to at D=1.5, not %.3f", d)
+	}
+	if b := tri.Bounds(); b.Min.Y != 0 || b.Max.Y != 1 {
+		t.Errorf("The bounds should cover the triangle while the shutter is open, not %s to %s", b.Min.String(), b.Max.String())
+	}
+	data, _ := json.Marshal(tri.AsMap())
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	shapes, err := FromMap([]map[string]interface{}{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := shapes[0].(*Triangle)
+	if moved.Velocities != tri.Velocities {
+		t.Error("The velocities should survive a round trip through a map")
+	}
+}
+
+// shadingRecorder is a material that records the last shading point it
+// was asked for
+type shadingRecorder struct {
+	*material.Lambertian
+	p material.ShadingPoint
+}
+
+func (r *shadingRecorder) At(p *material.ShadingPoint) material.Material {
+	r.p = *p
+	return r.Lambertian
+}
+
+func TestFootprint(t *testing.T) {
+	recorder := &shadingRecorder{Lambertian: &material.Lambertian{}}
+	tri := &Triangle{Vertices: [3]math3d.Vector3{{X: 0, Z: 0}, {X: 0, Z: 1}, {X: 1, Z: 0}}, Material: recorder}
+	down := math3d.LightRay{Source: math3d.Vector3{X: 0.2, Y: 2, Z: 0.3}, Direction: math3d.Vector3{Y: -1},
+		Differential: &math3d.Differential{SourceY: math3d.Vector3{Z: 0.05}, DirectionX: math3d.Vector3{X: 0.01}}}
+	hit := HitAt(tri, &down, tri.Intersect(&down))
+	if !hit.DPDX.Equal(&math3d.Vector3{X: 0.02}) || !hit.DPDY.Equal(&math3d.Vector3{Z: 0.05}) {
+		t.Fatalf("Expected the footprint to span 0.02 in X and 0.05 in Z, not %v and %v", hit.DPDX, hit.DPDY)
+	}
+	// The texture coordinates go from the first vertex to the second and
+	// the third
+	MaterialAtHit(tri, &hit, &math3d.UnitY)
+	if p := recorder.p; math.Abs(p.DUDX) > 1e-12 || math.Abs(p.DVDX-0.02) > 1e-12 || math.Abs(p.DUDY-0.05) > 1e-12 || math.Abs(p.DVDY) > 1e-12 {
+		t.Errorf("Unexpected footprint in texture space %+v", p)
+	}
+	MaterialAt(tri, &hit.Point, &hit.Normal, &math3d.UnitY)
+	if p := recorder.p; p.DUDX != 0 || p.DVDY != 0 || p.DUDY != 0 || p.DVDX != 0 {
+		t.Errorf("Without a footprint the textures shouldn't be filtered, not %+v", p)
+	}
+}
+
+func TestClippedBounds(t *testing.T) {
+	// A thin triangle along the diagonal of the unit square of the XY plane
+	tri := &Triangle{Vertices: [3]math3d.Vector3{{}, {X: 1, Y: 1}, {X: 1, Y: 0.99}}}
+	box := &math3d.AABB{Min: math3d.Vector3{X: 0, Y: 0, Z: -1}, Max: math3d.Vector3{X: 0.25, Y: 1, Z: 1}}
+	clipped := tri.ClippedBounds(box)
+	if math.Abs(clipped.Max.X-0.25) > 1e-12 || math.Abs(clipped.Max.Y-0.25) > 1e-12 || clipped.Min.X != 0 || clipped.Min.Y != 0 {
+		t.Errorf("The triangle should be clipped to the quarter of it left of X=0.25, not %v", clipped)
+	}
+	missed := &math3d.AABB{Min: math3d.Vector3{X: 0.5, Y: 0, Z: -1}, Max: math3d.Vector3{X: 1, Y: 0.25, Z: 1}}
+	if clipped := tri.ClippedBounds(missed); clipped.Min.LesserOrEqual(&clipped.Max) {
+		t.Errorf("The triangle misses the box, so its clipped bounds should be empty, not %v", clipped)
+	}
+}
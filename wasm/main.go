@@ -0,0 +1,121 @@
+//go:build js && wasm
+
+// Command wasm exposes the renderer to JavaScript when compiled to
+// WebAssembly:
+//
+//	GOOS=js GOARCH=wasm go build -o goraytrace.wasm ./wasm
+//
+// Once the module runs (with the wasm_exec.js that comes with Go), it
+// defines a global goraytrace object with two functions:
+//
+//	goraytrace.loadScene(json)
+//		Loads a scene in the JSON scene file format. It returns null, or
+//		the error message if the scene is invalid.
+//	goraytrace.render(width, height, onProgress)
+//		Renders the loaded scene and returns a Promise of an ImageData
+//		that can be drawn with CanvasRenderingContext2D.putImageData.
+//		onProgress is optional and gets the fraction of the frame that
+//		is done every time a tile is finished. The promise is rejected
+//		if the image would have more than 2^26 pixels.
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"github.com/ProjectMOA/goraytrace/image"
+	"github.com/ProjectMOA/goraytrace/render"
+	"github.com/ProjectMOA/goraytrace/scene"
+)
+
+// maxPixels is the most pixels render renders, so that a bad size fails
+// rather than running the module out of memory
+const maxPixels = 1 << 26
+
+var current *scene.Scene
+
+func main() {
+	js.Global().Set("goraytrace", js.ValueOf(map[string]interface{}{
+		"loadScene": js.FuncOf(loadScene),
+		"render":    js.FuncOf(renderScene),
+	}))
+	// Keep the module alive so the functions can be called
+	select {}
+}
+
+func loadScene(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return "loadScene needs the scene JSON"
+	}
+	if err := protect(func() { current = scene.LoadScene([]byte(args[0].String())) }); err != nil {
+		return err.Error()
+	}
+	return nil
+}
+
+func renderScene(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return rejected("render needs the width and height of the image")
+	}
+	if current == nil {
+		return rejected("no scene has been loaded")
+	}
+	if args[0].Type() != js.TypeNumber || args[1].Type() != js.TypeNumber {
+		return rejected("the width and height of the image must be numbers")
+	}
+	width, height := args[0].Int(), args[1].Int()
+	if width <= 0 || height <= 0 || int64(width)*int64(height) > maxPixels {
+		return rejected(fmt.Sprintf("can't render a %dx%d image, it must have between 1 and %d pixels", width, height, maxPixels))
+	}
+	onProgress := js.Undefined()
+	if len(args) > 2 && args[2].Type() == js.TypeFunction {
+		onProgress = args[2]
+	}
+
+	s := current
+	handler := js.FuncOf(func(this js.Value, promise []js.Value) interface{} {
+		resolve, reject := promise[0], promise[1]
+		// Rendering in a goroutine lets the promise be returned right away
+		go func() {
+			var frame *image.Image
+			if err := protect(func() { frame = image.New(width, height) }); err != nil {
+				reject.Invoke(err.Error())
+				return
+			}
+			tiles := render.Tiles(width, height, render.DefaultTileSize)
+			for i, tile := range tiles {
+				if err := protect(func() { s.TraceRegion(frame, tile) }); err != nil {
+					reject.Invoke(err.Error())
+					return
+				}
+				if !onProgress.IsUndefined() {
+					onProgress.Invoke(float64(i+1) / float64(len(tiles)))
+				}
+			}
+			pixels := js.Global().Get("Uint8ClampedArray").New(len(frame.Pix))
+			js.CopyBytesToJS(pixels, frame.Pix)
+			resolve.Invoke(js.Global().Get("ImageData").New(pixels, width, height))
+		}()
+		return nil
+	})
+	// The promise runs the handler before it's returned, and never again
+	promise := js.Global().Get("Promise").New(handler)
+	handler.Release()
+	return promise
+}
+
+func rejected(message string) interface{} {
+	return js.Global().Get("Promise").Call("reject", message)
+}
+
+// protect runs f turning its panics into errors, so an invalid scene
+// doesn't kill the module
+func protect(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	f()
+	return nil
+}